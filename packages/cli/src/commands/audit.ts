@@ -0,0 +1,172 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient, ProxyAuditEntry, ProxyAuditQuery } from "../proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+
+// Module-level logger that gets configured when the audit command runs
+let logger: Logger;
+
+interface AuditContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedAuditArgs {
+  query: ProxyAuditQuery;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for audit command
+ */
+export function parseAuditArgs(args: string[]): ParsedAuditArgs {
+  const query: ProxyAuditQuery = {};
+  let server: string | undefined;
+
+  for (let i = 0; i < args.length; i++) {
+    const value = args[i + 1];
+    switch (args[i]) {
+      case "--host":
+        query.host = value;
+        i++;
+        break;
+      case "--action":
+        query.action = value;
+        i++;
+        break;
+      case "--since":
+        query.since = value;
+        i++;
+        break;
+      case "--limit": {
+        const limit = parseInt(value, 10);
+        if (isNaN(limit) || limit < 0) {
+          throw new Error(`Invalid --limit "${value}", expected a number`);
+        }
+        query.limit = limit;
+        i++;
+        break;
+      }
+      case "--server":
+        server = value;
+        i++;
+        break;
+    }
+  }
+
+  return {
+    query,
+    server,
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Formats an audit entry as a single line
+ */
+export function formatAuditEntry(entry: ProxyAuditEntry): string {
+  const time = entry.time.replace("T", " ").replace(/\.\d+/, "").replace("Z", " UTC");
+  const subject = entry.host ? ` ${entry.host}` : "";
+  const details = entry.details ? ` (${entry.details})` : "";
+  return `${time}  ${entry.action}${subject}${details} by ${entry.actor} from ${entry.source_ip}`;
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: AuditContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Shows the proxy audit log of every server in the configuration
+ */
+export async function auditCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseAuditArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+    const context: AuditContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const servers = parsedArgs.server
+      ? [parsedArgs.server]
+      : Array.from(
+          new Set(
+            normalizeConfigEntries(config.services).map(
+              (service: ServiceEntry) => service.server
+            )
+          )
+        );
+
+    const results: Array<{
+      server: string;
+      entries: ProxyAuditEntry[] | null;
+    }> = [];
+
+    for (const serverHostname of servers) {
+      const sshClient = await establishSSHConnection(serverHostname, context);
+      try {
+        const proxyClient = new IopProxyClient(
+          new DockerClient(sshClient, serverHostname, context.verboseFlag),
+          serverHostname,
+          context.verboseFlag
+        );
+        const entries = await proxyClient.getAuditLog(parsedArgs.query);
+        results.push({ server: serverHostname, entries });
+
+        if (!isJsonOutput()) {
+          console.log(`\n=== ${serverHostname} ===`);
+          if (!entries) {
+            console.log("Could not read the audit log");
+          } else if (entries.length === 0) {
+            console.log("No audit entries");
+          } else {
+            entries.forEach((entry) => console.log(formatAuditEntry(entry)));
+          }
+
+        }
+      } finally {
+        await sshClient.close();
+      }
+    }
+
+    writeResult({ servers: results });
+  } catch (error) {
+    logger.error("Failed to read audit log", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
@@ -1,5 +1,12 @@
 import { ServiceEntry, IopSecrets } from "../config/types";
-import { DockerClient, DockerContainerOptions } from "../docker";
+import { interpolateEnvironment } from "../config/environment";
+import {
+  DEFAULT_STARTUP_PROBE,
+  DockerClient,
+  DockerContainerOptions,
+  getContainerSecurity,
+  StartupProbe,
+} from "../docker";
 import {
   serviceNeedsBuilding,
   getServiceImageName,
@@ -7,6 +14,11 @@ import {
 } from "../utils/image-utils";
 import { processVolumes } from "../utils";
 import { ServiceFingerprint } from "../utils/service-fingerprint";
+import { isWorker } from "../utils/service-utils";
+import { IMAGE_DIGEST_LABEL } from "../utils/image-verification";
+import { DeployMetadata, getDeployMetadataLabels } from "../utils/deploy-metadata";
+import { DeploymentTimeline } from "../utils/deploy-timeline";
+import { resolveSecretFiles } from "../utils/secret-files";
 
 export interface BlueGreenDeploymentOptions {
   serviceEntry: ServiceEntry; // Now using unified ServiceEntry
@@ -18,6 +30,11 @@ export interface BlueGreenDeploymentOptions {
   serverHostname: string;
   verbose?: boolean;
   fingerprint?: ServiceFingerprint; // Optional fingerprint for container labels
+  declaredVolumes?: string[]; // Project-level named volumes
+  metadata?: DeployMetadata; // Deployment metadata recorded as container labels
+  scanLabels?: Record<string, string>; // Vulnerability counts of the image, if it was scanned
+  timeline?: DeploymentTimeline; // Where the start, health check and cleanup steps are reported
+  bake?: boolean; // Keep the previous containers for the proxy to bake the switch with proxy.bake
 }
 
 export interface BlueGreenDeploymentResult {
@@ -25,12 +42,14 @@ export interface BlueGreenDeploymentResult {
   newColor: "blue" | "green";
   deployedContainers: string[];
   error?: string;
+  previousColor?: "blue" | "green"; // Set when the previous containers are kept for a bake
+  previousContainers?: string[]; // Running outside the network aliases until the proxy ends the bake
 }
 
 /**
  * Generates blue-green container names based on project name, service name, color, and replica count
  */
-function generateContainerNames(
+export function generateContainerNames(
   projectName: string,
   serviceName: string,
   color: "blue" | "green",
@@ -56,7 +75,8 @@ function createBlueGreenContainerOptions(
   secrets: IopSecrets,
   projectName: string,
   containerName: string,
-  fingerprint?: ServiceFingerprint
+  fingerprint?: ServiceFingerprint,
+  declaredVolumes: string[] = []
 ): DockerContainerOptions {
   const imageNameWithRelease = buildServiceImageName(serviceEntry, releaseId);
   const envVars = resolveEnvironmentVariables(serviceEntry, secrets);
@@ -68,7 +88,7 @@ function createBlueGreenContainerOptions(
     name: containerName,
     image: imageNameWithRelease,
     ports: serviceEntry.ports,
-    volumes: processVolumes(serviceEntry.volumes, projectName),
+    volumes: processVolumes(serviceEntry.volumes, projectName, declaredVolumes),
     envVars: envVars,
     network: `${projectName}-network`,
     networkAliases: [
@@ -77,6 +97,10 @@ function createBlueGreenContainerOptions(
     ],
     restart: "unless-stopped",
     command: serviceEntry.command,
+    healthCheck: serviceEntry.health_check?.command,
+    resources: serviceEntry.resources,
+    security: getContainerSecurity(serviceEntry),
+    secretFiles: resolveSecretFiles(serviceEntry, secrets),
     labels: {
       "iop.managed": "true",
       "iop.project": projectName,
@@ -87,6 +111,7 @@ function createBlueGreenContainerOptions(
         "iop.fingerprint-type": fingerprint.type,
         "iop.config-hash": fingerprint.configHash,
         "iop.secrets-hash": fingerprint.secretsHash,
+        ...(fingerprint.contextHash && { "iop.context-hash": fingerprint.contextHash }),
         ...(fingerprint.type === 'external' && fingerprint.imageReference && { 
           "iop.image-reference": fingerprint.imageReference 
         }),
@@ -96,10 +121,135 @@ function createBlueGreenContainerOptions(
 }
 
 /**
- * Resolves environment variables for a container from plain and secret sources
+ * Generates the name of a sidecar container, e.g. blog-web-blue-1-logs
+ */
+export function generateSidecarContainerName(
+  appContainerName: string,
+  sidecarName: string
+): string {
+  return `${appContainerName}-${sidecarName}`;
+}
+
+/**
+ * Creates container options for a sidecar. It joins the network namespace of
+ * its app container, so the two reach each other on localhost, and is labelled
+ * with it so the proxy stops, starts and clones them together.
+ */
+export function createSidecarContainerOptions(
+  serviceEntry: ServiceEntry,
+  sidecarName: string,
+  appContainerName: string,
+  secrets: IopSecrets,
+  projectName: string,
+  declaredVolumes: string[] = []
+): DockerContainerOptions {
+  const sidecar = serviceEntry.sidecars![sidecarName];
+
+  return {
+    name: generateSidecarContainerName(appContainerName, sidecarName),
+    image: sidecar.image,
+    network: `container:${appContainerName}`,
+    volumes: processVolumes(sidecar.volumes, projectName, declaredVolumes),
+    envVars: resolveEnvironmentVariables(
+      { name: `${serviceEntry.name} sidecar ${sidecarName}`, environment: sidecar.environment },
+      secrets
+    ),
+    restart: "unless-stopped",
+    command: sidecar.command,
+    resources: sidecar.resources,
+    security: getContainerSecurity(sidecar),
+    labels: {
+      "iop.managed": "true",
+      "iop.project": projectName,
+      "iop.type": "sidecar",
+      "iop.sidecar": sidecarName,
+      "iop.sidecar-of": appContainerName,
+    },
+  };
+}
+
+/**
+ * Creates container options for an init step. It runs with the network,
+ * volumes and environment of the service's containers, and by default their
+ * image.
+ */
+export function createInitContainerOptions(
+  serviceEntry: ServiceEntry,
+  stepIndex: number,
+  containerOptions: DockerContainerOptions,
+  secrets: IopSecrets,
+  projectName: string
+): DockerContainerOptions {
+  const step = serviceEntry.init![stepIndex];
+  const stepName = step.name || String(stepIndex + 1);
+
+  return {
+    name: `${projectName}-${serviceEntry.name}-init-${stepName}`,
+    image: step.image || containerOptions.image,
+    network: containerOptions.network,
+    volumes: containerOptions.volumes,
+    envVars: {
+      ...containerOptions.envVars,
+      ...resolveEnvironmentVariables(
+        { name: `${serviceEntry.name} init step ${stepName}`, environment: step.environment },
+        secrets
+      ),
+    },
+    command: step.command,
+    resources: containerOptions.resources,
+    secretFiles: containerOptions.secretFiles,
+    labels: {
+      "iop.managed": "true",
+      "iop.project": projectName,
+      "iop.type": "init",
+      "iop.service": serviceEntry.name,
+    },
+  };
+}
+
+/**
+ * Runs the init steps of a service in order, returning an error message for
+ * the first one that fails
+ */
+export async function runInitSteps(
+  serviceEntry: ServiceEntry,
+  containerOptions: DockerContainerOptions,
+  secrets: IopSecrets,
+  projectName: string,
+  dockerClient: DockerClient,
+  serverHostname: string,
+  verbose?: boolean
+): Promise<string | null> {
+  for (let i = 0; i < (serviceEntry.init?.length || 0); i++) {
+    const options = createInitContainerOptions(
+      serviceEntry,
+      i,
+      containerOptions,
+      secrets,
+      projectName
+    );
+
+    if (verbose) {
+      console.log(
+        `    [${serverHostname}] Running init step ${options.name}: ${options.command}`
+      );
+    }
+
+    const result = await dockerClient.runContainer(options);
+    if (!result.success) {
+      const output = result.output.trim().split("\n").slice(-5).join("\n");
+      return `Init step ${options.name} failed: ${output}`;
+    }
+  }
+  return null;
+}
+
+/**
+ * Resolves environment variables for a container from plain and secret
+ * sources, substituting ${NAME} and ${secrets.NAME} references
  */
 function resolveEnvironmentVariables(
-  entry: ServiceEntry,
+  entry: Pick<ServiceEntry, "name" | "environment">,
   secrets: IopSecrets
 ): Record<string, string> {
   const envVars: Record<string, string> = {};
@@ -125,7 +275,26 @@ function resolveEnvironmentVariables(
     }
   }
 
-  return envVars;
+  return interpolateEnvironment(envVars, secrets, entry.name, entry.environment?.secret);
+}
+
+const DURATION_UNIT_MS: Record<string, number> = { s: 1000, m: 60_000, h: 3_600_000 };
+
+/**
+ * Returns how a deploy checks a service's new containers: its startup probe,
+ * falling back to the health check path, every second and 30 attempts
+ */
+export function getStartupProbe(serviceEntry: ServiceEntry): StartupProbe {
+  const startup = serviceEntry.health_check?.startup;
+  const interval = startup?.interval?.match(/^(\d+)(s|m|h)$/);
+
+  return {
+    path: startup?.path || serviceEntry.health_check?.path || DEFAULT_STARTUP_PROBE.path,
+    intervalMs: interval
+      ? Number(interval[1]) * DURATION_UNIT_MS[interval[2]]
+      : DEFAULT_STARTUP_PROBE.intervalMs,
+    attempts: startup?.failure_threshold ?? DEFAULT_STARTUP_PROBE.attempts,
+  };
 }
 
 /**
@@ -137,7 +306,8 @@ async function performBlueGreenHealthChecks(
   dockerClient: DockerClient,
   serverHostname: string,
   projectName: string,
-  verbose?: boolean
+  verbose?: boolean,
+  timeline?: DeploymentTimeline
 ): Promise<boolean> {
   if (verbose) {
     console.log(
@@ -146,7 +316,7 @@ async function performBlueGreenHealthChecks(
   }
 
   const servicePort = serviceEntry.proxy?.app_port || 3000;
-  const healthCheckPath = serviceEntry.health_check?.path || "/up";
+  const startupProbe = getStartupProbe(serviceEntry);
   const healthPromises = containerNames.map(async (containerName) => {
     try {
       const healthCheckPassed =
@@ -156,7 +326,9 @@ async function performBlueGreenHealthChecks(
           containerName,
           projectName,
           servicePort,
-          healthCheckPath
+          startupProbe,
+          timeline &&
+            ((result, error) => timeline.attempt("health_check", result, error))
         );
 
       if (healthCheckPassed) {
@@ -205,6 +377,81 @@ async function performBlueGreenHealthChecks(
   return allHealthy;
 }
 
+/**
+ * Waits for all new worker containers to report healthy through Docker, as
+ * workers have no HTTP endpoint for the proxy to check
+ */
+async function performWorkerHealthChecks(
+  containerNames: string[],
+  dockerClient: DockerClient,
+  serverHostname: string,
+  verbose?: boolean
+): Promise<boolean> {
+  if (verbose) {
+    console.log(
+      `    [${serverHostname}] Waiting for ${containerNames.length} worker containers to become healthy...`
+    );
+  }
+
+  const healthResults = await Promise.all(
+    containerNames.map((containerName) =>
+      dockerClient.waitForContainerHealth(containerName)
+    )
+  );
+  const allHealthy = healthResults.every((result) => result === true);
+
+  if (verbose) {
+    if (allHealthy) {
+      console.log(
+        `    [${serverHostname}] All ${containerNames.length} worker containers are healthy ✓`
+      );
+    } else {
+      console.error(
+        `    [${serverHostname}] Some worker containers did not become healthy`
+      );
+    }
+  }
+
+  return allHealthy;
+}
+
+/**
+ * Starts the sidecars of a new app container, returning an error message if
+ * one of them couldn't be created
+ */
+async function startSidecars(
+  serviceEntry: ServiceEntry,
+  appContainerName: string,
+  secrets: IopSecrets,
+  projectName: string,
+  dockerClient: DockerClient,
+  serverHostname: string,
+  declaredVolumes: string[],
+  verbose?: boolean
+): Promise<string | null> {
+  for (const sidecarName of Object.keys(serviceEntry.sidecars || {})) {
+    const options = createSidecarContainerOptions(
+      serviceEntry,
+      sidecarName,
+      appContainerName,
+      secrets,
+      projectName,
+      declaredVolumes
+    );
+
+    if (verbose) {
+      console.log(
+        `    [${serverHostname}] Creating sidecar ${options.name}...`
+      );
+    }
+
+    if (!(await dockerClient.createContainer(options))) {
+      return `Failed to create sidecar ${options.name}`;
+    }
+  }
+  return null;
+}
+
 /**
  * Cleans up failed deployment containers
  */
@@ -222,6 +469,7 @@ async function cleanupFailedDeployment(
 
   for (const containerName of containerNames) {
     try {
+      await dockerClient.removeSidecars(containerName);
       await dockerClient.stopContainer(containerName);
       await dockerClient.removeContainer(containerName);
       if (verbose) {
@@ -256,6 +504,10 @@ export async function performBlueGreenDeployment(
     serverHostname,
     verbose = false,
     fingerprint,
+    declaredVolumes = [],
+    metadata = {},
+    scanLabels = {},
+    timeline,
   } = options;
 
   if (verbose) {
@@ -311,6 +563,7 @@ export async function performBlueGreenDeployment(
               `    [${serverHostname}] Removing existing container ${containerName}...`
             );
           }
+          await dockerClient.removeSidecars(containerName);
           await dockerClient.stopContainer(containerName);
           await dockerClient.removeContainer(containerName);
         }
@@ -324,9 +577,45 @@ export async function performBlueGreenDeployment(
       }
     }
 
+    await timeline?.step("start", "running");
+
+    // Step 2.75: Run init steps against the new release before starting it
+    if (serviceEntry.init?.length) {
+      const initError = await runInitSteps(
+        serviceEntry,
+        createBlueGreenContainerOptions(
+          serviceEntry,
+          releaseId,
+          secrets,
+          projectName,
+          newContainerNames[0],
+          options.fingerprint,
+          declaredVolumes
+        ),
+        secrets,
+        projectName,
+        dockerClient,
+        serverHostname,
+        verbose
+      );
+      if (initError) {
+        return {
+          success: false,
+          newColor,
+          deployedContainers: [],
+          error: initError,
+        };
+      }
+    }
+
     // Step 3: Create new containers
     const deployedContainers: string[] = [];
 
+    // Recorded so the exact image can be deployed again with --digest
+    const imageDigest = await dockerClient.getImageDigest(
+      buildServiceImageName(serviceEntry, releaseId)
+    );
+
     for (let i = 0; i < newContainerNames.length; i++) {
       const containerName = newContainerNames[i];
       const replicaIndex = i + 1;
@@ -337,8 +626,14 @@ export async function performBlueGreenDeployment(
         secrets,
         projectName,
         containerName,
-        options.fingerprint
+        options.fingerprint,
+        declaredVolumes
       );
+      if (imageDigest) {
+        containerOptions.labels![IMAGE_DIGEST_LABEL] = imageDigest;
+      }
+      Object.assign(containerOptions.labels!, getDeployMetadataLabels(metadata));
+      Object.assign(containerOptions.labels!, scanLabels);
 
       if (verbose) {
         console.log(
@@ -371,25 +666,80 @@ export async function performBlueGreenDeployment(
       }
 
       deployedContainers.push(containerName);
+
+      const sidecarError = await startSidecars(
+        serviceEntry,
+        containerName,
+        secrets,
+        projectName,
+        dockerClient,
+        serverHostname,
+        declaredVolumes,
+        verbose
+      );
+      if (sidecarError) {
+        await cleanupFailedDeployment(
+          deployedContainers,
+          dockerClient,
+          serverHostname,
+          verbose
+        );
+        return {
+          success: false,
+          newColor,
+          deployedContainers: [],
+          error: sidecarError,
+        };
+      }
     }
 
-    // Step 4: Health check all new containers (only if ports are exposed)
+    await timeline?.step("start", "succeeded", {
+      message: `Started ${deployedContainers.join(", ")}`,
+    });
+
+    // Step 4: Health check all new containers (workers through Docker, others if ports are exposed or a startup probe is set)
     let allHealthy = true;
     
-    if (serviceEntry.ports && serviceEntry.ports.length > 0) {
+    if (isWorker(serviceEntry)) {
+      await timeline?.step("health_check", "running");
+      allHealthy = await performWorkerHealthChecks(
+        newContainerNames,
+        dockerClient,
+        serverHostname,
+        verbose
+      );
+
+      if (!allHealthy) {
+        await cleanupFailedDeployment(
+          deployedContainers,
+          dockerClient,
+          serverHostname,
+          verbose
+        );
+        return {
+          success: false,
+          newColor,
+          deployedContainers: [],
+          error: "New worker containers did not become healthy",
+        };
+      }
+      await timeline?.step("health_check", "succeeded");
+    } else if ((serviceEntry.ports && serviceEntry.ports.length > 0) || serviceEntry.health_check?.startup) {
       if (verbose) {
         console.log(
-          `    [${serverHostname}] Service exposes ports, performing health checks...`
+          `    [${serverHostname}] Service exposes ports or has a startup probe, performing health checks...`
         );
       }
       
+      await timeline?.step("health_check", "running");
       allHealthy = await performBlueGreenHealthChecks(
         newContainerNames,
         serviceEntry,
         dockerClient,
         serverHostname,
         projectName,
-        verbose
+        verbose,
+        timeline
       );
 
       if (!allHealthy) {
@@ -406,12 +756,16 @@ export async function performBlueGreenDeployment(
           error: "Health checks failed for new containers",
         };
       }
+      await timeline?.step("health_check", "succeeded");
     } else {
       if (verbose) {
         console.log(
           `    [${serverHostname}] Service has no exposed ports, skipping health checks (assuming healthy if running)`
         );
       }
+      await timeline?.step("health_check", "skipped", {
+        message: "No exposed ports",
+      });
     }
 
     // Step 5: Switch network alias (zero-downtime transition)
@@ -422,6 +776,7 @@ export async function performBlueGreenDeployment(
           `    [${serverHostname}] Switching traffic to new version (zero downtime)...`
         );
       }
+      await timeline?.step("switch", "running");
 
       const aliasSwitch = await dockerClient.switchNetworkAliasForProject(
         serviceEntry.name,
@@ -444,12 +799,18 @@ export async function performBlueGreenDeployment(
           error: "Failed to switch network alias",
         };
       }
+      await timeline?.step("switch", "succeeded", {
+        message: `Network alias moved to ${newColor}`,
+      });
     } else {
       if (verbose) {
         console.log(
           `    [${serverHostname}] First deployment - network aliases already configured during container creation`
         );
       }
+      await timeline?.step("switch", "succeeded", {
+        message: "Network alias set when creating the containers",
+      });
     }
 
     // Step 6: Update labels to mark new containers as active
@@ -457,6 +818,7 @@ export async function performBlueGreenDeployment(
 
     // Step 7: Graceful shutdown of old containers
     if (currentActiveColor) {
+      await timeline?.step("cleanup", "running");
       const oldContainers = await dockerClient.findContainersByLabelAndProject(
         `iop.app=${serviceEntry.name}`,
         projectName
@@ -470,6 +832,30 @@ export async function performBlueGreenDeployment(
         }
       }
 
+      // A baked deploy keeps them to roll back to, the proxy removes them afterwards
+      const bake = options.bake ? serviceEntry.proxy?.bake : undefined;
+      if (
+        bake &&
+        oldActiveContainers.length > 0 &&
+        (await dockerClient.removeNetworkAliases(oldActiveContainers, networkName))
+      ) {
+        if (verbose) {
+          console.log(
+            `    [${serverHostname}] Keeping ${oldActiveContainers.length} old containers for a ${bake.duration} bake...`
+          );
+        }
+        await timeline?.step("cleanup", "succeeded", {
+          message: `Kept ${currentActiveColor} containers for a ${bake.duration} bake`,
+        });
+        return {
+          success: true,
+          newColor,
+          deployedContainers,
+          previousColor: currentActiveColor,
+          previousContainers: oldActiveContainers,
+        };
+      }
+
       if (oldActiveContainers.length > 0) {
         if (verbose) {
           console.log(
@@ -478,9 +864,10 @@ export async function performBlueGreenDeployment(
         }
         await dockerClient.gracefulShutdown(oldActiveContainers, 30);
 
-        // Remove old containers
+        // Remove old containers, after their sidecars saw them stop
         for (const containerName of oldActiveContainers) {
           try {
+            await dockerClient.removeSidecars(containerName);
             await dockerClient.removeContainer(containerName);
             if (verbose) {
               console.log(
@@ -497,6 +884,13 @@ export async function performBlueGreenDeployment(
           }
         }
       }
+      await timeline?.step("cleanup", "succeeded", {
+        message: `Removed ${currentActiveColor} containers`,
+      });
+    } else {
+      await timeline?.step("cleanup", "skipped", {
+        message: "First deployment",
+      });
     }
 
     if (verbose) {
@@ -0,0 +1,247 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+import {
+  buildBackupScript,
+  buildRestoreCommand,
+  getBackupDirectory,
+  listBackups,
+} from "../utils/db-backup";
+
+// Module-level logger that gets configured when db commands run
+let logger: Logger;
+
+interface DbContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedDbArgs {
+  subcommand: string;
+  serviceName?: string;
+  backupFile?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for db command
+ */
+export function parseDbArgs(args: string[]): ParsedDbArgs {
+  const verboseFlag = args.includes("--verbose");
+  const cleanArgs = args.filter((arg) => arg !== "--verbose");
+
+  return {
+    subcommand: cleanArgs[0] || "",
+    serviceName: cleanArgs[1],
+    backupFile: cleanArgs[2],
+    verboseFlag,
+  };
+}
+
+/**
+ * Finds a templated database service by name
+ */
+function findDatabaseService(config: IopConfig, serviceName: string): ServiceEntry {
+  const service = normalizeConfigEntries(config.services).find(
+    (entry) => entry.name === serviceName
+  );
+
+  if (!service) {
+    throw new Error(`Service "${serviceName}" not found in configuration`);
+  }
+  if (!service.template) {
+    throw new Error(
+      `Service "${serviceName}" does not use a database template (postgres, mysql, redis)`
+    );
+  }
+
+  return service;
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: DbContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Backup subcommand - runs a backup immediately using the same script as the schedule
+ */
+async function dbBackupSubcommand(
+  context: DbContext,
+  service: ServiceEntry
+): Promise<void> {
+  const script = buildBackupScript(service, context.config.name, context.secrets);
+
+  logger.server(service.server);
+  const sshClient = await establishSSHConnection(service.server, context);
+  try {
+    logger.serverStep(`Backing up ${service.name}`);
+    const output = await sshClient.exec(
+      `sh -s <<'IOP_BACKUP_EOF'\n${script}IOP_BACKUP_EOF`
+    );
+    const backupFile = output.trim().split("\n").pop();
+    logger.serverStepComplete(`Backup written to ${backupFile}`);
+  } catch (error) {
+    logger.serverStepError(`Failed to back up ${service.name}`, error);
+    throw error;
+  } finally {
+    await sshClient.close();
+  }
+}
+
+/**
+ * Restore subcommand - restores the given backup, or the latest one if none is given
+ */
+async function dbRestoreSubcommand(
+  context: DbContext,
+  service: ServiceEntry,
+  backupFile?: string
+): Promise<void> {
+  logger.server(service.server);
+  const sshClient = await establishSSHConnection(service.server, context);
+  try {
+    let fileToRestore = backupFile;
+    if (!fileToRestore) {
+      const backups = await listBackups(sshClient, context.config.name, service.name);
+      if (backups.length === 0) {
+        throw new Error(
+          `No backups found in ${getBackupDirectory(context.config.name, service.name)}`
+        );
+      }
+      fileToRestore = backups[0];
+    } else if (!fileToRestore.includes("/")) {
+      fileToRestore = `${getBackupDirectory(context.config.name, service.name)}/${fileToRestore}`;
+    }
+
+    logger.serverStep(`Restoring ${service.name} from ${fileToRestore}`);
+    await sshClient.exec(
+      buildRestoreCommand(service, context.config.name, fileToRestore)
+    );
+    logger.serverStepComplete(`Restored ${service.name}`);
+  } catch (error) {
+    logger.serverStepError(`Failed to restore ${service.name}`, error);
+    throw error;
+  } finally {
+    await sshClient.close();
+  }
+}
+
+/**
+ * List subcommand - shows backups stored on the server for a service
+ */
+async function dbListSubcommand(
+  context: DbContext,
+  service: ServiceEntry
+): Promise<void> {
+  const sshClient = await establishSSHConnection(service.server, context);
+  try {
+    const backups = await listBackups(sshClient, context.config.name, service.name);
+    if (isJsonOutput()) {
+      writeResult({ service: service.name, server: service.server, backups });
+      return;
+    }
+    if (backups.length === 0) {
+      logger.info(`No backups found for ${service.name} on ${service.server}`);
+      return;
+    }
+
+    console.log(`\nBackups of ${service.name} on ${service.server} (newest first):`);
+    backups.forEach((backup) => console.log(`   ${backup}`));
+  } finally {
+    await sshClient.close();
+  }
+}
+
+/**
+ * Shows help for db command
+ */
+function showDbHelp(): void {
+  console.log("IOP Database Management");
+  console.log("=======================");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop db <subcommand> <service> [file] [flags]");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  backup <service>          Run a backup now");
+  console.log("  restore <service> [file]  Restore a backup (latest if no file given)");
+  console.log("  list <service>            Show backups stored on the server");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --verbose         Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop db backup postgres");
+  console.log("  iop db restore postgres postgres-20250101T030000Z.sql.gz");
+}
+
+/**
+ * Main db command that handles subcommands
+ */
+export async function dbCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseDbArgs(args);
+
+  if (!["backup", "restore", "list"].includes(parsedArgs.subcommand)) {
+    showDbHelp();
+    return;
+  }
+
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    if (!parsedArgs.serviceName) {
+      logger.error(`Service name is required: iop db ${parsedArgs.subcommand} <service>`);
+      return;
+    }
+
+    const config = await loadConfig();
+    const secrets = await loadSecrets(true);
+    const context: DbContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const service = findDatabaseService(config, parsedArgs.serviceName);
+
+    switch (parsedArgs.subcommand) {
+      case "backup":
+        await dbBackupSubcommand(context, service);
+        break;
+      case "restore":
+        await dbRestoreSubcommand(context, service, parsedArgs.backupFile);
+        break;
+      case "list":
+        await dbListSubcommand(context, service);
+        break;
+    }
+  } finally {
+    logger.cleanup();
+  }
+}
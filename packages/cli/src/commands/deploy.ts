@@ -1,5 +1,5 @@
 import { loadConfig } from "../config"; // Assuming loadConfig is exported from src/config/index.ts
-import { loadSecrets } from "../config"; // Assuming loadSecrets is exported from src/config/index.ts
+import { loadSecrets, getConfigEnvironment, normalizeConfigEntries } from "../config"; // Assuming loadSecrets is exported from src/config/index.ts
 import {
   IopConfig,
   ServiceEntry,
@@ -18,6 +18,7 @@ import {
 } from "../utils/service-utils";
 import {
   createServiceFingerprint,
+  combineConfigAndContextHash,
   shouldRedeploy,
   ServiceFingerprint,
   isBuiltService,
@@ -26,6 +27,7 @@ import {
   DockerClient,
   DockerBuildOptions,
   DockerContainerOptions,
+  getContainerSecurity,
 } from "../docker";
 
 // Deployment result tracking
@@ -34,6 +36,7 @@ export interface ServiceDeploymentResult {
   status: 'deployed' | 'skipped';
   reason: string;
   url?: string;
+  deploymentId?: string; // Timeline of the deployment at GET /api/deployments/:id
 }
 
 export interface DeploymentSummary {
@@ -70,12 +73,99 @@ import {
   processVolumes,
   ensureProjectDirectories,
   sanitizeFolderName,
+  getDeclaredVolumeNames,
+  getProjectVolumeName,
+  getServiceNamedVolumes,
 } from "../utils";
 import { shouldUseSslip, generateAppSslipDomain } from "../utils/sslip";
 import { setupIopProxy } from "../setup-proxy/index";
-import { IopProxyClient } from "../proxy";
-import { performBlueGreenDeployment } from "./blue-green";
+import { IopProxyClient, ProxyAutoscalePolicy, ProxyBake, ProxyHostProbes, ProxyServiceRecord } from "../proxy";
+import {
+  IopProxyCluster,
+  aggregateHosts,
+  findStaleHostRegistrations,
+  getProxyServers,
+} from "../proxy/cluster";
+import {
+  BlueGreenDeploymentResult,
+  generateContainerNames,
+  performBlueGreenDeployment,
+  runInitSteps,
+} from "./blue-green";
 import { Logger } from "../utils/logger";
+import { installBackupSchedule, removeBackupSchedule } from "../utils/db-backup";
+import { resolveRegistryCredentials } from "../utils/registry";
+import {
+  collectBuildContextFiles,
+  createBuildContextArchive,
+  hashBuildContext,
+  hashBuildInputs,
+} from "../utils/build-context";
+import { getServiceTemplate } from "../config/templates";
+import { interpolateEnvironment } from "../config/environment";
+import { executeGcPlan, getImageRetention, planServerGc } from "../utils/image-gc";
+import { ensureProjectNetwork, removeProjectNetwork } from "../utils/project-network";
+import { ensureBuiltinRegistry, getRegistryServer } from "../utils/builtin-registry";
+import { ensureStatusPage, getStatusPageServer } from "../utils/status-page";
+import { ensureLogShipper } from "../utils/log-shipper";
+import { readMeshAddress } from "../utils/mesh";
+import { buildQuotaWorkloads } from "../utils/quota";
+import {
+  IMAGE_DIGEST_LABEL,
+  buildCosignVerifyArgs,
+  parseImageDigest,
+  pinImageDigest,
+} from "../utils/image-verification";
+import {
+  DeployMetadata,
+  collectDeployMetadata,
+  formatDeployMetadata,
+  getDeployMetadataLabels,
+} from "../utils/deploy-metadata";
+import {
+  formatHardeningResult,
+  hardenServer,
+  HardeningStepResult,
+} from "../utils/server-hardening";
+import { CloudflareDnsClient, getDnsRecordComment } from "../utils/cloudflare-dns";
+import { buildNotificationTargets } from "../utils/notifications";
+import { buildAcmeConfig } from "../utils/acme";
+import { isJsonOutput, logProgress, progressStream, writeError, writeResult } from "../utils/output";
+import { DeploymentTimeline, runStep } from "../utils/deploy-timeline";
+import {
+  ScanCounts,
+  formatBlockingVulnerabilities,
+  formatScanCounts,
+  getImageScanConfig,
+  getScanLabels,
+  scanImage,
+} from "../utils/image-scan";
+import {
+  ConcurrencyLimiter,
+  DeploymentFailure,
+  combineDeploymentFailures,
+  getDeployParallelism,
+  planDeploymentGroups,
+} from "../utils/deploy-parallel";
+import {
+  DeployLock,
+  DeployLockedError,
+  acquireDeployLock,
+  createDeployLock,
+  getDeployLockOwner,
+  releaseDeployLock,
+} from "../utils/deploy-lock";
+import {
+  DeployPlan,
+  PlanAction,
+  formatPlan,
+  planHostActions,
+} from "../utils/deploy-plan";
+import {
+  GitHubDeploymentReporter,
+  createGitHubReporter,
+} from "../utils/github-deployments";
+import { resolveSecretFiles } from "../utils/secret-files";
 import * as path from "path";
 import * as fs from "fs";
 import * as os from "os";
@@ -87,7 +177,8 @@ let logger: Logger;
 // Removed: Now using enhanced fingerprinting from service-fingerprint.ts
 
 /**
- * Resolves environment variables for a container from plain and secret sources
+ * Resolves environment variables for a container from plain and secret
+ * sources, substituting ${NAME} and ${secrets.NAME} references
  */
 function resolveEnvironmentVariables(
   entry: ServiceEntry,
@@ -115,7 +206,7 @@ function resolveEnvironmentVariables(
       }
     }
   }
-  return envVars;
+  return interpolateEnvironment(envVars, secrets, entry.name, entry.environment?.secret);
 }
 
 /**
@@ -126,7 +217,8 @@ function serviceEntryToContainerOptions(
   secrets: IopSecrets,
   projectName: string,
   releaseId?: string,
-  fingerprint?: ServiceFingerprint
+  fingerprint?: ServiceFingerprint,
+  declaredVolumes: string[] = []
 ): DockerContainerOptions {
   const containerName = `${projectName}-${serviceEntry.name}`; // Project-prefixed names
   const envVars = resolveEnvironmentVariables(serviceEntry, secrets);
@@ -149,11 +241,17 @@ function serviceEntryToContainerOptions(
     name: containerName,
     image: imageName,
     ports: serviceEntry.ports,
-    volumes: processVolumes(serviceEntry.volumes, projectName),
+    volumes: processVolumes(serviceEntry.volumes, projectName, declaredVolumes),
     envVars: envVars,
     network: networkName,
     networkAliases: [serviceEntry.name], // Allow other containers to reach this service by name (e.g. "db", "meilisearch")
     restart: "unless-stopped",
+    healthCheck:
+      serviceEntry.health_check?.command ||
+      getServiceTemplate(serviceEntry)?.healthCheck,
+    resources: serviceEntry.resources,
+    security: getContainerSecurity(serviceEntry),
+    secretFiles: resolveSecretFiles(serviceEntry, secrets),
     configHash, // Add for comparison
     labels: {
       "iop.managed": "true",
@@ -165,6 +263,7 @@ function serviceEntryToContainerOptions(
       ...(fingerprint ? {
         "iop.fingerprint-type": fingerprint.type,
         "iop.secrets-hash": fingerprint.secretsHash,
+        ...(fingerprint.contextHash && { "iop.context-hash": fingerprint.contextHash }),
         ...(fingerprint.type === 'external' && fingerprint.imageReference && { 
           "iop.image-reference": fingerprint.imageReference 
         }),
@@ -173,26 +272,6 @@ function serviceEntryToContainerOptions(
   };
 }
 
-/**
- * Converts object or array format configuration entries to a normalized array
- */
-function normalizeConfigEntries(
-  entries: Record<string, any> | Array<any> | undefined
-): Array<any> {
-  if (!entries) return [];
-
-  // If it's already an array, return it
-  if (Array.isArray(entries)) {
-    return entries;
-  }
-
-  // If it's an object, convert to array with name property
-  return Object.entries(entries).map(([name, entry]) => ({
-    ...entry,
-    name,
-  }));
-}
-
 interface DeploymentContext {
   config: IopConfig;
   secrets: IopSecrets;
@@ -203,11 +282,25 @@ interface DeploymentContext {
   verboseFlag: boolean;
   imageArchives?: Map<string, string>; // service name -> archive path
   serviceFingerprints?: Map<string, ServiceFingerprint>; // service name -> fingerprint
+  scanCounts?: Map<string, ScanCounts>; // service name -> vulnerabilities found in its image
+  buildRemote: boolean; // Build images on the target server instead of locally
+  dnsMode: "auto" | "manual"; // Whether DNS records are managed through the dns provider
+  forceRedeploy?: boolean; // Redeploy even when fingerprints match, e.g. to undo manual changes
+  metadata: DeployMetadata; // Recorded on containers and in the proxy's deployment history
+  limiter: ConcurrencyLimiter; // Bounds concurrent app deployments across all servers
 }
 
 interface ParsedArgs {
   entryNames: string[];
   verboseFlag: boolean;
+  buildRemoteFlag: boolean;
+  planFlag: boolean;
+  forceUnlockFlag: boolean;
+  forceFlag: boolean; // --force, redeploy even unchanged services
+  dnsMode: "auto" | "manual";
+  digest?: string; // Deploy the image at this digest instead of its tag
+  annotations: string[]; // --annotate key=value, added to the deployment's metadata
+  parallel?: number; // --parallel=N, overrides deploy.parallelism
 }
 
 /**
@@ -215,12 +308,102 @@ interface ParsedArgs {
  */
 function parseDeploymentArgs(rawEntryNamesAndFlags: string[]): ParsedArgs {
   const verboseFlag = rawEntryNamesAndFlags.includes("--verbose");
+  const buildRemoteFlag = rawEntryNamesAndFlags.includes("--build-remote");
+  const planFlag = rawEntryNamesAndFlags.includes("--plan");
+  const forceUnlockFlag = rawEntryNamesAndFlags.includes("--force-unlock");
+  const forceFlag = rawEntryNamesAndFlags.includes("--force");
+
+  const dnsFlag = rawEntryNamesAndFlags.find((name) => name.startsWith("--dns="));
+  const dnsMode = (
+    dnsFlag ? dnsFlag.substring("--dns=".length) : "auto"
+  ) as ParsedArgs["dnsMode"];
+  if (dnsMode !== "auto" && dnsMode !== "manual") {
+    throw new Error(`Invalid --dns value "${dnsMode}", expected "auto" or "manual"`);
+  }
+
+  const parallelFlag = rawEntryNamesAndFlags.find((name) => name.startsWith("--parallel="));
+  let parallel: number | undefined;
+  if (parallelFlag) {
+    parallel = Number(parallelFlag.substring("--parallel=".length));
+    if (!Number.isInteger(parallel) || parallel < 1) {
+      throw new Error(`Invalid ${parallelFlag}, expected a positive integer`);
+    }
+  }
+
+  // --digest sha256:... or --digest=sha256:...
+  let digest: string | undefined;
+  const valueArgs = new Set<number>(); // Indexes of flags taking a value and of their values
+  const digestIndex = rawEntryNamesAndFlags.findIndex(
+    (name) => name === "--digest" || name.startsWith("--digest=")
+  );
+  if (digestIndex !== -1) {
+    const digestFlag = rawEntryNamesAndFlags[digestIndex];
+    const spaced = digestFlag === "--digest";
+    const value = spaced
+      ? rawEntryNamesAndFlags[digestIndex + 1]
+      : digestFlag.substring("--digest=".length);
+    if (!value) {
+      throw new Error("--digest needs a value, e.g. --digest sha256:...");
+    }
+    digest = parseImageDigest(value);
+    valueArgs.add(digestIndex);
+    if (spaced) {
+      valueArgs.add(digestIndex + 1);
+    }
+  }
+
+  // --annotate key=value or --annotate=key=value, repeatable
+  const annotations: string[] = [];
+  rawEntryNamesAndFlags.forEach((name, index) => {
+    if (name === "--annotate") {
+      const value = rawEntryNamesAndFlags[index + 1];
+      if (!value) {
+        throw new Error("--annotate needs a value, e.g. --annotate ticket=OPS-42");
+      }
+      annotations.push(value);
+      valueArgs.add(index);
+      valueArgs.add(index + 1);
+    } else if (name.startsWith("--annotate=")) {
+      annotations.push(name.substring("--annotate=".length));
+      valueArgs.add(index);
+    }
+  });
 
   const entryNames = rawEntryNamesAndFlags.filter(
-    (name) => name !== "--verbose"
+    (name, index) =>
+      name !== "--verbose" &&
+      name !== "--build-remote" &&
+      name !== "--plan" &&
+      name !== "--force-unlock" &&
+      name !== "--force" &&
+      name !== dnsFlag &&
+      name !== parallelFlag &&
+      !valueArgs.has(index)
   );
 
-  return { entryNames, verboseFlag };
+  return { entryNames, verboseFlag, buildRemoteFlag, planFlag, forceUnlockFlag, forceFlag, dnsMode, digest, annotations, parallel };
+}
+
+/**
+ * Pins the image of the one selected service to a digest, so the exact image
+ * is deployed whatever its tag points at now
+ */
+function pinTargetImageDigest(
+  targetServices: ServiceEntry[],
+  digest: string
+): ServiceEntry[] {
+  if (targetServices.length !== 1) {
+    throw new Error(
+      "--digest needs exactly one service, e.g. iop deploy web --digest sha256:..."
+    );
+  }
+  const [service] = targetServices;
+  if (serviceNeedsBuilding(service) || !service.image) {
+    throw new Error(
+      `--digest only applies to pre-built images, ${service.name} is built from source`
+    );
+  }
+  return [{ ...service, image: pinImageDigest(service.image, digest) }];
 }
 
 /**
@@ -232,7 +415,7 @@ async function loadConfigurationAndSecrets(): Promise<{
 }> {
   try {
     const config = await loadConfig();
-    const secrets = await loadSecrets();
+    const secrets = await loadSecrets(true);
 
     // Validate configuration for common issues
     const validationErrors = validateConfig(config);
@@ -628,8 +811,16 @@ async function getBuildConfig(
 async function deployServices(context: DeploymentContext): Promise<ServiceDeploymentResult[]> {
   const services = context.targetServices;
 
-  // Separate services by build requirements
-  const servicesNeedingBuild = services.filter((service) => serviceNeedsBuilding(service));
+  // Separate services by build requirements. Remote builds happen per server
+  // during deployment, so nothing is built locally in that mode.
+  const unchangedBuilds = context.buildRemote
+    ? new Set<string>()
+    : await findUnchangedBuiltServices(context, services.filter((service) => serviceNeedsBuilding(service)));
+  const servicesNeedingBuild = context.buildRemote
+    ? []
+    : services.filter(
+        (service) => serviceNeedsBuilding(service) && !unchangedBuilds.has(service.name)
+      );
   const preBuiltServices = services.filter((service) => !serviceNeedsBuilding(service));
 
   // Initialize image archives map
@@ -681,6 +872,12 @@ async function deployServices(context: DeploymentContext): Promise<ServiceDeploy
   }
   logger.phaseComplete("Reconciling state");
 
+  if (context.config.dns && context.dnsMode === "auto") {
+    logger.phase("Updating DNS records");
+    await syncDnsRecords(context);
+    logger.phaseComplete("Updating DNS records");
+  }
+
   // Deployment phase - deploy services with appropriate strategy
   logger.phaseStart("Deploying services");
   
@@ -693,21 +890,106 @@ async function deployServices(context: DeploymentContext): Promise<ServiceDeploy
     servicesByServer.get(service.server)!.push(service);
   }
 
-  // Deploy to each server and collect results
+  // Deploy to all servers at once, bounded by the shared parallelism limit
+  const servers = Array.from(servicesByServer);
+  const outcomes = await Promise.allSettled(
+    servers.map(([serverHostname, serverServices]) =>
+      deployServicesToServer(serverServices, context, serverHostname)
+    )
+  );
+
   const allResults: ServiceDeploymentResult[] = [];
-  for (const [serverHostname, serverServices] of servicesByServer) {
-    const serverResults = await deployServicesToServer(serverServices, context, serverHostname);
-    allResults.push(...serverResults);
+  const failures: DeploymentFailure[] = [];
+  outcomes.forEach((outcome, index) => {
+    const [serverHostname, serverServices] = servers[index];
+    if (outcome.status === "fulfilled") {
+      allResults.push(...outcome.value.results);
+      failures.push(...outcome.value.failures);
+      return;
+    }
+    // The server itself failed, e.g. SSH or proxy configuration
+    const error = outcome.reason instanceof Error ? outcome.reason.message : String(outcome.reason);
+    for (const service of serverServices) {
+      failures.push({ serviceName: service.name, server: serverHostname, error });
+    }
+  });
+
+  if (services.length > 1) {
+    logger.deploymentSummary(
+      allResults.filter((result) => result.status === "deployed").length,
+      allResults.filter((result) => result.status === "skipped").length,
+      failures.length
+    );
+  }
+  if (failures.length > 0) {
+    throw combineDeploymentFailures(failures, services.length);
   }
 
   logger.phaseEnd("Deploying services");
+
+  await removeStaleHostRegistrations(context);
+
+  if (context.config.mesh) {
+    await syncServiceDiscovery(context);
+  }
   
-  // Show URLs immediately after deployment
-  displayServiceUrls(allResults);
+  // Show URLs immediately after deployment, the JSON result lists them instead
+  if (!isJsonOutput()) {
+    displayServiceUrls(allResults);
+  }
   
   return allResults;
 }
 
+/**
+ * Finds the built services whose running container was built from the same
+ * build context and config, so their image doesn't need to be built again
+ */
+async function findUnchangedBuiltServices(
+  context: DeploymentContext,
+  services: ServiceEntry[]
+): Promise<Set<string>> {
+  const unchanged = new Set<string>();
+  if (context.forceRedeploy || services.length === 0) {
+    return unchanged;
+  }
+
+  const servers = new Set(services.map((service) => service.server));
+  for (const serverHostname of servers) {
+    let sshClient: SSHClient | undefined;
+    try {
+      sshClient = await establishSSHConnection(
+        serverHostname,
+        context.config,
+        context.secrets,
+        context.verboseFlag
+      );
+      const dockerClient = new DockerClient(sshClient, serverHostname, context.verboseFlag);
+
+      for (const service of services.filter((s) => s.server === serverHostname)) {
+        const desiredFingerprint = context.serviceFingerprints?.get(service.name);
+        const currentFingerprint = await getCurrentServiceFingerprint(service, dockerClient, context);
+        if (
+          desiredFingerprint?.contextHash &&
+          currentFingerprint &&
+          !shouldRedeploy(currentFingerprint, desiredFingerprint).shouldRedeploy
+        ) {
+          logger.verboseLog(`Skipping build of ${service.name}, build context and config unchanged`);
+          unchanged.add(service.name);
+        }
+      }
+    } catch (error) {
+      // Servers that can't be checked get their services built as usual
+      logger.verboseLog(`Could not check deployed builds on ${serverHostname}: ${error}`);
+    } finally {
+      if (sshClient) {
+        await sshClient.close();
+      }
+    }
+  }
+  return unchanged;
+}
+
 /**
  * Builds a service image (without packaging for transfer)
  */
@@ -883,6 +1165,46 @@ async function saveServiceImage(
   }
 }
 
+/**
+ * Resolves build.args variable names to values from the service's environment section
+ */
+function resolveBuildArgs(
+  serviceEntry: ServiceEntry,
+  context: DeploymentContext
+): Record<string, string> {
+  const buildArgs: Record<string, string> = {};
+
+  // Handle build.args - resolve variable names from environment section
+  if (serviceEntry.build?.args && serviceEntry.build.args.length > 0) {
+    const envVars = resolveEnvironmentVariables(serviceEntry, context.secrets);
+
+    for (const varName of serviceEntry.build.args) {
+      if (envVars[varName] !== undefined) {
+        buildArgs[varName] = envVars[varName];
+
+        // Warn about potentially sensitive variables being exposed to build context
+        const lowerCaseName = varName.toLowerCase();
+        if (
+          lowerCaseName.includes("secret") ||
+          lowerCaseName.includes("password") ||
+          lowerCaseName.includes("key") ||
+          lowerCaseName.includes("token")
+        ) {
+          logger.warn(
+            `Warning: Build argument "${varName}" appears to contain sensitive data and will be visible in Docker build context for service ${serviceEntry.name}`
+          );
+        }
+      } else {
+        throw new Error(
+          `Build argument '${varName}' is not defined in the 'environment' section for service '${serviceEntry.name}'`
+        );
+      }
+    }
+  }
+
+  return buildArgs;
+}
+
 /**
  * Gets the build configuration for a service, providing defaults if none specified
  */
@@ -897,35 +1219,7 @@ async function getServiceBuildConfig(
   target?: string;
 }> {
   if (serviceEntry.build) {
-    const buildArgs: Record<string, string> = {};
-
-    // Handle build.args - resolve variable names from environment section
-    if (serviceEntry.build.args && serviceEntry.build.args.length > 0) {
-      const envVars = resolveEnvironmentVariables(serviceEntry, context.secrets);
-
-      for (const varName of serviceEntry.build.args) {
-        if (envVars[varName] !== undefined) {
-          buildArgs[varName] = envVars[varName];
-
-          // Warn about potentially sensitive variables being exposed to build context
-          const lowerCaseName = varName.toLowerCase();
-          if (
-            lowerCaseName.includes("secret") ||
-            lowerCaseName.includes("password") ||
-            lowerCaseName.includes("key") ||
-            lowerCaseName.includes("token")
-          ) {
-            logger.warn(
-              `Warning: Build argument "${varName}" appears to contain sensitive data and will be visible in Docker build context for service ${serviceEntry.name}`
-            );
-          }
-        } else {
-          throw new Error(
-            `Build argument '${varName}' is not defined in the 'environment' section for service '${serviceEntry.name}'`
-          );
-        }
-      }
-    }
+    const buildArgs = resolveBuildArgs(serviceEntry, context);
 
     // Detect platform if not explicitly set
     let platform = serviceEntry.build.platform;
@@ -1012,7 +1306,7 @@ async function deployServicesToServer(
   services: ServiceEntry[],
   context: DeploymentContext,
   serverHostname: string
-): Promise<ServiceDeploymentResult[]> {
+): Promise<{ results: ServiceDeploymentResult[]; failures: DeploymentFailure[] }> {
   let sshClient: SSHClient | undefined;
 
   try {
@@ -1028,17 +1322,105 @@ async function deployServicesToServer(
       context.verboseFlag
     );
 
+    // Containers resolve <service>.internal through the proxy's DNS server
+    if (context.config.mesh) {
+      const meshAddress = await readMeshAddress(sshClient);
+      if (meshAddress) {
+        dockerClient.setDnsServers([meshAddress]);
+      } else {
+        logger.warn(`${serverHostname} is not in the mesh yet, run iop network setup`);
+      }
+    }
+
+    // Push notification targets first so the proxy reports this deploy
+    if (context.config.notifications) {
+      const proxyClient = new IopProxyClient(
+        dockerClient,
+        serverHostname,
+        context.verboseFlag
+      );
+      const configured = await proxyClient.configureNotifications(
+        buildNotificationTargets(context.config, context.secrets)
+      );
+      if (!configured) {
+        logger.warn(`Could not configure notifications on ${serverHostname}`);
+      }
+    }
+
+    // Switch the proxy's certificate authority before hosts request certificates
+    const acme = buildAcmeConfig(context.config, context.secrets);
+    if (acme) {
+      const proxyClient = new IopProxyClient(
+        dockerClient,
+        serverHostname,
+        context.verboseFlag
+      );
+      if (!(await proxyClient.configureAcme(acme))) {
+        throw new Error(`Failed to configure ACME directory ${acme.directory} on ${serverHostname}`);
+      }
+    }
+
     // Deploy each service with appropriate strategy and collect results
     const results: ServiceDeploymentResult[] = [];
-    for (let i = 0; i < services.length; i++) {
-      const service = services[i];
-      const isLastService = i === services.length - 1;
-      
-      const result = await deployServiceWithStrategy(service, context, dockerClient, sshClient, serverHostname, isLastService);
-      results.push(result);
+    const failures: DeploymentFailure[] = [];
+    const deploy = async (service: ServiceEntry): Promise<boolean> => {
+      const isLastService = service === services[services.length - 1];
+      try {
+        results.push(
+          await context.limiter.run(() =>
+            deployServiceWithStrategy(service, context, dockerClient, sshClient!, serverHostname, isLastService)
+          )
+        );
+        return true;
+      } catch (error) {
+        const message = error instanceof Error ? error.message : String(error);
+        logger.serviceDeploymentFailed(service.name, message, isLastService);
+        failures.push({ serviceName: service.name, server: serverHostname, error: message });
+        return false;
+      }
+    };
+
+    // Stop-start services first, one at a time, as apps may depend on them.
+    // Apps sharing a proxy host then deploy in order, separate hosts concurrently.
+    const { sequential, groups } = planDeploymentGroups(services);
+    for (const service of sequential) {
+      if (!(await deploy(service))) {
+        return { results, failures };
+      }
+    }
+    await Promise.all(
+      groups.map(async (group) => {
+        for (const service of group) {
+          if (!(await deploy(service))) {
+            return;
+          }
+        }
+      })
+    );
+    results.sort(
+      (a, b) =>
+        services.findIndex((service) => service.name === a.serviceName) -
+        services.findIndex((service) => service.name === b.serviceName)
+    );
+    if (failures.length > 0) {
+      return { results, failures };
+    }
+
+    // Keep scheduled backups in sync with the database services on this server
+    for (const service of services) {
+      if (service.backup) {
+        logger.verboseLog(`Installing backup schedule for ${service.name} on ${serverHostname}`);
+        await installBackupSchedule(sshClient, service, context.projectName, context.secrets);
+      } else if (service.template) {
+        await removeBackupSchedule(sshClient, context.projectName, service.name);
+      }
+    }
+
+    if (context.config.gc?.enabled !== false) {
+      await collectServerGarbage(dockerClient, serverHostname, services, context);
     }
     
-    return results;
+    return { results, failures };
 
   } finally {
     if (sshClient) {
@@ -1047,6 +1429,37 @@ async function deployServicesToServer(
   }
 }
 
+/**
+ * Removes old release images and leftover containers after deploying to a server,
+ * keeping the images of the running release and the configured number of previous ones
+ */
+async function collectServerGarbage(
+  dockerClient: DockerClient,
+  serverHostname: string,
+  services: ServiceEntry[],
+  context: DeploymentContext
+): Promise<void> {
+  try {
+    const plan = await planServerGc(
+      dockerClient,
+      context.projectName,
+      services,
+      getImageRetention(context.config)
+    );
+    if (plan.containers.length === 0 && plan.images.length === 0) {
+      return;
+    }
+
+    const result = await executeGcPlan(dockerClient, plan);
+    logger.verboseLog(
+      `Garbage collection on ${serverHostname}: removed ${result.removedImages} images and ${result.removedContainers} containers`
+    );
+  } catch (error) {
+    // A failed cleanup should never fail an otherwise successful deployment
+    logger.warn(`Garbage collection on ${serverHostname} failed: ${error}`);
+  }
+}
+
 /**
  * Deploy a single service using the appropriate strategy (zero-downtime vs stop-start)
  */
@@ -1067,7 +1480,10 @@ async function deployServiceWithStrategy(
   }
   
 
-  const redeployDecision = shouldRedeploy(currentFingerprint, desiredFingerprint);
+  let redeployDecision = shouldRedeploy(currentFingerprint, desiredFingerprint);
+  if (!redeployDecision.shouldRedeploy && context.forceRedeploy) {
+    redeployDecision = { shouldRedeploy: true, reason: 'forced redeploy', priority: 'critical' };
+  }
   
   if (!redeployDecision.shouldRedeploy) {
     logger.verboseLog(`✓ Service ${service.name} is up-to-date (${redeployDecision.reason})`);
@@ -1106,19 +1522,46 @@ async function deployServiceWithStrategy(
   const deploymentStartTime = Date.now();
   logger.serviceDeploymentStep(service.name, strategyText, isLastService);
 
-  // Ensure image is available
-  await ensureServiceImageAvailable(service, context, dockerClient, sshClient);
+  // Report each step to the proxy, so the deployment can be followed at GET /api/deployments/:id
+  const timeline = await DeploymentTimeline.start(
+    new IopProxyClient(dockerClient, serverHostname, context.verboseFlag),
+    context.projectName,
+    service.name,
+    service.proxy?.hosts?.[0],
+    context.metadata
+  );
 
-  // Choose deployment strategy
-  if (strategy === 'zero-downtime') {
-    await deployServiceWithZeroDowntime(service, context, dockerClient, serverHostname, desiredFingerprint);
-  } else {
-    await deployServiceWithStopStart(service, context, dockerClient, serverHostname);
-  }
+  try {
+    // Ensure image is available
+    await ensureServiceImageAvailable(service, context, dockerClient, sshClient, timeline);
 
-  // Configure proxy if needed
-  if (service.proxy) {
-    await configureProxyForService(service, dockerClient, serverHostname, context);
+    // Choose deployment strategy
+    let bake: ProxyBake | undefined;
+    if (strategy === 'zero-downtime') {
+      bake = await deployServiceWithZeroDowntime(service, context, dockerClient, serverHostname, desiredFingerprint, timeline);
+    } else {
+      await runStep(timeline, "start", () =>
+        deployServiceWithStopStart(service, context, dockerClient, serverHostname)
+      );
+      await timeline?.step("health_check", "skipped", { message: "Stop-start deployment" });
+      await timeline?.step("cleanup", "skipped", { message: "Stop-start deployment" });
+    }
+
+    // Configure proxy if needed
+    if (service.proxy) {
+      await runStep(timeline, "switch", () =>
+        configureProxyForService(service, dockerClient, serverHostname, context, timeline?.id, bake)
+      );
+    } else if (strategy !== 'zero-downtime') {
+      await timeline?.step("switch", "skipped", { message: "Not served through the proxy" });
+    }
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    const failedStep = await timeline?.fail(message);
+    if (failedStep) {
+      throw new Error(`${message} (step ${failedStep} of deployment ${timeline!.id})`);
+    }
+    throw error;
   }
 
   // Complete service deployment logging
@@ -1147,19 +1590,22 @@ async function deployServiceWithStrategy(
     status: 'deployed',
     reason: redeployDecision.reason,
     url,
+    deploymentId: timeline?.id,
   };
 }
 
 /**
- * Deploy service using zero-downtime strategy (blue-green)
+ * Deploy service using zero-downtime strategy (blue-green), returning the
+ * bake the proxy watches the switch with when the previous containers were kept
  */
 async function deployServiceWithZeroDowntime(
   service: ServiceEntry,
   context: DeploymentContext,
   dockerClient: DockerClient,
   serverHostname: string,
-  fingerprint: ServiceFingerprint
-): Promise<void> {
+  fingerprint: ServiceFingerprint,
+  timeline?: DeploymentTimeline
+): Promise<ProxyBake | undefined> {
   logger.verboseLog(`🚀 Deploying ${service.name} with zero-downtime strategy`);
 
   // Use the existing blue-green deployment logic
@@ -1173,11 +1619,44 @@ async function deployServiceWithZeroDowntime(
     serverHostname,
     verbose: context.verboseFlag,
     fingerprint, // Pass fingerprint for container labels
+    declaredVolumes: getDeclaredVolumeNames(context.config),
+    metadata: context.metadata,
+    scanLabels: getScanLabels(context.scanCounts?.get(service.name)),
+    timeline,
+    bake: !!service.proxy?.bake,
   });
 
   if (!deploymentResult.success) {
     throw new Error(deploymentResult.error || "Zero-downtime deployment failed");
   }
+
+  return toProxyBake(service, context.projectName, context.networkName, deploymentResult);
+}
+
+/**
+ * The bake the proxy watches a blue-green switch with, undefined when the
+ * service isn't baked or there were no previous containers to keep
+ */
+export function toProxyBake(
+  service: ServiceEntry,
+  projectName: string,
+  networkName: string,
+  result: BlueGreenDeploymentResult
+): ProxyBake | undefined {
+  const bake = service.proxy?.bake;
+  if (!bake || !result.previousColor || !result.previousContainers?.length) {
+    return undefined;
+  }
+
+  return {
+    ...bake,
+    network: networkName,
+    aliases: [service.name, `${projectName}-${service.name}`],
+    color: result.newColor,
+    containers: result.deployedContainers,
+    previous_color: result.previousColor,
+    previous: result.previousContainers,
+  };
 }
 
 /**
@@ -1215,8 +1694,30 @@ async function deployServiceWithStopStart(
     context.secrets,
     context.projectName,
     context.releaseId,
-    fingerprint
+    fingerprint,
+    getDeclaredVolumeNames(context.config)
+  );
+
+  // Recorded so the exact image can be deployed again with --digest
+  const imageDigest = await dockerClient.getImageDigest(containerOptions.image);
+  if (imageDigest) {
+    containerOptions.labels![IMAGE_DIGEST_LABEL] = imageDigest;
+  }
+  Object.assign(containerOptions.labels!, getDeployMetadataLabels(context.metadata));
+  Object.assign(containerOptions.labels!, getScanLabels(context.scanCounts?.get(service.name)));
+
+  const initError = await runInitSteps(
+    service,
+    containerOptions,
+    context.secrets,
+    context.projectName,
+    dockerClient,
+    serverHostname,
+    context.verboseFlag
   );
+  if (initError) {
+    throw new Error(initError);
+  }
 
   const success = await dockerClient.createContainer(containerOptions);
   if (!success) {
@@ -1233,20 +1734,122 @@ async function ensureServiceImageAvailable(
   service: ServiceEntry,
   context: DeploymentContext,
   dockerClient: DockerClient,
-  sshClient: SSHClient
+  sshClient: SSHClient,
+  timeline?: DeploymentTimeline
 ): Promise<void> {
-  if (serviceNeedsBuilding(service)) {
+  if (serviceNeedsBuilding(service) && context.buildRemote) {
+    await runStep(timeline, "build", () =>
+      buildServiceImageOnServer(service, context, dockerClient, sshClient)
+    );
+    await timeline?.step("push", "skipped", { message: "Built on the server" });
+  } else if (serviceNeedsBuilding(service)) {
     // Package the image for transfer (lazy packaging)
-    const archivePath = await buildAndPackageServiceForTransfer(service, context);
+    const archivePath = await runStep(timeline, "build", () =>
+      buildAndPackageServiceForTransfer(service, context)
+    );
     context.imageArchives?.set(service.name, archivePath);
     
     // Transfer and load built image
     const imageNameWithRelease = buildServiceImageName(service, context.releaseId);
-    await transferAndLoadServiceImage(service, sshClient, dockerClient, context, imageNameWithRelease);
+    await runStep(timeline, "push", () =>
+      transferAndLoadServiceImage(service, sshClient, dockerClient, context, imageNameWithRelease)
+    );
   } else {
     // Pull pre-built image
     const imageName = service.image!;
-    await authenticateAndPullImage(service, dockerClient, context, imageName);
+    await timeline?.step("build", "skipped", { message: `Using ${imageName}` });
+    await runStep(timeline, "push", () =>
+      authenticateAndPullImage(service, dockerClient, context, imageName)
+    );
+  }
+
+  await scanServiceImage(service, context, dockerClient, timeline);
+}
+
+/**
+ * Scans a service's image on the server before any of its containers start,
+ * failing the deploy on vulnerabilities at or above the configured severity
+ */
+async function scanServiceImage(
+  service: ServiceEntry,
+  context: DeploymentContext,
+  dockerClient: DockerClient,
+  timeline?: DeploymentTimeline
+): Promise<void> {
+  const scanConfig = getImageScanConfig(service, context.config);
+  if (!scanConfig) {
+    await timeline?.step("scan", "skipped", { message: "Scanning not configured" });
+    return;
+  }
+
+  const image = buildServiceImageName(service, context.releaseId);
+  await timeline?.step("scan", "running");
+  logger.verboseLog(`Scanning ${image} with ${scanConfig.scanner}...`);
+  const result = await scanImage(dockerClient, image, scanConfig);
+  const summary = `${formatScanCounts(result.counts)}${result.cached ? " (cached)" : ""}`;
+  context.scanCounts?.set(service.name, result.counts);
+
+  if (result.blocking.length > 0) {
+    throw new Error(
+      `${image} has ${formatBlockingVulnerabilities(result, scanConfig)}. Upgrade the affected packages, or list reviewed IDs under scan.ignore.`
+    );
+  }
+  if (Object.values(result.counts).some((count) => count > 0)) {
+    logger.warn(`${service.name}: ${image} has ${summary}`);
+  } else {
+    logger.verboseLog(`${service.name}: ${image} has ${summary}`);
+  }
+  await timeline?.step("scan", "succeeded", { message: summary });
+}
+
+/**
+ * Ships a service's build context (respecting .dockerignore) to the server and
+ * builds the image there, avoiding a large image upload from the local machine
+ */
+async function buildServiceImageOnServer(
+  service: ServiceEntry,
+  context: DeploymentContext,
+  dockerClient: DockerClient,
+  sshClient: SSHClient
+): Promise<void> {
+  const contextDir = service.build?.context || ".";
+  const dockerfile = service.build?.dockerfile || "Dockerfile";
+  const imageNameWithRelease = buildServiceImageName(service, context.releaseId);
+  const latestTag = `${getServiceImageName(service)}:latest`;
+
+  const files = collectBuildContextFiles(contextDir, dockerfile);
+  logger.verboseLog(
+    `Packaging build context for ${service.name} (${files.length} files)`
+  );
+
+  const tempDir = fs.mkdtempSync(path.join(os.tmpdir(), "iop-"));
+  const archivePath = path.join(tempDir, `${service.name}-context.tar.gz`);
+  const remoteDir = `/tmp/iop-build-${service.name}-${context.releaseId}`;
+  const remoteArchivePath = `${remoteDir}.tar.gz`;
+
+  try {
+    await createBuildContextArchive(contextDir, files, archivePath);
+    await sshClient.uploadFile(archivePath, remoteArchivePath);
+    await sshClient.exec(
+      `mkdir -p ${remoteDir} && tar -xzf ${remoteArchivePath} -C ${remoteDir}`
+    );
+
+    logger.verboseLog(`Building ${service.name} on ${service.server}...`);
+    const built = await dockerClient.buildOnServer(remoteDir, {
+      dockerfile,
+      tags: [imageNameWithRelease, latestTag],
+      buildArgs: resolveBuildArgs(service, context),
+      target: service.build?.target,
+      platform: service.build?.platform, // Native server platform unless pinned
+    });
+    if (!built) {
+      throw new Error(`Remote build of ${service.name} failed`);
+    }
+  } finally {
+    await sshClient
+      .exec(`rm -rf ${remoteDir} ${remoteArchivePath}`)
+      .catch(() => {});
+    fs.rmSync(tempDir, { recursive: true, force: true });
   }
 }
 
@@ -1307,6 +1910,7 @@ async function getCurrentServiceFingerprint(
         configHash,
         secretsHash,
         serverImageHash,
+        contextHash: labels['iop.context-hash'],
       };
     } else {
       return {
@@ -1382,6 +1986,17 @@ async function reconcileServicesOnServer(
       for (const serviceName of servicesToRemove) {
         await removeOrphanedService(serviceName, dockerClient, serverHostname, context.projectName);
       }
+
+      if (context.config.dns && context.dnsMode === "auto") {
+        await removeDnsRecords(context, servicesToRemove);
+      }
+
+      // Only removed once nothing but the proxy is left on it
+      if (await removeProjectNetwork(dockerClient, context.projectName)) {
+        logger.verboseLog(
+          `Removed ${getProjectNetworkName(context.projectName)} from ${serverHostname}`
+        );
+      }
     }
   } catch (error) {
     logger.error(`Failed to deploy services to ${serverHostname}: ${error}`);
@@ -1456,9 +2071,6 @@ async function deployServiceDirectly(
       context
     );
 
-    logger.verboseLog(`Pruning Docker resources on ${serverHostname}`);
-    await dockerClient.prune();
-
     logger.verboseLog(
       `✓ Service ${serviceEntry.name} deployed successfully to ${serverHostname}`
     );
@@ -1502,46 +2114,92 @@ async function authenticateAndPullImage(
   context: DeploymentContext,
   imageToPull: string
 ): Promise<void> {
-  const globalRegistryConfig = context.config.docker;
-  const entryRegistry = entry.registry;
-  let imageRegistry =
-    entryRegistry?.url || globalRegistryConfig?.registry || "docker.io";
-  let registryLoginPerformed = false;
-
-  if (entryRegistry?.username && entryRegistry?.password_secret) {
-    const password = context.secrets[entryRegistry.password_secret];
-    if (password) {
-      await performRegistryLogin(
-        dockerClientRemote,
-        imageRegistry,
-        entryRegistry.username,
-        password
+  const credentials = resolveRegistryCredentials(
+    entry,
+    context.config,
+    context.secrets,
+    imageToPull
+  );
+
+  if (credentials) {
+    logger.verboseLog(`Authenticating with registry ${credentials.registry}`);
+    const loggedIn =
+      credentials.kind === "ecr"
+        ? await dockerClientRemote.loginWithEcr(
+            credentials.registry,
+            credentials.region,
+            credentials.accessKeyId,
+            credentials.secretAccessKey
+          )
+        : await performRegistryLogin(
+            dockerClientRemote,
+            credentials.registry,
+            credentials.username,
+            credentials.password
+          );
+
+    if (!loggedIn) {
+      throw new Error(
+        `Failed to authenticate with registry ${credentials.registry} for ${entry.name}`
       );
-      registryLoginPerformed = true;
     }
-  } else if (
-    globalRegistryConfig?.username &&
-    context.secrets.DOCKER_REGISTRY_PASSWORD
-  ) {
-    await performRegistryLogin(
-      dockerClientRemote,
-      imageRegistry,
-      globalRegistryConfig.username,
-      context.secrets.DOCKER_REGISTRY_PASSWORD
-    );
-    registryLoginPerformed = true;
   }
 
   logger.verboseLog(`Pulling image ${imageToPull}...`);
   const pullSuccess = await dockerClientRemote.pullImage(imageToPull);
 
-  if (registryLoginPerformed) {
-    await dockerClientRemote.logout(imageRegistry);
+  // Signatures are fetched from the registry, so they're checked before logging out
+  let verifyError: unknown;
+  if (pullSuccess && entry.verify) {
+    try {
+      await verifyImageSignature(entry, dockerClientRemote, imageToPull);
+    } catch (error) {
+      verifyError = error;
+    }
+  }
+
+  // Don't leave registry credentials behind on the server
+  if (credentials) {
+    await dockerClientRemote.logout(credentials.registry);
   }
 
   if (!pullSuccess) {
     throw new Error(`Failed to pull image ${imageToPull}`);
   }
+  if (verifyError) {
+    throw verifyError;
+  }
+}
+
+/**
+ * Verifies the cosign signature of a pulled image. The digest that was pulled
+ * is verified rather than the tag, which could have moved since.
+ */
+async function verifyImageSignature(
+  entry: ServiceEntry,
+  dockerClientRemote: DockerClient,
+  image: string
+): Promise<void> {
+  const verify = entry.verify!;
+  const digest = await dockerClientRemote.getImageDigest(image);
+  if (!digest) {
+    throw new Error(`Could not read the digest of ${image} to verify its signature`);
+  }
+
+  const imageRef = pinImageDigest(image, digest);
+  const publicKey = verify.key ? fs.readFileSync(verify.key, "utf-8") : undefined;
+  logger.verboseLog(`Verifying signature of ${imageRef}`);
+
+  const result = await dockerClientRemote.verifyImageSignature(
+    buildCosignVerifyArgs(verify, imageRef),
+    publicKey
+  );
+  if (!result.success) {
+    throw new Error(
+      `Signature verification failed for ${entry.name} (${imageRef}): ${result.output}`
+    );
+  }
+  logger.verboseLog(`✓ Signature of ${imageRef} verified`);
 }
 
 /**
@@ -1552,19 +2210,209 @@ async function performRegistryLogin(
   registry: string,
   username: string,
   password: string
-): Promise<void> {
+): Promise<boolean> {
   try {
-    await dockerClient.login(registry, username, password);
-    logger.verboseLog(`Successfully logged into registry`);
+    const loggedIn = await dockerClient.login(registry, username, password);
+    if (loggedIn) {
+      logger.verboseLog(`Successfully logged into registry`);
+    }
+    return loggedIn;
   } catch (loginError) {
     const errorMessage = String(loginError);
     if (
       errorMessage.includes("WARNING! Your password will be stored unencrypted")
     ) {
       logger.verboseLog(`Successfully logged into registry`);
-    } else {
-      logger.error(`Failed to login to registry`, loginError);
+      return true;
+    }
+    logger.error(`Failed to login to registry`, loginError);
+    return false;
+  }
+}
+
+/**
+ * Creates or verifies DNS records pointing each target service's proxy hosts at its server
+ */
+async function syncDnsRecords(context: DeploymentContext): Promise<void> {
+  const dnsClient = CloudflareDnsClient.fromConfig(context.config.dns!, context.secrets);
+
+  for (const service of context.targetServices) {
+    const hosts = service.proxy?.hosts || [];
+    for (const host of hosts) {
+      const change = await dnsClient.ensureRecord(
+        host,
+        service.server,
+        getDnsRecordComment(context.projectName, service.name)
+      );
+
+      if (change.action === "conflict") {
+        logger.warn(
+          `DNS record for ${host} points to ${change.record.content} and was not created by iop, leaving it unchanged`
+        );
+      } else if (change.action !== "unchanged") {
+        logger.verboseLog(`DNS record for ${host} ${change.action}d → ${service.server}`);
+      }
+    }
+  }
+}
+
+/**
+ * Removes the deployed services' hosts from the proxies of other servers,
+ * where they are left behind when a service moves. Hosts another project
+ * registered there are only reported.
+ */
+async function removeStaleHostRegistrations(context: DeploymentContext): Promise<void> {
+  const proxyServers = getProxyServers(context.config);
+  const hasHosts = context.targetServices.some((service) => service.proxy?.hosts?.length);
+  if (proxyServers.length < 2 || !hasHosts) {
+    return;
+  }
+
+  const cluster = new IopProxyCluster(
+    proxyServers,
+    (serverHostname) =>
+      establishSSHConnection(
+        serverHostname,
+        context.config,
+        context.secrets,
+        context.verboseFlag
+      ),
+    context.verboseFlag
+  );
+
+  try {
+    const { hosts: proxyHostsByServer, unreachable } = await cluster.getHostsByServer();
+    for (const [serverHostname, reason] of unreachable) {
+      logger.warn(`Could not check the proxy on ${serverHostname} for stale hosts: ${reason}`);
+    }
+
+    const hosts = aggregateHosts(proxyHostsByServer);
+    const targetNames = new Set(context.targetServices.map((service) => service.name));
+    const stale = findStaleHostRegistrations(context.config, hosts).filter(
+      (registration) => targetNames.has(registration.service)
+    );
+
+    for (const registration of stale) {
+      logger.verboseLog(
+        `Removing ${registration.host} from the proxy on ${registration.server}, ${registration.service} now runs on ${registration.owner}`
+      );
+      if (!(await cluster.removeHost(registration.host, registration.server))) {
+        logger.warn(
+          `Could not remove ${registration.host} from the proxy on ${registration.server}`
+        );
+      }
+    }
+
+    for (const service of context.targetServices) {
+      for (const host of service.proxy?.hosts || []) {
+        for (const registration of hosts[host] || []) {
+          if (
+            registration.server !== service.server &&
+            registration.project !== context.projectName
+          ) {
+            logger.warn(
+              `${host} is also routed to project "${registration.project}" by the proxy on ${registration.server}`
+            );
+          }
+        }
+      }
     }
+  } catch (error) {
+    logger.warn(`Failed to check other proxies for stale hosts: ${error}`);
+  } finally {
+    await cluster.close();
+  }
+}
+
+/**
+ * Builds the service records for the proxy on one server: services running
+ * there resolve to their container, services elsewhere to their server's mesh
+ * address. Services on servers outside the mesh are left out.
+ */
+export function buildServiceRecords(
+  services: ServiceEntry[],
+  projectName: string,
+  server: string,
+  meshAddresses: Record<string, string>
+): ProxyServiceRecord[] {
+  const records: ProxyServiceRecord[] = [];
+  for (const service of services) {
+    if (service.server === server) {
+      records.push({ name: service.name, container: `${projectName}-${service.name}` });
+    } else if (meshAddresses[service.server]) {
+      records.push({ name: service.name, address: meshAddresses[service.server] });
+    }
+  }
+  return records;
+}
+
+/**
+ * Sends every proxy in the mesh the project's service records, so containers
+ * resolve <service>.internal on whichever server the service runs
+ */
+async function syncServiceDiscovery(context: DeploymentContext): Promise<void> {
+  const services = normalizeConfigEntries(context.config.services) as ServiceEntry[];
+  const sshClients = new Map<string, SSHClient>();
+  const meshAddresses: Record<string, string> = {};
+
+  try {
+    for (const server of getProxyServers(context.config)) {
+      try {
+        const sshClient = await establishSSHConnection(
+          server,
+          context.config,
+          context.secrets,
+          context.verboseFlag
+        );
+        sshClients.set(server, sshClient);
+        const meshAddress = await readMeshAddress(sshClient);
+        if (meshAddress) {
+          meshAddresses[server] = meshAddress;
+        }
+      } catch (error) {
+        logger.warn(`Could not read the mesh address of ${server}: ${error}`);
+      }
+    }
+
+    for (const [server, sshClient] of sshClients) {
+      if (!meshAddresses[server]) continue;
+      const records = buildServiceRecords(services, context.projectName, server, meshAddresses);
+      logger.verboseLog(`Resolving ${records.length} services through the proxy on ${server}`);
+      const proxyClient = new IopProxyClient(
+        new DockerClient(sshClient, server, context.verboseFlag),
+        server,
+        context.verboseFlag
+      );
+      if (!(await proxyClient.setServiceRecords(context.projectName, records))) {
+        logger.warn(`Could not update service discovery on ${server}`);
+      }
+    }
+  } finally {
+    for (const sshClient of sshClients.values()) {
+      await sshClient.close();
+    }
+  }
+}
+
+/**
+ * Deletes the DNS records iop created for services that were removed
+ */
+async function removeDnsRecords(
+  context: DeploymentContext,
+  serviceNames: string[]
+): Promise<void> {
+  try {
+    const dnsClient = CloudflareDnsClient.fromConfig(context.config.dns!, context.secrets);
+    for (const serviceName of serviceNames) {
+      const removed = await dnsClient.deleteRecordsByComment(
+        getDnsRecordComment(context.projectName, serviceName)
+      );
+      if (removed.length > 0) {
+        logger.verboseLog(`Removed DNS records for ${serviceName}: ${removed.join(", ")}`);
+      }
+    }
+  } catch (error) {
+    logger.warn(`Failed to remove DNS records: ${error}`);
   }
 }
 
@@ -1600,6 +2448,7 @@ async function removeOrphanedService(
       // Stop and remove all containers for this service
       for (const containerName of allContainers) {
         try {
+          await dockerClient.removeSidecars(containerName);
           await dockerClient.stopContainer(containerName);
           await dockerClient.removeContainer(containerName);
           logger.verboseLog(`Removed container: ${containerName}`);
@@ -1616,13 +2465,16 @@ async function removeOrphanedService(
 }
 
 /**
- * Configures iop-proxy routing for a service's hosts
+ * Configures iop-proxy routing for a service's hosts, as the switch step of
+ * the deployment timeline when one is given
  */
 async function configureProxyForService(
   service: ServiceEntry,
   dockerClient: DockerClient,
   serverHostname: string,
-  context: DeploymentContext
+  context: DeploymentContext,
+  deploymentId?: string,
+  bake?: ProxyBake
 ): Promise<void> {
   // Skip proxy configuration if no proxy config at all
   if (!service.proxy) return;
@@ -1667,13 +2519,49 @@ async function configureProxyForService(
       projectSpecificTarget,
       servicePort,
       context.projectName,
-      healthPath
+      healthPath,
+      service.proxy.mode,
+      service.proxy,
+      context.metadata,
+      deploymentId,
+      bake
     );
 
     if (!success) {
       throw new Error(`Failed to configure proxy for ${host}`);
     }
 
+    const tlsPolicy = service.proxy.tls;
+    if (!(await proxyClient.setHostTlsPolicy(host, tlsPolicy || null))) {
+      throw new Error(`Failed to apply TLS policy for ${host}`);
+    }
+
+    if (!(await proxyClient.setHostLimits(host, service.proxy.limits || null))) {
+      throw new Error(`Failed to apply limits for ${host}`);
+    }
+
+    if (!(await proxyClient.setHostProbes(host, toHostProbes(service)))) {
+      throw new Error(`Failed to apply probes for ${host}`);
+    }
+
+    if (
+      !(await proxyClient.setScaleToZero(
+        host,
+        !!service.proxy.scale_to_zero,
+        service.proxy.idle_timeout,
+        service.proxy.cold_start
+      ))
+    ) {
+      throw new Error(`Failed to apply scale to zero for ${host}`);
+    }
+
+    // The deploy goes ahead either way, the certificate follows once DNS is fixed
+    if (service.proxy.mode !== "passthrough") {
+      for (const problem of await proxyClient.precheckCertificate(host)) {
+        logger.warn(problem);
+      }
+    }
+
     // Verify health and update proxy status
     logger.verboseLog(
       `Verifying health for ${host} -> ${projectSpecificTarget}:${servicePort}${healthPath}`
@@ -1704,6 +2592,54 @@ async function configureProxyForService(
       );
     }
   }
+
+  if (service.proxy.default_backend) {
+    const target = `${projectSpecificTarget}:${servicePort}`;
+    if (!(await proxyClient.setDefaultBackend(target))) {
+      throw new Error(`Failed to make ${service.name} the default backend`);
+    }
+  }
+
+  if (
+    !(await proxyClient.setAutoscalePolicy(
+      context.projectName,
+      service.name,
+      toAutoscalePolicy(service)
+    ))
+  ) {
+    throw new Error(`Failed to apply autoscaling for ${service.name}`);
+  }
+}
+
+/**
+ * Converts a service's autoscale config to the proxy's policy. Replicas from
+ * the config are the floor unless min_replicas says otherwise.
+ */
+export function toAutoscalePolicy(service: ServiceEntry): ProxyAutoscalePolicy | null {
+  const autoscale = service.autoscale;
+  if (!autoscale) return null;
+
+  const minReplicas = autoscale.min_replicas ?? service.replicas ?? 1;
+  return {
+    min_replicas: minReplicas,
+    max_replicas: Math.max(autoscale.max_replicas, minReplicas),
+    target_cpu: autoscale.target_cpu,
+    target_request_rate: autoscale.target_requests_per_second,
+    target_latency_ms: autoscale.target_latency_ms,
+    target_concurrency: autoscale.target_concurrency,
+    scale_up_cooldown: autoscale.scale_up_cooldown,
+    scale_down_cooldown: autoscale.scale_down_cooldown,
+  };
+}
+
+/**
+ * Converts a service's readiness and liveness probes to the proxy's. The
+ * proxy checks the host's health path for probes without their own.
+ */
+export function toHostProbes(service: ServiceEntry): ProxyHostProbes | null {
+  const { readiness, liveness } = service.health_check || {};
+  if (!readiness && !liveness) return null;
+  return { readiness, liveness };
 }
 
 /**
@@ -1797,7 +2733,8 @@ export async function serviceNeedsUpdate(
       context.secrets,
       context.projectName,
       context.releaseId,
-      fingerprint
+      fingerprint,
+      getDeclaredVolumeNames(context.config)
     );
 
     // Check for changes
@@ -1851,9 +2788,30 @@ async function replaceServiceContainer(
     context.secrets,
     context.projectName,
     context.releaseId,
-    fingerprint
+    fingerprint,
+    getDeclaredVolumeNames(context.config)
   );
 
+  // Recorded so the exact image can be deployed again with --digest
+  const imageDigest = await dockerClient.getImageDigest(serviceContainerOptions.image);
+  if (imageDigest) {
+    serviceContainerOptions.labels![IMAGE_DIGEST_LABEL] = imageDigest;
+  }
+  Object.assign(serviceContainerOptions.labels!, getDeployMetadataLabels(context.metadata));
+
+  const initError = await runInitSteps(
+    serviceEntry,
+    serviceContainerOptions,
+    context.secrets,
+    context.projectName,
+    dockerClient,
+    serverHostname,
+    context.verboseFlag
+  );
+  if (initError) {
+    throw new Error(initError);
+  }
+
   logger.verboseLog(
     `Starting new service container ${containerName} on ${serverHostname}`
   );
@@ -2069,6 +3027,7 @@ async function removeOrphanedApps(
         // Stop and remove all containers for this app
         for (const containerName of appContainers) {
           try {
+            await dockerClient.removeSidecars(containerName);
             await dockerClient.stopContainer(containerName);
             await dockerClient.removeContainer(containerName);
             logger.verboseLog(`Removed container: ${containerName}`);
@@ -2159,8 +3118,8 @@ async function transferAndLoadServiceImage(
           const timeStr = (logger as any).formatDuration(elapsed);
 
           // Clear current line and show progress with file size
-          process.stdout.write("\r\x1b[K");
-          process.stdout.write(
+          progressStream().write("\r\x1b[K");
+          progressStream().write(
             `     ├─ [${spinner}] Loading ${
               serviceEntry.name
             } image... (${timeStr}) | ${transferredMB}MB/${totalSizeMB}MB ${
@@ -2227,10 +3186,24 @@ async function bootstrapFreshServer(
 ): Promise<void> {
   logger.verboseLog(`Attempting to bootstrap fresh server: ${serverHostname}`);
 
-  // Try to connect as root
+  // Try to connect as root. Bastions keep the configured user, as only the
+  // server itself is fresh.
+  const sshUsername = config.ssh?.username || "root";
+  const withUsername = <T extends { username?: string }>(bastion: T | false | undefined) =>
+    bastion ? { ...bastion, username: bastion.username || sshUsername } : bastion;
   const rootConfig = {
     ...config,
-    ssh: { ...config.ssh, username: "root" },
+    ssh: {
+      ...config.ssh,
+      username: "root",
+      bastion: withUsername(config.ssh?.bastion) || undefined,
+      servers: Object.fromEntries(
+        Object.entries(config.ssh?.servers || {}).map(([host, server]) => [
+          host,
+          { ...server, bastion: withUsername(server.bastion) },
+        ])
+      ),
+    },
   };
 
   const rootSshClient = await establishSSHConnection(
@@ -2323,6 +3296,7 @@ async function ensureInfrastructureReady(
   logger.step("Preparing infrastructure");
 
   const startTime = Date.now();
+  const hardeningResults: Array<{ server: string; results: HardeningStepResult[] }> = [];
 
   for (const server of servers) {
     let sshClient: SSHClient;
@@ -2377,24 +3351,20 @@ async function ensureInfrastructureReady(
 
     try {
       // Fast checks first (parallel where possible)
-      const [networkExists, proxyRunning, directoriesExist] = await Promise.all(
-        [
-          dockerClient.networkExists(getProjectNetworkName(config.name!)),
-          dockerClient.containerExists("iop-proxy"),
-          checkProjectDirectoriesExist(sshClient, config.name!),
-        ]
-      );
+      const [proxyRunning, directoriesExist] = await Promise.all([
+        dockerClient.containerExists("iop-proxy"),
+        checkProjectDirectoriesExist(sshClient, config.name!),
+      ]);
 
       // Only do work that's actually needed
       const tasks = [];
 
-      if (!networkExists) {
-        tasks.push(() =>
-          dockerClient.createNetwork({
-            name: getProjectNetworkName(config.name!),
-          })
-        );
-      }
+      // The project network is created, or checked not to belong to
+      // something else, before anything attaches to it
+      tasks.push(async () => {
+        const warnings = await ensureProjectNetwork(dockerClient, config);
+        warnings.forEach((warning) => logger.warn(`${server}: ${warning}`));
+      });
 
       if (!directoriesExist) {
         tasks.push(() => ensureProjectDirectories(sshClient, config.name!));
@@ -2404,6 +3374,12 @@ async function ensureInfrastructureReady(
         tasks.push(() => setupIopProxy(server, sshClient, false));
       }
 
+      // Named volumes are created before any container mounts them so Docker
+      // never falls back to an anonymous, unlabelled volume
+      if (getDeclaredVolumeNames(config).length > 0) {
+        tasks.push(() => ensureProjectVolumes(dockerClient, config, server));
+      }
+
       // Always ensure proxy is connected to project network (needed for health checks)
       const projectNetworkName = getProjectNetworkName(config.name!);
       tasks.push(async () => {
@@ -2421,6 +3397,44 @@ async function ensureInfrastructureReady(
         }
       });
 
+      // The built-in registry runs once the proxy is there to route it
+      if (getRegistryServer(config) === server) {
+        tasks.push(async () => {
+          const proxyClient = new IopProxyClient(dockerClient, server, verbose);
+          if (
+            await ensureBuiltinRegistry(sshClient, dockerClient, proxyClient, config, secrets)
+          ) {
+            logger.verboseLog(`Registry ${config.registry!.host} started on ${server}`);
+          }
+        });
+      }
+
+      // The status page is served by the proxy itself, so it only needs to
+      // know the page's host and apps
+      if (getStatusPageServer(config) === server) {
+        tasks.push(async () => {
+          const proxyClient = new IopProxyClient(dockerClient, server, verbose);
+          if (await ensureStatusPage(proxyClient, config)) {
+            logger.verboseLog(`Status page ${config.status_page!.host} served by ${server}`);
+          } else {
+            logger.warn(`Status page ${config.status_page!.host} could not be set up on ${server}, the proxy may need an update: iop proxy update`);
+          }
+        });
+      }
+
+      // The log shipper follows the hosts in iop.yml, and is only recreated
+      // when they, the destinations or their secrets change
+      if (config.logs) {
+        tasks.push(async () => {
+          const services = normalizeConfigEntries(config.services).filter(
+            (service: ServiceEntry) => service.server === server
+          );
+          if (await ensureLogShipper(sshClient, dockerClient, config, secrets, server, services)) {
+            logger.verboseLog(`Log shipper configured on ${server}`);
+          }
+        });
+      }
+
       // Execute only needed tasks
       if (tasks.length > 0) {
         logger.verboseLog(
@@ -2432,6 +3446,19 @@ async function ensureInfrastructureReady(
       } else {
         logger.verboseLog(`Infrastructure already ready for ${server}`);
       }
+
+      // Hardening is opt-in, so a step that fails stops the deploy rather
+      // than leaving the server less protected than configured
+      if (config.hardening) {
+        try {
+          hardeningResults.push({
+            server,
+            results: await hardenServer(sshClient, config),
+          });
+        } catch (error) {
+          throw new Error(`Server hardening failed on ${server}: ${error}`);
+        }
+      }
     } finally {
       await sshClient.close();
     }
@@ -2439,6 +3466,58 @@ async function ensureInfrastructureReady(
 
   const elapsed = Date.now() - startTime;
   logger.stepComplete("Preparing infrastructure", elapsed);
+
+  for (const { server, results } of hardeningResults) {
+    logger.info(`Server hardening on ${server}`);
+    for (const result of results) {
+      logProgress(`    ${formatHardeningResult(result)}`);
+    }
+  }
+}
+
+/**
+ * Creates the declared named volumes used by services on a server
+ */
+async function ensureProjectVolumes(
+  dockerClient: DockerClient,
+  config: IopConfig,
+  server: string
+): Promise<void> {
+  const declaredVolumes = getDeclaredVolumeNames(config);
+  const servicesOnServer = normalizeConfigEntries(config.services).filter(
+    (service) => service.server === server
+  );
+
+  const volumesOnServer = new Set<string>();
+  for (const service of servicesOnServer) {
+    getServiceNamedVolumes(service.volumes, declaredVolumes).forEach((name) =>
+      volumesOnServer.add(name)
+    );
+  }
+
+  for (const volumeName of volumesOnServer) {
+    const volumeConfig = config.volumes![volumeName];
+    const dockerVolumeName = getProjectVolumeName(config.name, volumeName);
+
+    const created = await dockerClient.createVolume({
+      name: dockerVolumeName,
+      driver: volumeConfig.driver,
+      driverOpts: volumeConfig.driver_opts,
+      labels: {
+        "iop.managed": "true",
+        "iop.project": config.name,
+        "iop.volume": volumeName,
+        ...(volumeConfig.size ? { "iop.size": volumeConfig.size } : {}),
+      },
+    });
+
+    if (!created) {
+      throw new Error(
+        `Failed to create volume ${dockerVolumeName} on ${server}`
+      );
+    }
+    logger.verboseLog(`Volume ${dockerVolumeName} ready on ${server}`);
+  }
 }
 
 async function checkProjectDirectoriesExist(
@@ -2454,12 +3533,332 @@ async function checkProjectDirectoriesExist(
   }
 }
 
+/**
+ * Works out what a deployment would do without changing anything locally or on
+ * the servers. Mirrors the decisions deployServices makes, using read-only checks.
+ */
+async function planDeployment(context: DeploymentContext): Promise<DeployPlan> {
+  const actions: PlanAction[] = [];
+
+  for (const service of context.targetServices.filter((s) => serviceNeedsBuilding(s))) {
+    const imageName = buildServiceImageName(service, context.releaseId);
+    actions.push({
+      change: "create",
+      kind: "build",
+      target: service.name,
+      server: context.buildRemote ? service.server : undefined,
+      detail: context.buildRemote ? `build ${imageName} on server` : `build ${imageName}`,
+    });
+  }
+
+  const allServers = new Set<string>();
+  context.targetServices.forEach((service) => allServers.add(service.server));
+  normalizeConfigEntries(context.config.services).forEach((service) =>
+    allServers.add(service.server)
+  );
+
+  for (const serverHostname of allServers) {
+    let sshClient: SSHClient | undefined;
+
+    try {
+      sshClient = await establishSSHConnection(
+        serverHostname,
+        context.config,
+        context.secrets,
+        context.verboseFlag
+      );
+      const dockerClient = new DockerClient(sshClient, serverHostname, context.verboseFlag);
+      const proxyClient = new IopProxyClient(dockerClient, serverHostname, context.verboseFlag);
+
+      if (!(await dockerClient.networkExists(context.networkName))) {
+        actions.push({
+          change: "create",
+          kind: "network",
+          target: context.networkName,
+          server: serverHostname,
+          detail: "create project network",
+        });
+      }
+
+      const proxyRunning = await proxyClient.isProxyRunning();
+      if (!proxyRunning) {
+        actions.push({
+          change: "create",
+          kind: "proxy",
+          target: "iop-proxy",
+          server: serverHostname,
+          detail: "install and start iop-proxy",
+        });
+      }
+
+      const declaredVolumes = getDeclaredVolumeNames(context.config);
+      const volumesOnServer = new Set<string>();
+      normalizeConfigEntries(context.config.services)
+        .filter((service) => service.server === serverHostname)
+        .forEach((service) =>
+          getServiceNamedVolumes(service.volumes, declaredVolumes).forEach((name) =>
+            volumesOnServer.add(name)
+          )
+        );
+      for (const volumeName of volumesOnServer) {
+        const dockerVolumeName = getProjectVolumeName(context.projectName, volumeName);
+        if (!(await dockerClient.volumeExists(dockerVolumeName))) {
+          actions.push({
+            change: "create",
+            kind: "volume",
+            target: dockerVolumeName,
+            server: serverHostname,
+            detail: "create named volume",
+          });
+        }
+      }
+
+      // Orphaned services that reconciliation would remove
+      const currentState = await dockerClient.getProjectCurrentState(context.projectName);
+      const desiredServices = new Set(
+        normalizeConfigEntries(context.config.services)
+          .filter((service) => service.server === serverHostname)
+          .map((service) => service.name)
+      );
+      const orphans = new Set([
+        ...Object.keys(currentState.apps || {}),
+        ...Object.keys(currentState.services || {}),
+      ]);
+      for (const serviceName of orphans) {
+        if (!desiredServices.has(serviceName)) {
+          actions.push({
+            change: "delete",
+            kind: "service",
+            target: serviceName,
+            server: serverHostname,
+            detail: "no longer in iop.yml, containers will be removed",
+          });
+        }
+      }
+
+      const proxyHosts = (proxyRunning && (await proxyClient.getHosts())) || {};
+
+      for (const service of context.targetServices.filter((s) => s.server === serverHostname)) {
+        const desiredFingerprint = context.serviceFingerprints?.get(service.name);
+        if (!desiredFingerprint) {
+          throw new Error(`No fingerprint found for service ${service.name}`);
+        }
+
+        const currentFingerprint = await getCurrentServiceFingerprint(service, dockerClient, context);
+        const redeployDecision = shouldRedeploy(currentFingerprint, desiredFingerprint);
+
+        if (!redeployDecision.shouldRedeploy) {
+          actions.push({
+            change: "unchanged",
+            kind: "service",
+            target: service.name,
+            server: serverHostname,
+            detail: redeployDecision.reason,
+          });
+          continue;
+        }
+
+        if (!serviceNeedsBuilding(service)) {
+          actions.push({
+            change: "create",
+            kind: "pull",
+            target: service.name,
+            server: serverHostname,
+            detail: `pull ${service.image}`,
+          });
+        }
+
+        let containers: string[];
+        if (getDeploymentStrategy(service) === "zero-downtime") {
+          const activeColor = await dockerClient.getCurrentActiveColorForProject(
+            service.name,
+            context.projectName
+          );
+          containers = generateContainerNames(
+            context.projectName,
+            service.name,
+            activeColor === "blue" ? "green" : "blue",
+            service.replicas || 1
+          );
+        } else {
+          containers = [`${context.projectName}-${service.name}`];
+        }
+        actions.push({
+          change: currentFingerprint ? "update" : "create",
+          kind: "service",
+          target: service.name,
+          server: serverHostname,
+          detail: `${redeployDecision.reason}, start ${containers.join(", ")} (${getDeploymentStrategy(service)})`,
+        });
+
+        if (service.proxy) {
+          const hosts = shouldUseSslip(service.proxy.hosts)
+            ? [generateAppSslipDomain(context.projectName, service.name, serverHostname)]
+            : service.proxy.hosts!;
+          const target = `${context.projectName}-${service.name}:${getServiceProxyPort(service) || 80}`;
+          // configureProxyForService registers routes with SSL unless the app terminates TLS itself
+          const ssl = service.proxy.mode !== "passthrough";
+          actions.push(...planHostActions(hosts, target, ssl, serverHostname, proxyHosts));
+        }
+      }
+    } finally {
+      if (sshClient) {
+        await sshClient.close();
+      }
+    }
+  }
+
+  if (context.config.dns && context.dnsMode === "auto") {
+    for (const service of context.targetServices) {
+      for (const host of service.proxy?.hosts || []) {
+        actions.push({
+          change: "create",
+          kind: "dns",
+          target: host,
+          detail: `ensure record points to ${service.server}`,
+        });
+      }
+    }
+  }
+
+  return { project: context.projectName, releaseId: context.releaseId, actions };
+}
+
+export interface DeployCommandOptions {
+  // Rewrites the loaded configuration before deploying, e.g. for preview environments
+  transformConfig?: (config: IopConfig) => IopConfig;
+  // Redeploys the selected services even if they look up-to-date
+  forceRedeploy?: boolean;
+}
+
+/**
+ * Takes the project's deployment lock on every target server. If any server is
+ * locked by another deployment, the locks already taken are released again.
+ * @returns The servers that were locked
+ */
+async function acquireDeployLocks(
+  context: DeploymentContext,
+  servers: string[],
+  lock: DeployLock,
+  force: boolean
+): Promise<string[]> {
+  const locked: string[] = [];
+
+  try {
+    for (const server of servers) {
+      const sshClient = await establishSSHConnection(
+        server,
+        context.config,
+        context.secrets,
+        context.verboseFlag
+      );
+      try {
+        if (force) {
+          logger.warn(`Breaking any deployment lock for ${context.projectName} on ${server}`);
+        }
+        await acquireDeployLock(sshClient, server, context.projectName, lock, force);
+        locked.push(server);
+        logger.verboseLog(`Acquired deployment lock on ${server} as ${lock.owner}`);
+      } finally {
+        await sshClient.close();
+      }
+    }
+  } catch (error) {
+    if (error instanceof DeployLockedError) {
+      logger.error(error.message);
+    }
+    await releaseDeployLocks(context, locked, lock);
+    throw error;
+  }
+
+  return locked;
+}
+
+/**
+ * Asks the proxy on each server whether the deploy fits the project's quota
+ * there, before any container starts. Quotas are set by the server's admin,
+ * so the project's config can't raise them.
+ */
+async function checkDeployQuotas(context: DeploymentContext, servers: string[]): Promise<void> {
+  const violations: string[] = [];
+  for (const server of servers) {
+    const sshClient = await establishSSHConnection(
+      server,
+      context.config,
+      context.secrets,
+      context.verboseFlag
+    );
+    try {
+      const proxyClient = new IopProxyClient(
+        new DockerClient(sshClient, server, context.verboseFlag),
+        server,
+        context.verboseFlag
+      );
+      const workloads = buildQuotaWorkloads(
+        context.targetServices.filter((service) => service.server === server)
+      );
+      const result = await proxyClient.checkQuota(context.projectName, workloads);
+      if (!result.fits) {
+        violations.push(`${server}: ${result.error}`);
+      } else if (result.usage) {
+        logger.verboseLog(
+          `Project ${context.projectName} will run ${result.usage.containers} containers on ${server}`
+        );
+      }
+    } finally {
+      await sshClient.close();
+    }
+  }
+
+  if (violations.length > 0) {
+    for (const violation of violations) {
+      logger.error(violation);
+    }
+    throw new Error(
+      `Deploy exceeds the quota of ${context.projectName}. Lower replicas or resources, or ask the server's admin to raise it.`
+    );
+  }
+}
+
+/**
+ * Releases the deployment lock on each server. Failures are logged, a lock
+ * that can't be removed expires on its own.
+ */
+async function releaseDeployLocks(
+  context: DeploymentContext,
+  servers: string[],
+  lock: DeployLock
+): Promise<void> {
+  for (const server of servers) {
+    try {
+      const sshClient = await establishSSHConnection(
+        server,
+        context.config,
+        context.secrets,
+        context.verboseFlag
+      );
+      try {
+        await releaseDeployLock(sshClient, context.projectName, lock);
+      } finally {
+        await sshClient.close();
+      }
+    } catch (error) {
+      logger.warn(`Could not release deployment lock on ${server}: ${error}`);
+    }
+  }
+}
+
 /**
  * Main deployment command that orchestrates the entire deployment process
  */
-export async function deployCommand(rawEntryNamesAndFlags: string[]) {
+export async function deployCommand(
+  rawEntryNamesAndFlags: string[],
+  options: DeployCommandOptions = {}
+) {
+  let githubReporter: GitHubDeploymentReporter | undefined;
+
   try {
-    const { entryNames, verboseFlag } = parseDeploymentArgs(rawEntryNamesAndFlags);
+    const { entryNames, verboseFlag, buildRemoteFlag, planFlag, forceUnlockFlag, forceFlag, dnsMode, digest, annotations, parallel } = parseDeploymentArgs(rawEntryNamesAndFlags);
 
     // Set logger verbose mode
     logger = new Logger({ verbose: verboseFlag });
@@ -2471,35 +3870,67 @@ export async function deployCommand(rawEntryNamesAndFlags: string[]) {
     // Load configuration
     logger.phase("Loading configuration");
 
-    const { config, secrets } = await loadConfigurationAndSecrets();
+    const loaded = await loadConfigurationAndSecrets();
+    const config = options.transformConfig
+      ? options.transformConfig(loaded.config)
+      : loaded.config;
+    const secrets = loaded.secrets;
     logger.phaseComplete("Loading configuration");
 
-    const targetServices = identifyTargetServices(entryNames, config);
+    const environment = getConfigEnvironment();
+    if (environment) {
+      logger.info(`Deploying environment ${environment} as project ${config.name}`);
+    }
+
+    const metadata = await collectDeployMetadata(annotations);
+    const summary = formatDeployMetadata(metadata);
+    if (summary) {
+      logger.verboseLog(`Deployment metadata: ${summary}`);
+    }
+
+    if (!planFlag) {
+      githubReporter = await createGitHubReporter(config, secrets);
+      await githubReporter?.start(`Deploying ${releaseId} with iop`);
+    }
+
+    let targetServices = identifyTargetServices(entryNames, config);
+    if (digest) {
+      targetServices = pinTargetImageDigest(targetServices, digest);
+    }
     if (targetServices.length === 0) {
       logger.error("No services selected for deployment");
+      writeError("No services selected for deployment");
       return;
     }
 
     const projectName = config.name;
     const networkName = getProjectNetworkName(projectName);
 
-    // Ensure infrastructure is ready (auto-setup if needed)
-    const allTargetServers = new Set<string>();
-    targetServices.forEach((service) => {
-      allTargetServers.add(service.server);
-    });
-
-    await ensureInfrastructureReady(
-      config,
-      secrets,
-      Array.from(allTargetServers),
-      verboseFlag
-    );
-
     // Generate service fingerprints for smart redeployment
     const serviceFingerprints = new Map<string, ServiceFingerprint>();
     for (const service of targetServices) {
       const fingerprint = await createServiceFingerprint(service, secrets, config.name);
+      if (buildRemoteFlag && serviceNeedsBuilding(service)) {
+        // No local image exists to compare against, so fold the build context
+        // contents into the config hash to detect code changes
+        const contextDir = service.build?.context || ".";
+        const files = collectBuildContextFiles(
+          contextDir,
+          service.build?.dockerfile || "Dockerfile"
+        );
+        fingerprint.configHash = combineConfigAndContextHash(
+          fingerprint.configHash,
+          hashBuildContext(contextDir, files)
+        );
+      } else if (serviceNeedsBuilding(service)) {
+        // Hash the build context contents and base images, so unchanged apps
+        // are skipped without building their image
+        const contextDir = service.build?.context || ".";
+        const dockerfile = service.build?.dockerfile || "Dockerfile";
+        const files = collectBuildContextFiles(contextDir, dockerfile);
+        fingerprint.contextHash =
+          (await hashBuildInputs(contextDir, files, dockerfile)) ?? undefined;
+      }
       serviceFingerprints.set(service.name, fingerprint);
     }
 
@@ -2512,10 +3943,78 @@ export async function deployCommand(rawEntryNamesAndFlags: string[]) {
       networkName,
       verboseFlag,
       serviceFingerprints,
+      buildRemote: buildRemoteFlag,
+      dnsMode,
+      forceRedeploy: options.forceRedeploy || forceFlag,
+      metadata,
+      limiter: new ConcurrencyLimiter(getDeployParallelism(config, parallel)),
+      scanCounts: new Map<string, ScanCounts>(),
     };
 
-    const deploymentResults = await deployServices(context);
+    if (planFlag) {
+      logger.phase("Planning deployment");
+      const plan = await planDeployment(context);
+      logger.phaseComplete("Planning deployment");
+
+      if (isJsonOutput()) {
+        writeResult(plan);
+        return;
+      }
+      for (const line of formatPlan(plan)) {
+        console.log(line);
+      }
+      return;
+    }
+
+    // Ensure infrastructure is ready (auto-setup if needed)
+    const allTargetServers = new Set<string>();
+    targetServices.forEach((service) => {
+      allTargetServers.add(service.server);
+    });
+
+    await ensureInfrastructureReady(
+      config,
+      secrets,
+      Array.from(allTargetServers),
+      verboseFlag
+    );
+
+    // Only one deployment of a project may run at a time
+    const lock = createDeployLock(getDeployLockOwner(), releaseId);
+    const lockedServers = await acquireDeployLocks(
+      context,
+      Array.from(allTargetServers),
+      lock,
+      forceUnlockFlag
+    );
+
+    let deploymentResults: ServiceDeploymentResult[];
+    try {
+      await checkDeployQuotas(context, lockedServers);
+      deploymentResults = await deployServices(context);
+    } finally {
+      await releaseDeployLocks(context, lockedServers, lock);
+    }
+
+    writeResult({
+      success: true,
+      project: projectName,
+      releaseId,
+      metadata,
+      services: deploymentResults,
+    });
+
+    await githubReporter?.update(
+      "success",
+      `Deployed ${releaseId}`,
+      deploymentResults.find((result) => result.url)?.url
+    );
   } catch (error) {
+    await githubReporter?.update(
+      "failure",
+      error instanceof Error ? error.message : String(error)
+    );
+    writeError(error);
     logger.deploymentFailed(error);
     process.exit(1);
   } finally {
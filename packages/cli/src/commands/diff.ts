@@ -0,0 +1,505 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { interpolateEnvironment } from "../config/environment";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient, ProxyHostInfo, STATUS_PAGE_APP } from "../proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, logProgress, writeResult } from "../utils/output";
+import { serviceNeedsBuilding } from "../utils/image-utils";
+import { getDeploymentStrategy, getServiceProxyPort } from "../utils/service-utils";
+import { shouldUseSslip, generateAppSslipDomain } from "../utils/sslip";
+import { generateContainerNames } from "./blue-green";
+import { deployCommand } from "./deploy";
+
+// Module-level logger that gets configured when the diff command runs
+let logger: Logger;
+
+export type DriftKind =
+  | "missing_container"
+  | "stopped"
+  | "env_changed"
+  | "image_changed"
+  | "missing_host"
+  | "host_target"
+  | "orphaned_host"
+  | "orphaned_service";
+
+export interface DriftItem {
+  kind: DriftKind;
+  server: string;
+  service: string;
+  target: string; // Container or host the drift was found on
+  detail: string;
+}
+
+interface ParsedDiffArgs {
+  entryNames: string[];
+  reconcile: boolean;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for diff command
+ */
+export function parseDiffArgs(args: string[]): ParsedDiffArgs {
+  return {
+    entryNames: args.filter((arg) => !arg.startsWith("--")),
+    reconcile: args.includes("--reconcile"),
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  config: IopConfig,
+  secrets: IopSecrets,
+  verboseFlag: boolean
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    config,
+    secrets,
+    verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Returns the environment a service's containers should have. Secrets that
+ * are missing locally are left out, as deploy does.
+ */
+export function getDesiredEnvironment(
+  service: ServiceEntry,
+  secrets: IopSecrets
+): Record<string, string> {
+  const envVars: Record<string, string> = {};
+
+  for (const envVar of service.environment?.plain || []) {
+    const [key, ...valueParts] = envVar.split("=");
+    if (key && valueParts.length > 0) {
+      envVars[key] = valueParts.join("=");
+    }
+  }
+
+  for (const secretKey of service.environment?.secret || []) {
+    if (secrets[secretKey] !== undefined) {
+      envVars[secretKey] = secrets[secretKey];
+    }
+  }
+
+  return interpolateEnvironment(envVars, secrets, service.name, service.environment?.secret);
+}
+
+/**
+ * Lists the variables whose value in a container differs from the desired
+ * environment. Only names are returned so secret values never get printed.
+ * Variables the container has but iop didn't set (e.g. PATH from the image)
+ * are ignored.
+ */
+export function findEnvDrift(
+  desired: Record<string, string>,
+  containerEnv: string[]
+): { missing: string[]; changed: string[] } {
+  const actual = new Map<string, string>();
+  for (const entry of containerEnv) {
+    const separator = entry.indexOf("=");
+    if (separator > 0) {
+      actual.set(entry.substring(0, separator), entry.substring(separator + 1));
+    }
+  }
+
+  const missing: string[] = [];
+  const changed: string[] = [];
+  for (const [key, value] of Object.entries(desired)) {
+    if (!actual.has(key)) {
+      missing.push(key);
+    } else if (actual.get(key) !== value) {
+      changed.push(key);
+    }
+  }
+
+  return { missing, changed };
+}
+
+/**
+ * Compares an inspected, running container with the service's configuration
+ */
+export function findContainerDrift(
+  service: ServiceEntry,
+  containerName: string,
+  inspect: any,
+  desiredEnv: Record<string, string>,
+  server: string
+): DriftItem[] {
+  const drift: DriftItem[] = [];
+
+  const env = findEnvDrift(desiredEnv, inspect?.Config?.Env || []);
+  if (env.missing.length > 0 || env.changed.length > 0) {
+    const parts: string[] = [];
+    if (env.missing.length > 0) parts.push(`missing ${env.missing.join(", ")}`);
+    if (env.changed.length > 0) parts.push(`changed ${env.changed.join(", ")}`);
+    drift.push({
+      kind: "env_changed",
+      server,
+      service: service.name,
+      target: containerName,
+      detail: `environment ${parts.join("; ")}`,
+    });
+  }
+
+  // Built images are tagged per release, so only configured images can be compared
+  const image = inspect?.Config?.Image;
+  if (!serviceNeedsBuilding(service) && service.image && image && image !== service.image) {
+    drift.push({
+      kind: "image_changed",
+      server,
+      service: service.name,
+      target: containerName,
+      detail: `runs ${image}, expected ${service.image}`,
+    });
+  }
+
+  return drift;
+}
+
+/**
+ * Compares the hosts a server's proxy routes for the project with the hosts
+ * its services should have
+ */
+export function findHostDrift(
+  expected: Map<string, { service: string; target: string }>,
+  proxyHosts: Record<string, ProxyHostInfo>,
+  projectName: string,
+  server: string
+): DriftItem[] {
+  const drift: DriftItem[] = [];
+
+  for (const [host, { service, target }] of expected) {
+    const registered = proxyHosts[host];
+    if (!registered) {
+      drift.push({
+        kind: "missing_host",
+        server,
+        service,
+        target: host,
+        detail: `not registered with the proxy, expected route to ${target}`,
+      });
+    } else if (registered.target !== target) {
+      drift.push({
+        kind: "host_target",
+        server,
+        service,
+        target: host,
+        detail: `routes to ${registered.target}, expected ${target}`,
+      });
+    }
+  }
+
+  for (const [host, info] of Object.entries(proxyHosts)) {
+    // Status page hosts are deployed by the proxy, not from services
+    if (info.project === projectName && !expected.has(host) && info.app !== STATUS_PAGE_APP) {
+      drift.push({
+        kind: "orphaned_host",
+        server,
+        service: info.app,
+        target: host,
+        detail: `still routes to ${info.target} but is not in iop.yml`,
+      });
+    }
+  }
+
+  return drift;
+}
+
+/**
+ * Renders drift as one line per item, grouped by server
+ */
+export function formatDrift(drift: DriftItem[]): string[] {
+  if (drift.length === 0) {
+    return ["No drift. Servers match iop.yml."];
+  }
+
+  const lines: string[] = [];
+  const servers = [...new Set(drift.map((item) => item.server))];
+  for (const server of servers) {
+    lines.push(server);
+    for (const item of drift.filter((d) => d.server === server)) {
+      lines.push(`  ${item.service.padEnd(12)} ${item.target}: ${item.detail}`);
+    }
+  }
+  lines.push("", `${drift.length} difference(s) found.`);
+
+  return lines;
+}
+
+/**
+ * Returns the host names and proxy target a service should be routed with
+ */
+function getExpectedHosts(
+  service: ServiceEntry,
+  projectName: string,
+  server: string
+): string[] {
+  if (!service.proxy) return [];
+  return shouldUseSslip(service.proxy.hosts)
+    ? [generateAppSslipDomain(projectName, service.name, server)]
+    : service.proxy.hosts!;
+}
+
+/**
+ * Collects the drift between the configuration and a single server
+ */
+async function detectServerDrift(
+  config: IopConfig,
+  secrets: IopSecrets,
+  services: ServiceEntry[],
+  dockerClient: DockerClient,
+  proxyClient: IopProxyClient,
+  server: string
+): Promise<DriftItem[]> {
+  const projectName = config.name;
+  const drift: DriftItem[] = [];
+
+  for (const service of services) {
+    let containerNames: string[];
+    if (getDeploymentStrategy(service) === "zero-downtime") {
+      const activeColor = await dockerClient.getCurrentActiveColorForProject(
+        service.name,
+        projectName
+      );
+      containerNames = generateContainerNames(
+        projectName,
+        service.name,
+        activeColor || "blue",
+        service.replicas || 1
+      );
+    } else {
+      containerNames = [`${projectName}-${service.name}`];
+    }
+
+    const desiredEnv = getDesiredEnvironment(service, secrets);
+    for (const containerName of containerNames) {
+      if (!(await dockerClient.containerExists(containerName))) {
+        drift.push({
+          kind: "missing_container",
+          server,
+          service: service.name,
+          target: containerName,
+          detail: "container does not exist",
+        });
+        continue;
+      }
+
+      if (!(await dockerClient.containerIsRunning(containerName))) {
+        drift.push({
+          kind: "stopped",
+          server,
+          service: service.name,
+          target: containerName,
+          detail: "container is stopped",
+        });
+        continue;
+      }
+
+      const inspect = await dockerClient.inspectContainer(containerName);
+      drift.push(...findContainerDrift(service, containerName, inspect, desiredEnv, server));
+    }
+  }
+
+  // Services still running on the server that were removed from the configuration
+  const desiredServices = new Set(
+    normalizeConfigEntries(config.services)
+      .filter((service: ServiceEntry) => service.server === server)
+      .map((service: ServiceEntry) => service.name)
+  );
+  const currentState = await dockerClient.getProjectCurrentState(projectName);
+  const running = new Set([
+    ...Object.keys(currentState.apps || {}),
+    ...Object.keys(currentState.services || {}),
+  ]);
+  for (const serviceName of running) {
+    if (!desiredServices.has(serviceName)) {
+      drift.push({
+        kind: "orphaned_service",
+        server,
+        service: serviceName,
+        target: serviceName,
+        detail: "running but not in iop.yml",
+      });
+    }
+  }
+
+  const proxyHosts = await proxyClient.getHosts();
+  if (proxyHosts) {
+    const expected = new Map<string, { service: string; target: string }>();
+    for (const service of normalizeConfigEntries(config.services) as ServiceEntry[]) {
+      if (service.server !== server) continue;
+      const target = `${projectName}-${service.name}:${getServiceProxyPort(service) || 80}`;
+      for (const host of getExpectedHosts(service, projectName, server)) {
+        expected.set(host, { service: service.name, target });
+      }
+    }
+
+    const serviceNames = new Set(services.map((service) => service.name));
+    drift.push(
+      ...findHostDrift(expected, proxyHosts, projectName, server).filter(
+        (item) => item.kind === "orphaned_host" || serviceNames.has(item.service)
+      )
+    );
+  } else if (services.some((service) => service.proxy)) {
+    logger.warn(`No proxy running on ${server}, skipping host checks`);
+  }
+
+  return drift;
+}
+
+/**
+ * Fixes drift on a server that can be repaired in place: starts stopped
+ * containers and re-registers or removes proxy hosts
+ */
+async function reconcileServerInPlace(
+  config: IopConfig,
+  drift: DriftItem[],
+  dockerClient: DockerClient,
+  proxyClient: IopProxyClient
+): Promise<void> {
+  const services = normalizeConfigEntries(config.services) as ServiceEntry[];
+
+  for (const item of drift) {
+    if (item.kind === "stopped") {
+      if (await dockerClient.startContainer(item.target)) {
+        logger.verboseLog(`Started ${item.target} on ${item.server}`);
+      } else {
+        logger.warn(`Could not start ${item.target} on ${item.server}`);
+      }
+    } else if (item.kind === "missing_host" || item.kind === "host_target") {
+      const service = services.find((s) => s.name === item.service)!;
+      const configured = await proxyClient.configureProxy(
+        item.target,
+        `${config.name}-${service.name}`,
+        getServiceProxyPort(service) || 80,
+        config.name,
+        service.health_check?.path || "/up",
+        service.proxy?.mode,
+        service.proxy
+      );
+      if (!configured) {
+        logger.warn(`Could not configure ${item.target} on ${item.server}`);
+      }
+    } else if (item.kind === "orphaned_host") {
+      if (!(await proxyClient.removeProxyConfig(item.target))) {
+        logger.warn(`Could not remove ${item.target} from the proxy on ${item.server}`);
+      }
+    }
+  }
+}
+
+/**
+ * Main diff command. Compares iop.yml with what is actually running on the
+ * servers, and with --reconcile puts the servers back in line with the config.
+ */
+export async function diffCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseDiffArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets(true);
+
+    const allServices = normalizeConfigEntries(config.services) as ServiceEntry[];
+    const targetServices = parsedArgs.entryNames.length > 0
+      ? allServices.filter((service) => parsedArgs.entryNames.includes(service.name))
+      : allServices;
+    for (const name of parsedArgs.entryNames) {
+      if (!allServices.some((service) => service.name === name)) {
+        logger.warn(`Service "${name}" not found in configuration`);
+      }
+    }
+
+    const servers = new Set(targetServices.map((service) => service.server));
+    const drift: DriftItem[] = [];
+    const redeploy = new Set<string>();
+
+    for (const server of servers) {
+      let sshClient: SSHClient | undefined;
+      try {
+        sshClient = await establishSSHConnection(
+          server,
+          config,
+          secrets,
+          parsedArgs.verboseFlag
+        );
+        const dockerClient = new DockerClient(sshClient, server, parsedArgs.verboseFlag);
+        const proxyClient = new IopProxyClient(dockerClient, server, parsedArgs.verboseFlag);
+
+        const serverDrift = await detectServerDrift(
+          config,
+          secrets,
+          targetServices.filter((service) => service.server === server),
+          dockerClient,
+          proxyClient,
+          server
+        );
+        drift.push(...serverDrift);
+
+        if (parsedArgs.reconcile) {
+          await reconcileServerInPlace(config, serverDrift, dockerClient, proxyClient);
+          serverDrift
+            .filter((item) =>
+              ["missing_container", "env_changed", "image_changed"].includes(item.kind)
+            )
+            .forEach((item) => redeploy.add(item.service));
+        }
+      } finally {
+        if (sshClient) {
+          await sshClient.close();
+        }
+      }
+    }
+
+    if (!isJsonOutput()) {
+      for (const line of formatDrift(drift)) {
+        console.log(line);
+      }
+    }
+
+    if (parsedArgs.reconcile && drift.length > 0) {
+      // Replacing containers and removing orphaned services is what deploy
+      // already does, so hand those over to it. Deploy reports its own result.
+      const deployFlags = parsedArgs.verboseFlag ? ["--verbose"] : [];
+      if (redeploy.size > 0) {
+        logProgress("");
+        await deployCommand([...redeploy, ...deployFlags], { forceRedeploy: true });
+      } else if (drift.some((item) => item.kind === "orphaned_service")) {
+        logProgress("");
+        await deployCommand(deployFlags);
+      } else {
+        writeResult({ drift, reconciled: true });
+      }
+      return;
+    }
+
+    writeResult({ drift, reconciled: false });
+    if (drift.length > 0) {
+      process.exitCode = 1;
+    }
+  } finally {
+    logger.cleanup();
+  }
+}
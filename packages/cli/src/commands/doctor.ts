@@ -0,0 +1,306 @@
+import { createInterface } from "readline";
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient } from "../proxy";
+import { findOrphans, toInventoryEntry } from "./ps";
+import { toHostProbes } from "./deploy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, logProgress, writeResult } from "../utils/output";
+import { getServiceProxyPort } from "../utils/service-utils";
+import {
+  ProjectNetworkInfo,
+  getProjectNetworkInfo,
+  removeProjectNetwork,
+} from "../utils/project-network";
+import {
+  DoctorFinding,
+  describeFix,
+  findCertificateIssues,
+  findContainerIssues,
+  findHostIssues,
+  findNetworkIssues,
+} from "../utils/reconcile";
+
+// Module-level logger that gets configured when the doctor command runs
+let logger: Logger;
+
+interface DoctorContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedDoctorArgs {
+  fix: boolean;
+  yes: boolean;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+interface FixResult {
+  finding: DoctorFinding;
+  fixed: boolean;
+  skipped?: boolean;
+}
+
+/**
+ * Parses command line arguments for doctor command
+ */
+export function parseDoctorArgs(args: string[]): ParsedDoctorArgs {
+  let server: string | undefined;
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++;
+    }
+  }
+
+  return {
+    fix: args.includes("--fix"),
+    yes: args.includes("--yes") || args.includes("-y"),
+    server,
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+function prompt(question: string): Promise<string> {
+  const rl = createInterface({
+    input: process.stdin,
+    output: process.stderr,
+  });
+  return new Promise((resolve) => {
+    rl.question(question, (answer) => {
+      rl.close();
+      resolve(answer.trim());
+    });
+  });
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: DoctorContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Collects the findings of one server: orphaned containers, stale and
+ * unrouted proxy hosts, unattached networks and unreferenced certificates
+ */
+async function examineServer(
+  serverHostname: string,
+  services: ServiceEntry[],
+  dockerClient: DockerClient,
+  proxyClient: IopProxyClient,
+  context: DoctorContext
+): Promise<DoctorFinding[]> {
+  const projectName = context.config.name;
+  const containers = findOrphans(
+    (await dockerClient.inspectManagedContainers()).map((container) => toInventoryEntry(container)),
+    projectName,
+    services
+  );
+  const findings = findContainerIssues(serverHostname, containers);
+
+  if (await proxyClient.isProxyRunning()) {
+    const hosts = await proxyClient.getHosts();
+    if (hosts) {
+      findings.push(...findHostIssues(serverHostname, projectName, services, hosts, containers));
+    } else {
+      logger.warn(`Could not read the hosts of the proxy on ${serverHostname}`);
+    }
+
+    const certificates = await proxyClient.pruneCertificates(true);
+    if (certificates) {
+      findings.push(...findCertificateIssues(serverHostname, certificates));
+    } else {
+      logger.warn(`Could not read the certificates of the proxy on ${serverHostname}`);
+    }
+  } else {
+    logger.warn(`The proxy isn't running on ${serverHostname}, skipping hosts and certificates`);
+  }
+
+  const networks: ProjectNetworkInfo[] = [];
+  for (const name of await dockerClient.listNetworks("iop.managed=true")) {
+    const network = await getProjectNetworkInfo(dockerClient, name);
+    if (network) {
+      networks.push(network);
+    }
+  }
+  findings.push(...findNetworkIssues(serverHostname, networks));
+
+  return findings;
+}
+
+/**
+ * Fixes one finding, removing the resource or adopting it
+ */
+async function fixFinding(
+  finding: DoctorFinding,
+  services: ServiceEntry[],
+  dockerClient: DockerClient,
+  proxyClient: IopProxyClient,
+  context: DoctorContext
+): Promise<boolean> {
+  switch (finding.kind) {
+    case "orphaned-container":
+    case "leftover-container":
+      await dockerClient.removeSidecars(finding.resource);
+      await dockerClient.stopContainer(finding.resource);
+      return dockerClient.removeContainer(finding.resource);
+    case "stale-host":
+      return proxyClient.removeProxyConfig(finding.resource);
+    case "unrouted-host": {
+      // Routed the way a deploy routes it, with its TLS policy, limits, probes and scale to zero
+      const app = services.find((service) => service.name === finding.app)!;
+      const proxy = app.proxy!;
+      return (
+        (await proxyClient.configureProxy(
+          finding.resource,
+          `${context.config.name}-${app.name}`,
+          getServiceProxyPort(app) || 80,
+          context.config.name,
+          app.health_check?.path || "/up",
+          proxy.mode,
+          proxy
+        )) &&
+        (await proxyClient.setHostTlsPolicy(finding.resource, proxy.tls || null)) &&
+        (await proxyClient.setHostLimits(finding.resource, proxy.limits || null)) &&
+        (await proxyClient.setHostProbes(finding.resource, toHostProbes(app))) &&
+        (await proxyClient.setScaleToZero(
+          finding.resource,
+          !!proxy.scale_to_zero,
+          proxy.idle_timeout,
+          proxy.cold_start
+        ))
+      );
+    }
+    case "unattached-network":
+      return removeProjectNetwork(dockerClient, finding.project!);
+    case "unreferenced-cert":
+      return (await proxyClient.pruneCertificates(false)) !== null;
+  }
+}
+
+/**
+ * Finds resources on the servers that the project's state doesn't account
+ * for and, with --fix, offers to clean them up or adopt them
+ */
+export async function doctorCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseDoctorArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    if (parsedArgs.fix && !parsedArgs.yes && (!process.stdin.isTTY || isJsonOutput())) {
+      throw new Error("Pass --yes to fix without confirming each change");
+    }
+
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+    const context: DoctorContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const services: ServiceEntry[] = normalizeConfigEntries(config.services);
+    const servers = parsedArgs.server
+      ? [parsedArgs.server]
+      : Array.from(new Set(services.map((service) => service.server)));
+
+    const findings: DoctorFinding[] = [];
+    const results: FixResult[] = [];
+    for (const serverHostname of servers) {
+      const sshClient = await establishSSHConnection(serverHostname, context);
+      try {
+        const dockerClient = new DockerClient(sshClient, serverHostname, context.verboseFlag);
+        const proxyClient = new IopProxyClient(dockerClient, serverHostname, context.verboseFlag);
+        const serverServices = services.filter((service) => service.server === serverHostname);
+
+        const serverFindings = await examineServer(
+          serverHostname,
+          serverServices,
+          dockerClient,
+          proxyClient,
+          context
+        );
+        findings.push(...serverFindings);
+
+        if (!isJsonOutput()) {
+          console.log(`\n=== ${serverHostname} ===`);
+          if (serverFindings.length === 0) {
+            console.log("No issues found");
+          }
+          for (const finding of serverFindings) {
+            console.log(`  ${finding.kind.padEnd(18)}  ${finding.resource}: ${finding.detail}`);
+          }
+        }
+
+        if (serverFindings.length === 0 || !parsedArgs.fix) {
+          continue;
+        }
+        for (const finding of serverFindings) {
+          const description = describeFix(finding);
+          if (!parsedArgs.yes) {
+            const answer = await prompt(`${description}? [y/N] `);
+            if (answer.toLowerCase() !== "y" && answer.toLowerCase() !== "yes") {
+              results.push({ finding, fixed: false, skipped: true });
+              continue;
+            }
+          }
+          const fixed = await fixFinding(finding, serverServices, dockerClient, proxyClient, context);
+          results.push({ finding, fixed });
+          if (fixed) {
+            logProgress(`  [✓] ${description}`);
+          } else {
+            logger.warn(`Could not ${description.charAt(0).toLowerCase()}${description.slice(1)}`);
+          }
+        }
+      } finally {
+        await sshClient.close();
+      }
+    }
+
+    const failed = results.filter((result) => !result.fixed && !result.skipped).length;
+    if (isJsonOutput()) {
+      writeResult({ findings, ...(parsedArgs.fix && { fixes: results }) });
+    } else if (findings.length > 0 && !parsedArgs.fix) {
+      console.log(
+        `\n${findings.length} issue${findings.length === 1 ? "" : "s"} found. Run 'iop doctor --fix' to clean them up.`
+      );
+    } else if (parsedArgs.fix && results.length > 0) {
+      const fixed = results.filter((result) => result.fixed).length;
+      console.log(`\nFixed ${fixed} of ${findings.length} issue${findings.length === 1 ? "" : "s"}`);
+    }
+
+    if (failed > 0 || (!parsedArgs.fix && findings.length > 0)) {
+      process.exitCode = 1;
+    }
+  } finally {
+    logger.cleanup();
+  }
+}
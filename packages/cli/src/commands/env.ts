@@ -0,0 +1,329 @@
+import fs from "node:fs/promises";
+import path from "path";
+import { loadConfig, loadSecrets, updateSecrets, normalizeConfigEntries } from "../config";
+import { IopSecrets, ServiceEntry } from "../config/types";
+import { findReferences } from "../config/environment";
+import {
+  DEFAULT_IDENTITY_FILE,
+  encryptValue,
+  generateIdentity,
+  wasEncrypted,
+} from "../config/encryption";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+import { getDesiredEnvironment } from "./diff";
+
+// Module-level logger that gets configured when the env command runs
+let logger: Logger;
+
+const MASK = "********";
+const KEY_PATTERN = /^[A-Za-z_][A-Za-z0-9_]*$/;
+
+interface ParsedEnvArgs {
+  subcommand: string;
+  values: string[];
+  reveal: boolean;
+  verboseFlag: boolean;
+}
+
+export interface EnvVariable {
+  name: string;
+  value: string | null; // null when the value is a secret and not revealed, or missing
+  secret: boolean;
+  set: boolean;
+}
+
+/**
+ * Parses command line arguments for env command
+ */
+export function parseEnvArgs(args: string[]): ParsedEnvArgs {
+  const cleanArgs = args.filter((arg) => !arg.startsWith("--"));
+  return {
+    subcommand: cleanArgs[0] || "",
+    values: cleanArgs.slice(1),
+    reveal: args.includes("--reveal"),
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Parses KEY=VALUE arguments to env set
+ */
+export function parseAssignments(values: string[]): Record<string, string> {
+  const assignments: Record<string, string> = {};
+  for (const value of values) {
+    const [key, ...valueParts] = value.split("=");
+    if (!KEY_PATTERN.test(key) || valueParts.length === 0) {
+      throw new Error(`Invalid assignment "${value}", expected KEY=VALUE`);
+    }
+    assignments[key] = valueParts.join("=");
+  }
+  return assignments;
+}
+
+/**
+ * Lists the variables a service's containers get, in the order they're
+ * defined. Secrets, and plain values built from secrets, are masked unless
+ * revealed.
+ */
+export function listServiceEnvironment(
+  service: ServiceEntry,
+  secrets: IopSecrets,
+  reveal: boolean = false
+): EnvVariable[] {
+  const values = getDesiredEnvironment(service, secrets);
+  const plain = new Map(
+    (service.environment?.plain || []).map((envVar) => [
+      envVar.split("=")[0],
+      envVar,
+    ])
+  );
+  const secretKeys = service.environment?.secret || [];
+
+  const isSecret = (name: string, seen: Set<string> = new Set()): boolean => {
+    if (secretKeys.includes(name)) return true;
+    if (seen.has(name) || !plain.has(name)) return false;
+    if (wasEncrypted(plain.get(name)!)) return true;
+    seen.add(name);
+    const references = findReferences(plain.get(name)!);
+    return (
+      references.secrets.length > 0 ||
+      references.variables.some((variable) => isSecret(variable, seen))
+    );
+  };
+
+  return [...plain.keys(), ...secretKeys].map((name) => {
+    const secret = isSecret(name);
+    const set = values[name] !== undefined;
+    return {
+      name,
+      value: set && (reveal || !secret) ? values[name] : null,
+      secret,
+      set,
+    };
+  });
+}
+
+/**
+ * Shows the resolved environment of each service, or the given ones
+ */
+async function envListSubcommand(parsedArgs: ParsedEnvArgs): Promise<void> {
+  const config = await loadConfig();
+  const secrets = await loadSecrets(true);
+
+  const services: ServiceEntry[] = normalizeConfigEntries(config.services).filter(
+    (service: ServiceEntry) =>
+      parsedArgs.values.length === 0 || parsedArgs.values.includes(service.name)
+  );
+
+  const unknown = parsedArgs.values.filter(
+    (name) => !services.some((service) => service.name === name)
+  );
+  if (unknown.length > 0) {
+    throw new Error(`Unknown service(s): ${unknown.join(", ")}`);
+  }
+
+  const result = services.map((service) => ({
+    name: service.name,
+    server: service.server,
+    variables: listServiceEnvironment(service, secrets, parsedArgs.reveal),
+  }));
+  if (isJsonOutput()) {
+    writeResult({ services: result });
+    return;
+  }
+
+  for (const service of result) {
+    console.log(`\n=== ${service.name} (${service.server}) ===`);
+    if (service.variables.length === 0) {
+      console.log("No environment variables");
+    }
+    for (const variable of service.variables) {
+      const value = !variable.set ? "(not set)" : variable.value ?? MASK;
+      console.log(`  ${variable.name}=${value}${variable.secret ? " (secret)" : ""}`);
+    }
+  }
+}
+
+/**
+ * Sets secrets in .iop/secrets, or the environment's secrets file
+ */
+async function envSetSubcommand(parsedArgs: ParsedEnvArgs): Promise<void> {
+  const assignments = parseAssignments(parsedArgs.values);
+  const keys = Object.keys(assignments);
+  if (keys.length === 0) {
+    throw new Error("Usage: iop env set KEY=VALUE [KEY=VALUE...]");
+  }
+
+  const secretsPath = await updateSecrets(assignments);
+  if (isJsonOutput()) {
+    writeResult({ success: true, set: keys, file: secretsPath });
+    return;
+  }
+  console.log(`[✓] Set ${keys.join(", ")} in ${secretsPath}`);
+  console.log("Deploy to apply the change to running containers");
+}
+
+/**
+ * Removes secrets from .iop/secrets, or the environment's secrets file
+ */
+async function envUnsetSubcommand(parsedArgs: ParsedEnvArgs): Promise<void> {
+  const keys = parsedArgs.values;
+  if (keys.length === 0) {
+    throw new Error("Usage: iop env unset KEY [KEY...]");
+  }
+
+  const secretsPath = await updateSecrets({}, keys);
+  if (isJsonOutput()) {
+    writeResult({ success: true, unset: keys, file: secretsPath });
+  } else {
+    console.log(`[✓] Removed ${keys.join(", ")} from ${secretsPath}`);
+  }
+
+  // Deploys would fail on references to secrets that are gone
+  try {
+    const config = await loadConfig();
+    for (const service of normalizeConfigEntries(config.services) as ServiceEntry[]) {
+      const used = keys.filter(
+        (key) =>
+          service.environment?.secret?.includes(key) ||
+          service.environment?.plain?.some((envVar) =>
+            findReferences(envVar).secrets.includes(key)
+          )
+      );
+      if (used.length > 0) {
+        logger.warn(`${service.name} still uses ${used.join(", ")}`);
+      }
+    }
+  } catch (error) {
+    logger.verboseLog(`Could not check which services use the removed secrets: ${error}`);
+  }
+}
+
+/**
+ * Encrypts values to the recipients in iop.yml, to paste into it. KEY=VALUE
+ * only encrypts the value, so the variable's name stays readable.
+ */
+async function envEncryptSubcommand(parsedArgs: ParsedEnvArgs): Promise<void> {
+  if (parsedArgs.values.length === 0) {
+    throw new Error("Usage: iop env encrypt VALUE|KEY=VALUE [...]");
+  }
+
+  const config = await loadConfig();
+  const recipients = config.encryption?.recipients;
+  if (!recipients) {
+    throw new Error(
+      "No recipients to encrypt to. Run iop env keygen and add the recipient to encryption.recipients in iop.yml."
+    );
+  }
+
+  const encrypted = parsedArgs.values.map((value) => {
+    const [key, ...valueParts] = value.split("=");
+    return KEY_PATTERN.test(key) && valueParts.length > 0
+      ? `${key}=${encryptValue(valueParts.join("="), recipients)}`
+      : encryptValue(value, recipients);
+  });
+  if (isJsonOutput()) {
+    writeResult({ values: encrypted });
+    return;
+  }
+  encrypted.forEach((value) => console.log(value));
+}
+
+/**
+ * Creates the key decrypting iop.yml's values, unless there already is one
+ */
+async function envKeygenSubcommand(): Promise<void> {
+  const keyFile = process.env.IOP_AGE_KEY_FILE || DEFAULT_IDENTITY_FILE;
+  const { identity, recipient } = generateIdentity();
+  await fs.mkdir(path.dirname(keyFile), { recursive: true });
+  try {
+    // Same format as age-keygen, so the age CLI reads it too
+    await fs.writeFile(
+      keyFile,
+      `# created: ${new Date().toISOString()}\n# public key: ${recipient}\n${identity}\n`,
+      { encoding: "utf-8", mode: 0o600, flag: "wx" }
+    );
+  } catch (error) {
+    if ((error as NodeJS.ErrnoException).code === "EEXIST") {
+      throw new Error(`${keyFile} already exists, values encrypted to it would be lost`);
+    }
+    throw error;
+  }
+
+  if (isJsonOutput()) {
+    writeResult({ success: true, file: keyFile, recipient });
+    return;
+  }
+  console.log(`[✓] Wrote key to ${keyFile}`);
+  console.log(`Public key: ${recipient}`);
+  console.log("Add it to encryption.recipients in iop.yml, and keep the key file out of git");
+}
+
+/**
+ * Shows help for env command
+ */
+function showEnvHelp(): void {
+  console.log("IOP Environment Variables");
+  console.log("=========================");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop env <subcommand> [args] [flags]");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  list [service...]             Show the variables each service gets on deploy");
+  console.log("  set KEY=VALUE [KEY=VALUE...]  Set secrets in .iop/secrets");
+  console.log("  unset KEY [KEY...]            Remove secrets from .iop/secrets");
+  console.log("  encrypt VALUE|KEY=VALUE       Encrypt values to paste into iop.yml");
+  console.log("  keygen                        Create a key decrypting iop.yml's values");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --reveal    Show secret values in list");
+  console.log("  --json      Print results as JSON");
+  console.log("  --verbose   Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop env list web");
+  console.log("  iop env set DATABASE_URL=postgres://app@postgres/app");
+  console.log("  iop env unset OLD_API_KEY");
+  console.log("  iop env encrypt STRIPE_KEY=sk_live_...");
+}
+
+/**
+ * Main env command that handles subcommands
+ */
+export async function envCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseEnvArgs(args);
+
+  if (!["list", "set", "unset", "encrypt", "keygen"].includes(parsedArgs.subcommand)) {
+    showEnvHelp();
+    return;
+  }
+
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    switch (parsedArgs.subcommand) {
+      case "list":
+        await envListSubcommand(parsedArgs);
+        break;
+      case "set":
+        await envSetSubcommand(parsedArgs);
+        break;
+      case "unset":
+        await envUnsetSubcommand(parsedArgs);
+        break;
+      case "encrypt":
+        await envEncryptSubcommand(parsedArgs);
+        break;
+      case "keygen":
+        await envKeygenSubcommand();
+        break;
+    }
+  } catch (error) {
+    logger.error("Env command failed", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
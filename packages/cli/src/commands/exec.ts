@@ -0,0 +1,270 @@
+import { spawn } from "child_process";
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { DockerClient } from "../docker";
+import {
+  SSHClient,
+  SSHClientOptions,
+  buildSSHCommandOptions,
+  getSSHCredentials,
+} from "../ssh";
+import { requiresZeroDowntimeDeployment } from "../utils/service-utils";
+import { shellQuote } from "../utils/shell";
+import { Logger } from "../utils/logger";
+
+// Module-level logger that gets configured when execCommand runs
+let logger: Logger;
+
+interface ParsedExecArgs {
+  app?: string;
+  server?: string;
+  command: string[];
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for the exec command.
+ * Everything after "--" is passed through as the command to run in the container.
+ */
+export function parseExecArgs(args: string[]): ParsedExecArgs {
+  const parsed: ParsedExecArgs = {
+    command: [],
+    verboseFlag: false,
+  };
+
+  for (let i = 0; i < args.length; i++) {
+    const arg = args[i];
+    if (arg === "--") {
+      parsed.command = args.slice(i + 1);
+      break;
+    } else if (arg === "--verbose") {
+      parsed.verboseFlag = true;
+    } else if (arg === "--app" && i + 1 < args.length) {
+      parsed.app = args[i + 1];
+      i++; // Skip the next argument since it's the app value
+    } else if (arg === "--server" && i + 1 < args.length) {
+      parsed.server = args[i + 1];
+      i++; // Skip the next argument since it's the server value
+    } else if (!arg.startsWith("--") && !parsed.app) {
+      // Allow "iop exec web" as shorthand for "iop exec --app web"
+      parsed.app = arg;
+    }
+  }
+
+  return parsed;
+}
+
+/**
+ * Builds the argument list for the native ssh binary.
+ * The native client is used (instead of ssh2) so the remote TTY is wired
+ * straight to the local terminal, including resize and signal handling.
+ */
+export function buildSSHExecArgs(
+  sshOptions: Partial<SSHClientOptions>,
+  serverHostname: string,
+  containerName: string,
+  command: string[]
+): string[] {
+  const args = ["-t"];
+
+  if (sshOptions.port && sshOptions.port !== 22) {
+    args.push("-p", String(sshOptions.port));
+  }
+  args.push(...buildSSHCommandOptions(sshOptions));
+
+  args.push(`${sshOptions.username}@${serverHostname}`);
+  // ssh joins its arguments into one string for the remote shell, so each
+  // command argument is quoted to arrive in the container as it was typed
+  args.push("docker", "exec", "-it", containerName, ...command.map(shellQuote));
+
+  return args;
+}
+
+/**
+ * Finds the running container that currently serves traffic for a service.
+ * Zero-downtime services resolve to the active blue/green container, other
+ * services resolve to their single project-prefixed container.
+ */
+async function findActiveContainer(
+  dockerClient: DockerClient,
+  service: ServiceEntry,
+  projectName: string
+): Promise<string | null> {
+  if (!requiresZeroDowntimeDeployment(service)) {
+    const containerName = `${projectName}-${service.name}`;
+    return (await dockerClient.containerIsRunning(containerName))
+      ? containerName
+      : null;
+  }
+
+  const activeColor = await dockerClient.getCurrentActiveColorForProject(
+    service.name,
+    projectName
+  );
+  if (!activeColor) {
+    return null;
+  }
+
+  const containers = await dockerClient.findContainersByLabelAndProject(
+    `iop.app=${service.name}`,
+    projectName
+  );
+
+  for (const containerName of containers) {
+    const labels = await dockerClient.getContainerLabels(containerName);
+    if (
+      labels["iop.color"] === activeColor &&
+      (await dockerClient.containerIsRunning(containerName))
+    ) {
+      return containerName;
+    }
+  }
+
+  return null;
+}
+
+/**
+ * Opens an interactive session in the active container of a service
+ */
+async function openInteractiveSession(
+  sshOptions: Partial<SSHClientOptions>,
+  serverHostname: string,
+  containerName: string,
+  command: string[]
+): Promise<number> {
+  const args = buildSSHExecArgs(
+    sshOptions,
+    serverHostname,
+    containerName,
+    command
+  );
+  logger.verboseLog(`Running: ssh ${args.join(" ")}`);
+
+  return new Promise<number>((resolve, reject) => {
+    const sshProcess = spawn("ssh", args, { stdio: "inherit" });
+
+    sshProcess.on("close", (code) => {
+      resolve(code ?? 0);
+    });
+
+    sshProcess.on("error", (err) => {
+      reject(new Error(`Failed to start ssh: ${err.message}`));
+    });
+  });
+}
+
+/**
+ * Shows help for exec command
+ */
+function showExecHelp(): void {
+  console.log("Open a shell in a running container");
+  console.log("===================================");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop exec --app <name> [--server <host>] [-- <command...>]");
+  console.log("");
+  console.log("DESCRIPTION:");
+  console.log(
+    "  Opens an interactive TTY in the active (blue or green) container of an app."
+  );
+  console.log("  Defaults to 'sh' when no command is given.");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --app <name>      Service to connect to");
+  console.log("  --server <host>   Server to connect to (defaults to the service's server)");
+  console.log("  --verbose         Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop exec --app web                    # Open a shell in web");
+  console.log("  iop exec --app web -- rails console   # Run a command interactively");
+}
+
+/**
+ * Main exec command
+ */
+export async function execCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseExecArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  if (!parsedArgs.app) {
+    showExecHelp();
+    return;
+  }
+
+  let config: IopConfig;
+  let secrets: IopSecrets;
+  try {
+    config = await loadConfig();
+    secrets = await loadSecrets(true);
+  } catch (error) {
+    logger.error("Failed to load configuration/secrets", error);
+    throw error;
+  }
+
+  const services: ServiceEntry[] = normalizeConfigEntries(config.services);
+  const service = services.find((s) => s.name === parsedArgs.app);
+  if (!service) {
+    throw new Error(`Service "${parsedArgs.app}" not found in configuration`);
+  }
+
+  const serverHostname = parsedArgs.server || service.server;
+  const command = parsedArgs.command.length > 0 ? parsedArgs.command : ["sh"];
+
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    config,
+    secrets,
+    parsedArgs.verboseFlag
+  );
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+  if (sshCredentials.password) {
+    logger.warn(
+      "Password authentication is not supported for interactive sessions, falling back to your SSH agent/keys"
+    );
+  }
+
+  // Resolve the active container over a regular SSH connection first
+  let containerName: string | null;
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username,
+  });
+  try {
+    await sshClient.connect();
+    const dockerClient = new DockerClient(
+      sshClient,
+      serverHostname,
+      parsedArgs.verboseFlag
+    );
+    containerName = await findActiveContainer(
+      dockerClient,
+      service,
+      config.name
+    );
+  } finally {
+    await sshClient.close();
+  }
+
+  if (!containerName) {
+    throw new Error(
+      `No running container found for ${service.name} on ${serverHostname}`
+    );
+  }
+
+  logger.info(`Connecting to ${containerName} on ${serverHostname}`);
+
+  const exitCode = await openInteractiveSession(
+    sshCredentials,
+    serverHostname,
+    containerName,
+    command
+  );
+  logger.cleanup();
+
+  if (exitCode !== 0) {
+    process.exit(exitCode);
+  }
+}
@@ -0,0 +1,285 @@
+import { loadConfig, loadSecrets } from "../config";
+import { IopConfig, IopSecrets } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient, ProxyIncident, ProxyIncidentStatus } from "../proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+import { getStatusPageServer } from "../utils/status-page";
+
+// Module-level logger that gets configured when incident commands run
+let logger: Logger;
+
+const INCIDENT_STATUSES: ProxyIncidentStatus[] = [
+  "investigating",
+  "identified",
+  "monitoring",
+  "resolved",
+];
+
+interface IncidentContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  server: string;
+  verboseFlag: boolean;
+}
+
+interface ParsedIncidentArgs {
+  subcommand: string;
+  target?: string; // The title for open, the incident's ID otherwise
+  status?: ProxyIncidentStatus;
+  message?: string;
+  apps?: string[];
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for incident command
+ */
+export function parseIncidentArgs(args: string[]): ParsedIncidentArgs {
+  const positional: string[] = [];
+  let status: ProxyIncidentStatus | undefined;
+  let message: string | undefined;
+  let apps: string[] | undefined;
+
+  for (let i = 0; i < args.length; i++) {
+    const value = args[i + 1];
+    switch (args[i]) {
+      case "--status":
+        if (!INCIDENT_STATUSES.includes(value as ProxyIncidentStatus)) {
+          throw new Error(
+            `Invalid --status "${value}", expected one of ${INCIDENT_STATUSES.join(", ")}`
+          );
+        }
+        status = value as ProxyIncidentStatus;
+        i++;
+        break;
+      case "--message":
+        message = value;
+        i++;
+        break;
+      case "--apps":
+        apps = (value || "").split(",").filter((app) => app);
+        i++;
+        break;
+      case "--verbose":
+        break;
+      default:
+        positional.push(args[i]);
+    }
+  }
+
+  return {
+    subcommand: positional[0] || "",
+    target: positional[1],
+    status,
+    message,
+    apps,
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Formats an incident and its updates, newest update last
+ */
+export function formatIncident(incident: ProxyIncident): string[] {
+  const apps = incident.apps && incident.apps.length > 0 ? incident.apps.join(", ") : "all apps";
+  return [
+    `${incident.id}  ${incident.title} [${incident.status}] (${apps})`,
+    ...incident.updates.map((update) => {
+      const time = update.time.replace("T", " ").replace(/\.\d+/, "").replace("Z", " UTC");
+      return `  ${time}  ${update.status}${update.message ? `: ${update.message}` : ""}`;
+    }),
+  ];
+}
+
+/**
+ * Establishes SSH connection to the status page's server
+ */
+async function establishSSHConnection(
+  context: IncidentContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    context.server,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: context.server,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Runs a step against the proxy serving the status page
+ */
+async function withProxyClient<T>(
+  context: IncidentContext,
+  step: (proxyClient: IopProxyClient) => Promise<T>
+): Promise<T> {
+  const sshClient = await establishSSHConnection(context);
+  try {
+    return await step(
+      new IopProxyClient(
+        new DockerClient(sshClient, context.server, context.verboseFlag),
+        context.server,
+        context.verboseFlag
+      )
+    );
+  } finally {
+    await sshClient.close();
+  }
+}
+
+/**
+ * Shows help for incident command
+ */
+function showIncidentHelp(): void {
+  console.log("IOP Incident");
+  console.log("============");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop incident <subcommand> [flags]");
+  console.log("");
+  console.log("DESCRIPTION:");
+  console.log("  Posts incidents on the status page configured in the status_page section");
+  console.log("  of iop.yml. Open incidents mark the apps they affect as degraded.");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  list                 Show the page's incidents, newest first");
+  console.log('  open "<title>"       Open an incident');
+  console.log("  update <id>          Post an update on an incident");
+  console.log("  resolve <id>         Resolve an incident");
+  console.log("  remove <id>          Delete an incident opened by mistake");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --status <status>    investigating, identified, monitoring or resolved");
+  console.log("  --message <text>     Update shown to visitors");
+  console.log("  --apps <a,b>         Affected apps when opening (default: all)");
+  console.log("  --verbose            Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log('  iop incident open "Checkout errors" --apps web --message "Looking into it"');
+  console.log('  iop incident update inc_1a2b3c4d --status identified --message "Payment provider outage"');
+  console.log("  iop incident resolve inc_1a2b3c4d");
+}
+
+/**
+ * Main incident command that handles subcommands
+ */
+export async function incidentCommand(args: string[]): Promise<void> {
+  const verboseFlag = args.includes("--verbose");
+  logger = new Logger({ verbose: verboseFlag });
+
+  try {
+    const parsedArgs = parseIncidentArgs(args);
+    const { subcommand, target } = parsedArgs;
+
+    if (!["list", "open", "update", "resolve", "remove"].includes(subcommand)) {
+      showIncidentHelp();
+      return;
+    }
+    if (subcommand !== "list" && !target) {
+      throw new Error(
+        subcommand === "open"
+          ? 'Missing title: iop incident open "<title>"'
+          : `Missing incident ID: iop incident ${subcommand} <id>`
+      );
+    }
+
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+
+    if (!config.status_page) {
+      throw new Error(
+        "No status page configured. Add a status_page section with a host to iop.yml."
+      );
+    }
+    const server = getStatusPageServer(config);
+    if (!server) {
+      throw new Error("No server serves the status page, set status_page.server");
+    }
+
+    const context: IncidentContext = { config, secrets, server, verboseFlag };
+
+    switch (subcommand) {
+      case "list": {
+        const incidents = await withProxyClient(context, (proxyClient) =>
+          proxyClient.listIncidents(config.name)
+        );
+        if (!incidents) {
+          throw new Error("Could not list incidents, deploy to set up the status page first");
+        }
+        if (isJsonOutput()) {
+          writeResult({ incidents });
+          break;
+        }
+        if (incidents.length === 0) {
+          console.log("No incidents");
+        }
+        incidents.forEach((incident) => formatIncident(incident).forEach((line) => console.log(line)));
+        break;
+      }
+      case "open": {
+        const incident = await withProxyClient(context, (proxyClient) =>
+          proxyClient.openIncident(config.name, {
+            title: target!,
+            status: parsedArgs.status,
+            message: parsedArgs.message,
+            apps: parsedArgs.apps,
+          })
+        );
+        if (!incident) {
+          throw new Error("Could not open the incident, deploy to set up the status page first");
+        }
+        logger.info(`Opened incident ${incident.id} on ${config.status_page.host}`);
+        writeResult({ incident });
+        break;
+      }
+      case "update":
+      case "resolve": {
+        const status = subcommand === "resolve" ? "resolved" : parsedArgs.status;
+        if (!status) {
+          throw new Error("Missing --status, e.g. --status identified");
+        }
+        const incident = await withProxyClient(context, (proxyClient) =>
+          proxyClient.updateIncident(target!, status, parsedArgs.message)
+        );
+        if (!incident) {
+          throw new Error(`Could not update incident ${target}`);
+        }
+        logger.info(
+          status === "resolved" ? `Resolved incident ${incident.id}` : `Updated incident ${incident.id}`
+        );
+        writeResult({ incident });
+        break;
+      }
+      case "remove": {
+        const removed = await withProxyClient(context, (proxyClient) =>
+          proxyClient.removeIncident(target!)
+        );
+        if (!removed) {
+          throw new Error(`Could not remove incident ${target}`);
+        }
+        logger.info(`Removed incident ${target}`);
+        writeResult({ removed: target });
+        break;
+      }
+    }
+  } catch (error) {
+    logger.error("Incident command failed", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
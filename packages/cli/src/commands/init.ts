@@ -66,7 +66,7 @@ apps:
 
 # Example service (uncomment and configure as needed)
 # services:
-#   db:
+#   postgres:
 #     image: postgres:15
 #     server: your-server-ip
 #     ports:
@@ -0,0 +1,352 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient } from "../proxy";
+import { getDeclaredVolumeNames, getProjectNetworkName } from "../utils";
+import { requiresZeroDowntimeDeployment } from "../utils/service-utils";
+import { ServiceFingerprint } from "../utils/service-fingerprint";
+import { readDeployMetadataLabels } from "../utils/deploy-metadata";
+import { getScanLabels, readScanCounts } from "../utils/image-scan";
+import {
+  ContainerSecretFile,
+  SECRET_FILES_LABEL,
+  resolveSecretFiles,
+} from "../utils/secret-files";
+import { Logger } from "../utils/logger";
+import { writeResult } from "../utils/output";
+import { performBlueGreenDeployment } from "./blue-green";
+
+// Module-level logger that gets configured when a lifecycle command runs
+let logger: Logger;
+
+export type LifecycleAction = "restart" | "stop" | "start";
+
+interface LifecycleContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedLifecycleArgs {
+  entryNames: string[];
+  verboseFlag: boolean;
+}
+
+interface LifecycleResult {
+  serviceName: string;
+  server: string;
+  containers: string[];
+}
+
+/**
+ * Parses command line arguments for the restart, stop and start commands
+ */
+export function parseLifecycleArgs(args: string[]): ParsedLifecycleArgs {
+  return {
+    entryNames: args.filter((arg) => !arg.startsWith("--")),
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Looks up the named apps and services, failing on names not in iop.yml
+ */
+export function selectLifecycleServices(
+  services: ServiceEntry[],
+  entryNames: string[]
+): ServiceEntry[] {
+  const unknown = entryNames.filter(
+    (name) => !services.some((service) => service.name === name)
+  );
+  if (unknown.length > 0) {
+    throw new Error(`Unknown apps or services: ${unknown.join(", ")}`);
+  }
+  return services.filter((service) => entryNames.includes(service.name));
+}
+
+/**
+ * Rebuilds the fingerprint a container was deployed with from its labels, so
+ * a restarted container compares the same on the next deploy
+ */
+export function fingerprintFromLabels(
+  labels: Record<string, string>
+): ServiceFingerprint | undefined {
+  if (!labels["iop.config-hash"]) {
+    return undefined;
+  }
+  return {
+    type: labels["iop.fingerprint-type"] === "external" ? "external" : "built",
+    configHash: labels["iop.config-hash"],
+    secretsHash: labels["iop.secrets-hash"] || "",
+    contextHash: labels["iop.context-hash"],
+    imageReference: labels["iop.image-reference"],
+  };
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: LifecycleContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Finds the containers of a service, running or not. Zero-downtime services
+ * resolve to the containers of their active color.
+ */
+async function findServiceContainers(
+  dockerClient: DockerClient,
+  service: ServiceEntry,
+  projectName: string
+): Promise<string[]> {
+  if (!requiresZeroDowntimeDeployment(service)) {
+    const containerName = `${projectName}-${service.name}`;
+    return (await dockerClient.containerExists(containerName)) ? [containerName] : [];
+  }
+
+  const activeColor = await dockerClient.getCurrentActiveColorForProject(
+    service.name,
+    projectName
+  );
+  if (!activeColor) {
+    return [];
+  }
+
+  const containers: string[] = [];
+  for (const containerName of await dockerClient.findContainersByLabelAndProject(
+    `iop.app=${service.name}`,
+    projectName
+  )) {
+    const labels = await dockerClient.getContainerLabels(containerName);
+    if (labels["iop.color"] === activeColor) {
+      containers.push(containerName);
+    }
+  }
+  return containers.sort();
+}
+
+/**
+ * Replaces the active containers of a zero-downtime service with fresh ones
+ * from the same image, switching traffic once they are healthy
+ */
+async function rollingRestart(
+  service: ServiceEntry,
+  containers: string[],
+  dockerClient: DockerClient,
+  serverHostname: string,
+  context: LifecycleContext
+): Promise<string[]> {
+  const active = await dockerClient.inspectContainer(containers[0]);
+  const labels: Record<string, string> = active?.Config?.Labels || {};
+  const image: string | undefined = active?.Config?.Image;
+  if (!image) {
+    throw new Error(`Could not read the image of ${containers[0]}`);
+  }
+
+  // The running image, not a new build, and no init steps: only the process restarts
+  const result = await performBlueGreenDeployment({
+    serviceEntry: { ...service, image, build: undefined, init: undefined },
+    releaseId: "restart",
+    secrets: context.secrets,
+    projectName: context.config.name,
+    networkName: getProjectNetworkName(context.config.name),
+    dockerClient,
+    serverHostname,
+    verbose: context.verboseFlag,
+    fingerprint: fingerprintFromLabels(labels),
+    declaredVolumes: getDeclaredVolumeNames(context.config),
+    metadata: readDeployMetadataLabels(labels),
+    scanLabels: getScanLabels(readScanCounts(labels)),
+  });
+  if (!result.success) {
+    throw new Error(result.error || `Rolling restart of ${service.name} failed`);
+  }
+  return result.deployedContainers;
+}
+
+/**
+ * Stops or starts a container along with its sidecars. Secret files are
+ * removed once it stops and written again from the current secrets before it
+ * starts.
+ */
+async function setContainerRunning(
+  dockerClient: DockerClient,
+  containerName: string,
+  running: boolean,
+  secretFiles: ContainerSecretFile[] = []
+): Promise<void> {
+  const sidecars = await dockerClient.findContainersByLabel(
+    `iop.sidecar-of=${containerName}`
+  );
+  // Autoscaled copies mount the files of the container they were cloned from
+  const secretFilesDir = (await dockerClient.getContainerLabels(containerName))[SECRET_FILES_LABEL];
+  if (running) {
+    if (secretFilesDir) {
+      if (secretFiles.length === 0) {
+        throw new Error(
+          `${containerName} mounts secret files that are no longer configured, deploy it again`
+        );
+      }
+      await dockerClient.writeSecretFiles(secretFilesDir, secretFiles);
+    }
+    for (const name of [containerName, ...sidecars]) {
+      if (!(await dockerClient.startContainer(name))) {
+        throw new Error(`Failed to start ${name}`);
+      }
+    }
+  } else {
+    await dockerClient.gracefulShutdown([containerName, ...sidecars], 30);
+    if (secretFilesDir) {
+      await dockerClient.removeSecretFiles(secretFilesDir);
+    }
+  }
+}
+
+/**
+ * Restarts, stops or starts one service on its server
+ */
+async function runLifecycleAction(
+  action: LifecycleAction,
+  service: ServiceEntry,
+  dockerClient: DockerClient,
+  context: LifecycleContext
+): Promise<string[]> {
+  const projectName = context.config.name;
+  const containers = await findServiceContainers(dockerClient, service, projectName);
+  if (containers.length === 0) {
+    throw new Error(
+      `${service.name} isn't deployed on ${service.server}, run 'iop deploy ${service.name}' first`
+    );
+  }
+
+  const proxyClient = new IopProxyClient(dockerClient, service.server, context.verboseFlag);
+  const secretFiles = resolveSecretFiles(service, context.secrets);
+
+  switch (action) {
+    case "restart": {
+      const running = [];
+      for (const containerName of containers) {
+        if (await dockerClient.containerIsRunning(containerName)) {
+          running.push(containerName);
+        }
+      }
+      if (running.length === 0) {
+        throw new Error(`${service.name} is stopped, run 'iop start ${service.name}'`);
+      }
+      if (requiresZeroDowntimeDeployment(service)) {
+        return rollingRestart(service, running, dockerClient, service.server, context);
+      }
+      // Services without blue-green containers restart in place
+      await setContainerRunning(dockerClient, containers[0], false);
+      await setContainerRunning(dockerClient, containers[0], true, secretFiles);
+      return containers;
+    }
+    case "stop":
+      // Take the hosts out of rotation first, so requests get a clean 503
+      if (service.proxy && !(await proxyClient.setAppStopped(projectName, service.name, true))) {
+        logger.warn(`Could not mark ${service.name} as stopped in the proxy on ${service.server}`);
+      }
+      for (const containerName of containers) {
+        await setContainerRunning(dockerClient, containerName, false);
+      }
+      return containers;
+    case "start":
+      for (const containerName of containers) {
+        await setContainerRunning(dockerClient, containerName, true, secretFiles);
+      }
+      // The proxy routes to the hosts again once a health check passes
+      if (service.proxy && !(await proxyClient.setAppStopped(projectName, service.name, false))) {
+        throw new Error(`Started ${service.name} but the proxy on ${service.server} still has it stopped`);
+      }
+      return containers;
+  }
+}
+
+const ACTION_TEXT: Record<LifecycleAction, [string, string]> = {
+  restart: ["Restarting", "restarted"],
+  stop: ["Stopping", "stopped"],
+  start: ["Starting", "started"],
+};
+
+/**
+ * Restarts, stops or starts apps and services without touching their
+ * configuration. Zero-downtime apps restart through a blue-green switch.
+ */
+export async function lifecycleCommand(
+  action: LifecycleAction,
+  args: string[]
+): Promise<void> {
+  const parsedArgs = parseLifecycleArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  if (parsedArgs.entryNames.length === 0) {
+    throw new Error(`Specify the apps or services to ${action}, e.g. iop ${action} web`);
+  }
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets(true);
+    const context: LifecycleContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const services = selectLifecycleServices(
+      normalizeConfigEntries(config.services),
+      parsedArgs.entryNames
+    );
+    const [verb, pastTense] = ACTION_TEXT[action];
+    const results: LifecycleResult[] = [];
+
+    logger.phaseStart(`${verb} services`);
+    const servers = Array.from(new Set(services.map((service) => service.server)));
+    for (const serverHostname of servers) {
+      const sshClient = await establishSSHConnection(serverHostname, context);
+      try {
+        const dockerClient = new DockerClient(sshClient, serverHostname, context.verboseFlag);
+        const serverServices = services.filter((service) => service.server === serverHostname);
+
+        for (let i = 0; i < serverServices.length; i++) {
+          const service = serverServices[i];
+          const isLast = serverHostname === servers[servers.length - 1] && i === serverServices.length - 1;
+          const startTime = Date.now();
+          logger.serviceDeploymentStep(service.name, `${verb.toLowerCase()} on ${serverHostname}`, isLast);
+
+          const containers = await runLifecycleAction(action, service, dockerClient, context);
+          logger.serviceDeploymentComplete(service.name, pastTense, Date.now() - startTime, isLast);
+          results.push({ serviceName: service.name, server: serverHostname, containers });
+        }
+      } finally {
+        await sshClient.close();
+      }
+    }
+    logger.phaseEnd(`${verb} services`);
+
+    writeResult({ action, services: results });
+  } finally {
+    logger.cleanup();
+  }
+}
@@ -0,0 +1,275 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, LogDestination, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+import {
+  ensureLogShipper,
+  getLogShipperName,
+  removeLogShipper,
+} from "../utils/log-shipper";
+
+// Module-level logger that gets configured when logs commands run
+let logger: Logger;
+
+interface LogsContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedLogsArgs {
+  subcommand: string;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for logs command
+ */
+export function parseLogsArgs(args: string[]): ParsedLogsArgs {
+  const verboseFlag = args.includes("--verbose");
+
+  let server: string | undefined;
+  const cleanArgs: string[] = [];
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--verbose") {
+      continue;
+    } else if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++;
+    } else {
+      cleanArgs.push(args[i]);
+    }
+  }
+
+  return {
+    subcommand: cleanArgs[0] || "",
+    server,
+    verboseFlag,
+  };
+}
+
+/**
+ * Describes a destination without its secrets, e.g. "loki https://logs.example.com"
+ */
+export function describeLogDestination(destination: LogDestination): string {
+  switch (destination.type) {
+    case "loki":
+      return `loki ${destination.url}`;
+    case "s3":
+      return `s3 s3://${destination.bucket}/${destination.prefix || ""}`;
+    case "syslog":
+      return `syslog ${destination.protocol}://${destination.address}`;
+  }
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: LogsContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Setup subcommand - starts or reconfigures the log shipper on every server
+ */
+async function logsSetupSubcommand(
+  context: LogsContext,
+  services: ServiceEntry[],
+  servers: string[]
+): Promise<void> {
+  const results = [];
+  for (const server of servers) {
+    logger.server(server);
+    const sshClient = await establishSSHConnection(server, context);
+    try {
+      const dockerClient = new DockerClient(sshClient, server, context.verboseFlag);
+      const changed = await ensureLogShipper(
+        sshClient,
+        dockerClient,
+        context.config,
+        context.secrets,
+        server,
+        services.filter((service) => service.server === server)
+      );
+      logger.serverStepComplete(
+        changed ? "Log shipper configured" : "Log shipper is up to date"
+      );
+      results.push({ server, changed });
+    } finally {
+      await sshClient.close();
+    }
+  }
+
+  const destinations = context.config.logs!.destinations.map(describeLogDestination);
+  logger.info(`Shipping logs to ${destinations.join(", ")}`);
+  writeResult({ servers: results, destinations });
+}
+
+/**
+ * Status subcommand - shows whether each server's shipper runs and the
+ * errors it logged recently, such as a destination refusing logs
+ */
+async function logsStatusSubcommand(context: LogsContext, servers: string[]): Promise<void> {
+  const name = getLogShipperName(context.config.name);
+  const results = [];
+  for (const server of servers) {
+    const sshClient = await establishSSHConnection(server, context);
+    try {
+      const dockerClient = new DockerClient(sshClient, server, context.verboseFlag);
+      const running = await dockerClient.containerIsRunning(name);
+      const errors = running
+        ? (
+            await sshClient.exec(
+              `docker logs --since 10m ${name} 2>&1 | grep ERROR | tail -n 5 || true`
+            )
+          )
+            .split("\n")
+            .filter(Boolean)
+        : [];
+      results.push({ server, running, errors });
+    } finally {
+      await sshClient.close();
+    }
+  }
+
+  if (isJsonOutput()) {
+    writeResult({ servers: results });
+    return;
+  }
+  console.log(
+    `Destinations: ${context.config.logs!.destinations.map(describeLogDestination).join(", ")}`
+  );
+  for (const { server, running, errors } of results) {
+    console.log(`\n=== ${server} ===`);
+    console.log(`Status: ${running ? "running" : "not running, run: iop logs setup"}`);
+    if (errors.length > 0) {
+      console.log("Recent errors:");
+      errors.forEach((line) => console.log(`  ${line}`));
+    }
+  }
+}
+
+/**
+ * Remove subcommand - stops the log shipper on every server
+ */
+async function logsRemoveSubcommand(context: LogsContext, servers: string[]): Promise<void> {
+  const results = [];
+  for (const server of servers) {
+    const sshClient = await establishSSHConnection(server, context);
+    try {
+      const dockerClient = new DockerClient(sshClient, server, context.verboseFlag);
+      const removed = await removeLogShipper(dockerClient, context.config.name);
+      logger.info(`${server}: ${removed ? "log shipper removed" : "no log shipper"}`);
+      results.push({ server, removed });
+    } finally {
+      await sshClient.close();
+    }
+  }
+  if (context.config.logs) {
+    logger.warn("The next deploy sets the shipper up again, remove the logs section from iop.yml");
+  }
+  writeResult({ servers: results });
+}
+
+/**
+ * Shows help for logs command
+ */
+function showLogsHelp(): void {
+  console.log("IOP Log Shipping");
+  console.log("================");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop logs <subcommand> [flags]");
+  console.log("");
+  console.log("DESCRIPTION:");
+  console.log("  Runs a Vector container on each server that ships the output of the");
+  console.log("  project's containers and the proxy's access logs for its hosts to the");
+  console.log("  destinations in the logs section of iop.yml, labelled with project, app,");
+  console.log("  server and source. Deploy sets it up too and keeps it in sync with iop.yml.");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  setup    Start or reconfigure the log shipper on every server");
+  console.log("  status   Show whether the shipper runs and its recent errors");
+  console.log("  remove   Stop and remove the log shipper");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --server <host>     Only the given server");
+  console.log("  --verbose           Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop logs setup");
+  console.log("  iop logs status --server server1.example.com");
+}
+
+/**
+ * Main logs command that handles subcommands
+ */
+export async function logsCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseLogsArgs(args);
+
+  if (!["setup", "status", "remove"].includes(parsedArgs.subcommand)) {
+    showLogsHelp();
+    return;
+  }
+
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+
+    if (!config.logs && parsedArgs.subcommand !== "remove") {
+      throw new Error(
+        "No log shipping configured. Add a logs section with destinations to iop.yml."
+      );
+    }
+
+    const context: LogsContext = { config, secrets, verboseFlag: parsedArgs.verboseFlag };
+    const services: ServiceEntry[] = normalizeConfigEntries(config.services);
+    const servers = parsedArgs.server
+      ? [parsedArgs.server]
+      : Array.from(new Set(services.map((service) => service.server)));
+
+    switch (parsedArgs.subcommand) {
+      case "setup":
+        await logsSetupSubcommand(context, services, servers);
+        break;
+      case "status":
+        await logsStatusSubcommand(context, servers);
+        break;
+      case "remove":
+        await logsRemoveSubcommand(context, servers);
+        break;
+    }
+  } catch (error) {
+    logger.error("Logs command failed", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
@@ -0,0 +1,433 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import {
+  IopConfig,
+  IopSecrets,
+  MeshConfig,
+  MeshConfigSchema,
+  ServiceEntry,
+} from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+import { IOP_PROXY_NAME, setupIopProxy } from "../setup-proxy";
+import { RootShell, getFirewallBackend } from "../utils/server-hardening";
+import {
+  MESH_CONFIG_PATH,
+  MESH_DOCKER_DROPIN,
+  MESH_DOCKER_DROPIN_PATH,
+  MESH_HANDSHAKE_TIMEOUT,
+  MESH_INTERFACE,
+  MESH_KEY_PATH,
+  MeshPeer,
+  assignMeshAddresses,
+  buildWireGuardConfig,
+  formatHandshake,
+  getMeshEndpoint,
+  parseMeshAddress,
+  parseWireGuardDump,
+} from "../utils/mesh";
+
+// Module-level logger that gets configured when network commands run
+let logger: Logger;
+
+interface NetworkContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  mesh: MeshConfig;
+  verboseFlag: boolean;
+}
+
+interface ParsedNetworkArgs {
+  subcommand: string;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+// A server being set up, with the connection kept open between the steps
+interface MeshSession {
+  server: string;
+  sshClient: SSHClient;
+  shell: RootShell;
+  publicKey: string;
+  existingAddress?: string;
+}
+
+/**
+ * Parses command line arguments for network command
+ */
+export function parseNetworkArgs(args: string[]): ParsedNetworkArgs {
+  const verboseFlag = args.includes("--verbose");
+
+  let server: string | undefined;
+  const cleanArgs: string[] = [];
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--verbose") {
+      continue;
+    } else if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++;
+    } else {
+      cleanArgs.push(args[i]);
+    }
+  }
+
+  return {
+    subcommand: cleanArgs[0] || "",
+    server,
+    verboseFlag,
+  };
+}
+
+/**
+ * Returns every server in the configuration, in a stable order so addresses
+ * are handed out the same way on every run
+ */
+function resolveServers(config: IopConfig): string[] {
+  return Array.from(
+    new Set(
+      normalizeConfigEntries(config.services).map(
+        (service: ServiceEntry) => service.server
+      )
+    )
+  ).sort();
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: NetworkContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Installs WireGuard and creates the server's key pair unless it has one.
+ * Only the public key leaves the server.
+ */
+async function prepareMeshServer(
+  server: string,
+  context: NetworkContext
+): Promise<MeshSession> {
+  const sshClient = await establishSSHConnection(server, context);
+  try {
+    const shell = await RootShell.connect(sshClient);
+    await shell.installPackages(["wireguard-tools"]);
+    await shell.exec(
+      `sh -c 'umask 077; test -s ${MESH_KEY_PATH} || wg genkey > ${MESH_KEY_PATH}'`
+    );
+    const publicKey = (await shell.exec(`sh -c 'wg pubkey < ${MESH_KEY_PATH}'`)).trim();
+    const existingAddress = parseMeshAddress(await shell.readFile(MESH_CONFIG_PATH));
+    return { server, sshClient, shell, publicKey, existingAddress };
+  } catch (error) {
+    await sshClient.close();
+    throw error;
+  }
+}
+
+/**
+ * Writes a server's WireGuard config and brings the interface up. A running
+ * interface picks up new peers without dropping existing connections.
+ */
+async function applyMeshConfig(
+  session: MeshSession,
+  self: MeshPeer,
+  peers: MeshPeer[],
+  mesh: MeshConfig
+): Promise<boolean> {
+  const { shell } = session;
+  const unit = `wg-quick@${MESH_INTERFACE}`;
+  const changed = await shell.writeFile(
+    MESH_CONFIG_PATH,
+    buildWireGuardConfig(self, peers, mesh.subnet, mesh.port)
+  );
+  if (await shell.writeFile(MESH_DOCKER_DROPIN_PATH, MESH_DOCKER_DROPIN)) {
+    await shell.exec("systemctl daemon-reload");
+  }
+  const active = await shell.succeeds(`systemctl is-active --quiet ${unit}`);
+
+  if (!active) {
+    await shell.exec(`systemctl enable --now ${unit}`);
+    return true;
+  }
+  if (session.existingAddress !== self.address) {
+    // The address of a running interface only changes on restart
+    await shell.exec(`systemctl restart ${unit}`);
+    return true;
+  }
+  if (changed) {
+    // The config has no private key, so syncing it clears the key and it is
+    // set again right away
+    await shell.exec(
+      `bash -c 'wg syncconf ${MESH_INTERFACE} <(wg-quick strip ${MESH_INTERFACE}) && wg set ${MESH_INTERFACE} private-key ${MESH_KEY_PATH}'`
+    );
+  }
+  return changed;
+}
+
+/**
+ * Recreates a running proxy unless it publishes its DNS server on the
+ * server's mesh address. Docker only publishes ports when a container is
+ * created; a server without a proxy gets one on the next deploy.
+ * @returns false if the proxy couldn't be recreated
+ */
+async function publishServiceDiscovery(
+  session: MeshSession,
+  address: string,
+  context: NetworkContext
+): Promise<boolean> {
+  const { sshClient } = session;
+  const proxy = await sshClient.exec(`docker ps -aq --filter name=^${IOP_PROXY_NAME}$`);
+  if (!proxy.trim()) {
+    return true;
+  }
+  const published = await sshClient.exec(
+    `docker port ${IOP_PROXY_NAME} 53/udp 2>/dev/null || true`
+  );
+  if (published.includes(`${address}:53`)) {
+    return true;
+  }
+  logger.verboseLog(`Recreating ${IOP_PROXY_NAME} on ${session.server} to publish DNS on ${address}`);
+  return setupIopProxy(session.server, sshClient, context.verboseFlag, true);
+}
+
+/**
+ * Setup subcommand - provisions the WireGuard mesh across every server
+ */
+async function networkSetupSubcommand(context: NetworkContext): Promise<void> {
+  const servers = resolveServers(context.config);
+  if (servers.length < 2) {
+    throw new Error("A mesh needs at least two servers in iop.yml");
+  }
+  const hardening = context.config.hardening;
+  if (hardening && getFirewallBackend(hardening) && !context.config.mesh) {
+    logger.warn(
+      `Add a mesh section to iop.yml so the hardening firewall allows ${context.mesh.port}/udp and ${MESH_INTERFACE}`
+    );
+  }
+
+  const sessions: MeshSession[] = [];
+  try {
+    for (const server of servers) {
+      logger.server(server);
+      logger.serverStep("Preparing WireGuard");
+      sessions.push(await prepareMeshServer(server, context));
+      logger.serverStepComplete("WireGuard installed, key ready");
+    }
+
+    const existing: Record<string, string | undefined> = {};
+    for (const session of sessions) {
+      existing[session.server] = session.existingAddress;
+    }
+    const addresses = assignMeshAddresses(context.mesh.subnet, servers, existing);
+    const peers: MeshPeer[] = sessions.map((session) => ({
+      server: session.server,
+      address: addresses[session.server],
+      publicKey: session.publicKey,
+      endpoint: getMeshEndpoint(session.server, context.mesh),
+    }));
+
+    const results = [];
+    for (const [index, session] of sessions.entries()) {
+      const self = peers[index];
+      logger.serverStep(`Configuring ${MESH_INTERFACE} on ${session.server}`);
+      const changed = await applyMeshConfig(session, self, peers, context.mesh);
+      logger.serverStepComplete(
+        `${session.server}: ${self.address} (${changed ? "configured" : "unchanged"})`
+      );
+      results.push({
+        server: session.server,
+        address: self.address,
+        publicKey: self.publicKey,
+        endpoint: self.endpoint,
+        changed,
+      });
+    }
+
+    // Every server pings every other one, which also starts the handshakes
+    const unreachable: Array<{ from: string; to: string }> = [];
+    for (const session of sessions) {
+      for (const peer of peers) {
+        if (peer.server === session.server) continue;
+        logger.verboseLog(`Pinging ${peer.server} (${peer.address}) from ${session.server}`);
+        if (!(await session.shell.succeeds(`ping -c 3 -W 2 ${peer.address} > /dev/null`))) {
+          unreachable.push({ from: session.server, to: peer.server });
+        }
+      }
+    }
+
+    for (const session of sessions) {
+      if (!(await publishServiceDiscovery(session, addresses[session.server], context))) {
+        logger.warn(`Could not publish service discovery on ${session.server}, run iop proxy update`);
+      }
+    }
+
+    for (const { from, to } of unreachable) {
+      logger.warn(
+        `${from} can't reach ${to} over the mesh. Check that ${getMeshEndpoint(to, context.mesh)}/udp is open.`
+      );
+    }
+    if (unreachable.length === 0) {
+      logger.info(`Mesh ${context.mesh.subnet} is up across ${servers.length} server(s)`);
+    } else {
+      process.exitCode = 1;
+    }
+    writeResult({ subnet: context.mesh.subnet, servers: results, unreachable });
+  } finally {
+    for (const session of sessions) {
+      await session.sshClient.close();
+    }
+  }
+}
+
+/**
+ * Status subcommand - shows each server's mesh address and its peers' handshakes
+ */
+async function networkStatusSubcommand(
+  context: NetworkContext,
+  parsedArgs: ParsedNetworkArgs
+): Promise<void> {
+  const servers = parsedArgs.server ? [parsedArgs.server] : resolveServers(context.config);
+
+  const statuses = [];
+  for (const server of servers) {
+    const sshClient = await establishSSHConnection(server, context);
+    try {
+      const shell = await RootShell.connect(sshClient);
+      const address = parseMeshAddress(await shell.readFile(MESH_CONFIG_PATH));
+      const dump = address
+        ? await shell.exec(`wg show ${MESH_INTERFACE} dump 2>/dev/null || true`)
+        : "";
+      statuses.push({ server, address, peers: parseWireGuardDump(dump) });
+    } finally {
+      await sshClient.close();
+    }
+  }
+
+  // Peers are named after the server whose address they have
+  const names: Record<string, string> = {};
+  for (const status of statuses) {
+    if (status.address) names[`${status.address}/32`] = status.server;
+  }
+
+  const now = Date.now() / 1000;
+  const result = statuses.map((status) => ({
+    server: status.server,
+    address: status.address ?? null,
+    peers: status.peers.map((peer) => ({
+      server: peer.allowedIps.map((ip) => names[ip]).find(Boolean) ?? null,
+      allowedIps: peer.allowedIps,
+      endpoint: peer.endpoint ?? null,
+      latestHandshake: peer.latestHandshake || null,
+      connected:
+        peer.latestHandshake > 0 && now - peer.latestHandshake < MESH_HANDSHAKE_TIMEOUT,
+    })),
+  }));
+
+  if (isJsonOutput()) {
+    writeResult({ subnet: context.mesh.subnet, servers: result });
+    return;
+  }
+  for (const status of result) {
+    console.log(`\n=== ${status.server} ===`);
+    if (!status.address) {
+      console.log("Not part of the mesh, run: iop network setup");
+      continue;
+    }
+    console.log(`Address: ${status.address}`);
+    if (status.peers.length === 0) {
+      console.log(`${MESH_INTERFACE} is down or has no peers`);
+    }
+    for (const peer of status.peers) {
+      const name = peer.server ?? peer.allowedIps.join(", ");
+      const state = peer.connected ? "connected" : "no recent handshake";
+      console.log(
+        `  ${name}: ${state}, last handshake ${formatHandshake(peer.latestHandshake ?? 0, now)}`
+      );
+    }
+  }
+}
+
+/**
+ * Shows help for network command
+ */
+function showNetworkHelp(): void {
+  console.log("IOP Server Mesh");
+  console.log("===============");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop network <subcommand> [flags]");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  setup    Connect all servers with an encrypted WireGuard mesh");
+  console.log("  status   Show mesh addresses and when peers last shook hands");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --server <host>     Only show the given server (status)");
+  console.log("  --verbose           Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop network setup");
+  console.log("  iop network status");
+  console.log("  iop ports add 5432 postgres --allow 10.210.0.0/24");
+}
+
+/**
+ * Main network command that handles subcommands
+ */
+export async function networkCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseNetworkArgs(args);
+
+  if (!["setup", "status"].includes(parsedArgs.subcommand)) {
+    showNetworkHelp();
+    return;
+  }
+
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+
+    const context: NetworkContext = {
+      config,
+      secrets,
+      mesh: MeshConfigSchema.parse(config.mesh ?? {}),
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    switch (parsedArgs.subcommand) {
+      case "setup":
+        await networkSetupSubcommand(context);
+        break;
+      case "status":
+        await networkStatusSubcommand(context, parsedArgs);
+        break;
+    }
+  } catch (error) {
+    logger.error("Network command failed", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
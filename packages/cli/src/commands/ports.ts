@@ -0,0 +1,364 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient } from "../proxy";
+import { setupIopProxy, IOP_PROXY_NAME } from "../setup-proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+
+// Module-level logger that gets configured when ports commands run
+let logger: Logger;
+
+interface PortsContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedPortsArgs {
+  subcommand: string;
+  portSpec?: string;
+  serviceSpec?: string;
+  allow: string[];
+  server?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for ports command
+ */
+export function parsePortsArgs(args: string[]): ParsedPortsArgs {
+  const verboseFlag = args.includes("--verbose");
+
+  let server: string | undefined;
+  const allow: string[] = [];
+  const cleanArgs: string[] = [];
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--verbose") {
+      continue;
+    } else if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++;
+    } else if (args[i] === "--allow" && i + 1 < args.length) {
+      allow.push(
+        ...args[i + 1]
+          .split(",")
+          .map((entry) => entry.trim())
+          .filter(Boolean)
+      );
+      i++;
+    } else {
+      cleanArgs.push(args[i]);
+    }
+  }
+
+  return {
+    subcommand: cleanArgs[0] || "",
+    portSpec: cleanArgs[1],
+    serviceSpec: cleanArgs[2],
+    allow,
+    server,
+    verboseFlag,
+  };
+}
+
+/**
+ * Parses a port such as "5432" or "27015/udp"
+ */
+export function parsePortSpec(spec: string): {
+  listen: number;
+  protocol: "tcp" | "udp";
+} {
+  const match = spec.match(/^(\d+)(?:\/(tcp|udp))?$/i);
+  const listen = match ? parseInt(match[1], 10) : NaN;
+  if (!match || listen < 1 || listen > 65535) {
+    throw new Error(`Invalid port "${spec}", expected e.g. 5432 or 27015/udp`);
+  }
+
+  return {
+    listen,
+    protocol: (match[2]?.toLowerCase() as "tcp" | "udp") || "tcp",
+  };
+}
+
+/**
+ * Resolves "service" or "service:port" to the container address the proxy
+ * forwards to. The container port defaults to the listen port.
+ */
+export function resolvePortTarget(
+  projectName: string,
+  serviceSpec: string,
+  listen: number
+): { serviceName: string; target: string } {
+  const [serviceName, port] = serviceSpec.split(":");
+  if (port !== undefined && !/^\d+$/.test(port)) {
+    throw new Error(`Invalid service port in "${serviceSpec}"`);
+  }
+
+  return {
+    serviceName,
+    target: `${projectName}-${serviceName}:${port || listen}`,
+  };
+}
+
+/**
+ * Returns --server if given, otherwise every server in the configuration
+ */
+function resolveServers(config: IopConfig, server?: string): string[] {
+  if (server) {
+    return [server];
+  }
+  return Array.from(
+    new Set(
+      normalizeConfigEntries(config.services).map(
+        (service: ServiceEntry) => service.server
+      )
+    )
+  );
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: PortsContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Add subcommand - forwards a port on the service's server to the service
+ */
+async function portsAddSubcommand(
+  context: PortsContext,
+  parsedArgs: ParsedPortsArgs
+): Promise<void> {
+  if (!parsedArgs.portSpec || !parsedArgs.serviceSpec) {
+    throw new Error("Usage: iop ports add <port>[/udp] <service>[:port]");
+  }
+
+  const { listen, protocol } = parsePortSpec(parsedArgs.portSpec);
+  const { serviceName, target } = resolvePortTarget(
+    context.config.name,
+    parsedArgs.serviceSpec,
+    listen
+  );
+
+  const service = normalizeConfigEntries(context.config.services).find(
+    (entry) => entry.name === serviceName
+  );
+  if (!service) {
+    throw new Error(`Service "${serviceName}" not found in configuration`);
+  }
+
+  const serverHostname = parsedArgs.server || service.server;
+  logger.server(serverHostname);
+  const sshClient = await establishSSHConnection(serverHostname, context);
+  try {
+    const dockerClient = new DockerClient(
+      sshClient,
+      serverHostname,
+      context.verboseFlag
+    );
+    const proxyClient = new IopProxyClient(
+      dockerClient,
+      serverHostname,
+      context.verboseFlag
+    );
+
+    logger.serverStep(`Forwarding ${listen}/${protocol} to ${target}`);
+    const added = await proxyClient.addPortForward({
+      listen,
+      protocol,
+      target,
+      project: context.config.name,
+      allow: parsedArgs.allow,
+    });
+    if (!added) {
+      throw new Error(`Proxy rejected forwarding rule for ${listen}/${protocol}`);
+    }
+
+    // Docker only publishes ports when a container is created, so the proxy
+    // is recreated the first time a port is forwarded
+    const published = await sshClient.exec(
+      `docker port ${IOP_PROXY_NAME} ${listen}/${protocol} 2>/dev/null || true`
+    );
+    if (!published.trim()) {
+      logger.verboseLog(`Recreating ${IOP_PROXY_NAME} to publish ${listen}/${protocol}`);
+      if (!(await setupIopProxy(serverHostname, sshClient, context.verboseFlag, true))) {
+        throw new Error(`Failed to publish ${listen}/${protocol} on ${IOP_PROXY_NAME}`);
+      }
+    }
+
+    logger.serverStepComplete(
+      `${serverHostname}:${listen}/${protocol} -> ${target}`
+    );
+    writeResult({
+      server: serverHostname,
+      listen,
+      protocol,
+      target,
+      allow: parsedArgs.allow,
+    });
+  } catch (error) {
+    logger.serverStepError(`Failed to forward ${listen}/${protocol}`, error);
+    throw error;
+  } finally {
+    await sshClient.close();
+  }
+}
+
+/**
+ * Remove subcommand - stops forwarding a port
+ */
+async function portsRemoveSubcommand(
+  context: PortsContext,
+  parsedArgs: ParsedPortsArgs
+): Promise<void> {
+  if (!parsedArgs.portSpec) {
+    throw new Error("Usage: iop ports remove <port>[/udp]");
+  }
+
+  const { listen, protocol } = parsePortSpec(parsedArgs.portSpec);
+  const removedFrom: string[] = [];
+
+  for (const serverHostname of resolveServers(context.config, parsedArgs.server)) {
+    const sshClient = await establishSSHConnection(serverHostname, context);
+    try {
+      const proxyClient = new IopProxyClient(
+        new DockerClient(sshClient, serverHostname, context.verboseFlag),
+        serverHostname,
+        context.verboseFlag
+      );
+
+      if (await proxyClient.removePortForward(listen, protocol)) {
+        removedFrom.push(serverHostname);
+        logger.info(`Stopped forwarding ${listen}/${protocol} on ${serverHostname}`);
+      }
+    } finally {
+      await sshClient.close();
+    }
+  }
+
+  writeResult({ listen, protocol, servers: removedFrom });
+}
+
+/**
+ * List subcommand - shows forwarded ports on each server
+ */
+async function portsListSubcommand(
+  context: PortsContext,
+  parsedArgs: ParsedPortsArgs
+): Promise<void> {
+  const results: Array<{ server: string; forwards: string | null }> = [];
+  for (const serverHostname of resolveServers(context.config, parsedArgs.server)) {
+    const sshClient = await establishSSHConnection(serverHostname, context);
+    try {
+      const proxyClient = new IopProxyClient(
+        new DockerClient(sshClient, serverHostname, context.verboseFlag),
+        serverHostname,
+        context.verboseFlag
+      );
+
+      const output = await proxyClient.listPortForwards();
+      results.push({ server: serverHostname, forwards: output?.trim() ?? null });
+      if (!isJsonOutput()) {
+        console.log(`\n=== ${serverHostname} ===`);
+        console.log(output?.trim() || "Could not list forwarded ports");
+      }
+    } finally {
+      await sshClient.close();
+    }
+  }
+  writeResult({ servers: results });
+}
+
+/**
+ * Shows help for ports command
+ */
+function showPortsHelp(): void {
+  console.log("IOP Port Forwarding");
+  console.log("===================");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop ports <subcommand> [port] [service] [flags]");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  add <port>[/udp] <service>[:port]   Forward a port on the server to a service");
+  console.log("  remove <port>[/udp]                 Stop forwarding a port");
+  console.log("  list                                Show forwarded ports");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --allow <ip|cidr>   Only accept clients from these addresses (repeatable)");
+  console.log("  --server <host>     Only target the given server");
+  console.log("  --verbose           Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop ports add 5432 postgres --allow 203.0.113.0/24");
+  console.log("  iop ports add 27015/udp game");
+  console.log("  iop ports add 6380 cache:6379");
+  console.log("  iop ports remove 5432");
+}
+
+/**
+ * Main ports command that handles subcommands
+ */
+export async function portsCommand(args: string[]): Promise<void> {
+  const parsedArgs = parsePortsArgs(args);
+
+  if (!["add", "remove", "list"].includes(parsedArgs.subcommand)) {
+    showPortsHelp();
+    return;
+  }
+
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+
+    const context: PortsContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    switch (parsedArgs.subcommand) {
+      case "add":
+        await portsAddSubcommand(context, parsedArgs);
+        break;
+      case "remove":
+        await portsRemoveSubcommand(context, parsedArgs);
+        break;
+      case "list":
+        await portsListSubcommand(context, parsedArgs);
+        break;
+    }
+  } catch (error) {
+    logger.error("Ports command failed", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
@@ -0,0 +1,290 @@
+import { exec } from "child_process";
+import { promisify } from "util";
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient } from "../proxy";
+import { Logger } from "../utils/logger";
+import { getProjectNetworkName, sanitizeFolderName } from "../utils";
+import { CloudflareDnsClient, getDnsRecordComment } from "../utils/cloudflare-dns";
+import { deployCommand } from "./deploy";
+
+const execAsync = promisify(exec);
+
+// Module-level logger that gets configured when preview commands run
+let logger: Logger;
+
+// Keeps <branch>.<service>.<domain> well within the 63 character DNS label limit
+const MAX_BRANCH_SLUG_LENGTH = 40;
+
+interface ParsedPreviewArgs {
+  subcommand: string;
+  branch?: string;
+  verboseFlag: boolean;
+  deployArgs: string[];
+}
+
+/**
+ * Parses command line arguments for preview command
+ */
+export function parsePreviewArgs(args: string[]): ParsedPreviewArgs {
+  const verboseFlag = args.includes("--verbose");
+
+  if (args[0] === "rm") {
+    const positional = args.slice(1).filter((arg) => !arg.startsWith("--"));
+    return { subcommand: "rm", branch: positional[0], verboseFlag, deployArgs: [] };
+  }
+
+  let branch: string | undefined;
+  const deployArgs: string[] = [];
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--branch" && i + 1 < args.length) {
+      branch = args[i + 1];
+      i++;
+    } else {
+      deployArgs.push(args[i]);
+    }
+  }
+
+  return { subcommand: "deploy", branch, verboseFlag, deployArgs };
+}
+
+/**
+ * Turns a git branch name into a DNS-safe label, e.g. "feature/Login_Form" -> "feature-login-form"
+ */
+export function slugifyBranch(branch: string): string {
+  return branch
+    .toLowerCase()
+    .replace(/[^a-z0-9]+/g, "-")
+    .substring(0, MAX_BRANCH_SLUG_LENGTH)
+    .replace(/^-+|-+$/g, "");
+}
+
+/**
+ * Returns the project name a branch's preview environment is deployed under
+ */
+export function getPreviewProjectName(projectName: string, branchSlug: string): string {
+  return `${projectName}-preview-${branchSlug}`;
+}
+
+/**
+ * Returns the host a service is served at in a branch's preview environment
+ */
+export function getPreviewHost(
+  branchSlug: string,
+  serviceName: string,
+  domain: string
+): string {
+  return `${branchSlug}.${serviceName}.${domain}`;
+}
+
+/**
+ * Derives the preview environment configuration for a branch. It runs as its own
+ * project so containers, networks and volumes never collide with the main one, and
+ * every proxied service is served at its preview host only.
+ */
+export function buildPreviewConfig(config: IopConfig, branchSlug: string): IopConfig {
+  if (!config.preview) {
+    throw new Error(
+      "Preview environments need a 'preview.domain' in iop.yml, e.g. preview.example.com"
+    );
+  }
+  const domain = config.preview.domain;
+
+  const services = normalizeConfigEntries(config.services).map(
+    (service: ServiceEntry) =>
+      service.proxy
+        ? {
+            ...service,
+            proxy: {
+              ...service.proxy,
+              hosts: [getPreviewHost(branchSlug, service.name, domain)],
+            },
+          }
+        : service
+  );
+
+  return {
+    ...config,
+    name: getPreviewProjectName(config.name, branchSlug),
+    services,
+    // Keep preview deploys apart from production in the GitHub timeline
+    ...(config.github
+      ? { github: { ...config.github, environment: `preview/${branchSlug}` } }
+      : {}),
+  };
+}
+
+/**
+ * Gets the current git branch
+ */
+async function getCurrentBranch(): Promise<string> {
+  const { stdout } = await execAsync("git rev-parse --abbrev-ref HEAD");
+  const branch = stdout.trim();
+  if (!branch || branch === "HEAD") {
+    throw new Error(
+      "Could not determine the current git branch (detached HEAD?). Use --branch <name>."
+    );
+  }
+  return branch;
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  config: IopConfig,
+  secrets: IopSecrets,
+  verboseFlag: boolean
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    config,
+    secrets,
+    verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Removes a preview environment's containers, proxy routes, network, volumes
+ * and project directory from one server
+ */
+async function removePreviewFromServer(
+  previewConfig: IopConfig,
+  secrets: IopSecrets,
+  serverHostname: string,
+  services: ServiceEntry[],
+  verboseFlag: boolean
+): Promise<void> {
+  let sshClient: SSHClient | undefined;
+  try {
+    logger.server(serverHostname);
+    sshClient = await establishSSHConnection(
+      serverHostname,
+      previewConfig,
+      secrets,
+      verboseFlag
+    );
+    const dockerClient = new DockerClient(sshClient, serverHostname, verboseFlag);
+    const proxyClient = new IopProxyClient(dockerClient, serverHostname, verboseFlag);
+
+    logger.serverStep("Removing containers");
+    const containers = await dockerClient.findProjectContainers(previewConfig.name);
+    for (const containerName of containers) {
+      await dockerClient.stopContainer(containerName);
+      await dockerClient.removeContainer(containerName);
+    }
+    logger.serverStepComplete(`Removed ${containers.length} containers`);
+
+    logger.serverStep("Removing proxy routes");
+    for (const service of services) {
+      for (const host of service.proxy?.hosts || []) {
+        await proxyClient.removeProxyConfig(host);
+      }
+    }
+    logger.serverStepComplete("Removed proxy routes");
+
+    logger.serverStep("Removing network and volumes");
+    await dockerClient.removeNetwork(getProjectNetworkName(previewConfig.name));
+    const volumes = await dockerClient.listVolumes(`iop.project=${previewConfig.name}`);
+    for (const volume of volumes) {
+      await dockerClient.removeVolume(volume);
+    }
+    await sshClient.exec(
+      `rm -rf ~/.iop/projects/${sanitizeFolderName(previewConfig.name)}`
+    );
+    logger.serverStepComplete(`Removed network and ${volumes.length} volumes`);
+  } catch (error) {
+    logger.serverStepError(`Failed to remove preview from ${serverHostname}`, error);
+  } finally {
+    if (sshClient) {
+      await sshClient.close();
+    }
+  }
+}
+
+/**
+ * Remove subcommand - tears down a branch's preview environment
+ */
+async function previewRmSubcommand(branchSlug: string, verboseFlag: boolean): Promise<void> {
+  const config = await loadConfig();
+  const secrets = await loadSecrets(true);
+  const previewConfig = buildPreviewConfig(config, branchSlug);
+  const services = normalizeConfigEntries(previewConfig.services);
+
+  logger.info(`Removing preview environment ${previewConfig.name}`);
+
+  const servicesByServer = new Map<string, ServiceEntry[]>();
+  services.forEach((service) => {
+    const serverServices = servicesByServer.get(service.server) || [];
+    serverServices.push(service);
+    servicesByServer.set(service.server, serverServices);
+  });
+
+  for (const [serverHostname, serverServices] of servicesByServer) {
+    await removePreviewFromServer(
+      previewConfig,
+      secrets,
+      serverHostname,
+      serverServices,
+      verboseFlag
+    );
+  }
+
+  if (config.dns) {
+    try {
+      const dnsClient = CloudflareDnsClient.fromConfig(config.dns, secrets);
+      for (const service of services) {
+        await dnsClient.deleteRecordsByComment(
+          getDnsRecordComment(previewConfig.name, service.name)
+        );
+      }
+    } catch (error) {
+      logger.warn(`Failed to remove DNS records: ${error}`);
+    }
+  }
+
+  logger.info(`Preview environment for ${branchSlug} removed`);
+}
+
+/**
+ * Main preview command that deploys or removes a branch preview environment
+ */
+export async function previewCommand(args: string[]): Promise<void> {
+  const parsedArgs = parsePreviewArgs(args);
+
+  const branchSlug = slugifyBranch(parsedArgs.branch || (await getCurrentBranch()));
+  if (!branchSlug) {
+    throw new Error(`Branch name "${parsedArgs.branch}" has no DNS-safe characters`);
+  }
+
+  if (parsedArgs.subcommand === "deploy") {
+    // deployCommand owns its logger, output and error handling
+    await deployCommand(parsedArgs.deployArgs, {
+      transformConfig: (config) => buildPreviewConfig(config, branchSlug),
+    });
+    return;
+  }
+
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+  try {
+    await previewRmSubcommand(branchSlug, parsedArgs.verboseFlag);
+  } finally {
+    logger.cleanup();
+  }
+}
@@ -1,10 +1,16 @@
-import { loadConfig, loadSecrets } from "../config";
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
 import { IopConfig, IopSecrets } from "../config/types";
 import { SSHClient, getSSHCredentials } from "../ssh";
 import { DockerClient } from "../docker";
 import { setupIopProxy, IOP_PROXY_NAME } from "../setup-proxy/index";
-import { IopProxyClient } from "../proxy";
+import { IopProxyClient, ProxyHostInfo } from "../proxy";
+import {
+  aggregateHosts,
+  findDuplicateHosts,
+  getProxyServers,
+} from "../proxy/cluster";
 import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
 import {
   checkProxyStatus,
   formatProxyStatus,
@@ -24,29 +30,10 @@ interface ParsedProxyArgs {
   subcommand: string;
   verboseFlag: boolean;
   host?: string;
+  server?: string;
   lines?: number;
 }
 
-/**
- * Converts object or array format configuration entries to a normalized array
- */
-function normalizeConfigEntries(
-  entries: Record<string, any> | Array<any> | undefined
-): Array<any> {
-  if (!entries) return [];
-
-  // If it's already an array, return it
-  if (Array.isArray(entries)) {
-    return entries;
-  }
-
-  // If it's an object, convert to array with name property
-  return Object.entries(entries).map(([name, entry]) => ({
-    ...entry,
-    name,
-  }));
-}
-
 /**
  * Parses command line arguments for proxy command
  */
@@ -54,6 +41,7 @@ function parseProxyArgs(args: string[]): ParsedProxyArgs {
   const verboseFlag = args.includes("--verbose");
   
   let host: string | undefined;
+  let server: string | undefined;
   let lines: number | undefined;
   
   const cleanArgs: string[] = [];
@@ -64,6 +52,9 @@ function parseProxyArgs(args: string[]): ParsedProxyArgs {
     } else if (args[i] === "--host" && i + 1 < args.length) {
       host = args[i + 1];
       i++; // Skip the next argument since it's the host value
+    } else if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++; // Skip the next argument since it's the server value
     } else if (args[i] === "--lines" && i + 1 < args.length) {
       lines = parseInt(args[i + 1], 10);
       i++; // Skip the next argument since it's the lines value
@@ -78,6 +69,7 @@ function parseProxyArgs(args: string[]): ParsedProxyArgs {
     subcommand,
     verboseFlag,
     host,
+    server,
     lines,
   };
 }
@@ -100,20 +92,11 @@ async function loadConfigurationAndSecrets(): Promise<{
 }
 
 /**
- * Collects all unique servers from apps and services configuration
+ * Collects all unique servers running a proxy: the ones services deploy to
+ * and the extra servers in proxy.servers
  */
 function collectAllServers(config: IopConfig): Set<string> {
-  const configuredServices = normalizeConfigEntries(config.services);
-  const allServers = new Set<string>();
-
-  // Add servers from services
-  configuredServices.forEach((service) => {
-    if (service.server) {
-      allServers.add(service.server);
-    }
-  });
-
-  return allServers;
+  return new Set(getProxyServers(config));
 }
 
 /**
@@ -286,6 +269,8 @@ async function proxyStatusSubcommand(context: ProxyContext): Promise<void> {
   }
 
   const proxyStatuses: ProxyStatus[] = [];
+  const updatesAvailable = new Map<string, boolean>();
+  const proxyHostsByServer = new Map<string, Record<string, ProxyHostInfo>>();
 
   for (const serverHostname of targetServers) {
     let sshClient: SSHClient | undefined;
@@ -312,11 +297,22 @@ async function proxyStatusSubcommand(context: ProxyContext): Promise<void> {
           proxyImage
         );
 
+        updatesAvailable.set(serverHostname, updateCheck.needsUpdate);
         if (updateCheck.needsUpdate) {
           logger.info(`Proxy on ${serverHostname} can be updated`);
         } else {
           logger.verboseLog(`Proxy on ${serverHostname} is up to date`);
         }
+
+        const proxyClient = new IopProxyClient(
+          new DockerClient(sshClient, serverHostname, context.verboseFlag),
+          serverHostname,
+          context.verboseFlag
+        );
+        const hosts = await proxyClient.getHosts();
+        if (hosts) {
+          proxyHostsByServer.set(serverHostname, hosts);
+        }
       }
     } catch (error) {
       logger.verboseLog(`Failed to check proxy on ${serverHostname}: ${error}`);
@@ -337,6 +333,28 @@ async function proxyStatusSubcommand(context: ProxyContext): Promise<void> {
   // Display status for all servers
   logger.phaseComplete("Proxy status check complete");
 
+  const hosts = aggregateHosts(proxyHostsByServer);
+  const duplicates = findDuplicateHosts(hosts);
+
+  if (isJsonOutput()) {
+    writeResult({
+      proxies: proxyStatuses.map((status) => ({
+        ...status,
+        updateAvailable: updatesAvailable.get(status.serverId) ?? null,
+        hosts: proxyHostsByServer.has(status.serverId)
+          ? Object.keys(proxyHostsByServer.get(status.serverId)!)
+          : null,
+      })),
+      duplicateHosts: Object.fromEntries(
+        Object.entries(duplicates).map(([host, registrations]) => [
+          host,
+          registrations.map(({ server, project, target }) => ({ server, project, target })),
+        ])
+      ),
+    });
+    return;
+  }
+
   console.log(`\nProxy Statuses (${proxyStatuses.length}):`);
 
   for (const status of proxyStatuses) {
@@ -344,8 +362,31 @@ async function proxyStatusSubcommand(context: ProxyContext): Promise<void> {
     for (const line of formattedLines) {
       console.log(line);
     }
+    const serverHosts = proxyHostsByServer.get(status.serverId);
+    if (serverHosts) {
+      console.log(`     ├─ Hosts: ${Object.keys(serverHosts).length} routed`);
+    }
     console.log(); // Add spacing between proxy statuses
   }
+
+  if (proxyHostsByServer.size > 1) {
+    console.log(
+      `Hosts: ${Object.keys(hosts).length} across ${proxyHostsByServer.size} proxies`
+    );
+  }
+
+  for (const [host, registrations] of Object.entries(duplicates)) {
+    logger.warn(
+      `${host} is registered on ${registrations.length} proxies: ${registrations
+        .map(({ server, project }) => `${server} (${project})`)
+        .join(", ")}`
+    );
+  }
+  if (Object.keys(duplicates).length > 0) {
+    console.log(
+      "DNS sends each host to one server. Remove the stale route with: iop proxy delete-host --host <host> --server <server>"
+    );
+  }
 }
 
 /**
@@ -428,7 +469,8 @@ async function proxyUpdateSubcommand(
  */
 async function proxyDeleteHostSubcommand(
   context: ProxyContext,
-  host: string
+  host: string,
+  server?: string
 ): Promise<void> {
   if (!host) {
     logger.error("Host is required for delete-host command. Use --host <hostname>");
@@ -437,7 +479,8 @@ async function proxyDeleteHostSubcommand(
 
   logger.phase(`Deleting host: ${host}`);
 
-  const targetServers = collectAllServers(context.config);
+  // --server removes a stale registration and leaves the other proxies alone
+  const targetServers = server ? new Set([server]) : collectAllServers(context.config);
 
   if (targetServers.size === 0) {
     logger.info("No servers found in configuration.");
@@ -581,12 +624,14 @@ function showProxyHelp(): void {
   console.log("FLAGS:");
   console.log("  --verbose       Show detailed output");
   console.log("  --host <host>   Target specific host (for delete-host)");
+  console.log("  --server <srv>  Only delete the host from this server's proxy (for delete-host)");
   console.log("  --lines <n>     Number of log lines to show (for logs, default: 50)");
   console.log("");
   console.log("EXAMPLES:");
   console.log("  iop proxy status                      # Check status on all servers");
   console.log("  iop proxy update --verbose            # Update proxy on all servers with details");
   console.log("  iop proxy delete-host --host api.example.com  # Remove a specific host");
+  console.log("  iop proxy delete-host --host api.example.com --server 10.0.0.2  # Remove a stale route");
   console.log("  iop proxy logs --lines 100            # Show last 100 log lines from all servers");
 }
 
@@ -637,7 +682,7 @@ export async function proxyCommand(args: string[]): Promise<void> {
           logger.error("Host is required for delete-host command. Use --host <hostname>");
           return;
         }
-        await proxyDeleteHostSubcommand(context, parsedArgs.host);
+        await proxyDeleteHostSubcommand(context, parsedArgs.host, parsedArgs.server);
         break;
       case "logs":
         await proxyLogsSubcommand(context, parsedArgs.lines || 50);
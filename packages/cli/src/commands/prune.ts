@@ -0,0 +1,230 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { Logger } from "../utils/logger";
+import { logProgress, writeResult } from "../utils/output";
+import {
+  executeGcPlan,
+  formatImage,
+  getImageRetention,
+  planServerGc,
+} from "../utils/image-gc";
+
+// Module-level logger that gets configured when the prune command runs
+let logger: Logger;
+
+interface PruneContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ServerPruneResult {
+  server: string;
+  containers: string[];
+  images: string[];
+  removedContainers?: number;
+  removedImages?: number;
+  failed?: string[];
+  error?: string;
+}
+
+interface ParsedPruneArgs {
+  dryRun: boolean;
+  retain?: number;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for prune command
+ */
+export function parsePruneArgs(args: string[]): ParsedPruneArgs {
+  const parsed: ParsedPruneArgs = {
+    dryRun: args.includes("--dry-run"),
+    verboseFlag: args.includes("--verbose"),
+  };
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--retain" && i + 1 < args.length) {
+      const retain = parseInt(args[i + 1], 10);
+      if (isNaN(retain) || retain < 0) {
+        throw new Error(`Invalid --retain value: ${args[i + 1]}`);
+      }
+      parsed.retain = retain;
+      i++;
+    } else if (args[i] === "--server" && i + 1 < args.length) {
+      parsed.server = args[i + 1];
+      i++;
+    }
+  }
+
+  return parsed;
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: PruneContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Plans and (unless dry-running) runs garbage collection on one server
+ */
+async function pruneServer(
+  context: PruneContext,
+  serverHostname: string,
+  services: ServiceEntry[],
+  retain: number,
+  dryRun: boolean
+): Promise<ServerPruneResult> {
+  const summary: ServerPruneResult = {
+    server: serverHostname,
+    containers: [],
+    images: [],
+  };
+  let sshClient: SSHClient | undefined;
+  try {
+    sshClient = await establishSSHConnection(serverHostname, context);
+    const dockerClient = new DockerClient(
+      sshClient,
+      serverHostname,
+      context.verboseFlag
+    );
+
+    const plan = await planServerGc(
+      dockerClient,
+      context.config.name,
+      services,
+      retain
+    );
+    summary.containers = plan.containers;
+    summary.images = plan.images.map(formatImage);
+
+    logProgress(`\n${serverHostname}`);
+    if (plan.containers.length === 0 && plan.images.length === 0) {
+      logProgress("   Nothing to remove");
+      return summary;
+    }
+
+    const verb = dryRun ? "Would remove" : "Removing";
+    plan.containers.forEach((container) =>
+      logProgress(`   ${verb} container ${container}`)
+    );
+    plan.images.forEach((image) =>
+      logProgress(`   ${verb} image ${formatImage(image)}`)
+    );
+    if (context.verboseFlag) {
+      plan.retained.forEach((image) =>
+        logProgress(`   Keeping image ${formatImage(image)}`)
+      );
+    }
+
+    if (dryRun) {
+      return summary;
+    }
+
+    const result = await executeGcPlan(dockerClient, plan);
+    summary.removedContainers = result.removedContainers;
+    summary.removedImages = result.removedImages;
+    summary.failed = result.failed;
+    logProgress(
+      `   Removed ${result.removedImages} images and ${result.removedContainers} containers`
+    );
+    if (result.failed.length > 0) {
+      logger.warn(
+        `Could not remove on ${serverHostname}: ${result.failed.join(", ")}`
+      );
+    }
+  } catch (error) {
+    summary.error = String(error);
+    logger.error(`Failed to prune ${serverHostname}`, error);
+  } finally {
+    if (sshClient) {
+      await sshClient.close();
+    }
+  }
+  return summary;
+}
+
+/**
+ * Main prune command
+ */
+export async function pruneCommand(args: string[]): Promise<void> {
+  const parsedArgs = parsePruneArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+    const context: PruneContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const retain = parsedArgs.retain ?? getImageRetention(config);
+    const servicesByServer = new Map<string, ServiceEntry[]>();
+    normalizeConfigEntries(config.services).forEach((service) => {
+      if (parsedArgs.server && service.server !== parsedArgs.server) {
+        return;
+      }
+      const services = servicesByServer.get(service.server) || [];
+      services.push(service);
+      servicesByServer.set(service.server, services);
+    });
+
+    if (servicesByServer.size === 0) {
+      logger.error(
+        parsedArgs.server
+          ? `No services are configured on ${parsedArgs.server}`
+          : "No services found in configuration"
+      );
+      return;
+    }
+
+    if (parsedArgs.dryRun) {
+      logProgress("Dry run - nothing will be removed");
+    }
+
+    const results: ServerPruneResult[] = [];
+    for (const [serverHostname, services] of servicesByServer) {
+      results.push(
+        await pruneServer(
+          context,
+          serverHostname,
+          services,
+          retain,
+          parsedArgs.dryRun
+        )
+      );
+    }
+
+    writeResult({ dryRun: parsedArgs.dryRun, retain, servers: results });
+  } finally {
+    logger.cleanup();
+  }
+}
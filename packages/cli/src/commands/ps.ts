@@ -0,0 +1,361 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IOP_PROXY_NAME } from "../setup-proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+
+// Module-level logger that gets configured when the ps command runs
+let logger: Logger;
+
+interface PsContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedPsArgs {
+  all: boolean;
+  orphans: boolean;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+export interface ContainerInventoryEntry {
+  name: string;
+  image: string;
+  project?: string;
+  type: string; // app, service, sidecar, init, proxy or registry
+  entry?: string; // App or service name from iop.yml
+  color?: string;
+  sidecarOf?: string; // App container a sidecar belongs to
+  state: string;
+  uptime: string | null;
+  restarts: number;
+  ports: string[];
+  networks: string[];
+  aliases: string[];
+  orphan?: string; // Why the container isn't referenced by the current state
+}
+
+interface ServerInventory {
+  server: string;
+  containers: ContainerInventoryEntry[];
+  error?: string;
+}
+
+/**
+ * Parses command line arguments for ps command
+ */
+export function parsePsArgs(args: string[]): ParsedPsArgs {
+  let server: string | undefined;
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++;
+    }
+  }
+
+  return {
+    all: args.includes("--all"),
+    orphans: args.includes("--orphans"),
+    server,
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Formats a duration in a human-readable way, e.g. "2h 15m" or "3d 4h"
+ */
+export function formatUptime(uptimeMs: number): string {
+  const seconds = Math.max(0, Math.floor(uptimeMs / 1000));
+  const minutes = Math.floor(seconds / 60);
+  const hours = Math.floor(minutes / 60);
+  const days = Math.floor(hours / 24);
+
+  if (days > 0) {
+    return `${days}d ${hours % 24}h`;
+  } else if (hours > 0) {
+    return `${hours}h ${minutes % 60}m`;
+  } else if (minutes > 0) {
+    return `${minutes}m`;
+  }
+  return `${seconds}s`;
+}
+
+/**
+ * Turns docker inspect output for one container into an inventory entry
+ */
+export function toInventoryEntry(container: any, now: number = Date.now()): ContainerInventoryEntry {
+  const name = String(container.Name || "").replace(/^\//, "");
+  const labels: Record<string, string> = container.Config?.Labels || {};
+
+  let type = labels["iop.type"] || "unknown";
+  if (name === IOP_PROXY_NAME) {
+    type = "proxy";
+  } else if (labels["iop.app"]) {
+    type = "app";
+  } else if (labels["iop.registry.fingerprint"]) {
+    type = "registry";
+  }
+
+  const ports: string[] = [];
+  for (const [containerPort, bindings] of Object.entries(
+    container.NetworkSettings?.Ports || {}
+  )) {
+    if (Array.isArray(bindings)) {
+      for (const binding of bindings) {
+        const host = binding.HostIp && binding.HostIp !== "0.0.0.0" ? `${binding.HostIp}:` : "";
+        ports.push(`${host}${binding.HostPort}->${containerPort}`);
+      }
+    }
+  }
+  const uniquePorts = Array.from(new Set(ports));
+
+  const networks = container.NetworkSettings?.Networks || {};
+  const aliases = new Set<string>();
+  for (const network of Object.values(networks) as any[]) {
+    for (const alias of network?.Aliases || []) {
+      aliases.add(alias);
+    }
+  }
+
+  const running = container.State?.Running === true;
+  const startedAt = Date.parse(container.State?.StartedAt || "");
+
+  return {
+    name,
+    image: container.Config?.Image || "",
+    project: labels["iop.project"],
+    type,
+    entry: labels["iop.app"] || labels["iop.service"] || labels["iop.sidecar"],
+    color: labels["iop.color"],
+    sidecarOf: labels["iop.sidecar-of"],
+    state: container.State?.Status || "unknown",
+    uptime: running && !isNaN(startedAt) ? formatUptime(now - startedAt) : null,
+    restarts: container.RestartCount || 0,
+    ports: uniquePorts,
+    networks: Object.keys(networks).sort(),
+    aliases: Array.from(aliases).sort(),
+  };
+}
+
+/**
+ * Flags the project's containers that the current state doesn't reference:
+ * apps and services no longer in iop.yml for this server, blue-green
+ * containers of the color traffic was switched away from, sidecars whose app
+ * container is gone and init containers left behind by an aborted deploy.
+ * Containers of other projects are left alone since their config isn't known.
+ */
+export function findOrphans(
+  containers: ContainerInventoryEntry[],
+  projectName: string,
+  services: ServiceEntry[]
+): ContainerInventoryEntry[] {
+  const configured = new Set(services.map((service) => service.name));
+  const names = new Set(containers.map((container) => container.name));
+
+  return containers.map((container) => {
+    if (container.project !== projectName) {
+      return container;
+    }
+
+    let orphan: string | undefined;
+    switch (container.type) {
+      case "app": {
+        if (!container.entry || !configured.has(container.entry)) {
+          orphan = "app not in iop.yml";
+          break;
+        }
+        // The active color carries the app name as its network alias
+        const activeColor = containers.find(
+          (other) =>
+            other.project === projectName &&
+            other.type === "app" &&
+            other.entry === container.entry &&
+            other.aliases.includes(container.entry!)
+        )?.color;
+        if (activeColor && container.color !== activeColor) {
+          orphan = `inactive ${container.color} color`;
+        }
+        break;
+      }
+      case "service":
+        if (!container.entry || !configured.has(container.entry)) {
+          orphan = "service not in iop.yml";
+        }
+        break;
+      case "sidecar":
+        if (!container.sidecarOf || !names.has(container.sidecarOf)) {
+          orphan = "app container is gone";
+        }
+        break;
+      case "init":
+        if (container.state !== "running") {
+          orphan = "leftover init container";
+        }
+        break;
+    }
+
+    return orphan ? { ...container, orphan } : container;
+  });
+}
+
+/**
+ * Formats the inventory of one server as an aligned table
+ */
+export function formatPsTable(containers: ContainerInventoryEntry[]): string[] {
+  const rows = containers.map((c) => [
+    c.name,
+    c.image,
+    c.color || "-",
+    c.state,
+    c.uptime || "-",
+    String(c.restarts),
+    c.ports.length > 0 ? c.ports.join(",") : "-",
+    c.networks.length > 0 ? c.networks.join(",") : "-",
+    c.orphan ? `orphan: ${c.orphan}` : "",
+  ]);
+
+  const header = ["NAME", "IMAGE", "COLOR", "STATE", "UPTIME", "RESTARTS", "PORTS", "NETWORK", ""];
+  const widths = header.map((title, column) =>
+    Math.max(title.length, ...rows.map((row) => row[column].length))
+  );
+
+  return [header, ...rows].map((row) =>
+    row
+      .map((cell, column) => (column === row.length - 1 ? cell : cell.padEnd(widths[column])))
+      .join("  ")
+      .trimEnd()
+  );
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: PsContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Reads the container inventory of one server
+ */
+async function inventoryServer(
+  context: PsContext,
+  serverHostname: string,
+  services: ServiceEntry[],
+  parsedArgs: ParsedPsArgs
+): Promise<ServerInventory> {
+  let sshClient: SSHClient | undefined;
+  try {
+    sshClient = await establishSSHConnection(serverHostname, context);
+    const dockerClient = new DockerClient(sshClient, serverHostname, context.verboseFlag);
+
+    const now = Date.now();
+    const inventory = (await dockerClient.inspectManagedContainers()).map((container) =>
+      toInventoryEntry(container, now)
+    );
+    let containers = findOrphans(inventory, context.config.name, services).filter(
+      (container) =>
+        parsedArgs.all || container.type === "proxy" || container.project === context.config.name
+    );
+    if (parsedArgs.orphans) {
+      containers = containers.filter((container) => container.orphan);
+    }
+    containers.sort((a, b) => a.name.localeCompare(b.name));
+
+    return { server: serverHostname, containers };
+  } catch (error) {
+    logger.error(`Failed to list containers on ${serverHostname}`, error);
+    return { server: serverHostname, containers: [], error: String(error) };
+  } finally {
+    if (sshClient) {
+      await sshClient.close();
+    }
+  }
+}
+
+/**
+ * Lists the iop-managed containers on every server in the configuration
+ */
+export async function psCommand(args: string[]): Promise<void> {
+  const parsedArgs = parsePsArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+    const context: PsContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const services: ServiceEntry[] = normalizeConfigEntries(config.services);
+    const servers = parsedArgs.server
+      ? [parsedArgs.server]
+      : Array.from(new Set(services.map((service) => service.server)));
+
+    const results: ServerInventory[] = [];
+    for (const serverHostname of servers) {
+      const result = await inventoryServer(
+        context,
+        serverHostname,
+        services.filter((service) => service.server === serverHostname),
+        parsedArgs
+      );
+      results.push(result);
+
+      if (!isJsonOutput()) {
+        console.log(`\n=== ${serverHostname} ===`);
+        if (result.error) {
+          console.log("Could not list containers");
+        } else if (result.containers.length === 0) {
+          console.log(parsedArgs.orphans ? "No orphaned containers" : "No containers");
+        } else {
+          formatPsTable(result.containers).forEach((line) => console.log(line));
+        }
+      }
+    }
+
+    const orphans = results.reduce(
+      (count, result) => count + result.containers.filter((c) => c.orphan).length,
+      0
+    );
+    if (orphans > 0 && !isJsonOutput()) {
+      console.log(
+        `\n${orphans} orphaned container${orphans === 1 ? "" : "s"}. Stopped blue-green leftovers are removed by 'iop prune'.`
+      );
+    }
+
+    writeResult({ servers: results });
+    if (results.some((result) => result.error)) {
+      process.exitCode = 1;
+    }
+  } finally {
+    logger.cleanup();
+  }
+}
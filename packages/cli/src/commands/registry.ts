@@ -0,0 +1,224 @@
+import { spawnSync } from "child_process";
+import { loadConfig, loadSecrets } from "../config";
+import { IopConfig, IopSecrets } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient } from "../proxy";
+import { setupIopProxy, IOP_PROXY_NAME } from "../setup-proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, progressStream, writeResult } from "../utils/output";
+import {
+  REGISTRY_CONTAINER,
+  ensureBuiltinRegistry,
+  getRegistryPassword,
+  getRegistryServer,
+} from "../utils/builtin-registry";
+
+// Module-level logger that gets configured when registry commands run
+let logger: Logger;
+
+interface RegistryContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  server: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Establishes SSH connection to the registry's server
+ */
+async function establishSSHConnection(
+  context: RegistryContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    context.server,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: context.server,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Setup subcommand - starts the registry and routes it, without deploying
+ */
+async function registrySetupSubcommand(context: RegistryContext): Promise<void> {
+  const host = context.config.registry!.host;
+  logger.server(context.server);
+  const sshClient = await establishSSHConnection(context);
+  try {
+    const dockerClient = new DockerClient(
+      sshClient,
+      context.server,
+      context.verboseFlag
+    );
+    if (!(await dockerClient.containerIsRunning(IOP_PROXY_NAME))) {
+      if (!(await setupIopProxy(context.server, sshClient, context.verboseFlag))) {
+        throw new Error(`Failed to set up ${IOP_PROXY_NAME} on ${context.server}`);
+      }
+    }
+
+    const proxyClient = new IopProxyClient(
+      dockerClient,
+      context.server,
+      context.verboseFlag
+    );
+    const created = await ensureBuiltinRegistry(
+      sshClient,
+      dockerClient,
+      proxyClient,
+      context.config,
+      context.secrets
+    );
+    logger.serverStepComplete(
+      created ? `Started registry at ${host}` : `Registry at ${host} is up to date`
+    );
+    writeResult({ server: context.server, host, created });
+  } finally {
+    await sshClient.close();
+  }
+}
+
+/**
+ * Login subcommand - logs the local Docker client in to the registry
+ */
+function registryLoginSubcommand(context: RegistryContext): void {
+  const registry = context.config.registry!;
+  const password = getRegistryPassword(registry, context.secrets);
+
+  const result = spawnSync(
+    "docker",
+    ["login", registry.host, "--username", registry.username, "--password-stdin"],
+    { input: password, stdio: ["pipe", progressStream(), "inherit"] }
+  );
+  if (result.error || result.status !== 0) {
+    throw new Error(
+      `docker login ${registry.host} failed${result.error ? `: ${result.error.message}` : ""}`
+    );
+  }
+  writeResult({ host: registry.host, username: registry.username });
+}
+
+/**
+ * Status subcommand - shows whether the registry container is running
+ */
+async function registryStatusSubcommand(context: RegistryContext): Promise<void> {
+  const host = context.config.registry!.host;
+  const sshClient = await establishSSHConnection(context);
+  try {
+    const dockerClient = new DockerClient(
+      sshClient,
+      context.server,
+      context.verboseFlag
+    );
+    const running = await dockerClient.containerIsRunning(REGISTRY_CONTAINER);
+    const size = running
+      ? (
+          await sshClient.exec(
+            `docker exec ${REGISTRY_CONTAINER} du -sh /var/lib/registry 2>/dev/null | cut -f1 || true`
+          )
+        ).trim()
+      : "";
+
+    if (isJsonOutput()) {
+      writeResult({ host, server: context.server, running, size: size || null });
+      return;
+    }
+    console.log(`Registry:  https://${host}`);
+    console.log(`Server:    ${context.server}`);
+    console.log(`Status:    ${running ? "running" : "not running"}`);
+    if (size) {
+      console.log(`Storage:   ${size}`);
+    }
+  } finally {
+    await sshClient.close();
+  }
+}
+
+/**
+ * Shows help for registry command
+ */
+function showRegistryHelp(): void {
+  console.log("IOP Registry");
+  console.log("============");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop registry <subcommand> [flags]");
+  console.log("");
+  console.log("DESCRIPTION:");
+  console.log("  Runs the private registry configured in the registry section of iop.yml.");
+  console.log("  Deploy sets it up too, so setup is only needed before the first push.");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  setup    Start the registry and route its host through the proxy");
+  console.log("  login    Log the local Docker client in to the registry");
+  console.log("  status   Show whether the registry is running");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --verbose        Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop registry setup && iop registry login");
+  console.log("  docker push registry.example.com/web:1.0");
+}
+
+/**
+ * Main registry command that handles subcommands
+ */
+export async function registryCommand(args: string[]): Promise<void> {
+  const verboseFlag = args.includes("--verbose");
+  const subcommand = args.filter((arg) => !arg.startsWith("--"))[0] || "";
+
+  if (!["setup", "login", "status"].includes(subcommand)) {
+    showRegistryHelp();
+    return;
+  }
+
+  logger = new Logger({ verbose: verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets(true);
+
+    if (!config.registry) {
+      throw new Error(
+        "No registry configured. Add a registry section with a host to iop.yml."
+      );
+    }
+    const server = getRegistryServer(config);
+    if (!server) {
+      throw new Error("No server to run the registry on, set registry.server");
+    }
+
+    const context: RegistryContext = { config, secrets, server, verboseFlag };
+
+    switch (subcommand) {
+      case "setup":
+        await registrySetupSubcommand(context);
+        break;
+      case "login":
+        registryLoginSubcommand(context);
+        break;
+      case "status":
+        await registryStatusSubcommand(context);
+        break;
+    }
+  } catch (error) {
+    logger.error("Registry command failed", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
@@ -1,12 +1,16 @@
-import { loadConfig, loadSecrets } from "../config";
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
 import {
   IopConfig,
   ServiceEntry,
   IopSecrets,
 } from "../config/types";
-import { DockerClient } from "../docker";
+import { ContainerLimits, DockerClient } from "../docker";
 import { SSHClient, getSSHCredentials, SSHClientOptions } from "../ssh";
+import { IopProxyClient, ProxyHostInfo, ProxyQuotaEntry } from "../proxy";
 import { Logger } from "../utils/logger";
+import { DeployMetadata, formatDeployMetadata } from "../utils/deploy-metadata";
+import { ScanCounts, formatScanCounts } from "../utils/image-scan";
+import { isJsonOutput, writeError, writeResult } from "../utils/output";
 import {
   checkProxyStatus,
   formatProxyStatus,
@@ -29,6 +33,25 @@ interface ParsedStatusArgs {
   verboseFlag: boolean;
 }
 
+export interface HostStatus {
+  host: string;
+  healthy: boolean | null; // null when the proxy doesn't know the host
+  certificate?: {
+    status: string;
+    expiresAt?: string;
+  };
+}
+
+export interface StatusRow {
+  service: string;
+  color: string;
+  image: string;
+  replicas: string;
+  health: string;
+  cert: string;
+  deployed: string;
+}
+
 interface EntryStatus {
   name: string;
   type: "app" | "service";
@@ -43,15 +66,22 @@ interface EntryStatus {
   };
   lastDeployed?: string;
   servers: string[];
+  imageTag?: string;
+  hosts?: HostStatus[];
   // Basic info (always included)
   uptime?: string;
   resourceUsage?: {
     cpu: string;
     memory: string;
+    pids?: string;
+    limits?: string[];
   };
   // Additional info (always included)
   additionalInfo?: {
     exactImage: string;
+    imageDigest?: string;
+    deployMetadata?: DeployMetadata;
+    vulnerabilities?: ScanCounts; // From the image's scan at deploy time
     restartCount: number;
     exitCode?: number;
     ports: string[];
@@ -69,6 +99,8 @@ interface ServerEntryStatus {
   greenContainers?: string[];
   runningContainers: string[];
   totalContainers: string[];
+  // Hosts routed by the proxy on this server, null if it couldn't be queried
+  proxyHosts?: Record<string, ProxyHostInfo> | null;
   // Container details for running containers
   containerDetails?: Record<
     string,
@@ -78,8 +110,13 @@ interface ServerEntryStatus {
         cpuPercent: string;
         memoryUsage: string;
         memoryPercent: string;
+        pids: string;
       } | null;
+      limits: ContainerLimits;
       image: string | null;
+      imageDigest: string | null;
+      deployMetadata: DeployMetadata;
+      scanCounts: ScanCounts | null;
       createdAt: string | null;
       restartCount: number;
       exitCode: number | null;
@@ -132,6 +169,11 @@ interface ServerServiceStatus {
 
 interface ProxyStatusSummary {
   proxyStatuses: ProxyStatus[];
+  quotas: ServerQuotaStatus[];
+}
+
+interface ServerQuotaStatus extends ProxyQuotaEntry {
+  server: string;
 }
 
 /**
@@ -185,17 +227,6 @@ async function loadConfigurationAndSecrets(): Promise<{
   }
 }
 
-/**
- * Normalizes configuration entries from object/array format to array format
- */
-function normalizeConfigEntries(
-  entries: Record<string, any> | Array<any> | undefined
-): Array<any> {
-  if (!entries) return [];
-  if (Array.isArray(entries)) return entries;
-  return Object.entries(entries).map(([name, entry]) => ({ ...entry, name }));
-}
-
 /**
  * Establishes SSH connection to a server for status checking
  */
@@ -291,6 +322,7 @@ async function getProxyStatusSummary(
   context: StatusContext
 ): Promise<ProxyStatusSummary> {
   const proxyStatuses: ProxyStatus[] = [];
+  const quotas: ServerQuotaStatus[] = [];
 
   // Get unique servers from all services
   const allServers = new Set<string>();
@@ -302,7 +334,7 @@ async function getProxyStatusSummary(
   services.forEach((service) => allServers.add(service.server));
 
   if (allServers.size === 0) {
-    return { proxyStatuses: [] };
+    return { proxyStatuses: [], quotas: [] };
   }
 
   for (const serverHostname of allServers) {
@@ -324,6 +356,18 @@ async function getProxyStatusSummary(
       );
 
       proxyStatuses.push(proxyStatus);
+
+      if (proxyStatus.running) {
+        const proxyClient = new IopProxyClient(
+          new DockerClient(sshClient, serverHostname, context.verboseFlag),
+          serverHostname,
+          context.verboseFlag
+        );
+        const quota = await proxyClient.getQuota(context.projectName);
+        if (quota) {
+          quotas.push({ server: serverHostname, ...quota });
+        }
+      }
     } catch (error) {
       if (context.verboseFlag) {
         context.verboseMessages.push(
@@ -346,7 +390,7 @@ async function getProxyStatusSummary(
     }
   }
 
-  return { proxyStatuses };
+  return { proxyStatuses, quotas };
 }
 
 /**
@@ -385,6 +429,15 @@ async function getEntryStatusOnServer(
       containerInfo.activeColor = activeColor;
     }
 
+    if (entry.proxy?.hosts?.length) {
+      const proxyClient = new IopProxyClient(
+        dockerClient,
+        serverHostname,
+        context.verboseFlag
+      );
+      containerInfo.proxyHosts = await proxyClient.getHosts();
+    }
+
     return containerInfo;
   } catch (error) {
     // Store verbose message instead of logging immediately
@@ -423,9 +476,13 @@ function aggregateEntryStatus(
   activeColor: "blue" | "green" | null;
   status: "running" | "stopped" | "mixed" | "unknown";
   uptime: string | null;
-  resourceUsage: { cpu: string; memory: string } | null;
+  lastDeployed: string | null;
+  resourceUsage: EntryStatus["resourceUsage"] | null;
   additionalInfo: {
     exactImage: string;
+    imageDigest?: string;
+    deployMetadata?: DeployMetadata;
+    vulnerabilities?: ScanCounts; // From the image's scan at deploy time
     restartCount: number;
     exitCode?: number;
     ports: string[];
@@ -442,7 +499,8 @@ function aggregateEntryStatus(
   let totalGreen = 0;
   let activeColors: ("blue" | "green" | null)[] = [];
   let uptime: string | null = null;
-  let resourceUsage: { cpu: string; memory: string } | null = null;
+  let lastDeployed: string | null = null;
+  let resourceUsage: EntryStatus["resourceUsage"] | null = null;
   let additionalInfo: any = null;
 
   for (const serverStatus of serverStatuses) {
@@ -473,16 +531,22 @@ function aggregateEntryStatus(
 
       if (containerDetail) {
         uptime = containerDetail.uptime;
+        lastDeployed = containerDetail.createdAt;
         if (containerDetail.stats) {
           resourceUsage = {
             cpu: containerDetail.stats.cpuPercent,
             memory: containerDetail.stats.memoryUsage,
+            pids: containerDetail.stats.pids,
+            limits: formatResourceLimits(containerDetail.limits),
           };
         }
 
         // Extract additional info
         additionalInfo = {
           exactImage: containerDetail.image || "",
+          imageDigest: containerDetail.imageDigest || undefined,
+          deployMetadata: containerDetail.deployMetadata,
+          vulnerabilities: containerDetail.scanCounts || undefined,
           restartCount: containerDetail.restartCount,
           exitCode: containerDetail.exitCode,
           ports: containerDetail.ports,
@@ -522,11 +586,108 @@ function aggregateEntryStatus(
     activeColor,
     status,
     uptime,
+    lastDeployed,
     resourceUsage,
     additionalInfo,
   };
 }
 
+/**
+ * Extracts the tag from an image reference, e.g. "registry:5000/blog:abc123" -> "abc123"
+ */
+export function getImageTag(image: string): string {
+  const name = image.split("@")[0];
+  const lastColon = name.lastIndexOf(":");
+  if (lastColon === -1 || lastColon < name.lastIndexOf("/")) {
+    return "latest";
+  }
+  return name.substring(lastColon + 1);
+}
+
+/**
+ * Formats a byte count the way docker stats does, e.g. 512MiB
+ */
+function formatBytes(bytes: number): string {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return `${Number.isInteger(value) ? value : value.toFixed(1)}${units[unit]}`;
+}
+
+/**
+ * Describes a container's resource limits, e.g. ["0.5 CPUs", "512MiB memory"]
+ */
+export function formatResourceLimits(limits: ContainerLimits): string[] {
+  const parts: string[] = [];
+  if (limits.cpus !== undefined) {
+    parts.push(`${limits.cpus} CPU${limits.cpus === 1 ? "" : "s"}`);
+  }
+  if (limits.cpuShares !== undefined) {
+    parts.push(`${limits.cpuShares} CPU shares`);
+  }
+  if (limits.memory !== undefined) {
+    parts.push(`${formatBytes(limits.memory)} memory`);
+  }
+  if (limits.memoryReservation !== undefined) {
+    parts.push(`${formatBytes(limits.memoryReservation)} reserved`);
+  }
+  if (limits.pidsLimit !== undefined) {
+    parts.push(`${limits.pidsLimit} processes`);
+  }
+  return parts;
+}
+
+/**
+ * Describes what a project uses of its quota on a server, e.g.
+ * "3/10 containers, 1.5GiB/4GiB memory, 1.5/2 CPUs"
+ */
+export function formatQuotaUsage(entry: ProxyQuotaEntry): string {
+  const usage = entry.usage || { project: entry.project, containers: 0, memory: 0, cpus: 0 };
+  const quota = entry.quota || { project: entry.project };
+  const parts = [
+    `${usage.containers}${quota.max_containers ? `/${quota.max_containers}` : ""} containers`,
+    `${formatBytes(usage.memory)}${quota.max_memory ? `/${formatBytes(quota.max_memory)}` : ""} memory`,
+    `${Math.round(usage.cpus * 1000) / 1000}${quota.max_cpus ? `/${quota.max_cpus}` : ""} CPUs`,
+  ];
+  if (usage.unlimited) {
+    parts.push(`${usage.unlimited} without limits`);
+  }
+  return parts.join(", ");
+}
+
+/**
+ * Looks up the proxy's view of each of an entry's hosts
+ */
+function getHostStatuses(
+  entry: ServiceEntry,
+  serverStatuses: ServerEntryStatus[]
+): HostStatus[] {
+  return (entry.proxy?.hosts || []).map((host) => {
+    const info = serverStatuses
+      .map((serverStatus) => serverStatus.proxyHosts?.[host])
+      .find((hostInfo) => hostInfo !== undefined);
+
+    if (!info) {
+      return { host, healthy: null };
+    }
+
+    return {
+      host,
+      healthy: info.healthy,
+      certificate: info.certificate
+        ? {
+            status: info.certificate.status,
+            expiresAt: info.certificate.expires_at,
+          }
+        : undefined,
+    };
+  });
+}
+
 /**
  * Gets comprehensive status for any entry (app or service) across all its servers
  */
@@ -555,6 +716,8 @@ async function getEntryStatus(
       running: aggregated.totalRunning,
     },
     servers: [entry.server],
+    lastDeployed: aggregated.lastDeployed || undefined,
+    hosts: getHostStatuses(entry, serverStatuses),
     uptime: aggregated.uptime || undefined,
     resourceUsage: aggregated.resourceUsage || undefined,
   };
@@ -570,6 +733,9 @@ async function getEntryStatus(
   // Add additional info
   if (aggregated.additionalInfo) {
     baseStatus.additionalInfo = aggregated.additionalInfo;
+    if (aggregated.additionalInfo.exactImage) {
+      baseStatus.imageTag = getImageTag(aggregated.additionalInfo.exactImage);
+    }
   }
 
   return baseStatus;
@@ -616,15 +782,39 @@ function displayEntryStatus(entryStatus: EntryStatus): void {
   }
 
   if (entryStatus.resourceUsage) {
+    const usage = entryStatus.resourceUsage;
+    const lines = [`CPU: ${usage.cpu}`, `Memory: ${usage.memory}`];
+    if (usage.pids) {
+      lines.push(`Processes: ${usage.pids}`);
+    }
+    if (usage.limits && usage.limits.length > 0) {
+      lines.push(`Limits: ${usage.limits.join(", ")}`);
+    }
+
     console.log(`     ├─ Resources:`);
-    console.log(`     │  ├─ CPU: ${entryStatus.resourceUsage.cpu}`);
-    console.log(`     │  └─ Memory: ${entryStatus.resourceUsage.memory}`);
+    lines.forEach((line, index) => {
+      const branch = index === lines.length - 1 ? "└─" : "├─";
+      console.log(`     │  ${branch} ${line}`);
+    });
   }
 
   // Show additional info (always included)
   if (entryStatus.additionalInfo) {
     const info = entryStatus.additionalInfo;
     console.log(`     ├─ Image: ${info.exactImage}`);
+    if (info.imageDigest) {
+      console.log(`     ├─ Digest: ${info.imageDigest}`);
+    }
+    const deployment = formatDeployMetadata(info.deployMetadata || {});
+    if (deployment) {
+      console.log(`     ├─ Deployed: ${deployment}`);
+    }
+    if (info.deployMetadata?.ci_url) {
+      console.log(`     ├─ CI: ${info.deployMetadata.ci_url}`);
+    }
+    if (info.vulnerabilities) {
+      console.log(`     ├─ Vulnerabilities: ${formatScanCounts(info.vulnerabilities)}`);
+    }
 
     if (info.restartCount > 0) {
       console.log(`     ├─ Restarts: ${info.restartCount}`);
@@ -678,6 +868,98 @@ function displayEntryStatus(entryStatus: EntryStatus): void {
   console.log(); // Add spacing between entries
 }
 
+/**
+ * Formats a timestamp as a short age, e.g. "5m ago" or "3d ago"
+ */
+export function formatAge(timestamp: string, now: Date = new Date()): string {
+  const seconds = Math.floor((now.getTime() - new Date(timestamp).getTime()) / 1000);
+  if (isNaN(seconds)) return "-";
+  if (seconds < 60) return "just now";
+  if (seconds < 3600) return `${Math.floor(seconds / 60)}m ago`;
+  if (seconds < 86400) return `${Math.floor(seconds / 3600)}h ago`;
+  return `${Math.floor(seconds / 86400)}d ago`;
+}
+
+/**
+ * Summarizes health across an entry's hosts, falling back to container state
+ * for entries the proxy doesn't route
+ */
+function describeHealth(entryStatus: EntryStatus): string {
+  const known = (entryStatus.hosts || []).filter((host) => host.healthy !== null);
+  if (known.length === 0) {
+    return entryStatus.hosts?.length ? "not routed" : entryStatus.status;
+  }
+
+  const healthy = known.filter((host) => host.healthy).length;
+  if (healthy === known.length) return "healthy";
+  if (healthy === 0) return "unhealthy";
+  return `degraded (${healthy}/${known.length})`;
+}
+
+/**
+ * Summarizes certificates across an entry's hosts. Any certificate that isn't
+ * active wins, otherwise the earliest expiry is shown.
+ */
+function describeCertificates(entryStatus: EntryStatus): string {
+  const certificates = (entryStatus.hosts || [])
+    .map((host) => host.certificate)
+    .filter((certificate) => certificate !== undefined);
+  if (certificates.length === 0) return "-";
+
+  const problem = certificates.find((certificate) => certificate!.status !== "active");
+  if (problem) return problem.status;
+
+  const expiries = certificates
+    .map((certificate) => certificate!.expiresAt)
+    .filter((expiresAt): expiresAt is string => !!expiresAt)
+    .sort();
+  return expiries.length > 0 ? `active (exp ${expiries[0].substring(0, 10)})` : "active";
+}
+
+/**
+ * Summarizes an entry's status as a row of the overview table
+ */
+export function buildStatusRow(entryStatus: EntryStatus, now: Date = new Date()): StatusRow {
+  return {
+    service: entryStatus.name,
+    color: entryStatus.activeColor || "-",
+    image: entryStatus.imageTag || "-",
+    replicas: `${entryStatus.replicas.running}/${entryStatus.replicas.total}`,
+    health: describeHealth(entryStatus),
+    cert: describeCertificates(entryStatus),
+    deployed: entryStatus.lastDeployed ? formatAge(entryStatus.lastDeployed, now) : "-",
+  };
+}
+
+/**
+ * Lays out status rows as an aligned text table
+ */
+export function formatStatusTable(rows: StatusRow[]): string[] {
+  const columns: Array<[keyof StatusRow, string]> = [
+    ["service", "SERVICE"],
+    ["color", "COLOR"],
+    ["image", "IMAGE"],
+    ["replicas", "REPLICAS"],
+    ["health", "HEALTH"],
+    ["cert", "CERT"],
+    ["deployed", "DEPLOYED"],
+  ];
+  const widths = columns.map(([key, title]) =>
+    Math.max(title.length, ...rows.map((row) => row[key].length))
+  );
+
+  const formatLine = (values: string[]) =>
+    values
+      .map((value, i) => value.padEnd(widths[i]))
+      .join("  ")
+      .trimEnd();
+
+  return [
+    formatLine(columns.map(([, title]) => title)),
+    ...rows.map((row) => formatLine(columns.map(([key]) => row[key]))),
+  ];
+}
+
 /**
  * Filters apps and services based on requested entry names
  */
@@ -765,6 +1047,16 @@ async function checkAndDisplayStatus(
     getProxyStatusSummary(context),
   ]);
 
+  if (isJsonOutput()) {
+    writeResult({
+      project: context.projectName,
+      services: serviceStatuses,
+      proxies: proxyStatusSummary.proxyStatuses,
+      quotas: proxyStatusSummary.quotas,
+    });
+    return;
+  }
+
   // Complete the checking phase before displaying results
   logger.phaseComplete("Checking deployment status");
 
@@ -775,9 +1067,21 @@ async function checkAndDisplayStatus(
     }
   }
 
-  // Now display the collected results
-  displayCollectedEntryStatuses(filteredServices, serviceStatuses, "Services");
+  // Now display the collected results: an overview table, plus the full
+  // breakdown when specific entries were asked for
+  console.log();
+  for (const line of formatStatusTable(
+    serviceStatuses.map((entryStatus) => buildStatusRow(entryStatus))
+  )) {
+    console.log(line);
+  }
+  console.log();
+
+  if (parsedArgs.entryNames.length > 0) {
+    displayCollectedEntryStatuses(filteredServices, serviceStatuses, "Services");
+  }
   displayProxyStatus(proxyStatusSummary);
+  displayQuotas(proxyStatusSummary.quotas);
 }
 
 /**
@@ -815,6 +1119,22 @@ function displayProxyStatus(proxyStatusSummary: ProxyStatusSummary): void {
   }
 }
 
+/**
+ * Displays the project's quota usage on each server that sets it a quota
+ */
+function displayQuotas(quotas: ServerQuotaStatus[]): void {
+  const limited = quotas.filter((entry) => entry.quota);
+  if (limited.length === 0) {
+    return;
+  }
+
+  console.log(`Quotas (${limited.length}):`);
+  for (const entry of limited) {
+    console.log(`  ${entry.server}: ${formatQuotaUsage(entry)}`);
+  }
+  console.log();
+}
+
 /**
  * Displays proxy status information for a single proxy status
  */
@@ -839,7 +1159,9 @@ export async function statusCommand(
     // Initialize logger with verbose flag
     logger = new Logger({ verbose: parsedArgs.verboseFlag });
 
-    logger.phase("Checking deployment status");
+    if (!isJsonOutput()) {
+      logger.phase("Checking deployment status");
+    }
 
     // Load configuration and secrets
     const { config, secrets } = await loadConfigurationAndSecrets();
@@ -855,8 +1177,11 @@ export async function statusCommand(
     // Check and display status (this will complete the phase internally)
     await checkAndDisplayStatus(parsedArgs, context);
 
-    console.log("[✓] Status check complete!");
+    if (!isJsonOutput()) {
+      console.log("[✓] Status check complete!");
+    }
   } catch (error) {
+    writeError(error);
     logger.error("Failed to get status", error);
     process.exit(1);
   } finally {
@@ -0,0 +1,459 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import {
+  IopProxyClient,
+  LATENCY_BOUNDS_MS,
+  ProxyAppStats,
+  ProxyContainerStats,
+} from "../proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+
+// Module-level logger that gets configured when the top command runs
+let logger: Logger;
+
+// How often the view refreshes. Request counters are live, container usage
+// changes when the proxy samples it every 15 seconds.
+const REFRESH_INTERVAL_MS = 3000;
+
+interface TopContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+export type TopSort = "cpu" | "memory" | "name" | "requests";
+
+interface ParsedTopArgs {
+  sort: TopSort;
+  all: boolean;
+  watch: boolean;
+  containers: boolean;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for top command
+ */
+export function parseTopArgs(args: string[]): ParsedTopArgs {
+  let sort: TopSort = "cpu";
+  let server: string | undefined;
+
+  for (let i = 0; i < args.length; i++) {
+    const value = args[i + 1];
+    switch (args[i]) {
+      case "--sort":
+        if (value !== "cpu" && value !== "memory" && value !== "name" && value !== "requests") {
+          throw new Error(`Invalid --sort "${value}", expected cpu, memory, name or requests`);
+        }
+        sort = value;
+        i++;
+        break;
+      case "--server":
+        server = value;
+        i++;
+        break;
+    }
+  }
+
+  return {
+    sort,
+    all: args.includes("--all"),
+    watch: args.includes("--watch"),
+    containers: args.includes("--containers"),
+    server,
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Formats a byte count with binary units, e.g. 512.0MiB
+ */
+export function formatBytes(bytes: number): string {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return unit === 0 ? `${value}${units[unit]}` : `${value.toFixed(1)}${units[unit]}`;
+}
+
+/**
+ * Orders samples, busiest first unless sorting by name. Containers have no
+ * request counts, sorting them by requests sorts by CPU.
+ */
+export function sortStats(stats: ProxyContainerStats[], sort: TopSort): ProxyContainerStats[] {
+  return [...stats].sort((a, b) => {
+    switch (sort) {
+      case "memory":
+        return b.memory_bytes - a.memory_bytes;
+      case "name":
+        return a.container.localeCompare(b.container);
+      default:
+        return b.cpu_percent - a.cpu_percent;
+    }
+  });
+}
+
+/**
+ * Formats samples as an aligned table
+ */
+export function formatTopTable(stats: ProxyContainerStats[]): string[] {
+  const rows = stats.map((s) => {
+    const memoryPercent =
+      s.memory_limit_bytes > 0 ? (s.memory_bytes / s.memory_limit_bytes) * 100 : 0;
+    return [
+      s.container,
+      `${s.cpu_percent.toFixed(1)}%`,
+      `${formatBytes(s.memory_bytes)} / ${formatBytes(s.memory_limit_bytes)}`,
+      `${memoryPercent.toFixed(1)}%`,
+      String(s.pids),
+      `${formatBytes(s.network_rx_bytes)} / ${formatBytes(s.network_tx_bytes)}`,
+      `${formatBytes(s.disk_read_bytes)} / ${formatBytes(s.disk_write_bytes)}`,
+    ];
+  });
+
+  const header = ["CONTAINER", "CPU", "MEMORY", "MEM %", "PIDS", "NET RX / TX", "DISK R / W"];
+  const widths = header.map((title, column) =>
+    Math.max(title.length, ...rows.map((row) => row[column].length))
+  );
+
+  return [header, ...rows].map((row) =>
+    row
+      .map((cell, column) => (column === row.length - 1 ? cell : cell.padEnd(widths[column])))
+      .join("  ")
+  );
+}
+
+/**
+ * An app's load between two readings of its counters. Rates and latency are
+ * missing for the first reading, and after the proxy restarted.
+ */
+export interface AppTopRow {
+  project: string;
+  app: string;
+  containers: number;
+  requestsPerSecond?: number;
+  errorPercent?: number; // Share of the requests answered with a 5xx status
+  p95Ms?: number;
+  inFlight: number;
+  cpuPercent: number;
+  memoryBytes: number;
+  memoryLimitBytes: number;
+}
+
+/**
+ * Estimates the latency below which a fraction q of the requests in a
+ * histogram were served, as the upper bound of the bucket it falls in
+ */
+export function latencyPercentile(buckets: number[], q: number): number | undefined {
+  const total = buckets.reduce((sum, count) => sum + count, 0);
+  if (total === 0) {
+    return undefined;
+  }
+
+  let seen = 0;
+  for (let i = 0; i < LATENCY_BOUNDS_MS.length; i++) {
+    seen += buckets[i] || 0;
+    if (seen >= q * total) {
+      return LATENCY_BOUNDS_MS[i];
+    }
+  }
+  return LATENCY_BOUNDS_MS[LATENCY_BOUNDS_MS.length - 1];
+}
+
+/**
+ * Measures each app's request rate, error rate and p95 latency from the
+ * difference between two readings of the proxy's counters taken elapsedMs apart
+ */
+export function computeAppRows(
+  current: ProxyAppStats[],
+  previous: ProxyAppStats[] | undefined,
+  elapsedMs: number
+): AppTopRow[] {
+  const before = new Map<string, ProxyAppStats>(
+    (previous || []).map((s) => [`${s.project}/${s.app}`, s])
+  );
+
+  return current.map((s) => {
+    const row: AppTopRow = {
+      project: s.project,
+      app: s.app,
+      containers: s.containers,
+      inFlight: s.in_flight,
+      cpuPercent: s.cpu_percent,
+      memoryBytes: s.memory_bytes,
+      memoryLimitBytes: s.memory_limit_bytes,
+    };
+
+    // Counters drop when the proxy restarts or one of the app's hosts is removed
+    const last = before.get(`${s.project}/${s.app}`);
+    if (!last || elapsedMs <= 0 || s.requests < last.requests) {
+      return row;
+    }
+
+    const requests = s.requests - last.requests;
+    row.requestsPerSecond = requests / (elapsedMs / 1000);
+    if (requests > 0) {
+      row.errorPercent = ((s.errors - last.errors) / requests) * 100;
+      row.p95Ms = latencyPercentile(
+        s.latency_buckets.map((count, i) => count - (last.latency_buckets[i] || 0)),
+        0.95
+      );
+    }
+    return row;
+  });
+}
+
+/**
+ * Orders apps, busiest first unless sorting by name
+ */
+export function sortAppRows(rows: AppTopRow[], sort: TopSort): AppTopRow[] {
+  return [...rows].sort((a, b) => {
+    switch (sort) {
+      case "memory":
+        return b.memoryBytes - a.memoryBytes;
+      case "name":
+        return `${a.project}/${a.app}`.localeCompare(`${b.project}/${b.app}`);
+      case "requests":
+        return (b.requestsPerSecond || 0) - (a.requestsPerSecond || 0);
+      default:
+        return b.cpuPercent - a.cpuPercent;
+    }
+  });
+}
+
+/**
+ * Formats a latency in milliseconds, e.g. 250ms or 2.5s
+ */
+function formatLatency(ms: number): string {
+  return ms < 1000 ? `${ms}ms` : `${ms / 1000}s`;
+}
+
+/**
+ * Formats apps as an aligned table. Apps of other projects are prefixed
+ * with their project.
+ */
+export function formatAppTable(rows: AppTopRow[], project: string): string[] {
+  const cells = rows.map((row) => [
+    row.project === project ? row.app : `${row.project}/${row.app}`,
+    String(row.containers),
+    row.requestsPerSecond === undefined ? "-" : row.requestsPerSecond.toFixed(1),
+    row.errorPercent === undefined ? "-" : `${row.errorPercent.toFixed(1)}%`,
+    row.p95Ms === undefined ? "-" : formatLatency(row.p95Ms),
+    String(row.inFlight),
+    `${row.cpuPercent.toFixed(1)}%`,
+    `${formatBytes(row.memoryBytes)} / ${formatBytes(row.memoryLimitBytes)}`,
+  ]);
+
+  const header = ["APP", "CONTAINERS", "REQ/S", "ERRORS", "P95", "IN FLIGHT", "CPU", "MEMORY"];
+  const widths = header.map((title, column) =>
+    Math.max(title.length, ...cells.map((row) => row[column].length))
+  );
+
+  return [header, ...cells].map((row) =>
+    row
+      .map((cell, column) => (column === row.length - 1 ? cell : cell.padEnd(widths[column])))
+      .join("  ")
+  );
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: TopContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+interface TopServer {
+  server: string;
+  proxyClient: IopProxyClient;
+  sshClient: SSHClient;
+}
+
+function sleep(ms: number): Promise<void> {
+  return new Promise((resolve) => setTimeout(resolve, ms));
+}
+
+/**
+ * Shows each app's request rate, error rate, p95 latency and resource usage.
+ * Rates need two readings, so without --watch the counters are read twice, a
+ * refresh interval apart.
+ */
+async function showApps(servers: TopServer[], parsedArgs: ParsedTopArgs, project: string): Promise<void> {
+  const previous = new Map<string, { stats: ProxyAppStats[]; readAt: number }>();
+
+  const read = async () => {
+    const results: Array<{ server: string; apps: AppTopRow[] | null }> = [];
+    for (const { server, proxyClient } of servers) {
+      let stats = await proxyClient.getAppStats();
+      const readAt = Date.now();
+      if (!stats) {
+        results.push({ server, apps: null });
+        continue;
+      }
+      if (!parsedArgs.all) {
+        stats = stats.filter((s) => s.project === project);
+      }
+
+      const last = previous.get(server);
+      const rows = computeAppRows(stats, last?.stats, last ? readAt - last.readAt : 0);
+      previous.set(server, { stats, readAt });
+      results.push({ server, apps: sortAppRows(rows, parsedArgs.sort) });
+    }
+    return results;
+  };
+
+  await read();
+  while (true) {
+    await sleep(REFRESH_INTERVAL_MS);
+    const results = await read();
+
+    if (!isJsonOutput()) {
+      if (parsedArgs.watch) {
+        console.clear();
+      }
+      for (const { server, apps } of results) {
+        console.log(`\n=== ${server} ===`);
+        if (!apps) {
+          console.log("Could not read app stats, run iop proxy update if the proxy is older than this CLI");
+        } else if (apps.length === 0) {
+          console.log("No apps running");
+        } else {
+          formatAppTable(apps, project).forEach((line) => console.log(line));
+        }
+      }
+    }
+
+    if (!parsedArgs.watch) {
+      writeResult({ servers: results });
+      return;
+    }
+  }
+}
+
+/**
+ * Shows the resource usage of each container, as sampled by the proxy
+ */
+async function showContainers(servers: TopServer[], parsedArgs: ParsedTopArgs, project: string): Promise<void> {
+  while (true) {
+    const results: Array<{ server: string; containers: ProxyContainerStats[] | null }> = [];
+
+    for (const { server, proxyClient } of servers) {
+      let containers = await proxyClient.getContainerStats();
+      if (containers && !parsedArgs.all) {
+        containers = containers.filter((s) => s.project === project);
+      }
+      results.push({
+        server,
+        containers: containers ? sortStats(containers, parsedArgs.sort) : null,
+      });
+    }
+
+    if (!isJsonOutput()) {
+      if (parsedArgs.watch) {
+        console.clear();
+      }
+      for (const { server, containers } of results) {
+        console.log(`\n=== ${server} ===`);
+        if (!containers) {
+          console.log("Could not read container stats");
+        } else if (containers.length === 0) {
+          console.log("No containers running");
+        } else {
+          formatTopTable(containers).forEach((line) => console.log(line));
+        }
+      }
+    }
+
+    if (!parsedArgs.watch) {
+      writeResult({ servers: results });
+      return;
+    }
+    await sleep(REFRESH_INTERVAL_MS);
+  }
+}
+
+/**
+ * Shows live per-app load, or per-container usage, of the project on every
+ * server in the configuration
+ */
+export async function topCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseTopArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  const sshClients: TopServer[] = [];
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+    const context: TopContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const servers = parsedArgs.server
+      ? [parsedArgs.server]
+      : Array.from(
+          new Set(
+            normalizeConfigEntries(config.services).map(
+              (service: ServiceEntry) => service.server
+            )
+          )
+        );
+
+    for (const serverHostname of servers) {
+      const sshClient = await establishSSHConnection(serverHostname, context);
+      sshClients.push({
+        server: serverHostname,
+        sshClient,
+        proxyClient: new IopProxyClient(
+          new DockerClient(sshClient, serverHostname, context.verboseFlag),
+          serverHostname,
+          context.verboseFlag
+        ),
+      });
+    }
+
+    if (parsedArgs.containers) {
+      await showContainers(sshClients, parsedArgs, config.name);
+    } else {
+      await showApps(sshClients, parsedArgs, config.name);
+    }
+  } catch (error) {
+    logger.error("Failed to read stats", error);
+    process.exitCode = 1;
+  } finally {
+    for (const { sshClient } of sshClients) {
+      await sshClient.close();
+    }
+    logger.cleanup();
+  }
+}
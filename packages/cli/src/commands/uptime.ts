@@ -0,0 +1,182 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { IopProxyClient, ProxyUptimeReport } from "../proxy";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+
+// Module-level logger that gets configured when the uptime command runs
+let logger: Logger;
+
+interface UptimeContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedUptimeArgs {
+  server?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for uptime command
+ */
+export function parseUptimeArgs(args: string[]): ParsedUptimeArgs {
+  let server: string | undefined;
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++;
+    }
+  }
+
+  return {
+    server,
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+function formatPercent(percent: number | null): string {
+  return percent === null ? "-" : `${percent.toFixed(2)}%`;
+}
+
+/**
+ * Describes whether a host is up, or how long it has been down
+ */
+export function formatUptimeStatus(report: ProxyUptimeReport, now = Date.now()): string {
+  if (report.up) {
+    return "up";
+  }
+  if (!report.down_since) {
+    return "down";
+  }
+  const minutes = Math.max(0, Math.round((now - Date.parse(report.down_since)) / 60000));
+  return minutes < 60
+    ? `down ${minutes}m`
+    : `down ${Math.floor(minutes / 60)}h${minutes % 60}m`;
+}
+
+/**
+ * Formats the uptime of a server's hosts as table rows
+ */
+export function formatUptimeTable(reports: ProxyUptimeReport[], now = Date.now()): string[] {
+  const row = (columns: string[]) =>
+    `${columns[0].padEnd(40)} ${columns[1].padEnd(15)} ${columns[2].padEnd(12)} ${columns
+      .slice(3)
+      .map((column) => column.padStart(8))
+      .join(" ")}`;
+
+  return [
+    row(["HOST", "APP", "STATUS", "24H", "7D", "30D", "90D", "LATENCY"]),
+    ...reports.map((report) =>
+      row([
+        report.host,
+        report.app || "-",
+        formatUptimeStatus(report, now),
+        formatPercent(report.uptime_24h),
+        formatPercent(report.uptime_7d),
+        formatPercent(report.uptime_30d),
+        formatPercent(report.uptime_90d),
+        report.uptime_24h === null ? "-" : `${report.avg_latency_ms}ms`,
+      ])
+    ),
+  ];
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: UptimeContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Shows the uptime the proxies measured for the project's hosts
+ */
+export async function uptimeCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseUptimeArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+    const context: UptimeContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    const servers = parsedArgs.server
+      ? [parsedArgs.server]
+      : Array.from(
+          new Set(
+            normalizeConfigEntries(config.services).map(
+              (service: ServiceEntry) => service.server
+            )
+          )
+        );
+
+    const results: Array<{
+      server: string;
+      hosts: ProxyUptimeReport[] | null;
+    }> = [];
+
+    for (const serverHostname of servers) {
+      const sshClient = await establishSSHConnection(serverHostname, context);
+      try {
+        const proxyClient = new IopProxyClient(
+          new DockerClient(sshClient, serverHostname, context.verboseFlag),
+          serverHostname,
+          context.verboseFlag
+        );
+        const hosts = await proxyClient.getUptime(config.name);
+        results.push({ server: serverHostname, hosts });
+
+        if (!isJsonOutput()) {
+          console.log(`\n=== ${serverHostname} ===`);
+          if (!hosts) {
+            console.log("Could not read uptime, the proxy may need an update: iop proxy update");
+          } else if (hosts.length === 0) {
+            console.log("No hosts checked yet");
+          } else {
+            formatUptimeTable(hosts).forEach((line) => console.log(line));
+          }
+
+        }
+      } finally {
+        await sshClient.close();
+      }
+    }
+
+    writeResult({ servers: results });
+  } catch (error) {
+    logger.error("Failed to read uptime", error);
+    process.exitCode = 1;
+  } finally {
+    logger.cleanup();
+  }
+}
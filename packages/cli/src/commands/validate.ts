@@ -0,0 +1,159 @@
+import { loadConfig, loadRawConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { ProxyHostInfo } from "../proxy";
+import { IopProxyCluster, getProxyServers } from "../proxy/cluster";
+import { Logger } from "../utils/logger";
+import {
+  ConfigValidationError,
+  findHostConflicts,
+  findUnknownConfigKeys,
+  formatValidationErrors,
+  validateConfig,
+} from "../utils/config-validator";
+import { isJsonOutput, writeResult } from "../utils/output";
+
+// Module-level logger that gets configured when the validate command runs
+let logger: Logger;
+
+interface ParsedValidateArgs {
+  offline: boolean;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for validate command
+ */
+export function parseValidateArgs(args: string[]): ParsedValidateArgs {
+  return {
+    offline: args.includes("--offline"),
+    verboseFlag: args.includes("--verbose"),
+  };
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  config: IopConfig,
+  secrets: IopSecrets,
+  verboseFlag: boolean
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    config,
+    secrets,
+    verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Asks the proxy on every proxy server which hosts it already routes
+ */
+async function fetchProxyHosts(
+  config: IopConfig,
+  secrets: IopSecrets,
+  verboseFlag: boolean
+): Promise<Map<string, Record<string, ProxyHostInfo>>> {
+  const hasProxiedServices = normalizeConfigEntries(config.services).some(
+    (service: ServiceEntry) => service.proxy?.hosts?.length
+  );
+  if (!hasProxiedServices) {
+    return new Map();
+  }
+
+  const cluster = new IopProxyCluster(
+    getProxyServers(config),
+    (serverHostname) =>
+      establishSSHConnection(serverHostname, config, secrets, verboseFlag),
+    verboseFlag
+  );
+
+  try {
+    const { hosts, stopped, unreachable } = await cluster.getHostsByServer();
+    for (const serverHostname of stopped) {
+      logger.verboseLog(`No proxy running on ${serverHostname}, skipping host checks`);
+    }
+    for (const [serverHostname, reason] of unreachable) {
+      logger.warn(`Could not check the proxy on ${serverHostname}: ${reason}`);
+    }
+    return hosts;
+  } finally {
+    await cluster.close();
+  }
+}
+
+/**
+ * Main validate command. Checks iop.yml against the schema and the servers'
+ * proxies without changing anything, and exits non-zero when problems are found.
+ */
+export async function validateCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseValidateArgs(args);
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const errors: ConfigValidationError[] = [];
+
+    errors.push(...findUnknownConfigKeys(await loadRawConfig()));
+
+    let config: IopConfig;
+    try {
+      config = await loadConfig();
+    } catch (error) {
+      // loadConfig has already explained schema errors in detail
+      errors.push({
+        type: "configuration_error",
+        message: error instanceof Error ? error.message : String(error),
+        entries: [],
+        server: "",
+      });
+      reportErrors(errors);
+      return;
+    }
+
+    errors.push(...validateConfig(config));
+
+    if (!parsedArgs.offline) {
+      const secrets = await loadSecrets();
+      const proxyHosts = await fetchProxyHosts(config, secrets, parsedArgs.verboseFlag);
+      errors.push(...findHostConflicts(config, proxyHosts));
+    }
+
+    reportErrors(errors);
+  } finally {
+    logger.cleanup();
+  }
+}
+
+/**
+ * Prints the outcome and sets a failing exit code when there are errors
+ */
+function reportErrors(errors: ConfigValidationError[]): void {
+  if (isJsonOutput()) {
+    writeResult({ valid: errors.length === 0, errors });
+  } else if (errors.length === 0) {
+    console.log("[✓] iop.yml is valid");
+  }
+  if (errors.length === 0) {
+    return;
+  }
+
+  for (const line of formatValidationErrors(errors)) {
+    console.error(line);
+  }
+  process.exitCode = 1;
+}
@@ -0,0 +1,447 @@
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../config";
+import { IopConfig, IopSecrets } from "../config/types";
+import { SSHClient, getSSHCredentials } from "../ssh";
+import { DockerClient } from "../docker";
+import { Logger } from "../utils/logger";
+import { isJsonOutput, writeResult } from "../utils/output";
+import {
+  getDeclaredVolumeNames,
+  getProjectVolumeName,
+  getServiceNamedVolumes,
+  sanitizeFolderName,
+} from "../utils";
+
+// Module-level logger that gets configured when volumes commands run
+let logger: Logger;
+
+interface VolumesContext {
+  config: IopConfig;
+  secrets: IopSecrets;
+  verboseFlag: boolean;
+}
+
+interface ParsedVolumesArgs {
+  subcommand: string;
+  volumeName?: string;
+  server?: string;
+  verboseFlag: boolean;
+}
+
+/**
+ * Parses command line arguments for volumes command
+ */
+function parseVolumesArgs(args: string[]): ParsedVolumesArgs {
+  const verboseFlag = args.includes("--verbose");
+
+  let server: string | undefined;
+  const cleanArgs: string[] = [];
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--verbose") {
+      continue;
+    } else if (args[i] === "--server" && i + 1 < args.length) {
+      server = args[i + 1];
+      i++; // Skip the next argument since it's the server value
+    } else {
+      cleanArgs.push(args[i]);
+    }
+  }
+
+  return {
+    subcommand: cleanArgs[0] || "",
+    volumeName: cleanArgs[1],
+    server,
+    verboseFlag,
+  };
+}
+
+/**
+ * Returns the servers a declared volume lives on, based on which services mount it
+ */
+export function getVolumeServers(
+  config: IopConfig,
+  volumeName: string
+): string[] {
+  const declaredVolumes = getDeclaredVolumeNames(config);
+  const servers = new Set<string>();
+
+  normalizeConfigEntries(config.services).forEach((service) => {
+    if (
+      getServiceNamedVolumes(service.volumes, declaredVolumes).includes(
+        volumeName
+      )
+    ) {
+      servers.add(service.server);
+    }
+  });
+
+  return Array.from(servers);
+}
+
+/**
+ * Generates a timestamped backup file name for a volume
+ */
+export function generateBackupFileName(
+  volumeName: string,
+  date: Date = new Date()
+): string {
+  const timestamp = date
+    .toISOString()
+    .replace(/[-:]/g, "")
+    .replace(/\.\d+Z$/, "Z");
+  return `${sanitizeFolderName(volumeName)}-${timestamp}.tar.gz`;
+}
+
+/**
+ * Establishes SSH connection to a server
+ */
+async function establishSSHConnection(
+  serverHostname: string,
+  context: VolumesContext
+): Promise<SSHClient> {
+  const sshCredentials = await getSSHCredentials(
+    serverHostname,
+    context.config,
+    context.secrets,
+    context.verboseFlag
+  );
+
+  if (!sshCredentials.username) {
+    throw new Error("Could not determine SSH username");
+  }
+
+  const sshClient = await SSHClient.create({
+    ...sshCredentials,
+    host: serverHostname,
+    username: sshCredentials.username as string,
+  });
+  await sshClient.connect();
+
+  return sshClient;
+}
+
+/**
+ * Resolves the target servers for a volume, honoring --server
+ */
+function resolveVolumeServers(
+  context: VolumesContext,
+  volumeName: string,
+  server?: string
+): string[] {
+  if (!getDeclaredVolumeNames(context.config).includes(volumeName)) {
+    throw new Error(
+      `Volume "${volumeName}" is not declared in the volumes section of iop.yml`
+    );
+  }
+
+  const servers = getVolumeServers(context.config, volumeName);
+  if (server) {
+    return servers.includes(server) ? [server] : [];
+  }
+  return servers;
+}
+
+/**
+ * List subcommand - shows declared volumes and their state on each server
+ */
+async function volumesListSubcommand(context: VolumesContext): Promise<void> {
+  const declaredVolumes = getDeclaredVolumeNames(context.config);
+
+  if (declaredVolumes.length === 0) {
+    logger.info("No volumes declared in iop.yml");
+    writeResult({ volumes: [] });
+    return;
+  }
+
+  const results: Array<{
+    name: string;
+    dockerName: string;
+    driver: string;
+    size?: string;
+    servers: Array<{
+      server: string;
+      state: "missing" | "present" | "error";
+      usedBy?: string[];
+      error?: string;
+    }>;
+  }> = [];
+
+  for (const volumeName of declaredVolumes) {
+    const volumeConfig = context.config.volumes![volumeName];
+    const dockerVolumeName = getProjectVolumeName(
+      context.config.name,
+      volumeName
+    );
+    const servers = getVolumeServers(context.config, volumeName);
+    const result: (typeof results)[number] = {
+      name: volumeName,
+      dockerName: dockerVolumeName,
+      driver: volumeConfig.driver,
+      size: volumeConfig.size,
+      servers: [],
+    };
+    results.push(result);
+
+    for (const serverHostname of servers) {
+      let sshClient: SSHClient | undefined;
+      try {
+        sshClient = await establishSSHConnection(serverHostname, context);
+        const dockerClient = new DockerClient(
+          sshClient,
+          serverHostname,
+          context.verboseFlag
+        );
+
+        const exists = await dockerClient.volumeExists(dockerVolumeName);
+        if (!exists) {
+          result.servers.push({ server: serverHostname, state: "missing" });
+          continue;
+        }
+
+        const usedBy = await dockerClient.findContainersUsingVolume(
+          dockerVolumeName
+        );
+        result.servers.push({ server: serverHostname, state: "present", usedBy });
+      } catch (error) {
+        result.servers.push({
+          server: serverHostname,
+          state: "error",
+          error: String(error),
+        });
+      } finally {
+        if (sshClient) {
+          await sshClient.close();
+        }
+      }
+    }
+  }
+
+  if (isJsonOutput()) {
+    writeResult({ volumes: results });
+    return;
+  }
+  for (const result of results) {
+    console.log(`\n${result.name} (${result.dockerName})`);
+    console.log(`   Driver: ${result.driver}`);
+    if (result.size) {
+      console.log(`   Size hint: ${result.size}`);
+    }
+
+    if (result.servers.length === 0) {
+      console.log("   Not mounted by any service");
+      continue;
+    }
+    for (const server of result.servers) {
+      if (server.state === "missing") {
+        console.log(`   ${server.server}: not created yet`);
+      } else if (server.state === "present") {
+        const usage = server.usedBy!.length > 0 ? server.usedBy!.join(", ") : "unused";
+        console.log(`   ${server.server}: present (used by ${usage})`);
+      } else {
+        console.log(`   ${server.server}: failed to check (${server.error})`);
+      }
+    }
+  }
+}
+
+/**
+ * Inspect subcommand - shows Docker details for a volume on each server
+ */
+async function volumesInspectSubcommand(
+  context: VolumesContext,
+  volumeName: string,
+  server?: string
+): Promise<void> {
+  const dockerVolumeName = getProjectVolumeName(
+    context.config.name,
+    volumeName
+  );
+  const servers = resolveVolumeServers(context, volumeName, server);
+
+  if (servers.length === 0) {
+    logger.info(`Volume ${volumeName} is not used on any matching server`);
+    return;
+  }
+
+  for (const serverHostname of servers) {
+    let sshClient: SSHClient | undefined;
+    try {
+      sshClient = await establishSSHConnection(serverHostname, context);
+      const dockerClient = new DockerClient(
+        sshClient,
+        serverHostname,
+        context.verboseFlag
+      );
+
+      const details = await dockerClient.inspectVolume(dockerVolumeName);
+      console.log(`\n=== ${dockerVolumeName} on ${serverHostname} ===`);
+      if (!details) {
+        console.log("Volume does not exist");
+        continue;
+      }
+
+      console.log(`Driver:     ${details.Driver}`);
+      console.log(`Mountpoint: ${details.Mountpoint}`);
+      console.log(`Created:    ${details.CreatedAt}`);
+
+      try {
+        const size = await sshClient.exec(
+          `sudo du -sh ${details.Mountpoint} 2>/dev/null | cut -f1`
+        );
+        if (size.trim()) {
+          console.log(`Disk usage: ${size.trim()}`);
+        }
+      } catch {
+        logger.verboseLog(`Could not determine disk usage on ${serverHostname}`);
+      }
+
+      const usedBy = await dockerClient.findContainersUsingVolume(
+        dockerVolumeName
+      );
+      console.log(
+        `Used by:    ${usedBy.length > 0 ? usedBy.join(", ") : "-"}`
+      );
+    } catch (error) {
+      logger.error(`Failed to inspect ${volumeName} on ${serverHostname}`, error);
+    } finally {
+      if (sshClient) {
+        await sshClient.close();
+      }
+    }
+  }
+}
+
+/**
+ * Backup subcommand - archives a volume into the project's backup directory on the server
+ */
+async function volumesBackupSubcommand(
+  context: VolumesContext,
+  volumeName: string,
+  server?: string
+): Promise<void> {
+  const dockerVolumeName = getProjectVolumeName(
+    context.config.name,
+    volumeName
+  );
+  const servers = resolveVolumeServers(context, volumeName, server);
+
+  if (servers.length === 0) {
+    logger.info(`Volume ${volumeName} is not used on any matching server`);
+    return;
+  }
+
+  const backupDir = `~/.iop/projects/${sanitizeFolderName(
+    context.config.name
+  )}/backups`;
+
+  for (const serverHostname of servers) {
+    let sshClient: SSHClient | undefined;
+    try {
+      logger.server(serverHostname);
+      sshClient = await establishSSHConnection(serverHostname, context);
+      const dockerClient = new DockerClient(
+        sshClient,
+        serverHostname,
+        context.verboseFlag
+      );
+
+      if (!(await dockerClient.volumeExists(dockerVolumeName))) {
+        logger.serverStepError(`Volume ${dockerVolumeName} does not exist`);
+        continue;
+      }
+
+      const fileName = generateBackupFileName(volumeName);
+      logger.serverStep(`Backing up ${volumeName}`);
+
+      await sshClient.exec(`mkdir -p ${backupDir}`);
+      await sshClient.exec(
+        `docker run --rm -v ${dockerVolumeName}:/volume:ro -v ${backupDir}:/backup alpine tar czf /backup/${fileName} -C /volume .`
+      );
+
+      logger.serverStepComplete(`Backup written to ${backupDir}/${fileName}`);
+    } catch (error) {
+      logger.serverStepError(`Failed to back up ${volumeName}`, error);
+    } finally {
+      if (sshClient) {
+        await sshClient.close();
+      }
+    }
+  }
+}
+
+/**
+ * Shows help for volumes command
+ */
+function showVolumesHelp(): void {
+  console.log("IOP Volume Management");
+  console.log("=====================");
+  console.log("");
+  console.log("USAGE:");
+  console.log("  iop volumes <subcommand> [name] [flags]");
+  console.log("");
+  console.log("SUBCOMMANDS:");
+  console.log("  list              Show declared volumes and where they exist");
+  console.log("  inspect <name>    Show driver, mountpoint and usage of a volume");
+  console.log("  backup <name>     Archive a volume to ~/.iop/projects/<project>/backups");
+  console.log("");
+  console.log("FLAGS:");
+  console.log("  --server <host>   Only target the given server");
+  console.log("  --verbose         Show detailed output");
+  console.log("");
+  console.log("EXAMPLES:");
+  console.log("  iop volumes list");
+  console.log("  iop volumes inspect pgdata");
+  console.log("  iop volumes backup pgdata --server 1.2.3.4");
+}
+
+/**
+ * Main volumes command that handles subcommands
+ */
+export async function volumesCommand(args: string[]): Promise<void> {
+  const parsedArgs = parseVolumesArgs(args);
+
+  if (!["list", "inspect", "backup"].includes(parsedArgs.subcommand)) {
+    showVolumesHelp();
+    return;
+  }
+
+  logger = new Logger({ verbose: parsedArgs.verboseFlag });
+
+  try {
+    const config = await loadConfig();
+    const secrets = await loadSecrets();
+
+    const context: VolumesContext = {
+      config,
+      secrets,
+      verboseFlag: parsedArgs.verboseFlag,
+    };
+
+    if (parsedArgs.subcommand !== "list" && !parsedArgs.volumeName) {
+      logger.error(`Volume name is required: iop volumes ${parsedArgs.subcommand} <name>`);
+      return;
+    }
+
+    switch (parsedArgs.subcommand) {
+      case "list":
+        await volumesListSubcommand(context);
+        break;
+      case "inspect":
+        await volumesInspectSubcommand(
+          context,
+          parsedArgs.volumeName!,
+          parsedArgs.server
+        );
+        break;
+      case "backup":
+        await volumesBackupSubcommand(
+          context,
+          parsedArgs.volumeName!,
+          parsedArgs.server
+        );
+        break;
+    }
+  } finally {
+    logger.cleanup();
+  }
+}
@@ -0,0 +1,360 @@
+import crypto from "crypto";
+import fs from "node:fs/promises";
+import os from "os";
+import path from "path";
+
+// Encrypted values in iop.yml, an age file in base64, e.g.
+// DATABASE_URL=ENC[age,YWdlLWVuY3J5cHRpb24ub3JnL3Yx...]
+const ENCRYPTED_PATTERN = /ENC\[age,([A-Za-z0-9+/=]+)\]/g;
+
+// Where iop looks for the identity decrypting values, after IOP_AGE_KEY and
+// IOP_AGE_KEY_FILE
+export const DEFAULT_IDENTITY_FILE = path.join(os.homedir(), ".config", "iop", "age.key");
+
+const AGE_HEADER = "age-encryption.org/v1";
+const X25519_INFO = "age-encryption.org/v1/X25519";
+const CHUNK_SIZE = 64 * 1024;
+const BECH32_CHARSET = "qpzry9x8gf2tvdw0s3jn54khce6mua7l";
+
+// Config strings that held encrypted values, so commands showing the config
+// can mask them like secrets
+const decryptedStrings = new Set<string>();
+
+// DER prefixes turning raw X25519 keys into keys node's crypto accepts
+const X25519_PRIVATE_PREFIX = Buffer.from("302e020100300506032b656e04220420", "hex");
+const X25519_PUBLIC_PREFIX = Buffer.from("302a300506032b656e032100", "hex");
+
+/**
+ * Whether a string of the loaded config was decrypted from iop.yml
+ */
+export function wasEncrypted(value: string): boolean {
+  return decryptedStrings.has(value);
+}
+
+/**
+ * Whether a config value holds encrypted parts
+ */
+export function isEncrypted(value: string): boolean {
+  return new RegExp(ENCRYPTED_PATTERN.source).test(value);
+}
+
+function bech32Polymod(values: number[]): number {
+  const generators = [0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3];
+  let checksum = 1;
+  for (const value of values) {
+    const top = checksum >> 25;
+    checksum = ((checksum & 0x1ffffff) << 5) ^ value;
+    for (let i = 0; i < 5; i++) {
+      if ((top >> i) & 1) checksum ^= generators[i];
+    }
+  }
+  return checksum;
+}
+
+function bech32HrpExpand(hrp: string): number[] {
+  const chars = [...hrp].map((char) => char.charCodeAt(0));
+  return [...chars.map((char) => char >> 5), 0, ...chars.map((char) => char & 31)];
+}
+
+function convertBits(data: number[], from: number, to: number, pad: boolean): number[] {
+  let accumulator = 0;
+  let bits = 0;
+  const result: number[] = [];
+  for (const value of data) {
+    accumulator = (accumulator << from) | value;
+    bits += from;
+    while (bits >= to) {
+      bits -= to;
+      result.push((accumulator >> bits) & ((1 << to) - 1));
+    }
+  }
+  if (pad && bits > 0) {
+    result.push((accumulator << (to - bits)) & ((1 << to) - 1));
+  } else if (!pad && (bits >= from || (accumulator << (to - bits)) & ((1 << to) - 1))) {
+    throw new Error("invalid padding");
+  }
+  return result;
+}
+
+function bech32Encode(hrp: string, data: Buffer): string {
+  const words = convertBits([...data], 8, 5, true);
+  const polymod = bech32Polymod([...bech32HrpExpand(hrp), ...words, 0, 0, 0, 0, 0, 0]) ^ 1;
+  const checksum = [0, 1, 2, 3, 4, 5].map((i) => (polymod >> (5 * (5 - i))) & 31);
+  return `${hrp}1${[...words, ...checksum].map((word) => BECH32_CHARSET[word]).join("")}`;
+}
+
+function bech32Decode(encoded: string, expectedHrp: string): Buffer {
+  const lower = encoded.toLowerCase();
+  const separator = lower.lastIndexOf("1");
+  const hrp = lower.substring(0, separator);
+  const words = [...lower.substring(separator + 1)].map((char) => BECH32_CHARSET.indexOf(char));
+  if (
+    hrp !== expectedHrp ||
+    words.length < 6 ||
+    words.includes(-1) ||
+    bech32Polymod([...bech32HrpExpand(hrp), ...words]) !== 1
+  ) {
+    throw new Error(`Invalid ${expectedHrp === "age" ? "age recipient" : "age identity"}`);
+  }
+  return Buffer.from(convertBits(words.slice(0, -6), 5, 8, false));
+}
+
+function rawBase64(data: Buffer): string {
+  return data.toString("base64").replace(/=+$/, "");
+}
+
+function hkdf(ikm: Buffer, salt: Buffer, info: string): Buffer {
+  return Buffer.from(crypto.hkdfSync("sha256", ikm, salt, info, 32));
+}
+
+function seal(key: Buffer, nonce: Buffer, plaintext: Buffer): Buffer {
+  const cipher = crypto.createCipheriv("chacha20-poly1305", key, nonce, { authTagLength: 16 });
+  return Buffer.concat([cipher.update(plaintext), cipher.final(), cipher.getAuthTag()]);
+}
+
+function open(key: Buffer, nonce: Buffer, ciphertext: Buffer): Buffer {
+  if (ciphertext.length < 16) {
+    throw new Error("ciphertext too short");
+  }
+  const decipher = crypto.createDecipheriv("chacha20-poly1305", key, nonce, { authTagLength: 16 });
+  decipher.setAuthTag(ciphertext.subarray(ciphertext.length - 16));
+  return Buffer.concat([decipher.update(ciphertext.subarray(0, ciphertext.length - 16)), decipher.final()]);
+}
+
+function x25519(privateKey: Buffer, publicKey: Buffer): Buffer {
+  return crypto.diffieHellman({
+    privateKey: crypto.createPrivateKey({
+      key: Buffer.concat([X25519_PRIVATE_PREFIX, privateKey]),
+      format: "der",
+      type: "pkcs8",
+    }),
+    publicKey: crypto.createPublicKey({
+      key: Buffer.concat([X25519_PUBLIC_PREFIX, publicKey]),
+      format: "der",
+      type: "spki",
+    }),
+  });
+}
+
+function x25519PublicKey(privateKey: Buffer): Buffer {
+  const publicKey = crypto
+    .createPublicKey(
+      crypto.createPrivateKey({
+        key: Buffer.concat([X25519_PRIVATE_PREFIX, privateKey]),
+        format: "der",
+        type: "pkcs8",
+      })
+    )
+    .export({ format: "der", type: "spki" });
+  return publicKey.subarray(X25519_PUBLIC_PREFIX.length);
+}
+
+/**
+ * Creates an age identity, returned with the recipient values are encrypted to
+ */
+export function generateIdentity(): { identity: string; recipient: string } {
+  const privateKey = crypto.randomBytes(32);
+  return {
+    identity: bech32Encode("age-secret-key-", privateKey).toUpperCase(),
+    recipient: bech32Encode("age", x25519PublicKey(privateKey)),
+  };
+}
+
+/**
+ * Returns the recipient of an age identity, "AGE-SECRET-KEY-1..."
+ */
+export function identityToRecipient(identity: string): string {
+  return bech32Encode("age", x25519PublicKey(bech32Decode(identity, "age-secret-key-")));
+}
+
+/**
+ * Encrypts a value to age X25519 recipients, "age1...". The result is an
+ * age file any of their identities decrypts, also with the age CLI.
+ */
+export function encryptValue(plaintext: string, recipients: string[]): string {
+  if (recipients.length === 0) {
+    throw new Error("No recipients to encrypt to");
+  }
+
+  const fileKey = crypto.randomBytes(16);
+  const stanzas = recipients.map((recipient) => {
+    const recipientKey = bech32Decode(recipient, "age");
+    const ephemeral = crypto.randomBytes(32);
+    const share = x25519PublicKey(ephemeral);
+    const wrapKey = hkdf(
+      x25519(ephemeral, recipientKey),
+      Buffer.concat([share, recipientKey]),
+      X25519_INFO
+    );
+    const body = rawBase64(seal(wrapKey, Buffer.alloc(12), fileKey));
+    const lines = body.match(/.{1,64}/g) || [];
+    // A body line of exactly 64 characters is followed by an empty one
+    if (body.length % 64 === 0) lines.push("");
+    return `-> X25519 ${rawBase64(share)}\n${lines.join("\n")}\n`;
+  });
+
+  const header = `${AGE_HEADER}\n${stanzas.join("")}---`;
+  const mac = crypto
+    .createHmac("sha256", hkdf(fileKey, Buffer.alloc(0), "header"))
+    .update(header)
+    .digest();
+
+  const nonce = crypto.randomBytes(16);
+  const payloadKey = hkdf(fileKey, nonce, "payload");
+  const data = Buffer.from(plaintext, "utf-8");
+  const chunks: Buffer[] = [];
+  for (let offset = 0, counter = 0; ; offset += CHUNK_SIZE, counter++) {
+    const last = offset + CHUNK_SIZE >= data.length;
+    chunks.push(seal(payloadKey, streamNonce(counter, last), data.subarray(offset, offset + CHUNK_SIZE)));
+    if (last) break;
+  }
+
+  const file = Buffer.concat([
+    Buffer.from(`${header} ${rawBase64(mac)}\n`),
+    nonce,
+    ...chunks,
+  ]);
+  return `ENC[age,${file.toString("base64")}]`;
+}
+
+function streamNonce(counter: number, last: boolean): Buffer {
+  const nonce = Buffer.alloc(12);
+  nonce.writeUIntBE(counter, 5, 6);
+  nonce[11] = last ? 1 : 0;
+  return nonce;
+}
+
+/**
+ * Decrypts one ENC[age,...] value with the first identity that it was
+ * encrypted to
+ */
+export function decryptValue(encrypted: string, identities: string[]): string {
+  const match = /^ENC\[age,([A-Za-z0-9+/=]+)\]$/.exec(encrypted.trim());
+  if (!match) {
+    throw new Error("Not an encrypted value, expected ENC[age,...]");
+  }
+  const file = Buffer.from(match[1], "base64");
+
+  const headerEnd = file.indexOf("\n---");
+  const macLineEnd = file.indexOf("\n", headerEnd + 1);
+  if (headerEnd < 0 || macLineEnd < 0) {
+    throw new Error("Malformed encrypted value");
+  }
+  const header = file.subarray(0, headerEnd + 4).toString();
+  const mac = Buffer.from(file.subarray(headerEnd + 5, macLineEnd).toString(), "base64");
+  const lines = header.split("\n");
+  if (lines[0] !== AGE_HEADER) {
+    throw new Error("Unsupported encrypted value, expected an age v1 file");
+  }
+
+  // Stanzas are a "-> type args" line followed by body lines
+  const stanzas: Array<{ args: string[]; body: Buffer }> = [];
+  for (let i = 1; i < lines.length - 1; i++) {
+    if (lines[i].startsWith("-> ")) {
+      stanzas.push({ args: lines[i].substring(3).split(" "), body: Buffer.alloc(0) });
+    } else if (stanzas.length > 0) {
+      const last = stanzas[stanzas.length - 1];
+      last.body = Buffer.concat([last.body, Buffer.from(lines[i], "base64")]);
+    }
+  }
+
+  let fileKey: Buffer | undefined;
+  for (const identity of identities) {
+    const privateKey = bech32Decode(identity, "age-secret-key-");
+    const recipientKey = x25519PublicKey(privateKey);
+    for (const stanza of stanzas) {
+      if (stanza.args[0] !== "X25519" || stanza.args.length !== 2) continue;
+      const share = Buffer.from(stanza.args[1], "base64");
+      const wrapKey = hkdf(x25519(privateKey, share), Buffer.concat([share, recipientKey]), X25519_INFO);
+      try {
+        fileKey = open(wrapKey, Buffer.alloc(12), stanza.body);
+        break;
+      } catch {
+        // Encrypted to another recipient
+      }
+    }
+    if (fileKey) break;
+  }
+  if (!fileKey) {
+    throw new Error("None of the identities can decrypt the value");
+  }
+
+  const expectedMac = crypto
+    .createHmac("sha256", hkdf(fileKey, Buffer.alloc(0), "header"))
+    .update(header)
+    .digest();
+  if (mac.length !== expectedMac.length || !crypto.timingSafeEqual(mac, expectedMac)) {
+    throw new Error("Encrypted value was tampered with");
+  }
+
+  const payload = file.subarray(macLineEnd + 1);
+  const payloadKey = hkdf(fileKey, payload.subarray(0, 16), "payload");
+  const ciphertext = payload.subarray(16);
+  const chunks: Buffer[] = [];
+  for (let offset = 0, counter = 0; ; offset += CHUNK_SIZE + 16, counter++) {
+    const last = offset + CHUNK_SIZE + 16 >= ciphertext.length;
+    chunks.push(open(payloadKey, streamNonce(counter, last), ciphertext.subarray(offset, offset + CHUNK_SIZE + 16)));
+    if (last) break;
+  }
+  return Buffer.concat(chunks).toString("utf-8");
+}
+
+/**
+ * Reads the identities decrypting config values: IOP_AGE_KEY, the file at
+ * IOP_AGE_KEY_FILE, or ~/.config/iop/age.key. Key files are in age-keygen's
+ * format, one identity per line with # comments.
+ */
+export async function loadIdentities(
+  env: Record<string, string | undefined> = process.env
+): Promise<string[]> {
+  if (env.IOP_AGE_KEY) {
+    return [env.IOP_AGE_KEY.trim()];
+  }
+
+  const keyFile = env.IOP_AGE_KEY_FILE || DEFAULT_IDENTITY_FILE;
+  try {
+    const content = await fs.readFile(keyFile, "utf-8");
+    return content
+      .split("\n")
+      .map((line) => line.trim())
+      .filter((line) => line.startsWith("AGE-SECRET-KEY-1"));
+  } catch (error) {
+    if ((error as NodeJS.ErrnoException).code === "ENOENT") {
+      return [];
+    }
+    throw error;
+  }
+}
+
+/**
+ * Replaces the encrypted values in a parsed config with their plaintext,
+ * wherever they are, including inside KEY=ENC[age,...] entries. Configs
+ * without encrypted values are returned as they are, without needing a key.
+ */
+export function decryptConfigValues(rawConfig: unknown, identities: string[]): unknown {
+  const decrypt = (value: unknown): unknown => {
+    if (typeof value === "string") {
+      if (!isEncrypted(value)) return value;
+      if (identities.length === 0) {
+        throw new Error(
+          `iop.yml has encrypted values but no key to decrypt them. Set IOP_AGE_KEY, or put the key in ${DEFAULT_IDENTITY_FILE}`
+        );
+      }
+      const decrypted = value.replace(ENCRYPTED_PATTERN, (encrypted) =>
+        decryptValue(encrypted, identities)
+      );
+      decryptedStrings.add(decrypted);
+      return decrypted;
+    }
+    if (Array.isArray(value)) {
+      return value.map(decrypt);
+    }
+    if (value && typeof value === "object") {
+      return Object.fromEntries(
+        Object.entries(value).map(([key, entry]) => [key, decrypt(entry)])
+      );
+    }
+    return value;
+  };
+  return decrypt(rawConfig);
+}
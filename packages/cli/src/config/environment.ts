@@ -0,0 +1,187 @@
+import { EnvironmentGroup, IopConfig, IopSecrets } from "./types";
+
+export interface EnvironmentVariables {
+  plain?: string[];
+  secret?: string[];
+}
+
+export interface ServiceEnvironment extends EnvironmentVariables {
+  groups?: string[];
+  servers?: Record<string, EnvironmentVariables>;
+}
+
+// ${NAME} refers to another variable of the same container, ${secrets.NAME}
+// to a secret. $${ is a literal ${.
+const REFERENCE_PATTERN = /\$\$\{|\$\{(secrets\.)?([A-Za-z_][A-Za-z0-9_]*)\}/g;
+
+/**
+ * Merges sets of variables in order. A later variable replaces an earlier one
+ * with the same name, whether it's plain or secret.
+ */
+export function mergeEnvironment(
+  ...layers: Array<EnvironmentVariables | undefined>
+): Required<EnvironmentVariables> {
+  const merged = new Map<string, string | null>(); // null marks a secret
+
+  for (const layer of layers) {
+    for (const envVar of layer?.plain || []) {
+      const key = envVar.split("=")[0];
+      merged.delete(key);
+      merged.set(key, envVar);
+    }
+    for (const secretKey of layer?.secret || []) {
+      merged.delete(secretKey);
+      merged.set(secretKey, null);
+    }
+  }
+
+  const plain: string[] = [];
+  const secret: string[] = [];
+  for (const [key, envVar] of merged) {
+    if (envVar === null) {
+      secret.push(key);
+    } else {
+      plain.push(envVar);
+    }
+  }
+  return { plain, secret };
+}
+
+/**
+ * Resolves the variables of a service on its server: each of its groups in
+ * order followed by the group's overrides for the server, then the service's
+ * own variables and overrides.
+ */
+export function resolveServiceEnvironment(
+  service: { name: string; server: string; environment?: ServiceEnvironment },
+  groups: Record<string, EnvironmentGroup> = {}
+): Required<EnvironmentVariables> {
+  const layers: Array<EnvironmentVariables | undefined> = [];
+
+  for (const groupName of service.environment?.groups || []) {
+    const group = groups[groupName];
+    if (!group) {
+      throw new Error(
+        `Service ${service.name} uses unknown env group "${groupName}". Define it under env_groups in iop.yml.`
+      );
+    }
+    layers.push(group, group.servers?.[service.server]);
+  }
+
+  layers.push(
+    service.environment,
+    service.environment?.servers?.[service.server]
+  );
+  return mergeEnvironment(...layers);
+}
+
+/**
+ * Expands env_groups and per-server overrides into the plain and secret
+ * variables of each service, so deploy only has to look at those.
+ */
+export function applyEnvironmentGroups(config: IopConfig): IopConfig {
+  if (!config.services) {
+    return config;
+  }
+
+  const expand = <T extends { server: string; environment?: ServiceEnvironment }>(
+    name: string,
+    service: T
+  ): T => {
+    if (!service.environment) {
+      return service;
+    }
+    return {
+      ...service,
+      environment: resolveServiceEnvironment(
+        { ...service, name },
+        config.env_groups
+      ),
+    };
+  };
+
+  const services = Array.isArray(config.services)
+    ? config.services.map((service) => expand(service.name, service))
+    : Object.fromEntries(
+        Object.entries(config.services).map(([name, service]) => [
+          name,
+          expand(name, service),
+        ])
+      );
+
+  return { ...config, services };
+}
+
+/**
+ * Lists the variables and secrets a value refers to
+ */
+export function findReferences(value: string): {
+  variables: string[];
+  secrets: string[];
+} {
+  const variables: string[] = [];
+  const secrets: string[] = [];
+  for (const [match, secret, name] of value.matchAll(REFERENCE_PATTERN)) {
+    if (match === "$${") continue;
+    (secret ? secrets : variables).push(name);
+  }
+  return { variables, secrets };
+}
+
+/**
+ * Substitutes references in variable values. Variables listed in literalKeys,
+ * i.e. secrets, are used as they are.
+ */
+export function interpolateEnvironment(
+  envVars: Record<string, string>,
+  secrets: IopSecrets,
+  entryName: string,
+  literalKeys: string[] = []
+): Record<string, string> {
+  const resolved: Record<string, string> = {};
+  const resolving = new Set<string>();
+
+  const resolve = (key: string): string => {
+    if (key in resolved) {
+      return resolved[key];
+    }
+    if (literalKeys.includes(key)) {
+      return (resolved[key] = envVars[key]);
+    }
+    if (resolving.has(key)) {
+      throw new Error(`Variable ${key} of ${entryName} refers to itself`);
+    }
+
+    resolving.add(key);
+    const value = envVars[key].replace(
+      REFERENCE_PATTERN,
+      (match, secret: string | undefined, name: string) => {
+        if (match === "$${") {
+          return "${";
+        }
+        if (secret) {
+          if (secrets[name] === undefined) {
+            throw new Error(
+              `Secret ${name} used in ${key} of ${entryName} not found in loaded secrets`
+            );
+          }
+          return secrets[name];
+        }
+        if (envVars[name] === undefined) {
+          throw new Error(
+            `Variable ${name} used in ${key} of ${entryName} is not defined`
+          );
+        }
+        return resolve(name);
+      }
+    );
+    resolving.delete(key);
+
+    return (resolved[key] = value);
+  };
+
+  for (const key of Object.keys(envVars)) {
+    resolve(key);
+  }
+  return resolved;
+}
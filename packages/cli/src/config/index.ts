@@ -7,15 +7,88 @@ import {
   IopConfig,
   IopSecrets,
 } from "./types";
+import { applyServiceTemplates } from "./templates";
+import { applyEnvironmentGroups } from "./environment";
+import { applyConfigEnvironment } from "./profiles";
+import { decryptConfigValues, isEncrypted, loadIdentities } from "./encryption";
+import { resolveSecretReferences } from "./secret-resolvers";
 
 const IOP_DIR = ".iop";
 const CONFIG_FILE = "iop.yml";
 const SECRETS_FILE = "secrets";
 
+// Environment from iop.yml's 'environments' that commands work on, set once
+// from --env or IOP_ENV
+let configEnvironment: string | undefined;
+
+/**
+ * Selects the environment loadConfig and loadSecrets apply
+ */
+export function setConfigEnvironment(environment: string | undefined): void {
+  configEnvironment = environment;
+}
+
+/**
+ * The selected environment, if any
+ */
+export function getConfigEnvironment(): string | undefined {
+  return configEnvironment;
+}
+
+/**
+ * Works out the environment from --env <name>, --env=<name> or IOP_ENV, and
+ * returns the arguments without the flag
+ */
+export function resolveConfigEnvironment(
+  args: string[],
+  env: Record<string, string | undefined> = process.env
+): { environment?: string; args: string[] } {
+  let environment = env.IOP_ENV || undefined;
+  const rest: string[] = [];
+
+  for (let i = 0; i < args.length; i++) {
+    if (args[i] === "--env") {
+      environment = args[i + 1] ?? "";
+      i++;
+    } else if (args[i].startsWith("--env=")) {
+      environment = args[i].substring("--env=".length);
+    } else {
+      rest.push(args[i]);
+    }
+  }
+
+  if (environment !== undefined && !/^[a-z0-9][a-z0-9-]*$/.test(environment)) {
+    throw new Error(
+      `Invalid environment "${environment}", use lowercase letters, digits and -`
+    );
+  }
+  return { environment, args: rest };
+}
+
+/**
+ * Path of the secrets file, or of the selected environment's secrets file
+ */
+function getSecretsPath(environment?: string): string {
+  return path.join(IOP_DIR, environment ? `${SECRETS_FILE}.${environment}` : SECRETS_FILE);
+}
+
+/**
+ * Reads iop.yml without validating it, for checks the schema can't express
+ */
+export async function loadRawConfig(): Promise<unknown> {
+  const configFile = await fs.readFile(CONFIG_FILE, "utf-8");
+  return applyConfigEnvironment(yaml.load(configFile), configEnvironment);
+}
+
 export async function loadConfig(): Promise<IopConfig> {
   try {
     const configFile = await fs.readFile(CONFIG_FILE, "utf-8");
-    const rawConfig = yaml.load(configFile);
+    // Keys are only needed by configs with encrypted values
+    const identities = isEncrypted(configFile) ? await loadIdentities() : [];
+    const rawConfig = decryptConfigValues(
+      applyConfigEnvironment(yaml.load(configFile), configEnvironment),
+      identities
+    );
 
     // Validate and parse using Zod schema
     const validationResult = IopConfigSchema.safeParse(rawConfig);
@@ -253,7 +326,7 @@ export async function loadConfig(): Promise<IopConfig> {
 
       throw new Error(`Invalid configuration in ${CONFIG_FILE}.`);
     }
-    return validationResult.data;
+    return applyEnvironmentGroups(applyServiceTemplates(validationResult.data));
   } catch (error) {
     if (
       error instanceof Error &&
@@ -266,8 +339,58 @@ export async function loadConfig(): Promise<IopConfig> {
   }
 }
 
-export async function loadSecrets(): Promise<IopSecrets> {
-  const secretsPath = path.join(IOP_DIR, SECRETS_FILE);
+/**
+ * Converts object or array format configuration entries to a normalized array,
+ * taking each entry's name from its key in the object format
+ */
+export function normalizeConfigEntries(
+  entries: Record<string, any> | Array<any> | undefined
+): Array<any> {
+  if (!entries) return [];
+  if (Array.isArray(entries)) return entries;
+  return Object.entries(entries).map(([name, entry]) => ({ ...entry, name }));
+}
+
+/**
+ * Reads the secrets. References to an external store such as op://, vault://
+ * or aws-sm:// are resolved with resolveReferences, for commands that pass
+ * secrets on to containers. Other commands only resolve the SSH credentials
+ * they connect with, so reading status doesn't fetch every secret.
+ */
+export async function loadSecrets(resolveReferences: boolean = false): Promise<IopSecrets> {
+  const shouldResolve = resolveReferences ? () => true : isConnectionSecret;
+  const secrets = await readSecretsFile(getSecretsPath());
+  if (!configEnvironment) {
+    return resolveSecretReferences(secrets ?? IopSecretsSchema.parse({}), undefined, shouldResolve);
+  }
+
+  // The environment's own secrets file adds to and overrides the shared one
+  const environmentSecrets = await readSecretsFile(getSecretsPath(configEnvironment), false);
+  return resolveSecretReferences(
+    { ...(secrets ?? {}), ...(environmentSecrets ?? {}) },
+    undefined,
+    shouldResolve
+  );
+}
+
+/**
+ * Whether a secret holds an SSH key path or password used to reach servers
+ */
+function isConnectionSecret(key: string): boolean {
+  return (
+    key.startsWith("SSH_KEY_") ||
+    key.startsWith("SSH_PASSWORD_") ||
+    key === "DEFAULT_SSH_PASSWORD"
+  );
+}
+
+/**
+ * Reads and validates a secrets file, returning null if it doesn't exist
+ */
+async function readSecretsFile(
+  secretsPath: string,
+  warnIfMissing: boolean = true
+): Promise<IopSecrets | null> {
   try {
     const secretsFile = await fs.readFile(secretsPath, "utf-8");
     const parsedSecrets: Record<string, string> = {};
@@ -299,8 +422,10 @@ export async function loadSecrets(): Promise<IopSecrets> {
   } catch (error) {
     const nodeError = error as NodeJS.ErrnoException;
     if (nodeError.code === "ENOENT") {
-      console.warn(`${secretsPath} not found. Proceeding with empty secrets.`);
-      return IopSecretsSchema.parse({}); // Return validated empty secrets
+      if (warnIfMissing) {
+        console.warn(`${secretsPath} not found. Proceeding with empty secrets.`);
+      }
+      return null;
     }
     if (error instanceof Error && error.message.startsWith("Invalid secrets")) {
       throw error; // Re-throw Zod validation error for secrets
@@ -309,3 +434,65 @@ export async function loadSecrets(): Promise<IopSecrets> {
     throw error;
   }
 }
+
+/**
+ * Applies set and unset secrets to the contents of a secrets file. Comments,
+ * blank lines and the order of other keys are kept, new keys are appended.
+ */
+export function updateSecretsContent(
+  content: string,
+  set: Record<string, string>,
+  unset: string[] = []
+): string {
+  const formatValue = (value: string) =>
+    /^\s|\s$|^["']|["']$/.test(value) ? `"${value}"` : value;
+
+  const pending = new Map(Object.entries(set));
+  const lines: string[] = [];
+  for (const line of content.split("\n")) {
+    const key = line.trim().startsWith("#") ? "" : line.split("=")[0].trim();
+    if (unset.includes(key)) {
+      continue;
+    }
+    if (pending.has(key)) {
+      lines.push(`${key}=${formatValue(pending.get(key)!)}`);
+      pending.delete(key);
+      continue;
+    }
+    lines.push(line);
+  }
+
+  while (lines.length > 0 && lines[lines.length - 1] === "") {
+    lines.pop();
+  }
+  for (const [key, value] of pending) {
+    lines.push(`${key}=${formatValue(value)}`);
+  }
+  return lines.length > 0 ? `${lines.join("\n")}\n` : "";
+}
+
+/**
+ * Sets and removes keys in the secrets file, or the selected environment's
+ * secrets file, creating it if needed. Returns the file's path.
+ */
+export async function updateSecrets(
+  set: Record<string, string>,
+  unset: string[] = []
+): Promise<string> {
+  const secretsPath = getSecretsPath(configEnvironment);
+  let content = "";
+  try {
+    content = await fs.readFile(secretsPath, "utf-8");
+  } catch (error) {
+    if ((error as NodeJS.ErrnoException).code !== "ENOENT") {
+      throw error;
+    }
+    await fs.mkdir(IOP_DIR, { recursive: true });
+  }
+
+  await fs.writeFile(secretsPath, updateSecretsContent(content, set, unset), {
+    encoding: "utf-8",
+    mode: 0o600,
+  });
+  return secretsPath;
+}
@@ -0,0 +1,67 @@
+function isPlainObject(value: unknown): value is Record<string, unknown> {
+  return typeof value === "object" && value !== null && !Array.isArray(value);
+}
+
+/**
+ * Merges an environment's overrides onto the base iop.yml. Mappings are merged
+ * key by key, lists and plain values replace the base, and null removes a key.
+ */
+export function mergeConfigOverrides(base: unknown, overrides: unknown): unknown {
+  if (!isPlainObject(base) || !isPlainObject(overrides)) {
+    return overrides;
+  }
+
+  const merged: Record<string, unknown> = { ...base };
+  for (const [key, value] of Object.entries(overrides)) {
+    if (value === null) {
+      delete merged[key];
+    } else {
+      merged[key] = key in base ? mergeConfigOverrides(base[key], value) : value;
+    }
+  }
+  return merged;
+}
+
+/**
+ * Returns the raw iop.yml for an environment. Each environment deploys as its
+ * own project, named <name>-<environment> unless it sets a name, so its
+ * containers, volumes and proxy routes are kept apart from the others.
+ */
+export function applyConfigEnvironment(
+  rawConfig: unknown,
+  environment?: string
+): unknown {
+  if (!environment || !isPlainObject(rawConfig)) {
+    return rawConfig;
+  }
+  const { environments, ...base } = rawConfig;
+
+  const defined = isPlainObject(environments) ? Object.keys(environments) : [];
+  if (!defined.includes(environment)) {
+    throw new Error(
+      `Unknown environment "${environment}" in iop.yml. ${
+        defined.length > 0
+          ? `Defined environments: ${defined.join(", ")}`
+          : "Add it under 'environments'."
+      }`
+    );
+  }
+
+  const overrides = (environments as Record<string, unknown>)[environment] ?? {};
+  const merged = mergeConfigOverrides(base, overrides) as Record<string, unknown>;
+
+  if (!isPlainObject(overrides) || overrides.name === undefined) {
+    merged.name = `${base.name}-${environment}`;
+  }
+
+  // Report deploys under the environment unless it picks a GitHub environment
+  const githubOverrides = isPlainObject(overrides) ? overrides.github : undefined;
+  if (
+    isPlainObject(merged.github) &&
+    !(isPlainObject(githubOverrides) && githubOverrides.environment !== undefined)
+  ) {
+    merged.github = { ...merged.github, environment };
+  }
+
+  return merged;
+}
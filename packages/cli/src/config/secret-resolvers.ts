@@ -0,0 +1,161 @@
+import { execFile } from "child_process";
+import { IopSecrets } from "./types";
+
+// Resolver CLIs may prompt for a login or unlock, e.g. 1Password's app
+const RESOLVE_TIMEOUT_MS = 60_000;
+
+/**
+ * Runs a local command and returns its stdout
+ */
+export type CommandRunner = (command: string, args: string[]) => Promise<string>;
+
+/**
+ * Looks up secret values in an external store. A secret whose value is a
+ * <scheme>://... reference is replaced by the value the resolver returns.
+ */
+export interface SecretResolver {
+  scheme: string;
+  resolve(reference: string): Promise<string>;
+}
+
+const runCommand: CommandRunner = (command, args) =>
+  new Promise((resolve, reject) => {
+    execFile(command, args, { timeout: RESOLVE_TIMEOUT_MS }, (error, stdout, stderr) => {
+      if (error) {
+        const notInstalled = (error as NodeJS.ErrnoException).code === "ENOENT";
+        reject(
+          new Error(notInstalled ? `${command} is not installed` : stderr.trim() || error.message)
+        );
+        return;
+      }
+      resolve(stdout);
+    });
+  });
+
+/**
+ * Splits "path#field" references, the field picking a key of the secret
+ */
+function splitField(reference: string): { path: string; field?: string } {
+  const index = reference.lastIndexOf("#");
+  return index < 0
+    ? { path: reference }
+    : { path: reference.substring(0, index), field: reference.substring(index + 1) };
+}
+
+/**
+ * The resolvers iop ships with, each using the store's own CLI and the login
+ * the user already has there
+ */
+export function builtinSecretResolvers(run: CommandRunner = runCommand): SecretResolver[] {
+  return [
+    {
+      // op://<vault>/<item>/<field>, read with the 1Password CLI
+      scheme: "op",
+      resolve: (reference) => run("op", ["read", "--no-newline", `op://${reference}`]),
+    },
+    {
+      // vault://<mount>/<path>#<field>, read from a KV secrets engine
+      scheme: "vault",
+      resolve: async (reference) => {
+        const { path, field } = splitField(reference);
+        if (!field) {
+          throw new Error("Vault references need a field, e.g. vault://secret/shop/db#password");
+        }
+        return (await run("vault", ["kv", "get", `-field=${field}`, path])).replace(/\n$/, "");
+      },
+    },
+    {
+      // aws-sm://<secret name or ARN>[#<JSON key>], from AWS Secrets Manager
+      scheme: "aws-sm",
+      resolve: async (reference) => {
+        const { path, field } = splitField(reference);
+        const value = (
+          await run("aws", [
+            "secretsmanager",
+            "get-secret-value",
+            "--secret-id",
+            path,
+            "--query",
+            "SecretString",
+            "--output",
+            "text",
+          ])
+        ).replace(/\n$/, "");
+        if (!field) {
+          return value;
+        }
+
+        let parsed: unknown;
+        try {
+          parsed = JSON.parse(value);
+        } catch {
+          throw new Error(`${path} isn't a JSON secret, remove #${field}`);
+        }
+        const picked = (parsed as Record<string, unknown>)?.[field];
+        if (picked === undefined || picked === null) {
+          throw new Error(`${path} has no key ${field}`);
+        }
+        return String(picked);
+      },
+    },
+  ];
+}
+
+const resolvers = new Map<string, SecretResolver>(
+  builtinSecretResolvers().map((resolver) => [resolver.scheme, resolver])
+);
+
+/**
+ * Adds a resolver for another secret store, or replaces one
+ */
+export function registerSecretResolver(resolver: SecretResolver): void {
+  resolvers.set(resolver.scheme, resolver);
+}
+
+/**
+ * Splits a secret value into its resolver and reference, or returns null for
+ * values that aren't references to a store with a resolver
+ */
+export function parseSecretReference(
+  value: string,
+  registry: Map<string, SecretResolver> = resolvers
+): { resolver: SecretResolver; reference: string } | null {
+  // References may contain spaces, e.g. op://Vault Name/Item/field
+  const match = /^([a-z][a-z0-9-]*):\/\/(.+)$/.exec(value.trim());
+  const resolver = match && registry.get(match[1]);
+  return resolver ? { resolver, reference: match![2].trim() } : null;
+}
+
+/**
+ * Replaces secrets that reference an external store with their values, or
+ * only the ones selected by shouldResolve. Each reference is looked up once,
+ * and secrets files without references are returned as they are without
+ * running anything.
+ */
+export async function resolveSecretReferences(
+  secrets: IopSecrets,
+  registry: Map<string, SecretResolver> = resolvers,
+  shouldResolve: (key: string) => boolean = () => true
+): Promise<IopSecrets> {
+  const lookups = new Map<string, Promise<string>>();
+  const resolved = await Promise.all(
+    Object.entries(secrets).map(async ([key, value]) => {
+      const parsed = shouldResolve(key) ? parseSecretReference(value, registry) : null;
+      if (!parsed) {
+        return [key, value] as const;
+      }
+
+      if (!lookups.has(value)) {
+        lookups.set(value, parsed.resolver.resolve(parsed.reference));
+      }
+      try {
+        return [key, await lookups.get(value)!] as const;
+      } catch (error) {
+        throw new Error(
+          `Could not resolve secret ${key} from ${value}: ${error instanceof Error ? error.message : error}`
+        );
+      }
+    })
+  );
+  return Object.fromEntries(resolved);
+}
@@ -0,0 +1,104 @@
+import { IopConfig, ServiceTemplateName } from "./types";
+
+export interface ServiceTemplate {
+  image: string;
+  dataPath: string; // Where the database keeps its files inside the container
+  healthCheck: string; // Shell command used as the container HEALTHCHECK
+  dumpCommand: string; // Writes a full dump to stdout
+  restoreCommand?: string; // Reads a dump from stdin, undefined when restore needs a file copy
+  dumpExtension: string;
+}
+
+// Built-in templates for common stateful services. Commands run inside the
+// container via 'sh -c', so they can use the image's own environment variables.
+export const SERVICE_TEMPLATES: Record<ServiceTemplateName, ServiceTemplate> = {
+  postgres: {
+    image: "postgres:16",
+    dataPath: "/var/lib/postgresql/data",
+    healthCheck: 'pg_isready -U "${POSTGRES_USER:-postgres}"',
+    dumpCommand: 'pg_dumpall -U "${POSTGRES_USER:-postgres}"',
+    restoreCommand: 'psql -U "${POSTGRES_USER:-postgres}" -d postgres',
+    dumpExtension: "sql",
+  },
+  mysql: {
+    image: "mysql:8",
+    dataPath: "/var/lib/mysql",
+    healthCheck: 'mysqladmin ping -h 127.0.0.1 -uroot -p"$MYSQL_ROOT_PASSWORD" --silent',
+    dumpCommand:
+      'mysqldump --all-databases --single-transaction -uroot -p"$MYSQL_ROOT_PASSWORD"',
+    restoreCommand: 'mysql -uroot -p"$MYSQL_ROOT_PASSWORD"',
+    dumpExtension: "sql",
+  },
+  redis: {
+    image: "redis:7",
+    dataPath: "/data",
+    healthCheck: "redis-cli ping",
+    dumpCommand: "redis-cli SAVE >/dev/null && cat /data/dump.rdb",
+    dumpExtension: "rdb",
+  },
+};
+
+/**
+ * Returns the template a service is based on, if any
+ */
+export function getServiceTemplate(service: {
+  template?: ServiceTemplateName;
+}): ServiceTemplate | undefined {
+  return service.template ? SERVICE_TEMPLATES[service.template] : undefined;
+}
+
+/**
+ * Returns the name of the volume a templated service stores its data in
+ */
+export function getTemplateVolumeName(serviceName: string): string {
+  return `${serviceName}-data`;
+}
+
+/**
+ * Fills in image and data volume for services that use a template.
+ * Explicit values in iop.yml always win over template defaults.
+ */
+export function applyServiceTemplates(config: IopConfig): IopConfig {
+  if (!config.services) {
+    return config;
+  }
+
+  const volumes = { ...(config.volumes || {}) };
+
+  const expand = <T extends { template?: ServiceTemplateName; image?: string; volumes?: string[] }>(
+    name: string,
+    service: T
+  ): T => {
+    const template = getServiceTemplate(service);
+    if (!template) {
+      return service;
+    }
+
+    const expanded = { ...service, image: service.image || template.image };
+
+    if (!service.volumes || service.volumes.length === 0) {
+      const volumeName = getTemplateVolumeName(name);
+      expanded.volumes = [`${volumeName}:${template.dataPath}`];
+      if (!volumes[volumeName]) {
+        volumes[volumeName] = { driver: "local" };
+      }
+    }
+
+    return expanded;
+  };
+
+  const services = Array.isArray(config.services)
+    ? config.services.map((service) => expand(service.name, service))
+    : Object.fromEntries(
+        Object.entries(config.services).map(([name, service]) => [
+          name,
+          expand(name, service),
+        ])
+      );
+
+  return {
+    ...config,
+    services,
+    ...(Object.keys(volumes).length > 0 ? { volumes } : {}),
+  };
+}
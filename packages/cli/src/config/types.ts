@@ -1,11 +1,148 @@
+import { isIP } from "net";
 import { z } from "zod";
 
+// Zod schema for a probe, an HTTP check of an app on its own path and schedule
+export const ProbeSchema = z.object({
+  path: z
+    .string()
+    .startsWith("/")
+    .optional()
+    .describe("Endpoint to check, the health check path by default"),
+  interval: z
+    .string()
+    .regex(/^[1-9]\d*(s|m|h)$/, "Expected a duration of at least a second, such as 5s")
+    .optional()
+    .describe("Time between checks, e.g. 5s"),
+  failure_threshold: z
+    .number()
+    .int()
+    .positive()
+    .optional()
+    .describe("Consecutive failures before the probe acts"),
+});
+export type ProbeConfig = z.infer<typeof ProbeSchema>;
+
 // Zod schema for HealthCheck
 export const HealthCheckSchema = z.object({
   path: z.string().optional().default("/up"), // Health check endpoint path
+  command: z
+    .string()
+    .optional()
+    .describe(
+      "Shell command run inside the container as its Docker HEALTHCHECK, e.g. for workers without an HTTP endpoint"
+    ),
+  startup: ProbeSchema.optional().describe(
+    "Checks new containers during a deploy, before traffic switches to them. Every 1s with up to 30 attempts by default."
+  ),
+  readiness: ProbeSchema.optional().describe(
+    "Checked by the proxy while the app serves traffic. Failing hosts leave rotation until the probe passes again."
+  ),
+  liveness: ProbeSchema.optional().describe(
+    "Checked by the proxy while the app runs. The app's containers are restarted when it keeps failing."
+  ),
 });
 export type HealthCheckConfig = z.infer<typeof HealthCheckSchema>;
 
+// Docker byte sizes such as "512m" or "1g"
+const ByteSizeSchema = z
+  .string()
+  .regex(/^\d+(\.\d+)?[bkmg]?$/i, "Expected a size such as 512m or 1g");
+
+// Zod schema for container resource limits and reservations
+export const ResourcesSchema = z.object({
+  cpus: z
+    .number()
+    .positive()
+    .optional()
+    .describe("Maximum CPU cores the container can use, e.g. 0.5"),
+  cpu_shares: z
+    .number()
+    .int()
+    .min(2)
+    .optional()
+    .describe("Relative CPU weight when CPUs are contended. Docker's default is 1024."),
+  memory: ByteSizeSchema.optional().describe("Hard memory limit, e.g. 512m. The container is killed above it."),
+  memory_reservation: ByteSizeSchema.optional().describe(
+    "Memory the container is guaranteed when the server runs low, e.g. 256m"
+  ),
+  pids_limit: z
+    .number()
+    .int()
+    .positive()
+    .optional()
+    .describe("Maximum number of processes and threads in the container"),
+  ulimits: z
+    .record(
+      z.string().regex(/^[a-z]+$/, "Expected a ulimit name such as nofile"),
+      z.union([z.number().int(), z.string().regex(/^-?\d+:-?\d+$/, "Expected soft:hard")])
+    )
+    .optional()
+    .describe("Ulimits by name, either a single value or soft:hard, e.g. nofile: 65536"),
+});
+export type ResourcesConfig = z.infer<typeof ResourcesSchema>;
+
+// Durations such as "30s" or "5m"
+const DurationSchema = z
+  .string()
+  .regex(/^\d+(ms|s|m|h)$/, "Expected a duration such as 30s or 5m");
+
+// Zod schema for horizontal autoscaling, applied by iop-proxy on the server
+export const AutoscaleSchema = z
+  .object({
+    min_replicas: z
+      .number()
+      .int()
+      .min(1)
+      .optional()
+      .describe("Fewest replicas to run. Defaults to replicas, which are never removed."),
+    max_replicas: z.number().int().min(1).describe("Most replicas to run"),
+    target_cpu: z
+      .number()
+      .positive()
+      .optional()
+      .describe("Average CPU percent per replica to aim for, 100 is one full core"),
+    target_requests_per_second: z
+      .number()
+      .positive()
+      .optional()
+      .describe("Requests per second each replica should handle"),
+    target_latency_ms: z
+      .number()
+      .int()
+      .positive()
+      .optional()
+      .describe("Average response time to aim for, in milliseconds"),
+    target_concurrency: z
+      .number()
+      .positive()
+      .optional()
+      .describe("Average requests in flight each replica should handle"),
+    scale_up_cooldown: DurationSchema.optional().describe(
+      "Wait after scaling before adding replicas. Defaults to 1m."
+    ),
+    scale_down_cooldown: DurationSchema.optional().describe(
+      "Wait after scaling before removing replicas. Defaults to 5m."
+    ),
+  })
+  .refine(
+    (data) =>
+      !!(
+        data.target_cpu ||
+        data.target_requests_per_second ||
+        data.target_latency_ms ||
+        data.target_concurrency
+      ),
+    {
+      message:
+        "Autoscaling needs at least one of target_cpu, target_requests_per_second, target_latency_ms or target_concurrency",
+    }
+  )
+  .refine((data) => data.min_replicas === undefined || data.min_replicas <= data.max_replicas, {
+    message: "min_replicas can't be more than max_replicas",
+    path: ["min_replicas"],
+  });
+export type AutoscaleConfig = z.infer<typeof AutoscaleSchema>;
+
 // Zod schema for Proxy Configuration (for HTTP services)
 export const ProxyConfigSchema = z.object({
   hosts: z.array(z.string()).optional(),
@@ -32,22 +169,454 @@ export const ProxyConfigSchema = z.object({
       "Forward X-Forwarded-For/Proto headers. Default depends on SSL status (true if SSL disabled, false if SSL enabled, unless overridden)."
     )
     .optional(),
-  response_timeout: z
-    .string()
-    .describe("Request timeout, e.g., '30s', '1m'. Default is '30s'.")
-    .optional(),
+  dial_timeout: DurationSchema.optional().describe(
+    "Time the proxy waits to connect to the app, e.g. '5s'. Default is '10s'."
+  ),
+  response_timeout: DurationSchema.optional().describe(
+    "Time the app has to send response headers, e.g. '30s', '1m'. Default is '30s'."
+  ),
+  request_timeout: DurationSchema.optional().describe(
+    "Time for a whole request including the response body, e.g. '5m'. Unlimited by default."
+  ),
+  stream_idle_timeout: DurationSchema.optional().describe(
+    "Time without response data before a response, such as an event stream, is cut off. Unlimited by default."
+  ),
+  streaming: z
+    .boolean()
+    .optional()
+    .describe(
+      "Flush responses to clients as they arrive, for Server-Sent Events and long polling. Waiting for response headers is then only bounded by request_timeout."
+    ),
+  internal: z
+    .boolean()
+    .optional()
+    .describe(
+      "Only serve clients on the proxy's internal networks, such as the mesh or a Tailscale VPN. Others get 404. Set proxy.internal_networks to change the networks."
+    ),
+  tls: z
+    .object({
+      min_version: z
+        .enum(["1.0", "1.1", "1.2", "1.3"])
+        .optional()
+        .describe("Minimum TLS version. Defaults to 1.2."),
+      cipher_suites: z
+        .array(z.string())
+        .optional()
+        .describe("Go cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"),
+      alpn: z
+        .array(z.enum(["h2", "http/1.1"]))
+        .optional()
+        .describe("Protocols offered to clients. Use [http/1.1] to disable HTTP/2."),
+    })
+    .optional()
+    .describe("TLS policy for this service's hosts"),
+  limits: z
+    .object({
+      max_concurrent_requests: z
+        .number()
+        .int()
+        .positive()
+        .optional()
+        .describe("In-flight requests before the proxy answers 503"),
+      bandwidth: z
+        .string()
+        .regex(/^\d+(\.\d+)?\s*(B|KB|MB|GB)?(\/s)?$/i, "Use a rate such as 512KB or 10MB")
+        .optional()
+        .describe("Response bandwidth per second shared by all requests, e.g. '10MB'"),
+    })
+    .optional()
+    .describe("Per-host limits that keep one service from starving others on a shared server"),
+  bake: z
+    .object({
+      duration: DurationSchema.describe(
+        "How long the previous containers are kept after the traffic switch, e.g. '5m'"
+      ),
+      interval: DurationSchema.optional().describe("Time between checks. Defaults to 10s."),
+      max_error_rate: z
+        .number()
+        .min(0)
+        .max(1)
+        .optional()
+        .describe("Fraction of 5xx responses that rolls the deploy back, e.g. 0.05"),
+      max_latency: DurationSchema.optional().describe(
+        "Average response time that rolls the deploy back, e.g. '500ms'"
+      ),
+      min_requests: z
+        .number()
+        .int()
+        .min(0)
+        .optional()
+        .describe("Requests needed before the limits apply. Defaults to 0."),
+    })
+    .optional()
+    .describe(
+      "Watch each deploy after its traffic switch and roll back to the previous containers when its error rate or latency regress"
+    ),
+  mode: z
+    .enum(["http", "passthrough"])
+    .optional()
+    .describe(
+      "'passthrough' forwards TLS connections for the hosts to app_port untouched, for apps that manage their own certificates. Default is 'http'."
+    ),
+  default_backend: z
+    .boolean()
+    .optional()
+    .describe("Serve requests for hosts the proxy doesn't know instead of answering 404"),
+  scale_to_zero: z
+    .boolean()
+    .optional()
+    .describe(
+      "Stop the service's containers when it gets no requests and start them again on the next one"
+    ),
+  idle_timeout: DurationSchema.optional().describe(
+    "Time without requests before a scale_to_zero service is stopped, at least 1m. Defaults to 15m."
+  ),
+  cold_start: z
+    .object({
+      max_wait: DurationSchema.optional().describe(
+        "Longest a request waits for a stopped service to start before getting a 503, between 1s and 5m. Defaults to 30s."
+      ),
+      queue_depth: z
+        .number()
+        .int()
+        .positive()
+        .optional()
+        .describe("Most requests waiting for the service to start at once. Defaults to 100."),
+      starting_page: z
+        .boolean()
+        .optional()
+        .describe("Answer browsers with a page that reloads until the service is up instead of holding the request"),
+      warm_pool: z
+        .number()
+        .int()
+        .min(0)
+        .optional()
+        .describe(
+          "Containers paused instead of stopped when idle. They keep their memory and resume in milliseconds."
+        ),
+    })
+    .optional()
+    .describe("Limits for requests that arrive while a scale_to_zero service is starting"),
 });
 export type ProxyConfig = z.infer<typeof ProxyConfigSchema>;
 
+// Zod schema for a named volume declared at the project level
+export const VolumeConfigSchema = z.object({
+  driver: z
+    .string()
+    .optional()
+    .default("local")
+    .describe("Docker volume driver. Defaults to 'local'."),
+  driver_opts: z
+    .record(z.string())
+    .optional()
+    .describe("Driver-specific options passed to 'docker volume create --opt'."),
+  size: z
+    .string()
+    .optional()
+    .describe(
+      "Expected size of the volume, e.g. '10GB'. Used as a hint for capacity planning and shown in 'iop volumes list'."
+    ),
+});
+export type VolumeConfig = z.infer<typeof VolumeConfigSchema>;
+
+// Zod schema for the project network created on each server
+export const NetworkConfigSchema = z.object({
+  driver: z
+    .string()
+    .optional()
+    .describe("Docker network driver. Defaults to Docker's 'bridge'."),
+  subnet: z
+    .string()
+    .regex(/^\d{1,3}(\.\d{1,3}){3}\/\d{1,2}$/, "Subnet must be in CIDR form, e.g. '172.28.0.0/16'")
+    .optional()
+    .describe(
+      "Subnet in CIDR form, e.g. '172.28.0.0/16'. Docker picks a free one when omitted."
+    ),
+});
+export type NetworkConfig = z.infer<typeof NetworkConfigSchema>;
+
+// Zod schema for Amazon ECR credentials, exchanged for a registry token on the server
+export const EcrCredentialsSchema = z.object({
+  region: z.string().describe("AWS region of the ECR registry, e.g. 'us-east-1'"),
+  access_key_secret: z.string(), // Secret key for AWS_ACCESS_KEY_ID
+  secret_key_secret: z.string(), // Secret key for AWS_SECRET_ACCESS_KEY
+});
+export type EcrCredentials = z.infer<typeof EcrCredentialsSchema>;
+
+// Zod schema for private registry authentication (Docker Hub, GHCR, ECR, self-hosted)
+export const RegistryConfigSchema = z
+  .object({
+    url: z
+      .string()
+      .optional()
+      .describe("Registry host, e.g. 'ghcr.io'. Inferred from the image name when omitted."),
+    username: z.string().optional(),
+    password_secret: z.string().optional(), // Secret key for the password or access token
+    ecr: EcrCredentialsSchema.optional().describe(
+      "Authenticate against Amazon ECR using AWS credentials instead of a username and password"
+    ),
+  })
+  .refine((data) => !!data.ecr || (!!data.username && !!data.password_secret), {
+    message: "Registry needs either 'username' and 'password_secret', or 'ecr' credentials",
+    path: ["username"],
+  });
+export type RegistryConfig = z.infer<typeof RegistryConfigSchema>;
+
+// Zod schema for the registry iop runs on one of the servers, behind the proxy
+export const BuiltinRegistrySchema = z.object({
+  host: z
+    .string()
+    .describe("Hostname the registry is served at, e.g. 'registry.example.com'. Needs a DNS record pointing at its server."),
+  server: z
+    .string()
+    .optional()
+    .describe("Server running the registry. Defaults to the first server in the config."),
+  username: z
+    .string()
+    .optional()
+    .default("iop")
+    .describe("User allowed to push and pull. Defaults to 'iop'."),
+  password_secret: z
+    .string()
+    .optional()
+    .default("IOP_REGISTRY_PASSWORD")
+    .describe("Secret holding the user's password. Defaults to 'IOP_REGISTRY_PASSWORD'."),
+  image: z
+    .string()
+    .optional()
+    .default("registry:2")
+    .describe("Registry image to run. Defaults to 'registry:2'."),
+});
+export type BuiltinRegistryConfig = z.infer<typeof BuiltinRegistrySchema>;
+
+// Zod schema for verifying a pre-built image's cosign signature
+export const ImageVerifySchema = z
+  .object({
+    key: z
+      .string()
+      .optional()
+      .describe("Path to the cosign public key, relative to iop.yml"),
+    identity: z
+      .string()
+      .optional()
+      .describe("Certificate identity of keyless signatures, e.g. the signing workflow's URL"),
+    issuer: z
+      .string()
+      .optional()
+      .describe(
+        "OIDC issuer of keyless signatures, e.g. 'https://token.actions.githubusercontent.com'"
+      ),
+  })
+  .refine((data) => !!data.key !== !!(data.identity && data.issuer), {
+    message: "Signature verification needs either 'key', or 'identity' and 'issuer'",
+    path: ["key"],
+  });
+export type ImageVerifyConfig = z.infer<typeof ImageVerifySchema>;
+
+// Severities of vulnerabilities, from least to most severe
+export const ScanSeveritySchema = z.enum(["UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"]);
+export type ScanSeverity = z.infer<typeof ScanSeveritySchema>;
+
+// Zod schema for scanning images for vulnerabilities before their containers start
+export const ImageScanSchema = z.object({
+  scanner: z
+    .string()
+    .default("trivy")
+    .describe("Scanner to run on the server. Defaults to trivy."),
+  block_on: z
+    .union([ScanSeveritySchema, z.literal("NONE")])
+    .default("CRITICAL")
+    .describe("Lowest severity that fails the deploy, or NONE to only report findings"),
+  ignore_unfixed: z
+    .boolean()
+    .default(false)
+    .describe("Leave out vulnerabilities without a fixed version yet"),
+  ignore: z
+    .array(z.string())
+    .optional()
+    .describe("Vulnerability IDs that were reviewed and accepted, e.g. CVE-2023-44487"),
+});
+export type ImageScanConfig = z.infer<typeof ImageScanSchema>;
+
+// Built-in service templates for common databases
+export const ServiceTemplateNameSchema = z.enum(["postgres", "mysql", "redis"]);
+export type ServiceTemplateName = z.infer<typeof ServiceTemplateNameSchema>;
+
+// Zod schema for scheduled database backups of templated services
+export const BackupConfigSchema = z.object({
+  schedule: z
+    .string()
+    .optional()
+    .default("0 3 * * *")
+    .describe("Cron expression for when backups run on the server. Defaults to daily at 03:00."),
+  retention: z
+    .number()
+    .min(1)
+    .optional()
+    .default(7)
+    .describe("Number of backups to keep on the server. Defaults to 7."),
+  s3: z
+    .object({
+      bucket: z.string(),
+      prefix: z.string().optional(),
+      region: z.string().optional(),
+      endpoint: z.string().optional().describe("Custom endpoint for S3-compatible storage"),
+      access_key_secret: z.string(), // Secret key for AWS_ACCESS_KEY_ID
+      secret_key_secret: z.string(), // Secret key for AWS_SECRET_ACCESS_KEY
+    })
+    .optional()
+    .describe("Also upload each backup to S3 or S3-compatible storage"),
+});
+export type BackupConfig = z.infer<typeof BackupConfigSchema>;
+
+// Zod schema for plain and secret environment variables
+const EnvironmentVariablesSchema = z.object({
+  plain: z.array(z.string()).optional(), // Array format for environment variables like ["KEY=VALUE"]
+  secret: z.array(z.string()).optional(),
+});
+
+const EnvironmentServerOverridesSchema = z
+  .record(EnvironmentVariablesSchema)
+  .optional()
+  .describe("Variables added or replaced on specific servers, keyed by server hostname");
+
+// Zod schema for a group of variables shared by several services
+export const EnvironmentGroupSchema = EnvironmentVariablesSchema.extend({
+  servers: EnvironmentServerOverridesSchema,
+});
+export type EnvironmentGroup = z.infer<typeof EnvironmentGroupSchema>;
+
+// Zod schema for the environment of a service
+const ServiceEnvironmentSchema = EnvironmentVariablesSchema.extend({
+  groups: z
+    .array(z.string())
+    .optional()
+    .describe("Names of env_groups to include, in order. The service's own variables win."),
+  servers: EnvironmentServerOverridesSchema,
+});
+
+// Zod schema for options that harden a container
+export const ContainerSecuritySchema = z.object({
+  user: z
+    .string()
+    .regex(
+      /^[A-Za-z0-9_][A-Za-z0-9_.-]*(:[A-Za-z0-9_][A-Za-z0-9_.-]*)?$/,
+      "Expected a user such as 1000, 1000:1000 or app"
+    )
+    .optional()
+    .describe("User the container runs as, a uid[:gid] or a user name known to the image"),
+  read_only_rootfs: z
+    .boolean()
+    .optional()
+    .describe("Mount the container's root filesystem read-only. Volumes and tmpfs mounts stay writable."),
+  cap_drop: z
+    .array(z.string().regex(/^[A-Za-z_]+$/, "Expected a capability such as ALL or NET_RAW"))
+    .optional()
+    .describe("Linux capabilities to drop, e.g. [ALL]"),
+  no_new_privileges: z
+    .boolean()
+    .optional()
+    .describe("Stop processes from gaining privileges, e.g. through setuid binaries"),
+  tmpfs: z
+    .array(
+      z
+        .string()
+        .regex(
+          /^(\/[A-Za-z0-9._-]+)+(:[a-z0-9=,]+)?$/,
+          "Expected a path with optional mount options, e.g. /tmp:size=64m"
+        )
+    )
+    .optional()
+    .describe("Writable in-memory directories, e.g. /tmp:size=64m, for apps with a read-only root filesystem"),
+});
+export type ContainerSecurityConfig = z.infer<typeof ContainerSecuritySchema>;
+
+// Zod schema for a sidecar container run next to each app container
+export const SidecarSchema = z.object({
+  image: z.string().describe("Docker image of the sidecar, e.g. gcr.io/cloud-sql-connectors/cloud-sql-proxy:2"),
+  command: z.string().optional().describe("Override the default command for the sidecar"),
+  environment: EnvironmentVariablesSchema.optional(),
+  volumes: z.array(z.string()).optional(),
+  resources: ResourcesSchema.optional(),
+  ...ContainerSecuritySchema.shape,
+});
+export type SidecarConfig = z.infer<typeof SidecarSchema>;
+
+const SidecarsSchema = z
+  .record(
+    z.string().regex(/^[a-z0-9][a-z0-9_-]*$/, "Sidecar names use lowercase letters, digits, - and _"),
+    SidecarSchema
+  )
+  .optional()
+  .describe(
+    "Containers started, stopped and replaced together with each app container, sharing its network so they are reachable on localhost"
+  );
+
+// Zod schema for a step run to completion before the containers of a service start
+export const InitStepSchema = z.object({
+  name: z
+    .string()
+    .regex(/^[a-z0-9][a-z0-9_-]*$/, "Init step names use lowercase letters, digits, - and _")
+    .optional()
+    .describe("Name used in logs and for the container. Defaults to the step's position."),
+  image: z.string().optional().describe("Docker image to run. Defaults to the service's own image."),
+  command: z.string().describe("Command to run, e.g. \"chown -R 1000:1000 /data\""),
+  environment: EnvironmentVariablesSchema.optional().describe("Added to the service's environment"),
+});
+export type InitStep = z.infer<typeof InitStepSchema>;
+
+const InitStepsSchema = z
+  .array(InitStepSchema)
+  .optional()
+  .describe(
+    "Steps run in order as short-lived containers on the service's network and volumes. Each must succeed before the service's containers start."
+  );
+
+// Zod schema for a secret written to a file in the containers of a service
+export const SecretFileSchema = z.object({
+  secret: z.string().describe("Name of the secret in .iop/secrets whose value is the file's content"),
+  path: z
+    .string()
+    .regex(/^(\/[A-Za-z0-9._-]+)+$/, "Secret file paths are absolute and use letters, digits, ., _ and -")
+    .describe("Where the file appears in the container, e.g. /run/secrets/gcp.json"),
+  mode: z
+    .string()
+    .regex(/^0?[0-7]{3}$/, "File modes are octal, e.g. 0400")
+    .default("0444")
+    .describe("Permissions of the file. Defaults to 0444 so apps running as any user can read it."),
+  encoding: z
+    .enum(["base64"])
+    .optional()
+    .describe("Decode the secret from base64 first, for multi-line or binary files in .iop/secrets"),
+});
+export type SecretFile = z.infer<typeof SecretFileSchema>;
+
+const SecretFilesSchema = z
+  .array(SecretFileSchema)
+  .optional()
+  .describe(
+    "Secrets mounted read-only as files from memory on the server, for apps that need key files or certificates instead of environment variables"
+  );
+
 // Zod schema for unified Service Entry without name (used in record format)
 export const ServiceEntryWithoutNameSchema = z.object({
   image: z.string().optional(), // e.g., "postgres:15" or "my-app/web" - optional when build is specified
   server: z.string().describe("Hostname or IP address of the target server"),
+  type: z
+    .enum(["worker"])
+    .optional()
+    .describe(
+      "'worker' for apps without an HTTP endpoint, such as queue consumers. Workers get blue-green deploys gated on container health instead of proxy routing."
+    ),
   replicas: z
     .number()
     .min(1)
     .default(1)
     .describe("Number of replicas to deploy for this service. Defaults to 1."),
+  autoscale: AutoscaleSchema.optional().describe(
+    "Add and remove replicas with load, based on CPU usage, request rate and response times"
+  ),
   build: z
     .object({
       context: z.string(),
@@ -64,29 +633,42 @@ export const ServiceEntryWithoutNameSchema = z.object({
     ),
   ports: z.array(z.string()).optional(), // e.g., ["3000", "5432:5432"]
   volumes: z.array(z.string()).optional(), // e.g., ["mydata:/data/db"]
-  environment: z
-    .object({
-      plain: z.array(z.string()).optional(), // Array format for environment variables like ["KEY=VALUE"]
-      secret: z.array(z.string()).optional(),
-    })
-    .optional(),
-  registry: z // Optional registry for pre-built images
-    .object({
-      url: z.string().optional(),
-      username: z.string(),
-      password_secret: z.string(), // Secret key for the password
-    })
+  environment: ServiceEnvironmentSchema.optional(),
+  secret_files: SecretFilesSchema,
+  registry: RegistryConfigSchema // Optional registry for pre-built images
     .optional()
     .describe(
       "Registry configuration for pre-built images. Not used for services with 'build' configuration."
     ),
+  verify: ImageVerifySchema.optional().describe(
+    "Verify the image's cosign signature before starting containers. Only for pre-built images."
+  ),
+  scan: z
+    .union([ImageScanSchema, z.literal(false)])
+    .optional()
+    .describe("Vulnerability scan of the service's image, replacing the project's. false skips it."),
   command: z.string().optional().describe("Override the default command for the container"),
   health_check: HealthCheckSchema.optional(),
+  init: InitStepsSchema,
+  resources: ResourcesSchema.optional().describe(
+    "CPU, memory and process limits so one service can't starve the server"
+  ),
+  ...ContainerSecuritySchema.shape,
   proxy: ProxyConfigSchema.optional(),
+  sidecars: SidecarsSchema,
+  template: ServiceTemplateNameSchema.optional().describe(
+    "Built-in database template (postgres, mysql, redis). Provides the image, data volume and health check."
+  ),
+  backup: BackupConfigSchema.optional().describe(
+    "Scheduled backups for templated database services"
+  ),
 }).refine((data) => {
   // Ensure either image or build is provided, but not both
   const hasImage = !!data.image;
   const hasBuild = !!data.build;
+  if (data.template) {
+    return !hasBuild; // Templates provide a default image
+  }
   return hasImage !== hasBuild; // XOR: exactly one must be true
 }, {
   message: "Service must have either 'image' or 'build', but not both",
@@ -99,11 +681,20 @@ export const ServiceEntrySchema = z.object({
   name: z.string(),
   image: z.string().optional(), // e.g., "postgres:15" or "my-app/web" - optional when build is specified
   server: z.string().describe("Hostname or IP address of the target server"),
+  type: z
+    .enum(["worker"])
+    .optional()
+    .describe(
+      "'worker' for apps without an HTTP endpoint, such as queue consumers. Workers get blue-green deploys gated on container health instead of proxy routing."
+    ),
   replicas: z
     .number()
     .min(1)
     .default(1)
     .describe("Number of replicas to deploy for this service. Defaults to 1."),
+  autoscale: AutoscaleSchema.optional().describe(
+    "Add and remove replicas with load, based on CPU usage, request rate and response times"
+  ),
   build: z
     .object({
       context: z.string(),
@@ -120,29 +711,42 @@ export const ServiceEntrySchema = z.object({
     ),
   ports: z.array(z.string()).optional(), // e.g., ["3000", "5432:5432"]
   volumes: z.array(z.string()).optional(), // e.g., ["mydata:/data/db"]
-  environment: z
-    .object({
-      plain: z.array(z.string()).optional(), // Array format for environment variables like ["KEY=VALUE"]
-      secret: z.array(z.string()).optional(),
-    })
-    .optional(),
-  registry: z // Optional registry for pre-built images
-    .object({
-      url: z.string().optional(),
-      username: z.string(),
-      password_secret: z.string(), // Secret key for the password
-    })
+  environment: ServiceEnvironmentSchema.optional(),
+  secret_files: SecretFilesSchema,
+  registry: RegistryConfigSchema // Optional registry for pre-built images
     .optional()
     .describe(
       "Registry configuration for pre-built images. Not used for services with 'build' configuration."
     ),
+  verify: ImageVerifySchema.optional().describe(
+    "Verify the image's cosign signature before starting containers. Only for pre-built images."
+  ),
+  scan: z
+    .union([ImageScanSchema, z.literal(false)])
+    .optional()
+    .describe("Vulnerability scan of the service's image, replacing the project's. false skips it."),
   command: z.string().optional().describe("Override the default command for the container"),
   health_check: HealthCheckSchema.optional(),
+  init: InitStepsSchema,
+  resources: ResourcesSchema.optional().describe(
+    "CPU, memory and process limits so one service can't starve the server"
+  ),
+  ...ContainerSecuritySchema.shape,
   proxy: ProxyConfigSchema.optional(),
+  sidecars: SidecarsSchema,
+  template: ServiceTemplateNameSchema.optional().describe(
+    "Built-in database template (postgres, mysql, redis). Provides the image, data volume and health check."
+  ),
+  backup: BackupConfigSchema.optional().describe(
+    "Scheduled backups for templated database services"
+  ),
 }).refine((data) => {
   // Ensure either image or build is provided, but not both
   const hasImage = !!data.image;
   const hasBuild = !!data.build;
+  if (data.template) {
+    return !hasBuild; // Templates provide a default image
+  }
   return hasImage !== hasBuild; // XOR: exactly one must be true
 }, {
   message: "Service must have either 'image' or 'build', but not both",
@@ -151,8 +755,241 @@ export const ServiceEntrySchema = z.object({
 export type ServiceEntry = z.infer<typeof ServiceEntrySchema>;
 
 
+// Zod schema for automatic DNS management
+export const DnsConfigSchema = z.object({
+  provider: z.literal("cloudflare"),
+  api_token_secret: z
+    .string()
+    .default("CLOUDFLARE_API_TOKEN")
+    .describe("Secret key holding a Cloudflare API token with DNS edit permission"),
+  zone_id: z
+    .string()
+    .optional()
+    .describe("Cloudflare zone ID. Looked up from the host name when omitted."),
+  proxied: z
+    .boolean()
+    .default(false)
+    .describe("Route traffic through Cloudflare's proxy (orange cloud)"),
+  ttl: z.number().int().default(1).describe("Record TTL in seconds, 1 means automatic"),
+});
+export type DnsConfig = z.infer<typeof DnsConfigSchema>;
+
+// Zod schema for proxy notification targets
+export const NotificationConfigSchema = z.object({
+  type: z.enum(["slack", "discord", "webhook"]),
+  url_secret: z
+    .string()
+    .describe("Secret key holding the incoming webhook URL"),
+  events: z
+    .array(z.string())
+    .optional()
+    .describe(
+      "Events to send, e.g. deployment.failed, or a category such as cert. Defaults to all events."
+    ),
+  template: z
+    .string()
+    .optional()
+    .describe("Go text/template for the message, with .Event, .Hostname, .Text, .Timestamp and .Metadata"),
+});
+export type NotificationConfig = z.infer<typeof NotificationConfigSchema>;
+
+export const AcmeConfigSchema = z.object({
+  directory: z
+    .string()
+    .default("letsencrypt")
+    .describe(
+      "Certificate authority: letsencrypt, letsencrypt-staging, zerossl, buypass, google or an ACME directory URL"
+    ),
+  email: z.string().optional().describe("Contact email registered with the CA"),
+  eab_kid_secret: z
+    .string()
+    .optional()
+    .describe("Secret key holding the External Account Binding key ID (ZeroSSL, Google)"),
+  eab_hmac_key_secret: z
+    .string()
+    .optional()
+    .describe("Secret key holding the External Account Binding HMAC key"),
+});
+export type AcmeConfig = z.infer<typeof AcmeConfigSchema>;
+
+// Zod schema for server hardening applied while preparing servers
+export const HardeningConfigSchema = z.object({
+  firewall: z
+    .union([z.boolean(), z.enum(["ufw", "nftables"])])
+    .default(true)
+    .describe(
+      "Deny incoming traffic except SSH, 80 and 443. true uses ufw, 'nftables' loads an nftables table instead."
+    ),
+  allow: z
+    .array(
+      z.union([
+        z.number().int().min(1).max(65535),
+        z.string().regex(/^\d+(\/(tcp|udp))?$/, "Expected a port such as 5432 or 27015/udp"),
+      ])
+    )
+    .optional()
+    .describe("Extra ports the firewall allows, e.g. ['8443', '27015/udp']"),
+  fail2ban: z
+    .boolean()
+    .default(true)
+    .describe("Ban addresses after repeated failed SSH logins"),
+  auto_updates: z
+    .boolean()
+    .default(true)
+    .describe("Install security updates daily with unattended-upgrades"),
+  deploy_user: z
+    .boolean()
+    .default(true)
+    .describe("Ensure ssh.username exists as a non-root user in the docker group"),
+});
+export type HardeningConfig = z.infer<typeof HardeningConfigSchema>;
+
+// Zod schema for the WireGuard mesh set up by `iop network setup`
+export const MeshConfigSchema = z.object({
+  subnet: z
+    .string()
+    .regex(/^(\d{1,3}\.){3}\d{1,3}\/(1[6-9]|2[0-9]|30)$/, "Expected an IPv4 CIDR such as 10.210.0.0/24")
+    .default("10.210.0.0/24")
+    .describe("Private subnet servers get their mesh addresses from"),
+  port: z
+    .number()
+    .int()
+    .min(1)
+    .max(65535)
+    .default(51820)
+    .describe("UDP port WireGuard listens on. The hardening firewall allows it."),
+  endpoints: z
+    .record(z.string(), z.string())
+    .optional()
+    .describe(
+      "Addresses peers reach a server on, by server, for servers whose SSH address isn't reachable from the others"
+    ),
+});
+export type MeshConfig = z.infer<typeof MeshConfigSchema>;
+
+// Zod schemas for where the log shipper set up by `iop logs setup` sends logs
+export const LokiDestinationSchema = z.object({
+  type: z.literal("loki"),
+  url: z.string().url().describe("Loki base URL, e.g. https://logs.example.com"),
+  username: z.string().optional().describe("Basic auth user, e.g. a Grafana Cloud instance ID"),
+  password_secret: z
+    .string()
+    .optional()
+    .describe("Secret key holding the basic auth password or API token"),
+  tenant_id: z.string().optional().describe("X-Scope-OrgID of multi-tenant Loki setups"),
+});
+
+export const S3DestinationSchema = z.object({
+  type: z.literal("s3"),
+  bucket: z.string().min(1).describe("Bucket the logs are written to"),
+  region: z.string().default("us-east-1").describe("Bucket region"),
+  prefix: z
+    .string()
+    .optional()
+    .describe("Key prefix before <project>/<app>/<date>/, e.g. 'logs/'"),
+  endpoint: z
+    .string()
+    .url()
+    .optional()
+    .describe("Endpoint of S3-compatible storage such as R2 or MinIO"),
+  access_key_id_secret: z
+    .string()
+    .optional()
+    .describe("Secret key holding the access key ID. The server's instance role is used when omitted."),
+  secret_access_key_secret: z
+    .string()
+    .optional()
+    .describe("Secret key holding the secret access key"),
+});
+
+export const SyslogDestinationSchema = z.object({
+  type: z.literal("syslog"),
+  address: z
+    .string()
+    .regex(/^[^\s:]+:\d+$/, "Expected host:port, e.g. logs.example.com:514")
+    .describe("Syslog server as host:port"),
+  protocol: z.enum(["udp", "tcp"]).default("udp"),
+});
+
+export const LogDestinationSchema = z.discriminatedUnion("type", [
+  LokiDestinationSchema,
+  S3DestinationSchema,
+  SyslogDestinationSchema,
+]);
+export type LogDestination = z.infer<typeof LogDestinationSchema>;
+
+// Zod schema for shipping container and proxy logs off the servers
+export const LogsConfigSchema = z.object({
+  destinations: z
+    .array(LogDestinationSchema)
+    .min(1, "Add at least one destination")
+    .describe("Where logs are sent: loki, s3 or syslog"),
+  proxy: z
+    .boolean()
+    .default(true)
+    .describe("Also ship the proxy's access log lines for the project's hosts"),
+  image: z
+    .string()
+    .default("timberio/vector:0.39.0-alpine")
+    .describe("Vector image the shipper runs"),
+});
+export type LogsConfig = z.infer<typeof LogsConfigSchema>;
+
+// Zod schema for the public status page the proxy serves for the project
+export const StatusPageConfigSchema = z.object({
+  host: z
+    .string()
+    .describe("Hostname the page is served at, e.g. 'status.example.com'. Needs a DNS record pointing at its server."),
+  title: z.string().optional().describe("Page title. Defaults to the project name."),
+  apps: z
+    .array(z.string())
+    .optional()
+    .describe("Apps shown on the page. Defaults to every app with a host on the page's server."),
+  server: z
+    .string()
+    .optional()
+    .describe("Server whose proxy serves the page and checks the apps. Defaults to the first service's server."),
+  ssl: z.boolean().default(true).describe("Serve the page over HTTPS"),
+});
+export type StatusPageConfig = z.infer<typeof StatusPageConfigSchema>;
+
+// Zod schema for a jump host that servers are reached through, like ssh -J
+export const SSHBastionSchema = z.object({
+  host: z.string().min(1).describe("Bastion hostname or IP"),
+  username: z
+    .string()
+    .optional()
+    .describe("Bastion SSH username. Defaults to ssh.username."),
+  port: z.number().int().min(1).max(65535).optional().describe("Bastion SSH port. Defaults to 22."),
+  key_file: z
+    .string()
+    .optional()
+    .describe("Private key for the bastion. Defaults to the key used for the server."),
+});
+export type SSHBastion = z.infer<typeof SSHBastionSchema>;
+
+// Zod schema for SSH settings that differ for a single server
+export const SSHServerOverrideSchema = z.object({
+  port: z.number().int().min(1).max(65535).optional().describe("SSH port of this server"),
+  key_file: z.string().optional().describe("Private key for this server"),
+  bastion: z
+    .union([SSHBastionSchema, z.literal(false)])
+    .optional()
+    .describe("Bastion for this server, or false to connect directly"),
+});
+export type SSHServerOverride = z.infer<typeof SSHServerOverrideSchema>;
+
+// Latest iop.yml schema version this CLI understands
+export const CONFIG_SCHEMA_VERSION = 1;
+
 // Zod schema for IopConfig - unified services model
 export const IopConfigSchema = z.object({
+  version: z
+    .number()
+    .int()
+    .positive()
+    .optional()
+    .describe("iop.yml schema version. Defaults to the latest version."),
   name: z.string().min(1, "Project name is required"), // Used for network naming etc.
   services: z
     .union([
@@ -169,8 +1006,13 @@ export const IopConfigSchema = z.object({
           "Global Docker registry (optional - only needed for services using private registries)"
         ), // Global Docker registry
       username: z.string().optional().describe("Global registry username"), // Global username
-      // Global password_secret should be defined in IopSecrets and referenced
-      // e.g. global_docker_password_secret: "DOCKER_REGISTRY_PASSWORD"
+      password_secret: z
+        .string()
+        .optional()
+        .describe("Secret key holding the global registry password. Defaults to DOCKER_REGISTRY_PASSWORD."),
+      ecr: EcrCredentialsSchema.optional().describe(
+        "Global Amazon ECR credentials, used when 'registry' is an ECR registry"
+      ),
     })
     .optional()
     .describe(
@@ -181,19 +1023,206 @@ export const IopConfigSchema = z.object({
       username: z.string().optional(), // Default SSH username
       port: z.number().optional(), // Default SSH port
       key_file: z.string().optional(), // Path to SSH private key file
+      bastion: SSHBastionSchema.optional().describe(
+        "Jump host to reach servers in private networks through"
+      ),
+      agent_forwarding: z
+        .boolean()
+        .optional()
+        .describe("Forward the local SSH agent to servers, e.g. for git over SSH"),
+      servers: z
+        .record(SSHServerOverrideSchema)
+        .optional()
+        .describe("Port, key file and bastion overrides per server hostname"),
     })
     .optional(),
+  registry: BuiltinRegistrySchema.optional().describe(
+    "Run a private registry on a server so images can be pushed there instead of to a third-party registry"
+  ),
+  network: NetworkConfigSchema.optional().describe(
+    "Driver and subnet of the project network, '<name>-network', when deploy creates it"
+  ),
+  volumes: z
+    .record(VolumeConfigSchema)
+    .optional()
+    .describe(
+      "Named volumes managed by iop. Services reference them as 'name:/path' and they survive redeploys."
+    ),
+  env_groups: z
+    .record(EnvironmentGroupSchema)
+    .optional()
+    .describe("Variables shared by services that list the group in 'environment.groups'"),
+  dns: DnsConfigSchema.optional().describe(
+    "Manage DNS records for proxy hosts automatically during deploy"
+  ),
+  notifications: z
+    .array(NotificationConfigSchema)
+    .optional()
+    .describe(
+      "Slack, Discord or webhook targets the proxy notifies about deployment, certificate and health events"
+    ),
+  acme: AcmeConfigSchema.optional().describe(
+    "Certificate authority the proxy requests certificates from. Defaults to Let's Encrypt."
+  ),
+  preview: z
+    .object({
+      domain: z
+        .string()
+        .describe("Base domain for preview environments, e.g. preview.example.com"),
+    })
+    .optional()
+    .describe(
+      "Preview environments. 'iop preview' serves the current branch at <branch>.<service>.<domain>."
+    ),
+  environments: z
+    .record(z.record(z.unknown()).nullable())
+    .optional()
+    .describe(
+      "Named environments such as staging, selected with --env. Each one's settings are merged over the rest of iop.yml and it deploys as the project <name>-<environment>."
+    ),
+  github: z
+    .object({
+      repository: z
+        .string()
+        .optional()
+        .describe("owner/repo. Detected from GITHUB_REPOSITORY or the git remote when omitted."),
+      token_secret: z
+        .string()
+        .default("GITHUB_TOKEN")
+        .describe("Secret key holding a GitHub token with deployments permission"),
+      environment: z
+        .string()
+        .default("production")
+        .describe("GitHub environment name deployments are reported under"),
+      commit_status: z
+        .boolean()
+        .default(false)
+        .describe("Also set an 'iop/deploy' commit status on the deployed commit"),
+    })
+    .optional()
+    .describe("Report deployments to the GitHub Deployments API"),
+  deploy: z
+    .object({
+      parallelism: z
+        .number()
+        .int()
+        .min(1)
+        .default(4)
+        .describe("App deployments that may run at once, across servers and independent proxy hosts"),
+    })
+    .optional()
+    .describe("How deployments are carried out"),
+  gc: z
+    .object({
+      retain: z
+        .number()
+        .int()
+        .min(0)
+        .default(3)
+        .describe("Previous releases to keep per image, in addition to the ones in use"),
+      enabled: z
+        .boolean()
+        .default(true)
+        .describe("Collect garbage automatically after each deployment"),
+    })
+    .optional()
+    .describe(
+      "Image garbage collection. Old release images and leftover stopped containers are removed from servers."
+    ),
+  hardening: HardeningConfigSchema.optional().describe(
+    "Harden servers while preparing them for deploys: firewall, fail2ban, automatic security updates and a non-root deploy user"
+  ),
+  mesh: MeshConfigSchema.optional().describe(
+    "Encrypted WireGuard network between the servers, set up with `iop network setup`"
+  ),
+  logs: LogsConfigSchema.optional().describe(
+    "Ship app container output and proxy access logs to Loki, S3 or syslog, labelled per app"
+  ),
+  status_page: StatusPageConfigSchema.optional().describe(
+    "Public status page showing the health and uptime of the project's apps, with incidents posted through `iop incident`"
+  ),
+  scan: ImageScanSchema.optional().describe(
+    "Scan every service's image for vulnerabilities before its containers start, failing the deploy at a severity"
+  ),
+  encryption: z
+    .object({
+      recipients: z
+        .array(z.string().regex(/^age1[a-z0-9]+$/, "Expected an age recipient, age1..."))
+        .min(1)
+        .describe("age recipients `iop env encrypt` encrypts values to, e.g. one per team member and one for CI"),
+    })
+    .optional()
+    .describe(
+      "Encrypted values in iop.yml, written as ENC[age,...] and decrypted when the config is loaded"
+    ),
   proxy: z
     .object({
       image: z
         .string()
         .describe("Custom Docker image for the iop proxy")
         .optional(),
+      servers: z
+        .array(z.string())
+        .optional()
+        .describe(
+          "Servers running an iop proxy besides the ones services deploy to, e.g. a server a service moved away from. Their routes are included in proxy status and checked for hosts registered twice."
+        ),
+      internal_networks: z
+        .array(
+          z.string().refine(isInternalNetwork, "Expected an address or CIDR such as 10.8.0.0/24")
+        )
+        .optional()
+        .describe(
+          "Client networks internal hosts are served to, replacing the default of loopback, Tailscale and the default mesh subnet 10.210.0.0/24"
+        ),
+      tracing: z
+        .object({
+          endpoint: z
+            .string()
+            .url()
+            .describe("OTLP/HTTP collector URL, e.g. http://otel-collector:4318"),
+          service_name: z
+            .string()
+            .default("iop-proxy")
+            .describe("Service name reported with the proxy's spans"),
+        })
+        .optional()
+        .describe("Export OpenTelemetry traces from the proxy"),
+      sentry: z
+        .object({
+          dsn: z
+            .string()
+            .url()
+            .describe("Sentry project DSN, e.g. https://<key>@o1.ingest.sentry.io/123"),
+          environment: z
+            .string()
+            .optional()
+            .describe("Environment reported with events, e.g. production"),
+        })
+        .optional()
+        .describe(
+          "Report proxy panics, failed deployments and repeated certificate errors to Sentry"
+        ),
     })
     .optional(),
 });
 export type IopConfig = z.infer<typeof IopConfigSchema>;
 
+/**
+ * Whether value is an address or CIDR the proxy accepts in IOP_INTERNAL_NETWORKS
+ */
+function isInternalNetwork(value: string): boolean {
+  const [address, prefix, ...rest] = value.split("/");
+  const family = isIP(address);
+  if (family === 0 || rest.length > 0) {
+    return false;
+  }
+  if (prefix === undefined) {
+    return true;
+  }
+  return /^\d+$/.test(prefix) && Number(prefix) <= (family === 4 ? 32 : 128);
+}
+
 // Zod schema for IopSecrets (simple key-value)
 export const IopSecretsSchema = z.record(z.string());
 export type IopSecrets = z.infer<typeof IopSecretsSchema>;
@@ -5,15 +5,43 @@ import {
   ServiceEntry,
   IopSecrets,
   IopConfig,
+  ResourcesConfig,
+  ContainerSecurityConfig,
 } from "../config/types";
 import { exec } from "child_process";
 import { promisify } from "util";
 import { getProjectNetworkName, processVolumes } from "../utils";
+import {
+  COSIGN_IMAGE,
+  COSIGN_KEY_PATH,
+  IMAGE_DIGEST_LABEL,
+  selectRepoDigest,
+} from "../utils/image-verification";
+import { DeployMetadata, readDeployMetadataLabels } from "../utils/deploy-metadata";
+import { SCAN_CACHE_DIR, ScanCounts, readScanCounts } from "../utils/image-scan";
+import {
+  ContainerSecretFile,
+  SECRET_FILES_LABEL,
+  buildSecretFileMountFlags,
+  buildWriteSecretFilesCommand,
+  getSecretFilesDir,
+} from "../utils/secret-files";
+import { logProgress } from "../utils/output";
 
 const execAsync = promisify(exec);
 
 export interface DockerNetworkOptions {
   name: string;
+  driver?: string;
+  subnet?: string;
+  labels?: Record<string, string>;
+}
+
+export interface DockerVolumeOptions {
+  name: string;
+  driver?: string;
+  driverOpts?: Record<string, string>;
+  labels?: Record<string, string>;
 }
 
 export interface DockerContainerOptions {
@@ -28,6 +56,132 @@ export interface DockerContainerOptions {
   labels?: Record<string, string>;
   configHash?: string; // For Docker Compose-style change detection
   command?: string; // Override container command
+  healthCheck?: string; // Shell command run as the container HEALTHCHECK
+  resources?: ResourcesConfig; // CPU, memory and process limits
+  secretFiles?: ContainerSecretFile[]; // Written to the server's tmpfs and mounted read-only
+  security?: ContainerSecurityConfig; // User, read-only root filesystem, capabilities and tmpfs mounts
+}
+
+// How a deploy checks new containers before traffic switches to them
+export interface StartupProbe {
+  path: string;
+  intervalMs: number;
+  attempts: number;
+}
+
+export const DEFAULT_STARTUP_PROBE: StartupProbe = {
+  path: "/up",
+  intervalMs: 1000,
+  attempts: 30,
+};
+
+// Resource limits applied to a running container, as reported by docker inspect
+export interface ContainerLimits {
+  cpus?: number;
+  cpuShares?: number;
+  memory?: number; // Bytes
+  memoryReservation?: number; // Bytes
+  pidsLimit?: number;
+}
+
+/**
+ * Reads the resource limits from a container's inspect HostConfig. Unlimited
+ * values are left out.
+ */
+export function parseContainerLimits(hostConfig: any): ContainerLimits {
+  const limits: ContainerLimits = {};
+  if (hostConfig?.NanoCpus > 0) {
+    limits.cpus = hostConfig.NanoCpus / 1e9;
+  } else if (hostConfig?.CpuQuota > 0 && hostConfig?.CpuPeriod > 0) {
+    limits.cpus = hostConfig.CpuQuota / hostConfig.CpuPeriod;
+  }
+  if (hostConfig?.CpuShares > 0) {
+    limits.cpuShares = hostConfig.CpuShares;
+  }
+  if (hostConfig?.Memory > 0) {
+    limits.memory = hostConfig.Memory;
+  }
+  if (hostConfig?.MemoryReservation > 0) {
+    limits.memoryReservation = hostConfig.MemoryReservation;
+  }
+  if (hostConfig?.PidsLimit > 0) {
+    limits.pidsLimit = hostConfig.PidsLimit;
+  }
+  return limits;
+}
+
+/**
+ * Converts resource limits to docker run flags
+ */
+export function buildResourceFlags(resources?: ResourcesConfig): string[] {
+  if (!resources) {
+    return [];
+  }
+
+  const flags: string[] = [];
+  if (resources.cpus !== undefined) {
+    flags.push(`--cpus ${resources.cpus}`);
+  }
+  if (resources.cpu_shares !== undefined) {
+    flags.push(`--cpu-shares ${resources.cpu_shares}`);
+  }
+  if (resources.memory) {
+    flags.push(`--memory ${resources.memory}`);
+  }
+  if (resources.memory_reservation) {
+    flags.push(`--memory-reservation ${resources.memory_reservation}`);
+  }
+  if (resources.pids_limit !== undefined) {
+    flags.push(`--pids-limit ${resources.pids_limit}`);
+  }
+  Object.entries(resources.ulimits || {}).forEach(([name, value]) => {
+    flags.push(`--ulimit ${name}=${value}`);
+  });
+  return flags;
+}
+
+/**
+ * Picks the hardening options from a service or sidecar, or undefined when it
+ * has none
+ */
+export function getContainerSecurity(
+  config: ContainerSecurityConfig
+): ContainerSecurityConfig | undefined {
+  const security: ContainerSecurityConfig = {
+    user: config.user,
+    read_only_rootfs: config.read_only_rootfs,
+    cap_drop: config.cap_drop,
+    no_new_privileges: config.no_new_privileges,
+    tmpfs: config.tmpfs,
+  };
+  return Object.values(security).some((value) => value !== undefined) ? security : undefined;
+}
+
+/**
+ * Converts hardening options to docker run flags
+ */
+export function buildSecurityFlags(security?: ContainerSecurityConfig): string[] {
+  if (!security) {
+    return [];
+  }
+
+  const flags: string[] = [];
+  if (security.user) {
+    flags.push(`--user ${security.user}`);
+  }
+  if (security.read_only_rootfs) {
+    flags.push("--read-only");
+  }
+  (security.cap_drop || []).forEach((capability) => {
+    flags.push(`--cap-drop ${capability.toUpperCase()}`);
+  });
+  if (security.no_new_privileges) {
+    flags.push("--security-opt no-new-privileges");
+  }
+  (security.tmpfs || []).forEach((mount) => {
+    flags.push(`--tmpfs ${mount}`);
+  });
+  return flags;
 }
 
 export interface DockerBuildOptions {
@@ -40,10 +194,19 @@ export interface DockerBuildOptions {
   verbose?: boolean;
 }
 
+export interface DockerImageInfo {
+  id: string;
+  repository: string;
+  tag: string;
+  createdAt: string;
+  size: string;
+}
+
 export class DockerClient {
   private sshClient?: SSHClient;
   private serverHostname?: string;
   private verbose: boolean = false;
+  private dnsServers: string[] = []; // Given to every container this client creates
 
   constructor(
     sshClient?: SSHClient,
@@ -62,9 +225,9 @@ export class DockerClient {
     if (!this.verbose) return;
 
     if (this.serverHostname) {
-      console.log(`[${this.serverHostname}] ${message}`);
+      logProgress(`[${this.serverHostname}] ${message}`);
     } else {
-      console.log(message);
+      logProgress(message);
     }
   }
 
@@ -138,7 +301,7 @@ export class DockerClient {
     verbose: boolean = false
   ): Promise<{ stdout: string; stderr: string }> {
     if (verbose) {
-      console.log(`Executing local command: ${command}`);
+      logProgress(`Executing local command: ${command}`);
     }
     try {
       const { stdout, stderr } = await execAsync(command);
@@ -182,11 +345,11 @@ export class DockerClient {
     buildCommand += ` \"${options.context}\"`;
 
     if (options.verbose) {
-      console.log(`Attempting to build image with command: ${buildCommand}`);
+      logProgress(`Attempting to build image with command: ${buildCommand}`);
     }
     await DockerClient._runLocalCommand(buildCommand, options.verbose);
     if (options.verbose) {
-      console.log("Docker build process completed.");
+      logProgress("Docker build process completed.");
     }
   }
 
@@ -197,11 +360,11 @@ export class DockerClient {
   ): Promise<void> {
     const command = `docker tag \"${sourceImage}\" \"${targetImage}\"`;
     if (verbose) {
-      console.log(`Attempting to tag image: ${command}`);
+      logProgress(`Attempting to tag image: ${command}`);
     }
     await DockerClient._runLocalCommand(command, verbose);
     if (verbose) {
-      console.log(
+      logProgress(
         `Successfully tagged \"${sourceImage}\" as \"${targetImage}\".`
       );
     }
@@ -217,11 +380,11 @@ export class DockerClient {
   ): Promise<void> {
     const command = `docker save \"${imageName}\" -o \"${outputPath}\"`;
     if (verbose) {
-      console.log(`Saving image to archive: ${command}`);
+      logProgress(`Saving image to archive: ${command}`);
     }
     await DockerClient._runLocalCommand(command, verbose);
     if (verbose) {
-      console.log(
+      logProgress(
         `Successfully saved image \"${imageName}\" to \"${outputPath}\".`
       );
     }
@@ -245,23 +408,23 @@ export class DockerClient {
       try {
         await execPromise("which pigz");
         if (verbose) {
-          console.log("pigz is available, using parallel compression");
+          logProgress("pigz is available, using parallel compression");
         }
 
         const pigzCommand = `docker save "${imageName}" | pigz > "${outputPath}"`;
         if (verbose) {
-          console.log(`Saving compressed image to archive: ${pigzCommand}`);
+          logProgress(`Saving compressed image to archive: ${pigzCommand}`);
         }
         await DockerClient._runLocalCommand(pigzCommand, verbose);
         if (verbose) {
-          console.log(
+          logProgress(
             `Successfully saved compressed image "${imageName}" to "${outputPath}" using pigz.`
           );
         }
         return;
       } catch (pigzCheckError) {
         if (verbose) {
-          console.log("pigz not available, trying gzip");
+          logProgress("pigz not available, trying gzip");
         }
       }
 
@@ -269,23 +432,23 @@ export class DockerClient {
       try {
         await execPromise("which gzip");
         if (verbose) {
-          console.log("gzip is available, using standard compression");
+          logProgress("gzip is available, using standard compression");
         }
 
         const gzipCommand = `docker save "${imageName}" | gzip > "${outputPath}"`;
         if (verbose) {
-          console.log(`Saving compressed image to archive: ${gzipCommand}`);
+          logProgress(`Saving compressed image to archive: ${gzipCommand}`);
         }
         await DockerClient._runLocalCommand(gzipCommand, verbose);
         if (verbose) {
-          console.log(
+          logProgress(
             `Successfully saved compressed image "${imageName}" to "${outputPath}" using gzip.`
           );
         }
         return;
       } catch (gzipCheckError) {
         if (verbose) {
-          console.log("gzip not available, falling back to uncompressed tar");
+          logProgress("gzip not available, falling back to uncompressed tar");
         }
         // Fall back to regular save if gzip is not available
         const uncompressedPath = outputPath.replace(".tar.gz", ".tar");
@@ -294,7 +457,7 @@ export class DockerClient {
       }
     } catch (error) {
       if (verbose) {
-        console.log(
+        logProgress(
           `Compression failed, falling back to uncompressed tar: ${error}`
         );
       }
@@ -316,11 +479,11 @@ export class DockerClient {
     // For simplicity, ensure imageName is the full path if not Docker Hub.
     const command = `docker push \"${imageName}\"`;
     if (verbose) {
-      console.log(`Attempting to push image: ${command}`);
+      logProgress(`Attempting to push image: ${command}`);
     }
     await DockerClient._runLocalCommand(command, verbose);
     if (verbose) {
-      console.log(
+      logProgress(
         `Successfully pushed \"${imageName}\"${
           registry ? " to " + registry : ""
         }.`
@@ -446,6 +609,57 @@ EOF`);
     }
   }
 
+  /**
+   * Login to an Amazon ECR registry by exchanging AWS credentials for a
+   * short-lived registry token on the server (no AWS CLI install required)
+   */
+  async loginWithEcr(
+    registry: string,
+    region: string,
+    accessKeyId: string,
+    secretAccessKey: string
+  ): Promise<boolean> {
+    this.log(`Logging into ECR registry: ${registry}...`);
+    if (!this.sshClient) {
+      this.logError("SSH client not available for ECR login operation.");
+      return false;
+    }
+
+    // Credentials go through a private env file so they never show up in the process list
+    const envFile = `/tmp/ecr_login_${Date.now()}.env`;
+    try {
+      await this.sshClient.exec(`cat > ${envFile} << 'EOF'
+AWS_ACCESS_KEY_ID=${accessKeyId}
+AWS_SECRET_ACCESS_KEY=${secretAccessKey}
+AWS_DEFAULT_REGION=${region}
+EOF`);
+      await this.sshClient.exec(`chmod 600 ${envFile}`);
+
+      try {
+        await this.sshClient.exec(
+          `docker run --rm --env-file ${envFile} amazon/aws-cli ecr get-login-password --region ${region} | docker login ${registry} -u AWS --password-stdin`
+        );
+      } catch (loginError) {
+        if (
+          !String(loginError).includes(
+            "WARNING! Your password will be stored unencrypted"
+          )
+        ) {
+          // Don't include the error message which might contain sensitive info
+          throw new Error("ECR login failed (see server logs for details)");
+        }
+      }
+
+      this.log(`Successfully logged into ECR registry: ${registry}.`);
+      return true;
+    } catch (error) {
+      this.logError(`Failed to log into ECR registry ${registry}: ${error}`);
+      return false;
+    } finally {
+      await this.sshClient.exec(`rm -f ${envFile}`).catch(() => {});
+    }
+  }
+
   /**
    * Logout from Docker registry
    */
@@ -478,6 +692,119 @@ EOF`);
     }
   }
 
+  /**
+   * Returns an image's registry digest, or its ID for images that were built
+   * or loaded rather than pulled. Null if the image isn't on the server.
+   */
+  async getImageDigest(image: string): Promise<string | null> {
+    try {
+      const result = await this.execRemote(
+        `image inspect ${image} --format '{{json .RepoDigests}}|{{.Id}}'`
+      );
+      const [repoDigests, id] = result.trim().split("|");
+      return selectRepoDigest(image, JSON.parse(repoDigests) || []) || id || null;
+    } catch {
+      return null;
+    }
+  }
+
+  /**
+   * Runs cosign verify in a container, with the registry credentials of the
+   * server's Docker client and the public key, if any, mounted
+   */
+  async verifyImageSignature(
+    args: string[],
+    publicKey?: string
+  ): Promise<{ success: boolean; output: string }> {
+    if (!this.sshClient) {
+      return { success: false, output: "SSH client not available" };
+    }
+
+    const keyFile = `/tmp/iop_cosign_${Date.now()}.pub`;
+    try {
+      await this.sshClient.exec("mkdir -p ~/.docker");
+      if (publicKey) {
+        await this.sshClient.exec(`cat > ${keyFile} << 'EOF'
+${publicKey.trim()}
+EOF`);
+      }
+      const output = await this.execRemote(
+        [
+          "run --rm -e DOCKER_CONFIG=/docker -v ~/.docker:/docker:ro",
+          publicKey ? `-v ${keyFile}:${COSIGN_KEY_PATH}:ro` : "",
+          COSIGN_IMAGE,
+          ...args.map((arg) => `'${arg.replace(/'/g, "'\\''")}'`),
+        ]
+          .filter(Boolean)
+          .join(" ")
+      );
+      return { success: true, output };
+    } catch (error) {
+      return { success: false, output: String(error) };
+    } finally {
+      if (publicKey) {
+        await this.sshClient.exec(`rm -f ${keyFile}`).catch(() => {});
+      }
+    }
+  }
+
+  /**
+   * Runs a vulnerability scanner's image to completion and returns its output,
+   * pulling the image first so pull progress doesn't end up in the output
+   */
+  async runImageScanner(
+    scannerImage: string,
+    args: string[],
+    volumes: string[] = []
+  ): Promise<string> {
+    const present = await this.execRemote(`image inspect --format '{{.Id}}' ${scannerImage}`)
+      .then(() => true)
+      .catch(() => false);
+    if (!present && !(await this.pullImage(scannerImage))) {
+      throw new Error(`Could not pull ${scannerImage}`);
+    }
+
+    return this.execRemote(
+      [
+        "run --rm",
+        ...volumes.map((volume) => `-v ${volume}`),
+        scannerImage,
+        ...args.map((arg) => `'${arg.replace(/'/g, "'\\''")}'`),
+      ].join(" ")
+    );
+  }
+
+  /**
+   * Reads a cached scan report from the server, null if there is none
+   */
+  async readScanCache(key: string): Promise<string | null> {
+    if (!this.sshClient) {
+      return null;
+    }
+    try {
+      return (await this.sshClient.exec(`cat ${SCAN_CACHE_DIR}/${key}.json`)) || null;
+    } catch {
+      return null;
+    }
+  }
+
+  /**
+   * Caches a scan report on the server, dropping reports older than a week
+   */
+  async writeScanCache(key: string, report: string): Promise<void> {
+    if (!this.sshClient) {
+      return;
+    }
+    try {
+      await this.sshClient.exec(`mkdir -p ${SCAN_CACHE_DIR} && cat > ${SCAN_CACHE_DIR}/${key}.json << 'EOF'
+${report}
+EOF`);
+      await this.sshClient.exec(`find ${SCAN_CACHE_DIR} -name '*.json' -mtime +7 -delete`);
+    } catch (error) {
+      this.logError(`Failed to cache scan report ${key}: ${error}`);
+    }
+  }
+
   /**
    * Force pull a Docker image, ensuring we get the latest version from the registry
    * This removes the image first if it exists locally, then pulls it again
@@ -603,6 +930,53 @@ EOF`);
     }
   }
 
+  /**
+   * Build an image on the remote server with BuildKit from an extracted build context
+   * @param contextDir Build context directory on the server
+   */
+  async buildOnServer(
+    contextDir: string,
+    options: Omit<DockerBuildOptions, "context" | "verbose">
+  ): Promise<boolean> {
+    if (!this.sshClient) {
+      this.logError("SSH client not available for remote build.");
+      return false;
+    }
+
+    let buildCommand = `cd ${contextDir} && DOCKER_BUILDKIT=1 docker build`;
+    if (options.dockerfile) {
+      buildCommand += ` -f "${options.dockerfile}"`;
+    }
+    options.tags?.forEach((tag) => {
+      buildCommand += ` -t "${tag}"`;
+    });
+    if (options.buildArgs) {
+      Object.entries(options.buildArgs).forEach(([key, value]) => {
+        // Escape special characters in value
+        const escapedValue = value.replace(/[\$"`\\]/g, "\\$&");
+        buildCommand += ` --build-arg ${key}="${escapedValue}"`;
+      });
+    }
+    if (options.target) {
+      buildCommand += ` --target "${options.target}"`;
+    }
+    if (options.platform) {
+      buildCommand += ` --platform "${options.platform}"`;
+    }
+    // BuildKit writes its progress to stderr
+    buildCommand += " . 2>&1";
+
+    this.log(`Building image on server: ${options.tags?.join(", ")}`);
+    try {
+      const output = await this.sshClient.exec(buildCommand);
+      this.log(output);
+      return true;
+    } catch (error) {
+      this.logError(`Remote build failed: ${error}`);
+      return false;
+    }
+  }
+
   /**
    * Check if a network exists
    */
@@ -634,9 +1008,22 @@ EOF`);
         this.log(`Docker network ${options.name} already exists.`);
         return true;
       }
-      // Ensure network name is project specific if generated via new utility elsewhere
+      let command = "network create";
+      if (options.driver) {
+        command += ` --driver ${options.driver}`;
+      }
+      if (options.subnet) {
+        command += ` --subnet ${options.subnet}`;
+      }
+      if (options.labels) {
+        Object.entries(options.labels).forEach(([key, value]) => {
+          command += ` --label "${key}=${value}"`;
+        });
+      }
+      command += ` ${options.name}`;
+
       this.log(`Creating Docker network: ${options.name}`);
-      await this.execRemote(`network create ${options.name}`);
+      await this.execRemote(command);
       this.log(`Created Docker network: ${options.name}`);
       return true;
     } catch (error) {
@@ -645,6 +1032,52 @@ EOF`);
     }
   }
 
+  /**
+   * List Docker networks matching a label filter
+   * @param labelFilter Docker label filter string (e.g., "iop.managed=true")
+   */
+  async listNetworks(labelFilter: string): Promise<string[]> {
+    try {
+      const result = await this.execRemote(
+        `network ls --filter "label=${labelFilter}" --format "{{.Name}}"`
+      );
+      if (!result.trim()) {
+        return [];
+      }
+      return result.trim().split("\n");
+    } catch (error) {
+      this.logError(`Failed to list networks by label ${labelFilter}: ${error}`);
+      return [];
+    }
+  }
+
+  /**
+   * Inspect a Docker network, null if it doesn't exist
+   */
+  async inspectNetwork(name: string): Promise<any> {
+    try {
+      const result = await this.execRemote(`network inspect ${name}`);
+      const inspectData = JSON.parse(result);
+      return inspectData[0] || null;
+    } catch {
+      return null;
+    }
+  }
+
+  /**
+   * Remove a Docker network
+   */
+  async removeNetwork(name: string): Promise<boolean> {
+    try {
+      await this.execRemote(`network rm ${name}`);
+      this.log(`Removed network ${name}.`);
+      return true;
+    } catch (error) {
+      this.logError(`Failed to remove network ${name}: ${error}`);
+      return false;
+    }
+  }
+
   /**
    * Check if a container is connected to a specific network
    */
@@ -689,6 +1122,142 @@ EOF`);
     }
   }
 
+  /**
+   * Disconnect a container from a network
+   */
+  async disconnectContainerFromNetwork(
+    containerName: string,
+    networkName: string
+  ): Promise<boolean> {
+    try {
+      await this.execRemote(
+        `network disconnect ${networkName} ${containerName}`
+      );
+      this.log(`Disconnected ${containerName} from network ${networkName}`);
+      return true;
+    } catch (error) {
+      this.logError(
+        `Failed to disconnect ${containerName} from network ${networkName}: ${error}`
+      );
+      return false;
+    }
+  }
+
+  /**
+   * Check if a Docker volume exists
+   */
+  async volumeExists(name: string): Promise<boolean> {
+    try {
+      const result = await this.execRemote(
+        `volume ls --filter "name=^${name}$" --format "{{.Name}}"`
+      );
+      return result.trim().split("\n").includes(name);
+    } catch {
+      return false;
+    }
+  }
+
+  /**
+   * Create a Docker volume if it does not already exist
+   */
+  async createVolume(options: DockerVolumeOptions): Promise<boolean> {
+    try {
+      if (await this.volumeExists(options.name)) {
+        this.log(`Docker volume ${options.name} already exists.`);
+        return true;
+      }
+
+      let command = "volume create";
+      if (options.driver) {
+        command += ` --driver ${options.driver}`;
+      }
+      if (options.driverOpts) {
+        Object.entries(options.driverOpts).forEach(([key, value]) => {
+          command += ` --opt "${key}=${value}"`;
+        });
+      }
+      if (options.labels) {
+        Object.entries(options.labels).forEach(([key, value]) => {
+          command += ` --label "${key}=${value}"`;
+        });
+      }
+      command += ` ${options.name}`;
+
+      this.log(`Creating Docker volume: ${options.name}`);
+      await this.execRemote(command);
+      this.log(`Created Docker volume: ${options.name}`);
+      return true;
+    } catch (error) {
+      this.logError(`Failed to create Docker volume ${options.name}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Remove a Docker volume
+   */
+  async removeVolume(name: string): Promise<boolean> {
+    try {
+      await this.execRemote(`volume rm ${name}`);
+      this.log(`Removed volume ${name}.`);
+      return true;
+    } catch (error) {
+      this.logError(`Failed to remove volume ${name}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * List Docker volumes matching a label filter
+   * @param labelFilter Docker label filter string (e.g., "iop.project=blog")
+   */
+  async listVolumes(labelFilter: string): Promise<string[]> {
+    try {
+      const result = await this.execRemote(
+        `volume ls --filter "label=${labelFilter}" --format "{{.Name}}"`
+      );
+      if (!result.trim()) {
+        return [];
+      }
+      return result.trim().split("\n");
+    } catch (error) {
+      this.logError(`Failed to list volumes by label ${labelFilter}: ${error}`);
+      return [];
+    }
+  }
+
+  /**
+   * Inspect a Docker volume
+   */
+  async inspectVolume(name: string): Promise<any> {
+    try {
+      const result = await this.execRemote(`volume inspect ${name}`);
+      const inspectData = JSON.parse(result);
+      return inspectData[0] || null;
+    } catch (error) {
+      this.logError(`Failed to inspect volume ${name}: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Find containers that mount a given volume
+   */
+  async findContainersUsingVolume(name: string): Promise<string[]> {
+    try {
+      const result = await this.execRemote(
+        `ps -a --filter "volume=${name}" --format "{{.Names}}"`
+      );
+      if (!result.trim()) {
+        return [];
+      }
+      return result.trim().split("\n");
+    } catch (error) {
+      this.logError(`Failed to find containers using volume ${name}: ${error}`);
+      return [];
+    }
+  }
+
   /**
    * Check if a container exists
    */
@@ -746,12 +1315,13 @@ EOF`);
   }
 
   /**
-   * Remove a container
+   * Remove a container along with its secret files
    */
   async removeContainer(name: string): Promise<boolean> {
     try {
       await this.execRemote(`rm ${name}`);
       this.log(`Removed container ${name}.`);
+      await this.removeSecretFiles(getSecretFilesDir(name));
       return true;
     } catch (error) {
       this.logError(`Failed to remove container ${name}: ${error}`);
@@ -759,79 +1329,180 @@ EOF`);
     }
   }
 
+  /**
+   * Writes secret files to a directory on the server's tmpfs, replacing the
+   * files already there
+   */
+  async writeSecretFiles(dir: string, files: ContainerSecretFile[]): Promise<void> {
+    if (!this.sshClient) {
+      throw new Error("SSH client not available to write secret files.");
+    }
+    await this.sshClient.exec(buildWriteSecretFilesCommand(dir, files));
+    this.log(`Wrote ${files.length} secret file(s) to ${dir}.`);
+  }
+
+  /**
+   * Removes a directory of secret files from the server, if there is one
+   */
+  async removeSecretFiles(dir: string): Promise<void> {
+    await this.sshClient?.exec(`rm -rf ${dir}`).catch((error) => {
+      this.logError(`Failed to remove secret files in ${dir}: ${error}`);
+    });
+  }
+
+  /**
+   * Sets the DNS servers of the containers this client creates. Docker's own
+   * resolver still answers for container names on their networks and
+   * forwards other queries to these.
+   */
+  setDnsServers(servers: string[]): void {
+    this.dnsServers = servers;
+  }
+
   /**
    * Create and run a new container
    */
   async createContainer(options: DockerContainerOptions): Promise<boolean> {
     try {
-      // Build the docker run command
-      let cmd = `run -d --name ${options.name}`;
-
-      // Add labels if specified
-      if (options.labels) {
-        Object.entries(options.labels).forEach(([key, value]) => {
-          cmd += ` --label ${key}="${value}"`;
-        });
+      if (options.secretFiles && options.secretFiles.length > 0) {
+        await this.writeSecretFiles(getSecretFilesDir(options.name), options.secretFiles);
       }
+      const cmd = `run -d --name ${options.name}${this.buildRunFlags(options)}`;
 
-      // Add network if specified
-      if (options.network) {
-        cmd += ` --network ${options.network}`;
+      // Execute the command
+      await this.execRemote(cmd);
+      this.log(`Created and started container ${options.name}.`);
+      return true;
+    } catch (error) {
+      this.logError(`Failed to create container ${options.name}: ${error}`);
+      return false;
+    }
+  }
 
-        // Add network aliases if specified
-        if (options.networkAliases && options.networkAliases.length > 0) {
-          options.networkAliases.forEach((alias) => {
-            cmd += ` --network-alias ${alias}`;
-          });
-        }
+  /**
+   * Runs a short-lived container to completion and removes it. A non-zero
+   * exit code fails the run.
+   */
+  async runContainer(
+    options: DockerContainerOptions
+  ): Promise<{ success: boolean; output: string }> {
+    this.log(`Running container ${options.name}...`);
+    try {
+      // Left behind if a previous run was interrupted
+      if (await this.containerExists(options.name)) {
+        await this.stopContainer(options.name);
+        await this.removeContainer(options.name);
       }
-
-      // Add restart policy
-      if (options.restart) {
-        cmd += ` --restart ${options.restart}`;
-      } else {
-        cmd += ` --restart unless-stopped`;
+      if (options.secretFiles && options.secretFiles.length > 0) {
+        await this.writeSecretFiles(getSecretFilesDir(options.name), options.secretFiles);
       }
-
-      // Add ports
-      if (options.ports && options.ports.length > 0) {
-        options.ports.forEach((port) => {
-          cmd += ` -p ${port}`;
-        });
+      const output = await this.execRemote(
+        `run --rm --name ${options.name}${this.buildRunFlags({ ...options, restart: "no" })}`
+      );
+      this.log(`Container ${options.name} completed successfully.`);
+      return { success: true, output };
+    } catch (error) {
+      this.logError(`Container ${options.name} failed: ${error}`);
+      return { success: false, output: String(error) };
+    } finally {
+      if (options.secretFiles && options.secretFiles.length > 0) {
+        await this.removeSecretFiles(getSecretFilesDir(options.name));
       }
+    }
+  }
 
-      // Add volumes
-      if (options.volumes && options.volumes.length > 0) {
-        options.volumes.forEach((volume) => {
-          cmd += ` -v ${volume}`;
-        });
-      }
+  /**
+   * Builds the docker run flags, image and command for a container
+   */
+  private buildRunFlags(options: DockerContainerOptions): string {
+    let cmd = "";
+    const hasSecretFiles = !!options.secretFiles && options.secretFiles.length > 0;
+
+    // Add labels if specified
+    const labels = hasSecretFiles
+      ? { ...options.labels, [SECRET_FILES_LABEL]: getSecretFilesDir(options.name) }
+      : options.labels;
+    if (labels) {
+      Object.entries(labels).forEach(([key, value]) => {
+        cmd += ` --label ${key}="${value}"`;
+      });
+    }
+
+    // Add network if specified
+    if (options.network) {
+      cmd += ` --network ${options.network}`;
 
-      // Add environment variables
-      if (options.envVars) {
-        Object.entries(options.envVars).forEach(([key, value]) => {
-          // Escape special characters in value
-          const escapedValue = value.replace(/[\$"`\\]/g, "\\$&");
-          cmd += ` -e ${key}="${escapedValue}"`;
+      // Add network aliases if specified
+      if (options.networkAliases && options.networkAliases.length > 0) {
+        options.networkAliases.forEach((alias) => {
+          cmd += ` --network-alias ${alias}`;
         });
       }
+    }
 
-      // Add the image
-      cmd += ` ${options.image}`;
+    // Add DNS servers
+    this.dnsServers.forEach((server) => {
+      cmd += ` --dns ${server}`;
+    });
 
-      // Add custom command if specified
-      if (options.command) {
-        cmd += ` ${options.command}`;
-      }
+    // Add restart policy
+    cmd += ` --restart ${options.restart || "unless-stopped"}`;
 
-      // Execute the command
-      await this.execRemote(cmd);
-      this.log(`Created and started container ${options.name}.`);
-      return true;
-    } catch (error) {
-      this.logError(`Failed to create container ${options.name}: ${error}`);
-      return false;
+    // Add container health check
+    if (options.healthCheck) {
+      const escapedHealthCheck = options.healthCheck.replace(/'/g, "'\\''");
+      cmd += ` --health-cmd '${escapedHealthCheck}' --health-interval 10s --health-timeout 5s --health-retries 5`;
     }
+
+    // Add resource limits
+    buildResourceFlags(options.resources).forEach((flag) => {
+      cmd += ` ${flag}`;
+    });
+
+    // Add user, capability and filesystem hardening
+    buildSecurityFlags(options.security).forEach((flag) => {
+      cmd += ` ${flag}`;
+    });
+
+    // Add ports
+    if (options.ports && options.ports.length > 0) {
+      options.ports.forEach((port) => {
+        cmd += ` -p ${port}`;
+      });
+    }
+
+    // Add volumes
+    if (options.volumes && options.volumes.length > 0) {
+      options.volumes.forEach((volume) => {
+        cmd += ` -v ${volume}`;
+      });
+    }
+
+    // Mount secret files
+    if (hasSecretFiles) {
+      buildSecretFileMountFlags(options.name, options.secretFiles!).forEach((flag) => {
+        cmd += ` ${flag}`;
+      });
+    }
+
+    // Add environment variables
+    if (options.envVars) {
+      Object.entries(options.envVars).forEach(([key, value]) => {
+        // Escape special characters in value
+        const escapedValue = value.replace(/[\$"`\\]/g, "\\$&");
+        cmd += ` -e ${key}="${escapedValue}"`;
+      });
+    }
+
+    // Add the image
+    cmd += ` ${options.image}`;
+
+    // Add custom command if specified
+    if (options.command) {
+      cmd += ` ${options.command}`;
+    }
+
+    return cmd;
   }
 
   /**
@@ -847,6 +1518,15 @@ EOF`);
         this.log(`Container ${options.name} is already running.`);
         return true;
       } else {
+        // Secret files are gone after a reboot
+        if (options.secretFiles && options.secretFiles.length > 0) {
+          try {
+            await this.writeSecretFiles(getSecretFilesDir(options.name), options.secretFiles);
+          } catch (error) {
+            this.logError(`Failed to write secret files of ${options.name}: ${error}`);
+            return false;
+          }
+        }
         return await this.startContainer(options.name);
       }
     } else {
@@ -928,7 +1608,8 @@ EOF`);
    * @param targetContainerName Name of the container to check
    * @param projectName The project name for network isolation
    * @param appPort The port the app is listening on (default: 80)
-   * @param healthCheckPath The health check endpoint path (default: "/up")
+   * @param probe The path to check, how often and how many times (default: /up every second, 30 times)
+   * @param onAttempt Called with each attempt's status code or error
    * @returns true if the health check endpoint returns 200, false otherwise
    */
   async checkHealthWithIopProxy(
@@ -937,21 +1618,24 @@ EOF`);
     targetContainerName: string,
     projectName: string,
     appPort: number = 80,
-    healthCheckPath: string = "/up"
+    probe: StartupProbe = DEFAULT_STARTUP_PROBE,
+    onAttempt?: (result?: string, error?: string) => Promise<void>
   ): Promise<boolean> {
     try {
       // Use project-specific target directly (dual alias solution)
       const appName = targetNetworkAlias; // Assuming targetNetworkAlias is the app name
       const projectSpecificTarget = `${projectName}-${appName}`;
+      const healthCheckPath = probe.path;
 
       this.log(
         `Using project-specific health check for ${targetContainerName} (project: ${projectName}, target: ${projectSpecificTarget}:${appPort}${healthCheckPath})`
       );
 
-      // Retry the health check if it fails. Give containers up to 30 seconds to start up.
+      // Retry the health check if it fails, for as many attempts as the probe allows
       let statusCode = "";
       let success = false;
-      const maxAttempts = 30;
+      const maxAttempts = probe.attempts;
+      const retryIn = `${probe.intervalMs / 1000} second${probe.intervalMs === 1000 ? "" : "s"}`;
 
       for (let attempt = 0; attempt < maxAttempts; attempt++) {
         try {
@@ -968,6 +1652,7 @@ EOF`);
 
           // Check if we got a successful status code
           const cleanStatusCode = statusCode.trim();
+          await onAttempt?.(cleanStatusCode);
           if (cleanStatusCode === "200") {
             success = true;
             this.log(
@@ -981,16 +1666,17 @@ EOF`);
               this.log(
                 `Health check attempt ${
                   attempt + 1
-                }/${maxAttempts} returned status: ${cleanStatusCode}, retrying in 1 second...`
+                }/${maxAttempts} returned status: ${cleanStatusCode}, retrying in ${retryIn}...`
               );
             }
           }
         } catch (execError) {
+          await onAttempt?.(undefined, String(execError));
           if (attempt < maxAttempts - 1) {
             this.log(
               `Health check attempt ${
                 attempt + 1
-              }/${maxAttempts} failed: ${execError}, retrying in 1 second...`
+              }/${maxAttempts} failed: ${execError}, retrying in ${retryIn}...`
             );
           } else {
             this.logError(
@@ -1001,9 +1687,9 @@ EOF`);
           }
         }
 
-        // Wait 1 second before next attempt (unless this was the last attempt)
+        // Wait before the next attempt (unless this was the last attempt)
         if (attempt < maxAttempts - 1) {
-          await new Promise((resolve) => setTimeout(resolve, 1000));
+          await new Promise((resolve) => setTimeout(resolve, probe.intervalMs));
         }
       }
 
@@ -1021,16 +1707,90 @@ EOF`);
     }
   }
 
+  /**
+   * Waits for a container without an HTTP endpoint to become healthy. With a
+   * Docker HEALTHCHECK the container has to report healthy, without one it
+   * has to keep running without restarting for settleSeconds, so a worker
+   * that crashes on boot fails the deployment.
+   * @param containerName Name of the container to wait for
+   * @param timeoutSeconds How long to wait in total (default: 120)
+   * @param settleSeconds How long a container without a HEALTHCHECK must stay up (default: 10)
+   * @returns true if the container is healthy, false otherwise
+   */
+  async waitForContainerHealth(
+    containerName: string,
+    timeoutSeconds: number = 120,
+    settleSeconds: number = 10
+  ): Promise<boolean> {
+    const deadline = Date.now() + timeoutSeconds * 1000;
+    let runningSince: number | undefined;
+
+    while (Date.now() < deadline) {
+      try {
+        const [container] = JSON.parse(
+          await this.execRemote(`inspect ${containerName}`)
+        );
+        const state = container.State;
+
+        if (!state.Running || state.Restarting || container.RestartCount > 0) {
+          this.logError(
+            `Container ${containerName} stopped while starting (${state.Status}, exit code ${state.ExitCode})`
+          );
+          return false;
+        }
+
+        if (state.Health) {
+          if (state.Health.Status === "healthy") {
+            this.log(`Container ${containerName} is healthy`);
+            return true;
+          }
+          if (state.Health.Status === "unhealthy") {
+            const lastCheck = state.Health.Log?.[state.Health.Log.length - 1];
+            this.logError(
+              `Container ${containerName} is unhealthy: ${lastCheck?.Output?.trim() || "no output"}`
+            );
+            return false;
+          }
+          this.log(`Container ${containerName} health status: ${state.Health.Status}`);
+        } else {
+          runningSince = runningSince ?? Date.now();
+          if (Date.now() - runningSince >= settleSeconds * 1000) {
+            this.log(
+              `Container ${containerName} has been running for ${settleSeconds}s without a health check`
+            );
+            return true;
+          }
+        }
+      } catch (error) {
+        this.log(`Could not inspect ${containerName}, retrying: ${error}`);
+      }
+
+      await new Promise((resolve) => setTimeout(resolve, 1000));
+    }
+
+    this.logError(
+      `Container ${containerName} did not become healthy within ${timeoutSeconds}s`
+    );
+    return false;
+  }
+
   /**
    * Execute a command inside a running container
+   * @param env Extra environment variables for the command
    */
   async execInContainer(
     containerName: string,
-    command: string
+    command: string,
+    env: Record<string, string> = {}
   ): Promise<{ success: boolean; output: string }> {
     this.log(`Executing command in container ${containerName}: ${command}`);
     try {
-      const output = await this.execRemote(`exec ${containerName} ${command}`);
+      const envFlags = Object.entries(env)
+        .map(([key, value]) => `-e ${key}='${value.replace(/'/g, "'\\''")}' `)
+        .join("");
+      const output = await this.execRemote(
+        `exec ${envFlags}${containerName} ${command}`
+      );
       this.log(`Successfully executed command in container ${containerName}.`);
       return { success: true, output };
     } catch (error) {
@@ -1067,7 +1827,7 @@ EOF`);
           this.log(
             `Command succeeded despite non-zero exit code in container ${containerName}:`
           );
-          console.log(errorOutput); // Show the full output in verbose mode
+          logProgress(errorOutput); // Show the full output in verbose mode
         } else {
           this.log(
             `Command succeeded despite non-zero exit code in container ${containerName}.`
@@ -1098,6 +1858,118 @@ EOF`);
     }
   }
 
+  /**
+   * List all images on the server with full IDs
+   */
+  async listImages(): Promise<DockerImageInfo[]> {
+    try {
+      const result = await this.execRemote(
+        `images --no-trunc --format "{{.ID}}|{{.Repository}}|{{.Tag}}|{{.CreatedAt}}|{{.Size}}"`
+      );
+      if (!result.trim()) {
+        return [];
+      }
+      return result
+        .trim()
+        .split("\n")
+        .map((line) => {
+          const [id, repository, tag, createdAt, size] = line.split("|");
+          return { id, repository, tag, createdAt, size };
+        });
+    } catch (error) {
+      this.logError(`Failed to list images: ${error}`);
+      return [];
+    }
+  }
+
+  /**
+   * Get the image IDs used by containers, keyed by container name
+   */
+  async getContainerImageIds(): Promise<Record<string, string>> {
+    if (!this.sshClient) {
+      throw new Error(
+        "SSH client is not initialized for remote Docker command."
+      );
+    }
+    try {
+      // ps -aq is empty on a fresh server and inspect would fail without arguments
+      const result = await this.sshClient.exec(
+        `ids=$(docker ps -aq); [ -z "$ids" ] || docker inspect --format "{{.Name}}|{{.Image}}" $ids`
+      );
+      const imageIds: Record<string, string> = {};
+      for (const line of result.trim().split("\n").filter(Boolean)) {
+        const [name, imageId] = line.split("|");
+        imageIds[name.replace(/^\//, "")] = imageId;
+      }
+      return imageIds;
+    } catch (error) {
+      this.logError(`Failed to get container images: ${error}`);
+      throw error;
+    }
+  }
+
+  /**
+   * Inspect every iop-managed container on the server, running or not,
+   * along with the iop-proxy container
+   * @returns Raw docker inspect output, one object per container
+   */
+  async inspectManagedContainers(): Promise<any[]> {
+    if (!this.sshClient) {
+      throw new Error(
+        "SSH client is not initialized for remote Docker command."
+      );
+    }
+    try {
+      // The proxy isn't labeled, so it's matched by name
+      const result = await this.sshClient.exec(
+        `ids=$( (docker ps -aq --filter "label=iop.managed=true"; docker ps -aq --filter "name=^iop-proxy$") | sort -u); [ -z "$ids" ] || docker inspect $ids`
+      );
+      return result.trim() ? JSON.parse(result) : [];
+    } catch (error) {
+      this.logError(`Failed to inspect managed containers: ${error}`);
+      throw error;
+    }
+  }
+
+  /**
+   * Find stopped (exited, created or dead) containers by label filter within a project
+   * @param labelFilter Docker label filter string (e.g., "iop.color")
+   * @param projectName Project name to scope the search to
+   */
+  async findStoppedContainersByLabelAndProject(
+    labelFilter: string,
+    projectName: string
+  ): Promise<string[]> {
+    try {
+      const result = await this.execRemote(
+        `ps -a --filter "label=${labelFilter}" --filter "label=iop.project=${projectName}" --filter "status=exited" --filter "status=created" --filter "status=dead" --format "{{.Names}}"`
+      );
+      if (!result.trim()) {
+        return [];
+      }
+      return result.trim().split("\n");
+    } catch (error) {
+      this.logError(
+        `Failed to find stopped containers by label ${labelFilter} in project ${projectName}: ${error}`
+      );
+      return [];
+    }
+  }
+
+  /**
+   * Remove an image by ID or reference
+   */
+  async removeImage(image: string): Promise<boolean> {
+    try {
+      await this.execRemote(`rmi ${image}`);
+      this.log(`Removed image ${image}.`);
+      return true;
+    } catch (error) {
+      this.logError(`Failed to remove image ${image}: ${error}`);
+      return false;
+    }
+  }
+
   /**
    * Inspect a container and return its configuration
    */
@@ -1123,7 +1995,8 @@ EOF`);
   static serviceToContainerOptions(
     service: ServiceEntry,
     projectName: string,
-    secrets: IopSecrets
+    secrets: IopSecrets,
+    declaredVolumes: string[] = []
   ): DockerContainerOptions {
     const containerName = `${projectName}-${service.name}`;
     const options: DockerContainerOptions = {
@@ -1132,9 +2005,10 @@ EOF`);
       network: `${projectName}-network`,
       networkAliases: [service.name], // Add service name as network alias (e.g., "db")
       ports: service.ports,
-      volumes: processVolumes(service.volumes, projectName),
+      volumes: processVolumes(service.volumes, projectName, declaredVolumes),
       envVars: {},
       command: service.command,
+      resources: service.resources,
       labels: {
         "iop.managed": "true",
         "iop.project": projectName,
@@ -1188,6 +2062,20 @@ EOF`);
     }
   }
 
+  /**
+   * Stop and remove the sidecars of an app container
+   * @param containerName Name of the app container the sidecars belong to
+   */
+  async removeSidecars(containerName: string): Promise<void> {
+    const sidecars = await this.findContainersByLabel(
+      `iop.sidecar-of=${containerName}`
+    );
+    for (const sidecar of sidecars) {
+      await this.stopContainer(sidecar);
+      await this.removeContainer(sidecar);
+    }
+  }
+
   /**
    * Find containers by label filter within a specific project
    * @param labelFilter Docker label filter string (e.g., "iop.app=web", "iop.color=blue")
@@ -1574,6 +2462,34 @@ EOF`);
     }
   }
 
+  /**
+   * Takes containers out of their network aliases while keeping them running
+   * and on the network, so a baking deploy can roll back to them
+   * @param containerNames Containers to reconnect without aliases
+   * @param networkName The network name
+   * @returns true if successful
+   */
+  async removeNetworkAliases(
+    containerNames: string[],
+    networkName: string
+  ): Promise<boolean> {
+    for (const containerName of containerNames) {
+      try {
+        await this.execRemote(
+          `network disconnect ${networkName} ${containerName} || true`
+        );
+        await this.execRemote(`network connect ${networkName} ${containerName}`);
+        this.log(`Removed network aliases of container ${containerName}`);
+      } catch (error) {
+        this.logError(
+          `Failed to remove network aliases of ${containerName}: ${error}`
+        );
+        return false;
+      }
+    }
+    return true;
+  }
+
   /**
    * Updates container labels to mark them as active/inactive
    * Note: Docker doesn't support updating labels after container creation,
@@ -1777,7 +2693,7 @@ EOF`);
   }
 
   /**
-   * Get container resource usage (CPU and memory)
+   * Get container resource usage (CPU, memory and processes)
    * @param containerName Name of the container
    * @returns Object with CPU and memory usage or null if error
    */
@@ -1785,11 +2701,12 @@ EOF`);
     cpuPercent: string;
     memoryUsage: string;
     memoryPercent: string;
+    pids: string;
   } | null> {
     try {
       // Use docker stats with --no-stream to get current stats
       const statsOutput = await this.execRemote(
-        `stats ${containerName} --no-stream --format "{{.CPUPerc}}\t{{.MemUsage}}\t{{.MemPerc}}"`
+        `stats ${containerName} --no-stream --format "{{.CPUPerc}}\t{{.MemUsage}}\t{{.MemPerc}}\t{{.PIDs}}"`
       );
 
       const lines = statsOutput.trim().split("\n");
@@ -1799,12 +2716,13 @@ EOF`);
 
       // Get data from first line (no header when using custom format)
       const dataLine = lines[0];
-      const [cpuPercent, memoryUsage, memoryPercent] = dataLine.split("\t");
+      const [cpuPercent, memoryUsage, memoryPercent, pids] = dataLine.split("\t");
 
       return {
         cpuPercent: cpuPercent?.trim() || "0%",
         memoryUsage: memoryUsage?.trim() || "0B / 0B",
         memoryPercent: memoryPercent?.trim() || "0%",
+        pids: pids?.trim() || "0",
       };
     } catch (error) {
       this.logError(
@@ -1825,8 +2743,13 @@ EOF`);
       cpuPercent: string;
       memoryUsage: string;
       memoryPercent: string;
+      pids: string;
     } | null;
+    limits: ContainerLimits;
     image: string | null;
+    imageDigest: string | null;
+    deployMetadata: DeployMetadata;
+    scanCounts: ScanCounts | null;
     createdAt: string | null;
     restartCount: number;
     exitCode: number | null;
@@ -1855,8 +2778,12 @@ EOF`);
 
       // Extract detailed info
       const image = container.Config?.Image || null;
+      const imageDigest = container.Config?.Labels?.[IMAGE_DIGEST_LABEL] || null;
+      const deployMetadata = readDeployMetadataLabels(container.Config?.Labels || {});
+      const scanCounts = readScanCounts(container.Config?.Labels || {}) || null;
       const createdAt = container.Created || null;
       const restartCount = container.RestartCount || 0;
+      const limits = parseContainerLimits(container.HostConfig);
       const exitCode = container.State?.ExitCode || null;
 
       // Extract port mappings
@@ -1892,7 +2819,11 @@ EOF`);
       return {
         uptime,
         stats,
+        limits,
         image,
+        imageDigest,
+        deployMetadata,
+        scanCounts,
         createdAt,
         restartCount,
         exitCode,
@@ -0,0 +1,236 @@
+import { DockerClient } from "../docker";
+import { SSHClient } from "../ssh";
+import { IopConfig, ServiceEntry } from "../config/types";
+import { IopProxyClient, ProxyHostInfo } from "./index";
+
+/**
+ * A host as reported by one of the cluster's proxies
+ */
+export interface ClusterHostInfo extends ProxyHostInfo {
+  server: string;
+}
+
+/**
+ * A host of the project routed by a proxy other than the one on its service's server
+ */
+export interface StaleHostRegistration {
+  host: string;
+  service: string;
+  server: string; // the proxy that should no longer route the host
+  owner: string; // the server the service deploys to
+}
+
+function normalizeServices(config: IopConfig): ServiceEntry[] {
+  return Array.isArray(config.services)
+    ? config.services
+    : Object.entries(config.services || {}).map(([name, service]) => ({
+        ...service,
+        name,
+      }));
+}
+
+/**
+ * Lists the servers whose proxies the project uses: the ones services deploy
+ * to, followed by the extra proxy servers in proxy.servers
+ */
+export function getProxyServers(config: IopConfig): string[] {
+  const servers = new Set<string>();
+  for (const service of normalizeServices(config)) {
+    if (service.server) {
+      servers.add(service.server);
+    }
+  }
+  for (const server of config.proxy?.servers || []) {
+    servers.add(server);
+  }
+  return Array.from(servers);
+}
+
+/**
+ * Groups the hosts reported by each proxy by hostname
+ */
+export function aggregateHosts(
+  proxyHostsByServer: Map<string, Record<string, ProxyHostInfo>>
+): Record<string, ClusterHostInfo[]> {
+  const hosts: Record<string, ClusterHostInfo[]> = {};
+  for (const [server, proxyHosts] of proxyHostsByServer) {
+    for (const [host, info] of Object.entries(proxyHosts)) {
+      if (!hosts[host]) {
+        hosts[host] = [];
+      }
+      hosts[host].push({ ...info, server });
+    }
+  }
+  return hosts;
+}
+
+/**
+ * Returns the hosts registered on more than one proxy. DNS only points a
+ * host at one of them, so the others serve stale or wrong routes.
+ */
+export function findDuplicateHosts(
+  hosts: Record<string, ClusterHostInfo[]>
+): Record<string, ClusterHostInfo[]> {
+  return Object.fromEntries(
+    Object.entries(hosts).filter(([, registrations]) => registrations.length > 1)
+  );
+}
+
+/**
+ * Finds the project's hosts that proxies other than their service's server
+ * still route, e.g. after the service moved to another server
+ */
+export function findStaleHostRegistrations(
+  config: IopConfig,
+  hosts: Record<string, ClusterHostInfo[]>
+): StaleHostRegistration[] {
+  const stale: StaleHostRegistration[] = [];
+  for (const service of normalizeServices(config)) {
+    for (const host of service.proxy?.hosts || []) {
+      for (const registration of hosts[host] || []) {
+        if (
+          registration.server !== service.server &&
+          registration.project === config.name
+        ) {
+          stale.push({
+            host,
+            service: service.name,
+            server: registration.server,
+            owner: service.server,
+          });
+        }
+      }
+    }
+  }
+  return stale;
+}
+
+/**
+ * Controls the proxies on several servers from one place. Requests for a
+ * host go to the proxy of the server it belongs to, reads are gathered from
+ * all of them. Connections are opened on first use and kept until close().
+ */
+export class IopProxyCluster {
+  private servers: string[];
+  private connect: (server: string) => Promise<SSHClient>;
+  private verbose: boolean;
+  private sshClients = new Map<string, SSHClient>();
+  private proxyClients = new Map<string, IopProxyClient>();
+
+  /**
+   * Create a new IopProxyCluster
+   * @param servers The servers running an iop proxy, see getProxyServers
+   * @param connect Opens an SSH connection to a server
+   * @param verbose Whether to enable verbose logging
+   */
+  constructor(
+    servers: string[],
+    connect: (server: string) => Promise<SSHClient>,
+    verbose: boolean = false
+  ) {
+    this.servers = servers;
+    this.connect = connect;
+    this.verbose = verbose;
+  }
+
+  /**
+   * Get the servers of the cluster
+   */
+  getServers(): string[] {
+    return [...this.servers];
+  }
+
+  /**
+   * Get the client for the proxy on a server
+   * @param server One of the cluster's servers
+   */
+  async proxyFor(server: string): Promise<IopProxyClient> {
+    if (!this.servers.includes(server)) {
+      throw new Error(`${server} is not one of the proxy servers`);
+    }
+
+    let proxyClient = this.proxyClients.get(server);
+    if (!proxyClient) {
+      const sshClient = await this.connect(server);
+      this.sshClients.set(server, sshClient);
+      const dockerClient = new DockerClient(sshClient, server, this.verbose);
+      proxyClient = new IopProxyClient(dockerClient, server, this.verbose);
+      this.proxyClients.set(server, proxyClient);
+    }
+    return proxyClient;
+  }
+
+  /**
+   * Ask every proxy which hosts it routes
+   * @returns The hosts of each proxy that answered, the servers without a
+   * running proxy, and why the others couldn't be reached
+   */
+  async getHostsByServer(): Promise<{
+    hosts: Map<string, Record<string, ProxyHostInfo>>;
+    stopped: string[];
+    unreachable: Map<string, string>;
+  }> {
+    const hosts = new Map<string, Record<string, ProxyHostInfo>>();
+    const stopped: string[] = [];
+    const unreachable = new Map<string, string>();
+
+    await Promise.all(
+      this.servers.map(async (server) => {
+        try {
+          const proxyClient = await this.proxyFor(server);
+          if (!(await proxyClient.isProxyRunning())) {
+            stopped.push(server);
+            return;
+          }
+          const proxyHosts = await proxyClient.getHosts();
+          if (proxyHosts) {
+            hosts.set(server, proxyHosts);
+          } else {
+            unreachable.set(server, "iop-proxy did not list its hosts");
+          }
+        } catch (error) {
+          unreachable.set(server, String(error));
+        }
+      })
+    );
+
+    // Keep the cluster's server order regardless of who answered first
+    const ordered = new Map<string, Record<string, ProxyHostInfo>>();
+    for (const server of this.servers) {
+      if (hosts.has(server)) {
+        ordered.set(server, hosts.get(server)!);
+      }
+    }
+    return {
+      hosts: ordered,
+      stopped: this.servers.filter((server) => stopped.includes(server)),
+      unreachable,
+    };
+  }
+
+  /**
+   * Gather the hosts of every reachable proxy, keyed by hostname
+   */
+  async getHosts(): Promise<Record<string, ClusterHostInfo[]>> {
+    return aggregateHosts((await this.getHostsByServer()).hosts);
+  }
+
+  /**
+   * Remove a host from the proxy on one server
+   * @returns true if the removal was successful
+   */
+  async removeHost(host: string, server: string): Promise<boolean> {
+    return (await this.proxyFor(server)).removeProxyConfig(host);
+  }
+
+  /**
+   * Close the connections the cluster opened
+   */
+  async close(): Promise<void> {
+    for (const sshClient of this.sshClients.values()) {
+      await sshClient.close();
+    }
+    this.sshClients.clear();
+    this.proxyClients.clear();
+  }
+}
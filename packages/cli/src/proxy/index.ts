@@ -1,5 +1,353 @@
 import { DockerClient } from "../docker";
 import { SSHClient } from "../ssh";
+import { getDeployLockOwner } from "../utils/deploy-lock";
+import { shellQuote } from "../utils/shell";
+import { logProgress } from "../utils/output";
+
+/**
+ * A notification target as stored by the proxy
+ */
+export interface ProxyNotificationTarget {
+  type: string;
+  url: string;
+  events?: string[];
+  template?: string;
+}
+
+/**
+ * The certificate authority settings pushed to the proxy
+ */
+export interface ProxyAcmeConfig {
+  directory: string;
+  email?: string;
+  eabKeyId?: string;
+  eabHmacKey?: string;
+}
+
+/**
+ * A host's TLS policy as stored by the proxy
+ */
+export interface ProxyTlsPolicy {
+  min_version?: string;
+  cipher_suites?: string[];
+  alpn?: string[];
+}
+
+/**
+ * A host's resource limits as configured in iop.yml
+ */
+export interface ProxyHostLimits {
+  max_concurrent_requests?: number;
+  bandwidth?: string;
+}
+
+/**
+ * A readiness or liveness probe as stored by the proxy
+ */
+export interface ProxyProbe {
+  path?: string;
+  interval?: string;
+  failure_threshold?: number;
+}
+
+/**
+ * A host's readiness and liveness probes as stored by the proxy
+ */
+export interface ProxyHostProbes {
+  readiness?: ProxyProbe;
+  liveness?: ProxyProbe;
+}
+
+/**
+ * How the proxy talks to a host's app, as configured in iop.yml
+ */
+export interface ProxyRouteOptions {
+  dial_timeout?: string;
+  response_timeout?: string;
+  request_timeout?: string;
+  stream_idle_timeout?: string;
+  streaming?: boolean;
+  internal?: boolean;
+}
+
+/**
+ * A deploy the proxy watches after the switch, with the containers it
+ * replaced kept running to roll back to
+ */
+export interface ProxyBake {
+  duration: string;
+  interval?: string;
+  max_error_rate?: number;
+  max_latency?: string;
+  min_requests?: number;
+  network: string;
+  aliases: string[];
+  color: string;
+  containers: string[];
+  previous_color: string;
+  previous: string[];
+}
+
+/**
+ * Limits for requests waiting on a scaled to zero app, as configured in iop.yml
+ */
+export interface ProxyColdStartPolicy {
+  max_wait?: string;
+  queue_depth?: number;
+  starting_page?: boolean;
+  warm_pool?: number;
+}
+
+/**
+ * An app's autoscaling policy as stored by the proxy
+ */
+export interface ProxyAutoscalePolicy {
+  min_replicas: number;
+  max_replicas: number;
+  target_cpu?: number;
+  target_request_rate?: number;
+  target_latency_ms?: number;
+  target_concurrency?: number;
+  scale_up_cooldown?: string;
+  scale_down_cooldown?: string;
+}
+
+/**
+ * A raw TCP/UDP forwarding rule as stored by the proxy
+ */
+export interface ProxyPortForward {
+  listen: number;
+  protocol: "tcp" | "udp";
+  target: string;
+  project?: string;
+  allow?: string[];
+}
+
+/**
+ * A service name the proxy's DNS server resolves, as <name>.internal and
+ * <name>.<project>.internal
+ */
+export interface ProxyServiceRecord {
+  name: string;
+  address?: string; // Mesh address of the server running the service
+  container?: string; // Container on the proxy's own server
+}
+
+/**
+ * Limits of one container a deploy runs, unset for none
+ */
+export interface ProxyQuotaContainer {
+  memory?: number; // Bytes
+  cpus?: number;
+}
+
+/**
+ * The containers a deploy runs for one service, including sidecars
+ */
+export interface ProxyQuotaWorkload {
+  service: string;
+  containers: ProxyQuotaContainer[];
+}
+
+/**
+ * What a project runs on a server: its containers and the sum of their limits
+ */
+export interface ProxyQuotaUsage {
+  project: string;
+  containers: number;
+  memory: number; // Bytes
+  cpus: number;
+  unlimited?: number; // Containers without a memory or CPU limit
+}
+
+/**
+ * A project's quota on a server, unset caps nothing
+ */
+export interface ProxyQuota {
+  project: string;
+  max_containers?: number;
+  max_memory?: number; // Bytes
+  max_cpus?: number;
+}
+
+/**
+ * A project's quota, if it has one, with what it currently runs
+ */
+export interface ProxyQuotaEntry {
+  project: string;
+  quota?: ProxyQuota;
+  usage?: ProxyQuotaUsage;
+}
+
+/**
+ * An entry in the proxy's audit log
+ */
+export interface ProxyAuditEntry {
+  time: string;
+  action: string;
+  host?: string;
+  details?: string;
+  actor: string;
+  source_ip: string;
+}
+
+/**
+ * Filters for the proxy's audit log
+ */
+export interface ProxyAuditQuery {
+  host?: string;
+  action?: string;
+  since?: string;
+  limit?: number;
+}
+
+/**
+ * Resource usage of an app container as sampled by the proxy. Network and disk
+ * values are totals since the container started.
+ */
+export interface ProxyContainerStats {
+  container: string;
+  project: string;
+  app: string;
+  time: string;
+  cpu_percent: number; // 100 is one full core
+  memory_bytes: number;
+  memory_limit_bytes: number;
+  network_rx_bytes: number;
+  network_tx_bytes: number;
+  disk_read_bytes: number;
+  disk_write_bytes: number;
+  pids: number;
+}
+
+/**
+ * Upper bounds in milliseconds of the proxy's latency histogram buckets. The
+ * last bucket counts the requests slower than all of them.
+ */
+export const LATENCY_BOUNDS_MS = [5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000];
+
+/**
+ * What an app's hosts served since the proxy started, with the current usage
+ * of its containers. Rates and percentiles come from the difference between
+ * two readings.
+ */
+export interface ProxyAppStats {
+  project: string;
+  app: string;
+  hosts?: string[];
+  requests: number;
+  errors: number; // Responses with a 5xx status
+  latency: number; // Total nanoseconds spent serving the requests
+  in_flight: number;
+  latency_buckets: number[]; // Requests per bucket of LATENCY_BOUNDS_MS
+  containers: number;
+  cpu_percent: number; // Summed over the containers, 100 is one full core
+  memory_bytes: number;
+  memory_limit_bytes: number;
+}
+
+/**
+ * One check of a host from outside, through DNS, TLS and the proxy
+ */
+export interface ProxyUptimeCheck {
+  time: string;
+  up: boolean;
+  status_code?: number;
+  latency_ms: number;
+  tls_ms?: number;
+  error?: string;
+}
+
+/**
+ * A host's uptime as measured by the proxy's uptime monitor. Percentages
+ * are null for periods without checks.
+ */
+export interface ProxyUptimeReport {
+  host: string;
+  project: string;
+  app?: string;
+  up: boolean;
+  last_check?: ProxyUptimeCheck;
+  down_since?: string;
+  uptime_24h: number | null;
+  uptime_7d: number | null;
+  uptime_30d: number | null;
+  uptime_90d: number | null;
+  avg_latency_ms: number; // Over the last 24 hours
+}
+
+// App name of the hosts the proxy deploys for status pages
+export const STATUS_PAGE_APP = "status-page";
+
+export type ProxyIncidentStatus = "investigating" | "identified" | "monitoring" | "resolved";
+
+/**
+ * A project's status page as stored by the proxy
+ */
+export interface ProxyStatusPage {
+  project: string;
+  host: string;
+  title?: string;
+  apps?: string[];
+  ssl: boolean;
+}
+
+/**
+ * An incident posted on a status page
+ */
+export interface ProxyIncident {
+  id: string;
+  project: string;
+  title: string;
+  status: ProxyIncidentStatus;
+  apps?: string[]; // Affected apps, every app when empty
+  updates: Array<{ time: string; status: ProxyIncidentStatus; message?: string }>;
+  created_at: string;
+  resolved_at?: string;
+}
+
+/**
+ * Steps of a deployment timeline, in the order they run
+ */
+export type ProxyDeploymentStep =
+  | "build"
+  | "push"
+  | "scan"
+  | "start"
+  | "health_check"
+  | "switch"
+  | "cleanup";
+
+/**
+ * Progress of a deployment step reported to the proxy. Without a status only
+ * the attempt is recorded.
+ */
+export interface ProxyDeploymentStepUpdate {
+  step: ProxyDeploymentStep;
+  status?: "running" | "succeeded" | "failed" | "skipped";
+  message?: string;
+  error?: string;
+  attempt?: { result?: string; error?: string };
+}
+
+/**
+ * A host as reported by the proxy's host list
+ */
+export interface ProxyHostInfo {
+  project: string;
+  target: string;
+  app: string;
+  ssl_enabled: boolean;
+  healthy: boolean;
+  last_health_check?: string;
+  stopped?: boolean; // Stopped with iop stop, down until started or deployed
+  alias_of?: string; // Custom domain serving the same backend as this host
+  on_demand?: boolean; // Only holds an on-demand certificate
+  certificate?: {
+    status: string;
+    expires_at?: string;
+  };
+}
 
 /**
  * Client for interacting with the iop-proxy service
@@ -32,9 +380,9 @@ export class IopProxyClient {
     if (!this.verbose) return;
 
     if (this.serverHostname) {
-      console.log(`[${this.serverHostname}] ${message}`);
+      logProgress(`[${this.serverHostname}] ${message}`);
     } else {
-      console.log(message);
+      logProgress(message);
     }
   }
 
@@ -49,6 +397,17 @@ export class IopProxyClient {
     }
   }
 
+  /**
+   * Run an iop-proxy command, passing along who is running it for the proxy's audit log
+   */
+  private execInProxy(
+    command: string
+  ): Promise<{ success: boolean; output: string }> {
+    return this.dockerClient.execInContainer("iop-proxy", command, {
+      IOP_ACTOR: getDeployLockOwner(),
+    });
+  }
+
   /**
    * Check if the iop-proxy container is running
    * @returns true if the iop-proxy container exists and is running
@@ -84,6 +443,11 @@ export class IopProxyClient {
    * @param targetPort The port on the target container
    * @param projectName The name of the project (used for network connectivity)
    * @param healthPath The health check endpoint path (default: "/up")
+   * @param mode "http" to terminate TLS at the proxy, "passthrough" to forward TLS to the container
+   * @param options Timeouts, streaming and internal, unset ones use the proxy's defaults
+   * @param metadata Recorded in the host's deployment history, e.g. sha and actor
+   * @param deploymentId Timeline whose switch step this is
+   * @param bake Keep the replaced containers and roll back to them if the deploy regresses
    * @returns true if the configuration was successful
    */
   async configureProxy(
@@ -91,14 +455,19 @@ export class IopProxyClient {
     targetContainer: string,
     targetPort: number,
     projectName: string,
-    healthPath: string = "/up"
+    healthPath: string = "/up",
+    mode: "http" | "passthrough" = "http",
+    options: ProxyRouteOptions = {},
+    metadata: Record<string, string> = {},
+    deploymentId?: string,
+    bake?: ProxyBake
   ): Promise<boolean> {
     try {
       // Build the command arguments
       const args = [
         "deploy",
         "--host",
-        host,
+        shellQuote(host),
         "--target",
         `${targetContainer}:${targetPort}`,
         "--project",
@@ -106,13 +475,62 @@ export class IopProxyClient {
         "--health-path",
         healthPath,
         "--ssl",
+        "--mode",
+        mode,
       ];
+      const timeoutFlags: [Exclude<keyof ProxyRouteOptions, "streaming" | "internal">, string][] = [
+        ["dial_timeout", "--dial-timeout"],
+        ["response_timeout", "--response-timeout"],
+        ["request_timeout", "--request-timeout"],
+        ["stream_idle_timeout", "--stream-idle-timeout"],
+      ];
+      for (const [key, flag] of timeoutFlags) {
+        const value = options[key];
+        if (value) {
+          args.push(flag, shellQuote(value));
+        }
+      }
+      if (options.streaming) {
+        args.push("--streaming");
+      }
+      if (options.internal) {
+        args.push("--internal");
+      }
+      for (const [key, value] of Object.entries(metadata)) {
+        args.push("--meta", shellQuote(`${key}=${value}`));
+      }
+      if (deploymentId) {
+        args.push("--deployment", shellQuote(deploymentId));
+      }
+      if (bake) {
+        args.push("--bake", shellQuote(bake.duration));
+        if (bake.interval) {
+          args.push("--bake-interval", shellQuote(bake.interval));
+        }
+        if (bake.max_error_rate !== undefined) {
+          args.push("--bake-max-error-rate", String(bake.max_error_rate));
+        }
+        if (bake.max_latency) {
+          args.push("--bake-max-latency", shellQuote(bake.max_latency));
+        }
+        if (bake.min_requests !== undefined) {
+          args.push("--bake-min-requests", String(bake.min_requests));
+        }
+        args.push("--bake-network", shellQuote(bake.network));
+        args.push("--bake-color", bake.color, "--bake-previous-color", bake.previous_color);
+        for (const [flag, values] of [
+          ["--bake-alias", bake.aliases],
+          ["--bake-container", bake.containers],
+          ["--bake-previous", bake.previous],
+        ] as const) {
+          for (const value of values) {
+            args.push(flag, shellQuote(value));
+          }
+        }
+      }
 
       const command = `/usr/local/bin/iop-proxy ${args.join(" ")}`;
-      const execResult = await this.dockerClient.execInContainer(
-        "iop-proxy",
-        command
-      );
+      const execResult = await this.execInProxy(command);
 
       if (this.verbose) {
         this.log(`Proxy configuration result: ${execResult.output.trim()}`);
@@ -133,6 +551,33 @@ export class IopProxyClient {
     }
   }
 
+  /**
+   * Check a host's DNS before the proxy orders its certificate: the host
+   * points at the server, its CAA records allow the CA and DNSSEC validates
+   * @param host The hostname to check
+   * @returns The DNS changes needed, empty when there are none or the check couldn't run
+   */
+  async precheckCertificate(host: string): Promise<string[]> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy cert-precheck --host ${shellQuote(host)} --json`
+      );
+
+      // The command fails when there are problems, after printing them
+      const line = execResult.output
+        .split("\n")
+        .find((output) => output.trim().startsWith("["));
+      if (!line) {
+        this.log(`Certificate precheck for ${host} gave no result: ${execResult.output.trim()}`);
+        return [];
+      }
+      return JSON.parse(line) || [];
+    } catch (error) {
+      this.log(`Failed to precheck certificate for ${host}: ${error}`);
+      return [];
+    }
+  }
+
   /**
    * Remove a host configuration from the iop-proxy
    * @param host The hostname to remove
@@ -151,13 +596,10 @@ export class IopProxyClient {
       this.log(`Removing iop-proxy configuration for host: ${host}`);
 
       // Build the proxy removal command
-      const proxyCmd = `iop-proxy remove --host ${host}`;
+      const proxyCmd = `iop-proxy remove --host ${shellQuote(host)}`;
 
       // Execute the command in the iop-proxy container
-      const execResult = await this.dockerClient.execInContainer(
-        "iop-proxy",
-        proxyCmd
-      );
+      const execResult = await this.execInProxy(proxyCmd);
 
       if (execResult.success) {
         this.log(`Successfully removed iop-proxy configuration for ${host}`);
@@ -191,10 +633,7 @@ export class IopProxyClient {
       this.log("Listing iop-proxy configurations");
 
       // Execute the list command in the iop-proxy container
-      const execResult = await this.dockerClient.execInContainer(
-        "iop-proxy",
-        "iop-proxy list"
-      );
+      const execResult = await this.execInProxy("iop-proxy list");
 
       if (execResult.success) {
         return execResult.output;
@@ -210,6 +649,31 @@ export class IopProxyClient {
     }
   }
 
+  /**
+   * Get every host the proxy routes, keyed by hostname
+   * @returns The hosts, or null if the proxy could not be queried
+   */
+  async getHosts(): Promise<Record<string, ProxyHostInfo> | null> {
+    try {
+      if (!(await this.isProxyRunning())) {
+        this.logError("Cannot list hosts: iop-proxy container is not running");
+        return null;
+      }
+
+      const execResult = await this.execInProxy("iop-proxy list --json");
+
+      if (!execResult.success) {
+        this.logError(`Failed to list iop-proxy hosts: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim()) || {};
+    } catch (error) {
+      this.logError(`Error listing iop-proxy hosts: ${error}`);
+      return null;
+    }
+  }
+
   /**
    * Update the health status of a service in the proxy
    * @param host The hostname to update
@@ -234,13 +698,10 @@ export class IopProxyClient {
 
       // Build the proxy update health command
       const healthStatus = healthy ? "true" : "false";
-      const proxyCmd = `/usr/local/bin/iop-proxy updatehealth --host ${host} --healthy ${healthStatus}`;
+      const proxyCmd = `/usr/local/bin/iop-proxy updatehealth --host ${shellQuote(host)} --healthy ${healthStatus}`;
 
       // Execute the command in the iop-proxy container
-      const execResult = await this.dockerClient.execInContainer(
-        "iop-proxy",
-        proxyCmd
-      );
+      const execResult = await this.execInProxy(proxyCmd);
 
       if (execResult.success || execResult.output.includes("updated")) {
         this.log(
@@ -260,4 +721,944 @@ export class IopProxyClient {
       return false;
     }
   }
+
+  /**
+   * Replace the notification targets the proxy delivers events to
+   * @param targets The targets, an empty list disables notifications
+   * @returns true if the targets were stored
+   */
+  async configureNotifications(
+    targets: ProxyNotificationTarget[]
+  ): Promise<boolean> {
+    try {
+      if (!(await this.isProxyRunning())) {
+        this.logError(
+          "Cannot configure notifications: iop-proxy container is not running"
+        );
+        return false;
+      }
+
+      this.log(`Configuring ${targets.length} notification targets`);
+
+      const payload = JSON.stringify(targets).replace(/'/g, "'\\''");
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy notifications set --json '${payload}'`
+      );
+
+      if (execResult.success) {
+        this.log("Successfully configured notifications");
+        return true;
+      }
+
+      this.logError(`Failed to configure notifications: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error configuring notifications: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Switch the certificate authority the proxy uses for new certificates
+   * @param acme The directory and optional External Account Binding credentials
+   * @returns true if the proxy registered with the CA
+   */
+  async configureAcme(acme: ProxyAcmeConfig): Promise<boolean> {
+    try {
+      if (!(await this.isProxyRunning())) {
+        this.logError(
+          "Cannot configure ACME: iop-proxy container is not running"
+        );
+        return false;
+      }
+
+      this.log(`Configuring ACME directory: ${acme.directory}`);
+
+      const args = ["acme", "set", "--acme-directory", shellQuote(acme.directory)];
+      if (acme.email) {
+        args.push("--email", shellQuote(acme.email));
+      }
+      if (acme.eabKeyId && acme.eabHmacKey) {
+        args.push("--eab-kid", shellQuote(acme.eabKeyId), "--eab-hmac-key", shellQuote(acme.eabHmacKey));
+      }
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (execResult.success) {
+        this.log("Successfully configured ACME");
+        return true;
+      }
+
+      this.logError(`Failed to configure ACME: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error configuring ACME: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Set or clear the TLS policy for a host
+   * @param host The hostname to configure
+   * @param policy The policy, or null to restore the proxy's defaults
+   * @returns true if the policy was stored
+   */
+  async setHostTlsPolicy(
+    host: string,
+    policy: ProxyTlsPolicy | null
+  ): Promise<boolean> {
+    try {
+      const args = policy
+        ? [
+            "tls",
+            "set",
+            "--host",
+            shellQuote(host),
+            ...(policy.min_version ? ["--min-version", policy.min_version] : []),
+            ...(policy.cipher_suites?.length
+              ? ["--cipher-suites", policy.cipher_suites.join(",")]
+              : []),
+            ...(policy.alpn?.length ? ["--alpn", policy.alpn.join(",")] : []),
+          ]
+        : ["tls", "reset", "--host", shellQuote(host)];
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (execResult.success) {
+        this.log(`Updated TLS policy for ${host}`);
+        return true;
+      }
+
+      this.logError(`Failed to update TLS policy for ${host}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error updating TLS policy for ${host}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Set or clear the concurrency and bandwidth limits for a host
+   * @param host The hostname to configure
+   * @param limits The limits, or null to remove them
+   * @returns true if the limits were stored
+   */
+  async setHostLimits(
+    host: string,
+    limits: ProxyHostLimits | null
+  ): Promise<boolean> {
+    try {
+      const args =
+        limits && (limits.max_concurrent_requests || limits.bandwidth)
+          ? [
+              "limits",
+              "set",
+              "--host",
+              shellQuote(host),
+              ...(limits.max_concurrent_requests
+                ? ["--max-concurrent", String(limits.max_concurrent_requests)]
+                : []),
+              ...(limits.bandwidth
+                ? ["--bandwidth", `'${limits.bandwidth.replace(/\s+/g, "")}'`]
+                : []),
+            ]
+          : ["limits", "reset", "--host", shellQuote(host)];
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (execResult.success) {
+        this.log(`Updated limits for ${host}`);
+        return true;
+      }
+
+      this.logError(`Failed to update limits for ${host}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error updating limits for ${host}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Set or clear the readiness and liveness probes of a host
+   * @param host The hostname to configure
+   * @param probes The probes, or null for the regular health check
+   * @returns true if the probes were stored
+   */
+  async setHostProbes(
+    host: string,
+    probes: ProxyHostProbes | null
+  ): Promise<boolean> {
+    try {
+      const probeArgs = (name: string, probe?: ProxyProbe) =>
+        probe
+          ? [
+              `--${name}`,
+              ...(probe.path ? [`--${name}-path`, shellQuote(probe.path)] : []),
+              ...(probe.interval ? [`--${name}-interval`, shellQuote(probe.interval)] : []),
+              ...(probe.failure_threshold
+                ? [`--${name}-failures`, String(probe.failure_threshold)]
+                : []),
+            ]
+          : [];
+      const args =
+        probes && (probes.readiness || probes.liveness)
+          ? [
+              "probes",
+              "set",
+              "--host",
+              shellQuote(host),
+              ...probeArgs("readiness", probes.readiness),
+              ...probeArgs("liveness", probes.liveness),
+            ]
+          : ["probes", "reset", "--host", shellQuote(host)];
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (execResult.success) {
+        this.log(`Updated probes for ${host}`);
+        return true;
+      }
+
+      this.logError(`Failed to update probes for ${host}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error updating probes for ${host}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Enable or disable stopping a host's app when idle
+   * @param host The hostname to configure
+   * @param enabled Whether the app scales to zero
+   * @param idleTimeout Time without requests before it is stopped, e.g. "15m"
+   * @param coldStart Limits for requests waiting while the app starts
+   * @returns true if the setting was stored
+   */
+  async setScaleToZero(
+    host: string,
+    enabled: boolean,
+    idleTimeout?: string,
+    coldStart?: ProxyColdStartPolicy
+  ): Promise<boolean> {
+    try {
+      const args = [
+        "scale-to-zero",
+        "--host",
+        shellQuote(host),
+        `--enabled=${enabled}`,
+        ...(enabled && idleTimeout ? ["--idle-timeout", shellQuote(idleTimeout)] : []),
+        ...(enabled && coldStart?.max_wait ? ["--max-wait", shellQuote(coldStart.max_wait)] : []),
+        ...(enabled && coldStart?.queue_depth ? [`--queue-depth=${coldStart.queue_depth}`] : []),
+        ...(enabled && coldStart?.starting_page ? ["--starting-page"] : []),
+        ...(enabled && coldStart?.warm_pool ? [`--warm-pool=${coldStart.warm_pool}`] : []),
+      ];
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (execResult.success) {
+        this.log(`Updated scale to zero for ${host}`);
+        return true;
+      }
+
+      this.logError(`Failed to update scale to zero for ${host}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error updating scale to zero for ${host}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Stop or start serving an app's hosts. Stopped hosts answer 503 and aren't
+   * health checked or woken by scale to zero.
+   * @param project The project the app belongs to
+   * @param app The app whose hosts to update
+   * @param stopped Whether the app is stopped
+   * @returns true if the hosts were updated
+   */
+  async setAppStopped(project: string, app: string, stopped: boolean): Promise<boolean> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${stopped ? "stop" : "start"} --project ${shellQuote(project)} --app ${shellQuote(app)}`
+      );
+
+      if (execResult.success) {
+        this.log(`${stopped ? "Stopped" : "Started"} ${project}/${app} in the proxy`);
+        return true;
+      }
+
+      this.logError(
+        `Failed to ${stopped ? "stop" : "start"} ${project}/${app} in the proxy: ${execResult.output}`
+      );
+      return false;
+    } catch (error) {
+      this.logError(`Error updating ${project}/${app} in the proxy: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Set or remove the autoscaling policy of an app
+   * @param project The project the app belongs to
+   * @param app The app to scale
+   * @param policy The policy, or null to stop autoscaling
+   * @returns true if the policy was stored or removed
+   */
+  async setAutoscalePolicy(
+    project: string,
+    app: string,
+    policy: ProxyAutoscalePolicy | null
+  ): Promise<boolean> {
+    try {
+      const target = ["--project", shellQuote(project), "--app", shellQuote(app)];
+      const args = policy
+        ? [
+            "autoscale",
+            "set",
+            ...target,
+            "--min",
+            String(policy.min_replicas),
+            "--max",
+            String(policy.max_replicas),
+            ...(policy.target_cpu ? ["--cpu", String(policy.target_cpu)] : []),
+            ...(policy.target_request_rate
+              ? ["--requests-per-second", String(policy.target_request_rate)]
+              : []),
+            ...(policy.target_latency_ms
+              ? ["--latency", String(policy.target_latency_ms)]
+              : []),
+            ...(policy.target_concurrency
+              ? ["--concurrency", String(policy.target_concurrency)]
+              : []),
+            ...(policy.scale_up_cooldown
+              ? ["--scale-up-cooldown", shellQuote(policy.scale_up_cooldown)]
+              : []),
+            ...(policy.scale_down_cooldown
+              ? ["--scale-down-cooldown", shellQuote(policy.scale_down_cooldown)]
+              : []),
+          ]
+        : ["autoscale", "remove", ...target];
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      // Removing a policy that was never set is fine
+      if (execResult.success || (!policy && execResult.output.includes("no autoscaling policy"))) {
+        this.log(`Updated autoscaling for ${project}/${app}`);
+        return true;
+      }
+
+      this.logError(`Failed to update autoscaling for ${project}/${app}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error updating autoscaling for ${project}/${app}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Replace the service records of a project in the proxy's DNS server
+   * @param project The project the services belong to
+   * @param records The records, an empty list removes the project's records
+   * @returns true if the records were stored
+   */
+  async setServiceRecords(project: string, records: ProxyServiceRecord[]): Promise<boolean> {
+    try {
+      const payload = JSON.stringify(records).replace(/'/g, "'\\''");
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy discovery set --project ${shellQuote(project)} --json '${payload}'`
+      );
+
+      if (execResult.success) {
+        this.log(`Updated ${records.length} service records of ${project}`);
+        return true;
+      }
+
+      this.logError(`Failed to update service records of ${project}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error updating service records of ${project}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Check whether a deploy fits the project's quota on the server. Proxies
+   * from before quotas have none, so every deploy fits them.
+   * @param project The project being deployed
+   * @param workloads The containers the deploy runs for each service it replaces
+   * @returns The usage after the deploy, or the reason it doesn't fit
+   */
+  async checkQuota(
+    project: string,
+    workloads: ProxyQuotaWorkload[]
+  ): Promise<{ fits: boolean; usage?: ProxyQuotaUsage; error?: string }> {
+    const payload = JSON.stringify(workloads).replace(/'/g, "'\\''");
+    const execResult = await this.execInProxy(
+      `/usr/local/bin/iop-proxy quota check --project ${shellQuote(project)} --json '${payload}'`
+    );
+
+    if (execResult.success) {
+      try {
+        return { fits: true, usage: JSON.parse(execResult.output.trim()) };
+      } catch {
+        return { fits: true };
+      }
+    }
+    if (execResult.output.includes("unknown command: quota")) {
+      return { fits: true };
+    }
+    return { fits: false, error: execResult.output.trim() };
+  }
+
+  /**
+   * Read a project's quota and what it runs on the server
+   * @param project The project
+   * @returns The entry, without a quota if the project has none, or null if the proxy could not be queried
+   */
+  async getQuota(project: string): Promise<ProxyQuotaEntry | null> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy quota list --project ${shellQuote(project)} --json`
+      );
+
+      if (!execResult.success) {
+        // Proxies from before quotas have none to report
+        if (!execResult.output.includes("unknown command: quota")) {
+          this.logError(`Failed to read quota of ${project}: ${execResult.output}`);
+        }
+        return null;
+      }
+
+      const entries: ProxyQuotaEntry[] = JSON.parse(execResult.output.trim()) || [];
+      return entries.find((entry) => entry.project === project) || null;
+    } catch (error) {
+      this.logError(`Error reading quota of ${project}: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Add or replace the project's status page. The proxy deploys its host and
+   * keeps the page's incidents.
+   * @param page The page
+   * @returns true if the page was stored
+   */
+  async setStatusPage(page: ProxyStatusPage): Promise<boolean> {
+    try {
+      let command = `/usr/local/bin/iop-proxy status-page set --project ${shellQuote(page.project)} --host ${shellQuote(page.host)} --ssl=${page.ssl}`;
+      if (page.title) {
+        command += ` --title ${shellQuote(page.title)}`;
+      }
+      if (page.apps && page.apps.length > 0) {
+        command += ` --apps ${shellQuote(page.apps.join(","))}`;
+      }
+      const execResult = await this.execInProxy(command);
+
+      if (execResult.success) {
+        this.log(`Status page of ${page.project} served at ${page.host}`);
+        return true;
+      }
+
+      this.logError(`Failed to set status page of ${page.project}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error setting status page of ${page.project}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Read the project's status page
+   * @param project The project
+   * @returns The page, or null if it has none or the proxy could not be queried
+   */
+  async getStatusPage(project: string): Promise<ProxyStatusPage | null> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy status-page list --project ${shellQuote(project)} --json`
+      );
+
+      if (!execResult.success) {
+        // Proxies from before status pages have none to report
+        if (!execResult.output.includes("unknown command: status-page")) {
+          this.logError(`Failed to read status page of ${project}: ${execResult.output}`);
+        }
+        return null;
+      }
+
+      const pages: ProxyStatusPage[] = JSON.parse(execResult.output.trim()) || [];
+      return pages.find((page) => page.project === project) || null;
+    } catch (error) {
+      this.logError(`Error reading status page of ${project}: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Remove the project's status page with its incidents and host
+   * @param project The project
+   * @returns true if the page was removed
+   */
+  async removeStatusPage(project: string): Promise<boolean> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy status-page remove --project ${shellQuote(project)}`
+      );
+
+      if (execResult.success) {
+        this.log(`Removed status page of ${project}`);
+        return true;
+      }
+
+      this.logError(`Failed to remove status page of ${project}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error removing status page of ${project}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * List the incidents of the project's status page, newest first
+   * @param project The project
+   * @returns The incidents, or null if the proxy could not be queried
+   */
+  async listIncidents(project: string): Promise<ProxyIncident[] | null> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy incident list --project ${shellQuote(project)} --json`
+      );
+
+      if (!execResult.success) {
+        this.logError(`Failed to list incidents: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim()) || [];
+    } catch (error) {
+      this.logError(`Error listing incidents: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Open an incident on the project's status page
+   * @param project The project
+   * @param incident Its title, first status and message, and the affected apps
+   * @returns The incident, or null if it could not be opened
+   */
+  async openIncident(
+    project: string,
+    incident: { title: string; status?: ProxyIncidentStatus; message?: string; apps?: string[] }
+  ): Promise<ProxyIncident | null> {
+    try {
+      let command = `/usr/local/bin/iop-proxy incident open --json --project ${shellQuote(project)} --title ${shellQuote(incident.title)}`;
+      if (incident.status) {
+        command += ` --status ${incident.status}`;
+      }
+      if (incident.message) {
+        command += ` --message ${shellQuote(incident.message)}`;
+      }
+      if (incident.apps && incident.apps.length > 0) {
+        command += ` --apps ${shellQuote(incident.apps.join(","))}`;
+      }
+      const execResult = await this.execInProxy(command);
+
+      if (!execResult.success) {
+        this.logError(`Failed to open incident: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim());
+    } catch (error) {
+      this.logError(`Error opening incident: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Post an update on an incident, resolving it with the resolved status
+   * @param id The incident's ID
+   * @param status Its new status
+   * @param message The update for visitors
+   * @returns The incident, or null if it could not be updated
+   */
+  async updateIncident(
+    id: string,
+    status: ProxyIncidentStatus,
+    message?: string
+  ): Promise<ProxyIncident | null> {
+    try {
+      let command = `/usr/local/bin/iop-proxy incident update --json --id ${shellQuote(id)} --status ${status}`;
+      if (message) {
+        command += ` --message ${shellQuote(message)}`;
+      }
+      const execResult = await this.execInProxy(command);
+
+      if (!execResult.success) {
+        this.logError(`Failed to update incident ${id}: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim());
+    } catch (error) {
+      this.logError(`Error updating incident ${id}: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Remove an incident, e.g. one opened by mistake
+   * @param id The incident's ID
+   * @returns true if the incident was removed
+   */
+  async removeIncident(id: string): Promise<boolean> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy incident remove --id ${shellQuote(id)}`
+      );
+
+      if (execResult.success) {
+        this.log(`Removed incident ${id}`);
+        return true;
+      }
+
+      this.logError(`Failed to remove incident ${id}: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error removing incident ${id}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Route requests for hosts the proxy doesn't know to a target
+   * @param target The container:port to serve unknown hosts
+   * @returns true if the default backend was stored
+   */
+  async setDefaultBackend(target: string): Promise<boolean> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy default-backend set --target ${shellQuote(target)}`
+      );
+
+      if (execResult.success) {
+        this.log(`Default backend set to ${target}`);
+        return true;
+      }
+
+      this.logError(`Failed to set default backend: ${execResult.output}`);
+      return false;
+    } catch (error) {
+      this.logError(`Error setting default backend: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Forward a port on the server to a container, replacing any rule for the same port
+   * @param rule The forwarding rule
+   * @returns true if the rule was stored and the proxy is listening
+   */
+  async addPortForward(rule: ProxyPortForward): Promise<boolean> {
+    try {
+      const args = [
+        "ports",
+        "add",
+        "--listen",
+        String(rule.listen),
+        "--protocol",
+        rule.protocol,
+        "--target",
+        shellQuote(rule.target),
+      ];
+      if (rule.project) {
+        args.push("--project", shellQuote(rule.project));
+      }
+      if (rule.allow && rule.allow.length > 0) {
+        args.push("--allow", shellQuote(rule.allow.join(",")));
+      }
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (execResult.success) {
+        this.log(`Forwarding ${rule.listen}/${rule.protocol} to ${rule.target}`);
+        return true;
+      }
+
+      this.logError(
+        `Failed to forward ${rule.listen}/${rule.protocol}: ${execResult.output}`
+      );
+      return false;
+    } catch (error) {
+      this.logError(`Error forwarding ${rule.listen}/${rule.protocol}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * Stop forwarding a port
+   * @param listen The forwarded port
+   * @param protocol The port's protocol
+   * @returns true if the rule was removed
+   */
+  async removePortForward(
+    listen: number,
+    protocol: "tcp" | "udp"
+  ): Promise<boolean> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ports remove --listen ${listen} --protocol ${protocol}`
+      );
+
+      if (execResult.success) {
+        this.log(`Stopped forwarding ${listen}/${protocol}`);
+        return true;
+      }
+
+      this.logError(
+        `Failed to remove forward ${listen}/${protocol}: ${execResult.output}`
+      );
+      return false;
+    } catch (error) {
+      this.logError(`Error removing forward ${listen}/${protocol}: ${error}`);
+      return false;
+    }
+  }
+
+  /**
+   * List the forwarded ports
+   * @returns The output of the ports list command if successful, or null if it fails
+   */
+  async listPortForwards(): Promise<string | null> {
+    try {
+      const execResult = await this.execInProxy(
+        "/usr/local/bin/iop-proxy ports list"
+      );
+
+      if (execResult.success) {
+        return execResult.output;
+      }
+
+      this.logError(`Failed to list forwarded ports: ${execResult.output}`);
+      return null;
+    } catch (error) {
+      this.logError(`Error listing forwarded ports: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Read the proxy's audit log of state-changing operations
+   * @param query Filters for the entries, such as host or since
+   * @returns The matching entries, oldest first, or null if the proxy could not be queried
+   */
+  async getAuditLog(query: ProxyAuditQuery = {}): Promise<ProxyAuditEntry[] | null> {
+    try {
+      const args = ["audit", "--json"];
+      if (query.host) {
+        args.push("--host", shellQuote(query.host));
+      }
+      if (query.action) {
+        args.push("--action", shellQuote(query.action));
+      }
+      if (query.since) {
+        args.push("--since", shellQuote(query.since));
+      }
+      if (query.limit !== undefined) {
+        args.push("--limit", String(query.limit));
+      }
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (!execResult.success) {
+        this.logError(`Failed to read audit log: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim()) || [];
+    } catch (error) {
+      this.logError(`Error reading audit log: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Read the latest resource usage of the app containers on the server
+   * @returns One sample per container, or null if the proxy could not be queried
+   */
+  async getContainerStats(): Promise<ProxyContainerStats[] | null> {
+    try {
+      const execResult = await this.execInProxy("/usr/local/bin/iop-proxy stats --json");
+
+      if (!execResult.success) {
+        this.logError(`Failed to read container stats: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim()) || [];
+    } catch (error) {
+      this.logError(`Error reading container stats: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Get each app's request counters and the usage of its containers
+   * @returns The apps, or null if the proxy could not be queried
+   */
+  async getAppStats(): Promise<ProxyAppStats[] | null> {
+    try {
+      const execResult = await this.execInProxy("/usr/local/bin/iop-proxy stats --apps --json");
+
+      if (!execResult.success) {
+        this.logError(`Failed to read app stats: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim()) || [];
+    } catch (error) {
+      this.logError(`Error reading app stats: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Get the uptime of the hosts the proxy checks
+   * @param project Only hosts of this project
+   * @returns The hosts, or null if the proxy could not be queried
+   */
+  async getUptime(project?: string): Promise<ProxyUptimeReport[] | null> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy uptime --json${project ? ` --project ${shellQuote(project)}` : ""}`
+      );
+
+      if (!execResult.success) {
+        this.logError(`Failed to read uptime: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim()) || [];
+    } catch (error) {
+      this.logError(`Error reading uptime: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * List, or delete, the certificate directories on the proxy's disk that no host uses
+   * @param dryRun Only list them
+   * @returns The directories, or null if the proxy could not be queried
+   */
+  async pruneCertificates(dryRun: boolean): Promise<string[] | null> {
+    try {
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy cert-prune --json${dryRun ? " --dry-run" : ""}`
+      );
+
+      if (!execResult.success) {
+        this.logError(`Failed to prune certificates: ${execResult.output}`);
+        return null;
+      }
+
+      return JSON.parse(execResult.output.trim()) || [];
+    } catch (error) {
+      this.logError(`Error pruning certificates: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Start the timeline the steps of an app deployment are reported to
+   * @returns The deployment ID, or null if the proxy could not start it
+   */
+  async startDeploymentTimeline(
+    projectName: string,
+    appName: string,
+    host?: string,
+    metadata: Record<string, string> = {}
+  ): Promise<string | null> {
+    try {
+      const args = ["timeline", "start", "--project", shellQuote(projectName), "--app", shellQuote(appName)];
+      if (host) {
+        args.push("--host", shellQuote(host));
+      }
+      for (const [key, value] of Object.entries(metadata)) {
+        args.push("--meta", shellQuote(`${key}=${value}`));
+      }
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (!execResult.success) {
+        this.log(`Failed to start deployment timeline: ${execResult.output}`);
+        return null;
+      }
+
+      return execResult.output.trim() || null;
+    } catch (error) {
+      this.log(`Error starting deployment timeline: ${error}`);
+      return null;
+    }
+  }
+
+  /**
+   * Report progress of a step to a deployment's timeline
+   * @returns true if the proxy recorded it
+   */
+  async updateDeploymentStep(
+    deploymentId: string,
+    update: ProxyDeploymentStepUpdate
+  ): Promise<boolean> {
+    try {
+      const args = ["timeline", "step", "--id", shellQuote(deploymentId), "--step", update.step];
+      if (update.status) {
+        args.push("--status", update.status);
+      }
+      if (update.message) {
+        args.push("--message", shellQuote(update.message));
+      }
+      if (update.error) {
+        args.push("--error", shellQuote(update.error));
+      }
+      if (update.attempt?.result) {
+        args.push("--attempt", shellQuote(update.attempt.result));
+      }
+      if (update.attempt?.error) {
+        args.push("--attempt-error", shellQuote(update.attempt.error));
+      }
+
+      const execResult = await this.execInProxy(
+        `/usr/local/bin/iop-proxy ${args.join(" ")}`
+      );
+
+      if (!execResult.success) {
+        this.log(`Failed to update deployment ${deploymentId}: ${execResult.output}`);
+      }
+      return execResult.success;
+    } catch (error) {
+      this.log(`Error updating deployment ${deploymentId}: ${error}`);
+      return false;
+    }
+  }
 }
@@ -2,11 +2,72 @@ import { DockerClient } from "../docker";
 import { IopConfig, IopSecrets } from "../config/types";
 import { SSHClient } from "../ssh";
 import { loadConfig } from "../config";
+import { readMeshAddress } from "../utils/mesh";
+import { logProgress } from "../utils/output";
 
 // Constants
 export const IOP_PROXY_NAME = "iop-proxy";
 const DEFAULT_IOP_PROXY_IMAGE = "elitan/iop-proxy:latest";
 
+/**
+ * Docker port bindings for the forwarding rules in the proxy's state file, so
+ * a recreated proxy container publishes every forwarded port
+ * @param stateJson Contents of the proxy's state.json
+ * @returns Bindings such as "5432:5432" or "27015:27015/udp"
+ */
+export function getForwardedPortBindings(stateJson: string): string[] {
+  try {
+    const state = JSON.parse(stateJson);
+    const rules: Array<{ listen: number; protocol: string }> =
+      state?.ports || [];
+    return rules.map((rule) =>
+      rule.protocol === "udp"
+        ? `${rule.listen}:${rule.listen}/udp`
+        : `${rule.listen}:${rule.listen}`
+    );
+  } catch {
+    return [];
+  }
+}
+
+/**
+ * Docker port bindings for the proxy's DNS server. It is only published on
+ * the server's mesh address, where the server's containers send their queries,
+ * as the host's own resolver usually listens on port 53 of localhost.
+ * @param meshAddress The server's mesh address, if it has one
+ */
+export function getDiscoveryPortBindings(meshAddress?: string): string[] {
+  return meshAddress ? [`${meshAddress}:53:53/udp`, `${meshAddress}:53:53`] : [];
+}
+
+/**
+ * Environment of the proxy container for the tracing and error reporting
+ * settings of iop.yml
+ * @param config The project's configuration
+ * @param serverHostname Reported as the server name, the container's own hostname is its ID
+ */
+export function getProxyEnvVars(
+  config: IopConfig,
+  serverHostname: string
+): Record<string, string> | undefined {
+  const envVars: Record<string, string> = {};
+  if (config.proxy?.tracing) {
+    envVars.OTEL_EXPORTER_OTLP_ENDPOINT = config.proxy.tracing.endpoint;
+    envVars.OTEL_SERVICE_NAME = config.proxy.tracing.service_name;
+  }
+  if (config.proxy?.sentry) {
+    envVars.SENTRY_DSN = config.proxy.sentry.dsn;
+    envVars.SENTRY_SERVER_NAME = serverHostname;
+    if (config.proxy.sentry.environment) {
+      envVars.SENTRY_ENVIRONMENT = config.proxy.sentry.environment;
+    }
+  }
+  if (config.proxy?.internal_networks) {
+    envVars.IOP_INTERNAL_NETWORKS = config.proxy.internal_networks.join(",");
+  }
+  return Object.keys(envVars).length > 0 ? envVars : undefined;
+}
+
 /**
  * Check if the iop proxy is running and set it up if not
  * @param serverHostname The hostname of the server
@@ -22,7 +83,7 @@ export async function setupIopProxy(
 ): Promise<boolean> {
   try {
     if (verbose) {
-      console.log(`[${serverHostname}] Checking iop proxy status...`);
+      logProgress(`[${serverHostname}] Checking iop proxy status...`);
     }
 
     // Get SSH client from Docker client (reusing existing SSH connection)
@@ -38,10 +99,10 @@ export async function setupIopProxy(
     if (proxyExists) {
       if (forceUpdate) {
         if (verbose) {
-          console.log(
+          logProgress(
             `[${serverHostname}] iop proxy already exists. Force updating to latest version...`
           );
-          console.log(
+          logProgress(
             `[${serverHostname}] Stopping and removing existing proxy container...`
           );
         }
@@ -49,7 +110,7 @@ export async function setupIopProxy(
         // Backup state before updating container
         try {
           if (verbose) {
-            console.log(`[${serverHostname}] Backing up proxy state before update...`);
+            logProgress(`[${serverHostname}] Backing up proxy state before update...`);
           }
           
           // Ensure state directory exists on host
@@ -60,11 +121,11 @@ export async function setupIopProxy(
           const backupResult = await sshClient.exec(copyStateCmd);
           
           if (verbose) {
-            console.log(`[${serverHostname}] State backup result: ${backupResult.trim()}`);
+            logProgress(`[${serverHostname}] State backup result: ${backupResult.trim()}`);
           }
         } catch (error) {
           if (verbose) {
-            console.log(`[${serverHostname}] Warning: Could not backup state: ${error}`);
+            logProgress(`[${serverHostname}] Warning: Could not backup state: ${error}`);
           }
           // Continue with update anyway
         }
@@ -79,7 +140,7 @@ export async function setupIopProxy(
           }
           await dockerClient.removeContainer(IOP_PROXY_NAME);
           if (verbose) {
-            console.log(
+            logProgress(
               `[${serverHostname}] Existing proxy container removed successfully.`
             );
           }
@@ -97,21 +158,21 @@ export async function setupIopProxy(
 
         if (proxyRunning) {
           if (verbose) {
-            console.log(
+            logProgress(
               `[${serverHostname}] iop proxy already exists and is running. Skipping setup.`
             );
           }
           return true;
         } else {
           if (verbose) {
-            console.log(
+            logProgress(
               `[${serverHostname}] iop proxy exists but is not running. Starting it...`
             );
           }
           try {
             await dockerClient.startContainer(IOP_PROXY_NAME);
             if (verbose) {
-              console.log(
+              logProgress(
                 `[${serverHostname}] iop proxy started successfully.`
               );
             }
@@ -128,11 +189,11 @@ export async function setupIopProxy(
 
     // Force pull the latest image (whether container existed or not)
     if (verbose) {
-      console.log(`[${serverHostname}] Setting up iop proxy...`);
-      console.log(
+      logProgress(`[${serverHostname}] Setting up iop proxy...`);
+      logProgress(
         `[${serverHostname}] Force pulling latest iop proxy image from registry...`
       );
-      console.log(
+      logProgress(
         `[${serverHostname}] Force pulling latest image ${proxyImage}...`
       );
     }
@@ -160,29 +221,51 @@ export async function setupIopProxy(
 
     // Ensure .iop directories exist on the server before mounting
     if (verbose) {
-      console.log(`[${serverHostname}] Creating .iop directory structure...`);
+      logProgress(`[${serverHostname}] Creating .iop directory structure...`);
     }
     try {
       await sshClient.exec("mkdir -p ~/.iop/iop-proxy-certs ~/.iop/iop-proxy-state");
       if (verbose) {
-        console.log(`[${serverHostname}] .iop directories created successfully.`);
+        logProgress(`[${serverHostname}] .iop directories created successfully.`);
       }
     } catch (error) {
       console.error(`[${serverHostname}] Failed to create .iop directories: ${error}`);
       return false;
     }
 
+    // Publish the ports forwarded by the proxy alongside HTTP and HTTPS
+    let forwardedPorts: string[] = [];
+    try {
+      const stateJson = await sshClient.exec(
+        "cat ~/.iop/iop-proxy-state/state.json 2>/dev/null || true"
+      );
+      forwardedPorts = getForwardedPortBindings(stateJson);
+      if (verbose && forwardedPorts.length > 0) {
+        logProgress(
+          `[${serverHostname}] Publishing forwarded ports: ${forwardedPorts.join(", ")}`
+        );
+      }
+    } catch (error) {
+      if (verbose) {
+        logProgress(`[${serverHostname}] Warning: Could not read forwarded ports: ${error}`);
+      }
+    }
+
+    // Serve service discovery to the containers of servers in a mesh
+    const discoveryPorts = getDiscoveryPortBindings(await readMeshAddress(sshClient));
+
     // Create container options
     const containerOptions = {
       name: IOP_PROXY_NAME,
       image: proxyImage,
-      ports: ["80:80", "443:443"],
+      ports: ["80:80", "443:443", ...forwardedPorts, ...discoveryPorts],
       volumes: [
         "./.iop/iop-proxy-certs:/var/lib/iop-proxy/certs",
         "./.iop/iop-proxy-state:/var/lib/iop-proxy",
         "/var/run/docker.sock:/var/run/docker.sock",
       ],
       restart: "always",
+      envVars: getProxyEnvVars(config, serverHostname),
     };
 
     // Create and start the container
@@ -219,7 +302,7 @@ export async function setupIopProxy(
     // Reconnect proxy to all project networks (needed after container recreation)
     try {
       if (verbose) {
-        console.log(`[${serverHostname}] Reconnecting proxy to project networks...`);
+        logProgress(`[${serverHostname}] Reconnecting proxy to project networks...`);
       }
       
       // Find all project networks (they follow the pattern: {project-name}-network)
@@ -229,7 +312,7 @@ export async function setupIopProxy(
       );
       
       if (verbose) {
-        console.log(`[${serverHostname}] Found project networks: ${projectNetworks.join(', ')}`);
+        logProgress(`[${serverHostname}] Found project networks: ${projectNetworks.join(', ')}`);
       }
       
       // Connect proxy to each project network
@@ -244,33 +327,33 @@ export async function setupIopProxy(
           if (!isConnected) {
             await dockerClient.connectContainerToNetwork(IOP_PROXY_NAME, networkName);
             if (verbose) {
-              console.log(`[${serverHostname}] Connected proxy to network: ${networkName}`);
+              logProgress(`[${serverHostname}] Connected proxy to network: ${networkName}`);
             }
           } else {
             if (verbose) {
-              console.log(`[${serverHostname}] Proxy already connected to network: ${networkName}`);
+              logProgress(`[${serverHostname}] Proxy already connected to network: ${networkName}`);
             }
           }
         } catch (error) {
           if (verbose) {
-            console.log(`[${serverHostname}] Warning: Could not connect to network ${networkName}: ${error}`);
+            logProgress(`[${serverHostname}] Warning: Could not connect to network ${networkName}: ${error}`);
           }
           // Continue with other networks
         }
       }
       
       if (verbose) {
-        console.log(`[${serverHostname}] Network reconnection completed`);
+        logProgress(`[${serverHostname}] Network reconnection completed`);
       }
     } catch (error) {
       if (verbose) {
-        console.log(`[${serverHostname}] Warning: Network reconnection failed: ${error}`);
+        logProgress(`[${serverHostname}] Warning: Network reconnection failed: ${error}`);
       }
       // Don't fail the setup if network reconnection fails
     }
 
     if (verbose) {
-      console.log(
+      logProgress(
         `[${serverHostname}] iop proxy has been successfully set up.`
       );
     }
@@ -2,11 +2,20 @@
 
 import SSH2Promise from "ssh2-promise";
 import { ConnectConfig } from "ssh2";
-import { getSSHCredentials } from "./utils";
+import { buildSSHCommandOptions, getSSHCredentials } from "./utils";
 import { createReadStream } from "fs";
 import { stat } from "fs/promises";
+import { logProgress } from "../utils/output";
 
-export { getSSHCredentials };
+export { buildSSHCommandOptions, getSSHCredentials };
+
+// A jump host the connection to the server is tunnelled through
+export interface SSHBastionOptions {
+  host: string;
+  port?: number;
+  username: string;
+  identity?: string;
+}
 
 export interface SSHClientOptions {
   host: string;
@@ -17,6 +26,8 @@ export interface SSHClientOptions {
   password?: string;
   passphrase?: string;
   agent?: string;
+  agentForward?: boolean;
+  bastion?: SSHBastionOptions;
   verbose?: boolean;
   skipHostKeyVerification?: boolean;
   suppressConnectionErrors?: boolean;
@@ -29,10 +40,15 @@ export class SSHClient {
   private verbose: boolean = false;
   private suppressConnectionErrors: boolean = false;
   private platformCache?: string;
+  // Identity and bastion options for the rsync and scp uploads
+  private commandOptions: string[] = [];
 
-  private constructor(connectOptions: ConnectConfig) {
+  private constructor(connectOptions: ConnectConfig, bastionOptions?: ConnectConfig) {
     this.connectOptions = connectOptions;
-    this.ssh = new SSH2Promise(this.connectOptions);
+    // ssh2-promise hops through each config in turn when given a list
+    this.ssh = new SSH2Promise(
+      bastionOptions ? ([bastionOptions, connectOptions] as any) : this.connectOptions
+    );
     this.host = connectOptions.host!;
   }
 
@@ -45,6 +61,15 @@ export class SSHClient {
       passphrase: options.passphrase,
       agent: options.agent,
     };
+
+    if (options.agentForward) {
+      if (!options.agent) {
+        throw new Error(
+          "SSH agent forwarding needs a running ssh-agent, but SSH_AUTH_SOCK is not set"
+        );
+      }
+      connectOpts.agentForward = true;
+    }
     
     // Skip host key verification for fresh servers
     if (options.skipHostKeyVerification) {
@@ -66,8 +91,29 @@ export class SSHClient {
     ssh2PromiseConfig.reconnectDelay = 2000;
     ssh2PromiseConfig.reconnectTries = 5;
 
-    const client = new SSHClient(ssh2PromiseConfig as ConnectConfig);
+    let bastionConfig: any;
+    if (options.bastion) {
+      bastionConfig = {
+        host: options.bastion.host,
+        port: options.bastion.port || 22,
+        username: options.bastion.username,
+        agent: options.agent,
+        reconnect: true,
+        reconnectDelay: 2000,
+        reconnectTries: 5,
+      };
+      // Without a key of its own the bastion is offered the server's key
+      const bastionIdentity = options.bastion.identity || options.identity;
+      if (bastionIdentity) {
+        bastionConfig.identity = bastionIdentity;
+      } else if (options.privateKey) {
+        bastionConfig.privateKey = options.privateKey;
+      }
+    }
+
+    const client = new SSHClient(ssh2PromiseConfig as ConnectConfig, bastionConfig);
     client.setVerbose(options.verbose || false);
+    client.commandOptions = buildSSHCommandOptions(options);
     client.setSuppressConnectionErrors(options.suppressConnectionErrors || false);
     return client;
   }
@@ -84,7 +130,7 @@ export class SSHClient {
     try {
       await this.ssh.connect();
       if (this.verbose) {
-        console.log(`SSH connection established to ${this.host}`);
+        logProgress(`SSH connection established to ${this.host}`);
       }
     } catch (err) {
       if (!this.suppressConnectionErrors) {
@@ -100,17 +146,19 @@ export class SSHClient {
       command.includes("password") ||
       command.includes("login") ||
       command.includes('echo "') ||
-      command.includes("cat >");
+      command.includes("cat >") ||
+      command.includes("<< 'EOF'");
 
     // Create a sanitized version for logging
     const sanitizedCommand = isSensitiveCommand
       ? command
           .replace(/echo ".*?"/g, 'echo "***REDACTED***"')
           .replace(/cat > .*?<< ['"]?EOF/g, "cat > ***REDACTED*** << EOF")
+          .replace(/<< ['"]?EOF['"]?\n[\s\S]*?\nEOF/g, "<< EOF\n***REDACTED***\nEOF")
       : command;
 
     if (this.verbose) {
-      console.log(`[${this.host}] Executing: ${sanitizedCommand}`);
+      logProgress(`[${this.host}] Executing: ${sanitizedCommand}`);
     }
 
     return new Promise(async (resolve, reject) => {
@@ -201,7 +249,7 @@ export class SSHClient {
     try {
       await this.ssh.close();
       if (this.verbose) {
-        console.log(`SSH connection closed to ${this.host}`);
+        logProgress(`SSH connection closed to ${this.host}`);
       }
     } catch (err) {
       if (this.verbose) {
@@ -221,7 +269,7 @@ export class SSHClient {
 
     try {
       if (this.verbose) {
-        console.log(`[${this.host}] Detecting server platform...`);
+        logProgress(`[${this.host}] Detecting server platform...`);
       }
 
       // Get architecture and OS information
@@ -280,7 +328,7 @@ export class SSHClient {
       this.platformCache = platform;
 
       if (this.verbose) {
-        console.log(`[${this.host}] Detected platform: ${platform} (arch: ${cleanArch}, os: ${cleanOs})`);
+        logProgress(`[${this.host}] Detected platform: ${platform} (arch: ${cleanArch}, os: ${cleanOs})`);
       }
 
       return platform;
@@ -303,7 +351,7 @@ export class SSHClient {
     onProgress?: (transferred: number, total: number) => void
   ): Promise<void> {
     if (this.verbose) {
-      console.log(
+      logProgress(
         `[${this.host}] Uploading ${localPath} to ${remotePath} via rsync or SCP`
       );
     }
@@ -313,7 +361,7 @@ export class SSHClient {
       const stats = await stat(localPath);
       const totalSize = stats.size;
       if (this.verbose) {
-        console.log(
+        logProgress(
           `[${this.host}] File size: ${(totalSize / 1024 / 1024).toFixed(2)} MB`
         );
       }
@@ -323,11 +371,15 @@ export class SSHClient {
 
       // Use native rsync or scp command for maximum speed
       // Try rsync first (faster for large files), fall back to scp
-      const rsyncCommand = `rsync -avz --progress "${localPath}" ${this.connectOptions.username}@${this.host}:"${remotePath}"`;
-      const scpCommand = `scp -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -C "${localPath}" ${this.connectOptions.username}@${this.host}:"${remotePath}"`;
+      const port = String(this.connectOptions.port || 22);
+      // rsync splits its remote shell on spaces unless they are quoted
+      const rsyncShell = ["ssh", "-p", port, ...this.commandOptions]
+        .map((arg) => (arg.includes(" ") ? `"${arg}"` : arg))
+        .join(" ");
+      const rsyncCommand = `rsync -avz --progress -e '${rsyncShell}' "${localPath}" ${this.connectOptions.username}@${this.host}:"${remotePath}"`;
 
       if (this.verbose) {
-        console.log(`[${this.host}] Trying rsync for faster transfer`);
+        logProgress(`[${this.host}] Trying rsync for faster transfer`);
       }
 
       // Execute rsync or scp command directly
@@ -337,7 +389,7 @@ export class SSHClient {
         // Try rsync first with progress monitoring
         try {
           if (this.verbose) {
-            console.log(
+            logProgress(
               `[${this.host}] Executing rsync: ${rsyncCommand.replace(
                 localPath,
                 "***"
@@ -346,7 +398,7 @@ export class SSHClient {
           }
           
           await new Promise<void>((resolve, reject) => {
-            const rsyncArgs = ['-avz', '--progress', localPath, `${this.connectOptions.username}@${this.host}:${remotePath}`];
+            const rsyncArgs = ['-avz', '--progress', '-e', rsyncShell, localPath, `${this.connectOptions.username}@${this.host}:${remotePath}`];
             const rsyncProcess = spawn('rsync', rsyncArgs);
 
             let lastProgress = 0;
@@ -354,7 +406,7 @@ export class SSHClient {
             rsyncProcess.stdout?.on('data', (data) => {
               const output = data.toString();
               if (this.verbose) {
-                console.log(`[${this.host}] rsync stdout:`, output.trim());
+                logProgress(`[${this.host}] rsync stdout:`, output.trim());
               }
               
               if (onProgress) {
@@ -373,7 +425,7 @@ export class SSHClient {
 
             rsyncProcess.stderr?.on('data', (data) => {
               if (this.verbose) {
-                console.log(`[${this.host}] rsync stderr:`, data.toString().trim());
+                logProgress(`[${this.host}] rsync stderr:`, data.toString().trim());
               }
             });
 
@@ -392,18 +444,18 @@ export class SSHClient {
           });
           
           if (this.verbose) {
-            console.log(`[${this.host}] rsync upload completed: ${remotePath}`);
+            logProgress(`[${this.host}] rsync upload completed: ${remotePath}`);
           }
         } catch (rsyncError) {
           // Fallback to SCP if rsync fails
           if (this.verbose) {
-            console.log(
+            logProgress(
               `[${this.host}] rsync failed, falling back to SCP: ${rsyncError}`
             );
           }
           
           await new Promise<void>((resolve, reject) => {
-            const scpArgs = ['-o', 'StrictHostKeyChecking=no', '-o', 'UserKnownHostsFile=/dev/null', '-C', localPath, `${this.connectOptions.username}@${this.host}:${remotePath}`];
+            const scpArgs = ['-P', port, ...this.commandOptions, '-o', 'StrictHostKeyChecking=no', '-o', 'UserKnownHostsFile=/dev/null', '-C', localPath, `${this.connectOptions.username}@${this.host}:${remotePath}`];
             const scpProcess = spawn('scp', scpArgs);
 
             // For SCP, simulate progress based on time (not ideal but better than nothing)
@@ -422,13 +474,13 @@ export class SSHClient {
 
             scpProcess.stdout?.on('data', (data) => {
               if (this.verbose) {
-                console.log(`[${this.host}] scp stdout:`, data.toString().trim());
+                logProgress(`[${this.host}] scp stdout:`, data.toString().trim());
               }
             });
 
             scpProcess.stderr?.on('data', (data) => {
               if (this.verbose) {
-                console.log(`[${this.host}] scp stderr:`, data.toString().trim());
+                logProgress(`[${this.host}] scp stderr:`, data.toString().trim());
               }
             });
 
@@ -453,7 +505,7 @@ export class SSHClient {
           });
           
           if (this.verbose) {
-            console.log(`[${this.host}] SCP upload completed: ${remotePath}`);
+            logProgress(`[${this.host}] SCP upload completed: ${remotePath}`);
           }
         }
       } catch (transferError) {
@@ -473,7 +525,7 @@ export class SSHClient {
    */
   async downloadFile(remotePath: string, localPath: string): Promise<void> {
     if (this.verbose) {
-      console.log(`[${this.host}] Downloading ${remotePath} to ${localPath}`);
+      logProgress(`[${this.host}] Downloading ${remotePath} to ${localPath}`);
     }
 
     try {
@@ -481,7 +533,7 @@ export class SSHClient {
       await sftp.fastGet(remotePath, localPath);
 
       if (this.verbose) {
-        console.log(`[${this.host}] Download completed: ${localPath}`);
+        logProgress(`[${this.host}] Download completed: ${localPath}`);
       }
     } catch (err) {
       console.error(
@@ -2,6 +2,7 @@ import { IopConfig, IopSecrets } from "../config/types";
 import { SSHClientOptions } from "./index";
 import * as fs from "fs";
 import * as os from "os";
+import { logProgress } from "../utils/output";
 
 /**
  * Get SSH credentials for connecting to a server.
@@ -13,8 +14,9 @@ export async function getSSHCredentials(
   secrets: IopSecrets,
   verbose: boolean = false
 ): Promise<Partial<SSHClientOptions>> {
+  const serverOverride = config.ssh?.servers?.[serverHostname];
   const sshUser = config.ssh?.username || "root"; // Default to root, though setup warns against it
-  const sshPort = config.ssh?.port || 22;
+  const sshPort = serverOverride?.port || config.ssh?.port || 22;
   const sshOptions: Partial<SSHClientOptions> = {
     username: sshUser,
     host: serverHostname,
@@ -22,6 +24,32 @@ export async function getSSHCredentials(
     verbose: verbose,
   };
 
+  const bastion =
+    serverOverride?.bastion === false
+      ? undefined
+      : serverOverride?.bastion || config.ssh?.bastion;
+  if (bastion) {
+    sshOptions.bastion = {
+      host: bastion.host,
+      port: bastion.port || 22,
+      username: bastion.username || sshUser,
+      identity: bastion.key_file ? expandHome(bastion.key_file) : undefined,
+    };
+    if (verbose) {
+      logProgress(
+        `[${serverHostname}] Connecting through bastion ${sshOptions.bastion.username}@${bastion.host}`
+      );
+    }
+  }
+
+  // Agent keys are offered to the bastion and forwarded to the server
+  if (bastion || config.ssh?.agent_forwarding) {
+    sshOptions.agent = process.env.SSH_AUTH_SOCK;
+  }
+  if (config.ssh?.agent_forwarding) {
+    sshOptions.agentForward = true;
+  }
+
   // Check for server-specific key path in secrets
   const serverSpecificKeyEnvVar = `SSH_KEY_${serverHostname
     .replace(/\./g, "_")
@@ -29,7 +57,7 @@ export async function getSSHCredentials(
   const serverSpecificKeyPath = secrets[serverSpecificKeyEnvVar];
   if (serverSpecificKeyPath) {
     if (verbose) {
-      console.log(
+      logProgress(
         `[${serverHostname}] Attempting SSH with server-specific key from secrets (${serverSpecificKeyEnvVar}): ${serverSpecificKeyPath}`
       );
     }
@@ -37,21 +65,20 @@ export async function getSSHCredentials(
     return sshOptions;
   }
 
-  // Check for key_file in config
-  const configKeyFile = config.ssh?.key_file;
+  // Check for key_file in config, preferring the server's own
+  const configKeyFile = serverOverride?.key_file || config.ssh?.key_file;
   if (configKeyFile) {
-    // Expand ~ to home directory
-    const expandedPath = configKeyFile.replace(/^~/, os.homedir());
+    const expandedPath = expandHome(configKeyFile);
     if (fs.existsSync(expandedPath)) {
       if (verbose) {
-        console.log(
+        logProgress(
           `[${serverHostname}] Attempting SSH with key file from config: ${expandedPath}`
         );
       }
       sshOptions.identity = expandedPath;
       return sshOptions;
     } else if (verbose) {
-      console.log(
+      logProgress(
         `[${serverHostname}] Config key_file ${expandedPath} does not exist, skipping...`
       );
     }
@@ -65,7 +92,7 @@ export async function getSSHCredentials(
   const serverSpecificPassword = secrets[serverSpecificPasswordEnvVar];
   if (serverSpecificPassword) {
     if (verbose) {
-      console.log(
+      logProgress(
         `[${serverHostname}] Attempting SSH with server-specific password from secrets (${serverSpecificPasswordEnvVar}).`
       );
     }
@@ -77,7 +104,7 @@ export async function getSSHCredentials(
   const defaultPassword = secrets.DEFAULT_SSH_PASSWORD;
   if (defaultPassword) {
     if (verbose) {
-      console.log(
+      logProgress(
         `[${serverHostname}] Attempting SSH with default password from secrets (DEFAULT_SSH_PASSWORD).`
       );
     }
@@ -88,7 +115,7 @@ export async function getSSHCredentials(
   // Try to find common SSH key files in the user's home directory
   const homeDir = os.homedir();
   if (verbose) {
-    console.log(`[${serverHostname}] Home directory resolved as: ${homeDir}`);
+    logProgress(`[${serverHostname}] Home directory resolved as: ${homeDir}`);
   }
 
   // Check for common SSH key files
@@ -104,7 +131,7 @@ export async function getSSHCredentials(
       if (fs.existsSync(keyPath)) {
         sshOptions.identity = keyPath;
         if (verbose) {
-          console.log(
+          logProgress(
             `[${serverHostname}] Explicitly using SSH key at: ${keyPath}`
           );
         }
@@ -113,7 +140,7 @@ export async function getSSHCredentials(
     }
 
     if (!sshOptions.identity && verbose) {
-      console.log(
+      logProgress(
         `[${serverHostname}] No SSH keys found at standard locations: ${keyPaths.join(
           ", "
         )}`
@@ -129,10 +156,47 @@ export async function getSSHCredentials(
   }
 
   if (verbose) {
-    console.log(
+    logProgress(
       `[${serverHostname}] No specific SSH key or password found in iop secrets. Attempting agent-based authentication or found key file.`
     );
   }
 
   return sshOptions;
 }
+
+function expandHome(filePath: string): string {
+  return filePath.replace(/^~/, os.homedir());
+}
+
+/**
+ * Builds the options that make the ssh binary connect like SSHClient does,
+ * for commands that shell out to ssh, rsync or scp. The port is left out as
+ * scp spells its flag differently.
+ */
+export function buildSSHCommandOptions(
+  sshOptions: Partial<SSHClientOptions>
+): string[] {
+  const args: string[] = [];
+
+  if (sshOptions.identity) {
+    args.push("-i", sshOptions.identity);
+  }
+  if (sshOptions.agentForward) {
+    args.push("-A");
+  }
+  if (sshOptions.bastion) {
+    // ProxyCommand rather than -J, so the bastion can use its own key
+    const jump = ["ssh", "-W", "%h:%p"];
+    if (sshOptions.bastion.port && sshOptions.bastion.port !== 22) {
+      jump.push("-p", String(sshOptions.bastion.port));
+    }
+    const identity = sshOptions.bastion.identity || sshOptions.identity;
+    if (identity) {
+      jump.push("-i", identity);
+    }
+    jump.push(`${sshOptions.bastion.username}@${sshOptions.bastion.host}`);
+    args.push("-o", `ProxyCommand=${jump.join(" ")}`);
+  }
+
+  return args;
+}
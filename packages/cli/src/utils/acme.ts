@@ -0,0 +1,35 @@
+import { IopConfig, IopSecrets } from "../config/types";
+import { ProxyAcmeConfig } from "../proxy";
+
+/**
+ * Resolves the acme section into the settings pushed to the proxy, reading
+ * the External Account Binding credentials from secrets
+ */
+export function buildAcmeConfig(
+  config: IopConfig,
+  secrets: IopSecrets
+): ProxyAcmeConfig | null {
+  if (!config.acme) {
+    return null;
+  }
+
+  const { directory, email, eab_kid_secret, eab_hmac_key_secret } = config.acme;
+  if (!eab_kid_secret !== !eab_hmac_key_secret) {
+    throw new Error("acme.eab_kid_secret and acme.eab_hmac_key_secret must be set together");
+  }
+
+  const readSecret = (key: string) => {
+    const value = secrets[key];
+    if (!value) {
+      throw new Error(`ACME secret "${key}" not found in secrets`);
+    }
+    return value;
+  };
+
+  return {
+    directory,
+    email,
+    eabKeyId: eab_kid_secret ? readSecret(eab_kid_secret) : undefined,
+    eabHmacKey: eab_hmac_key_secret ? readSecret(eab_hmac_key_secret) : undefined,
+  };
+}
@@ -0,0 +1,243 @@
+import * as crypto from "crypto";
+import * as fs from "fs";
+import * as path from "path";
+import { exec } from "child_process";
+import { promisify } from "util";
+import { shellQuote } from "./shell";
+
+const execAsync = promisify(exec);
+
+export interface DockerignoreRule {
+  pattern: RegExp;
+  negate: boolean;
+}
+
+/**
+ * Converts a single .dockerignore pattern into a regular expression.
+ * Follows Docker's rules: '**' matches any number of directories, '*' and '?'
+ * never cross a '/', and a pattern matching a directory excludes its contents.
+ */
+function dockerignorePatternToRegExp(pattern: string): RegExp {
+  let regex = "";
+  for (let i = 0; i < pattern.length; i++) {
+    const char = pattern[i];
+    if (char === "*") {
+      if (pattern[i + 1] === "*") {
+        // "**/" matches zero or more directories, a trailing "**" matches everything
+        if (pattern[i + 2] === "/") {
+          regex += "(?:.*/)?";
+          i += 2;
+        } else {
+          regex += ".*";
+          i += 1;
+        }
+      } else {
+        regex += "[^/]*";
+      }
+    } else if (char === "?") {
+      regex += "[^/]";
+    } else if ("\\^$+.()|{}[]".includes(char)) {
+      regex += `\\${char}`;
+    } else {
+      regex += char;
+    }
+  }
+  return new RegExp(`^${regex}(?:/.*)?$`);
+}
+
+/**
+ * Parses the contents of a .dockerignore file into ordered rules
+ */
+export function parseDockerignore(content: string): DockerignoreRule[] {
+  return content
+    .split("\n")
+    .map((line) => line.trim())
+    .filter((line) => line.length > 0 && !line.startsWith("#"))
+    .map((line) => {
+      const negate = line.startsWith("!");
+      const raw = (negate ? line.slice(1) : line).trim();
+      const cleaned = path.posix
+        .normalize(raw)
+        .replace(/^\/+/, "")
+        .replace(/\/+$/, "");
+      return { pattern: dockerignorePatternToRegExp(cleaned), negate };
+    });
+}
+
+/**
+ * Checks if a context-relative path is excluded. Later rules win, like Docker.
+ */
+export function isIgnored(relativePath: string, rules: DockerignoreRule[]): boolean {
+  let ignored = false;
+  for (const rule of rules) {
+    if (rule.pattern.test(relativePath)) {
+      ignored = !rule.negate;
+    }
+  }
+  return ignored;
+}
+
+/**
+ * Lists the files Docker would send as build context, relative to the context directory.
+ * The Dockerfile and .dockerignore are always included, matching docker build.
+ */
+export function collectBuildContextFiles(
+  contextDir: string,
+  dockerfile: string = "Dockerfile"
+): string[] {
+  const ignorePath = path.join(contextDir, ".dockerignore");
+  const rules = fs.existsSync(ignorePath)
+    ? parseDockerignore(fs.readFileSync(ignorePath, "utf-8"))
+    : [];
+
+  const alwaysIncluded = new Set([
+    path.posix.normalize(dockerfile.split(path.sep).join("/")),
+    ".dockerignore",
+  ]);
+
+  const files: string[] = [];
+  const walk = (dir: string) => {
+    for (const entry of fs.readdirSync(dir, { withFileTypes: true })) {
+      const absolutePath = path.join(dir, entry.name);
+      const relativePath = path
+        .relative(contextDir, absolutePath)
+        .split(path.sep)
+        .join("/");
+
+      if (entry.isDirectory()) {
+        // Negated rules can re-include files below an ignored directory, so only
+        // prune when no negation exists
+        if (
+          isIgnored(relativePath, rules) &&
+          !rules.some((rule) => rule.negate)
+        ) {
+          continue;
+        }
+        walk(absolutePath);
+      } else if (entry.isFile() || entry.isSymbolicLink()) {
+        if (alwaysIncluded.has(relativePath) || !isIgnored(relativePath, rules)) {
+          files.push(relativePath);
+        }
+      }
+    }
+  };
+
+  walk(contextDir);
+  return files.sort();
+}
+
+/**
+ * Computes a content hash over the given build context files
+ */
+export function hashBuildContext(contextDir: string, files: string[]): string {
+  const hash = crypto.createHash("sha256");
+  for (const file of files) {
+    hash.update(file);
+    hash.update("\0");
+    hash.update(fs.readFileSync(path.join(contextDir, file)));
+    hash.update("\0");
+  }
+  return hash.digest("hex").substring(0, 12);
+}
+
+/**
+ * Lists the images a Dockerfile builds FROM, leaving out scratch and earlier
+ * stages. Returns null when a FROM uses a build argument, as the image isn't
+ * known before building.
+ */
+export function parseBaseImages(dockerfileContent: string): string[] | null {
+  const images: string[] = [];
+  const stages = new Set<string>();
+  for (const line of dockerfileContent.split("\n")) {
+    const match = line.match(/^\s*FROM\s+(?:--\S+\s+)*(\S+)(?:\s+AS\s+(\S+))?/i);
+    if (!match) {
+      continue;
+    }
+    const [, image, stage] = match;
+    if (image.includes("$")) {
+      return null;
+    }
+    if (image !== "scratch" && !stages.has(image.toLowerCase()) && !images.includes(image)) {
+      images.push(image);
+    }
+    if (stage) {
+      stages.add(stage.toLowerCase());
+    }
+  }
+  return images;
+}
+
+/**
+ * Resolves the image a local build would start from: the local copy when one
+ * exists, as builds don't pull, otherwise the registry's current digest
+ */
+export async function resolveBaseImageDigest(image: string): Promise<string | null> {
+  try {
+    const { stdout } = await execAsync(`docker image inspect --format '{{.Id}}' ${shellQuote(image)}`);
+    if (stdout.trim()) {
+      return stdout.trim();
+    }
+  } catch {
+    // Not pulled locally, ask the registry
+  }
+  try {
+    const { stdout } = await execAsync(
+      `docker buildx imagetools inspect --format '{{.Manifest.Digest}}' ${shellQuote(image)}`
+    );
+    return stdout.trim() || null;
+  } catch {
+    return null;
+  }
+}
+
+/**
+ * Hashes everything a local build starts from: the build context contents and
+ * the digests of the Dockerfile's base images, so a new base image is rebuilt
+ * even when no file changed. Returns null when a base image can't be resolved.
+ */
+export async function hashBuildInputs(
+  contextDir: string,
+  files: string[],
+  dockerfile: string = "Dockerfile",
+  resolveDigest: (image: string) => Promise<string | null> = resolveBaseImageDigest
+): Promise<string | null> {
+  const dockerfilePath = path.join(contextDir, dockerfile);
+  if (!fs.existsSync(dockerfilePath)) {
+    return null;
+  }
+  const baseImages = parseBaseImages(fs.readFileSync(dockerfilePath, "utf-8"));
+  if (!baseImages) {
+    return null;
+  }
+
+  const hash = crypto.createHash("sha256");
+  hash.update(hashBuildContext(contextDir, files));
+  for (const image of baseImages) {
+    const digest = await resolveDigest(image);
+    if (!digest) {
+      return null;
+    }
+    hash.update(`\0${image}@${digest}`);
+  }
+  return hash.digest("hex").substring(0, 12);
+}
+
+/**
+ * Writes the given build context files into a gzipped tar archive
+ */
+export async function createBuildContextArchive(
+  contextDir: string,
+  files: string[],
+  archivePath: string
+): Promise<void> {
+  const fileListPath = `${archivePath}.files`;
+  fs.writeFileSync(fileListPath, files.join("\n") + "\n");
+  try {
+    await execAsync(
+      `tar -czf "${archivePath}" -C "${contextDir}" -T "${fileListPath}"`,
+      { maxBuffer: 10 * 1024 * 1024 }
+    );
+  } finally {
+    fs.unlinkSync(fileListPath);
+  }
+}
@@ -0,0 +1,180 @@
+import * as crypto from "crypto";
+import {
+  BuiltinRegistryConfig,
+  IopConfig,
+  IopSecrets,
+} from "../config/types";
+import { DockerClient } from "../docker";
+import { IopProxyClient } from "../proxy";
+import { SSHClient } from "../ssh";
+
+export const REGISTRY_CONTAINER = "iop-registry";
+// The proxy routes the registry as its own project, so the proxy attaches to
+// its network like any other project's
+export const REGISTRY_PROJECT = "iop-registry";
+const REGISTRY_NETWORK = "iop-registry-network";
+const REGISTRY_VOLUME = "iop-registry-data";
+const REGISTRY_PORT = 5000;
+const REGISTRY_DIR = "~/.iop/registry";
+// Only used to hash the password, the registry image no longer ships htpasswd
+const HTPASSWD_IMAGE = "httpd:2-alpine";
+
+/**
+ * Returns the server the built-in registry runs on
+ */
+export function getRegistryServer(config: IopConfig): string | undefined {
+  if (!config.registry) {
+    return undefined;
+  }
+  if (config.registry.server) {
+    return config.registry.server;
+  }
+  const services = Array.isArray(config.services)
+    ? config.services
+    : Object.values(config.services || {});
+  return services[0]?.server;
+}
+
+/**
+ * Returns the password of the built-in registry's user from the secrets store
+ */
+export function getRegistryPassword(
+  registry: BuiltinRegistryConfig,
+  secrets: IopSecrets
+): string {
+  const password = secrets[registry.password_secret];
+  if (!password) {
+    throw new Error(
+      `Registry password secret "${registry.password_secret}" not found in secrets`
+    );
+  }
+  return password;
+}
+
+/**
+ * Identifies the registry's settings and credentials, so a change recreates
+ * the container. The password only goes in hashed.
+ */
+export function getRegistryFingerprint(
+  registry: BuiltinRegistryConfig,
+  password: string
+): string {
+  return crypto
+    .createHash("sha256")
+    .update(
+      [registry.host, registry.image, registry.username, password].join("\n")
+    )
+    .digest("hex")
+    .substring(0, 16);
+}
+
+/**
+ * Runs the built-in registry on the server and routes its host through the
+ * proxy. The container is left alone while its settings are unchanged.
+ * Returns whether it was (re)created.
+ */
+export async function ensureBuiltinRegistry(
+  sshClient: SSHClient,
+  dockerClient: DockerClient,
+  proxyClient: IopProxyClient,
+  config: IopConfig,
+  secrets: IopSecrets
+): Promise<boolean> {
+  const registry = config.registry!;
+  const password = getRegistryPassword(registry, secrets);
+  const fingerprint = getRegistryFingerprint(registry, password);
+
+  const labels = await dockerClient.getContainerLabels(REGISTRY_CONTAINER);
+  const upToDate =
+    labels["iop.registry.fingerprint"] === fingerprint &&
+    (await dockerClient.containerIsRunning(REGISTRY_CONTAINER));
+
+  if (!upToDate) {
+    const managedLabels = {
+      "iop.managed": "true",
+      "iop.project": REGISTRY_PROJECT,
+    };
+    if (
+      !(await dockerClient.createNetwork({
+        name: REGISTRY_NETWORK,
+        labels: managedLabels,
+      })) ||
+      !(await dockerClient.createVolume({
+        name: REGISTRY_VOLUME,
+        labels: managedLabels,
+      }))
+    ) {
+      throw new Error("Failed to create the registry's network and volume");
+    }
+
+    // The password goes through a private file so it never shows up in the
+    // process list
+    const passwordFile = `${REGISTRY_DIR}/.password`;
+    await sshClient.exec(`mkdir -p ${REGISTRY_DIR} && chmod 700 ${REGISTRY_DIR}`);
+    await sshClient.exec(`umask 077 && cat > ${passwordFile} << 'EOF'
+${password}
+EOF`);
+    try {
+      await sshClient.exec(
+        `docker run --rm -i --entrypoint htpasswd ${HTPASSWD_IMAGE} -Bin ${registry.username} < ${passwordFile} > ${REGISTRY_DIR}/htpasswd`
+      );
+    } finally {
+      await sshClient.exec(`rm -f ${passwordFile}`);
+    }
+
+    if (await dockerClient.containerExists(REGISTRY_CONTAINER)) {
+      await dockerClient.stopContainer(REGISTRY_CONTAINER);
+      await dockerClient.removeContainer(REGISTRY_CONTAINER);
+    }
+
+    const created = await dockerClient.createContainer({
+      name: REGISTRY_CONTAINER,
+      image: registry.image,
+      network: REGISTRY_NETWORK,
+      volumes: [
+        `${REGISTRY_VOLUME}:/var/lib/registry`,
+        `${REGISTRY_DIR}:/auth:ro`,
+      ],
+      envVars: {
+        REGISTRY_AUTH: "htpasswd",
+        REGISTRY_AUTH_HTPASSWD_REALM: "iop",
+        REGISTRY_AUTH_HTPASSWD_PATH: "/auth/htpasswd",
+        // Upload URLs must point at the proxy, not the container
+        REGISTRY_HTTP_HOST: `https://${registry.host}`,
+        REGISTRY_STORAGE_DELETE_ENABLED: "true",
+      },
+      labels: {
+        ...managedLabels,
+        "iop.registry.fingerprint": fingerprint,
+      },
+    });
+    if (!created) {
+      throw new Error(`Failed to start ${REGISTRY_CONTAINER}`);
+    }
+  }
+
+  if (
+    !(await dockerClient.isContainerConnectedToNetwork(
+      "iop-proxy",
+      REGISTRY_NETWORK
+    ))
+  ) {
+    await dockerClient.connectContainerToNetwork("iop-proxy", REGISTRY_NETWORK);
+  }
+
+  // Layers can take a while to upload and for the registry to commit
+  const routed = await proxyClient.configureProxy(
+    registry.host,
+    REGISTRY_CONTAINER,
+    REGISTRY_PORT,
+    REGISTRY_PROJECT,
+    "/",
+    "http",
+    { response_timeout: "10m", streaming: true }
+  );
+  if (!routed) {
+    throw new Error(`Failed to route ${registry.host} to ${REGISTRY_CONTAINER}`);
+  }
+
+  return !upToDate;
+}
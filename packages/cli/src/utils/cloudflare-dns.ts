@@ -0,0 +1,215 @@
+import { DnsConfig, IopSecrets } from "../config/types";
+
+const CLOUDFLARE_API = "https://api.cloudflare.com/client/v4";
+
+export type DnsRecordType = "A" | "AAAA" | "CNAME";
+
+export interface DnsRecord {
+  id: string;
+  type: string;
+  name: string;
+  content: string;
+  proxied?: boolean;
+  comment?: string | null;
+}
+
+export type DnsChange =
+  | { action: "create" }
+  | { action: "unchanged"; record: DnsRecord }
+  | { action: "update"; record: DnsRecord }
+  | { action: "conflict"; record: DnsRecord };
+
+/**
+ * Works out which record type points a host at a server:
+ * A for IPv4, AAAA for IPv6 and CNAME for hostnames
+ */
+export function getDnsRecordForServer(server: string): {
+  type: DnsRecordType;
+  content: string;
+} {
+  if (/^\d{1,3}(\.\d{1,3}){3}$/.test(server)) {
+    return { type: "A", content: server };
+  }
+  if (server.includes(":")) {
+    return { type: "AAAA", content: server.replace(/^\[|\]$/g, "") };
+  }
+  return { type: "CNAME", content: server };
+}
+
+/**
+ * Comment written on records iop manages, used to find them again on removal
+ */
+export function getDnsRecordComment(projectName: string, serviceName: string): string {
+  return `iop:${projectName}/${serviceName}`;
+}
+
+/**
+ * Compares the records that exist for a host with the desired one. Records
+ * iop did not create are never overwritten.
+ */
+export function planDnsChange(
+  existing: DnsRecord[],
+  desired: { type: DnsRecordType; content: string; proxied: boolean },
+  comment: string
+): DnsChange {
+  const relevant = existing.filter((record) =>
+    ["A", "AAAA", "CNAME"].includes(record.type)
+  );
+  if (relevant.length === 0) {
+    return { action: "create" };
+  }
+
+  const match = relevant.find(
+    (record) => record.type === desired.type && record.content === desired.content
+  );
+  if (match) {
+    return match.proxied === desired.proxied
+      ? { action: "unchanged", record: match }
+      : { action: "update", record: match };
+  }
+
+  const managed = relevant.find((record) => record.comment === comment);
+  if (managed) {
+    return { action: "update", record: managed };
+  }
+
+  return { action: "conflict", record: relevant[0] };
+}
+
+/**
+ * Minimal Cloudflare DNS API client
+ */
+export class CloudflareDnsClient {
+  private apiToken: string;
+  private config: DnsConfig;
+  private fetchFn: typeof fetch;
+  private zoneIds = new Map<string, string>();
+
+  constructor(
+    apiToken: string,
+    config: DnsConfig,
+    fetchFn: typeof fetch = fetch
+  ) {
+    this.apiToken = apiToken;
+    this.config = config;
+    this.fetchFn = fetchFn;
+  }
+
+  /**
+   * Creates a client from the dns section, reading the API token from secrets
+   */
+  static fromConfig(config: DnsConfig, secrets: IopSecrets): CloudflareDnsClient {
+    const apiToken = secrets[config.api_token_secret];
+    if (!apiToken) {
+      throw new Error(
+        `Cloudflare API token "${config.api_token_secret}" not found in secrets`
+      );
+    }
+    return new CloudflareDnsClient(apiToken, config);
+  }
+
+  private async request<T>(method: string, path: string, body?: unknown): Promise<T> {
+    const response = await this.fetchFn(`${CLOUDFLARE_API}${path}`, {
+      method,
+      headers: {
+        Authorization: `Bearer ${this.apiToken}`,
+        "Content-Type": "application/json",
+      },
+      body: body ? JSON.stringify(body) : undefined,
+    });
+    const data: any = await response.json();
+    if (!response.ok || !data.success) {
+      const errors = (data.errors || [])
+        .map((error: any) => error.message)
+        .join(", ");
+      throw new Error(
+        `Cloudflare API ${method} ${path} failed: ${errors || response.status}`
+      );
+    }
+    return data.result as T;
+  }
+
+  /**
+   * Finds the zone a host belongs to by trying each parent domain
+   */
+  async getZoneId(host: string): Promise<string> {
+    if (this.config.zone_id) {
+      return this.config.zone_id;
+    }
+
+    const labels = host.split(".");
+    for (let i = 0; i < labels.length - 1; i++) {
+      const candidate = labels.slice(i).join(".");
+      const cached = this.zoneIds.get(candidate);
+      if (cached) {
+        return cached;
+      }
+      const zones = await this.request<Array<{ id: string }>>(
+        "GET",
+        `/zones?name=${encodeURIComponent(candidate)}`
+      );
+      if (zones.length > 0) {
+        this.zoneIds.set(candidate, zones[0].id);
+        return zones[0].id;
+      }
+    }
+
+    throw new Error(`No Cloudflare zone found for ${host}`);
+  }
+
+  /**
+   * Creates or verifies the record pointing a host at a server
+   */
+  async ensureRecord(host: string, server: string, comment: string): Promise<DnsChange> {
+    const zoneId = await this.getZoneId(host);
+    const existing = await this.request<DnsRecord[]>(
+      "GET",
+      `/zones/${zoneId}/dns_records?name=${encodeURIComponent(host)}`
+    );
+
+    const desired = { ...getDnsRecordForServer(server), proxied: this.config.proxied };
+    const change = planDnsChange(existing, desired, comment);
+    const body = {
+      ...desired,
+      name: host,
+      ttl: this.config.ttl,
+      comment,
+    };
+
+    if (change.action === "create") {
+      await this.request("POST", `/zones/${zoneId}/dns_records`, body);
+    } else if (change.action === "update") {
+      await this.request(
+        "PUT",
+        `/zones/${zoneId}/dns_records/${change.record.id}`,
+        body
+      );
+    }
+
+    return change;
+  }
+
+  /**
+   * Deletes every record carrying the given comment, returning the removed names
+   */
+  async deleteRecordsByComment(comment: string): Promise<string[]> {
+    const zoneIds = this.config.zone_id
+      ? [this.config.zone_id]
+      : (await this.request<Array<{ id: string }>>("GET", "/zones?per_page=50")).map(
+          (zone) => zone.id
+        );
+
+    const removed: string[] = [];
+    for (const zoneId of zoneIds) {
+      const records = await this.request<DnsRecord[]>(
+        "GET",
+        `/zones/${zoneId}/dns_records?comment.exact=${encodeURIComponent(comment)}`
+      );
+      for (const record of records) {
+        await this.request("DELETE", `/zones/${zoneId}/dns_records/${record.id}`);
+        removed.push(record.name);
+      }
+    }
+    return removed;
+  }
+}
@@ -1,8 +1,31 @@
-import { IopConfig, ServiceEntry } from "../config/types";
+import { z } from "zod";
+import {
+  CONFIG_SCHEMA_VERSION,
+  IopConfig,
+  IopConfigSchema,
+  ServiceEntry,
+} from "../config/types";
+import { ProxyHostInfo } from "../proxy";
 import { parsePortMappings } from "./port-checker";
+import { requiresZeroDowntimeDeployment } from "./service-utils";
+import { findReferences } from "../config/environment";
+
+// RFC 1123 host names: dot separated labels of letters, digits and inner hyphens,
+// optionally behind a "*." wildcard label
+const HOSTNAME_PATTERN =
+  /^(?=.{1,253}$)(\*\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$/i;
 
 export interface ConfigValidationError {
-  type: "port_conflict" | "invalid_port" | "configuration_error" | "reserved_name";
+  type:
+    | "port_conflict"
+    | "invalid_port"
+    | "configuration_error"
+    | "reserved_name"
+    | "invalid_host"
+    | "duplicate_host"
+    | "host_conflict"
+    | "unknown_key"
+    | "unsupported_version";
   message: string;
   entries: string[];
   server: string;
@@ -14,7 +37,7 @@ export class ConfigValidator {
   private config: IopConfig;
   
   // Reserved command names that cannot be used as app/service names
-  private static readonly RESERVED_NAMES = ["init", "status", "proxy"];
+  private static readonly RESERVED_NAMES = ["init", "status", "proxy", "exec", "volumes", "db", "prune", "preview", "validate", "diff", "ports", "audit", "top", "uptime", "incident", "ps", "doctor", "env", "registry", "network", "logs", "restart", "stop", "start"];
 
   constructor(config: IopConfig) {
     this.config = config;
@@ -26,6 +49,9 @@ export class ConfigValidator {
   validate(): ConfigValidationError[] {
     const errors: ConfigValidationError[] = [];
 
+    // Check the config isn't written for a newer iop
+    errors.push(...this.checkVersion());
+
     // Check for reserved names
     const reservedNameErrors = this.checkReservedNames();
     errors.push(...reservedNameErrors);
@@ -38,6 +64,447 @@ export class ConfigValidator {
     const invalidPorts = this.checkInvalidPorts();
     errors.push(...invalidPorts);
 
+    // Check proxy host names and that each host is routed to one service
+    errors.push(...this.checkHosts());
+
+    // Check workers don't use settings that need the proxy
+    errors.push(...this.checkWorkers());
+
+    // Check probes are only set where something runs them
+    errors.push(...this.checkProbes());
+
+    // Check baked deploys have limits to roll back on
+    errors.push(...this.checkBake());
+
+    // Check sidecars are only attached to blue-green deployed apps
+    errors.push(...this.checkSidecars());
+
+    // Check ${NAME} references point at variables of the same service
+    errors.push(...this.checkEnvironmentReferences());
+
+    // Check the built-in registry runs on a deployed server, at its own host
+    errors.push(...this.checkRegistry());
+
+    // Check the status page is served by a deployed server and shows its apps
+    errors.push(...this.checkStatusPage());
+
+    // Check signatures are only verified for pulled images
+    errors.push(...this.checkImageVerification());
+
+    // Check secret files don't share a path in the container
+    errors.push(...this.checkSecretFiles());
+
+    return errors;
+  }
+
+  /**
+   * Checks that variables referenced from plain values are defined. Secret
+   * references are resolved at deploy time, when secrets are loaded.
+   */
+  private checkEnvironmentReferences(): ConfigValidationError[] {
+    const errors: ConfigValidationError[] = [];
+
+    for (const entry of this.getAllEntries()) {
+      const plain = entry.environment?.plain || [];
+      const defined = new Set([
+        ...plain.map((envVar) => envVar.split("=")[0]),
+        ...(entry.environment?.secret || []),
+      ]);
+
+      for (const envVar of plain) {
+        const key = envVar.split("=")[0];
+        for (const name of findReferences(envVar).variables) {
+          if (defined.has(name)) continue;
+          errors.push({
+            type: "configuration_error",
+            message: `Variable ${name} used in ${key} of ${entry.name} is not defined`,
+            entries: [entry.name],
+            server: entry.server,
+            suggestions: [
+              `Add ${name} to the service's environment or one of its env_groups,`,
+              "use ${secrets." + name + "} for a secret, or write $${" + name + "} for a literal ${" + name + "}",
+            ],
+          });
+        }
+      }
+    }
+
+    return errors;
+  }
+
+  /**
+   * Checks that probes are set on apps: deploys run startup probes through
+   * the proxy, which runs readiness and liveness probes for its hosts
+   */
+  private checkProbes(): ConfigValidationError[] {
+    const errors: ConfigValidationError[] = [];
+
+    for (const entry of this.getAllEntries()) {
+      const { startup, readiness, liveness } = entry.health_check || {};
+      if (!startup && !readiness && !liveness) continue;
+
+      if (entry.type === "worker") {
+        errors.push({
+          type: "configuration_error",
+          message: `Worker ${entry.name} has health check probes`,
+          entries: [entry.name],
+          server: entry.server,
+          suggestions: [
+            "Probes make HTTP requests. Use health_check.command to check workers.",
+          ],
+        });
+      } else if ((readiness || liveness) && !entry.proxy) {
+        errors.push({
+          type: "configuration_error",
+          message: `Service ${entry.name} has readiness or liveness probes but no proxy configuration`,
+          entries: [entry.name],
+          server: entry.server,
+          suggestions: [
+            "The proxy runs readiness and liveness probes for the hosts it routes. Add 'proxy' to the service.",
+          ],
+        });
+      } else if (liveness && entry.proxy?.mode === "passthrough") {
+        errors.push({
+          type: "configuration_error",
+          message: `Service ${entry.name} has a liveness probe but its proxy mode is passthrough`,
+          entries: [entry.name],
+          server: entry.server,
+          suggestions: [
+            "Passthrough hosts are only checked for open connections. Remove the liveness probe.",
+          ],
+        });
+      }
+    }
+
+    return errors;
+  }
+
+  /**
+   * Checks that baked deploys can be judged: the proxy sees their responses
+   * and has an error rate or latency limit to compare them with
+   */
+  private checkBake(): ConfigValidationError[] {
+    const errors: ConfigValidationError[] = [];
+
+    for (const entry of this.getAllEntries()) {
+      const bake = entry.proxy?.bake;
+      if (!bake) continue;
+
+      if (entry.proxy?.mode === "passthrough") {
+        errors.push({
+          type: "configuration_error",
+          message: `Service ${entry.name} bakes its deploys but its proxy mode is passthrough`,
+          entries: [entry.name],
+          server: entry.server,
+          suggestions: [
+            "The proxy can't see the responses of passthrough hosts. Remove proxy.bake.",
+          ],
+        });
+      } else if (bake.max_error_rate === undefined && bake.max_latency === undefined) {
+        errors.push({
+          type: "configuration_error",
+          message: `Service ${entry.name} bakes its deploys without limits to roll back on`,
+          entries: [entry.name],
+          server: entry.server,
+          suggestions: [
+            "Set proxy.bake.max_error_rate, proxy.bake.max_latency or both",
+          ],
+        });
+      }
+    }
+
+    return errors;
+  }
+
+  /**
+   * Checks that each secret file of a service is mounted at its own path
+   */
+  private checkSecretFiles(): ConfigValidationError[] {
+    const errors: ConfigValidationError[] = [];
+
+    for (const entry of this.getAllEntries()) {
+      const seen = new Set<string>();
+      for (const file of entry.secret_files || []) {
+        if (seen.has(file.path)) {
+          errors.push({
+            type: "configuration_error",
+            message: `Service ${entry.name} mounts more than one secret file at ${file.path}`,
+            entries: [entry.name],
+            server: entry.server,
+            suggestions: ["Give each secret file its own path"],
+          });
+        }
+        seen.add(file.path);
+      }
+    }
+
+    return errors;
+  }
+
+  /**
+   * Checks that sidecars belong to apps, as they follow the lifecycle of
+   * blue-green app containers
+   */
+  private checkSidecars(): ConfigValidationError[] {
+    return this.getAllEntries()
+      .filter((entry) => entry.sidecars && !requiresZeroDowntimeDeployment(entry))
+      .map((entry) => ({
+        type: "configuration_error" as const,
+        message: `Service ${entry.name} has sidecars but isn't an app`,
+        entries: [entry.name],
+        server: entry.server,
+        suggestions: [
+          "Sidecars run next to blue-green app containers. Add 'proxy' or 'type: worker' to the service.",
+        ],
+      }));
+  }
+
+  /**
+   * Checks signature verification is only set on services with pre-built
+   * images, as images built by iop aren't signed
+   */
+  private checkImageVerification(): ConfigValidationError[] {
+    return this.getAllEntries()
+      .filter((entry) => entry.verify && (entry.build || !entry.image))
+      .map((entry) => ({
+        type: "configuration_error" as const,
+        message: `Service ${entry.name} verifies signatures but is built from source`,
+        entries: [entry.name],
+        server: entry.server,
+        suggestions: [
+          "Signatures are checked for images pulled from a registry. Remove 'verify', or deploy a signed 'image'.",
+        ],
+      }));
+  }
+
+  /**
+   * Checks the built-in registry's server is one deploy sets up, and that its
+   * host is a valid name no service routes
+   */
+  private checkRegistry(): ConfigValidationError[] {
+    const registry = this.config.registry;
+    if (!registry) return [];
+
+    const errors: ConfigValidationError[] = [];
+    const entries = this.getAllEntries();
+    const server = registry.server || entries[0]?.server || "";
+
+    if (!entries.some((entry) => entry.server === server)) {
+      errors.push({
+        type: "configuration_error",
+        message: registry.server
+          ? `Registry server ${registry.server} doesn't run any service`
+          : "The registry needs a server, but no services are configured",
+        entries: ["registry"],
+        server,
+        suggestions: [
+          "Deploy sets up the registry with the other infrastructure, so it must run on one of the services' servers.",
+        ],
+      });
+    }
+
+    if (!HOSTNAME_PATTERN.test(registry.host) || registry.host.startsWith("*.")) {
+      errors.push({
+        type: "invalid_host",
+        message: `Invalid registry host "${registry.host}"`,
+        entries: ["registry"],
+        server,
+        suggestions: ["Use a plain host name, e.g. registry.example.com"],
+      });
+    }
+
+    const routed = entries.filter((entry) =>
+      (entry.proxy?.hosts || []).includes(registry.host)
+    );
+    if (routed.length > 0) {
+      errors.push({
+        type: "duplicate_host",
+        message: `Registry host ${registry.host} is also routed to ${routed.map((entry) => entry.name).join(", ")}`,
+        entries: ["registry", ...routed.map((entry) => entry.name)],
+        server,
+        suggestions: ["Give the registry a host of its own"],
+      });
+    }
+
+    return errors;
+  }
+
+  /**
+   * Checks the status page's server is one deploy sets up, that its host is
+   * a valid name nothing else routes, and that the apps it shows run there
+   */
+  private checkStatusPage(): ConfigValidationError[] {
+    const statusPage = this.config.status_page;
+    if (!statusPage) return [];
+
+    const errors: ConfigValidationError[] = [];
+    const entries = this.getAllEntries();
+    const server = statusPage.server || entries[0]?.server || "";
+
+    if (!entries.some((entry) => entry.server === server)) {
+      errors.push({
+        type: "configuration_error",
+        message: statusPage.server
+          ? `Status page server ${statusPage.server} doesn't run any service`
+          : "The status page needs a server, but no services are configured",
+        entries: ["status_page"],
+        server,
+        suggestions: [
+          "The proxy serving the page checks the apps it shows, so it must run on one of the services' servers.",
+        ],
+      });
+    }
+
+    if (!HOSTNAME_PATTERN.test(statusPage.host) || statusPage.host.startsWith("*.")) {
+      errors.push({
+        type: "invalid_host",
+        message: `Invalid status page host "${statusPage.host}"`,
+        entries: ["status_page"],
+        server,
+        suggestions: ["Use a plain host name, e.g. status.example.com"],
+      });
+    }
+
+    const routed = entries.filter((entry) =>
+      (entry.proxy?.hosts || []).includes(statusPage.host)
+    );
+    if (routed.length > 0 || this.config.registry?.host === statusPage.host) {
+      const names = routed.map((entry) => entry.name);
+      if (this.config.registry?.host === statusPage.host) {
+        names.push("registry");
+      }
+      errors.push({
+        type: "duplicate_host",
+        message: `Status page host ${statusPage.host} is also routed to ${names.join(", ")}`,
+        entries: ["status_page", ...names],
+        server,
+        suggestions: ["Give the status page a host of its own"],
+      });
+    }
+
+    for (const app of statusPage.apps || []) {
+      const entry = entries.find((candidate) => candidate.name === app);
+      if (!entry || !entry.proxy) {
+        errors.push({
+          type: "configuration_error",
+          message: `Status page shows ${app}, which isn't an app with hosts`,
+          entries: ["status_page"],
+          server,
+          suggestions: ["List services that have 'proxy.hosts', or leave out 'apps' to show them all"],
+        });
+      } else if (entry.server !== server) {
+        errors.push({
+          type: "configuration_error",
+          message: `Status page shows ${app}, which runs on ${entry.server} rather than ${server}`,
+          entries: ["status_page", app],
+          server,
+          suggestions: ["The page shows apps of its own server. Set 'status_page.server' to the app's server."],
+        });
+      }
+    }
+
+    return errors;
+  }
+
+  /**
+   * Checks that workers, which have no HTTP endpoint, aren't given proxy
+   * routing or request-based autoscaling
+   */
+  private checkWorkers(): ConfigValidationError[] {
+    const errors: ConfigValidationError[] = [];
+
+    for (const entry of this.getAllEntries()) {
+      if (entry.type !== "worker") continue;
+
+      if (entry.proxy) {
+        errors.push({
+          type: "configuration_error",
+          message: `Worker ${entry.name} has a proxy configuration`,
+          entries: [entry.name],
+          server: entry.server,
+          suggestions: [
+            "Workers aren't registered with the proxy. Remove 'proxy', or remove 'type: worker' to serve HTTP.",
+          ],
+        });
+      }
+
+      if (entry.autoscale) {
+        errors.push({
+          type: "configuration_error",
+          message: `Worker ${entry.name} can't autoscale`,
+          entries: [entry.name],
+          server: entry.server,
+          suggestions: [
+            "The proxy scales apps it routes traffic to. Set a fixed 'replicas' count for workers.",
+          ],
+        });
+      }
+    }
+
+    return errors;
+  }
+
+  /**
+   * Checks the schema version is one this CLI understands
+   */
+  private checkVersion(): ConfigValidationError[] {
+    const version = this.config.version;
+    if (version === undefined || version <= CONFIG_SCHEMA_VERSION) {
+      return [];
+    }
+
+    return [
+      {
+        type: "unsupported_version",
+        message: `iop.yml uses schema version ${version}, but this iop supports up to version ${CONFIG_SCHEMA_VERSION}`,
+        entries: [],
+        server: "",
+        suggestions: ["Upgrade iop to the latest version"],
+      },
+    ];
+  }
+
+  /**
+   * Checks proxy host syntax and hosts claimed by more than one service
+   */
+  private checkHosts(): ConfigValidationError[] {
+    const errors: ConfigValidationError[] = [];
+    const hostOwners = new Map<string, ServiceEntry[]>();
+
+    for (const entry of this.getAllEntries()) {
+      for (const host of entry.proxy?.hosts || []) {
+        if (!HOSTNAME_PATTERN.test(host)) {
+          errors.push({
+            type: "invalid_host",
+            message: `Invalid host "${host}" in ${entry.name}`,
+            entries: [entry.name],
+            server: entry.server,
+            suggestions: [
+              "Hosts are domain names without a scheme, port or path,",
+              'e.g. "example.com", "api.example.com" or "*.tenant.example.com"',
+            ],
+          });
+        }
+
+        const key = host.toLowerCase();
+        hostOwners.set(key, [...(hostOwners.get(key) || []), entry]);
+      }
+    }
+
+    for (const [host, owners] of hostOwners) {
+      if (owners.length > 1) {
+        errors.push({
+          type: "duplicate_host",
+          message: `Host "${host}" is used by multiple services: ${owners
+            .map((owner) => owner.name)
+            .join(", ")}`,
+          entries: owners.map((owner) => owner.name),
+          server: owners[0].server,
+          suggestions: ["Each host can only route to one service"],
+        });
+      }
+    }
+
     return errors;
   }
 
@@ -149,6 +616,7 @@ export class ConfigValidator {
               '- "127.0.0.1:8080:80" (bind to specific IP)',
               '- "80" (same port for host and container)',
               '- "80/tcp" or "80/udp" (specify protocol)',
+              "Port numbers must be between 1 and 65535",
             ],
           });
         }
@@ -173,7 +641,19 @@ export class ConfigValidator {
       /^(\d+\.\d+\.\d+\.\d+):(\d+):(\d+)(?:\/(tcp|udp))?$/, // "127.0.0.1:80:80"
     ];
 
-    return patterns.some((pattern) => pattern.test(portSpec));
+    if (!patterns.some((pattern) => pattern.test(portSpec))) {
+      return false;
+    }
+
+    // Every port number (skipping a bind IP) must be in range
+    return portSpec
+      .split("/")[0]
+      .split(":")
+      .filter((part) => !part.includes("."))
+      .every((part) => {
+        const port = parseInt(part, 10);
+        return port >= 1 && port <= 65535;
+      });
   }
 
   /**
@@ -279,6 +759,185 @@ export function validateConfig(config: IopConfig): ConfigValidationError[] {
   return validator.validate();
 }
 
+/**
+ * Strips optional, default and refinement wrappers to get at the underlying schema
+ */
+function unwrapSchema(schema: z.ZodTypeAny): z.ZodTypeAny {
+  for (;;) {
+    if (schema instanceof z.ZodOptional || schema instanceof z.ZodNullable) {
+      schema = schema.unwrap();
+    } else if (schema instanceof z.ZodDefault) {
+      schema = schema._def.innerType;
+    } else if (schema instanceof z.ZodEffects) {
+      schema = schema.innerType();
+    } else {
+      return schema;
+    }
+  }
+}
+
+function isPlainObject(value: unknown): value is Record<string, unknown> {
+  return typeof value === "object" && value !== null && !Array.isArray(value);
+}
+
+/**
+ * Returns the candidate within two edits of a key, if any
+ */
+function suggestKey(key: string, candidates: string[]): string | undefined {
+  const distance = (a: string, b: string): number => {
+    const row = Array.from({ length: b.length + 1 }, (_, i) => i);
+    for (let i = 1; i <= a.length; i++) {
+      let previous = row[0];
+      row[0] = i;
+      for (let j = 1; j <= b.length; j++) {
+        const current = row[j];
+        row[j] = Math.min(
+          row[j] + 1,
+          row[j - 1] + 1,
+          previous + (a[i - 1] === b[j - 1] ? 0 : 1)
+        );
+        previous = current;
+      }
+    }
+    return row[b.length];
+  };
+
+  return candidates
+    .map((candidate) => ({ candidate, score: distance(key, candidate) }))
+    .filter(({ score }) => score <= 2)
+    .sort((a, b) => a.score - b.score)[0]?.candidate;
+}
+
+function collectUnknownKeys(
+  schema: z.ZodTypeAny,
+  value: unknown,
+  path: string,
+  errors: ConfigValidationError[]
+): void {
+  schema = unwrapSchema(schema);
+
+  if (schema instanceof z.ZodObject) {
+    if (!isPlainObject(value)) return;
+    const shape = schema.shape as Record<string, z.ZodTypeAny>;
+    for (const [key, child] of Object.entries(value)) {
+      const keyPath = path ? `${path}.${key}` : key;
+      if (key in shape) {
+        collectUnknownKeys(shape[key], child, keyPath, errors);
+        continue;
+      }
+
+      const suggestion = suggestKey(key, Object.keys(shape));
+      errors.push({
+        type: "unknown_key",
+        message: `Unknown key "${keyPath}"`,
+        entries: [],
+        server: "",
+        suggestions: suggestion
+          ? [`Did you mean "${suggestion}"?`]
+          : [`Valid keys here: ${Object.keys(shape).join(", ")}`],
+      });
+    }
+  } else if (schema instanceof z.ZodRecord) {
+    if (!isPlainObject(value)) return;
+    for (const [key, child] of Object.entries(value)) {
+      collectUnknownKeys(schema.valueSchema, child, path ? `${path}.${key}` : key, errors);
+    }
+  } else if (schema instanceof z.ZodArray) {
+    if (!Array.isArray(value)) return;
+    value.forEach((item, i) =>
+      collectUnknownKeys(schema.element, item, `${path}.${i}`, errors)
+    );
+  } else if (schema instanceof z.ZodDiscriminatedUnion) {
+    // Follow the option the discriminator names, e.g. a destination's type
+    if (!isPlainObject(value)) return;
+    const option = schema.optionsMap.get(value[schema.discriminator] as string);
+    if (option) {
+      collectUnknownKeys(option, value, path, errors);
+    }
+  } else if (schema instanceof z.ZodUnion) {
+    // Follow the option matching the shape that was written (list or map)
+    const option = (schema.options as z.ZodTypeAny[]).find(
+      (candidate) =>
+        (unwrapSchema(candidate) instanceof z.ZodArray) === Array.isArray(value)
+    );
+    if (option) {
+      collectUnknownKeys(option, value, path, errors);
+    }
+  }
+}
+
+/**
+ * Finds keys in a raw iop.yml that the schema doesn't know about. Loading the
+ * config silently drops them, so typos like "helth_check" would go unnoticed.
+ */
+export function findUnknownConfigKeys(rawConfig: unknown): ConfigValidationError[] {
+  const errors: ConfigValidationError[] = [];
+  collectUnknownKeys(IopConfigSchema, rawConfig, "", errors);
+  return errors;
+}
+
+/**
+ * Finds configured hosts that the proxy on their server already routes for
+ * another project, or that the proxy on another server routes as well
+ */
+export function findHostConflicts(
+  config: IopConfig,
+  proxyHostsByServer: Map<string, Record<string, ProxyHostInfo>>
+): ConfigValidationError[] {
+  const errors: ConfigValidationError[] = [];
+  const services: ServiceEntry[] = Array.isArray(config.services)
+    ? config.services
+    : Object.entries(config.services || {}).map(([name, service]) => ({
+        ...service,
+        name,
+      }));
+
+  for (const service of services) {
+    for (const host of service.proxy?.hosts || []) {
+      const existing = proxyHostsByServer.get(service.server)?.[host];
+      if (existing && existing.project && existing.project !== config.name) {
+        errors.push({
+          type: "host_conflict",
+          message: `Host "${host}" of ${service.name} is already routed to project "${existing.project}" on ${service.server}`,
+          entries: [service.name],
+          server: service.server,
+          suggestions: [
+            `Remove it from the other project first: iop proxy delete-host --host ${host} --server ${service.server}`,
+            "or choose a different host",
+          ],
+        });
+      }
+
+      // DNS points the host at one server, a second proxy routing it is stale
+      for (const [server, proxyHosts] of proxyHostsByServer) {
+        const other = proxyHosts[host];
+        if (server === service.server || !other) continue;
+
+        errors.push({
+          type: "host_conflict",
+          message:
+            other.project === config.name
+              ? `Host "${host}" of ${service.name} is still routed by the proxy on ${server}, but ${service.name} deploys to ${service.server}`
+              : `Host "${host}" of ${service.name} is also routed to project "${other.project}" by the proxy on ${server}`,
+          entries: [service.name],
+          server,
+          suggestions:
+            other.project === config.name
+              ? [
+                  `The next deploy removes it, or run: iop proxy delete-host --host ${host} --server ${server}`,
+                ]
+              : [
+                  `Remove it from the other project: iop proxy delete-host --host ${host} --server ${server}`,
+                  "or choose a different host",
+                ],
+        });
+      }
+    }
+  }
+
+  return errors;
+}
+
 /**
  * Formats validation errors for display
  */
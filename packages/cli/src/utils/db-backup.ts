@@ -0,0 +1,212 @@
+import { BackupConfig, IopSecrets, ServiceEntry } from "../config/types";
+import { getServiceTemplate } from "../config/templates";
+import { SSHClient } from "../ssh";
+import { sanitizeFolderName } from "./index";
+import { shellQuote } from "./shell";
+
+/**
+ * Returns the directory on the server where backups of a service are stored
+ */
+export function getBackupDirectory(
+  projectName: string,
+  serviceName: string
+): string {
+  return `~/.iop/projects/${sanitizeFolderName(
+    projectName
+  )}/backups/${sanitizeFolderName(serviceName)}`;
+}
+
+/**
+ * Returns the path of the backup script installed on the server for a service
+ */
+export function getBackupScriptPath(
+  projectName: string,
+  serviceName: string
+): string {
+  return `${getBackupDirectory(projectName, serviceName)}/backup.sh`;
+}
+
+/**
+ * Marker appended to crontab lines so iop can find and replace its own entries
+ */
+export function getBackupCronMarker(
+  projectName: string,
+  serviceName: string
+): string {
+  return `# iop-backup ${sanitizeFolderName(projectName)}/${sanitizeFolderName(
+    serviceName
+  )}`;
+}
+
+/**
+ * Builds the shell script that dumps a templated service, optionally uploads the
+ * dump to S3, and prunes old backups according to the retention setting
+ */
+export function buildBackupScript(
+  service: ServiceEntry,
+  projectName: string,
+  secrets: IopSecrets
+): string {
+  const template = getServiceTemplate(service);
+  if (!template) {
+    throw new Error(
+      `Service ${service.name} has no template, backups are only supported for postgres, mysql and redis templates`
+    );
+  }
+
+  const backup: BackupConfig = service.backup || { schedule: "0 3 * * *", retention: 7 };
+  const backupDir = getBackupDirectory(projectName, service.name);
+  const containerName = `${projectName}-${service.name}`;
+  const filePrefix = sanitizeFolderName(service.name);
+
+  const lines = [
+    "#!/bin/sh",
+    "# Generated by iop - changes are overwritten on deploy",
+    "set -e",
+    `BACKUP_DIR=${backupDir.replace(/^~/, "$HOME")}`,
+    'mkdir -p "$BACKUP_DIR"',
+    `FILE="$BACKUP_DIR/${filePrefix}-$(date -u +%Y%m%dT%H%M%SZ).${template.dumpExtension}.gz"`,
+    `docker exec ${containerName} sh -c ${shellQuote(template.dumpCommand)} | gzip > "$FILE"`,
+  ];
+
+  if (backup.s3) {
+    const accessKey = secrets[backup.s3.access_key_secret];
+    const secretKey = secrets[backup.s3.secret_key_secret];
+    if (!accessKey || !secretKey) {
+      throw new Error(
+        `S3 credentials for ${service.name} backups not found in secrets (${backup.s3.access_key_secret}, ${backup.s3.secret_key_secret})`
+      );
+    }
+
+    const prefix = backup.s3.prefix
+      ? `${backup.s3.prefix.replace(/\/+$/, "")}/`
+      : "";
+    let upload = `docker run --rm -v "$BACKUP_DIR":/backup`;
+    upload += ` -e AWS_ACCESS_KEY_ID=${shellQuote(accessKey)}`;
+    upload += ` -e AWS_SECRET_ACCESS_KEY=${shellQuote(secretKey)}`;
+    if (backup.s3.region) {
+      upload += ` -e AWS_DEFAULT_REGION=${shellQuote(backup.s3.region)}`;
+    }
+    upload += ` amazon/aws-cli s3 cp "/backup/$(basename "$FILE")" "s3://${backup.s3.bucket}/${prefix}$(basename "$FILE")"`;
+    if (backup.s3.endpoint) {
+      upload += ` --endpoint-url ${shellQuote(backup.s3.endpoint)}`;
+    }
+    lines.push(upload);
+  }
+
+  lines.push(
+    `ls -1t "$BACKUP_DIR"/${filePrefix}-*.gz | tail -n +${
+      backup.retention + 1
+    } | xargs -r rm -f`,
+    'echo "$FILE"'
+  );
+
+  return lines.join("\n") + "\n";
+}
+
+/**
+ * Builds the crontab line that runs the backup script on schedule
+ */
+export function buildBackupCronLine(
+  service: ServiceEntry,
+  projectName: string
+): string {
+  const schedule = service.backup?.schedule || "0 3 * * *";
+  const scriptPath = getBackupScriptPath(projectName, service.name);
+  const logPath = `${getBackupDirectory(projectName, service.name)}/backup.log`;
+  return `${schedule} sh ${scriptPath} >> ${logPath} 2>&1 ${getBackupCronMarker(
+    projectName,
+    service.name
+  )}`;
+}
+
+/**
+ * Builds the command that restores a backup file into a running templated service
+ */
+export function buildRestoreCommand(
+  service: ServiceEntry,
+  projectName: string,
+  backupFile: string
+): string {
+  const template = getServiceTemplate(service);
+  if (!template) {
+    throw new Error(`Service ${service.name} has no template to restore into`);
+  }
+
+  const containerName = `${projectName}-${service.name}`;
+
+  if (template.restoreCommand) {
+    return `gunzip -c ${backupFile} | docker exec -i ${containerName} sh -c ${shellQuote(
+      template.restoreCommand
+    )}`;
+  }
+
+  // Snapshot-based restore (redis): replace the data file while stopped
+  const restoredFile = `/tmp/iop-restore-${sanitizeFolderName(service.name)}.${template.dumpExtension}`;
+  return [
+    `gunzip -c ${backupFile} > ${restoredFile}`,
+    `docker stop ${containerName}`,
+    `docker cp ${restoredFile} ${containerName}:${template.dataPath}/dump.${template.dumpExtension}`,
+    `docker start ${containerName}`,
+    `rm -f ${restoredFile}`,
+  ].join(" && ");
+}
+
+/**
+ * Installs or refreshes the backup script and cron entry for a service on its server
+ */
+export async function installBackupSchedule(
+  sshClient: SSHClient,
+  service: ServiceEntry,
+  projectName: string,
+  secrets: IopSecrets
+): Promise<void> {
+  const script = buildBackupScript(service, projectName, secrets);
+  const scriptPath = getBackupScriptPath(projectName, service.name);
+  const marker = getBackupCronMarker(projectName, service.name);
+
+  await sshClient.exec(
+    `mkdir -p ${getBackupDirectory(projectName, service.name)}`
+  );
+  await sshClient.exec(
+    `cat > ${scriptPath} <<'IOP_BACKUP_EOF'\n${script}IOP_BACKUP_EOF`
+  );
+  await sshClient.exec(`chmod 700 ${scriptPath}`);
+  await sshClient.exec(
+    `(crontab -l 2>/dev/null | grep -vF ${shellQuote(marker)}; echo ${shellQuote(
+      buildBackupCronLine(service, projectName)
+    )}) | crontab -`
+  );
+}
+
+/**
+ * Removes the backup cron entry for a service, leaving existing backups in place
+ */
+export async function removeBackupSchedule(
+  sshClient: SSHClient,
+  projectName: string,
+  serviceName: string
+): Promise<void> {
+  const marker = getBackupCronMarker(projectName, serviceName);
+  await sshClient.exec(
+    `crontab -l 2>/dev/null | grep -vF ${shellQuote(marker)} | crontab - || true`
+  );
+}
+
+/**
+ * Lists backup files for a service on the server, newest first
+ */
+export async function listBackups(
+  sshClient: SSHClient,
+  projectName: string,
+  serviceName: string
+): Promise<string[]> {
+  const backupDir = getBackupDirectory(projectName, serviceName);
+  const output = await sshClient.exec(
+    `ls -1t ${backupDir}/${sanitizeFolderName(serviceName)}-*.gz 2>/dev/null || true`
+  );
+  return output
+    .trim()
+    .split("\n")
+    .filter((line) => line.length > 0);
+}
@@ -0,0 +1,184 @@
+import { randomUUID } from "crypto";
+import os from "os";
+import { SSHClient } from "../ssh";
+import { sanitizeFolderName } from "./index";
+import { shellQuote } from "./shell";
+
+/**
+ * How long a deployment lock is honored. A lock older than this is assumed to
+ * belong to a deploy that crashed or lost its connection and is taken over.
+ */
+export const DEPLOY_LOCK_TTL_MS = 30 * 60 * 1000;
+
+/**
+ * The contents of a project's deployment lock file
+ */
+export interface DeployLock {
+  id: string; // Unique per deploy, release IDs repeat when a commit is deployed twice
+  owner: string;
+  releaseId: string;
+  acquiredAt: string;
+  expiresAt: string;
+}
+
+/**
+ * Thrown when another deployment holds the project's lock
+ */
+export class DeployLockedError extends Error {
+  constructor(public lock: DeployLock, server: string, now: Date = new Date()) {
+    super(
+      `Deployment in progress by ${describeDeployLock(lock, now)} on ${server}. ` +
+        `Wait for it to finish, or run 'iop deploy --force-unlock' if it is stuck.`
+    );
+    this.name = "DeployLockedError";
+  }
+}
+
+/**
+ * Returns the path of a project's deployment lock on the server
+ */
+export function getDeployLockPath(projectName: string): string {
+  return `~/.iop/projects/${sanitizeFolderName(projectName)}/deploy.lock`;
+}
+
+/**
+ * Identifies who is deploying. IOP_DEPLOY_OWNER wins, CI jobs on GitHub
+ * Actions report the actor and run, everyone else is user@host.
+ */
+export function getDeployLockOwner(
+  env: Record<string, string | undefined> = process.env
+): string {
+  if (env.IOP_DEPLOY_OWNER) {
+    return env.IOP_DEPLOY_OWNER;
+  }
+  if (env.GITHUB_ACTIONS === "true" && env.GITHUB_ACTOR) {
+    return `${env.GITHUB_ACTOR} (GitHub Actions run ${env.GITHUB_RUN_ID})`;
+  }
+
+  let username = env.USER || env.USERNAME || "unknown";
+  try {
+    username = os.userInfo().username;
+  } catch {
+    // Fall back to the environment when there is no passwd entry
+  }
+  return `${username}@${os.hostname()}`;
+}
+
+/**
+ * Creates the lock contents for a deployment starting now
+ */
+export function createDeployLock(
+  owner: string,
+  releaseId: string,
+  now: Date = new Date(),
+  ttlMs: number = DEPLOY_LOCK_TTL_MS
+): DeployLock {
+  return {
+    id: randomUUID(),
+    owner,
+    releaseId,
+    acquiredAt: now.toISOString(),
+    expiresAt: new Date(now.getTime() + ttlMs).toISOString(),
+  };
+}
+
+/**
+ * Whether a lock has outlived its TTL. Unreadable locks count as expired.
+ */
+export function isDeployLockExpired(lock: DeployLock, now: Date = new Date()): boolean {
+  const expiresAt = Date.parse(lock.expiresAt);
+  return isNaN(expiresAt) || expiresAt <= now.getTime();
+}
+
+/**
+ * Describes who holds a lock and for how long, e.g. "alice@laptop (release 1a2b3c4, started 3m ago)"
+ */
+export function describeDeployLock(lock: DeployLock, now: Date = new Date()): string {
+  const startedMs = now.getTime() - Date.parse(lock.acquiredAt);
+  const minutes = Math.max(0, Math.floor(startedMs / 60000));
+  const started = isNaN(startedMs)
+    ? "at an unknown time"
+    : minutes === 0
+    ? "just now"
+    : `${minutes}m ago`;
+  return `${lock.owner} (release ${lock.releaseId}, started ${started})`;
+}
+
+/**
+ * Parses a lock file, returning null if it isn't a lock
+ */
+export function parseDeployLock(contents: string): DeployLock | null {
+  try {
+    const lock = JSON.parse(contents);
+    return lock && typeof lock.owner === "string" ? lock : null;
+  } catch {
+    return null;
+  }
+}
+
+/**
+ * Reads the lock currently held on a server, if any
+ */
+export async function readDeployLock(
+  sshClient: SSHClient,
+  projectName: string
+): Promise<DeployLock | null> {
+  const contents = await sshClient.exec(
+    `cat ${getDeployLockPath(projectName)} 2>/dev/null || true`
+  );
+  return contents.trim() ? parseDeployLock(contents.trim()) : null;
+}
+
+/**
+ * Takes the project's deployment lock on a server. The lock file is created
+ * with noclobber so two deploys racing for it can't both win. Expired locks
+ * are taken over, and force breaks any lock.
+ * @throws DeployLockedError if another deployment holds the lock
+ */
+export async function acquireDeployLock(
+  sshClient: SSHClient,
+  server: string,
+  projectName: string,
+  lock: DeployLock,
+  force: boolean = false
+): Promise<void> {
+  const lockPath = getDeployLockPath(projectName);
+  const createLock = `mkdir -p $(dirname ${lockPath}) && (set -C; printf '%s' ${shellQuote(
+    JSON.stringify(lock)
+  )} > ${lockPath}) 2>/dev/null && echo acquired || true`;
+
+  for (let attempt = 0; attempt < 2; attempt++) {
+    if ((await sshClient.exec(createLock)).trim() === "acquired") {
+      return;
+    }
+
+    const existing = await readDeployLock(sshClient, projectName);
+    if (existing && !force && !isDeployLockExpired(existing)) {
+      throw new DeployLockedError(existing, server);
+    }
+
+    // Stale, unreadable or forcibly broken, remove it and try once more
+    await sshClient.exec(`rm -f ${lockPath}`);
+  }
+
+  const holder = await readDeployLock(sshClient, projectName);
+  if (holder) {
+    throw new DeployLockedError(holder, server);
+  }
+  throw new Error(`Could not create deployment lock ${lockPath} on ${server}`);
+}
+
+/**
+ * Releases the lock on a server if it is still ours. A lock that was broken
+ * and taken by another deployment is left alone.
+ */
+export async function releaseDeployLock(
+  sshClient: SSHClient,
+  projectName: string,
+  lock: DeployLock
+): Promise<void> {
+  const existing = await readDeployLock(sshClient, projectName);
+  if (existing && existing.id === lock.id) {
+    await sshClient.exec(`rm -f ${getDeployLockPath(projectName)}`);
+  }
+}
@@ -0,0 +1,157 @@
+import { exec } from "child_process";
+import { promisify } from "util";
+
+const execAsync = promisify(exec);
+
+/**
+ * Metadata attached to a deployment, e.g. { sha, branch, actor, ci_url }
+ */
+export type DeployMetadata = Record<string, string>;
+
+// Keys the proxy accepts, so they also work as container label suffixes
+const METADATA_KEY_PATTERN = /^[a-z0-9][a-z0-9_.-]*$/;
+
+// Containers carry their deployment's metadata as iop.deploy.<key> labels
+export const DEPLOY_METADATA_LABEL_PREFIX = "iop.deploy.";
+
+/**
+ * Parses an --annotate value, e.g. "ticket=OPS-42"
+ */
+export function parseAnnotation(annotation: string): [string, string] {
+  const separator = annotation.indexOf("=");
+  const key = separator === -1 ? "" : annotation.substring(0, separator);
+  if (!METADATA_KEY_PATTERN.test(key)) {
+    throw new Error(
+      `Invalid annotation "${annotation}", expected key=value with a lowercase key, e.g. ticket=OPS-42`
+    );
+  }
+  return [key, annotation.substring(separator + 1)];
+}
+
+/**
+ * Reads what the CI system knows about the run deploying, for GitHub Actions
+ * and GitLab CI. Outside CI this is empty.
+ */
+export function detectCiMetadata(
+  env: NodeJS.ProcessEnv = process.env
+): DeployMetadata {
+  const metadata: DeployMetadata = {};
+  const set = (key: string, value: string | undefined) => {
+    if (value) {
+      metadata[key] = value;
+    }
+  };
+
+  if (env.GITHUB_ACTIONS === "true") {
+    set("sha", env.GITHUB_SHA);
+    set("branch", env.GITHUB_HEAD_REF || env.GITHUB_REF_NAME);
+    set("actor", env.GITHUB_ACTOR);
+    if (env.GITHUB_REPOSITORY && env.GITHUB_RUN_ID) {
+      set(
+        "ci_url",
+        `${env.GITHUB_SERVER_URL || "https://github.com"}/${env.GITHUB_REPOSITORY}/actions/runs/${env.GITHUB_RUN_ID}`
+      );
+    }
+  } else if (env.GITLAB_CI === "true") {
+    set("sha", env.CI_COMMIT_SHA);
+    set("branch", env.CI_COMMIT_REF_NAME);
+    set("actor", env.GITLAB_USER_LOGIN);
+    set("ci_url", env.CI_JOB_URL);
+  }
+
+  return metadata;
+}
+
+/**
+ * Runs a git command, returning undefined outside a repository
+ */
+async function git(command: string): Promise<string | undefined> {
+  try {
+    const { stdout } = await execAsync(`git ${command}`);
+    return stdout.trim() || undefined;
+  } catch {
+    return undefined;
+  }
+}
+
+/**
+ * Collects the metadata of a deployment: what CI reports, the local git
+ * commit, branch and user for anything CI didn't, and --annotate values on top
+ */
+export async function collectDeployMetadata(
+  annotations: string[],
+  env: NodeJS.ProcessEnv = process.env
+): Promise<DeployMetadata> {
+  const metadata = detectCiMetadata(env);
+
+  if (!metadata.sha) {
+    const sha = await git("rev-parse HEAD");
+    if (sha) {
+      metadata.sha = sha;
+    }
+  }
+  if (!metadata.branch) {
+    const branch = await git("rev-parse --abbrev-ref HEAD");
+    if (branch && branch !== "HEAD") {
+      metadata.branch = branch;
+    }
+  }
+  if (!metadata.actor) {
+    const actor = (await git("config user.name")) || env.USER;
+    if (actor) {
+      metadata.actor = actor;
+    }
+  }
+
+  for (const annotation of annotations) {
+    const [key, value] = parseAnnotation(annotation);
+    metadata[key] = value;
+  }
+
+  return metadata;
+}
+
+/**
+ * Turns metadata into the labels put on the containers of a deployment
+ */
+export function getDeployMetadataLabels(
+  metadata: DeployMetadata
+): Record<string, string> {
+  const labels: Record<string, string> = {};
+  for (const [key, value] of Object.entries(metadata)) {
+    labels[`${DEPLOY_METADATA_LABEL_PREFIX}${key}`] = value;
+  }
+  return labels;
+}
+
+/**
+ * Reads the metadata back from a container's labels
+ */
+export function readDeployMetadataLabels(
+  labels: Record<string, string>
+): DeployMetadata {
+  const metadata: DeployMetadata = {};
+  for (const [label, value] of Object.entries(labels)) {
+    if (label.startsWith(DEPLOY_METADATA_LABEL_PREFIX)) {
+      metadata[label.substring(DEPLOY_METADATA_LABEL_PREFIX.length)] = value;
+    }
+  }
+  return metadata;
+}
+
+/**
+ * Summarizes metadata on one line, e.g. "4f2a9c1 (main) by alice"
+ */
+export function formatDeployMetadata(metadata: DeployMetadata): string {
+  const parts: string[] = [];
+  if (metadata.sha) {
+    parts.push(metadata.sha.substring(0, 7));
+  }
+  if (metadata.branch) {
+    parts.push(`(${metadata.branch})`);
+  }
+  if (metadata.actor) {
+    parts.push(`by ${metadata.actor}`);
+  }
+  return parts.join(" ");
+}
@@ -0,0 +1,125 @@
+import { IopConfig, ServiceEntry } from "../config/types";
+import { getDeploymentStrategy } from "./service-utils";
+
+export const DEFAULT_DEPLOY_PARALLELISM = 4;
+
+/**
+ * Gets how many app deployments may run at once, from --parallel or the
+ * deploy.parallelism config option
+ */
+export function getDeployParallelism(config: IopConfig, flag?: number): number {
+  return flag ?? config.deploy?.parallelism ?? DEFAULT_DEPLOY_PARALLELISM;
+}
+
+/**
+ * Orders the services of one server into deployment waves. Stop-start
+ * services such as databases deploy first, one at a time, since apps may
+ * depend on them. The apps then deploy in groups: apps sharing a proxy host
+ * stay in one group and deploy in order, while separate groups are
+ * independent and may deploy concurrently.
+ */
+export function planDeploymentGroups(services: ServiceEntry[]): {
+  sequential: ServiceEntry[];
+  groups: ServiceEntry[][];
+} {
+  const sequential: ServiceEntry[] = [];
+  const groups: ServiceEntry[][] = [];
+  const groupByHost = new Map<string, ServiceEntry[]>();
+
+  for (const service of services) {
+    if (getDeploymentStrategy(service) !== "zero-downtime") {
+      sequential.push(service);
+      continue;
+    }
+
+    const hosts = service.proxy?.hosts ?? [];
+    const overlapping = new Set<ServiceEntry[]>();
+    for (const host of hosts) {
+      const group = groupByHost.get(host);
+      if (group) {
+        overlapping.add(group);
+      }
+    }
+
+    // Merge every group this app shares a host with, keeping config order
+    let group: ServiceEntry[] = [];
+    if (overlapping.size > 0) {
+      const merged = groups.filter((candidate) => overlapping.has(candidate));
+      group = merged.flat();
+      group.sort((a, b) => services.indexOf(a) - services.indexOf(b));
+      groups.splice(groups.indexOf(merged[0]), 1, group);
+      for (const stale of merged.slice(1)) {
+        groups.splice(groups.indexOf(stale), 1);
+      }
+      for (const member of group) {
+        for (const host of member.proxy?.hosts ?? []) {
+          groupByHost.set(host, group);
+        }
+      }
+    } else {
+      groups.push(group);
+    }
+
+    group.push(service);
+    for (const host of hosts) {
+      groupByHost.set(host, group);
+    }
+  }
+
+  return { sequential, groups };
+}
+
+/**
+ * Bounds how many tasks run at once. One limiter is shared by every server
+ * of a deploy, so the limit holds for the deploy as a whole.
+ */
+export class ConcurrencyLimiter {
+  private active = 0;
+  private waiting: (() => void)[] = [];
+
+  constructor(readonly limit: number) {
+    if (!Number.isInteger(limit) || limit < 1) {
+      throw new Error(`Parallelism must be a positive integer, got ${limit}`);
+    }
+  }
+
+  async run<T>(task: () => Promise<T>): Promise<T> {
+    if (this.active >= this.limit) {
+      // A finishing task hands its slot straight to the next waiting one
+      await new Promise<void>((resolve) => this.waiting.push(resolve));
+    } else {
+      this.active++;
+    }
+    try {
+      return await task();
+    } finally {
+      const next = this.waiting.shift();
+      if (next) {
+        next();
+      } else {
+        this.active--;
+      }
+    }
+  }
+}
+
+export interface DeploymentFailure {
+  serviceName: string;
+  server: string;
+  error: string;
+}
+
+/**
+ * Combines the failures of concurrent deployments into one error
+ */
+export function combineDeploymentFailures(
+  failures: DeploymentFailure[],
+  total: number
+): Error {
+  const details = failures
+    .map((failure) => `${failure.serviceName} on ${failure.server}: ${failure.error}`)
+    .join("; ");
+  return new Error(
+    `${failures.length} of ${total} service deployment${total === 1 ? "" : "s"} failed: ${details}`
+  );
+}
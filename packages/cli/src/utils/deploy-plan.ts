@@ -0,0 +1,124 @@
+import { ProxyHostInfo } from "../proxy";
+
+export type PlanChange = "create" | "update" | "delete" | "unchanged";
+
+export type PlanActionKind =
+  | "build"
+  | "pull"
+  | "network"
+  | "proxy"
+  | "volume"
+  | "service"
+  | "host"
+  | "certificate"
+  | "dns";
+
+export interface PlanAction {
+  change: PlanChange;
+  kind: PlanActionKind;
+  target: string; // Service, host, volume or network the action applies to
+  server?: string; // Unset for actions that run locally
+  detail: string;
+}
+
+export interface DeployPlan {
+  project: string;
+  releaseId: string;
+  actions: PlanAction[];
+}
+
+const CHANGE_SYMBOLS: Record<PlanChange, string> = {
+  create: "+",
+  update: "~",
+  delete: "-",
+  unchanged: "=",
+};
+
+/**
+ * Works out the proxy route and certificate actions for a service's hosts,
+ * given the hosts the server's proxy already routes
+ */
+export function planHostActions(
+  hosts: string[],
+  target: string,
+  ssl: boolean,
+  server: string,
+  proxyHosts: Record<string, ProxyHostInfo>
+): PlanAction[] {
+  const actions: PlanAction[] = [];
+
+  for (const host of hosts) {
+    const existing = proxyHosts[host];
+    if (!existing) {
+      actions.push({ change: "create", kind: "host", target: host, server, detail: `route to ${target}` });
+    } else if (existing.target !== target) {
+      actions.push({
+        change: "update",
+        kind: "host",
+        target: host,
+        server,
+        detail: `reroute from ${existing.target} to ${target}`,
+      });
+    } else {
+      actions.push({ change: "unchanged", kind: "host", target: host, server, detail: `routes to ${target}` });
+    }
+
+    // Wildcard hosts are served over HTTP, the proxy can't issue certificates for them
+    if (ssl && !host.startsWith("*.") && existing?.certificate?.status !== "active") {
+      actions.push({
+        change: "create",
+        kind: "certificate",
+        target: host,
+        server,
+        detail: "request Let's Encrypt certificate",
+      });
+    }
+  }
+
+  return actions;
+}
+
+/**
+ * Counts the plan's actions by change type, ignoring unchanged ones
+ */
+export function summarizePlan(plan: DeployPlan): Record<Exclude<PlanChange, "unchanged">, number> {
+  const summary = { create: 0, update: 0, delete: 0 };
+  for (const action of plan.actions) {
+    if (action.change !== "unchanged") {
+      summary[action.change]++;
+    }
+  }
+  return summary;
+}
+
+/**
+ * Renders a plan grouped by where each action runs, terraform style
+ */
+export function formatPlan(plan: DeployPlan): string[] {
+  const lines = [`Plan for ${plan.project} (release ${plan.releaseId})`];
+
+  const groups = new Map<string, PlanAction[]>();
+  for (const action of plan.actions) {
+    const group = action.server || "local";
+    groups.set(group, [...(groups.get(group) || []), action]);
+  }
+
+  const width = Math.max(0, ...plan.actions.map((action) => `${action.kind} ${action.target}`.length));
+  for (const [group, actions] of groups) {
+    lines.push("", `  ${group}`);
+    for (const action of actions) {
+      const label = `${action.kind} ${action.target}`.padEnd(width);
+      lines.push(`    ${CHANGE_SYMBOLS[action.change]} ${label}  ${action.detail}`);
+    }
+  }
+
+  const summary = summarizePlan(plan);
+  lines.push(
+    "",
+    summary.create + summary.update + summary.delete === 0
+      ? "No changes. Everything is up to date."
+      : `Plan: ${summary.create} to create, ${summary.update} to change, ${summary.delete} to remove.`
+  );
+
+  return lines;
+}
@@ -0,0 +1,121 @@
+import {
+  IopProxyClient,
+  ProxyDeploymentStep,
+  ProxyDeploymentStepUpdate,
+} from "../proxy";
+import { DeployMetadata } from "./deploy-metadata";
+
+/**
+ * The part of the proxy client a timeline reports through
+ */
+export type TimelineProxyClient = Pick<
+  IopProxyClient,
+  "startDeploymentTimeline" | "updateDeploymentStep"
+>;
+
+/**
+ * Reports the steps of one app deployment to the proxy, which serves them at
+ * GET /api/deployments/:id. Reporting is best effort: a proxy that can't
+ * record progress never fails the deployment.
+ */
+export class DeploymentTimeline {
+  private current?: ProxyDeploymentStep;
+  private failed?: ProxyDeploymentStep;
+
+  constructor(
+    private proxyClient: TimelineProxyClient,
+    readonly id: string
+  ) {}
+
+  /**
+   * Starts the timeline of an app deployment, undefined if the proxy can't
+   * track it
+   */
+  static async start(
+    proxyClient: TimelineProxyClient,
+    projectName: string,
+    appName: string,
+    host?: string,
+    metadata: DeployMetadata = {}
+  ): Promise<DeploymentTimeline | undefined> {
+    const id = await proxyClient.startDeploymentTimeline(
+      projectName,
+      appName,
+      host,
+      metadata
+    );
+    return id ? new DeploymentTimeline(proxyClient, id) : undefined;
+  }
+
+  /**
+   * Reports a step's status, remembering the running step for fail()
+   */
+  async step(
+    step: ProxyDeploymentStep,
+    status: NonNullable<ProxyDeploymentStepUpdate["status"]>,
+    details: { message?: string; error?: string } = {}
+  ): Promise<void> {
+    if (status === "running") {
+      this.current = step;
+    } else if (this.current === step) {
+      this.current = undefined;
+    }
+    await this.proxyClient.updateDeploymentStep(this.id, {
+      step,
+      status,
+      ...details,
+    });
+  }
+
+  /**
+   * Records one try of a step that retries, e.g. a health check's status code
+   */
+  async attempt(
+    step: ProxyDeploymentStep,
+    result?: string,
+    error?: string
+  ): Promise<void> {
+    await this.proxyClient.updateDeploymentStep(this.id, {
+      step,
+      attempt: { result, error },
+    });
+  }
+
+  /**
+   * Runs a step, reporting it as running and then as succeeded or failed
+   */
+  async run<T>(step: ProxyDeploymentStep, fn: () => Promise<T>): Promise<T> {
+    await this.step(step, "running");
+    try {
+      const result = await fn();
+      await this.step(step, "succeeded");
+      return result;
+    } catch (error) {
+      await this.fail(error instanceof Error ? error.message : String(error));
+      throw error;
+    }
+  }
+
+  /**
+   * Marks the running step as failed, once
+   * @returns The step that failed, undefined if none was running
+   */
+  async fail(error: string): Promise<ProxyDeploymentStep | undefined> {
+    if (!this.failed && this.current) {
+      this.failed = this.current;
+      await this.step(this.failed, "failed", { error });
+    }
+    return this.failed;
+  }
+}
+
+/**
+ * Runs a step on a timeline, or just runs it when there is none
+ */
+export function runStep<T>(
+  timeline: DeploymentTimeline | undefined,
+  step: ProxyDeploymentStep,
+  fn: () => Promise<T>
+): Promise<T> {
+  return timeline ? timeline.run(step, fn) : fn();
+}
@@ -0,0 +1,164 @@
+import { exec } from "child_process";
+import { promisify } from "util";
+import { IopConfig, IopSecrets } from "../config/types";
+
+const execAsync = promisify(exec);
+
+const GITHUB_API = "https://api.github.com";
+const COMMIT_STATUS_CONTEXT = "iop/deploy";
+
+export type DeploymentState = "in_progress" | "success" | "failure";
+
+interface GitHubReporterOptions {
+  repository: string;
+  token: string;
+  environment: string;
+  commitStatus: boolean;
+  sha: string;
+}
+
+/**
+ * Extracts "owner/repo" from a GitHub remote URL in https or ssh form
+ */
+export function parseGitHubRepository(remoteUrl: string): string | null {
+  const match = remoteUrl
+    .trim()
+    .match(/github\.com[:/]([^/\s]+)\/([^/\s]+?)(?:\.git)?\/?$/);
+  return match ? `${match[1]}/${match[2]}` : null;
+}
+
+/**
+ * Maps a deployment state to the closest commit status state
+ */
+export function toCommitState(state: DeploymentState): "pending" | "success" | "failure" {
+  return state === "in_progress" ? "pending" : state;
+}
+
+/**
+ * Reports a deploy to the GitHub Deployments API so it shows up in the PR timeline.
+ * Reporting failures are logged and never fail the deploy itself.
+ */
+export class GitHubDeploymentReporter {
+  private options: GitHubReporterOptions;
+  private fetchFn: typeof fetch;
+  private deploymentId?: number;
+
+  constructor(options: GitHubReporterOptions, fetchFn: typeof fetch = fetch) {
+    this.options = options;
+    this.fetchFn = fetchFn;
+  }
+
+  private async request(method: string, path: string, body: unknown): Promise<any> {
+    const response = await this.fetchFn(
+      `${GITHUB_API}/repos/${this.options.repository}${path}`,
+      {
+        method,
+        headers: {
+          Authorization: `Bearer ${this.options.token}`,
+          Accept: "application/vnd.github+json",
+          "Content-Type": "application/json",
+          "X-GitHub-Api-Version": "2022-11-28",
+        },
+        body: JSON.stringify(body),
+      }
+    );
+    const data: any = await response.json();
+    if (!response.ok) {
+      throw new Error(
+        `GitHub API ${method} ${path} failed (${response.status}): ${data.message || "unknown error"}`
+      );
+    }
+    return data;
+  }
+
+  /**
+   * Creates the deployment and marks it in progress
+   */
+  async start(description: string): Promise<void> {
+    try {
+      const deployment = await this.request("POST", "/deployments", {
+        ref: this.options.sha,
+        environment: this.options.environment,
+        description,
+        auto_merge: false,
+        required_contexts: [], // CI is what triggers the deploy, so don't wait on it
+      });
+      this.deploymentId = deployment.id;
+      await this.update("in_progress", description);
+    } catch (error) {
+      console.warn(`[!] Could not report deployment to GitHub: ${error}`);
+    }
+  }
+
+  /**
+   * Sets the state of the deployment and, if enabled, the commit status
+   */
+  async update(
+    state: DeploymentState,
+    description: string,
+    environmentUrl?: string
+  ): Promise<void> {
+    try {
+      if (this.deploymentId !== undefined) {
+        await this.request("POST", `/deployments/${this.deploymentId}/statuses`, {
+          state,
+          description: description.substring(0, 140),
+          environment_url: environmentUrl,
+          auto_inactive: state === "success",
+        });
+      }
+
+      if (this.options.commitStatus) {
+        await this.request("POST", `/statuses/${this.options.sha}`, {
+          state: toCommitState(state),
+          context: COMMIT_STATUS_CONTEXT,
+          description: description.substring(0, 140),
+          target_url: environmentUrl,
+        });
+      }
+    } catch (error) {
+      console.warn(`[!] Could not update GitHub deployment status: ${error}`);
+    }
+  }
+}
+
+/**
+ * Creates a reporter from the github section, or returns undefined when it isn't configured
+ */
+export async function createGitHubReporter(
+  config: IopConfig,
+  secrets: IopSecrets
+): Promise<GitHubDeploymentReporter | undefined> {
+  if (!config.github) {
+    return undefined;
+  }
+
+  const token = secrets[config.github.token_secret] || process.env.GITHUB_TOKEN;
+  if (!token) {
+    throw new Error(
+      `GitHub token "${config.github.token_secret}" not found in secrets`
+    );
+  }
+
+  let repository = config.github.repository || process.env.GITHUB_REPOSITORY;
+  if (!repository) {
+    const { stdout } = await execAsync("git remote get-url origin");
+    repository = parseGitHubRepository(stdout) || undefined;
+  }
+  if (!repository) {
+    throw new Error(
+      "Could not determine the GitHub repository. Set github.repository in iop.yml."
+    );
+  }
+
+  const sha =
+    process.env.GITHUB_SHA || (await execAsync("git rev-parse HEAD")).stdout.trim();
+
+  return new GitHubDeploymentReporter({
+    repository,
+    token,
+    environment: config.github.environment,
+    commitStatus: config.github.commit_status,
+    sha,
+  });
+}
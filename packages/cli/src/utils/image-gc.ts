@@ -0,0 +1,174 @@
+import { IopConfig, ServiceEntry } from "../config/types";
+import { DockerClient, DockerImageInfo } from "../docker";
+import { getServiceImageName } from "./image-utils";
+
+export const DEFAULT_IMAGE_RETENTION = 3;
+
+export interface GcPlan {
+  containers: string[]; // Stopped leftover containers to remove
+  images: DockerImageInfo[]; // Images to remove
+  retained: DockerImageInfo[]; // Project images kept (in use or within retention)
+}
+
+export interface GcResult {
+  removedContainers: number;
+  removedImages: number;
+  failed: string[];
+}
+
+/**
+ * Strips the tag and digest from an image reference
+ * e.g. "ghcr.io/acme/web:1.0" -> "ghcr.io/acme/web", "localhost:5000/web" -> "localhost:5000/web"
+ */
+export function getImageRepository(image: string): string {
+  const withoutDigest = image.split("@")[0];
+  const lastSlash = withoutDigest.lastIndexOf("/");
+  const lastColon = withoutDigest.lastIndexOf(":");
+  return lastColon > lastSlash
+    ? withoutDigest.substring(0, lastColon)
+    : withoutDigest;
+}
+
+/**
+ * Returns how many previous releases to keep per image
+ */
+export function getImageRetention(config: IopConfig): number {
+  return config.gc?.retain ?? DEFAULT_IMAGE_RETENTION;
+}
+
+/**
+ * Converts docker's "2025-01-02 03:04:05 +0000 UTC" timestamps to epoch millis
+ */
+function parseDockerTimestamp(createdAt: string): number {
+  const [date, time, offset] = createdAt.split(" ");
+  const parsed = Date.parse(
+    `${date}T${time}${offset ? `${offset.slice(0, 3)}:${offset.slice(3)}` : "Z"}`
+  );
+  return isNaN(parsed) ? 0 : parsed;
+}
+
+/**
+ * Decides which images to remove. Images used by any container are always kept.
+ * For each project repository the newest `retain` unused tags are kept for
+ * rollbacks, and "latest" is never touched. Dangling images are removed too.
+ */
+export function selectImagesToPrune(
+  images: DockerImageInfo[],
+  repositories: string[],
+  inUseImageIds: Set<string>,
+  retain: number
+): { remove: DockerImageInfo[]; keep: DockerImageInfo[] } {
+  const remove: DockerImageInfo[] = [];
+  const keep: DockerImageInfo[] = [];
+
+  for (const repository of new Set(repositories)) {
+    const candidates = images
+      .filter(
+        (image) => image.repository === repository && image.tag !== "latest"
+      )
+      .sort(
+        (a, b) =>
+          parseDockerTimestamp(b.createdAt) - parseDockerTimestamp(a.createdAt)
+      );
+
+    let retainedUnused = 0;
+    for (const image of candidates) {
+      if (inUseImageIds.has(image.id)) {
+        keep.push(image);
+      } else if (retainedUnused < retain) {
+        keep.push(image);
+        retainedUnused++;
+      } else {
+        remove.push(image);
+      }
+    }
+  }
+
+  for (const image of images) {
+    if (image.repository === "<none>" && !inUseImageIds.has(image.id)) {
+      remove.push(image);
+    }
+  }
+
+  return { remove, keep };
+}
+
+/**
+ * Works out what garbage collection would remove on one server
+ */
+export async function planServerGc(
+  dockerClient: DockerClient,
+  projectName: string,
+  services: ServiceEntry[],
+  retain: number
+): Promise<GcPlan> {
+  // Blue-green containers of the inactive color are normally removed after a
+  // switch; anything still lying around stopped is a leftover
+  const containers = await dockerClient.findStoppedContainersByLabelAndProject(
+    "iop.color",
+    projectName
+  );
+
+  const containerImages = await dockerClient.getContainerImageIds();
+  const inUseImageIds = new Set(
+    Object.entries(containerImages)
+      .filter(([name]) => !containers.includes(name))
+      .map(([, imageId]) => imageId)
+  );
+
+  const repositories = services.map((service) =>
+    getImageRepository(getServiceImageName(service))
+  );
+  const images = await dockerClient.listImages();
+  const { remove, keep } = selectImagesToPrune(
+    images,
+    repositories,
+    inUseImageIds,
+    retain
+  );
+
+  return { containers, images: remove, retained: keep };
+}
+
+/**
+ * Removes the containers and images in a plan. Containers go first so the
+ * images they reference become removable.
+ */
+export async function executeGcPlan(
+  dockerClient: DockerClient,
+  plan: GcPlan
+): Promise<GcResult> {
+  const result: GcResult = { removedContainers: 0, removedImages: 0, failed: [] };
+
+  for (const container of plan.containers) {
+    if (await dockerClient.removeContainer(container)) {
+      result.removedContainers++;
+    } else {
+      result.failed.push(container);
+    }
+  }
+
+  for (const image of plan.images) {
+    // Dangling images have no tag to remove by
+    const reference =
+      image.repository === "<none>" ? image.id : `${image.repository}:${image.tag}`;
+    if (await dockerClient.removeImage(reference)) {
+      result.removedImages++;
+    } else {
+      result.failed.push(reference);
+    }
+  }
+
+  return result;
+}
+
+/**
+ * Formats an image for display, e.g. "web:a1b2c3d (120MB)"
+ */
+export function formatImage(image: DockerImageInfo): string {
+  const name =
+    image.repository === "<none>"
+      ? `<dangling> ${image.id.replace(/^sha256:/, "").substring(0, 12)}`
+      : `${image.repository}:${image.tag}`;
+  return `${name} (${image.size})`;
+}
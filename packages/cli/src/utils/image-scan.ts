@@ -0,0 +1,283 @@
+import type { DockerClient } from "../docker";
+import {
+  ImageScanConfig,
+  IopConfig,
+  ScanSeverity,
+  ScanSeveritySchema,
+  ServiceEntry,
+} from "../config/types";
+
+// Run on the server against its Docker daemon, so nothing has to be installed
+export const TRIVY_IMAGE = "aquasec/trivy:0.57.1";
+
+// Keeps trivy's vulnerability database between scans instead of downloading it each time
+const TRIVY_CACHE_VOLUME = "iop-trivy-cache";
+
+// Scan reports on the server, by scanner and image digest
+export const SCAN_CACHE_DIR = "~/.iop/scans";
+
+// A digest is scanned again after a day, as the scanner's database keeps learning
+// of new vulnerabilities
+export const SCAN_CACHE_TTL_MS = 24 * 60 * 60 * 1000;
+
+// Containers carry their image's vulnerability counts as iop.scan.<severity> labels
+export const SCAN_LABEL_PREFIX = "iop.scan.";
+
+const SEVERITIES = ScanSeveritySchema.options;
+
+/**
+ * A vulnerability found in a package of an image
+ */
+export interface Vulnerability {
+  id: string; // e.g. CVE-2023-44487
+  package: string;
+  installedVersion: string;
+  fixedVersion?: string;
+  severity: ScanSeverity;
+  title?: string;
+}
+
+/**
+ * What a scanner found in an image, as cached on the server
+ */
+export interface ImageScanReport {
+  scanner: string;
+  digest: string;
+  scannedAt: string;
+  vulnerabilities: Vulnerability[];
+}
+
+/**
+ * Number of vulnerabilities by severity
+ */
+export type ScanCounts = Record<ScanSeverity, number>;
+
+/**
+ * A scan judged against the scan settings of a service
+ */
+export interface ImageScanResult {
+  report: ImageScanReport;
+  cached: boolean; // Reused from an earlier scan of the same digest
+  counts: ScanCounts; // Vulnerabilities that weren't ignored
+  blocking: Vulnerability[]; // Those at or above block_on
+}
+
+/**
+ * Finds vulnerabilities in an image on a server
+ */
+export interface ImageScanner {
+  name: string;
+  scan(dockerClient: DockerClient, image: string): Promise<Vulnerability[]>;
+}
+
+/**
+ * Reads trivy's JSON report
+ */
+export function parseTrivyReport(output: string): Vulnerability[] {
+  let report: any;
+  try {
+    report = JSON.parse(output);
+  } catch {
+    throw new Error(`Could not read trivy's report: ${output.substring(0, 200)}`);
+  }
+
+  const seen = new Set<string>();
+  const vulnerabilities: Vulnerability[] = [];
+  for (const result of report?.Results || []) {
+    for (const vulnerability of result.Vulnerabilities || []) {
+      // The same package can be reported for several targets of an image
+      const key = `${vulnerability.VulnerabilityID}/${vulnerability.PkgName}/${vulnerability.InstalledVersion}`;
+      if (seen.has(key)) {
+        continue;
+      }
+      seen.add(key);
+
+      const severity = SEVERITIES.includes(vulnerability.Severity)
+        ? vulnerability.Severity
+        : "UNKNOWN";
+      vulnerabilities.push({
+        id: vulnerability.VulnerabilityID,
+        package: vulnerability.PkgName,
+        installedVersion: vulnerability.InstalledVersion,
+        fixedVersion: vulnerability.FixedVersion || undefined,
+        severity,
+        title: vulnerability.Title || undefined,
+      });
+    }
+  }
+  return vulnerabilities;
+}
+
+const trivyScanner: ImageScanner = {
+  name: "trivy",
+  scan: async (dockerClient, image) =>
+    parseTrivyReport(
+      await dockerClient.runImageScanner(
+        TRIVY_IMAGE,
+        ["image", "--quiet", "--format", "json", "--scanners", "vuln", image],
+        ["/var/run/docker.sock:/var/run/docker.sock:ro", `${TRIVY_CACHE_VOLUME}:/root/.cache/trivy`]
+      )
+    ),
+};
+
+const scanners = new Map<string, ImageScanner>([[trivyScanner.name, trivyScanner]]);
+
+/**
+ * Adds a scanner that scan.scanner can name, or replaces one
+ */
+export function registerImageScanner(scanner: ImageScanner): void {
+  scanners.set(scanner.name, scanner);
+}
+
+/**
+ * The scan settings of a service: its own, the project's, or none when it
+ * opts out with scan: false
+ */
+export function getImageScanConfig(
+  entry: Pick<ServiceEntry, "scan">,
+  config: Pick<IopConfig, "scan">
+): ImageScanConfig | undefined {
+  if (entry.scan === false) {
+    return undefined;
+  }
+  return entry.scan || config.scan;
+}
+
+/**
+ * Counts vulnerabilities by severity
+ */
+export function countBySeverity(vulnerabilities: Vulnerability[]): ScanCounts {
+  const counts = Object.fromEntries(SEVERITIES.map((severity) => [severity, 0])) as ScanCounts;
+  vulnerabilities.forEach((vulnerability) => counts[vulnerability.severity]++);
+  return counts;
+}
+
+/**
+ * Judges a scan report against scan settings, leaving out ignored and, if
+ * asked to, unfixed vulnerabilities
+ */
+export function evaluateImageScan(
+  report: ImageScanReport,
+  config: ImageScanConfig,
+  cached: boolean = false
+): ImageScanResult {
+  const ignored = new Set(config.ignore || []);
+  const counted = report.vulnerabilities.filter(
+    (vulnerability) =>
+      !ignored.has(vulnerability.id) && !(config.ignore_unfixed && !vulnerability.fixedVersion)
+  );
+
+  const threshold =
+    config.block_on === "NONE" ? SEVERITIES.length : SEVERITIES.indexOf(config.block_on);
+  return {
+    report,
+    cached,
+    counts: countBySeverity(counted),
+    blocking: counted.filter(
+      (vulnerability) => SEVERITIES.indexOf(vulnerability.severity) >= threshold
+    ),
+  };
+}
+
+/**
+ * Scans an image on a server, reusing a recent report for the same digest
+ */
+export async function scanImage(
+  dockerClient: DockerClient,
+  image: string,
+  config: ImageScanConfig,
+  now: Date = new Date()
+): Promise<ImageScanResult> {
+  const scanner = scanners.get(config.scanner);
+  if (!scanner) {
+    throw new Error(
+      `Unknown scanner "${config.scanner}", expected one of ${Array.from(scanners.keys()).join(", ")}`
+    );
+  }
+
+  const digest = await dockerClient.getImageDigest(image);
+  if (!digest) {
+    throw new Error(`Could not read the digest of ${image} to scan it`);
+  }
+
+  // Both repo@sha256:<hex> and image IDs end in the hex digest
+  const cacheKey = `${scanner.name}-${digest.substring(digest.lastIndexOf(":") + 1)}`;
+  const cached = await dockerClient.readScanCache(cacheKey);
+  if (cached) {
+    try {
+      const report: ImageScanReport = JSON.parse(cached);
+      if (now.getTime() - new Date(report.scannedAt).getTime() < SCAN_CACHE_TTL_MS) {
+        return evaluateImageScan(report, config, true);
+      }
+    } catch {
+      // Scanned again below
+    }
+  }
+
+  const report: ImageScanReport = {
+    scanner: scanner.name,
+    digest,
+    scannedAt: now.toISOString(),
+    vulnerabilities: await scanner.scan(dockerClient, image),
+  };
+  await dockerClient.writeScanCache(cacheKey, JSON.stringify(report));
+  return evaluateImageScan(report, config);
+}
+
+/**
+ * Summarizes counts, most severe first, e.g. "2 critical, 5 high"
+ */
+export function formatScanCounts(counts: Partial<ScanCounts>): string {
+  const parts = [...SEVERITIES]
+    .reverse()
+    .filter((severity) => (counts[severity] || 0) > 0)
+    .map((severity) => `${counts[severity]} ${severity.toLowerCase()}`);
+  return parts.length > 0 ? parts.join(", ") : "no vulnerabilities";
+}
+
+/**
+ * Describes the vulnerabilities that failed a deploy, listing the first few
+ */
+export function formatBlockingVulnerabilities(
+  result: ImageScanResult,
+  config: ImageScanConfig,
+  limit: number = 5
+): string {
+  const listed = result.blocking.slice(0, limit).map((vulnerability) => {
+    const fix = vulnerability.fixedVersion ? `, fixed in ${vulnerability.fixedVersion}` : "";
+    return `${vulnerability.id} (${vulnerability.severity.toLowerCase()}, ${vulnerability.package} ${vulnerability.installedVersion}${fix})`;
+  });
+  const more = result.blocking.length - listed.length;
+  return `${result.blocking.length} vulnerabilit${result.blocking.length === 1 ? "y" : "ies"} at or above ${config.block_on}: ${listed.join(", ")}${more > 0 ? ` and ${more} more` : ""}`;
+}
+
+/**
+ * Turns counts into the labels put on the containers started from the image
+ */
+export function getScanLabels(counts?: Partial<ScanCounts>): Record<string, string> {
+  const labels: Record<string, string> = {};
+  if (!counts) {
+    return labels;
+  }
+  for (const severity of SEVERITIES) {
+    if (counts[severity] !== undefined) {
+      labels[`${SCAN_LABEL_PREFIX}${severity.toLowerCase()}`] = String(counts[severity]);
+    }
+  }
+  return labels;
+}
+
+/**
+ * Reads the counts from a container's labels, undefined if its image wasn't
+ * scanned
+ */
+export function readScanCounts(labels: Record<string, string>): ScanCounts | undefined {
+  if (!SEVERITIES.some((severity) => `${SCAN_LABEL_PREFIX}${severity.toLowerCase()}` in labels)) {
+    return undefined;
+  }
+  const counts = countBySeverity([]);
+  for (const severity of SEVERITIES) {
+    counts[severity] = parseInt(labels[`${SCAN_LABEL_PREFIX}${severity.toLowerCase()}`], 10) || 0;
+  }
+  return counts;
+}
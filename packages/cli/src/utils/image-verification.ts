@@ -0,0 +1,80 @@
+import { ImageVerifyConfig } from "../config/types";
+import { getImageRepository } from "./image-gc";
+
+const DIGEST_PATTERN = /^sha256:[a-f0-9]{64}$/;
+
+// Run on the server, so cosign doesn't have to be installed anywhere
+export const COSIGN_IMAGE = "ghcr.io/sigstore/cosign/cosign:v2.4.1";
+
+// Where the public key is mounted in the cosign container
+export const COSIGN_KEY_PATH = "/cosign.pub";
+
+// Container label holding the digest of the image it was started from
+export const IMAGE_DIGEST_LABEL = "iop.image-digest";
+
+/**
+ * Checks a --digest value, e.g. "sha256:4f2a..."
+ */
+export function parseImageDigest(digest: string): string {
+  const normalized = digest.trim().toLowerCase();
+  if (!DIGEST_PATTERN.test(normalized)) {
+    throw new Error(
+      `Invalid digest "${digest}", expected sha256: followed by 64 hex characters`
+    );
+  }
+  return normalized;
+}
+
+/**
+ * Pins an image reference to a digest
+ * e.g. "ghcr.io/acme/web:1.0" -> "ghcr.io/acme/web@sha256:4f2a..."
+ */
+export function pinImageDigest(image: string, digest: string): string {
+  return `${getImageRepository(image)}@${digest}`;
+}
+
+/**
+ * Drops the Docker Hub prefixes inspect leaves out, so "docker.io/library/postgres"
+ * and "postgres" compare equal
+ */
+function shortRepository(repository: string): string {
+  return repository.replace(/^docker\.io\//, "").replace(/^library\//, "");
+}
+
+/**
+ * Picks the registry digest of an image from its inspect RepoDigests, preferring
+ * the one of the repository it was pulled as. Returns null for images that were
+ * built or loaded rather than pulled.
+ */
+export function selectRepoDigest(
+  image: string,
+  repoDigests: string[]
+): string | null {
+  const repository = shortRepository(getImageRepository(image));
+  const match =
+    repoDigests.find(
+      (entry) => shortRepository(entry.split("@")[0]) === repository
+    ) || repoDigests[0];
+  return match?.split("@")[1] || null;
+}
+
+/**
+ * Builds the cosign arguments verifying an image against a public key, or
+ * against the identity and issuer of a keyless signature
+ */
+export function buildCosignVerifyArgs(
+  verify: ImageVerifyConfig,
+  imageRef: string
+): string[] {
+  if (verify.key) {
+    return ["verify", "--key", COSIGN_KEY_PATH, imageRef];
+  }
+  return [
+    "verify",
+    "--certificate-identity",
+    verify.identity!,
+    "--certificate-oidc-issuer",
+    verify.issuer!,
+    imageRef,
+  ];
+}
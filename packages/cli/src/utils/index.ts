@@ -30,15 +30,52 @@ export function sanitizeFolderName(name: string): string {
     .toLowerCase();
 }
 
+/**
+ * Returns the Docker volume name for a project-level named volume
+ */
+export function getProjectVolumeName(
+  projectName: string,
+  volumeName: string
+): string {
+  return `${sanitizeFolderName(projectName)}-${sanitizeFolderName(volumeName)}`;
+}
+
+/**
+ * Returns the names of the volumes declared in the top-level volumes section
+ */
+export function getDeclaredVolumeNames(config: {
+  volumes?: Record<string, unknown>;
+}): string[] {
+  return Object.keys(config.volumes || {});
+}
+
+/**
+ * Returns the declared named volumes a service mounts
+ */
+export function getServiceNamedVolumes(
+  volumes: string[] | undefined,
+  declaredVolumes: string[]
+): string[] {
+  if (!volumes) {
+    return [];
+  }
+
+  return volumes
+    .map((volume) => volume.split(":")[0])
+    .filter((source) => declaredVolumes.includes(source));
+}
+
 /**
  * Processes volume mappings to ensure project isolation and proper path resolution
  * @param volumes Array of volume mappings (e.g., ["mydata:/data", "./local:/app"])
  * @param projectName Project name for prefixing
+ * @param declaredVolumes Names declared in the top-level volumes section, mounted as Docker named volumes
  * @returns Processed volume mappings with project prefixes and resolved paths
  */
 export function processVolumes(
   volumes: string[] | undefined,
-  projectName: string
+  projectName: string,
+  declaredVolumes: string[] = []
 ): string[] {
   if (!volumes || volumes.length === 0) {
     return [];
@@ -57,7 +94,10 @@ export function processVolumes(
 
     let processedSource: string;
 
-    if (
+    if (declaredVolumes.includes(source)) {
+      // Declared named volume - managed by iop and prefixed with project name
+      processedSource = getProjectVolumeName(projectName, source);
+    } else if (
       source.startsWith("./") ||
       source.startsWith("../") ||
       !source.startsWith("/")
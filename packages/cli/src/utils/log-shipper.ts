@@ -0,0 +1,380 @@
+import * as crypto from "crypto";
+import {
+  IopConfig,
+  IopSecrets,
+  LogDestination,
+  LogsConfig,
+  ServiceEntry,
+} from "../config/types";
+import { DockerClient } from "../docker";
+import { IOP_PROXY_NAME } from "../setup-proxy";
+import { SSHClient } from "../ssh";
+import { getExpectedHosts } from "./reconcile";
+
+// Where the shipper keeps its position in each container's log, so a
+// restart neither loses nor repeats lines
+const LOGS_DATA_DIR = "/var/lib/vector";
+const LOGS_CONFIG_PATH = "/etc/vector/vector.json";
+
+// Matches the line the proxy logs for every request it proxies
+const ACCESS_LOG_PATTERN =
+  "\\[PROXY\\] (?P<host>[^\\s:]+)(?::\\d+)? (?P<method>\\S+) (?P<path>\\S+) -> (?P<target>\\S+) (?P<status>\\d+) \\((?P<duration_ms>\\d+)ms\\)";
+
+/**
+ * Returns the name of a project's log shipper container. One runs per
+ * project on each server, so projects sharing a server ship to their own
+ * destinations.
+ */
+export function getLogShipperName(project: string): string {
+  return `iop-logs-${project}`;
+}
+
+function getLogShipperDir(project: string): string {
+  return `~/.iop/logs/${project}`;
+}
+
+/**
+ * Returns the app each host of the server's services belongs to. Wildcard
+ * hosts such as *.example.com match any subdomain.
+ */
+export function getHostApps(
+  config: IopConfig,
+  services: ServiceEntry[]
+): Array<{ host: string; app: string }> {
+  return services.flatMap((service) =>
+    getExpectedHosts(config.name, service).map((host) => ({ host, app: service.name }))
+  );
+}
+
+/**
+ * Returns the environment variable a destination's secret is passed in, so
+ * it stays out of the config file
+ */
+function secretEnvName(index: number, name: string): string {
+  return `IOP_LOGS_${index}_${name}`;
+}
+
+/**
+ * Resolves the secrets the destinations reference into the shipper's
+ * environment
+ */
+export function getLogShipperEnv(logs: LogsConfig, secrets: IopSecrets): Record<string, string> {
+  const env: Record<string, string> = {};
+  const resolve = (index: number, name: string, secret?: string) => {
+    if (!secret) return;
+    if (!secrets[secret]) {
+      throw new Error(`Log destination secret "${secret}" not found in secrets`);
+    }
+    env[secretEnvName(index, name)] = secrets[secret];
+  };
+
+  logs.destinations.forEach((destination, index) => {
+    if (destination.type === "loki") {
+      resolve(index, "PASSWORD", destination.password_secret);
+    } else if (destination.type === "s3") {
+      resolve(index, "ACCESS_KEY_ID", destination.access_key_id_secret);
+      resolve(index, "SECRET_ACCESS_KEY", destination.secret_access_key_secret);
+    }
+  });
+  return env;
+}
+
+/**
+ * Builds the VRL program that turns a proxy log line into an access log
+ * event of one of the project's apps, dropping lines of other projects
+ */
+function buildAccessLogProgram(
+  project: string,
+  server: string,
+  hostApps: Array<{ host: string; app: string }>
+): string {
+  const exact: Record<string, string> = {};
+  const wildcards: string[] = [];
+  for (const { host, app } of hostApps) {
+    if (host.startsWith("*.")) {
+      wildcards.push(
+        `if app == null && ends_with(host, ${JSON.stringify(host.slice(1))}) { app = ${JSON.stringify(app)} }`
+      );
+    } else {
+      exact[host] = app;
+    }
+  }
+
+  return [
+    `parsed, err = parse_regex(.message, r'${ACCESS_LOG_PATTERN}')`,
+    "if err != null { abort }",
+    "host = string!(parsed.host)",
+    `app = get!(value: ${JSON.stringify(exact)}, path: [host])`,
+    ...wildcards,
+    "if app == null { abort }",
+    `.project = ${JSON.stringify(project)}`,
+    ".app = app",
+    `.server = ${JSON.stringify(server)}`,
+    '.source = "proxy"',
+    ".request = {",
+    "  \"host\": host,",
+    "  \"method\": parsed.method,",
+    "  \"path\": parsed.path,",
+    "  \"target\": parsed.target,",
+    "  \"status\": to_int!(parsed.status),",
+    "  \"duration_ms\": to_int!(parsed.duration_ms)",
+    "}",
+    "del(.label)",
+  ].join("\n");
+}
+
+/**
+ * Builds the sink, and for syslog the transform formatting its lines, of
+ * one destination
+ */
+function buildDestination(
+  destination: LogDestination,
+  index: number,
+  inputs: string[]
+): { transforms: Record<string, unknown>; sinks: Record<string, unknown> } {
+  const name = `${destination.type}_${index}`;
+
+  switch (destination.type) {
+    case "loki":
+      return {
+        transforms: {},
+        sinks: {
+          [name]: {
+            type: "loki",
+            inputs,
+            endpoint: destination.url,
+            encoding: { codec: "json" },
+            labels: {
+              project: "{{ project }}",
+              app: "{{ app }}",
+              server: "{{ server }}",
+              source: "{{ source }}",
+            },
+            out_of_order_action: "accept",
+            ...(destination.tenant_id ? { tenant_id: destination.tenant_id } : {}),
+            ...(destination.username
+              ? {
+                  auth: {
+                    strategy: "basic",
+                    user: destination.username,
+                    password: `\${${secretEnvName(index, "PASSWORD")}}`,
+                  },
+                }
+              : {}),
+          },
+        },
+      };
+    case "s3":
+      return {
+        transforms: {},
+        sinks: {
+          [name]: {
+            type: "aws_s3",
+            inputs,
+            bucket: destination.bucket,
+            region: destination.region,
+            key_prefix: `${destination.prefix || ""}{{ project }}/{{ app }}/%F/`,
+            compression: "gzip",
+            encoding: { codec: "json" },
+            framing: { method: "newline_delimited" },
+            ...(destination.endpoint ? { endpoint: destination.endpoint } : {}),
+            ...(destination.access_key_id_secret
+              ? {
+                  auth: {
+                    access_key_id: `\${${secretEnvName(index, "ACCESS_KEY_ID")}}`,
+                    secret_access_key: `\${${secretEnvName(index, "SECRET_ACCESS_KEY")}}`,
+                  },
+                }
+              : {}),
+          },
+        },
+      };
+    case "syslog":
+      // RFC 5424 lines with the app as APP-NAME, stderr of apps as errors
+      return {
+        transforms: {
+          [name]: {
+            type: "remap",
+            inputs,
+            source: [
+              'pri = if .source == "app" && .stream == "stderr" { "<11>" } else { "<14>" }',
+              '.message = pri + "1 " + format_timestamp!(.timestamp, format: "%+") + " " + string!(.server) + " " + string!(.project) + "-" + string!(.app) + " - - - " + string!(.message)',
+            ].join("\n"),
+          },
+        },
+        sinks: {
+          [name]: {
+            type: "socket",
+            inputs: [name],
+            address: destination.address,
+            mode: destination.protocol,
+            encoding: { codec: "text" },
+            ...(destination.protocol === "tcp"
+              ? { framing: { method: "newline_delimited" } }
+              : {}),
+          },
+        },
+      };
+  }
+}
+
+/**
+ * Builds the Vector config of a project's log shipper on a server. It reads
+ * the output of the project's containers and, unless disabled, the proxy's
+ * access log lines for the project's hosts, and labels each line with its
+ * project, app, server and source.
+ */
+export function buildLogShipperConfig(
+  config: IopConfig,
+  server: string,
+  hostApps: Array<{ host: string; app: string }>
+): Record<string, unknown> {
+  const logs = config.logs!;
+  const project = config.name;
+
+  const sources: Record<string, unknown> = {
+    containers: {
+      type: "docker_logs",
+      include_labels: [`iop.project=${project}`],
+      exclude_containers: [getLogShipperName(project)],
+    },
+  };
+  const transforms: Record<string, unknown> = {
+    apps: {
+      type: "remap",
+      inputs: ["containers"],
+      source: [
+        `.project = ${JSON.stringify(project)}`,
+        '.app = .label."iop.app" || .label."iop.service" || .container_name',
+        `.server = ${JSON.stringify(server)}`,
+        '.source = "app"',
+        "del(.label)",
+      ].join("\n"),
+    },
+  };
+  const inputs = ["apps"];
+
+  if (logs.proxy && hostApps.length > 0) {
+    sources.proxy = { type: "docker_logs", include_containers: [IOP_PROXY_NAME] };
+    transforms.access = {
+      type: "remap",
+      inputs: ["proxy"],
+      drop_on_abort: true,
+      source: buildAccessLogProgram(project, server, hostApps),
+    };
+    inputs.push("access");
+  }
+
+  const sinks: Record<string, unknown> = {};
+  logs.destinations.forEach((destination, index) => {
+    const built = buildDestination(destination, index, inputs);
+    Object.assign(transforms, built.transforms);
+    Object.assign(sinks, built.sinks);
+  });
+
+  return { data_dir: LOGS_DATA_DIR, sources, transforms, sinks };
+}
+
+/**
+ * Identifies the shipper's config, image and secrets, so a change recreates
+ * the container. Secrets only go in hashed.
+ */
+export function getLogShipperFingerprint(
+  vectorConfig: string,
+  image: string,
+  env: Record<string, string>
+): string {
+  return crypto
+    .createHash("sha256")
+    .update([vectorConfig, image, JSON.stringify(env)].join("\n"))
+    .digest("hex")
+    .substring(0, 16);
+}
+
+/**
+ * Runs the project's log shipper on the server. The container is left alone
+ * while its config is unchanged. Returns whether it was (re)created.
+ */
+export async function ensureLogShipper(
+  sshClient: SSHClient,
+  dockerClient: DockerClient,
+  config: IopConfig,
+  secrets: IopSecrets,
+  server: string,
+  services: ServiceEntry[]
+): Promise<boolean> {
+  const logs = config.logs!;
+  const name = getLogShipperName(config.name);
+  const vectorConfig = JSON.stringify(
+    buildLogShipperConfig(config, server, getHostApps(config, services)),
+    null,
+    2
+  );
+  const env = getLogShipperEnv(logs, secrets);
+  const fingerprint = getLogShipperFingerprint(vectorConfig, logs.image, env);
+
+  const labels = await dockerClient.getContainerLabels(name);
+  if (
+    labels["iop.logs.fingerprint"] === fingerprint &&
+    (await dockerClient.containerIsRunning(name))
+  ) {
+    return false;
+  }
+
+  const managedLabels = {
+    "iop.managed": "true",
+    "iop.project": config.name,
+  };
+  const volume = `${name}-data`;
+  if (!(await dockerClient.createVolume({ name: volume, labels: managedLabels }))) {
+    throw new Error(`Failed to create the log shipper's volume ${volume}`);
+  }
+
+  const dir = getLogShipperDir(config.name);
+  await sshClient.exec(`mkdir -p ${dir} && chmod 700 ${dir}`);
+  await sshClient.exec(`cat > ${dir}/vector.json << 'IOP_LOGS_EOF'
+${vectorConfig}
+IOP_LOGS_EOF`);
+
+  if (await dockerClient.containerExists(name)) {
+    await dockerClient.stopContainer(name);
+    await dockerClient.removeContainer(name);
+  }
+
+  const created = await dockerClient.createContainer({
+    name,
+    image: logs.image,
+    volumes: [
+      "/var/run/docker.sock:/var/run/docker.sock:ro",
+      `${volume}:${LOGS_DATA_DIR}`,
+      `${dir}/vector.json:${LOGS_CONFIG_PATH}:ro`,
+    ],
+    envVars: env,
+    command: `--config ${LOGS_CONFIG_PATH}`,
+    labels: {
+      ...managedLabels,
+      "iop.type": "logs",
+      "iop.logs.fingerprint": fingerprint,
+    },
+  });
+  if (!created) {
+    throw new Error(`Failed to start ${name}`);
+  }
+  return true;
+}
+
+/**
+ * Stops and removes the project's log shipper on the server, keeping its
+ * position in the logs in case it is set up again
+ */
+export async function removeLogShipper(
+  dockerClient: DockerClient,
+  project: string
+): Promise<boolean> {
+  const name = getLogShipperName(project);
+  if (!(await dockerClient.containerExists(name))) {
+    return false;
+  }
+  await dockerClient.stopContainer(name);
+  return dockerClient.removeContainer(name);
+}
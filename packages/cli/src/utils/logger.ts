@@ -1,3 +1,5 @@
+import { logProgress, progressStream } from "./output";
+
 export interface LoggerOptions {
   verbose?: boolean;
 }
@@ -32,14 +34,14 @@ export class Logger {
 
   // Setup-specific methods
   setupStart(message: string) {
-    console.log(`${message}\n`);
+    logProgress(`${message}\n`);
     this.startTime = Date.now();
   }
 
   setupComplete() {
     this.clearSpinner();
     const totalDuration = Date.now() - this.startTime;
-    console.log(
+    logProgress(
       `\n[✓] Setup completed successfully in ${this.formatDuration(
         totalDuration
       )}`
@@ -49,7 +51,7 @@ export class Logger {
   setupFailed(error?: any) {
     this.clearSpinner();
     const totalDuration = Date.now() - this.startTime;
-    console.log(
+    logProgress(
       `\n[✗] Setup failed after ${this.formatDuration(totalDuration)}`
     );
     if (this.isVerbose && error) {
@@ -71,13 +73,13 @@ export class Logger {
   phaseComplete(message: string, duration?: number) {
     this.clearSpinner();
     const elapsed = duration || Date.now() - this.stepStartTime;
-    console.log(`[✓] ${message} (${this.formatDuration(elapsed)})`);
+    logProgress(`[✓] ${message} (${this.formatDuration(elapsed)})`);
     this.currentOutputLine++;
   }
 
   phaseError(message: string, error?: any) {
     this.clearSpinner();
-    console.log(`[✗] ${message}`);
+    logProgress(`[✗] ${message}`);
     if (error && this.isVerbose) {
       console.error(`   Error details: ${error}`);
     }
@@ -94,20 +96,20 @@ export class Logger {
     this.clearSpinner();
     const indent = this.getIndent(level);
     const elapsed = duration || Date.now() - this.stepStartTime;
-    console.log(`${indent}[✓] ${message} (${this.formatDuration(elapsed)})`);
+    logProgress(`${indent}[✓] ${message} (${this.formatDuration(elapsed)})`);
   }
 
   stepLast(message: string, duration?: number, level: number = 0) {
     this.clearSpinner();
     const indent = this.getIndent(level);
     const elapsed = duration || Date.now() - this.stepStartTime;
-    console.log(`${indent}[✓] ${message} (${this.formatDuration(elapsed)})`);
+    logProgress(`${indent}[✓] ${message} (${this.formatDuration(elapsed)})`);
   }
 
   stepError(message: string, error?: any, level: number = 0) {
     this.clearSpinner();
     const indent = this.getIndent(level);
-    console.log(`${indent}[✗] ${message}`);
+    logProgress(`${indent}[✗] ${message}`);
     if (error && this.isVerbose) {
       console.error(`${indent}   Error: ${error}`);
     }
@@ -116,7 +118,7 @@ export class Logger {
   // Server-specific operations
   server(hostname: string) {
     this.clearSpinner();
-    console.log(`  └─ ${hostname}`);
+    logProgress(`  └─ ${hostname}`);
   }
 
   // New method for app-specific deployment logging
@@ -124,9 +126,9 @@ export class Logger {
     this.clearSpinner();
     const symbol = isLast ? "└─" : "├─";
     if (servers.length === 1) {
-      console.log(`  ${symbol} ${appName} → ${servers[0]}`);
+      logProgress(`  ${symbol} ${appName} → ${servers[0]}`);
     } else {
-      console.log(`  ${symbol} ${appName} → ${servers.join(", ")}`);
+      logProgress(`  ${symbol} ${appName} → ${servers.join(", ")}`);
     }
     this.currentOutputLine++;
   }
@@ -142,17 +144,34 @@ export class Logger {
     this.clearSpinner();
     const symbol = isLast ? "└─" : "├─";
     const elapsed = duration || Date.now() - this.stepStartTime;
-    console.log(`  ${symbol} [✓] ${serviceName} → ${message} (${this.formatDuration(elapsed)})`);
+    logProgress(`  ${symbol} [✓] ${serviceName} → ${message} (${this.formatDuration(elapsed)})`);
     this.currentOutputLine++;
   }
 
   serviceDeploymentSkipped(serviceName: string, reason: string, isLast: boolean = false) {
     this.clearSpinner();
     const symbol = isLast ? "└─" : "├─";
-    console.log(`  ${symbol} [✓] ${serviceName} → ${reason}`);
+    logProgress(`  ${symbol} [✓] ${serviceName} → ${reason}`);
+    this.currentOutputLine++;
+  }
+
+  serviceDeploymentFailed(serviceName: string, error: string, isLast: boolean = false) {
+    this.clearSpinner();
+    const symbol = isLast ? "└─" : "├─";
+    logProgress(`  ${symbol} [✗] ${serviceName} → ${error}`);
     this.currentOutputLine++;
   }
 
+  // Combined outcome of the services deployed in one run
+  deploymentSummary(deployed: number, skipped: number, failed: number) {
+    this.clearSpinner();
+    const parts = [`${deployed} deployed`, `${skipped} up-to-date`];
+    if (failed > 0) {
+      parts.push(`${failed} failed`);
+    }
+    logProgress(`  Services: ${parts.join(", ")}`);
+  }
+
   // Build steps that work within a phase
   buildStep(message: string, isLast: boolean = false) {
     // Don't clear the main spinner, just pause it and show the step
@@ -174,7 +193,7 @@ export class Logger {
     this.clearSpinner();
     const symbol = isLast ? "└─" : "├─";
     const elapsed = duration || Date.now() - this.stepStartTime;
-    console.log(
+    logProgress(
       `  ${symbol} [✓] ${message} (${this.formatDuration(elapsed)})`
     );
     this.currentOutputLine++;
@@ -192,12 +211,12 @@ export class Logger {
     this.clearSpinner();
     // Move cursor up past all sub-steps to overwrite the [i] line
     const linesToMoveUp = subStepCount + 1; // +1 for the current position
-    process.stdout.write(`\x1b[${linesToMoveUp}A\x1b[2K`);
-    console.log(`[✓] ${message} (${this.formatDuration(duration)})`);
+    progressStream().write(`\x1b[${linesToMoveUp}A\x1b[2K`);
+    logProgress(`[✓] ${message} (${this.formatDuration(duration)})`);
 
     // Move cursor back down to the bottom
     if (subStepCount > 0) {
-      process.stdout.write(`\x1b[${subStepCount}B`);
+      progressStream().write(`\x1b[${subStepCount}B`);
     }
   }
 
@@ -218,7 +237,7 @@ export class Logger {
     const symbol = isLast ? "└─" : "├─";
     const prefix = isLastParent ? "     " : "  │  ";
     const elapsed = duration || Date.now() - this.stepStartTime;
-    console.log(
+    logProgress(
       `${prefix}${symbol} [✓] ${message} (${this.formatDuration(elapsed)})`
     );
     this.currentOutputLine++;
@@ -228,7 +247,7 @@ export class Logger {
     this.clearSpinner();
     const symbol = isLast ? "└─" : "├─";
     const prefix = isLastParent ? "     " : "  │  ";
-    console.log(`${prefix}${symbol} [✗] ${message}`);
+    logProgress(`${prefix}${symbol} [✗] ${message}`);
     if (error && this.isVerbose) {
       console.error(`${prefix}   Error: ${error}`);
     }
@@ -241,11 +260,11 @@ export class Logger {
     if (appUrls.length > 0) {
       const isPlural = appUrls.length > 1;
       const appText = isPlural ? "apps are" : "app is";
-      console.log(`\nYour ${appText} live at:`);
+      logProgress(`\nYour ${appText} live at:`);
       appUrls.forEach((appUrl, index) => {
         const isLast = index === appUrls.length - 1;
         const symbol = isLast ? "└─" : "├─";
-        console.log(`  ${symbol} ${appUrl.appName} → ${appUrl.url}`);
+        logProgress(`  ${symbol} ${appUrl.appName} → ${appUrl.url}`);
       });
     }
   }
@@ -253,7 +272,7 @@ export class Logger {
   deploymentFailed(error: any) {
     this.clearSpinner();
     const totalDuration = Date.now() - this.startTime;
-    console.log(
+    logProgress(
       `[✗] Deployment failed after ${this.formatDuration(totalDuration)}`
     );
     if (this.isVerbose && error) {
@@ -265,7 +284,7 @@ export class Logger {
   verboseLog(message: string) {
     if (this.isVerbose) {
       this.clearSpinner();
-      console.log(`   ${message}`);
+      logProgress(`   ${message}`);
       // Restart spinner if one was active
       if (this.activeSpinner) {
         this.restartSpinner();
@@ -291,13 +310,13 @@ export class Logger {
   // Info messages
   info(message: string) {
     this.clearSpinner();
-    console.log(`[i] ${message}`);
+    logProgress(`[i] ${message}`);
   }
 
   // Simple parent phase (shows [ ] initially, then [✓] when complete)
   phaseStart(message: string) {
     this.clearSpinner();
-    console.log(`[ ] ${message}`);
+    logProgress(`[ ] ${message}`);
     this.phaseLines.set(message, this.currentOutputLine);
     this.currentOutputLine++;
   }
@@ -312,23 +331,23 @@ export class Logger {
       
       if (linesToMoveUp > 0) {
         // Move cursor up to the phase line
-        process.stdout.write(`\u001b[${linesToMoveUp}A`);
+        progressStream().write(`\u001b[${linesToMoveUp}A`);
         // Clear the line and write new content
-        process.stdout.write('\u001b[2K\r');
-        process.stdout.write(`[✓] ${message}`);
+        progressStream().write('\u001b[2K\r');
+        progressStream().write(`[✓] ${message}`);
         // Move cursor back down to current position and to start of line
-        process.stdout.write(`\u001b[${linesToMoveUp}B\r`);
+        progressStream().write(`\u001b[${linesToMoveUp}B\r`);
       } else {
         // If we're on the same line, just update it
-        process.stdout.write('\u001b[2K\r');
-        process.stdout.write(`[✓] ${message}\n`);
+        progressStream().write('\u001b[2K\r');
+        progressStream().write(`[✓] ${message}\n`);
       }
       
       // Clean up
       this.phaseLines.delete(message);
     } else {
       // Fallback to regular output
-      console.log(`[✓] ${message}`);
+      logProgress(`[✓] ${message}`);
     }
   }
 
@@ -345,8 +364,8 @@ export class Logger {
       const timeStr = this.formatDuration(elapsed);
 
       // Clear the current line and write the spinner
-      process.stdout.write("\r\x1b[K");
-      process.stdout.write(`[${spinner}] ${message}... (${timeStr})`);
+      progressStream().write("\r\x1b[K");
+      progressStream().write(`[${spinner}] ${message}... (${timeStr})`);
 
       this.spinnerIndex = (this.spinnerIndex + 1) % this.spinnerChars.length;
     };
@@ -381,8 +400,8 @@ export class Logger {
       const timeStr = this.formatDuration(elapsed);
 
       // Clear the current line and write the spinner with prefix positioning
-      process.stdout.write("\r\x1b[K");
-      process.stdout.write(`${prefix}[${spinner}] ${message}... (${timeStr})`);
+      progressStream().write("\r\x1b[K");
+      progressStream().write(`${prefix}[${spinner}] ${message}... (${timeStr})`);
 
       this.spinnerIndex = (this.spinnerIndex + 1) % this.spinnerChars.length;
     };
@@ -405,7 +424,7 @@ export class Logger {
     if (this.activeSpinner) {
       clearInterval(this.activeSpinner.interval);
       // Clear the current line
-      process.stdout.write("\r\x1b[K");
+      progressStream().write("\r\x1b[K");
       this.activeSpinner = null;
     }
   }
@@ -0,0 +1,209 @@
+import { MeshConfig } from "../config/types";
+import { SSHClient } from "../ssh";
+
+export const MESH_INTERFACE = "iop0";
+export const MESH_DEFAULT_SUBNET = "10.210.0.0/24";
+export const MESH_DEFAULT_PORT = 51820;
+export const MESH_CONFIG_PATH = `/etc/wireguard/${MESH_INTERFACE}.conf`;
+// The private key stays in its own file on the server and never passes
+// through the CLI. The config loads it with PostUp.
+export const MESH_KEY_PATH = `/etc/wireguard/${MESH_INTERFACE}.key`;
+// Seconds after which a peer without a handshake counts as unreachable.
+// WireGuard renews handshakes every two minutes while traffic flows.
+export const MESH_HANDSHAKE_TIMEOUT = 180;
+// The proxy publishes its DNS server on the mesh address, so Docker has to
+// start its containers after the interface is up
+export const MESH_DOCKER_DROPIN_PATH = "/etc/systemd/system/docker.service.d/iop-mesh.conf";
+export const MESH_DOCKER_DROPIN = `[Unit]
+After=wg-quick@${MESH_INTERFACE}.service
+Wants=wg-quick@${MESH_INTERFACE}.service
+`;
+
+export interface MeshPeer {
+  server: string;
+  address: string;
+  publicKey: string;
+  endpoint: string;
+}
+
+export interface WireGuardPeerStatus {
+  publicKey: string;
+  endpoint?: string;
+  allowedIps: string[];
+  // Unix time of the last handshake, 0 if there was none
+  latestHandshake: number;
+  rxBytes: number;
+  txBytes: number;
+}
+
+function ipToNumber(ip: string): number {
+  const octets = ip.split(".").map((octet) => parseInt(octet, 10));
+  if (octets.length !== 4 || octets.some((octet) => isNaN(octet) || octet < 0 || octet > 255)) {
+    throw new Error(`Invalid IPv4 address "${ip}"`);
+  }
+  return octets.reduce((value, octet) => value * 256 + octet, 0);
+}
+
+function numberToIp(value: number): string {
+  return [24, 16, 8, 0].map((shift) => Math.floor(value / 2 ** shift) % 256).join(".");
+}
+
+/**
+ * Parses a CIDR such as "10.210.0.0/24" into its network address and prefix
+ */
+export function parseMeshSubnet(subnet: string): { network: number; prefix: number } {
+  const [ip, prefixText] = subnet.split("/");
+  const prefix = parseInt(prefixText, 10);
+  if (isNaN(prefix) || prefix < 16 || prefix > 30) {
+    throw new Error(`Invalid mesh subnet "${subnet}", expected e.g. ${MESH_DEFAULT_SUBNET}`);
+  }
+  const size = 2 ** (32 - prefix);
+  return { network: ipToNumber(ip) - (ipToNumber(ip) % size), prefix };
+}
+
+/**
+ * Gives every server an address in the subnet. Servers keep the address
+ * they already have, so adding a server doesn't renumber the others; the
+ * rest get the lowest free ones.
+ */
+export function assignMeshAddresses(
+  subnet: string,
+  servers: string[],
+  existing: Record<string, string | undefined>
+): Record<string, string> {
+  const { network, prefix } = parseMeshSubnet(subnet);
+  const first = network + 1;
+  const last = network + 2 ** (32 - prefix) - 2;
+
+  const assigned: Record<string, string> = {};
+  const taken = new Set<number>();
+  for (const server of servers) {
+    const address = existing[server];
+    if (!address) continue;
+    let value: number;
+    try {
+      value = ipToNumber(address);
+    } catch {
+      continue;
+    }
+    if (value >= first && value <= last && !taken.has(value)) {
+      assigned[server] = address;
+      taken.add(value);
+    }
+  }
+
+  let next = first;
+  for (const server of servers) {
+    if (assigned[server]) continue;
+    while (taken.has(next)) next++;
+    if (next > last) {
+      throw new Error(`Mesh subnet ${subnet} has no free address left for ${server}`);
+    }
+    assigned[server] = numberToIp(next);
+    taken.add(next);
+  }
+  return assigned;
+}
+
+/**
+ * Returns the address a server has in its current mesh config, if any
+ */
+export function parseMeshAddress(config: string): string | undefined {
+  const match = config.match(/^\s*Address\s*=\s*([\d.]+)/m);
+  return match?.[1];
+}
+
+/**
+ * Returns the IPv4 address in `ip -4 -o addr show dev <interface>` output
+ */
+export function parseInterfaceAddress(output: string): string | undefined {
+  const match = output.match(/\binet\s+([\d.]+)\//);
+  return match?.[1];
+}
+
+/**
+ * Returns the address of the server's mesh interface, or undefined when the
+ * server isn't part of a mesh. Unlike the config file, this needs no root.
+ */
+export async function readMeshAddress(sshClient: SSHClient): Promise<string | undefined> {
+  return parseInterfaceAddress(
+    await sshClient.exec(`ip -4 -o addr show dev ${MESH_INTERFACE} 2>/dev/null || true`)
+  );
+}
+
+/**
+ * Returns the host:port other servers reach a server's WireGuard on:
+ * mesh.endpoints if set, otherwise the server's SSH address
+ */
+export function getMeshEndpoint(server: string, mesh: Partial<MeshConfig> = {}): string {
+  const host = mesh.endpoints?.[server] ?? server;
+  const port = mesh.port ?? MESH_DEFAULT_PORT;
+  // IPv6 addresses are bracketed, like in URLs
+  return host.includes(":") ? `[${host}]:${port}` : `${host}:${port}`;
+}
+
+/**
+ * Builds a server's wg-quick config: its own address and every other server
+ * as a peer reachable at a single mesh address
+ */
+export function buildWireGuardConfig(
+  self: MeshPeer,
+  peers: MeshPeer[],
+  subnet: string,
+  port: number
+): string {
+  const { prefix } = parseMeshSubnet(subnet);
+  let config = `# Managed by iop network setup
+[Interface]
+Address = ${self.address}/${prefix}
+ListenPort = ${port}
+PostUp = wg set %i private-key ${MESH_KEY_PATH}
+`;
+  for (const peer of peers) {
+    if (peer.server === self.server) continue;
+    config += `
+# ${peer.server}
+[Peer]
+PublicKey = ${peer.publicKey}
+AllowedIPs = ${peer.address}/32
+Endpoint = ${peer.endpoint}
+PersistentKeepalive = 25
+`;
+  }
+  return config;
+}
+
+/**
+ * Parses the peers from `wg show <interface> dump`. The first line describes
+ * the interface itself and is skipped.
+ */
+export function parseWireGuardDump(output: string): WireGuardPeerStatus[] {
+  return output
+    .trim()
+    .split("\n")
+    .slice(1)
+    .map((line) => line.split("\t"))
+    .filter((fields) => fields.length >= 8)
+    .map((fields) => ({
+      publicKey: fields[0],
+      endpoint: fields[2] === "(none)" ? undefined : fields[2],
+      allowedIps: fields[3] === "(none)" ? [] : fields[3].split(","),
+      latestHandshake: parseInt(fields[4], 10) || 0,
+      rxBytes: parseInt(fields[5], 10) || 0,
+      txBytes: parseInt(fields[6], 10) || 0,
+    }));
+}
+
+/**
+ * Formats how long ago a peer's last handshake was, e.g. "42s ago" or "never"
+ */
+export function formatHandshake(latestHandshake: number, now: number = Date.now() / 1000): string {
+  if (!latestHandshake) {
+    return "never";
+  }
+  const seconds = Math.max(0, Math.floor(now - latestHandshake));
+  if (seconds < 60) return `${seconds}s ago`;
+  if (seconds < 3600) return `${Math.floor(seconds / 60)}m ago`;
+  if (seconds < 86400) return `${Math.floor(seconds / 3600)}h ago`;
+  return `${Math.floor(seconds / 86400)}d ago`;
+}
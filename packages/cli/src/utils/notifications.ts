@@ -0,0 +1,26 @@
+import { IopConfig, IopSecrets } from "../config/types";
+import { ProxyNotificationTarget } from "../proxy";
+
+/**
+ * Resolves the notifications section into the targets pushed to the proxy,
+ * reading each webhook URL from secrets
+ */
+export function buildNotificationTargets(
+  config: IopConfig,
+  secrets: IopSecrets
+): ProxyNotificationTarget[] {
+  return (config.notifications || []).map((notification) => {
+    const url = secrets[notification.url_secret];
+    if (!url) {
+      throw new Error(
+        `Notification URL "${notification.url_secret}" not found in secrets`
+      );
+    }
+    return {
+      type: notification.type,
+      url,
+      events: notification.events,
+      template: notification.template,
+    };
+  });
+}
@@ -0,0 +1,71 @@
+export type OutputMode = "text" | "json";
+
+let outputMode: OutputMode = "text";
+
+/**
+ * Works out the output mode from the --json flag or IOP_OUTPUT=json
+ */
+export function resolveOutputMode(
+  args: string[],
+  env: Record<string, string | undefined> = process.env
+): OutputMode {
+  if (args.includes("--json")) {
+    return "json";
+  }
+  return env.IOP_OUTPUT?.toLowerCase() === "json" ? "json" : "text";
+}
+
+/**
+ * Sets the output mode for the whole process. In JSON mode stdout is reserved
+ * for the result object: commands check isJsonOutput() and skip their
+ * human-readable output, and progress goes to stderr through logProgress.
+ */
+export function setOutputMode(mode: OutputMode): void {
+  outputMode = mode;
+}
+
+/**
+ * Whether commands should emit machine-readable JSON
+ */
+export function isJsonOutput(): boolean {
+  return outputMode === "json";
+}
+
+/**
+ * The stream progress and diagnostics are written to, stderr in JSON mode
+ */
+export function progressStream(): NodeJS.WriteStream {
+  return outputMode === "json" ? process.stderr : process.stdout;
+}
+
+/**
+ * Prints progress or diagnostics like console.log, on stderr in JSON mode
+ */
+export function logProgress(...args: unknown[]): void {
+  if (outputMode === "json") {
+    console.error(...args);
+  } else {
+    console.log(...args);
+  }
+}
+
+/**
+ * Writes a command's result object to stdout. Does nothing in text mode, where
+ * commands print their own human-readable output.
+ */
+export function writeResult(result: unknown): void {
+  if (outputMode !== "json") {
+    return;
+  }
+  process.stdout.write(`${JSON.stringify(result, null, 2)}\n`);
+}
+
+/**
+ * Writes a failure as a result object so scripts can tell why a command failed
+ */
+export function writeError(error: unknown): void {
+  writeResult({
+    success: false,
+    error: error instanceof Error ? error.message : String(error),
+  });
+}
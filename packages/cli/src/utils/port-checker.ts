@@ -1,5 +1,6 @@
 import { SSHClient } from "../ssh";
 import { DockerClient } from "../docker";
+import { logProgress } from "./output";
 
 export interface PortUsage {
   port: number;
@@ -153,7 +154,7 @@ export class PortChecker {
           // Skip ports that are already handled by Docker containers
           if (excludeDockerPorts.has(port)) {
             if (this.verbose) {
-              console.log(`[${this.serverHostname}] Skipping port ${port} - already handled by Docker`);
+              logProgress(`[${this.serverHostname}] Skipping port ${port} - already handled by Docker`);
             }
             continue;
           }
@@ -227,7 +228,7 @@ export class PortChecker {
           const isOwnProjectContainer = conflict.containerName.startsWith(`${projectName}-`);
           if (isOwnProjectContainer) {
             if (this.verbose) {
-              console.log(
+              logProgress(
                 `[${this.serverHostname}] Port ${planned.hostPort} used by own project container ${conflict.containerName}, will be replaced`
               );
             }
@@ -0,0 +1,174 @@
+import { IopConfig, NetworkConfig } from "../config/types";
+import { DockerClient } from "../docker";
+import { getProjectNetworkName } from "./index";
+
+const PROXY_CONTAINER = "iop-proxy";
+
+export interface NetworkContainer {
+  name: string;
+  project?: string; // The container's iop.project label
+}
+
+export interface ProjectNetworkInfo {
+  name: string;
+  driver: string;
+  subnets: string[];
+  labels: Record<string, string>;
+  containers: NetworkContainer[];
+}
+
+export interface ProjectNetworkCheck {
+  conflict?: string; // Why the network can't be used by the project
+  warnings: string[]; // Settings in iop.yml the existing network doesn't match
+}
+
+/**
+ * Labels marking a network as created by iop for a project
+ */
+export function getProjectNetworkLabels(
+  projectName: string
+): Record<string, string> {
+  return { "iop.managed": "true", "iop.project": projectName };
+}
+
+/**
+ * Checks whether an existing network can serve as the project's network.
+ * Networks created by older versions carry no labels, so an unlabelled one is
+ * only used while every container on it belongs to the project or is the proxy.
+ */
+export function checkProjectNetwork(
+  projectName: string,
+  network: ProjectNetworkInfo,
+  config?: NetworkConfig
+): ProjectNetworkCheck {
+  const owner = network.labels["iop.project"];
+  if (owner && owner !== projectName) {
+    return {
+      conflict: `Network ${network.name} belongs to project "${owner}". Rename one of the projects.`,
+      warnings: [],
+    };
+  }
+
+  if (!owner) {
+    const foreign = network.containers
+      .filter((c) => c.name !== PROXY_CONTAINER && c.project !== projectName)
+      .map((c) => c.name);
+    if (foreign.length > 0) {
+      return {
+        conflict: `Network ${network.name} exists but isn't managed by iop, it is used by ${foreign.join(", ")}. Remove or rename it.`,
+        warnings: [],
+      };
+    }
+  }
+
+  const warnings: string[] = [];
+  if (config?.driver && network.driver !== config.driver) {
+    warnings.push(
+      `Network ${network.name} uses driver ${network.driver}, not ${config.driver}. Remove it to recreate it.`
+    );
+  }
+  if (config?.subnet && !network.subnets.includes(config.subnet)) {
+    warnings.push(
+      `Network ${network.name} has subnet ${network.subnets.join(", ") || "none"}, not ${config.subnet}. Remove it to recreate it.`
+    );
+  }
+  return { warnings };
+}
+
+/**
+ * Whether a project's network can be removed: iop created it for the project
+ * and nothing but the proxy is attached. Unlabelled networks are left alone.
+ */
+export function canRemoveProjectNetwork(
+  projectName: string,
+  network: ProjectNetworkInfo
+): boolean {
+  return (
+    network.labels["iop.managed"] === "true" &&
+    network.labels["iop.project"] === projectName &&
+    network.containers.every((c) => c.name === PROXY_CONTAINER)
+  );
+}
+
+/**
+ * Inspects a network and the project labels of its containers, null if it
+ * doesn't exist
+ */
+export async function getProjectNetworkInfo(
+  dockerClient: DockerClient,
+  name: string
+): Promise<ProjectNetworkInfo | null> {
+  const inspect = await dockerClient.inspectNetwork(name);
+  if (!inspect) {
+    return null;
+  }
+
+  const containers = await Promise.all(
+    Object.values<any>(inspect.Containers || {}).map(async (c) => ({
+      name: c.Name,
+      project: (await dockerClient.getContainerLabels(c.Name))["iop.project"],
+    }))
+  );
+
+  return {
+    name: inspect.Name,
+    driver: inspect.Driver,
+    subnets: (inspect.IPAM?.Config || [])
+      .map((c: any) => c.Subnet)
+      .filter(Boolean),
+    labels: inspect.Labels || {},
+    containers,
+  };
+}
+
+/**
+ * Creates the project network with its configured driver and subnet, or
+ * checks the existing one. Throws when the network belongs to something else
+ * and returns warnings for settings the existing network doesn't match.
+ */
+export async function ensureProjectNetwork(
+  dockerClient: DockerClient,
+  config: IopConfig
+): Promise<string[]> {
+  const name = getProjectNetworkName(config.name);
+  const existing = await getProjectNetworkInfo(dockerClient, name);
+
+  if (existing) {
+    const check = checkProjectNetwork(config.name, existing, config.network);
+    if (check.conflict) {
+      throw new Error(check.conflict);
+    }
+    return check.warnings;
+  }
+
+  const created = await dockerClient.createNetwork({
+    name,
+    driver: config.network?.driver,
+    subnet: config.network?.subnet,
+    labels: getProjectNetworkLabels(config.name),
+  });
+  if (!created) {
+    throw new Error(`Failed to create network ${name}`);
+  }
+  return [];
+}
+
+/**
+ * Removes the project network once the project has nothing left on the
+ * server, detaching the proxy first. Returns whether it was removed.
+ */
+export async function removeProjectNetwork(
+  dockerClient: DockerClient,
+  projectName: string
+): Promise<boolean> {
+  const name = getProjectNetworkName(projectName);
+  const network = await getProjectNetworkInfo(dockerClient, name);
+  if (!network || !canRemoveProjectNetwork(projectName, network)) {
+    return false;
+  }
+
+  if (network.containers.some((c) => c.name === PROXY_CONTAINER)) {
+    await dockerClient.disconnectContainerFromNetwork(PROXY_CONTAINER, name);
+  }
+  return dockerClient.removeNetwork(name);
+}
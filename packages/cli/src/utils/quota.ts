@@ -0,0 +1,46 @@
+import { ResourcesConfig, ServiceEntry } from "../config/types";
+import { ProxyQuotaContainer, ProxyQuotaWorkload } from "../proxy";
+import { getDeploymentStrategy } from "./service-utils";
+
+const BYTE_UNITS: Record<string, number> = { "": 1, b: 1, k: 1024, m: 1024 ** 2, g: 1024 ** 3 };
+
+/**
+ * Parses a Docker byte size such as "512m" or "1g" into bytes
+ */
+export function parseByteSize(size: string): number | undefined {
+  const match = size.trim().toLowerCase().match(/^(\d+(?:\.\d+)?)([bkmg]?)$/);
+  if (!match) {
+    return undefined;
+  }
+  return Math.floor(parseFloat(match[1]) * BYTE_UNITS[match[2]]);
+}
+
+function quotaContainer(resources?: ResourcesConfig): ProxyQuotaContainer {
+  return {
+    memory: resources?.memory ? parseByteSize(resources.memory) : undefined,
+    cpus: resources?.cpus,
+  };
+}
+
+/**
+ * The containers deploying each service leaves running, with their limits,
+ * for the proxy to check against the project's quota. Zero-downtime services
+ * run their replicas, each with its sidecars.
+ */
+export function buildQuotaWorkloads(services: ServiceEntry[]): ProxyQuotaWorkload[] {
+  return services.map((service) => {
+    if (getDeploymentStrategy(service) !== "zero-downtime") {
+      return { service: service.name, containers: [quotaContainer(service.resources)] };
+    }
+
+    const replica = [
+      quotaContainer(service.resources),
+      ...Object.values(service.sidecars || {}).map((sidecar) => quotaContainer(sidecar.resources)),
+    ];
+    const containers: ProxyQuotaContainer[] = [];
+    for (let i = 0; i < (service.replicas || 1); i++) {
+      containers.push(...replica);
+    }
+    return { service: service.name, containers };
+  });
+}
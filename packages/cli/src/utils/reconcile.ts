@@ -0,0 +1,204 @@
+import { ServiceEntry } from "../config/types";
+import { ProxyHostInfo, STATUS_PAGE_APP } from "../proxy";
+import type { ContainerInventoryEntry } from "../commands/ps";
+import { ProjectNetworkInfo, canRemoveProjectNetwork } from "./project-network";
+import { getProjectNetworkName } from "./index";
+import { generateAppSslipDomain, shouldUseSslip } from "./sslip";
+import { requiresZeroDowntimeDeployment } from "./service-utils";
+
+export type DoctorFindingKind =
+  | "orphaned-container" // Not referenced by iop.yml or by its app container
+  | "leftover-container" // Blue-green container of the inactive color
+  | "stale-host" // Proxy host of the project that nothing in iop.yml or on the server backs
+  | "unrouted-host" // Host in iop.yml the proxy doesn't route although the app runs
+  | "unattached-network" // Project network nothing but the proxy is attached to
+  | "unreferenced-cert"; // Certificate files on the proxy that no host uses
+
+export interface DoctorFinding {
+  kind: DoctorFindingKind;
+  server: string;
+  resource: string; // Container, hostname, network or certificate directory
+  detail: string;
+  fix: "remove" | "adopt"; // Adopting routes a host to the running app
+  app?: string; // App an unrouted host belongs to
+  project?: string; // Project an unattached network belongs to
+}
+
+/**
+ * Gets the hosts the proxy should route for an app, the generated
+ * app.iop.run domain when iop.yml names none
+ */
+export function getExpectedHosts(projectName: string, service: ServiceEntry): string[] {
+  if (!service.proxy) {
+    return [];
+  }
+  return shouldUseSslip(service.proxy.hosts)
+    ? [generateAppSslipDomain(projectName, service.name, service.server)]
+    : service.proxy.hosts!;
+}
+
+/**
+ * Turns the orphans flagged by iop ps into findings. Containers of the
+ * inactive color are leftovers of a switch, the rest are orphans.
+ */
+export function findContainerIssues(
+  server: string,
+  containers: ContainerInventoryEntry[]
+): DoctorFinding[] {
+  return containers
+    .filter((container) => container.orphan)
+    .map((container): DoctorFinding => ({
+      kind: container.color && container.orphan!.startsWith("inactive")
+        ? "leftover-container"
+        : "orphaned-container",
+      server,
+      resource: container.name,
+      detail: `${container.orphan}, ${container.state}`,
+      fix: "remove",
+    }));
+}
+
+/**
+ * Compares the project's hosts in the proxy with iop.yml and the containers
+ * on the server. A host is stale when its app is no longer configured on the
+ * server, iop.yml no longer names it, or no container carries the network
+ * alias it targets. A configured host the proxy doesn't know is adopted when
+ * the app's containers are running. Custom domains and on-demand hosts are
+ * managed through the proxy and left alone.
+ */
+export function findHostIssues(
+  server: string,
+  projectName: string,
+  services: ServiceEntry[],
+  hosts: Record<string, ProxyHostInfo>,
+  containers: ContainerInventoryEntry[]
+): DoctorFinding[] {
+  const findings: DoctorFinding[] = [];
+  const aliases = new Set(
+    containers
+      .filter((container) => container.project === projectName)
+      .flatMap((container) => container.aliases)
+  );
+  const apps = services.filter(
+    (service) => service.proxy && requiresZeroDowntimeDeployment(service)
+  );
+
+  for (const [hostname, host] of Object.entries(hosts)) {
+    // Status page hosts are served by the proxy itself
+    if (
+      host.project !== projectName ||
+      host.alias_of ||
+      host.on_demand ||
+      host.app === STATUS_PAGE_APP
+    ) {
+      continue;
+    }
+
+    const app = apps.find((service) => service.name === host.app);
+    const targetAlias = host.target.substring(0, host.target.lastIndexOf(":"));
+    let detail: string | undefined;
+    if (!app) {
+      detail = `app ${host.app} isn't in iop.yml for this server`;
+    } else if (!getExpectedHosts(projectName, app).includes(hostname)) {
+      detail = `no longer a host of ${app.name} in iop.yml`;
+    } else if (!aliases.has(targetAlias)) {
+      detail = `no container answers to ${host.target}`;
+    }
+    if (detail) {
+      findings.push({ kind: "stale-host", server, resource: hostname, detail, fix: "remove" });
+    }
+  }
+
+  for (const app of apps) {
+    const running = containers.some(
+      (container) =>
+        container.project === projectName &&
+        container.type === "app" &&
+        container.entry === app.name &&
+        container.state === "running" &&
+        container.aliases.includes(`${projectName}-${app.name}`)
+    );
+    if (!running) {
+      continue;
+    }
+    for (const hostname of getExpectedHosts(projectName, app)) {
+      if (!hosts[hostname]) {
+        findings.push({
+          kind: "unrouted-host",
+          server,
+          resource: hostname,
+          detail: `${app.name} runs but the proxy doesn't route it`,
+          fix: "adopt",
+          app: app.name,
+        });
+      }
+    }
+  }
+
+  return findings;
+}
+
+/**
+ * Finds iop-managed project networks that nothing but the proxy is attached
+ * to, of any project, as left behind when a project is gone from a server
+ */
+export function findNetworkIssues(
+  server: string,
+  networks: ProjectNetworkInfo[]
+): DoctorFinding[] {
+  return networks
+    .filter((network) => {
+      const owner = network.labels["iop.project"];
+      return (
+        !!owner &&
+        network.name === getProjectNetworkName(owner) &&
+        canRemoveProjectNetwork(owner, network)
+      );
+    })
+    .map((network): DoctorFinding => ({
+      kind: "unattached-network",
+      server,
+      resource: network.name,
+      detail: `no containers of project ${network.labels["iop.project"]} are attached`,
+      fix: "remove",
+      project: network.labels["iop.project"],
+    }));
+}
+
+/**
+ * Turns the certificate directories no proxy host uses into one finding, as
+ * the proxy deletes them together
+ */
+export function findCertificateIssues(server: string, dirs: string[]): DoctorFinding[] {
+  if (dirs.length === 0) {
+    return [];
+  }
+  return [
+    {
+      kind: "unreferenced-cert",
+      server,
+      resource: dirs.length === 1 ? "1 certificate" : `${dirs.length} certificates`,
+      detail: `no host uses ${dirs.join(", ")}`,
+      fix: "remove",
+    },
+  ];
+}
+
+/**
+ * Describes what fixing a finding does, for the confirmation prompt
+ */
+export function describeFix(finding: DoctorFinding): string {
+  switch (finding.kind) {
+    case "orphaned-container":
+    case "leftover-container":
+      return `Remove container ${finding.resource}`;
+    case "stale-host":
+      return `Remove ${finding.resource} from the proxy`;
+    case "unrouted-host":
+      return `Route ${finding.resource} to ${finding.app}`;
+    case "unattached-network":
+      return `Remove network ${finding.resource}`;
+    case "unreferenced-cert":
+      return `Delete ${finding.resource} no host uses`;
+  }
+}
@@ -0,0 +1,162 @@
+import {
+  EcrCredentials,
+  IopConfig,
+  IopSecrets,
+  ServiceEntry,
+} from "../config/types";
+import { getRegistryPassword } from "./builtin-registry";
+
+export const DEFAULT_REGISTRY = "docker.io";
+
+export type RegistryCredentials =
+  | { registry: string; kind: "password"; username: string; password: string }
+  | {
+      registry: string;
+      kind: "ecr";
+      region: string;
+      accessKeyId: string;
+      secretAccessKey: string;
+    };
+
+/**
+ * Returns the registry host an image reference points to
+ * e.g. "ghcr.io/acme/web:1.0" -> "ghcr.io", "postgres:15" -> "docker.io"
+ */
+export function getImageRegistry(image: string): string {
+  const firstSlash = image.indexOf("/");
+  if (firstSlash === -1) {
+    return DEFAULT_REGISTRY;
+  }
+
+  const firstComponent = image.substring(0, firstSlash);
+  // Same rule Docker uses: a registry host contains a dot or a port, or is localhost
+  if (
+    firstComponent.includes(".") ||
+    firstComponent.includes(":") ||
+    firstComponent === "localhost"
+  ) {
+    return firstComponent;
+  }
+
+  return DEFAULT_REGISTRY;
+}
+
+/**
+ * Checks if a registry host is an Amazon ECR registry
+ */
+export function isEcrRegistry(registry: string): boolean {
+  return /^\d+\.dkr\.ecr\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$/.test(registry);
+}
+
+/**
+ * Normalizes registry hosts so "https://index.docker.io/v1/" and "docker.io" compare equal
+ */
+function normalizeRegistry(registry: string): string {
+  const host = registry.replace(/^https?:\/\//, "").replace(/\/.*$/, "");
+  if (host === "index.docker.io" || host === "registry-1.docker.io") {
+    return DEFAULT_REGISTRY;
+  }
+  return host;
+}
+
+/**
+ * Resolves ECR credentials from the secrets store
+ */
+function resolveEcrCredentials(
+  registry: string,
+  ecr: EcrCredentials,
+  secrets: IopSecrets
+): RegistryCredentials {
+  const accessKeyId = secrets[ecr.access_key_secret];
+  const secretAccessKey = secrets[ecr.secret_key_secret];
+  if (!accessKeyId || !secretAccessKey) {
+    throw new Error(
+      `ECR credentials for ${registry} not found in secrets (${ecr.access_key_secret}, ${ecr.secret_key_secret})`
+    );
+  }
+  return {
+    registry,
+    kind: "ecr",
+    region: ecr.region,
+    accessKeyId,
+    secretAccessKey,
+  };
+}
+
+/**
+ * Works out which credentials, if any, are needed to pull a service's image.
+ * Service-level registry settings win over the built-in registry and the global
+ * docker section, which only apply when they target the registry the image
+ * lives in.
+ */
+export function resolveRegistryCredentials(
+  entry: ServiceEntry,
+  config: IopConfig,
+  secrets: IopSecrets,
+  image: string = entry.image || ""
+): RegistryCredentials | null {
+  const imageRegistry = getImageRegistry(image);
+
+  if (entry.registry) {
+    const registry = normalizeRegistry(entry.registry.url || imageRegistry);
+
+    if (entry.registry.ecr) {
+      return resolveEcrCredentials(registry, entry.registry.ecr, secrets);
+    }
+
+    const password = secrets[entry.registry.password_secret!];
+    if (!password) {
+      throw new Error(
+        `Registry password secret "${entry.registry.password_secret}" for ${entry.name} not found in secrets`
+      );
+    }
+    return {
+      registry,
+      kind: "password",
+      username: entry.registry.username!,
+      password,
+    };
+  }
+
+  // Images pushed to the built-in registry
+  if (
+    config.registry &&
+    normalizeRegistry(imageRegistry) === normalizeRegistry(config.registry.host)
+  ) {
+    return {
+      registry: config.registry.host,
+      kind: "password",
+      username: config.registry.username,
+      password: getRegistryPassword(config.registry, secrets),
+    };
+  }
+
+  const globalConfig = config.docker;
+  if (!globalConfig) {
+    return null;
+  }
+
+  const globalRegistry = normalizeRegistry(
+    globalConfig.registry || DEFAULT_REGISTRY
+  );
+  if (globalRegistry !== normalizeRegistry(imageRegistry)) {
+    return null;
+  }
+
+  if (globalConfig.ecr) {
+    return resolveEcrCredentials(globalRegistry, globalConfig.ecr, secrets);
+  }
+
+  const passwordSecret =
+    globalConfig.password_secret || "DOCKER_REGISTRY_PASSWORD";
+  if (globalConfig.username && secrets[passwordSecret]) {
+    return {
+      registry: globalRegistry,
+      kind: "password",
+      username: globalConfig.username,
+      password: secrets[passwordSecret],
+    };
+  }
+
+  return null;
+}
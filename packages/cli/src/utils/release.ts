@@ -1,5 +1,6 @@
 import { exec } from "child_process";
 import { promisify } from "util";
+import { logProgress } from "./output";
 
 const execAsync = promisify(exec);
 
@@ -45,6 +46,6 @@ export async function generateReleaseId(): Promise<string> {
   }
   // Fallback to timestamp if Git checks fail for other reasons (e.g., not a git repo)
   const timestampId = Date.now().toString();
-  console.log(`Using timestamp for release ID: ${timestampId}`);
+  logProgress(`Using timestamp for release ID: ${timestampId}`);
   return timestampId;
 }
@@ -0,0 +1,95 @@
+import { IopSecrets, ServiceEntry } from "../config/types";
+
+// Secret files live on the server's tmpfs so they never touch its disk. A
+// reboot clears them, 'iop start' or 'iop deploy' writes them again.
+export const SECRET_FILES_DIR = "/dev/shm/iop-secrets";
+
+// Label naming the directory a container's secret files are mounted from.
+// Autoscaled copies of a container inherit it along with its mounts.
+export const SECRET_FILES_LABEL = "iop.secret-files";
+
+/**
+ * A secret file with its content, ready to be written for a container
+ */
+export interface ContainerSecretFile {
+  path: string; // Where the file is mounted in the container
+  mode: string;
+  content: Buffer;
+}
+
+/**
+ * Looks up the secrets behind a service's secret_files
+ */
+export function resolveSecretFiles(
+  entry: Pick<ServiceEntry, "name" | "secret_files">,
+  secrets: IopSecrets
+): ContainerSecretFile[] {
+  return (entry.secret_files || []).map((file) => {
+    const content = secrets[file.secret];
+    if (content === undefined) {
+      throw new Error(
+        `Secret "${file.secret}" for the file ${file.path} of ${entry.name} is not defined in .iop/secrets`
+      );
+    }
+    return {
+      path: file.path,
+      mode: file.mode || "0444",
+      content: Buffer.from(content, file.encoding === "base64" ? "base64" : "utf-8"),
+    };
+  });
+}
+
+/**
+ * Directory on the server holding the secret files of a container
+ */
+export function getSecretFilesDir(containerName: string): string {
+  return `${SECRET_FILES_DIR}/${containerName}`;
+}
+
+/**
+ * Where on the server a secret file is written, one file per mount path
+ */
+export function getSecretFileSource(dir: string, filePath: string): string {
+  return `${dir}/${encodeURIComponent(filePath.substring(1))}`;
+}
+
+/**
+ * Builds the docker run flags mounting a container's secret files read-only.
+ * Unlike -v, --mount fails when the file is missing instead of creating an
+ * empty directory in its place.
+ */
+export function buildSecretFileMountFlags(
+  containerName: string,
+  files: ContainerSecretFile[]
+): string[] {
+  const dir = getSecretFilesDir(containerName);
+  return files.map(
+    (file) =>
+      `--mount type=bind,source=${getSecretFileSource(dir, file.path)},target=${file.path},readonly`
+  );
+}
+
+/**
+ * Builds the shell command writing secret files to a directory, failing on
+ * the first file that can't be written. The content goes through base64 so
+ * any bytes survive the heredoc.
+ */
+export function buildWriteSecretFilesCommand(
+  dir: string,
+  files: ContainerSecretFile[]
+): string {
+  const writes = files.map((file) => {
+    const source = getSecretFileSource(dir, file.path);
+    const encoded = file.content.toString("base64").replace(/(.{76})/g, "$1\n");
+    return `base64 -d > ${source} << 'EOF'
+${encoded}
+EOF
+chmod ${file.mode} ${source}`;
+  });
+  return `(set -e
+umask 077
+rm -rf ${dir}
+mkdir -p ${dir}
+${writes.join("\n")}
+)`;
+}
@@ -0,0 +1,419 @@
+import { HardeningConfig, IopConfig } from "../config/types";
+import { SSHClient } from "../ssh";
+import { MESH_DEFAULT_PORT, MESH_INTERFACE } from "./mesh";
+import { shellQuote } from "./shell";
+
+export const DEFAULT_DEPLOY_USER = "iop";
+export const FAIL2BAN_JAIL_PATH = "/etc/fail2ban/jail.d/iop-sshd.conf";
+export const AUTO_UPGRADES_PATH = "/etc/apt/apt.conf.d/20auto-upgrades";
+export const NFTABLES_RULES_PATH = "/etc/iop/firewall.nft";
+export const NFTABLES_UNIT_PATH = "/etc/systemd/system/iop-firewall.service";
+// Rules ufw was last configured with, so unchanged rules aren't reapplied
+export const UFW_RULES_PATH = "/etc/iop/ufw-rules";
+
+export type HardeningStepName =
+  | "firewall"
+  | "fail2ban"
+  | "auto_updates"
+  | "deploy_user";
+
+export interface HardeningStepResult {
+  step: HardeningStepName;
+  status: "configured" | "unchanged" | "skipped";
+  detail: string;
+}
+
+const STEP_LABELS: Record<HardeningStepName, string> = {
+  firewall: "Firewall",
+  fail2ban: "fail2ban",
+  auto_updates: "Automatic security updates",
+  deploy_user: "Deploy user",
+};
+
+/**
+ * Returns the SSH port deploys connect on, which the firewall and fail2ban protect
+ */
+export function getSshPort(config: IopConfig): number {
+  return config.ssh?.port ?? 22;
+}
+
+/**
+ * Returns the ports the firewall allows: SSH, HTTP and HTTPS, the WireGuard
+ * port of the mesh plus any extra ones from hardening.allow, each as
+ * port/protocol, e.g. "22/tcp"
+ */
+export function getAllowedPorts(config: IopConfig): string[] {
+  const ports = [`${getSshPort(config)}/tcp`, "80/tcp", "443/tcp"];
+  if (config.mesh) {
+    ports.push(`${config.mesh.port ?? MESH_DEFAULT_PORT}/udp`);
+  }
+  for (const entry of config.hardening?.allow ?? []) {
+    const port = String(entry);
+    ports.push(port.includes("/") ? port : `${port}/tcp`);
+  }
+  return [...new Set(ports)];
+}
+
+/**
+ * Returns the interfaces the firewall accepts everything on: the mesh
+ * interface, as only other servers can send traffic through it
+ */
+export function getTrustedInterfaces(config: IopConfig): string[] {
+  return config.mesh ? [MESH_INTERFACE] : [];
+}
+
+/**
+ * Returns the firewall to configure, or undefined when it is turned off
+ */
+export function getFirewallBackend(
+  hardening: HardeningConfig
+): "ufw" | "nftables" | undefined {
+  if (hardening.firewall === false) {
+    return undefined;
+  }
+  return hardening.firewall === "nftables" ? "nftables" : "ufw";
+}
+
+/**
+ * Builds the commands that make ufw deny everything incoming except the
+ * allowed ports. The reset drops rules added by hand, as the firewall is
+ * meant to allow these ports only.
+ */
+export function buildUfwCommands(
+  ports: string[],
+  interfaces: string[] = []
+): string[] {
+  return [
+    "ufw --force reset",
+    "ufw default deny incoming",
+    "ufw default allow outgoing",
+    ...ports.map((port) => `ufw allow ${port}`),
+    ...interfaces.map((name) => `ufw allow in on ${name}`),
+    "ufw --force enable",
+  ];
+}
+
+/**
+ * Builds an nftables ruleset in its own table that drops incoming traffic
+ * except replies, loopback, ICMP, trusted interfaces and the allowed ports.
+ * Deleting the table first makes loading it again replace the previous rules.
+ */
+export function buildNftablesRuleset(
+  ports: string[],
+  interfaces: string[] = []
+): string {
+  const byProtocol: Record<string, string[]> = {};
+  for (const entry of ports) {
+    const [port, protocol] = entry.split("/");
+    byProtocol[protocol] = [...(byProtocol[protocol] || []), port];
+  }
+
+  const allowRules = Object.entries(byProtocol).map(
+    ([protocol, list]) =>
+      `    ${protocol} dport { ${list.join(", ")} } accept\n`
+  );
+
+  return (
+    "table inet iop_firewall\n" +
+    "delete table inet iop_firewall\n" +
+    "table inet iop_firewall {\n" +
+    "  chain input {\n" +
+    "    type filter hook input priority 0; policy drop;\n" +
+    "    ct state established,related accept\n" +
+    "    ct state invalid drop\n" +
+    "    iif lo accept\n" +
+    "    meta l4proto { icmp, ipv6-icmp } accept\n" +
+    interfaces.map((name) => `    iifname "${name}" accept\n`).join("") +
+    allowRules.join("") +
+    "  }\n" +
+    "}\n"
+  );
+}
+
+/**
+ * Builds the systemd unit that loads the nftables rules at boot
+ */
+export function buildNftablesUnit(): string {
+  return `[Unit]
+Description=iop firewall
+Wants=network-pre.target
+Before=network-pre.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/sbin/nft -f ${NFTABLES_RULES_PATH}
+
+[Install]
+WantedBy=multi-user.target
+`;
+}
+
+/**
+ * Builds the fail2ban jail that bans addresses after repeated SSH login failures
+ */
+export function buildFail2banJail(sshPort: number): string {
+  return `[sshd]
+enabled = true
+port = ${sshPort}
+backend = systemd
+maxretry = 5
+findtime = 10m
+bantime = 1h
+`;
+}
+
+/**
+ * Builds the apt configuration that installs security updates daily. The
+ * unattended-upgrades package defaults to the security archive only.
+ */
+export function buildAutoUpgradesConfig(): string {
+  return `APT::Periodic::Update-Package-Lists "1";
+APT::Periodic::Unattended-Upgrade "1";
+`;
+}
+
+/**
+ * Formats a step result for the setup output,
+ * e.g. "Firewall: configured (ufw allows 22/tcp, 80/tcp, 443/tcp)"
+ */
+export function formatHardeningResult(result: HardeningStepResult): string {
+  return `${STEP_LABELS[result.step]}: ${result.status} (${result.detail})`;
+}
+
+/**
+ * Runs commands as root, through sudo unless already connected as root
+ */
+export class RootShell {
+  constructor(private sshClient: SSHClient, private sudo: string) {}
+
+  static async connect(sshClient: SSHClient): Promise<RootShell> {
+    const uid = (await sshClient.exec("id -u")).trim();
+    return new RootShell(sshClient, uid === "0" ? "" : "sudo ");
+  }
+
+  exec(command: string): Promise<string> {
+    return this.sshClient.exec(`${this.sudo}${command}`);
+  }
+
+  async succeeds(command: string): Promise<boolean> {
+    try {
+      await this.exec(command);
+      return true;
+    } catch {
+      return false;
+    }
+  }
+
+  async readFile(path: string): Promise<string> {
+    return this.exec(`cat ${path} 2>/dev/null || true`);
+  }
+
+  /**
+   * Writes a file unless it already has the content, reporting whether it changed
+   */
+  async writeFile(path: string, content: string): Promise<boolean> {
+    if ((await this.readFile(path)).trim() === content.trim()) {
+      return false;
+    }
+    await this.exec(`mkdir -p $(dirname ${path})`);
+    await this.sshClient.exec(
+      `${this.sudo}tee ${path} > /dev/null <<'IOP_HARDENING_EOF'\n${content}IOP_HARDENING_EOF`
+    );
+    return true;
+  }
+
+  /**
+   * Installs missing apt packages, reporting whether any were missing
+   */
+  async installPackages(packages: string[]): Promise<boolean> {
+    const missing: string[] = [];
+    for (const pkg of packages) {
+      if (!(await this.succeeds(`dpkg -s ${pkg} > /dev/null 2>&1`))) {
+        missing.push(pkg);
+      }
+    }
+    if (missing.length === 0) {
+      return false;
+    }
+    await this.exec("apt-get update");
+    await this.exec(
+      `env DEBIAN_FRONTEND=noninteractive apt-get install -y ${missing.join(" ")}`
+    );
+    return true;
+  }
+
+  /**
+   * Enables and starts a service unless it already runs, reporting whether it did
+   */
+  async enableService(name: string): Promise<boolean> {
+    const enabled = await this.succeeds(`systemctl is-enabled --quiet ${name}`);
+    const active = await this.succeeds(`systemctl is-active --quiet ${name}`);
+    if (enabled && active) {
+      return false;
+    }
+    await this.exec(`systemctl enable --now ${name}`);
+    return true;
+  }
+}
+
+async function hardenFirewall(
+  shell: RootShell,
+  config: IopConfig,
+  backend: "ufw" | "nftables"
+): Promise<HardeningStepResult> {
+  const ports = getAllowedPorts(config);
+  const interfaces = getTrustedInterfaces(config);
+  const detail = `${backend} allows ${[...ports, ...interfaces].join(", ")}`;
+
+  if (backend === "ufw") {
+    const installed = await shell.installPackages(["ufw"]);
+    const commands = buildUfwCommands(ports, interfaces);
+    const rules = commands.join("\n") + "\n";
+    const active = (await shell.exec("ufw status")).includes("Status: active");
+    const applied = (await shell.readFile(UFW_RULES_PATH)).trim() === rules.trim();
+    if (!active || !applied) {
+      for (const command of commands) {
+        await shell.exec(command);
+      }
+      await shell.writeFile(UFW_RULES_PATH, rules);
+    }
+    const changed = installed || !active || !applied;
+    return { step: "firewall", status: changed ? "configured" : "unchanged", detail };
+  }
+
+  let changed = await shell.installPackages(["nftables"]);
+  const rulesChanged = await shell.writeFile(
+    NFTABLES_RULES_PATH,
+    buildNftablesRuleset(ports, interfaces)
+  );
+  const unitChanged = await shell.writeFile(NFTABLES_UNIT_PATH, buildNftablesUnit());
+  const loaded = await shell.succeeds("nft list table inet iop_firewall > /dev/null 2>&1");
+  if (rulesChanged || !loaded) {
+    await shell.exec(`nft -f ${NFTABLES_RULES_PATH}`);
+  }
+  if (unitChanged) {
+    await shell.exec("systemctl daemon-reload");
+  }
+  const enabled = await shell.succeeds("systemctl is-enabled --quiet iop-firewall");
+  if (!enabled) {
+    await shell.exec("systemctl enable iop-firewall");
+  }
+  changed = changed || rulesChanged || unitChanged || !loaded || !enabled;
+  return { step: "firewall", status: changed ? "configured" : "unchanged", detail };
+}
+
+async function hardenFail2ban(
+  shell: RootShell,
+  config: IopConfig
+): Promise<HardeningStepResult> {
+  const installed = await shell.installPackages(["fail2ban", "python3-systemd"]);
+  const jailChanged = await shell.writeFile(
+    FAIL2BAN_JAIL_PATH,
+    buildFail2banJail(getSshPort(config))
+  );
+  const started = await shell.enableService("fail2ban");
+  if (jailChanged && !started) {
+    await shell.exec("systemctl restart fail2ban");
+  }
+  const changed = installed || jailChanged || started;
+  return {
+    step: "fail2ban",
+    status: changed ? "configured" : "unchanged",
+    detail: `bans SSH clients on port ${getSshPort(config)} for 1h after 5 failed logins`,
+  };
+}
+
+async function hardenAutoUpdates(shell: RootShell): Promise<HardeningStepResult> {
+  const installed = await shell.installPackages(["unattended-upgrades"]);
+  const configChanged = await shell.writeFile(
+    AUTO_UPGRADES_PATH,
+    buildAutoUpgradesConfig()
+  );
+  return {
+    step: "auto_updates",
+    status: installed || configChanged ? "configured" : "unchanged",
+    detail: "unattended-upgrades installs security updates daily",
+  };
+}
+
+async function hardenDeployUser(
+  shell: RootShell,
+  config: IopConfig
+): Promise<HardeningStepResult> {
+  const username = config.ssh?.username || DEFAULT_DEPLOY_USER;
+  if (username === "root") {
+    return {
+      step: "deploy_user",
+      status: "skipped",
+      detail: "ssh.username is root, set it to a non-root user to deploy without root",
+    };
+  }
+
+  let changed = false;
+  if (!(await shell.succeeds(`id ${shellQuote(username)} > /dev/null 2>&1`))) {
+    await shell.exec(`useradd -m -s /bin/bash ${shellQuote(username)}`);
+    changed = true;
+  }
+
+  const groups = await shell.exec(`id -nG ${shellQuote(username)}`);
+  if (!groups.trim().split(/\s+/).includes("docker")) {
+    await shell.exec(`usermod -aG docker ${shellQuote(username)}`);
+    changed = true;
+  }
+
+  // Keys are only copied when the user has none, never overwritten
+  const home = `/home/${username}`;
+  const authorizedKeys = `${home}/.ssh/authorized_keys`;
+  if (
+    !(await shell.succeeds(`test -s ${shellQuote(authorizedKeys)}`)) &&
+    (await shell.succeeds("test -s /root/.ssh/authorized_keys"))
+  ) {
+    await shell.exec(`mkdir -p ${shellQuote(`${home}/.ssh`)}`);
+    await shell.exec(`cp /root/.ssh/authorized_keys ${shellQuote(authorizedKeys)}`);
+    await shell.exec(`chown -R ${shellQuote(`${username}:${username}`)} ${shellQuote(`${home}/.ssh`)}`);
+    await shell.exec(`chmod 700 ${shellQuote(`${home}/.ssh`)}`);
+    await shell.exec(`chmod 600 ${shellQuote(authorizedKeys)}`);
+    changed = true;
+  }
+
+  return {
+    step: "deploy_user",
+    status: changed ? "configured" : "unchanged",
+    detail: `${username} is in the docker group`,
+  };
+}
+
+/**
+ * Applies the hardening steps enabled in the config to a server. Every step
+ * checks the server first and only changes what differs, so it is safe to
+ * run on each deploy.
+ */
+export async function hardenServer(
+  sshClient: SSHClient,
+  config: IopConfig
+): Promise<HardeningStepResult[]> {
+  const hardening = config.hardening;
+  if (!hardening) {
+    return [];
+  }
+
+  const shell = await RootShell.connect(sshClient);
+  const results: HardeningStepResult[] = [];
+
+  const firewall = getFirewallBackend(hardening);
+  if (firewall) {
+    results.push(await hardenFirewall(shell, config, firewall));
+  }
+  if (hardening.fail2ban) {
+    results.push(await hardenFail2ban(shell, config));
+  }
+  if (hardening.auto_updates) {
+    results.push(await hardenAutoUpdates(shell));
+  }
+  if (hardening.deploy_user) {
+    results.push(await hardenDeployUser(shell, config));
+  }
+
+  return results;
+}
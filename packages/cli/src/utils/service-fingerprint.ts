@@ -2,6 +2,8 @@ import * as crypto from 'crypto';
 import { exec } from 'child_process';
 import { promisify } from 'util';
 import { ServiceEntry, IopSecrets } from '../config/types';
+import { findReferences } from '../config/environment';
+import { getContainerSecurity } from '../docker';
 
 const execAsync = promisify(exec);
 
@@ -13,6 +15,7 @@ export interface ServiceFingerprint {
   // For built services
   localImageHash?: string;
   serverImageHash?: string;
+  contextHash?: string; // Hash of the build context and base images the image was built from
   
   // For external services  
   imageReference?: string;
@@ -30,6 +33,7 @@ export interface RedeploymentDecision {
 export function createServiceConfigHash(serviceEntry: ServiceEntry, secrets?: IopSecrets): string {
   const configForHash = {
     image: serviceEntry.image,
+    type: serviceEntry.type,
     ports: serviceEntry.ports?.sort() || [],
     volumes: serviceEntry.volumes?.sort() || [],
     command: serviceEntry.command,
@@ -41,9 +45,20 @@ export function createServiceConfigHash(serviceEntry: ServiceEntry, secrets?: Io
       ssl: serviceEntry.proxy.ssl,
       ssl_redirect: serviceEntry.proxy.ssl_redirect,
       forward_headers: serviceEntry.proxy.forward_headers,
+      dial_timeout: serviceEntry.proxy.dial_timeout,
       response_timeout: serviceEntry.proxy.response_timeout,
+      request_timeout: serviceEntry.proxy.request_timeout,
+      stream_idle_timeout: serviceEntry.proxy.stream_idle_timeout,
+      streaming: serviceEntry.proxy.streaming,
+      internal: serviceEntry.proxy.internal,
     } : undefined,
     health_check: serviceEntry.health_check,
+    init: serviceEntry.init,
+    resources: serviceEntry.resources,
+    security: getContainerSecurity(serviceEntry),
+    sidecars: serviceEntry.sidecars,
+    secret_files: serviceEntry.secret_files,
+    template: serviceEntry.template,
     build: serviceEntry.build ? {
       context: serviceEntry.build.context,
       dockerfile: serviceEntry.build.dockerfile,
@@ -57,12 +72,24 @@ export function createServiceConfigHash(serviceEntry: ServiceEntry, secrets?: Io
       secret: serviceEntry.environment?.secret?.sort() || [],
       // Include resolved secret values for change detection
       secretValues: secrets ? 
-        serviceEntry.environment?.secret?.reduce((acc, key) => {
+        [
+          ...(serviceEntry.environment?.secret || []),
+          ...Object.values(serviceEntry.sidecars || {}).flatMap(
+            (sidecar) => sidecar.environment?.secret || []
+          ),
+          ...(serviceEntry.init || []).flatMap(
+            (step) => step.environment?.secret || []
+          ),
+          ...(serviceEntry.environment?.plain || []).flatMap(
+            (envVar) => findReferences(envVar).secrets
+          ),
+          ...(serviceEntry.secret_files || []).map((file) => file.secret),
+        ].reduce((acc, key) => {
           if (secrets[key] !== undefined) {
             acc[key] = secrets[key];
           }
           return acc;
-        }, {} as Record<string, string>) || {} : {},
+        }, {} as Record<string, string>) : {},
     },
   };
   
@@ -183,6 +210,16 @@ export function shouldRedeploy(
   
   // For built services, check image hash (code changes) after config/secrets
   if (desired.type === 'built') {
+    // The context hash covers the files and base images, so a change is
+    // known before building. Build args are part of the config hash.
+    if (desired.contextHash && current.contextHash !== desired.contextHash) {
+      return {
+        shouldRedeploy: true,
+        reason: 'build context changed',
+        priority: 'normal'
+      };
+    }
+
     // Compare local desired image with current server image
     if (desired.localImageHash && current.serverImageHash !== desired.localImageHash) {
       return {
@@ -211,3 +248,18 @@ export function shouldRedeploy(
   };
 }
 
+
+/**
+ * Folds a build context content hash into a config hash, used when the image is
+ * built on the server and no local image hash is available for comparison
+ */
+export function combineConfigAndContextHash(
+  configHash: string,
+  contextHash: string
+): string {
+  return crypto
+    .createHash('sha256')
+    .update(`${configHash}:${contextHash}`)
+    .digest('hex')
+    .substring(0, 12);
+}
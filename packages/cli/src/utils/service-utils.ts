@@ -4,9 +4,17 @@ import { ServiceEntry } from "../config/types";
  * Determines if a service requires zero-downtime deployment based on its characteristics
  */
 export function requiresZeroDowntimeDeployment(service: ServiceEntry): boolean {
-  // Only services with proxy configuration get zero-downtime deployment
+  // Only services with proxy configuration and workers get zero-downtime deployment
   // All other services (including those with health_check or exposed ports) get stop-start
-  return Boolean(service.proxy);
+  return Boolean(service.proxy) || isWorker(service);
+}
+
+/**
+ * Checks if a service is a worker, an app without an HTTP endpoint whose
+ * deployments wait for container health instead of proxy health checks
+ */
+export function isWorker(service: ServiceEntry): boolean {
+  return service.type === "worker";
 }
 
 /**
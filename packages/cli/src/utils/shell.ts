@@ -0,0 +1,7 @@
+/**
+ * Quotes a value for safe use inside a POSIX shell command, so spaces,
+ * metacharacters and globs like *.example.com reach the remote side unchanged
+ */
+export function shellQuote(value: string): string {
+  return `'${value.replace(/'/g, "'\\''")}'`;
+}
@@ -0,0 +1,36 @@
+import { IopConfig } from "../config/types";
+import { IopProxyClient } from "../proxy";
+
+/**
+ * Returns the server whose proxy serves the status page
+ */
+export function getStatusPageServer(config: IopConfig): string | undefined {
+  if (!config.status_page) {
+    return undefined;
+  }
+  if (config.status_page.server) {
+    return config.status_page.server;
+  }
+  const services = Array.isArray(config.services)
+    ? config.services
+    : Object.values(config.services || {});
+  return services[0]?.server;
+}
+
+/**
+ * Stores the project's status page with the proxy, which deploys its host
+ * and keeps the incidents already posted
+ */
+export async function ensureStatusPage(
+  proxyClient: IopProxyClient,
+  config: IopConfig
+): Promise<boolean> {
+  const statusPage = config.status_page!;
+  return proxyClient.setStatusPage({
+    project: config.name!,
+    host: statusPage.host,
+    title: statusPage.title,
+    apps: statusPage.apps,
+    ssl: statusPage.ssl,
+  });
+}
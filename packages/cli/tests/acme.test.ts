@@ -0,0 +1,60 @@
+import { describe, it, expect } from "bun:test";
+import { buildAcmeConfig } from "../src/utils/acme";
+import { IopConfigSchema } from "../src/config/types";
+
+describe("acme", () => {
+  it("should default to no ACME settings", () => {
+    const config = IopConfigSchema.parse({ name: "blog" });
+    expect(buildAcmeConfig(config, {})).toBeNull();
+  });
+
+  it("should resolve EAB credentials from secrets", () => {
+    const config = IopConfigSchema.parse({
+      name: "blog",
+      acme: {
+        directory: "zerossl",
+        email: "ops@example.com",
+        eab_kid_secret: "ZEROSSL_EAB_KID",
+        eab_hmac_key_secret: "ZEROSSL_EAB_HMAC_KEY",
+      },
+    });
+
+    expect(
+      buildAcmeConfig(config, {
+        ZEROSSL_EAB_KID: "kid-1",
+        ZEROSSL_EAB_HMAC_KEY: "c2VjcmV0",
+      })
+    ).toEqual({
+      directory: "zerossl",
+      email: "ops@example.com",
+      eabKeyId: "kid-1",
+      eabHmacKey: "c2VjcmV0",
+    });
+  });
+
+  it("should fail when EAB settings are incomplete", () => {
+    const config = IopConfigSchema.parse({
+      name: "blog",
+      acme: { directory: "google", eab_kid_secret: "GTS_EAB_KID" },
+    });
+
+    expect(() => buildAcmeConfig(config, { GTS_EAB_KID: "kid" })).toThrow(
+      "acme.eab_kid_secret and acme.eab_hmac_key_secret must be set together"
+    );
+  });
+
+  it("should fail when a secret is missing", () => {
+    const config = IopConfigSchema.parse({
+      name: "blog",
+      acme: {
+        directory: "zerossl",
+        eab_kid_secret: "ZEROSSL_EAB_KID",
+        eab_hmac_key_secret: "ZEROSSL_EAB_HMAC_KEY",
+      },
+    });
+
+    expect(() => buildAcmeConfig(config, { ZEROSSL_EAB_KID: "kid" })).toThrow(
+      'ACME secret "ZEROSSL_EAB_HMAC_KEY" not found in secrets'
+    );
+  });
+});
@@ -0,0 +1,70 @@
+import { describe, it, expect } from "bun:test";
+import { formatAuditEntry, parseAuditArgs } from "../src/commands/audit";
+
+describe("audit", () => {
+  describe("parseAuditArgs", () => {
+    it("should collect the filters", () => {
+      const parsed = parseAuditArgs([
+        "--host",
+        "api.example.com",
+        "--action",
+        "switch",
+        "--since",
+        "24h",
+        "--limit",
+        "20",
+        "--server",
+        "server1.com",
+        "--verbose",
+      ]);
+      expect(parsed.query).toEqual({
+        host: "api.example.com",
+        action: "switch",
+        since: "24h",
+        limit: 20,
+      });
+      expect(parsed.server).toBe("server1.com");
+      expect(parsed.verboseFlag).toBe(true);
+    });
+
+    it("should default to no filters", () => {
+      expect(parseAuditArgs([])).toEqual({
+        query: {},
+        server: undefined,
+        verboseFlag: false,
+      });
+    });
+
+    it("should reject an invalid limit", () => {
+      expect(() => parseAuditArgs(["--limit", "many"])).toThrow();
+    });
+  });
+
+  describe("formatAuditEntry", () => {
+    it("should format host changes", () => {
+      expect(
+        formatAuditEntry({
+          time: "2024-05-01T12:00:03.123456Z",
+          action: "switch",
+          host: "api.example.com",
+          details: "target=api-green:3000",
+          actor: "alice@laptop",
+          source_ip: "127.0.0.1",
+        })
+      ).toBe(
+        "2024-05-01 12:00:03 UTC  switch api.example.com (target=api-green:3000) by alice@laptop from 127.0.0.1"
+      );
+    });
+
+    it("should format global changes", () => {
+      expect(
+        formatAuditEntry({
+          time: "2024-05-01T12:00:00Z",
+          action: "staging",
+          actor: "ci",
+          source_ip: "10.0.0.2",
+        })
+      ).toBe("2024-05-01 12:00:00 UTC  staging by ci from 10.0.0.2");
+    });
+  });
+});
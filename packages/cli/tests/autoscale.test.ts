@@ -0,0 +1,56 @@
+import { describe, expect, test } from "bun:test";
+import { ServiceEntry, ServiceEntryWithoutNameSchema } from "../src/config/types";
+import { toAutoscalePolicy } from "../src/commands/deploy";
+
+describe("service autoscaling", () => {
+  const parse = (autoscale: unknown) =>
+    ServiceEntryWithoutNameSchema.safeParse({
+      image: "blog-web:latest",
+      server: "server1.example.com",
+      autoscale,
+    });
+
+  test("should validate autoscale config", () => {
+    expect(
+      parse({
+        min_replicas: 2,
+        max_replicas: 6,
+        target_cpu: 70,
+        target_latency_ms: 250,
+        scale_down_cooldown: "10m",
+      }).success
+    ).toBe(true);
+  });
+
+  test("should reject configs without targets or with bad bounds", () => {
+    for (const autoscale of [
+      { max_replicas: 4 },
+      { min_replicas: 5, max_replicas: 4, target_cpu: 70 },
+      { max_replicas: 0, target_cpu: 70 },
+      { max_replicas: 4, target_cpu: 70, scale_up_cooldown: "soon" },
+    ]) {
+      expect(parse(autoscale).success).toBe(false);
+    }
+  });
+
+  test("should convert config to the proxy's policy", () => {
+    const service = {
+      name: "web",
+      server: "server1.example.com",
+      replicas: 2,
+      autoscale: { max_replicas: 6, target_requests_per_second: 50, target_concurrency: 8 },
+    } as ServiceEntry;
+
+    expect(toAutoscalePolicy(service)).toEqual({
+      min_replicas: 2,
+      max_replicas: 6,
+      target_cpu: undefined,
+      target_request_rate: 50,
+      target_latency_ms: undefined,
+      target_concurrency: 8,
+      scale_up_cooldown: undefined,
+      scale_down_cooldown: undefined,
+    });
+    expect(toAutoscalePolicy({ ...service, autoscale: undefined })).toBeNull();
+  });
+});
@@ -0,0 +1,131 @@
+import { describe, expect, test } from "bun:test";
+import { IopConfig, ServiceEntry, ServiceEntryWithoutNameSchema } from "../src/config/types";
+import { toProxyBake } from "../src/commands/deploy";
+import { DockerClient } from "../src/docker";
+import { IopProxyClient } from "../src/proxy";
+import { SSHClient } from "../src/ssh";
+import { validateConfig } from "../src/utils/config-validator";
+
+const web = {
+  name: "web",
+  image: "shop",
+  server: "1.2.3.4",
+  proxy: {
+    hosts: ["shop.example.com"],
+    app_port: 3000,
+    bake: { duration: "5m", max_error_rate: 0.05, max_latency: "500ms" },
+  },
+} as unknown as ServiceEntry;
+
+const switched = {
+  success: true,
+  newColor: "green" as const,
+  deployedContainers: ["shop-web-green"],
+  previousColor: "blue" as const,
+  previousContainers: ["shop-web-blue"],
+};
+
+describe("baked deploys", () => {
+  test("should validate bake config", () => {
+    const parse = (bake: unknown) =>
+      ServiceEntryWithoutNameSchema.safeParse({
+        image: "shop",
+        server: "1.2.3.4",
+        proxy: { hosts: ["shop.example.com"], bake },
+      });
+
+    expect(parse(web.proxy!.bake).success).toBe(true);
+    for (const bake of [
+      {},
+      { duration: "soon" },
+      { duration: "5m", max_error_rate: 1.5 },
+      { duration: "5m", min_requests: -1 },
+    ]) {
+      expect(parse(bake).success).toBe(false);
+    }
+  });
+
+  test("should bake the switch with the previous containers", () => {
+    expect(toProxyBake(web, "shop", "shop-network", switched)).toEqual({
+      duration: "5m",
+      max_error_rate: 0.05,
+      max_latency: "500ms",
+      network: "shop-network",
+      aliases: ["web", "shop-web"],
+      color: "green",
+      containers: ["shop-web-green"],
+      previous_color: "blue",
+      previous: ["shop-web-blue"],
+    });
+
+    // Nothing to roll back to on a first deploy
+    expect(
+      toProxyBake(web, "shop", "shop-network", { success: true, newColor: "blue", deployedContainers: ["shop-web-blue"] })
+    ).toBeUndefined();
+    expect(
+      toProxyBake({ ...web, proxy: { hosts: ["shop.example.com"] } } as ServiceEntry, "shop", "shop-network", switched)
+    ).toBeUndefined();
+  });
+
+  test("should pass the bake to the proxy's switch", async () => {
+    const commands: string[] = [];
+    const dockerClient = {
+      execInContainer: async (_container: string, command: string) => {
+        commands.push(command);
+        return { success: true, output: "" };
+      },
+    } as unknown as DockerClient;
+    const proxyClient = new IopProxyClient(dockerClient, "1.2.3.4");
+
+    const bake = toProxyBake(web, "shop", "shop-network", switched);
+    expect(
+      await proxyClient.configureProxy("shop.example.com", "shop-web", 3000, "shop", "/up", "http", {}, {}, undefined, bake)
+    ).toBe(true);
+    expect(commands[0]).toEndWith(
+      "--bake '5m' --bake-max-error-rate 0.05 --bake-max-latency '500ms' --bake-network 'shop-network'" +
+        " --bake-color green --bake-previous-color blue --bake-alias 'web' --bake-alias 'shop-web'" +
+        " --bake-container 'shop-web-green' --bake-previous 'shop-web-blue'"
+    );
+  });
+
+  test("should keep previous containers on the network without aliases", async () => {
+    const commands: string[] = [];
+    const sshClient = {
+      exec: async (command: string) => {
+        commands.push(command);
+        return "";
+      },
+    } as unknown as SSHClient;
+
+    expect(await new DockerClient(sshClient).removeNetworkAliases(["shop-web-blue"], "shop-network")).toBe(true);
+    expect(commands.filter((command) => command.includes("network"))).toEqual([
+      "docker network disconnect shop-network shop-web-blue || true",
+      "docker network connect shop-network shop-web-blue",
+    ]);
+  });
+
+  test("should reject bakes the proxy can't judge", () => {
+    const config = {
+      name: "shop",
+      services: {
+        web,
+        api: {
+          image: "shop",
+          server: "1.2.3.4",
+          proxy: { hosts: ["api.example.com"], bake: { duration: "5m" } },
+        },
+        tls: {
+          image: "shop",
+          server: "1.2.3.4",
+          proxy: { hosts: ["tls.example.com"], mode: "passthrough", bake: { duration: "5m", max_error_rate: 0.05 } },
+        },
+      },
+    } as unknown as IopConfig;
+
+    const errors = validateConfig(config).filter((error) => error.type === "configuration_error");
+    expect(errors.map((error) => error.message)).toEqual([
+      "Service api bakes its deploys without limits to roll back on",
+      "Service tls bakes its deploys but its proxy mode is passthrough",
+    ]);
+  });
+});
@@ -0,0 +1,110 @@
+import { describe, it, expect, beforeEach, afterEach } from "bun:test";
+import * as fs from "fs";
+import * as os from "os";
+import * as path from "path";
+import {
+  collectBuildContextFiles,
+  hashBuildContext,
+  hashBuildInputs,
+  isIgnored,
+  parseBaseImages,
+  parseDockerignore,
+} from "../src/utils/build-context";
+
+describe("build context", () => {
+  describe("dockerignore", () => {
+    it("should skip comments and blank lines", () => {
+      expect(parseDockerignore("# comment\n\nnode_modules\n")).toHaveLength(1);
+    });
+
+    it("should match directories and their contents", () => {
+      const rules = parseDockerignore("node_modules/");
+      expect(isIgnored("node_modules", rules)).toBe(true);
+      expect(isIgnored("node_modules/react/index.js", rules)).toBe(true);
+      expect(isIgnored("src/node_modules.ts", rules)).toBe(false);
+    });
+
+    it("should not let single stars cross directories", () => {
+      const rules = parseDockerignore("*.log");
+      expect(isIgnored("debug.log", rules)).toBe(true);
+      expect(isIgnored("logs/debug.log", rules)).toBe(false);
+      expect(isIgnored("logs/debug.log", parseDockerignore("**/*.log"))).toBe(true);
+    });
+
+    it("should let later negations re-include files", () => {
+      const rules = parseDockerignore("*.md\n!README.md");
+      expect(isIgnored("CHANGELOG.md", rules)).toBe(true);
+      expect(isIgnored("README.md", rules)).toBe(false);
+    });
+  });
+
+  describe("files", () => {
+    let dir: string;
+
+    beforeEach(() => {
+      dir = fs.mkdtempSync(path.join(os.tmpdir(), "iop-context-test-"));
+      fs.mkdirSync(path.join(dir, "src"));
+      fs.mkdirSync(path.join(dir, "node_modules"));
+      fs.writeFileSync(path.join(dir, "Dockerfile"), "FROM scratch\n");
+      fs.writeFileSync(path.join(dir, "src", "index.ts"), "console.log(1);\n");
+      fs.writeFileSync(path.join(dir, "node_modules", "dep.js"), "");
+      fs.writeFileSync(path.join(dir, ".dockerignore"), "node_modules\nDockerfile\n");
+    });
+
+    afterEach(() => {
+      fs.rmSync(dir, { recursive: true, force: true });
+    });
+
+    it("should respect .dockerignore but always keep the Dockerfile", () => {
+      expect(collectBuildContextFiles(dir)).toEqual([
+        ".dockerignore",
+        "Dockerfile",
+        "src/index.ts",
+      ]);
+    });
+
+    it("should hash contents deterministically", () => {
+      const files = collectBuildContextFiles(dir);
+      const before = hashBuildContext(dir, files);
+      expect(hashBuildContext(dir, files)).toBe(before);
+
+      fs.writeFileSync(path.join(dir, "src", "index.ts"), "console.log(2);\n");
+      expect(hashBuildContext(dir, files)).not.toBe(before);
+    });
+
+    it("should change the build inputs hash when a base image changes", async () => {
+      fs.writeFileSync(path.join(dir, "Dockerfile"), "FROM node:20 AS build\nFROM nginx:1.27\nCOPY --from=build /app /app\n");
+      const files = collectBuildContextFiles(dir);
+      const digests: Record<string, string> = { "node:20": "sha256:aaa", "nginx:1.27": "sha256:bbb" };
+      const resolve = async (image: string) => digests[image] ?? null;
+
+      const before = await hashBuildInputs(dir, files, "Dockerfile", resolve);
+      expect(before).toHaveLength(12);
+      expect(await hashBuildInputs(dir, files, "Dockerfile", resolve)).toBe(before);
+
+      digests["node:20"] = "sha256:ccc";
+      expect(await hashBuildInputs(dir, files, "Dockerfile", resolve)).not.toBe(before);
+
+      delete digests["nginx:1.27"];
+      expect(await hashBuildInputs(dir, files, "Dockerfile", resolve)).toBeNull();
+    });
+  });
+
+  describe("base images", () => {
+    it("should list external images and skip stages and scratch", () => {
+      const dockerfile = [
+        "FROM --platform=linux/amd64 node:20-alpine AS deps",
+        "RUN npm ci",
+        "from deps as build",
+        "FROM scratch AS empty",
+        "FROM ghcr.io/acme/runtime@sha256:4f2a",
+        "COPY --from=build /app /app",
+      ].join("\n");
+      expect(parseBaseImages(dockerfile)).toEqual(["node:20-alpine", "ghcr.io/acme/runtime@sha256:4f2a"]);
+    });
+
+    it("should give up on images chosen by build arguments", () => {
+      expect(parseBaseImages("ARG NODE_VERSION=20\nFROM node:${NODE_VERSION}\n")).toBeNull();
+    });
+  });
+});
@@ -0,0 +1,84 @@
+import { describe, it, expect } from "bun:test";
+import {
+  CloudflareDnsClient,
+  getDnsRecordComment,
+  getDnsRecordForServer,
+  planDnsChange,
+} from "../src/utils/cloudflare-dns";
+import { DnsConfigSchema } from "../src/config/types";
+
+const config = DnsConfigSchema.parse({ provider: "cloudflare" });
+const comment = getDnsRecordComment("blog", "web");
+
+describe("cloudflare dns", () => {
+  it("should apply config defaults", () => {
+    expect(config).toEqual({
+      provider: "cloudflare",
+      api_token_secret: "CLOUDFLARE_API_TOKEN",
+      proxied: false,
+      ttl: 1,
+    });
+  });
+
+  it("should pick the record type from the server address", () => {
+    expect(getDnsRecordForServer("1.2.3.4")).toEqual({ type: "A", content: "1.2.3.4" });
+    expect(getDnsRecordForServer("2001:db8::1")).toEqual({ type: "AAAA", content: "2001:db8::1" });
+    expect(getDnsRecordForServer("web1.example.com")).toEqual({
+      type: "CNAME",
+      content: "web1.example.com",
+    });
+  });
+
+  describe("planDnsChange", () => {
+    const desired = { type: "A" as const, content: "1.2.3.4", proxied: false };
+
+    it("should create missing records", () => {
+      expect(planDnsChange([], desired, comment)).toEqual({ action: "create" });
+    });
+
+    it("should leave matching records alone", () => {
+      const record = { id: "1", type: "A", name: "blog.com", content: "1.2.3.4", proxied: false };
+      expect(planDnsChange([record], desired, comment).action).toBe("unchanged");
+    });
+
+    it("should update records iop manages", () => {
+      const record = { id: "1", type: "A", name: "blog.com", content: "5.6.7.8", comment };
+      expect(planDnsChange([record], desired, comment).action).toBe("update");
+    });
+
+    it("should not overwrite records created elsewhere", () => {
+      const record = { id: "1", type: "CNAME", name: "blog.com", content: "other.host" };
+      expect(planDnsChange([record], desired, comment).action).toBe("conflict");
+    });
+  });
+
+  it("should require the api token secret", () => {
+    expect(() => CloudflareDnsClient.fromConfig(config, {})).toThrow("CLOUDFLARE_API_TOKEN");
+  });
+
+  it("should look up the zone and create the record", async () => {
+    const calls: Array<{ method: string; url: string; body?: any }> = [];
+    const fakeFetch = (async (url: string, init: any) => {
+      calls.push({ method: init.method, url, body: init.body && JSON.parse(init.body) });
+      let result: any = [];
+      if (url.includes("/zones?name=example.com")) result = [{ id: "zone1" }];
+      return new Response(JSON.stringify({ success: true, result }));
+    }) as unknown as typeof fetch;
+
+    const client = new CloudflareDnsClient("token", config, fakeFetch);
+    const change = await client.ensureRecord("app.example.com", "1.2.3.4", comment);
+
+    expect(change).toEqual({ action: "create" });
+    const create = calls[calls.length - 1];
+    expect(create.method).toBe("POST");
+    expect(create.url).toContain("/zones/zone1/dns_records");
+    expect(create.body).toEqual({
+      type: "A",
+      content: "1.2.3.4",
+      proxied: false,
+      name: "app.example.com",
+      ttl: 1,
+      comment: "iop:blog/web",
+    });
+  });
+});
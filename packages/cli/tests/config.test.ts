@@ -7,7 +7,7 @@ import {
   mock,
   spyOn,
 } from "bun:test";
-import { loadConfig, loadSecrets } from "../src/config";
+import { loadConfig, loadSecrets, normalizeConfigEntries } from "../src/config";
 import fs from "node:fs/promises";
 import { rmSync, existsSync } from "node:fs";
 import { join } from "node:path";
@@ -145,5 +145,28 @@ QUOTED_VALUE_2='another quoted value'
       expect(secrets.QUOTED_VALUE_1).toBe("this is a quoted value");
       expect(secrets.QUOTED_VALUE_2).toBe("another quoted value");
     });
+
+    test("should leave references unresolved unless asked to resolve them", async () => {
+      await Bun.write(join(".iop", "secrets"), "DATABASE_URL=op://Production/Shop DB/connection string\n");
+
+      // Resolving would run the 1Password CLI, reading without resolving must not
+      const secrets = await loadSecrets();
+      expect(secrets.DATABASE_URL).toBe("op://Production/Shop DB/connection string");
+    });
+  });
+
+  describe("normalizeConfigEntries", () => {
+    test("should name object entries after their keys", () => {
+      expect(normalizeConfigEntries({ web: { image: "web:1" }, db: { image: "postgres" } })).toEqual([
+        { image: "web:1", name: "web" },
+        { image: "postgres", name: "db" },
+      ]);
+    });
+
+    test("should keep arrays and treat missing entries as empty", () => {
+      const entries = [{ name: "web", image: "web:1" }];
+      expect(normalizeConfigEntries(entries)).toBe(entries);
+      expect(normalizeConfigEntries(undefined)).toEqual([]);
+    });
   });
 });
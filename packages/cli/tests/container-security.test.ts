@@ -0,0 +1,85 @@
+import { describe, expect, test } from "bun:test";
+import { ServiceEntry, ServiceEntryWithoutNameSchema } from "../src/config/types";
+import { buildSecurityFlags, getContainerSecurity } from "../src/docker";
+import { createSidecarContainerOptions } from "../src/commands/blue-green";
+import { createServiceConfigHash } from "../src/utils/service-fingerprint";
+
+describe("container hardening", () => {
+  test("should validate hardening options", () => {
+    const result = ServiceEntryWithoutNameSchema.safeParse({
+      image: "blog-web:latest",
+      server: "server1.example.com",
+      user: "1000:1000",
+      read_only_rootfs: true,
+      cap_drop: ["ALL"],
+      no_new_privileges: true,
+      tmpfs: ["/tmp:size=64m", "/app/cache"],
+    });
+    expect(result.success).toBe(true);
+  });
+
+  test("should reject invalid users, capabilities and tmpfs mounts", () => {
+    for (const options of [
+      { user: "1000 --privileged" },
+      { user: ":1000" },
+      { cap_drop: ["NET RAW"] },
+      { tmpfs: ["tmp"] },
+      { tmpfs: ["/tmp:size=64m --privileged"] },
+    ]) {
+      const result = ServiceEntryWithoutNameSchema.safeParse({
+        image: "blog-web:latest",
+        server: "server1.example.com",
+        ...options,
+      });
+      expect(result.success).toBe(false);
+    }
+  });
+
+  test("should build docker run flags", () => {
+    expect(buildSecurityFlags(undefined)).toEqual([]);
+    expect(
+      buildSecurityFlags({
+        user: "app",
+        read_only_rootfs: true,
+        cap_drop: ["all", "NET_RAW"],
+        no_new_privileges: true,
+        tmpfs: ["/tmp:size=64m"],
+      })
+    ).toEqual([
+      "--user app",
+      "--read-only",
+      "--cap-drop ALL",
+      "--cap-drop NET_RAW",
+      "--security-opt no-new-privileges",
+      "--tmpfs /tmp:size=64m",
+    ]);
+  });
+
+  test("should only pick the hardening options of a service", () => {
+    const web = {
+      name: "web",
+      server: "1.2.3.4",
+      image: "blog",
+      replicas: 1,
+      read_only_rootfs: true,
+      sidecars: { logs: { image: "vector", user: "65534" } },
+    } as unknown as ServiceEntry;
+
+    expect(getContainerSecurity(web)).toEqual({
+      user: undefined,
+      read_only_rootfs: true,
+      cap_drop: undefined,
+      no_new_privileges: undefined,
+      tmpfs: undefined,
+    });
+    expect(getContainerSecurity({ ...web, read_only_rootfs: undefined })).toBeUndefined();
+    expect(
+      createSidecarContainerOptions(web, "logs", "blog-web-blue", {}, "blog").security?.user
+    ).toBe("65534");
+  });
+
+  test("should redeploy when hardening options change", () => {
+    const web = { name: "web", server: "1.2.3.4", image: "blog", replicas: 1 } as ServiceEntry;
+    expect(createServiceConfigHash({ ...web, user: "1000" })).not.toBe(createServiceConfigHash(web));
+  });
+});
@@ -0,0 +1,166 @@
+import { describe, it, expect } from "bun:test";
+import { applyServiceTemplates, SERVICE_TEMPLATES } from "../src/config/templates";
+import {
+  buildBackupScript,
+  buildBackupCronLine,
+  buildRestoreCommand,
+  getBackupDirectory,
+} from "../src/utils/db-backup";
+import { IopConfigSchema, ServiceEntry } from "../src/config/types";
+
+describe("database templates", () => {
+  describe("schema", () => {
+    it("should accept a templated service without an image", () => {
+      const result = IopConfigSchema.safeParse({
+        name: "blog",
+        services: { db: { template: "postgres", server: "1.2.3.4" } },
+      });
+      expect(result.success).toBe(true);
+    });
+
+    it("should reject a templated service with a build section", () => {
+      const result = IopConfigSchema.safeParse({
+        name: "blog",
+        services: {
+          db: { template: "postgres", server: "1.2.3.4", build: { context: "." } },
+        },
+      });
+      expect(result.success).toBe(false);
+    });
+
+    it("should apply backup defaults", () => {
+      const parsed = IopConfigSchema.parse({
+        name: "blog",
+        services: { db: { template: "redis", server: "1.2.3.4", backup: {} } },
+      });
+      const services = parsed.services as Record<string, any>;
+      expect(services.db.backup).toEqual({ schedule: "0 3 * * *", retention: 7 });
+    });
+  });
+
+  describe("applyServiceTemplates", () => {
+    it("should fill in image and a managed data volume", () => {
+      const config = applyServiceTemplates(
+        IopConfigSchema.parse({
+          name: "blog",
+          services: { db: { template: "postgres", server: "1.2.3.4" } },
+        })
+      );
+      const services = config.services as Record<string, any>;
+      expect(services.db.image).toBe(SERVICE_TEMPLATES.postgres.image);
+      expect(services.db.volumes).toEqual(["db-data:/var/lib/postgresql/data"]);
+      expect(config.volumes?.["db-data"]).toEqual({ driver: "local" });
+    });
+
+    it("should keep explicit image and volumes", () => {
+      const config = applyServiceTemplates(
+        IopConfigSchema.parse({
+          name: "blog",
+          services: [
+            {
+              name: "cache",
+              template: "redis",
+              image: "redis:6",
+              server: "1.2.3.4",
+              volumes: ["./redis:/data"],
+            },
+          ],
+        })
+      );
+      const services = config.services as any[];
+      expect(services[0].image).toBe("redis:6");
+      expect(services[0].volumes).toEqual(["./redis:/data"]);
+      expect(config.volumes).toBeUndefined();
+    });
+
+    it("should leave services without a template untouched", () => {
+      const parsed = IopConfigSchema.parse({
+        name: "blog",
+        services: { web: { image: "nginx", server: "1.2.3.4" } },
+      });
+      expect(applyServiceTemplates(parsed)).toEqual(parsed);
+    });
+  });
+
+  describe("backups", () => {
+    const service = {
+      name: "db",
+      template: "postgres",
+      image: "postgres:16",
+      server: "1.2.3.4",
+      replicas: 1,
+      backup: { schedule: "30 2 * * *", retention: 3 },
+    } as ServiceEntry;
+
+    it("should build a dump script with retention", () => {
+      const script = buildBackupScript(service, "blog", {});
+      expect(script).toContain("docker exec blog-db sh -c 'pg_dumpall");
+      expect(script).toContain("tail -n +4");
+      expect(script).not.toContain("aws-cli");
+    });
+
+    it("should upload to S3 when configured", () => {
+      const script = buildBackupScript(
+        {
+          ...service,
+          backup: {
+            schedule: "0 3 * * *",
+            retention: 7,
+            s3: {
+              bucket: "backups",
+              prefix: "blog/",
+              access_key_secret: "S3_KEY",
+              secret_key_secret: "S3_SECRET",
+            },
+          },
+        },
+        "blog",
+        { S3_KEY: "key", S3_SECRET: "secret" }
+      );
+      expect(script).toContain("amazon/aws-cli s3 cp");
+      expect(script).toContain('s3://backups/blog/$(basename "$FILE")');
+    });
+
+    it("should fail when S3 secrets are missing", () => {
+      expect(() =>
+        buildBackupScript(
+          {
+            ...service,
+            backup: {
+              schedule: "0 3 * * *",
+              retention: 7,
+              s3: { bucket: "b", access_key_secret: "A", secret_key_secret: "B" },
+            },
+          },
+          "blog",
+          {}
+        )
+      ).toThrow();
+    });
+
+    it("should build a tagged cron line", () => {
+      expect(buildBackupCronLine(service, "blog")).toBe(
+        `30 2 * * * sh ${getBackupDirectory("blog", "db")}/backup.sh >> ${getBackupDirectory(
+          "blog",
+          "db"
+        )}/backup.log 2>&1 # iop-backup blog/db`
+      );
+    });
+
+    it("should restore sql dumps through the database client", () => {
+      expect(buildRestoreCommand(service, "blog", "/tmp/db.sql.gz")).toBe(
+        `gunzip -c /tmp/db.sql.gz | docker exec -i blog-db sh -c 'psql -U "\${POSTGRES_USER:-postgres}" -d postgres'`
+      );
+    });
+
+    it("should restore redis by replacing the snapshot", () => {
+      const command = buildRestoreCommand(
+        { ...service, name: "cache", template: "redis" } as ServiceEntry,
+        "blog",
+        "/tmp/cache.rdb.gz"
+      );
+      expect(command).toContain("docker cp /tmp/iop-restore-cache.rdb blog-cache:/data/dump.rdb");
+      expect(command).toContain("docker start blog-cache");
+    });
+  });
+});
@@ -0,0 +1,102 @@
+import { describe, it, expect } from "bun:test";
+import { SSHClient } from "../src/ssh";
+import {
+  DeployLockedError,
+  acquireDeployLock,
+  createDeployLock,
+  describeDeployLock,
+  getDeployLockOwner,
+  getDeployLockPath,
+  isDeployLockExpired,
+  releaseDeployLock,
+} from "../src/utils/deploy-lock";
+
+/**
+ * Stands in for a server holding at most one lock file
+ */
+function fakeServer() {
+  const server = { lockFile: null as string | null };
+  const client = {
+    exec: async (command: string): Promise<string> => {
+      if (command.includes("set -C")) {
+        if (server.lockFile !== null) return "";
+        server.lockFile = command.match(/printf '%s' '(.*)' >/)![1];
+        return "acquired";
+      }
+      if (command.startsWith("cat ")) return server.lockFile ?? "";
+      if (command.startsWith("rm -f ")) server.lockFile = null;
+      return "";
+    },
+  };
+  return { server, client: client as unknown as SSHClient };
+}
+
+describe("deploy lock", () => {
+  const now = new Date("2024-05-01T12:00:00Z");
+
+  it("should store locks under the project directory", () => {
+    expect(getDeployLockPath("My App")).toBe("~/.iop/projects/my-app/deploy.lock");
+  });
+
+  it("should pick the owner from the environment", () => {
+    expect(getDeployLockOwner({ IOP_DEPLOY_OWNER: "release-bot" })).toBe("release-bot");
+    expect(
+      getDeployLockOwner({
+        GITHUB_ACTIONS: "true",
+        GITHUB_ACTOR: "alice",
+        GITHUB_RUN_ID: "42",
+      })
+    ).toBe("alice (GitHub Actions run 42)");
+    expect(getDeployLockOwner({})).toContain("@");
+  });
+
+  it("should expire after the TTL", () => {
+    const lock = createDeployLock("alice@laptop", "a1b2c3d", now, 60_000);
+    expect(isDeployLockExpired(lock, now)).toBe(false);
+    expect(isDeployLockExpired(lock, new Date(now.getTime() + 60_000))).toBe(true);
+    expect(isDeployLockExpired({ ...lock, expiresAt: "garbage" }, now)).toBe(true);
+  });
+
+  it("should describe who holds the lock", () => {
+    const lock = createDeployLock("alice@laptop", "a1b2c3d", now);
+    expect(describeDeployLock(lock, new Date(now.getTime() + 3 * 60_000))).toBe(
+      "alice@laptop (release a1b2c3d, started 3m ago)"
+    );
+  });
+
+  it("should refuse a second deployment until the first releases", async () => {
+    const { server, client } = fakeServer();
+    const first = createDeployLock("alice@laptop", "a1b2c3d");
+    const second = createDeployLock("ci", "a1b2c3d");
+
+    await acquireDeployLock(client, "server1.com", "blog", first);
+    const error = await acquireDeployLock(client, "server1.com", "blog", second).catch(
+      (e) => e
+    );
+    expect(error).toBeInstanceOf(DeployLockedError);
+    expect(error.message).toContain("Deployment in progress by alice@laptop");
+    expect(error.message).toContain("--force-unlock");
+
+    // Releasing with someone else's lock leaves it in place
+    await releaseDeployLock(client, "blog", second);
+    expect(server.lockFile).not.toBeNull();
+
+    await releaseDeployLock(client, "blog", first);
+    expect(server.lockFile).toBeNull();
+    await acquireDeployLock(client, "server1.com", "blog", second);
+  });
+
+  it("should take over expired locks and break locks when forced", async () => {
+    const { server, client } = fakeServer();
+    const stale = createDeployLock("alice@laptop", "a1b2c3d", new Date(0));
+    await acquireDeployLock(client, "server1.com", "blog", stale);
+
+    const next = createDeployLock("bob@desktop", "e4f5a6b");
+    await acquireDeployLock(client, "server1.com", "blog", next);
+    expect(JSON.parse(server.lockFile!).id).toBe(next.id);
+
+    const forced = createDeployLock("ci", "e4f5a6b");
+    await acquireDeployLock(client, "server1.com", "blog", forced, true);
+    expect(JSON.parse(server.lockFile!).id).toBe(forced.id);
+  });
+});
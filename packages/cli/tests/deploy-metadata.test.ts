@@ -0,0 +1,111 @@
+import { describe, it, expect } from "bun:test";
+import {
+  collectDeployMetadata,
+  detectCiMetadata,
+  formatDeployMetadata,
+  getDeployMetadataLabels,
+  parseAnnotation,
+  readDeployMetadataLabels,
+} from "../src/utils/deploy-metadata";
+
+describe("deploy metadata", () => {
+  describe("parseAnnotation", () => {
+    it("should split key and value", () => {
+      expect(parseAnnotation("ticket=OPS-42")).toEqual(["ticket", "OPS-42"]);
+      expect(parseAnnotation("note=a=b")).toEqual(["note", "a=b"]);
+      expect(parseAnnotation("empty=")).toEqual(["empty", ""]);
+    });
+
+    it("should reject missing or invalid keys", () => {
+      expect(() => parseAnnotation("OPS-42")).toThrow("Invalid annotation");
+      expect(() => parseAnnotation("=OPS-42")).toThrow("Invalid annotation");
+      expect(() => parseAnnotation("Ticket ID=OPS-42")).toThrow("Invalid annotation");
+    });
+  });
+
+  describe("detectCiMetadata", () => {
+    it("should read GitHub Actions", () => {
+      expect(
+        detectCiMetadata({
+          GITHUB_ACTIONS: "true",
+          GITHUB_SHA: "4f2a9c1e",
+          GITHUB_REF_NAME: "main",
+          GITHUB_ACTOR: "alice",
+          GITHUB_SERVER_URL: "https://github.com",
+          GITHUB_REPOSITORY: "acme/web",
+          GITHUB_RUN_ID: "123",
+        })
+      ).toEqual({
+        sha: "4f2a9c1e",
+        branch: "main",
+        actor: "alice",
+        ci_url: "https://github.com/acme/web/actions/runs/123",
+      });
+    });
+
+    it("should prefer the pull request branch on GitHub", () => {
+      expect(
+        detectCiMetadata({
+          GITHUB_ACTIONS: "true",
+          GITHUB_HEAD_REF: "feature/login",
+          GITHUB_REF_NAME: "12/merge",
+        }).branch
+      ).toBe("feature/login");
+    });
+
+    it("should read GitLab CI", () => {
+      expect(
+        detectCiMetadata({
+          GITLAB_CI: "true",
+          CI_COMMIT_SHA: "4f2a9c1e",
+          CI_COMMIT_REF_NAME: "main",
+          GITLAB_USER_LOGIN: "bob",
+          CI_JOB_URL: "https://gitlab.com/acme/web/-/jobs/7",
+        })
+      ).toEqual({
+        sha: "4f2a9c1e",
+        branch: "main",
+        actor: "bob",
+        ci_url: "https://gitlab.com/acme/web/-/jobs/7",
+      });
+    });
+
+    it("should be empty outside CI", () => {
+      expect(detectCiMetadata({})).toEqual({});
+    });
+  });
+
+  it("should let annotations override what CI reports", async () => {
+    const metadata = await collectDeployMetadata(["actor=release-bot", "ticket=OPS-42"], {
+      GITHUB_ACTIONS: "true",
+      GITHUB_SHA: "4f2a9c1e",
+      GITHUB_REF_NAME: "main",
+      GITHUB_ACTOR: "alice",
+    });
+
+    expect(metadata).toMatchObject({
+      sha: "4f2a9c1e",
+      branch: "main",
+      actor: "release-bot",
+      ticket: "OPS-42",
+    });
+  });
+
+  it("should round trip through container labels", () => {
+    const metadata = { sha: "4f2a9c1e", ci_url: "https://ci.example.com/1" };
+    const labels = getDeployMetadataLabels(metadata);
+
+    expect(labels).toEqual({
+      "iop.deploy.sha": "4f2a9c1e",
+      "iop.deploy.ci_url": "https://ci.example.com/1",
+    });
+    expect(readDeployMetadataLabels({ ...labels, "iop.managed": "true" })).toEqual(metadata);
+  });
+
+  it("should summarize metadata on one line", () => {
+    expect(
+      formatDeployMetadata({ sha: "4f2a9c1e0b", branch: "main", actor: "alice" })
+    ).toBe("4f2a9c1 (main) by alice");
+    expect(formatDeployMetadata({ ticket: "OPS-42" })).toBe("");
+  });
+});
@@ -0,0 +1,94 @@
+import { describe, it, expect } from "bun:test";
+import {
+  ConcurrencyLimiter,
+  combineDeploymentFailures,
+  getDeployParallelism,
+  planDeploymentGroups,
+} from "../src/utils/deploy-parallel";
+import { IopConfig, ServiceEntry } from "../src/config/types";
+
+function app(name: string, hosts: string[]): ServiceEntry {
+  return {
+    name,
+    server: "server1.example.com",
+    image: `${name}:latest`,
+    proxy: { app_port: 3000, hosts },
+  } as ServiceEntry;
+}
+
+describe("deploy parallelism", () => {
+  it("should deploy stop-start services first, one at a time", () => {
+    const db = { name: "db", server: "server1.example.com", image: "postgres:16" } as ServiceEntry;
+    const web = app("web", ["example.com"]);
+
+    const { sequential, groups } = planDeploymentGroups([web, db]);
+    expect(sequential.map((service) => service.name)).toEqual(["db"]);
+    expect(groups.map((group) => group.map((service) => service.name))).toEqual([["web"]]);
+  });
+
+  it("should keep apps sharing a proxy host in one group", () => {
+    const { groups } = planDeploymentGroups([
+      app("web", ["example.com"]),
+      app("api", ["api.example.com"]),
+      app("docs", ["docs.example.com"]),
+      app("web-next", ["example.com", "docs.example.com"]),
+    ]);
+
+    expect(groups.map((group) => group.map((service) => service.name))).toEqual([
+      ["web", "docs", "web-next"],
+      ["api"],
+    ]);
+  });
+
+  it("should never run more tasks than the limit", async () => {
+    const limiter = new ConcurrencyLimiter(2);
+    let running = 0;
+    let peak = 0;
+
+    const results = await Promise.all(
+      [1, 2, 3, 4, 5].map((n) =>
+        limiter.run(async () => {
+          running++;
+          peak = Math.max(peak, running);
+          await new Promise((resolve) => setTimeout(resolve, 5));
+          running--;
+          return n * 2;
+        })
+      )
+    );
+
+    expect(results).toEqual([2, 4, 6, 8, 10]);
+    expect(peak).toBe(2);
+  });
+
+  it("should free a slot when a task fails", async () => {
+    const limiter = new ConcurrencyLimiter(1);
+    await expect(
+      limiter.run(async () => {
+        throw new Error("boom");
+      })
+    ).rejects.toThrow("boom");
+    expect(await limiter.run(async () => "ok")).toBe("ok");
+  });
+
+  it("should reject invalid limits", () => {
+    expect(() => new ConcurrencyLimiter(0)).toThrow();
+  });
+
+  it("should prefer --parallel over the config", () => {
+    const config = { name: "blog", services: {}, deploy: { parallelism: 8 } } as unknown as IopConfig;
+    expect(getDeployParallelism(config)).toBe(8);
+    expect(getDeployParallelism(config, 2)).toBe(2);
+    expect(getDeployParallelism({ name: "blog" } as IopConfig)).toBe(4);
+  });
+
+  it("should combine failures into one error", () => {
+    const error = combineDeploymentFailures(
+      [{ serviceName: "api", server: "server2.example.com", error: "Health checks failed" }],
+      3
+    );
+    expect(error.message).toBe(
+      "1 of 3 service deployments failed: api on server2.example.com: Health checks failed"
+    );
+  });
+});
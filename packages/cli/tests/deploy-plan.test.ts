@@ -0,0 +1,101 @@
+import { describe, it, expect } from "bun:test";
+import {
+  DeployPlan,
+  formatPlan,
+  planHostActions,
+  summarizePlan,
+} from "../src/utils/deploy-plan";
+import { ProxyHostInfo } from "../src/proxy";
+
+describe("deploy plan", () => {
+  const existingHost: ProxyHostInfo = {
+    project: "blog",
+    target: "blog-web:3000",
+    app: "web",
+    ssl_enabled: true,
+    healthy: true,
+    certificate: { status: "active" },
+  };
+
+  it("should register new hosts and request certificates", () => {
+    const actions = planHostActions(["blog.example.com"], "blog-web:3000", true, "server1", {});
+
+    expect(actions).toEqual([
+      {
+        change: "create",
+        kind: "host",
+        target: "blog.example.com",
+        server: "server1",
+        detail: "route to blog-web:3000",
+      },
+      {
+        change: "create",
+        kind: "certificate",
+        target: "blog.example.com",
+        server: "server1",
+        detail: "request Let's Encrypt certificate",
+      },
+    ]);
+  });
+
+  it("should leave routed hosts with active certificates alone", () => {
+    const actions = planHostActions(["blog.example.com"], "blog-web:3000", true, "server1", {
+      "blog.example.com": existingHost,
+    });
+
+    expect(actions).toHaveLength(1);
+    expect(actions[0].change).toBe("unchanged");
+  });
+
+  it("should reroute hosts pointing elsewhere and retry pending certificates", () => {
+    const actions = planHostActions(["blog.example.com"], "blog-web:8080", true, "server1", {
+      "blog.example.com": { ...existingHost, certificate: { status: "pending" } },
+    });
+
+    expect(actions.map((action) => `${action.change} ${action.kind}`)).toEqual([
+      "update host",
+      "create certificate",
+    ]);
+    expect(actions[0].detail).toBe("reroute from blog-web:3000 to blog-web:8080");
+  });
+
+  it("should summarize and format a plan", () => {
+    const plan: DeployPlan = {
+      project: "blog",
+      releaseId: "abc123",
+      actions: [
+        { change: "create", kind: "build", target: "web", detail: "build blog-web:abc123" },
+        { change: "update", kind: "service", target: "web", server: "server1", detail: "image updated" },
+        { change: "unchanged", kind: "service", target: "db", server: "server1", detail: "up-to-date, skipped" },
+        { change: "delete", kind: "service", target: "worker", server: "server1", detail: "no longer in iop.yml" },
+      ],
+    };
+
+    expect(summarizePlan(plan)).toEqual({ create: 1, update: 1, delete: 1 });
+    expect(formatPlan(plan)).toEqual([
+      "Plan for blog (release abc123)",
+      "",
+      "  local",
+      "    + build web       build blog-web:abc123",
+      "",
+      "  server1",
+      "    ~ service web     image updated",
+      "    = service db      up-to-date, skipped",
+      "    - service worker  no longer in iop.yml",
+      "",
+      "Plan: 1 to create, 1 to change, 1 to remove.",
+    ]);
+  });
+
+  it("should report when nothing would change", () => {
+    const plan: DeployPlan = {
+      project: "blog",
+      releaseId: "abc123",
+      actions: [
+        { change: "unchanged", kind: "service", target: "web", server: "server1", detail: "up-to-date, skipped" },
+      ],
+    };
+
+    expect(formatPlan(plan).pop()).toBe("No changes. Everything is up to date.");
+  });
+});
@@ -0,0 +1,73 @@
+import { describe, it, expect } from "bun:test";
+import { DeploymentTimeline, TimelineProxyClient } from "../src/utils/deploy-timeline";
+import { ProxyDeploymentStepUpdate } from "../src/proxy";
+
+function fakeProxyClient(id: string | null = "3f9a") {
+  const updates: ProxyDeploymentStepUpdate[] = [];
+  const client: TimelineProxyClient = {
+    startDeploymentTimeline: async () => id,
+    updateDeploymentStep: async (_id, update) => {
+      updates.push(update);
+      return true;
+    },
+  };
+  return { client, updates };
+}
+
+describe("deployment timeline", () => {
+  it("should not track deployments the proxy can't", async () => {
+    const { client } = fakeProxyClient(null);
+    expect(await DeploymentTimeline.start(client, "blog", "web")).toBeUndefined();
+  });
+
+  it("should report a step as running and then succeeded", async () => {
+    const { client, updates } = fakeProxyClient();
+    const timeline = (await DeploymentTimeline.start(client, "blog", "web"))!;
+    expect(timeline.id).toBe("3f9a");
+
+    expect(await timeline.run("push", async () => "pulled")).toBe("pulled");
+    expect(updates).toEqual([
+      { step: "push", status: "running" },
+      { step: "push", status: "succeeded" },
+    ]);
+  });
+
+  it("should fail the running step once", async () => {
+    const { client, updates } = fakeProxyClient();
+    const timeline = new DeploymentTimeline(client, "3f9a");
+
+    await expect(
+      timeline.run("build", async () => {
+        throw new Error("docker build exited with 1");
+      })
+    ).rejects.toThrow("docker build exited with 1");
+
+    expect(await timeline.fail("Build failed")).toBe("build");
+    expect(updates).toEqual([
+      { step: "build", status: "running" },
+      { step: "build", status: "failed", error: "docker build exited with 1" },
+    ]);
+  });
+
+  it("should record attempts without changing the step", async () => {
+    const { client, updates } = fakeProxyClient();
+    const timeline = new DeploymentTimeline(client, "3f9a");
+
+    await timeline.step("health_check", "running");
+    await timeline.attempt("health_check", "503");
+    expect(await timeline.fail("Health checks failed")).toBe("health_check");
+    expect(updates[1]).toEqual({
+      step: "health_check",
+      attempt: { result: "503", error: undefined },
+    });
+  });
+
+  it("should have nothing to fail between steps", async () => {
+    const { client } = fakeProxyClient();
+    const timeline = new DeploymentTimeline(client, "3f9a");
+
+    await timeline.step("start", "running");
+    await timeline.step("start", "succeeded");
+    expect(await timeline.fail("Something broke")).toBeUndefined();
+  });
+});
@@ -0,0 +1,109 @@
+import { describe, it, expect } from "bun:test";
+import {
+  findContainerDrift,
+  findEnvDrift,
+  findHostDrift,
+  formatDrift,
+  getDesiredEnvironment,
+  parseDiffArgs,
+} from "../src/commands/diff";
+import { ServiceEntry } from "../src/config/types";
+import { ProxyHostInfo } from "../src/proxy";
+
+describe("diff", () => {
+  const db = {
+    name: "db",
+    image: "postgres:16",
+    server: "server1",
+    environment: {
+      plain: ["POSTGRES_DB=blog"],
+      secret: ["POSTGRES_PASSWORD"],
+    },
+  } as ServiceEntry;
+
+  it("should parse arguments", () => {
+    expect(parseDiffArgs(["web", "--reconcile"])).toEqual({
+      entryNames: ["web"],
+      reconcile: true,
+      verboseFlag: false,
+    });
+  });
+
+  it("should resolve the desired environment", () => {
+    expect(getDesiredEnvironment(db, { POSTGRES_PASSWORD: "secret" })).toEqual({
+      POSTGRES_DB: "blog",
+      POSTGRES_PASSWORD: "secret",
+    });
+    expect(getDesiredEnvironment(db, {})).toEqual({ POSTGRES_DB: "blog" });
+  });
+
+  it("should report missing and changed variables but ignore image defaults", () => {
+    const drift = findEnvDrift(
+      { POSTGRES_DB: "blog", POSTGRES_PASSWORD: "secret", TZ: "UTC" },
+      ["PATH=/usr/bin", "POSTGRES_DB=blog", "POSTGRES_PASSWORD=changed-by-hand"]
+    );
+
+    expect(drift).toEqual({ missing: ["TZ"], changed: ["POSTGRES_PASSWORD"] });
+  });
+
+  it("should compare a running container with the configuration", () => {
+    const drift = findContainerDrift(
+      db,
+      "blog-db",
+      { Config: { Image: "postgres:15", Env: ["POSTGRES_DB=other", "POSTGRES_PASSWORD=secret"] } },
+      { POSTGRES_DB: "blog", POSTGRES_PASSWORD: "secret" },
+      "server1"
+    );
+
+    expect(drift.map((item) => item.kind)).toEqual(["env_changed", "image_changed"]);
+    expect(drift[0].detail).toBe("environment changed POSTGRES_DB");
+    expect(drift[1].detail).toBe("runs postgres:15, expected postgres:16");
+  });
+
+  it("should report missing, misrouted and leftover hosts", () => {
+    const host = (target: string, project = "blog"): ProxyHostInfo => ({
+      project,
+      target,
+      app: "web",
+      ssl_enabled: true,
+      healthy: true,
+    });
+
+    const drift = findHostDrift(
+      new Map([
+        ["blog.com", { service: "web", target: "blog-web:3000" }],
+        ["www.blog.com", { service: "web", target: "blog-web:3000" }],
+        ["api.blog.com", { service: "api", target: "blog-api:8080" }],
+      ]),
+      {
+        "blog.com": host("blog-web:3000"),
+        "api.blog.com": host("blog-api:3000"),
+        "old.blog.com": host("blog-web:3000"),
+        "shop.com": host("shop-web:3000", "shop"),
+        "status.blog.com": { ...host("127.0.0.1:8081"), app: "status-page" },
+      },
+      "blog",
+      "server1"
+    );
+
+    expect(drift.map((item) => `${item.kind} ${item.target}`)).toEqual([
+      "missing_host www.blog.com",
+      "host_target api.blog.com",
+      "orphaned_host old.blog.com",
+    ]);
+  });
+
+  it("should format drift by server", () => {
+    expect(formatDrift([])).toEqual(["No drift. Servers match iop.yml."]);
+    expect(
+      formatDrift([
+        { kind: "stopped", server: "server1", service: "db", target: "blog-db", detail: "container is stopped" },
+      ])
+    ).toEqual([
+      "server1",
+      "  db           blog-db: container is stopped",
+      "",
+      "1 difference(s) found.",
+    ]);
+  });
+});
@@ -0,0 +1,198 @@
+import { describe, it, expect } from "bun:test";
+import { parseDoctorArgs } from "../src/commands/doctor";
+import type { ContainerInventoryEntry } from "../src/commands/ps";
+import { ServiceEntry } from "../src/config/types";
+import { ProxyHostInfo } from "../src/proxy";
+import {
+  describeFix,
+  findCertificateIssues,
+  findContainerIssues,
+  findHostIssues,
+  findNetworkIssues,
+  getExpectedHosts,
+} from "../src/utils/reconcile";
+import { generateAppSslipDomain } from "../src/utils/sslip";
+
+const SERVER = "server1.example.com";
+
+function container(
+  name: string,
+  fields: Partial<ContainerInventoryEntry> = {}
+): ContainerInventoryEntry {
+  return {
+    name,
+    image: "blog-web:a1b2c3d",
+    project: "blog",
+    type: "app",
+    entry: "web",
+    state: "running",
+    uptime: "2h 15m",
+    restarts: 0,
+    ports: [],
+    networks: ["blog-network"],
+    aliases: [],
+    ...fields,
+  };
+}
+
+function host(fields: Partial<ProxyHostInfo> = {}): ProxyHostInfo {
+  return {
+    project: "blog",
+    target: "blog-web:3000",
+    app: "web",
+    ssl_enabled: true,
+    healthy: true,
+    ...fields,
+  };
+}
+
+const services = [
+  {
+    name: "web",
+    server: SERVER,
+    image: "blog-web",
+    proxy: { app_port: 3000, hosts: ["blog.example.com", "www.blog.example.com"] },
+  },
+  { name: "db", server: SERVER, image: "postgres:16" },
+] as ServiceEntry[];
+
+const activeWeb = container("blog-web-green", {
+  color: "green",
+  aliases: ["web", "blog-web"],
+});
+
+describe("doctor", () => {
+  it("should parse flags", () => {
+    expect(parseDoctorArgs([])).toEqual({
+      fix: false,
+      yes: false,
+      server: undefined,
+      verboseFlag: false,
+    });
+    expect(parseDoctorArgs(["--fix", "-y", "--server", "server1.com"])).toEqual({
+      fix: true,
+      yes: true,
+      server: "server1.com",
+      verboseFlag: false,
+    });
+  });
+
+  it("should expect the generated domain when iop.yml names no hosts", () => {
+    const app = { name: "api", server: SERVER, image: "blog-api", proxy: { app_port: 3000 } } as ServiceEntry;
+    expect(getExpectedHosts("blog", app)).toEqual([generateAppSslipDomain("blog", "api", SERVER)]);
+    expect(getExpectedHosts("blog", services[0])).toEqual(["blog.example.com", "www.blog.example.com"]);
+    expect(getExpectedHosts("blog", services[1])).toEqual([]);
+  });
+
+  it("should tell blue-green leftovers from orphaned containers", () => {
+    const findings = findContainerIssues(SERVER, [
+      activeWeb,
+      container("blog-web-blue", { color: "blue", state: "exited", orphan: "inactive blue color" }),
+      container("blog-api-blue", { entry: "api", color: "blue", orphan: "app not in iop.yml" }),
+      container("blog-cache", { type: "service", entry: "cache", orphan: "service not in iop.yml" }),
+    ]);
+
+    expect(findings.map((f) => [f.kind, f.resource, f.detail])).toEqual([
+      ["leftover-container", "blog-web-blue", "inactive blue color, exited"],
+      ["orphaned-container", "blog-api-blue", "app not in iop.yml, running"],
+      ["orphaned-container", "blog-cache", "service not in iop.yml, running"],
+    ]);
+    expect(findings.every((f) => f.fix === "remove" && f.server === SERVER)).toBe(true);
+  });
+
+  it("should flag hosts nothing backs anymore", () => {
+    const hosts = {
+      "blog.example.com": host(),
+      "www.blog.example.com": host(),
+      "old.blog.example.com": host(),
+      "api.blog.example.com": host({ app: "api", target: "blog-api:3000" }),
+      "shop.example.com": host({ project: "shop", app: "api", target: "shop-api:3000" }),
+      "docs.example.com": host({ alias_of: "blog.example.com" }),
+      "tenant.example.com": host({ on_demand: true }),
+    };
+
+    const findings = findHostIssues(SERVER, "blog", services, hosts, [activeWeb]);
+    expect(findings.map((f) => [f.kind, f.resource, f.detail])).toEqual([
+      ["stale-host", "old.blog.example.com", "no longer a host of web in iop.yml"],
+      ["stale-host", "api.blog.example.com", "app api isn't in iop.yml for this server"],
+    ]);
+  });
+
+  it("should flag hosts whose target no container answers to", () => {
+    const hosts = {
+      "blog.example.com": host(),
+      "www.blog.example.com": host(),
+    };
+    const stopped = container("blog-web-green", { color: "green", state: "exited" });
+
+    const findings = findHostIssues(SERVER, "blog", services, hosts, [stopped]);
+    expect(findings.map((f) => [f.kind, f.resource, f.detail])).toEqual([
+      ["stale-host", "blog.example.com", "no container answers to blog-web:3000"],
+      ["stale-host", "www.blog.example.com", "no container answers to blog-web:3000"],
+    ]);
+  });
+
+  it("should adopt configured hosts of a running app the proxy doesn't route", () => {
+    const hosts = { "blog.example.com": host() };
+
+    const findings = findHostIssues(SERVER, "blog", services, hosts, [activeWeb]);
+    expect(findings).toEqual([
+      {
+        kind: "unrouted-host",
+        server: SERVER,
+        resource: "www.blog.example.com",
+        detail: "web runs but the proxy doesn't route it",
+        fix: "adopt",
+        app: "web",
+      },
+    ]);
+    expect(describeFix(findings[0])).toBe("Route www.blog.example.com to web");
+
+    // Nothing to route to while the app is down
+    const stopped = { ...activeWeb, state: "exited" };
+    expect(findHostIssues(SERVER, "blog", services, {}, [stopped]).map((f) => f.kind)).toEqual([]);
+  });
+
+  it("should flag project networks nothing is attached to", () => {
+    const network = (name: string, project: string, containers: string[]) => ({
+      name,
+      driver: "bridge",
+      subnets: [],
+      labels: { "iop.managed": "true", "iop.project": project },
+      containers: containers.map((name) => ({ name })),
+    });
+
+    const findings = findNetworkIssues(SERVER, [
+      network("blog-network", "blog", ["iop-proxy", "blog-web-green"]),
+      network("shop-network", "shop", ["iop-proxy"]),
+      network("custom-net", "docs", []),
+    ] as any);
+    expect(findings).toEqual([
+      {
+        kind: "unattached-network",
+        server: SERVER,
+        resource: "shop-network",
+        detail: "no containers of project shop are attached",
+        fix: "remove",
+        project: "shop",
+      },
+    ]);
+    expect(describeFix(findings[0])).toBe("Remove network shop-network");
+  });
+
+  it("should collect unreferenced certificates into one finding", () => {
+    expect(findCertificateIssues(SERVER, [])).toEqual([]);
+
+    const [finding] = findCertificateIssues(SERVER, [
+      "/var/lib/iop-proxy/certs/_groups/blog",
+      "/var/lib/iop-proxy/certs/old.example.com",
+    ]);
+    expect(finding.kind).toBe("unreferenced-cert");
+    expect(finding.resource).toBe("2 certificates");
+    expect(finding.detail).toBe(
+      "no host uses /var/lib/iop-proxy/certs/_groups/blog, /var/lib/iop-proxy/certs/old.example.com"
+    );
+    expect(describeFix(finding)).toBe("Delete 2 certificates no host uses");
+    expect(findCertificateIssues(SERVER, ["/certs/a"])[0].resource).toBe("1 certificate");
+  });
+});
@@ -0,0 +1,148 @@
+import { describe, it, expect } from "bun:test";
+import fs from "node:fs/promises";
+import os from "os";
+import path from "path";
+import {
+  decryptConfigValues,
+  decryptValue,
+  encryptValue,
+  generateIdentity,
+  identityToRecipient,
+  isEncrypted,
+  loadIdentities,
+  wasEncrypted,
+} from "../src/config/encryption";
+
+describe("config encryption", () => {
+  const alice = generateIdentity();
+  const bob = generateIdentity();
+
+  it("should derive the recipient from the identity", () => {
+    expect(alice.recipient).toMatch(/^age1[a-z0-9]{58}$/);
+    expect(alice.identity).toMatch(/^AGE-SECRET-KEY-1[A-Z0-9]{58}$/);
+    expect(identityToRecipient(alice.identity)).toBe(alice.recipient);
+    const corrupted = alice.identity.slice(0, -1) + (alice.identity.endsWith("Q") ? "P" : "Q");
+    expect(() => identityToRecipient(corrupted)).toThrow("Invalid age identity");
+  });
+
+  it("should decrypt values for any of the recipients", () => {
+    const encrypted = encryptValue("postgres://app:s3cret@db/app", [alice.recipient, bob.recipient]);
+    expect(isEncrypted(encrypted)).toBe(true);
+    expect(encrypted).not.toContain("s3cret");
+    expect(decryptValue(encrypted, [alice.identity])).toBe("postgres://app:s3cret@db/app");
+    expect(decryptValue(encrypted, [generateIdentity().identity, bob.identity])).toBe(
+      "postgres://app:s3cret@db/app"
+    );
+    expect(() => decryptValue(encrypted, [generateIdentity().identity])).toThrow(
+      "None of the identities can decrypt the value"
+    );
+  });
+
+  it("should handle empty values and values spanning several chunks", () => {
+    const long = "x".repeat(150 * 1024);
+    expect(decryptValue(encryptValue("", [alice.recipient]), [alice.identity])).toBe("");
+    expect(decryptValue(encryptValue(long, [alice.recipient]), [alice.identity])).toBe(long);
+  });
+
+  it("should reject tampered values", () => {
+    const encrypted = encryptValue("secret", [alice.recipient]);
+    const file = Buffer.from(encrypted.slice("ENC[age,".length, -1), "base64");
+    file[file.length - 1] ^= 1;
+    expect(() => decryptValue(`ENC[age,${file.toString("base64")}]`, [alice.identity])).toThrow();
+  });
+
+  it("should decrypt values anywhere in the config", () => {
+    const config = {
+      name: "shop",
+      services: {
+        web: {
+          environment: {
+            plain: [
+              `DATABASE_URL=${encryptValue("postgres://db/shop", [alice.recipient])}`,
+              "LOG_LEVEL=info",
+            ],
+          },
+          replicas: 2,
+        },
+      },
+    };
+
+    const decrypted = decryptConfigValues(config, [alice.identity]) as typeof config;
+    expect(decrypted.services.web.environment.plain).toEqual([
+      "DATABASE_URL=postgres://db/shop",
+      "LOG_LEVEL=info",
+    ]);
+    expect(decrypted.services.web.replicas).toBe(2);
+    expect(wasEncrypted("DATABASE_URL=postgres://db/shop")).toBe(true);
+    expect(wasEncrypted("LOG_LEVEL=info")).toBe(false);
+
+    expect(() => decryptConfigValues(config, [])).toThrow("no key to decrypt them");
+    expect(decryptConfigValues({ name: "plain" }, [])).toEqual({ name: "plain" });
+  });
+
+  it("should read identities from the environment or a key file", async () => {
+    expect(await loadIdentities({ IOP_AGE_KEY: ` ${alice.identity}\n` })).toEqual([alice.identity]);
+
+    const dir = await fs.mkdtemp(path.join(os.tmpdir(), "iop-age-"));
+    const keyFile = path.join(dir, "keys.txt");
+    await fs.writeFile(
+      keyFile,
+      `# created: 2024-05-01T12:00:00Z\n# public key: ${alice.recipient}\n${alice.identity}\n${bob.identity}\n`
+    );
+    expect(await loadIdentities({ IOP_AGE_KEY_FILE: keyFile })).toEqual([alice.identity, bob.identity]);
+    expect(await loadIdentities({ IOP_AGE_KEY_FILE: path.join(dir, "missing") })).toEqual([]);
+    await fs.rm(dir, { recursive: true });
+  });
+
+  describe("reference vectors", () => {
+    // Keys and values made with filippo.io/age v1.2.1, the reference implementation
+    const reference = {
+      alice: {
+        identity: "AGE-SECRET-KEY-1XR69LCTQCC8FQARVQGTQSK3ZMP06KAHYEJ9G3S86NYNW0EU8L2PQHWRMN9",
+        recipient: "age13zqqf6s5svguyls7ma563va5x6kqpfmelf3vkhkm0e3mlf78gels89609x",
+      },
+      bob: {
+        identity: "AGE-SECRET-KEY-12WPTN0CSZCSUUXCJH8N7KJAWQ4Q0YUS7DDACCLM964HLWDWQ7MCQM4CL9U",
+        recipient: "age1s7f73chvcjejkgv0fq9487p5q9hpycwc89crcdwce4gnljv5jsms5walmz",
+      },
+      // "postgres://app:s3cret@db/app" for alice and bob
+      value: [
+        "ENC[age,YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBzNzZraWJrQll4T3pCTURCL0YvbzRUWkQ5aDBu",
+        "NWY1VklYY2hGSkdHZWlzCnJwZWxzelA3YmNvcUtCempQaTBEZGdBdExYb0FSaWwwNlBqVkM1eTkrZXMKLT4gWDI1",
+        "NTE5IEZrOERxWG1nVWhrUFFiTDc5UW12U3QzWnZoUVVOTXd0VkZLVXhRMTFwaE0KYkhDZUJ1QUxIM3lwY2pSQllB",
+        "R09GU0pReVR6WVFsaU9hd3FhT2VYbmtHTQotLS0gRDM5OHptNGx3RjUralRDOHRqTVY0dWxyTGVqMGlTUjlzRERs",
+        "WCtXV20wZwoeqm/Bw9KjnfAp+alKnWj2ydxWJxg1dhmQwvYfG2uamwH4tFYzLznseyGh6JzuxMw0oJErkjUlOkQ4",
+        "L1U=]",
+      ].join(""),
+      // "" for alice
+      empty: [
+        "ENC[age,YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBPN2lMaysya2hyS1FFdXUvVU45bXg3Rllyejdj",
+        "d29qY3ovNzZFaVJZWFJBCmg3bWlIaHRmK3FGWmdmUktHb2E3eVZhdEFrNFRNZm8yUnFFL205U1JkREUKLS0tIGNt",
+        "RlVTeStMMWRLM2JnNHBSandhMDdvRWtXaWRHMXVjNXlERTBZSmpBUkUKMzuLNapxhWeBWoH5U93VyjRC5wRFwa41",
+        "Ziwn/9Cj6LI=]",
+      ].join(""),
+    };
+
+    it("should derive the recipients age-keygen derives", () => {
+      expect(identityToRecipient(reference.alice.identity)).toBe(reference.alice.recipient);
+      expect(identityToRecipient(reference.bob.identity)).toBe(reference.bob.recipient);
+    });
+
+    it("should decrypt values encrypted by age", () => {
+      expect(decryptValue(reference.value, [reference.alice.identity])).toBe("postgres://app:s3cret@db/app");
+      expect(decryptValue(reference.value, [reference.bob.identity])).toBe("postgres://app:s3cret@db/app");
+      expect(decryptValue(reference.empty, [reference.alice.identity])).toBe("");
+      expect(() => decryptValue(reference.empty, [reference.bob.identity])).toThrow(
+        "None of the identities can decrypt the value"
+      );
+    });
+
+    it("should write the header age writes", () => {
+      const encrypted = encryptValue("secret", [reference.alice.recipient, reference.bob.recipient]);
+      const file = Buffer.from(encrypted.slice("ENC[age,".length, -1), "base64").toString("latin1");
+      const header = file.slice(0, file.indexOf("\n---"));
+      expect(header).toMatch(/^age-encryption\.org\/v1\n-> X25519 [A-Za-z0-9+/]{43}\n[A-Za-z0-9+/]{43}\n/);
+      expect(header.split("\n").filter((line) => line.startsWith("-> X25519 "))).toHaveLength(2);
+    });
+  });
+});
@@ -0,0 +1,174 @@
+import { describe, it, expect } from "bun:test";
+import {
+  applyEnvironmentGroups,
+  interpolateEnvironment,
+  mergeEnvironment,
+  resolveServiceEnvironment,
+} from "../src/config/environment";
+import { updateSecretsContent } from "../src/config";
+import { IopConfig, ServiceEntry } from "../src/config/types";
+import { listServiceEnvironment, parseAssignments } from "../src/commands/env";
+import { validateConfig } from "../src/utils/config-validator";
+
+describe("environment groups", () => {
+  it("should let later variables replace earlier ones of either kind", () => {
+    expect(
+      mergeEnvironment(
+        { plain: ["A=1", "B=1"], secret: ["C"] },
+        { plain: ["C=2"], secret: ["A"] },
+        undefined
+      )
+    ).toEqual({ plain: ["B=1", "C=2"], secret: ["A"] });
+  });
+
+  it("should layer groups, server overrides and the service's own variables", () => {
+    const groups = {
+      shared: {
+        plain: ["LOG_LEVEL=info", "REGION=eu"],
+        secret: ["SENTRY_DSN"],
+        servers: { "10.0.0.2": { plain: ["REGION=us"] } },
+      },
+      mail: { plain: ["SMTP_HOST=smtp.example.com"] },
+    };
+    const service = {
+      name: "web",
+      server: "10.0.0.2",
+      environment: {
+        groups: ["shared", "mail"],
+        plain: ["LOG_LEVEL=debug"],
+        servers: { "10.0.0.2": { secret: ["SMTP_HOST"] } },
+      },
+    };
+
+    expect(resolveServiceEnvironment(service, groups)).toEqual({
+      plain: ["REGION=us", "LOG_LEVEL=debug"],
+      secret: ["SENTRY_DSN", "SMTP_HOST"],
+    });
+    expect(resolveServiceEnvironment({ ...service, server: "10.0.0.1" }, groups).plain).toEqual([
+      "REGION=eu",
+      "SMTP_HOST=smtp.example.com",
+      "LOG_LEVEL=debug",
+    ]);
+  });
+
+  it("should reject unknown groups", () => {
+    expect(() =>
+      resolveServiceEnvironment({ name: "web", server: "a", environment: { groups: ["nope"] } })
+    ).toThrow('Service web uses unknown env group "nope"');
+  });
+
+  it("should expand groups into each service when the config is loaded", () => {
+    const config = applyEnvironmentGroups({
+      name: "blog",
+      env_groups: { shared: { plain: ["TZ=UTC"] } },
+      services: {
+        web: { image: "blog", server: "a", environment: { groups: ["shared"], plain: ["PORT=3000"] } },
+        db: { image: "postgres", server: "a" },
+      },
+    } as unknown as IopConfig);
+
+    const services = config.services as Record<string, ServiceEntry>;
+    expect(services.web.environment).toEqual({ plain: ["TZ=UTC", "PORT=3000"], secret: [] });
+    expect(services.db.environment).toBeUndefined();
+  });
+});
+
+describe("environment interpolation", () => {
+  it("should substitute variables and secrets", () => {
+    expect(
+      interpolateEnvironment(
+        {
+          URL: "http://${HOST}:${PORT}/",
+          HOST: "${APP}.internal",
+          APP: "web",
+          PORT: "3000",
+          DB: "${secrets.DB_URL}?pool=5",
+          RAW: "$${HOME}",
+        },
+        { DB_URL: "postgres://db" },
+        "web"
+      )
+    ).toEqual({
+      URL: "http://web.internal:3000/",
+      HOST: "web.internal",
+      APP: "web",
+      PORT: "3000",
+      DB: "postgres://db?pool=5",
+      RAW: "${HOME}",
+    });
+  });
+
+  it("should leave secret values alone", () => {
+    expect(
+      interpolateEnvironment({ TOKEN: "a${b}", COPY: "${TOKEN}" }, {}, "web", ["TOKEN"])
+    ).toEqual({ TOKEN: "a${b}", COPY: "a${b}" });
+  });
+
+  it("should fail on unknown references and cycles", () => {
+    expect(() => interpolateEnvironment({ A: "${B}" }, {}, "web")).toThrow(
+      "Variable B used in A of web is not defined"
+    );
+    expect(() => interpolateEnvironment({ A: "${secrets.B}" }, {}, "web")).toThrow(
+      "Secret B used in A of web not found in loaded secrets"
+    );
+    expect(() => interpolateEnvironment({ A: "${B}", B: "${A}" }, {}, "web")).toThrow(
+      "refers to itself"
+    );
+  });
+
+  it("should report undefined variables when validating", () => {
+    const config = {
+      name: "blog",
+      services: {
+        web: {
+          image: "blog",
+          server: "a",
+          environment: { plain: ["URL=${SCHEME}://${HOST}", "HOST=example.com"] },
+        },
+      },
+    } as unknown as IopConfig;
+
+    expect(validateConfig(config).map((error) => error.message)).toEqual([
+      "Variable SCHEME used in URL of web is not defined",
+    ]);
+  });
+});
+
+describe("env command", () => {
+  it("should mask secrets and values built from them", () => {
+    const service = {
+      name: "web",
+      server: "a",
+      environment: {
+        plain: ["PORT=3000", "DSN=${secrets.DB}", "URL=${DSN}/app"],
+        secret: ["API_KEY", "MISSING"],
+      },
+    } as unknown as ServiceEntry;
+    const secrets = { DB: "postgres://db", API_KEY: "k" };
+
+    expect(listServiceEnvironment(service, secrets)).toEqual([
+      { name: "PORT", value: "3000", secret: false, set: true },
+      { name: "DSN", value: null, secret: true, set: true },
+      { name: "URL", value: null, secret: true, set: true },
+      { name: "API_KEY", value: null, secret: true, set: true },
+      { name: "MISSING", value: null, secret: true, set: false },
+    ]);
+    expect(listServiceEnvironment(service, secrets, true)[2].value).toBe("postgres://db/app");
+  });
+
+  it("should parse assignments", () => {
+    expect(parseAssignments(["A=1", "B=x=y", "C="])).toEqual({ A: "1", B: "x=y", C: "" });
+    expect(() => parseAssignments(["A"])).toThrow("expected KEY=VALUE");
+    expect(() => parseAssignments(["1A=1"])).toThrow("expected KEY=VALUE");
+  });
+
+  it("should update the secrets file in place", () => {
+    const content = "# Database\nDB_URL=postgres://old\n\nAPI_KEY=abc\nOLD=1\n";
+
+    expect(updateSecretsContent(content, { DB_URL: "postgres://new", TOKEN: " padded " }, ["OLD"])).toBe(
+      '# Database\nDB_URL=postgres://new\n\nAPI_KEY=abc\nTOKEN=" padded "\n'
+    );
+    expect(updateSecretsContent("", { A: "1" })).toBe("A=1\n");
+    expect(updateSecretsContent("A=1\n", {}, ["A"])).toBe("");
+  });
+});
@@ -0,0 +1,94 @@
+import { describe, it, expect } from "bun:test";
+import { execFileSync } from "child_process";
+import { parseExecArgs, buildSSHExecArgs } from "../src/commands/exec";
+
+describe("exec command", () => {
+  describe("parseExecArgs", () => {
+    it("should parse --app and --server flags", () => {
+      const parsed = parseExecArgs(["--app", "web", "--server", "1.2.3.4"]);
+      expect(parsed.app).toBe("web");
+      expect(parsed.server).toBe("1.2.3.4");
+      expect(parsed.command).toEqual([]);
+    });
+
+    it("should accept the app name as a positional argument", () => {
+      const parsed = parseExecArgs(["web", "--verbose"]);
+      expect(parsed.app).toBe("web");
+      expect(parsed.verboseFlag).toBe(true);
+    });
+
+    it("should pass everything after -- through as the command", () => {
+      const parsed = parseExecArgs(["--app", "web", "--", "rails", "console", "--sandbox"]);
+      expect(parsed.command).toEqual(["rails", "console", "--sandbox"]);
+      expect(parsed.verboseFlag).toBe(false);
+    });
+  });
+
+  describe("buildSSHExecArgs", () => {
+    it("should build a TTY docker exec invocation", () => {
+      const args = buildSSHExecArgs(
+        { username: "deploy", port: 22 },
+        "1.2.3.4",
+        "myproject-web-blue",
+        ["sh"]
+      );
+      expect(args).toEqual([
+        "-t",
+        "deploy@1.2.3.4",
+        "docker",
+        "exec",
+        "-it",
+        "myproject-web-blue",
+        "'sh'",
+      ]);
+    });
+
+    it("should quote command arguments for the remote shell", () => {
+      const command = ["sh", "-c", "echo $HOME; ls | wc -l", "it's a file.txt"];
+      const args = buildSSHExecArgs({ username: "deploy" }, "1.2.3.4", "myproject-web", command);
+      const quoted = args.slice(args.indexOf("myproject-web") + 1);
+      expect(quoted).toEqual(["'sh'", "'-c'", "'echo $HOME; ls | wc -l'", `'it'\\''s a file.txt'`]);
+
+      // ssh hands the remote shell one joined string, which must split back into the same arguments
+      const remote = execFileSync("sh", ["-c", `printf '%s\\n' ${quoted.join(" ")}`]).toString();
+      expect(remote.trimEnd().split("\n")).toEqual(command);
+    });
+
+    it("should include custom port and identity file", () => {
+      const args = buildSSHExecArgs(
+        { username: "deploy", port: 2222, identity: "/home/me/.ssh/id_ed25519" },
+        "example.com",
+        "myproject-db",
+        ["psql"]
+      );
+      expect(args.slice(0, 5)).toEqual([
+        "-t",
+        "-p",
+        "2222",
+        "-i",
+        "/home/me/.ssh/id_ed25519",
+      ]);
+      expect(args).toContain("deploy@example.com");
+    });
+
+    it("should forward the agent and jump through a bastion", () => {
+      const args = buildSSHExecArgs(
+        {
+          username: "deploy",
+          agentForward: true,
+          bastion: { host: "bastion.example.com", port: 2200, username: "jump" },
+        },
+        "10.0.1.5",
+        "myproject-web-green",
+        ["sh"]
+      );
+      expect(args.slice(0, 5)).toEqual([
+        "-t",
+        "-A",
+        "-o",
+        "ProxyCommand=ssh -W %h:%p -p 2200 jump@bastion.example.com",
+        "deploy@10.0.1.5",
+      ]);
+    });
+  });
+});
@@ -0,0 +1,52 @@
+import { describe, it, expect } from "bun:test";
+import {
+  GitHubDeploymentReporter,
+  parseGitHubRepository,
+  toCommitState,
+} from "../src/utils/github-deployments";
+
+describe("github deployments", () => {
+  it("should parse https and ssh remotes", () => {
+    expect(parseGitHubRepository("https://github.com/acme/blog.git")).toBe("acme/blog");
+    expect(parseGitHubRepository("git@github.com:acme/blog.git\n")).toBe("acme/blog");
+    expect(parseGitHubRepository("https://github.com/acme/blog")).toBe("acme/blog");
+    expect(parseGitHubRepository("git@gitlab.com:acme/blog.git")).toBeNull();
+  });
+
+  it("should map deployment states to commit states", () => {
+    expect(toCommitState("in_progress")).toBe("pending");
+    expect(toCommitState("failure")).toBe("failure");
+  });
+
+  it("should create a deployment and post statuses", async () => {
+    const calls: Array<{ url: string; body: any }> = [];
+    const fakeFetch = (async (url: string, init: any) => {
+      calls.push({ url, body: JSON.parse(init.body) });
+      return new Response(JSON.stringify({ id: 7 }), { status: 201 });
+    }) as unknown as typeof fetch;
+
+    const reporter = new GitHubDeploymentReporter(
+      {
+        repository: "acme/blog",
+        token: "t",
+        environment: "production",
+        commitStatus: true,
+        sha: "abc123",
+      },
+      fakeFetch
+    );
+    await reporter.start("Deploying abc123");
+    await reporter.update("success", "Deployed", "https://blog.com");
+
+    expect(calls.map((call) => call.url)).toEqual([
+      "https://api.github.com/repos/acme/blog/deployments",
+      "https://api.github.com/repos/acme/blog/deployments/7/statuses",
+      "https://api.github.com/repos/acme/blog/statuses/abc123",
+      "https://api.github.com/repos/acme/blog/deployments/7/statuses",
+      "https://api.github.com/repos/acme/blog/statuses/abc123",
+    ]);
+    expect(calls[0].body.ref).toBe("abc123");
+    expect(calls[3].body.environment_url).toBe("https://blog.com");
+    expect(calls[4].body).toMatchObject({ state: "success", context: "iop/deploy" });
+  });
+});
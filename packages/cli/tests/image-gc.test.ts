@@ -0,0 +1,90 @@
+import { describe, it, expect } from "bun:test";
+import {
+  formatImage,
+  getImageRepository,
+  getImageRetention,
+  selectImagesToPrune,
+} from "../src/utils/image-gc";
+import { parsePruneArgs } from "../src/commands/prune";
+import { DockerImageInfo } from "../src/docker";
+
+const image = (
+  repository: string,
+  tag: string,
+  id: string,
+  createdAt: string
+): DockerImageInfo => ({ id, repository, tag, createdAt, size: "100MB" });
+
+describe("image garbage collection", () => {
+  it("should strip tags and digests from image references", () => {
+    expect(getImageRepository("web")).toBe("web");
+    expect(getImageRepository("ghcr.io/acme/web:1.0")).toBe("ghcr.io/acme/web");
+    expect(getImageRepository("localhost:5000/web")).toBe("localhost:5000/web");
+    expect(getImageRepository("postgres@sha256:abc")).toBe("postgres");
+  });
+
+  it("should default retention to 3", () => {
+    expect(getImageRetention({ name: "blog" })).toBe(3);
+    expect(getImageRetention({ name: "blog", gc: { retain: 1, enabled: true } })).toBe(1);
+  });
+
+  describe("selectImagesToPrune", () => {
+    const images = [
+      image("web", "latest", "sha256:e", "2025-01-05 10:00:00 +0000 UTC"),
+      image("web", "e5", "sha256:e", "2025-01-05 10:00:00 +0000 UTC"),
+      image("web", "d4", "sha256:d", "2025-01-04 10:00:00 +0000 UTC"),
+      image("web", "c3", "sha256:c", "2025-01-03 10:00:00 +0000 UTC"),
+      image("web", "b2", "sha256:b", "2025-01-02 10:00:00 +0000 UTC"),
+      image("web", "a1", "sha256:a", "2025-01-01 10:00:00 +0000 UTC"),
+      image("postgres", "15", "sha256:p", "2024-12-01 10:00:00 +0000 UTC"),
+      image("<none>", "<none>", "sha256:x", "2024-12-01 10:00:00 +0000 UTC"),
+    ];
+
+    it("should keep images in use plus the newest unused releases", () => {
+      const { remove, keep } = selectImagesToPrune(
+        images,
+        ["web"],
+        new Set(["sha256:e"]),
+        2
+      );
+      expect(keep.map((i) => i.tag)).toEqual(["e5", "d4", "c3"]);
+      expect(remove.map((i) => i.tag)).toEqual(["b2", "a1", "<none>"]);
+    });
+
+    it("should never keep unused releases when retention is zero", () => {
+      const { remove } = selectImagesToPrune(images, ["web"], new Set(["sha256:e"]), 0);
+      expect(remove.map((i) => i.tag)).toEqual(["d4", "c3", "b2", "a1", "<none>"]);
+    });
+
+    it("should leave images outside the project alone", () => {
+      const { remove } = selectImagesToPrune(images, ["web"], new Set(), 10);
+      expect(remove.map((i) => i.repository)).toEqual(["<none>"]);
+    });
+
+    it("should keep an in-use image even when it is old", () => {
+      const { remove } = selectImagesToPrune(images, ["web"], new Set(["sha256:a"]), 0);
+      expect(remove.map((i) => i.tag)).not.toContain("a1");
+    });
+  });
+
+  it("should format dangling images by short id", () => {
+    expect(formatImage(image("<none>", "<none>", "sha256:0123456789abcdef", ""))).toBe(
+      "<dangling> 0123456789ab (100MB)"
+    );
+  });
+
+  describe("parsePruneArgs", () => {
+    it("should parse flags", () => {
+      expect(parsePruneArgs(["--dry-run", "--retain", "1", "--server", "1.2.3.4"])).toEqual({
+        dryRun: true,
+        retain: 1,
+        server: "1.2.3.4",
+        verboseFlag: false,
+      });
+    });
+
+    it("should reject invalid retention", () => {
+      expect(() => parsePruneArgs(["--retain", "-1"])).toThrow();
+    });
+  });
+});
@@ -0,0 +1,162 @@
+import { describe, expect, test } from "bun:test";
+import { ImageScanSchema, ServiceEntryWithoutNameSchema } from "../src/config/types";
+import { DockerClient } from "../src/docker";
+import {
+  ImageScanReport,
+  evaluateImageScan,
+  formatBlockingVulnerabilities,
+  formatScanCounts,
+  getImageScanConfig,
+  getScanLabels,
+  parseTrivyReport,
+  readScanCounts,
+  scanImage,
+} from "../src/utils/image-scan";
+
+const trivyOutput = JSON.stringify({
+  Results: [
+    {
+      Target: "shop:1 (debian 12.5)",
+      Vulnerabilities: [
+        {
+          VulnerabilityID: "CVE-2024-0001",
+          PkgName: "openssl",
+          InstalledVersion: "3.0.11",
+          FixedVersion: "3.0.13",
+          Severity: "CRITICAL",
+          Title: "openssl: remote code execution",
+        },
+        { VulnerabilityID: "CVE-2024-0002", PkgName: "zlib", InstalledVersion: "1.2.13", Severity: "HIGH" },
+      ],
+    },
+    { Target: "app/package-lock.json", Vulnerabilities: null },
+    {
+      Target: "usr/lib/node_modules",
+      Vulnerabilities: [
+        {
+          VulnerabilityID: "CVE-2024-0001",
+          PkgName: "openssl",
+          InstalledVersion: "3.0.11",
+          FixedVersion: "3.0.13",
+          Severity: "CRITICAL",
+        },
+        { VulnerabilityID: "GHSA-xxxx", PkgName: "semver", InstalledVersion: "7.5.1", FixedVersion: "7.5.2", Severity: "MEDIUM" },
+      ],
+    },
+  ],
+});
+
+const report: ImageScanReport = {
+  scanner: "trivy",
+  digest: "shop@sha256:abc123",
+  scannedAt: "2026-10-16T12:00:00.000Z",
+  vulnerabilities: parseTrivyReport(trivyOutput),
+};
+
+const config = ImageScanSchema.parse({});
+
+function fakeDockerClient(cached: string | null): { dockerClient: DockerClient; calls: string[] } {
+  const calls: string[] = [];
+  const dockerClient = {
+    getImageDigest: async () => "shop@sha256:abc123",
+    readScanCache: async (key: string) => {
+      calls.push(`read ${key}`);
+      return cached;
+    },
+    writeScanCache: async (key: string) => {
+      calls.push(`write ${key}`);
+    },
+    runImageScanner: async (image: string, args: string[]) => {
+      calls.push(`scan ${args[args.length - 1]}`);
+      return trivyOutput;
+    },
+  } as unknown as DockerClient;
+  return { dockerClient, calls };
+}
+
+describe("image scanning", () => {
+  test("should default to trivy blocking on critical vulnerabilities", () => {
+    expect(config).toEqual({ scanner: "trivy", block_on: "CRITICAL", ignore_unfixed: false });
+    expect(ImageScanSchema.safeParse({ block_on: "SEVERE" }).success).toBe(false);
+    expect(
+      ServiceEntryWithoutNameSchema.safeParse({ image: "shop:1", server: "1.2.3.4", scan: false }).success
+    ).toBe(true);
+  });
+
+  test("should let a service replace or opt out of the project's scan", () => {
+    const project = { scan: config };
+    expect(getImageScanConfig({}, project)).toBe(config);
+    expect(getImageScanConfig({ scan: { ...config, block_on: "HIGH" } }, project)?.block_on).toBe("HIGH");
+    expect(getImageScanConfig({ scan: false }, project)).toBeUndefined();
+    expect(getImageScanConfig({}, {})).toBeUndefined();
+  });
+
+  test("should read trivy's report once per vulnerable package", () => {
+    expect(report.vulnerabilities.map((vulnerability) => vulnerability.id)).toEqual([
+      "CVE-2024-0001",
+      "CVE-2024-0002",
+      "GHSA-xxxx",
+    ]);
+    expect(report.vulnerabilities[1].fixedVersion).toBeUndefined();
+    expect(parseTrivyReport("{}")).toEqual([]);
+    expect(() => parseTrivyReport("FATAL no such image")).toThrow("Could not read trivy's report");
+  });
+
+  test("should block on vulnerabilities at or above the severity", () => {
+    const critical = evaluateImageScan(report, config);
+    expect(critical.counts).toEqual({ UNKNOWN: 0, LOW: 0, MEDIUM: 1, HIGH: 1, CRITICAL: 1 });
+    expect(critical.blocking.map((vulnerability) => vulnerability.id)).toEqual(["CVE-2024-0001"]);
+    expect(formatBlockingVulnerabilities(critical, config)).toBe(
+      "1 vulnerability at or above CRITICAL: CVE-2024-0001 (critical, openssl 3.0.11, fixed in 3.0.13)"
+    );
+
+    expect(evaluateImageScan(report, { ...config, block_on: "MEDIUM" }).blocking).toHaveLength(3);
+    expect(evaluateImageScan(report, { ...config, block_on: "NONE" }).blocking).toEqual([]);
+  });
+
+  test("should leave out ignored and unfixed vulnerabilities", () => {
+    const result = evaluateImageScan(report, {
+      ...config,
+      block_on: "LOW",
+      ignore_unfixed: true,
+      ignore: ["CVE-2024-0001"],
+    });
+    expect(result.blocking.map((vulnerability) => vulnerability.id)).toEqual(["GHSA-xxxx"]);
+    expect(result.counts.CRITICAL).toBe(0);
+  });
+
+  test("should scan a digest once and reuse its report", async () => {
+    const fresh = fakeDockerClient(null);
+    const now = new Date("2026-10-16T12:00:00.000Z");
+    const result = await scanImage(fresh.dockerClient, "shop:1", config, now);
+    expect(result.cached).toBe(false);
+    expect(result.report.digest).toBe("shop@sha256:abc123");
+    expect(fresh.calls).toEqual(["read trivy-abc123", "scan shop:1", "write trivy-abc123"]);
+
+    const cached = fakeDockerClient(JSON.stringify(report));
+    expect((await scanImage(cached.dockerClient, "shop:1", config, now)).cached).toBe(true);
+    expect(cached.calls).toEqual(["read trivy-abc123"]);
+
+    const stale = fakeDockerClient(JSON.stringify(report));
+    await scanImage(stale.dockerClient, "shop:1", config, new Date("2026-10-18T12:00:00.000Z"));
+    expect(stale.calls).toContain("scan shop:1");
+
+    await expect(
+      scanImage(fresh.dockerClient, "shop:1", { ...config, scanner: "grype" })
+    ).rejects.toThrow('Unknown scanner "grype"');
+  });
+
+  test("should record the counts as container labels", () => {
+    const { counts } = evaluateImageScan(report, config);
+    const labels = getScanLabels(counts);
+    expect(labels["iop.scan.critical"]).toBe("1");
+    expect(readScanCounts(labels)).toEqual(counts);
+    expect(readScanCounts({ "iop.color": "blue" })).toBeUndefined();
+    expect(getScanLabels(undefined)).toEqual({});
+
+    expect(formatScanCounts(counts)).toBe("1 critical, 1 high, 1 medium");
+    expect(formatScanCounts(evaluateImageScan({ ...report, vulnerabilities: [] }, config).counts)).toBe(
+      "no vulnerabilities"
+    );
+  });
+});
@@ -0,0 +1,99 @@
+import { describe, it, expect } from "bun:test";
+import {
+  COSIGN_KEY_PATH,
+  buildCosignVerifyArgs,
+  parseImageDigest,
+  pinImageDigest,
+  selectRepoDigest,
+} from "../src/utils/image-verification";
+import { ImageVerifySchema, IopConfig } from "../src/config/types";
+import { validateConfig } from "../src/utils/config-validator";
+
+const digest = `sha256:${"4f2a".repeat(16)}`;
+
+describe("image verification", () => {
+  describe("parseImageDigest", () => {
+    it("should accept sha256 digests", () => {
+      expect(parseImageDigest(digest)).toBe(digest);
+      expect(parseImageDigest(digest.toUpperCase().replace("SHA256", "sha256"))).toBe(digest);
+    });
+
+    it("should reject anything else", () => {
+      expect(() => parseImageDigest("4f2a")).toThrow("Invalid digest");
+      expect(() => parseImageDigest("sha256:xyz")).toThrow("Invalid digest");
+    });
+  });
+
+  it("should pin an image to a digest in place of its tag", () => {
+    expect(pinImageDigest("ghcr.io/acme/web:1.0", digest)).toBe(`ghcr.io/acme/web@${digest}`);
+    expect(pinImageDigest("localhost:5000/web", digest)).toBe(`localhost:5000/web@${digest}`);
+    expect(pinImageDigest(`postgres@sha256:${"0".repeat(64)}`, digest)).toBe(`postgres@${digest}`);
+  });
+
+  describe("selectRepoDigest", () => {
+    it("should prefer the digest of the repository the image was pulled as", () => {
+      const other = `sha256:${"1".repeat(64)}`;
+      expect(
+        selectRepoDigest("ghcr.io/acme/web:1.0", [
+          `registry.example.com/web@${other}`,
+          `ghcr.io/acme/web@${digest}`,
+        ])
+      ).toBe(digest);
+    });
+
+    it("should match Docker Hub images listed without their prefix", () => {
+      expect(selectRepoDigest("docker.io/library/postgres:15", [`postgres@${digest}`])).toBe(digest);
+    });
+
+    it("should return null for built images", () => {
+      expect(selectRepoDigest("blog-web:20240101", [])).toBeNull();
+    });
+  });
+
+  describe("buildCosignVerifyArgs", () => {
+    it("should verify against the mounted public key", () => {
+      expect(buildCosignVerifyArgs({ key: "cosign.pub" }, `web@${digest}`)).toEqual([
+        "verify",
+        "--key",
+        COSIGN_KEY_PATH,
+        `web@${digest}`,
+      ]);
+    });
+
+    it("should verify keyless signatures by identity and issuer", () => {
+      const args = buildCosignVerifyArgs(
+        {
+          identity: "https://github.com/acme/web/.github/workflows/release.yml@refs/heads/main",
+          issuer: "https://token.actions.githubusercontent.com",
+        },
+        `web@${digest}`
+      );
+      expect(args).toContain("--certificate-identity");
+      expect(args).toContain("https://token.actions.githubusercontent.com");
+    });
+  });
+
+  describe("config", () => {
+    it("should need either a key or an identity and issuer", () => {
+      expect(ImageVerifySchema.safeParse({ key: "cosign.pub" }).success).toBe(true);
+      expect(ImageVerifySchema.safeParse({ identity: "me", issuer: "https://issuer" }).success).toBe(true);
+      expect(ImageVerifySchema.safeParse({ identity: "me" }).success).toBe(false);
+      expect(
+        ImageVerifySchema.safeParse({ key: "cosign.pub", identity: "me", issuer: "https://issuer" }).success
+      ).toBe(false);
+    });
+
+    it("should reject verification of services built from source", () => {
+      const config = {
+        name: "blog",
+        services: {
+          web: { build: { context: "." }, server: "1.2.3.4", verify: { key: "cosign.pub" } },
+          api: { image: "ghcr.io/acme/api:1", server: "1.2.3.4", verify: { key: "cosign.pub" } },
+        },
+      } as unknown as IopConfig;
+
+      const errors = validateConfig(config).filter((error) => error.message.includes("signatures"));
+      expect(errors.map((error) => error.entries)).toEqual([["web"]]);
+    });
+  });
+});
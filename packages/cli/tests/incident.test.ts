@@ -0,0 +1,61 @@
+import { describe, it, expect } from "bun:test";
+import { formatIncident, parseIncidentArgs } from "../src/commands/incident";
+
+describe("incident", () => {
+  describe("parseIncidentArgs", () => {
+    it("should read the subcommand, its target and flags", () => {
+      expect(
+        parseIncidentArgs([
+          "open",
+          "Checkout errors",
+          "--apps",
+          "web,api",
+          "--message",
+          "Looking into it",
+          "--verbose",
+        ])
+      ).toEqual({
+        subcommand: "open",
+        target: "Checkout errors",
+        status: undefined,
+        message: "Looking into it",
+        apps: ["web", "api"],
+        verboseFlag: true,
+      });
+      expect(parseIncidentArgs(["update", "inc_1a2b3c4d", "--status", "identified"])).toMatchObject({
+        subcommand: "update",
+        target: "inc_1a2b3c4d",
+        status: "identified",
+      });
+    });
+
+    it("should reject unknown statuses", () => {
+      expect(() => parseIncidentArgs(["update", "inc_1", "--status", "fixed"])).toThrow(
+        'Invalid --status "fixed"'
+      );
+    });
+  });
+
+  describe("formatIncident", () => {
+    it("should list the updates under the incident", () => {
+      expect(
+        formatIncident({
+          id: "inc_1a2b3c4d",
+          project: "shop",
+          title: "Checkout errors",
+          status: "resolved",
+          updates: [
+            { time: "2024-05-01T12:00:00.123Z", status: "investigating", message: "Looking into it" },
+            { time: "2024-05-01T12:30:00Z", status: "resolved" },
+          ],
+          created_at: "2024-05-01T12:00:00.123Z",
+          resolved_at: "2024-05-01T12:30:00Z",
+        })
+      ).toEqual([
+        "inc_1a2b3c4d  Checkout errors [resolved] (all apps)",
+        "  2024-05-01 12:00:00 UTC  investigating: Looking into it",
+        "  2024-05-01 12:30:00 UTC  resolved",
+      ]);
+    });
+  });
+});
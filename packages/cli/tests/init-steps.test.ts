@@ -0,0 +1,78 @@
+import { describe, it, expect } from "bun:test";
+import { InitStepSchema, ServiceEntry } from "../src/config/types";
+import { createInitContainerOptions } from "../src/commands/blue-green";
+import { DockerContainerOptions } from "../src/docker";
+import { createServiceConfigHash } from "../src/utils/service-fingerprint";
+
+const web = {
+  name: "web",
+  server: "1.2.3.4",
+  image: "blog",
+  replicas: 1,
+  proxy: { app_port: 3000 },
+  init: [
+    { command: "chown -R 1000:1000 /data", image: "busybox" },
+    {
+      name: "schema",
+      command: "bin/check-schema",
+      environment: { plain: ["STRICT=1"], secret: ["SCHEMA_TOKEN"] },
+    },
+  ],
+} as unknown as ServiceEntry;
+
+const containerOptions: DockerContainerOptions = {
+  name: "blog-web-green-1",
+  image: "blog:abc123",
+  network: "blog-network",
+  networkAliases: ["web"],
+  volumes: ["blog-uploads:/data"],
+  envVars: { DATABASE_URL: "postgres://db/blog", STRICT: "0" },
+  healthCheck: "curl -f localhost:3000/up",
+  resources: { memory: "512m" },
+  labels: { "iop.type": "service" },
+};
+
+describe("init steps", () => {
+  it("should require a command", () => {
+    expect(InitStepSchema.safeParse({ image: "busybox" }).success).toBe(false);
+    expect(InitStepSchema.safeParse({ name: "Bad Name", command: "true" }).success).toBe(false);
+    expect(InitStepSchema.safeParse({ command: "true" }).success).toBe(true);
+  });
+
+  it("should run on the network and volumes of the service", () => {
+    const options = createInitContainerOptions(web, 0, containerOptions, {}, "blog");
+
+    expect(options.name).toBe("blog-web-init-1");
+    expect(options.image).toBe("busybox");
+    expect(options.command).toBe("chown -R 1000:1000 /data");
+    expect(options.network).toBe("blog-network");
+    expect(options.networkAliases).toBeUndefined();
+    expect(options.volumes).toEqual(["blog-uploads:/data"]);
+    expect(options.healthCheck).toBeUndefined();
+    expect(options.resources).toEqual({ memory: "512m" });
+    expect(options.labels).toEqual({
+      "iop.managed": "true",
+      "iop.project": "blog",
+      "iop.type": "init",
+      "iop.service": "web",
+    });
+  });
+
+  it("should default to the service image and add to its environment", () => {
+    const options = createInitContainerOptions(web, 1, containerOptions, { SCHEMA_TOKEN: "t" }, "blog");
+
+    expect(options.name).toBe("blog-web-init-schema");
+    expect(options.image).toBe("blog:abc123");
+    expect(options.envVars).toEqual({
+      DATABASE_URL: "postgres://db/blog",
+      STRICT: "1",
+      SCHEMA_TOKEN: "t",
+    });
+  });
+
+  it("should redeploy when init steps or their secrets change", () => {
+    const hash = createServiceConfigHash(web, { SCHEMA_TOKEN: "a" });
+    expect(createServiceConfigHash(web, { SCHEMA_TOKEN: "b" })).not.toBe(hash);
+    expect(createServiceConfigHash({ ...web, init: [] } as ServiceEntry, { SCHEMA_TOKEN: "a" })).not.toBe(hash);
+  });
+});
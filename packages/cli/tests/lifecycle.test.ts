@@ -0,0 +1,52 @@
+import { describe, it, expect } from "bun:test";
+import {
+  fingerprintFromLabels,
+  parseLifecycleArgs,
+  selectLifecycleServices,
+} from "../src/commands/lifecycle";
+import { ServiceEntry } from "../src/config/types";
+
+const services = [
+  { name: "web", server: "server1.example.com", image: "web:latest", proxy: { app_port: 3000 } },
+  { name: "db", server: "server1.example.com", image: "postgres:16" },
+] as ServiceEntry[];
+
+describe("lifecycle commands", () => {
+  it("should parse names and flags", () => {
+    expect(parseLifecycleArgs(["web", "db", "--verbose"])).toEqual({
+      entryNames: ["web", "db"],
+      verboseFlag: true,
+    });
+  });
+
+  it("should select services in config order", () => {
+    expect(selectLifecycleServices(services, ["db", "web"]).map((s) => s.name)).toEqual([
+      "web",
+      "db",
+    ]);
+  });
+
+  it("should reject names missing from the config", () => {
+    expect(() => selectLifecycleServices(services, ["web", "api"])).toThrow(
+      "Unknown apps or services: api"
+    );
+  });
+
+  it("should keep the fingerprint of the restarted container", () => {
+    expect(
+      fingerprintFromLabels({
+        "iop.fingerprint-type": "built",
+        "iop.config-hash": "4f2a9c1d8e7b",
+        "iop.secrets-hash": "9b1e0c3a5d2f",
+        "iop.context-hash": "1c2d3e4f5a6b",
+      })
+    ).toEqual({
+      type: "built",
+      configHash: "4f2a9c1d8e7b",
+      secretsHash: "9b1e0c3a5d2f",
+      contextHash: "1c2d3e4f5a6b",
+      imageReference: undefined,
+    });
+    expect(fingerprintFromLabels({})).toBeUndefined();
+  });
+});
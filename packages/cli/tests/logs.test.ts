@@ -0,0 +1,112 @@
+import { describe, it, expect } from "bun:test";
+import { IopConfig, LogsConfigSchema, ServiceEntry } from "../src/config/types";
+import { describeLogDestination, parseLogsArgs } from "../src/commands/logs";
+import {
+  buildLogShipperConfig,
+  getHostApps,
+  getLogShipperEnv,
+  getLogShipperFingerprint,
+} from "../src/utils/log-shipper";
+import { findUnknownConfigKeys } from "../src/utils/config-validator";
+
+const logs = LogsConfigSchema.parse({
+  destinations: [
+    { type: "loki", url: "https://logs.example.com", username: "1234", password_secret: "LOKI_TOKEN" },
+    { type: "s3", bucket: "acme-logs", prefix: "iop/", access_key_id_secret: "AWS_KEY", secret_access_key_secret: "AWS_SECRET" },
+    { type: "syslog", address: "syslog.example.com:514" },
+  ],
+});
+const config: IopConfig = { name: "blog", logs };
+const services = [
+  { name: "web", server: "1.2.3.4", proxy: { hosts: ["blog.example.com", "*.blog.example.com"] } },
+  { name: "worker", server: "1.2.3.4" },
+] as ServiceEntry[];
+
+describe("log shipping", () => {
+  it("should default to shipping proxy logs with Vector", () => {
+    expect(logs.proxy).toBe(true);
+    expect(logs.image).toStartWith("timberio/vector:");
+    expect(logs.destinations[2]).toEqual({ type: "syslog", address: "syslog.example.com:514", protocol: "udp" });
+    expect(LogsConfigSchema.safeParse({ destinations: [] }).success).toBe(false);
+    expect(
+      LogsConfigSchema.safeParse({ destinations: [{ type: "syslog", address: "syslog.example.com" }] }).success
+    ).toBe(false);
+  });
+
+  it("should check the keys of each destination type", () => {
+    const errors = findUnknownConfigKeys({
+      name: "blog",
+      logs: { destinations: [{ type: "loki", url: "https://logs.example.com", pasword_secret: "X" }] },
+    });
+    expect(errors.map((error) => error.message)).toEqual([
+      'Unknown key "logs.destinations.0.pasword_secret"',
+    ]);
+  });
+
+  it("should map the server's hosts to their apps", () => {
+    expect(getHostApps(config, services)).toEqual([
+      { host: "blog.example.com", app: "web" },
+      { host: "*.blog.example.com", app: "web" },
+    ]);
+  });
+
+  it("should label app and proxy logs and send them to every destination", () => {
+    const vector: any = buildLogShipperConfig(config, "1.2.3.4", getHostApps(config, services));
+
+    expect(vector.sources.containers.include_labels).toEqual(["iop.project=blog"]);
+    expect(vector.sources.containers.exclude_containers).toEqual(["iop-logs-blog"]);
+    expect(vector.sources.proxy.include_containers).toEqual(["iop-proxy"]);
+    expect(vector.transforms.apps.source).toContain('.app = .label."iop.app" || .label."iop.service"');
+    expect(vector.transforms.access.source).toContain('get!(value: {"blog.example.com":"web"}, path: [host])');
+    expect(vector.transforms.access.source).toContain('ends_with(host, ".blog.example.com")');
+
+    expect(vector.sinks.loki_0.inputs).toEqual(["apps", "access"]);
+    expect(vector.sinks.loki_0.labels.app).toBe("{{ app }}");
+    expect(vector.sinks.loki_0.auth.password).toBe("${IOP_LOGS_0_PASSWORD}");
+    expect(vector.sinks.s3_1.key_prefix).toBe("iop/{{ project }}/{{ app }}/%F/");
+    expect(vector.sinks.s3_1.auth.secret_access_key).toBe("${IOP_LOGS_1_SECRET_ACCESS_KEY}");
+    expect(vector.transforms.syslog_2.inputs).toEqual(["apps", "access"]);
+    expect(vector.sinks.syslog_2).toMatchObject({ type: "socket", inputs: ["syslog_2"], mode: "udp" });
+    expect(vector.sinks.syslog_2.framing).toBeUndefined();
+  });
+
+  it("should skip the proxy without hosts or when disabled", () => {
+    const vector: any = buildLogShipperConfig(config, "1.2.3.4", []);
+    expect(vector.sources.proxy).toBeUndefined();
+    expect(vector.sinks.loki_0.inputs).toEqual(["apps"]);
+
+    const withoutProxy: IopConfig = { name: "blog", logs: { ...logs, proxy: false } };
+    expect(
+      (buildLogShipperConfig(withoutProxy, "1.2.3.4", getHostApps(config, services)) as any).transforms.access
+    ).toBeUndefined();
+  });
+
+  it("should pass secrets through the environment", () => {
+    const secrets = { LOKI_TOKEN: "t0ken", AWS_KEY: "AKIA", AWS_SECRET: "s3cret" };
+    const env = getLogShipperEnv(logs, secrets);
+    expect(env).toEqual({
+      IOP_LOGS_0_PASSWORD: "t0ken",
+      IOP_LOGS_1_ACCESS_KEY_ID: "AKIA",
+      IOP_LOGS_1_SECRET_ACCESS_KEY: "s3cret",
+    });
+    expect(() => getLogShipperEnv(logs, { LOKI_TOKEN: "t0ken" })).toThrow("AWS_KEY");
+
+    // A rotated secret recreates the shipper
+    expect(getLogShipperFingerprint("{}", logs.image, env)).not.toBe(
+      getLogShipperFingerprint("{}", logs.image, { ...env, IOP_LOGS_0_PASSWORD: "new" })
+    );
+  });
+
+  it("should parse subcommands and describe destinations", () => {
+    expect(parseLogsArgs(["status", "--server", "1.2.3.4", "--verbose"])).toEqual({
+      subcommand: "status",
+      server: "1.2.3.4",
+      verboseFlag: true,
+    });
+    expect(logs.destinations.map(describeLogDestination)).toEqual([
+      "loki https://logs.example.com",
+      "s3 s3://acme-logs/iop/",
+      "syslog udp://syslog.example.com:514",
+    ]);
+  });
+});
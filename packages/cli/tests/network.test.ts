@@ -0,0 +1,125 @@
+import { describe, it, expect } from "bun:test";
+import { parseNetworkArgs } from "../src/commands/network";
+import { MeshConfigSchema } from "../src/config/types";
+import {
+  MESH_KEY_PATH,
+  assignMeshAddresses,
+  buildWireGuardConfig,
+  formatHandshake,
+  getMeshEndpoint,
+  parseMeshAddress,
+  parseWireGuardDump,
+} from "../src/utils/mesh";
+
+describe("network", () => {
+  it("should parse subcommand and flags", () => {
+    expect(parseNetworkArgs(["status", "--server", "b.example.com", "--verbose"])).toEqual({
+      subcommand: "status",
+      server: "b.example.com",
+      verboseFlag: true,
+    });
+    expect(parseNetworkArgs([]).subcommand).toBe("");
+  });
+
+  it("should default the mesh subnet and port", () => {
+    expect(MeshConfigSchema.parse({})).toEqual({ subnet: "10.210.0.0/24", port: 51820 });
+    expect(MeshConfigSchema.safeParse({ subnet: "10.210.0.0" }).success).toBe(false);
+    expect(MeshConfigSchema.safeParse({ subnet: "10.0.0.0/8" }).success).toBe(false);
+  });
+
+  describe("assignMeshAddresses", () => {
+    it("should hand out the lowest free addresses", () => {
+      expect(assignMeshAddresses("10.210.0.0/24", ["a", "b", "c"], {})).toEqual({
+        a: "10.210.0.1",
+        b: "10.210.0.2",
+        c: "10.210.0.3",
+      });
+    });
+
+    it("should keep existing addresses when servers are added", () => {
+      expect(
+        assignMeshAddresses("10.210.0.0/24", ["a", "b", "new"], {
+          a: "10.210.0.2",
+          b: "10.210.0.7",
+        })
+      ).toEqual({ a: "10.210.0.2", b: "10.210.0.7", new: "10.210.0.1" });
+    });
+
+    it("should renumber addresses outside the subnet or taken twice", () => {
+      expect(
+        assignMeshAddresses("10.210.0.0/24", ["a", "b", "c"], {
+          a: "10.210.0.1",
+          b: "10.210.0.1",
+          c: "10.99.0.4",
+        })
+      ).toEqual({ a: "10.210.0.1", b: "10.210.0.2", c: "10.210.0.3" });
+    });
+
+    it("should fail when the subnet is full", () => {
+      expect(() => assignMeshAddresses("10.210.0.0/30", ["a", "b", "c"], {})).toThrow(
+        "no free address left for c"
+      );
+    });
+  });
+
+  it("should build endpoints from the SSH address or mesh.endpoints", () => {
+    expect(getMeshEndpoint("203.0.113.10")).toBe("203.0.113.10:51820");
+    expect(getMeshEndpoint("2001:db8::10", { port: 51821 })).toBe("[2001:db8::10]:51821");
+    expect(getMeshEndpoint("10.0.0.5", { endpoints: { "10.0.0.5": "db.example.com" } })).toBe(
+      "db.example.com:51820"
+    );
+  });
+
+  it("should build a config with every other server as a peer", () => {
+    const peers = [
+      { server: "a.example.com", address: "10.210.0.1", publicKey: "A=", endpoint: "a.example.com:51820" },
+      { server: "b.example.com", address: "10.210.0.2", publicKey: "B=", endpoint: "b.example.com:51820" },
+    ];
+    const config = buildWireGuardConfig(peers[0], peers, "10.210.0.0/24", 51820);
+
+    expect(config).toContain("Address = 10.210.0.1/24\nListenPort = 51820\n");
+    expect(config).toContain(`PostUp = wg set %i private-key ${MESH_KEY_PATH}`);
+    expect(config).not.toContain("PrivateKey");
+    expect(config).not.toContain("PublicKey = A=");
+    expect(config).toContain(
+      "# b.example.com\n[Peer]\nPublicKey = B=\nAllowedIPs = 10.210.0.2/32\nEndpoint = b.example.com:51820\n"
+    );
+    expect(parseMeshAddress(config)).toBe("10.210.0.1");
+    expect(parseMeshAddress("")).toBeUndefined();
+  });
+
+  it("should parse peers from wg show dump", () => {
+    const dump = [
+      "cHJpdmF0ZQ==\tQQ==\t51820\toff",
+      "Qg==\t(none)\t203.0.113.11:51820\t10.210.0.2/32\t1700000000\t1024\t2048\t25",
+      "Qw==\t(none)\t(none)\t10.210.0.3/32\t0\t0\t0\t25",
+    ].join("\n");
+
+    expect(parseWireGuardDump(dump)).toEqual([
+      {
+        publicKey: "Qg==",
+        endpoint: "203.0.113.11:51820",
+        allowedIps: ["10.210.0.2/32"],
+        latestHandshake: 1700000000,
+        rxBytes: 1024,
+        txBytes: 2048,
+      },
+      {
+        publicKey: "Qw==",
+        endpoint: undefined,
+        allowedIps: ["10.210.0.3/32"],
+        latestHandshake: 0,
+        rxBytes: 0,
+        txBytes: 0,
+      },
+    ]);
+    expect(parseWireGuardDump("")).toEqual([]);
+  });
+
+  it("should format handshake ages", () => {
+    expect(formatHandshake(0)).toBe("never");
+    expect(formatHandshake(1000, 1042)).toBe("42s ago");
+    expect(formatHandshake(1000, 1000 + 5 * 60)).toBe("5m ago");
+    expect(formatHandshake(1000, 1000 + 3 * 86400)).toBe("3d ago");
+  });
+});
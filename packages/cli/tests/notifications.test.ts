@@ -0,0 +1,50 @@
+import { describe, it, expect } from "bun:test";
+import { buildNotificationTargets } from "../src/utils/notifications";
+import { IopConfigSchema } from "../src/config/types";
+
+describe("notifications", () => {
+  const config = IopConfigSchema.parse({
+    name: "blog",
+    notifications: [
+      { type: "slack", url_secret: "SLACK_WEBHOOK_URL", events: ["deployment.failed", "cert"] },
+      { type: "webhook", url_secret: "HOOK_URL", template: "{{.Text}}" },
+    ],
+  });
+
+  it("should resolve webhook URLs from secrets", () => {
+    const targets = buildNotificationTargets(config, {
+      SLACK_WEBHOOK_URL: "https://hooks.slack.com/services/x",
+      HOOK_URL: "https://example.com/hook",
+    });
+
+    expect(targets).toEqual([
+      {
+        type: "slack",
+        url: "https://hooks.slack.com/services/x",
+        events: ["deployment.failed", "cert"],
+        template: undefined,
+      },
+      {
+        type: "webhook",
+        url: "https://example.com/hook",
+        events: undefined,
+        template: "{{.Text}}",
+      },
+    ]);
+  });
+
+  it("should fail when a URL secret is missing", () => {
+    expect(() =>
+      buildNotificationTargets(config, { SLACK_WEBHOOK_URL: "https://hooks.slack.com/x" })
+    ).toThrow('Notification URL "HOOK_URL" not found in secrets');
+  });
+
+  it("should reject unknown target types", () => {
+    expect(() =>
+      IopConfigSchema.parse({
+        name: "blog",
+        notifications: [{ type: "email", url_secret: "X" }],
+      })
+    ).toThrow();
+  });
+});
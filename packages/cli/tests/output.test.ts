@@ -0,0 +1,39 @@
+import { describe, it, expect } from "bun:test";
+import {
+  isJsonOutput,
+  progressStream,
+  resolveOutputMode,
+  setOutputMode,
+} from "../src/utils/output";
+
+describe("output mode", () => {
+  it("should use json with the --json flag", () => {
+    expect(resolveOutputMode(["status", "--json"], {})).toBe("json");
+  });
+
+  it("should use json when IOP_OUTPUT is json", () => {
+    expect(resolveOutputMode(["status"], { IOP_OUTPUT: "json" })).toBe("json");
+    expect(resolveOutputMode(["status"], { IOP_OUTPUT: "JSON" })).toBe("json");
+  });
+
+  it("should default to text", () => {
+    expect(resolveOutputMode(["status"], {})).toBe("text");
+    expect(resolveOutputMode(["status"], { IOP_OUTPUT: "text" })).toBe("text");
+    expect(isJsonOutput()).toBe(false);
+  });
+
+  it("should send progress to stderr in json mode without patching stdout", () => {
+    const write = process.stdout.write;
+    const log = console.log;
+    try {
+      setOutputMode("json");
+      expect(isJsonOutput()).toBe(true);
+      expect(progressStream()).toBe(process.stderr);
+      expect(process.stdout.write).toBe(write);
+      expect(console.log).toBe(log);
+    } finally {
+      setOutputMode("text");
+    }
+    expect(progressStream()).toBe(process.stdout);
+  });
+});
@@ -0,0 +1,93 @@
+import { describe, it, expect } from "bun:test";
+import {
+  parsePortsArgs,
+  parsePortSpec,
+  resolvePortTarget,
+} from "../src/commands/ports";
+import { getForwardedPortBindings } from "../src/setup-proxy";
+
+describe("ports", () => {
+  describe("parsePortSpec", () => {
+    it("should default to tcp", () => {
+      expect(parsePortSpec("5432")).toEqual({ listen: 5432, protocol: "tcp" });
+    });
+
+    it("should accept an explicit protocol", () => {
+      expect(parsePortSpec("27015/udp")).toEqual({
+        listen: 27015,
+        protocol: "udp",
+      });
+      expect(parsePortSpec("6379/TCP")).toEqual({
+        listen: 6379,
+        protocol: "tcp",
+      });
+    });
+
+    it("should reject invalid ports", () => {
+      expect(() => parsePortSpec("postgres")).toThrow();
+      expect(() => parsePortSpec("70000")).toThrow();
+      expect(() => parsePortSpec("5432/sctp")).toThrow();
+    });
+  });
+
+  describe("resolvePortTarget", () => {
+    it("should default the container port to the listen port", () => {
+      expect(resolvePortTarget("blog", "db", 5432)).toEqual({
+        serviceName: "db",
+        target: "blog-db:5432",
+      });
+    });
+
+    it("should use an explicit container port", () => {
+      expect(resolvePortTarget("blog", "cache:6379", 6380).target).toBe(
+        "blog-cache:6379"
+      );
+      expect(() => resolvePortTarget("blog", "cache:redis", 6380)).toThrow();
+    });
+  });
+
+  describe("parsePortsArgs", () => {
+    it("should collect repeated and comma-separated allow entries", () => {
+      const parsed = parsePortsArgs([
+        "add",
+        "5432",
+        "db",
+        "--allow",
+        "10.0.0.0/8,203.0.113.7",
+        "--allow",
+        "192.168.1.0/24",
+        "--server",
+        "1.2.3.4",
+      ]);
+      expect(parsed.subcommand).toBe("add");
+      expect(parsed.portSpec).toBe("5432");
+      expect(parsed.serviceSpec).toBe("db");
+      expect(parsed.allow).toEqual([
+        "10.0.0.0/8",
+        "203.0.113.7",
+        "192.168.1.0/24",
+      ]);
+      expect(parsed.server).toBe("1.2.3.4");
+    });
+  });
+
+  describe("getForwardedPortBindings", () => {
+    it("should publish every forwarded port with its protocol", () => {
+      const state = JSON.stringify({
+        ports: [
+          { listen: 5432, protocol: "tcp", target: "blog-db:5432" },
+          { listen: 27015, protocol: "udp", target: "game-server:27015" },
+        ],
+      });
+      expect(getForwardedPortBindings(state)).toEqual([
+        "5432:5432",
+        "27015:27015/udp",
+      ]);
+    });
+
+    it("should publish nothing without a readable state file", () => {
+      expect(getForwardedPortBindings("")).toEqual([]);
+      expect(getForwardedPortBindings(JSON.stringify({ projects: {} }))).toEqual([]);
+    });
+  });
+});
@@ -0,0 +1,67 @@
+import { describe, it, expect } from "bun:test";
+import {
+  buildPreviewConfig,
+  getPreviewProjectName,
+  parsePreviewArgs,
+  slugifyBranch,
+} from "../src/commands/preview";
+import { IopConfig } from "../src/config/types";
+
+describe("preview environments", () => {
+  it("should turn branch names into DNS-safe labels", () => {
+    expect(slugifyBranch("feature/Login_Form")).toBe("feature-login-form");
+    expect(slugifyBranch("--fix--")).toBe("fix");
+    expect(slugifyBranch("a".repeat(80))).toHaveLength(40);
+  });
+
+  it("should parse deploy and rm arguments", () => {
+    expect(parsePreviewArgs(["--branch", "pr-42", "web", "--verbose"])).toEqual({
+      subcommand: "deploy",
+      branch: "pr-42",
+      verboseFlag: true,
+      deployArgs: ["web", "--verbose"],
+    });
+    expect(parsePreviewArgs(["rm", "pr-42"])).toEqual({
+      subcommand: "rm",
+      branch: "pr-42",
+      verboseFlag: false,
+      deployArgs: [],
+    });
+  });
+
+  describe("buildPreviewConfig", () => {
+    const config = {
+      name: "blog",
+      preview: { domain: "preview.example.com" },
+      services: {
+        web: {
+          image: "blog/web",
+          server: "1.2.3.4",
+          proxy: { hosts: ["blog.com"], app_port: 3000 },
+        },
+        db: { image: "postgres:16", server: "1.2.3.4" },
+      },
+    } as unknown as IopConfig;
+
+    it("should run under its own project name", () => {
+      expect(buildPreviewConfig(config, "pr-42").name).toBe(
+        getPreviewProjectName("blog", "pr-42")
+      );
+    });
+
+    it("should replace proxy hosts with the preview host", () => {
+      const services = buildPreviewConfig(config, "pr-42").services as any[];
+      const web = services.find((service) => service.name === "web");
+      expect(web.proxy).toEqual({
+        hosts: ["pr-42.web.preview.example.com"],
+        app_port: 3000,
+      });
+      const db = services.find((service) => service.name === "db");
+      expect(db.proxy).toBeUndefined();
+    });
+
+    it("should require a preview domain", () => {
+      expect(() => buildPreviewConfig({ name: "blog" }, "pr-42")).toThrow("preview.domain");
+    });
+  });
+});
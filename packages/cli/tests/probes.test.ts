@@ -0,0 +1,112 @@
+import { describe, expect, test } from "bun:test";
+import { IopConfig, ServiceEntry, ServiceEntryWithoutNameSchema } from "../src/config/types";
+import { getStartupProbe } from "../src/commands/blue-green";
+import { toHostProbes } from "../src/commands/deploy";
+import { DockerClient } from "../src/docker";
+import { IopProxyClient } from "../src/proxy";
+import { SSHClient } from "../src/ssh";
+import { validateConfig } from "../src/utils/config-validator";
+
+const web = {
+  name: "web",
+  image: "shop",
+  server: "1.2.3.4",
+  proxy: { hosts: ["shop.example.com"], app_port: 3000 },
+  health_check: {
+    path: "/up",
+    startup: { path: "/started", interval: "2s", failure_threshold: 60 },
+    readiness: { path: "/ready", interval: "5s" },
+    liveness: { failure_threshold: 5 },
+  },
+} as unknown as ServiceEntry;
+
+describe("health check probes", () => {
+  test("should validate probe config", () => {
+    const parse = (health_check: unknown) =>
+      ServiceEntryWithoutNameSchema.safeParse({ image: "shop", server: "1.2.3.4", health_check });
+
+    expect(parse(web.health_check).success).toBe(true);
+    for (const probe of [
+      { path: "ready" },
+      { interval: "soon" },
+      { interval: "500ms" },
+      { failure_threshold: 0 },
+    ]) {
+      expect(parse({ readiness: probe }).success).toBe(false);
+    }
+  });
+
+  test("should check new containers with the startup probe", async () => {
+    expect(getStartupProbe(web)).toEqual({ path: "/started", intervalMs: 2000, attempts: 60 });
+    expect(
+      getStartupProbe({ ...web, health_check: { path: "/health" } } as ServiceEntry)
+    ).toEqual({ path: "/health", intervalMs: 1000, attempts: 30 });
+
+    const commands: string[] = [];
+    const sshClient = {
+      exec: async (command: string) => {
+        commands.push(command);
+        return "503";
+      },
+    } as unknown as SSHClient;
+    const dockerClient = new DockerClient(sshClient);
+
+    const healthy = await dockerClient.checkHealthWithIopProxy("iop-proxy", "web", "shop-web-green", "shop", 3000, {
+      path: "/started",
+      intervalMs: 1,
+      attempts: 3,
+    });
+    expect(healthy).toBe(false);
+    const checks = commands.filter((command) => command.includes("curl -s"));
+    expect(checks).toHaveLength(3);
+    expect(checks[0]).toContain("http://shop-web:3000/started");
+  });
+
+  test("should send readiness and liveness probes to the proxy", async () => {
+    expect(toHostProbes(web)).toEqual({
+      readiness: { path: "/ready", interval: "5s" },
+      liveness: { failure_threshold: 5 },
+    });
+    expect(toHostProbes({ ...web, health_check: { path: "/up" } } as ServiceEntry)).toBeNull();
+
+    const commands: string[] = [];
+    const dockerClient = {
+      execInContainer: async (_container: string, command: string) => {
+        commands.push(command);
+        return { success: true, output: "" };
+      },
+    } as unknown as DockerClient;
+    const proxyClient = new IopProxyClient(dockerClient, "1.2.3.4");
+
+    expect(await proxyClient.setHostProbes("shop.example.com", toHostProbes(web))).toBe(true);
+    expect(await proxyClient.setHostProbes("shop.example.com", null)).toBe(true);
+    expect(commands).toEqual([
+      "/usr/local/bin/iop-proxy probes set --host 'shop.example.com' --readiness --readiness-path '/ready' --readiness-interval '5s' --liveness --liveness-failures 5",
+      "/usr/local/bin/iop-proxy probes reset --host 'shop.example.com'",
+    ]);
+  });
+
+  test("should reject probes nothing runs", () => {
+    const config = {
+      name: "shop",
+      services: {
+        web,
+        jobs: { image: "shop", server: "1.2.3.4", type: "worker", health_check: { startup: {} } },
+        api: { image: "shop", server: "1.2.3.4", health_check: { readiness: {} } },
+        tls: {
+          image: "shop",
+          server: "1.2.3.4",
+          proxy: { hosts: ["tls.example.com"], mode: "passthrough" },
+          health_check: { liveness: {} },
+        },
+      },
+    } as unknown as IopConfig;
+
+    const errors = validateConfig(config).filter((error) => error.type === "configuration_error");
+    expect(errors.map((error) => error.message)).toEqual([
+      "Worker jobs has health check probes",
+      "Service api has readiness or liveness probes but no proxy configuration",
+      "Service tls has a liveness probe but its proxy mode is passthrough",
+    ]);
+  });
+});
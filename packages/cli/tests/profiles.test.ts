@@ -0,0 +1,93 @@
+import { describe, it, expect } from "bun:test";
+import { applyConfigEnvironment, mergeConfigOverrides } from "../src/config/profiles";
+import { resolveConfigEnvironment } from "../src/config";
+import { IopConfigSchema } from "../src/config/types";
+
+const rawConfig = {
+  name: "shop",
+  github: { repository: "acme/shop" },
+  services: {
+    web: {
+      image: "shop",
+      server: "prod1.example.com",
+      replicas: 3,
+      proxy: { hosts: ["shop.example.com"], app_port: 3000 },
+      environment: { plain: ["LOG_LEVEL=warn", "TZ=UTC"] },
+    },
+    metrics: { image: "prom/prometheus", server: "prod1.example.com" },
+  },
+  environments: {
+    staging: {
+      services: {
+        web: {
+          server: "staging.example.com",
+          replicas: 1,
+          proxy: { hosts: ["staging.shop.example.com"] },
+          environment: { plain: ["LOG_LEVEL=debug"] },
+        },
+        metrics: null,
+      },
+    },
+    demo: { name: "shop-demo-eu", github: { environment: "demo-eu" } },
+    empty: null,
+  },
+};
+
+describe("config environments", () => {
+  it("should merge mappings, replace lists and values, and drop nulls", () => {
+    expect(
+      mergeConfigOverrides(
+        { a: { b: 1, c: [1, 2] }, d: "x", e: true },
+        { a: { c: [3] }, d: null, f: 2 }
+      )
+    ).toEqual({ a: { b: 1, c: [3] }, e: true, f: 2 });
+  });
+
+  it("should apply an environment as its own project", () => {
+    const staging = applyConfigEnvironment(rawConfig, "staging") as any;
+
+    expect(staging.name).toBe("shop-staging");
+    expect(staging.environments).toBeUndefined();
+    expect(staging.github).toEqual({ repository: "acme/shop", environment: "staging" });
+    expect(Object.keys(staging.services)).toEqual(["web"]);
+    expect(staging.services.web).toEqual({
+      image: "shop",
+      server: "staging.example.com",
+      replicas: 1,
+      proxy: { hosts: ["staging.shop.example.com"], app_port: 3000 },
+      environment: { plain: ["LOG_LEVEL=debug"] },
+    });
+    expect(IopConfigSchema.safeParse(staging).success).toBe(true);
+  });
+
+  it("should keep names and GitHub environments an environment sets", () => {
+    const demo = applyConfigEnvironment(rawConfig, "demo") as any;
+    expect(demo.name).toBe("shop-demo-eu");
+    expect(demo.github.environment).toBe("demo-eu");
+
+    expect((applyConfigEnvironment(rawConfig, "empty") as any).name).toBe("shop-empty");
+  });
+
+  it("should use the file as written without an environment", () => {
+    expect(applyConfigEnvironment(rawConfig, undefined)).toBe(rawConfig);
+    expect(IopConfigSchema.safeParse(rawConfig).success).toBe(true);
+  });
+
+  it("should reject unknown environments", () => {
+    expect(() => applyConfigEnvironment(rawConfig, "prod")).toThrow(
+      'Unknown environment "prod" in iop.yml. Defined environments: staging, demo, empty'
+    );
+  });
+
+  it("should read --env and IOP_ENV", () => {
+    expect(resolveConfigEnvironment(["status", "--env", "staging", "--verbose"], {})).toEqual({
+      environment: "staging",
+      args: ["status", "--verbose"],
+    });
+    expect(resolveConfigEnvironment(["--env=demo"], { IOP_ENV: "staging" }).environment).toBe("demo");
+    expect(resolveConfigEnvironment(["status"], { IOP_ENV: "staging" }).environment).toBe("staging");
+    expect(resolveConfigEnvironment(["status"], {}).environment).toBeUndefined();
+    expect(() => resolveConfigEnvironment(["--env"], {})).toThrow('Invalid environment ""');
+    expect(() => resolveConfigEnvironment(["--env", "Prod"], {})).toThrow("Invalid environment");
+  });
+});
@@ -0,0 +1,127 @@
+import { describe, it, expect } from "bun:test";
+import {
+  canRemoveProjectNetwork,
+  checkProjectNetwork,
+  getProjectNetworkLabels,
+  ProjectNetworkInfo,
+} from "../src/utils/project-network";
+import { IopConfigSchema } from "../src/config/types";
+
+const network = (
+  overrides: Partial<ProjectNetworkInfo> = {}
+): ProjectNetworkInfo => ({
+  name: "blog-network",
+  driver: "bridge",
+  subnets: ["172.28.0.0/16"],
+  labels: getProjectNetworkLabels("blog"),
+  containers: [],
+  ...overrides,
+});
+
+describe("project network", () => {
+  describe("checkProjectNetwork", () => {
+    it("should accept the project's own network", () => {
+      const check = checkProjectNetwork(
+        "blog",
+        network({ containers: [{ name: "blog-web", project: "blog" }] })
+      );
+      expect(check.conflict).toBeUndefined();
+      expect(check.warnings).toEqual([]);
+    });
+
+    it("should reject a network labelled for another project", () => {
+      // "My Blog" and "my-blog" share the network name my-blog-network
+      const check = checkProjectNetwork(
+        "My Blog",
+        network({ labels: getProjectNetworkLabels("my-blog") })
+      );
+      expect(check.conflict).toContain('belongs to project "my-blog"');
+    });
+
+    it("should accept unlabelled networks used only by the project and the proxy", () => {
+      const check = checkProjectNetwork(
+        "blog",
+        network({
+          labels: {},
+          containers: [
+            { name: "iop-proxy" },
+            { name: "blog-web", project: "blog" },
+          ],
+        })
+      );
+      expect(check.conflict).toBeUndefined();
+    });
+
+    it("should reject unlabelled networks used by other containers", () => {
+      const check = checkProjectNetwork(
+        "blog",
+        network({
+          labels: {},
+          containers: [{ name: "postgres" }, { name: "iop-proxy" }],
+        })
+      );
+      expect(check.conflict).toContain("used by postgres");
+    });
+
+    it("should warn when the driver or subnet differ from the config", () => {
+      const check = checkProjectNetwork("blog", network(), {
+        driver: "overlay",
+        subnet: "10.10.0.0/24",
+      });
+      expect(check.conflict).toBeUndefined();
+      expect(check.warnings).toHaveLength(2);
+      expect(check.warnings[0]).toContain("driver bridge, not overlay");
+      expect(check.warnings[1]).toContain("subnet 172.28.0.0/16, not 10.10.0.0/24");
+    });
+  });
+
+  describe("canRemoveProjectNetwork", () => {
+    it("should remove the project's network once only the proxy is left", () => {
+      expect(
+        canRemoveProjectNetwork(
+          "blog",
+          network({ containers: [{ name: "iop-proxy" }] })
+        )
+      ).toBe(true);
+    });
+
+    it("should keep networks still in use", () => {
+      expect(
+        canRemoveProjectNetwork(
+          "blog",
+          network({ containers: [{ name: "blog-web", project: "blog" }] })
+        )
+      ).toBe(false);
+    });
+
+    it("should keep unlabelled and other projects' networks", () => {
+      expect(canRemoveProjectNetwork("blog", network({ labels: {} }))).toBe(
+        false
+      );
+      expect(
+        canRemoveProjectNetwork(
+          "blog",
+          network({ labels: getProjectNetworkLabels("shop") })
+        )
+      ).toBe(false);
+    });
+  });
+
+  describe("config", () => {
+    it("should accept a subnet in CIDR form", () => {
+      const result = IopConfigSchema.safeParse({
+        name: "blog",
+        network: { driver: "bridge", subnet: "172.28.0.0/16" },
+      });
+      expect(result.success).toBe(true);
+    });
+
+    it("should reject a subnet without a prefix length", () => {
+      const result = IopConfigSchema.safeParse({
+        name: "blog",
+        network: { subnet: "172.28.0.0" },
+      });
+      expect(result.success).toBe(false);
+    });
+  });
+});
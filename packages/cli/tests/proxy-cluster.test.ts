@@ -0,0 +1,56 @@
+import { describe, it, expect } from "bun:test";
+import {
+  aggregateHosts,
+  findDuplicateHosts,
+  findStaleHostRegistrations,
+  getProxyServers,
+} from "../src/proxy/cluster";
+import { ProxyHostInfo } from "../src/proxy";
+import { IopConfig } from "../src/config/types";
+
+function host(project: string, target: string): ProxyHostInfo {
+  return { project, target, app: "web", ssl_enabled: true, healthy: true };
+}
+
+const config = {
+  name: "blog",
+  services: {
+    web: { image: "blog", server: "10.0.0.2", proxy: { hosts: ["blog.com"], app_port: 3000 } },
+    db: { image: "postgres", server: "10.0.0.3" },
+  },
+  proxy: { servers: ["10.0.0.1", "10.0.0.2"] },
+} as unknown as IopConfig;
+
+const proxyHostsByServer = new Map([
+  ["10.0.0.1", { "blog.com": host("blog", "blog-web:3000"), "shop.com": host("shop", "shop-web:80") }],
+  ["10.0.0.2", { "blog.com": host("blog", "blog-web:3000") }],
+  ["10.0.0.3", { "shop.com": host("shop", "shop-web:80"), "docs.com": host("docs", "docs-web:80") }],
+]);
+
+describe("proxy cluster", () => {
+  it("should include the extra proxy servers once", () => {
+    expect(getProxyServers(config)).toEqual(["10.0.0.2", "10.0.0.3", "10.0.0.1"]);
+  });
+
+  it("should aggregate hosts across proxies", () => {
+    const hosts = aggregateHosts(proxyHostsByServer);
+
+    expect(Object.keys(hosts)).toEqual(["blog.com", "shop.com", "docs.com"]);
+    expect(hosts["blog.com"].map((registration) => registration.server)).toEqual([
+      "10.0.0.1",
+      "10.0.0.2",
+    ]);
+    expect(hosts["docs.com"]).toEqual([{ ...host("docs", "docs-web:80"), server: "10.0.0.3" }]);
+  });
+
+  it("should detect hosts registered on multiple proxies", () => {
+    const duplicates = findDuplicateHosts(aggregateHosts(proxyHostsByServer));
+    expect(Object.keys(duplicates)).toEqual(["blog.com", "shop.com"]);
+  });
+
+  it("should find the project's hosts left on other proxies", () => {
+    expect(findStaleHostRegistrations(config, aggregateHosts(proxyHostsByServer))).toEqual([
+      { host: "blog.com", service: "web", server: "10.0.0.1", owner: "10.0.0.2" },
+    ]);
+  });
+});
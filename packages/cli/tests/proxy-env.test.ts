@@ -0,0 +1,46 @@
+import { describe, it, expect } from "bun:test";
+import { IopConfigSchema } from "../src/config/types";
+import { getProxyEnvVars } from "../src/setup-proxy";
+
+describe("proxy environment", () => {
+  it("should pass tracing and Sentry settings to the proxy", () => {
+    const config = IopConfigSchema.parse({
+      name: "blog",
+      proxy: {
+        tracing: { endpoint: "http://otel-collector:4318" },
+        sentry: { dsn: "https://abc123@o1.ingest.sentry.io/4505", environment: "production" },
+      },
+    });
+
+    expect(getProxyEnvVars(config, "web1.example.com")).toEqual({
+      OTEL_EXPORTER_OTLP_ENDPOINT: "http://otel-collector:4318",
+      OTEL_SERVICE_NAME: "iop-proxy",
+      SENTRY_DSN: "https://abc123@o1.ingest.sentry.io/4505",
+      SENTRY_SERVER_NAME: "web1.example.com",
+      SENTRY_ENVIRONMENT: "production",
+    });
+  });
+
+  it("should pass the internal networks to the proxy", () => {
+    const config = IopConfigSchema.parse({
+      name: "blog",
+      proxy: { internal_networks: ["10.8.0.0/24", "fd7a:115c:a1e0::/48", "198.51.100.7"] },
+    });
+
+    expect(getProxyEnvVars(config, "web1.example.com")).toEqual({
+      IOP_INTERNAL_NETWORKS: "10.8.0.0/24,fd7a:115c:a1e0::/48,198.51.100.7",
+    });
+    for (const network of ["vpn", "10.8.0.0/33", "10.8.0.0/24/1"]) {
+      expect(
+        IopConfigSchema.safeParse({ name: "blog", proxy: { internal_networks: [network] } }).success
+      ).toBe(false);
+    }
+  });
+
+  it("should leave the environment empty without either", () => {
+    expect(getProxyEnvVars(IopConfigSchema.parse({ name: "blog" }), "web1.example.com")).toBeUndefined();
+    expect(
+      IopConfigSchema.safeParse({ name: "blog", proxy: { sentry: { dsn: "not a url" } } }).success
+    ).toBe(false);
+  });
+});
@@ -0,0 +1,119 @@
+import { describe, it, expect } from "bun:test";
+import {
+  findOrphans,
+  formatPsTable,
+  formatUptime,
+  parsePsArgs,
+  toInventoryEntry,
+} from "../src/commands/ps";
+import { ServiceEntry } from "../src/config/types";
+
+const NOW = Date.parse("2026-01-10T12:00:00Z");
+
+function inspect(name: string, labels: Record<string, string>, aliases: string[] = []): any {
+  return {
+    Name: `/${name}`,
+    RestartCount: 0,
+    Config: { Image: "blog-web:a1b2c3d", Labels: { "iop.managed": "true", ...labels } },
+    State: { Status: "running", Running: true, StartedAt: "2026-01-10T09:45:00Z" },
+    NetworkSettings: {
+      Ports: {},
+      Networks: { "blog-network": { Aliases: aliases } },
+    },
+  };
+}
+
+const services = [
+  { name: "web", server: "server1.example.com", image: "blog-web", proxy: { app_port: 3000 } },
+  { name: "db", server: "server1.example.com", image: "postgres:16" },
+] as ServiceEntry[];
+
+describe("ps", () => {
+  it("should parse flags", () => {
+    expect(parsePsArgs([])).toEqual({
+      all: false,
+      orphans: false,
+      server: undefined,
+      verboseFlag: false,
+    });
+    expect(parsePsArgs(["--all", "--orphans", "--server", "server1.com"])).toEqual({
+      all: true,
+      orphans: true,
+      server: "server1.com",
+      verboseFlag: false,
+    });
+  });
+
+  it("should format uptime", () => {
+    expect(formatUptime(42 * 1000)).toBe("42s");
+    expect(formatUptime(135 * 60 * 1000)).toBe("2h 15m");
+    expect(formatUptime(76 * 60 * 60 * 1000)).toBe("3d 4h");
+  });
+
+  it("should read the inventory from docker inspect", () => {
+    const container = inspect(
+      "blog-web-blue-1",
+      { "iop.project": "blog", "iop.type": "service", "iop.app": "web", "iop.color": "blue" },
+      ["web"]
+    );
+    container.RestartCount = 2;
+    container.NetworkSettings.Ports = { "3000/tcp": [{ HostIp: "0.0.0.0", HostPort: "3000" }] };
+
+    expect(toInventoryEntry(container, NOW)).toEqual({
+      name: "blog-web-blue-1",
+      image: "blog-web:a1b2c3d",
+      project: "blog",
+      type: "app",
+      entry: "web",
+      color: "blue",
+      sidecarOf: undefined,
+      state: "running",
+      uptime: "2h 15m",
+      restarts: 2,
+      ports: ["3000->3000/tcp"],
+      networks: ["blog-network"],
+      aliases: ["web"],
+    });
+
+    const proxy = toInventoryEntry({ ...inspect("iop-proxy", {}), Config: { Image: "elitan/iop-proxy:latest" } }, NOW);
+    expect(proxy.type).toBe("proxy");
+  });
+
+  it("should flag containers the current state doesn't reference", () => {
+    const containers = [
+      inspect("blog-web-green-1", { "iop.project": "blog", "iop.type": "service", "iop.app": "web", "iop.color": "green" }, ["web"]),
+      inspect("blog-web-blue-1", { "iop.project": "blog", "iop.type": "service", "iop.app": "web", "iop.color": "blue" }),
+      inspect("blog-api-blue-1", { "iop.project": "blog", "iop.type": "service", "iop.app": "api", "iop.color": "blue" }, ["api"]),
+      inspect("blog-db", { "iop.project": "blog", "iop.type": "service", "iop.service": "db" }, ["db"]),
+      inspect("blog-cache", { "iop.project": "blog", "iop.type": "service", "iop.service": "cache" }, ["cache"]),
+      inspect("blog-web-blue-1-logs", { "iop.project": "blog", "iop.type": "sidecar", "iop.sidecar": "logs", "iop.sidecar-of": "blog-web-blue-1" }),
+      inspect("blog-web-green-0-logs", { "iop.project": "blog", "iop.type": "sidecar", "iop.sidecar": "logs", "iop.sidecar-of": "blog-web-green-0" }),
+      inspect("shop-web-blue-1", { "iop.project": "shop", "iop.type": "service", "iop.app": "web", "iop.color": "blue" }),
+    ].map((container) => toInventoryEntry(container, NOW));
+
+    const orphans = Object.fromEntries(
+      findOrphans(containers, "blog", services).map((c) => [c.name, c.orphan])
+    );
+    expect(orphans).toEqual({
+      "blog-web-green-1": undefined,
+      "blog-web-blue-1": "inactive blue color",
+      "blog-api-blue-1": "app not in iop.yml",
+      "blog-db": undefined,
+      "blog-cache": "service not in iop.yml",
+      "blog-web-blue-1-logs": undefined,
+      "blog-web-green-0-logs": "app container is gone",
+      "shop-web-blue-1": undefined,
+    });
+  });
+
+  it("should align the table", () => {
+    const entry = toInventoryEntry(
+      inspect("blog-db", { "iop.project": "blog", "iop.type": "service", "iop.service": "cache" }),
+      NOW
+    );
+    expect(formatPsTable([{ ...entry, orphan: "service not in iop.yml" }])).toEqual([
+      "NAME     IMAGE             COLOR  STATE    UPTIME  RESTARTS  PORTS  NETWORK",
+      "blog-db  blog-web:a1b2c3d  -      running  2h 15m  0         -      blog-network  orphan: service not in iop.yml",
+    ]);
+  });
+});
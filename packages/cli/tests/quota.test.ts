@@ -0,0 +1,45 @@
+import { describe, it, expect } from "bun:test";
+import { formatQuotaUsage } from "../src/commands/status";
+import { ServiceEntry } from "../src/config/types";
+import { buildQuotaWorkloads, parseByteSize } from "../src/utils/quota";
+
+describe("quotas", () => {
+  it("should parse Docker byte sizes", () => {
+    expect(parseByteSize("512m")).toBe(512 * 1024 * 1024);
+    expect(parseByteSize("1.5G")).toBe(1.5 * 1024 ** 3);
+    expect(parseByteSize("1024")).toBe(1024);
+    expect(parseByteSize("4gb")).toBeUndefined();
+  });
+
+  it("should count each replica with its sidecars", () => {
+    const services = [
+      {
+        name: "web",
+        server: "a.example.com",
+        image: "shop/web",
+        replicas: 2,
+        proxy: { app_port: 3000 },
+        resources: { cpus: 0.5, memory: "512m" },
+        sidecars: { logs: { image: "fluent/fluent-bit", resources: { cpus: 0.1, memory: "64m" } } },
+      },
+      { name: "postgres", server: "a.example.com", image: "postgres:17" },
+    ] as ServiceEntry[];
+
+    const web = { memory: 512 * 1024 * 1024, cpus: 0.5 };
+    const logs = { memory: 64 * 1024 * 1024, cpus: 0.1 };
+    expect(buildQuotaWorkloads(services)).toEqual([
+      { service: "web", containers: [web, logs, web, logs] },
+      { service: "postgres", containers: [{ memory: undefined, cpus: undefined }] },
+    ]);
+  });
+
+  it("should describe usage against the quota", () => {
+    expect(
+      formatQuotaUsage({
+        project: "shop",
+        quota: { project: "shop", max_containers: 10, max_memory: 4 * 1024 ** 3 },
+        usage: { project: "shop", containers: 3, memory: 1.5 * 1024 ** 3, cpus: 1.5, unlimited: 1 },
+      })
+    ).toBe("3/10 containers, 1.5GiB/4GiB memory, 1.5 CPUs, 1 without limits");
+  });
+});
@@ -0,0 +1,221 @@
+import { describe, it, expect } from "bun:test";
+import {
+  getImageRegistry,
+  isEcrRegistry,
+  resolveRegistryCredentials,
+} from "../src/utils/registry";
+import {
+  BuiltinRegistrySchema,
+  IopConfig,
+  RegistryConfigSchema,
+  ServiceEntry,
+} from "../src/config/types";
+import { getRegistryFingerprint, getRegistryServer } from "../src/utils/builtin-registry";
+
+const service = (overrides: Partial<ServiceEntry>): ServiceEntry =>
+  ({
+    name: "web",
+    server: "1.2.3.4",
+    replicas: 1,
+    ...overrides,
+  } as ServiceEntry);
+
+const config: IopConfig = { name: "blog" };
+
+describe("registry authentication", () => {
+  describe("getImageRegistry", () => {
+    it("should default to Docker Hub", () => {
+      expect(getImageRegistry("postgres:15")).toBe("docker.io");
+      expect(getImageRegistry("acme/web:1.0")).toBe("docker.io");
+    });
+
+    it("should detect registry hosts", () => {
+      expect(getImageRegistry("ghcr.io/acme/web:1.0")).toBe("ghcr.io");
+      expect(getImageRegistry("localhost:5000/web")).toBe("localhost:5000");
+      expect(
+        getImageRegistry("123456789012.dkr.ecr.eu-west-1.amazonaws.com/web:latest")
+      ).toBe("123456789012.dkr.ecr.eu-west-1.amazonaws.com");
+    });
+  });
+
+  it("should recognise ECR registries", () => {
+    expect(isEcrRegistry("123456789012.dkr.ecr.us-east-1.amazonaws.com")).toBe(true);
+    expect(isEcrRegistry("ghcr.io")).toBe(false);
+  });
+
+  describe("schema", () => {
+    it("should require username and password_secret without ecr", () => {
+      expect(RegistryConfigSchema.safeParse({ username: "me" }).success).toBe(false);
+      expect(
+        RegistryConfigSchema.safeParse({ username: "me", password_secret: "TOKEN" }).success
+      ).toBe(true);
+    });
+
+    it("should accept ecr credentials on their own", () => {
+      const result = RegistryConfigSchema.safeParse({
+        ecr: { region: "us-east-1", access_key_secret: "AWS_KEY", secret_key_secret: "AWS_SECRET" },
+      });
+      expect(result.success).toBe(true);
+    });
+  });
+
+  describe("resolveRegistryCredentials", () => {
+    it("should return null for public images without registry config", () => {
+      expect(resolveRegistryCredentials(service({ image: "nginx" }), config, {})).toBeNull();
+    });
+
+    it("should infer the registry from the image for service credentials", () => {
+      const credentials = resolveRegistryCredentials(
+        service({
+          image: "ghcr.io/acme/web:1.0",
+          registry: { username: "acme-bot", password_secret: "GHCR_TOKEN" },
+        }),
+        config,
+        { GHCR_TOKEN: "ghp_123" }
+      );
+      expect(credentials).toEqual({
+        registry: "ghcr.io",
+        kind: "password",
+        username: "acme-bot",
+        password: "ghp_123",
+      });
+    });
+
+    it("should fail loudly when the password secret is missing", () => {
+      expect(() =>
+        resolveRegistryCredentials(
+          service({
+            image: "ghcr.io/acme/web",
+            registry: { username: "acme-bot", password_secret: "GHCR_TOKEN" },
+          }),
+          config,
+          {}
+        )
+      ).toThrow("GHCR_TOKEN");
+    });
+
+    it("should resolve ECR credentials from secrets", () => {
+      const credentials = resolveRegistryCredentials(
+        service({
+          image: "123456789012.dkr.ecr.us-east-1.amazonaws.com/web:1",
+          registry: {
+            ecr: { region: "us-east-1", access_key_secret: "AWS_KEY", secret_key_secret: "AWS_SECRET" },
+          },
+        }),
+        config,
+        { AWS_KEY: "AKIA", AWS_SECRET: "shh" }
+      );
+      expect(credentials).toEqual({
+        registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+        kind: "ecr",
+        region: "us-east-1",
+        accessKeyId: "AKIA",
+        secretAccessKey: "shh",
+      });
+    });
+
+    it("should only use global credentials for images in the global registry", () => {
+      const globalConfig: IopConfig = {
+        name: "blog",
+        docker: { registry: "registry.example.com", username: "deploy" },
+      };
+      const secrets = { DOCKER_REGISTRY_PASSWORD: "pw" };
+
+      expect(
+        resolveRegistryCredentials(
+          service({ image: "registry.example.com/web:1" }),
+          globalConfig,
+          secrets
+        )
+      ).toEqual({
+        registry: "registry.example.com",
+        kind: "password",
+        username: "deploy",
+        password: "pw",
+      });
+      expect(
+        resolveRegistryCredentials(service({ image: "postgres:15" }), globalConfig, secrets)
+      ).toBeNull();
+    });
+
+    it("should honour a custom global password secret", () => {
+      const credentials = resolveRegistryCredentials(
+        service({ image: "acme/private" }),
+        { name: "blog", docker: { username: "acme", password_secret: "HUB_TOKEN" } },
+        { HUB_TOKEN: "dckr_pat" }
+      );
+      expect(credentials?.registry).toBe("docker.io");
+    });
+
+    it("should use the built-in registry's credentials for images pushed to it", () => {
+      const builtinConfig: IopConfig = {
+        name: "blog",
+        registry: {
+          host: "registry.example.com",
+          username: "iop",
+          password_secret: "IOP_REGISTRY_PASSWORD",
+          image: "registry:2",
+        },
+      };
+
+      expect(
+        resolveRegistryCredentials(
+          service({ image: "registry.example.com/web:1" }),
+          builtinConfig,
+          { IOP_REGISTRY_PASSWORD: "pw" }
+        )
+      ).toEqual({
+        registry: "registry.example.com",
+        kind: "password",
+        username: "iop",
+        password: "pw",
+      });
+      expect(() =>
+        resolveRegistryCredentials(
+          service({ image: "registry.example.com/web:1" }),
+          builtinConfig,
+          {}
+        )
+      ).toThrow("IOP_REGISTRY_PASSWORD");
+      expect(
+        resolveRegistryCredentials(service({ image: "postgres:15" }), builtinConfig, {})
+      ).toBeNull();
+    });
+  });
+
+  describe("built-in registry", () => {
+    const registry = BuiltinRegistrySchema.parse({ host: "registry.example.com" });
+
+    it("should default the user, secret and image", () => {
+      expect(registry).toEqual({
+        host: "registry.example.com",
+        username: "iop",
+        password_secret: "IOP_REGISTRY_PASSWORD",
+        image: "registry:2",
+      });
+    });
+
+    it("should run on the first server unless configured", () => {
+      const services = {
+        web: { image: "web", server: "1.2.3.4" },
+        api: { image: "api", server: "5.6.7.8" },
+      };
+      expect(getRegistryServer({ name: "blog", registry, services } as IopConfig)).toBe("1.2.3.4");
+      expect(
+        getRegistryServer({
+          name: "blog",
+          registry: { ...registry, server: "5.6.7.8" },
+          services,
+        } as IopConfig)
+      ).toBe("5.6.7.8");
+      expect(getRegistryServer({ name: "blog", services } as IopConfig)).toBeUndefined();
+    });
+
+    it("should change the fingerprint with the password", () => {
+      const fingerprint = getRegistryFingerprint(registry, "pw");
+      expect(fingerprint).toHaveLength(16);
+      expect(fingerprint).toBe(getRegistryFingerprint(registry, "pw"));
+      expect(fingerprint).not.toBe(getRegistryFingerprint(registry, "other"));
+    });
+  });
+});
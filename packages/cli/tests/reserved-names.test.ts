@@ -44,6 +44,24 @@ describe("Reserved Names Validation", () => {
     expect(errors[0].entries).toEqual(["status"]);
   });
 
+  test("should reject service with reserved name 'db'", () => {
+    const config: IopConfig = {
+      name: "test-project",
+      services: {
+        db: {
+          image: "postgres:15",
+          server: "test.com",
+        },
+      },
+    };
+
+    const errors = validateConfig(config);
+    
+    expect(errors).toHaveLength(1);
+    expect(errors[0].type).toBe("reserved_name");
+    expect(errors[0].entries).toEqual(["db"]);
+  });
+
   test("should reject service with reserved name 'init'", () => {
     const config: IopConfig = {
       name: "test-project",
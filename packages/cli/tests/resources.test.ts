@@ -0,0 +1,72 @@
+import { describe, expect, test } from "bun:test";
+import { ServiceEntryWithoutNameSchema } from "../src/config/types";
+import { buildResourceFlags, parseContainerLimits } from "../src/docker";
+
+describe("service resource limits", () => {
+  test("should validate resource limits", () => {
+    const result = ServiceEntryWithoutNameSchema.safeParse({
+      image: "blog-web:latest",
+      server: "server1.example.com",
+      resources: {
+        cpus: 0.5,
+        memory: "512m",
+        memory_reservation: "256M",
+        pids_limit: 200,
+        ulimits: { nofile: "1024:65536", nproc: 512 },
+      },
+    });
+    expect(result.success).toBe(true);
+  });
+
+  test("should reject invalid sizes and ulimits", () => {
+    for (const resources of [
+      { memory: "lots" },
+      { cpus: 0 },
+      { pids_limit: 1.5 },
+      { ulimits: { nofile: "many" } },
+    ]) {
+      const result = ServiceEntryWithoutNameSchema.safeParse({
+        image: "blog-web:latest",
+        server: "server1.example.com",
+        resources,
+      });
+      expect(result.success).toBe(false);
+    }
+  });
+
+  test("should build docker run flags", () => {
+    expect(buildResourceFlags(undefined)).toEqual([]);
+    expect(
+      buildResourceFlags({
+        cpus: 1.5,
+        cpu_shares: 512,
+        memory: "1g",
+        memory_reservation: "512m",
+        pids_limit: 100,
+        ulimits: { nofile: "1024:65536", core: 0 },
+      })
+    ).toEqual([
+      "--cpus 1.5",
+      "--cpu-shares 512",
+      "--memory 1g",
+      "--memory-reservation 512m",
+      "--pids-limit 100",
+      "--ulimit nofile=1024:65536",
+      "--ulimit core=0",
+    ]);
+  });
+
+  test("should read limits from docker inspect", () => {
+    expect(
+      parseContainerLimits({
+        NanoCpus: 500000000,
+        CpuShares: 0,
+        Memory: 536870912,
+        MemoryReservation: 0,
+        PidsLimit: 200,
+      })
+    ).toEqual({ cpus: 0.5, memory: 536870912, pidsLimit: 200 });
+    expect(parseContainerLimits({ CpuQuota: 50000, CpuPeriod: 100000 })).toEqual({ cpus: 0.5 });
+    expect(parseContainerLimits(undefined)).toEqual({});
+  });
+});
@@ -0,0 +1,109 @@
+import { describe, it, expect } from "bun:test";
+import { SecretFileSchema, ServiceEntry } from "../src/config/types";
+import { DockerClient } from "../src/docker";
+import { SSHClient } from "../src/ssh";
+import {
+  buildSecretFileMountFlags,
+  buildWriteSecretFilesCommand,
+  getSecretFileSource,
+  resolveSecretFiles,
+} from "../src/utils/secret-files";
+import { createServiceConfigHash } from "../src/utils/service-fingerprint";
+
+const web = {
+  name: "web",
+  server: "1.2.3.4",
+  image: "shop",
+  replicas: 1,
+  secret_files: [
+    { secret: "GCP_SERVICE_ACCOUNT", path: "/run/secrets/gcp.json", mode: "0400" },
+    { secret: "TLS_KEY", path: "/etc/tls/key.pem", mode: "0444" },
+  ],
+} as unknown as ServiceEntry;
+
+const secrets = { GCP_SERVICE_ACCOUNT: '{"type": "service_account"}', TLS_KEY: "-----BEGIN KEY-----" };
+
+function recordingDockerClient(): { dockerClient: DockerClient; commands: string[] } {
+  const commands: string[] = [];
+  const sshClient = {
+    exec: async (command: string) => {
+      commands.push(command);
+      return "";
+    },
+  } as unknown as SSHClient;
+  return { dockerClient: new DockerClient(sshClient), commands };
+}
+
+describe("secret files", () => {
+  it("should validate paths and modes", () => {
+    expect(SecretFileSchema.parse({ secret: "KEY", path: "/run/secrets/key" }).mode).toBe("0444");
+    expect(SecretFileSchema.safeParse({ secret: "KEY", path: "run/key" }).success).toBe(false);
+    expect(SecretFileSchema.safeParse({ secret: "KEY", path: "/run/my key" }).success).toBe(false);
+    expect(SecretFileSchema.safeParse({ secret: "KEY", path: "/run/key", mode: "0800" }).success).toBe(false);
+  });
+
+  it("should resolve the content of each file from the secrets", () => {
+    expect(resolveSecretFiles(web, secrets)).toEqual([
+      { path: "/run/secrets/gcp.json", mode: "0400", content: Buffer.from('{"type": "service_account"}') },
+      { path: "/etc/tls/key.pem", mode: "0444", content: Buffer.from("-----BEGIN KEY-----") },
+    ]);
+    expect(resolveSecretFiles({ name: "api" }, secrets)).toEqual([]);
+    const [pem] = resolveSecretFiles(
+      { name: "api", secret_files: [{ secret: "PEM", path: "/tls/key.pem", mode: "0400", encoding: "base64" }] },
+      { PEM: Buffer.from("line 1\nline 2\n").toString("base64") }
+    );
+    expect(pem.content.toString()).toBe("line 1\nline 2\n");
+    expect(() => resolveSecretFiles(web, { TLS_KEY: "key" })).toThrow(
+      'Secret "GCP_SERVICE_ACCOUNT" for the file /run/secrets/gcp.json of web'
+    );
+  });
+
+  it("should mount each file read-only from its own source", () => {
+    expect(buildSecretFileMountFlags("shop-web-blue", resolveSecretFiles(web, secrets))).toEqual([
+      "--mount type=bind,source=/dev/shm/iop-secrets/shop-web-blue/run%2Fsecrets%2Fgcp.json,target=/run/secrets/gcp.json,readonly",
+      "--mount type=bind,source=/dev/shm/iop-secrets/shop-web-blue/etc%2Ftls%2Fkey.pem,target=/etc/tls/key.pem,readonly",
+    ]);
+    expect(getSecretFileSource("/dir", "/a/b")).not.toBe(getSecretFileSource("/dir", "/a_b"));
+  });
+
+  it("should write the files privately with their modes", () => {
+    const command = buildWriteSecretFilesCommand("/dev/shm/iop-secrets/shop-web-blue", [
+      { path: "/run/key", mode: "0400", content: Buffer.from("EOF\n$(reboot)") },
+    ]);
+
+    expect(command).toStartWith("(set -e\numask 077\nrm -rf /dev/shm/iop-secrets/shop-web-blue\n");
+    expect(command).toContain(
+      `base64 -d > /dev/shm/iop-secrets/shop-web-blue/run%2Fkey << 'EOF'\n${Buffer.from("EOF\n$(reboot)").toString("base64")}\nEOF\n`
+    );
+    expect(command).toContain("chmod 0400 /dev/shm/iop-secrets/shop-web-blue/run%2Fkey");
+    expect(command).not.toContain("reboot");
+  });
+
+  it("should write the files before creating the container and remove them with it", async () => {
+    const { dockerClient, commands } = recordingDockerClient();
+    const created = await dockerClient.createContainer({
+      name: "shop-web-blue",
+      image: "shop:1",
+      secretFiles: resolveSecretFiles(web, secrets),
+    });
+
+    expect(created).toBe(true);
+    expect(commands[0]).toStartWith("(set -e");
+    expect(commands[1]).toContain(' --label iop.secret-files="/dev/shm/iop-secrets/shop-web-blue"');
+    expect(commands[1]).toContain(" --mount type=bind,source=/dev/shm/iop-secrets/shop-web-blue/");
+
+    await dockerClient.removeContainer("shop-web-blue");
+    expect(commands.slice(-2)).toEqual([
+      "docker rm shop-web-blue",
+      "rm -rf /dev/shm/iop-secrets/shop-web-blue",
+    ]);
+  });
+
+  it("should redeploy when secret files or their secrets change", () => {
+    const hash = createServiceConfigHash(web, secrets);
+    expect(createServiceConfigHash(web, { ...secrets, TLS_KEY: "rotated" })).not.toBe(hash);
+    expect(
+      createServiceConfigHash({ ...web, secret_files: web.secret_files!.slice(1) } as ServiceEntry, secrets)
+    ).not.toBe(hash);
+  });
+});
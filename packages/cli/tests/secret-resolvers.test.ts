@@ -0,0 +1,83 @@
+import { describe, it, expect } from "bun:test";
+import {
+  SecretResolver,
+  builtinSecretResolvers,
+  parseSecretReference,
+  resolveSecretReferences,
+} from "../src/config/secret-resolvers";
+
+describe("secret resolvers", () => {
+  const calls: string[][] = [];
+  const outputs: Record<string, string> = {
+    op: "s3cret",
+    vault: "hunter2\n",
+    aws: '{"username":"shop","password":"pa55"}\n',
+  };
+  const registry = new Map<string, SecretResolver>(
+    builtinSecretResolvers(async (command, args) => {
+      calls.push([command, ...args]);
+      return outputs[command];
+    }).map((resolver) => [resolver.scheme, resolver])
+  );
+
+  it("should only parse references to stores with a resolver", () => {
+    expect(parseSecretReference("op://prod/db/password", registry)?.reference).toBe("prod/db/password");
+    expect(parseSecretReference("https://hooks.slack.com/services/T0", registry)).toBeNull();
+    expect(parseSecretReference("plain-value", registry)).toBeNull();
+  });
+
+  it("should keep spaces inside references", () => {
+    expect(parseSecretReference("op://Vault Name/Item/field", registry)?.reference).toBe("Vault Name/Item/field");
+    expect(parseSecretReference(" op://Shared Vault/Stripe Keys/api key ", registry)?.reference).toBe(
+      "Shared Vault/Stripe Keys/api key"
+    );
+  });
+
+  it("should resolve references with each store's CLI", async () => {
+    calls.length = 0;
+    const secrets = await resolveSecretReferences(
+      {
+        DATABASE_PASSWORD: "op://prod/db/password",
+        DB_PASSWORD_COPY: "op://prod/db/password",
+        API_KEY: "vault://secret/shop/api#key",
+        AWS_DB_PASSWORD: "aws-sm://prod/shop/db#password",
+        LOG_LEVEL: "debug",
+      },
+      registry
+    );
+
+    expect(secrets).toEqual({
+      DATABASE_PASSWORD: "s3cret",
+      DB_PASSWORD_COPY: "s3cret",
+      API_KEY: "hunter2",
+      AWS_DB_PASSWORD: "pa55",
+      LOG_LEVEL: "debug",
+    });
+    expect(calls).toEqual([
+      ["op", "read", "--no-newline", "op://prod/db/password"],
+      ["vault", "kv", "get", "-field=key", "secret/shop/api"],
+      ["aws", "secretsmanager", "get-secret-value", "--secret-id", "prod/shop/db", "--query", "SecretString", "--output", "text"],
+    ]);
+  });
+
+  it("should only resolve the selected secrets", async () => {
+    calls.length = 0;
+    const secrets = await resolveSecretReferences(
+      { SSH_PASSWORD_WEB1: "op://prod/web1/password", DATABASE_PASSWORD: "op://prod/db/password" },
+      registry,
+      (key) => key.startsWith("SSH_")
+    );
+
+    expect(secrets).toEqual({ SSH_PASSWORD_WEB1: "s3cret", DATABASE_PASSWORD: "op://prod/db/password" });
+    expect(calls).toEqual([["op", "read", "--no-newline", "op://prod/web1/password"]]);
+  });
+
+  it("should name the secret that could not be resolved", async () => {
+    await expect(
+      resolveSecretReferences({ API_KEY: "vault://secret/shop/api" }, registry)
+    ).rejects.toThrow("Could not resolve secret API_KEY from vault://secret/shop/api: Vault references need a field");
+    await expect(
+      resolveSecretReferences({ DB: "aws-sm://prod/shop/db#host" }, registry)
+    ).rejects.toThrow("prod/shop/db has no key host");
+  });
+});
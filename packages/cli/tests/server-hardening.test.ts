@@ -0,0 +1,93 @@
+import { describe, it, expect } from "bun:test";
+import { HardeningConfigSchema, IopConfig } from "../src/config/types";
+import {
+  buildFail2banJail,
+  buildNftablesRuleset,
+  buildUfwCommands,
+  formatHardeningResult,
+  getAllowedPorts,
+  getFirewallBackend,
+  getTrustedInterfaces,
+} from "../src/utils/server-hardening";
+
+const config = (overrides: Partial<IopConfig> = {}): IopConfig =>
+  ({ name: "blog", ...overrides } as IopConfig);
+
+describe("server hardening", () => {
+  it("should enable every step by default", () => {
+    const hardening = HardeningConfigSchema.parse({});
+    expect(hardening).toEqual({
+      firewall: true,
+      fail2ban: true,
+      auto_updates: true,
+      deploy_user: true,
+    });
+    expect(getFirewallBackend(hardening)).toBe("ufw");
+  });
+
+  it("should pick the firewall backend", () => {
+    expect(getFirewallBackend(HardeningConfigSchema.parse({ firewall: "nftables" }))).toBe("nftables");
+    expect(getFirewallBackend(HardeningConfigSchema.parse({ firewall: false }))).toBeUndefined();
+    expect(HardeningConfigSchema.safeParse({ firewall: "iptables" }).success).toBe(false);
+  });
+
+  it("should validate extra ports", () => {
+    expect(HardeningConfigSchema.safeParse({ allow: [8443, "51820/udp"] }).success).toBe(true);
+    expect(HardeningConfigSchema.safeParse({ allow: [0] }).success).toBe(false);
+    expect(HardeningConfigSchema.safeParse({ allow: ["53/icmp"] }).success).toBe(false);
+  });
+
+  it("should allow SSH, HTTP, HTTPS and extra ports", () => {
+    expect(getAllowedPorts(config())).toEqual(["22/tcp", "80/tcp", "443/tcp"]);
+    expect(
+      getAllowedPorts(
+        config({
+          ssh: { username: "deploy", port: 2222 },
+          hardening: HardeningConfigSchema.parse({ allow: [8443, "51820/udp", "443"] }),
+        })
+      )
+    ).toEqual(["2222/tcp", "80/tcp", "443/tcp", "8443/tcp", "51820/udp"]);
+  });
+
+  it("should open the mesh port and interface", () => {
+    const meshConfig = config({ mesh: { subnet: "10.210.0.0/24", port: 51821 } });
+    expect(getAllowedPorts(meshConfig)).toEqual(["22/tcp", "80/tcp", "443/tcp", "51821/udp"]);
+    expect(getTrustedInterfaces(meshConfig)).toEqual(["iop0"]);
+    expect(getTrustedInterfaces(config())).toEqual([]);
+    expect(buildUfwCommands(["22/tcp"], ["iop0"])).toContain("ufw allow in on iop0");
+    expect(buildNftablesRuleset(["22/tcp"], ["iop0"])).toContain('iifname "iop0" accept');
+  });
+
+  it("should build ufw commands that deny incoming by default", () => {
+    expect(buildUfwCommands(["22/tcp", "51820/udp"])).toEqual([
+      "ufw --force reset",
+      "ufw default deny incoming",
+      "ufw default allow outgoing",
+      "ufw allow 22/tcp",
+      "ufw allow 51820/udp",
+      "ufw --force enable",
+    ]);
+  });
+
+  it("should build an nftables ruleset that replaces itself", () => {
+    const ruleset = buildNftablesRuleset(["22/tcp", "80/tcp", "51820/udp"]);
+    expect(ruleset.startsWith("table inet iop_firewall\ndelete table inet iop_firewall\n")).toBe(true);
+    expect(ruleset).toContain("policy drop;");
+    expect(ruleset).toContain("ct state established,related accept");
+    expect(ruleset).toContain("tcp dport { 22, 80 } accept");
+    expect(ruleset).toContain("udp dport { 51820 } accept");
+  });
+
+  it("should protect the SSH port with fail2ban", () => {
+    const jail = buildFail2banJail(2222);
+    expect(jail).toContain("[sshd]");
+    expect(jail).toContain("port = 2222");
+    expect(jail).toContain("backend = systemd");
+  });
+
+  it("should format step results", () => {
+    expect(
+      formatHardeningResult({ step: "auto_updates", status: "unchanged", detail: "unattended-upgrades enabled" })
+    ).toBe("Automatic security updates: unchanged (unattended-upgrades enabled)");
+  });
+});
@@ -0,0 +1,62 @@
+import { describe, it, expect } from "bun:test";
+import { buildServiceRecords } from "../src/commands/deploy";
+import { ServiceEntry } from "../src/config/types";
+import { DockerClient } from "../src/docker";
+import { getDiscoveryPortBindings } from "../src/setup-proxy";
+import { SSHClient } from "../src/ssh";
+import { parseInterfaceAddress } from "../src/utils/mesh";
+
+describe("service discovery", () => {
+  const services = [
+    { name: "web", server: "a.example.com" },
+    { name: "api", server: "a.example.com" },
+    { name: "postgres", server: "b.example.com" },
+    { name: "legacy", server: "c.example.com" },
+  ] as ServiceEntry[];
+  const meshAddresses = { "a.example.com": "10.210.0.1", "b.example.com": "10.210.0.2" };
+
+  it("should resolve local services to containers and others to mesh addresses", () => {
+    expect(buildServiceRecords(services, "shop", "a.example.com", meshAddresses)).toEqual([
+      { name: "web", container: "shop-web" },
+      { name: "api", container: "shop-api" },
+      { name: "postgres", address: "10.210.0.2" },
+    ]);
+    expect(buildServiceRecords(services, "shop", "b.example.com", meshAddresses)).toEqual([
+      { name: "web", address: "10.210.0.1" },
+      { name: "api", address: "10.210.0.1" },
+      { name: "postgres", container: "shop-postgres" },
+    ]);
+  });
+
+  it("should publish DNS only on the mesh address", () => {
+    expect(getDiscoveryPortBindings("10.210.0.1")).toEqual([
+      "10.210.0.1:53:53/udp",
+      "10.210.0.1:53:53",
+    ]);
+    expect(getDiscoveryPortBindings(undefined)).toEqual([]);
+  });
+
+  it("should read the address of the mesh interface", () => {
+    expect(
+      parseInterfaceAddress(
+        "4: iop0    inet 10.210.0.2/24 scope global iop0\\       valid_lft forever preferred_lft forever"
+      )
+    ).toBe("10.210.0.2");
+    expect(parseInterfaceAddress("")).toBeUndefined();
+  });
+
+  it("should point new containers at the DNS servers", async () => {
+    const commands: string[] = [];
+    const sshClient = {
+      exec: async (command: string) => {
+        commands.push(command);
+        return "";
+      },
+    } as unknown as SSHClient;
+    const dockerClient = new DockerClient(sshClient);
+    dockerClient.setDnsServers(["10.210.0.1"]);
+
+    expect(await dockerClient.createContainer({ name: "shop-api", image: "shop/api:1" })).toBe(true);
+    expect(commands[0]).toContain(" --dns 10.210.0.1 ");
+  });
+});
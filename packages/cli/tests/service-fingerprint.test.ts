@@ -6,7 +6,8 @@ import {
   ServiceFingerprint,
   createServiceFingerprint,
   isBuiltService,
-  getLocalImageHash
+  getLocalImageHash,
+  combineConfigAndContextHash
 } from '../src/utils/service-fingerprint';
 import { ServiceEntry, IopSecrets } from '../src/config/types';
 
@@ -116,6 +117,28 @@ describe('service-fingerprint', () => {
       
       expect(hash1).not.toBe(hash2); // Should differ when secret values change
     });
+
+    it('should include build argument values in config hash', () => {
+      const service = (version: string): ServiceEntry => ({
+        name: 'web',
+        server: 'example.com',
+        build: { context: '.', args: ['NODE_VERSION'] },
+        environment: { plain: [`NODE_VERSION=${version}`] }
+      });
+
+      expect(createServiceConfigHash(service('20'), {})).not.toBe(createServiceConfigHash(service('22'), {}));
+    });
+  });
+
+  describe('combineConfigAndContextHash', () => {
+    it('should change with either the config or the build context', () => {
+      const combined = combineConfigAndContextHash('abc123', '4f2a9c1d8e7b');
+
+      expect(combined).toHaveLength(12);
+      expect(combineConfigAndContextHash('abc123', '4f2a9c1d8e7b')).toBe(combined);
+      expect(combineConfigAndContextHash('abc123', '9b1e0c3a5d2f')).not.toBe(combined);
+      expect(combineConfigAndContextHash('changed123', '4f2a9c1d8e7b')).not.toBe(combined);
+    });
   });
 
   describe('createSecretsHash', () => {
@@ -330,6 +353,37 @@ describe('service-fingerprint', () => {
       expect(result.priority).toBe('optional');
     });
 
+    it('should require redeploy when the build context changes', () => {
+      const current = { ...builtFingerprint, contextHash: '4f2a9c1d8e7b' };
+      const desired = { ...builtFingerprint, contextHash: '9b1e0c3a5d2f' };
+
+      const result = shouldRedeploy(current, desired);
+
+      expect(result.shouldRedeploy).toBe(true);
+      expect(result.reason).toBe('build context changed');
+      expect(result.priority).toBe('normal');
+    });
+
+    it('should still compare images when the build context is unchanged', () => {
+      const current = { ...builtFingerprint, contextHash: '4f2a9c1d8e7b', serverImageHash: 'sha256:server123' };
+      const desired = { ...builtFingerprint, contextHash: '4f2a9c1d8e7b', localImageHash: 'sha256:local456' };
+
+      const result = shouldRedeploy(current, desired);
+
+      expect(result.shouldRedeploy).toBe(true);
+      expect(result.reason).toBe('image updated');
+    });
+
+    it('should skip built services without a local image when the build context is unchanged', () => {
+      const current = { ...builtFingerprint, contextHash: '4f2a9c1d8e7b', serverImageHash: 'sha256:server123' };
+      const desired = { ...builtFingerprint, contextHash: '4f2a9c1d8e7b', localImageHash: undefined };
+
+      const result = shouldRedeploy(current, desired);
+
+      expect(result.shouldRedeploy).toBe(false);
+      expect(result.reason).toBe('up-to-date, skipped');
+    });
+
     it('should not require redeploy for external services when only config matches', () => {
       const current = { ...externalFingerprint };
       const desired = { ...externalFingerprint };
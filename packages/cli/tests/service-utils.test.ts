@@ -35,6 +35,23 @@ describe('service-utils', () => {
       expect(requiresZeroDowntimeDeployment(service)).toBe(false);
     });
 
+    it('should return true for workers without proxy config', () => {
+      const service: ServiceEntry = {
+        name: 'jobs',
+        server: 'example.com',
+        image: 'myapp',
+        type: 'worker',
+        command: 'bundle exec sidekiq',
+        health_check: {
+          path: '/up',
+          command: 'pgrep -f sidekiq'
+        }
+      };
+      
+      expect(requiresZeroDowntimeDeployment(service)).toBe(true);
+      expect(getDeploymentStrategy(service)).toBe('zero-downtime');
+    });
+
     it('should return false for services with exposed ports but no proxy', () => {
       const service: ServiceEntry = {
         name: 'app',
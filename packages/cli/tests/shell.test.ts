@@ -0,0 +1,29 @@
+import { describe, expect, test } from "bun:test";
+import { execFileSync } from "child_process";
+import { shellQuote } from "../src/utils/shell";
+
+describe("shellQuote", () => {
+  test("should wrap values in single quotes", () => {
+    expect(shellQuote("app.example.com")).toBe("'app.example.com'");
+    expect(shellQuote("")).toBe("''");
+  });
+
+  test("should escape embedded single quotes", () => {
+    expect(shellQuote("it's")).toBe(`'it'\\''s'`);
+  });
+
+  test("should pass spaces, metacharacters and globs through the shell unchanged", () => {
+    const values = [
+      "*.example.com",
+      "two words",
+      "a; rm -rf /",
+      "$(whoami) `id` $HOME",
+      "x | y && z > /tmp/out",
+      "it's \"quoted\"\n\\n",
+    ];
+    for (const value of values) {
+      const echoed = execFileSync("sh", ["-c", `printf '%s' ${shellQuote(value)}`]).toString();
+      expect(echoed).toBe(value);
+    }
+  });
+});
@@ -0,0 +1,67 @@
+import { describe, it, expect } from "bun:test";
+import { ServiceEntry } from "../src/config/types";
+import {
+  createSidecarContainerOptions,
+  generateSidecarContainerName,
+} from "../src/commands/blue-green";
+import { createServiceConfigHash } from "../src/utils/service-fingerprint";
+
+const web = {
+  name: "web",
+  server: "1.2.3.4",
+  image: "blog",
+  replicas: 2,
+  proxy: { app_port: 3000 },
+  sidecars: {
+    cloudsql: {
+      image: "gcr.io/cloud-sql-connectors/cloud-sql-proxy:2",
+      command: "--port 5432 project:region:db",
+      environment: { plain: ["LOG_LEVEL=info"], secret: ["SQL_CREDENTIALS"] },
+      volumes: ["sockets:/run/sockets"],
+    },
+  },
+} as unknown as ServiceEntry;
+
+describe("sidecars", () => {
+  it("should name sidecars after their app container", () => {
+    expect(generateSidecarContainerName("blog-web-green-2", "cloudsql")).toBe(
+      "blog-web-green-2-cloudsql"
+    );
+  });
+
+  it("should share the network of the app container", () => {
+    const options = createSidecarContainerOptions(
+      web,
+      "cloudsql",
+      "blog-web-blue-1",
+      { SQL_CREDENTIALS: "{}" },
+      "blog",
+      ["sockets"]
+    );
+
+    expect(options.name).toBe("blog-web-blue-1-cloudsql");
+    expect(options.network).toBe("container:blog-web-blue-1");
+    expect(options.networkAliases).toBeUndefined();
+    expect(options.command).toBe("--port 5432 project:region:db");
+    expect(options.envVars).toEqual({ LOG_LEVEL: "info", SQL_CREDENTIALS: "{}" });
+    expect(options.volumes).toEqual(["blog-sockets:/run/sockets"]);
+    expect(options.labels).toEqual({
+      "iop.managed": "true",
+      "iop.project": "blog",
+      "iop.type": "sidecar",
+      "iop.sidecar": "cloudsql",
+      "iop.sidecar-of": "blog-web-blue-1",
+    });
+  });
+
+  it("should redeploy the app when a sidecar or its secrets change", () => {
+    const hash = createServiceConfigHash(web, { SQL_CREDENTIALS: "a" });
+    expect(createServiceConfigHash(web, { SQL_CREDENTIALS: "b" })).not.toBe(hash);
+
+    const changed = {
+      ...web,
+      sidecars: { cloudsql: { ...web.sidecars!.cloudsql, image: "cloud-sql-proxy:3" } },
+    } as ServiceEntry;
+    expect(createServiceConfigHash(changed, { SQL_CREDENTIALS: "a" })).not.toBe(hash);
+  });
+});
@@ -0,0 +1,62 @@
+import { describe, it, expect } from "bun:test";
+import * as fs from "fs";
+import * as os from "os";
+import * as path from "path";
+import { IopConfig } from "../src/config/types";
+import { buildSSHCommandOptions, getSSHCredentials } from "../src/ssh";
+
+describe("ssh credentials", () => {
+  const keyFile = path.join(fs.mkdtempSync(path.join(os.tmpdir(), "iop-ssh-")), "id_private");
+  fs.writeFileSync(keyFile, "");
+
+  const config = {
+    name: "blog",
+    ssh: {
+      username: "deploy",
+      port: 2222,
+      bastion: { host: "bastion.example.com", key_file: "/keys/bastion" },
+      agent_forwarding: true,
+      servers: {
+        "10.0.1.5": { port: 22, key_file: keyFile },
+        "public.example.com": { bastion: false as const },
+      },
+    },
+  } as IopConfig;
+
+  it("should apply per-server port and key file overrides", async () => {
+    const options = await getSSHCredentials("10.0.1.5", config, {});
+    expect(options.port).toBe(22);
+    expect(options.identity).toBe(keyFile);
+    expect(options.agentForward).toBe(true);
+  });
+
+  it("should connect through the bastion as the SSH user by default", async () => {
+    const options = await getSSHCredentials("10.0.1.6", config, {});
+    expect(options.port).toBe(2222);
+    expect(options.bastion).toEqual({
+      host: "bastion.example.com",
+      port: 22,
+      username: "deploy",
+      identity: "/keys/bastion",
+    });
+  });
+
+  it("should let servers opt out of the bastion", async () => {
+    const options = await getSSHCredentials("public.example.com", config, {});
+    expect(options.bastion).toBeUndefined();
+  });
+
+  it("should give the bastion the server key when it has none", () => {
+    expect(
+      buildSSHCommandOptions({
+        identity: "/keys/server",
+        bastion: { host: "bastion.example.com", username: "jump" },
+      })
+    ).toEqual([
+      "-i",
+      "/keys/server",
+      "-o",
+      "ProxyCommand=ssh -W %h:%p -i /keys/server jump@bastion.example.com",
+    ]);
+  });
+});
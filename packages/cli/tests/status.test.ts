@@ -0,0 +1,111 @@
+import { describe, it, expect } from "bun:test";
+import {
+  buildStatusRow,
+  formatAge,
+  formatResourceLimits,
+  formatStatusTable,
+  getImageTag,
+} from "../src/commands/status";
+
+describe("status overview", () => {
+  const now = new Date("2026-01-10T12:00:00Z");
+
+  it("should extract image tags", () => {
+    expect(getImageTag("blog-web:abc123")).toBe("abc123");
+    expect(getImageTag("registry.example.com:5000/blog-web:abc123")).toBe("abc123");
+    expect(getImageTag("registry.example.com:5000/blog-web")).toBe("latest");
+    expect(getImageTag("postgres")).toBe("latest");
+  });
+
+  it("should format ages", () => {
+    expect(formatAge("2026-01-10T11:59:30Z", now)).toBe("just now");
+    expect(formatAge("2026-01-10T11:15:00Z", now)).toBe("45m ago");
+    expect(formatAge("2026-01-10T07:00:00Z", now)).toBe("5h ago");
+    expect(formatAge("2026-01-07T12:00:00Z", now)).toBe("3d ago");
+  });
+
+  it("should summarize a proxied service", () => {
+    const row = buildStatusRow(
+      {
+        name: "web",
+        type: "service",
+        status: "running",
+        activeColor: "green",
+        imageTag: "abc123",
+        replicas: { total: 2, running: 2 },
+        servers: ["1.2.3.4"],
+        lastDeployed: "2026-01-10T10:00:00Z",
+        hosts: [
+          {
+            host: "example.com",
+            healthy: true,
+            certificate: { status: "active", expiresAt: "2026-03-01T00:00:00Z" },
+          },
+          {
+            host: "www.example.com",
+            healthy: false,
+            certificate: { status: "active", expiresAt: "2026-02-01T00:00:00Z" },
+          },
+        ],
+      },
+      now
+    );
+
+    expect(row).toEqual({
+      service: "web",
+      color: "green",
+      image: "abc123",
+      replicas: "2/2",
+      health: "degraded (1/2)",
+      cert: "active (exp 2026-02-01)",
+      deployed: "2h ago",
+    });
+  });
+
+  it("should fall back to container state for unproxied services", () => {
+    const row = buildStatusRow({
+      name: "db",
+      type: "service",
+      status: "stopped",
+      replicas: { total: 1, running: 0 },
+      servers: ["1.2.3.4"],
+      hosts: [],
+    });
+
+    expect(row.health).toBe("stopped");
+    expect(row.cert).toBe("-");
+    expect(row.deployed).toBe("-");
+  });
+
+  it("should align table columns", () => {
+    const lines = formatStatusTable([
+      {
+        service: "web",
+        color: "blue",
+        image: "abc123",
+        replicas: "1/1",
+        health: "healthy",
+        cert: "acquiring",
+        deployed: "5m ago",
+      },
+    ]);
+
+    expect(lines).toEqual([
+      "SERVICE  COLOR  IMAGE   REPLICAS  HEALTH   CERT       DEPLOYED",
+      "web      blue   abc123  1/1       healthy  acquiring  5m ago",
+    ]);
+  });
+
+  it("should describe resource limits", () => {
+    expect(formatResourceLimits({})).toEqual([]);
+    expect(
+      formatResourceLimits({
+        cpus: 0.5,
+        memory: 512 * 1024 * 1024,
+        memoryReservation: 1.5 * 1024 * 1024 * 1024,
+        pidsLimit: 200,
+      })
+    ).toEqual(["0.5 CPUs", "512MiB memory", "1.5GiB reserved", "200 processes"]);
+    expect(formatResourceLimits({ cpus: 1, cpuShares: 512 })).toEqual(["1 CPU", "512 CPU shares"]);
+  });
+});
@@ -0,0 +1,126 @@
+import { describe, it, expect } from "bun:test";
+import {
+  computeAppRows,
+  formatAppTable,
+  formatBytes,
+  formatTopTable,
+  latencyPercentile,
+  parseTopArgs,
+  sortAppRows,
+  sortStats,
+} from "../src/commands/top";
+import type { ProxyAppStats, ProxyContainerStats } from "../src/proxy";
+
+function appStats(app: string, requests: number, errors: number, buckets: number[]): ProxyAppStats {
+  return {
+    project: "blog",
+    app,
+    requests,
+    errors,
+    latency: 0,
+    in_flight: 2,
+    latency_buckets: buckets,
+    containers: 2,
+    cpu_percent: 35,
+    memory_bytes: 256 * 1024 * 1024,
+    memory_limit_bytes: 1024 * 1024 * 1024,
+  };
+}
+
+function sample(container: string, cpu: number, memory: number): ProxyContainerStats {
+  return {
+    container,
+    project: "blog",
+    app: "web",
+    time: "2026-01-10T12:00:00Z",
+    cpu_percent: cpu,
+    memory_bytes: memory,
+    memory_limit_bytes: 512 * 1024 * 1024,
+    network_rx_bytes: 2048,
+    network_tx_bytes: 512,
+    disk_read_bytes: 0,
+    disk_write_bytes: 1024 * 1024,
+    pids: 7,
+  };
+}
+
+describe("top", () => {
+  it("should parse flags", () => {
+    expect(parseTopArgs([])).toEqual({
+      sort: "cpu",
+      all: false,
+      watch: false,
+      containers: false,
+      server: undefined,
+      verboseFlag: false,
+    });
+
+    const parsed = parseTopArgs(["--sort", "memory", "--all", "--watch", "--containers", "--server", "server1.com"]);
+    expect(parsed.sort).toBe("memory");
+    expect(parsed.all).toBe(true);
+    expect(parsed.watch).toBe(true);
+    expect(parsed.containers).toBe(true);
+    expect(parsed.server).toBe("server1.com");
+
+    expect(() => parseTopArgs(["--sort", "disk"])).toThrow();
+  });
+
+  it("should format byte counts", () => {
+    expect(formatBytes(0)).toBe("0B");
+    expect(formatBytes(1536)).toBe("1.5KiB");
+    expect(formatBytes(512 * 1024 * 1024)).toBe("512.0MiB");
+  });
+
+  it("should sort busiest containers first", () => {
+    const stats = [sample("a", 1, 300), sample("b", 50, 100), sample("c", 10, 200)];
+    expect(sortStats(stats, "cpu").map((s) => s.container)).toEqual(["b", "c", "a"]);
+    expect(sortStats(stats, "memory").map((s) => s.container)).toEqual(["a", "c", "b"]);
+    expect(sortStats(stats, "name").map((s) => s.container)).toEqual(["a", "b", "c"]);
+  });
+
+  it("should align the table", () => {
+    expect(formatTopTable([sample("blog-web-blue-1", 12.5, 128 * 1024 * 1024)])).toEqual([
+      "CONTAINER        CPU    MEMORY               MEM %  PIDS  NET RX / TX    DISK R / W",
+      "blog-web-blue-1  12.5%  128.0MiB / 512.0MiB  25.0%  7     2.0KiB / 512B  0B / 1.0MiB",
+    ]);
+  });
+
+  it("should estimate latency percentiles from the histogram", () => {
+    expect(latencyPercentile([90, 0, 0, 0, 0, 9, 0, 0, 0, 0, 0, 1], 0.5)).toBe(5);
+    expect(latencyPercentile([90, 0, 0, 0, 0, 9, 0, 0, 0, 0, 0, 1], 0.95)).toBe(250);
+    expect(latencyPercentile([0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3], 0.95)).toBe(10000);
+    expect(latencyPercentile([], 0.95)).toBeUndefined();
+  });
+
+  it("should measure apps between two readings", () => {
+    const before = [appStats("web", 100, 1, [100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0])];
+    const after = [appStats("web", 400, 31, [370, 0, 0, 0, 0, 30, 0, 0, 0, 0, 0, 0])];
+
+    const [first] = computeAppRows(before, undefined, 0);
+    expect(first.requestsPerSecond).toBeUndefined();
+    expect(first.cpuPercent).toBe(35);
+
+    const [row] = computeAppRows(after, before, 3000);
+    expect(row.requestsPerSecond).toBe(100);
+    expect(row.errorPercent).toBe(10);
+    expect(row.p95Ms).toBe(250);
+
+    // The proxy restarted between the readings
+    expect(computeAppRows(before, after, 3000)[0].requestsPerSecond).toBeUndefined();
+  });
+
+  it("should sort and align apps", () => {
+    const rows = computeAppRows(
+      [appStats("web", 130, 0, [30, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0]), appStats("worker", 0, 0, [])],
+      [appStats("web", 100, 0, []), appStats("worker", 0, 0, [])],
+      3000
+    );
+    expect(sortAppRows(rows, "requests").map((r) => r.app)).toEqual(["web", "worker"]);
+    expect(formatAppTable(rows, "blog")).toEqual([
+      "APP     CONTAINERS  REQ/S  ERRORS  P95  IN FLIGHT  CPU    MEMORY",
+      "web     2           10.0   0.0%    5ms  2          35.0%  256.0MiB / 1.0GiB",
+      "worker  2           0.0    -       -    2          35.0%  256.0MiB / 1.0GiB",
+    ]);
+    expect(formatAppTable(rows, "shop")[1].startsWith("blog/web  ")).toBe(true);
+  });
+});
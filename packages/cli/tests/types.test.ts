@@ -66,6 +66,77 @@ describe("type schemas", () => {
         expect(errorPaths).toContain("server");
       }
     });
+
+    test("should validate scale to zero settings", () => {
+      const service = {
+        image: "nginx:latest",
+        server: "server1.example.com",
+        proxy: { app_port: 80, scale_to_zero: true, idle_timeout: "30m" },
+      };
+
+      expect(ServiceEntryWithoutNameSchema.safeParse(service).success).toBe(true);
+
+      service.proxy.idle_timeout = "half an hour";
+      expect(ServiceEntryWithoutNameSchema.safeParse(service).success).toBe(false);
+    });
+
+    test("should validate cold start limits", () => {
+      const parse = (cold_start: unknown) =>
+        ServiceEntryWithoutNameSchema.safeParse({
+          image: "nginx:latest",
+          server: "server1.example.com",
+          proxy: { app_port: 80, scale_to_zero: true, cold_start },
+        }).success;
+
+      expect(parse({ max_wait: "20s", queue_depth: 50, starting_page: true, warm_pool: 1 })).toBe(true);
+      expect(parse({ queue_depth: 0 })).toBe(false);
+      expect(parse({ warm_pool: -1 })).toBe(false);
+      expect(parse({ max_wait: "soon" })).toBe(false);
+    });
+
+    test("should validate proxy timeouts", () => {
+      const parse = (timeouts: Record<string, string>) =>
+        ServiceEntryWithoutNameSchema.safeParse({
+          image: "nginx:latest",
+          server: "server1.example.com",
+          proxy: { app_port: 80, ...timeouts },
+        }).success;
+
+      expect(
+        parse({
+          dial_timeout: "5s",
+          response_timeout: "1m",
+          request_timeout: "10m",
+          stream_idle_timeout: "30s",
+        })
+      ).toBe(true);
+      expect(parse({ response_timeout: "30" })).toBe(false);
+      expect(parse({ stream_idle_timeout: "forever" })).toBe(false);
+    });
+
+    test("should validate streaming", () => {
+      const parse = (streaming: unknown) =>
+        ServiceEntryWithoutNameSchema.safeParse({
+          image: "nginx:latest",
+          server: "server1.example.com",
+          proxy: { app_port: 80, streaming },
+        }).success;
+
+      expect(parse(true)).toBe(true);
+      expect(parse("yes")).toBe(false);
+    });
+
+    test("should validate internal", () => {
+      const parse = (internal: unknown) =>
+        ServiceEntryWithoutNameSchema.safeParse({
+          image: "nginx:latest",
+          server: "server1.example.com",
+          proxy: { app_port: 80, internal },
+        }).success;
+
+      expect(parse(true)).toBe(true);
+      expect(parse("private")).toBe(false);
+    });
   });
 
   describe("IopConfigSchema", () => {
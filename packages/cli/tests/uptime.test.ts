@@ -0,0 +1,69 @@
+import { describe, it, expect } from "bun:test";
+import {
+  formatUptimeStatus,
+  formatUptimeTable,
+  parseUptimeArgs,
+} from "../src/commands/uptime";
+import { ProxyUptimeReport } from "../src/proxy";
+
+const report = (overrides: Partial<ProxyUptimeReport> = {}): ProxyUptimeReport => ({
+  host: "blog.example.com",
+  project: "blog",
+  app: "web",
+  up: true,
+  uptime_24h: 100,
+  uptime_7d: 99.5,
+  uptime_30d: 99.95,
+  uptime_90d: null,
+  avg_latency_ms: 42,
+  ...overrides,
+});
+
+describe("uptime", () => {
+  describe("parseUptimeArgs", () => {
+    it("should read the server and verbose flag", () => {
+      expect(parseUptimeArgs(["--server", "server1.com", "--verbose"])).toEqual({
+        server: "server1.com",
+        verboseFlag: true,
+      });
+      expect(parseUptimeArgs([])).toEqual({
+        server: undefined,
+        verboseFlag: false,
+      });
+    });
+  });
+
+  describe("formatUptimeStatus", () => {
+    const now = Date.parse("2024-05-01T12:00:00Z");
+
+    it("should show how long a host has been down", () => {
+      expect(formatUptimeStatus(report(), now)).toBe("up");
+      expect(
+        formatUptimeStatus(report({ up: false, down_since: "2024-05-01T11:55:00Z" }), now)
+      ).toBe("down 5m");
+      expect(
+        formatUptimeStatus(report({ up: false, down_since: "2024-05-01T09:30:00Z" }), now)
+      ).toBe("down 2h30m");
+    });
+  });
+
+  describe("formatUptimeTable", () => {
+    it("should show a row per host", () => {
+      const lines = formatUptimeTable([report()]);
+      expect(lines).toHaveLength(2);
+      expect(lines[0]).toContain("HOST");
+      expect(lines[1]).toContain("blog.example.com");
+      expect(lines[1]).toContain("100.00%");
+      expect(lines[1]).toContain("99.95%");
+      expect(lines[1]).toContain("42ms");
+    });
+
+    it("should show a dash for periods without checks", () => {
+      const lines = formatUptimeTable([
+        report({ uptime_24h: null, uptime_7d: null, uptime_30d: null }),
+      ]);
+      expect(lines[1]).not.toContain("%");
+      expect(lines[1]).not.toContain("ms");
+    });
+  });
+});
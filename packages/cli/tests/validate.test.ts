@@ -0,0 +1,282 @@
+import { describe, it, expect } from "bun:test";
+import {
+  findHostConflicts,
+  findUnknownConfigKeys,
+  validateConfig,
+} from "../src/utils/config-validator";
+import { IopConfig } from "../src/config/types";
+
+describe("validate", () => {
+  describe("unknown keys", () => {
+    it("should report unknown keys with a suggestion", () => {
+      const errors = findUnknownConfigKeys({
+        name: "blog",
+        servces: {},
+        services: {
+          web: {
+            image: "blog",
+            server: "1.2.3.4",
+            proxy: { hosts: ["blog.com"], app_prot: 3000 },
+          },
+        },
+      });
+
+      expect(errors.map((error) => error.message)).toEqual([
+        'Unknown key "servces"',
+        'Unknown key "services.web.proxy.app_prot"',
+      ]);
+      expect(errors[0].suggestions).toEqual(['Did you mean "services"?']);
+      expect(errors[1].suggestions).toEqual(['Did you mean "app_port"?']);
+    });
+
+    it("should follow the array form of services", () => {
+      const errors = findUnknownConfigKeys({
+        name: "blog",
+        services: [{ name: "web", image: "blog", server: "1.2.3.4", replica: 2 }],
+      });
+
+      expect(errors.map((error) => error.message)).toEqual([
+        'Unknown key "services.0.replica"',
+      ]);
+    });
+
+    it("should accept a clean config", () => {
+      expect(
+        findUnknownConfigKeys({
+          name: "blog",
+          services: { db: { image: "postgres:17", server: "1.2.3.4" } },
+        })
+      ).toHaveLength(0);
+    });
+  });
+
+  describe("hosts", () => {
+    it("should reject malformed hosts", () => {
+      const config = {
+        name: "blog",
+        services: {
+          web: {
+            image: "blog",
+            server: "1.2.3.4",
+            proxy: {
+              hosts: [
+                "https://blog.com",
+                "blog.com:443",
+                "-bad.com",
+                "ok.blog.com",
+                "*.tenants.blog.com",
+                "a.*.blog.com",
+              ],
+            },
+          },
+        },
+      } as unknown as IopConfig;
+
+      const errors = validateConfig(config).filter((error) => error.type === "invalid_host");
+      expect(errors.map((error) => error.message)).toEqual([
+        'Invalid host "https://blog.com" in web',
+        'Invalid host "blog.com:443" in web',
+        'Invalid host "-bad.com" in web',
+        'Invalid host "a.*.blog.com" in web',
+      ]);
+    });
+
+    it("should reject a host used by two services", () => {
+      const config = {
+        name: "blog",
+        services: {
+          web: { image: "blog", server: "1.2.3.4", proxy: { hosts: ["blog.com"] } },
+          api: { image: "api", server: "1.2.3.4", proxy: { hosts: ["Blog.com"] } },
+        },
+      } as unknown as IopConfig;
+
+      const errors = validateConfig(config);
+      expect(errors).toHaveLength(1);
+      expect(errors[0].type).toBe("duplicate_host");
+      expect(errors[0].entries).toEqual(["web", "api"]);
+    });
+
+    it("should report hosts routed to another project", () => {
+      const config = {
+        name: "blog",
+        services: {
+          web: { image: "blog", server: "1.2.3.4", proxy: { hosts: ["blog.com", "www.blog.com"] } },
+        },
+      } as unknown as IopConfig;
+
+      const errors = findHostConflicts(
+        config,
+        new Map([
+          [
+            "1.2.3.4",
+            {
+              "blog.com": { project: "shop", target: "shop-web:3000", app: "web", ssl_enabled: true, healthy: true },
+              "www.blog.com": { project: "blog", target: "blog-web:3000", app: "web", ssl_enabled: true, healthy: true },
+            },
+          ],
+        ])
+      );
+
+      expect(errors).toHaveLength(1);
+      expect(errors[0].type).toBe("host_conflict");
+      expect(errors[0].message).toContain('already routed to project "shop"');
+    });
+
+    it("should report hosts that proxies on other servers route", () => {
+      const config = {
+        name: "blog",
+        services: {
+          web: { image: "blog", server: "1.2.3.4", proxy: { hosts: ["blog.com", "www.blog.com"], app_port: 3000 } },
+        },
+      } as unknown as IopConfig;
+
+      const errors = findHostConflicts(
+        config,
+        new Map([
+          [
+            "1.2.3.4",
+            {
+              "blog.com": { project: "blog", target: "blog-web:3000", app: "web", ssl_enabled: true, healthy: true },
+            },
+          ],
+          [
+            "5.6.7.8",
+            {
+              "blog.com": { project: "blog", target: "blog-web:3000", app: "web", ssl_enabled: true, healthy: true },
+              "www.blog.com": { project: "shop", target: "shop-web:3000", app: "web", ssl_enabled: true, healthy: true },
+            },
+          ],
+        ])
+      );
+
+      expect(errors.map((error) => error.message)).toEqual([
+        'Host "blog.com" of web is still routed by the proxy on 5.6.7.8, but web deploys to 1.2.3.4',
+        'Host "www.blog.com" of web is also routed to project "shop" by the proxy on 5.6.7.8',
+      ]);
+      expect(errors.every((error) => error.server === "5.6.7.8")).toBe(true);
+    });
+  });
+
+  it("should reject out of range ports", () => {
+    const config = {
+      name: "blog",
+      services: { db: { image: "postgres", server: "1.2.3.4", ports: ["70000:5432", "0"] } },
+    } as unknown as IopConfig;
+
+    const errors = validateConfig(config).filter((error) => error.type === "invalid_port");
+    expect(errors).toHaveLength(2);
+  });
+
+  it("should reject workers with proxy routing or autoscaling", () => {
+    const config = {
+      name: "blog",
+      services: {
+        jobs: {
+          image: "blog",
+          server: "1.2.3.4",
+          type: "worker",
+          proxy: { app_port: 3000 },
+          autoscale: { max_replicas: 3, target_cpu: 70 },
+        },
+        mailer: { image: "blog", server: "1.2.3.4", type: "worker" },
+      },
+    } as unknown as IopConfig;
+
+    const errors = validateConfig(config).filter((error) => error.type === "configuration_error");
+    expect(errors.map((error) => error.message)).toEqual([
+      "Worker jobs has a proxy configuration",
+      "Worker jobs can't autoscale",
+    ]);
+  });
+
+  it("should reject sidecars on services that aren't apps", () => {
+    const sidecars = { logs: { image: "fluent/fluent-bit" } };
+    const config = {
+      name: "blog",
+      services: {
+        web: { image: "blog", server: "1.2.3.4", proxy: { app_port: 3000 }, sidecars },
+        db: { image: "postgres", server: "1.2.3.4", sidecars },
+      },
+    } as unknown as IopConfig;
+
+    const errors = validateConfig(config).filter((error) => error.type === "configuration_error");
+    expect(errors.map((error) => error.message)).toEqual([
+      "Service db has sidecars but isn't an app",
+    ]);
+  });
+
+  it("should reject a registry off the deployed servers or on a routed host", () => {
+    const config = {
+      name: "blog",
+      registry: { host: "app.example.com", server: "5.6.7.8" },
+      services: {
+        web: {
+          image: "blog",
+          server: "1.2.3.4",
+          proxy: { app_port: 3000, hosts: ["app.example.com"] },
+        },
+      },
+    } as unknown as IopConfig;
+
+    const errors = validateConfig(config).filter((error) =>
+      error.entries.includes("registry")
+    );
+    expect(errors.map((error) => error.message)).toEqual([
+      "Registry server 5.6.7.8 doesn't run any service",
+      "Registry host app.example.com is also routed to web",
+    ]);
+  });
+
+  it("should reject a status page on a routed host or showing unknown apps", () => {
+    const config = {
+      name: "blog",
+      status_page: { host: "app.example.com", apps: ["web", "db", "api"], ssl: true },
+      services: {
+        web: {
+          image: "blog",
+          server: "1.2.3.4",
+          proxy: { app_port: 3000, hosts: ["app.example.com"] },
+        },
+        api: { image: "api", server: "5.6.7.8", proxy: { app_port: 8080 } },
+        db: { image: "postgres", server: "1.2.3.4" },
+      },
+    } as unknown as IopConfig;
+
+    const errors = validateConfig(config).filter((error) =>
+      error.entries.includes("status_page")
+    );
+    expect(errors.map((error) => error.message)).toEqual([
+      "Status page host app.example.com is also routed to web",
+      "Status page shows db, which isn't an app with hosts",
+      "Status page shows api, which runs on 5.6.7.8 rather than 1.2.3.4",
+    ]);
+  });
+
+  it("should reject two secret files at the same path", () => {
+    const config = {
+      name: "blog",
+      services: {
+        web: {
+          image: "blog",
+          server: "1.2.3.4",
+          secret_files: [
+            { secret: "KEY", path: "/run/secrets/key" },
+            { secret: "OTHER_KEY", path: "/run/secrets/key" },
+          ],
+        },
+      },
+    } as unknown as IopConfig;
+
+    expect(validateConfig(config).map((error) => error.message)).toEqual([
+      "Service web mounts more than one secret file at /run/secrets/key",
+    ]);
+  });
+
+  it("should reject configs written for a newer schema version", () => {
+    const config = { name: "blog", version: 99 } as unknown as IopConfig;
+
+    const errors = validateConfig(config);
+    expect(errors).toHaveLength(1);
+    expect(errors[0].type).toBe("unsupported_version");
+  });
+});
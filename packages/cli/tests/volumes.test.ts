@@ -0,0 +1,100 @@
+import { describe, it, expect } from "bun:test";
+import {
+  processVolumes,
+  getDeclaredVolumeNames,
+  getProjectVolumeName,
+  getServiceNamedVolumes,
+} from "../src/utils";
+import {
+  getVolumeServers,
+  generateBackupFileName,
+} from "../src/commands/volumes";
+import { IopConfig, IopConfigSchema } from "../src/config/types";
+
+describe("volumes", () => {
+  describe("processVolumes", () => {
+    it("should mount declared volumes as project-prefixed named volumes", () => {
+      const result = processVolumes(
+        ["pgdata:/var/lib/postgresql/data", "./uploads:/app/uploads"],
+        "My Project",
+        ["pgdata"]
+      );
+      expect(result).toEqual([
+        "my-project-pgdata:/var/lib/postgresql/data",
+        "~/.iop/projects/my-project/uploads:/app/uploads",
+      ]);
+    });
+
+    it("should keep mount options for declared volumes", () => {
+      const result = processVolumes(["cache:/cache:ro"], "blog", ["cache"]);
+      expect(result).toEqual(["blog-cache:/cache:ro"]);
+    });
+
+    it("should treat undeclared names as bind mounts", () => {
+      const result = processVolumes(["pgdata:/data"], "blog");
+      expect(result).toEqual(["~/.iop/projects/blog/pgdata:/data"]);
+    });
+  });
+
+  describe("helpers", () => {
+    const config: IopConfig = {
+      name: "blog",
+      volumes: {
+        pgdata: { driver: "local", size: "10GB" },
+        cache: { driver: "local" },
+      },
+      services: {
+        db: {
+          image: "postgres:15",
+          server: "10.0.0.1",
+          volumes: ["pgdata:/var/lib/postgresql/data"],
+        },
+        worker: {
+          image: "blog/worker",
+          server: "10.0.0.2",
+          volumes: ["cache:/cache", "./logs:/logs"],
+        },
+      },
+    };
+
+    it("should list declared volume names", () => {
+      expect(getDeclaredVolumeNames(config)).toEqual(["pgdata", "cache"]);
+      expect(getDeclaredVolumeNames({})).toEqual([]);
+    });
+
+    it("should build the docker volume name", () => {
+      expect(getProjectVolumeName("My_Blog", "pgdata")).toBe("my-blog-pgdata");
+    });
+
+    it("should find the declared volumes a service mounts", () => {
+      expect(
+        getServiceNamedVolumes(["cache:/cache", "./logs:/logs"], ["pgdata", "cache"])
+      ).toEqual(["cache"]);
+      expect(getServiceNamedVolumes(undefined, ["pgdata"])).toEqual([]);
+    });
+
+    it("should resolve the servers a volume lives on", () => {
+      expect(getVolumeServers(config, "pgdata")).toEqual(["10.0.0.1"]);
+      expect(getVolumeServers(config, "cache")).toEqual(["10.0.0.2"]);
+      expect(getVolumeServers(config, "missing")).toEqual([]);
+    });
+
+    it("should generate timestamped backup file names", () => {
+      const date = new Date("2025-03-04T05:06:07.890Z");
+      expect(generateBackupFileName("pgdata", date)).toBe(
+        "pgdata-20250304T050607Z.tar.gz"
+      );
+    });
+  });
+
+  describe("config schema", () => {
+    it("should default the volume driver to local", () => {
+      const parsed = IopConfigSchema.parse({
+        name: "blog",
+        volumes: { pgdata: { size: "5GB" } },
+      });
+      expect(parsed.volumes?.pgdata.driver).toBe("local");
+      expect(parsed.volumes?.pgdata.size).toBe("5GB");
+    });
+  });
+});
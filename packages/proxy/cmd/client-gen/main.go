@@ -0,0 +1,37 @@
+// Command client-gen generates pkg/client from the API's OpenAPI
+// specification. Run it with go generate ./pkg/client after changing the
+// specification.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/elitan/iop/proxy/internal/openapi"
+)
+
+func main() {
+	specPath := flag.String("spec", "internal/api/openapi.json", "OpenAPI specification")
+	out := flag.String("out", "pkg/client/client_gen.go", "Generated Go file")
+	pkg := flag.String("package", "client", "Go package name")
+	flag.Parse()
+
+	data, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatalf("Failed to read specification: %v", err)
+	}
+	spec, err := openapi.Parse(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	code, err := openapi.Generate(spec, *pkg, filepath.Base(*specPath))
+	if err != nil {
+		log.Fatalf("Failed to generate client: %v", err)
+	}
+	if err := os.WriteFile(*out, code, 0644); err != nil {
+		log.Fatalf("Failed to write client: %v", err)
+	}
+}
@@ -15,11 +15,31 @@ import (
 	"time"
 
 	"github.com/elitan/iop/proxy/internal/api"
+	"github.com/elitan/iop/proxy/internal/audit"
+	"github.com/elitan/iop/proxy/internal/autoscale"
 	"github.com/elitan/iop/proxy/internal/cert"
 	"github.com/elitan/iop/proxy/internal/cli"
+	"github.com/elitan/iop/proxy/internal/deployment"
+	"github.com/elitan/iop/proxy/internal/diagnostics"
+	"github.com/elitan/iop/proxy/internal/discovery"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/domains"
+	"github.com/elitan/iop/proxy/internal/events"
 	"github.com/elitan/iop/proxy/internal/health"
+	"github.com/elitan/iop/proxy/internal/networks"
+	"github.com/elitan/iop/proxy/internal/notify"
+	"github.com/elitan/iop/proxy/internal/ports"
+	"github.com/elitan/iop/proxy/internal/quota"
 	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/scaletozero"
+	"github.com/elitan/iop/proxy/internal/sentry"
+	"github.com/elitan/iop/proxy/internal/services"
 	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/stats"
+	"github.com/elitan/iop/proxy/internal/statuspage"
+	"github.com/elitan/iop/proxy/internal/supervisor"
+	"github.com/elitan/iop/proxy/internal/tracing"
+	"github.com/elitan/iop/proxy/internal/uptime"
 )
 
 const (
@@ -60,39 +80,141 @@ func main() {
 	}
 }
 
-// handleCLI handles CLI commands via HTTP API only
+// handleCLI handles CLI commands via HTTP API only, over the unix socket when available
 func handleCLI() error {
-	httpClient := api.NewHTTPClient("http://localhost:8080")
+	httpClient := api.NewLocalClient()
 	httpCli := cli.NewHTTPBasedCLI(httpClient)
 	return httpCli.Execute(os.Args[1:])
 }
 
 func runProxy() error {
+	defer sentry.Recover()
 	log.Println("[PROXY] Starting Lightform proxy...")
 
 	// Load state
-	st := state.NewState(getStateFile())
+	stateFile := getStateFile()
+	st := state.NewState(stateFile)
 	if err := st.Load(); err != nil {
 		return fmt.Errorf("failed to load state: %w", err)
 	}
 
+	// Export traces when an OTLP collector is configured
+	var tracer *tracing.Tracer
+	if config := tracing.ConfigFromEnv(); config != nil {
+		tracer = tracing.NewTracer(tracing.NewOTLPExporter(*config))
+		tracing.SetTracer(tracer)
+		log.Printf("[PROXY] Exporting traces to %s as %s", config.Endpoint, config.ServiceName)
+	}
+
+	// Report panics, failed deployments and repeated certificate errors when a Sentry DSN is configured
+	var sentryClient *sentry.Client
+	if config := sentry.ConfigFromEnv(); config != nil {
+		client, err := sentry.NewClient(*config)
+		if err != nil {
+			return err
+		}
+		sentryClient = client
+		sentry.SetClient(sentryClient)
+		log.Printf("[PROXY] Reporting errors to Sentry as %s", config.ServerName)
+	}
+
 	// Create certificate manager
 	certManager, err := cert.NewManager(st)
 	if err != nil {
 		return fmt.Errorf("failed to create certificate manager: %w", err)
 	}
+	expiryThresholds, err := cert.ExpiryThresholdsFromEnv()
+	if err != nil {
+		return err
+	}
+	expiryMonitor := cert.NewExpiryMonitor(certManager, expiryThresholds)
 
 	// Create health checker
 	healthChecker := health.NewChecker(st)
 
+	// Publish deployment, certificate and health events for notifications
+	eventBus := events.NewSimpleBus()
+	certManager.SetEventBus(eventBus)
+	certManager.Watch()
+	healthChecker.SetEventBus(eventBus)
+	notifier := notify.NewNotifier(st)
+
+	// Sample app container usage for the stats API and Prometheus metrics
+	dockerClient := docker.New()
+	statsCollector := stats.NewCollector(dockerClient)
+	statsCollector.SetEventBus(eventBus)
+
+	// Restart apps that keep failing their liveness probe
+	healthChecker.SetDocker(dockerClient)
+
 	// Create router
 	rt := router.NewRouter(st, certManager)
+	rt.Watch()
+
+	// Internal hosts are only served to clients on these networks
+	internalNetworks, err := router.InternalNetworksFromEnv()
+	if err != nil {
+		return err
+	}
+	rt.SetInternalNetworks(internalNetworks)
+
+	// Stop idle apps of hosts that scale to zero and start them on the next request
+	scaleToZero := scaletozero.NewManager(st, dockerClient, services.NewHealthService())
+	rt.SetWaker(scaleToZero)
+
+	// Cap what each project runs on this server, checked by deploys and the autoscaler
+	quotaChecker := quota.New(st, dockerClient)
+
+	// Scale apps with an autoscaling policy on their request rate, latency and CPU usage
+	autoscaler := autoscale.New(st, dockerClient, rt, statsCollector, eventBus)
+	autoscaler.SetQuotas(quotaChecker)
+
+	// Attach the proxy container to the networks of the projects it routes to
+	networkManager := networks.NewManager(st, dockerClient)
+	networkManager.Watch()
+
+	// Forward raw TCP/UDP ports. Rules that fail to bind are logged and skipped.
+	portManager := ports.NewManager(st)
+	portManager.Sync()
+
+	// Resolve service names such as postgres.internal for containers and
+	// forward other queries. A DNS server that fails to listen is logged and skipped.
+	dnsServer := discovery.NewServer(st, discovery.UpstreamFromEnv())
+	if addr := discovery.ListenAddrFromEnv(); addr != "" {
+		if err := dnsServer.Start(addr); err != nil {
+			log.Printf("[DNS] %v", err)
+		}
+	}
+
+	// Check each host from outside through DNS, TLS and the proxy, keeping
+	// the history next to the state file
+	uptimeMonitor := uptime.New(st, filepath.Join(filepath.Dir(stateFile), "uptime.json"))
+	if err := uptimeMonitor.Load(); err != nil {
+		log.Printf("[UPTIME] %v", err)
+	}
 
 	// Create channel to signal when HTTP server is ready
 	httpServerReady := make(chan struct{})
 
 	// Create and start HTTP API server with readiness signal
 	httpAPIServer := api.NewHTTPServerWithReadiness(st, certManager, healthChecker, httpServerReady)
+	httpAPIServer.SetEventBus(eventBus)
+	httpAPIServer.SetPortManager(portManager)
+	domainManager := domains.NewManager(st, certManager)
+	httpAPIServer.SetDomainManager(domainManager)
+	httpAPIServer.SetNetworkManager(networkManager)
+	httpAPIServer.SetStatsCollector(statsCollector)
+	httpAPIServer.SetRequestMetrics(rt)
+	httpAPIServer.SetAutoscaler(autoscaler)
+	// Roll back deploys whose error rate or latency regress while they bake
+	httpAPIServer.SetBaker(deployment.NewBaker(dockerClient, rt, eventBus))
+	httpAPIServer.SetQuotaChecker(quotaChecker)
+	httpAPIServer.SetUptimeMonitor(uptimeMonitor)
+	// Check ports, Docker, the ACME directory, disk space and the clock on request
+	httpAPIServer.SetDiagnostics(diagnostics.New(st, dockerClient, filepath.Dir(stateFile), cert.Dir()))
+	httpAPIServer.SetSocketPath(api.SocketPath())
+	// Record state-changing API calls next to the state file
+	httpAPIServer.SetAuditLog(audit.NewLog(filepath.Join(filepath.Dir(stateFile), "audit.log")))
 	if err := httpAPIServer.Start(); err != nil {
 		return fmt.Errorf("failed to start HTTP API server: %w", err)
 	}
@@ -108,13 +230,91 @@ func runProxy() error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer sentry.Recover()
 		healthChecker.Start(ctx)
 	}()
 
+	// Re-check customer domains waiting for verification
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		domainManager.Run(ctx)
+	}()
+
+	// Reconcile the proxy container's networks at startup and after host changes
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		networkManager.Run(ctx)
+	}()
+
+	// Restart crashed app containers and catch crash loops
+	containerSupervisor := supervisor.New(st, dockerClient, eventBus)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		containerSupervisor.Run(ctx)
+	}()
+
+	// Start stats collector
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		statsCollector.Run(ctx)
+	}()
+
+	// Start inactivity monitor
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		scaleToZero.Run(ctx)
+	}()
+
+	// Start autoscaler
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		autoscaler.Run(ctx)
+	}()
+
+	// Start uptime monitor
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		uptimeMonitor.Run(ctx)
+	}()
+
+	// Start notifier
+	notifierEvents := eventBus.Subscribe()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		notifier.Run(ctx, notifierEvents)
+	}()
+
+	// Start error reporter
+	if sentryClient != nil {
+		reporterEvents := eventBus.Subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sentry.NewReporter(st, sentryClient).Run(ctx, reporterEvents)
+		}()
+	}
+
 	// Start state persistence worker
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer sentry.Recover()
 		statePersistenceWorker(ctx, st)
 	}()
 
@@ -122,6 +322,7 @@ func runProxy() error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer sentry.Recover()
 		certificateAcquisitionWorker(ctx, st, certManager)
 	}()
 
@@ -129,13 +330,22 @@ func runProxy() error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		certificateRenewalWorker(ctx, st, certManager)
+		defer sentry.Recover()
+		certManager.RunRenewals(ctx)
+	}()
+
+	// Alert before certificates expire and when renewals keep failing
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer sentry.Recover()
+		expiryMonitor.Run(ctx)
 	}()
 
 	// Start HTTP server
 	httpServer := &http.Server{
 		Addr:         ":80",
-		Handler:      rt,
+		Handler:      sentry.Handler(rt),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -165,7 +375,7 @@ func runProxy() error {
 	// Start HTTPS server
 	httpsServer := &http.Server{
 		Addr:         ":443",
-		Handler:      rt,
+		Handler:      sentry.Handler(rt),
 		TLSConfig:    rt.GetTLSConfig(),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
@@ -176,11 +386,36 @@ func runProxy() error {
 	go func() {
 		defer wg.Done()
 		log.Println("[PROXY] Starting HTTPS server on :443")
-		if err := httpsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+
+		ln, err := net.Listen("tcp", ":443")
+		if err != nil {
+			log.Printf("[PROXY] HTTPS server listen error: %v", err)
+			return
+		}
+
+		// Route passthrough hosts by SNI before TLS is terminated
+		if err := httpsServer.ServeTLS(router.NewSNIListener(ln, rt), "", ""); err != nil && err != http.ErrServerClosed {
 			log.Printf("[PROXY] HTTPS server error: %v", err)
 		}
 	}()
 
+	// Serve status pages, which the router reaches like any other backend
+	statusPageServer := &http.Server{
+		Addr:         state.StatusPageTarget,
+		Handler:      sentry.Handler(statuspage.New(st, uptimeMonitor)),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Printf("[STATUS-PAGE] Starting status page server on %s", state.StatusPageTarget)
+		if err := statusPageServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[STATUS-PAGE] Status page server error: %v", err)
+		}
+	}()
+
 	// Wait for shutdown signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -203,32 +438,71 @@ func runProxy() error {
 		log.Printf("[PROXY] HTTPS server shutdown error: %v", err)
 	}
 
+	if err := statusPageServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[PROXY] Status page server shutdown error: %v", err)
+	}
+
 	// Shutdown HTTP API server
 	if err := httpAPIServer.Stop(); err != nil {
 		log.Printf("[PROXY] HTTP API server shutdown error: %v", err)
 	}
 
+	// Stop forwarding ports
+	portManager.Stop()
+	dnsServer.Close()
+
 	// Wait for background workers to finish
 	wg.Wait()
 
+	// Flush spans that haven't been exported yet
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[PROXY] Trace exporter shutdown error: %v", err)
+	}
+
+	// Send error reports that are still queued
+	if err := sentryClient.Flush(shutdownCtx); err != nil {
+		log.Printf("[PROXY] Error reporter shutdown error: %v", err)
+	}
+
 	log.Println("[PROXY] Shutdown complete")
 	return nil
 }
 
-// statePersistenceWorker periodically saves state to disk
+// stateSaveDelay is how long the state must go without changes before it is
+// saved, so a burst of mutations is written once
+const stateSaveDelay = 1500 * time.Millisecond
+
+// statePersistenceWorker saves state to disk shortly after it changes, right
+// away after deploys and issued certificates, periodically as a fallback and
+// once more on shutdown
 func statePersistenceWorker(ctx context.Context, st *state.State) {
 	log.Println("[WORKER] Starting state persistence worker")
 
 	ticker := time.NewTicker(60 * time.Second)
 	defer ticker.Stop()
 
+	debounce := time.NewTimer(stateSaveDelay)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	save := func() {
+		if err := st.Save(); err != nil {
+			log.Printf("[WORKER] Failed to save state: %v", err)
+		}
+	}
+
 	for {
 		select {
+		case <-st.Changed():
+			debounce.Reset(stateSaveDelay)
+		case <-st.Urgent():
+			save()
+		case <-debounce.C:
+			save()
 		case <-ticker.C:
-			if err := st.Save(); err != nil {
-				log.Printf("[WORKER] Failed to save state: %v", err)
-			}
+			save()
 		case <-ctx.Done():
+			save()
 			log.Println("[WORKER] Stopping state persistence worker")
 			return
 		}
@@ -306,51 +580,3 @@ func processPendingCertificates(st *state.State, cm *cert.Manager) {
 		}
 	}
 }
-
-// certificateRenewalWorker checks for certificates that need renewal
-func certificateRenewalWorker(ctx context.Context, st *state.State, cm *cert.Manager) {
-	log.Println("[WORKER] Starting certificate renewal worker")
-
-	// Check every 12 hours
-	ticker := time.NewTicker(12 * time.Hour)
-	defer ticker.Stop()
-
-	// Initial check
-	checkCertificateRenewals(st, cm)
-
-	for {
-		select {
-		case <-ticker.C:
-			checkCertificateRenewals(st, cm)
-		case <-ctx.Done():
-			log.Println("[WORKER] Stopping certificate renewal worker")
-			return
-		}
-	}
-}
-
-// checkCertificateRenewals checks for certificates expiring within 30 days
-func checkCertificateRenewals(st *state.State, cm *cert.Manager) {
-	hosts := st.GetAllHosts()
-	renewalThreshold := 30 * 24 * time.Hour // 30 days
-
-	for hostname, host := range hosts {
-		if host.Certificate == nil || host.Certificate.Status != "active" {
-			continue
-		}
-
-		cert := host.Certificate
-		timeUntilExpiry := time.Until(cert.ExpiresAt)
-
-		if timeUntilExpiry < renewalThreshold {
-			log.Printf("[WORKER] Certificate for %s expires in %d days, attempting renewal",
-				hostname, int(timeUntilExpiry.Hours()/24))
-
-			go func(h string) {
-				if err := cm.RenewCertificate(h); err != nil {
-					log.Printf("[WORKER] Certificate renewal failed for %s: %v", h, err)
-				}
-			}(hostname)
-		}
-	}
-}
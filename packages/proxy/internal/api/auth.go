@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// TokenPrefix starts every API token so they are easy to spot in configs and logs
+const TokenPrefix = "iop_"
+
+type contextKey int
+
+const (
+	// socketKey marks requests that came in over the unix socket
+	socketKey contextKey = iota
+	// userKey holds the authenticated *state.User of a request
+	userKey
+)
+
+// deployerRoutes are the changes a deployer may make: everything a deploy
+// applies to its own hosts. Keyed by method, then by path pattern where *
+// is one path segment.
+var deployerRoutes = map[string][]string{
+	http.MethodPost:   {"/api/deploy", "/api/deployments", "/api/deployments/*/steps", "/api/cert/renew/*", "/api/quotas/check", "/api/incidents", "/api/incidents/*/updates"},
+	http.MethodPatch:  {"/api/hosts/*"},
+	http.MethodPut:    {"/api/hosts/*", "/api/hosts/*/health", "/api/hosts/*/tls", "/api/hosts/*/limits", "/api/hosts/*/rules", "/api/hosts/*/mirror", "/api/hosts/*/probes", "/api/hosts/*/error-pages", "/api/hosts/*/scale-to-zero", "/api/apps/*/*/stopped", "/api/autoscale", "/api/status-pages"},
+	http.MethodDelete: {"/api/autoscale", "/api/status-pages", "/api/incidents/*"},
+}
+
+// adminReads are reads that expose secrets: exports carry private keys
+var adminReads = []string{"/api/export", "/api/users"}
+
+// projectRoutes are the endpoints a user limited to projects may call. Each
+// checks or filters what it touches by project, every other endpoint is
+// server-wide. Keyed by method, then by path pattern where * is one path
+// segment.
+var projectRoutes = map[string][]string{
+	http.MethodGet: {"/api/hosts", "/api/hosts/*", "/api/hosts/*/*", "/api/deployments/*", "/api/cert/precheck", "/api/certs/expiring",
+		"/api/status", "/api/stats", "/api/stats/apps", "/api/uptime", "/api/audit", "/api/autoscale", "/api/discovery", "/api/quotas", "/api/status-pages",
+		"/api/incidents", "/api/openapi.json"},
+	http.MethodPost:   {"/api/deploy", "/api/deployments", "/api/deployments/*/steps", "/api/cert/renew/*", "/api/cert/revoke/*", "/api/cert/promote", "/api/quotas/check", "/api/incidents", "/api/incidents/*/updates"},
+	http.MethodPatch:  {"/api/hosts/*"},
+	http.MethodPut:    {"/api/hosts/*", "/api/hosts/*/*", "/api/apps/*/*/stopped", "/api/autoscale", "/api/discovery", "/api/status-pages"},
+	http.MethodDelete: {"/api/hosts/*", "/api/autoscale", "/api/status-pages", "/api/incidents/*"},
+}
+
+// projectRoute reports whether a user limited to projects may call an endpoint
+func projectRoute(method, path string) bool {
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	for _, pattern := range projectRoutes[method] {
+		if matchRoute(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredRole returns the least privileged role that may make a request
+func requiredRole(method, path string) string {
+	for _, pattern := range adminReads {
+		if path == pattern || strings.HasPrefix(path, pattern+"/") {
+			return state.RoleAdmin
+		}
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return state.RoleReadOnly
+	}
+	for _, pattern := range deployerRoutes[method] {
+		if matchRoute(pattern, path) {
+			return state.RoleDeployer
+		}
+	}
+	return state.RoleAdmin
+}
+
+// matchRoute matches a path against a pattern where * is one path segment
+func matchRoute(pattern, path string) bool {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		if part != "*" && part != pathParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// authorize checks each request's bearer token against the role its
+// endpoint requires. Until the first user is added the API stays open, as
+// it only listens locally. Requests over the unix socket without a token
+// act as admin: the socket's file permissions already limit who can connect.
+func (s *HTTPServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !hasToken {
+			if !s.state.HasUsers() || r.Context().Value(socketKey) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeErrorResponse(w, "API token required, set IOP_PROXY_TOKEN", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := s.state.Authenticate(strings.TrimSpace(token))
+		if err != nil {
+			log.Printf("[HTTP-API] Rejected %s %s: invalid token", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			s.writeErrorResponse(w, "Invalid API token", http.StatusUnauthorized)
+			return
+		}
+
+		if required := requiredRole(r.Method, r.URL.Path); !state.RoleAllows(user.Role, required) {
+			log.Printf("[HTTP-API] Denied %s %s to %s (%s)", r.Method, r.URL.Path, user.Name, user.Role)
+			s.writeErrorResponse(w, fmt.Sprintf("User %s has role %s, %s %s requires %s", user.Name, user.Role, r.Method, r.URL.Path, required), http.StatusForbidden)
+			return
+		}
+
+		if len(user.Projects) > 0 && !projectRoute(r.Method, r.URL.Path) {
+			log.Printf("[HTTP-API] Denied %s %s to %s (limited to projects)", r.Method, r.URL.Path, user.Name)
+			s.writeErrorResponse(w, fmt.Sprintf("User %s is limited to projects %s, %s %s is server-wide", user.Name, strings.Join(user.Projects, ", "), r.Method, r.URL.Path), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+	})
+}
+
+// limitedUser returns the user of a request if they are limited to
+// projects, nil if the request may touch every project
+func limitedUser(r *http.Request) *state.User {
+	if user, ok := r.Context().Value(userKey).(*state.User); ok && len(user.Projects) > 0 {
+		return user
+	}
+	return nil
+}
+
+// allowsProject reports whether a request may touch a project
+func allowsProject(r *http.Request, project string) bool {
+	user := limitedUser(r)
+	return user == nil || user.Allows(project)
+}
+
+// allowsHost reports whether a request may touch a host. Hosts that don't
+// exist yet are checked by the project they are created in.
+func (s *HTTPServer) allowsHost(r *http.Request, hostname string) bool {
+	if limitedUser(r) == nil {
+		return true
+	}
+	_, project, err := s.state.GetHost(hostname)
+	return err != nil || allowsProject(r, project)
+}
+
+// allowedHosts returns the hosts a request may touch, sorted
+func (s *HTTPServer) allowedHosts(r *http.Request) []string {
+	var hostnames []string
+	for hostname := range s.state.GetAllHosts() {
+		if s.allowsHost(r, hostname) {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+	return hostnames
+}
+
+// checkProject answers 403 and returns false if a request may not touch a project
+func (s *HTTPServer) checkProject(w http.ResponseWriter, r *http.Request, project string) bool {
+	if allowsProject(r, project) {
+		return true
+	}
+	s.denyProject(w, r)
+	return false
+}
+
+// checkHost answers 403 and returns false if a request may not touch a host
+func (s *HTTPServer) checkHost(w http.ResponseWriter, r *http.Request, hostname string) bool {
+	if s.allowsHost(r, hostname) {
+		return true
+	}
+	s.denyProject(w, r)
+	return false
+}
+
+// denyProject answers a request for another project without telling which
+// project that is
+func (s *HTTPServer) denyProject(w http.ResponseWriter, r *http.Request) {
+	user := limitedUser(r)
+	log.Printf("[HTTP-API] Denied %s %s to %s (other project)", r.Method, r.URL.Path, user.Name)
+	s.writeErrorResponse(w, fmt.Sprintf("User %s is limited to projects %s", user.Name, strings.Join(user.Projects, ", ")), http.StatusForbidden)
+}
+
+// newToken returns a random API token
+func newToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return TokenPrefix + hex.EncodeToString(bytes), nil
+}
+
+// UserRequest adds an API user
+type UserRequest struct {
+	Name     string   `json:"name"`
+	Role     string   `json:"role"`
+	Projects []string `json:"projects,omitempty"` // Limit the token to these projects
+}
+
+// UserCreated is a new user with their token, which is only shown once
+type UserCreated struct {
+	state.User
+	Token string `json:"token"`
+}
+
+// handleUsers handles GET and POST /api/users
+func (s *HTTPServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSuccessResponse(w, "", s.state.GetUsers())
+	case http.MethodPost:
+		var req UserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" || strings.Contains(req.Name, "/") {
+			s.writeErrorResponse(w, "Invalid user name", http.StatusBadRequest)
+			return
+		}
+
+		token, err := newToken()
+		if err != nil {
+			s.writeErrorResponse(w, fmt.Sprintf("Failed to generate token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := s.state.AddUser(req.Name, req.Role, req.Projects, state.HashToken(token)); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.saveUsers()
+
+		scope := "every project"
+		if len(req.Projects) > 0 {
+			scope = "projects " + strings.Join(req.Projects, ",")
+		}
+		log.Printf("[HTTP-API] Added user %s with role %s for %s", req.Name, req.Role, scope)
+		s.record(r, "users.add", "", fmt.Sprintf("name=%s role=%s projects=%s", req.Name, req.Role, strings.Join(req.Projects, ",")))
+		s.writeSuccessResponse(w, fmt.Sprintf("Added user %s with role %s for %s", req.Name, req.Role, scope), UserCreated{
+			User:  state.User{Name: req.Name, Role: req.Role, Projects: req.Projects},
+			Token: token,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUser handles DELETE /api/users/:name
+func (s *HTTPServer) handleUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if err := s.state.RemoveUser(name); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.saveUsers()
+
+	log.Printf("[HTTP-API] Removed user %s", name)
+	s.record(r, "users.remove", "", "name="+name)
+	s.writeSuccessResponse(w, fmt.Sprintf("Removed user %s, their token no longer works", name), nil)
+}
+
+// saveUsers writes state right away so that a revoked token doesn't come
+// back if the proxy stops before the next periodic save
+func (s *HTTPServer) saveUsers() {
+	if err := s.state.Save(); err != nil {
+		log.Printf("[HTTP-API] Failed to save state: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method, path, role string
+	}{
+		{http.MethodGet, "/api/hosts", state.RoleReadOnly},
+		{http.MethodGet, "/metrics", state.RoleReadOnly},
+		{http.MethodGet, "/api/export", state.RoleAdmin},
+		{http.MethodGet, "/api/users", state.RoleAdmin},
+		{http.MethodPost, "/api/deploy", state.RoleDeployer},
+		{http.MethodPatch, "/api/hosts/blog.example.com", state.RoleDeployer},
+		{http.MethodPut, "/api/hosts/blog.example.com", state.RoleDeployer},
+		{http.MethodPut, "/api/hosts/blog.example.com/health", state.RoleDeployer},
+		{http.MethodPost, "/api/cert/renew/blog.example.com", state.RoleDeployer},
+		{http.MethodPost, "/api/deployments", state.RoleDeployer},
+		{http.MethodPost, "/api/deployments/3f9a/steps", state.RoleDeployer},
+		{http.MethodPut, "/api/apps/blog/web/stopped", state.RoleDeployer},
+		{http.MethodPost, "/api/quotas/check", state.RoleDeployer},
+		{http.MethodPut, "/api/quotas", state.RoleAdmin},
+		{http.MethodDelete, "/api/hosts/blog.example.com", state.RoleAdmin},
+		{http.MethodPut, "/api/acme", state.RoleAdmin},
+		{http.MethodPost, "/api/apply", state.RoleAdmin},
+		{http.MethodDelete, "/api/users/ci", state.RoleAdmin},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.role, requiredRole(tt.method, tt.path), "%s %s", tt.method, tt.path)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	s := NewHTTPServer(st, nil, nil)
+	handler := s.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, path, token string, socket bool) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if socket {
+			req = req.WithContext(context.WithValue(req.Context(), socketKey, true))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Open until the first user exists
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/api/hosts/blog.example.com", "", false))
+
+	require.NoError(t, st.AddUser("ci", state.RoleDeployer, nil, state.HashToken("iop_ci")))
+	require.NoError(t, st.AddUser("grafana", state.RoleReadOnly, nil, state.HashToken("iop_grafana")))
+
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/hosts", "", false))
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/hosts", "iop_wrong", false))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/hosts", "iop_grafana", false))
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/deploy", "iop_grafana", false))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/deploy", "iop_ci", false))
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/api/hosts/blog.example.com", "iop_ci", false))
+
+	// The socket's file permissions stand in for a token, but a token still limits the role
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/api/hosts/blog.example.com", "", true))
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/api/hosts/blog.example.com", "iop_ci", true))
+}
+
+func TestProjectScopedTokens(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-web:3000", "shop", "web", "/up", false))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	st.SetServiceRecords("shop", []state.ServiceRecord{{Name: "db", Address: "10.210.0.2"}})
+	st.SetServiceRecords("blog", []state.ServiceRecord{{Name: "db", Address: "10.210.0.3"}})
+	require.NoError(t, st.AddUser("shop-ci", state.RoleAdmin, []string{"shop"}, state.HashToken("iop_shop")))
+	handler := NewHTTPServer(st, nil, nil).Handler()
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer iop_shop")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	data := func(rec *httptest.ResponseRecorder, v interface{}) {
+		var envelope struct{ Data json.RawMessage }
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &envelope))
+		require.NoError(t, json.Unmarshal(envelope.Data, v))
+	}
+
+	// Lists only show the token's projects
+	rec := serve(http.MethodGet, "/api/hosts", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var hosts map[string]interface{}
+	data(rec, &hosts)
+	assert.Contains(t, hosts, "shop.example.com")
+	assert.NotContains(t, hosts, "blog.example.com")
+
+	var records []state.ServiceRecord
+	data(serve(http.MethodGet, "/api/discovery", ""), &records)
+	assert.Equal(t, []state.ServiceRecord{{Project: "shop", Name: "db", Address: "10.210.0.2"}}, records)
+
+	// Other projects' hosts can't be read, changed or taken over
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/hosts/shop.example.com", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/hosts/blog.example.com", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPut, "/api/hosts/blog.example.com", `{"project":"shop","target":"shop-web:3000"}`).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/deploy", `{"host":"blog.example.com","target":"shop-web:3000","project":"shop"}`).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/deploy", `{"host":"new.example.com","target":"blog-web:3000","project":"blog"}`).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPut, "/api/discovery", `{"project":"blog","records":[]}`).Code)
+
+	// Server-wide settings are out of reach whatever the role
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/users", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/export", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/api/quotas?project=shop", "").Code)
+	assert.True(t, projectRoute(http.MethodHead, "/api/hosts/shop.example.com"))
+	assert.False(t, projectRoute(http.MethodPost, "/api/apply"))
+}
+
+type fakeRequestMetrics map[string]router.RequestStats
+
+func (f fakeRequestMetrics) RequestStats() map[string]router.RequestStats { return f }
+
+func TestAppStatsForProjectScopedTokens(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-web:3000", "shop", "web", "/up", false))
+	require.NoError(t, st.DeployHost("www.shop.example.com", "shop-web:3000", "shop", "", "/up", false))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.AddUser("shop-ci", state.RoleReadOnly, []string{"shop"}, state.HashToken("iop_shop")))
+	s := NewHTTPServer(st, nil, nil)
+	s.SetRequestMetrics(fakeRequestMetrics{
+		"shop.example.com":     {Requests: 10, Errors: 1, Latency: time.Second},
+		"www.shop.example.com": {Requests: 5, Latency: time.Second},
+		"blog.example.com":     {Requests: 7},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/apps", nil)
+	req.Header.Set("Authorization", "Bearer iop_shop")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var envelope struct{ Data []AppStats }
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &envelope))
+	require.Len(t, envelope.Data, 1, "other projects' apps are left out")
+	app := envelope.Data[0]
+	assert.Equal(t, "shop", app.Project)
+	assert.Equal(t, []string{"shop.example.com", "www.shop.example.com"}, app.Hosts)
+	assert.Equal(t, uint64(15), app.Requests)
+	assert.Equal(t, uint64(1), app.Errors)
+	assert.Equal(t, 2*time.Second, app.Latency)
+}
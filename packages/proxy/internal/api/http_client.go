@@ -2,51 +2,129 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/quota"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/timeline"
+	"github.com/elitan/iop/proxy/pkg/client"
 )
 
-// HTTPClient provides HTTP API client for CLI commands
+// HTTPClient provides HTTP API client for CLI commands. It prints results
+// for people, pkg/client makes the requests.
 type HTTPClient struct {
-	baseURL    string
-	httpClient *http.Client
+	api *client.Client
 }
 
 // NewHTTPClient creates a new HTTP API client
 func NewHTTPClient(baseURL string) *HTTPClient {
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
+	return &HTTPClient{api: client.New(baseURL, envOptions()...)}
+}
+
+// envOptions authenticates the CLI with IOP_PROXY_TOKEN. IOP_ACTOR is set by
+// the iop CLI so the audit log shows who made the change.
+func envOptions() []client.Option {
+	return []client.Option{
+		client.WithToken(os.Getenv("IOP_PROXY_TOKEN")),
+		client.WithActor(os.Getenv("IOP_ACTOR")),
 	}
+}
 
-	return &HTTPClient{
-		baseURL:    baseURL,
-		httpClient: &http.Client{},
+// done prints the outcome of a change, or wraps the API's error
+func done(resp *client.Response, err error, failure string) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", failure, err)
 	}
+	fmt.Printf("✅ %s\n", resp.Message)
+	return nil
 }
 
-// Deploy deploys a host via HTTP API
-func (c *HTTPClient) Deploy(host, target, project, app, healthPath string, ssl bool) error {
-	req := HTTPDeployRequest{
-		Host:       host,
-		Target:     target,
-		Project:    project,
-		App:        app,
-		HealthPath: healthPath,
-		SSL:        ssl,
+// convert copies a value into its pkg/client counterpart. Fields the
+// client type lacks are an error: the OpenAPI specification is out of date.
+func convert(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(to); err != nil {
+		return fmt.Errorf("failed to convert %T: %w", from, err)
 	}
+	return nil
+}
 
-	resp, err := c.makeRequest("POST", "/api/deploy", req)
+// printJSON prints API data as JSON, for scripts
+func printJSON(data interface{}, what string) error {
+	jsonData, err := json.Marshal(data)
 	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", what, err)
+	}
+	fmt.Println(string(jsonData))
+	return nil
+}
+
+// Deploy deploys a host via HTTP API
+func (c *HTTPClient) Deploy(host, target, project, app, healthPath string, ssl bool, mode string, streaming, internal bool, timeouts state.HostTimeouts, metadata map[string]string, deploymentID string, bake *HTTPBakeRequest) error {
+	var bakeRequest *client.BakeRequest
+	if bake != nil {
+		bakeRequest = &client.BakeRequest{}
+		if err := convert(bake, bakeRequest); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.api.DeployHost(context.Background(), &client.DeployRequest{
+		Host:              host,
+		Target:            target,
+		Project:           project,
+		App:               app,
+		HealthPath:        healthPath,
+		SSL:               ssl,
+		Mode:              mode,
+		Streaming:         streaming,
+		Internal:          internal,
+		Metadata:          metadata,
+		DeploymentID:      deploymentID,
+		Bake:              bakeRequest,
+		DialTimeout:       timeouts.DialTimeout,
+		ResponseTimeout:   timeouts.ResponseTimeout,
+		RequestTimeout:    timeouts.RequestTimeout,
+		StreamIdleTimeout: timeouts.StreamIdleTimeout,
+	})
+	return done(resp, err, "deployment failed")
+}
+
+// Apply replaces all hosts with the desired set via HTTP API and prints what changed
+func (c *HTTPClient) Apply(req *HTTPApplyRequest) error {
+	var body client.ApplyRequest
+	if err := convert(req, &body); err != nil {
 		return err
 	}
 
-	if resp.Success {
-		fmt.Printf("✅ %s\n", resp.Message)
-	} else {
-		return fmt.Errorf("deployment failed: %s", resp.Message)
+	result, resp, err := c.api.ApplyHosts(context.Background(), &body)
+	if err := done(resp, err, "apply failed"); err != nil {
+		return err
+	}
+	if result != nil {
+		for _, change := range []struct {
+			hosts []string
+			sign  string
+		}{{result.Added, "+"}, {result.Updated, "~"}, {result.Removed, "-"}} {
+			for _, host := range change.hosts {
+				fmt.Printf("  %s %s\n", change.sign, host)
+			}
+		}
 	}
 
 	return nil
@@ -54,150 +132,276 @@ func (c *HTTPClient) Deploy(host, target, project, app, healthPath string, ssl b
 
 // Remove removes a host via HTTP API
 func (c *HTTPClient) Remove(host string) error {
-	resp, err := c.makeRequest("DELETE", fmt.Sprintf("/api/hosts/%s", host), nil)
+	resp, err := c.api.RemoveHost(context.Background(), host)
+	return done(resp, err, "removal failed")
+}
+
+// List lists all hosts via HTTP API
+func (c *HTTPClient) List() error {
+	hosts, _, err := c.api.ListHosts(context.Background())
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to list hosts: %w", err)
 	}
 
-	if resp.Success {
-		fmt.Printf("✅ %s\n", resp.Message)
-	} else {
-		return fmt.Errorf("removal failed: %s", resp.Message)
+	if len(hosts) == 0 {
+		fmt.Println("No hosts configured")
+		return nil
+	}
+
+	fmt.Println("Configured hosts:")
+	for hostname, host := range hosts {
+		fmt.Printf("  %s -> %s (SSL: %v, Healthy: %v)\n", hostname, host.Target, host.SSLEnabled, host.Healthy)
+		if host.CrashLooping {
+			fmt.Println("    Container is crash looping")
+		}
+		if host.Sleeping {
+			fmt.Println("    Scaled to zero, starts on the next request")
+		}
+		if host.Stopped {
+			fmt.Println("    Stopped, starts with iop start or the next deploy")
+		}
+		if host.Certificate != nil {
+			fmt.Printf("    Certificate: %s\n", host.Certificate.Status)
+		}
 	}
 
 	return nil
 }
 
-// List lists all hosts via HTTP API
-func (c *HTTPClient) List() error {
-	resp, err := c.makeRequest("GET", "/api/hosts", nil)
+// ListJSON prints all hosts as JSON via HTTP API, for scripts
+func (c *HTTPClient) ListJSON() error {
+	hosts, _, err := c.api.ListHosts(context.Background())
 	if err != nil {
+		return fmt.Errorf("failed to list hosts: %w", err)
+	}
+	return printJSON(hosts, "hosts")
+}
+
+// UpdateHealth updates host health status via HTTP API
+func (c *HTTPClient) UpdateHealth(host string, healthy bool) error {
+	resp, err := c.api.UpdateHealth(context.Background(), host, &client.HealthUpdateRequest{Healthy: healthy})
+	return done(resp, err, "health update failed")
+}
+
+// SetAppStopped stops or starts serving an app's hosts via HTTP API
+func (c *HTTPClient) SetAppStopped(project, app string, stopped bool) error {
+	hosts, resp, err := c.api.SetAppStopped(context.Background(), project, app, &client.AppStoppedRequest{Stopped: stopped})
+	if err := done(resp, err, "app state update failed"); err != nil {
 		return err
 	}
+	if stopped {
+		fmt.Printf("Stopped %s: %s\n", app, strings.Join(hosts, ", "))
+	} else {
+		fmt.Printf("Started %s: %s\n", app, strings.Join(hosts, ", "))
+	}
+	return nil
+}
+
+// CertRenew renews certificate via HTTP API
+func (c *HTTPClient) CertRenew(host string) error {
+	resp, err := c.api.RenewCertificate(context.Background(), host)
+	return done(resp, err, "certificate renewal failed")
+}
+
+// CertRevoke revokes a certificate via HTTP API
+func (c *HTTPClient) CertRevoke(host, reason string) error {
+	resp, err := c.api.RevokeCertificate(context.Background(), host, &client.CertRevokeRequest{Reason: reason})
+	return done(resp, err, "certificate revocation failed")
+}
 
-	if !resp.Success {
-		return fmt.Errorf("failed to list hosts: %s", resp.Message)
+// SetCertGroups sets the projects whose hosts share one certificate via HTTP API, empty ungroups all
+func (c *HTTPClient) SetCertGroups(projects []string) error {
+	resp, err := c.api.SetCertGroups(context.Background(), &client.CertGroups{Projects: projects})
+	return done(resp, err, "certificate groups update failed")
+}
+
+// ShowCertGroups prints the projects whose hosts share one certificate via HTTP API
+func (c *HTTPClient) ShowCertGroups() error {
+	groups, _, err := c.api.GetCertGroups(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get certificate groups: %w", err)
 	}
 
-	// Pretty print the hosts data
-	if hostsData, ok := resp.Data.(map[string]interface{}); ok {
-		if len(hostsData) == 0 {
-			fmt.Println("No hosts configured")
-			return nil
-		}
+	if groups == nil || len(groups.Projects) == 0 {
+		fmt.Println("No certificate groups, every host gets its own certificate")
+		return nil
+	}
+
+	fmt.Println("Hosts share one certificate per project in:")
+	for _, project := range groups.Projects {
+		fmt.Printf("  %s\n", project)
+	}
 
-		fmt.Println("Configured hosts:")
-		for hostname, hostInfo := range hostsData {
-			if hostMap, ok := hostInfo.(map[string]interface{}); ok {
-				target := hostMap["target"]
-				ssl := hostMap["ssl_enabled"]
-				healthy := hostMap["healthy"]
+	return nil
+}
 
-				fmt.Printf("  %s -> %s (SSL: %v, Healthy: %v)\n", hostname, target, ssl, healthy)
+// CertsExpiring prints the certificates expiring within a duration such as 14d via HTTP API
+func (c *HTTPClient) CertsExpiring(within string, jsonOutput bool) error {
+	expiring, _, err := c.api.GetExpiringCertificates(context.Background(), &client.GetExpiringCertificatesParams{Within: within})
+	if err != nil {
+		return fmt.Errorf("failed to list expiring certificates: %w", err)
+	}
 
-				// Show certificate status if available
-				if cert, exists := hostMap["certificate"]; exists && cert != nil {
-					if certMap, ok := cert.(map[string]interface{}); ok {
-						if status, exists := certMap["status"]; exists {
-							fmt.Printf("    Certificate: %s\n", status)
-						}
-					}
-				}
-			}
+	if jsonOutput {
+		return printJSON(expiring, "expiring certificates")
+	}
+
+	if len(expiring) == 0 {
+		fmt.Println("No certificates expiring")
+		return nil
+	}
+
+	fmt.Printf("%-35s %-22s %-10s %s\n", "HOST", "EXPIRES", "DAYS LEFT", "RENEWAL")
+	for _, certificate := range expiring {
+		renewal := "-"
+		if certificate.RenewalAttempts > 0 {
+			renewal = fmt.Sprintf("failed %d times: %s", certificate.RenewalAttempts, certificate.RenewalError)
 		}
-	} else {
-		// Fallback to raw JSON output
-		jsonData, _ := json.MarshalIndent(resp.Data, "", "  ")
-		fmt.Println(string(jsonData))
+		fmt.Printf("%-35s %-22s %-10d %s\n", certificate.Hostname, certificate.ExpiresAt.Format(time.RFC3339), certificate.DaysLeft, renewal)
 	}
 
 	return nil
 }
 
-// UpdateHealth updates host health status via HTTP API
-func (c *HTTPClient) UpdateHealth(host string, healthy bool) error {
-	req := HealthUpdateRequest{
-		Healthy: healthy,
+// CertPromote promotes hosts from staging to production certificates via
+// HTTP API and fails when a host isn't promoted
+func (c *HTTPClient) CertPromote(hosts []string, jsonOutput bool) error {
+	promotions, _, err := c.api.PromoteCertificates(context.Background(), &client.CertPromoteRequest{Hosts: hosts})
+	if err != nil {
+		return fmt.Errorf("certificate promotion failed: %w", err)
+	}
+
+	if jsonOutput {
+		if err := printJSON(promotions, "promotions"); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	symbols := map[string]string{"ok": "✓", "failed": "✗", "skipped": "-"}
+	for _, promotion := range promotions {
+		for _, step := range promotion.Steps {
+			if step.Status == "failed" {
+				failed++
+			}
+			if !jsonOutput {
+				fmt.Printf("[%s] %-30s %-10s %s\n", symbols[step.Status], promotion.Hostname, step.Name, step.Detail)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hosts failed to promote", failed, len(promotions))
 	}
+	return nil
+}
 
-	resp, err := c.makeRequest("PUT", fmt.Sprintf("/api/hosts/%s/health", host), req)
+// CertPrecheck prints the DNS changes a host needs before the CA issues its
+// certificate via HTTP API, failing when there are any
+func (c *HTTPClient) CertPrecheck(host string, jsonOutput bool) error {
+	problems, _, err := c.api.PrecheckCertificate(context.Background(), &client.PrecheckCertificateParams{Host: host})
 	if err != nil {
-		return err
+		return fmt.Errorf("certificate precheck failed: %w", err)
 	}
 
-	if resp.Success {
-		fmt.Printf("✅ %s\n", resp.Message)
+	if jsonOutput {
+		if err := printJSON(problems, "problems"); err != nil {
+			return err
+		}
+	} else if len(problems) == 0 {
+		fmt.Printf("✅ DNS of %s is ready for a certificate\n", host)
 	} else {
-		return fmt.Errorf("health update failed: %s", resp.Message)
+		for _, problem := range problems {
+			fmt.Printf("Warning: %s\n", problem)
+		}
 	}
 
+	if len(problems) > 0 {
+		return fmt.Errorf("%d DNS problems for %s", len(problems), host)
+	}
 	return nil
 }
 
-// CertRenew renews certificate via HTTP API
-func (c *HTTPClient) CertRenew(host string) error {
-	resp, err := c.makeRequest("POST", fmt.Sprintf("/api/cert/renew/%s", host), nil)
+// CertPrune lists, or with delete set deletes, certificate files no host
+// uses via HTTP API
+func (c *HTTPClient) CertPrune(deleteFiles, jsonOutput bool) error {
+	var dirs []string
+	var err error
+	if deleteFiles {
+		dirs, _, err = c.api.PruneCertificates(context.Background())
+	} else {
+		dirs, _, err = c.api.ListUnreferencedCertificates(context.Background())
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to prune certificates: %w", err)
 	}
 
-	if resp.Success {
-		fmt.Printf("✅ %s\n", resp.Message)
-	} else {
-		return fmt.Errorf("certificate renewal failed: %s", resp.Message)
+	if jsonOutput {
+		return printJSON(dirs, "unreferenced certificates")
 	}
 
+	if len(dirs) == 0 {
+		fmt.Println("No unreferenced certificates")
+		return nil
+	}
+	verb := "Unreferenced"
+	if deleteFiles {
+		verb = "Deleted"
+	}
+	for _, dir := range dirs {
+		fmt.Printf("%s: %s\n", verb, dir)
+	}
 	return nil
 }
 
 // CertStatus gets certificate status via HTTP API
 func (c *HTTPClient) CertStatus(host string) error {
-	endpoint := "/api/status"
-	if host != "" {
-		endpoint += "?host=" + url.QueryEscape(host)
-	}
-
-	resp, err := c.makeRequest("GET", endpoint, nil)
+	raw, _, err := c.api.GetCertificateStatus(context.Background(), &client.GetCertificateStatusParams{Host: host})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get certificate status: %w", err)
 	}
 
-	if !resp.Success {
-		return fmt.Errorf("failed to get certificate status: %s", resp.Message)
+	var data interface{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return fmt.Errorf("failed to unmarshal certificate status: %w", err)
+		}
 	}
 
 	// Pretty print certificate status
 	if host != "" {
 		// Single host certificate status
-		if resp.Data != nil {
+		if data != nil {
 			fmt.Printf("Certificate status for %s:\n", host)
-			jsonData, _ := json.MarshalIndent(resp.Data, "", "  ")
+			jsonData, _ := json.MarshalIndent(data, "", "  ")
 			fmt.Println(string(jsonData))
 		} else {
 			fmt.Printf("No certificate information for %s\n", host)
 		}
-	} else {
-		// All hosts certificate status
-		if certData, ok := resp.Data.(map[string]interface{}); ok {
-			if len(certData) == 0 {
-				fmt.Println("No certificate information available")
-				return nil
-			}
+		return nil
+	}
 
-			fmt.Println("Certificate status for all hosts:")
-			for hostname, certInfo := range certData {
-				fmt.Printf("\n%s:\n", hostname)
-				if certInfo != nil {
-					jsonData, _ := json.MarshalIndent(certInfo, "", "  ")
-					fmt.Println(string(jsonData))
-				} else {
-					fmt.Println("  No certificate")
-				}
-			}
-		} else {
-			// Fallback to raw JSON output
-			jsonData, _ := json.MarshalIndent(resp.Data, "", "  ")
+	// All hosts certificate status
+	certData, ok := data.(map[string]interface{})
+	if !ok {
+		// Fallback to raw JSON output
+		jsonData, _ := json.MarshalIndent(data, "", "  ")
+		fmt.Println(string(jsonData))
+		return nil
+	}
+	if len(certData) == 0 {
+		fmt.Println("No certificate information available")
+		return nil
+	}
+
+	fmt.Println("Certificate status for all hosts:")
+	for hostname, certInfo := range certData {
+		fmt.Printf("\n%s:\n", hostname)
+		if certInfo != nil {
+			jsonData, _ := json.MarshalIndent(certInfo, "", "  ")
 			fmt.Println(string(jsonData))
+		} else {
+			fmt.Println("  No certificate")
 		}
 	}
 
@@ -206,83 +410,1182 @@ func (c *HTTPClient) CertStatus(host string) error {
 
 // SetStaging sets Let's Encrypt staging mode via HTTP API
 func (c *HTTPClient) SetStaging(enabled bool) error {
-	req := StagingRequest{
-		Enabled: enabled,
+	resp, err := c.api.SetStaging(context.Background(), &client.StagingRequest{Enabled: enabled})
+	return done(resp, err, "staging mode update failed")
+}
+
+// EncryptKeys encrypts private keys stored as plain PEM via HTTP API
+func (c *HTTPClient) EncryptKeys() error {
+	resp, err := c.api.EncryptKeys(context.Background())
+	return done(resp, err, "key encryption failed")
+}
+
+// SwitchTarget switches host target via HTTP API
+func (c *HTTPClient) SwitchTarget(host, target string) error {
+	resp, err := c.api.SwitchTarget(context.Background(), host, &client.SwitchTargetRequest{Target: target})
+	return done(resp, err, "target switch failed")
+}
+
+// SetNotifications replaces the notification targets via HTTP API
+func (c *HTTPClient) SetNotifications(targets []*state.NotificationTarget) error {
+	var body []client.NotificationTarget
+	if err := convert(targets, &body); err != nil {
+		return err
 	}
+	if body == nil {
+		body = []client.NotificationTarget{}
+	}
+
+	resp, err := c.api.SetNotifications(context.Background(), body)
+	return done(resp, err, "notifications update failed")
+}
 
-	resp, err := c.makeRequest("PUT", "/api/staging", req)
+// ListNotifications lists the notification targets via HTTP API
+func (c *HTTPClient) ListNotifications() error {
+	targets, _, err := c.api.ListNotifications(context.Background())
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to list notifications: %w", err)
 	}
 
-	if resp.Success {
-		fmt.Printf("✅ %s\n", resp.Message)
+	if len(targets) == 0 {
+		fmt.Println("No notification targets configured")
+		return nil
+	}
+
+	fmt.Println("Notification targets:")
+	for _, target := range targets {
+		events := "all events"
+		if len(target.Events) > 0 {
+			events = fmt.Sprintf("%v", target.Events)
+		}
+		fmt.Printf("  %s (%s)\n", target.Type, events)
+	}
+
+	return nil
+}
+
+// SetACME switches the certificate authority via HTTP API
+func (c *HTTPClient) SetACME(req ACMERequest) error {
+	resp, err := c.api.SetACME(context.Background(), &client.ACMERequest{
+		DirectoryURL: req.DirectoryURL,
+		Email:        req.Email,
+		EABKeyID:     req.EABKeyID,
+		EABHMACKey:   req.EABHMACKey,
+	})
+	return done(resp, err, "ACME update failed")
+}
+
+// ShowACME prints the ACME configuration via HTTP API
+func (c *HTTPClient) ShowACME() error {
+	config, _, err := c.api.GetACME(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get ACME configuration: %w", err)
+	}
+
+	if config != nil {
+		fmt.Printf("Directory: %s\n", config.DirectoryURL)
+		if config.Email != "" {
+			fmt.Printf("Email:     %s\n", config.Email)
+		}
+		if config.EABKeyID != "" {
+			fmt.Printf("EAB key:   %s\n", config.EABKeyID)
+		}
+	}
+
+	return nil
+}
+
+// SetTLSPolicy sets the TLS policy for a host, or the default policy when
+// host is empty, via HTTP API. A nil policy restores the defaults.
+func (c *HTTPClient) SetTLSPolicy(host string, policy *state.TLSPolicy) error {
+	body := &client.TLSPolicy{}
+	if policy != nil {
+		if err := convert(policy, body); err != nil {
+			return err
+		}
+	}
+
+	var resp *client.Response
+	var err error
+	if host != "" {
+		resp, err = c.api.SetHostTLSPolicy(context.Background(), host, body)
 	} else {
-		return fmt.Errorf("staging mode update failed: %s", resp.Message)
+		resp, err = c.api.SetTLSPolicy(context.Background(), body)
+	}
+	return done(resp, err, "TLS policy update failed")
+}
+
+// ShowTLSPolicy prints the default TLS policy via HTTP API
+func (c *HTTPClient) ShowTLSPolicy() error {
+	policy, _, err := c.api.GetTLSPolicy(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get TLS policy: %w", err)
+	}
+
+	if policy == nil {
+		fmt.Println("Default TLS policy: TLS 1.2+, AEAD cipher suites, h2 and http/1.1")
+		return nil
 	}
 
+	jsonData, _ := json.MarshalIndent(policy, "", "  ")
+	fmt.Println(string(jsonData))
 	return nil
 }
 
-// SwitchTarget switches host target via HTTP API
-func (c *HTTPClient) SwitchTarget(host, target string) error {
-	// Note: This endpoint isn't in the PDR, but exists in the Unix socket API
-	// We'll implement it as a PATCH to /api/hosts/:host for consistency
-	req := map[string]string{
-		"target": target,
+// SetHostLimits sets the resource limits for a host via HTTP API. Nil limits remove them.
+func (c *HTTPClient) SetHostLimits(host string, limits *state.HostLimits) error {
+	body := &client.HostLimits{}
+	if limits != nil {
+		if err := convert(limits, body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.api.SetHostLimits(context.Background(), host, body)
+	return done(resp, err, "limits update failed")
+}
+
+// HostRules returns the routing rules of a host via HTTP API
+func (c *HTTPClient) HostRules(host string) ([]state.RoutingRule, error) {
+	status, _, err := c.api.GetHost(context.Background(), host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host: %w", err)
+	}
+
+	var rules []state.RoutingRule
+	if err := convert(status.Rules, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// SetHostRules replaces the routing rules of a host via HTTP API. No rules remove them.
+func (c *HTTPClient) SetHostRules(host string, rules []state.RoutingRule) error {
+	body := []client.RoutingRule{}
+	if err := convert(rules, &body); err != nil {
+		return err
+	}
+	if body == nil {
+		body = []client.RoutingRule{}
 	}
 
-	resp, err := c.makeRequest("PATCH", fmt.Sprintf("/api/hosts/%s", host), req)
+	resp, err := c.api.SetHostRules(context.Background(), host, body)
+	return done(resp, err, "routing rules update failed")
+}
+
+// ShowHostRules prints the routing rules of a host via HTTP API
+func (c *HTTPClient) ShowHostRules(host string) error {
+	rules, err := c.HostRules(host)
 	if err != nil {
 		return err
 	}
 
-	if resp.Success {
-		fmt.Printf("✅ %s\n", resp.Message)
-	} else {
-		return fmt.Errorf("target switch failed: %s", resp.Message)
+	if len(rules) == 0 {
+		fmt.Printf("No routing rules, all requests for %s go to its target\n", host)
+		return nil
+	}
+
+	fmt.Printf("Routing rules for %s (first match wins):\n", host)
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+
+		var match []string
+		switch {
+		case rule.Header != "" && rule.Value != "":
+			match = append(match, fmt.Sprintf("header %s: %s", rule.Header, rule.Value))
+		case rule.Header != "":
+			match = append(match, "header "+rule.Header)
+		case rule.Cookie != "" && rule.Value != "":
+			match = append(match, fmt.Sprintf("cookie %s=%s", rule.Cookie, rule.Value))
+		case rule.Cookie != "":
+			match = append(match, "cookie "+rule.Cookie)
+		}
+		if rule.Percent > 0 {
+			match = append(match, fmt.Sprintf("%d%% of clients", rule.Percent))
+		}
+		fmt.Printf("  %s: %s -> %s\n", name, strings.Join(match, " and "), rule.Target)
 	}
 
 	return nil
 }
 
-// makeRequest makes an HTTP request to the API server
-func (c *HTTPClient) makeRequest(method, endpoint string, payload interface{}) (*HTTPResponse, error) {
-	url := c.baseURL + endpoint
+// SetHostMirror sets the shadow target of a host via HTTP API. Nil stops mirroring.
+func (c *HTTPClient) SetHostMirror(host string, mirror *state.MirrorPolicy) error {
+	body := &client.MirrorPolicy{}
+	if mirror != nil {
+		if err := convert(mirror, body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.api.SetHostMirror(context.Background(), host, body)
+	return done(resp, err, "mirror update failed")
+}
 
-	var body io.Reader
-	if payload != nil {
-		jsonData, err := json.Marshal(payload)
+// ErrorPages returns the error page templates of a host, or the global ones
+// when host is empty, via HTTP API
+func (c *HTTPClient) ErrorPages(host string) (map[string]string, error) {
+	if host == "" {
+		pages, _, err := c.api.GetErrorPages(context.Background())
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request: %w", err)
+			return nil, fmt.Errorf("failed to get error pages: %w", err)
 		}
-		body = bytes.NewBuffer(jsonData)
+		return pages, nil
+	}
+
+	status, _, err := c.api.GetHost(context.Background(), host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host: %w", err)
+	}
+	return status.ErrorPages, nil
+}
+
+// SetErrorPages replaces the error page templates of a host, or the global
+// ones when host is empty, via HTTP API. No pages remove them.
+func (c *HTTPClient) SetErrorPages(host string, pages map[string]string) error {
+	if pages == nil {
+		pages = map[string]string{}
+	}
+
+	var resp *client.Response
+	var err error
+	if host != "" {
+		resp, err = c.api.SetHostErrorPages(context.Background(), host, pages)
+	} else {
+		resp, err = c.api.SetErrorPages(context.Background(), pages)
 	}
+	return done(resp, err, "error pages update failed")
+}
 
-	req, err := http.NewRequest(method, url, body)
+// ShowErrorPages prints the error page templates of a host, or the global
+// ones when host is empty, via HTTP API
+func (c *HTTPClient) ShowErrorPages(host string) error {
+	pages, err := c.ErrorPages(host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return err
+	}
+
+	if len(pages) == 0 {
+		if host != "" {
+			fmt.Printf("No error pages, %s uses the global ones\n", host)
+		} else {
+			fmt.Println("No global error pages, errors are answered with plain text")
+		}
+		return nil
+	}
+
+	statuses := make([]string, 0, len(pages))
+	for status := range pages {
+		statuses = append(statuses, status)
 	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Printf("%s (%d bytes)\n", status, len(pages[status]))
+	}
+	return nil
+}
+
+// SetHostProbes sets the readiness and liveness probes of a host via HTTP
+// API. Nil goes back to the regular health check.
+func (c *HTTPClient) SetHostProbes(host string, probes *state.HostProbes) error {
+	body := &client.HostProbes{}
+	if probes != nil {
+		if err := convert(probes, body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.api.SetHostProbes(context.Background(), host, body)
+	return done(resp, err, "probes update failed")
+}
 
-	if payload != nil {
-		req.Header.Set("Content-Type", "application/json")
+// SetScaleToZero enables or disables scaling a host's app to zero via HTTP API
+func (c *HTTPClient) SetScaleToZero(host string, enabled bool, idleTimeout string, coldStart *state.ColdStartPolicy) error {
+	body := &client.ScaleToZeroRequest{Enabled: enabled, IdleTimeout: idleTimeout}
+	if coldStart != nil {
+		body.ColdStart = &client.ColdStartPolicy{}
+		if err := convert(coldStart, body.ColdStart); err != nil {
+			return err
+		}
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.api.SetScaleToZero(context.Background(), host, body)
+	return done(resp, err, "scale to zero update failed")
+}
+
+// SetDefaultBackend sets the backend for unknown hosts via HTTP API. An empty target removes it.
+func (c *HTTPClient) SetDefaultBackend(target string) error {
+	resp, err := c.api.SetDefaultBackend(context.Background(), &client.DefaultBackend{Target: target})
+	return done(resp, err, "default backend update failed")
+}
+
+// ShowDefaultBackend prints the default backend via HTTP API
+func (c *HTTPClient) ShowDefaultBackend() error {
+	backend, _, err := c.api.GetDefaultBackend(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return fmt.Errorf("failed to get default backend: %w", err)
+	}
+
+	if backend != nil && backend.Target != "" {
+		fmt.Printf("Default backend: %s\n", backend.Target)
+	} else {
+		fmt.Println("No default backend, unknown hosts get a 404")
 	}
-	defer resp.Body.Close()
 
-	responseBody, err := io.ReadAll(resp.Body)
+	return nil
+}
+
+// SetOnDemandTLS sets the on-demand TLS allowlist and limits via HTTP API, an
+// empty allowlist turns it off
+func (c *HTTPClient) SetOnDemandTLS(onDemand client.OnDemandTLS) error {
+	resp, err := c.api.SetOnDemandTLS(context.Background(), &onDemand)
+	return done(resp, err, "on-demand TLS update failed")
+}
+
+// ShowOnDemandTLS prints the on-demand TLS allowlist via HTTP API
+func (c *HTTPClient) ShowOnDemandTLS() error {
+	onDemand, _, err := c.api.GetOnDemandTLS(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return fmt.Errorf("failed to get on-demand TLS: %w", err)
+	}
+
+	if onDemand == nil || len(onDemand.Allow) == 0 {
+		fmt.Println("On-demand TLS is off, only deployed hosts get certificates")
+		return nil
+	}
+
+	fmt.Println("On-demand TLS allowed for:")
+	for _, pattern := range onDemand.Allow {
+		fmt.Printf("  %s\n", pattern)
+	}
+	if onDemand.Ask != "" {
+		fmt.Printf("Approved by: %s\n", onDemand.Ask)
+	}
+	maxHosts, rateLimit := onDemand.MaxHosts, onDemand.RateLimit
+	if maxHosts == 0 {
+		maxHosts = state.DefaultOnDemandMaxHosts
+	}
+	if rateLimit == 0 {
+		rateLimit = state.DefaultOnDemandRateLimit
+	}
+	fmt.Printf("Limits: %d hosts, %d acquisitions per hour\n", maxHosts, rateLimit)
+
+	return nil
+}
+
+// AddDomain registers a customer domain for a host via HTTP API and prints how to verify it
+func (c *HTTPClient) AddDomain(domain, host string) error {
+	status, resp, err := c.api.AddDomain(context.Background(), &client.DomainRequest{Domain: domain, Host: host})
+	if err := done(resp, err, "domain registration failed"); err != nil {
+		return err
+	}
+
+	if status != nil && status.Verification != nil {
+		fmt.Printf("  TXT   %s  \"%s\"\n", status.Verification.TXTName, status.Verification.TXTValue)
+		fmt.Printf("  CNAME %s  %s\n", domain, status.Verification.CNAMETarget)
 	}
 
-	var apiResp HTTPResponse
-	if err := json.Unmarshal(responseBody, &apiResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	return nil
+}
+
+// VerifyDomain checks a customer domain's DNS now via HTTP API
+func (c *HTTPClient) VerifyDomain(domain string) error {
+	status, resp, err := c.api.VerifyDomain(context.Background(), domain)
+	if err != nil {
+		return fmt.Errorf("domain verification failed: %w", err)
 	}
 
-	return &apiResp, nil
+	if status != nil && status.Status == state.DomainVerified {
+		fmt.Printf("✅ %s\n", resp.Message)
+	} else {
+		fmt.Printf("⏳ %s\n", resp.Message)
+	}
+
+	return nil
+}
+
+// RemoveDomain unregisters a customer domain via HTTP API
+func (c *HTTPClient) RemoveDomain(domain string) error {
+	resp, err := c.api.RemoveDomain(context.Background(), domain)
+	return done(resp, err, "domain removal failed")
+}
+
+// ListDomains lists the customer domains via HTTP API
+func (c *HTTPClient) ListDomains() error {
+	domains, _, err := c.api.ListDomains(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list domains: %w", err)
+	}
+
+	if len(domains) == 0 {
+		fmt.Println("No custom domains")
+		return nil
+	}
+
+	fmt.Printf("%-35s %-30s %-10s %s\n", "DOMAIN", "HOST", "STATUS", "LAST ERROR")
+	for _, domain := range domains {
+		fmt.Printf("%-35s %-30s %-10s %s\n", domain.Domain, domain.Host, domain.Status, domain.LastError)
+	}
+
+	return nil
+}
+
+// AddPortForward adds or replaces a forwarding rule via HTTP API
+func (c *HTTPClient) AddPortForward(rule *state.PortForward) error {
+	var body client.PortForward
+	if err := convert(rule, &body); err != nil {
+		return err
+	}
+
+	resp, err := c.api.SetPortForward(context.Background(), &body)
+	return done(resp, err, "port forward failed")
+}
+
+// RemovePortForward removes a forwarding rule via HTTP API
+func (c *HTTPClient) RemovePortForward(listen int, protocol string) error {
+	resp, err := c.api.RemovePortForward(context.Background(), &client.RemovePortForwardParams{Listen: listen, Protocol: protocol})
+	return done(resp, err, "port forward removal failed")
+}
+
+// AddUser adds an API user, limited to projects unless that is empty, via
+// HTTP API and prints their token
+func (c *HTTPClient) AddUser(name, role string, projects []string) error {
+	user, resp, err := c.api.AddUser(context.Background(), &client.UserRequest{Name: name, Role: role, Projects: projects})
+	if err != nil {
+		return fmt.Errorf("failed to add user: %w", err)
+	}
+
+	fmt.Printf("✅ %s\n", resp.Message)
+	if user != nil {
+		fmt.Printf("Token (shown once): %s\n", user.Token)
+	}
+	return nil
+}
+
+// RemoveUser removes an API user via HTTP API
+func (c *HTTPClient) RemoveUser(name string) error {
+	resp, err := c.api.RemoveUser(context.Background(), name)
+	return done(resp, err, "failed to remove user")
+}
+
+// ListUsers lists the API users via HTTP API
+func (c *HTTPClient) ListUsers() error {
+	users, _, err := c.api.ListUsers(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	if len(users) == 0 {
+		fmt.Println("No users, the API doesn't require tokens")
+		return nil
+	}
+
+	fmt.Printf("%-20s %-12s %-30s %s\n", "NAME", "ROLE", "PROJECTS", "CREATED")
+	for _, user := range users {
+		projects := "*"
+		if len(user.Projects) > 0 {
+			projects = strings.Join(user.Projects, ",")
+		}
+		fmt.Printf("%-20s %-12s %-30s %v\n", user.Name, user.Role, projects, user.CreatedAt.Format(time.RFC3339Nano))
+	}
+
+	return nil
+}
+
+// ListPortForwards lists the forwarding rules via HTTP API
+func (c *HTTPClient) ListPortForwards() error {
+	rules, _, err := c.api.ListPortForwards(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list port forwards: %w", err)
+	}
+
+	if len(rules) == 0 {
+		fmt.Println("No ports forwarded")
+		return nil
+	}
+
+	fmt.Printf("%-12s %-30s %-20s %s\n", "PORT", "TARGET", "PROJECT", "ALLOW")
+	for _, rule := range rules {
+		allow := "all"
+		if len(rule.Allow) > 0 {
+			allow = strings.Join(rule.Allow, ",")
+		}
+		fmt.Printf("%-12s %-30s %-20s %s\n", fmt.Sprintf("%d/%s", rule.Listen, rule.Protocol), rule.Target, rule.Project, allow)
+	}
+
+	return nil
+}
+
+// ConnectNetwork attaches the proxy container to a network via HTTP API
+func (c *HTTPClient) ConnectNetwork(network string) error {
+	resp, err := c.api.ConnectNetwork(context.Background(), network)
+	return done(resp, err, "network connect failed")
+}
+
+// DisconnectNetwork detaches the proxy container from a network via HTTP API
+func (c *HTTPClient) DisconnectNetwork(network string) error {
+	resp, err := c.api.DisconnectNetwork(context.Background(), network)
+	return done(resp, err, "network disconnect failed")
+}
+
+// ListNetworks lists the proxy container's networks via HTTP API
+func (c *HTTPClient) ListNetworks() error {
+	attachments, _, err := c.api.ListNetworks(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	if len(attachments) == 0 {
+		fmt.Println("No networks attached")
+		return nil
+	}
+
+	fmt.Printf("%-30s %-10s %-10s %s\n", "NETWORK", "CONNECTED", "ATTACHED", "PROJECTS")
+	for _, attachment := range attachments {
+		attached := "-"
+		switch {
+		case attachment.Pinned:
+			attached = "by hand"
+		case attachment.Managed:
+			attached = "by iop"
+		}
+		fmt.Printf("%-30s %-10t %-10s %s\n", attachment.Network, attachment.Connected, attached, strings.Join(attachment.Projects, ","))
+	}
+
+	return nil
+}
+
+// SetAutoscalePolicy adds or replaces an app's autoscaling policy via HTTP API
+func (c *HTTPClient) SetAutoscalePolicy(policy *state.AutoscalePolicy) error {
+	var body client.AutoscalePolicy
+	if err := convert(policy, &body); err != nil {
+		return err
+	}
+
+	resp, err := c.api.SetAutoscalePolicy(context.Background(), &body)
+	return done(resp, err, "autoscale update failed")
+}
+
+// RemoveAutoscalePolicy stops autoscaling an app via HTTP API
+func (c *HTTPClient) RemoveAutoscalePolicy(project, app string) error {
+	resp, err := c.api.RemoveAutoscalePolicy(context.Background(), &client.RemoveAutoscalePolicyParams{Project: project, App: app})
+	return done(resp, err, "autoscale removal failed")
+}
+
+// ListAutoscale prints the autoscaling policies and each app's measured load via HTTP API, optionally as JSON
+func (c *HTTPClient) ListAutoscale(jsonOutput bool) error {
+	entries, _, err := c.api.ListAutoscale(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list autoscaling policies: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(entries, "autoscaling policies")
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No apps autoscaled")
+		return nil
+	}
+
+	fmt.Printf("%-30s %-10s %-10s %-30s %s\n", "APP", "REPLICAS", "BOUNDS", "TARGETS", "LAST SCALED")
+	for _, e := range entries {
+		var targets []string
+		if e.TargetCPU > 0 {
+			targets = append(targets, fmt.Sprintf("cpu=%g%%", e.TargetCPU))
+		}
+		if e.TargetRequestRate > 0 {
+			targets = append(targets, fmt.Sprintf("rate=%g/s", e.TargetRequestRate))
+		}
+		if e.TargetLatencyMs > 0 {
+			targets = append(targets, fmt.Sprintf("latency=%dms", e.TargetLatencyMs))
+		}
+		if e.TargetConcurrency > 0 {
+			targets = append(targets, fmt.Sprintf("concurrency=%g", e.TargetConcurrency))
+		}
+
+		replicas, lastScaled := "-", "never"
+		if e.Status != nil {
+			replicas = strconv.Itoa(e.Status.Replicas)
+			if e.Status.LastReason != "" {
+				lastScaled = fmt.Sprintf("%s (%s)", e.Status.LastScaled.Format(time.RFC3339Nano), e.Status.LastReason)
+			}
+		}
+
+		fmt.Printf("%-30s %-10s %-10s %-30s %s\n",
+			e.Project+"/"+e.App,
+			replicas,
+			fmt.Sprintf("%d-%d", e.MinReplicas, e.MaxReplicas),
+			strings.Join(targets, " "),
+			lastScaled)
+	}
+
+	return nil
+}
+
+// SetServiceRecords replaces the service records of a project via HTTP API
+func (c *HTTPClient) SetServiceRecords(project string, records []state.ServiceRecord) error {
+	var body []client.ServiceRecord
+	if err := convert(records, &body); err != nil {
+		return err
+	}
+	if body == nil {
+		body = []client.ServiceRecord{}
+	}
+
+	resp, err := c.api.SetServiceRecords(context.Background(), &client.DiscoveryRequest{Project: project, Records: body})
+	return done(resp, err, "discovery update failed")
+}
+
+// ListServiceRecords prints the names the DNS server resolves via HTTP API, optionally as JSON
+func (c *HTTPClient) ListServiceRecords(jsonOutput bool) error {
+	records, _, err := c.api.ListServiceRecords(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list service records: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(records, "service records")
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No service records")
+		return nil
+	}
+
+	fmt.Printf("%-40s %s\n", "NAME", "RESOLVES TO")
+	for _, record := range records {
+		target := record.Address
+		if record.Container != "" {
+			target = "container " + record.Container
+		}
+		fmt.Printf("%-40s %s\n", record.Name+"."+record.Project+".internal", target)
+	}
+
+	return nil
+}
+
+// SetQuota adds or replaces a project's quota via HTTP API
+func (c *HTTPClient) SetQuota(q *state.Quota) error {
+	var body client.Quota
+	if err := convert(q, &body); err != nil {
+		return err
+	}
+
+	resp, err := c.api.SetQuota(context.Background(), &body)
+	return done(resp, err, "quota update failed")
+}
+
+// RemoveQuota lifts a project's quota via HTTP API
+func (c *HTTPClient) RemoveQuota(project string) error {
+	resp, err := c.api.RemoveQuota(context.Background(), &client.RemoveQuotaParams{Project: project})
+	return done(resp, err, "quota removal failed")
+}
+
+// ListQuotas prints project quotas and what each project runs via HTTP API,
+// optionally as JSON. With a project, it is listed even without a quota.
+func (c *HTTPClient) ListQuotas(project string, jsonOutput bool) error {
+	entries, _, err := c.api.ListQuotas(context.Background(), &client.ListQuotasParams{Project: project})
+	if err != nil {
+		return fmt.Errorf("failed to list quotas: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(entries, "quotas")
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No quotas")
+		return nil
+	}
+
+	fmt.Printf("%-30s %-15s %-20s %-15s %s\n", "PROJECT", "CONTAINERS", "MEMORY", "CPUS", "UNLIMITED")
+	for _, e := range entries {
+		var usage client.QuotaUsage
+		if e.Usage != nil {
+			usage = *e.Usage
+		}
+		var q client.Quota
+		if e.Quota != nil {
+			q = *e.Quota
+		}
+
+		containers, memory, cpus := strconv.Itoa(usage.Containers), quota.FormatBytes(usage.Memory), fmt.Sprintf("%g", usage.Cpus)
+		if q.MaxContainers > 0 {
+			containers += "/" + strconv.Itoa(q.MaxContainers)
+		}
+		if q.MaxMemory > 0 {
+			memory += "/" + quota.FormatBytes(q.MaxMemory)
+		}
+		if q.MaxCpus > 0 {
+			cpus += fmt.Sprintf("/%g", q.MaxCpus)
+		}
+		fmt.Printf("%-30s %-15s %-20s %-15s %d\n", e.Project, containers, memory, cpus, usage.Unlimited)
+	}
+
+	return nil
+}
+
+// CheckQuota prints the usage a project would have after deploying the
+// workloads via HTTP API, failing if that doesn't fit its quota
+func (c *HTTPClient) CheckQuota(project string, workloads []quota.Workload) error {
+	var body []client.QuotaWorkload
+	if err := convert(workloads, &body); err != nil {
+		return err
+	}
+	if body == nil {
+		body = []client.QuotaWorkload{}
+	}
+
+	usage, _, err := c.api.CheckQuota(context.Background(), &client.QuotaCheckRequest{Project: project, Workloads: body})
+	if err != nil {
+		return fmt.Errorf("quota check failed: %w", err)
+	}
+	return printJSON(usage, "quota usage")
+}
+
+// Audit prints audit log entries via HTTP API, optionally as JSON
+func (c *HTTPClient) Audit(params url.Values, jsonOutput bool) error {
+	query := &client.ListAuditEntriesParams{
+		Host:   params.Get("host"),
+		Action: params.Get("action"),
+		Since:  params.Get("since"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return fmt.Errorf("invalid limit %q", limit)
+		}
+		query.Limit = n
+	}
+
+	entries, _, err := c.api.ListAuditEntries(context.Background(), query)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(entries, "audit log")
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No audit entries")
+		return nil
+	}
+
+	fmt.Printf("%-20s %-16s %-30s %-24s %-15s %s\n", "TIME", "ACTION", "HOST", "ACTOR", "SOURCE", "DETAILS")
+	for _, entry := range entries {
+		host := entry.Host
+		if host == "" {
+			host = "-"
+		}
+		fmt.Printf("%-20s %-16s %-30s %-24s %-15s %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Action, host, entry.Actor, entry.SourceIP, entry.Details)
+	}
+
+	return nil
+}
+
+// Deployments prints a host's recent deployments, most recent first
+func (c *HTTPClient) Deployments(host string, jsonOutput bool) error {
+	deployments, _, err := c.api.ListDeployments(context.Background(), host)
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(deployments, "deployments")
+	}
+
+	if len(deployments) == 0 {
+		fmt.Printf("No deployments recorded for %s\n", host)
+		return nil
+	}
+
+	fmt.Printf("%-20s %-30s %s\n", "TIME", "TARGET", "METADATA")
+	for _, deployment := range deployments {
+		fmt.Printf("%-20s %-30s %s\n", deployment.Time.Local().Format("2006-01-02 15:04:05"), deployment.Target, state.FormatMetadata(deployment.Metadata))
+	}
+
+	return nil
+}
+
+// StartDeployment starts the timeline of a deployment and prints its ID
+func (c *HTTPClient) StartDeployment(project, app, host string, metadata map[string]string) error {
+	deployment, resp, err := c.api.StartDeployment(context.Background(), &client.DeploymentStartRequest{
+		Project:  project,
+		App:      app,
+		Host:     host,
+		Metadata: metadata,
+	})
+	if err := done(resp, err, "failed to start deployment"); err != nil {
+		return err
+	}
+	fmt.Println(deployment.ID)
+	return nil
+}
+
+// UpdateDeploymentStep reports progress of a step of a deployment
+func (c *HTTPClient) UpdateDeploymentStep(id string, update *client.DeploymentStepUpdate) error {
+	_, resp, err := c.api.UpdateDeploymentStep(context.Background(), id, update)
+	return done(resp, err, "failed to update deployment")
+}
+
+// Deployment prints a deployment's step progress, failing when the
+// deployment failed so scripts stop at the step that broke
+func (c *HTTPClient) Deployment(id string, jsonOutput bool) error {
+	deployment, _, err := c.api.GetDeployment(context.Background(), id)
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %w", err)
+	}
+
+	if jsonOutput {
+		if err := printJSON(deployment, "deployment"); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Deployment %s of %s/%s: %s\n", deployment.ID, deployment.Project, deployment.App, deployment.Status)
+		fmt.Printf("%-14s %-10s %-10s %s\n", "STEP", "STATUS", "DURATION", "DETAILS")
+		for _, step := range deployment.Steps {
+			duration := ""
+			if !step.StartedAt.IsZero() && !step.FinishedAt.IsZero() {
+				duration = step.FinishedAt.Sub(step.StartedAt).Round(time.Millisecond).String()
+			}
+			details := step.Message
+			if step.Error != "" {
+				details = step.Error
+			}
+			if len(step.Attempts) > 0 {
+				details = strings.TrimSpace(fmt.Sprintf("%s (%d attempts)", details, len(step.Attempts)))
+			}
+			fmt.Printf("%-14s %-10s %-10s %s\n", step.Name, step.Status, duration, details)
+		}
+	}
+
+	if deployment.Status == string(timeline.StatusFailed) {
+		return fmt.Errorf("deployment %s failed at step %s", deployment.ID, deployment.FailedStep)
+	}
+	return nil
+}
+
+// Export writes the full state and its certificates to w as an archive for Import
+func (c *HTTPClient) Export(w io.Writer) error {
+	archive, _, err := c.api.ExportState(context.Background())
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal archive: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// Import replaces the full state and its certificates with an archive from Export
+func (c *HTTPClient) Import(archive json.RawMessage) error {
+	var body client.Archive
+	if err := json.Unmarshal(archive, &body); err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+
+	resp, err := c.api.ImportState(context.Background(), &body)
+	return done(resp, err, "import failed")
+}
+
+// Stats prints the resource usage of app containers via HTTP API
+func (c *HTTPClient) Stats(jsonOutput bool) error {
+	samples, _, err := c.api.ListStats(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read container stats: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(samples, "container stats")
+	}
+
+	if len(samples) == 0 {
+		fmt.Println("No app containers running")
+		return nil
+	}
+
+	fmt.Printf("%-40s %8s %22s %8s %20s %20s\n", "CONTAINER", "CPU", "MEMORY", "PIDS", "NET RX/TX", "DISK R/W")
+	for _, s := range samples {
+		fmt.Printf("%-40s %7.1f%% %22s %8d %20s %20s\n",
+			s.Container,
+			s.CPUPercent,
+			formatBytes(float64(s.MemoryBytes))+" / "+formatBytes(float64(s.MemoryLimitBytes)),
+			s.PIDs,
+			formatBytes(float64(s.NetworkRxBytes))+" / "+formatBytes(float64(s.NetworkTxBytes)),
+			formatBytes(float64(s.DiskReadBytes))+" / "+formatBytes(float64(s.DiskWriteBytes)),
+		)
+	}
+
+	return nil
+}
+
+// AppStats prints each app's requests since the proxy started and the usage
+// of its containers via HTTP API
+func (c *HTTPClient) AppStats(jsonOutput bool) error {
+	apps, _, err := c.api.ListAppStats(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read app stats: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(apps, "app stats")
+	}
+
+	if len(apps) == 0 {
+		fmt.Println("No apps")
+		return nil
+	}
+
+	fmt.Printf("%-30s %10s %8s %22s %10s %8s %8s %8s\n", "APP", "CONTAINERS", "CPU", "MEMORY", "REQUESTS", "ERRORS", "AVG", "P95")
+	for _, app := range apps {
+		var requests router.RequestStats
+		for i, count := range app.LatencyBuckets {
+			if i < len(requests.LatencyBuckets) {
+				requests.LatencyBuckets[i] = uint64(count)
+			}
+		}
+		avg, p95 := "-", "-"
+		if app.Requests > 0 {
+			avg = (time.Duration(app.Latency) / time.Duration(app.Requests)).Round(time.Millisecond).String()
+			p95 = requests.Percentile(0.95).String()
+		}
+		fmt.Printf("%-30s %10d %7.1f%% %22s %10d %8d %8s %8s\n",
+			app.Project+"/"+app.App,
+			app.Containers,
+			app.CPUPercent,
+			formatBytes(float64(app.MemoryBytes))+" / "+formatBytes(float64(app.MemoryLimitBytes)),
+			app.Requests,
+			app.Errors,
+			avg,
+			p95,
+		)
+	}
+
+	return nil
+}
+
+// Uptime prints the uptime of each checked host via HTTP API, optionally of
+// one project
+func (c *HTTPClient) Uptime(project string, jsonOutput bool) error {
+	reports, _, err := c.api.ListUptime(context.Background(), &client.ListUptimeParams{Project: project})
+	if err != nil {
+		return fmt.Errorf("failed to read uptime: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(reports, "uptime")
+	}
+
+	if len(reports) == 0 {
+		fmt.Println("No hosts checked yet")
+		return nil
+	}
+
+	fmt.Printf("%-40s %-25s %-20s %8s %8s %8s %8s\n", "HOST", "APP", "STATUS", "24H", "7D", "30D", "LATENCY")
+	for _, r := range reports {
+		fmt.Printf("%-40s %-25s %-20s %7.2f%% %7.2f%% %7.2f%% %6dms\n",
+			r.Host, r.Project+"/"+r.App, uptimeStatus(r), r.Uptime24h, r.Uptime7d, r.Uptime30d, r.AvgLatencyMs)
+	}
+
+	return nil
+}
+
+// HostUptime prints a host's uptime with its daily totals and recent checks
+// via HTTP API
+func (c *HTTPClient) HostUptime(host string, jsonOutput bool) error {
+	report, _, err := c.api.GetHostUptime(context.Background(), host)
+	if err != nil {
+		return fmt.Errorf("failed to read uptime of %s: %w", host, err)
+	}
+
+	if jsonOutput {
+		return printJSON(report, "uptime")
+	}
+
+	fmt.Printf("Host:    %s (%s/%s)\n", report.Host, report.Project, report.App)
+	fmt.Printf("Status:  %s\n", uptimeStatus(*report))
+	fmt.Printf("Uptime:  %.2f%% 24h, %.2f%% 7d, %.2f%% 30d, %.2f%% 90d\n", report.Uptime24h, report.Uptime7d, report.Uptime30d, report.Uptime90d)
+	fmt.Printf("Latency: %dms average over 24h\n", report.AvgLatencyMs)
+
+	fmt.Printf("\n%-12s %8s %8s\n", "DAY", "CHECKS", "UPTIME")
+	for _, day := range report.Daily {
+		fmt.Printf("%-12s %8d %7.2f%%\n", day.Start.Format("2006-01-02"), day.Checks, float64(day.Up)*100/float64(day.Checks))
+	}
+
+	fmt.Printf("\n%-20s %-6s %6s %8s %6s %s\n", "TIME", "UP", "STATUS", "LATENCY", "TLS", "ERROR")
+	for _, check := range report.Recent {
+		fmt.Printf("%-20s %-6t %6d %6dms %4dms %s\n",
+			check.Time.Local().Format("2006-01-02 15:04:05"), check.Up, check.StatusCode, check.LatencyMs, check.TLSMs, check.Error)
+	}
+
+	return nil
+}
+
+// uptimeStatus describes whether a host is up, or since when it is down
+func uptimeStatus(r client.UptimeReport) string {
+	if r.Up {
+		return "up"
+	}
+	if !r.DownSince.IsZero() {
+		return fmt.Sprintf("down for %s", time.Since(r.DownSince).Round(time.Minute))
+	}
+	return "down"
+}
+
+// SetStatusPage adds or replaces a project's status page via HTTP API
+func (c *HTTPClient) SetStatusPage(page *state.StatusPage) error {
+	var body client.StatusPage
+	if err := convert(page, &body); err != nil {
+		return err
+	}
+
+	resp, err := c.api.SetStatusPage(context.Background(), &body)
+	return done(resp, err, "status page update failed")
+}
+
+// RemoveStatusPage removes a project's status page via HTTP API
+func (c *HTTPClient) RemoveStatusPage(project string) error {
+	resp, err := c.api.RemoveStatusPage(context.Background(), &client.RemoveStatusPageParams{Project: project})
+	return done(resp, err, "status page removal failed")
+}
+
+// ListStatusPages prints the status pages via HTTP API, optionally as JSON
+func (c *HTTPClient) ListStatusPages(project string, jsonOutput bool) error {
+	pages, _, err := c.api.ListStatusPages(context.Background(), &client.ListStatusPagesParams{Project: project})
+	if err != nil {
+		return fmt.Errorf("failed to list status pages: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(pages, "status pages")
+	}
+
+	if len(pages) == 0 {
+		fmt.Println("No status pages")
+		return nil
+	}
+
+	fmt.Printf("%-20s %-40s %-10s %s\n", "PROJECT", "HOST", "INCIDENTS", "APPS")
+	for _, page := range pages {
+		apps := strings.Join(page.Apps, ",")
+		if apps == "" {
+			apps = "all"
+		}
+		open := 0
+		for _, incident := range page.Incidents {
+			if incident.ResolvedAt.IsZero() {
+				open++
+			}
+		}
+		fmt.Printf("%-20s %-40s %-10s %s\n", page.Project, page.Host, fmt.Sprintf("%d open", open), apps)
+	}
+
+	return nil
+}
+
+// ListIncidents prints the incidents of the status pages via HTTP API,
+// optionally as JSON
+func (c *HTTPClient) ListIncidents(project string, jsonOutput bool) error {
+	incidents, _, err := c.api.ListIncidents(context.Background(), &client.ListIncidentsParams{Project: project})
+	if err != nil {
+		return fmt.Errorf("failed to list incidents: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(incidents, "incidents")
+	}
+
+	if len(incidents) == 0 {
+		fmt.Println("No incidents")
+		return nil
+	}
+
+	fmt.Printf("%-14s %-20s %-15s %-20s %s\n", "ID", "PROJECT", "STATUS", "OPENED", "TITLE")
+	for _, incident := range incidents {
+		fmt.Printf("%-14s %-20s %-15s %-20s %s\n",
+			incident.ID, incident.Project, incident.Status, incident.CreatedAt.Local().Format("2006-01-02 15:04"), incident.Title)
+	}
+
+	return nil
+}
+
+// OpenIncident opens an incident on a project's status page via HTTP API
+func (c *HTTPClient) OpenIncident(req *client.OpenIncidentRequest, jsonOutput bool) error {
+	incident, resp, err := c.api.OpenIncident(context.Background(), req)
+	if err != nil {
+		return fmt.Errorf("failed to open incident: %w", err)
+	}
+
+	if jsonOutput {
+		return printJSON(incident, "incident")
+	}
+	fmt.Printf("✅ %s\n", resp.Message)
+	return nil
+}
+
+// UpdateIncident posts an update on an incident via HTTP API
+func (c *HTTPClient) UpdateIncident(id, status, message string, jsonOutput bool) error {
+	incident, resp, err := c.api.UpdateIncident(context.Background(), id, &client.IncidentUpdateRequest{Status: status, Message: message})
+	if err != nil {
+		return fmt.Errorf("failed to update incident %s: %w", id, err)
+	}
+
+	if jsonOutput {
+		return printJSON(incident, "incident")
+	}
+	fmt.Printf("✅ %s\n", resp.Message)
+	return nil
+}
+
+// RemoveIncident removes an incident via HTTP API
+func (c *HTTPClient) RemoveIncident(id string) error {
+	resp, err := c.api.RemoveIncident(context.Background(), id)
+	return done(resp, err, "incident removal failed")
+}
+
+// Doctor runs the proxy's self-diagnostics via HTTP API and fails when a
+// check fails
+func (c *HTTPClient) Doctor(jsonOutput bool) error {
+	report, _, err := c.api.GetDiagnostics(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to run diagnostics: %w", err)
+	}
+
+	if jsonOutput {
+		if err := printJSON(report, "diagnostics"); err != nil {
+			return err
+		}
+	} else {
+		symbols := map[string]string{"ok": "✓", "warn": "!", "fail": "✗", "skipped": "-"}
+		for _, check := range report.Checks {
+			fmt.Printf("[%s] %-7s %s\n", symbols[check.Status], check.Name, check.Detail)
+		}
+	}
+
+	if report.Status == "fail" {
+		return fmt.Errorf("diagnostics failed")
+	}
+	return nil
+}
+
+// formatBytes formats a byte count with binary units, e.g. 512MiB
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f%s", bytes, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", bytes, units[unit])
 }
@@ -1,16 +1,41 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/elitan/iop/proxy/internal/audit"
+	"github.com/elitan/iop/proxy/internal/autoscale"
+	"github.com/elitan/iop/proxy/internal/backup"
 	"github.com/elitan/iop/proxy/internal/cert"
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/deployment"
+	"github.com/elitan/iop/proxy/internal/diagnostics"
+	"github.com/elitan/iop/proxy/internal/discovery"
+	"github.com/elitan/iop/proxy/internal/domains"
 	"github.com/elitan/iop/proxy/internal/health"
+	"github.com/elitan/iop/proxy/internal/networks"
+	"github.com/elitan/iop/proxy/internal/ports"
+	"github.com/elitan/iop/proxy/internal/quota"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/sentry"
 	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/stats"
+	"github.com/elitan/iop/proxy/internal/timeline"
+	"github.com/elitan/iop/proxy/internal/uptime"
+	"github.com/elitan/iop/proxy/pkg/client"
 )
 
 // HTTPServer provides HTTP API for CLI commands
@@ -19,15 +44,34 @@ type HTTPServer struct {
 	certManager     *cert.Manager
 	healthChecker   *health.Checker
 	server          *http.Server
+	socketServer    *http.Server
+	socketPath      string
 	httpServerReady <-chan struct{}
+	events          core.EventBus
+	ports           *ports.Manager
+	audit           *audit.Log
+	domains         *domains.Manager
+	networks        *networks.Manager
+	stats           *stats.Collector
+	requests        autoscale.RequestMetrics
+	autoscaler      *autoscale.Autoscaler
+	quotas          *quota.Checker
+	uptime          *uptime.Monitor
+	timelines       *timeline.Tracker
+	diagnostics     *diagnostics.Diagnostics
+	baker           *deployment.Baker
 }
 
+// ActorHeader carries who is making a request, for the audit log
+const ActorHeader = client.ActorHeader
+
 // NewHTTPServer creates a new HTTP API server
 func NewHTTPServer(st *state.State, cm *cert.Manager, hc *health.Checker) *HTTPServer {
 	return &HTTPServer{
 		state:         st,
 		certManager:   cm,
 		healthChecker: hc,
+		timelines:     timeline.NewTracker(),
 	}
 }
 
@@ -38,17 +82,233 @@ func NewHTTPServerWithReadiness(st *state.State, cm *cert.Manager, hc *health.Ch
 		certManager:     cm,
 		healthChecker:   hc,
 		httpServerReady: httpServerReady,
+		timelines:       timeline.NewTracker(),
+	}
+}
+
+// SetEventBus makes the server publish deployment events
+func (s *HTTPServer) SetEventBus(events core.EventBus) {
+	s.events = events
+}
+
+// SetPortManager makes the server apply port forwarding changes immediately
+func (s *HTTPServer) SetPortManager(m *ports.Manager) {
+	s.ports = m
+}
+
+// SetDomainManager enables the custom domains API
+func (s *HTTPServer) SetDomainManager(m *domains.Manager) {
+	s.domains = m
+}
+
+// SetNetworkManager enables the networks API
+func (s *HTTPServer) SetNetworkManager(m *networks.Manager) {
+	s.networks = m
+}
+
+// SetStatsCollector enables the container stats API and Prometheus metrics
+func (s *HTTPServer) SetStatsCollector(c *stats.Collector) {
+	s.stats = c
+}
+
+// SetRequestMetrics adds the requests each app's hosts served to the app stats API
+func (s *HTTPServer) SetRequestMetrics(m autoscale.RequestMetrics) {
+	s.requests = m
+}
+
+// SetDiagnostics enables the self-diagnostics API
+func (s *HTTPServer) SetDiagnostics(d *diagnostics.Diagnostics) {
+	s.diagnostics = d
+}
+
+// SetSocketPath serves the API on a unix socket at path too, readable and
+// writable by the proxy's user only
+func (s *HTTPServer) SetSocketPath(path string) {
+	s.socketPath = path
+}
+
+// SetAutoscaler adds each app's measured load to the autoscaling API
+func (s *HTTPServer) SetAutoscaler(a *autoscale.Autoscaler) {
+	s.autoscaler = a
+}
+
+// SetBaker enables baking deploys, rolling back releases that regress
+func (s *HTTPServer) SetBaker(b *deployment.Baker) {
+	s.baker = b
+}
+
+// SetQuotaChecker adds usage to the quotas API and enables checking deploys
+func (s *HTTPServer) SetQuotaChecker(c *quota.Checker) {
+	s.quotas = c
+}
+
+// SetUptimeMonitor enables the uptime API
+func (s *HTTPServer) SetUptimeMonitor(m *uptime.Monitor) {
+	s.uptime = m
+}
+
+// SetAuditLog makes the server record state-changing requests
+func (s *HTTPServer) SetAuditLog(l *audit.Log) {
+	s.audit = l
+}
+
+// record adds a successful state-changing request to the audit log
+func (s *HTTPServer) record(r *http.Request, action, host, details string) {
+	if s.audit == nil {
+		return
+	}
+
+	// The token's user is who made the change, the header says on whose behalf
+	actor := r.Header.Get(ActorHeader)
+	if user, ok := r.Context().Value(userKey).(*state.User); ok {
+		if actor == "" {
+			actor = user.Name
+		} else {
+			actor = fmt.Sprintf("%s via %s", actor, user.Name)
+		}
+	}
+	if actor == "" {
+		actor = "unknown"
+	}
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+
+	if err := s.audit.Record(audit.Entry{
+		Action:   action,
+		Host:     host,
+		Details:  details,
+		Actor:    actor,
+		SourceIP: sourceIP,
+	}); err != nil {
+		log.Printf("[HTTP-API] Failed to write audit log: %v", err)
+	}
+}
+
+// publish sends an event if an event bus is configured
+func (s *HTTPServer) publish(event core.Event) {
+	if s.events != nil {
+		s.events.Publish(event)
 	}
 }
 
 // HTTP request/response structures
+
+// HostStatus is a host as listed by the API, including its runtime health
+type HostStatus struct {
+	*state.Host
+	Project         string    `json:"project"`
+	ETag            string    `json:"etag"` // For If-Match on PUT and DELETE /api/hosts/:host
+	Healthy         bool      `json:"healthy"`
+	LastHealthCheck time.Time `json:"last_health_check"`
+	CrashLooping    bool      `json:"crash_looping,omitempty"`
+	Sleeping        bool      `json:"sleeping,omitempty"`
+}
 type HTTPDeployRequest struct {
-	Host       string `json:"host"`
-	Target     string `json:"target"`
-	Project    string `json:"project"`
-	App        string `json:"app"`
-	HealthPath string `json:"health_path"`
-	SSL        bool   `json:"ssl"`
+	Host       string            `json:"host"`
+	Target     string            `json:"target"`
+	Project    string            `json:"project"`
+	App        string            `json:"app"`
+	HealthPath string            `json:"health_path"`
+	SSL        bool              `json:"ssl"`
+	Mode       string            `json:"mode,omitempty"` // "http" (default) or "passthrough"
+	Streaming  bool              `json:"streaming,omitempty"`
+	Internal   bool              `json:"internal,omitempty"` // Only served to clients on private networks
+	Metadata   map[string]string `json:"metadata,omitempty"` // Recorded in the host's deployment history, e.g. sha and actor
+	// DeploymentID is the timeline whose switch step this deploy is
+	DeploymentID string `json:"deployment_id,omitempty"`
+	// Bake keeps the containers the deploy replaced to roll back to
+	Bake *HTTPBakeRequest `json:"bake,omitempty"`
+	state.HostTimeouts
+}
+
+// HTTPBakeRequest watches a deploy's requests for a while after the traffic
+// switch. The replaced containers keep running outside the app's network
+// aliases and get them back when the error rate or latency exceed the limits.
+type HTTPBakeRequest struct {
+	Duration      string   `json:"duration"`
+	Interval      string   `json:"interval,omitempty"`       // 10s by default
+	MaxErrorRate  float64  `json:"max_error_rate,omitempty"` // e.g. 0.05, no limit if 0
+	MaxLatency    string   `json:"max_latency,omitempty"`    // Average, no limit if empty
+	MinRequests   uint64   `json:"min_requests,omitempty"`
+	Network       string   `json:"network"`
+	Aliases       []string `json:"aliases"`
+	Color         string   `json:"color,omitempty"`
+	Containers    []string `json:"containers"`
+	PreviousColor string   `json:"previous_color,omitempty"`
+	Previous      []string `json:"previous"`
+}
+
+// release validates a bake request and returns the release it watches
+func (b *HTTPBakeRequest) release(req *HTTPDeployRequest) (deployment.Release, error) {
+	target, _, err := net.SplitHostPort(req.Target)
+	if err != nil {
+		return deployment.Release{}, fmt.Errorf("Invalid target %s: %v", req.Target, err)
+	}
+	if b.Network == "" || len(b.Aliases) == 0 || len(b.Containers) == 0 || len(b.Previous) == 0 {
+		return deployment.Release{}, fmt.Errorf("Missing required bake fields: network, aliases, containers, previous")
+	}
+
+	policy := core.BakePolicy{MaxErrorRate: b.MaxErrorRate, MinRequests: b.MinRequests}
+	for _, d := range []struct {
+		name     string
+		value    string
+		into     *time.Duration
+		required bool
+	}{
+		{"duration", b.Duration, &policy.Duration, true},
+		{"interval", b.Interval, &policy.Interval, false},
+		{"max_latency", b.MaxLatency, &policy.MaxLatency, false},
+	} {
+		if d.value == "" && !d.required {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return deployment.Release{}, fmt.Errorf("Invalid bake %s %q, expected a duration such as 5m", d.name, d.value)
+		}
+		*d.into = parsed
+	}
+	if b.MaxErrorRate < 0 || b.MaxErrorRate > 1 {
+		return deployment.Release{}, fmt.Errorf("Invalid bake max_error_rate %v, expected a fraction between 0 and 1", b.MaxErrorRate)
+	}
+
+	return deployment.Release{
+		DeploymentID:  req.DeploymentID,
+		Project:       req.Project,
+		Target:        target,
+		Network:       b.Network,
+		Aliases:       b.Aliases,
+		Color:         core.Color(b.Color),
+		Containers:    b.Containers,
+		PreviousColor: core.Color(b.PreviousColor),
+		Previous:      b.Previous,
+		Policy:        policy,
+	}, nil
+}
+
+// DeploymentStartRequest begins the timeline of a deployment, for
+// POST /api/deployments
+type DeploymentStartRequest struct {
+	Project  string            `json:"project"`
+	App      string            `json:"app"`
+	Host     string            `json:"host,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// HostPutRequest is the complete routing configuration of a host, for
+// PUT /api/hosts/:host
+type HostPutRequest struct {
+	Project string `json:"project"`
+	state.HostSpec
+}
+
+// HTTPApplyRequest is the complete desired set of hosts, keyed by project
+// and then hostname. Hosts that aren't listed are removed.
+type HTTPApplyRequest struct {
+	Projects map[string]map[string]*state.HostSpec `json:"projects"`
+	DryRun   bool                                  `json:"dry_run,omitempty"`
 }
 
 type HTTPResponse struct {
@@ -61,25 +321,95 @@ type HealthUpdateRequest struct {
 	Healthy bool `json:"healthy"`
 }
 
+// AppStoppedRequest stops or starts serving an app, for
+// PUT /api/apps/:project/:app/stopped
+type AppStoppedRequest struct {
+	Stopped bool `json:"stopped"`
+}
+
 type StagingRequest struct {
 	Enabled bool `json:"enabled"`
 }
 
-// Start starts the HTTP API server on localhost:8080
-func (s *HTTPServer) Start() error {
+// ACMERequest selects the certificate authority. DirectoryURL may also be a
+// CA name such as "zerossl".
+type ACMERequest struct {
+	DirectoryURL string `json:"directory_url"`
+	Email        string `json:"email"`
+	EABKeyID     string `json:"eab_kid"`
+	EABHMACKey   string `json:"eab_hmac_key"`
+}
+
+// Handler returns the API's routes behind its middleware
+func (s *HTTPServer) Handler() http.Handler {
+	// Check API tokens and roles before any handler runs, then replay
+	// retried writes that carry an idempotency key, then reject requests
+	// that don't match the OpenAPI specification
+	return s.authorize(s.idempotent(s.validate(s.routes())))
+}
+
+// routes maps the API's paths to their handlers
+func (s *HTTPServer) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// API routes
 	mux.HandleFunc("/api/deploy", s.handleDeploy)
-	mux.HandleFunc("/api/hosts/", s.handleHosts)          // For DELETE /api/hosts/:host and PUT /api/hosts/:host/health
-	mux.HandleFunc("/api/hosts", s.handleHostsList)       // For GET /api/hosts
-	mux.HandleFunc("/api/cert/renew/", s.handleCertRenew) // For POST /api/cert/renew/:host
-	mux.HandleFunc("/api/staging", s.handleStaging)       // For PUT /api/staging
-	mux.HandleFunc("/api/status", s.handleStatus)         // For GET /api/status
+	mux.HandleFunc("/api/apply", s.handleApply)                    // For POST /api/apply
+	mux.HandleFunc("/api/deployments", s.handleTimelineStart)      // For POST /api/deployments
+	mux.HandleFunc("/api/deployments/", s.handleTimeline)          // For GET /api/deployments/:id and POST /api/deployments/:id/steps
+	mux.HandleFunc("/api/hosts/", s.handleHosts)                   // For GET/PUT/DELETE /api/hosts/:host, PUT /api/hosts/:host/health and GET /api/hosts/:host/health-history, /deployments and /uptime
+	mux.HandleFunc("/api/hosts", s.handleHostsList)                // For GET /api/hosts
+	mux.HandleFunc("/api/apps/", s.handleApps)                     // For PUT /api/apps/:project/:app/stopped
+	mux.HandleFunc("/api/cert/renew/", s.handleCertRenew)          // For POST /api/cert/renew/:host
+	mux.HandleFunc("/api/cert/revoke/", s.handleCertRevoke)        // For POST /api/cert/revoke/:host
+	mux.HandleFunc("/api/cert/groups", s.handleCertGroups)         // For GET/PUT /api/cert/groups
+	mux.HandleFunc("/api/cert/promote", s.handleCertPromote)       // For POST /api/cert/promote
+	mux.HandleFunc("/api/cert/precheck", s.handleCertPrecheck)     // For GET /api/cert/precheck
+	mux.HandleFunc("/api/certs/expiring", s.handleCertsExpiring)   // For GET /api/certs/expiring
+	mux.HandleFunc("/api/certs/unreferenced", s.handleCertsPrune)  // For GET/DELETE /api/certs/unreferenced
+	mux.HandleFunc("/api/staging", s.handleStaging)                // For PUT /api/staging
+	mux.HandleFunc("/api/keys/encrypt", s.handleEncryptKeys)       // For POST /api/keys/encrypt
+	mux.HandleFunc("/api/acme", s.handleACME)                      // For GET/PUT /api/acme
+	mux.HandleFunc("/api/tls", s.handleTLS)                        // For GET/PUT /api/tls
+	mux.HandleFunc("/api/default-backend", s.handleDefaultBackend) // For GET/PUT /api/default-backend
+	mux.HandleFunc("/api/on-demand-tls", s.handleOnDemandTLS)      // For GET/PUT /api/on-demand-tls
+	mux.HandleFunc("/api/error-pages", s.handleErrorPages)         // For GET/PUT /api/error-pages
+	mux.HandleFunc("/api/domains", s.handleDomainsList)            // For GET/POST /api/domains
+	mux.HandleFunc("/api/domains/", s.handleDomains)               // For GET/DELETE /api/domains/:domain and POST /api/domains/:domain/verify
+	mux.HandleFunc("/api/ports", s.handlePorts)                    // For GET/PUT/DELETE /api/ports
+	mux.HandleFunc("/api/networks", s.handleNetworksList)          // For GET /api/networks
+	mux.HandleFunc("/api/networks/", s.handleNetworks)             // For POST /api/networks/:network/connect and /disconnect
+	mux.HandleFunc("/api/audit", s.handleAudit)                    // For GET /api/audit
+	mux.HandleFunc("/api/export", s.handleExport)                  // For GET /api/export
+	mux.HandleFunc("/api/import", s.handleImport)                  // For POST /api/import
+	mux.HandleFunc("/api/status", s.handleStatus)                  // For GET /api/status
+	mux.HandleFunc("/api/notifications", s.handleNotifications)    // For GET/PUT /api/notifications
+	mux.HandleFunc("/api/stats", s.handleStats)                    // For GET /api/stats
+	mux.HandleFunc("/api/stats/apps", s.handleAppStats)            // For GET /api/stats/apps
+	mux.HandleFunc("/api/uptime", s.handleUptime)                  // For GET /api/uptime
+	mux.HandleFunc("/api/diagnostics", s.handleDiagnostics)        // For GET /api/diagnostics
+	mux.HandleFunc("/api/autoscale", s.handleAutoscale)            // For GET/PUT/DELETE /api/autoscale
+	mux.HandleFunc("/api/discovery", s.handleDiscovery)            // For GET/PUT /api/discovery
+	mux.HandleFunc("/api/quotas", s.handleQuotas)                  // For GET/PUT/DELETE /api/quotas
+	mux.HandleFunc("/api/quotas/check", s.handleQuotaCheck)        // For POST /api/quotas/check
+	mux.HandleFunc("/api/status-pages", s.handleStatusPages)       // For GET/PUT/DELETE /api/status-pages
+	mux.HandleFunc("/api/incidents", s.handleIncidents)            // For GET/POST /api/incidents
+	mux.HandleFunc("/api/incidents/", s.handleIncident)            // For POST /api/incidents/:id/updates and DELETE /api/incidents/:id
+	mux.HandleFunc("/api/users", s.handleUsers)                    // For GET/POST /api/users
+	mux.HandleFunc("/api/users/", s.handleUser)                    // For DELETE /api/users/:name
+	mux.HandleFunc("/api/openapi.json", s.handleOpenAPI)           // For GET /api/openapi.json
+	mux.HandleFunc("/metrics", s.handleMetrics)                    // For GET /metrics (Prometheus)
+
+	return mux
+}
+
+// Start starts the HTTP API server on localhost:8080
+func (s *HTTPServer) Start() error {
+	handler := sentry.Handler(s.Handler())
 
 	s.server = &http.Server{
 		Addr:    "localhost:8080",
-		Handler: mux,
+		Handler: handler,
 	}
 
 	log.Printf("[HTTP-API] Starting HTTP API server on localhost:8080")
@@ -90,11 +420,45 @@ func (s *HTTPServer) Start() error {
 		}
 	}()
 
+	if s.socketPath != "" {
+		s.startSocket(handler)
+	}
+
 	return nil
 }
 
+// startSocket serves the API on the unix socket as well. Without it the API
+// is still available over TCP.
+func (s *HTTPServer) startSocket(handler http.Handler) {
+	listener, err := listenUnix(s.socketPath)
+	if err != nil {
+		log.Printf("[HTTP-API] Failed to listen on %s, serving over TCP only: %v", s.socketPath, err)
+		return
+	}
+
+	// Socket peers have no address, name the transport in the audit log instead
+	s.socketServer = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RemoteAddr = "unix"
+			handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), socketKey, true)))
+		}),
+	}
+
+	log.Printf("[HTTP-API] Starting HTTP API server on %s", s.socketPath)
+
+	go func() {
+		if err := s.socketServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[HTTP-API] HTTP API socket error: %v", err)
+		}
+	}()
+}
+
 // Stop gracefully stops the HTTP server
 func (s *HTTPServer) Stop() error {
+	if s.socketServer != nil {
+		s.socketServer.Close()
+		os.Remove(s.socketPath)
+	}
 	if s.server != nil {
 		return s.server.Close()
 	}
@@ -116,57 +480,277 @@ func (s *HTTPServer) handleDeploy(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[HTTP-API] Deploy request for host %s with SSL=%v", req.Host, req.SSL)
 
+	// Hosts of other projects can't be taken over either
+	if !s.checkProject(w, r, req.Project) || !s.checkHost(w, r, req.Host) || !s.checkDeployment(w, r, req.DeploymentID) {
+		return
+	}
+
+	// The deploy is the switch step of its timeline
+	if req.DeploymentID != "" {
+		s.trackStep(req.DeploymentID, timeline.Update{Step: timeline.StepSwitch, Status: timeline.StatusRunning})
+		recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		w = recorder
+		defer s.trackSwitch(req, recorder)
+	}
+
 	// Validate required fields
 	if req.Host == "" || req.Target == "" || req.Project == "" {
 		s.writeErrorResponse(w, "Missing required fields: host, target, project", http.StatusBadRequest)
 		return
 	}
 
-	// Set default health path if not provided
-	if req.HealthPath == "" {
-		req.HealthPath = "/up"
+	spec := &state.HostSpec{Target: req.Target, App: req.App, HealthPath: req.HealthPath, SSL: req.SSL, Mode: req.Mode, Streaming: req.Streaming, Internal: req.Internal, HostTimeouts: req.HostTimeouts}
+	if err := normalizeHostSpec(req.Host, spec); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := state.ValidateMetadata(req.Metadata); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.HealthPath, req.SSL = spec.HealthPath, spec.SSL
+
+	var release deployment.Release
+	if req.Bake != nil {
+		if s.baker == nil {
+			s.writeErrorResponse(w, "Baking deploys is not enabled", http.StatusNotImplemented)
+			return
+		}
+		var err error
+		if release, err = req.Bake.release(&req); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	previousTarget := ""
+	if existing, _, err := s.state.GetHost(req.Host); err == nil {
+		previousTarget = existing.Target
 	}
 
 	// Update state directly in memory
 	if err := s.state.DeployHost(req.Host, req.Target, req.Project, req.App, req.HealthPath, req.SSL); err != nil {
+		s.publish(core.DeploymentFailed{
+			BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: req.Host},
+			Error:     err.Error(),
+			Metadata:  req.Metadata,
+		})
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.state.SetHostMode(req.Host, req.Mode); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.state.SetHostTimeouts(req.Host, req.HostTimeouts); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.state.SetHostStreaming(req.Host, req.Streaming); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.state.SetHostInternal(req.Host, req.Internal); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.state.RecordDeployment(req.Host, req.Target, req.Metadata); err != nil {
 		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if previousTarget != req.Target {
+		s.publish(core.TrafficSwitched{
+			BaseEvent:  core.BaseEvent{Timestamp: time.Now(), Hostname: req.Host},
+			FromTarget: previousTarget,
+			ToTarget:   req.Target,
+			Metadata:   req.Metadata,
+		})
+	}
+
+	// Watched from the switch on, a deploy that isn't baked ends the app's bake
+	if req.Bake != nil {
+		s.baker.Bake(req.Host, release)
+	} else if s.baker != nil {
+		if target, _, err := net.SplitHostPort(req.Target); err == nil {
+			s.baker.Cancel(req.Project, target)
+		}
+	}
+
 	// Trigger immediate health check
 	go s.healthChecker.CheckHost(req.Host)
 
 	// Attempt immediate certificate acquisition if SSL enabled
 	if req.SSL {
-		log.Printf("[HTTP-API] SSL enabled - starting immediate certificate acquisition for %s", req.Host)
-		go func() {
-			// Wait for HTTP server to be ready to handle ACME challenges if we have a readiness channel
-			if s.httpServerReady != nil {
-				log.Printf("[HTTP-API] Waiting for HTTP server readiness before certificate acquisition for %s", req.Host)
-
-				// Wait for HTTP server readiness with timeout
-				select {
-				case <-s.httpServerReady:
-					log.Printf("[HTTP-API] HTTP server is ready, starting certificate acquisition for %s", req.Host)
-				case <-time.After(10 * time.Second):
-					log.Printf("[HTTP-API] HTTP server readiness timeout after 10 seconds for %s, proceeding with certificate acquisition", req.Host)
-				}
-			} else {
-				// Fallback to sleep if no readiness channel (backward compatibility)
-				log.Printf("[HTTP-API] No readiness channel, using fallback delay before certificate acquisition for %s", req.Host)
-				time.Sleep(2 * time.Second)
+		s.acquireCertificateAsync(req.Host)
+	}
+
+	if host, project, err := s.state.GetHost(req.Host); err == nil {
+		w.Header().Set("ETag", host.ETag(project))
+	}
+
+	details := fmt.Sprintf("target=%s project=%s ssl=%v", req.Target, req.Project, req.SSL)
+	if req.Internal {
+		details += " internal=true"
+	}
+	if req.Bake != nil {
+		details += " bake=" + req.Bake.Duration
+	}
+	if len(req.Metadata) > 0 {
+		details += " " + state.FormatMetadata(req.Metadata)
+	}
+	s.record(r, "deploy", req.Host, details)
+	s.writeSuccessResponse(w, fmt.Sprintf("Deployed host %s", req.Host), nil)
+}
+
+// normalizeHostSpec validates a host's routing configuration and fills in
+// defaults, turning SSL off where the proxy can't provide a certificate
+func normalizeHostSpec(hostname string, spec *state.HostSpec) error {
+	// Wildcards are only allowed as the whole leftmost label
+	if strings.Contains(strings.TrimPrefix(hostname, "*."), "*") {
+		return fmt.Errorf("Invalid host pattern %s, use *.example.com", hostname)
+	}
+
+	// HTTP-01 challenges can't issue wildcard certificates
+	if state.IsHostPattern(hostname) && spec.SSL {
+		log.Printf("[HTTP-API] Host pattern %s is served over HTTP only, wildcard certificates need DNS validation", hostname)
+		spec.SSL = false
+	}
+
+	if spec.Mode != "" && spec.Mode != state.HostModeHTTP && spec.Mode != state.HostModePassthrough {
+		return fmt.Errorf("Invalid mode %s, expected http or passthrough", spec.Mode)
+	}
+
+	if err := spec.HostTimeouts.Validate(); err != nil {
+		return fmt.Errorf("Invalid timeouts for %s: %w", hostname, err)
+	}
+
+	// Passthrough backends terminate TLS themselves
+	if spec.Mode == state.HostModePassthrough {
+		spec.SSL = false
+	}
+
+	// Set default health path if not provided
+	if spec.HealthPath == "" {
+		spec.HealthPath = "/up"
+	}
+
+	return nil
+}
+
+// acquireCertificateAsync requests a certificate for a host in the background,
+// once the HTTP server can answer ACME challenges
+func (s *HTTPServer) acquireCertificateAsync(hostname string) {
+	log.Printf("[HTTP-API] SSL enabled - starting immediate certificate acquisition for %s", hostname)
+	go func() {
+		// Wait for HTTP server to be ready to handle ACME challenges if we have a readiness channel
+		if s.httpServerReady != nil {
+			log.Printf("[HTTP-API] Waiting for HTTP server readiness before certificate acquisition for %s", hostname)
+
+			// Wait for HTTP server readiness with timeout
+			select {
+			case <-s.httpServerReady:
+				log.Printf("[HTTP-API] HTTP server is ready, starting certificate acquisition for %s", hostname)
+			case <-time.After(10 * time.Second):
+				log.Printf("[HTTP-API] HTTP server readiness timeout after 10 seconds for %s, proceeding with certificate acquisition", hostname)
 			}
+		} else {
+			// Fallback to sleep if no readiness channel (backward compatibility)
+			log.Printf("[HTTP-API] No readiness channel, using fallback delay before certificate acquisition for %s", hostname)
+			time.Sleep(2 * time.Second)
+		}
 
-			if err := s.certManager.AcquireCertificate(req.Host); err != nil {
-				log.Printf("[HTTP-API] Certificate acquisition failed for %s: %v", req.Host, err)
-				log.Printf("[HTTP-API] Certificate will be retried by background worker")
-			} else {
-				log.Printf("[HTTP-API] Certificate acquisition completed successfully for %s", req.Host)
+		if err := s.certManager.AcquireCertificate(hostname); err != nil {
+			log.Printf("[HTTP-API] Certificate acquisition failed for %s: %v", hostname, err)
+			log.Printf("[HTTP-API] Certificate will be retried by background worker")
+		} else {
+			log.Printf("[HTTP-API] Certificate acquisition completed successfully for %s", hostname)
+		}
+	}()
+}
+
+// handleApply handles POST /api/apply
+func (s *HTTPServer) handleApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req HTTPApplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	// An empty document removes every host, so it has to be explicit
+	if req.Projects == nil {
+		s.writeErrorResponse(w, "Missing required field: projects", http.StatusBadRequest)
+		return
+	}
+
+	for _, hosts := range req.Projects {
+		for hostname, spec := range hosts {
+			if spec == nil {
+				continue
 			}
-		}()
+			if err := normalizeHostSpec(hostname, spec); err != nil {
+				s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 	}
 
-	s.writeSuccessResponse(w, fmt.Sprintf("Deployed host %s", req.Host), nil)
+	previousTargets := make(map[string]string)
+	for hostname, host := range s.state.GetAllHosts() {
+		previousTargets[hostname] = host.Target
+	}
+
+	result, err := s.state.Apply(req.Projects, req.DryRun)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message := fmt.Sprintf("%d added, %d updated, %d removed, %d unchanged",
+		len(result.Added), len(result.Updated), len(result.Removed), len(result.Unchanged))
+	log.Printf("[HTTP-API] Apply request (dry run=%v): %s", req.DryRun, message)
+
+	if req.DryRun {
+		s.writeSuccessResponse(w, "Dry run: "+message, result)
+		return
+	}
+
+	for _, hostname := range append(append([]string{}, result.Added...), result.Updated...) {
+		host, project, err := s.state.GetHost(hostname)
+		if err != nil {
+			continue
+		}
+
+		if previousTargets[hostname] != host.Target {
+			s.publish(core.TrafficSwitched{
+				BaseEvent:  core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+				FromTarget: previousTargets[hostname],
+				ToTarget:   host.Target,
+			})
+		}
+
+		go s.healthChecker.CheckHost(hostname)
+		if host.SSLEnabled {
+			s.acquireCertificateAsync(hostname)
+		}
+
+		s.record(r, "apply", hostname, fmt.Sprintf("target=%s project=%s ssl=%v", host.Target, project, host.SSLEnabled))
+	}
+	for _, hostname := range result.Removed {
+		s.record(r, "apply", hostname, "removed")
+	}
+
+	s.writeSuccessResponse(w, message, result)
 }
 
 // handleHosts handles routes that start with /api/hosts/
@@ -181,19 +765,62 @@ func (s *HTTPServer) handleHosts(w http.ResponseWriter, r *http.Request) {
 	}
 
 	hostname := parts[0]
+	if !s.checkHost(w, r, hostname) {
+		return
+	}
 
 	switch r.Method {
+	case http.MethodGet:
+		if len(parts) == 1 {
+			// GET /api/hosts/:host
+			s.handleGetHost(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "health-history" {
+			// GET /api/hosts/:host/health-history
+			s.handleHealthHistory(w, hostname)
+		} else if len(parts) == 2 && parts[1] == "deployments" {
+			// GET /api/hosts/:host/deployments
+			s.handleDeployments(w, hostname)
+		} else if len(parts) == 2 && parts[1] == "uptime" {
+			// GET /api/hosts/:host/uptime
+			s.handleHostUptime(w, hostname)
+		} else {
+			http.Error(w, "Invalid path", http.StatusNotFound)
+		}
 	case http.MethodDelete:
 		if len(parts) == 1 {
 			// DELETE /api/hosts/:host
-			s.handleRemoveHost(w, hostname)
+			s.handleRemoveHost(w, hostname, r)
 		} else {
 			http.Error(w, "Invalid path", http.StatusNotFound)
 		}
 	case http.MethodPut:
-		if len(parts) == 2 && parts[1] == "health" {
+		if len(parts) == 1 {
+			// PUT /api/hosts/:host
+			s.handlePutHost(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "health" {
 			// PUT /api/hosts/:host/health
 			s.handleUpdateHealth(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "tls" {
+			// PUT /api/hosts/:host/tls
+			s.handleHostTLS(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "limits" {
+			// PUT /api/hosts/:host/limits
+			s.handleHostLimits(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "rules" {
+			// PUT /api/hosts/:host/rules
+			s.handleHostRules(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "mirror" {
+			// PUT /api/hosts/:host/mirror
+			s.handleHostMirror(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "probes" {
+			// PUT /api/hosts/:host/probes
+			s.handleHostProbes(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "error-pages" {
+			// PUT /api/hosts/:host/error-pages
+			s.handleHostErrorPages(w, hostname, r)
+		} else if len(parts) == 2 && parts[1] == "scale-to-zero" {
+			// PUT /api/hosts/:host/scale-to-zero
+			s.handleScaleToZero(w, hostname, r)
 		} else {
 			http.Error(w, "Invalid path", http.StatusNotFound)
 		}
@@ -216,97 +843,1525 @@ func (s *HTTPServer) handleHostsList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hosts := s.state.GetAllHosts()
+	hosts := make(map[string]HostStatus)
+	for hostname, host := range s.state.GetAllHosts() {
+		_, project, _ := s.state.GetHost(hostname)
+		if !allowsProject(r, project) {
+			continue
+		}
+		hosts[hostname] = hostStatus(host, project)
+	}
 	s.writeSuccessResponse(w, "", hosts)
 }
 
-// handleRemoveHost handles DELETE /api/hosts/:host
-func (s *HTTPServer) handleRemoveHost(w http.ResponseWriter, hostname string) {
-	log.Printf("[HTTP-API] Remove request for host %s", hostname)
-
-	if err := s.state.RemoveHost(hostname); err != nil {
-		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
-		return
+// hostStatus describes a host with its runtime state
+func hostStatus(host *state.Host, project string) HostStatus {
+	return HostStatus{
+		Host:            host,
+		Project:         project,
+		ETag:            host.ETag(project),
+		Healthy:         host.Healthy,
+		LastHealthCheck: host.LastHealthCheck,
+		CrashLooping:    host.CrashLooping,
+		Sleeping:        host.Sleeping,
 	}
+}
 
-	s.writeSuccessResponse(w, fmt.Sprintf("Removed host %s", hostname), nil)
+// precondition reads the conditional request headers of a host write
+func precondition(r *http.Request) state.Precondition {
+	return state.Precondition{
+		IfMatch:     r.Header.Get("If-Match"),
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}
 }
 
-// handleUpdateHealth handles PUT /api/hosts/:host/health
-func (s *HTTPServer) handleUpdateHealth(w http.ResponseWriter, hostname string, r *http.Request) {
-	var req HealthUpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+// handleGetHost handles GET /api/hosts/:host
+func (s *HTTPServer) handleGetHost(w http.ResponseWriter, hostname string, r *http.Request) {
+	host, project, err := s.state.GetHost(hostname)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	log.Printf("[HTTP-API] UpdateHealth request for host %s, healthy=%v", hostname, req.Healthy)
-
-	if err := s.state.UpdateHealthStatus(hostname, req.Healthy); err != nil {
-		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	etag := host.ETag(project)
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && state.ETagMatches(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	s.writeSuccessResponse(w, fmt.Sprintf("Updated health for %s", hostname), nil)
+	s.writeSuccessResponse(w, "", hostStatus(host, project))
 }
 
-// handleCertRenew handles POST /api/cert/renew/:host
-func (s *HTTPServer) handleCertRenew(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handlePutHost handles PUT /api/hosts/:host. The body is the host's whole
+// configuration, so repeating a request changes nothing. With If-Match the
+// write only happens if the host still has that ETag, with If-None-Match: *
+// only if the host doesn't exist yet.
+func (s *HTTPServer) handlePutHost(w http.ResponseWriter, hostname string, r *http.Request) {
+	var req HostPutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
 		return
 	}
 
-	// Extract hostname from path
-	path := strings.TrimPrefix(r.URL.Path, "/api/cert/renew/")
-	hostname := strings.Split(path, "/")[0]
-
-	if hostname == "" {
-		http.Error(w, "Host not specified", http.StatusBadRequest)
+	if req.Target == "" || req.Project == "" {
+		s.writeErrorResponse(w, "Missing required fields: target, project", http.StatusBadRequest)
+		return
+	}
+	if !s.checkProject(w, r, req.Project) {
 		return
 	}
 
-	log.Printf("[HTTP-API] CertRenew request for host %s", hostname)
-
-	if err := s.certManager.RenewCertificate(hostname); err != nil {
-		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	spec := req.HostSpec
+	if err := normalizeHostSpec(hostname, &spec); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	s.writeSuccessResponse(w, fmt.Sprintf("Certificate renewal initiated for %s", hostname), nil)
-}
+	previousTarget := ""
+	if existing, _, err := s.state.GetHost(hostname); err == nil {
+		previousTarget = existing.Target
+	}
 
-// handleStaging handles PUT /api/staging
-func (s *HTTPServer) handleStaging(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	created, changed, err := s.state.PutHost(hostname, req.Project, &spec, precondition(r))
+	if errors.Is(err, state.ErrPreconditionFailed) {
+		s.writeErrorResponse(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var req StagingRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+	host, project, err := s.state.GetHost(hostname)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", host.ETag(project))
+
+	if !changed {
+		s.writeSuccessResponse(w, fmt.Sprintf("Host %s is unchanged", hostname), hostStatus(host, project))
+		return
+	}
+
+	log.Printf("[HTTP-API] Put host %s (created=%v) with target %s in project %s", hostname, created, spec.Target, project)
+
+	if previousTarget != spec.Target {
+		s.publish(core.TrafficSwitched{
+			BaseEvent:  core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+			FromTarget: previousTarget,
+			ToTarget:   spec.Target,
+		})
+	}
+
+	go s.healthChecker.CheckHost(hostname)
+	if host.SSLEnabled {
+		s.acquireCertificateAsync(hostname)
+	}
+
+	s.record(r, "put", hostname, fmt.Sprintf("target=%s project=%s ssl=%v", spec.Target, project, host.SSLEnabled))
+	if created {
+		s.writeCreatedResponse(w, fmt.Sprintf("Created host %s", hostname), hostStatus(host, project))
+		return
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("Updated host %s", hostname), hostStatus(host, project))
+}
+
+// handleRemoveHost handles DELETE /api/hosts/:host, honouring If-Match
+func (s *HTTPServer) handleRemoveHost(w http.ResponseWriter, hostname string, r *http.Request) {
+	log.Printf("[HTTP-API] Remove request for host %s", hostname)
+
+	if err := s.state.RemoveHostIf(hostname, precondition(r)); errors.Is(err, state.ErrPreconditionFailed) {
+		s.writeErrorResponse(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.record(r, "remove", hostname, "")
+	s.writeSuccessResponse(w, fmt.Sprintf("Removed host %s", hostname), nil)
+}
+
+// handleUpdateHealth handles PUT /api/hosts/:host/health
+func (s *HTTPServer) handleUpdateHealth(w http.ResponseWriter, hostname string, r *http.Request) {
+	var req HealthUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[HTTP-API] UpdateHealth request for host %s, healthy=%v", hostname, req.Healthy)
+
+	if err := s.state.UpdateHealthStatus(hostname, req.Healthy); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.record(r, "health", hostname, fmt.Sprintf("healthy=%v", req.Healthy))
+	s.writeSuccessResponse(w, fmt.Sprintf("Updated health for %s", hostname), nil)
+}
+
+// handleHealthHistory handles GET /api/hosts/:host/health-history
+func (s *HTTPServer) handleHealthHistory(w http.ResponseWriter, hostname string) {
+	if _, _, err := s.state.GetHost(hostname); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.writeSuccessResponse(w, fmt.Sprintf("Health history for %s", hostname), s.healthChecker.History(hostname))
+}
+
+// handleUptime handles GET /api/uptime, listing the uptime of every checked
+// host, optionally of one project
+func (s *HTTPServer) handleUptime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.uptime == nil {
+		s.writeErrorResponse(w, "Uptime monitoring is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	project := r.URL.Query().Get("project")
+	reports := make([]uptime.Report, 0)
+	for _, report := range s.uptime.Reports() {
+		if (project == "" || report.Project == project) && allowsProject(r, report.Project) {
+			reports = append(reports, report)
+		}
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("%d hosts", len(reports)), reports)
+}
+
+// handleHostUptime handles GET /api/hosts/:host/uptime
+func (s *HTTPServer) handleHostUptime(w http.ResponseWriter, hostname string) {
+	if s.uptime == nil {
+		s.writeErrorResponse(w, "Uptime monitoring is not enabled", http.StatusNotImplemented)
+		return
+	}
+	if _, _, err := s.state.GetHost(hostname); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	report, ok := s.uptime.HostReport(hostname)
+	if !ok {
+		s.writeErrorResponse(w, fmt.Sprintf("%s hasn't been checked yet", hostname), http.StatusNotFound)
+		return
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("Uptime of %s", hostname), report)
+}
+
+// handleDeployments handles GET /api/hosts/:host/deployments
+func (s *HTTPServer) handleDeployments(w http.ResponseWriter, hostname string) {
+	deployments, err := s.state.GetDeployments(hostname)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.writeSuccessResponse(w, fmt.Sprintf("Deployments of %s", hostname), deployments)
+}
+
+// handleApps handles routes that start with /api/apps/
+func (s *HTTPServer) handleApps(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/apps/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] != "stopped" {
+		http.Error(w, "Invalid path", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	project, app := parts[0], parts[1]
+	if !s.checkProject(w, r, project) {
+		return
+	}
+
+	var req AppStoppedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	hostnames := s.state.SetStopped(project, app, req.Stopped)
+	if len(hostnames) == 0 {
+		s.writeErrorResponse(w, fmt.Sprintf("No hosts route to %s/%s", project, app), http.StatusNotFound)
+		return
+	}
+
+	log.Printf("[HTTP-API] Set %s/%s stopped=%t for hosts %s", project, app, req.Stopped, strings.Join(hostnames, ", "))
+	s.record(r, "stopped", project+"/"+app, fmt.Sprintf("stopped=%t", req.Stopped))
+	if req.Stopped {
+		s.writeSuccessResponse(w, fmt.Sprintf("Stopped %s/%s", project, app), hostnames)
+		return
+	}
+
+	// Started hosts are routed to again once a health check passes
+	for _, hostname := range hostnames {
+		go s.healthChecker.CheckHost(hostname)
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("Started %s/%s", project, app), hostnames)
+}
+
+// handleTimelineStart handles POST /api/deployments
+func (s *HTTPServer) handleTimelineStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DeploymentStartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Project == "" || req.App == "" {
+		s.writeErrorResponse(w, "Missing required fields: project, app", http.StatusBadRequest)
+		return
+	}
+	if !s.checkProject(w, r, req.Project) || (req.Host != "" && !s.checkHost(w, r, req.Host)) {
+		return
+	}
+	if err := state.ValidateMetadata(req.Metadata); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deployment, err := s.timelines.Start(req.Project, req.App, req.Host, req.Metadata)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSuccessResponse(w, fmt.Sprintf("Started deployment %s of %s/%s", deployment.ID, req.Project, req.App), deployment)
+}
+
+// handleTimeline handles routes that start with /api/deployments/
+func (s *HTTPServer) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/deployments/"), "/")
+	id := parts[0]
+	if id == "" {
+		http.Error(w, "Deployment not specified", http.StatusBadRequest)
+		return
+	}
+	if !s.checkDeployment(w, r, id) {
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		// GET /api/deployments/:id
+		deployment, err := s.timelines.Get(id)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeSuccessResponse(w, "", deployment)
+	case len(parts) == 2 && parts[1] == "steps" && r.Method == http.MethodPost:
+		// POST /api/deployments/:id/steps
+		var update timeline.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		deployment, err := s.timelines.Update(id, update)
+		if errors.Is(err, timeline.ErrNotFound) {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writeSuccessResponse(w, "", deployment)
+	default:
+		http.Error(w, "Invalid path", http.StatusNotFound)
+	}
+}
+
+// checkDeployment answers 403 and returns false if a request may not touch
+// a deployment's timeline. Unknown IDs are left to the caller.
+func (s *HTTPServer) checkDeployment(w http.ResponseWriter, r *http.Request, id string) bool {
+	if id == "" || limitedUser(r) == nil {
+		return true
+	}
+	deployment, err := s.timelines.Get(id)
+	if err != nil {
+		return true
+	}
+	return s.checkProject(w, r, deployment.Project)
+}
+
+// trackSwitch completes the switch step of a deploy's timeline with the
+// outcome of the deploy
+func (s *HTTPServer) trackSwitch(req HTTPDeployRequest, recorder *recordingWriter) {
+	update := timeline.Update{Step: timeline.StepSwitch, Status: timeline.StatusSucceeded, Message: fmt.Sprintf("%s routes to %s", req.Host, req.Target)}
+	if recorder.status >= http.StatusBadRequest {
+		var response HTTPResponse
+		json.Unmarshal(recorder.body.Bytes(), &response)
+		update = timeline.Update{Step: timeline.StepSwitch, Status: timeline.StatusFailed, Error: response.Message}
+	}
+	s.trackStep(req.DeploymentID, update)
+}
+
+// trackStep records progress the proxy makes on a deployment's timeline.
+// Timelines are best effort, so failures are only logged.
+func (s *HTTPServer) trackStep(id string, update timeline.Update) {
+	if _, err := s.timelines.Update(id, update); err != nil {
+		log.Printf("[HTTP-API] Failed to track %s of deployment %s: %v", update.Step, id, err)
+	}
+}
+
+// handleCertRenew handles POST /api/cert/renew/:host
+func (s *HTTPServer) handleCertRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract hostname from path
+	path := strings.TrimPrefix(r.URL.Path, "/api/cert/renew/")
+	hostname := strings.Split(path, "/")[0]
+
+	if hostname == "" {
+		http.Error(w, "Host not specified", http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkHost(w, r, hostname) {
+		return
+	}
+
+	log.Printf("[HTTP-API] CertRenew request for host %s", hostname)
+
+	if err := s.certManager.RenewCertificate(hostname); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.record(r, "cert.renew", hostname, "")
+	s.writeSuccessResponse(w, fmt.Sprintf("Certificate renewal initiated for %s", hostname), nil)
+}
+
+// CertPromoteRequest is the optional body of POST /api/cert/promote
+type CertPromoteRequest struct {
+	Hosts []string `json:"hosts,omitempty"` // Hosts to promote, every host when empty
+}
+
+// handleCertPromote handles POST /api/cert/promote. Hosts that fail a step
+// are reported in the data, the request only fails when it can't start.
+func (s *HTTPServer) handleCertPromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CertPromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	for _, hostname := range req.Hosts {
+		if _, _, err := s.state.GetHost(hostname); err != nil {
+			s.writeErrorResponse(w, fmt.Sprintf("Host %s not found", hostname), http.StatusNotFound)
+			return
+		}
+		if !s.checkHost(w, r, hostname) {
+			return
+		}
+	}
+	// Every host means every host of the user's projects
+	if len(req.Hosts) == 0 && limitedUser(r) != nil {
+		req.Hosts = s.allowedHosts(r)
+		if len(req.Hosts) == 0 {
+			s.writeSuccessResponse(w, "Promoted 0 of 0 hosts", []cert.Promotion{})
+			return
+		}
+	}
+
+	log.Printf("[HTTP-API] CertPromote request for %d host(s)", len(req.Hosts))
+
+	promotions, err := s.certManager.PromoteCertificates(req.Hosts)
+	if err != nil && promotions == nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.writeErrorResponse(w, fmt.Sprintf("Failed to switch to production: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	promoted := 0
+	for _, promotion := range promotions {
+		if promotion.Promoted {
+			promoted++
+		}
+	}
+	s.record(r, "cert.promote", strings.Join(req.Hosts, ","), fmt.Sprintf("promoted=%d", promoted))
+	s.writeSuccessResponse(w, fmt.Sprintf("Promoted %d of %d hosts", promoted, len(promotions)), promotions)
+}
+
+// precheckTimeout bounds the DNS, CAA and DNSSEC lookups of a precheck
+const precheckTimeout = 20 * time.Second
+
+// handleCertPrecheck handles GET /api/cert/precheck?host=, returning the DNS
+// changes a host needs before the CA issues its certificate
+func (s *HTTPServer) handleCertPrecheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hostname := r.URL.Query().Get("host")
+	if hostname == "" {
+		s.writeErrorResponse(w, "Missing host parameter", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := s.state.GetHost(hostname); err != nil {
+		s.writeErrorResponse(w, "Host not found", http.StatusNotFound)
+		return
+	}
+	if !s.checkHost(w, r, hostname) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), precheckTimeout)
+	defer cancel()
+	problems := s.certManager.Precheck(ctx, hostname)
+	if problems == nil {
+		problems = []string{}
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("%d DNS problems for %s", len(problems), hostname), problems)
+}
+
+// CertRevokeRequest is the optional body of POST /api/cert/revoke/:host
+type CertRevokeRequest struct {
+	Reason string `json:"reason,omitempty"` // A key of cert.RevocationReasons, key_compromise by default
+}
+
+// handleCertRevoke handles POST /api/cert/revoke/:host
+func (s *HTTPServer) handleCertRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hostname := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/cert/revoke/"), "/")[0]
+	if hostname == "" {
+		http.Error(w, "Host not specified", http.StatusBadRequest)
+		return
+	}
+
+	var req CertRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "key_compromise"
+	}
+	reason, ok := cert.RevocationReasons[req.Reason]
+	if !ok {
+		s.writeErrorResponse(w, fmt.Sprintf("Unknown revocation reason %q", req.Reason), http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkHost(w, r, hostname) {
+		return
+	}
+
+	log.Printf("[HTTP-API] CertRevoke request for host %s, reason %s", hostname, req.Reason)
+
+	if err := s.certManager.RevokeCertificate(hostname, reason); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.record(r, "cert.revoke", hostname, "reason="+req.Reason)
+	s.writeSuccessResponse(w, fmt.Sprintf("Certificate for %s revoked, a new one is being acquired", hostname), nil)
+}
+
+// CertGroupsRequest sets the projects whose hosts share one certificate
+type CertGroupsRequest struct {
+	Projects []string `json:"projects"`
+}
+
+// handleCertGroups handles GET and PUT /api/cert/groups. An empty list gives
+// every host its own certificate again.
+func (s *HTTPServer) handleCertGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSuccessResponse(w, "", CertGroupsRequest{Projects: s.state.GetCertGroups()})
+	case http.MethodPut:
+		var req CertGroupsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		for _, project := range req.Projects {
+			if project == "" {
+				s.writeErrorResponse(w, "Project names can't be empty", http.StatusBadRequest)
+				return
+			}
+		}
+
+		s.state.SetCertGroups(req.Projects)
+		// Order certificates for the changed groups, current ones are served meanwhile
+		s.certManager.Regroup()
+
+		if len(req.Projects) == 0 {
+			log.Printf("[HTTP-API] Disabling certificate groups")
+			s.record(r, "cert.groups", "", "disabled")
+			s.writeSuccessResponse(w, "Every host gets its own certificate", nil)
+			return
+		}
+
+		log.Printf("[HTTP-API] Certificate groups set for %s", strings.Join(req.Projects, ", "))
+		s.record(r, "cert.groups", "", fmt.Sprintf("projects=%s", strings.Join(req.Projects, ",")))
+		s.writeSuccessResponse(w, fmt.Sprintf("Hosts of %s share one certificate per project", strings.Join(req.Projects, ", ")), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCertsExpiring handles GET /api/certs/expiring?within=14d
+func (s *HTTPServer) handleCertsExpiring(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	within := 14 * 24 * time.Hour
+	if value := r.URL.Query().Get("within"); value != "" {
+		d, err := cert.ParseDays(value)
+		if err != nil {
+			s.writeErrorResponse(w, fmt.Sprintf("Invalid within %q, expected a duration like 14d or 72h", value), http.StatusBadRequest)
+			return
+		}
+		within = d
+	}
+
+	expiring := s.certManager.Expiring(within, time.Now())
+	if limitedUser(r) != nil {
+		allowed := expiring[:0]
+		for _, certificate := range expiring {
+			if s.allowsHost(r, certificate.Hostname) {
+				allowed = append(allowed, certificate)
+			}
+		}
+		expiring = allowed
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("%d certificate(s) expiring", len(expiring)), expiring)
+}
+
+// handleCertsPrune handles GET and DELETE /api/certs/unreferenced, listing
+// or deleting certificate files no host uses
+func (s *HTTPServer) handleCertsPrune(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		unreferenced := s.certManager.UnreferencedCertificates()
+		s.writeSuccessResponse(w, fmt.Sprintf("%d unreferenced certificate(s)", len(unreferenced)), unreferenced)
+	case http.MethodDelete:
+		log.Printf("[HTTP-API] PruneCertificates request")
+		pruned := s.certManager.PruneCertificates()
+		s.record(r, "certs.prune", "", fmt.Sprintf("deleted=%d", len(pruned)))
+		s.writeSuccessResponse(w, fmt.Sprintf("Deleted %d unreferenced certificate(s)", len(pruned)), pruned)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleEncryptKeys handles POST /api/keys/encrypt, encrypting private keys
+// written before a key passphrase was set
+func (s *HTTPServer) handleEncryptKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Printf("[HTTP-API] EncryptKeys request")
+
+	encrypted, err := s.certManager.EncryptKeys()
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.record(r, "keys.encrypt", "", fmt.Sprintf("encrypted=%d", encrypted))
+	s.writeSuccessResponse(w, fmt.Sprintf("Encrypted %d private keys", encrypted), nil)
+}
+
+// handleStaging handles PUT /api/staging
+func (s *HTTPServer) handleStaging(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req StagingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
 		return
 	}
 
 	log.Printf("[HTTP-API] SetStaging request, enabled=%v", req.Enabled)
 
-	// Update the state with new staging mode
-	s.state.SetLetsEncryptStaging(req.Enabled)
+	// Update the state with new staging mode
+	s.state.SetLetsEncryptStaging(req.Enabled)
+
+	// Update the ACME client to use the new directory URL
+	if err := s.certManager.UpdateACMEClient(); err != nil {
+		log.Printf("[HTTP-API] Failed to update ACME client: %v", err)
+		s.writeErrorResponse(w, fmt.Sprintf("Failed to update ACME client: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	mode := "production"
+	if req.Enabled {
+		mode = "staging"
+	}
+
+	s.record(r, "staging", "", fmt.Sprintf("enabled=%v", req.Enabled))
+	s.writeSuccessResponse(w, fmt.Sprintf("Set Let's Encrypt mode to %s", mode), nil)
+}
+
+// handleACME handles GET and PUT /api/acme
+func (s *HTTPServer) handleACME(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		current := s.state.GetACMEConfig()
+		if current.EABHMACKey != "" {
+			current.EABHMACKey = "redacted"
+		}
+		s.writeSuccessResponse(w, "", current)
+	case http.MethodPut:
+		var req ACMERequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		directoryURL, err := state.ResolveACMEDirectory(req.DirectoryURL)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if (req.EABKeyID == "") != (req.EABHMACKey == "") {
+			s.writeErrorResponse(w, "EAB key ID and HMAC key must be set together", http.StatusBadRequest)
+			return
+		}
+		if req.EABKeyID != "" {
+			if _, err := cert.DecodeEABKey(req.EABHMACKey); err != nil {
+				s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		previous := s.state.GetACMEConfig()
+		if previous.DirectoryURL == directoryURL && previous.Email == req.Email &&
+			previous.EABKeyID == req.EABKeyID && previous.EABHMACKey == req.EABHMACKey {
+			s.writeSuccessResponse(w, fmt.Sprintf("ACME directory already set to %s", directoryURL), nil)
+			return
+		}
+
+		log.Printf("[HTTP-API] SetACME request, directory=%s, eab=%v", directoryURL, req.EABKeyID != "")
+		s.state.SetACMEConfig(directoryURL, req.Email, req.EABKeyID, req.EABHMACKey)
+
+		// Registering with the new CA fails on bad EAB credentials, so keep
+		// using the previous CA in that case
+		if err := s.certManager.UpdateACMEClient(); err != nil {
+			log.Printf("[HTTP-API] Failed to switch ACME directory, restoring %s: %v", previous.DirectoryURL, err)
+			s.state.SetACMEConfig(previous.DirectoryURL, previous.Email, previous.EABKeyID, previous.EABHMACKey)
+			if restoreErr := s.certManager.UpdateACMEClient(); restoreErr != nil {
+				log.Printf("[HTTP-API] Failed to restore ACME client: %v", restoreErr)
+			}
+			s.writeErrorResponse(w, fmt.Sprintf("Failed to register with %s: %v", directoryURL, err), http.StatusBadGateway)
+			return
+		}
+
+		s.record(r, "acme", "", fmt.Sprintf("directory=%s", directoryURL))
+		s.writeSuccessResponse(w, fmt.Sprintf("Set ACME directory to %s", directoryURL), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeTLSPolicy reads and validates a TLS policy. An empty policy decodes
+// to nil, which restores the defaults.
+func decodeTLSPolicy(r *http.Request) (*state.TLSPolicy, error) {
+	var policy state.TLSPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		return nil, fmt.Errorf("Invalid JSON payload")
+	}
+	if err := router.ValidateTLSPolicy(&policy); err != nil {
+		return nil, err
+	}
+	if policy.MinVersion == "" && len(policy.CipherSuites) == 0 && len(policy.ALPN) == 0 {
+		return nil, nil
+	}
+	return &policy, nil
+}
+
+// describeTLSPolicy formats a policy for the audit log
+func describeTLSPolicy(policy *state.TLSPolicy) string {
+	if policy == nil {
+		return "reset to defaults"
+	}
+	return fmt.Sprintf("%+v", *policy)
+}
+
+// handleTLS handles GET and PUT /api/tls for the global TLS policy
+func (s *HTTPServer) handleTLS(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSuccessResponse(w, "", s.state.GetDefaultTLSPolicy())
+	case http.MethodPut:
+		policy, err := decodeTLSPolicy(r)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[HTTP-API] Setting default TLS policy: %+v", policy)
+		s.state.SetDefaultTLSPolicy(policy)
+		s.record(r, "tls", "", describeTLSPolicy(policy))
+		s.writeSuccessResponse(w, "Updated default TLS policy", nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHostTLS handles PUT /api/hosts/:host/tls
+func (s *HTTPServer) handleHostTLS(w http.ResponseWriter, hostname string, r *http.Request) {
+	policy, err := decodeTLSPolicy(r)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[HTTP-API] Setting TLS policy for host %s: %+v", hostname, policy)
+	if err := s.state.SetHostTLSPolicy(hostname, policy); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.record(r, "tls", hostname, describeTLSPolicy(policy))
+	s.writeSuccessResponse(w, fmt.Sprintf("Updated TLS policy for %s", hostname), nil)
+}
+
+// DefaultBackendRequest sets the backend for requests that match no host
+type DefaultBackendRequest struct {
+	Target string `json:"target"`
+}
+
+// handleDefaultBackend handles GET and PUT /api/default-backend. An empty target removes it.
+func (s *HTTPServer) handleDefaultBackend(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSuccessResponse(w, "", DefaultBackendRequest{Target: s.state.GetDefaultBackend()})
+	case http.MethodPut:
+		var req DefaultBackendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		if req.Target == "" {
+			log.Printf("[HTTP-API] Removing default backend")
+			s.state.SetDefaultBackend("")
+			s.record(r, "default-backend", "", "removed")
+			s.writeSuccessResponse(w, "Removed default backend, unknown hosts get a 404", nil)
+			return
+		}
+
+		if _, _, err := net.SplitHostPort(req.Target); err != nil {
+			s.writeErrorResponse(w, fmt.Sprintf("Invalid target %s, expected container:port", req.Target), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[HTTP-API] Setting default backend to %s", req.Target)
+		s.state.SetDefaultBackend(req.Target)
+		s.record(r, "default-backend", "", fmt.Sprintf("target=%s", req.Target))
+		s.writeSuccessResponse(w, fmt.Sprintf("Unknown hosts are now served by %s", req.Target), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DomainRequest registers a customer domain for a deployed host
+type DomainRequest struct {
+	Domain string `json:"domain"`
+	Host   string `json:"host"`
+}
+
+// DomainStatus is a customer domain with the DNS records that verify it
+type DomainStatus struct {
+	*state.CustomDomain
+	Verification domains.Instructions `json:"verification"`
+}
+
+func domainStatus(domain *state.CustomDomain) DomainStatus {
+	return DomainStatus{CustomDomain: domain, Verification: domains.InstructionsFor(domain)}
+}
+
+// handleDomainsList handles GET and POST /api/domains
+func (s *HTTPServer) handleDomainsList(w http.ResponseWriter, r *http.Request) {
+	if s.domains == nil {
+		s.writeErrorResponse(w, "Custom domains are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		registered := s.state.GetCustomDomains()
+		statuses := make([]DomainStatus, 0, len(registered))
+		for i := range registered {
+			statuses = append(statuses, domainStatus(&registered[i]))
+		}
+		s.writeSuccessResponse(w, "", statuses)
+	case http.MethodPost:
+		var req DomainRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if req.Domain == "" || req.Host == "" {
+			s.writeErrorResponse(w, "Missing required fields: domain, host", http.StatusBadRequest)
+			return
+		}
+
+		domain, err := s.domains.Add(req.Domain, req.Host)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	// Update the ACME client to use the new directory URL
-	if err := s.certManager.UpdateACMEClient(); err != nil {
-		log.Printf("[HTTP-API] Failed to update ACME client: %v", err)
-		s.writeErrorResponse(w, fmt.Sprintf("Failed to update ACME client: %v", err), http.StatusInternalServerError)
+		s.record(r, "domain.add", domain.Domain, fmt.Sprintf("host=%s", domain.Host))
+		s.writeSuccessResponse(w, fmt.Sprintf("Registered %s, add a TXT record at %s%s or a CNAME to %s to verify it", domain.Domain, domains.TXTPrefix, domain.Domain, domain.Host), domainStatus(domain))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDomains handles routes that start with /api/domains/
+func (s *HTTPServer) handleDomains(w http.ResponseWriter, r *http.Request) {
+	if s.domains == nil {
+		s.writeErrorResponse(w, "Custom domains are not enabled", http.StatusNotImplemented)
 		return
 	}
 
-	mode := "production"
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/domains/"), "/")
+	name := strings.ToLower(parts[0])
+	if name == "" {
+		http.Error(w, "Domain not specified", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		// GET /api/domains/:domain
+		domain, err := s.state.GetCustomDomain(name)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeSuccessResponse(w, "", domainStatus(domain))
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		// DELETE /api/domains/:domain
+		if err := s.state.RemoveCustomDomain(name); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.record(r, "domain.remove", name, "")
+		s.writeSuccessResponse(w, fmt.Sprintf("Removed domain %s", name), nil)
+	case len(parts) == 2 && parts[1] == "verify" && r.Method == http.MethodPost:
+		// POST /api/domains/:domain/verify
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		domain, err := s.domains.Verify(ctx, name)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if domain.Status != state.DomainVerified {
+			s.writeSuccessResponse(w, fmt.Sprintf("Domain %s is not verified yet: %s", name, domain.LastError), domainStatus(domain))
+			return
+		}
+		s.record(r, "domain.verify", name, fmt.Sprintf("host=%s", domain.Host))
+		s.writeSuccessResponse(w, fmt.Sprintf("Domain %s is verified and served as %s", name, domain.Host), domainStatus(domain))
+	default:
+		http.Error(w, "Invalid path", http.StatusNotFound)
+	}
+}
+
+// OnDemandTLSRequest sets the hostnames that get certificates on their first TLS handshake
+type OnDemandTLSRequest struct {
+	Allow     []string `json:"allow"`
+	Ask       string   `json:"ask,omitempty"`
+	MaxHosts  int      `json:"max_hosts,omitempty"`
+	RateLimit int      `json:"rate_limit,omitempty"`
+}
+
+// handleOnDemandTLS handles GET and PUT /api/on-demand-tls. An empty allowlist turns it off.
+func (s *HTTPServer) handleOnDemandTLS(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		onDemand := s.state.GetOnDemandTLS()
+		s.writeSuccessResponse(w, "", OnDemandTLSRequest{
+			Allow:     onDemand.Allow,
+			Ask:       onDemand.Ask,
+			MaxHosts:  onDemand.MaxHosts,
+			RateLimit: onDemand.RateLimit,
+		})
+	case http.MethodPut:
+		var req OnDemandTLSRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		for _, pattern := range req.Allow {
+			// Wildcards are only allowed as the whole leftmost label, and
+			// allowing every name would let anyone exhaust the CA's rate limits
+			if pattern == "" || strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+				s.writeErrorResponse(w, fmt.Sprintf("Invalid pattern %q, use app.example.com or *.example.com", pattern), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Ask != "" {
+			if ask, err := url.Parse(req.Ask); err != nil || (ask.Scheme != "http" && ask.Scheme != "https") || ask.Host == "" {
+				s.writeErrorResponse(w, fmt.Sprintf("Invalid ask URL %q, use http:// or https://", req.Ask), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.MaxHosts < 0 || req.RateLimit < 0 {
+			s.writeErrorResponse(w, "max_hosts and rate_limit can't be negative", http.StatusBadRequest)
+			return
+		}
+
+		s.state.SetOnDemandTLS(state.OnDemandTLS{
+			Allow:     req.Allow,
+			Ask:       req.Ask,
+			MaxHosts:  req.MaxHosts,
+			RateLimit: req.RateLimit,
+		})
+		if len(req.Allow) == 0 {
+			log.Printf("[HTTP-API] Disabling on-demand TLS")
+			s.record(r, "on-demand-tls", "", "disabled")
+			s.writeSuccessResponse(w, "On-demand TLS disabled", nil)
+			return
+		}
+
+		log.Printf("[HTTP-API] On-demand TLS allowed for %s", strings.Join(req.Allow, ", "))
+		s.record(r, "on-demand-tls", "", fmt.Sprintf("allow=%s", strings.Join(req.Allow, ",")))
+		s.writeSuccessResponse(w, fmt.Sprintf("On-demand TLS allowed for %s", strings.Join(req.Allow, ", ")), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePorts handles GET, PUT and DELETE /api/ports. DELETE takes the rule's
+// listen port and protocol as query parameters.
+func (s *HTTPServer) handlePorts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSuccessResponse(w, "", s.state.GetPortForwards())
+	case http.MethodPut:
+		var rule state.PortForward
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if rule.Protocol == "" {
+			rule.Protocol = "tcp"
+		}
+		if err := ports.ValidateRule(&rule); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var previous *state.PortForward
+		for _, existing := range s.state.GetPortForwards() {
+			if existing.Key() == rule.Key() {
+				previous = &existing
+				break
+			}
+		}
+
+		log.Printf("[HTTP-API] Forwarding %s to %s", rule.Key(), rule.Target)
+		s.state.SetPortForward(&rule)
+		if err := s.syncPorts(); err != nil {
+			// Put back what was there so state matches the running listeners
+			if previous != nil {
+				s.state.SetPortForward(previous)
+			} else {
+				s.state.RemovePortForward(rule.Listen, rule.Protocol)
+			}
+			s.syncPorts()
+			s.writeErrorResponse(w, fmt.Sprintf("Failed to listen on %s: %v", rule.Key(), err), http.StatusConflict)
+			return
+		}
+
+		s.savePorts()
+		s.record(r, "ports.add", "", fmt.Sprintf("%s target=%s allow=%s", rule.Key(), rule.Target, strings.Join(rule.Allow, ",")))
+		s.writeSuccessResponse(w, fmt.Sprintf("Forwarding %s to %s", rule.Key(), rule.Target), nil)
+	case http.MethodDelete:
+		listen, err := strconv.Atoi(r.URL.Query().Get("listen"))
+		if err != nil {
+			s.writeErrorResponse(w, "Missing or invalid listen parameter", http.StatusBadRequest)
+			return
+		}
+		protocol := r.URL.Query().Get("protocol")
+		if protocol == "" {
+			protocol = "tcp"
+		}
+
+		log.Printf("[HTTP-API] Removing forwarding rule %d/%s", listen, protocol)
+		if err := s.state.RemovePortForward(listen, protocol); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.syncPorts()
+		s.savePorts()
+		s.record(r, "ports.remove", "", fmt.Sprintf("%d/%s", listen, protocol))
+
+		s.writeSuccessResponse(w, fmt.Sprintf("Stopped forwarding %d/%s", listen, protocol), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// syncPorts applies the forwarding rules in state if a port manager is configured
+func (s *HTTPServer) syncPorts() error {
+	// Forwarded projects need their networks attached too
+	if s.networks != nil {
+		s.networks.Changed()
+	}
+	if s.ports == nil {
+		return nil
+	}
+	return s.ports.Sync()
+}
+
+// savePorts writes state right away, the CLI reads forwarding rules from the
+// state file to decide which ports the proxy container publishes
+func (s *HTTPServer) savePorts() {
+	if err := s.state.Save(); err != nil {
+		log.Printf("[HTTP-API] Failed to save state: %v", err)
+	}
+}
+
+// handleNetworksList handles GET /api/networks
+func (s *HTTPServer) handleNetworksList(w http.ResponseWriter, r *http.Request) {
+	if s.networks == nil {
+		s.writeErrorResponse(w, "Network management is not enabled", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachments, err := s.networks.List(r.Context())
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	s.writeSuccessResponse(w, "", attachments)
+}
+
+// handleNetworks handles POST /api/networks/:network/connect and /disconnect
+func (s *HTTPServer) handleNetworks(w http.ResponseWriter, r *http.Request) {
+	if s.networks == nil {
+		s.writeErrorResponse(w, "Network management is not enabled", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/networks/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	network := parts[0]
+
+	switch parts[1] {
+	case "connect":
+		if err := s.networks.Connect(r.Context(), network); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.record(r, "network.connect", "", network)
+		s.writeSuccessResponse(w, fmt.Sprintf("Attached the proxy to %s", network), nil)
+	case "disconnect":
+		if err := s.networks.Disconnect(r.Context(), network); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.record(r, "network.disconnect", "", network)
+		s.writeSuccessResponse(w, fmt.Sprintf("Detached the proxy from %s", network), nil)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleExport handles GET /api/export
+func (s *HTTPServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive, err := backup.Create(s.state)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[HTTP-API] Exported state with %d files", len(archive.Files))
+	s.writeSuccessResponse(w, "", archive)
+}
+
+// handleImport handles POST /api/import, replacing the whole state and its
+// certificates with an archive from GET /api/export
+func (s *HTTPServer) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var archive backup.Archive
+	if err := json.NewDecoder(r.Body).Decode(&archive); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := backup.Restore(s.state, &archive); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Pick up the restored account key, certificates and forwarding rules
+	if s.certManager != nil {
+		if err := s.certManager.Reload(); err != nil {
+			log.Printf("[HTTP-API] Failed to reload certificates after import: %v", err)
+		}
+	}
+	if err := s.syncPorts(); err != nil {
+		log.Printf("[HTTP-API] Failed to forward some ports after import: %v", err)
+	}
+
+	hosts := s.state.GetAllHosts()
+	for hostname := range hosts {
+		go s.healthChecker.CheckHost(hostname)
+	}
+
+	log.Printf("[HTTP-API] Imported state with %d hosts and %d files", len(hosts), len(archive.Files))
+	s.record(r, "import", "", fmt.Sprintf("hosts=%d files=%d exported=%s", len(hosts), len(archive.Files), archive.CreatedAt.Format(time.RFC3339)))
+	s.writeSuccessResponse(w, fmt.Sprintf("Imported %d hosts and %d files", len(hosts), len(archive.Files)), nil)
+}
+
+// ScaleToZeroRequest enables or disables scaling a host's app to zero
+type ScaleToZeroRequest struct {
+	Enabled     bool                   `json:"enabled"`
+	IdleTimeout string                 `json:"idle_timeout,omitempty"` // e.g. "15m", empty for the default
+	ColdStart   *state.ColdStartPolicy `json:"cold_start,omitempty"`   // Limits for requests waiting on a start, nil for the defaults
+}
+
+// handleScaleToZero handles PUT /api/hosts/:host/scale-to-zero
+func (s *HTTPServer) handleScaleToZero(w http.ResponseWriter, hostname string, r *http.Request) {
+	var req ScaleToZeroRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.IdleTimeout != "" {
+		if d, err := time.ParseDuration(req.IdleTimeout); err != nil || d < time.Minute {
+			s.writeErrorResponse(w, fmt.Sprintf("Invalid idle timeout %q, expected at least 1m", req.IdleTimeout), http.StatusBadRequest)
+			return
+		}
+	}
+	if policy := req.ColdStart; policy != nil {
+		if policy.MaxWait != "" {
+			if d, err := time.ParseDuration(policy.MaxWait); err != nil || d < time.Second || d > 5*time.Minute {
+				s.writeErrorResponse(w, fmt.Sprintf("Invalid max wait %q, expected between 1s and 5m", policy.MaxWait), http.StatusBadRequest)
+				return
+			}
+		}
+		if policy.QueueDepth < 0 || policy.WarmPool < 0 {
+			s.writeErrorResponse(w, "Queue depth and warm pool cannot be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	log.Printf("[HTTP-API] Setting scale to zero for host %s: %t %s", hostname, req.Enabled, req.IdleTimeout)
+	if err := s.state.SetScaleToZero(hostname, req.Enabled, req.IdleTimeout, req.ColdStart); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	details := fmt.Sprintf("enabled=%t idle_timeout=%s", req.Enabled, req.IdleTimeout)
+	if policy := req.ColdStart; policy != nil {
+		details += fmt.Sprintf(" max_wait=%s queue_depth=%d starting_page=%t warm_pool=%d", policy.MaxWait, policy.QueueDepth, policy.StartingPage, policy.WarmPool)
+	}
+	s.record(r, "scale-to-zero", hostname, details)
 	if req.Enabled {
-		mode = "staging"
+		s.writeSuccessResponse(w, fmt.Sprintf("%s scales to zero when idle", hostname), nil)
+	} else {
+		s.writeSuccessResponse(w, fmt.Sprintf("%s no longer scales to zero", hostname), nil)
+	}
+}
+
+// handleHostLimits handles PUT /api/hosts/:host/limits. Empty limits remove them.
+func (s *HTTPServer) handleHostLimits(w http.ResponseWriter, hostname string, r *http.Request) {
+	var limits state.HostLimits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if err := router.ValidateHostLimits(&limits); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var update *state.HostLimits
+	if limits.MaxConcurrent > 0 || limits.Bandwidth > 0 {
+		update = &limits
+	}
+
+	log.Printf("[HTTP-API] Setting limits for host %s: %+v", hostname, update)
+	if err := s.state.SetHostLimits(hostname, update); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.record(r, "limits", hostname, fmt.Sprintf("%+v", limits))
+	s.writeSuccessResponse(w, fmt.Sprintf("Updated limits for %s", hostname), nil)
+}
+
+// handleHostRules handles PUT /api/hosts/:host/rules. The body is the host's
+// whole list of routing rules, an empty list removes them.
+func (s *HTTPServer) handleHostRules(w http.ResponseWriter, hostname string, r *http.Request) {
+	var rules []state.RoutingRule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if err := router.ValidateRoutingRules(rules); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rules) == 0 {
+		rules = nil
+	}
+
+	log.Printf("[HTTP-API] Setting %d routing rules for host %s", len(rules), hostname)
+	if err := s.state.SetHostRules(hostname, rules); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.record(r, "rules", hostname, fmt.Sprintf("%+v", rules))
+	if len(rules) == 0 {
+		s.writeSuccessResponse(w, fmt.Sprintf("Removed routing rules of %s", hostname), nil)
+	} else {
+		s.writeSuccessResponse(w, fmt.Sprintf("Updated routing rules of %s", hostname), nil)
+	}
+}
+
+// handleHostMirror handles PUT /api/hosts/:host/mirror. An empty target stops mirroring.
+func (s *HTTPServer) handleHostMirror(w http.ResponseWriter, hostname string, r *http.Request) {
+	var mirror state.MirrorPolicy
+	if err := json.NewDecoder(r.Body).Decode(&mirror); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	var update *state.MirrorPolicy
+	if mirror.Target != "" {
+		if err := router.ValidateMirrorPolicy(&mirror); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		update = &mirror
+	}
+
+	log.Printf("[HTTP-API] Setting mirror for host %s: %+v", hostname, update)
+	if err := s.state.SetHostMirror(hostname, update); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.record(r, "mirror", hostname, fmt.Sprintf("target=%s percent=%d", mirror.Target, mirror.Percent))
+	if update == nil {
+		s.writeSuccessResponse(w, fmt.Sprintf("Stopped mirroring %s", hostname), nil)
+	} else {
+		s.writeSuccessResponse(w, fmt.Sprintf("Mirroring %d%% of %s to %s", mirror.Percent, hostname, mirror.Target), nil)
+	}
+}
+
+// handleHostProbes handles PUT /api/hosts/:host/probes. Without readiness
+// and liveness probes the host goes back to the regular health check.
+func (s *HTTPServer) handleHostProbes(w http.ResponseWriter, hostname string, r *http.Request) {
+	var probes state.HostProbes
+	if err := json.NewDecoder(r.Body).Decode(&probes); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	var update *state.HostProbes
+	if probes.Readiness != nil || probes.Liveness != nil {
+		if err := probes.Validate(); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		update = &probes
+	}
+
+	log.Printf("[HTTP-API] Setting probes for host %s: %+v", hostname, update)
+	if err := s.state.SetHostProbes(hostname, update); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.record(r, "probes", hostname, fmt.Sprintf("readiness=%t liveness=%t", probes.Readiness != nil, probes.Liveness != nil))
+	if update == nil {
+		s.writeSuccessResponse(w, fmt.Sprintf("Removed probes of %s", hostname), nil)
+	} else {
+		s.writeSuccessResponse(w, fmt.Sprintf("Updated probes of %s", hostname), nil)
+	}
+}
+
+// handleHostErrorPages handles PUT /api/hosts/:host/error-pages. An empty
+// object falls back to the global error pages.
+func (s *HTTPServer) handleHostErrorPages(w http.ResponseWriter, hostname string, r *http.Request) {
+	var pages map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&pages); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if err := router.ValidateErrorPages(pages); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[HTTP-API] Setting %d error pages for host %s", len(pages), hostname)
+	if err := s.state.SetHostErrorPages(hostname, pages); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.record(r, "error-pages", hostname, fmt.Sprintf("statuses=%s", errorPageStatuses(pages)))
+	if len(pages) == 0 {
+		s.writeSuccessResponse(w, fmt.Sprintf("%s uses the global error pages", hostname), nil)
+	} else {
+		s.writeSuccessResponse(w, fmt.Sprintf("Updated error pages of %s", hostname), nil)
+	}
+}
+
+// handleErrorPages handles GET and PUT /api/error-pages, the pages of hosts
+// without their own. An empty object restores the plain text responses.
+func (s *HTTPServer) handleErrorPages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSuccessResponse(w, "", s.state.GetErrorPages())
+	case http.MethodPut:
+		var pages map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&pages); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if err := router.ValidateErrorPages(pages); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[HTTP-API] Setting %d global error pages", len(pages))
+		s.state.SetErrorPages(pages)
+		s.record(r, "error-pages", "", fmt.Sprintf("statuses=%s", errorPageStatuses(pages)))
+		if len(pages) == 0 {
+			s.writeSuccessResponse(w, "Removed the global error pages", nil)
+		} else {
+			s.writeSuccessResponse(w, "Updated the global error pages", nil)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// errorPageStatuses lists the statuses of error pages for the audit log
+func errorPageStatuses(pages map[string]string) string {
+	statuses := make([]string, 0, len(pages))
+	for status := range pages {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	return strings.Join(statuses, ",")
+}
+
+// handleAudit handles GET /api/audit. Entries can be filtered with the host,
+// action, since (RFC 3339 or a duration such as 24h) and limit parameters.
+func (s *HTTPServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.audit == nil {
+		s.writeErrorResponse(w, "Audit log is not enabled", http.StatusNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	query := audit.Query{
+		Host:   params.Get("host"),
+		Action: params.Get("action"),
+		Limit:  100,
+	}
+
+	if since := params.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			query.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			query.Since = t
+		} else {
+			s.writeErrorResponse(w, fmt.Sprintf("Invalid since %q, expected a duration like 24h or an RFC 3339 time", since), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			s.writeErrorResponse(w, fmt.Sprintf("Invalid limit %q", limit), http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+
+	// Users limited to projects see the changes to those projects' hosts and apps
+	if limitedUser(r) != nil {
+		query.Hosts = make(map[string]bool)
+		for hostname, host := range s.state.GetAllHosts() {
+			if _, project, err := s.state.GetHost(hostname); err == nil && allowsProject(r, project) {
+				query.Hosts[hostname] = true
+				query.Hosts[project+"/"+host.App] = true
+			}
+		}
 	}
 
-	s.writeSuccessResponse(w, fmt.Sprintf("Set Let's Encrypt mode to %s", mode), nil)
+	entries, err := s.audit.Entries(query)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, "", entries)
 }
 
 // handleStatus handles GET /api/status
@@ -318,13 +2373,23 @@ func (s *HTTPServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 
 	// Get host query parameter for specific host cert status
 	hostname := r.URL.Query().Get("host")
+	if hostname != "" && !s.checkHost(w, r, hostname) {
+		return
+	}
 
 	hosts := s.state.GetAllHosts()
 
 	if hostname != "" {
 		// Return status for specific host
 		if host, exists := hosts[hostname]; exists {
-			s.writeSuccessResponse(w, "", host.Certificate)
+			status := certificateStatus(host)
+			// Tell what stops a certificate that isn't issued yet
+			if response, ok := status.(*CertificateStatusResponse); ok && response.Status != "active" && s.certManager != nil {
+				ctx, cancel := context.WithTimeout(r.Context(), precheckTimeout)
+				response.Precheck = s.certManager.Precheck(ctx, hostname)
+				cancel()
+			}
+			s.writeSuccessResponse(w, "", status)
 		} else {
 			s.writeErrorResponse(w, "Host not found", http.StatusNotFound)
 		}
@@ -332,12 +2397,637 @@ func (s *HTTPServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 		// Return status for all hosts
 		certStatuses := make(map[string]interface{})
 		for hostName, host := range hosts {
-			certStatuses[hostName] = host.Certificate
+			if !s.allowsHost(r, hostName) {
+				continue
+			}
+			certStatuses[hostName] = certificateStatus(host)
 		}
 		s.writeSuccessResponse(w, "", certStatuses)
 	}
 }
 
+// CertificateStatusResponse is a host's certificate status, flagging hosts
+// served a temporary self-signed certificate and, for a host still waiting
+// for one, the DNS problems the CA would refuse it for
+type CertificateStatusResponse struct {
+	*state.CertificateStatus
+	SelfSigned bool     `json:"self_signed,omitempty"`
+	Precheck   []string `json:"precheck,omitempty"` // DNS changes needed before the CA issues, single host only
+}
+
+// certificateStatus returns the status reported for a host, nil without a certificate
+func certificateStatus(host *state.Host) interface{} {
+	if host.Certificate == nil {
+		return nil
+	}
+	return &CertificateStatusResponse{
+		CertificateStatus: host.Certificate,
+		SelfSigned:        cert.ServesSelfSigned(host),
+	}
+}
+
+// handleNotifications handles GET and PUT /api/notifications
+func (s *HTTPServer) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeSuccessResponse(w, "", s.state.GetNotifications())
+	case http.MethodPut:
+		var targets []*state.NotificationTarget
+		if err := json.NewDecoder(r.Body).Decode(&targets); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		for _, target := range targets {
+			if target.URL == "" {
+				s.writeErrorResponse(w, "Notification target is missing a url", http.StatusBadRequest)
+				return
+			}
+			if target.Type != "slack" && target.Type != "discord" && target.Type != "webhook" {
+				s.writeErrorResponse(w, fmt.Sprintf("Unknown notification type %q", target.Type), http.StatusBadRequest)
+				return
+			}
+		}
+
+		log.Printf("[HTTP-API] Setting %d notification targets", len(targets))
+		s.state.SetNotifications(targets)
+		s.record(r, "notifications", "", fmt.Sprintf("targets=%d", len(targets)))
+		s.writeSuccessResponse(w, fmt.Sprintf("Configured %d notification targets", len(targets)), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleStats handles GET /api/stats
+func (s *HTTPServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.stats == nil {
+		s.writeErrorResponse(w, "Container stats are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	samples := s.stats.Samples()
+	if limitedUser(r) != nil {
+		allowed := samples[:0]
+		for _, sample := range samples {
+			if allowsProject(r, sample.Project) {
+				allowed = append(allowed, sample)
+			}
+		}
+		samples = allowed
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("%d containers", len(samples)), samples)
+}
+
+// AppStats is what an app's hosts served since the proxy started, with the
+// current usage of its containers. Request rates, error rates and latency
+// percentiles come from the difference between two readings.
+type AppStats struct {
+	Project string   `json:"project"`
+	App     string   `json:"app"`
+	Hosts   []string `json:"hosts,omitempty"`
+	router.RequestStats
+	Containers       int     `json:"containers"`
+	CPUPercent       float64 `json:"cpu_percent"` // Summed over the containers, 100 is one full core
+	MemoryBytes      uint64  `json:"memory_bytes"`
+	MemoryLimitBytes uint64  `json:"memory_limit_bytes"`
+}
+
+// handleAppStats handles GET /api/stats/apps, combining the request counters
+// of each app's hosts with the samples of its containers
+func (s *HTTPServer) handleAppStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.stats == nil && s.requests == nil {
+		s.writeErrorResponse(w, "App stats are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	apps := make(map[state.AppRef]*AppStats)
+	appFor := func(ref state.AppRef) *AppStats {
+		app, exists := apps[ref]
+		if !exists {
+			app = &AppStats{Project: ref.Project, App: ref.App}
+			apps[ref] = app
+		}
+		return app
+	}
+
+	if s.requests != nil {
+		requests := s.requests.RequestStats()
+		for hostname, ref := range s.state.HostApps() {
+			app := appFor(ref)
+			app.Hosts = append(app.Hosts, hostname)
+			app.RequestStats.Add(requests[hostname])
+		}
+	}
+	if s.stats != nil {
+		for _, sample := range s.stats.Samples() {
+			app := appFor(state.AppRef{Project: sample.Project, App: sample.App})
+			app.Containers++
+			app.CPUPercent += sample.CPUPercent
+			app.MemoryBytes += sample.MemoryBytes
+			app.MemoryLimitBytes += sample.MemoryLimitBytes
+		}
+	}
+
+	list := make([]AppStats, 0, len(apps))
+	for _, app := range apps {
+		if !allowsProject(r, app.Project) {
+			continue
+		}
+		sort.Strings(app.Hosts)
+		list = append(list, *app)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Project != list[j].Project {
+			return list[i].Project < list[j].Project
+		}
+		return list[i].App < list[j].App
+	})
+	s.writeSuccessResponse(w, fmt.Sprintf("%d apps", len(list)), list)
+}
+
+// handleDiagnostics handles GET /api/diagnostics, checking what certificates
+// and traffic depend on. A failing check is reported in the data, not as an
+// error status, so the whole report reaches the caller.
+func (s *HTTPServer) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.diagnostics == nil {
+		s.writeErrorResponse(w, "Diagnostics are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	report := s.diagnostics.Run(r.Context())
+	s.writeSuccessResponse(w, fmt.Sprintf("Diagnostics %s", report.Status), report)
+}
+
+// AutoscaleEntry is an app's autoscaling policy with its last measured load
+type AutoscaleEntry struct {
+	state.AutoscalePolicy
+	Status *autoscale.Status `json:"status,omitempty"`
+}
+
+// handleAutoscale handles GET, PUT and DELETE /api/autoscale. DELETE takes the
+// policy's project and app as query parameters.
+func (s *HTTPServer) handleAutoscale(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		statuses := make(map[string]autoscale.Status)
+		if s.autoscaler != nil {
+			for _, status := range s.autoscaler.Status() {
+				statuses[status.Project+"/"+status.App] = status
+			}
+		}
+
+		policies := s.state.GetAutoscalePolicies()
+		entries := make([]AutoscaleEntry, 0, len(policies))
+		for _, policy := range policies {
+			if !allowsProject(r, policy.Project) {
+				continue
+			}
+			entry := AutoscaleEntry{AutoscalePolicy: policy}
+			if status, ok := statuses[policy.Key()]; ok {
+				entry.Status = &status
+			}
+			entries = append(entries, entry)
+		}
+		s.writeSuccessResponse(w, "", entries)
+	case http.MethodPut:
+		var policy state.AutoscalePolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if err := autoscale.Validate(&policy); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !s.checkProject(w, r, policy.Project) {
+			return
+		}
+
+		log.Printf("[HTTP-API] Autoscaling %s between %d and %d replicas", policy.Key(), policy.MinReplicas, policy.MaxReplicas)
+		s.state.SetAutoscalePolicy(&policy)
+		s.record(r, "autoscale.set", "", fmt.Sprintf("%s min=%d max=%d cpu=%g rate=%g latency=%dms",
+			policy.Key(), policy.MinReplicas, policy.MaxReplicas, policy.TargetCPU, policy.TargetRequestRate, policy.TargetLatencyMs))
+		s.writeSuccessResponse(w, fmt.Sprintf("Autoscaling %s between %d and %d replicas", policy.Key(), policy.MinReplicas, policy.MaxReplicas), nil)
+	case http.MethodDelete:
+		project, app := r.URL.Query().Get("project"), r.URL.Query().Get("app")
+		if project == "" || app == "" {
+			s.writeErrorResponse(w, "Missing project or app parameter", http.StatusBadRequest)
+			return
+		}
+		if !s.checkProject(w, r, project) {
+			return
+		}
+
+		log.Printf("[HTTP-API] Removing autoscaling policy for %s/%s", project, app)
+		if err := s.state.RemoveAutoscalePolicy(project, app); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.record(r, "autoscale.remove", "", project+"/"+app)
+		s.writeSuccessResponse(w, fmt.Sprintf("Stopped autoscaling %s/%s", project, app), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DiscoveryRequest replaces the service records of a project
+type DiscoveryRequest struct {
+	Project string                `json:"project"`
+	Records []state.ServiceRecord `json:"records"`
+}
+
+// handleDiscovery handles GET and PUT /api/discovery. PUT replaces all
+// records of a project, an empty list removes them.
+func (s *HTTPServer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		records := []state.ServiceRecord{}
+		for _, record := range s.state.GetServiceRecords() {
+			if allowsProject(r, record.Project) {
+				records = append(records, record)
+			}
+		}
+		s.writeSuccessResponse(w, "", records)
+	case http.MethodPut:
+		var req DiscoveryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if req.Project == "" {
+			s.writeErrorResponse(w, "Missing project", http.StatusBadRequest)
+			return
+		}
+		if !s.checkProject(w, r, req.Project) {
+			return
+		}
+		names := make([]string, 0, len(req.Records))
+		for _, record := range req.Records {
+			if err := discovery.ValidateRecord(record); err != nil {
+				s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			names = append(names, record.Name)
+		}
+
+		log.Printf("[HTTP-API] Setting %d service records for %s", len(req.Records), req.Project)
+		s.state.SetServiceRecords(req.Project, req.Records)
+		// Containers resolve through the project's network
+		if s.networks != nil {
+			s.networks.Changed()
+		}
+		s.record(r, "discovery.set", "", fmt.Sprintf("%s services=%s", req.Project, strings.Join(names, ",")))
+		s.writeSuccessResponse(w, fmt.Sprintf("Resolving %d services of %s", len(req.Records), req.Project), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// QuotaEntry is a project's quota, if it has one, with what it currently runs
+type QuotaEntry struct {
+	Project string       `json:"project"`
+	Quota   *state.Quota `json:"quota,omitempty"`
+	Usage   *quota.Usage `json:"usage,omitempty"`
+}
+
+// QuotaCheckRequest asks whether a deploy of a project's services fits its quota
+type QuotaCheckRequest struct {
+	Project   string           `json:"project"`
+	Workloads []quota.Workload `json:"workloads"`
+}
+
+// handleQuotas handles GET, PUT and DELETE /api/quotas. GET lists every
+// quota, or with a project parameter that project's even without a quota.
+// DELETE takes the project as a query parameter.
+func (s *HTTPServer) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var projects []string
+		if project := r.URL.Query().Get("project"); project != "" {
+			if !s.checkProject(w, r, project) {
+				return
+			}
+			projects = []string{project}
+		} else {
+			for _, q := range s.state.GetQuotas() {
+				if allowsProject(r, q.Project) {
+					projects = append(projects, q.Project)
+				}
+			}
+		}
+
+		entries := make([]QuotaEntry, 0, len(projects))
+		for _, project := range projects {
+			entry := QuotaEntry{Project: project, Quota: s.state.GetQuota(project)}
+			if s.quotas != nil {
+				usage, err := s.quotas.Usage(r.Context(), project)
+				if err != nil {
+					log.Printf("[HTTP-API] Failed to measure usage of %s: %v", project, err)
+				} else {
+					entry.Usage = &usage
+				}
+			}
+			entries = append(entries, entry)
+		}
+		s.writeSuccessResponse(w, "", entries)
+	case http.MethodPut:
+		var q state.Quota
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if err := quota.Validate(&q); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[HTTP-API] Setting quota of %s", q.Project)
+		s.state.SetQuota(&q)
+		s.record(r, "quota.set", "", fmt.Sprintf("%s containers=%d memory=%d cpus=%g", q.Project, q.MaxContainers, q.MaxMemory, q.MaxCPUs))
+		s.writeSuccessResponse(w, fmt.Sprintf("Set quota of %s", q.Project), nil)
+	case http.MethodDelete:
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			s.writeErrorResponse(w, "Missing project parameter", http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[HTTP-API] Removing quota of %s", project)
+		if err := s.state.RemoveQuota(project); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.record(r, "quota.remove", "", project)
+		s.writeSuccessResponse(w, fmt.Sprintf("Removed quota of %s", project), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleQuotaCheck handles POST /api/quotas/check. A deploy that doesn't fit
+// is answered with 409 and what it would exceed.
+func (s *HTTPServer) handleQuotaCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.quotas == nil {
+		s.writeErrorResponse(w, "Quotas are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	var req QuotaCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if req.Project == "" {
+		s.writeErrorResponse(w, "Missing project", http.StatusBadRequest)
+		return
+	}
+	if !s.checkProject(w, r, req.Project) {
+		return
+	}
+
+	usage, err := s.quotas.Check(r.Context(), req.Project, req.Workloads)
+	if errors.Is(err, quota.ErrExceeded) {
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.writeErrorResponse(w, fmt.Sprintf("Failed to measure usage of %s: %v", req.Project, err), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, fmt.Sprintf("Deploy fits the quota of %s", req.Project), usage)
+}
+
+// statusPageHealthPath is answered by the status page server for health checks
+const statusPageHealthPath = "/healthz"
+
+// handleStatusPages handles GET/PUT/DELETE /api/status-pages. Setting a page
+// deploys its host, routed to the status page server, and removing it
+// removes the host.
+func (s *HTTPServer) handleStatusPages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		project := r.URL.Query().Get("project")
+		pages := make([]state.StatusPage, 0)
+		for _, page := range s.state.GetStatusPages() {
+			if (project == "" || page.Project == project) && allowsProject(r, page.Project) {
+				pages = append(pages, page)
+			}
+		}
+		s.writeSuccessResponse(w, "", pages)
+	case http.MethodPut:
+		var page state.StatusPage
+		if err := json.NewDecoder(r.Body).Decode(&page); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if page.Project == "" || page.Host == "" {
+			s.writeErrorResponse(w, "Missing project or host", http.StatusBadRequest)
+			return
+		}
+		if state.IsHostPattern(page.Host) {
+			s.writeErrorResponse(w, fmt.Sprintf("Status page host %s must be a hostname, not a pattern", page.Host), http.StatusBadRequest)
+			return
+		}
+		if !s.checkProject(w, r, page.Project) {
+			return
+		}
+		if host, project, err := s.state.GetHost(page.Host); err == nil && host.Target != state.StatusPageTarget {
+			s.writeErrorResponse(w, fmt.Sprintf("%s is already deployed as a host of project %s", page.Host, project), http.StatusConflict)
+			return
+		}
+
+		previous := s.state.GetStatusPage(page.Project)
+		if err := s.state.SetStatusPage(&page); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if previous != nil && previous.Host != page.Host {
+			s.state.RemoveHost(previous.Host)
+		}
+		spec := &state.HostSpec{Target: state.StatusPageTarget, App: state.StatusPageApp, HealthPath: statusPageHealthPath, SSL: page.SSL}
+		if _, _, err := s.state.PutHost(page.Host, page.Project, spec, state.Precondition{}); err != nil {
+			s.writeErrorResponse(w, fmt.Sprintf("Failed to deploy %s: %v", page.Host, err), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("[HTTP-API] Setting status page of %s at %s", page.Project, page.Host)
+		s.record(r, "status_page.set", page.Host, page.Project)
+		s.writeSuccessResponse(w, fmt.Sprintf("Set status page of %s at %s", page.Project, page.Host), nil)
+	case http.MethodDelete:
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			s.writeErrorResponse(w, "Missing project parameter", http.StatusBadRequest)
+			return
+		}
+		if !s.checkProject(w, r, project) {
+			return
+		}
+
+		page, err := s.state.RemoveStatusPage(project)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.state.RemoveHost(page.Host)
+
+		log.Printf("[HTTP-API] Removing status page of %s", project)
+		s.record(r, "status_page.remove", page.Host, project)
+		s.writeSuccessResponse(w, fmt.Sprintf("Removed status page of %s", project), nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// IncidentEntry is an incident with the project whose status page it is on
+type IncidentEntry struct {
+	Project string `json:"project"`
+	state.Incident
+}
+
+// OpenIncidentRequest opens an incident on a project's status page
+type OpenIncidentRequest struct {
+	Project string   `json:"project"`
+	Title   string   `json:"title"`
+	Status  string   `json:"status,omitempty"` // Defaults to investigating
+	Message string   `json:"message,omitempty"`
+	Apps    []string `json:"apps,omitempty"`
+}
+
+// IncidentUpdateRequest posts an update on an incident
+type IncidentUpdateRequest struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// handleIncidents handles GET /api/incidents, listing the incidents of the
+// status pages newest first, and POST /api/incidents
+func (s *HTTPServer) handleIncidents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		project := r.URL.Query().Get("project")
+		incidents := make([]IncidentEntry, 0)
+		for _, page := range s.state.GetStatusPages() {
+			if (project != "" && page.Project != project) || !allowsProject(r, page.Project) {
+				continue
+			}
+			for _, incident := range page.Incidents {
+				incidents = append(incidents, IncidentEntry{Project: page.Project, Incident: *incident})
+			}
+		}
+		sort.SliceStable(incidents, func(i, j int) bool { return incidents[i].CreatedAt.After(incidents[j].CreatedAt) })
+		s.writeSuccessResponse(w, fmt.Sprintf("%d incidents", len(incidents)), incidents)
+	case http.MethodPost:
+		var req OpenIncidentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if req.Project == "" || req.Title == "" {
+			s.writeErrorResponse(w, "Missing project or title", http.StatusBadRequest)
+			return
+		}
+		if req.Status == "" {
+			req.Status = state.IncidentInvestigating
+		}
+		if !s.checkProject(w, r, req.Project) {
+			return
+		}
+
+		incident, err := s.state.OpenIncident(req.Project, req.Title, req.Status, req.Message, req.Apps)
+		if err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("[HTTP-API] Opened incident %s on the status page of %s: %s", incident.ID, req.Project, req.Title)
+		s.record(r, "incident.open", "", fmt.Sprintf("%s %s %s", req.Project, incident.ID, req.Title))
+		s.writeSuccessResponse(w, fmt.Sprintf("Opened incident %s", incident.ID), IncidentEntry{Project: req.Project, Incident: *incident})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleIncident handles POST /api/incidents/:id/updates and DELETE /api/incidents/:id
+func (s *HTTPServer) handleIncident(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/incidents/"), "/")
+	switch {
+	case action == "updates" && r.Method == http.MethodPost:
+	case action == "" && r.Method == http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, project, err := s.state.GetIncident(id)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !s.checkProject(w, r, project) {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := s.state.RemoveIncident(id); err != nil {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("[HTTP-API] Removed incident %s from the status page of %s", id, project)
+		s.record(r, "incident.remove", "", fmt.Sprintf("%s %s", project, id))
+		s.writeSuccessResponse(w, fmt.Sprintf("Removed incident %s", id), nil)
+		return
+	}
+
+	var req IncidentUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	incident, err := s.state.UpdateIncident(id, req.Status, req.Message)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[HTTP-API] Incident %s of %s is %s", id, project, incident.Status)
+	s.record(r, "incident.update", "", fmt.Sprintf("%s %s %s", project, id, incident.Status))
+	s.writeSuccessResponse(w, fmt.Sprintf("Incident %s is %s", id, incident.Status), IncidentEntry{Project: project, Incident: *incident})
+}
+
+// handleMetrics handles GET /metrics in the Prometheus text format
+func (s *HTTPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var samples []stats.Sample
+	if s.stats != nil {
+		samples = s.stats.Samples()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := stats.WritePrometheus(w, samples); err != nil {
+		log.Printf("[HTTP-API] Failed to write metrics: %v", err)
+	}
+}
+
 // handleSwitchTarget handles PATCH /api/hosts/:host
 func (s *HTTPServer) handleSwitchTarget(w http.ResponseWriter, hostname string, r *http.Request) {
 	var req map[string]string
@@ -354,11 +3044,23 @@ func (s *HTTPServer) handleSwitchTarget(w http.ResponseWriter, hostname string,
 
 	log.Printf("[HTTP-API] SwitchTarget request for host %s to target %s", hostname, target)
 
+	previousTarget := ""
+	if existing, _, err := s.state.GetHost(hostname); err == nil {
+		previousTarget = existing.Target
+	}
+
 	if err := s.state.SwitchTarget(hostname, target); err != nil {
 		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	s.publish(core.TrafficSwitched{
+		BaseEvent:  core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+		FromTarget: previousTarget,
+		ToTarget:   target,
+	})
+
+	s.record(r, "switch", hostname, fmt.Sprintf("%s -> %s", previousTarget, target))
 	s.writeSuccessResponse(w, fmt.Sprintf("Switched %s to target %s", hostname, target), nil)
 }
 
@@ -373,6 +3075,18 @@ func (s *HTTPServer) writeSuccessResponse(w http.ResponseWriter, message string,
 	json.NewEncoder(w).Encode(response)
 }
 
+// writeCreatedResponse is writeSuccessResponse for requests that created a resource
+func (s *HTTPServer) writeCreatedResponse(w http.ResponseWriter, message string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	response := HTTPResponse{
+		Success: true,
+		Message: message,
+		Data:    data,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
 func (s *HTTPServer) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
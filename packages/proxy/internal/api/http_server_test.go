@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/deployment"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/elitan/iop/proxy/internal/health"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker records the network and container changes a bake makes
+type fakeDocker struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeDocker) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeDocker) ConnectNetworkWithAliases(ctx context.Context, network, id string, aliases []string) error {
+	f.record("connect " + id + " " + strings.Join(aliases, ","))
+	return nil
+}
+
+func (f *fakeDocker) DisconnectNetwork(ctx context.Context, network, id string) error {
+	f.record("disconnect " + id)
+	return nil
+}
+
+func (f *fakeDocker) Remove(ctx context.Context, id string, timeout time.Duration) error {
+	f.record("remove " + id)
+	return nil
+}
+
+func (f *fakeDocker) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// fakeRequestStats serves request counters set by the test
+type fakeRequestStats struct {
+	mu    sync.Mutex
+	stats map[string]router.RequestStats
+}
+
+func (f *fakeRequestStats) RequestStats() map[string]router.RequestStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := make(map[string]router.RequestStats, len(f.stats))
+	for host, s := range f.stats {
+		stats[host] = s
+	}
+	return stats
+}
+
+func (f *fakeRequestStats) add(hostname string, requests, errors uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.stats[hostname]
+	s.Requests += requests
+	s.Errors += errors
+	f.stats[hostname] = s
+}
+
+func TestDeployBakeRollsBack(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	s := NewHTTPServer(st, nil, health.NewChecker(st))
+	bus := events.NewSimpleBus()
+	s.SetEventBus(bus)
+	docker := &fakeDocker{}
+	stats := &fakeRequestStats{stats: map[string]router.RequestStats{"blog.example.com": {Requests: 500, Errors: 50}}}
+	s.SetBaker(deployment.NewBaker(docker, stats, bus))
+
+	published := bus.Subscribe()
+
+	deploy := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/deploy", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.handleDeploy(rec, req)
+		return rec
+	}
+
+	invalid := deploy(`{"host":"blog.example.com","target":"blog-web:3000","project":"blog","bake":{"duration":"soon","network":"blog-network","aliases":["web"],"containers":["blog-web-green"],"previous":["blog-web-blue"]}}`)
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	assert.Contains(t, invalid.Body.String(), "Invalid bake duration")
+
+	rec := deploy(`{"host":"blog.example.com","target":"blog-web:3000","project":"blog",
+		"bake":{"duration":"1m","interval":"10ms","max_error_rate":0.05,"min_requests":20,
+			"network":"blog-network","aliases":["web","blog-web"],
+			"color":"green","containers":["blog-web-green"],"previous_color":"blue","previous":["blog-web-blue"]}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Errors from before the switch don't count, these do
+	stats.add("blog.example.com", 50, 20)
+
+	deadline := time.After(time.Second)
+	for rolledBack := false; !rolledBack; {
+		select {
+		case event := <-published:
+			e, ok := event.(core.DeploymentRolledBack)
+			if !ok {
+				continue
+			}
+			rolledBack = true
+			assert.Equal(t, "blog.example.com", e.Hostname)
+			assert.Equal(t, core.Green, e.FromColor)
+			assert.Equal(t, core.Blue, e.ToColor)
+			assert.Equal(t, "error rate 40.0% over 50 requests exceeds 5.0%", e.Reason)
+		case <-deadline:
+			t.Fatal("expected a DeploymentRolledBack event")
+		}
+	}
+
+	// The previous containers take the aliases back before the new ones go
+	assert.Equal(t, []string{
+		"disconnect blog-web-blue",
+		"connect blog-web-blue web,blog-web",
+		"disconnect blog-web-green",
+		"remove blog-web-green",
+	}, docker.Calls())
+}
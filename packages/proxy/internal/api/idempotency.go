@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// IdempotencyKeyHeader lets a client retry a write safely: repeating a key
+// replays the first response instead of making the change again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ReplayedHeader marks a response replayed for a repeated idempotency key
+const ReplayedHeader = "Idempotent-Replayed"
+
+// idempotencyTTL is how long a key's response is kept for replays
+const idempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength bounds keys, they're held in memory until they expire
+const maxIdempotencyKeyLength = 255
+
+// idempotentResponse is the outcome of the first request with a key
+type idempotentResponse struct {
+	bodyHash string
+	done     bool // False while the first request is still running
+	status   int
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+// recordingWriter passes a response through while keeping a copy to replay
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// idempotent replays the response of writes that repeat an Idempotency-Key.
+// Keys are scoped to the user, method and path, and kept in memory for a
+// day, so a restart forgets them. Reusing a key with a different body is
+// rejected, and so is a retry while the first request is still running.
+// Server errors aren't kept, the retry runs again.
+func (s *HTTPServer) idempotent(next http.Handler) http.Handler {
+	var mu sync.Mutex
+	responses := make(map[string]*idempotentResponse)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			s.writeErrorResponse(w, fmt.Sprintf("%s is longer than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeErrorResponse(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		bodyHash := hex.EncodeToString(sum[:])
+
+		scope := ""
+		if user, ok := r.Context().Value(userKey).(*state.User); ok {
+			scope = user.Name
+		}
+		scope = fmt.Sprintf("%s %s %s %s", scope, r.Method, r.URL.Path, key)
+
+		mu.Lock()
+		now := time.Now()
+		for k, response := range responses {
+			if response.done && now.After(response.expires) {
+				delete(responses, k)
+			}
+		}
+		previous := responses[scope]
+		if previous == nil {
+			responses[scope] = &idempotentResponse{bodyHash: bodyHash}
+		}
+		mu.Unlock()
+
+		switch {
+		case previous == nil:
+		case previous.bodyHash != bodyHash:
+			s.writeErrorResponse(w, fmt.Sprintf("%s %s was already used with a different request body", IdempotencyKeyHeader, key), http.StatusUnprocessableEntity)
+			return
+		case !previous.done:
+			s.writeErrorResponse(w, fmt.Sprintf("A request with %s %s is still in progress", IdempotencyKeyHeader, key), http.StatusConflict)
+			return
+		default:
+			for name, values := range previous.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(previous.status)
+			w.Write(previous.body)
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if recorder.status >= http.StatusInternalServerError {
+				delete(responses, scope)
+				return
+			}
+			responses[scope] = &idempotentResponse{
+				bodyHash: bodyHash,
+				done:     true,
+				status:   recorder.status,
+				header:   w.Header().Clone(),
+				body:     recorder.body.Bytes(),
+				expires:  time.Now().Add(idempotencyTTL),
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotent(t *testing.T) {
+	s := NewHTTPServer(state.NewState(filepath.Join(t.TempDir(), "state.json")), nil, nil)
+	calls := 0
+	status := http.StatusCreated
+	handler := s.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(status)
+		w.Write([]byte("created"))
+	}))
+
+	serve := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/hosts/blog.example.com", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve("deploy-1", `{"target":"blog:3000"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+
+	// A retry replays the first response without running the handler again
+	retry := serve("deploy-1", `{"target":"blog:3000"}`)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "created", retry.Body.String())
+	assert.Equal(t, `"v1"`, retry.Header().Get("ETag"))
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+
+	// The key can't be reused for a different request
+	assert.Equal(t, http.StatusUnprocessableEntity, serve("deploy-1", `{"target":"blog:4000"}`).Code)
+	assert.Equal(t, 1, calls)
+
+	// Without a key every request runs
+	serve("", `{"target":"blog:3000"}`)
+	assert.Equal(t, 2, calls)
+
+	// Server errors aren't kept, so the retry runs again
+	status = http.StatusInternalServerError
+	serve("deploy-2", `{}`)
+	serve("deploy-2", `{}`)
+	assert.Equal(t, 4, calls)
+}
+
+func TestHostPreconditions(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	s := NewHTTPServer(st, nil, nil)
+	_, _, err := st.PutHost("blog.example.com", "blog", &state.HostSpec{Target: "blog:3000", App: "web", HealthPath: "/up"}, state.Precondition{})
+	assert.NoError(t, err)
+
+	serve := func(method, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/hosts/blog.example.com", strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		s.handleHosts(rec, req)
+		return rec
+	}
+
+	get := serve(http.MethodGet, "", nil)
+	assert.Equal(t, http.StatusOK, get.Code)
+	etag := get.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, http.StatusNotModified, serve(http.MethodGet, "", http.Header{"If-None-Match": {etag}}).Code)
+
+	// Putting the same configuration again is a no-op with the same ETag
+	put := serve(http.MethodPut, `{"project":"blog","target":"blog:3000","app":"web"}`, http.Header{"If-Match": {etag}})
+	assert.Equal(t, http.StatusOK, put.Code)
+	assert.Equal(t, etag, put.Header().Get("ETag"))
+	assert.Contains(t, put.Body.String(), "unchanged")
+
+	assert.Equal(t, http.StatusPreconditionFailed, serve(http.MethodPut, `{"project":"blog","target":"blog:3000"}`, http.Header{"If-None-Match": {"*"}}).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, serve(http.MethodDelete, "", http.Header{"If-Match": {`"stale"`}}).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "", http.Header{"If-Match": {etag}}).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "", nil).Code)
+}
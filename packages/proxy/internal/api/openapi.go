@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/elitan/iop/proxy/internal/openapi"
+)
+
+// openAPIJSON describes the API. pkg/client is generated from it, so run
+// go generate ./pkg/client after changing it.
+//
+//go:embed openapi.json
+var openAPIJSON []byte
+
+// apiSpec is the parsed specification requests are validated against
+var apiSpec = mustParseSpec()
+
+func mustParseSpec() *openapi.Spec {
+	spec, err := openapi.Parse(openAPIJSON)
+	if err != nil {
+		panic(err)
+	}
+	return spec
+}
+
+// handleOpenAPI handles GET /api/openapi.json
+func (s *HTTPServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.oai.openapi+json")
+	w.Write(openAPIJSON)
+}
+
+// validate rejects requests whose query parameters or JSON body don't match
+// the OpenAPI specification, before a handler sees them. Requests for
+// routes the specification doesn't describe are passed on unchecked and
+// answered by the mux.
+func (s *HTTPServer) validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := apiSpec.Find(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				s.writeErrorResponse(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if err := apiSpec.ValidateRequest(op, r.URL.Query(), body); err != nil {
+			log.Printf("[HTTP-API] Rejected %s %s: %v", r.Method, r.URL.Path, err)
+			s.writeErrorResponse(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
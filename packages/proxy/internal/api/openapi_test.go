@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	_, _, err := st.PutHost("blog.example.com", "blog", &state.HostSpec{Target: "blog:3000"}, state.Precondition{})
+	assert.NoError(t, err)
+	handler := NewHTTPServer(st, nil, nil).Handler()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	message := func(rec *httptest.ResponseRecorder) string {
+		var resp HTTPResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp.Message
+	}
+
+	tests := []struct {
+		name, method, target, body, message string
+	}{
+		{"missing field", http.MethodPost, "/api/deploy", `{"host":"blog.example.com","project":"blog"}`, "body.target is required"},
+		{"unknown field", http.MethodPut, "/api/staging", `{"enabled":true,"stagging":true}`, "body has unknown field stagging"},
+		{"wrong type", http.MethodPut, "/api/hosts/blog.example.com/health", `{"healthy":"yes"}`, "body.healthy must be a boolean"},
+		{"enum", http.MethodPut, "/api/tls", `{"min_version":"1.1.5"}`, "body.min_version must be one of"},
+		{"nested", http.MethodPost, "/api/apply", `{"projects":{"blog":{"blog.example.com":{"ssl":true}}}}`, "body.projects.blog.blog.example.com.target is required"},
+		{"missing body", http.MethodPut, "/api/autoscale", ``, "missing JSON body"},
+		{"invalid JSON", http.MethodPut, "/api/ports", `{"listen":`, "invalid JSON body"},
+		{"missing query parameter", http.MethodDelete, "/api/ports", ``, "missing query parameter listen"},
+		{"query parameter type", http.MethodDelete, "/api/ports?listen=ssh", ``, "listen must be an integer"},
+		{"query parameter range", http.MethodDelete, "/api/ports?listen=70000", ``, "listen must be at most 65535"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.method, tt.target, tt.body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, message(rec), "Invalid request: "+tt.message)
+		})
+	}
+
+	// Valid requests reach the handler
+	rec := serve(http.MethodPut, "/api/hosts/blog.example.com/health", `{"healthy":true}`)
+	assert.Equal(t, http.StatusOK, rec.Code, message(rec))
+
+	// The specification itself is served
+	rec = serve(http.MethodGet, "/api/openapi.json", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, openAPIJSON, rec.Body.Bytes())
+}
+
+// TestSpecMatchesRoutes checks that every operation in the specification
+// has a handler that accepts its method
+func TestSpecMatchesRoutes(t *testing.T) {
+	s := NewHTTPServer(state.NewState(filepath.Join(t.TempDir(), "state.json")), nil, nil)
+	mux := s.routes()
+	param := regexp.MustCompile(`\{[^}]+\}`)
+
+	for _, op := range apiSpec.Operations() {
+		t.Run(op.Method+" "+op.Path, func(t *testing.T) {
+			assert.NotEmpty(t, op.OperationID)
+			req := httptest.NewRequest(op.Method, param.ReplaceAllString(op.Path, "example.com"), nil)
+			rec := httptest.NewRecorder()
+			func() {
+				// Handlers that need a certificate manager or health
+				// checker panic without one, which means they were reached
+				defer func() { recover() }()
+				mux.ServeHTTP(rec, req)
+			}()
+			assert.NotEqual(t, "404 page not found\n", rec.Body.String())
+			assert.NotEqual(t, "Method not allowed\n", rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/elitan/iop/proxy/pkg/client"
+)
+
+// DefaultSocketPath is where the management API listens for local clients
+// unless IOP_PROXY_SOCKET names another path
+const DefaultSocketPath = "/var/run/iop-proxy/api.sock"
+
+// SocketPath returns the path of the management API's unix socket
+func SocketPath() string {
+	if path := os.Getenv("IOP_PROXY_SOCKET"); path != "" {
+		return path
+	}
+	return DefaultSocketPath
+}
+
+// listenUnix listens on a unix socket only its owner can connect to. A stale
+// socket left by a previous run is replaced, any other file is not.
+func listenUnix(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	// The socket is created with the umask's permissions, so it is bound in a
+	// directory only the owner can enter and moved into place once
+	// restricted. Other users can never reach it with broader permissions.
+	private, err := os.MkdirTemp(dir, ".iop-proxy-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(private)
+
+	bound := filepath.Join(private, "api.sock")
+	listener, err := net.Listen("unix", bound)
+	if err != nil {
+		return nil, err
+	}
+	// Stop removes the socket at its final path
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(bound, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	if err := os.Rename(bound, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to move socket into place: %w", err)
+	}
+	return listener, nil
+}
+
+// NewSocketClient creates an API client that connects over a unix socket
+func NewSocketClient(socketPath string) *HTTPClient {
+	return &HTTPClient{api: client.NewUnix(socketPath, envOptions()...)}
+}
+
+// NewLocalClient creates an API client for the proxy on this machine. It
+// uses the unix socket when there is one, so no other process can pose as
+// the API by taking its TCP port, and falls back to localhost:8080.
+func NewLocalClient() *HTTPClient {
+	socketPath := SocketPath()
+	if info, err := os.Stat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		return NewSocketClient(socketPath)
+	}
+	return NewHTTPClient(client.DefaultBaseURL)
+}
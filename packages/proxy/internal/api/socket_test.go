@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.sock")
+
+	listener, err := listenUnix(path)
+	require.NoError(t, err)
+	info, err := os.Lstat(path)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSocket)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	conn.Close()
+
+	// Nothing is left of the directory the socket was bound in
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// A stale socket is replaced, other files are not
+	listener.Close()
+	listener, err = listenUnix(path)
+	require.NoError(t, err)
+	listener.Close()
+
+	file := filepath.Join(dir, "state.json")
+	require.NoError(t, os.WriteFile(file, []byte("{}"), 0644))
+	_, err = listenUnix(file)
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one state-changing operation
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`         // e.g. "deploy", "remove", "switch", "cert.renew"
+	Host     string    `json:"host,omitempty"` // Host the operation applied to, empty for global settings
+	Details  string    `json:"details,omitempty"`
+	Actor    string    `json:"actor"`     // Who asked for it, as reported by the client
+	SourceIP string    `json:"source_ip"` // Where the request came from
+}
+
+// Query filters entries. Zero values match everything.
+type Query struct {
+	Host   string
+	Action string
+	Since  time.Time
+	Limit  int             // Most recent entries to return, 0 for all
+	Hosts  map[string]bool // Only entries for these hosts, nil for all
+}
+
+func (q Query) matches(e Entry) bool {
+	return (q.Host == "" || e.Host == q.Host) &&
+		(q.Hosts == nil || q.Hosts[e.Host]) &&
+		(q.Action == "" || e.Action == q.Action) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since))
+}
+
+// Log is an append-only audit log stored as one JSON entry per line
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewLog creates an audit log that appends to path
+func NewLog(path string) *Log {
+	return &Log{path: path}
+}
+
+// Record appends an entry, stamping it with the current time if it has none
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// Entries returns the entries matching q, oldest first
+func (l *Log) Entries(q Query) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip a line torn by a crash mid-write
+			continue
+		}
+		if q.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewLog(path)
+
+	entries, err := l.Entries(Query{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, l.Record(Entry{Time: start, Action: "deploy", Host: "app.example.com", Actor: "alice", SourceIP: "127.0.0.1"}))
+	require.NoError(t, l.Record(Entry{Time: start.Add(time.Minute), Action: "staging", Details: "enabled=true", Actor: "bob"}))
+	require.NoError(t, l.Record(Entry{Time: start.Add(2 * time.Minute), Action: "switch", Host: "app.example.com", Actor: "alice"}))
+	require.NoError(t, l.Record(Entry{Action: "remove", Host: "old.example.com", Actor: "ci"}))
+
+	entries, err = l.Entries(Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, "deploy", entries[0].Action)
+	assert.False(t, entries[3].Time.IsZero())
+
+	entries, err = l.Entries(Query{Host: "app.example.com"})
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	entries, err = l.Entries(Query{Action: "staging"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "bob", entries[0].Actor)
+
+	entries, err = l.Entries(Query{Since: start.Add(time.Minute), Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "switch", entries[0].Action)
+	assert.Equal(t, "remove", entries[1].Action)
+}
+
+func TestEntriesSkipsTornLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewLog(path)
+	require.NoError(t, l.Record(Entry{Action: "deploy", Host: "app.example.com"}))
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	f.WriteString(`{"time":"2024-05-01T12:00:00Z","act`)
+	f.Close()
+
+	entries, err := l.Entries(Query{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
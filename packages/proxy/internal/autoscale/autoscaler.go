@@ -0,0 +1,469 @@
+// Package autoscale adds and removes replicas of apps based on their request
+// rate, concurrency, response times and CPU usage
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/stats"
+)
+
+const (
+	// evaluateInterval is how often apps are checked against their policies
+	evaluateInterval = 30 * time.Second
+	// Cooldowns of policies that don't set their own
+	defaultScaleUpCooldown   = time.Minute
+	defaultScaleDownCooldown = 5 * time.Minute
+	// tolerance ignores load within 10% of the target so replica counts don't flap
+	tolerance = 0.1
+	// stopTimeout is how long a removed replica gets to finish its requests
+	stopTimeout = 30 * time.Second
+	// AutoscaledLabel marks the replicas the autoscaler created. Only those
+	// are removed when scaling down, the replicas of a deploy stay.
+	AutoscaledLabel = "iop.autoscaled"
+)
+
+// Docker is the part of the Docker Engine API the autoscaler uses
+type Docker interface {
+	Containers(ctx context.Context, label string) ([]docker.Container, error)
+	Clone(ctx context.Context, id, name string, labels map[string]string) (string, error)
+	Remove(ctx context.Context, id string, timeout time.Duration) error
+}
+
+// RequestMetrics reports the requests the router served per host
+type RequestMetrics interface {
+	RequestStats() map[string]router.RequestStats
+}
+
+// Usage reports the latest resource usage of app containers
+type Usage interface {
+	Samples() []stats.Sample
+}
+
+// Quotas limits how many replicas a project's quota leaves room for
+type Quotas interface {
+	Room(ctx context.Context, project string, replica docker.Container, count int) (int, error)
+}
+
+// Status is an app's load as last measured by the autoscaler
+type Status struct {
+	Project     string    `json:"project"`
+	App         string    `json:"app"`
+	Replicas    int       `json:"replicas"`
+	CPUPercent  float64   `json:"cpu_percent"`  // Average per replica
+	RequestRate float64   `json:"request_rate"` // Requests per second per replica
+	LatencyMs   float64   `json:"latency_ms"`   // Average response time
+	Concurrency float64   `json:"concurrency"`  // Average requests in flight per replica
+	InFlight    int64     `json:"in_flight"`    // Requests in flight across the app's hosts when measured
+	LastScaled  time.Time `json:"last_scaled,omitempty"`
+	LastReason  string    `json:"last_reason,omitempty"`
+}
+
+// app tracks the measurements and scaling history of one app
+type app struct {
+	requests   router.RequestStats // Totals at the last evaluation
+	measuredAt time.Time
+	lastScaled time.Time
+	status     Status
+}
+
+// Autoscaler keeps the number of replicas of each app with a policy between
+// its bounds, sized for its targets
+type Autoscaler struct {
+	state    *state.State
+	docker   Docker
+	requests RequestMetrics
+	usage    Usage
+	events   core.EventBus
+	quotas   Quotas
+
+	// now is replaced in tests
+	now func() time.Time
+
+	mu   sync.Mutex
+	apps map[string]*app // Keyed by project/app
+}
+
+// New creates an autoscaler for the policies in state
+func New(st *state.State, docker Docker, requests RequestMetrics, usage Usage, events core.EventBus) *Autoscaler {
+	return &Autoscaler{
+		state:    st,
+		docker:   docker,
+		requests: requests,
+		usage:    usage,
+		events:   events,
+		now:      time.Now,
+		apps:     make(map[string]*app),
+	}
+}
+
+// SetQuotas makes the autoscaler stay within project quotas when adding replicas
+func (a *Autoscaler) SetQuotas(quotas Quotas) {
+	a.quotas = quotas
+}
+
+// Validate checks that a policy can be applied
+func Validate(policy *state.AutoscalePolicy) error {
+	if policy.Project == "" || policy.App == "" {
+		return fmt.Errorf("project and app are required")
+	}
+	if policy.MinReplicas < 1 {
+		return fmt.Errorf("min replicas must be at least 1")
+	}
+	if policy.MaxReplicas < policy.MinReplicas {
+		return fmt.Errorf("max replicas can't be less than min replicas")
+	}
+	if policy.TargetCPU < 0 || policy.TargetRequestRate < 0 || policy.TargetLatencyMs < 0 || policy.TargetConcurrency < 0 {
+		return fmt.Errorf("targets can't be negative")
+	}
+	if policy.TargetCPU == 0 && policy.TargetRequestRate == 0 && policy.TargetLatencyMs == 0 && policy.TargetConcurrency == 0 {
+		return fmt.Errorf("at least one of target CPU, request rate, latency or concurrency is required")
+	}
+	for _, cooldown := range []string{policy.ScaleUpCooldown, policy.ScaleDownCooldown} {
+		if cooldown == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(cooldown); err != nil || d < 0 {
+			return fmt.Errorf("invalid cooldown %q, expected e.g. 30s or 5m", cooldown)
+		}
+	}
+	return nil
+}
+
+// Run evaluates every policy until the context is cancelled
+func (a *Autoscaler) Run(ctx context.Context) {
+	log.Println("[AUTOSCALE] Starting autoscaler")
+
+	ticker := time.NewTicker(evaluateInterval)
+	defer ticker.Stop()
+
+	lastErr := ""
+	for {
+		select {
+		case <-ticker.C:
+			// Only log a failure once, e.g. when the Docker socket isn't mounted
+			if err := a.Evaluate(ctx); err != nil && err.Error() != lastErr && ctx.Err() == nil {
+				log.Printf("[AUTOSCALE] Failed to evaluate policies: %v", err)
+				lastErr = err.Error()
+			}
+		case <-ctx.Done():
+			log.Println("[AUTOSCALE] Stopping autoscaler")
+			return
+		}
+	}
+}
+
+// Evaluate measures every app with a policy once and scales it if needed
+func (a *Autoscaler) Evaluate(ctx context.Context) error {
+	policies := a.state.GetAutoscalePolicies()
+
+	a.mu.Lock()
+	keys := make(map[string]bool, len(policies))
+	for _, policy := range policies {
+		keys[policy.Key()] = true
+	}
+	for key := range a.apps {
+		if !keys[key] {
+			delete(a.apps, key)
+		}
+	}
+	a.mu.Unlock()
+
+	if len(policies) == 0 {
+		return nil
+	}
+
+	containers, err := a.docker.Containers(ctx, "iop.managed=true")
+	if err != nil {
+		return err
+	}
+	replicas := make(map[string][]docker.Container)
+	for _, container := range containers {
+		if container.Labels["iop.type"] != "service" {
+			continue
+		}
+		app := container.Labels["iop.app"]
+		if app == "" {
+			app = container.Labels["iop.service"]
+		}
+		key := container.Labels["iop.project"] + "/" + app
+		replicas[key] = append(replicas[key], container)
+	}
+
+	samples := make(map[string]stats.Sample)
+	if a.usage != nil {
+		for _, sample := range a.usage.Samples() {
+			samples[sample.Container] = sample
+		}
+	}
+	requests := a.requests.RequestStats()
+
+	for _, policy := range policies {
+		a.evaluate(ctx, policy, replicas[policy.Key()], samples, requests)
+	}
+	return nil
+}
+
+// evaluate measures an app's load per replica and scales it towards the
+// number of replicas that brings the busiest metric back to its target
+func (a *Autoscaler) evaluate(ctx context.Context, policy state.AutoscalePolicy, replicas []docker.Container, samples map[string]stats.Sample, requests map[string]router.RequestStats) {
+	key := policy.Key()
+	if len(replicas) == 0 {
+		return
+	}
+
+	// A deploy runs the old and new color side by side until it switches over
+	colors := make(map[string]bool)
+	for _, replica := range replicas {
+		colors[replica.Labels["iop.color"]] = true
+	}
+	if len(colors) > 1 {
+		log.Printf("[AUTOSCALE] [%s] Deployment in progress, skipping", key)
+		return
+	}
+
+	hostnames := a.state.AppHosts(policy.Project, policy.App)
+	var total router.RequestStats
+	for _, hostname := range hostnames {
+		s := requests[hostname]
+		total.Requests += s.Requests
+		total.Latency += s.Latency
+		total.InFlight += s.InFlight
+	}
+
+	now := a.now()
+	current := len(replicas)
+
+	a.mu.Lock()
+	tracked, exists := a.apps[key]
+	if !exists {
+		tracked = &app{}
+		a.apps[key] = tracked
+	}
+	previous, measuredAt := tracked.requests, tracked.measuredAt
+	tracked.requests, tracked.measuredAt = total, now
+	a.mu.Unlock()
+
+	// Rates need two readings, and totals drop when one of the hosts is removed
+	if measuredAt.IsZero() || total.Requests < previous.Requests {
+		return
+	}
+
+	// The time spent serving requests per second of wall time is the
+	// average number of requests in flight
+	status := Status{Project: policy.Project, App: policy.App, Replicas: current, InFlight: total.InFlight}
+	if elapsed := now.Sub(measuredAt); elapsed > 0 {
+		status.RequestRate = float64(total.Requests-previous.Requests) / elapsed.Seconds() / float64(current)
+		status.Concurrency = float64(total.Latency-previous.Latency) / float64(elapsed) / float64(current)
+	}
+	if served := total.Requests - previous.Requests; served > 0 {
+		status.LatencyMs = float64(total.Latency-previous.Latency) / float64(served) / float64(time.Millisecond)
+	}
+
+	sampled := 0
+	for _, replica := range replicas {
+		if sample, ok := samples[replica.Name]; ok {
+			status.CPUPercent += sample.CPUPercent
+			sampled++
+		}
+	}
+	if sampled > 0 {
+		status.CPUPercent /= float64(sampled)
+	}
+
+	// The busiest metric relative to its target decides
+	ratio, reason := -1.0, ""
+	consider := func(value, target float64, format string) {
+		if target > 0 && value/target > ratio {
+			ratio = value / target
+			reason = fmt.Sprintf(format, value, target)
+		}
+	}
+	if sampled > 0 {
+		consider(status.CPUPercent, policy.TargetCPU, "cpu %.0f%% (target %.0f%%)")
+	}
+	consider(status.RequestRate, policy.TargetRequestRate, "%.1f requests/s per replica (target %.1f)")
+	consider(status.Concurrency, policy.TargetConcurrency, "%.1f concurrent requests per replica (target %.1f)")
+	if total.Requests > previous.Requests {
+		consider(status.LatencyMs, float64(policy.TargetLatencyMs), "latency %.0fms (target %.0fms)")
+	}
+
+	desired := current
+	if ratio >= 0 && math.Abs(ratio-1) > tolerance {
+		desired = int(math.Ceil(float64(current) * ratio))
+	}
+	if desired < policy.MinReplicas {
+		desired = policy.MinReplicas
+		if current < desired {
+			reason = fmt.Sprintf("below minimum of %d replicas", policy.MinReplicas)
+		}
+	}
+	if desired > policy.MaxReplicas {
+		desired = policy.MaxReplicas
+		if current > desired {
+			reason = fmt.Sprintf("above maximum of %d replicas", policy.MaxReplicas)
+		}
+	}
+
+	a.mu.Lock()
+	status.LastScaled, status.LastReason = tracked.status.LastScaled, tracked.status.LastReason
+	tracked.status = status
+	lastScaled := tracked.lastScaled
+	a.mu.Unlock()
+
+	cooldown := cooldownOf(policy.ScaleUpCooldown, defaultScaleUpCooldown)
+	if desired < current {
+		cooldown = cooldownOf(policy.ScaleDownCooldown, defaultScaleDownCooldown)
+	}
+	if desired == current || now.Sub(lastScaled) < cooldown {
+		return
+	}
+
+	var scaled int
+	if desired > current {
+		scaled = current + a.scaleUp(ctx, policy.Project, key, replicas, desired-current)
+	} else {
+		scaled = current - a.scaleDown(ctx, key, replicas, current-desired)
+	}
+	if scaled == current {
+		return
+	}
+
+	log.Printf("[AUTOSCALE] [%s] Scaled from %d to %d replicas: %s", key, current, scaled, reason)
+
+	a.mu.Lock()
+	tracked.lastScaled = now
+	tracked.status.Replicas = scaled
+	tracked.status.LastScaled, tracked.status.LastReason = now, reason
+	a.mu.Unlock()
+
+	if a.events != nil {
+		hostname := ""
+		if len(hostnames) > 0 {
+			hostname = hostnames[0]
+		}
+		a.events.Publish(core.Scaled{
+			BaseEvent: core.BaseEvent{Timestamp: now, Hostname: hostname},
+			Project:   policy.Project,
+			App:       policy.App,
+			From:      current,
+			To:        scaled,
+			Reason:    reason,
+		})
+	}
+}
+
+// scaleUp clones the newest replica a deploy created and returns how many
+// replicas were added. Clones share its network alias, so the proxy's
+// requests spread across them.
+func (a *Autoscaler) scaleUp(ctx context.Context, project, key string, replicas []docker.Container, count int) int {
+	template := replicas[0]
+	names := make(map[string]bool, len(replicas))
+	for _, replica := range replicas {
+		names[replica.Name] = true
+		if betterTemplate(replica, template) {
+			template = replica
+		}
+	}
+
+	if a.quotas != nil {
+		room, err := a.quotas.Room(ctx, project, template, count)
+		if err != nil {
+			log.Printf("[AUTOSCALE] [%s] Failed to check the quota of %s: %v", key, project, err)
+			return 0
+		}
+		if room < count {
+			log.Printf("[AUTOSCALE] [%s] Quota of %s leaves room for %d of %d replicas", key, project, room, count)
+			count = room
+		}
+	}
+
+	added := 0
+	for i := 1; added < count; i++ {
+		name := fmt.Sprintf("%s-scale-%d", template.Name, i)
+		if names[name] {
+			continue
+		}
+		if _, err := a.docker.Clone(ctx, template.ID, name, map[string]string{AutoscaledLabel: "true"}); err != nil {
+			log.Printf("[AUTOSCALE] [%s] Failed to add replica %s: %v", key, name, err)
+			break
+		}
+		names[name] = true
+		added++
+	}
+	return added
+}
+
+// scaleDown removes the newest autoscaled replicas and returns how many were removed
+func (a *Autoscaler) scaleDown(ctx context.Context, key string, replicas []docker.Container, count int) int {
+	var candidates []docker.Container
+	for _, replica := range replicas {
+		if isAutoscaled(replica) {
+			candidates = append(candidates, replica)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Created.After(candidates[j].Created) })
+
+	removed := 0
+	for _, replica := range candidates {
+		if removed == count {
+			break
+		}
+		if err := a.docker.Remove(ctx, replica.ID, stopTimeout); err != nil {
+			log.Printf("[AUTOSCALE] [%s] Failed to remove replica %s: %v", key, replica.Name, err)
+			break
+		}
+		removed++
+	}
+	return removed
+}
+
+// Status returns the last measurements of every app with a policy, ordered by project and app
+func (a *Autoscaler) Status() []Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	statuses := make([]Status, 0, len(a.apps))
+	for _, tracked := range a.apps {
+		if tracked.status.App != "" {
+			statuses = append(statuses, tracked.status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Project != statuses[j].Project {
+			return statuses[i].Project < statuses[j].Project
+		}
+		return statuses[i].App < statuses[j].App
+	})
+	return statuses
+}
+
+// betterTemplate prefers replicas created by a deploy over clones, then newer ones
+func betterTemplate(replica, template docker.Container) bool {
+	if isAutoscaled(replica) != isAutoscaled(template) {
+		return !isAutoscaled(replica)
+	}
+	return replica.Created.After(template.Created)
+}
+
+func isAutoscaled(container docker.Container) bool {
+	return container.Labels[AutoscaledLabel] == "true"
+}
+
+// cooldownOf parses a policy's cooldown, falling back to the default when unset
+func cooldownOf(value string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,265 @@
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDocker struct {
+	containers []docker.Container
+	removed    []string
+}
+
+func (f *fakeDocker) Containers(ctx context.Context, label string) ([]docker.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeDocker) Clone(ctx context.Context, id, name string, labels map[string]string) (string, error) {
+	var source docker.Container
+	for _, c := range f.containers {
+		if c.ID == id {
+			source = c
+		}
+	}
+	merged := map[string]string{}
+	for k, v := range source.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	clone := docker.Container{ID: "id-" + name, Name: name, Labels: merged, Created: testTime.Add(time.Duration(len(f.containers)) * time.Second)}
+	f.containers = append(f.containers, clone)
+	return clone.ID, nil
+}
+
+func (f *fakeDocker) Remove(ctx context.Context, id string, timeout time.Duration) error {
+	for i, c := range f.containers {
+		if c.ID == id {
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			f.removed = append(f.removed, c.Name)
+			return nil
+		}
+	}
+	return fmt.Errorf("no such container %s", id)
+}
+
+type fakeRequests map[string]router.RequestStats
+
+func (f fakeRequests) RequestStats() map[string]router.RequestStats {
+	return f
+}
+
+type fakeUsage []stats.Sample
+
+func (f *fakeUsage) Samples() []stats.Sample {
+	return *f
+}
+
+var testTime = time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+
+func replica(name, color string, created time.Time) docker.Container {
+	return docker.Container{
+		ID:      "id-" + name,
+		Name:    name,
+		Created: created,
+		Labels: map[string]string{
+			"iop.managed": "true", "iop.type": "service", "iop.project": "blog", "iop.app": "web", "iop.color": color,
+		},
+	}
+}
+
+type harness struct {
+	scaler   *Autoscaler
+	docker   *fakeDocker
+	requests fakeRequests
+	usage    *fakeUsage
+	events   <-chan core.Event
+	now      time.Time
+}
+
+func newHarness(t *testing.T, policy state.AutoscalePolicy) *harness {
+	st := state.NewState(t.TempDir() + "/state.json")
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/health", false))
+	st.SetAutoscalePolicy(&policy)
+
+	bus := events.NewSimpleBus()
+	h := &harness{
+		docker:   &fakeDocker{containers: []docker.Container{replica("blog-web-blue", "blue", testTime)}},
+		requests: fakeRequests{},
+		usage:    &fakeUsage{},
+		events:   bus.Subscribe(),
+		now:      testTime,
+	}
+	h.scaler = New(st, h.docker, h.requests, h.usage, bus)
+	h.scaler.now = func() time.Time { return h.now }
+	return h
+}
+
+// step advances the clock and serves requests at a rate per second
+func (h *harness) step(t *testing.T, elapsed time.Duration, rate float64, latency time.Duration) {
+	h.now = h.now.Add(elapsed)
+	s := h.requests["blog.example.com"]
+	served := uint64(rate * elapsed.Seconds())
+	s.Requests += served
+	s.Latency += time.Duration(served) * latency
+	h.requests["blog.example.com"] = s
+	require.NoError(t, h.scaler.Evaluate(context.Background()))
+}
+
+func (h *harness) setCPU(percent float64) {
+	*h.usage = nil
+	for _, c := range h.docker.containers {
+		*h.usage = append(*h.usage, stats.Sample{Container: c.Name, CPUPercent: percent})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 3, TargetCPU: 70}
+	assert.NoError(t, Validate(&valid))
+
+	tests := map[string]func(p *state.AutoscalePolicy){
+		"missing app":    func(p *state.AutoscalePolicy) { p.App = "" },
+		"zero min":       func(p *state.AutoscalePolicy) { p.MinReplicas = 0 },
+		"max below min":  func(p *state.AutoscalePolicy) { p.MaxReplicas = 0 },
+		"no targets":     func(p *state.AutoscalePolicy) { p.TargetCPU = 0 },
+		"negative":       func(p *state.AutoscalePolicy) { p.TargetRequestRate = -1 },
+		"bad cooldown":   func(p *state.AutoscalePolicy) { p.ScaleUpCooldown = "soon" },
+		"negative delay": func(p *state.AutoscalePolicy) { p.ScaleDownCooldown = "-1m" },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			policy := valid
+			mutate(&policy)
+			assert.Error(t, Validate(&policy))
+		})
+	}
+}
+
+func TestScalesUpOnRequestRate(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 4, TargetRequestRate: 10})
+
+	// The first evaluation only records the request counters
+	h.step(t, 0, 0, 0)
+	assert.Len(t, h.docker.containers, 1)
+
+	h.step(t, 30*time.Second, 25, 20*time.Millisecond)
+	require.Len(t, h.docker.containers, 3)
+	assert.Equal(t, "blog-web-blue-scale-1", h.docker.containers[1].Name)
+	assert.Equal(t, "true", h.docker.containers[1].Labels[AutoscaledLabel])
+	assert.Equal(t, "blue", h.docker.containers[1].Labels["iop.color"])
+
+	event := (<-h.events).(core.Scaled)
+	assert.Equal(t, 1, event.From)
+	assert.Equal(t, 3, event.To)
+	assert.Equal(t, "blog.example.com", event.Hostname)
+	assert.Equal(t, "25.0 requests/s per replica (target 10.0)", event.Reason)
+
+	status := h.scaler.Status()
+	require.Len(t, status, 1)
+	assert.Equal(t, 3, status[0].Replicas)
+	assert.InDelta(t, 20, status[0].LatencyMs, 0.01)
+}
+
+func TestScalesOnConcurrency(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 4, TargetConcurrency: 2})
+	h.step(t, 0, 0, 0)
+
+	// 10 requests/s taking 500ms each keep 5 requests in flight on average
+	h.step(t, 30*time.Second, 10, 500*time.Millisecond)
+	assert.Len(t, h.docker.containers, 3)
+	assert.Equal(t, "5.0 concurrent requests per replica (target 2.0)", (<-h.events).(core.Scaled).Reason)
+	assert.InDelta(t, 5, h.scaler.Status()[0].Concurrency, 0.01)
+}
+
+func TestScalesOnCPUWithinBounds(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 2, TargetCPU: 50})
+	h.step(t, 0, 0, 0)
+
+	h.setCPU(200)
+	h.step(t, 30*time.Second, 0, 0)
+	assert.Len(t, h.docker.containers, 2, "capped at max replicas")
+	assert.Equal(t, "cpu 200% (target 50%)", (<-h.events).(core.Scaled).Reason)
+}
+
+func TestCooldowns(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{
+		Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 10, TargetLatencyMs: 100,
+		ScaleUpCooldown: "2m", ScaleDownCooldown: "10m",
+	})
+	h.step(t, 0, 0, 0)
+
+	h.step(t, 30*time.Second, 10, 200*time.Millisecond)
+	require.Len(t, h.docker.containers, 2)
+
+	// Still too slow, but within the scale up cooldown
+	h.step(t, 30*time.Second, 10, 200*time.Millisecond)
+	assert.Len(t, h.docker.containers, 2)
+
+	h.step(t, 90*time.Second, 10, 200*time.Millisecond)
+	assert.Len(t, h.docker.containers, 4)
+
+	// Idle, but within the scale down cooldown
+	h.step(t, 5*time.Minute, 10, 10*time.Millisecond)
+	assert.Len(t, h.docker.containers, 4)
+
+	h.step(t, 5*time.Minute, 10, 10*time.Millisecond)
+	assert.Len(t, h.docker.containers, 1)
+	assert.Equal(t, []string{"blog-web-blue-scale-3", "blog-web-blue-scale-2", "blog-web-blue-scale-1"}, h.docker.removed)
+}
+
+func TestScaleDownKeepsDeployedReplicas(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 4, TargetCPU: 50})
+	h.docker.containers = append(h.docker.containers, replica("blog-web-blue-2", "blue", testTime))
+	h.step(t, 0, 0, 0)
+
+	h.setCPU(1)
+	h.step(t, 30*time.Second, 0, 0)
+	assert.Len(t, h.docker.containers, 2)
+	assert.Empty(t, h.docker.removed)
+	assert.Empty(t, h.events)
+}
+
+func TestEnforcesMinimum(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 3, MaxReplicas: 4, TargetCPU: 50})
+	h.step(t, 0, 0, 0)
+
+	h.step(t, 30*time.Second, 0, 0)
+	assert.Len(t, h.docker.containers, 3)
+	assert.Equal(t, "below minimum of 3 replicas", (<-h.events).(core.Scaled).Reason)
+}
+
+func TestSkipsDuringDeployment(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 3, MaxReplicas: 4, TargetCPU: 50})
+	h.docker.containers = append(h.docker.containers, replica("blog-web-green", "green", testTime))
+	h.step(t, 0, 0, 0)
+
+	h.step(t, 30*time.Second, 0, 0)
+	assert.Len(t, h.docker.containers, 2)
+}
+
+type fakeQuotas int
+
+func (f fakeQuotas) Room(ctx context.Context, project string, replica docker.Container, count int) (int, error) {
+	return min(int(f), count), nil
+}
+
+func TestScaleUpStaysWithinQuota(t *testing.T) {
+	h := newHarness(t, state.AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 4, TargetRequestRate: 10})
+	h.scaler.SetQuotas(fakeQuotas(1))
+	h.step(t, 0, 0, 0)
+
+	h.step(t, 30*time.Second, 40, 0)
+	assert.Len(t, h.docker.containers, 2)
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// Version is the archive format written by Create
+const Version = 1
+
+// Archive is everything needed to rebuild a proxy on another server: the
+// state plus the ACME account key and the certificates it points to
+type Archive struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	State     json.RawMessage   `json:"state"`
+	Files     map[string][]byte `json:"files"` // File contents by absolute path, base64 encoded in JSON
+}
+
+// Create builds an archive of the current state and the files it references.
+// Missing certificate files are skipped, the proxy re-acquires them.
+func Create(st *state.State) (*Archive, error) {
+	snapshot, err := st.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		State:     snapshot,
+		Files:     make(map[string][]byte),
+	}
+
+	for _, path := range referencedFiles(st) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		archive.Files[path] = data
+	}
+
+	return archive, nil
+}
+
+// referencedFiles lists the account key and certificate files in the state
+func referencedFiles(st *state.State) []string {
+	var paths []string
+	if key := st.GetACMEConfig().AccountKeyFile; key != "" {
+		paths = append(paths, key)
+	}
+	for _, host := range st.GetAllHosts() {
+		if host.Certificate == nil {
+			continue
+		}
+		for _, path := range []string{host.Certificate.CertFile, host.Certificate.KeyFile} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// Restore writes the archive's files and replaces the state with its
+// snapshot. The archive is checked before anything is written.
+func Restore(st *state.State, archive *Archive) error {
+	if archive.Version != Version {
+		return fmt.Errorf("unsupported archive version %d, expected %d", archive.Version, Version)
+	}
+	if len(archive.State) == 0 {
+		return fmt.Errorf("archive has no state")
+	}
+	if err := state.NewState("").Restore(archive.State); err != nil {
+		return fmt.Errorf("invalid archive state: %w", err)
+	}
+	for path := range archive.Files {
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+			return fmt.Errorf("invalid file path %q in archive", path)
+		}
+	}
+
+	for path, data := range archive.Files {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		// Keys and certificates are secrets, keep them private
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+
+	if err := st.Restore(archive.State); err != nil {
+		return err
+	}
+	return st.Save()
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	accountKey := filepath.Join(dir, "certs", "account.key")
+	certFile := filepath.Join(dir, "certs", "app.example.com", "cert.pem")
+	keyFile := filepath.Join(dir, "certs", "app.example.com", "key.pem")
+	require.NoError(t, os.MkdirAll(filepath.Dir(certFile), 0700))
+	require.NoError(t, os.WriteFile(accountKey, []byte("account key"), 0600))
+	require.NoError(t, os.WriteFile(certFile, []byte("certificate"), 0600))
+	require.NoError(t, os.WriteFile(keyFile, []byte("private key"), 0600))
+
+	st := state.NewState(filepath.Join(dir, "state.json"))
+	st.LetsEncrypt.AccountKeyFile = accountKey
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.DeployHost("plain.example.com", "plain:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.UpdateCertificateStatus("app.example.com", &state.CertificateStatus{
+		Status:   "active",
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}))
+	st.SetPortForward(&state.PortForward{Listen: 5432, Protocol: "tcp", Target: "db:5432"})
+
+	archive, err := Create(st)
+	require.NoError(t, err)
+	assert.Equal(t, Version, archive.Version)
+	assert.Len(t, archive.Files, 3)
+
+	// Round trip through JSON like the API does
+	data, err := json.Marshal(archive)
+	require.NoError(t, err)
+	var decoded Archive
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	// Simulate losing the disk
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "certs")))
+
+	restoredPath := filepath.Join(t.TempDir(), "state.json")
+	restored := state.NewState(restoredPath)
+	require.NoError(t, Restore(restored, &decoded))
+
+	host, project, err := restored.GetHost("app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "blog", project)
+	assert.Equal(t, "active", host.Certificate.Status)
+	assert.Len(t, restored.GetAllHosts(), 2)
+	assert.Len(t, restored.GetPortForwards(), 1)
+	assert.Equal(t, accountKey, restored.GetACMEConfig().AccountKeyFile)
+
+	contents, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, "private key", string(contents))
+
+	// The restored state is saved right away
+	reloaded := state.NewState(restoredPath)
+	require.NoError(t, reloaded.Load())
+	assert.Len(t, reloaded.GetAllHosts(), 2)
+}
+
+func TestRestoreRejectsInvalidArchives(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", false))
+
+	snapshot, err := st.Snapshot()
+	require.NoError(t, err)
+	outside := filepath.Join(t.TempDir(), "written")
+
+	invalid := []*Archive{
+		{Version: 2, State: snapshot},
+		{Version: Version},
+		{Version: Version, State: json.RawMessage(`{"projects": []}`)},
+		{Version: Version, State: snapshot, Files: map[string][]byte{"relative/key.pem": []byte("x")}},
+		{Version: Version, State: snapshot, Files: map[string][]byte{outside + "/../escape": []byte("x")}},
+	}
+	for _, archive := range invalid {
+		assert.Error(t, Restore(st, archive))
+	}
+
+	// Nothing was replaced
+	assert.Len(t, st.GetAllHosts(), 1)
+	_, err = os.Stat(outside)
+	assert.True(t, os.IsNotExist(err))
+}
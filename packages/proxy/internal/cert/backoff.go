@@ -0,0 +1,127 @@
+package cert
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Classes of acquisition failure, reported as CertificateStatus.ErrorType
+const (
+	// ErrorRateLimited means the CA refused to issue until its rate limit resets
+	ErrorRateLimited = "rate_limited"
+	// ErrorDNS means the domain didn't resolve, usually a record that hasn't propagated yet
+	ErrorDNS = "dns"
+	// ErrorCAA means the domain's CAA records don't allow the CA, see CAAError
+	ErrorCAA = "caa"
+	// ErrorDNSSEC means the domain's DNSSEC fails validation, see DNSSECError
+	ErrorDNSSEC = "dnssec"
+	// ErrorAuthorization means the CA won't issue for the domain, e.g. the
+	// challenge reached a different server
+	ErrorAuthorization = "authorization"
+	// ErrorOther is anything else, such as timeouts or connection failures
+	ErrorOther = "other"
+)
+
+// Retry delays per failure class
+const (
+	rateLimitDefaultBackoff = time.Hour
+	dnsBackoff              = 2 * time.Minute
+	caaBackoff              = 5 * time.Minute
+	authorizationBackoff    = 6 * time.Hour
+	defaultBackoff          = 10 * time.Minute
+)
+
+// Let's Encrypt states when a rate limit resets in the problem detail, e.g.
+// "too many certificates already issued ...: retry after 2024-05-01 12:00:00 UTC"
+var retryAfterDetail = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) UTC`)
+
+// ClassifyError works out from an ACME problem document why acquisition
+// failed and how long to wait before trying again
+func ClassifyError(err error, now time.Time) (string, time.Duration) {
+	problems := acmeProblems(err)
+
+	for _, problem := range problems {
+		if retry, ok := acme.RateLimit(problem); ok {
+			if retry <= 0 {
+				retry = retryAfterFromDetail(problem.Detail, now)
+			}
+			if retry <= 0 {
+				retry = rateLimitDefaultBackoff
+			}
+			return ErrorRateLimited, retry
+		}
+	}
+
+	for _, problem := range problems {
+		for _, problemType := range problemTypes(problem) {
+			switch problemType {
+			case "caa":
+				// Same class and retry as when the precheck finds the CAA records
+				return ErrorCAA, caaBackoff
+			case "unauthorized", "rejectedIdentifier":
+				return ErrorAuthorization, authorizationBackoff
+			}
+		}
+	}
+
+	for _, problem := range problems {
+		for _, problemType := range problemTypes(problem) {
+			if problemType == "dns" {
+				return ErrorDNS, dnsBackoff
+			}
+		}
+	}
+
+	return ErrorOther, defaultBackoff
+}
+
+// acmeProblems collects the ACME problem documents in err, including the
+// challenge errors of a failed authorization
+func acmeProblems(err error) []*acme.Error {
+	var problems []*acme.Error
+
+	var authzErr *acme.AuthorizationError
+	if errors.As(err, &authzErr) {
+		for _, challengeErr := range authzErr.Errors {
+			problems = append(problems, acmeProblems(challengeErr)...)
+		}
+	}
+
+	var problem *acme.Error
+	if errors.As(err, &problem) {
+		problems = append(problems, problem)
+	}
+
+	return problems
+}
+
+// problemTypes returns the short types of a problem and its subproblems,
+// e.g. "dns" for "urn:ietf:params:acme:error:dns"
+func problemTypes(problem *acme.Error) []string {
+	types := []string{shortProblemType(problem.ProblemType)}
+	for _, sub := range problem.Subproblems {
+		types = append(types, shortProblemType(sub.Type))
+	}
+	return types
+}
+
+func shortProblemType(problemType string) string {
+	return problemType[strings.LastIndex(problemType, ":")+1:]
+}
+
+// retryAfterFromDetail reads the reset time from a rate limit problem detail
+func retryAfterFromDetail(detail string, now time.Time) time.Duration {
+	match := retryAfterDetail.FindStringSubmatch(detail)
+	if match == nil {
+		return 0
+	}
+	resetAt, err := time.Parse("2006-01-02 15:04:05", match[1])
+	if err != nil {
+		return 0
+	}
+	return resetAt.Sub(now)
+}
@@ -0,0 +1,106 @@
+package cert
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+)
+
+func TestClassifyError(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		err       error
+		errorType string
+		retryIn   time.Duration
+	}{
+		{
+			name: "rate limit with Retry-After header",
+			err: &acme.Error{
+				StatusCode:  429,
+				ProblemType: "urn:ietf:params:acme:error:rateLimited",
+				Header:      http.Header{"Retry-After": []string{"3600"}},
+			},
+			errorType: ErrorRateLimited,
+			retryIn:   time.Hour,
+		},
+		{
+			name: "rate limit with reset time in the detail",
+			err: &acme.Error{
+				StatusCode:  429,
+				ProblemType: "urn:ietf:params:acme:error:rateLimited",
+				Detail:      "too many certificates (5) already issued for this exact set of domains in the last 168 hours: app.example.com, retry after 2024-05-03 12:00:00 UTC",
+			},
+			errorType: ErrorRateLimited,
+			retryIn:   48 * time.Hour,
+		},
+		{
+			name:      "rate limit without a reset time",
+			err:       &acme.Error{StatusCode: 429, ProblemType: "urn:ietf:params:acme:error:rateLimited"},
+			errorType: ErrorRateLimited,
+			retryIn:   rateLimitDefaultBackoff,
+		},
+		{
+			name: "DNS failure during validation",
+			err: &acme.AuthorizationError{
+				Identifier: "app.example.com",
+				Errors: []error{&acme.Error{
+					ProblemType: "urn:ietf:params:acme:error:dns",
+					Detail:      "DNS problem: NXDOMAIN looking up A for app.example.com",
+				}},
+			},
+			errorType: ErrorDNS,
+			retryIn:   dnsBackoff,
+		},
+		{
+			name: "CAA record forbids issuance",
+			err: fmt.Errorf("finalize: %w", &acme.Error{
+				StatusCode:  403,
+				ProblemType: "urn:ietf:params:acme:error:caa",
+			}),
+			errorType: ErrorCAA,
+			retryIn:   caaBackoff,
+		},
+		{
+			name: "CAA failure during validation",
+			err: &acme.AuthorizationError{
+				Identifier: "app.example.com",
+				Errors: []error{&acme.Error{
+					ProblemType: "urn:ietf:params:acme:error:caa",
+					Detail:      "CAA record for example.com prevents issuance",
+				}},
+			},
+			errorType: ErrorCAA,
+			retryIn:   caaBackoff,
+		},
+		{
+			name: "authorization subproblem",
+			err: &acme.Error{
+				ProblemType: "urn:ietf:params:acme:error:malformed",
+				Subproblems: []acme.Subproblem{{Type: "urn:ietf:params:acme:error:rejectedIdentifier"}},
+			},
+			errorType: ErrorAuthorization,
+			retryIn:   authorizationBackoff,
+		},
+		{
+			name:      "unknown error",
+			err:       errors.New("context deadline exceeded"),
+			errorType: ErrorOther,
+			retryIn:   defaultBackoff,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errorType, retryIn := ClassifyError(tt.err, now)
+			assert.Equal(t, tt.errorType, errorType)
+			assert.Equal(t, tt.retryIn, retryIn)
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package cert
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultDNSResolver answers the CAA lookups. It validates DNSSEC like the
+// CAs' resolvers do, which the resolver Docker provides may not.
+const defaultDNSResolver = "1.1.1.1:53"
+
+// caaLookupTimeout bounds one DNS query
+const caaLookupTimeout = 5 * time.Second
+
+// DNS constants used by the CAA lookups
+const (
+	typeCAA  = 257
+	typeOPT  = 41
+	classIN  = 1
+	rcodeOK  = 0
+	rcodeNX  = 3
+	rcodeSRV = 2 // SERVFAIL, e.g. when DNSSEC validation fails
+)
+
+// CAA property tags CAs understand. A record with the critical flag and
+// another tag forbids issuance.
+var knownCAATags = map[string]bool{
+	"issue": true, "issuewild": true, "iodef": true, "contactemail": true,
+	"contactphone": true, "issuemail": true, "issuevmc": true,
+}
+
+// CAARecord is a CAA resource record
+type CAARecord struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+// CAAError means the domain's CAA records don't allow the CA to issue
+type CAAError struct {
+	Hostname   string
+	Domain     string   // Where the records are
+	Allowed    []string // CAs the records allow
+	Identities []string // The configured CA's CAA identities
+	Tag        string   // issue or issuewild
+	Critical   string   // Unknown critical tag that forbids issuance, if any
+}
+
+func (e *CAAError) Error() string {
+	if e.Critical != "" {
+		return fmt.Sprintf("CAA records of %s have the critical tag %q no CA understands, which forbids issuance for %s: remove that record", e.Domain, e.Critical, e.Hostname)
+	}
+	allowed := "no CA"
+	if len(e.Allowed) > 0 {
+		allowed = "only " + strings.Join(e.Allowed, ", ")
+	}
+	return fmt.Sprintf("CAA records of %s allow %s to issue for %s: add the record %s. CAA 0 %s %q", e.Domain, allowed, e.Hostname, e.Domain, e.Tag, e.Identities[0])
+}
+
+// DNSSECError means a domain's DNSSEC validation fails, so CAs can't resolve it
+type DNSSECError struct {
+	Hostname string
+	Domain   string
+}
+
+func (e *DNSSECError) Error() string {
+	return fmt.Sprintf("DNSSEC validation fails for %s, so CAs can't look up %s: make the DS record at the registrar match the zone's DNSKEY, or remove it", e.Domain, e.Hostname)
+}
+
+// CAALookupError means a resolver couldn't answer for a domain. CAs refuse
+// to issue when CAA lookups fail.
+type CAALookupError struct {
+	Hostname string
+	Domain   string
+}
+
+func (e *CAALookupError) Error() string {
+	return fmt.Sprintf("CAA lookup of %s fails (SERVFAIL), CAs refuse to issue for %s until its name servers answer", e.Domain, e.Hostname)
+}
+
+// CAACheck verifies that a hostname's CAA records allow the CA to issue and
+// that its DNSSEC validates, before an ACME order is created
+type CAACheck struct {
+	query func(ctx context.Context, name string, checkingDisabled bool) (int, []CAARecord, error)
+}
+
+// NewCAACheckFromEnv creates the CAA check, or returns nil when disabled
+// with IOP_SKIP_CAA_CHECK. IOP_DNS_RESOLVER sets the resolver, host:port.
+func NewCAACheckFromEnv() *CAACheck {
+	if skip := os.Getenv("IOP_SKIP_CAA_CHECK"); skip == "true" || skip == "1" {
+		return nil
+	}
+
+	resolver := os.Getenv("IOP_DNS_RESOLVER")
+	if resolver == "" {
+		resolver = defaultDNSResolver
+	} else if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+	return &CAACheck{
+		query: func(ctx context.Context, name string, checkingDisabled bool) (int, []CAARecord, error) {
+			return queryCAA(ctx, resolver, name, checkingDisabled)
+		},
+	}
+}
+
+// Check returns a CAAError, DNSSECError or CAALookupError when a CA with
+// the given CAA identities would refuse to issue for hostname. Resolver
+// errors pass, a missing check must never block issuance.
+func (c *CAACheck) Check(ctx context.Context, hostname string, identities []string) error {
+	if len(identities) == 0 {
+		return nil
+	}
+
+	// The relevant records are the first found climbing towards the root
+	name := strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(hostname), "*."), ".")
+	wildcard := strings.HasPrefix(hostname, "*.")
+	for domain := name; domain != ""; domain = parentDomain(domain) {
+		rcode, records, err := c.query(ctx, domain, false)
+		if err != nil {
+			return nil
+		}
+		switch rcode {
+		case rcodeOK, rcodeNX:
+		case rcodeSRV:
+			// Tell broken DNSSEC apart from name servers that don't answer
+			if rcode, _, err := c.query(ctx, domain, true); err == nil && rcode != rcodeSRV {
+				return &DNSSECError{Hostname: hostname, Domain: domain}
+			}
+			return &CAALookupError{Hostname: hostname, Domain: domain}
+		default:
+			return nil
+		}
+		if len(records) > 0 {
+			return evaluateCAA(hostname, domain, records, identities, wildcard)
+		}
+	}
+	return nil
+}
+
+// evaluateCAA applies a domain's CAA records to an order for hostname
+func evaluateCAA(hostname, domain string, records []CAARecord, identities []string, wildcard bool) error {
+	tag := "issue"
+	if wildcard {
+		for _, record := range records {
+			if strings.EqualFold(record.Tag, "issuewild") {
+				tag = "issuewild"
+				break
+			}
+		}
+	}
+
+	var allowed []string
+	restricted := false
+	for _, record := range records {
+		if record.Flags&128 != 0 && !knownCAATags[strings.ToLower(record.Tag)] {
+			return &CAAError{Hostname: hostname, Domain: domain, Identities: identities, Tag: tag, Critical: record.Tag}
+		}
+		if !strings.EqualFold(record.Tag, tag) {
+			continue
+		}
+		restricted = true
+		issuer := strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0])
+		for _, identity := range identities {
+			if strings.EqualFold(issuer, identity) {
+				return nil
+			}
+		}
+		if issuer != "" {
+			allowed = append(allowed, issuer)
+		}
+	}
+
+	// Records with other tags only, e.g. iodef, don't restrict issuance
+	if !restricted {
+		return nil
+	}
+	return &CAAError{Hostname: hostname, Domain: domain, Allowed: allowed, Identities: identities, Tag: tag}
+}
+
+// parentDomain strips the leftmost label, "" for a top-level domain
+func parentDomain(domain string) string {
+	_, parent, found := strings.Cut(domain, ".")
+	if !found {
+		return ""
+	}
+	return parent
+}
+
+// queryCAA asks resolver for the CAA records of name, over TCP when the
+// answer doesn't fit in UDP. It returns the response code and the records.
+func queryCAA(ctx context.Context, resolver, name string, checkingDisabled bool) (int, []CAARecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, caaLookupTimeout)
+	defer cancel()
+
+	query, id, err := buildQuery(name, typeCAA, checkingDisabled)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var dialer net.Dialer
+	response, err := exchange(ctx, &dialer, "udp", resolver, query)
+	if err == nil && len(response) > 2 && response[2]&0x02 != 0 {
+		// Truncated
+		response, err = exchange(ctx, &dialer, "tcp", resolver, query)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return parseCAAResponse(response, id)
+}
+
+// exchange sends a query and reads the response, length-prefixed over TCP
+func exchange(ctx context.Context, dialer *net.Dialer, network, address string, query []byte) ([]byte, error) {
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// buildQuery encodes a recursive query with an EDNS0 record allowing
+// 1232 byte UDP responses, and returns it with its ID
+func buildQuery(name string, qtype uint16, checkingDisabled bool) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	flags := uint16(0x0100) // Recursion desired
+	if checkingDisabled {
+		flags |= 0x0010
+	}
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, flags)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // Questions
+	msg = binary.BigEndian.AppendUint16(msg, 0) // Answers
+	msg = binary.BigEndian.AppendUint16(msg, 0) // Authority records
+	msg = binary.BigEndian.AppendUint16(msg, 1) // Additional records
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid domain name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+
+	// OPT record: root name, type, UDP payload size, extended flags, no data
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, typeOPT)
+	msg = binary.BigEndian.AppendUint16(msg, 1232)
+	msg = binary.BigEndian.AppendUint32(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	return msg, id, nil
+}
+
+var errMalformedResponse = errors.New("malformed DNS response")
+
+// parseCAAResponse reads the response code and CAA answers of a response.
+// Other answers, such as the CNAMEs leading to the records, are skipped.
+func parseCAAResponse(msg []byte, id uint16) (int, []CAARecord, error) {
+	if len(msg) < 12 {
+		return 0, nil, errMalformedResponse
+	}
+	if binary.BigEndian.Uint16(msg) != id {
+		return 0, nil, errors.New("DNS response ID doesn't match the query")
+	}
+	rcode := int(msg[3] & 0x0f)
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		end, err := skipName(msg, offset)
+		if err != nil || end+4 > len(msg) {
+			return 0, nil, errMalformedResponse
+		}
+		offset = end + 4
+	}
+
+	var records []CAARecord
+	for i := 0; i < answers; i++ {
+		end, err := skipName(msg, offset)
+		if err != nil || end+10 > len(msg) {
+			return 0, nil, errMalformedResponse
+		}
+		rrtype := binary.BigEndian.Uint16(msg[end:])
+		length := int(binary.BigEndian.Uint16(msg[end+8:]))
+		data := end + 10
+		if data+length > len(msg) {
+			return 0, nil, errMalformedResponse
+		}
+		if rrtype == typeCAA {
+			rdata := msg[data : data+length]
+			if len(rdata) < 2 || 2+int(rdata[1]) > len(rdata) {
+				return 0, nil, errMalformedResponse
+			}
+			tagEnd := 2 + int(rdata[1])
+			records = append(records, CAARecord{
+				Flags: rdata[0],
+				Tag:   string(rdata[2:tagEnd]),
+				Value: string(rdata[tagEnd:]),
+			})
+		}
+		offset = data + length
+	}
+	return rcode, records, nil
+}
+
+// skipName returns the offset after an encoded, possibly compressed, name
+func skipName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errMalformedResponse
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			// A pointer ends the name
+			return offset + 2, nil
+		default:
+			offset += 1 + length
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package cert
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zone is a fake resolver's view: CAA records and response codes by domain
+type zone struct {
+	records  map[string][]CAARecord
+	rcodes   map[string]int // Without checking disabled
+	cdRcodes map[string]int // With checking disabled
+	err      error
+}
+
+func (z *zone) check() *CAACheck {
+	return &CAACheck{query: func(_ context.Context, name string, checkingDisabled bool) (int, []CAARecord, error) {
+		if z.err != nil {
+			return 0, nil, z.err
+		}
+		rcodes := z.rcodes
+		if checkingDisabled {
+			rcodes = z.cdRcodes
+		}
+		if rcode, ok := rcodes[name]; ok {
+			return rcode, nil, nil
+		}
+		return rcodeOK, z.records[name], nil
+	}}
+}
+
+var letsEncrypt = []string{"letsencrypt.org"}
+
+func TestCAACheckAllows(t *testing.T) {
+	for name, records := range map[string]map[string][]CAARecord{
+		"no records": nil,
+		"allowed":    {"example.com": {{Tag: "issue", Value: "letsencrypt.org; validationmethods=http-01"}}},
+		"iodef only": {"example.com": {{Tag: "iodef", Value: "mailto:security@example.com"}}},
+		"subdomain overrides": {
+			"app.example.com": {{Tag: "issue", Value: "LetsEncrypt.org"}},
+			"example.com":     {{Tag: "issue", Value: "sectigo.com"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			z := &zone{records: records}
+			assert.NoError(t, z.check().Check(context.Background(), "app.example.com", letsEncrypt))
+		})
+	}
+}
+
+func TestCAACheckRefuses(t *testing.T) {
+	z := &zone{records: map[string][]CAARecord{
+		"example.com": {{Tag: "issue", Value: "sectigo.com"}, {Tag: "issue", Value: "digicert.com"}},
+	}}
+	err := z.check().Check(context.Background(), "app.example.com", letsEncrypt)
+
+	var caaErr *CAAError
+	require.ErrorAs(t, err, &caaErr)
+	assert.Equal(t, "example.com", caaErr.Domain)
+	assert.Equal(t, `CAA records of example.com allow only sectigo.com, digicert.com to issue for app.example.com: add the record example.com. CAA 0 issue "letsencrypt.org"`, err.Error())
+
+	// An empty issuer forbids every CA
+	z.records["example.com"] = []CAARecord{{Tag: "issue", Value: ";"}}
+	assert.ErrorContains(t, z.check().Check(context.Background(), "app.example.com", letsEncrypt), "allow no CA to issue")
+
+	// Unknown critical tags forbid issuance
+	z.records["example.com"] = []CAARecord{{Flags: 128, Tag: "tbs", Value: "x"}, {Tag: "issue", Value: "letsencrypt.org"}}
+	assert.ErrorContains(t, z.check().Check(context.Background(), "app.example.com", letsEncrypt), `critical tag "tbs"`)
+}
+
+func TestCAACheckWildcard(t *testing.T) {
+	z := &zone{records: map[string][]CAARecord{
+		"example.com": {{Tag: "issue", Value: "letsencrypt.org"}, {Tag: "issuewild", Value: ";"}},
+	}}
+	assert.NoError(t, z.check().Check(context.Background(), "app.example.com", letsEncrypt))
+	assert.ErrorContains(t, z.check().Check(context.Background(), "*.example.com", letsEncrypt), `example.com. CAA 0 issuewild "letsencrypt.org"`)
+}
+
+func TestCAACheckDNSSEC(t *testing.T) {
+	z := &zone{
+		rcodes:   map[string]int{"example.com": rcodeSRV},
+		cdRcodes: map[string]int{"example.com": rcodeOK},
+	}
+	err := z.check().Check(context.Background(), "app.example.com", letsEncrypt)
+	var dnssecErr *DNSSECError
+	require.ErrorAs(t, err, &dnssecErr)
+	assert.Contains(t, err.Error(), "DS record at the registrar")
+
+	// Failing with checking disabled too, the name servers are at fault
+	z.cdRcodes["example.com"] = rcodeSRV
+	var lookupErr *CAALookupError
+	assert.ErrorAs(t, z.check().Check(context.Background(), "app.example.com", letsEncrypt), &lookupErr)
+}
+
+func TestCAACheckNeverBlocksOnResolverErrors(t *testing.T) {
+	z := &zone{err: errors.New("i/o timeout")}
+	assert.NoError(t, z.check().Check(context.Background(), "app.example.com", letsEncrypt))
+
+	// A CA without CAA identities can't be checked
+	z = &zone{records: map[string][]CAARecord{"example.com": {{Tag: "issue", Value: ";"}}}}
+	assert.NoError(t, z.check().Check(context.Background(), "app.example.com", nil))
+}
+
+func TestPrecheckErrorType(t *testing.T) {
+	errorType, _ := precheckErrorType(&CAAError{})
+	assert.Equal(t, ErrorCAA, errorType)
+	errorType, _ = precheckErrorType(&DNSSECError{})
+	assert.Equal(t, ErrorDNSSEC, errorType)
+	errorType, backoff := precheckErrorType(&DNSNotReadyError{})
+	assert.Equal(t, ErrorDNS, errorType)
+	assert.Equal(t, dnsBackoff, backoff)
+}
+
+// caaAnswer appends a CAA answer for the question's name to a response
+func caaAnswer(msg []byte, flags uint8, tag, value string) []byte {
+	msg = append(msg, 0xc0, 12) // Pointer to the question's name
+	msg = binary.BigEndian.AppendUint16(msg, typeCAA)
+	msg = binary.BigEndian.AppendUint16(msg, classIN)
+	msg = binary.BigEndian.AppendUint32(msg, 300)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(2+len(tag)+len(value)))
+	msg = append(msg, flags, byte(len(tag)))
+	msg = append(msg, tag...)
+	return append(msg, value...)
+}
+
+func TestQueryCAA(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		// Answer with the question, without the OPT record
+		query := buf[:n]
+		end, _ := skipName(query, 12)
+		response := append([]byte{}, query[:end+4]...)
+		response[2] |= 0x80 // Response
+		binary.BigEndian.PutUint16(response[6:], 2)
+		binary.BigEndian.PutUint16(response[10:], 0)
+		response = caaAnswer(response, 0, "issue", "letsencrypt.org")
+		response = caaAnswer(response, 128, "iodef", "mailto:security@example.com")
+		conn.WriteTo(response, addr)
+	}()
+
+	rcode, records, err := queryCAA(context.Background(), conn.LocalAddr().String(), "example.com", false)
+	require.NoError(t, err)
+	assert.Equal(t, rcodeOK, rcode)
+	assert.Equal(t, []CAARecord{
+		{Tag: "issue", Value: "letsencrypt.org"},
+		{Flags: 128, Tag: "iodef", Value: "mailto:security@example.com"},
+	}, records)
+}
+
+func TestParseCAAResponseRejectsMalformed(t *testing.T) {
+	query, id, err := buildQuery("example.com", typeCAA, true)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x10), query[3]&0x10, "checking disabled bit")
+
+	// Claims a second answer after the OPT record it reads as the first
+	binary.BigEndian.PutUint16(query[6:], 2)
+	_, _, err = parseCAAResponse(query, id)
+	assert.Error(t, err)
+
+	_, _, err = parseCAAResponse(query, id+1)
+	assert.ErrorContains(t, err, "doesn't match")
+
+	_, _, err = buildQuery("bad..example.com", typeCAA, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,184 @@
+package cert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long discovered public IPs are trusted before looking them up again
+const publicIPCacheTTL = time.Hour
+
+// Services that echo the caller's address, used when the proxy runs on a
+// Docker bridge network and can't see its public IPs on an interface
+var publicIPServices = []string{
+	"https://api.ipify.org",
+	"https://api6.ipify.org",
+}
+
+// DNSNotReadyError means a hostname doesn't resolve to this server yet, so
+// HTTP-01 validation would fail and count against the CA's limits
+type DNSNotReadyError struct {
+	Hostname string
+	Resolved []net.IP
+	Public   []net.IP
+}
+
+func (e *DNSNotReadyError) Error() string {
+	if len(e.Resolved) == 0 {
+		return fmt.Sprintf("DNS not pointing here yet: %s does not resolve, this server is %s", e.Hostname, joinIPs(e.Public))
+	}
+	return fmt.Sprintf("DNS not pointing here yet: %s resolves to %s, this server is %s", e.Hostname, joinIPs(e.Resolved), joinIPs(e.Public))
+}
+
+// DNSCheck verifies that a hostname's A/AAAA records point at this server
+// before an ACME order is created
+type DNSCheck struct {
+	lookupIP  func(ctx context.Context, host string) ([]net.IP, error)
+	publicIPs func(ctx context.Context) ([]net.IP, error)
+
+	mu       sync.Mutex
+	cached   []net.IP
+	cachedAt time.Time
+}
+
+// NewDNSCheckFromEnv creates the DNS check, or returns nil when disabled with
+// IOP_SKIP_DNS_CHECK, e.g. behind a CDN whose addresses the domain resolves to.
+// IOP_PUBLIC_IPS lists the server's addresses. Without it they're discovered
+// from the network interfaces and by asking api.ipify.org, a third-party
+// service, which setting IOP_PUBLIC_IPS avoids.
+func NewDNSCheckFromEnv() *DNSCheck {
+	if skip := os.Getenv("IOP_SKIP_DNS_CHECK"); skip == "true" || skip == "1" {
+		return nil
+	}
+
+	check := &DNSCheck{
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		publicIPs: discoverPublicIPs,
+	}
+
+	if configured := os.Getenv("IOP_PUBLIC_IPS"); configured != "" {
+		var ips []net.IP
+		for _, value := range strings.Split(configured, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(value)); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		check.publicIPs = func(context.Context) ([]net.IP, error) { return ips, nil }
+	}
+
+	return check
+}
+
+// Check returns a DNSNotReadyError if hostname doesn't resolve to any of the
+// server's public IPs. When those or the records can't be determined the check
+// passes, a missing DNS check must never block issuance.
+func (c *DNSCheck) Check(ctx context.Context, hostname string) error {
+	public, err := c.serverIPs(ctx)
+	if err != nil || len(public) == 0 {
+		log.Printf("[CERT] [%s] Skipping DNS check, public IPs unknown: %v", hostname, err)
+		return nil
+	}
+
+	resolved, err := c.lookupIP(ctx, hostname)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return &DNSNotReadyError{Hostname: hostname, Public: public}
+		}
+		// A resolver failure or timeout says nothing about the records
+		log.Printf("[CERT] [%s] Skipping DNS check, lookup failed: %v", hostname, err)
+		return nil
+	}
+
+	for _, ip := range resolved {
+		for _, own := range public {
+			if ip.Equal(own) {
+				return nil
+			}
+		}
+	}
+	return &DNSNotReadyError{Hostname: hostname, Resolved: resolved, Public: public}
+}
+
+// serverIPs returns the server's public IPs, discovering them at most once per TTL
+func (c *DNSCheck) serverIPs(ctx context.Context) ([]net.IP, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cached != nil && time.Since(c.cachedAt) < publicIPCacheTTL {
+		return c.cached, nil
+	}
+
+	ips, err := c.publicIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) > 0 {
+		c.cached = ips
+		c.cachedAt = time.Now()
+	}
+	return ips, nil
+}
+
+// discoverPublicIPs collects public addresses on the network interfaces and
+// the addresses the outside world sees this server connect from
+func discoverPublicIPs(ctx context.Context) ([]net.IP, error) {
+	var ips []net.IP
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() && !ipNet.IP.IsPrivate() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	var lastErr error
+	for _, service := range publicIPServices {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, service, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			// Expected for IPv6 on servers without it
+			lastErr = err
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ip := net.ParseIP(strings.TrimSpace(string(body))); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("failed to discover public IPs: %w", lastErr)
+	}
+	return ips, nil
+}
+
+func joinIPs(ips []net.IP) string {
+	values := make([]string, len(ips))
+	for i, ip := range ips {
+		values[i] = ip.String()
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,78 @@
+package cert
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeDNSCheck(records map[string][]net.IP, public []net.IP, publicErr error) (*DNSCheck, *int) {
+	lookups := 0
+	return &DNSCheck{
+		lookupIP: func(_ context.Context, host string) ([]net.IP, error) {
+			if ips, ok := records[host]; ok {
+				return ips, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		},
+		publicIPs: func(context.Context) ([]net.IP, error) {
+			lookups++
+			return public, publicErr
+		},
+	}, &lookups
+}
+
+func TestDNSCheck(t *testing.T) {
+	public := []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("2001:db8::10")}
+	check, lookups := fakeDNSCheck(map[string][]net.IP{
+		"app.example.com":   {net.ParseIP("203.0.113.10")},
+		"v6.example.com":    {net.ParseIP("2001:db8::10")},
+		"moved.example.com": {net.ParseIP("198.51.100.7")},
+	}, public, nil)
+	ctx := context.Background()
+
+	assert.NoError(t, check.Check(ctx, "app.example.com"))
+	assert.NoError(t, check.Check(ctx, "v6.example.com"))
+
+	err := check.Check(ctx, "moved.example.com")
+	var notReady *DNSNotReadyError
+	require.ErrorAs(t, err, &notReady)
+	assert.Equal(t, "DNS not pointing here yet: moved.example.com resolves to 198.51.100.7, this server is 203.0.113.10, 2001:db8::10", err.Error())
+
+	err = check.Check(ctx, "missing.example.com")
+	require.ErrorAs(t, err, &notReady)
+	assert.Contains(t, err.Error(), "does not resolve")
+
+	// Public IPs are looked up once and cached
+	assert.Equal(t, 1, *lookups)
+}
+
+func TestDNSCheckPassesWhenPublicIPsAreUnknown(t *testing.T) {
+	check, _ := fakeDNSCheck(nil, nil, errors.New("network unreachable"))
+	assert.NoError(t, check.Check(context.Background(), "app.example.com"))
+}
+
+func TestDNSCheckPassesWhenLookupFails(t *testing.T) {
+	check, _ := fakeDNSCheck(nil, []net.IP{net.ParseIP("203.0.113.10")}, nil)
+	check.lookupIP = func(_ context.Context, host string) ([]net.IP, error) {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+	}
+	assert.NoError(t, check.Check(context.Background(), "app.example.com"))
+}
+
+func TestNewDNSCheckFromEnv(t *testing.T) {
+	t.Setenv("IOP_SKIP_DNS_CHECK", "true")
+	assert.Nil(t, NewDNSCheckFromEnv())
+
+	t.Setenv("IOP_SKIP_DNS_CHECK", "")
+	t.Setenv("IOP_PUBLIC_IPS", "203.0.113.10, 2001:db8::10")
+	check := NewDNSCheckFromEnv()
+	require.NotNil(t, check)
+	ips, err := check.publicIPs(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, ips, 2)
+}
@@ -0,0 +1,221 @@
+package cert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+)
+
+// DefaultExpiryThresholds are the times before expiry at which a certificate
+// raises an alert, overridden with IOP_CERT_EXPIRY_ALERTS=14d,7d,1d
+var DefaultExpiryThresholds = []time.Duration{14 * 24 * time.Hour, 7 * 24 * time.Hour, 3 * 24 * time.Hour, 24 * time.Hour}
+
+// renewalFailureAlert is how many renewals in a row must fail before an
+// alert is raised. Failed renewals are retried an hour or more apart.
+const renewalFailureAlert = 3
+
+// How often certificates are checked against the thresholds
+const expiryCheckInterval = time.Hour
+
+// ExpiringCertificate is an active certificate close to its expiry
+type ExpiringCertificate struct {
+	Hostname        string    `json:"hostname"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	DaysLeft        int       `json:"days_left"` // Negative once expired
+	RenewalAttempts int       `json:"renewal_attempts,omitempty"`
+	RenewalError    string    `json:"renewal_error,omitempty"`
+}
+
+// ParseDays parses a duration that may be given in days, e.g. "14d" or "36h"
+func ParseDays(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// ExpiryThresholdsFromEnv returns the alert thresholds from
+// IOP_CERT_EXPIRY_ALERTS, longest first, or the defaults
+func ExpiryThresholdsFromEnv() ([]time.Duration, error) {
+	configured := os.Getenv("IOP_CERT_EXPIRY_ALERTS")
+	if configured == "" {
+		return DefaultExpiryThresholds, nil
+	}
+
+	var thresholds []time.Duration
+	for _, value := range strings.Split(configured, ",") {
+		d, err := ParseDays(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("IOP_CERT_EXPIRY_ALERTS: %w", err)
+		}
+		thresholds = append(thresholds, d)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+	return thresholds, nil
+}
+
+// Expiring returns the active certificates expiring within the given time,
+// including expired ones, soonest first
+func (m *Manager) Expiring(within time.Duration, now time.Time) []ExpiringCertificate {
+	expiring := []ExpiringCertificate{}
+	for hostname, host := range m.state.GetAllHosts() {
+		certificate := host.Certificate
+		if certificate == nil || certificate.Status != "active" || certificate.ExpiresAt.IsZero() {
+			continue
+		}
+		if certificate.ExpiresAt.Sub(now) > within {
+			continue
+		}
+		expiring = append(expiring, ExpiringCertificate{
+			Hostname:        hostname,
+			ExpiresAt:       certificate.ExpiresAt,
+			DaysLeft:        daysLeft(certificate.ExpiresAt, now),
+			RenewalAttempts: certificate.RenewalAttempts,
+			RenewalError:    certificate.RenewalError,
+		})
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		if !expiring[i].ExpiresAt.Equal(expiring[j].ExpiresAt) {
+			return expiring[i].ExpiresAt.Before(expiring[j].ExpiresAt)
+		}
+		return expiring[i].Hostname < expiring[j].Hostname
+	})
+	return expiring
+}
+
+// daysLeft rounds the time to expiry down to whole days
+func daysLeft(expiresAt, now time.Time) int {
+	left := expiresAt.Sub(now)
+	days := int(left / (24 * time.Hour))
+	if left < 0 && left%(24*time.Hour) != 0 {
+		days--
+	}
+	return days
+}
+
+// ExpiryMonitor raises an alert once when a certificate crosses each expiry
+// threshold, and when its renewal keeps failing. Alerts already raised are
+// only remembered in memory, so a restart may repeat the latest one.
+type ExpiryMonitor struct {
+	manager    *Manager
+	thresholds []time.Duration // Longest first
+
+	mu     sync.Mutex
+	alerts map[string]expiryAlerts // By hostname
+}
+
+// expiryAlerts is what was already raised for one certificate
+type expiryAlerts struct {
+	expiresAt       time.Time     // Identifies the certificate, a renewed one starts over
+	threshold       time.Duration // The shortest threshold alerted, 0 for none
+	renewalFailures int           // Renewal attempts when the renewal alert was raised
+}
+
+// NewExpiryMonitor creates a monitor for the manager's certificates
+func NewExpiryMonitor(m *Manager, thresholds []time.Duration) *ExpiryMonitor {
+	return &ExpiryMonitor{
+		manager:    m,
+		thresholds: thresholds,
+		alerts:     make(map[string]expiryAlerts),
+	}
+}
+
+// Run checks certificates every hour until the context is cancelled
+func (e *ExpiryMonitor) Run(ctx context.Context) {
+	log.Println("[CERT] Starting certificate expiry monitor")
+
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	e.Check(time.Now())
+	for {
+		select {
+		case <-ticker.C:
+			e.Check(time.Now())
+		case <-ctx.Done():
+			log.Println("[CERT] Stopping certificate expiry monitor")
+			return
+		}
+	}
+}
+
+// Check publishes the alerts due at now
+func (e *ExpiryMonitor) Check(now time.Time) {
+	if len(e.thresholds) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Renewals start before the longest threshold, their failures count too
+	window := e.thresholds[0]
+	if window < renewBefore+renewalSpread {
+		window = renewBefore + renewalSpread
+	}
+
+	seen := make(map[string]bool)
+	for _, certificate := range e.manager.Expiring(window, now) {
+		seen[certificate.Hostname] = true
+
+		alerts := e.alerts[certificate.Hostname]
+		if !alerts.expiresAt.Equal(certificate.ExpiresAt) {
+			alerts = expiryAlerts{expiresAt: certificate.ExpiresAt}
+		}
+
+		// Only the shortest threshold crossed raises an alert, so a proxy
+		// starting 2 days before expiry doesn't send the 14 and 7 day ones
+		left := certificate.ExpiresAt.Sub(now)
+		var crossed time.Duration
+		for _, threshold := range e.thresholds {
+			if left <= threshold {
+				crossed = threshold
+			}
+		}
+		if crossed > 0 && (alerts.threshold == 0 || crossed < alerts.threshold) {
+			alerts.threshold = crossed
+			log.Printf("[CERT] [%s] Certificate expires in %d days", certificate.Hostname, certificate.DaysLeft)
+			e.manager.publish(core.CertificateExpiring{
+				BaseEvent: core.BaseEvent{Timestamp: now, Hostname: certificate.Hostname},
+				ExpiresAt: certificate.ExpiresAt,
+				DaysLeft:  certificate.DaysLeft,
+			})
+		}
+
+		if certificate.RenewalAttempts >= renewalFailureAlert && certificate.RenewalAttempts > alerts.renewalFailures {
+			alerts.renewalFailures = certificate.RenewalAttempts
+			log.Printf("[CERT] [%s] Renewal failed %d times in a row", certificate.Hostname, certificate.RenewalAttempts)
+			e.manager.publish(core.CertificateRenewalFailing{
+				BaseEvent: core.BaseEvent{Timestamp: now, Hostname: certificate.Hostname},
+				Attempts:  certificate.RenewalAttempts,
+				ExpiresAt: certificate.ExpiresAt,
+				Error:     certificate.RenewalError,
+			})
+		}
+
+		e.alerts[certificate.Hostname] = alerts
+	}
+
+	// Renewed or removed certificates start over
+	for hostname := range e.alerts {
+		if !seen[hostname] {
+			delete(e.alerts, hostname)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cert
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drain returns the events published so far
+func drain(events <-chan core.Event) []core.Event {
+	var out []core.Event
+	for {
+		select {
+		case event := <-events:
+			out = append(out, event)
+		default:
+			return out
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	d, err := ParseDays("14d")
+	require.NoError(t, err)
+	assert.Equal(t, 14*24*time.Hour, d)
+	d, err = ParseDays("36h")
+	require.NoError(t, err)
+	assert.Equal(t, 36*time.Hour, d)
+	for _, invalid := range []string{"", "d", "-2d", "soon"} {
+		_, err := ParseDays(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	t.Setenv("IOP_CERT_EXPIRY_ALERTS", "1d, 10d")
+	thresholds, err := ExpiryThresholdsFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{10 * 24 * time.Hour, 24 * time.Hour}, thresholds)
+}
+
+func TestExpiryMonitor(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	for _, hostname := range []string{"soon.example.com", "later.example.com", "pending.example.com"} {
+		require.NoError(t, st.DeployHost(hostname, "app:3000", "blog", "web", "/up", true))
+	}
+	require.NoError(t, st.UpdateCertificateStatus("soon.example.com", &state.CertificateStatus{Status: "active", ExpiresAt: now.Add(2*24*time.Hour + time.Hour)}))
+	require.NoError(t, st.UpdateCertificateStatus("later.example.com", &state.CertificateStatus{Status: "active", ExpiresAt: now.Add(60 * 24 * time.Hour)}))
+
+	bus := events.NewSimpleBus()
+	published := bus.Subscribe()
+	m := &Manager{state: st, events: bus}
+
+	expiring := m.Expiring(14*24*time.Hour, now)
+	require.Len(t, expiring, 1)
+	assert.Equal(t, "soon.example.com", expiring[0].Hostname)
+	assert.Equal(t, 2, expiring[0].DaysLeft)
+	assert.Len(t, m.Expiring(90*24*time.Hour, now), 2)
+
+	// Only the shortest threshold crossed alerts, and only once
+	monitor := NewExpiryMonitor(m, DefaultExpiryThresholds)
+	monitor.Check(now)
+	monitor.Check(now.Add(time.Hour))
+	require.Len(t, drain(published), 1)
+	assert.Equal(t, 3*24*time.Hour, monitor.alerts["soon.example.com"].threshold)
+
+	// The next threshold alerts again
+	monitor.Check(now.Add(36 * time.Hour))
+	alerts := drain(published)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 0, alerts[0].(core.CertificateExpiring).DaysLeft)
+
+	// Renewals failing repeatedly alert
+	require.NoError(t, st.UpdateCertificateStatus("soon.example.com", &state.CertificateStatus{
+		Status:          "active",
+		ExpiresAt:       now.Add(2*24*time.Hour + time.Hour),
+		RenewalAttempts: renewalFailureAlert,
+		RenewalError:    "rate limited",
+	}))
+	monitor.Check(now.Add(37 * time.Hour))
+	alerts = drain(published)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, renewalFailureAlert, alerts[0].(core.CertificateRenewalFailing).Attempts)
+
+	// A renewed certificate starts over
+	require.NoError(t, st.UpdateCertificateStatus("soon.example.com", &state.CertificateStatus{Status: "active", ExpiresAt: now.Add(90 * 24 * time.Hour)}))
+	monitor.Check(now.Add(38 * time.Hour))
+	assert.Empty(t, drain(published))
+	assert.Empty(t, monitor.alerts)
+}
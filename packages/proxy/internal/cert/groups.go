@@ -0,0 +1,185 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// Regroup brings active certificates in line with the certificate groups,
+// e.g. after a project was grouped or ungrouped: each group whose
+// certificate doesn't name exactly its hosts gets a new one, and hosts no
+// longer grouped get their own. Current certificates are served until then.
+func (m *Manager) Regroup() {
+	ordered := make(map[string]bool) // By the group's first hostname
+	for hostname, host := range m.state.GetAllHosts() {
+		if host.Certificate == nil || host.Certificate.Status != "active" {
+			continue
+		}
+
+		group := m.state.CertGroup(hostname)
+		if group == nil {
+			group = []string{hostname}
+		}
+		current := host.Certificate.Names
+		if current == nil {
+			current = []string{hostname}
+		}
+		if equalNames(group, current) || ordered[group[0]] {
+			continue
+		}
+		ordered[group[0]] = true
+
+		go func(hostname string) {
+			if err := m.reissue(hostname); err != nil {
+				log.Printf("[CERT] [%s] Failed to regroup certificate, keeping the current one: %v", hostname, err)
+			}
+		}(hostname)
+	}
+}
+
+// reissue orders a new certificate for an active host and the rest of its
+// group. The current certificate stays active if the order fails.
+func (m *Manager) reissue(hostname string) error {
+	names := m.state.CertGroup(hostname)
+	if names == nil {
+		names = []string{hostname}
+	}
+	unlock := m.hosts.lockAll(names)
+	defer unlock()
+
+	host, project, err := m.state.GetHost(hostname)
+	if err != nil {
+		return fmt.Errorf("host not found: %w", err)
+	}
+	if host.Certificate == nil || host.Certificate.Status != "active" {
+		// Acquisition takes the current group into account already
+		return nil
+	}
+
+	if m.slots != nil {
+		m.slots <- struct{}{}
+		defer func() { <-m.slots }()
+	}
+
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	names = m.groupNames(hostname, names)
+	log.Printf("[CERT] [%s] Ordering a certificate for %d hostname(s) to regroup", hostname, len(names))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	derCerts, key, err := m.order(ctx, client, hostname, names)
+	if err != nil {
+		return err
+	}
+	_, err = m.install(hostname, project, names, derCerts, key)
+	return err
+}
+
+// leaveGroup handles the removal of a host that shared a certificate: the
+// group's remaining hosts get a new certificate without it, which replaces
+// the shared files. The files are deleted if no host uses them anymore.
+func (m *Manager) leaveGroup(hostname string, certificate *state.CertificateStatus) {
+	if _, _, err := m.state.GetHost(hostname); err == nil {
+		return
+	}
+	m.certCache.Delete(hostname)
+	m.selfSigned.Delete(hostname)
+
+	for _, name := range certificate.Names {
+		host, _, err := m.state.GetHost(name)
+		if name == hostname || err != nil || host.Certificate == nil || host.Certificate.CertFile != certificate.CertFile {
+			continue
+		}
+		if err := m.reissue(name); err != nil {
+			log.Printf("[CERT] [%s] Failed to regroup certificate after removing %s, it keeps naming it: %v", name, hostname, err)
+		}
+		return
+	}
+
+	unlock := m.hosts.lock(hostname)
+	defer unlock()
+	m.deleteFiles(hostname, certificate)
+}
+
+// groupNames returns the hostnames to put on hostname's certificate: its
+// group, without hosts whose own acquisition failed or whose DNS would make
+// the CA refuse them, so they don't fail the whole order
+func (m *Manager) groupNames(hostname string, group []string) []string {
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	names := make([]string, 0, len(group))
+	for _, name := range group {
+		if name == hostname {
+			names = append(names, name)
+			continue
+		}
+
+		host, _, err := m.state.GetHost(name)
+		if err != nil || (host.Certificate != nil && host.Certificate.Status == "failed") {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		err = m.precheck(ctx, client, name)
+		cancel()
+		if err != nil {
+			log.Printf("[CERT] [%s] Leaving %s off the group certificate: %v", hostname, name, err)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// loadHostCertificate loads a host's active certificate. The hosts of a
+// certificate group share one copy.
+func (m *Manager) loadHostCertificate(hostname string, certificate *state.CertificateStatus) (*tls.Certificate, error) {
+	grouped := len(certificate.Names) > 1
+	if grouped {
+		if cert, ok := m.shared.Load(certificate.CertFile); ok {
+			return cert.(*tls.Certificate), nil
+		}
+	}
+
+	cert, err := m.loadCertificate(hostname, certificate.CertFile, certificate.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if grouped {
+		m.shared.Store(certificate.CertFile, cert)
+	}
+	return cert, nil
+}
+
+// certFileInUse reports whether any host's certificate is stored in certFile
+func (m *Manager) certFileInUse(certFile string) bool {
+	for _, host := range m.state.GetAllHosts() {
+		if host.Certificate != nil && host.Certificate.CertFile == certFile {
+			return true
+		}
+	}
+	return false
+}
+
+// equalNames reports whether two sorted hostname lists are the same
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package cert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedKeyType marks a PEM block holding a private key encrypted with
+// the key passphrase. The original block type is kept in its headers.
+const encryptedKeyType = "IOP ENCRYPTED PRIVATE KEY"
+
+// errKeyPassphraseMissing is returned for encrypted keys when no passphrase is set
+var errKeyPassphraseMissing = errors.New("private key is encrypted, set IOP_KEY_PASSPHRASE or IOP_KEY_PASSPHRASE_FILE")
+
+// keyStore encrypts private keys at rest with AES-256-GCM, using a key derived
+// from a passphrase with scrypt. Without a passphrase keys are written as
+// plain PEM. Plain keys are read either way, so encryption can be turned on
+// before existing keys are migrated.
+type keyStore struct {
+	passphrase []byte
+	salt       []byte // For keys written by this process, so they share one derivation
+
+	mu      sync.Mutex
+	derived map[string]cipher.AEAD // By hex salt, deriving is deliberately slow
+}
+
+// newKeyStoreFromEnv reads the passphrase from IOP_KEY_PASSPHRASE or the file
+// named by IOP_KEY_PASSPHRASE_FILE, e.g. a secret mounted by a KMS or
+// orchestrator. Without either keys aren't encrypted.
+func newKeyStoreFromEnv() (*keyStore, error) {
+	passphrase := os.Getenv("IOP_KEY_PASSPHRASE")
+	if path := os.Getenv("IOP_KEY_PASSPHRASE_FILE"); path != "" {
+		if passphrase != "" {
+			return nil, fmt.Errorf("set only one of IOP_KEY_PASSPHRASE and IOP_KEY_PASSPHRASE_FILE")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key passphrase: %w", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+		if passphrase == "" {
+			return nil, fmt.Errorf("key passphrase file %s is empty", path)
+		}
+	}
+	return newKeyStore(passphrase), nil
+}
+
+// newKeyStore creates a key store, an empty passphrase disables encryption
+func newKeyStore(passphrase string) *keyStore {
+	ks := &keyStore{derived: make(map[string]cipher.AEAD)}
+	if passphrase != "" {
+		ks.passphrase = []byte(passphrase)
+		ks.salt = make([]byte, 16)
+		rand.Read(ks.salt)
+	}
+	return ks
+}
+
+// encrypting reports whether keys are written encrypted. A nil key store
+// doesn't encrypt.
+func (ks *keyStore) encrypting() bool {
+	return ks != nil && ks.passphrase != nil
+}
+
+// encode returns the PEM to write for a private key block, encrypted when a
+// passphrase is set
+func (ks *keyStore) encode(block *pem.Block) ([]byte, error) {
+	if !ks.encrypting() {
+		return pem.EncodeToMemory(block), nil
+	}
+
+	aead, err := ks.aead(ks.salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	// The original type is authenticated, so it can't be swapped
+	sealed := aead.Seal(nonce, nonce, block.Bytes, []byte(block.Type))
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedKeyType,
+		Headers: map[string]string{
+			"Key-Type": block.Type,
+			"Salt":     hex.EncodeToString(ks.salt),
+		},
+		Bytes: sealed,
+	}), nil
+}
+
+// decode returns the plain PEM of a private key file, decrypting it if needed
+func (ks *keyStore) decode(data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != encryptedKeyType {
+		return data, nil
+	}
+	if !ks.encrypting() {
+		return nil, errKeyPassphraseMissing
+	}
+
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("encrypted private key has an invalid salt")
+	}
+	aead, err := ks.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Bytes) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted private key is truncated")
+	}
+	nonce, sealed := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+	keyType := block.Headers["Key-Type"]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(keyType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key, is the passphrase right? %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: keyType, Bytes: plain}), nil
+}
+
+// isEncrypted reports whether a key file's contents are encrypted
+func isEncrypted(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == encryptedKeyType
+}
+
+// aead returns the cipher for a salt, deriving its key on first use
+func (ks *keyStore) aead(salt []byte) (cipher.AEAD, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if aead, ok := ks.derived[hex.EncodeToString(salt)]; ok {
+		return aead, nil
+	}
+	key, err := scrypt.Key(ks.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	ks.derived[hex.EncodeToString(salt)] = aead
+	return aead, nil
+}
+
+// EncryptKeys encrypts the account key and certificate keys still stored as
+// plain PEM with the key passphrase, returning how many it encrypted. Keys
+// that are already encrypted are left alone, so it can be run again.
+func (m *Manager) EncryptKeys() (int, error) {
+	if !m.keys.encrypting() {
+		return 0, fmt.Errorf("no key passphrase is set, set IOP_KEY_PASSPHRASE or IOP_KEY_PASSPHRASE_FILE and restart the proxy")
+	}
+
+	encrypted := 0
+
+	// The account key is only written under m.mu, certificate keys by their
+	// host's acquisition
+	m.mu.Lock()
+	done, err := m.keys.encryptFile(m.state.GetACMEConfig().AccountKeyFile)
+	m.mu.Unlock()
+	if err != nil {
+		return encrypted, err
+	}
+	if done {
+		encrypted++
+	}
+
+	for hostname, host := range m.state.GetRoutes() {
+		if host.Certificate == nil || host.Certificate.KeyFile == "" {
+			continue
+		}
+		unlock := m.hosts.lock(hostname)
+		done, err := m.keys.encryptFile(host.Certificate.KeyFile)
+		unlock()
+		if err != nil {
+			return encrypted, err
+		}
+		if done {
+			encrypted++
+		}
+	}
+	return encrypted, nil
+}
+
+// encryptFile encrypts a plain PEM key file in place. Reports whether it
+// did, missing and already encrypted files are left alone.
+func (ks *keyStore) encryptFile(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if isEncrypted(data) {
+		return false, nil
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	keyPEM, err := ks.encode(block)
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt %s: %w", path, err)
+	}
+
+	// Replaced in one step, a crash leaves the plain or the encrypted key
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, keyPEM, 0600); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", tmpFile, err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		return false, fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,112 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeyPEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestKeyStore(t *testing.T) {
+	plain := testKeyPEM(t)
+	block, _ := pem.Decode(plain)
+
+	// Without a passphrase keys are written as they are
+	var none *keyStore
+	data, err := none.encode(block)
+	require.NoError(t, err)
+	assert.Equal(t, plain, data)
+
+	ks := newKeyStore("correct horse")
+	encrypted, err := ks.encode(block)
+	require.NoError(t, err)
+	assert.True(t, isEncrypted(encrypted))
+	assert.NotContains(t, string(encrypted), string(plain))
+
+	decrypted, err := ks.decode(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	// Another process with the same passphrase reads it, plain keys pass through
+	decrypted, err = newKeyStore("correct horse").decode(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+	decrypted, err = ks.decode(plain)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	// A wrong or missing passphrase fails
+	_, err = newKeyStore("wrong").decode(encrypted)
+	assert.Error(t, err)
+	_, err = none.decode(encrypted)
+	assert.ErrorIs(t, err, errKeyPassphraseMissing)
+}
+
+func TestKeyStoreFromEnv(t *testing.T) {
+	t.Setenv("IOP_KEY_PASSPHRASE", "")
+	t.Setenv("IOP_KEY_PASSPHRASE_FILE", "")
+	ks, err := newKeyStoreFromEnv()
+	require.NoError(t, err)
+	assert.False(t, ks.encrypting())
+
+	path := filepath.Join(t.TempDir(), "passphrase")
+	require.NoError(t, os.WriteFile(path, []byte("from a file\n"), 0600))
+	t.Setenv("IOP_KEY_PASSPHRASE_FILE", path)
+	ks, err = newKeyStoreFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "from a file", string(ks.passphrase))
+
+	t.Setenv("IOP_KEY_PASSPHRASE", "both")
+	_, err = newKeyStoreFromEnv()
+	assert.Error(t, err)
+}
+
+func TestEncryptKeys(t *testing.T) {
+	dir := t.TempDir()
+	st := state.NewState(filepath.Join(dir, "state.json"))
+	accountKey := filepath.Join(dir, "account.key")
+	st.LetsEncrypt.AccountKeyFile = accountKey
+	require.NoError(t, os.WriteFile(accountKey, testKeyPEM(t), 0600))
+
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	hostKey := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(hostKey, testKeyPEM(t), 0600))
+	require.NoError(t, st.UpdateCertificateStatus("app.example.com", &state.CertificateStatus{Status: "active", KeyFile: hostKey}))
+
+	// Nothing to encrypt with without a passphrase
+	_, err := (&Manager{state: st}).EncryptKeys()
+	assert.Error(t, err)
+
+	m := &Manager{state: st, keys: newKeyStore("secret")}
+	encrypted, err := m.EncryptKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 2, encrypted)
+	for _, path := range []string{accountKey, hostKey} {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, isEncrypted(data), path)
+	}
+
+	// The account key still loads, and running again changes nothing
+	_, err = m.loadOrCreateAccountKey()
+	require.NoError(t, err)
+	encrypted, err = m.EncryptKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 0, encrypted)
+}
@@ -0,0 +1,67 @@
+package cert
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxConcurrentAcquisitions bounds the ACME orders in flight at once, so a
+// burst of deploys doesn't open hundreds of orders with the CA
+const maxConcurrentAcquisitions = 8
+
+// hostLocks serializes work on one hostname's certificate while different
+// hostnames proceed in parallel. Locks are dropped once nobody holds or
+// waits for them, so the map only holds hostnames being worked on.
+type hostLocks struct {
+	mu    sync.Mutex
+	locks map[string]*hostLock
+}
+
+type hostLock struct {
+	mu   sync.Mutex
+	refs int // Holders and waiters
+}
+
+// lock locks a hostname and returns the function that unlocks it
+func (h *hostLocks) lock(hostname string) func() {
+	h.mu.Lock()
+	if h.locks == nil {
+		h.locks = make(map[string]*hostLock)
+	}
+	l := h.locks[hostname]
+	if l == nil {
+		l = &hostLock{}
+		h.locks[hostname] = l
+	}
+	l.refs++
+	h.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(h.locks, hostname)
+		}
+	}
+}
+
+// lockAll locks several hostnames, e.g. a certificate group, and returns the
+// function that unlocks them. They are locked in sorted order, so callers
+// locking overlapping sets can't deadlock.
+func (h *hostLocks) lockAll(hostnames []string) func() {
+	sorted := append([]string{}, hostnames...)
+	sort.Strings(sorted)
+
+	unlocks := make([]func(), 0, len(sorted))
+	for _, hostname := range sorted {
+		unlocks = append(unlocks, h.lock(hostname))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package cert
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostLocks(t *testing.T) {
+	var locks hostLocks
+
+	// Different hostnames don't wait for each other
+	unlockA := locks.lock("a.example.com")
+	acquired := make(chan struct{})
+	go func() {
+		unlock := locks.lock("b.example.com")
+		defer unlock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("b.example.com waited for a.example.com")
+	}
+
+	// The same hostname does
+	acquired = make(chan struct{})
+	go func() {
+		unlock := locks.lock("a.example.com")
+		defer unlock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a.example.com was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockA()
+	<-acquired
+
+	// Many holders in turn, then nothing is left behind
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := locks.lock("c.example.com")
+			counter++
+			unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 50, counter)
+	assert.Empty(t, locks.locks)
+}
+
+func TestHostLocksLockAll(t *testing.T) {
+	var locks hostLocks
+
+	// Overlapping sets in any order don't deadlock
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			locks.lockAll([]string{"b.example.com", "a.example.com"})()
+		}()
+		go func() {
+			defer wg.Done()
+			locks.lockAll([]string{"a.example.com", "b.example.com", "c.example.com"})()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lockAll deadlocked")
+	}
+
+	// Each hostname of the set is held
+	unlock := locks.lockAll([]string{"a.example.com", "b.example.com"})
+	acquired := make(chan struct{})
+	go func() {
+		locks.lock("b.example.com")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("b.example.com was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+	assert.Empty(t, locks.locks)
+}
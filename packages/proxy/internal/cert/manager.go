@@ -9,33 +9,63 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/elitan/iop/proxy/internal/core"
 	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/tracing"
 	"golang.org/x/crypto/acme"
 )
 
 type Manager struct {
 	state      *state.State
 	client     *acme.Client
+	staging    *acme.Client // Let's Encrypt staging, for promotions, see promote.go
 	accountKey crypto.Signer
-	httpTokens sync.Map // map[token]keyAuth for HTTP-01 challenges
-	certCache  sync.Map // map[hostname]*tls.Certificate
-	mu         sync.Mutex
+	httpTokens sync.Map      // map[token]keyAuth for HTTP-01 challenges
+	certCache  sync.Map      // map[hostname]*tls.Certificate
+	selfSigned sync.Map      // map[hostname]*tls.Certificate, served until ACME succeeds
+	shared     sync.Map      // map[certFile]*tls.Certificate, one copy for all hosts of a certificate group
+	onDemand   sync.Map      // map[hostname]chan struct{}, closed when on-demand acquisition ends
+	issued     issueWindow   // On-demand acquisitions started in the last hour, see ondemand.go
+	renewing   sync.Map      // map[hostname]struct{}, renewals in progress
+	mu         sync.RWMutex  // Guards the account key and client, acquisitions only read them
+	hosts      hostLocks     // One acquisition per hostname at a time
+	slots      chan struct{} // Bounds concurrent acquisitions, see maxConcurrentAcquisitions
+	events     core.EventBus
+	dnsCheck   *DNSCheck // nil when disabled
+	caaCheck   *CAACheck // nil when disabled
+	keys       *keyStore // Encrypts private keys at rest when a passphrase is set
+	revokeOld  bool      // Revoke the certificates of removed hosts, IOP_REVOKE_ON_REMOVE
 }
 
 // NewManager creates a new certificate manager
 func NewManager(st *state.State) (*Manager, error) {
 	m := &Manager{
-		state: st,
+		state:    st,
+		dnsCheck: NewDNSCheckFromEnv(),
+		caaCheck: NewCAACheckFromEnv(),
+		slots:    make(chan struct{}, maxConcurrentAcquisitions),
 	}
+	if revoke := os.Getenv("IOP_REVOKE_ON_REMOVE"); revoke == "true" || revoke == "1" {
+		m.revokeOld = true
+	}
+
+	keys, err := newKeyStoreFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	m.keys = keys
 
 	// Load or create account key
 	accountKey, err := m.loadOrCreateAccountKey()
@@ -62,6 +92,18 @@ func NewManager(st *state.State) (*Manager, error) {
 	return m, nil
 }
 
+// SetEventBus makes the manager publish certificate events
+func (m *Manager) SetEventBus(events core.EventBus) {
+	m.events = events
+}
+
+// publish sends an event if an event bus is configured
+func (m *Manager) publish(event core.Event) {
+	if m.events != nil {
+		m.events.Publish(event)
+	}
+}
+
 // initACMEClient initializes or reinitializes the ACME client with current configuration
 func (m *Manager) initACMEClient() error {
 	// Create ACME client with proper HTTP transport configuration
@@ -76,6 +118,7 @@ func (m *Manager) initACMEClient() error {
 		},
 	}
 
+	m.staging = nil // Registered again with the current account key on next use
 	m.client = &acme.Client{
 		Key:          m.accountKey,
 		DirectoryURL: m.state.LetsEncrypt.DirectoryURL,
@@ -106,9 +149,69 @@ func (m *Manager) UpdateACMEClient() error {
 	return nil
 }
 
+// Watch makes the manager react to state changes as they happen: newly
+// deployed hosts start acquiring their certificate right away and the
+// certificates of removed hosts are deleted. The acquisition worker still
+// retries what fails.
+func (m *Manager) Watch() {
+	m.state.OnCertChanged(func(change state.CertChange) {
+		if change.Status != "pending" {
+			return
+		}
+		go func() {
+			if err := m.AcquireCertificate(change.Hostname); err != nil {
+				log.Printf("[CERT] [%s] Certificate acquisition failed, the background worker will retry: %v", change.Hostname, err)
+			}
+		}()
+	})
+	m.state.OnHostChanged(func(change state.HostChange) {
+		if !change.Removed {
+			return
+		}
+		m.certCache.Delete(change.Hostname)
+		m.selfSigned.Delete(change.Hostname)
+		if change.Certificate != nil {
+			go m.removeCertificate(change.Hostname, change.Certificate)
+		}
+	})
+}
+
+// Reload re-reads the account key and certificates after the state was
+// replaced, e.g. by restoring a backup
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Printf("[CERT] Reloading account key and certificates...")
+
+	accountKey, err := m.loadOrCreateAccountKey()
+	if err != nil {
+		return fmt.Errorf("failed to load account key: %w", err)
+	}
+	m.accountKey = accountKey
+
+	if err := m.initACMEClient(); err != nil {
+		return err
+	}
+	if err := m.registerAccount(); err != nil {
+		// Certificates already on disk keep working, acquisition retries later
+		log.Printf("[CERT] Failed to register account after reload: %v", err)
+	}
+
+	m.certCache.Range(func(key, _ interface{}) bool {
+		m.certCache.Delete(key)
+		return true
+	})
+	m.shared.Range(func(key, _ interface{}) bool {
+		m.shared.Delete(key)
+		return true
+	})
+	return m.loadCertificates()
+}
+
 // GetCertificate returns a certificate for the given hostname
 func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	hostname := hello.ServerName
+	hostname := strings.ToLower(hello.ServerName)
 
 	// Check cache first
 	if cert, ok := m.certCache.Load(hostname); ok {
@@ -118,14 +221,21 @@ func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate,
 	// Check if we have a certificate on disk
 	host, _, err := m.state.GetHost(hostname)
 	if err != nil {
+		if m.state.AllowsOnDemand(hostname) {
+			return m.onDemandCertificate(hello)
+		}
 		return nil, fmt.Errorf("unknown host: %s", hostname)
 	}
 
+	if ServesSelfSigned(host) {
+		return m.fallbackCertificate(hostname)
+	}
+
 	if host.Certificate == nil || host.Certificate.Status != "active" {
 		return nil, fmt.Errorf("no active certificate for host: %s", hostname)
 	}
 
-	cert, err := m.loadCertificate(hostname, host.Certificate.CertFile, host.Certificate.KeyFile)
+	cert, err := m.loadHostCertificate(hostname, host.Certificate)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load certificate: %w", err)
 	}
@@ -144,23 +254,42 @@ func (m *Manager) ServeHTTPChallenge(token string) (string, bool) {
 	return "", false
 }
 
-// AcquireCertificate attempts to acquire a certificate for the given hostname
-func (m *Manager) AcquireCertificate(hostname string) error {
+// AcquireCertificate attempts to acquire a certificate for the given
+// hostname. Hosts of a certificate group get one certificate naming the
+// whole group.
+func (m *Manager) AcquireCertificate(hostname string) (err error) {
 	log.Printf("[CERT] [%s] Certificate acquisition request received", hostname)
 
-	// Use a per-hostname mutex to prevent concurrent acquisition attempts for the same domain
-	// This prevents ACME client race conditions that cause hanging
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	_, span := tracing.Start(context.Background(), "cert.acquire", tracing.SpanKindInternal,
+		tracing.String("host", hostname),
+	)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	// One attempt per hostname at a time, other hostnames proceed in
+	// parallel. A group's hostnames are locked together.
+	names := m.state.CertGroup(hostname)
+	if names == nil {
+		names = []string{hostname}
+	}
+	unlock := m.hosts.lockAll(names)
+	defer unlock()
 
 	log.Printf("[CERT] [%s] Acquired certificate acquisition lock", hostname)
 
-	host, _, err := m.state.GetHost(hostname)
+	host, project, err := m.state.GetHost(hostname)
 	if err != nil {
 		log.Printf("[CERT] [%s] Host not found in state: %v", hostname, err)
 		return fmt.Errorf("host not found: %w", err)
 	}
 
+	if host.Mode == state.HostModePassthrough {
+		log.Printf("[CERT] [%s] Passthrough host terminates TLS itself, skipping acquisition", hostname)
+		return nil
+	}
+
 	if host.Certificate == nil {
 		log.Printf("[CERT] [%s] Initializing new certificate status", hostname)
 		host.Certificate = &state.CertificateStatus{
@@ -176,28 +305,158 @@ func (m *Manager) AcquireCertificate(hostname string) error {
 		return nil
 	}
 
+	// Asking again before a rate limit resets only extends it
+	if host.Certificate.ErrorType == ErrorRateLimited && time.Now().Before(host.Certificate.NextAttempt) {
+		log.Printf("[CERT] [%s] Rate limited by the CA until %s, skipping", hostname, host.Certificate.NextAttempt.Format(time.RFC3339))
+		return nil
+	}
+
 	if host.Certificate.Status == "acquiring" && host.Certificate.AttemptCount > 0 && time.Since(host.Certificate.LastAttempt) < 30*time.Second {
 		log.Printf("[CERT] [%s] Recent acquisition attempt in progress (last attempt %v ago), skipping", hostname, time.Since(host.Certificate.LastAttempt))
 		return nil
 	}
 
-	// Update status
+	// Wait for a free slot, keeping the number of open orders bounded
+	if m.slots != nil {
+		m.slots <- struct{}{}
+		defer func() { <-m.slots }()
+	}
+
+	// The client in use when the attempt starts serves the whole order, even
+	// if the CA is switched meanwhile
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	// Validation would fail while DNS points elsewhere, and the CA refuses
+	// orders its CAA records or broken DNSSEC forbid, both counting against
+	// its limits, so wait for a DNS change without using up an attempt
+	precheckCtx, precheckCancel := context.WithTimeout(context.Background(), 20*time.Second)
+	err = m.precheck(precheckCtx, client, hostname)
+	precheckCancel()
+	if err != nil {
+		errorType, backoff := precheckErrorType(err)
+		log.Printf("[CERT] [%s] %v, re-checking in %s", hostname, err, backoff)
+		host.Certificate.Status = "acquiring"
+		host.Certificate.ErrorType = errorType
+		host.Certificate.LastError = err.Error()
+		host.Certificate.NextAttempt = time.Now().Add(backoff)
+		if updateErr := m.state.UpdateCertificateStatus(hostname, host.Certificate); updateErr != nil {
+			log.Printf("[CERT] [%s] Failed to update certificate status: %v", hostname, updateErr)
+		}
+		return err
+	}
+	names = m.groupNames(hostname, names)
+
+	// Record the attempt, failures count from it
 	host.Certificate.Status = "acquiring"
 	host.Certificate.LastAttempt = time.Now()
 	host.Certificate.AttemptCount++
+	if err := m.state.UpdateCertificateStatus(hostname, host.Certificate); err != nil {
+		return err
+	}
 
 	log.Printf("[CERT] [%s] Starting certificate acquisition (attempt %d/%d)", hostname, host.Certificate.AttemptCount, host.Certificate.MaxAttempts)
+	span.SetAttributes(
+		tracing.Int("cert.attempt", host.Certificate.AttemptCount),
+		tracing.Int("cert.names", len(names)),
+		tracing.String("acme.directory", client.DirectoryURL),
+	)
 
 	// Create order with shorter timeout to prevent hanging
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	log.Printf("[CERT] [%s] Creating ACME order with Let's Encrypt (timeout: 30s)", hostname)
-	log.Printf("[CERT] [%s] ACME directory URL: %s", hostname, m.client.DirectoryURL)
-	log.Printf("[CERT] [%s] Attempting AuthorizeOrder for domain: %s", hostname, hostname)
+	derCerts, key, err := m.order(ctx, client, hostname, names)
+	if err != nil {
+		m.updateCertificateError(hostname, err)
+		return err
+	}
+
+	cert, err := m.install(hostname, project, names, derCerts, key)
+	if err != nil {
+		m.updateCertificateError(hostname, err)
+		return err
+	}
+
+	log.Printf("[CERT] [%s] Certificate issued successfully", hostname)
+	m.publish(core.CertificateIssued{
+		BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+		ExpiresAt: cert.NotAfter,
+	})
+
+	// Small delay to ensure all systems are synchronized
+	time.Sleep(500 * time.Millisecond)
+	log.Printf("[CERT] [%s] Certificate acquisition completed and synchronized", hostname)
+
+	return nil
+}
+
+// Precheck runs the DNS, CAA and DNSSEC checks acquisition waits on for a
+// host and the others on its certificate, and returns the DNS changes needed
+func (m *Manager) Precheck(ctx context.Context, hostname string) []string {
+	names := m.state.CertGroup(hostname)
+	if names == nil {
+		names = []string{hostname}
+	}
+
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	var problems []string
+	for _, name := range names {
+		if err := m.precheck(ctx, client, name); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// precheck returns why the CA would refuse to issue for hostname, or fail
+// to validate it: DNS not pointing here, CAA records not allowing the CA or
+// DNSSEC failing validation
+func (m *Manager) precheck(ctx context.Context, client *acme.Client, hostname string) error {
+	if m.dnsCheck != nil {
+		if err := m.dnsCheck.Check(ctx, hostname); err != nil {
+			return err
+		}
+	}
+	if m.caaCheck == nil || client == nil {
+		return nil
+	}
+
+	// The CA names the domains CAA records identify it by in its directory
+	directory, err := client.Discover(ctx)
+	if err != nil {
+		log.Printf("[CERT] [%s] Skipping CAA check, ACME directory unavailable: %v", hostname, err)
+		return nil
+	}
+	return m.caaCheck.Check(ctx, hostname, directory.CAA)
+}
+
+// precheckErrorType classifies a precheck error and says when to check again
+func precheckErrorType(err error) (string, time.Duration) {
+	var caaErr *CAAError
+	var dnssecErr *DNSSECError
+	switch {
+	case errors.As(err, &caaErr):
+		return ErrorCAA, caaBackoff
+	case errors.As(err, &dnssecErr):
+		return ErrorDNSSEC, caaBackoff
+	}
+	return ErrorDNS, dnsBackoff
+}
+
+// order runs an ACME order for names, answering its HTTP-01 challenges, and
+// returns the issued certificate chain and its new private key
+func (m *Manager) order(ctx context.Context, client *acme.Client, hostname string, names []string) ([][]byte, *ecdsa.PrivateKey, error) {
+	log.Printf("[CERT] [%s] Creating ACME order (timeout: 30s)", hostname)
+	log.Printf("[CERT] [%s] ACME directory URL: %s", hostname, client.DirectoryURL)
+	log.Printf("[CERT] [%s] Attempting AuthorizeOrder for domains: %s", hostname, strings.Join(names, ", "))
 
 	orderStart := time.Now()
-	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(hostname))
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
 	orderDuration := time.Since(orderStart)
 
 	if err != nil {
@@ -205,8 +464,7 @@ func (m *Manager) AcquireCertificate(hostname string) error {
 		if ctx.Err() == context.DeadlineExceeded {
 			log.Printf("[CERT] [%s] ACME order creation timed out after 30 seconds", hostname)
 		}
-		m.updateCertificateError(hostname, err)
-		return err
+		return nil, nil, err
 	}
 	log.Printf("[CERT] [%s] ACME order created successfully in %v (status: %s)", hostname, orderDuration, order.Status)
 
@@ -215,11 +473,10 @@ func (m *Manager) AcquireCertificate(hostname string) error {
 	for i, authzURL := range order.AuthzURLs {
 		log.Printf("[CERT] [%s] Processing authorization %d/%d", hostname, i+1, len(order.AuthzURLs))
 
-		authz, err := m.client.GetAuthorization(ctx, authzURL)
+		authz, err := client.GetAuthorization(ctx, authzURL)
 		if err != nil {
 			log.Printf("[CERT] [%s] Failed to get authorization %d: %v", hostname, i+1, err)
-			m.updateCertificateError(hostname, err)
-			return err
+			return nil, nil, err
 		}
 
 		if authz.Status == acme.StatusValid {
@@ -241,18 +498,16 @@ func (m *Manager) AcquireCertificate(hostname string) error {
 		if challenge == nil {
 			err := fmt.Errorf("no HTTP-01 challenge found among %d challenges", len(authz.Challenges))
 			log.Printf("[CERT] [%s] %v", hostname, err)
-			m.updateCertificateError(hostname, err)
-			return err
+			return nil, nil, err
 		}
 
 		log.Printf("[CERT] [%s] Found HTTP-01 challenge: token=%s, status=%s", hostname, challenge.Token, challenge.Status)
 
 		// Prepare challenge response
-		keyAuth, err := m.client.HTTP01ChallengeResponse(challenge.Token)
+		keyAuth, err := client.HTTP01ChallengeResponse(challenge.Token)
 		if err != nil {
 			log.Printf("[CERT] [%s] Failed to prepare challenge response: %v", hostname, err)
-			m.updateCertificateError(hostname, err)
-			return err
+			return nil, nil, err
 		}
 
 		// Store challenge token
@@ -265,23 +520,21 @@ func (m *Manager) AcquireCertificate(hostname string) error {
 
 		// Accept challenge
 		log.Printf("[CERT] [%s] Accepting ACME challenge", hostname)
-		if _, err := m.client.Accept(ctx, challenge); err != nil {
+		if _, err := client.Accept(ctx, challenge); err != nil {
 			log.Printf("[CERT] [%s] Failed to accept challenge: %v", hostname, err)
-			m.updateCertificateError(hostname, err)
-			return err
+			return nil, nil, err
 		}
 		log.Printf("[CERT] [%s] ACME challenge accepted, waiting for validation", hostname)
 
 		// Wait for challenge to complete
 		log.Printf("[CERT] [%s] Waiting for challenge validation...", hostname)
-		authz, err = m.client.WaitAuthorization(ctx, authz.URI)
+		authz, err = client.WaitAuthorization(ctx, authz.URI)
 		if err != nil {
 			log.Printf("[CERT] [%s] Challenge validation failed: %v", hostname, err)
 			if authz != nil && authz.Status == acme.StatusInvalid {
 				log.Printf("[CERT] [%s] DNS validation failed: NXDOMAIN", hostname)
 			}
-			m.updateCertificateError(hostname, err)
-			return err
+			return nil, nil, err
 		}
 
 		log.Printf("[CERT] [%s] ACME challenge validation successful", hostname)
@@ -289,11 +542,10 @@ func (m *Manager) AcquireCertificate(hostname string) error {
 
 	// Wait for order to be ready
 	log.Printf("[CERT] [%s] Waiting for ACME order to be ready for finalization", hostname)
-	order, err = m.client.WaitOrder(ctx, order.URI)
+	order, err = client.WaitOrder(ctx, order.URI)
 	if err != nil {
 		log.Printf("[CERT] [%s] Failed to wait for order: %v", hostname, err)
-		m.updateCertificateError(hostname, err)
-		return err
+		return nil, nil, err
 	}
 	log.Printf("[CERT] [%s] ACME order is ready for finalization", hostname)
 
@@ -302,116 +554,96 @@ func (m *Manager) AcquireCertificate(hostname string) error {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		log.Printf("[CERT] [%s] Failed to generate key: %v", hostname, err)
-		m.updateCertificateError(hostname, err)
-		return err
+		return nil, nil, err
 	}
 
 	template := &x509.CertificateRequest{
 		Subject:  pkix.Name{CommonName: hostname},
-		DNSNames: []string{hostname},
+		DNSNames: names,
 	}
 
 	log.Printf("[CERT] [%s] Creating certificate signing request (CSR)", hostname)
 	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
 	if err != nil {
 		log.Printf("[CERT] [%s] Failed to create CSR: %v", hostname, err)
-		m.updateCertificateError(hostname, err)
-		return err
+		return nil, nil, err
 	}
 
 	// Finalize order
 	log.Printf("[CERT] [%s] Finalizing ACME order with CSR", hostname)
-	derCerts, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	derCerts, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
 	if err != nil {
 		log.Printf("[CERT] [%s] Failed to finalize order: %v", hostname, err)
-		m.updateCertificateError(hostname, err)
-		return err
+		return nil, nil, err
 	}
 	log.Printf("[CERT] [%s] ACME order finalized, certificate obtained", hostname)
 
-	// Save certificate
-	log.Printf("[CERT] [%s] Saving certificate to disk", hostname)
-	certPath := filepath.Join("/var/lib/iop-proxy/certs", hostname, "cert.pem")
-	keyPath := filepath.Join("/var/lib/iop-proxy/certs", hostname, "key.pem")
+	return derCerts, key, nil
+}
 
-	// For local testing, use home directory if we can't write to /var/lib
-	if os.Getuid() != 0 {
-		if homeDir, err := os.UserHomeDir(); err == nil {
-			localCertDir := filepath.Join(homeDir, ".iop-proxy", "certs", hostname)
-			certPath = filepath.Join(localCertDir, "cert.pem")
-			keyPath = filepath.Join(localCertDir, "key.pem")
-		}
+// install saves an issued certificate and makes it the active certificate of
+// every host it names. Files those hosts used before are deleted once no
+// host uses them anymore.
+func (m *Manager) install(hostname, project string, names []string, derCerts [][]byte, key *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	dir := certDir(hostname)
+	if len(names) > 1 {
+		dir = certDir(filepath.Join("_groups", project))
 	}
 
-	if err := m.saveCertificate(hostname, derCerts, key); err != nil {
+	log.Printf("[CERT] [%s] Saving certificate to disk", hostname)
+	if err := m.saveCertificate(dir, derCerts, key); err != nil {
 		log.Printf("[CERT] [%s] Failed to save certificate: %v", hostname, err)
-		m.updateCertificateError(hostname, err)
-		return err
+		return nil, err
 	}
-	log.Printf("[CERT] [%s] Certificate saved to disk: %s", hostname, certPath)
+	log.Printf("[CERT] [%s] Certificate saved to disk: %s", hostname, dir)
 
 	// Parse certificate to get expiry
 	cert, err := x509.ParseCertificate(derCerts[0])
 	if err != nil {
 		log.Printf("[CERT] [%s] Failed to parse certificate: %v", hostname, err)
-		m.updateCertificateError(hostname, err)
-		return err
+		return nil, err
 	}
 
-	// Update state
-	status := &state.CertificateStatus{
+	status := state.CertificateStatus{
 		Status:     "active",
 		AcquiredAt: time.Now(),
 		ExpiresAt:  cert.NotAfter,
-		CertFile:   certPath,
-		KeyFile:    keyPath,
+		CertFile:   filepath.Join(dir, "cert.pem"),
+		KeyFile:    filepath.Join(dir, "key.pem"),
 	}
-
-	log.Printf("[CERT] [%s] Updating certificate status to active (expires: %s)", hostname, cert.NotAfter.Format(time.RFC3339))
-	if err := m.state.UpdateCertificateStatus(hostname, status); err != nil {
-		log.Printf("[CERT] [%s] Failed to update certificate status: %v", hostname, err)
-		return err
+	if len(names) > 1 {
+		status.Names = names
 	}
+	m.shared.Delete(status.CertFile)
 
-	// Clear cache to force reload
-	m.certCache.Delete(hostname)
-
-	log.Printf("[CERT] [%s] Certificate issued successfully", hostname)
-
-	// Small delay to ensure all systems are synchronized
-	time.Sleep(500 * time.Millisecond)
-	log.Printf("[CERT] [%s] Certificate acquisition completed and synchronized", hostname)
-
-	return nil
-}
+	log.Printf("[CERT] [%s] Updating certificate status to active for %s (expires: %s)", hostname, strings.Join(names, ", "), cert.NotAfter.Format(time.RFC3339))
+	previous := make(map[string]*state.CertificateStatus) // By certificate file
+	for _, name := range names {
+		if host, _, err := m.state.GetHost(name); err == nil && host.Certificate != nil && host.Certificate.CertFile != status.CertFile {
+			previous[host.Certificate.CertFile] = host.Certificate
+		}
 
-// RenewCertificate attempts to renew a certificate
-func (m *Manager) RenewCertificate(hostname string) error {
-	host, _, err := m.state.GetHost(hostname)
-	if err != nil {
-		return fmt.Errorf("host not found: %w", err)
-	}
+		certificate := status
+		if err := m.state.UpdateCertificateStatus(name, &certificate); err != nil {
+			log.Printf("[CERT] [%s] Failed to update certificate status: %v", name, err)
+			if name == hostname {
+				return nil, err
+			}
+			continue
+		}
 
-	if host.Certificate == nil {
-		return fmt.Errorf("no certificate to renew")
+		// Clear cache to force reload
+		m.certCache.Delete(name)
+		m.selfSigned.Delete(name)
 	}
 
-	// Update renewal attempt
-	host.Certificate.LastRenewalAttempt = time.Now()
-	host.Certificate.RenewalAttempts++
-	host.Certificate.Status = "renewing"
-
-	// Attempt acquisition (same process as initial acquisition)
-	if err := m.AcquireCertificate(hostname); err != nil {
-		// Restore previous status if renewal fails
-		host.Certificate.Status = "active"
-		return err
+	for certFile, certificate := range previous {
+		if certFile != "" && !m.certFileInUse(certFile) {
+			m.deleteFiles(hostname, certificate)
+		}
 	}
 
-	// Reset renewal attempts on success
-	host.Certificate.RenewalAttempts = 0
-
-	return nil
+	return cert, nil
 }
 
 // loadOrCreateAccountKey loads or creates the ACME account key
@@ -438,6 +670,10 @@ func (m *Manager) loadOrCreateAccountKey() (crypto.Signer, error) {
 
 	// Try to load existing key
 	if data, err := os.ReadFile(keyPath); err == nil {
+		data, err := m.keys.decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("account key %s: %w", keyPath, err)
+		}
 		block, _ := pem.Decode(data)
 		if block == nil {
 			return nil, fmt.Errorf("failed to decode PEM block")
@@ -463,12 +699,15 @@ func (m *Manager) loadOrCreateAccountKey() (crypto.Signer, error) {
 		return nil, fmt.Errorf("failed to marshal key: %w", err)
 	}
 
-	block := &pem.Block{
+	keyPEM, err := m.keys.encode(&pem.Block{
 		Type:  "EC PRIVATE KEY",
 		Bytes: keyBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key: %w", err)
 	}
 
-	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
 		return nil, fmt.Errorf("failed to save key: %w", err)
 	}
 
@@ -490,6 +729,18 @@ func (m *Manager) registerAccount() error {
 		log.Println("[CERT] Registering ACME account without email")
 	}
 
+	if m.state.LetsEncrypt.EABKeyID != "" {
+		key, err := DecodeEABKey(m.state.LetsEncrypt.EABHMACKey)
+		if err != nil {
+			return err
+		}
+		acct.ExternalAccountBinding = &acme.ExternalAccountBinding{
+			KID: m.state.LetsEncrypt.EABKeyID,
+			Key: key,
+		}
+		log.Printf("[CERT] Using External Account Binding with key ID: %s", m.state.LetsEncrypt.EABKeyID)
+	}
+
 	_, err := m.client.Register(ctx, acct, acme.AcceptTOS)
 	if err != nil && err != acme.ErrAccountAlreadyExists {
 		return fmt.Errorf("failed to register account: %w", err)
@@ -499,13 +750,27 @@ func (m *Manager) registerAccount() error {
 	return nil
 }
 
+// DecodeEABKey decodes an External Account Binding MAC key. CAs hand these
+// out base64url encoded, usually without padding.
+func DecodeEABKey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, fmt.Errorf("missing EAB HMAC key")
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EAB HMAC key: %w", err)
+	}
+	return key, nil
+}
+
 // loadCertificates loads all certificates from disk
 func (m *Manager) loadCertificates() error {
 	hosts := m.state.GetAllHosts()
 
 	for hostname, host := range hosts {
 		if host.Certificate != nil && host.Certificate.Status == "active" {
-			cert, err := m.loadCertificate(hostname, host.Certificate.CertFile, host.Certificate.KeyFile)
+			cert, err := m.loadHostCertificate(hostname, host.Certificate)
 			if err != nil {
 				log.Printf("[CERT] [%s] Failed to load certificate: %v", hostname, err)
 				continue
@@ -529,6 +794,9 @@ func (m *Manager) loadCertificate(hostname, certPath, keyPath string) (*tls.Cert
 	if err != nil {
 		return nil, fmt.Errorf("failed to read key: %w", err)
 	}
+	if keyPEM, err = m.keys.decode(keyPEM); err != nil {
+		return nil, err
+	}
 
 	cert, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
@@ -538,9 +806,24 @@ func (m *Manager) loadCertificate(hostname, certPath, keyPath string) (*tls.Cert
 	return &cert, nil
 }
 
-// saveCertificate saves a certificate to disk
-func (m *Manager) saveCertificate(hostname string, derCerts [][]byte, key crypto.PrivateKey) error {
-	certDir := filepath.Join("/var/lib/iop-proxy/certs", hostname)
+// certDir returns the directory of the certificate named name, under the
+// home directory for local testing when not running as root
+func certDir(name string) string {
+	if os.Getuid() != 0 {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, ".iop-proxy", "certs", name)
+		}
+	}
+	return filepath.Join("/var/lib/iop-proxy/certs", name)
+}
+
+// Dir returns the directory certificates are stored in
+func Dir() string {
+	return certDir("")
+}
+
+// saveCertificate saves a certificate and its key to certDir
+func (m *Manager) saveCertificate(certDir string, derCerts [][]byte, key crypto.PrivateKey) error {
 	if err := os.MkdirAll(certDir, 0755); err != nil {
 		return fmt.Errorf("failed to create certificate directory: %w", err)
 	}
@@ -570,12 +853,15 @@ func (m *Manager) saveCertificate(hostname string, derCerts [][]byte, key crypto
 		return fmt.Errorf("failed to marshal key: %w", err)
 	}
 
-	block := &pem.Block{
+	keyPEM, err := m.keys.encode(&pem.Block{
 		Type:  "EC PRIVATE KEY",
 		Bytes: keyBytes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key: %w", err)
 	}
 
-	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
 		return fmt.Errorf("failed to save key: %w", err)
 	}
 
@@ -594,8 +880,16 @@ func (m *Manager) updateCertificateError(hostname string, err error) {
 
 	log.Printf("[CERT] [%s] Current status: %s, attempts: %d/%d", hostname, host.Certificate.Status, host.Certificate.AttemptCount, host.Certificate.MaxAttempts)
 
-	// Schedule next attempt
-	host.Certificate.NextAttempt = time.Now().Add(10 * time.Minute)
+	// Schedule next attempt based on why this one failed
+	errorType, retryIn := ClassifyError(err, time.Now())
+	host.Certificate.ErrorType = errorType
+	host.Certificate.LastError = err.Error()
+	host.Certificate.NextAttempt = time.Now().Add(retryIn)
+
+	// Waiting out a rate limit isn't the domain's fault, don't count it
+	if errorType == ErrorRateLimited && host.Certificate.AttemptCount > 0 {
+		host.Certificate.AttemptCount--
+	}
 
 	// Check if we've exceeded max attempts
 	if host.Certificate.AttemptCount >= host.Certificate.MaxAttempts {
@@ -603,7 +897,7 @@ func (m *Manager) updateCertificateError(hostname string, err error) {
 		log.Printf("[CERT] [%s] Acquisition failed after %d attempts, marking as failed", hostname, host.Certificate.MaxAttempts)
 		log.Printf("[CERT] [%s] Final error: %v", hostname, err)
 	} else {
-		log.Printf("[CERT] [%s] Acquisition failed, scheduling retry in 10 minutes", hostname)
+		log.Printf("[CERT] [%s] Acquisition failed (%s), scheduling retry in %s", hostname, errorType, retryIn.Round(time.Second))
 		log.Printf("[CERT] [%s] Attempt %d/%d, next attempt: %s",
 			hostname,
 			host.Certificate.AttemptCount,
@@ -612,6 +906,13 @@ func (m *Manager) updateCertificateError(hostname string, err error) {
 		log.Printf("[CERT] [%s] Error details: %v", hostname, err)
 	}
 
+	m.publish(core.CertificateFailed{
+		BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+		Error:     err.Error(),
+		Attempts:  host.Certificate.AttemptCount,
+		Final:     host.Certificate.Status == "failed",
+	})
+
 	if err := m.state.UpdateCertificateStatus(hostname, host.Certificate); err != nil {
 		log.Printf("[CERT] [%s] Failed to update certificate status in state: %v", hostname, err)
 	} else {
@@ -0,0 +1,152 @@
+package cert
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// How long a TLS handshake waits for an on-demand certificate. After that the
+// self-signed fallback is served while acquisition carries on in the background.
+const onDemandTimeout = 10 * time.Second
+
+// How long the ask endpoint has to approve a hostname
+const onDemandAskTimeout = 5 * time.Second
+
+// The period on-demand acquisitions are counted over for the rate limit
+const onDemandWindow = time.Hour
+
+// errOnDemandExists means a concurrent handshake already created the host
+var errOnDemandExists = errors.New("on-demand host already exists")
+
+// onDemandCertificate acquires a certificate during the first handshake for
+// an allowed hostname, so custom domains work without deploying them first
+func (m *Manager) onDemandCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	hostname := strings.ToLower(hello.ServerName)
+
+	done := make(chan struct{})
+	if pending, loaded := m.onDemand.LoadOrStore(hostname, done); loaded {
+		// Another handshake already started acquisition, wait for the same one
+		done = pending.(chan struct{})
+	} else if err := m.startOnDemand(hostname, done); err != nil {
+		m.onDemand.Delete(hostname)
+		close(done)
+		if errors.Is(err, errOnDemandExists) {
+			// A concurrent handshake created it and already finished
+			return m.GetCertificate(hello)
+		}
+		log.Printf("[CERT] [%s] On-demand certificate refused: %v", hostname, err)
+		return nil, err
+	}
+
+	select {
+	case <-done:
+	case <-time.After(onDemandTimeout):
+		log.Printf("[CERT] [%s] On-demand certificate not ready after %s, serving self-signed certificate", hostname, onDemandTimeout)
+	}
+
+	// A failed acquisition removed the host again
+	if _, _, err := m.state.GetHost(hostname); err != nil {
+		return nil, fmt.Errorf("no on-demand certificate for %s", hostname)
+	}
+	// The host exists, so this serves the issued or the fallback certificate
+	return m.GetCertificate(hello)
+}
+
+// startOnDemand checks that hostname may get a certificate, creates its host
+// and starts acquisition in the background, closing done when it ends
+func (m *Manager) startOnDemand(hostname string, done chan struct{}) error {
+	onDemand := m.state.GetOnDemandTLS()
+	if onDemand.Ask != "" {
+		if err := askOnDemand(onDemand.Ask, hostname); err != nil {
+			return err
+		}
+	}
+
+	if err := m.state.AddOnDemandHost(hostname); err != nil {
+		if errors.Is(err, state.ErrOnDemandLimit) {
+			return err
+		}
+		return errOnDemandExists
+	}
+	if !m.issued.take(time.Now(), onDemand.IssueLimit()) {
+		m.state.RemoveOnDemandHost(hostname)
+		return fmt.Errorf("on-demand rate limit of %d acquisitions per hour reached", onDemand.IssueLimit())
+	}
+
+	log.Printf("[CERT] [%s] On-demand certificate requested by first TLS handshake", hostname)
+	go func() {
+		defer close(done)
+		defer m.onDemand.Delete(hostname)
+		if err := m.AcquireCertificate(hostname); err != nil {
+			// Removed so hostnames that can't get a certificate don't pile up,
+			// a later handshake tries again within the rate limit
+			log.Printf("[CERT] [%s] On-demand acquisition failed, removing the host: %v", hostname, err)
+			m.selfSigned.Delete(hostname)
+			if err := m.state.RemoveOnDemandHost(hostname); err != nil {
+				log.Printf("[CERT] [%s] Failed to remove on-demand host: %v", hostname, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// askOnDemand asks the configured endpoint whether hostname may get a
+// certificate. Any 2xx answer to GET <ask>?domain=<hostname> approves it.
+func askOnDemand(ask, hostname string) error {
+	endpoint, err := url.Parse(ask)
+	if err != nil {
+		return fmt.Errorf("invalid ask URL %q: %w", ask, err)
+	}
+	query := endpoint.Query()
+	query.Set("domain", hostname)
+	endpoint.RawQuery = query.Encode()
+
+	client := &http.Client{Timeout: onDemandAskTimeout}
+	resp, err := client.Get(endpoint.String())
+	if err != nil {
+		return fmt.Errorf("ask endpoint unavailable: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("not approved by the ask endpoint (status %d)", resp.StatusCode)
+	}
+	return nil
+}
+
+// issueWindow counts the on-demand acquisitions started within the last
+// onDemandWindow
+type issueWindow struct {
+	mu      sync.Mutex
+	started []time.Time
+}
+
+// take records an acquisition starting at now, unless limit acquisitions
+// already started within the window
+func (w *issueWindow) take(now time.Time, limit int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	recent := w.started[:0]
+	for _, started := range w.started {
+		if now.Sub(started) < onDemandWindow {
+			recent = append(recent, started)
+		}
+	}
+	w.started = recent
+
+	if len(w.started) >= limit {
+		return false
+	}
+	w.started = append(w.started, now)
+	return true
+}
@@ -0,0 +1,106 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// onDemandManager returns a manager whose acquisitions stop before
+// contacting the CA, because DNS doesn't point at this server
+func onDemandManager(st *state.State) *Manager {
+	return &Manager{
+		state: st,
+		dnsCheck: &DNSCheck{
+			lookupIP: func(context.Context, string) ([]net.IP, error) {
+				return []net.IP{net.ParseIP("198.51.100.7")}, nil
+			},
+			publicIPs: func(context.Context) ([]net.IP, error) {
+				return []net.IP{net.ParseIP("203.0.113.10")}, nil
+			},
+		},
+	}
+}
+
+func TestOnDemandCertificate(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetOnDemandTLS(state.OnDemandTLS{Allow: []string{"*.customers.example.com"}})
+	m := onDemandManager(st)
+
+	// The failed acquisition removes the host again, nothing is left behind
+	_, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "Acme.Customers.Example.com"})
+	assert.Error(t, err)
+	_, _, err = st.GetHost("acme.customers.example.com")
+	assert.Error(t, err)
+	assert.Empty(t, st.GetAllHosts())
+
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.Error(t, err)
+	_, _, err = st.GetHost("other.example.com")
+	assert.Error(t, err)
+}
+
+func TestOnDemandLimits(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetOnDemandTLS(state.OnDemandTLS{Allow: []string{"*.customers.example.com"}, MaxHosts: 1, RateLimit: 2})
+	m := onDemandManager(st)
+
+	// Once the cap is reached further names are refused without a host
+	require.NoError(t, st.AddOnDemandHost("held.customers.example.com"))
+	_, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "new.customers.example.com"})
+	assert.ErrorIs(t, err, state.ErrOnDemandLimit)
+	_, _, err = st.GetHost("new.customers.example.com")
+	assert.Error(t, err)
+	require.NoError(t, st.RemoveOnDemandHost("held.customers.example.com"))
+
+	// Two acquisitions per hour, the third name waits for the window to pass
+	for _, hostname := range []string{"a.customers.example.com", "b.customers.example.com"} {
+		_, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: hostname})
+		assert.NotErrorIs(t, err, state.ErrOnDemandLimit)
+	}
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "c.customers.example.com"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rate limit")
+	_, _, err = st.GetHost("c.customers.example.com")
+	assert.Error(t, err)
+
+	assert.True(t, m.issued.take(time.Now().Add(onDemandWindow), 2))
+}
+
+func TestOnDemandAsk(t *testing.T) {
+	var asked []string
+	ask := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domain := r.URL.Query().Get("domain")
+		asked = append(asked, domain)
+		if !strings.HasPrefix(domain, "acme.") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ask.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetOnDemandTLS(state.OnDemandTLS{Allow: []string{"*.customers.example.com"}, Ask: ask.URL + "/domains?token=1"})
+	m := onDemandManager(st)
+
+	_, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "made-up.customers.example.com"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not approved")
+
+	// Approved, acquisition then fails on DNS
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "acme.customers.example.com"})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "not approved")
+
+	assert.Equal(t, []string{"made-up.customers.example.com", "acme.customers.example.com"}, asked)
+	assert.Equal(t, 1, len(m.issued.started), "denied names don't count against the rate limit")
+}
@@ -0,0 +1,318 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"golang.org/x/crypto/acme"
+)
+
+// Steps of a promotion, see PromoteCertificates
+const (
+	PromoteStaging    = "staging"
+	PromoteVerify     = "verify"
+	PromoteProduction = "production"
+)
+
+// promoteDialTimeout bounds the TLS connection that checks a host serves
+// its staging certificate
+const promoteDialTimeout = 10 * time.Second
+
+// PromotionStep is the outcome of one step of a host's promotion
+type PromotionStep struct {
+	Name   string `json:"name"`   // staging, verify or production
+	Status string `json:"status"` // ok, failed or skipped
+	Detail string `json:"detail"`
+}
+
+// Promotion is a host's way from a staging to a production certificate
+type Promotion struct {
+	Hostname string          `json:"hostname"`
+	Promoted bool            `json:"promoted"`
+	Steps    []PromotionStep `json:"steps"`
+}
+
+func (p *Promotion) step(name, status, format string, args ...interface{}) {
+	p.Steps = append(p.Steps, PromotionStep{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// failed reports whether a step of the promotion failed
+func (p *Promotion) failed() bool {
+	for _, step := range p.Steps {
+		if step.Status == "failed" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStagingCertificate reports whether a certificate was issued by Let's
+// Encrypt's staging environment, which browsers don't trust
+func IsStagingCertificate(cert *x509.Certificate) bool {
+	issuer := cert.Issuer.CommonName
+	return strings.HasPrefix(issuer, "(STAGING)") || strings.HasPrefix(issuer, "Fake LE")
+}
+
+// PromoteCertificates takes hosts from a staging to a production
+// certificate, so misconfigured DNS or a closed port fails against staging's
+// generous limits instead of production's. Each host first gets a staging
+// certificate, unless it is serving one already, and must then serve it
+// with its chain on port 443. Hosts that pass are issued a production
+// certificate. Without hostnames every host is promoted.
+//
+// In staging mode every host must pass before the proxy leaves staging mode
+// for production, as renewals would otherwise bring back staging
+// certificates. A single host can't be promoted then.
+func (m *Manager) PromoteCertificates(hostnames []string) ([]*Promotion, error) {
+	staging := m.state.GetACMEConfig().Staging
+	if len(hostnames) == 0 {
+		for hostname, host := range m.state.GetAllHosts() {
+			if host.Mode != state.HostModePassthrough && host.SSLEnabled {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+		sort.Strings(hostnames)
+	} else if staging {
+		return nil, fmt.Errorf("staging mode is on, promote every host to leave it")
+	}
+
+	var promotions []*Promotion
+	passed := true
+	for _, hostname := range hostnames {
+		promotion := &Promotion{Hostname: hostname}
+		promotions = append(promotions, promotion)
+		if m.stage(promotion) {
+			m.verify(promotion)
+		}
+		passed = passed && !promotion.failed()
+	}
+
+	if staging {
+		if !passed {
+			for _, promotion := range promotions {
+				if !promotion.failed() && promotion.Steps[len(promotion.Steps)-1].Name == PromoteVerify {
+					promotion.step(PromoteProduction, "skipped", "staging mode stays on until every host passes")
+				}
+			}
+			return promotions, nil
+		}
+		log.Printf("[CERT] Every host passed on staging, switching to production")
+		m.state.SetLetsEncryptStaging(false)
+		if err := m.UpdateACMEClient(); err != nil {
+			return promotions, err
+		}
+	}
+
+	for _, promotion := range promotions {
+		if !promotion.failed() && promotion.Steps[len(promotion.Steps)-1].Name == PromoteVerify {
+			m.produce(promotion)
+		}
+	}
+	return promotions, nil
+}
+
+// stage makes sure a host serves a staging certificate, issuing one when it
+// has none yet. It returns false when there is nothing left to do.
+func (m *Manager) stage(p *Promotion) bool {
+	names := m.state.CertGroup(p.Hostname)
+	if names == nil {
+		names = []string{p.Hostname}
+	}
+	unlock := m.hosts.lockAll(names)
+	defer unlock()
+
+	host, project, err := m.state.GetHost(p.Hostname)
+	if err != nil {
+		p.step(PromoteStaging, "failed", "host not found")
+		return false
+	}
+	if host.Mode == state.HostModePassthrough {
+		p.step(PromoteStaging, "skipped", "passthrough hosts terminate TLS themselves")
+		return false
+	}
+
+	if host.Certificate != nil && host.Certificate.Status == "active" {
+		leaf, err := m.activeLeaf(p.Hostname, host.Certificate)
+		if err != nil {
+			p.step(PromoteStaging, "failed", "%v", err)
+			return false
+		}
+		if !IsStagingCertificate(leaf) {
+			p.step(PromoteStaging, "skipped", "already has a certificate from %s", leaf.Issuer.CommonName)
+			return false
+		}
+		p.step(PromoteStaging, "ok", "serving a staging certificate issued %s", leaf.NotBefore.Format(time.RFC3339))
+		return true
+	}
+
+	client, err := m.stagingClient()
+	if err != nil {
+		p.step(PromoteStaging, "failed", "%v", err)
+		return false
+	}
+
+	if m.slots != nil {
+		m.slots <- struct{}{}
+		defer func() { <-m.slots }()
+	}
+
+	names = m.groupNames(p.Hostname, names)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	derCerts, key, err := m.order(ctx, client, p.Hostname, names)
+	if err == nil {
+		_, err = m.install(p.Hostname, project, names, derCerts, key)
+	}
+	if err != nil {
+		p.step(PromoteStaging, "failed", "%v", err)
+		return false
+	}
+	p.step(PromoteStaging, "ok", "issued a staging certificate")
+	return true
+}
+
+// verify connects to the host on port 443, the way browsers will, and
+// checks it serves its staging certificate with the chain
+func (m *Manager) verify(p *Promotion) {
+	host, _, err := m.state.GetHost(p.Hostname)
+	if err != nil || host.Certificate == nil {
+		p.step(PromoteVerify, "failed", "host has no certificate")
+		return
+	}
+	leaf, err := m.activeLeaf(p.Hostname, host.Certificate)
+	if err != nil {
+		p.step(PromoteVerify, "failed", "%v", err)
+		return
+	}
+
+	dialer := &net.Dialer{Timeout: promoteDialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(p.Hostname, "443"), &tls.Config{
+		ServerName:         p.Hostname,
+		InsecureSkipVerify: true, // Staging roots aren't trusted, the chain is checked below
+	})
+	if err != nil {
+		p.step(PromoteVerify, "failed", "couldn't connect on port 443: %v", err)
+		return
+	}
+	served := conn.ConnectionState().PeerCertificates
+	conn.Close()
+
+	if err := checkServedChain(p.Hostname, leaf, served); err != nil {
+		p.step(PromoteVerify, "failed", "%v", err)
+		return
+	}
+	p.step(PromoteVerify, "ok", "port 443 serves the certificate with its chain")
+}
+
+// checkServedChain checks the certificates a host served are its own
+// certificate followed by the intermediate that signed it
+func checkServedChain(hostname string, expected *x509.Certificate, served []*x509.Certificate) error {
+	if len(served) == 0 {
+		return errors.New("no certificate served on port 443")
+	}
+	if !served[0].Equal(expected) {
+		return fmt.Errorf("port 443 serves another certificate, issued by %s, is DNS pointing at another server?", served[0].Issuer.CommonName)
+	}
+	if err := served[0].VerifyHostname(hostname); err != nil {
+		return err
+	}
+	if len(served) < 2 {
+		return errors.New("port 443 serves the certificate without its intermediate")
+	}
+	if err := served[0].CheckSignatureFrom(served[1]); err != nil {
+		return fmt.Errorf("the served intermediate didn't sign the certificate: %w", err)
+	}
+	return nil
+}
+
+// produce issues the production certificate that replaces the staging one
+func (m *Manager) produce(p *Promotion) {
+	names := m.state.CertGroup(p.Hostname)
+	if names == nil {
+		names = []string{p.Hostname}
+	}
+	unlock := m.hosts.lockAll(names)
+	defer unlock()
+
+	_, project, err := m.state.GetHost(p.Hostname)
+	if err != nil {
+		p.step(PromoteProduction, "failed", "host not found")
+		return
+	}
+
+	if m.slots != nil {
+		m.slots <- struct{}{}
+		defer func() { <-m.slots }()
+	}
+
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	names = m.groupNames(p.Hostname, names)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	derCerts, key, err := m.order(ctx, client, p.Hostname, names)
+	if err != nil {
+		p.step(PromoteProduction, "failed", "%v", err)
+		return
+	}
+	cert, err := m.install(p.Hostname, project, names, derCerts, key)
+	if err != nil {
+		p.step(PromoteProduction, "failed", "%v", err)
+		return
+	}
+	log.Printf("[CERT] [%s] Promoted to a certificate from %s", p.Hostname, cert.Issuer.CommonName)
+	p.Promoted = true
+	p.step(PromoteProduction, "ok", "issued by %s, expires %s", cert.Issuer.CommonName, cert.NotAfter.Format(time.RFC3339))
+}
+
+// activeLeaf returns a host's active certificate
+func (m *Manager) activeLeaf(hostname string, certificate *state.CertificateStatus) (*x509.Certificate, error) {
+	cert, err := m.loadHostCertificate(hostname, certificate)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// stagingClient returns a client for Let's Encrypt's staging environment
+// with the proxy's account key, registering the account there on first use
+func (m *Manager) stagingClient() (*acme.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.staging != nil {
+		return m.staging, nil
+	}
+
+	client := &acme.Client{
+		Key:          m.accountKey,
+		DirectoryURL: state.ACMEDirectories["letsencrypt-staging"],
+		HTTPClient:   m.client.HTTPClient,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	account := &acme.Account{}
+	if email := m.state.GetACMEConfig().Email; email != "" {
+		account.Contact = []string{"mailto:" + email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("failed to register staging account: %w", err)
+	}
+
+	m.staging = client
+	return client, nil
+}
@@ -0,0 +1,117 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issue creates a certificate for hostname signed by a new intermediate
+// named issuer, returning the DER chain, the leaf key and both parsed
+func issue(t *testing.T, hostname, issuer string) ([][]byte, *ecdsa.PrivateKey, []*x509.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: issuer},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(leafDER)
+	require.NoError(t, err)
+
+	return [][]byte{leafDER, caDER}, key, []*x509.Certificate{leaf, ca}
+}
+
+func TestCheckServedChain(t *testing.T) {
+	_, _, chain := issue(t, "app.example.com", "(STAGING) Pretend Pear X1")
+	_, _, other := issue(t, "app.example.com", "Other CA")
+
+	assert.NoError(t, checkServedChain("app.example.com", chain[0], chain))
+	assert.ErrorContains(t, checkServedChain("app.example.com", chain[0], nil), "no certificate served")
+	assert.ErrorContains(t, checkServedChain("app.example.com", chain[0], other), "serves another certificate, issued by Other CA")
+	assert.ErrorContains(t, checkServedChain("app.example.com", chain[0], chain[:1]), "without its intermediate")
+	assert.ErrorContains(t, checkServedChain("app.example.com", chain[0], []*x509.Certificate{chain[0], other[1]}), "didn't sign")
+	assert.Error(t, checkServedChain("www.example.com", chain[0], chain))
+}
+
+func TestIsStagingCertificate(t *testing.T) {
+	_, _, staging := issue(t, "app.example.com", "(STAGING) Pretend Pear X1")
+	_, _, production := issue(t, "app.example.com", "R11")
+
+	assert.True(t, IsStagingCertificate(staging[0]))
+	assert.False(t, IsStagingCertificate(production[0]))
+}
+
+func TestPromoteCertificatesStagingMode(t *testing.T) {
+	dir := t.TempDir()
+	st := state.NewState(filepath.Join(dir, "state.json"))
+	m := &Manager{state: st}
+
+	// One host already has a trusted certificate, the other a staging one
+	// that can't be served on port 443
+	for hostname, issuer := range map[string]string{"app.invalid": "(STAGING) Pretend Pear X1", "www.invalid": "R11"} {
+		require.NoError(t, st.DeployHost(hostname, "app:3000", "blog", "web", "/up", true))
+		der, key, _ := issue(t, hostname, issuer)
+		certDir := filepath.Join(dir, "certs", hostname)
+		require.NoError(t, m.saveCertificate(certDir, der, key))
+		require.NoError(t, st.UpdateCertificateStatus(hostname, &state.CertificateStatus{
+			Status:   "active",
+			CertFile: filepath.Join(certDir, "cert.pem"),
+			KeyFile:  filepath.Join(certDir, "key.pem"),
+		}))
+	}
+	st.SetLetsEncryptStaging(true)
+
+	_, err := m.PromoteCertificates([]string{"app.invalid"})
+	assert.ErrorContains(t, err, "staging mode is on")
+
+	promotions, err := m.PromoteCertificates(nil)
+	require.NoError(t, err)
+	require.Len(t, promotions, 2)
+
+	app := promotions[0]
+	assert.Equal(t, "app.invalid", app.Hostname)
+	assert.False(t, app.Promoted)
+	require.Len(t, app.Steps, 2)
+	assert.Equal(t, "ok", app.Steps[0].Status)
+	assert.Equal(t, PromoteVerify, app.Steps[1].Name)
+	assert.Equal(t, "failed", app.Steps[1].Status)
+	assert.Contains(t, app.Steps[1].Detail, "couldn't connect on port 443")
+
+	www := promotions[1]
+	assert.Equal(t, []PromotionStep{{Name: PromoteStaging, Status: "skipped", Detail: "already has a certificate from R11"}}, www.Steps)
+
+	// A failed host keeps the proxy on staging
+	assert.True(t, st.GetACMEConfig().Staging)
+}
@@ -0,0 +1,105 @@
+package cert
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// groupsDir holds the certificates shared by a project's hosts, see install
+const groupsDir = "_groups"
+
+// UnreferencedCertificates lists the certificate directories on disk that no
+// host's certificate is stored in, e.g. left behind by hosts removed while
+// the proxy was down
+func (m *Manager) UnreferencedCertificates() []string {
+	return m.unreferencedIn(certDir(""))
+}
+
+// PruneCertificates deletes the unreferenced certificate directories and
+// returns the ones it deleted
+func (m *Manager) PruneCertificates() []string {
+	return m.pruneIn(certDir(""))
+}
+
+// referencedIn returns the certificate directories under root that are in
+// use. A host's own directory counts even before it has a certificate, and
+// a grouped project's directory even before the group's certificate is
+// issued, so orders in flight keep their files.
+func (m *Manager) referencedIn(root string) map[string]bool {
+	referenced := make(map[string]bool)
+	for hostname, host := range m.state.GetAllHosts() {
+		referenced[filepath.Join(root, hostname)] = true
+		if host.Certificate != nil && host.Certificate.CertFile != "" {
+			referenced[filepath.Dir(host.Certificate.CertFile)] = true
+		}
+	}
+	for _, project := range m.state.GetCertGroups() {
+		referenced[filepath.Join(root, groupsDir, project)] = true
+	}
+	return referenced
+}
+
+// unreferencedIn lists the unreferenced certificate directories under root
+func (m *Manager) unreferencedIn(root string) []string {
+	referenced := m.referencedIn(root)
+
+	var candidates []string
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[CERT] Failed to list certificates: %v", err)
+		}
+		return []string{}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue // e.g. the account key
+		}
+		if entry.Name() != groupsDir {
+			candidates = append(candidates, filepath.Join(root, entry.Name()))
+			continue
+		}
+		groups, err := os.ReadDir(filepath.Join(root, groupsDir))
+		if err != nil {
+			log.Printf("[CERT] Failed to list certificate groups: %v", err)
+			continue
+		}
+		for _, group := range groups {
+			if group.IsDir() {
+				candidates = append(candidates, filepath.Join(root, groupsDir, group.Name()))
+			}
+		}
+	}
+
+	unreferenced := []string{}
+	for _, dir := range candidates {
+		if !referenced[dir] {
+			unreferenced = append(unreferenced, dir)
+		}
+	}
+	sort.Strings(unreferenced)
+	return unreferenced
+}
+
+// pruneIn deletes the unreferenced certificate directories under root. Each
+// is checked again under its host's lock, so a certificate being installed
+// for a host deployed meanwhile isn't deleted.
+func (m *Manager) pruneIn(root string) []string {
+	pruned := []string{}
+	for _, dir := range m.unreferencedIn(root) {
+		unlock := m.hosts.lock(filepath.Base(dir))
+		if !m.referencedIn(root)[dir] {
+			m.shared.Delete(filepath.Join(dir, "cert.pem"))
+			if err := os.RemoveAll(dir); err != nil {
+				log.Printf("[CERT] Failed to delete %s: %v", dir, err)
+			} else {
+				log.Printf("[CERT] Deleted unreferenced certificate %s", dir)
+				pruned = append(pruned, dir)
+			}
+		}
+		unlock()
+	}
+	return pruned
+}
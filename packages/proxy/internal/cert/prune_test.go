@@ -0,0 +1,49 @@
+package cert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneCertificates(t *testing.T) {
+	dir := t.TempDir()
+	st := state.NewState(filepath.Join(dir, "state.json"))
+	m := &Manager{state: st}
+	root := filepath.Join(dir, "certs")
+
+	for _, name := range []string{"app.example.com", "old.example.com", "new.example.com", "_groups/blog", "_groups/shop"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, name), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name, "cert.pem"), []byte("pem"), 0600))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(root, "account.key"), []byte("key"), 0600))
+
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.UpdateCertificateStatus("app.example.com", &state.CertificateStatus{
+		Status:   "active",
+		CertFile: filepath.Join(root, "app.example.com", "cert.pem"),
+		KeyFile:  filepath.Join(root, "app.example.com", "key.pem"),
+	}))
+	// Still acquiring its first certificate
+	require.NoError(t, st.DeployHost("new.example.com", "new:3000", "shop", "web", "/up", true))
+	st.SetCertGroups([]string{"shop"})
+
+	unreferenced := []string{
+		filepath.Join(root, "_groups", "blog"),
+		filepath.Join(root, "old.example.com"),
+	}
+	assert.Equal(t, unreferenced, m.unreferencedIn(root))
+
+	assert.Equal(t, unreferenced, m.pruneIn(root))
+	assert.NoDirExists(t, filepath.Join(root, "old.example.com"))
+	assert.NoDirExists(t, filepath.Join(root, "_groups", "blog"))
+	assert.DirExists(t, filepath.Join(root, "app.example.com"))
+	assert.DirExists(t, filepath.Join(root, "new.example.com"))
+	assert.DirExists(t, filepath.Join(root, "_groups", "shop"))
+	assert.FileExists(t, filepath.Join(root, "account.key"))
+	assert.Empty(t, m.unreferencedIn(root))
+}
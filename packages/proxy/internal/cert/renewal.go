@@ -0,0 +1,169 @@
+package cert
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// Renewal timing. A certificate is due renewBefore its expiry, moved earlier
+// by up to renewalSpread per hostname so certificates issued together don't
+// all renew at once. Failed renewals wait at least renewalRetryMin.
+const (
+	renewBefore          = 30 * 24 * time.Hour
+	renewalSpread        = 72 * time.Hour
+	renewalRetryMin      = time.Hour
+	renewalCheckInterval = 10 * time.Minute
+)
+
+// RenewalDue returns when a host's active certificate should be renewed:
+// the retry time after a failed renewal, else its spread out renewal time
+// before expiry
+func RenewalDue(hostname string, certificate *state.CertificateStatus) time.Time {
+	if !certificate.NextRenewal.IsZero() {
+		return certificate.NextRenewal
+	}
+	return certificate.ExpiresAt.Add(-renewBefore - spread(hostname, renewalSpread))
+}
+
+// spread maps a hostname to a stable offset below max, so its schedule
+// survives restarts
+func spread(hostname string, max time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// RunRenewals renews certificates as they become due until the context is
+// cancelled. Due certificates are renewed one after another, so a batch
+// issued together doesn't flood the CA.
+func (m *Manager) RunRenewals(ctx context.Context) {
+	log.Println("[CERT] Starting certificate renewal worker")
+
+	ticker := time.NewTicker(renewalCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, hostname := range m.dueRenewals(time.Now()) {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := m.renew(hostname, false); err != nil {
+				log.Printf("[CERT] [%s] Certificate renewal failed: %v", hostname, err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("[CERT] Stopping certificate renewal worker")
+			return
+		}
+	}
+}
+
+// dueRenewals returns the hosts whose active certificate is due for renewal
+func (m *Manager) dueRenewals(now time.Time) []string {
+	var due []string
+	for hostname, host := range m.state.GetAllHosts() {
+		if host.Certificate == nil || host.Certificate.Status != "active" {
+			continue
+		}
+		if !now.Before(RenewalDue(hostname, host.Certificate)) {
+			due = append(due, hostname)
+		}
+	}
+	return due
+}
+
+// RenewCertificate renews a host's active certificate now, whether it is due
+// or not. Its current certificate is served until the new one is issued, and
+// stays if renewal fails.
+func (m *Manager) RenewCertificate(hostname string) error {
+	return m.renew(hostname, true)
+}
+
+// renew renews a host's certificate, together with the rest of its group.
+// Unless forced it first checks the renewal is still due, as another renewal
+// of the group may have just finished. Failures are recorded on the host and
+// retried with a backoff.
+func (m *Manager) renew(hostname string, force bool) error {
+	// A renewal already running for the host is enough
+	if _, running := m.renewing.LoadOrStore(hostname, struct{}{}); running {
+		return fmt.Errorf("renewal of %s already in progress", hostname)
+	}
+	defer m.renewing.Delete(hostname)
+
+	names := m.state.CertGroup(hostname)
+	if names == nil {
+		names = []string{hostname}
+	}
+	unlock := m.hosts.lockAll(names)
+	defer unlock()
+
+	host, project, err := m.state.GetHost(hostname)
+	if err != nil {
+		return fmt.Errorf("host not found: %w", err)
+	}
+	if host.Certificate == nil || host.Certificate.Status != "active" {
+		return fmt.Errorf("no active certificate to renew")
+	}
+	if !force && time.Now().Before(RenewalDue(hostname, host.Certificate)) {
+		return nil
+	}
+
+	log.Printf("[CERT] [%s] Renewing certificate expiring %s", hostname, host.Certificate.ExpiresAt.Format(time.RFC3339))
+
+	if m.slots != nil {
+		m.slots <- struct{}{}
+		defer func() { <-m.slots }()
+	}
+
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	names = m.groupNames(hostname, names)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	derCerts, key, err := m.order(ctx, client, hostname, names)
+	if err == nil {
+		_, err = m.install(hostname, project, names, derCerts, key)
+	}
+	if err != nil {
+		m.renewalFailed(hostname, host.Certificate, err, time.Now())
+		return err
+	}
+
+	log.Printf("[CERT] [%s] Certificate renewed", hostname)
+	return nil
+}
+
+// renewalFailed records a failed renewal and schedules the next attempt. The
+// certificate stays active meanwhile.
+func (m *Manager) renewalFailed(hostname string, certificate *state.CertificateStatus, err error, now time.Time) {
+	errorType, retryIn := ClassifyError(err, now)
+	if retryIn < renewalRetryMin {
+		retryIn = renewalRetryMin
+	}
+	// Up to 10% later, so hosts that failed together retry apart
+	retryIn += time.Duration(rand.Int63n(int64(retryIn / 10)))
+
+	certificate.LastRenewalAttempt = now
+	certificate.RenewalAttempts++
+	certificate.RenewalError = err.Error()
+	certificate.ErrorType = errorType
+	certificate.NextRenewal = now.Add(retryIn)
+
+	log.Printf("[CERT] [%s] Renewal failed (%s, %d in a row), retrying in %s", hostname, errorType, certificate.RenewalAttempts, retryIn.Round(time.Second))
+	if err := m.state.UpdateCertificateStatus(hostname, certificate); err != nil {
+		log.Printf("[CERT] [%s] Failed to record renewal failure: %v", hostname, err)
+	}
+}
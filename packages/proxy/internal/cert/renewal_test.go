@@ -0,0 +1,67 @@
+package cert
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewalDue(t *testing.T) {
+	expiresAt := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	certificate := &state.CertificateStatus{Status: "active", ExpiresAt: expiresAt}
+
+	// Spread over a few days before the renewal window opens, the same on every call
+	due := RenewalDue("app.example.com", certificate)
+	assert.False(t, due.After(expiresAt.Add(-renewBefore)))
+	assert.True(t, due.After(expiresAt.Add(-renewBefore-renewalSpread)))
+	assert.Equal(t, due, RenewalDue("app.example.com", certificate))
+
+	// A failed renewal's retry time wins
+	certificate.NextRenewal = expiresAt.Add(-10 * 24 * time.Hour)
+	assert.Equal(t, certificate.NextRenewal, RenewalDue("app.example.com", certificate))
+}
+
+func TestRenewalFailed(t *testing.T) {
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.DeployHost("new.example.com", "app:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.UpdateCertificateStatus("app.example.com", &state.CertificateStatus{Status: "active", ExpiresAt: now.Add(20 * 24 * time.Hour)}))
+	require.NoError(t, st.UpdateCertificateStatus("new.example.com", &state.CertificateStatus{Status: "active", ExpiresAt: now.Add(80 * 24 * time.Hour)}))
+	m := &Manager{state: st}
+
+	assert.Equal(t, []string{"app.example.com"}, m.dueRenewals(now))
+
+	// Failures are persisted, keep the certificate active and back off
+	host, _, err := st.GetHost("app.example.com")
+	require.NoError(t, err)
+	m.renewalFailed("app.example.com", host.Certificate, errors.New("connection reset"), now)
+	m.renewalFailed("app.example.com", host.Certificate, errors.New("connection reset"), now)
+
+	host, _, err = st.GetHost("app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "active", host.Certificate.Status)
+	assert.Equal(t, 2, host.Certificate.RenewalAttempts)
+	assert.Equal(t, "connection reset", host.Certificate.RenewalError)
+	assert.False(t, host.Certificate.NextRenewal.Before(now.Add(renewalRetryMin)))
+	assert.Empty(t, m.dueRenewals(now))
+	assert.Equal(t, []string{"app.example.com"}, m.dueRenewals(now.Add(2*renewalRetryMin)))
+}
+
+func TestRenewalOverlap(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	m := &Manager{state: st}
+
+	m.renewing.Store("app.example.com", struct{}{})
+	assert.ErrorContains(t, m.RenewCertificate("app.example.com"), "already in progress")
+	m.renewing.Delete("app.example.com")
+
+	// Only active certificates are renewed
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	assert.ErrorContains(t, m.RenewCertificate("app.example.com"), "no active certificate")
+}
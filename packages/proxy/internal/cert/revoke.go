@@ -0,0 +1,166 @@
+package cert
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/state"
+	"golang.org/x/crypto/acme"
+)
+
+// RevocationReasons are the reasons a certificate can be revoked for, by
+// their names in the API
+var RevocationReasons = map[string]acme.CRLReasonCode{
+	"unspecified":            acme.CRLReasonUnspecified,
+	"key_compromise":         acme.CRLReasonKeyCompromise,
+	"superseded":             acme.CRLReasonSuperseded,
+	"cessation_of_operation": acme.CRLReasonCessationOfOperation,
+}
+
+// reasonName returns the API name of a revocation reason
+func reasonName(reason acme.CRLReasonCode) string {
+	for name, code := range RevocationReasons {
+		if code == reason {
+			return name
+		}
+	}
+	return fmt.Sprintf("reason %d", reason)
+}
+
+// RevokeCertificate revokes a host's certificate with the CA, for example
+// because its key leaked, and deletes it. The host then gets a new
+// certificate with a new key, so it keeps serving HTTPS.
+func (m *Manager) RevokeCertificate(hostname string, reason acme.CRLReasonCode) error {
+	unlock := m.hosts.lock(hostname)
+	defer unlock()
+
+	host, _, err := m.state.GetHost(hostname)
+	if err != nil {
+		return fmt.Errorf("host not found: %w", err)
+	}
+	if host.Certificate == nil || host.Certificate.CertFile == "" {
+		return fmt.Errorf("host %s has no issued certificate", hostname)
+	}
+
+	if err := m.revoke(hostname, host.Certificate, reason); err != nil {
+		return err
+	}
+
+	// A group's certificate is revoked for all its hosts
+	revoked := []string{hostname}
+	for _, name := range host.Certificate.Names {
+		if other, _, err := m.state.GetHost(name); err == nil && name != hostname &&
+			other.Certificate != nil && other.Certificate.CertFile == host.Certificate.CertFile {
+			revoked = append(revoked, name)
+		}
+	}
+
+	m.deleteCertificate(hostname, host.Certificate)
+	for _, name := range revoked {
+		m.certCache.Delete(name)
+		m.publish(core.CertificateRevoked{
+			BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: name},
+			Reason:    reasonName(reason),
+		})
+
+		// Pending again, so acquisition starts over with a new key
+		if err := m.state.UpdateCertificateStatus(name, &state.CertificateStatus{
+			Status:       "pending",
+			FirstAttempt: time.Now(),
+			MaxAttempts:  144,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCertificate cleans up after a removed host: its certificate leaves
+// the caches and the disk, and is revoked first when IOP_REVOKE_ON_REMOVE is
+// set. Nothing is touched if the host was deployed again meanwhile, its new
+// certificate uses the same files. A group's certificate is replaced instead,
+// see leaveGroup.
+func (m *Manager) removeCertificate(hostname string, certificate *state.CertificateStatus) {
+	if len(certificate.Names) > 1 {
+		m.leaveGroup(hostname, certificate)
+		return
+	}
+
+	unlock := m.hosts.lock(hostname)
+	defer unlock()
+
+	if _, _, err := m.state.GetHost(hostname); err == nil {
+		return
+	}
+
+	if m.revokeOld && certificate.Status == "active" && certificate.CertFile != "" {
+		if err := m.revoke(hostname, certificate, acme.CRLReasonCessationOfOperation); err != nil {
+			log.Printf("[CERT] [%s] Failed to revoke the removed host's certificate: %v", hostname, err)
+		} else {
+			m.publish(core.CertificateRevoked{
+				BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+				Reason:    reasonName(acme.CRLReasonCessationOfOperation),
+			})
+		}
+	}
+	m.deleteCertificate(hostname, certificate)
+}
+
+// revoke asks the CA to revoke a certificate, signed with the account key
+// that ordered it
+func (m *Manager) revoke(hostname string, certificate *state.CertificateStatus, reason acme.CRLReasonCode) error {
+	certPEM, err := os.ReadFile(certificate.CertFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("%s holds no PEM certificate", certificate.CertFile)
+	}
+
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := client.RevokeCert(ctx, nil, block.Bytes, reason); err != nil {
+		return fmt.Errorf("failed to revoke certificate: %w", err)
+	}
+	log.Printf("[CERT] [%s] Certificate revoked with the CA", hostname)
+	return nil
+}
+
+// deleteCertificate drops a host's certificate from the caches and deletes
+// its files
+func (m *Manager) deleteCertificate(hostname string, certificate *state.CertificateStatus) {
+	m.certCache.Delete(hostname)
+	m.selfSigned.Delete(hostname)
+	m.deleteFiles(hostname, certificate)
+}
+
+// deleteFiles deletes a certificate's files, and their directory once it is
+// empty
+func (m *Manager) deleteFiles(hostname string, certificate *state.CertificateStatus) {
+	m.shared.Delete(certificate.CertFile)
+
+	for _, path := range []string{certificate.CertFile, certificate.KeyFile} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[CERT] [%s] Failed to delete %s: %v", hostname, path, err)
+		}
+	}
+	if certificate.CertFile != "" {
+		// Fails while anything else is in it, which is fine
+		os.Remove(filepath.Dir(certificate.CertFile))
+	}
+	log.Printf("[CERT] [%s] Certificate files deleted", hostname)
+}
@@ -0,0 +1,53 @@
+package cert
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveCertificate(t *testing.T) {
+	dir := t.TempDir()
+	st := state.NewState(filepath.Join(dir, "state.json"))
+	m := &Manager{state: st}
+
+	certDir := filepath.Join(dir, "certs", "app.example.com")
+	require.NoError(t, os.MkdirAll(certDir, 0700))
+	certificate := &state.CertificateStatus{
+		Status:   "active",
+		CertFile: filepath.Join(certDir, "cert.pem"),
+		KeyFile:  filepath.Join(certDir, "key.pem"),
+	}
+	for _, path := range []string{certificate.CertFile, certificate.KeyFile} {
+		require.NoError(t, os.WriteFile(path, []byte("pem"), 0600))
+	}
+
+	// A host deployed again meanwhile keeps its files
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	m.removeCertificate("app.example.com", certificate)
+	assert.FileExists(t, certificate.CertFile)
+
+	// A removed one loses them, the cache entry and the directory
+	require.NoError(t, st.RemoveHost("app.example.com"))
+	m.certCache.Store("app.example.com", &tls.Certificate{})
+	m.removeCertificate("app.example.com", certificate)
+	assert.NoFileExists(t, certificate.CertFile)
+	assert.NoFileExists(t, certificate.KeyFile)
+	assert.NoDirExists(t, certDir)
+	_, cached := m.certCache.Load("app.example.com")
+	assert.False(t, cached)
+}
+
+func TestRevokeCertificateWithoutCertificate(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	m := &Manager{state: st}
+
+	assert.Error(t, m.RevokeCertificate("missing.example.com", RevocationReasons["key_compromise"]))
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	assert.ErrorContains(t, m.RevokeCertificate("app.example.com", RevocationReasons["key_compromise"]), "no issued certificate")
+}
@@ -0,0 +1,88 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// How long a fallback certificate is valid, it is regenerated after that
+const selfSignedValidity = 7 * 24 * time.Hour
+
+// ServesSelfSigned reports whether the proxy answers TLS for a host with a
+// temporary self-signed certificate, because its real one isn't issued yet
+func ServesSelfSigned(host *state.Host) bool {
+	return host.SSLEnabled &&
+		host.Mode != state.HostModePassthrough &&
+		host.Certificate != nil &&
+		host.Certificate.Status != "active"
+}
+
+// fallbackCertificate returns the host's self-signed certificate, creating
+// one the first time so clients get a certificate warning instead of a
+// handshake failure while ACME is pending
+func (m *Manager) fallbackCertificate(hostname string) (*tls.Certificate, error) {
+	if cached, ok := m.selfSigned.Load(hostname); ok {
+		cert := cached.(*tls.Certificate)
+		if time.Now().Before(cert.Leaf.NotAfter) {
+			return cert, nil
+		}
+	}
+
+	cert, err := generateSelfSigned(hostname, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	m.selfSigned.Store(hostname, cert)
+	return cert, nil
+}
+
+// generateSelfSigned creates an ephemeral certificate for hostname. The key
+// only lives in memory.
+func generateSelfSigned(hostname string, now time.Time) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   hostname,
+			Organization: []string{"iop-proxy self-signed, certificate pending"},
+		},
+		DNSNames:    []string{hostname},
+		NotBefore:   now.Add(-time.Hour), // Tolerate clients with slow clocks
+		NotAfter:    now.Add(selfSignedValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse self-signed certificate: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
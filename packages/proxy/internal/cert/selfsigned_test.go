@@ -0,0 +1,45 @@
+package cert
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServesSelfSigned(t *testing.T) {
+	pending := &state.CertificateStatus{Status: "pending"}
+	active := &state.CertificateStatus{Status: "active"}
+
+	assert.True(t, ServesSelfSigned(&state.Host{SSLEnabled: true, Certificate: pending}))
+	assert.True(t, ServesSelfSigned(&state.Host{SSLEnabled: true, Certificate: &state.CertificateStatus{Status: "failed"}}))
+	assert.False(t, ServesSelfSigned(&state.Host{SSLEnabled: true, Certificate: active}))
+	assert.False(t, ServesSelfSigned(&state.Host{SSLEnabled: false, Certificate: pending}))
+	assert.False(t, ServesSelfSigned(&state.Host{SSLEnabled: true, Mode: state.HostModePassthrough, Certificate: pending}))
+}
+
+func TestGetCertificateFallsBackToSelfSigned(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.DeployHost("plain.example.com", "plain:3000", "blog", "web", "/up", false))
+	m := &Manager{state: st}
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"})
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+	assert.Equal(t, []string{"app.example.com"}, cert.Leaf.DNSNames)
+	assert.Equal(t, cert.Leaf.Issuer.String(), cert.Leaf.Subject.String())
+
+	// The same certificate is reused until ACME succeeds
+	again, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"})
+	require.NoError(t, err)
+	assert.Same(t, cert, again)
+
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "plain.example.com"})
+	assert.Error(t, err)
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.example.com"})
+	assert.Error(t, err)
+}
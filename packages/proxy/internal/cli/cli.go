@@ -287,6 +287,9 @@ func (c *CLI) certStatus(args []string) error {
 		if !cert.NextAttempt.IsZero() {
 			fmt.Printf("Next attempt: %s\n", cert.NextAttempt.Format(time.RFC3339))
 		}
+		if cert.LastError != "" {
+			fmt.Printf("Last error (%s): %s\n", cert.ErrorType, cert.LastError)
+		}
 	}
 
 	return nil
@@ -1,11 +1,20 @@
 package cli
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/elitan/iop/proxy/internal/api"
+	"github.com/elitan/iop/proxy/internal/quota"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/pkg/client"
 )
 
 // HTTPCli provides command-line interface using HTTP API
@@ -33,6 +42,8 @@ func (c *HTTPCli) Execute(args []string) error {
 		return c.deploy(args[1:])
 	case "remove":
 		return c.remove(args[1:])
+	case "apply":
+		return c.apply(args[1:])
 	case "list":
 		return c.list(args[1:])
 	case "status":
@@ -43,10 +54,84 @@ func (c *HTTPCli) Execute(args []string) error {
 		return c.certStatus(args[1:])
 	case "cert-renew":
 		return c.certRenew(args[1:])
+	case "cert-revoke":
+		return c.certRevoke(args[1:])
+	case "cert-groups":
+		return c.certGroups(args[1:])
+	case "cert-expiring":
+		return c.certExpiring(args[1:])
+	case "cert-prune":
+		return c.certPrune(args[1:])
+	case "cert-promote":
+		return c.certPromote(args[1:])
+	case "cert-precheck":
+		return c.certPrecheck(args[1:])
 	case "set-staging":
 		return c.setStaging(args[1:])
 	case "switch":
 		return c.switchTarget(args[1:])
+	case "encrypt-keys":
+		return c.client.EncryptKeys()
+	case "notifications":
+		return c.notifications(args[1:])
+	case "acme":
+		return c.acme(args[1:])
+	case "tls":
+		return c.tls(args[1:])
+	case "limits":
+		return c.limits(args[1:])
+	case "rules":
+		return c.rules(args[1:])
+	case "mirror":
+		return c.mirror(args[1:])
+	case "probes":
+		return c.probes(args[1:])
+	case "error-pages":
+		return c.errorPages(args[1:])
+	case "scale-to-zero":
+		return c.scaleToZero(args[1:])
+	case "stop":
+		return c.setAppStopped("stop", true, args[1:])
+	case "start":
+		return c.setAppStopped("start", false, args[1:])
+	case "default-backend":
+		return c.defaultBackend(args[1:])
+	case "on-demand-tls":
+		return c.onDemandTLS(args[1:])
+	case "domains":
+		return c.domains(args[1:])
+	case "ports":
+		return c.ports(args[1:])
+	case "networks":
+		return c.networks(args[1:])
+	case "autoscale":
+		return c.autoscale(args[1:])
+	case "discovery":
+		return c.discovery(args[1:])
+	case "quota":
+		return c.quota(args[1:])
+	case "deployments":
+		return c.deployments(args[1:])
+	case "timeline":
+		return c.timeline(args[1:])
+	case "audit":
+		return c.audit(args[1:])
+	case "user":
+		return c.user(args[1:])
+	case "stats":
+		return c.stats(args[1:])
+	case "uptime":
+		return c.uptime(args[1:])
+	case "status-page":
+		return c.statusPage(args[1:])
+	case "incident":
+		return c.incident(args[1:])
+	case "doctor":
+		return c.doctor(args[1:])
+	case "export":
+		return c.client.Export(os.Stdout)
+	case "import":
+		return c.importArchive(args[1:])
 	default:
 		return fmt.Errorf("unknown command: %s", command)
 	}
@@ -61,6 +146,47 @@ func (c *HTTPCli) deploy(args []string) error {
 	healthPath := fs.String("health-path", "/up", "Health check path")
 	app := fs.String("app", "", "App name")
 	ssl := fs.Bool("ssl", true, "Enable SSL")
+	mode := fs.String("mode", "http", "Routing mode: http, or passthrough to forward TLS to the target untouched")
+	streaming := fs.Bool("streaming", false, "Flush responses as they arrive, for Server-Sent Events and long polling")
+	internal := fs.Bool("internal", false, "Only serve clients on internal networks, e.g. over the mesh or a VPN, see IOP_INTERNAL_NETWORKS")
+	metadata := make(map[string]string)
+	fs.Func("meta", "Deployment metadata as key=value, e.g. sha=4f2a9c1, repeatable", func(value string) error {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("expected key=value, got %q", value)
+		}
+		metadata[key] = val
+		return nil
+	})
+	var timeouts state.HostTimeouts
+	fs.StringVar(&timeouts.DialTimeout, "dial-timeout", "", "Time to connect to the target, default 10s")
+	fs.StringVar(&timeouts.ResponseTimeout, "response-timeout", "", "Time for the target to send response headers, default 30s")
+	fs.StringVar(&timeouts.RequestTimeout, "request-timeout", "", "Time for the whole request including the body, unlimited if empty")
+	fs.StringVar(&timeouts.StreamIdleTimeout, "stream-idle-timeout", "", "Time without response data before a stream is closed, unlimited if empty")
+	deploymentID := fs.String("deployment", "", "Deployment timeline this deploy is the switch step of")
+	var bake api.HTTPBakeRequest
+	fs.StringVar(&bake.Duration, "bake", "", "Keep the replaced containers this long, e.g. 5m, and roll back to them if the deploy regresses")
+	fs.StringVar(&bake.Interval, "bake-interval", "", "Time between bake checks, default 10s")
+	fs.Float64Var(&bake.MaxErrorRate, "bake-max-error-rate", 0, "Highest fraction of 5xx responses while baking, e.g. 0.05")
+	fs.StringVar(&bake.MaxLatency, "bake-max-latency", "", "Highest average response time while baking, e.g. 500ms")
+	fs.Uint64Var(&bake.MinRequests, "bake-min-requests", 0, "Requests needed before the bake limits apply")
+	fs.StringVar(&bake.Network, "bake-network", "", "Project network the app's aliases are on")
+	fs.StringVar(&bake.Color, "bake-color", "", "Color of the new containers")
+	fs.StringVar(&bake.PreviousColor, "bake-previous-color", "", "Color of the replaced containers")
+	for _, f := range []struct {
+		name, usage string
+		list        *[]string
+	}{
+		{"bake-alias", "Network alias the app is reachable as, repeatable", &bake.Aliases},
+		{"bake-container", "New container of the deploy, repeatable", &bake.Containers},
+		{"bake-previous", "Replaced container kept to roll back to, repeatable", &bake.Previous},
+	} {
+		list := f.list
+		fs.Func(f.name, f.usage, func(value string) error {
+			*list = append(*list, value)
+			return nil
+		})
+	}
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -70,7 +196,12 @@ func (c *HTTPCli) deploy(args []string) error {
 		return fmt.Errorf("missing required flags: --host, --target, --project")
 	}
 
-	return c.client.Deploy(*host, *target, *project, *app, *healthPath, *ssl)
+	var bakeRequest *api.HTTPBakeRequest
+	if bake.Duration != "" {
+		bakeRequest = &bake
+	}
+
+	return c.client.Deploy(*host, *target, *project, *app, *healthPath, *ssl, *mode, *streaming, *internal, timeouts, metadata, *deploymentID, bakeRequest)
 }
 
 // remove handles the remove command via HTTP API
@@ -91,6 +222,16 @@ func (c *HTTPCli) remove(args []string) error {
 
 // list handles the list command via HTTP API
 func (c *HTTPCli) list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "Print hosts as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return c.client.ListJSON()
+	}
 	return c.client.List()
 }
 
@@ -149,6 +290,107 @@ func (c *HTTPCli) certRenew(args []string) error {
 	return c.client.CertRenew(*host)
 }
 
+// certRevoke handles the cert-revoke command via HTTP API
+func (c *HTTPCli) certRevoke(args []string) error {
+	fs := flag.NewFlagSet("cert-revoke", flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname whose certificate to revoke")
+	reason := fs.String("reason", "key_compromise", "Revocation reason: key_compromise, superseded, cessation_of_operation or unspecified")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	return c.client.CertRevoke(*host, *reason)
+}
+
+// certGroups handles the cert-groups command via HTTP API
+func (c *HTTPCli) certGroups(args []string) error {
+	if len(args) < 1 || args[0] == "show" {
+		return c.client.ShowCertGroups()
+	}
+
+	switch args[0] {
+	case "reset":
+		return c.client.SetCertGroups(nil)
+	case "set":
+		fs := flag.NewFlagSet("cert-groups set", flag.ContinueOnError)
+		projects := fs.String("projects", "", "Comma-separated projects whose hosts share one certificate")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		names := splitList(*projects)
+		if len(names) == 0 {
+			return fmt.Errorf("missing required flag: --projects")
+		}
+
+		return c.client.SetCertGroups(names)
+	default:
+		return fmt.Errorf("unknown cert-groups subcommand: %s", args[0])
+	}
+}
+
+// certExpiring handles the cert-expiring command via HTTP API
+func (c *HTTPCli) certExpiring(args []string) error {
+	fs := flag.NewFlagSet("cert-expiring", flag.ContinueOnError)
+	within := fs.String("within", "14d", "Show certificates expiring within this time, e.g. 14d or 72h")
+	jsonOutput := fs.Bool("json", false, "Print certificates as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return c.client.CertsExpiring(*within, *jsonOutput)
+}
+
+// certPromote handles the cert-promote command via HTTP API
+func (c *HTTPCli) certPromote(args []string) error {
+	fs := flag.NewFlagSet("cert-promote", flag.ContinueOnError)
+	hosts := fs.String("host", "", "Comma-separated hosts to promote, every host when empty")
+	jsonOutput := fs.Bool("json", false, "Print promotions as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return c.client.CertPromote(splitList(*hosts), *jsonOutput)
+}
+
+// certPrecheck handles the cert-precheck command via HTTP API
+func (c *HTTPCli) certPrecheck(args []string) error {
+	fs := flag.NewFlagSet("cert-precheck", flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to check")
+	jsonOutput := fs.Bool("json", false, "Print problems as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	return c.client.CertPrecheck(*host, *jsonOutput)
+}
+
+// certPrune handles the cert-prune command via HTTP API
+func (c *HTTPCli) certPrune(args []string) error {
+	fs := flag.NewFlagSet("cert-prune", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Only list the certificates no host uses")
+	jsonOutput := fs.Bool("json", false, "Print certificate directories as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return c.client.CertPrune(!*dryRun, *jsonOutput)
+}
+
 // setStaging handles the set-staging command via HTTP API
 func (c *HTTPCli) setStaging(args []string) error {
 	fs := flag.NewFlagSet("set-staging", flag.ContinueOnError)
@@ -186,3 +428,1169 @@ func (c *HTTPCli) switchTarget(args []string) error {
 
 	return c.client.SwitchTarget(*host, *target)
 }
+
+// notifications handles the notifications command via HTTP API
+func (c *HTTPCli) notifications(args []string) error {
+	if len(args) < 1 || args[0] == "list" {
+		return c.client.ListNotifications()
+	}
+
+	if args[0] != "set" {
+		return fmt.Errorf("unknown notifications subcommand: %s", args[0])
+	}
+
+	fs := flag.NewFlagSet("notifications set", flag.ContinueOnError)
+	targetsJSON := fs.String("json", "", "Notification targets as a JSON array")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *targetsJSON == "" {
+		return fmt.Errorf("missing required flag: --json")
+	}
+
+	var targets []*state.NotificationTarget
+	if err := json.Unmarshal([]byte(*targetsJSON), &targets); err != nil {
+		return fmt.Errorf("invalid notification targets: %w", err)
+	}
+
+	return c.client.SetNotifications(targets)
+}
+
+// acme handles the acme command via HTTP API
+func (c *HTTPCli) acme(args []string) error {
+	if len(args) < 1 || args[0] == "show" {
+		return c.client.ShowACME()
+	}
+
+	if args[0] != "set" {
+		return fmt.Errorf("unknown acme subcommand: %s", args[0])
+	}
+
+	fs := flag.NewFlagSet("acme set", flag.ContinueOnError)
+	directory := fs.String("acme-directory", "", "ACME directory URL or CA name (letsencrypt, zerossl, buypass, google)")
+	email := fs.String("email", "", "Account contact email")
+	eabKeyID := fs.String("eab-kid", "", "External Account Binding key ID")
+	eabHMACKey := fs.String("eab-hmac-key", "", "External Account Binding HMAC key (base64url)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *directory == "" {
+		return fmt.Errorf("missing required flag: --acme-directory")
+	}
+
+	return c.client.SetACME(api.ACMERequest{
+		DirectoryURL: *directory,
+		Email:        *email,
+		EABKeyID:     *eabKeyID,
+		EABHMACKey:   *eabHMACKey,
+	})
+}
+
+// tls handles the tls command via HTTP API
+func (c *HTTPCli) tls(args []string) error {
+	if len(args) < 1 || args[0] == "show" {
+		return c.client.ShowTLSPolicy()
+	}
+
+	if args[0] != "set" && args[0] != "reset" {
+		return fmt.Errorf("unknown tls subcommand: %s", args[0])
+	}
+
+	fs := flag.NewFlagSet("tls "+args[0], flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to configure, defaults to all hosts")
+	minVersion := fs.String("min-version", "", "Minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	cipherSuites := fs.String("cipher-suites", "", "Comma-separated Go cipher suite names")
+	alpn := fs.String("alpn", "", "Comma-separated ALPN protocols (h2, http/1.1)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if args[0] == "reset" {
+		return c.client.SetTLSPolicy(*host, nil)
+	}
+
+	policy := &state.TLSPolicy{
+		MinVersion:   *minVersion,
+		CipherSuites: splitList(*cipherSuites),
+		ALPN:         splitList(*alpn),
+	}
+	if policy.MinVersion == "" && len(policy.CipherSuites) == 0 && len(policy.ALPN) == 0 {
+		return fmt.Errorf("missing flags: set at least one of --min-version, --cipher-suites, --alpn")
+	}
+
+	return c.client.SetTLSPolicy(*host, policy)
+}
+
+// limits handles the limits command via HTTP API
+func (c *HTTPCli) limits(args []string) error {
+	if len(args) < 1 || (args[0] != "set" && args[0] != "reset") {
+		return fmt.Errorf("usage: limits set|reset --host <host> [--max-concurrent <n>] [--bandwidth <rate>]")
+	}
+
+	fs := flag.NewFlagSet("limits "+args[0], flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to configure")
+	maxConcurrent := fs.Int("max-concurrent", 0, "Maximum in-flight requests, 0 for unlimited")
+	bandwidth := fs.String("bandwidth", "", "Response bandwidth per second, e.g. 512KB or 10MB")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	if args[0] == "reset" {
+		return c.client.SetHostLimits(*host, nil)
+	}
+
+	limits := &state.HostLimits{MaxConcurrent: *maxConcurrent}
+	if *bandwidth != "" {
+		rate, err := router.ParseBandwidth(*bandwidth)
+		if err != nil {
+			return err
+		}
+		limits.Bandwidth = rate
+	}
+	if limits.MaxConcurrent == 0 && limits.Bandwidth == 0 {
+		return fmt.Errorf("missing flags: set at least one of --max-concurrent, --bandwidth")
+	}
+
+	return c.client.SetHostLimits(*host, limits)
+}
+
+// rules handles the rules command via HTTP API. Rules are added at the end,
+// after the ones a request is checked against first.
+func (c *HTTPCli) rules(args []string) error {
+	const usage = "usage: rules list|add|remove|reset --host <host> [--name <name>] [--header <name> | --cookie <name>] [--value <value>] [--percent <n>] [--target <container:port>]"
+	if len(args) < 1 {
+		return fmt.Errorf(usage)
+	}
+
+	fs := flag.NewFlagSet("rules "+args[0], flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to configure")
+	name := fs.String("name", "", "Rule name, required for remove")
+	header := fs.String("header", "", "Request header to match, e.g. X-Beta")
+	cookie := fs.String("cookie", "", "Cookie to match")
+	value := fs.String("value", "", "Required header or cookie value, empty matches any value")
+	percent := fs.Int("percent", 0, "Share of clients to match, 1-100")
+	target := fs.String("target", "", "Alternate target container:port")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	switch args[0] {
+	case "list":
+		return c.client.ShowHostRules(*host)
+	case "reset":
+		return c.client.SetHostRules(*host, nil)
+	case "add":
+		if *target == "" {
+			return fmt.Errorf("missing required flag: --target")
+		}
+		rules, err := c.client.HostRules(*host)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, state.RoutingRule{
+			Name:    *name,
+			Header:  *header,
+			Cookie:  *cookie,
+			Value:   *value,
+			Percent: *percent,
+			Target:  *target,
+		})
+		return c.client.SetHostRules(*host, rules)
+	case "remove":
+		if *name == "" {
+			return fmt.Errorf("missing required flag: --name")
+		}
+		rules, err := c.client.HostRules(*host)
+		if err != nil {
+			return err
+		}
+		kept := rules[:0]
+		for _, rule := range rules {
+			if rule.Name != *name {
+				kept = append(kept, rule)
+			}
+		}
+		if len(kept) == len(rules) {
+			return fmt.Errorf("host %s has no rule named %s", *host, *name)
+		}
+		return c.client.SetHostRules(*host, kept)
+	default:
+		return fmt.Errorf(usage)
+	}
+}
+
+// mirror handles the mirror command via HTTP API
+func (c *HTTPCli) mirror(args []string) error {
+	if len(args) < 1 || (args[0] != "set" && args[0] != "reset") {
+		return fmt.Errorf("usage: mirror set|reset --host <host> [--target <container:port>] [--percent <n>]")
+	}
+
+	fs := flag.NewFlagSet("mirror "+args[0], flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to configure")
+	target := fs.String("target", "", "Shadow target container:port")
+	percent := fs.Int("percent", 100, "Share of requests to copy, 1-100")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	if args[0] == "reset" {
+		return c.client.SetHostMirror(*host, nil)
+	}
+
+	if *target == "" {
+		return fmt.Errorf("missing required flag: --target")
+	}
+	return c.client.SetHostMirror(*host, &state.MirrorPolicy{Target: *target, Percent: *percent})
+}
+
+// probes handles the probes command via HTTP API. Probes without a path
+// check the host's health path.
+func (c *HTTPCli) probes(args []string) error {
+	if len(args) < 1 || (args[0] != "set" && args[0] != "reset") {
+		return fmt.Errorf("usage: probes set|reset --host <host> [--readiness] [--readiness-path <path>] [--readiness-interval <duration>] [--readiness-failures <n>] [--liveness] [--liveness-path <path>] [--liveness-interval <duration>] [--liveness-failures <n>]")
+	}
+
+	fs := flag.NewFlagSet("probes "+args[0], flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to configure")
+	probeFlags := func(name string) (*bool, *state.ProbePolicy) {
+		enabled := fs.Bool(name, false, "Enable the "+name+" probe")
+		probe := &state.ProbePolicy{}
+		fs.StringVar(&probe.Path, name+"-path", "", "Path of the "+name+" probe, the health path by default")
+		fs.StringVar(&probe.Interval, name+"-interval", "", "Time between "+name+" checks, e.g. 10s")
+		fs.IntVar(&probe.FailureThreshold, name+"-failures", 0, "Consecutive "+name+" failures before acting")
+		return enabled, probe
+	}
+	readinessEnabled, readiness := probeFlags("readiness")
+	livenessEnabled, liveness := probeFlags("liveness")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	if args[0] == "reset" {
+		return c.client.SetHostProbes(*host, nil)
+	}
+
+	// Any flag of a probe enables it
+	probes := &state.HostProbes{}
+	if *readinessEnabled || *readiness != (state.ProbePolicy{}) {
+		probes.Readiness = readiness
+	}
+	if *livenessEnabled || *liveness != (state.ProbePolicy{}) {
+		probes.Liveness = liveness
+	}
+	if probes.Readiness == nil && probes.Liveness == nil {
+		return fmt.Errorf("set --readiness or --liveness, or use probes reset")
+	}
+	return c.client.SetHostProbes(*host, probes)
+}
+
+// errorPages handles the error-pages command via HTTP API. Pages are set one
+// status at a time, from a file or from stdin with --file -.
+func (c *HTTPCli) errorPages(args []string) error {
+	const usage = "usage: error-pages show|set|reset [--host <host>] [--status <404|502|503>] [--file <page.html>]"
+	if len(args) < 1 {
+		return fmt.Errorf(usage)
+	}
+
+	fs := flag.NewFlagSet("error-pages "+args[0], flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to configure, the global pages if empty")
+	status := fs.String("status", "", "Status the page is for: 404, 502 or 503")
+	file := fs.String("file", "", "HTML template of the page, - reads stdin")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		return c.client.ShowErrorPages(*host)
+	case "reset":
+		if *status == "" {
+			return c.client.SetErrorPages(*host, nil)
+		}
+		pages, err := c.client.ErrorPages(*host)
+		if err != nil {
+			return err
+		}
+		delete(pages, *status)
+		return c.client.SetErrorPages(*host, pages)
+	case "set":
+		if *status == "" {
+			return fmt.Errorf("missing required flag: --status")
+		}
+		if *file == "" {
+			return fmt.Errorf("missing required flag: --file")
+		}
+
+		var page []byte
+		var err error
+		if *file == "-" {
+			page, err = io.ReadAll(os.Stdin)
+		} else {
+			page, err = os.ReadFile(*file)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read error page: %w", err)
+		}
+
+		pages, err := c.client.ErrorPages(*host)
+		if err != nil {
+			return err
+		}
+		if pages == nil {
+			pages = make(map[string]string)
+		}
+		pages[*status] = string(page)
+		return c.client.SetErrorPages(*host, pages)
+	default:
+		return fmt.Errorf(usage)
+	}
+}
+
+// defaultBackend handles the default-backend command via HTTP API
+func (c *HTTPCli) defaultBackend(args []string) error {
+	if len(args) < 1 || args[0] == "show" {
+		return c.client.ShowDefaultBackend()
+	}
+
+	switch args[0] {
+	case "reset":
+		return c.client.SetDefaultBackend("")
+	case "set":
+		fs := flag.NewFlagSet("default-backend set", flag.ContinueOnError)
+		target := fs.String("target", "", "Target container:port for unknown hosts")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *target == "" {
+			return fmt.Errorf("missing required flag: --target")
+		}
+
+		return c.client.SetDefaultBackend(*target)
+	default:
+		return fmt.Errorf("unknown default-backend subcommand: %s", args[0])
+	}
+}
+
+// onDemandTLS handles the on-demand-tls command via HTTP API
+func (c *HTTPCli) onDemandTLS(args []string) error {
+	if len(args) < 1 || args[0] == "show" {
+		return c.client.ShowOnDemandTLS()
+	}
+
+	switch args[0] {
+	case "reset":
+		return c.client.SetOnDemandTLS(client.OnDemandTLS{})
+	case "set":
+		fs := flag.NewFlagSet("on-demand-tls set", flag.ContinueOnError)
+		allow := fs.String("allow", "", "Comma-separated hostnames or *.example.com patterns")
+		ask := fs.String("ask", "", "URL approving each hostname, GET <ask>?domain=<hostname> must answer 2xx")
+		maxHosts := fs.Int("max-hosts", 0, "Hosts held at once (default 100)")
+		rateLimit := fs.Int("rate-limit", 0, "Acquisitions started per hour (default 10)")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		patterns := splitList(*allow)
+		if len(patterns) == 0 {
+			return fmt.Errorf("missing required flag: --allow")
+		}
+
+		return c.client.SetOnDemandTLS(client.OnDemandTLS{
+			Allow:     patterns,
+			Ask:       *ask,
+			MaxHosts:  *maxHosts,
+			RateLimit: *rateLimit,
+		})
+	default:
+		return fmt.Errorf("unknown on-demand-tls subcommand: %s", args[0])
+	}
+}
+
+// domains handles the domains command via HTTP API
+func (c *HTTPCli) domains(args []string) error {
+	if len(args) < 1 || args[0] == "list" {
+		return c.client.ListDomains()
+	}
+
+	fs := flag.NewFlagSet("domains "+args[0], flag.ContinueOnError)
+	domain := fs.String("domain", "", "Customer domain, e.g. shop.customer.com")
+	host := fs.String("host", "", "Deployed host that serves the domain (add only)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *domain == "" {
+		return fmt.Errorf("missing required flag: --domain")
+	}
+
+	switch args[0] {
+	case "add":
+		if *host == "" {
+			return fmt.Errorf("missing required flag: --host")
+		}
+		return c.client.AddDomain(*domain, *host)
+	case "verify":
+		return c.client.VerifyDomain(*domain)
+	case "remove":
+		return c.client.RemoveDomain(*domain)
+	default:
+		return fmt.Errorf("unknown domains subcommand: %s", args[0])
+	}
+}
+
+// networks handles the networks command via HTTP API
+func (c *HTTPCli) networks(args []string) error {
+	if len(args) < 1 || args[0] == "list" {
+		return c.client.ListNetworks()
+	}
+
+	fs := flag.NewFlagSet("networks "+args[0], flag.ContinueOnError)
+	network := fs.String("network", "", "Docker network, e.g. blog-network")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *network == "" {
+		return fmt.Errorf("missing required flag: --network")
+	}
+
+	switch args[0] {
+	case "connect":
+		return c.client.ConnectNetwork(*network)
+	case "disconnect":
+		return c.client.DisconnectNetwork(*network)
+	default:
+		return fmt.Errorf("unknown networks subcommand: %s", args[0])
+	}
+}
+
+// user handles the user command via HTTP API
+func (c *HTTPCli) user(args []string) error {
+	if len(args) < 1 || args[0] == "list" {
+		return c.client.ListUsers()
+	}
+
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+		name := fs.String("name", "", "User name")
+		role := fs.String("role", state.RoleReadOnly, "Role: read-only, deployer or admin")
+		projects := fs.String("projects", "", "Comma-separated projects to limit the token to, every project if empty")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *name == "" {
+			return fmt.Errorf("missing required flag: --name")
+		}
+
+		var projectList []string
+		if *projects != "" {
+			projectList = strings.Split(*projects, ",")
+		}
+
+		return c.client.AddUser(*name, *role, projectList)
+	case "remove":
+		fs := flag.NewFlagSet("user remove", flag.ContinueOnError)
+		name := fs.String("name", "", "User name")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *name == "" {
+			return fmt.Errorf("missing required flag: --name")
+		}
+
+		return c.client.RemoveUser(*name)
+	default:
+		return fmt.Errorf("unknown user subcommand: %s", args[0])
+	}
+}
+
+// ports handles the ports command via HTTP API
+func (c *HTTPCli) ports(args []string) error {
+	if len(args) < 1 || args[0] == "list" {
+		return c.client.ListPortForwards()
+	}
+
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("ports add", flag.ContinueOnError)
+		listen := fs.Int("listen", 0, "Port to listen on")
+		protocol := fs.String("protocol", "tcp", "Protocol: tcp or udp")
+		target := fs.String("target", "", "Target container:port")
+		project := fs.String("project", "", "Project name")
+		allow := fs.String("allow", "", "Comma-separated client IPs or CIDRs, empty allows everyone")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *listen == 0 || *target == "" {
+			return fmt.Errorf("missing required flags: --listen, --target")
+		}
+
+		return c.client.AddPortForward(&state.PortForward{
+			Listen:   *listen,
+			Protocol: *protocol,
+			Target:   *target,
+			Project:  *project,
+			Allow:    splitList(*allow),
+		})
+	case "remove":
+		fs := flag.NewFlagSet("ports remove", flag.ContinueOnError)
+		listen := fs.Int("listen", 0, "Port to stop forwarding")
+		protocol := fs.String("protocol", "tcp", "Protocol: tcp or udp")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *listen == 0 {
+			return fmt.Errorf("missing required flag: --listen")
+		}
+
+		return c.client.RemovePortForward(*listen, *protocol)
+	default:
+		return fmt.Errorf("unknown ports subcommand: %s", args[0])
+	}
+}
+
+// scaleToZero handles the scale-to-zero command via HTTP API
+func (c *HTTPCli) scaleToZero(args []string) error {
+	fs := flag.NewFlagSet("scale-to-zero", flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to configure")
+	enabled := fs.Bool("enabled", true, "Stop the app when idle and start it on the next request")
+	idleTimeout := fs.String("idle-timeout", "", "Inactivity before the app is stopped, e.g. 15m")
+	maxWait := fs.String("max-wait", "", "Longest a request waits for the app to start, e.g. 30s")
+	queueDepth := fs.Int("queue-depth", 0, "Most requests waiting for the app to start at once")
+	startingPage := fs.Bool("starting-page", false, "Answer browsers with a page that reloads until the app is up")
+	warmPool := fs.Int("warm-pool", 0, "Containers paused instead of stopped when idle, resumed without a restart")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	var coldStart *state.ColdStartPolicy
+	if *maxWait != "" || *queueDepth != 0 || *startingPage || *warmPool != 0 {
+		coldStart = &state.ColdStartPolicy{MaxWait: *maxWait, QueueDepth: *queueDepth, StartingPage: *startingPage, WarmPool: *warmPool}
+	}
+
+	return c.client.SetScaleToZero(*host, *enabled, *idleTimeout, coldStart)
+}
+
+// setAppStopped handles the stop and start commands via HTTP API
+func (c *HTTPCli) setAppStopped(command string, stopped bool, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	project := fs.String("project", "", "Project name")
+	app := fs.String("app", "", "App name")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *project == "" || *app == "" {
+		return fmt.Errorf("missing required flags: --project, --app")
+	}
+
+	return c.client.SetAppStopped(*project, *app, stopped)
+}
+
+// autoscale handles the autoscale command via HTTP API
+func (c *HTTPCli) autoscale(args []string) error {
+	if len(args) < 1 || args[0] == "list" || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && args[0] == "list" {
+			args = args[1:]
+		}
+		fs := flag.NewFlagSet("autoscale list", flag.ContinueOnError)
+		jsonOutput := fs.Bool("json", false, "Print policies as JSON")
+
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		return c.client.ListAutoscale(*jsonOutput)
+	}
+
+	switch args[0] {
+	case "set":
+		fs := flag.NewFlagSet("autoscale set", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+		app := fs.String("app", "", "App name")
+		min := fs.Int("min", 1, "Minimum number of replicas")
+		max := fs.Int("max", 0, "Maximum number of replicas")
+		cpu := fs.Float64("cpu", 0, "Target average CPU percent per replica")
+		rate := fs.Float64("requests-per-second", 0, "Target requests per second per replica")
+		latency := fs.Int("latency", 0, "Target average response time in milliseconds")
+		concurrency := fs.Float64("concurrency", 0, "Target average requests in flight per replica")
+		upCooldown := fs.String("scale-up-cooldown", "", "Wait after scaling before adding replicas, e.g. 1m")
+		downCooldown := fs.String("scale-down-cooldown", "", "Wait after scaling before removing replicas, e.g. 5m")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" || *app == "" || *max == 0 {
+			return fmt.Errorf("missing required flags: --project, --app, --max")
+		}
+
+		return c.client.SetAutoscalePolicy(&state.AutoscalePolicy{
+			Project:           *project,
+			App:               *app,
+			MinReplicas:       *min,
+			MaxReplicas:       *max,
+			TargetCPU:         *cpu,
+			TargetRequestRate: *rate,
+			TargetLatencyMs:   *latency,
+			TargetConcurrency: *concurrency,
+			ScaleUpCooldown:   *upCooldown,
+			ScaleDownCooldown: *downCooldown,
+		})
+	case "remove":
+		fs := flag.NewFlagSet("autoscale remove", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+		app := fs.String("app", "", "App name")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" || *app == "" {
+			return fmt.Errorf("missing required flags: --project, --app")
+		}
+
+		return c.client.RemoveAutoscalePolicy(*project, *app)
+	default:
+		return fmt.Errorf("unknown autoscale subcommand: %s", args[0])
+	}
+}
+
+// discovery handles the discovery command via HTTP API
+func (c *HTTPCli) discovery(args []string) error {
+	if len(args) < 1 || args[0] == "list" || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && args[0] == "list" {
+			args = args[1:]
+		}
+		fs := flag.NewFlagSet("discovery list", flag.ContinueOnError)
+		jsonOutput := fs.Bool("json", false, "Print records as JSON")
+
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		return c.client.ListServiceRecords(*jsonOutput)
+	}
+
+	if args[0] != "set" {
+		return fmt.Errorf("unknown discovery subcommand: %s", args[0])
+	}
+
+	fs := flag.NewFlagSet("discovery set", flag.ContinueOnError)
+	project := fs.String("project", "", "Project name")
+	recordsJSON := fs.String("json", "[]", "Service records as a JSON array, replacing the project's records")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *project == "" {
+		return fmt.Errorf("missing required flag: --project")
+	}
+
+	var records []state.ServiceRecord
+	if err := json.Unmarshal([]byte(*recordsJSON), &records); err != nil {
+		return fmt.Errorf("invalid service records: %w", err)
+	}
+
+	return c.client.SetServiceRecords(*project, records)
+}
+
+// quota handles the quota command via HTTP API
+func (c *HTTPCli) quota(args []string) error {
+	if len(args) < 1 || args[0] == "list" || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && args[0] == "list" {
+			args = args[1:]
+		}
+		fs := flag.NewFlagSet("quota list", flag.ContinueOnError)
+		project := fs.String("project", "", "Only this project, listed even without a quota")
+		jsonOutput := fs.Bool("json", false, "Print quotas as JSON")
+
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		return c.client.ListQuotas(*project, *jsonOutput)
+	}
+
+	switch args[0] {
+	case "set":
+		fs := flag.NewFlagSet("quota set", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+		containers := fs.Int("containers", 0, "Maximum number of containers")
+		memory := fs.String("memory", "", "Maximum total memory limit of the containers, e.g. 4g")
+		cpus := fs.Float64("cpus", 0, "Maximum total CPU limit of the containers")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" {
+			return fmt.Errorf("missing required flag: --project")
+		}
+
+		var maxMemory int64
+		if *memory != "" {
+			size, err := quota.ParseSize(*memory)
+			if err != nil {
+				return err
+			}
+			maxMemory = size
+		}
+
+		return c.client.SetQuota(&state.Quota{
+			Project:       *project,
+			MaxContainers: *containers,
+			MaxMemory:     maxMemory,
+			MaxCPUs:       *cpus,
+		})
+	case "remove":
+		fs := flag.NewFlagSet("quota remove", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" {
+			return fmt.Errorf("missing required flag: --project")
+		}
+
+		return c.client.RemoveQuota(*project)
+	case "check":
+		fs := flag.NewFlagSet("quota check", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+		workloadsJSON := fs.String("json", "[]", "Workloads of the deploy as a JSON array")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" {
+			return fmt.Errorf("missing required flag: --project")
+		}
+
+		var workloads []quota.Workload
+		if err := json.Unmarshal([]byte(*workloadsJSON), &workloads); err != nil {
+			return fmt.Errorf("invalid workloads: %w", err)
+		}
+
+		return c.client.CheckQuota(*project, workloads)
+	default:
+		return fmt.Errorf("unknown quota subcommand: %s", args[0])
+	}
+}
+
+// apply handles the apply command via HTTP API, reading the desired state
+// document from a file or stdin
+func (c *HTTPCli) apply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	file := fs.String("file", "-", "Desired state document, - for stdin")
+	dryRun := fs.Bool("dry-run", false, "Show what would change without changing it")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := readInput(*file)
+	if err != nil {
+		return fmt.Errorf("failed to read desired state: %w", err)
+	}
+
+	var req api.HTTPApplyRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("invalid desired state: %w", err)
+	}
+	if *dryRun {
+		req.DryRun = true
+	}
+
+	return c.client.Apply(&req)
+}
+
+// importArchive handles the import command via HTTP API, reading an archive
+// written by export from a file or stdin
+func (c *HTTPCli) importArchive(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "-", "Archive written by export, - for stdin")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := readInput(*file)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("invalid archive: not JSON")
+	}
+
+	return c.client.Import(data)
+}
+
+// audit handles the audit command via HTTP API
+func (c *HTTPCli) audit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	host := fs.String("host", "", "Only show entries for this host")
+	action := fs.String("action", "", "Only show entries for this action, e.g. deploy or switch")
+	since := fs.String("since", "", "Only show entries newer than a duration (24h) or RFC 3339 time")
+	limit := fs.Int("limit", 100, "Most recent entries to show, 0 for all")
+	jsonOutput := fs.Bool("json", false, "Print entries as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	if *host != "" {
+		params.Set("host", *host)
+	}
+	if *action != "" {
+		params.Set("action", *action)
+	}
+	if *since != "" {
+		params.Set("since", *since)
+	}
+	params.Set("limit", strconv.Itoa(*limit))
+
+	return c.client.Audit(params, *jsonOutput)
+}
+
+// deployments handles the deployments command via HTTP API
+func (c *HTTPCli) deployments(args []string) error {
+	fs := flag.NewFlagSet("deployments", flag.ContinueOnError)
+	host := fs.String("host", "", "Hostname to show the deployments of")
+	jsonOutput := fs.Bool("json", false, "Print deployments as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		return fmt.Errorf("missing required flag: --host")
+	}
+
+	return c.client.Deployments(*host, *jsonOutput)
+}
+
+// timeline handles the timeline command via HTTP API
+func (c *HTTPCli) timeline(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing timeline subcommand: start, step or show")
+	}
+
+	switch args[0] {
+	case "start":
+		fs := flag.NewFlagSet("timeline start", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+		app := fs.String("app", "", "App name")
+		host := fs.String("host", "", "Hostname the app is served as")
+		metadata := make(map[string]string)
+		fs.Func("meta", "Deployment metadata as key=value, repeatable", func(value string) error {
+			key, val, ok := strings.Cut(value, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("expected key=value, got %q", value)
+			}
+			metadata[key] = val
+			return nil
+		})
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" || *app == "" {
+			return fmt.Errorf("missing required flags: --project, --app")
+		}
+
+		return c.client.StartDeployment(*project, *app, *host, metadata)
+	case "step":
+		fs := flag.NewFlagSet("timeline step", flag.ContinueOnError)
+		id := fs.String("id", "", "Deployment ID")
+		var update client.DeploymentStepUpdate
+		fs.StringVar(&update.Step, "step", "", "Step: build, push, start, health_check, switch or cleanup")
+		fs.StringVar(&update.Status, "status", "", "Status: running, succeeded, failed or skipped, empty to only record an attempt")
+		fs.StringVar(&update.Message, "message", "", "What the step did")
+		fs.StringVar(&update.Error, "error", "", "Why the step failed")
+		attempt := fs.String("attempt", "", "Record an attempt with this result, e.g. the status code a health check got")
+		attemptError := fs.String("attempt-error", "", "Record an attempt that failed with this error")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *id == "" || update.Step == "" {
+			return fmt.Errorf("missing required flags: --id, --step")
+		}
+		if *attempt != "" || *attemptError != "" {
+			update.Attempt = &client.DeploymentAttempt{Result: *attempt, Error: *attemptError}
+		}
+
+		return c.client.UpdateDeploymentStep(*id, &update)
+	case "show":
+		fs := flag.NewFlagSet("timeline show", flag.ContinueOnError)
+		id := fs.String("id", "", "Deployment ID")
+		jsonOutput := fs.Bool("json", false, "Print the timeline as JSON")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *id == "" {
+			return fmt.Errorf("missing required flag: --id")
+		}
+
+		return c.client.Deployment(*id, *jsonOutput)
+	default:
+		return fmt.Errorf("unknown timeline subcommand: %s", args[0])
+	}
+}
+
+// stats handles the stats command via HTTP API
+func (c *HTTPCli) stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "Print samples as JSON")
+	apps := fs.Bool("apps", false, "Show each app's requests and total usage instead of containers")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *apps {
+		return c.client.AppStats(*jsonOutput)
+	}
+	return c.client.Stats(*jsonOutput)
+}
+
+// uptime handles the uptime command via HTTP API
+func (c *HTTPCli) uptime(args []string) error {
+	fs := flag.NewFlagSet("uptime", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "Print the report as JSON")
+	project := fs.String("project", "", "Only hosts of this project")
+	host := fs.String("host", "", "Show one host's daily uptime and recent checks")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *host != "" {
+		return c.client.HostUptime(*host, *jsonOutput)
+	}
+	return c.client.Uptime(*project, *jsonOutput)
+}
+
+// statusPage handles the status-page command via HTTP API
+func (c *HTTPCli) statusPage(args []string) error {
+	if len(args) < 1 || args[0] == "list" || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && args[0] == "list" {
+			args = args[1:]
+		}
+		fs := flag.NewFlagSet("status-page list", flag.ContinueOnError)
+		project := fs.String("project", "", "Only this project")
+		jsonOutput := fs.Bool("json", false, "Print status pages as JSON")
+
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		return c.client.ListStatusPages(*project, *jsonOutput)
+	}
+
+	switch args[0] {
+	case "set":
+		fs := flag.NewFlagSet("status-page set", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+		host := fs.String("host", "", "Hostname to serve the page at")
+		title := fs.String("title", "", "Page title, the project name by default")
+		apps := fs.String("apps", "", "Comma-separated apps to show, all apps with a host by default")
+		ssl := fs.Bool("ssl", true, "Serve the page over HTTPS")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" || *host == "" {
+			return fmt.Errorf("missing required flags: --project and --host")
+		}
+
+		return c.client.SetStatusPage(&state.StatusPage{
+			Project: *project,
+			Host:    *host,
+			Title:   *title,
+			Apps:    splitList(*apps),
+			SSL:     *ssl,
+		})
+	case "remove":
+		fs := flag.NewFlagSet("status-page remove", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" {
+			return fmt.Errorf("missing required flag: --project")
+		}
+
+		return c.client.RemoveStatusPage(*project)
+	default:
+		return fmt.Errorf("unknown status-page subcommand: %s", args[0])
+	}
+}
+
+// incident handles the incident command via HTTP API
+func (c *HTTPCli) incident(args []string) error {
+	if len(args) < 1 || args[0] == "list" || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && args[0] == "list" {
+			args = args[1:]
+		}
+		fs := flag.NewFlagSet("incident list", flag.ContinueOnError)
+		project := fs.String("project", "", "Only this project")
+		jsonOutput := fs.Bool("json", false, "Print incidents as JSON")
+
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		return c.client.ListIncidents(*project, *jsonOutput)
+	}
+
+	switch args[0] {
+	case "open":
+		fs := flag.NewFlagSet("incident open", flag.ContinueOnError)
+		project := fs.String("project", "", "Project name")
+		title := fs.String("title", "", "What visitors see, e.g. \"Checkout is slow\"")
+		status := fs.String("status", state.IncidentInvestigating, "investigating, identified, monitoring or resolved")
+		message := fs.String("message", "", "First update")
+		apps := fs.String("apps", "", "Comma-separated affected apps, all apps by default")
+		jsonOutput := fs.Bool("json", false, "Print the incident as JSON")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *project == "" || *title == "" {
+			return fmt.Errorf("missing required flags: --project and --title")
+		}
+
+		return c.client.OpenIncident(&client.OpenIncidentRequest{
+			Project: *project,
+			Title:   *title,
+			Status:  *status,
+			Message: *message,
+			Apps:    splitList(*apps),
+		}, *jsonOutput)
+	case "update", "resolve":
+		fs := flag.NewFlagSet("incident "+args[0], flag.ContinueOnError)
+		id := fs.String("id", "", "Incident ID")
+		status := fs.String("status", "", "investigating, identified, monitoring or resolved")
+		message := fs.String("message", "", "Update for visitors")
+		jsonOutput := fs.Bool("json", false, "Print the incident as JSON")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if args[0] == "resolve" {
+			*status = state.IncidentResolved
+		}
+		if *id == "" || *status == "" {
+			return fmt.Errorf("missing required flags: --id and --status")
+		}
+
+		return c.client.UpdateIncident(*id, *status, *message, *jsonOutput)
+	case "remove":
+		fs := flag.NewFlagSet("incident remove", flag.ContinueOnError)
+		id := fs.String("id", "", "Incident ID")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *id == "" {
+			return fmt.Errorf("missing required flag: --id")
+		}
+
+		return c.client.RemoveIncident(*id)
+	default:
+		return fmt.Errorf("unknown incident subcommand: %s", args[0])
+	}
+}
+
+// doctor handles the doctor command via HTTP API
+func (c *HTTPCli) doctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "Print the report as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return c.client.Doctor(*jsonOutput)
+}
+
+// readInput reads a file, or stdin when path is "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -15,12 +15,39 @@ type Deployment struct {
 // Container represents a deployed container
 type Container struct {
 	ID          string
+	Image       string
 	Target      string // "localhost:3001"
 	HealthPath  string // "/health"
 	HealthState HealthState
+	Ready       bool // Passing its readiness probe, so it receives traffic
+	Restarts    int  // Times restarted after failing its liveness probe
 	StartedAt   time.Time
 }
 
+// Probe configures one kind of health check for a container
+type Probe struct {
+	Path             string // Empty disables the probe
+	Interval         time.Duration
+	FailureThreshold int // Consecutive failures before the probe acts
+}
+
+// Probes configures how an app's containers are health checked
+type Probes struct {
+	Startup   Probe // Must pass once before the container gets traffic
+	Readiness Probe // Takes the container out of rotation while failing
+	Liveness  Probe // Restarts the container once failing
+}
+
+// BakePolicy watches a deployment's traffic after the switch and rolls back
+// to the previous color when it regresses. A zero Duration disables it.
+type BakePolicy struct {
+	Duration     time.Duration // How long the previous container is kept to roll back to
+	Interval     time.Duration // How often the error rate and latency are checked
+	MaxErrorRate float64       // Highest fraction of 5xx responses, e.g. 0.05, 0 for no limit
+	MaxLatency   time.Duration // Highest average response time, 0 for no limit
+	MinRequests  uint64        // Requests needed before the thresholds are applied
+}
+
 // Color represents blue or green in deployments
 type Color string
 
@@ -85,6 +112,7 @@ type TrafficSwitched struct {
 	ToColor      Color
 	FromTarget   string
 	ToTarget     string
+	Metadata     map[string]string // Attached by the client deploying, e.g. sha and actor
 }
 
 // DeploymentCompleted indicates a deployment finished successfully
@@ -94,10 +122,107 @@ type DeploymentCompleted struct {
 	Color        Color
 }
 
+// ContainerRestarted indicates a container was restarted after failing its liveness probe
+type ContainerRestarted struct {
+	BaseEvent
+	DeploymentID string
+	Color        Color
+	Container    string
+	Restarts     int
+	Error        string
+}
+
+// ContainerCrashed indicates an app container exited without being stopped
+type ContainerCrashed struct {
+	BaseEvent
+	Container string
+	ExitCode  int
+	Crashes   int           // Crashes within the crash loop window
+	Backoff   time.Duration // Delay before the supervisor restarts it
+}
+
+// CrashLoopChanged indicates an app container started or stopped crash looping
+type CrashLoopChanged struct {
+	BaseEvent
+	Container string
+	Looping   bool
+}
+
+// CapacityWarning indicates a container is close to one of its resource limits
+type CapacityWarning struct {
+	BaseEvent
+	Container string
+	Resource  string  // "memory"
+	Percent   float64 // Share of the limit in use
+}
+
+// Scaled indicates the autoscaler changed the number of replicas of an app
+type Scaled struct {
+	BaseEvent
+	Project string
+	App     string
+	From    int
+	To      int
+	Reason  string // The metric that drove the change, e.g. "cpu 92% (target 70%)"
+}
+
 // DeploymentFailed indicates a deployment failed
 type DeploymentFailed struct {
 	BaseEvent
 	DeploymentID string
 	Color        Color
 	Error        string
-}
\ No newline at end of file
+	Metadata     map[string]string // Attached by the client deploying, e.g. sha and actor
+}
+
+// DeploymentRolledBack indicates traffic went back to the previous color
+// because the new one regressed during its bake period
+type DeploymentRolledBack struct {
+	BaseEvent
+	DeploymentID string
+	FromColor    Color
+	ToColor      Color
+	Reason       string
+}
+
+// CertificateIssued indicates a certificate was acquired or renewed
+type CertificateIssued struct {
+	BaseEvent
+	ExpiresAt time.Time
+}
+
+// CertificateFailed indicates a certificate acquisition attempt failed
+type CertificateFailed struct {
+	BaseEvent
+	Error    string
+	Attempts int  // Failed attempts so far, including this one
+	Final    bool // No further attempts will be made
+}
+
+// CertificateRevoked indicates a certificate was revoked with the CA
+type CertificateRevoked struct {
+	BaseEvent
+	Reason string // e.g. "key_compromise"
+}
+
+// CertificateExpiring indicates a certificate crossed an expiry alert threshold
+type CertificateExpiring struct {
+	BaseEvent
+	ExpiresAt time.Time
+	DaysLeft  int
+}
+
+// CertificateRenewalFailing indicates a certificate's renewal failed several times in a row
+type CertificateRenewalFailing struct {
+	BaseEvent
+	Attempts  int
+	ExpiresAt time.Time
+	Error     string
+}
+
+// HealthChanged indicates a host's health check result flipped
+type HealthChanged struct {
+	BaseEvent
+	Healthy bool
+	Error   string
+}
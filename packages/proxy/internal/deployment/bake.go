@@ -0,0 +1,190 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/router"
+)
+
+// defaultBakeInterval applies to bake policies configured without an interval
+const defaultBakeInterval = 10 * time.Second
+
+// RequestStatsSource reads the request counters bake policies judge a
+// deployment by, e.g. the router's
+type RequestStatsSource interface {
+	RequestStats() map[string]router.RequestStats
+}
+
+// SetRequestStats sets where the controller reads hosts' request counters.
+// Without it bake policies are ignored.
+func (c *Controller) SetRequestStats(source RequestStatsSource) {
+	c.probesMu.Lock()
+	defer c.probesMu.Unlock()
+	c.requestStats = source
+}
+
+// SetBakePolicy configures how an app's future deployments are watched after
+// their traffic switch. The previous container is kept for the bake period
+// and traffic returns to it when the error rate or latency exceeds the limits.
+func (c *Controller) SetBakePolicy(project, app string, policy core.BakePolicy) {
+	if policy.Interval <= 0 {
+		policy.Interval = defaultBakeInterval
+	}
+
+	c.probesMu.Lock()
+	defer c.probesMu.Unlock()
+	c.bakePolicies[project+"/"+app] = policy
+}
+
+// bakePolicyFor returns an app's bake policy, a zero policy when its
+// deployments aren't baked
+func (c *Controller) bakePolicyFor(project, app string) core.BakePolicy {
+	c.probesMu.RLock()
+	defer c.probesMu.RUnlock()
+
+	if c.requestStats == nil {
+		return core.BakePolicy{}
+	}
+	return c.bakePolicies[project+"/"+app]
+}
+
+// hostRequestStats returns the request counters of a host
+func (c *Controller) hostRequestStats(hostname string) router.RequestStats {
+	c.probesMu.RLock()
+	source := c.requestStats
+	c.probesMu.RUnlock()
+
+	if source == nil {
+		return router.RequestStats{}
+	}
+	return source.RequestStats()[hostname]
+}
+
+// regression compares the requests served since baseline with a policy's
+// limits, returning why the deployment regressed or "" if it didn't
+func regression(policy core.BakePolicy, baseline, current router.RequestStats) string {
+	requests := current.Requests - baseline.Requests
+	if requests == 0 || requests < policy.MinRequests {
+		return ""
+	}
+
+	errorRate := float64(current.Errors-baseline.Errors) / float64(requests)
+	if policy.MaxErrorRate > 0 && errorRate > policy.MaxErrorRate {
+		return fmt.Sprintf("error rate %.1f%% over %d requests exceeds %.1f%%",
+			errorRate*100, requests, policy.MaxErrorRate*100)
+	}
+
+	latency := (current.Latency - baseline.Latency) / time.Duration(requests)
+	if policy.MaxLatency > 0 && latency > policy.MaxLatency {
+		return fmt.Sprintf("average latency %s over %d requests exceeds %s",
+			latency.Round(time.Millisecond), requests, policy.MaxLatency)
+	}
+
+	return ""
+}
+
+// bakeAndCleanup watches a deployment for its bake period and then removes
+// the previous container, or rolls back to it when the deployment regresses.
+// Nothing is done once a newer deployment replaced either container.
+func (c *Controller) bakeAndCleanup(ctx context.Context, hostname string, newColor, oldColor core.Color, newStartedAt, oldStartedAt time.Time, policy core.BakePolicy) {
+	log.Printf("[DEPLOY] Baking %s (%s) for %s before removing %s", hostname, newColor, policy.Duration, oldColor)
+
+	baseline := c.hostRequestStats(hostname)
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(policy.Duration)
+	defer deadline.Stop()
+
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-deadline.C:
+			done = true
+		}
+
+		if reason := regression(policy, baseline, c.hostRequestStats(hostname)); reason != "" {
+			c.rollback(hostname, newColor, oldColor, newStartedAt, oldStartedAt, reason)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deployment, ok := c.bakedDeployment(hostname, newColor, oldColor, newStartedAt, oldStartedAt)
+	if !ok {
+		return
+	}
+
+	c.cleanupOldContainer(deployment, oldColor)
+
+	c.events.Publish(&core.DeploymentCompleted{
+		BaseEvent:    core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+		DeploymentID: deployment.ID,
+		Color:        newColor,
+	})
+}
+
+// bakedDeployment returns a deployment that still serves the baking
+// container with the previous one standing by. The caller must hold c.mu.
+func (c *Controller) bakedDeployment(hostname string, newColor, oldColor core.Color, newStartedAt, oldStartedAt time.Time) (*core.Deployment, bool) {
+	if _, ok := c.liveContainer(hostname, newColor, newStartedAt); !ok {
+		return nil, false
+	}
+	deployment, err := c.store.GetDeployment(hostname)
+	if err != nil {
+		return nil, false
+	}
+
+	previous := c.getContainer(deployment, oldColor)
+	if previous.Target == "" || !previous.StartedAt.Equal(oldStartedAt) {
+		return nil, false
+	}
+	return deployment, true
+}
+
+// rollback switches traffic back to the previous container and removes the
+// one that regressed
+func (c *Controller) rollback(hostname string, newColor, oldColor core.Color, newStartedAt, oldStartedAt time.Time, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deployment, ok := c.bakedDeployment(hostname, newColor, oldColor, newStartedAt, oldStartedAt)
+	if !ok {
+		return
+	}
+
+	log.Printf("[DEPLOY] %s (%s) regressed: %s - rolling back to %s", hostname, newColor, reason, oldColor)
+
+	previous := c.getContainer(deployment, oldColor)
+	c.proxy.UpdateRoute(hostname, previous.Target, true)
+
+	failed := c.getContainer(deployment, newColor)
+	containerName := c.extractContainerName(failed.Target)
+	if err := c.stopContainer(containerName); err != nil {
+		log.Printf("[DEPLOY] Failed to stop container %s: %v", containerName, err)
+	}
+	failed.Target = ""
+	failed.Ready = false
+	failed.HealthState = core.HealthStopped
+	c.setContainer(deployment, newColor, failed)
+
+	deployment.Active = oldColor
+	deployment.UpdatedAt = time.Now()
+	c.store.SaveDeployment(deployment)
+
+	c.events.Publish(core.DeploymentRolledBack{
+		BaseEvent:    core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+		DeploymentID: deployment.ID,
+		FromColor:    newColor,
+		ToColor:      oldColor,
+		Reason:       reason,
+	})
+}
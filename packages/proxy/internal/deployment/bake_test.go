@@ -0,0 +1,152 @@
+package deployment
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/elitan/iop/proxy/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRequestStats serves request counters set by the test
+type fakeRequestStats struct {
+	mu    sync.Mutex
+	stats map[string]router.RequestStats
+}
+
+func (f *fakeRequestStats) RequestStats() map[string]router.RequestStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := make(map[string]router.RequestStats, len(f.stats))
+	for host, s := range f.stats {
+		stats[host] = s
+	}
+	return stats
+}
+
+func (f *fakeRequestStats) add(hostname string, requests, errors uint64, latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.stats[hostname]
+	s.Requests += requests
+	s.Errors += errors
+	s.Latency += latency
+	f.stats[hostname] = s
+}
+
+func TestRegression(t *testing.T) {
+	policy := core.BakePolicy{MaxErrorRate: 0.05, MaxLatency: 200 * time.Millisecond, MinRequests: 20}
+	baseline := router.RequestStats{Requests: 1000, Errors: 100, Latency: 1000 * time.Second}
+
+	// Errors before the switch don't count against the new release
+	assert.Empty(t, regression(policy, baseline, router.RequestStats{Requests: 1100, Errors: 102, Latency: 1010 * time.Second}))
+
+	// Too few requests to judge
+	assert.Empty(t, regression(policy, baseline, router.RequestStats{Requests: 1010, Errors: 110, Latency: 1000 * time.Second}))
+
+	assert.Equal(t, "error rate 40.0% over 50 requests exceeds 5.0%",
+		regression(policy, baseline, router.RequestStats{Requests: 1050, Errors: 120, Latency: 1005 * time.Second}))
+
+	assert.Equal(t, "average latency 500ms over 20 requests exceeds 200ms",
+		regression(policy, baseline, router.RequestStats{Requests: 1020, Errors: 100, Latency: 1010 * time.Second}))
+
+	assert.Empty(t, regression(core.BakePolicy{}, baseline, router.RequestStats{Requests: 1050, Errors: 150}))
+}
+
+// deployBaked deploys two releases of a baked app and returns the first
+// release's target once traffic switched to the second
+func deployBaked(t *testing.T, ctx context.Context, controller *Controller, proxyUpdater *mockProxyUpdater) string {
+	require.NoError(t, controller.Deploy(ctx, "blog.example.com", "blog:v1", "blog", "web"))
+	require.Eventually(t, func() bool {
+		return proxyUpdater.GetRoute("blog.example.com").target != ""
+	}, time.Second, 5*time.Millisecond)
+	previous := proxyUpdater.GetRoute("blog.example.com").target
+
+	require.NoError(t, controller.Deploy(ctx, "blog.example.com", "blog:v2", "blog", "web"))
+	require.Eventually(t, func() bool {
+		return proxyUpdater.GetRoute("blog.example.com").target != previous
+	}, time.Second, 5*time.Millisecond)
+	return previous
+}
+
+func TestBakeRollsBackOnErrorRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	proxyUpdater := newMockProxyUpdater()
+	stats := &fakeRequestStats{stats: map[string]router.RequestStats{}}
+	bus := events.NewSimpleBus()
+	rollbacks := bus.Subscribe()
+
+	controller := NewController(storage.NewMemoryStore(), proxyUpdater, &mockHealthChecker{shouldPass: true}, bus)
+	controller.SetProbes("blog", "web", core.Probes{Startup: core.Probe{Interval: 10 * time.Millisecond}})
+	controller.SetRequestStats(stats)
+	controller.SetBakePolicy("blog", "web", core.BakePolicy{
+		Duration:     time.Second,
+		Interval:     10 * time.Millisecond,
+		MaxErrorRate: 0.05,
+		MinRequests:  10,
+	})
+
+	previous := deployBaked(t, ctx, controller, proxyUpdater)
+	stats.add("blog.example.com", 50, 20, time.Second)
+
+	require.Eventually(t, func() bool {
+		return proxyUpdater.GetRoute("blog.example.com").target == previous
+	}, time.Second, 5*time.Millisecond)
+
+	deployment, err := controller.GetStatus("blog.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, previous, controller.getContainer(deployment, deployment.Active).Target)
+	assert.Empty(t, controller.getContainer(deployment, controller.getInactiveColor(deployment)).Target)
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case event := <-rollbacks:
+			if rolledBack, ok := event.(core.DeploymentRolledBack); ok {
+				assert.Equal(t, deployment.Active, rolledBack.ToColor)
+				assert.Contains(t, rolledBack.Reason, "error rate 40.0%")
+				return
+			}
+		case <-deadline:
+			t.Fatal("expected a DeploymentRolledBack event")
+		}
+	}
+}
+
+func TestBakeRemovesPreviousContainerAfterwards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	proxyUpdater := newMockProxyUpdater()
+	stats := &fakeRequestStats{stats: map[string]router.RequestStats{}}
+
+	controller := NewController(storage.NewMemoryStore(), proxyUpdater, &mockHealthChecker{shouldPass: true}, events.NewSimpleBus())
+	controller.SetProbes("blog", "web", core.Probes{Startup: core.Probe{Interval: 10 * time.Millisecond}})
+	controller.SetRequestStats(stats)
+	controller.SetBakePolicy("blog", "web", core.BakePolicy{
+		Duration:     100 * time.Millisecond,
+		Interval:     10 * time.Millisecond,
+		MaxErrorRate: 0.05,
+	})
+
+	deployBaked(t, ctx, controller, proxyUpdater)
+	stats.add("blog.example.com", 50, 1, time.Second)
+
+	// The previous container stands by during the bake period
+	deployment, err := controller.GetStatus("blog.example.com")
+	require.NoError(t, err)
+	assert.NotEmpty(t, controller.getContainer(deployment, controller.getInactiveColor(deployment)).Target)
+
+	require.Eventually(t, func() bool {
+		deployment, err := controller.GetStatus("blog.example.com")
+		return err == nil && controller.getContainer(deployment, controller.getInactiveColor(deployment)).Target == ""
+	}, time.Second, 5*time.Millisecond)
+}
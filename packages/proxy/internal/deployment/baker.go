@@ -0,0 +1,229 @@
+package deployment
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/router"
+)
+
+// bakeStopTimeout is how long containers get to shut down when a bake
+// removes them, as the CLI gives replaced containers
+const bakeStopTimeout = 30 * time.Second
+
+// Docker is the part of the Docker Engine API a baker moves traffic with
+type Docker interface {
+	ConnectNetworkWithAliases(ctx context.Context, network, id string, aliases []string) error
+	DisconnectNetwork(ctx context.Context, network, id string) error
+	Remove(ctx context.Context, id string, timeout time.Duration) error
+}
+
+// Release is an app version the CLI switched traffic to, with the containers
+// it replaced kept running but out of the app's network aliases
+type Release struct {
+	DeploymentID  string
+	Project       string
+	Target        string   // Alias the app's hosts route to, e.g. blog-web
+	Network       string   // Project network the aliases live on
+	Aliases       []string // Every alias the app is reachable as
+	Color         core.Color
+	Containers    []string
+	PreviousColor core.Color
+	Previous      []string
+	Policy        core.BakePolicy
+}
+
+// sameContainers reports whether two releases run the same containers
+func (r Release) sameContainers(other Release) bool {
+	return r.Color == other.Color && strings.Join(r.Containers, ",") == strings.Join(other.Containers, ",")
+}
+
+// Baker watches releases after their traffic switch and rolls back to the
+// previous containers when the new ones regress, removing the previous ones
+// once the bake period passes
+type Baker struct {
+	docker Docker
+	stats  RequestStatsSource
+	events core.EventBus
+
+	mu    sync.Mutex
+	bakes map[string]*bake // Keyed by project/target
+}
+
+// bake is a release being watched
+type bake struct {
+	release   Release
+	baselines map[string]router.RequestStats // Keyed by hostname
+	cancel    context.CancelFunc
+}
+
+// NewBaker creates a baker judging releases by the given request counters
+func NewBaker(docker Docker, stats RequestStatsSource, events core.EventBus) *Baker {
+	return &Baker{
+		docker: docker,
+		stats:  stats,
+		events: events,
+		bakes:  make(map[string]*bake),
+	}
+}
+
+// Bake watches a host serving a release. Hosts of a release already being
+// baked join its bake, a different release of the app replaces it.
+func (b *Baker) Bake(hostname string, release Release) {
+	if release.Policy.Interval <= 0 {
+		release.Policy.Interval = defaultBakeInterval
+	}
+	key := release.Project + "/" + release.Target
+	baseline := b.stats.RequestStats()[hostname]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if current, ok := b.bakes[key]; ok {
+		if current.release.sameContainers(release) {
+			if _, ok := current.baselines[hostname]; !ok {
+				current.baselines[hostname] = baseline
+			}
+			return
+		}
+		current.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bk := &bake{
+		release:   release,
+		baselines: map[string]router.RequestStats{hostname: baseline},
+		cancel:    cancel,
+	}
+	b.bakes[key] = bk
+
+	log.Printf("[BAKE] Baking %s (%s) for %s before removing %s",
+		release.Target, release.Color, release.Policy.Duration, release.PreviousColor)
+	go b.run(ctx, key, bk)
+}
+
+// Cancel stops watching an app's release without touching its containers,
+// as a deploy that isn't baked replaced them
+func (b *Baker) Cancel(project, target string) {
+	key := project + "/" + target
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if current, ok := b.bakes[key]; ok {
+		log.Printf("[BAKE] Stopped baking %s, a newer deploy replaced it", target)
+		current.cancel()
+		delete(b.bakes, key)
+	}
+}
+
+// run checks a release every interval until its bake period passes
+func (b *Baker) run(ctx context.Context, key string, bk *bake) {
+	policy := bk.release.Policy
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(policy.Duration)
+	defer deadline.Stop()
+
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-deadline.C:
+			done = true
+		}
+
+		if hostname, reason := b.regressed(bk); reason != "" {
+			if b.finish(key, bk) {
+				b.rollback(bk.release, hostname, reason)
+			}
+			return
+		}
+	}
+
+	if b.finish(key, bk) {
+		b.complete(bk.release)
+	}
+}
+
+// regressed returns a host whose requests since the switch exceed the
+// release's limits and why, or "" when all hosts are within them
+func (b *Baker) regressed(bk *bake) (string, string) {
+	stats := b.stats.RequestStats()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for hostname, baseline := range bk.baselines {
+		if reason := regression(bk.release.Policy, baseline, stats[hostname]); reason != "" {
+			return hostname, reason
+		}
+	}
+	return "", ""
+}
+
+// finish ends a bake, reporting false when a newer deploy replaced it
+func (b *Baker) finish(key string, bk *bake) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.bakes[key] != bk {
+		return false
+	}
+	delete(b.bakes, key)
+	bk.cancel()
+	return true
+}
+
+// rollback moves the app's aliases back to the previous containers and
+// removes the ones that regressed
+func (b *Baker) rollback(release Release, hostname, reason string) {
+	log.Printf("[BAKE] %s (%s) regressed on %s: %s - rolling back to %s",
+		release.Target, release.Color, hostname, reason, release.PreviousColor)
+
+	ctx := context.Background()
+	for _, container := range release.Previous {
+		// Reconnected to take the aliases back, it stayed on the network without them
+		if err := b.docker.DisconnectNetwork(ctx, release.Network, container); err != nil {
+			log.Printf("[BAKE] Failed to disconnect %s from %s: %v", container, release.Network, err)
+		}
+		if err := b.docker.ConnectNetworkWithAliases(ctx, release.Network, container, release.Aliases); err != nil {
+			log.Printf("[BAKE] Failed to connect %s to %s: %v", container, release.Network, err)
+		}
+	}
+	for _, container := range release.Containers {
+		if err := b.docker.DisconnectNetwork(ctx, release.Network, container); err != nil {
+			log.Printf("[BAKE] Failed to disconnect %s from %s: %v", container, release.Network, err)
+		}
+		if err := b.docker.Remove(ctx, container, bakeStopTimeout); err != nil {
+			log.Printf("[BAKE] Failed to remove %s: %v", container, err)
+		}
+	}
+
+	if b.events != nil {
+		b.events.Publish(core.DeploymentRolledBack{
+			BaseEvent:    core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+			DeploymentID: release.DeploymentID,
+			FromColor:    release.Color,
+			ToColor:      release.PreviousColor,
+			Reason:       reason,
+		})
+	}
+}
+
+// complete removes the previous containers of a release that passed its bake
+func (b *Baker) complete(release Release) {
+	log.Printf("[BAKE] %s (%s) passed its bake, removing %s", release.Target, release.Color, release.PreviousColor)
+
+	for _, container := range release.Previous {
+		if err := b.docker.Remove(context.Background(), container, bakeStopTimeout); err != nil {
+			log.Printf("[BAKE] Failed to remove %s: %v", container, err)
+		}
+	}
+}
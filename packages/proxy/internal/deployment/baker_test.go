@@ -0,0 +1,96 @@
+package deployment
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/router"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDocker records the containers a baker removes
+type fakeDocker struct {
+	mu      sync.Mutex
+	removed []string
+}
+
+func (f *fakeDocker) ConnectNetworkWithAliases(ctx context.Context, network, id string, aliases []string) error {
+	return nil
+}
+
+func (f *fakeDocker) DisconnectNetwork(ctx context.Context, network, id string) error {
+	return nil
+}
+
+func (f *fakeDocker) Remove(ctx context.Context, id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+func (f *fakeDocker) Removed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.removed...)
+}
+
+func bakedRelease(color core.Color, containers ...string) Release {
+	previous := core.Blue
+	if color == core.Blue {
+		previous = core.Green
+	}
+	return Release{
+		Project:       "blog",
+		Target:        "blog-web",
+		Network:       "blog-network",
+		Aliases:       []string{"web", "blog-web"},
+		Color:         color,
+		Containers:    containers,
+		PreviousColor: previous,
+		Previous:      []string{"blog-web-" + string(previous)},
+		Policy:        core.BakePolicy{Duration: 50 * time.Millisecond, Interval: 10 * time.Millisecond, MaxErrorRate: 0.05},
+	}
+}
+
+func TestBakerRemovesPreviousContainersAfterwards(t *testing.T) {
+	docker := &fakeDocker{}
+	stats := &fakeRequestStats{stats: map[string]router.RequestStats{}}
+	baker := NewBaker(docker, stats, nil)
+
+	// Both hosts of the release share one bake
+	baker.Bake("blog.example.com", bakedRelease(core.Green, "blog-web-green"))
+	baker.Bake("www.blog.example.com", bakedRelease(core.Green, "blog-web-green"))
+	stats.add("blog.example.com", 100, 1, time.Second)
+
+	assert.Eventually(t, func() bool {
+		return len(docker.Removed()) > 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"blog-web-blue"}, docker.Removed())
+}
+
+func TestBakerLeavesContainersOfReplacedReleases(t *testing.T) {
+	docker := &fakeDocker{}
+	stats := &fakeRequestStats{stats: map[string]router.RequestStats{}}
+	baker := NewBaker(docker, stats, nil)
+
+	// A newer baked release takes over, its previous containers are the
+	// first release's new ones
+	baker.Bake("blog.example.com", bakedRelease(core.Green, "blog-web-green"))
+	baker.Bake("blog.example.com", bakedRelease(core.Blue, "blog-web-blue"))
+
+	assert.Eventually(t, func() bool {
+		return len(docker.Removed()) > 0
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"blog-web-green"}, docker.Removed())
+
+	// Deploys that aren't baked end the bake without removing anything
+	baker.Bake("blog.example.com", bakedRelease(core.Green, "blog-web-green"))
+	baker.Cancel("blog", "blog-web")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"blog-web-green"}, docker.Removed())
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/tracing"
 )
 
 // ProxyUpdater interface to update proxy routes
@@ -23,6 +24,11 @@ type Controller struct {
 	proxy  ProxyUpdater
 	health core.HealthChecker
 	events core.EventBus
+
+	probesMu     sync.RWMutex // Protects the per-app settings below
+	probes       map[string]core.Probes     // Keyed by project/app
+	bakePolicies map[string]core.BakePolicy // Keyed by project/app
+	requestStats RequestStatsSource
 }
 
 // NewController creates a new deployment controller
@@ -32,11 +38,24 @@ func NewController(store core.DeploymentStore, proxy ProxyUpdater, health core.H
 		proxy:  proxy,
 		health: health,
 		events: events,
+		probes:       make(map[string]core.Probes),
+		bakePolicies: make(map[string]core.BakePolicy),
 	}
 }
 
 // Deploy orchestrates a blue-green deployment with immediate cleanup
-func (c *Controller) Deploy(ctx context.Context, hostname, imageTag, project, app string) error {
+func (c *Controller) Deploy(ctx context.Context, hostname, imageTag, project, app string) (err error) {
+	ctx, span := tracing.Start(ctx, "deployment.deploy", tracing.SpanKindInternal,
+		tracing.String("host", hostname),
+		tracing.String("image", imageTag),
+		tracing.String("project", project),
+		tracing.String("app", app),
+	)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	// Simple input validation
 	if hostname == "" {
 		return fmt.Errorf("hostname cannot be empty")
@@ -51,6 +70,9 @@ func (c *Controller) Deploy(ctx context.Context, hostname, imageTag, project, ap
 	
 	log.Printf("[DEPLOY] Starting deployment for %s -> %s", hostname, imageTag)
 
+	probes := c.probesFor(project, app)
+	bake := c.bakePolicyFor(project, app)
+
 	// Get or create deployment
 	deployment, err := c.getOrCreateDeployment(hostname, project, app)
 	if err != nil {
@@ -60,12 +82,14 @@ func (c *Controller) Deploy(ctx context.Context, hostname, imageTag, project, ap
 	// Determine which color to deploy to (inactive)
 	inactiveColor := c.getInactiveColor(deployment)
 	containerName := c.generateContainerName(hostname, inactiveColor)
+	span.SetAttributes(tracing.String("deployment.color", string(inactiveColor)))
 	
 	// Create new container record
 	newContainer := core.Container{
 		ID:          containerName,
+		Image:       imageTag,
 		Target:      fmt.Sprintf("%s:3000", containerName), // Always port 3000
-		HealthPath:  "/health",
+		HealthPath:  probes.Startup.Path,
 		HealthState: core.HealthUnknown,
 		StartedAt:   time.Now(),
 	}
@@ -93,7 +117,7 @@ func (c *Controller) Deploy(ctx context.Context, hostname, imageTag, project, ap
 	}
 
 	// Start health checking - this will handle the rest of the flow
-	go c.healthCheckAndSwitch(ctx, deployment, inactiveColor)
+	go c.healthCheckAndSwitch(ctx, deployment, inactiveColor, probes, bake)
 
 	return nil
 }
@@ -103,54 +127,51 @@ func (c *Controller) GetStatus(hostname string) (*core.Deployment, error) {
 	return c.store.GetDeployment(hostname)
 }
 
-// healthCheckAndSwitch handles health checking and automatic traffic switching
-func (c *Controller) healthCheckAndSwitch(ctx context.Context, deployment *core.Deployment, newColor core.Color) {
+// healthCheckAndSwitch runs the startup probe, switches traffic once it passes
+// and then keeps watching the container with its readiness and liveness probes
+func (c *Controller) healthCheckAndSwitch(ctx context.Context, deployment *core.Deployment, newColor core.Color, probes core.Probes, bake core.BakePolicy) {
 	log.Printf("[DEPLOY] Starting health checks for %s (%s)", deployment.Hostname, newColor)
 
-	maxAttempts := 12 // 1 minute with 5-second intervals
-	attempts := 0
-
-	ticker := time.NewTicker(50 * time.Millisecond) // Fast for testing
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("[DEPLOY] Health check cancelled for %s", deployment.Hostname)
-			return
-		case <-ticker.C:
-			attempts++
-			container := c.getContainer(deployment, newColor)
-			
-			// Health check
-			err := c.health.CheckHealth(ctx, container.Target, container.HealthPath)
-			
-			if err == nil {
-				// Health check passed - switch traffic and cleanup
-				c.switchTrafficAndCleanup(deployment, newColor)
-				return
-			}
-
-			// Health check failed
-			log.Printf("[DEPLOY] Health check failed for %s (%s): %v (attempt %d/%d)", 
-				deployment.Hostname, newColor, err, attempts, maxAttempts)
-			
-			if attempts >= maxAttempts {
-				// Max attempts reached - mark as failed
-				c.markDeploymentFailed(deployment, newColor, err)
-				return
-			}
-
-			// Update container state and continue
-			container.HealthState = core.HealthChecking
-			c.setContainer(deployment, newColor, container)
-			c.store.SaveDeployment(deployment)
-		}
+	// Continues the deploy span's trace, which ends once the container is started
+	_, span := tracing.Start(ctx, "deployment.health_check", tracing.SpanKindInternal,
+		tracing.String("host", deployment.Hostname),
+		tracing.String("deployment.color", string(newColor)),
+	)
+
+	container := c.getContainer(deployment, newColor)
+	attempts, err := c.waitForStartup(ctx, deployment.Hostname, newColor, container.Target, probes.Startup, func() {
+		// Update container state and continue
+		container := c.getContainer(deployment, newColor)
+		container.HealthState = core.HealthChecking
+		c.setContainer(deployment, newColor, container)
+		c.store.SaveDeployment(deployment)
+	})
+	span.SetAttributes(tracing.Int("health.attempts", attempts))
+
+	if ctx.Err() != nil {
+		log.Printf("[DEPLOY] Health check cancelled for %s", deployment.Hostname)
+		span.End()
+		return
 	}
+
+	if err != nil {
+		// Failure budget used up - mark as failed
+		span.RecordError(err)
+		span.End()
+		c.markDeploymentFailed(deployment, newColor, err)
+		return
+	}
+
+	// Health check passed - switch traffic and cleanup
+	c.switchTrafficAndCleanup(ctx, deployment, newColor, bake)
+	span.End()
+
+	c.monitor(ctx, deployment.Hostname, newColor, c.getContainer(deployment, newColor).StartedAt, probes)
 }
 
-// switchTrafficAndCleanup atomically switches traffic and cleans up old container
-func (c *Controller) switchTrafficAndCleanup(deployment *core.Deployment, newColor core.Color) {
+// switchTrafficAndCleanup atomically switches traffic and cleans up old container,
+// right away or once the deployment has baked
+func (c *Controller) switchTrafficAndCleanup(ctx context.Context, deployment *core.Deployment, newColor core.Color, bake core.BakePolicy) {
 	log.Printf("[DEPLOY] Health check passed for %s (%s) - switching traffic", deployment.Hostname, newColor)
 
 	// Get old and new containers
@@ -160,6 +181,7 @@ func (c *Controller) switchTrafficAndCleanup(deployment *core.Deployment, newCol
 
 	// Update new container state
 	newContainer.HealthState = core.HealthHealthy
+	newContainer.Ready = true
 	c.setContainer(deployment, newColor, newContainer)
 
 	// Update proxy (atomic traffic switch)
@@ -187,6 +209,12 @@ func (c *Controller) switchTrafficAndCleanup(deployment *core.Deployment, newCol
 	log.Printf("[DEPLOY] Traffic switched successfully for %s: %s -> %s", 
 		deployment.Hostname, oldContainer.Target, newContainer.Target)
 
+	// Keep the old container to roll back to while the deployment bakes
+	if bake.Duration > 0 && oldContainer.Target != "" {
+		go c.bakeAndCleanup(ctx, deployment.Hostname, newColor, oldColor, newContainer.StartedAt, oldContainer.StartedAt, bake)
+		return
+	}
+
 	// Clean up old container immediately
 	if oldContainer.Target != "" {
 		c.cleanupOldContainer(deployment, oldColor)
@@ -0,0 +1,297 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+)
+
+const (
+	// defaultProbeInterval and defaultProbeFailures apply to readiness and
+	// liveness probes configured without an interval or threshold
+	defaultProbeInterval = 10 * time.Second
+	defaultProbeFailures = 3
+)
+
+// DefaultProbes returns the probes of apps without their own configuration:
+// a startup probe with a budget of 12 attempts and no readiness or liveness probe
+func DefaultProbes() core.Probes {
+	return core.Probes{
+		Startup: core.Probe{
+			Path:             "/health",
+			Interval:         50 * time.Millisecond, // Fast for testing
+			FailureThreshold: 12,
+		},
+	}
+}
+
+// SetProbes configures the probes used for an app's future deployments.
+// Unset startup fields fall back to the defaults, and readiness and liveness
+// probes with a path but no interval or threshold check every 10 seconds and
+// act after 3 consecutive failures.
+func (c *Controller) SetProbes(project, app string, probes core.Probes) {
+	defaults := DefaultProbes()
+	if probes.Startup.Path == "" {
+		probes.Startup.Path = defaults.Startup.Path
+	}
+	if probes.Startup.Interval <= 0 {
+		probes.Startup.Interval = defaults.Startup.Interval
+	}
+	if probes.Startup.FailureThreshold <= 0 {
+		probes.Startup.FailureThreshold = defaults.Startup.FailureThreshold
+	}
+	for _, probe := range []*core.Probe{&probes.Readiness, &probes.Liveness} {
+		if probe.Path == "" {
+			continue
+		}
+		if probe.Interval <= 0 {
+			probe.Interval = defaultProbeInterval
+		}
+		if probe.FailureThreshold <= 0 {
+			probe.FailureThreshold = defaultProbeFailures
+		}
+	}
+
+	c.probesMu.Lock()
+	defer c.probesMu.Unlock()
+	c.probes[project+"/"+app] = probes
+}
+
+// probesFor returns the probes configured for an app
+func (c *Controller) probesFor(project, app string) core.Probes {
+	c.probesMu.RLock()
+	defer c.probesMu.RUnlock()
+
+	if probes, ok := c.probes[project+"/"+app]; ok {
+		return probes
+	}
+	return DefaultProbes()
+}
+
+// waitForStartup checks a container until its startup probe passes or the
+// probe's failure budget is used up, returning the number of attempts made
+func (c *Controller) waitForStartup(ctx context.Context, hostname string, color core.Color, target string, probe core.Probe, onFailure func()) (int, error) {
+	attempts := 0
+
+	ticker := time.NewTicker(probe.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return attempts, ctx.Err()
+		case <-ticker.C:
+			attempts++
+
+			err := c.health.CheckHealth(ctx, target, probe.Path)
+			if err == nil {
+				return attempts, nil
+			}
+
+			log.Printf("[DEPLOY] Health check failed for %s (%s): %v (attempt %d/%d)",
+				hostname, color, err, attempts, probe.FailureThreshold)
+
+			if attempts >= probe.FailureThreshold {
+				return attempts, err
+			}
+			onFailure()
+		}
+	}
+}
+
+// monitor runs a live container's readiness and liveness probes until the
+// container is replaced. Readiness failures take it out of rotation and
+// liveness failures restart it.
+func (c *Controller) monitor(ctx context.Context, hostname string, color core.Color, startedAt time.Time, probes core.Probes) {
+	var readiness, liveness <-chan time.Time
+	if probes.Readiness.Path != "" {
+		ticker := time.NewTicker(probes.Readiness.Interval)
+		defer ticker.Stop()
+		readiness = ticker.C
+	}
+	if probes.Liveness.Path != "" {
+		ticker := time.NewTicker(probes.Liveness.Interval)
+		defer ticker.Stop()
+		liveness = ticker.C
+	}
+	if readiness == nil && liveness == nil {
+		return
+	}
+
+	readinessFailures, livenessFailures := 0, 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-readiness:
+			container, ok := c.liveContainer(hostname, color, startedAt)
+			if !ok {
+				return
+			}
+
+			if err := c.health.CheckHealth(ctx, container.Target, probes.Readiness.Path); err != nil {
+				readinessFailures++
+				if container.Ready && readinessFailures >= probes.Readiness.FailureThreshold {
+					log.Printf("[DEPLOY] Readiness probe failed %d times for %s (%s): %v - removing from rotation",
+						readinessFailures, hostname, color, err)
+					c.setReady(hostname, color, startedAt, false)
+				}
+				continue
+			}
+
+			readinessFailures = 0
+			if !container.Ready {
+				log.Printf("[DEPLOY] Readiness probe passed for %s (%s) - returning to rotation", hostname, color)
+				c.setReady(hostname, color, startedAt, true)
+			}
+		case <-liveness:
+			container, ok := c.liveContainer(hostname, color, startedAt)
+			if !ok {
+				return
+			}
+
+			err := c.health.CheckHealth(ctx, container.Target, probes.Liveness.Path)
+			if err == nil {
+				livenessFailures = 0
+				continue
+			}
+
+			livenessFailures++
+			if livenessFailures < probes.Liveness.FailureThreshold {
+				continue
+			}
+
+			log.Printf("[DEPLOY] Liveness probe failed %d times for %s (%s): %v - restarting container",
+				livenessFailures, hostname, color, err)
+			startedAt, ok = c.restartContainer(ctx, hostname, color, startedAt, probes.Startup, err)
+			if !ok {
+				return
+			}
+			readinessFailures, livenessFailures = 0, 0
+		}
+	}
+}
+
+// liveContainer returns the container still serving a deployment, or false
+// once it has been replaced by a newer one
+func (c *Controller) liveContainer(hostname string, color core.Color, startedAt time.Time) (core.Container, bool) {
+	deployment, err := c.store.GetDeployment(hostname)
+	if err != nil || deployment.Active != color {
+		return core.Container{}, false
+	}
+
+	container := c.getContainer(deployment, color)
+	if container.Target == "" || !container.StartedAt.Equal(startedAt) {
+		return core.Container{}, false
+	}
+	return container, true
+}
+
+// setReady moves a container in or out of rotation
+func (c *Controller) setReady(hostname string, color core.Color, startedAt time.Time, ready bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	container, ok := c.liveContainer(hostname, color, startedAt)
+	if !ok {
+		return
+	}
+	deployment, err := c.store.GetDeployment(hostname)
+	if err != nil {
+		return
+	}
+
+	container.Ready = ready
+	c.setContainer(deployment, color, container)
+	c.store.SaveDeployment(deployment)
+
+	c.proxy.UpdateRoute(hostname, container.Target, ready)
+}
+
+// restartContainer restarts a container that failed its liveness probe and
+// returns it to rotation once its startup probe passes again. It returns the
+// restarted container's start time, or false if the container was replaced
+// or didn't come back.
+func (c *Controller) restartContainer(ctx context.Context, hostname string, color core.Color, startedAt time.Time, startup core.Probe, cause error) (time.Time, bool) {
+	c.mu.Lock()
+	container, ok := c.liveContainer(hostname, color, startedAt)
+	if !ok {
+		c.mu.Unlock()
+		return time.Time{}, false
+	}
+	deployment, err := c.store.GetDeployment(hostname)
+	if err != nil {
+		c.mu.Unlock()
+		return time.Time{}, false
+	}
+
+	c.proxy.UpdateRoute(hostname, container.Target, false)
+
+	containerName := c.extractContainerName(container.Target)
+	if err := c.stopContainer(containerName); err != nil {
+		log.Printf("[DEPLOY] Failed to stop container %s: %v", containerName, err)
+	}
+	if err := c.startContainer(containerName, container.Image); err != nil {
+		log.Printf("[DEPLOY] Failed to restart container %s: %v", containerName, err)
+	}
+
+	container.Restarts++
+	container.Ready = false
+	container.HealthState = core.HealthChecking
+	container.StartedAt = time.Now()
+	c.setContainer(deployment, color, container)
+	c.store.SaveDeployment(deployment)
+	c.mu.Unlock()
+
+	c.events.Publish(&core.ContainerRestarted{
+		BaseEvent:    core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+		DeploymentID: deployment.ID,
+		Color:        color,
+		Restarts:     container.Restarts,
+		Error:        cause.Error(),
+	})
+
+	_, err = c.waitForStartup(ctx, hostname, color, container.Target, startup, func() {})
+	if ctx.Err() != nil {
+		return time.Time{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.liveContainer(hostname, color, container.StartedAt); !ok {
+		return time.Time{}, false
+	}
+	deployment, getErr := c.store.GetDeployment(hostname)
+	if getErr != nil {
+		return time.Time{}, false
+	}
+
+	if err != nil {
+		log.Printf("[DEPLOY] Restarted container %s failed its startup probe: %v", containerName, err)
+		container.HealthState = core.HealthUnhealthy
+		c.setContainer(deployment, color, container)
+		c.store.SaveDeployment(deployment)
+
+		c.events.Publish(&core.DeploymentFailed{
+			BaseEvent:    core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+			DeploymentID: deployment.ID,
+			Color:        color,
+			Error:        fmt.Sprintf("restarted container failed its startup probe: %v", err),
+		})
+		return time.Time{}, false
+	}
+
+	container.HealthState = core.HealthHealthy
+	container.Ready = true
+	c.setContainer(deployment, color, container)
+	c.store.SaveDeployment(deployment)
+	c.proxy.UpdateRoute(hostname, container.Target, true)
+
+	log.Printf("[DEPLOY] Restarted container %s is healthy again", containerName)
+	return container.StartedAt, true
+}
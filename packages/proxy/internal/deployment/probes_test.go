@@ -0,0 +1,115 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/elitan/iop/proxy/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pathHealthChecker fails checks of the paths marked as failing
+type pathHealthChecker struct {
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+func (p *pathHealthChecker) CheckHealth(ctx context.Context, target, healthPath string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing[healthPath] {
+		return fmt.Errorf("%s failed", healthPath)
+	}
+	return nil
+}
+
+func (p *pathHealthChecker) setFailing(path string, failing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing[path] = failing
+}
+
+func TestSetProbesFillsDefaults(t *testing.T) {
+	controller := NewController(storage.NewMemoryStore(), newMockProxyUpdater(), &mockHealthChecker{}, events.NewSimpleBus())
+
+	assert.Equal(t, DefaultProbes(), controller.probesFor("blog", "web"))
+
+	controller.SetProbes("blog", "web", core.Probes{
+		Startup:   core.Probe{FailureThreshold: 60},
+		Readiness: core.Probe{Path: "/ready"},
+	})
+	probes := controller.probesFor("blog", "web")
+	assert.Equal(t, "/health", probes.Startup.Path)
+	assert.Equal(t, 60, probes.Startup.FailureThreshold)
+	assert.Equal(t, defaultProbeInterval, probes.Readiness.Interval)
+	assert.Equal(t, defaultProbeFailures, probes.Readiness.FailureThreshold)
+	assert.Empty(t, probes.Liveness.Path)
+
+	assert.Equal(t, DefaultProbes(), controller.probesFor("blog", "worker"))
+}
+
+func TestReadinessAndLivenessProbes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemoryStore()
+	proxyUpdater := newMockProxyUpdater()
+	checker := &pathHealthChecker{failing: map[string]bool{}}
+	bus := events.NewSimpleBus()
+	restarts := bus.Subscribe()
+
+	controller := NewController(store, proxyUpdater, checker, bus)
+	controller.SetProbes("blog", "web", core.Probes{
+		Startup:   core.Probe{Path: "/started", Interval: 10 * time.Millisecond},
+		Readiness: core.Probe{Path: "/ready", Interval: 10 * time.Millisecond, FailureThreshold: 2},
+		Liveness:  core.Probe{Path: "/alive", Interval: 10 * time.Millisecond, FailureThreshold: 3},
+	})
+
+	require.NoError(t, controller.Deploy(ctx, "blog.example.com", "blog:v1", "blog", "web"))
+	require.Eventually(t, func() bool {
+		return proxyUpdater.GetRoute("blog.example.com").healthy
+	}, time.Second, 5*time.Millisecond)
+
+	// A failing readiness probe takes the container out of rotation without restarting it
+	checker.setFailing("/ready", true)
+	require.Eventually(t, func() bool {
+		return !proxyUpdater.GetRoute("blog.example.com").healthy
+	}, time.Second, 5*time.Millisecond)
+
+	checker.setFailing("/ready", false)
+	require.Eventually(t, func() bool {
+		return proxyUpdater.GetRoute("blog.example.com").healthy
+	}, time.Second, 5*time.Millisecond)
+
+	deployment, err := controller.GetStatus("blog.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 0, deployment.Blue.Restarts+deployment.Green.Restarts)
+
+	// A failing liveness probe restarts the container
+	checker.setFailing("/alive", true)
+	require.Eventually(t, func() bool {
+		deployment, err := controller.GetStatus("blog.example.com")
+		return err == nil && controller.getContainer(deployment, deployment.Active).Restarts > 0
+	}, time.Second, 5*time.Millisecond)
+	checker.setFailing("/alive", false)
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case event := <-restarts:
+			if restarted, ok := event.(*core.ContainerRestarted); ok {
+				assert.Equal(t, "blog.example.com", restarted.Hostname)
+				assert.Equal(t, "/alive failed", restarted.Error)
+				return
+			}
+		case <-deadline:
+			t.Fatal("expected a ContainerRestarted event")
+		}
+	}
+}
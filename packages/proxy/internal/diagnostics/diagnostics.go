@@ -0,0 +1,348 @@
+// Package diagnostics checks what the proxy needs to obtain certificates and
+// serve traffic: ports 80 and 443 reachable from outside, the Docker socket,
+// the ACME directory, disk space for certificates and state, and the clock.
+// The report is meant for troubleshooting, e.g. when certificates fail.
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// Status is the outcome of a check, or of the whole report
+type Status string
+
+const (
+	StatusOK      Status = "ok"
+	StatusWarn    Status = "warn"
+	StatusFail    Status = "fail"
+	StatusSkipped Status = "skipped"
+)
+
+const (
+	// DefaultEchoURL connects back to the caller on a port, see checkPorts
+	DefaultEchoURL = "https://ifconfig.co"
+	// checkTimeout bounds each check's network calls
+	checkTimeout = 10 * time.Second
+
+	// Free space below which certificates and state can't be written reliably
+	minFreeSpace  = 10 << 20
+	warnFreeSpace = 100 << 20
+
+	// Clock skew that breaks certificate validity checks and expiry timing
+	warnClockSkew = 30 * time.Second
+	failClockSkew = 5 * time.Minute
+)
+
+// Check is the result of one diagnostic
+type Check struct {
+	Name   string `json:"name"` // ports, docker, acme, disk or clock
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// Report is the result of all diagnostics. Its status is that of the worst check.
+type Report struct {
+	Status    Status    `json:"status"`
+	Checks    []Check   `json:"checks"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Docker is the part of the Docker Engine API the diagnostics use
+type Docker interface {
+	Ping(ctx context.Context) error
+}
+
+// Diagnostics runs the checks against the proxy's configuration
+type Diagnostics struct {
+	state   *state.State
+	docker  Docker
+	dirs    []string // Directories certificates and state are written to
+	echoURL string
+	client  *http.Client
+	now     func() time.Time
+}
+
+// New creates the diagnostics for the directories the proxy writes to.
+// IOP_ECHO_URL sets the service that checks the ports from outside, "off"
+// skips that check.
+func New(st *state.State, d Docker, dirs ...string) *Diagnostics {
+	echoURL := os.Getenv("IOP_ECHO_URL")
+	if echoURL == "" {
+		echoURL = DefaultEchoURL
+	}
+	return &Diagnostics{
+		state:   st,
+		docker:  d,
+		dirs:    dirs,
+		echoURL: strings.TrimSuffix(echoURL, "/"),
+		client:  &http.Client{Timeout: checkTimeout},
+		now:     time.Now,
+	}
+}
+
+// Run runs all checks concurrently and returns them in a fixed order
+func (d *Diagnostics) Run(ctx context.Context) *Report {
+	var ports, docker, acme, disk, clock Check
+	var wg sync.WaitGroup
+	for _, run := range []func(){
+		func() { ports = d.checkPorts(ctx) },
+		func() { docker = d.checkDocker(ctx) },
+		func() { acme, clock = d.checkACME(ctx) },
+		func() { disk = d.checkDisk() },
+	} {
+		wg.Add(1)
+		go func(run func()) {
+			defer wg.Done()
+			run()
+		}(run)
+	}
+	wg.Wait()
+
+	report := &Report{
+		Status:    StatusOK,
+		Checks:    []Check{ports, docker, acme, disk, clock},
+		CheckedAt: d.now().UTC(),
+	}
+	for _, check := range report.Checks {
+		if check.Status == StatusFail || (check.Status == StatusWarn && report.Status != StatusFail) {
+			report.Status = check.Status
+		}
+	}
+	return report
+}
+
+// echoResult is the echo service's answer whether it could connect back
+type echoResult struct {
+	IP        string `json:"ip"`
+	Port      int    `json:"port"`
+	Reachable bool   `json:"reachable"`
+}
+
+// checkPorts asks the echo service to connect back to this server on ports
+// 80 and 443. Let's Encrypt validates over port 80, clients connect on 443.
+func (d *Diagnostics) checkPorts(ctx context.Context) Check {
+	check := Check{Name: "ports"}
+	if d.echoURL == "off" {
+		check.Status = StatusSkipped
+		check.Detail = "disabled with IOP_ECHO_URL=off"
+		return check
+	}
+
+	var ip string
+	var closed []string
+	for _, port := range []int{80, 443} {
+		result, err := d.echo(ctx, port)
+		if err != nil {
+			check.Status = StatusWarn
+			check.Detail = fmt.Sprintf("couldn't check from outside: %v", err)
+			return check
+		}
+		ip = result.IP
+		if !result.Reachable {
+			closed = append(closed, fmt.Sprint(port))
+		}
+	}
+
+	if len(closed) > 0 {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("port %s not reachable from outside at %s, check the firewall and that the proxy publishes it", strings.Join(closed, " and "), ip)
+		return check
+	}
+	check.Status = StatusOK
+	check.Detail = fmt.Sprintf("ports 80 and 443 reachable from outside at %s", ip)
+	return check
+}
+
+func (d *Diagnostics) echo(ctx context.Context, port int) (*echoResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/port/%d", d.echoURL, port), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %d", d.echoURL, resp.StatusCode)
+	}
+
+	var result echoResult
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid answer from %s: %w", d.echoURL, err)
+	}
+	return &result, nil
+}
+
+// checkDocker pings the Docker Engine over its socket. Without it the proxy
+// can't attach networks, supervise containers or scale to zero.
+func (d *Diagnostics) checkDocker(ctx context.Context) Check {
+	check := Check{Name: "docker"}
+	if d.docker == nil {
+		check.Status = StatusSkipped
+		check.Detail = "no Docker client configured"
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	if err := d.docker.Ping(ctx); err != nil {
+		check.Status = StatusFail
+		check.Detail = fmt.Sprintf("Docker socket not accessible, mount /var/run/docker.sock into the proxy container: %v", err)
+		return check
+	}
+	check.Status = StatusOK
+	check.Detail = "Docker socket accessible"
+	return check
+}
+
+// checkACME fetches the ACME directory and compares the clock with the
+// directory's Date header, as a skewed clock makes fresh certificates look
+// not yet valid and renewals run at the wrong time
+func (d *Diagnostics) checkACME(ctx context.Context) (Check, Check) {
+	acme := Check{Name: "acme"}
+	clock := Check{Name: "clock"}
+
+	directoryURL := d.state.GetACMEConfig().DirectoryURL
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	started := d.now()
+	resp, err := d.fetch(ctx, directoryURL)
+	if err != nil {
+		acme.Status = StatusFail
+		acme.Detail = fmt.Sprintf("couldn't reach %s: %v", directoryURL, err)
+		clock.Status = StatusSkipped
+		clock.Detail = "no time to compare with, the ACME directory is unreachable"
+		return acme, clock
+	}
+	defer resp.Body.Close()
+	elapsed := d.now().Sub(started)
+
+	var directory struct {
+		NewNonce string `json:"newNonce"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		acme.Status = StatusFail
+		acme.Detail = fmt.Sprintf("%s answered %d", directoryURL, resp.StatusCode)
+	} else if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&directory); err != nil || directory.NewNonce == "" {
+		acme.Status = StatusFail
+		acme.Detail = fmt.Sprintf("%s isn't an ACME directory", directoryURL)
+	} else {
+		acme.Status = StatusOK
+		acme.Detail = fmt.Sprintf("reached %s in %s", directoryURL, elapsed.Round(time.Millisecond))
+	}
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		clock.Status = StatusSkipped
+		clock.Detail = "the ACME directory sent no Date header"
+		return acme, clock
+	}
+	clock.Status, clock.Detail = clockSkew(started.Add(elapsed/2), date)
+	return acme, clock
+}
+
+func (d *Diagnostics) fetch(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.Do(req)
+}
+
+// clockSkew compares local time with a server's. The Date header has whole
+// seconds, so differences under a second aren't reported.
+func clockSkew(local, remote time.Time) (Status, string) {
+	skew := local.Sub(remote)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew < warnClockSkew {
+		return StatusOK, fmt.Sprintf("within %s of the ACME server", warnClockSkew)
+	}
+
+	direction := "ahead of"
+	if local.Before(remote) {
+		direction = "behind"
+	}
+	status := StatusWarn
+	if skew >= failClockSkew {
+		status = StatusFail
+	}
+	return status, fmt.Sprintf("%s %s the ACME server, enable NTP on the host", skew.Round(time.Second), direction)
+}
+
+// checkDisk checks each directory is writable and has space left
+func (d *Diagnostics) checkDisk() Check {
+	check := Check{Name: "disk", Status: StatusOK}
+	var details []string
+	for _, dir := range d.dirs {
+		status, detail := checkDir(dir)
+		details = append(details, detail)
+		if status == StatusFail || (status == StatusWarn && check.Status != StatusFail) {
+			check.Status = status
+		}
+	}
+	if len(details) == 0 {
+		check.Status = StatusSkipped
+		details = append(details, "no directories configured")
+	}
+	check.Detail = strings.Join(details, "; ")
+	return check
+}
+
+func checkDir(dir string) (Status, string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return StatusFail, fmt.Sprintf("%s: %v", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".diagnostics-*")
+	if err != nil {
+		return StatusFail, fmt.Sprintf("%s not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return StatusWarn, fmt.Sprintf("%s writable, free space unknown: %v", dir, err)
+	}
+	free := uint64(fs.Bavail) * uint64(fs.Bsize)
+	detail := fmt.Sprintf("%s has %s free", filepath.Clean(dir), formatBytes(free))
+	switch {
+	case free < minFreeSpace:
+		return StatusFail, detail
+	case free < warnFreeSpace:
+		return StatusWarn, detail
+	}
+	return StatusOK, detail
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
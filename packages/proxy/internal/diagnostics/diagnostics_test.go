@@ -0,0 +1,125 @@
+package diagnostics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDocker struct{ err error }
+
+func (f *fakeDocker) Ping(context.Context) error { return f.err }
+
+// newDiagnostics returns diagnostics against a fake echo service reporting
+// the given ports open and a fake ACME directory whose clock is off by skew
+func newDiagnostics(t *testing.T, open map[int]bool, skew time.Duration) *Diagnostics {
+	t.Helper()
+
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var port int
+		fmt.Sscanf(r.URL.Path, "/port/%d", &port)
+		fmt.Fprintf(w, `{"ip":"203.0.113.10","port":%d,"reachable":%t}`, port, open[port])
+	}))
+	t.Cleanup(echo.Close)
+
+	acme := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		fmt.Fprint(w, `{"newNonce":"https://acme.example.com/new-nonce","newOrder":"https://acme.example.com/new-order"}`)
+	}))
+	t.Cleanup(acme.Close)
+
+	dir := t.TempDir()
+	st := state.NewState(filepath.Join(dir, "state.json"))
+	st.SetACMEConfig(acme.URL+"/directory", "", "", "")
+
+	d := New(st, &fakeDocker{}, dir)
+	d.echoURL = echo.URL
+	return d
+}
+
+func TestRunReportsAllChecks(t *testing.T) {
+	d := newDiagnostics(t, map[int]bool{80: true, 443: true}, 0)
+
+	report := d.Run(context.Background())
+	require.Len(t, report.Checks, 5)
+	for i, name := range []string{"ports", "docker", "acme", "disk", "clock"} {
+		assert.Equal(t, name, report.Checks[i].Name)
+		assert.Equal(t, StatusOK, report.Checks[i].Status, report.Checks[i].Detail)
+	}
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, "ports 80 and 443 reachable from outside at 203.0.113.10", report.Checks[0].Detail)
+}
+
+func TestRunFailsOnClosedPortAndDocker(t *testing.T) {
+	d := newDiagnostics(t, map[int]bool{443: true}, 0)
+	d.docker = &fakeDocker{err: errors.New("dial unix /var/run/docker.sock: connect: no such file or directory")}
+
+	report := d.Run(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusFail, report.Checks[0].Status)
+	assert.Contains(t, report.Checks[0].Detail, "port 80 not reachable from outside at 203.0.113.10")
+	assert.Equal(t, StatusFail, report.Checks[1].Status)
+	assert.Contains(t, report.Checks[1].Detail, "no such file or directory")
+}
+
+func TestRunWarnsWhenEchoServiceUnreachable(t *testing.T) {
+	d := newDiagnostics(t, nil, 0)
+	d.echoURL = "http://127.0.0.1:1"
+
+	report := d.Run(context.Background())
+	assert.Equal(t, StatusWarn, report.Checks[0].Status)
+	assert.Contains(t, report.Checks[0].Detail, "couldn't check from outside")
+	assert.Equal(t, StatusWarn, report.Status)
+
+	d.echoURL = "off"
+	assert.Equal(t, StatusSkipped, d.checkPorts(context.Background()).Status)
+}
+
+func TestCheckACMEDetectsClockSkew(t *testing.T) {
+	d := newDiagnostics(t, nil, -10*time.Minute)
+
+	acme, clock := d.checkACME(context.Background())
+	assert.Equal(t, StatusOK, acme.Status)
+	assert.Equal(t, StatusFail, clock.Status)
+	assert.Contains(t, clock.Detail, "ahead of the ACME server")
+}
+
+func TestCheckACMEUnreachable(t *testing.T) {
+	d := newDiagnostics(t, nil, 0)
+	d.state.SetACMEConfig("http://127.0.0.1:1/directory", "", "", "")
+
+	acme, clock := d.checkACME(context.Background())
+	assert.Equal(t, StatusFail, acme.Status)
+	assert.Contains(t, acme.Detail, "couldn't reach http://127.0.0.1:1/directory")
+	assert.Equal(t, StatusSkipped, clock.Status)
+}
+
+func TestClockSkew(t *testing.T) {
+	remote := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	status, _ := clockSkew(remote.Add(2*time.Second), remote)
+	assert.Equal(t, StatusOK, status)
+
+	status, detail := clockSkew(remote.Add(-45*time.Second), remote)
+	assert.Equal(t, StatusWarn, status)
+	assert.Equal(t, "45s behind the ACME server, enable NTP on the host", detail)
+
+	status, _ = clockSkew(remote.Add(time.Hour), remote)
+	assert.Equal(t, StatusFail, status)
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "100.0 MiB", formatBytes(100<<20))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
@@ -0,0 +1,207 @@
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fleet is the state of the proxy on the server running shop-api, with
+// postgres on another server
+func fleet(t *testing.T) *state.State {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetServiceRecords("shop", []state.ServiceRecord{
+		{Name: "api", Container: "shop-api"},
+		{Name: "postgres", Address: "10.210.0.2"},
+	})
+	st.SetServiceRecords("blog", []state.ServiceRecord{{Name: "api", Address: "10.210.0.3"}})
+	return st
+}
+
+func resolver(st *state.State) *Resolver {
+	r := NewResolver(st)
+	r.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		if host == "shop-api" {
+			return []string{"172.18.0.5", "fd00::5", "172.18.0.6"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return r
+}
+
+func TestValidateRecord(t *testing.T) {
+	assert.NoError(t, ValidateRecord(state.ServiceRecord{Name: "postgres", Address: "10.210.0.2"}))
+	assert.NoError(t, ValidateRecord(state.ServiceRecord{Name: "api-v2", Container: "shop-api-v2"}))
+	assert.Error(t, ValidateRecord(state.ServiceRecord{Name: "Postgres", Address: "10.210.0.2"}))
+	assert.Error(t, ValidateRecord(state.ServiceRecord{Name: "db.primary", Address: "10.210.0.2"}))
+	assert.Error(t, ValidateRecord(state.ServiceRecord{Name: "db"}))
+	assert.Error(t, ValidateRecord(state.ServiceRecord{Name: "db", Address: "10.210.0.2", Container: "shop-db"}))
+	assert.Error(t, ValidateRecord(state.ServiceRecord{Name: "db", Address: "fd00::2"}))
+}
+
+func TestResolve(t *testing.T) {
+	r := resolver(fleet(t))
+	ctx := context.Background()
+
+	ips, found, err := r.Resolve(ctx, "postgres.internal.")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []net.IP{net.ParseIP("10.210.0.2").To4()}, ips)
+
+	// Containers on this server resolve to their current addresses
+	ips, found, err = r.Resolve(ctx, "API.shop.internal")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []net.IP{net.ParseIP("172.18.0.5").To4(), net.ParseIP("172.18.0.6").To4()}, ips)
+
+	ips, found, _ = r.Resolve(ctx, "api.blog.internal")
+	assert.True(t, found)
+	assert.Equal(t, []net.IP{net.ParseIP("10.210.0.3").To4()}, ips)
+
+	// Two projects have an api, the bare name is ambiguous
+	_, found, _ = r.Resolve(ctx, "api.internal")
+	assert.False(t, found)
+
+	for _, name := range []string{"redis.internal", "postgres.blog.internal", "postgres.example.com", "a.b.c.internal"} {
+		_, found, _ = r.Resolve(ctx, name)
+		assert.False(t, found, name)
+	}
+}
+
+// query builds a query for name with the given type
+func query(id uint16, name string, qtype uint16) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0) // Recursion desired, one question
+	for _, label := range splitLabels(name) {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, classIN)
+}
+
+func splitLabels(name string) []string {
+	var labels []string
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i == len(name) || name[i] == '.' {
+			labels = append(labels, name[start:i])
+			start = i + 1
+		}
+	}
+	return labels
+}
+
+// answers returns the rcode and A records of a response to a query built by query
+func answers(t *testing.T, response []byte, q []byte) (int, []string) {
+	require.GreaterOrEqual(t, len(response), len(q))
+	assert.Equal(t, q[:2], response[:2], "id")
+	assert.NotZero(t, response[2]&0x80, "response bit")
+	var ips []string
+	offset := len(q)
+	for i := 0; i < int(binary.BigEndian.Uint16(response[6:])); i++ {
+		offset += 10
+		length := int(binary.BigEndian.Uint16(response[offset:]))
+		ips = append(ips, net.IP(response[offset+2:offset+2+length]).String())
+		offset += 2 + length
+	}
+	return int(response[3] & 0x0f), ips
+}
+
+func TestServerAnswersServiceNames(t *testing.T) {
+	s := &Server{resolver: resolver(fleet(t))}
+
+	q := query(7, "api.shop.internal", typeA)
+	rcode, ips := answers(t, s.handle(q, false), q)
+	assert.Equal(t, 0, rcode)
+	assert.Equal(t, []string{"172.18.0.5", "172.18.0.6"}, ips)
+
+	q = query(8, "redis.internal", typeA)
+	rcode, _ = answers(t, s.handle(q, false), q)
+	assert.Equal(t, rcodeNXDomain, rcode)
+
+	// Known names have no IPv6 addresses, which isn't an error
+	q = query(9, "postgres.internal", 28)
+	rcode, ips = answers(t, s.handle(q, false), q)
+	assert.Equal(t, 0, rcode)
+	assert.Empty(t, ips)
+
+	assert.Nil(t, s.handle([]byte{1, 2, 3}, false))
+}
+
+func TestServerForwardsOtherNames(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := upstream.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		q, _ := parseQuestion(buf[:n])
+		upstream.WriteTo(reply(buf[:n], q, 0, []net.IP{net.ParseIP("93.184.216.34")}, false), addr)
+	}()
+
+	s := NewServer(fleet(t), upstream.LocalAddr().String())
+	require.NoError(t, s.Start("127.0.0.1:0"))
+	defer s.Close()
+
+	conn, err := net.Dial("udp", s.udp.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	q := query(42, "example.com", typeA)
+	_, err = conn.Write(q)
+	require.NoError(t, err)
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	rcode, ips := answers(t, buf[:n], q)
+	assert.Equal(t, 0, rcode)
+	assert.Equal(t, []string{"93.184.216.34"}, ips)
+}
+
+func TestServerOverTCP(t *testing.T) {
+	s := NewServer(fleet(t), "127.0.0.1:1")
+	require.NoError(t, s.Start("127.0.0.1:0"))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.tcp.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	q := query(3, "postgres.shop.internal", typeA)
+	require.NoError(t, writeTCPMessage(conn, q))
+	response, err := readTCPMessage(conn)
+	require.NoError(t, err)
+	rcode, ips := answers(t, response, q)
+	assert.Equal(t, 0, rcode)
+	assert.Equal(t, []string{"10.210.0.2"}, ips)
+}
+
+func TestReplyTruncatesOverUDP(t *testing.T) {
+	q := query(1, "api.internal", typeA)
+	parsed, err := parseQuestion(q)
+	require.NoError(t, err)
+	ips := make([]net.IP, 40)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, 0, byte(i))
+	}
+
+	response := reply(q, parsed, 0, ips, false)
+	assert.LessOrEqual(t, len(response), maxUDPSize)
+	assert.NotZero(t, response[2]&0x02, "truncated bit")
+
+	response = reply(q, parsed, 0, ips, true)
+	assert.Equal(t, uint16(40), binary.BigEndian.Uint16(response[6:]))
+}
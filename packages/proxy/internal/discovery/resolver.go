@@ -0,0 +1,92 @@
+// Package discovery answers DNS queries for <name>.internal with the
+// addresses of a fleet's services, so apps reach a service by name whichever
+// server it runs on
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// Domain is the zone service names are resolved in
+const Domain = "internal"
+
+var labelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateRecord checks that a record has a name usable as a DNS label and
+// either an address or a container
+func ValidateRecord(record state.ServiceRecord) error {
+	if !labelPattern.MatchString(record.Name) {
+		return fmt.Errorf("invalid service name %q, expected lowercase letters, digits and dashes", record.Name)
+	}
+	if (record.Address == "") == (record.Container == "") {
+		return fmt.Errorf("service %s needs either an address or a container", record.Name)
+	}
+	if record.Address != "" {
+		if ip := net.ParseIP(record.Address); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid address %q of service %s, expected an IPv4 address", record.Address, record.Name)
+		}
+	}
+	return nil
+}
+
+// Resolver looks up service names in the records kept in state
+type Resolver struct {
+	state *state.State
+	// lookupHost resolves container names through Docker's DNS server
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// NewResolver creates a resolver for the service records in state
+func NewResolver(st *state.State) *Resolver {
+	return &Resolver{state: st, lookupHost: net.DefaultResolver.LookupHost}
+}
+
+// Resolve returns the IPv4 addresses of a name such as "postgres.internal"
+// or "postgres.shop.internal". found is false for names without records and
+// for bare names that several projects use.
+func (r *Resolver) Resolve(ctx context.Context, name string) (ips []net.IP, found bool, err error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if !strings.HasSuffix(name, "."+Domain) {
+		return nil, false, nil
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+Domain), ".")
+	if len(labels) > 2 {
+		return nil, false, nil
+	}
+
+	var matches []state.ServiceRecord
+	projects := make(map[string]bool)
+	for _, record := range r.state.GetServiceRecords() {
+		if record.Name != labels[0] || (len(labels) == 2 && record.Project != labels[1]) {
+			continue
+		}
+		matches = append(matches, record)
+		projects[record.Project] = true
+	}
+	if len(matches) == 0 || len(projects) > 1 {
+		return nil, false, nil
+	}
+
+	for _, record := range matches {
+		if record.Address != "" {
+			ips = append(ips, net.ParseIP(record.Address).To4())
+			continue
+		}
+		addrs, err := r.lookupHost(ctx, record.Container)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to resolve container %s: %w", record.Container, err)
+		}
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr).To4(); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, true, nil
+}
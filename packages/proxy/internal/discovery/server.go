@@ -0,0 +1,330 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+const (
+	// DefaultListenAddr is where the DNS server listens inside the proxy
+	// container. The CLI publishes it on the server's mesh address.
+	DefaultListenAddr = ":53"
+	// recordTTL is short as container addresses change with every deploy
+	recordTTL       = 5
+	upstreamTimeout = 5 * time.Second
+	maxUDPSize      = 512
+	maxMessageSize  = 64 * 1024
+
+	typeA   = 1
+	typeANY = 255
+	classIN = 1
+
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+)
+
+// Server answers queries for service names and forwards all others to the
+// upstream resolver, so containers can use it as their only DNS server
+type Server struct {
+	resolver *Resolver
+	upstream string // host:port
+
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+// NewServer creates a DNS server for the service records in state that
+// forwards other queries to upstream, a host:port
+func NewServer(st *state.State, upstream string) *Server {
+	return &Server{resolver: NewResolver(st), upstream: upstream}
+}
+
+// ListenAddrFromEnv returns IOP_DNS_LISTEN, the address the DNS server
+// listens on, or "" when it is set to "off"
+func ListenAddrFromEnv() string {
+	addr := os.Getenv("IOP_DNS_LISTEN")
+	switch addr {
+	case "":
+		return DefaultListenAddr
+	case "off":
+		return ""
+	}
+	return addr
+}
+
+// UpstreamFromEnv returns IOP_DNS_UPSTREAM, or else the first name server
+// in /etc/resolv.conf, which is Docker's own inside a container
+func UpstreamFromEnv() string {
+	if upstream := os.Getenv("IOP_DNS_UPSTREAM"); upstream != "" {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			return net.JoinHostPort(upstream, "53")
+		}
+		return upstream
+	}
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.11:53"
+}
+
+// Start listens for queries over UDP and TCP on addr
+func (s *Server) Start(addr string) error {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s/udp: %w", addr, err)
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("failed to listen on %s/tcp: %w", addr, err)
+	}
+	s.udp, s.tcp = udp, tcp
+	log.Printf("[DNS] Resolving *.%s on %s, forwarding other names to %s", Domain, addr, s.upstream)
+
+	go s.serveUDP()
+	go s.serveTCP()
+	return nil
+}
+
+// Close stops listening
+func (s *Server) Close() {
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+}
+
+func (s *Server) serveUDP() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[DNS] UDP read failed: %v", err)
+			}
+			return
+		}
+		query := append([]byte{}, buf[:n]...)
+		go func() {
+			if response := s.handle(query, false); response != nil {
+				s.udp.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[DNS] TCP accept failed: %v", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(upstreamTimeout * 2))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				response := s.handle(query, true)
+				if response == nil || writeTCPMessage(conn, response) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle answers a query for a service name itself and forwards any other.
+// It returns nil for messages that aren't queries.
+func (s *Server) handle(query []byte, overTCP bool) []byte {
+	q, err := parseQuestion(query)
+	if err != nil {
+		return nil
+	}
+	if !strings.HasSuffix(strings.ToLower(q.name), "."+Domain) {
+		ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+		defer cancel()
+		response, err := forward(ctx, s.upstream, query, overTCP)
+		if err != nil {
+			log.Printf("[DNS] Forwarding %s failed: %v", q.name, err)
+			return reply(query, q, rcodeServFail, nil, overTCP)
+		}
+		return response
+	}
+
+	if q.opcode != 0 || q.class != classIN {
+		return reply(query, q, rcodeNotImp, nil, overTCP)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+	ips, found, err := s.resolver.Resolve(ctx, q.name)
+	switch {
+	case err != nil:
+		log.Printf("[DNS] Resolving %s failed: %v", q.name, err)
+		return reply(query, q, rcodeServFail, nil, overTCP)
+	case !found:
+		return reply(query, q, rcodeNXDomain, nil, overTCP)
+	case q.qtype != typeA && q.qtype != typeANY:
+		// The name exists, it just has no records of this type
+		return reply(query, q, 0, nil, overTCP)
+	}
+	return reply(query, q, 0, ips, overTCP)
+}
+
+// question is the single question of a query
+type question struct {
+	name   string
+	qtype  uint16
+	class  uint16
+	opcode byte
+	end    int // Offset after the question
+}
+
+func parseQuestion(msg []byte) (question, error) {
+	if len(msg) < 12 {
+		return question{}, errors.New("message too short")
+	}
+	if msg[2]&0x80 != 0 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return question{}, errors.New("not a query with one question")
+	}
+
+	var labels []string
+	offset := 12
+	for {
+		if offset >= len(msg) {
+			return question{}, errors.New("truncated name")
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		// Queries don't compress names
+		if length > 63 || offset+length > len(msg) {
+			return question{}, errors.New("invalid label")
+		}
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+	if offset+4 > len(msg) {
+		return question{}, errors.New("truncated question")
+	}
+	return question{
+		name:   strings.Join(labels, "."),
+		qtype:  binary.BigEndian.Uint16(msg[offset:]),
+		class:  binary.BigEndian.Uint16(msg[offset+2:]),
+		opcode: (msg[2] >> 3) & 0x0f,
+		end:    offset + 4,
+	}, nil
+}
+
+// reply builds an authoritative response with an A record for each address.
+// Over UDP, addresses that don't fit in 512 bytes are left out and the
+// response is marked truncated so the client retries over TCP.
+func reply(query []byte, q question, rcode byte, ips []net.IP, overTCP bool) []byte {
+	msg := append([]byte{}, query[:q.end]...)
+	msg[2] = 0x80 | q.opcode<<3 | 0x04 | query[2]&0x01 // Response, authoritative, recursion desired as asked
+	msg[3] = 0x80 | rcode                              // Recursion available
+	binary.BigEndian.PutUint16(msg[8:], 0)             // Authority records
+	binary.BigEndian.PutUint16(msg[10:], 0)            // Additional records, the query's EDNS record isn't echoed
+
+	answers := 0
+	for _, ip := range ips {
+		if !overTCP && len(msg)+16 > maxUDPSize {
+			msg[2] |= 0x02
+			break
+		}
+		msg = append(msg, 0xc0, 12) // Pointer to the question's name
+		msg = binary.BigEndian.AppendUint16(msg, typeA)
+		msg = binary.BigEndian.AppendUint16(msg, classIN)
+		msg = binary.BigEndian.AppendUint32(msg, recordTTL)
+		msg = binary.BigEndian.AppendUint16(msg, 4)
+		msg = append(msg, ip.To4()...)
+		answers++
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(answers))
+	return msg
+}
+
+// forward relays a query to the upstream resolver and returns its response
+func forward(ctx context.Context, upstream string, query []byte, overTCP bool) ([]byte, error) {
+	network := "udp"
+	if overTCP {
+		network = "tcp"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if overTCP {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Skip stray responses to earlier queries
+		if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+// readTCPMessage reads a message prefixed with its length
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
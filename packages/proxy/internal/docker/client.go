@@ -0,0 +1,712 @@
+// Package docker is a minimal client for the Docker Engine API, reached over
+// the Docker socket mounted into the proxy container
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SidecarOfLabel names the app container a sidecar runs next to. Sidecars
+	// share that container's network namespace, so they are started, stopped,
+	// cloned and removed together with it.
+	SidecarOfLabel = "iop.sidecar-of"
+	// SidecarLabel is a sidecar's name within its app, e.g. "cloudsql"
+	SidecarLabel = "iop.sidecar"
+)
+
+// Event is a container lifecycle event reported by Docker
+type Event struct {
+	ID       string
+	Name     string
+	Action   string // "die", "kill", "start", "destroy", ...
+	ExitCode int
+	Labels   map[string]string
+}
+
+// Client talks to the Docker Engine API over its unix socket
+type Client struct {
+	client *http.Client
+}
+
+// New returns a client for the Docker socket named by DOCKER_HOST, or
+// /var/run/docker.sock when it is unset
+func New() *Client {
+	socket := "/var/run/docker.sock"
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		socket = strings.TrimPrefix(host, "unix://")
+	}
+
+	return &Client{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// send makes a request with a JSON body
+func (c *Client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.client.Do(req)
+}
+
+// Events streams container events until the context is cancelled or the stream breaks
+func (c *Client) Events(ctx context.Context, handle func(Event)) error {
+	filters, _ := json.Marshal(map[string][]string{"type": {"container"}})
+	resp, err := c.do(ctx, http.MethodGet, "/events?filters="+url.QueryEscape(string(filters)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker events: %s", resp.Status)
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var raw struct {
+			Action string `json:"Action"`
+			Actor  struct {
+				ID         string            `json:"ID"`
+				Attributes map[string]string `json:"Attributes"`
+			} `json:"Actor"`
+		}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		// Attributes mix the container's labels with name, image and exitCode
+		exitCode, _ := strconv.Atoi(raw.Actor.Attributes["exitCode"])
+		handle(Event{
+			ID:       raw.Actor.ID,
+			Name:     raw.Actor.Attributes["name"],
+			Action:   raw.Action,
+			ExitCode: exitCode,
+			Labels:   raw.Actor.Attributes,
+		})
+	}
+}
+
+// Ping checks the Docker Engine answers on its socket
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/_ping")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ping: %s", resp.Status)
+	}
+	return nil
+}
+
+// Running reports whether a container is running
+func (c *Client) Running(ctx context.Context, id string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("inspect container %s: %s", id, resp.Status)
+	}
+
+	var inspect struct {
+		State struct {
+			Running bool `json:"Running"`
+		} `json:"State"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return false, err
+	}
+	return inspect.State.Running, nil
+}
+
+// Start starts a stopped container and then its stopped sidecars, which
+// can only join its network once it runs
+func (c *Client) Start(ctx context.Context, id string) error {
+	if err := c.start(ctx, id); err != nil {
+		return err
+	}
+	return c.eachSidecar(ctx, id, func(sidecar Container) error {
+		if sidecar.State == "running" {
+			return nil
+		}
+		return c.start(ctx, sidecar.ID)
+	})
+}
+
+func (c *Client) start(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodPost, "/containers/"+id+"/start")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 304 means it was already started, e.g. by its restart policy
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		return fmt.Errorf("start container %s: %s", id, resp.Status)
+	}
+	return nil
+}
+
+// Sidecars lists the sidecars of an app container, including stopped ones
+func (c *Client) Sidecars(ctx context.Context, name string) ([]Container, error) {
+	return c.AllContainers(ctx, SidecarOfLabel+"="+name)
+}
+
+// RestartSidecars restarts the running sidecars of an app container. A
+// container that was restarted gets a new network namespace, and its
+// sidecars have to be restarted to join it.
+func (c *Client) RestartSidecars(ctx context.Context, name string) error {
+	sidecars, err := c.Sidecars(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, sidecar := range sidecars {
+		if sidecar.State != "running" {
+			continue
+		}
+		resp, err := c.do(ctx, http.MethodPost, "/containers/"+sidecar.ID+"/restart?t=10")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("restart container %s: %s", sidecar.Name, resp.Status)
+		}
+	}
+	return nil
+}
+
+// eachSidecar calls fn for every sidecar of the container with the given ID
+func (c *Client) eachSidecar(ctx context.Context, id string, fn func(Container) error) error {
+	name, err := c.name(ctx, id)
+	if err != nil {
+		return err
+	}
+	sidecars, err := c.Sidecars(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, sidecar := range sidecars {
+		if err := fn(sidecar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// name returns the name of the container with the given ID
+func (c *Client) name(ctx context.Context, id string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("inspect container %s: %s", id, resp.Status)
+	}
+
+	var inspect struct {
+		Name string `json:"Name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(inspect.Name, "/"), nil
+}
+
+// Container is a container as listed by Docker
+type Container struct {
+	ID      string
+	Name    string
+	Labels  map[string]string
+	Created time.Time
+	State   string // "running", "paused", "exited", ...
+}
+
+// Containers lists the running containers carrying a label, e.g. "iop.managed=true"
+func (c *Client) Containers(ctx context.Context, label string) ([]Container, error) {
+	return c.list(ctx, label, false)
+}
+
+// AllContainers lists the containers carrying a label, including stopped ones
+func (c *Client) AllContainers(ctx context.Context, label string) ([]Container, error) {
+	return c.list(ctx, label, true)
+}
+
+func (c *Client) list(ctx context.Context, label string, all bool) ([]Container, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {label}})
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/containers/json?all=%t&filters=%s", all, url.QueryEscape(string(filters))))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list containers: %s", resp.Status)
+	}
+
+	var raw []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Labels  map[string]string `json:"Labels"`
+		Created int64             `json:"Created"`
+		State   string            `json:"State"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	containers := make([]Container, 0, len(raw))
+	for _, r := range raw {
+		name := r.ID
+		if len(r.Names) > 0 {
+			name = strings.TrimPrefix(r.Names[0], "/")
+		}
+		containers = append(containers, Container{ID: r.ID, Name: name, Labels: r.Labels, Created: time.Unix(r.Created, 0), State: r.State})
+	}
+	return containers, nil
+}
+
+// Stats is a single resource usage sample of a container, as returned by the
+// Engine API. Only the fields iop uses are decoded.
+type Stats struct {
+	CPUStats    CPUStats `json:"cpu_stats"`
+	PreCPUStats CPUStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+	BlkioStats struct {
+		IOServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+	PidsStats struct {
+		Current uint64 `json:"current"`
+	} `json:"pids_stats"`
+}
+
+// CPUStats is a container's cumulative CPU time at the moment of a sample
+type CPUStats struct {
+	CPUUsage struct {
+		TotalUsage uint64 `json:"total_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  uint64 `json:"online_cpus"`
+}
+
+// Stats samples a container's resource usage. Docker takes about a second to
+// answer, since it measures CPU usage between two readings.
+func (c *Client) Stats(ctx context.Context, id string) (*Stats, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/stats?stream=false")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("container stats %s: %s", id, resp.Status)
+	}
+
+	var stats Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Limits are the resource limits a container was created with. Zero means
+// unlimited.
+type Limits struct {
+	Memory int64   // Bytes
+	CPUs   float64 // Cores
+}
+
+// Limits returns a container's memory and CPU limits
+func (c *Client) Limits(ctx context.Context, id string) (Limits, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json")
+	if err != nil {
+		return Limits{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Limits{}, fmt.Errorf("inspect container %s: %s", id, resp.Status)
+	}
+
+	var inspect struct {
+		HostConfig struct {
+			Memory    int64 `json:"Memory"`
+			NanoCpus  int64 `json:"NanoCpus"`
+			CPUQuota  int64 `json:"CpuQuota"`
+			CPUPeriod int64 `json:"CpuPeriod"`
+		} `json:"HostConfig"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return Limits{}, err
+	}
+
+	limits := Limits{Memory: inspect.HostConfig.Memory}
+	switch host := inspect.HostConfig; {
+	case host.NanoCpus > 0:
+		limits.CPUs = float64(host.NanoCpus) / 1e9
+	case host.CPUQuota > 0 && host.CPUPeriod > 0:
+		limits.CPUs = float64(host.CPUQuota) / float64(host.CPUPeriod)
+	}
+	return limits, nil
+}
+
+// Clone creates and starts a copy of a container under a new name, with the
+// same image, configuration, network aliases and labels plus the given extra
+// labels. Its sidecars are cloned too, named after the copy. It returns the
+// new container's ID.
+func (c *Client) Clone(ctx context.Context, id, name string, labels map[string]string) (string, error) {
+	cloneID, err := c.clone(ctx, id, name, labels, "")
+	if err != nil {
+		return "", err
+	}
+
+	err = c.eachSidecar(ctx, id, func(sidecar Container) error {
+		sidecarLabels := map[string]string{SidecarOfLabel: name}
+		for key, value := range labels {
+			sidecarLabels[key] = value
+		}
+		_, err := c.clone(ctx, sidecar.ID, name+"-"+sidecar.Labels[SidecarLabel], sidecarLabels, "container:"+name)
+		return err
+	})
+	if err != nil {
+		c.Remove(ctx, cloneID, 0)
+		return "", err
+	}
+	return cloneID, nil
+}
+
+// clone copies a container. A networkMode such as "container:web" replaces
+// the original's network settings.
+func (c *Client) clone(ctx context.Context, id, name string, labels map[string]string, networkMode string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("inspect container %s: %s", id, resp.Status)
+	}
+
+	// Config and HostConfig are passed through untouched so nothing the
+	// original was created with gets lost
+	var inspect struct {
+		Config          map[string]interface{} `json:"Config"`
+		HostConfig      map[string]interface{} `json:"HostConfig"`
+		NetworkSettings struct {
+			Networks map[string]struct {
+				Aliases []string `json:"Aliases"`
+			} `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return "", err
+	}
+
+	body := inspect.Config
+	if body == nil {
+		return "", fmt.Errorf("inspect container %s: no config", id)
+	}
+	// The hostname defaults to the container's short ID, the clone gets its own
+	delete(body, "Hostname")
+
+	merged := make(map[string]string)
+	if existing, ok := body["Labels"].(map[string]interface{}); ok {
+		for key, value := range existing {
+			if s, ok := value.(string); ok {
+				merged[key] = s
+			}
+		}
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	body["Labels"] = merged
+	body["HostConfig"] = inspect.HostConfig
+
+	if networkMode != "" {
+		if inspect.HostConfig != nil {
+			inspect.HostConfig["NetworkMode"] = networkMode
+		}
+	} else {
+		// Docker adds the short container ID as an alias, which belongs to the original
+		endpoints := make(map[string]interface{})
+		for network, settings := range inspect.NetworkSettings.Networks {
+			var aliases []string
+			for _, alias := range settings.Aliases {
+				if !strings.HasPrefix(id, alias) {
+					aliases = append(aliases, alias)
+				}
+			}
+			endpoints[network] = map[string]interface{}{"Aliases": aliases}
+		}
+		body["NetworkingConfig"] = map[string]interface{}{"EndpointsConfig": endpoints}
+	}
+
+	created, err := c.send(ctx, http.MethodPost, "/containers/create?name="+url.QueryEscape(name), body)
+	if err != nil {
+		return "", err
+	}
+	defer created.Body.Close()
+
+	if created.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(created.Body)
+		return "", fmt.Errorf("create container %s: %s: %s", name, created.Status, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(created.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	if err := c.start(ctx, result.ID); err != nil {
+		c.remove(ctx, result.ID, 0)
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// Stop stops a container, giving it the timeout to exit before it is killed,
+// and then its sidecars, so that e.g. log shippers see its last output
+func (c *Client) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	if err := c.stop(ctx, id, timeout); err != nil {
+		return err
+	}
+	return c.eachSidecar(ctx, id, func(sidecar Container) error {
+		return c.stop(ctx, sidecar.ID, timeout)
+	})
+}
+
+func (c *Client) stop(ctx context.Context, id string, timeout time.Duration) error {
+	resp, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%s/stop?t=%d", id, int(timeout.Seconds())))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// 304 means it had already stopped
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		return fmt.Errorf("stop container %s: %s", id, resp.Status)
+	}
+	return nil
+}
+
+// Pause freezes the processes of a container and its sidecars, keeping their memory
+func (c *Client) Pause(ctx context.Context, id string) error {
+	if err := c.pause(ctx, id); err != nil {
+		return err
+	}
+	return c.eachSidecar(ctx, id, func(sidecar Container) error {
+		if sidecar.State != "running" {
+			return nil
+		}
+		return c.pause(ctx, sidecar.ID)
+	})
+}
+
+func (c *Client) pause(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodPost, "/containers/"+id+"/pause")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("pause container %s: %s", id, resp.Status)
+	}
+	return nil
+}
+
+// Unpause resumes the processes of a paused container and its sidecars
+func (c *Client) Unpause(ctx context.Context, id string) error {
+	if err := c.unpause(ctx, id); err != nil {
+		return err
+	}
+	return c.eachSidecar(ctx, id, func(sidecar Container) error {
+		if sidecar.State != "paused" {
+			return nil
+		}
+		return c.unpause(ctx, sidecar.ID)
+	})
+}
+
+func (c *Client) unpause(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodPost, "/containers/"+id+"/unpause")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unpause container %s: %s", id, resp.Status)
+	}
+	return nil
+}
+
+// Remove stops a container, giving it the timeout to exit before it is
+// killed, and deletes it along with its sidecars
+func (c *Client) Remove(ctx context.Context, id string, timeout time.Duration) error {
+	if err := c.Stop(ctx, id, timeout); err != nil {
+		return err
+	}
+	if err := c.eachSidecar(ctx, id, func(sidecar Container) error {
+		return c.remove(ctx, sidecar.ID, timeout)
+	}); err != nil {
+		return err
+	}
+	return c.remove(ctx, id, timeout)
+}
+
+func (c *Client) remove(ctx context.Context, id string, timeout time.Duration) error {
+	if err := c.stop(ctx, id, timeout); err != nil {
+		return err
+	}
+
+	resp, err := c.do(ctx, http.MethodDelete, "/containers/"+id+"?force=true")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("remove container %s: %s", id, resp.Status)
+	}
+	return nil
+}
+
+// Networks lists the networks a container is attached to
+func (c *Client) Networks(ctx context.Context, id string) ([]string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("inspect container %s: %s", id, resp.Status)
+	}
+
+	var inspect struct {
+		NetworkSettings struct {
+			Networks map[string]struct{} `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return nil, err
+	}
+
+	networks := make([]string, 0, len(inspect.NetworkSettings.Networks))
+	for network := range inspect.NetworkSettings.Networks {
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// NetworkExists reports whether a network exists
+func (c *Client) NetworkExists(ctx context.Context, name string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/networks/"+url.PathEscape(name))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("inspect network %s: %s", name, resp.Status)
+	}
+}
+
+// ConnectNetwork attaches a container to a network
+func (c *Client) ConnectNetwork(ctx context.Context, network, id string) error {
+	return c.network(ctx, "connect", network, id, nil)
+}
+
+// ConnectNetworkWithAliases attaches a container to a network under extra
+// DNS names, e.g. the app alias traffic is routed to
+func (c *Client) ConnectNetworkWithAliases(ctx context.Context, network, id string, aliases []string) error {
+	return c.network(ctx, "connect", network, id, aliases)
+}
+
+// DisconnectNetwork detaches a container from a network
+func (c *Client) DisconnectNetwork(ctx context.Context, network, id string) error {
+	return c.network(ctx, "disconnect", network, id, nil)
+}
+
+func (c *Client) network(ctx context.Context, action, network, id string, aliases []string) error {
+	body := map[string]interface{}{"Container": id}
+	if len(aliases) > 0 {
+		body["EndpointConfig"] = map[string]interface{}{"Aliases": aliases}
+	}
+	resp, err := c.send(ctx, http.MethodPost, "/networks/"+url.PathEscape(network)+"/"+action, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("network %s %s %s: %s: %s", action, network, id, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
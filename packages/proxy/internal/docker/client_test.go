@@ -0,0 +1,146 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeContainer struct {
+	id, name, state, networkMode string
+	labels                       map[string]string
+}
+
+// fakeEngine implements the few Engine API endpoints the client calls
+type fakeEngine struct {
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	nextID     int
+}
+
+func (f *fakeEngine) add(name, state, networkMode string, labels map[string]string) {
+	f.nextID++
+	id := fmt.Sprintf("id%d", f.nextID)
+	f.containers[id] = &fakeContainer{id: id, name: name, state: state, networkMode: networkMode, labels: labels}
+}
+
+func (f *fakeEngine) byName(name string) *fakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/containers/json":
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		key, value, _ := strings.Cut(filters["label"][0], "=")
+		var list []map[string]interface{}
+		for _, c := range f.containers {
+			if c.labels[key] == value {
+				list = append(list, map[string]interface{}{"Id": c.id, "Names": []string{"/" + c.name}, "Labels": c.labels, "State": c.state})
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.URL.Path == "/containers/create":
+		var body struct {
+			Labels     map[string]string
+			HostConfig map[string]interface{}
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mode, _ := body.HostConfig["NetworkMode"].(string)
+		f.add(r.URL.Query().Get("name"), "created", mode, body.Labels)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"Id": fmt.Sprintf("id%d", f.nextID)})
+	default:
+		c := f.containers[parts[1]]
+		if c == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Name":       "/" + c.name,
+				"Config":     map[string]interface{}{"Image": "app", "Labels": c.labels},
+				"HostConfig": map[string]interface{}{"NetworkMode": c.networkMode},
+			})
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.containers, c.id)
+		} else {
+			c.state = map[string]string{"start": "running", "restart": "running", "stop": "exited", "pause": "paused", "unpause": "running"}[parts[2]]
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newFakeEngine(t *testing.T) (*fakeEngine, *Client) {
+	engine := &fakeEngine{containers: make(map[string]*fakeContainer)}
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{Handler: engine}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	t.Setenv("DOCKER_HOST", "unix://"+socket)
+	return engine, New()
+}
+
+func TestSidecarsShareLifecycle(t *testing.T) {
+	engine, client := newFakeEngine(t)
+	ctx := context.Background()
+
+	engine.add("blog-web-blue", "running", "blog-network", map[string]string{"iop.type": "service"})
+	engine.add("blog-web-blue-logs", "running", "container:blog-web-blue",
+		map[string]string{"iop.type": "sidecar", SidecarOfLabel: "blog-web-blue", SidecarLabel: "logs"})
+	app := engine.byName("blog-web-blue")
+	sidecar := engine.byName("blog-web-blue-logs")
+
+	require.NoError(t, client.Pause(ctx, app.id))
+	assert.Equal(t, "paused", sidecar.state)
+	require.NoError(t, client.Unpause(ctx, app.id))
+	assert.Equal(t, "running", sidecar.state)
+
+	require.NoError(t, client.Stop(ctx, app.id, time.Second))
+	assert.Equal(t, "exited", sidecar.state)
+	require.NoError(t, client.Start(ctx, app.id))
+	assert.Equal(t, "running", app.state)
+	assert.Equal(t, "running", sidecar.state)
+
+	// Clones get their own sidecars in their network namespace
+	cloneID, err := client.Clone(ctx, app.id, "blog-web-blue-scale-1", map[string]string{"iop.autoscaled": "true"})
+	require.NoError(t, err)
+	cloned := engine.byName("blog-web-blue-scale-1-logs")
+	require.NotNil(t, cloned)
+	assert.Equal(t, "container:blog-web-blue-scale-1", cloned.networkMode)
+	assert.Equal(t, "blog-web-blue-scale-1", cloned.labels[SidecarOfLabel])
+	assert.Equal(t, "true", cloned.labels["iop.autoscaled"])
+	assert.Equal(t, "running", cloned.state)
+
+	require.NoError(t, client.Remove(ctx, cloneID, time.Second))
+	assert.Nil(t, engine.byName("blog-web-blue-scale-1"))
+	assert.Nil(t, engine.byName("blog-web-blue-scale-1-logs"))
+	assert.NotNil(t, engine.byName("blog-web-blue-logs"))
+}
@@ -0,0 +1,176 @@
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// TXTPrefix is prepended to a domain to get the name of its verification TXT record
+const TXTPrefix = "_iop-verify."
+
+// How often pending domains are checked in the background
+const checkInterval = 5 * time.Minute
+
+// CertificateAcquirer starts certificate acquisition for a host
+type CertificateAcquirer interface {
+	AcquireCertificate(hostname string) error
+}
+
+// Instructions tell the customer which DNS record proves they own a domain.
+// Either record is enough.
+type Instructions struct {
+	TXTName     string `json:"txt_name"`
+	TXTValue    string `json:"txt_value"`
+	CNAMETarget string `json:"cname_target"`
+}
+
+// InstructionsFor returns the DNS records that verify a domain
+func InstructionsFor(domain *state.CustomDomain) Instructions {
+	return Instructions{
+		TXTName:     TXTPrefix + domain.Domain,
+		TXTValue:    domain.Token,
+		CNAMETarget: domain.Host,
+	}
+}
+
+// Manager registers customer domains, verifies that the customer controls
+// them and serves verified ones with the backend of the host they map to
+type Manager struct {
+	state *state.State
+	certs CertificateAcquirer
+
+	lookupTXT   func(ctx context.Context, name string) ([]string, error)
+	lookupCNAME func(ctx context.Context, name string) (string, error)
+}
+
+// NewManager creates a custom domain manager
+func NewManager(st *state.State, certs CertificateAcquirer) *Manager {
+	return &Manager{
+		state:       st,
+		certs:       certs,
+		lookupTXT:   net.DefaultResolver.LookupTXT,
+		lookupCNAME: net.DefaultResolver.LookupCNAME,
+	}
+}
+
+// Add registers domain for host and returns it with a fresh verification token
+func (m *Manager) Add(domain, host string) (*state.CustomDomain, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" || strings.Contains(domain, "*") || !strings.Contains(domain, ".") {
+		return nil, fmt.Errorf("invalid domain %q", domain)
+	}
+	if state.IsHostPattern(host) {
+		return nil, fmt.Errorf("host %s is a pattern, custom domains need a deployed host", host)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	registered := &state.CustomDomain{
+		Domain:    domain,
+		Host:      host,
+		Token:     hex.EncodeToString(token),
+		Status:    state.DomainPending,
+		CreatedAt: time.Now(),
+	}
+	if err := m.state.AddCustomDomain(registered); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[DOMAINS] [%s] Registered for %s, waiting for verification", domain, host)
+	return registered, nil
+}
+
+// Verify checks a pending domain's DNS. Once it is verified the domain is
+// deployed and its certificate requested in the background.
+func (m *Manager) Verify(ctx context.Context, domain string) (*state.CustomDomain, error) {
+	registered, err := m.state.GetCustomDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	if registered.Status == state.DomainVerified {
+		return registered, nil
+	}
+
+	if checkErr := m.check(ctx, registered); checkErr != nil {
+		if err := m.state.RecordDomainCheck(domain, checkErr); err != nil {
+			return nil, err
+		}
+		return m.state.GetCustomDomain(domain)
+	}
+
+	if err := m.state.VerifyCustomDomain(domain); err != nil {
+		return nil, err
+	}
+	log.Printf("[DOMAINS] [%s] Ownership verified, serving it as %s", domain, registered.Host)
+
+	if m.certs != nil {
+		go func() {
+			if err := m.certs.AcquireCertificate(domain); err != nil {
+				log.Printf("[DOMAINS] [%s] Certificate acquisition failed, the background worker will retry: %v", domain, err)
+			}
+		}()
+	}
+
+	return m.state.GetCustomDomain(domain)
+}
+
+// check looks for the verification TXT record or a CNAME to the mapped host
+func (m *Manager) check(ctx context.Context, domain *state.CustomDomain) error {
+	records, txtErr := m.lookupTXT(ctx, TXTPrefix+domain.Domain)
+	for _, record := range records {
+		if strings.TrimSpace(record) == domain.Token {
+			return nil
+		}
+	}
+
+	cname, cnameErr := m.lookupCNAME(ctx, domain.Domain)
+	if cnameErr == nil && strings.EqualFold(strings.TrimSuffix(cname, "."), domain.Host) {
+		return nil
+	}
+
+	if txtErr != nil && cnameErr != nil {
+		return fmt.Errorf("no TXT record at %s%s and no CNAME to %s", TXTPrefix, domain.Domain, domain.Host)
+	}
+	return fmt.Errorf("TXT record at %s%s doesn't contain the token and %s isn't a CNAME to %s", TXTPrefix, domain.Domain, domain.Domain, domain.Host)
+}
+
+// Run re-checks pending domains until ctx is cancelled, so customers don't
+// have to ask again once their DNS change propagates
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.verifyPending(ctx)
+		}
+	}
+}
+
+// verifyPending checks every pending domain once
+func (m *Manager) verifyPending(ctx context.Context) {
+	for _, domain := range m.state.GetCustomDomains() {
+		if domain.Status != state.DomainPending {
+			continue
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if _, err := m.Verify(checkCtx, domain.Domain); err != nil {
+			log.Printf("[DOMAINS] [%s] Verification failed: %v", domain.Domain, err)
+		}
+		cancel()
+	}
+}
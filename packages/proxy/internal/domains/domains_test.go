@@ -0,0 +1,126 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCerts struct {
+	mu        sync.Mutex
+	requested []string
+}
+
+func (f *fakeCerts) AcquireCertificate(hostname string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requested = append(f.requested, hostname)
+	return nil
+}
+
+func newTestManager(t *testing.T) (*Manager, *state.State, map[string][]string, map[string]string) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("app.example.com", "app-blue:3000", "saas", "web", "/up", true))
+
+	txt := map[string][]string{}
+	cnames := map[string]string{}
+	m := NewManager(st, &fakeCerts{})
+	m.lookupTXT = func(_ context.Context, name string) ([]string, error) {
+		if records, ok := txt[name]; ok {
+			return records, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	m.lookupCNAME = func(_ context.Context, name string) (string, error) {
+		if cname, ok := cnames[name]; ok {
+			return cname, nil
+		}
+		return "", errors.New("no such host")
+	}
+	return m, st, txt, cnames
+}
+
+func TestVerifyWithTXTRecord(t *testing.T) {
+	m, st, txt, _ := newTestManager(t)
+	ctx := context.Background()
+
+	domain, err := m.Add("Shop.Customer.com.", "app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "shop.customer.com", domain.Domain)
+	assert.Equal(t, state.DomainPending, domain.Status)
+	assert.Len(t, domain.Token, 32)
+
+	instructions := InstructionsFor(domain)
+	assert.Equal(t, "_iop-verify.shop.customer.com", instructions.TXTName)
+	assert.Equal(t, "app.example.com", instructions.CNAMETarget)
+
+	// Without DNS records the domain stays pending and isn't served
+	domain, err = m.Verify(ctx, "shop.customer.com")
+	require.NoError(t, err)
+	assert.Equal(t, state.DomainPending, domain.Status)
+	assert.Contains(t, domain.LastError, "no TXT record")
+	_, _, err = st.GetHost("shop.customer.com")
+	assert.Error(t, err)
+
+	txt["_iop-verify.shop.customer.com"] = []string{"unrelated", domain.Token}
+	domain, err = m.Verify(ctx, "shop.customer.com")
+	require.NoError(t, err)
+	assert.Equal(t, state.DomainVerified, domain.Status)
+	assert.Empty(t, domain.LastError)
+
+	host, project, err := st.GetHost("shop.customer.com")
+	require.NoError(t, err)
+	assert.Equal(t, "saas", project)
+	assert.Equal(t, "app-blue:3000", host.Target)
+	assert.Equal(t, "app.example.com", host.AliasOf)
+	assert.True(t, host.SSLEnabled)
+
+	// The domain follows its host through blue-green switches
+	require.NoError(t, st.SwitchTarget("app.example.com", "app-green:3000"))
+	host, _, err = st.GetHost("shop.customer.com")
+	require.NoError(t, err)
+	assert.Equal(t, "app-green:3000", host.Target)
+
+	require.NoError(t, st.RemoveCustomDomain("shop.customer.com"))
+	_, _, err = st.GetHost("shop.customer.com")
+	assert.Error(t, err)
+	assert.Empty(t, st.GetCustomDomains())
+}
+
+func TestVerifyWithCNAME(t *testing.T) {
+	m, st, _, cnames := newTestManager(t)
+
+	_, err := m.Add("www.customer.org", "app.example.com")
+	require.NoError(t, err)
+
+	cnames["www.customer.org"] = "app.example.com."
+	m.verifyPending(context.Background())
+
+	domain, err := st.GetCustomDomain("www.customer.org")
+	require.NoError(t, err)
+	assert.Equal(t, state.DomainVerified, domain.Status)
+}
+
+func TestAddRejectsInvalidDomains(t *testing.T) {
+	m, _, _, _ := newTestManager(t)
+
+	_, err := m.Add("*.customer.com", "app.example.com")
+	assert.Error(t, err)
+	_, err = m.Add("localhost", "app.example.com")
+	assert.Error(t, err)
+	_, err = m.Add("shop.customer.com", "missing.example.com")
+	assert.Error(t, err)
+	_, err = m.Add("app.example.com", "app.example.com")
+	assert.Error(t, err)
+
+	_, err = m.Add("shop.customer.com", "app.example.com")
+	require.NoError(t, err)
+	_, err = m.Add("shop.customer.com", "app.example.com")
+	assert.Error(t, err)
+}
@@ -3,22 +3,53 @@ package health
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/elitan/iop/proxy/internal/core"
 	"github.com/elitan/iop/proxy/internal/state"
 )
 
+// Health check scheduling. Each host is checked every checkInterval, give or
+// take checkJitter, starting from its own offset so hosts deployed together
+// aren't checked at once. Hosts with a readiness probe use its interval with
+// the same tenth of jitter. At most maxConcurrentChecks run at a time,
+// including liveness probes.
+const (
+	checkInterval       = 30 * time.Second
+	checkJitter         = checkInterval / 10
+	maxConcurrentChecks = 20
+	scheduleTick        = time.Second
+)
+
 type Checker struct {
 	state  *state.State
 	client *http.Client
+	events core.EventBus
+	docker Docker
+
+	mu       sync.Mutex
+	history  map[string]*ring
+	schedule map[string]time.Time // Next scheduled check by hostname
+	checking map[string]bool      // Scheduled checks in progress
+	liveness map[string]*liveness // Liveness probes by hostname
+	restarts map[string]*restart  // Liveness restarts by project/app
 }
 
 // NewChecker creates a new health checker
 func NewChecker(st *state.State) *Checker {
 	return &Checker{
-		state: st,
+		state:    st,
+		history:  make(map[string]*ring),
+		schedule: make(map[string]time.Time),
+		checking: make(map[string]bool),
+		liveness: make(map[string]*liveness),
+		restarts: make(map[string]*restart),
 		client: &http.Client{
 			Timeout: 5 * time.Second,
 			Transport: &http.Transport{
@@ -30,53 +61,297 @@ func NewChecker(st *state.State) *Checker {
 	}
 }
 
-// Start begins the health checking loop
+// SetEventBus makes the checker publish an event whenever a host's health flips
+func (c *Checker) SetEventBus(events core.EventBus) {
+	c.events = events
+}
+
+// recordResult stores a health check result and publishes a change event. The
+// first check of a host is not a change, since its health was unknown before.
+// A flapping host keeps its routing until the new health is confirmed by
+// several consecutive results.
+func (c *Checker) recordResult(hostname string, host *state.Host, result Result, detail string) {
+	wasChecked := !host.LastHealthCheck.IsZero()
+	wasHealthy := host.Healthy
+
+	c.mu.Lock()
+	r, ok := c.history[hostname]
+	if !ok {
+		r = newRing()
+		c.history[hostname] = r
+	}
+	r.add(result)
+	hold := wasChecked && wasHealthy != result.Healthy && r.flapping() && r.streak() < flapConfirmations
+	// A readiness probe only takes a host out of rotation after its failure threshold
+	var notReady int
+	if readiness := readinessProbe(host); readiness != nil && wasHealthy && !result.Healthy && !host.CrashLooping {
+		if streak := r.streak(); streak < readiness.Threshold() {
+			notReady = streak
+		}
+	}
+	c.mu.Unlock()
+
+	if notReady > 0 {
+		log.Printf("[HEALTH] [%s] Readiness probe failed %d/%d times, keeping it in rotation",
+			hostname, notReady, readinessProbe(host).Threshold())
+		c.state.UpdateHealthStatus(hostname, wasHealthy)
+		return
+	}
+
+	if hold {
+		log.Printf("[HEALTH] [%s] Flapping, keeping it %s until %d consecutive checks agree",
+			hostname, healthWord(wasHealthy), flapConfirmations)
+		c.state.UpdateHealthStatus(hostname, wasHealthy)
+		return
+	}
+
+	c.state.UpdateHealthStatus(hostname, result.Healthy)
+
+	if c.events != nil && wasChecked && wasHealthy != result.Healthy {
+		c.events.Publish(core.HealthChanged{
+			BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+			Healthy:   result.Healthy,
+			Error:     detail,
+		})
+	}
+}
+
+// History returns a host's recent health check results
+func (c *Checker) History(hostname string) History {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.history[hostname]
+	if !ok {
+		return History{Results: []Result{}}
+	}
+	return History{Results: r.last(historySize), Flapping: r.flapping()}
+}
+
+func healthWord(healthy bool) string {
+	if healthy {
+		return "healthy"
+	}
+	return "unhealthy"
+}
+
+// Start checks hosts as they become due until the context is cancelled.
+// Checks in progress are cancelled with it.
 func (c *Checker) Start(ctx context.Context) {
 	log.Println("[HEALTH] Starting health checker")
 
-	// Initial health check for all hosts
-	c.checkAllHosts()
+	jobs := make(chan func())
+	var workers sync.WaitGroup
+	for i := 0; i < maxConcurrentChecks; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				job()
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		workers.Wait()
+		log.Println("[HEALTH] Stopping health checker")
+	}()
 
-	// Start periodic health checks
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(scheduleTick)
 	defer ticker.Stop()
 
 	for {
+		now := time.Now()
+		var batch []func()
+		for _, hostname := range c.due(now) {
+			hostname := hostname
+			batch = append(batch, func() {
+				c.checkHost(ctx, hostname)
+
+				c.mu.Lock()
+				delete(c.checking, hostname)
+				c.mu.Unlock()
+			})
+		}
+		for _, hostname := range c.livenessDue(now) {
+			hostname := hostname
+			batch = append(batch, func() {
+				c.checkLiveness(ctx, hostname)
+
+				c.mu.Lock()
+				c.liveness[hostname].probing = false
+				c.mu.Unlock()
+			})
+		}
+
+		for _, job := range batch {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+
 		select {
 		case <-ticker.C:
-			c.checkAllHosts()
 		case <-ctx.Done():
-			log.Println("[HEALTH] Stopping health checker")
 			return
 		}
 	}
 }
 
+// due returns the hosts whose scheduled check is due and schedules their
+// next one. A host still being checked is skipped until its check finishes.
+func (c *Checker) due(now time.Time) []string {
+	hosts := c.state.GetAllHosts()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Forget the history and schedule of hosts that were removed
+	for hostname := range c.history {
+		if _, exists := hosts[hostname]; !exists {
+			delete(c.history, hostname)
+		}
+	}
+	for hostname := range c.schedule {
+		if _, exists := hosts[hostname]; !exists {
+			delete(c.schedule, hostname)
+		}
+	}
+
+	var due []string
+	for hostname := range hosts {
+		next, scheduled := c.schedule[hostname]
+		if !scheduled {
+			c.schedule[hostname] = now.Add(offset(hostname))
+			continue
+		}
+		if now.Before(next) || c.checking[hostname] {
+			continue
+		}
+		c.checking[hostname] = true
+		c.schedule[hostname] = now.Add(jittered(checkEvery(hosts[hostname])))
+		due = append(due, hostname)
+	}
+	return due
+}
+
+// checkEvery returns the time between a host's checks, set by its readiness
+// probe if it has one
+func checkEvery(host *state.Host) time.Duration {
+	if readiness := readinessProbe(host); readiness != nil {
+		return readiness.Every()
+	}
+	return checkInterval
+}
+
+// jittered spreads an interval by a tenth either way
+func jittered(interval time.Duration) time.Duration {
+	jitter := interval / 10
+	return interval - jitter + time.Duration(rand.Int63n(int64(2*jitter)))
+}
+
+// readinessProbe returns a host's readiness probe, nil if it has none
+func readinessProbe(host *state.Host) *state.ProbePolicy {
+	if host.Probes == nil {
+		return nil
+	}
+	return host.Probes.Readiness
+}
+
+// offset maps a hostname to a stable point within the check interval, where
+// its first check is scheduled
+func offset(hostname string) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	return time.Duration(h.Sum64() % uint64(checkInterval))
+}
+
 // CheckHost performs a health check on a specific host
 func (c *Checker) CheckHost(hostname string) error {
+	return c.checkHost(context.Background(), hostname)
+}
+
+// checkHost performs a health check on a host. A check cancelled by the
+// context records no result.
+func (c *Checker) checkHost(ctx context.Context, hostname string) error {
 	host, _, err := c.state.GetHost(hostname)
 	if err != nil {
 		return fmt.Errorf("host not found: %w", err)
 	}
 
-	// Build health check URL
-	url := fmt.Sprintf("http://%s%s", host.Target, host.HealthPath)
+	// On-demand hosts only hold a certificate, there is no backend to check
+	if host.OnDemand {
+		return nil
+	}
+
+	// A scaled to zero app is stopped on purpose, the next request starts it
+	if host.Sleeping {
+		return nil
+	}
+
+	// A stopped app stays down until it is started again
+	if host.Stopped {
+		return nil
+	}
+
+	// A crash looping container may answer between crashes, so keep the host out of rotation
+	if host.CrashLooping {
+		c.recordResult(hostname, host, Result{Time: time.Now(), Error: "container is crash looping"}, "container is crash looping")
+		return nil
+	}
+
+	// Passthrough backends speak TLS the proxy can't verify, so check they accept connections
+	if host.Mode == state.HostModePassthrough {
+		return c.checkTCP(ctx, hostname, host)
+	}
+
+	// Build health check URL, on the readiness probe's path if it has its own
+	path := host.HealthPath
+	if readiness := readinessProbe(host); readiness != nil && readiness.Path != "" {
+		path = readiness.Path
+	}
+	url := fmt.Sprintf("http://%s%s", host.Target, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
 
 	// Perform health check
 	start := time.Now()
-	resp, err := c.client.Get(url)
+	resp, err := c.client.Do(req)
 	duration := time.Since(start)
 
+	if ctx.Err() != nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return ctx.Err()
+	}
 	if err != nil {
 		log.Printf("[HEALTH] [%s] Check failed: %v", hostname, err)
-		c.state.UpdateHealthStatus(hostname, false)
+		c.recordResult(hostname, host, Result{
+			Time:      start,
+			LatencyMs: duration.Milliseconds(),
+			Error:     err.Error(),
+		}, err.Error())
 		return err
 	}
 	defer resp.Body.Close()
 
 	// Check status code
 	healthy := resp.StatusCode >= 200 && resp.StatusCode < 300
-	c.state.UpdateHealthStatus(hostname, healthy)
+	result := Result{
+		Time:       start,
+		Healthy:    healthy,
+		LatencyMs:  duration.Milliseconds(),
+		StatusCode: resp.StatusCode,
+	}
+	if !healthy {
+		result.Error = fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	c.recordResult(hostname, host, result, fmt.Sprintf("status %d", resp.StatusCode))
 
 	if healthy {
 		log.Printf("[HEALTH] [%s] Check passed: %d OK (%dms)", hostname, resp.StatusCode, duration.Milliseconds())
@@ -87,15 +362,35 @@ func (c *Checker) CheckHost(hostname string) error {
 	return nil
 }
 
-// checkAllHosts performs health checks on all configured hosts
-func (c *Checker) checkAllHosts() {
-	hosts := c.state.GetAllHosts()
+// checkTCP marks a host healthy if its target accepts TCP connections
+func (c *Checker) checkTCP(ctx context.Context, hostname string, host *state.Host) error {
+	dialer := net.Dialer{Timeout: c.client.Timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", host.Target)
+	duration := time.Since(start)
 
-	for hostname := range hosts {
-		go func(h string) {
-			if err := c.CheckHost(h); err != nil {
-				// Error already logged in CheckHost
-			}
-		}(hostname)
+	if ctx.Err() != nil {
+		if err == nil {
+			conn.Close()
+		}
+		return ctx.Err()
 	}
+	if err != nil {
+		log.Printf("[HEALTH] [%s] TCP check failed: %v", hostname, err)
+		c.recordResult(hostname, host, Result{
+			Time:      start,
+			LatencyMs: duration.Milliseconds(),
+			Error:     err.Error(),
+		}, err.Error())
+		return err
+	}
+	conn.Close()
+
+	c.recordResult(hostname, host, Result{
+		Time:      start,
+		Healthy:   true,
+		LatencyMs: duration.Milliseconds(),
+	}, "accepts connections")
+	log.Printf("[HEALTH] [%s] TCP check passed (%dms)", hostname, duration.Milliseconds())
+	return nil
 }
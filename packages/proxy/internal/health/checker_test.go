@@ -0,0 +1,63 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDueSpreadsAndReschedulesChecks(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("a.example.com", "a:3000", "app", "web", "/up", false))
+	require.NoError(t, st.DeployHost("b.example.com", "b:3000", "app", "web", "/up", false))
+	checker := NewChecker(st)
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// New hosts start at their own offset within the interval
+	assert.Empty(t, checker.due(now))
+	for _, hostname := range []string{"a.example.com", "b.example.com"} {
+		assert.Equal(t, now.Add(offset(hostname)), checker.schedule[hostname])
+	}
+
+	now = now.Add(checkInterval)
+	assert.ElementsMatch(t, []string{"a.example.com", "b.example.com"}, checker.due(now))
+	for _, next := range checker.schedule {
+		assert.False(t, next.Before(now.Add(checkInterval-checkJitter)))
+		assert.True(t, next.Before(now.Add(checkInterval+checkJitter)))
+	}
+
+	// A check still in progress isn't scheduled again
+	delete(checker.checking, "a.example.com")
+	now = now.Add(2 * checkInterval)
+	assert.Equal(t, []string{"a.example.com"}, checker.due(now))
+
+	// Removed hosts are forgotten
+	require.NoError(t, st.RemoveHost("b.example.com"))
+	checker.due(now)
+	assert.NotContains(t, checker.schedule, "b.example.com")
+}
+
+func TestCancelledCheckRecordsNothing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	target := strings.TrimPrefix(server.URL, "http://")
+	require.NoError(t, st.DeployHost("app.example.com", target, "app", "web", "/up", false))
+	checker := NewChecker(st)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	assert.ErrorIs(t, checker.checkHost(ctx, "app.example.com"), context.Canceled)
+	assert.Empty(t, checker.History("app.example.com").Results)
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"time"
+)
+
+const (
+	// historySize is how many results are kept per host
+	historySize = 50
+	// flapWindow is how many recent results are looked at for flap detection
+	flapWindow = 10
+	// flapThreshold is how many healthy/unhealthy transitions within the
+	// window mark a host as flapping
+	flapThreshold = 4
+	// flapConfirmations is how many consecutive results a flapping host needs
+	// before its routing flips
+	flapConfirmations = 3
+)
+
+// Result is a single health check outcome
+type Result struct {
+	Time       time.Time `json:"time"`
+	Healthy    bool      `json:"healthy"`
+	LatencyMs  int64     `json:"latency_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// History is a host's recent health check results, oldest first
+type History struct {
+	Results  []Result `json:"results"`
+	Flapping bool     `json:"flapping"`
+}
+
+// ring is a fixed size buffer of a host's results
+type ring struct {
+	results []Result
+	next    int
+	count   int
+}
+
+func newRing() *ring {
+	return &ring{results: make([]Result, historySize)}
+}
+
+func (r *ring) add(result Result) {
+	r.results[r.next] = result
+	r.next = (r.next + 1) % len(r.results)
+	if r.count < len(r.results) {
+		r.count++
+	}
+}
+
+// last returns up to n of the most recent results, oldest first
+func (r *ring) last(n int) []Result {
+	if n > r.count {
+		n = r.count
+	}
+	out := make([]Result, 0, n)
+	for i := n; i > 0; i-- {
+		out = append(out, r.results[(r.next-i+len(r.results))%len(r.results)])
+	}
+	return out
+}
+
+// flapping reports whether the results within the flap window changed
+// between healthy and unhealthy too often
+func (r *ring) flapping() bool {
+	recent := r.last(flapWindow)
+	transitions := 0
+	for i := 1; i < len(recent); i++ {
+		if recent[i].Healthy != recent[i-1].Healthy {
+			transitions++
+		}
+	}
+	return transitions >= flapThreshold
+}
+
+// streak returns how many of the most recent results share the latest result's health
+func (r *ring) streak() int {
+	recent := r.last(r.count)
+	n := 0
+	for i := len(recent) - 1; i >= 0 && recent[i].Healthy == recent[len(recent)-1].Healthy; i-- {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingKeepsMostRecentResults(t *testing.T) {
+	r := newRing()
+	for i := 0; i < historySize+5; i++ {
+		r.add(Result{StatusCode: i})
+	}
+
+	results := r.last(historySize)
+	require.Len(t, results, historySize)
+	assert.Equal(t, 5, results[0].StatusCode)
+	assert.Equal(t, historySize+4, results[len(results)-1].StatusCode)
+
+	recent := r.last(3)
+	assert.Equal(t, []int{historySize + 2, historySize + 3, historySize + 4},
+		[]int{recent[0].StatusCode, recent[1].StatusCode, recent[2].StatusCode})
+}
+
+func TestFlappingAndStreak(t *testing.T) {
+	r := newRing()
+	for _, healthy := range []bool{true, true, true, false, true} {
+		r.add(Result{Healthy: healthy})
+	}
+	assert.False(t, r.flapping())
+	assert.Equal(t, 1, r.streak())
+
+	r.add(Result{Healthy: false})
+	r.add(Result{Healthy: true})
+	assert.True(t, r.flapping())
+
+	r.add(Result{Healthy: true})
+	assert.Equal(t, 2, r.streak())
+}
+
+func TestFlappingHostKeepsRouting(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	target := strings.TrimPrefix(server.URL, "http://")
+	require.NoError(t, st.DeployHost("app.example.com", target, "app", "web", "/up", false))
+
+	checker := NewChecker(st)
+	check := func(up bool) bool {
+		healthy.Store(up)
+		checker.CheckHost("app.example.com")
+		host, _, err := st.GetHost("app.example.com")
+		require.NoError(t, err)
+		return host.Healthy
+	}
+
+	// Before the host flaps every result flips routing
+	assert.True(t, check(true))
+	assert.False(t, check(false))
+	assert.True(t, check(true))
+	assert.False(t, check(false))
+
+	// The fourth transition marks it flapping, so it stays unhealthy until
+	// enough consecutive checks pass
+	assert.False(t, check(true))
+	assert.False(t, check(true))
+	assert.True(t, check(true))
+
+	history := checker.History("app.example.com")
+	require.Len(t, history.Results, 7)
+	assert.True(t, history.Flapping)
+	assert.Equal(t, http.StatusServiceUnavailable, history.Results[1].StatusCode)
+	assert.Equal(t, "status 503", history.Results[1].Error)
+	assert.Empty(t, history.Results[2].Error)
+
+	assert.Empty(t, checker.History("missing.example.com").Results)
+}
@@ -0,0 +1,216 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+const (
+	// restartGrace is how long an app restarted by its liveness probe gets to
+	// boot before the probe can restart it again
+	restartGrace = time.Minute
+	// restartStopTimeout is how long containers get to exit before they are killed
+	restartStopTimeout = 10 * time.Second
+)
+
+// Docker is the part of the Docker Engine API liveness probes use to restart apps
+type Docker interface {
+	AllContainers(ctx context.Context, label string) ([]docker.Container, error)
+	Stop(ctx context.Context, id string, timeout time.Duration) error
+	Start(ctx context.Context, id string) error
+}
+
+// liveness tracks the liveness probe of one host
+type liveness struct {
+	next     time.Time
+	failures int // Consecutive failures
+	probing  bool
+}
+
+// restart tracks the liveness restarts of one app
+type restart struct {
+	at    time.Time
+	count int
+}
+
+// SetDocker lets liveness probes restart the containers of apps that fail
+// them. Without it liveness failures are only logged.
+func (c *Checker) SetDocker(docker Docker) {
+	c.docker = docker
+}
+
+// livenessDue returns the hosts whose liveness probe is due and schedules
+// their next one. A probe still in progress is skipped until it finishes.
+func (c *Checker) livenessDue(now time.Time) []string {
+	hosts := c.state.GetAllHosts()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var due []string
+	for hostname, host := range hosts {
+		probe := livenessProbe(host)
+		if probe == nil {
+			continue
+		}
+
+		l, ok := c.liveness[hostname]
+		if !ok {
+			c.liveness[hostname] = &liveness{next: now.Add(jittered(probe.Every()))}
+			continue
+		}
+		if now.Before(l.next) || l.probing {
+			continue
+		}
+		l.probing = true
+		l.next = now.Add(jittered(probe.Every()))
+		due = append(due, hostname)
+	}
+
+	// Forget hosts that were removed or lost their liveness probe, unless a
+	// probe is still running
+	for hostname, l := range c.liveness {
+		if host, exists := hosts[hostname]; (!exists || livenessProbe(host) == nil) && !l.probing {
+			delete(c.liveness, hostname)
+		}
+	}
+	return due
+}
+
+// livenessProbe returns a host's liveness probe, nil if it has none
+func livenessProbe(host *state.Host) *state.ProbePolicy {
+	if host.Probes == nil {
+		return nil
+	}
+	return host.Probes.Liveness
+}
+
+// checkLiveness runs a host's liveness probe and restarts its app once the
+// probe has failed its threshold of times in a row
+func (c *Checker) checkLiveness(ctx context.Context, hostname string) {
+	host, project, err := c.state.GetHost(hostname)
+	if err != nil {
+		return
+	}
+	probe := livenessProbe(host)
+	// Apps that are down on purpose or being started aren't probed
+	if probe == nil || host.OnDemand || host.Sleeping || host.Stopped || host.Mode == state.HostModePassthrough {
+		return
+	}
+
+	path := probe.Path
+	if path == "" {
+		path = host.HealthPath
+	}
+	err = c.get(ctx, fmt.Sprintf("http://%s%s", host.Target, path))
+	if ctx.Err() != nil {
+		return
+	}
+
+	c.mu.Lock()
+	l, ok := c.liveness[hostname]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	if err == nil {
+		l.failures = 0
+		c.mu.Unlock()
+		return
+	}
+
+	l.failures++
+	log.Printf("[HEALTH] [%s] Liveness probe failed %d/%d times: %v", hostname, l.failures, probe.Threshold(), err)
+	if l.failures < probe.Threshold() {
+		c.mu.Unlock()
+		return
+	}
+	l.failures = 0
+
+	// Several hosts can route to the same app, which is restarted once
+	key := project + "/" + host.App
+	r, ok := c.restarts[key]
+	if !ok {
+		r = &restart{}
+		c.restarts[key] = r
+	}
+	if time.Since(r.at) < restartGrace {
+		c.mu.Unlock()
+		return
+	}
+	r.at = time.Now()
+	r.count++
+	restarts := r.count
+	c.mu.Unlock()
+
+	c.restartApp(ctx, hostname, project, host.App, restarts, err)
+}
+
+// get requests a URL and fails unless the response is a 2xx
+func (c *Checker) get(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// restartApp stops and starts the running containers of an app that failed
+// its liveness probe
+func (c *Checker) restartApp(ctx context.Context, hostname, project, app string, restarts int, cause error) {
+	if c.docker == nil {
+		log.Printf("[HEALTH] [%s] Liveness probe failed, but Docker isn't available to restart %s/%s", hostname, project, app)
+		return
+	}
+
+	containers, err := c.docker.AllContainers(ctx, "iop.project="+project)
+	if err != nil {
+		log.Printf("[HEALTH] [%s] Failed to list containers of %s/%s: %v", hostname, project, app, err)
+		return
+	}
+
+	for _, container := range containers {
+		name := container.Labels["iop.app"]
+		if name == "" {
+			name = container.Labels["iop.service"]
+		}
+		if container.Labels["iop.type"] != "service" || name != app || container.State != "running" {
+			continue
+		}
+
+		log.Printf("[HEALTH] [%s] Restarting %s after repeated liveness probe failures", hostname, container.Name)
+		if err := c.docker.Stop(ctx, container.ID, restartStopTimeout); err != nil {
+			log.Printf("[HEALTH] [%s] Failed to stop %s: %v", hostname, container.Name, err)
+			continue
+		}
+		if err := c.docker.Start(ctx, container.ID); err != nil {
+			log.Printf("[HEALTH] [%s] Failed to start %s: %v", hostname, container.Name, err)
+			continue
+		}
+
+		if c.events != nil {
+			c.events.Publish(core.ContainerRestarted{
+				BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: hostname},
+				Container: container.Name,
+				Restarts:  restarts,
+				Error:     cause.Error(),
+			})
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []docker.Container
+	calls      []string
+}
+
+func (f *fakeDocker) AllContainers(ctx context.Context, label string) ([]docker.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeDocker) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "stop "+id)
+	return nil
+}
+
+func (f *fakeDocker) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "start "+id)
+	return nil
+}
+
+// probedHost deploys a host backed by a server answering with the given status
+func probedHost(t *testing.T, status int, probes *state.HostProbes) (*state.State, *Checker) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	target := strings.TrimPrefix(server.URL, "http://")
+	require.NoError(t, st.DeployHost("shop.example.com", target, "shop", "web", "/up", false))
+	require.NoError(t, st.SetHostProbes("shop.example.com", probes))
+	return st, NewChecker(st)
+}
+
+func TestReadinessProbeWaitsForItsThreshold(t *testing.T) {
+	st, checker := probedHost(t, http.StatusServiceUnavailable, &state.HostProbes{
+		Readiness: &state.ProbePolicy{Interval: "5s", FailureThreshold: 3},
+	})
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, checker.checkHost(context.Background(), "shop.example.com"))
+		host, _, _ := st.GetHost("shop.example.com")
+		assert.Equal(t, i < 3, host.Healthy, "after %d failures", i)
+	}
+
+	// The probe's interval replaces the regular one
+	now := time.Now()
+	checker.due(now)
+	now = now.Add(checkInterval)
+	checker.due(now)
+	next := checker.schedule["shop.example.com"]
+	assert.True(t, next.Before(now.Add(6*time.Second)))
+}
+
+func TestLivenessProbeRestartsApp(t *testing.T) {
+	_, checker := probedHost(t, http.StatusInternalServerError, &state.HostProbes{
+		Liveness: &state.ProbePolicy{Path: "/live", FailureThreshold: 2},
+	})
+	fake := &fakeDocker{containers: []docker.Container{
+		{ID: "web1", Name: "shop-web-green", State: "running", Labels: map[string]string{"iop.type": "service", "iop.app": "web"}},
+		{ID: "web2", Name: "shop-web-blue", State: "exited", Labels: map[string]string{"iop.type": "service", "iop.app": "web"}},
+		{ID: "api1", Name: "shop-api-green", State: "running", Labels: map[string]string{"iop.type": "service", "iop.app": "api"}},
+	}}
+	checker.SetDocker(fake)
+	bus := events.NewSimpleBus()
+	published := bus.Subscribe()
+	checker.SetEventBus(bus)
+
+	now := time.Now()
+	assert.Empty(t, checker.livenessDue(now))
+	assert.Equal(t, []string{"shop.example.com"}, checker.livenessDue(now.Add(time.Minute)))
+
+	ctx := context.Background()
+	checker.checkLiveness(ctx, "shop.example.com")
+	assert.Empty(t, fake.calls)
+
+	checker.checkLiveness(ctx, "shop.example.com")
+	assert.Equal(t, []string{"stop web1", "start web1"}, fake.calls)
+
+	select {
+	case event := <-published:
+		restarted, ok := event.(core.ContainerRestarted)
+		require.True(t, ok)
+		assert.Equal(t, "shop-web-green", restarted.Container)
+		assert.Equal(t, 1, restarted.Restarts)
+		assert.Equal(t, "status 500", restarted.Error)
+	case <-time.After(time.Second):
+		t.Fatal("no restart event")
+	}
+
+	// A restarted app gets time to boot before it is restarted again
+	checker.checkLiveness(ctx, "shop.example.com")
+	checker.checkLiveness(ctx, "shop.example.com")
+	assert.Len(t, fake.calls, 2)
+}
@@ -0,0 +1,323 @@
+// Package networks keeps the proxy container attached to the Docker networks
+// of the projects it routes to, so their containers' aliases resolve. A
+// project's network is attached when its first host is deployed and detached
+// when its last one is removed.
+package networks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+const (
+	// DefaultContainer is the proxy container's name unless IOP_PROXY_CONTAINER sets another
+	DefaultContainer = "iop-proxy"
+	// reconcileInterval retries networks that didn't exist yet or failed to attach
+	reconcileInterval = 5 * time.Minute
+	// dockerTimeout bounds one reconciliation's Docker calls
+	dockerTimeout = 30 * time.Second
+)
+
+// Docker is the part of the Docker Engine API the manager uses
+type Docker interface {
+	Networks(ctx context.Context, id string) ([]string, error)
+	NetworkExists(ctx context.Context, name string) (bool, error)
+	ConnectNetwork(ctx context.Context, network, id string) error
+	DisconnectNetwork(ctx context.Context, network, id string) error
+}
+
+var unsafeNetworkChars = regexp.MustCompile(`[^a-z0-9-]`)
+
+// NetworkName returns the network the deploy creates for a project,
+// e.g. "blog-network"
+func NetworkName(project string) string {
+	return unsafeNetworkChars.ReplaceAllString(strings.ToLower(project), "-") + "-network"
+}
+
+// Attachment is a network the proxy container is or should be attached to
+type Attachment struct {
+	Network   string   `json:"network"`
+	Projects  []string `json:"projects,omitempty"` // Routed projects using the network
+	Connected bool     `json:"connected"`
+	Managed   bool     `json:"managed"` // Detached with the last host of its projects
+	Pinned    bool     `json:"pinned"`  // Attached by hand
+}
+
+// Manager attaches the proxy container to project networks
+type Manager struct {
+	state     *state.State
+	docker    Docker
+	container string
+
+	mu      sync.Mutex // Serializes reconciliations and manual changes
+	changed chan struct{}
+}
+
+// NewManager creates a network manager for the proxy container
+func NewManager(st *state.State, d Docker) *Manager {
+	container := os.Getenv("IOP_PROXY_CONTAINER")
+	if container == "" {
+		container = DefaultContainer
+	}
+	return &Manager{
+		state:     st,
+		docker:    d,
+		container: container,
+		changed:   make(chan struct{}, 1),
+	}
+}
+
+// Watch reconciles the networks after hosts are deployed or removed
+func (m *Manager) Watch() {
+	m.state.OnHostChanged(func(state.HostChange) {
+		m.Changed()
+	})
+}
+
+// Changed asks Run for a reconciliation, e.g. after port forwarding rules changed
+func (m *Manager) Changed() {
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run reconciles the networks now and after every change until the context
+// is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		reconcileCtx, cancel := context.WithTimeout(ctx, dockerTimeout)
+		if err := m.Reconcile(reconcileCtx); err != nil {
+			log.Printf("[NETWORK] Reconciliation failed: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile attaches the proxy container to the network of every routed
+// project and detaches it from managed networks no project uses anymore.
+// Networks found attached for a routed project are adopted, networks that
+// don't exist yet are attached on a later run.
+func (m *Manager) Reconcile(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	connected, err := m.connected(ctx)
+	if err != nil {
+		return err
+	}
+	wanted := m.wanted()
+	recorded := m.state.GetNetworks()
+	pinned := toSet(recorded.Pinned)
+
+	var errs []error
+	managed := make(map[string]bool)
+	for _, network := range sortedKeys(wanted) {
+		if connected[network] {
+			managed[network] = true
+			continue
+		}
+		exists, err := m.docker.NetworkExists(ctx, network)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !exists {
+			log.Printf("[NETWORK] Network %s of project %s doesn't exist yet", network, strings.Join(wanted[network], ", "))
+			continue
+		}
+		if err := m.docker.ConnectNetwork(ctx, network, m.container); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		managed[network] = true
+		log.Printf("[NETWORK] Attached %s to %s", m.container, network)
+	}
+
+	for _, network := range recorded.Managed {
+		if managed[network] || !connected[network] {
+			continue
+		}
+		if pinned[network] {
+			managed[network] = false
+			continue
+		}
+		if err := m.docker.DisconnectNetwork(ctx, network, m.container); err != nil {
+			// Kept managed so the next run tries again
+			managed[network] = true
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("[NETWORK] Detached %s from %s, no project routes to it anymore", m.container, network)
+	}
+
+	var keep []string
+	for network, ok := range managed {
+		if ok {
+			keep = append(keep, network)
+		}
+	}
+	m.state.SetNetworks(state.Networks{Managed: keep, Pinned: recorded.Pinned})
+	return errors.Join(errs...)
+}
+
+// Connect attaches the proxy container to a network by hand. The network
+// stays attached until Disconnect, whether a project uses it or not.
+func (m *Manager) Connect(ctx context.Context, network string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exists, err := m.docker.NetworkExists(ctx, network)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("network %s not found", network)
+	}
+
+	connected, err := m.connected(ctx)
+	if err != nil {
+		return err
+	}
+	if !connected[network] {
+		if err := m.docker.ConnectNetwork(ctx, network, m.container); err != nil {
+			return err
+		}
+		log.Printf("[NETWORK] Attached %s to %s", m.container, network)
+	}
+
+	recorded := m.state.GetNetworks()
+	if !toSet(recorded.Pinned)[network] {
+		recorded.Pinned = append(recorded.Pinned, network)
+	}
+	m.state.SetNetworks(recorded)
+	return nil
+}
+
+// Disconnect detaches the proxy container from a network. The network of a
+// project that still has hosts can't be detached, its hosts would stop
+// resolving.
+func (m *Manager) Disconnect(ctx context.Context, network string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if projects := m.wanted()[network]; len(projects) > 0 {
+		return fmt.Errorf("network %s is used by project %s", network, strings.Join(projects, ", "))
+	}
+
+	connected, err := m.connected(ctx)
+	if err != nil {
+		return err
+	}
+	if connected[network] {
+		if err := m.docker.DisconnectNetwork(ctx, network, m.container); err != nil {
+			return err
+		}
+		log.Printf("[NETWORK] Detached %s from %s", m.container, network)
+	}
+
+	recorded := m.state.GetNetworks()
+	m.state.SetNetworks(state.Networks{
+		Managed: without(recorded.Managed, network),
+		Pinned:  without(recorded.Pinned, network),
+	})
+	return nil
+}
+
+// List returns the networks the proxy container is attached to, and those
+// routed projects need, sorted by name
+func (m *Manager) List(ctx context.Context) ([]Attachment, error) {
+	connected, err := m.connected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wanted := m.wanted()
+	recorded := m.state.GetNetworks()
+	managed, pinned := toSet(recorded.Managed), toSet(recorded.Pinned)
+
+	names := make(map[string]bool)
+	for network := range connected {
+		names[network] = true
+	}
+	for network := range wanted {
+		names[network] = true
+	}
+
+	attachments := make([]Attachment, 0, len(names))
+	for _, network := range sortedKeys(names) {
+		attachments = append(attachments, Attachment{
+			Network:   network,
+			Projects:  wanted[network],
+			Connected: connected[network],
+			Managed:   managed[network],
+			Pinned:    pinned[network],
+		})
+	}
+	return attachments, nil
+}
+
+// connected returns the networks the proxy container is attached to
+func (m *Manager) connected(ctx context.Context) (map[string]bool, error) {
+	networks, err := m.docker.Networks(ctx, m.container)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks of %s: %w", m.container, err)
+	}
+	return toSet(networks), nil
+}
+
+// wanted maps the networks routed projects need to their projects
+func (m *Manager) wanted() map[string][]string {
+	wanted := make(map[string][]string)
+	for _, project := range m.state.RoutedProjects() {
+		network := NetworkName(project)
+		wanted[network] = append(wanted[network], project)
+	}
+	return wanted
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+func without(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
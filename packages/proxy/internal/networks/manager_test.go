@@ -0,0 +1,108 @@
+package networks
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker tracks the networks of the proxy container
+type fakeDocker struct {
+	mu       sync.Mutex
+	existing map[string]bool
+	attached map[string]bool
+}
+
+func newFakeDocker(existing ...string) *fakeDocker {
+	f := &fakeDocker{existing: make(map[string]bool), attached: map[string]bool{"bridge": true}}
+	for _, network := range existing {
+		f.existing[network] = true
+	}
+	return f
+}
+
+func (f *fakeDocker) Networks(ctx context.Context, id string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var networks []string
+	for network := range f.attached {
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (f *fakeDocker) NetworkExists(ctx context.Context, name string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.existing[name], nil
+}
+
+func (f *fakeDocker) ConnectNetwork(ctx context.Context, network, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attached[network] = true
+	return nil
+}
+
+func (f *fakeDocker) DisconnectNetwork(ctx context.Context, network, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.attached, network)
+	return nil
+}
+
+func (f *fakeDocker) networks() []string {
+	networks, _ := f.Networks(context.Background(), "")
+	sort.Strings(networks)
+	return networks
+}
+
+func TestNetworkName(t *testing.T) {
+	assert.Equal(t, "blog-network", NetworkName("blog"))
+	assert.Equal(t, "my-shop-network", NetworkName("My_Shop"))
+}
+
+func TestReconcileFollowsHosts(t *testing.T) {
+	ctx := context.Background()
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	d := newFakeDocker("blog-network", "shop-network", "db-network")
+	m := NewManager(st, d)
+
+	// The first host of a project attaches its network, a missing network waits
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.DeployHost("docs.example.com", "docs-web:3000", "docs", "web", "/up", false))
+	require.NoError(t, m.Reconcile(ctx))
+	assert.Equal(t, []string{"blog-network", "bridge"}, d.networks())
+
+	// A network attached before, e.g. by an older deploy, is adopted
+	d.attached["shop-network"] = true
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-web:3000", "shop", "web", "/up", false))
+	require.NoError(t, m.Reconcile(ctx))
+	assert.Equal(t, []string{"blog-network", "shop-network"}, st.GetNetworks().Managed)
+
+	// Networks attached by hand stay, even without a project
+	require.NoError(t, m.Connect(ctx, "db-network"))
+	assert.Error(t, m.Connect(ctx, "missing-network"))
+	assert.ErrorContains(t, m.Disconnect(ctx, "blog-network"), "used by project blog")
+
+	// The last host of a project detaches its network
+	require.NoError(t, st.RemoveHost("shop.example.com"))
+	require.NoError(t, m.Reconcile(ctx))
+	assert.Equal(t, []string{"blog-network", "bridge", "db-network"}, d.networks())
+	assert.Equal(t, state.Networks{Managed: []string{"blog-network"}, Pinned: []string{"db-network"}}, st.GetNetworks())
+
+	attachments, err := m.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, attachments, 4)
+	assert.Equal(t, Attachment{Network: "docs-network", Projects: []string{"docs"}}, attachments[3])
+
+	require.NoError(t, m.Disconnect(ctx, "db-network"))
+	assert.Equal(t, []string{"blog-network", "bridge"}, d.networks())
+	assert.Empty(t, st.GetNetworks().Pinned)
+}
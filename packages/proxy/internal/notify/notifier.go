@@ -0,0 +1,246 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// Message is the data passed to notification templates
+type Message struct {
+	Event     string
+	Hostname  string
+	Text      string
+	Timestamp time.Time
+	Metadata  map[string]string // Attached to the deployment by the client, e.g. sha and actor
+	Data      core.Event
+}
+
+// Notifier delivers events from the event bus to the configured notification targets
+type Notifier struct {
+	state  *state.State
+	client *http.Client
+}
+
+// NewNotifier creates a new notifier
+func NewNotifier(st *state.State) *Notifier {
+	return &Notifier{
+		state: st,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Run delivers events until the context is cancelled or the channel closes
+func (n *Notifier) Run(ctx context.Context, events <-chan core.Event) {
+	log.Println("[NOTIFY] Starting notifier")
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			n.Handle(event)
+		case <-ctx.Done():
+			log.Println("[NOTIFY] Stopping notifier")
+			return
+		}
+	}
+}
+
+// Handle sends an event to every target subscribed to it
+func (n *Notifier) Handle(event core.Event) {
+	msg, ok := NewMessage(event)
+	if !ok {
+		return
+	}
+
+	for _, target := range n.state.GetNotifications() {
+		if !Matches(target.Events, msg.Event) {
+			continue
+		}
+		if err := n.send(target, msg); err != nil {
+			log.Printf("[NOTIFY] [%s] Failed to deliver %s to %s: %v", msg.Hostname, msg.Event, target.Type, err)
+		}
+	}
+}
+
+// NewMessage describes an event for notifications. Events that are not worth
+// notifying about return false.
+func NewMessage(event core.Event) (Message, bool) {
+	msg := Message{Timestamp: event.EventTime(), Data: event}
+
+	switch e := event.(type) {
+	case core.DeploymentStarted:
+		msg.Event, msg.Hostname = "deployment.started", e.Hostname
+		msg.Text = fmt.Sprintf("Deployment of %s started (%s)", e.Hostname, e.Color)
+	case core.TrafficSwitched:
+		msg.Event, msg.Hostname = "deployment.switched", e.Hostname
+		msg.Text = fmt.Sprintf("%s now routes to %s", e.Hostname, e.ToTarget)
+		msg.Metadata = e.Metadata
+	case core.DeploymentCompleted:
+		msg.Event, msg.Hostname = "deployment.completed", e.Hostname
+		msg.Text = fmt.Sprintf("Deployment of %s completed (%s)", e.Hostname, e.Color)
+	case core.DeploymentFailed:
+		msg.Event, msg.Hostname = "deployment.failed", e.Hostname
+		msg.Text = fmt.Sprintf("Deployment of %s failed: %s", e.Hostname, e.Error)
+		msg.Metadata = e.Metadata
+	case core.DeploymentRolledBack:
+		msg.Event, msg.Hostname = "deployment.rolled_back", e.Hostname
+		msg.Text = fmt.Sprintf("Deployment of %s rolled back to %s: %s", e.Hostname, e.ToColor, e.Reason)
+	case core.CertificateIssued:
+		msg.Event, msg.Hostname = "cert.issued", e.Hostname
+		msg.Text = fmt.Sprintf("Certificate for %s issued, expires %s", e.Hostname, e.ExpiresAt.Format("2006-01-02"))
+	case core.CertificateFailed:
+		msg.Event, msg.Hostname = "cert.failed", e.Hostname
+		msg.Text = fmt.Sprintf("Certificate acquisition for %s failed: %s", e.Hostname, e.Error)
+		if e.Final {
+			msg.Text += " (giving up)"
+		}
+	case core.CertificateRevoked:
+		msg.Event, msg.Hostname = "cert.revoked", e.Hostname
+		msg.Text = fmt.Sprintf("Certificate for %s revoked (%s)", e.Hostname, e.Reason)
+	case core.CertificateExpiring:
+		msg.Event, msg.Hostname = "cert.expiring", e.Hostname
+		msg.Text = fmt.Sprintf("Certificate for %s expires in %d days (%s)", e.Hostname, e.DaysLeft, e.ExpiresAt.Format(time.RFC3339))
+	case core.CertificateRenewalFailing:
+		msg.Event, msg.Hostname = "cert.renewal_failing", e.Hostname
+		msg.Text = fmt.Sprintf("Renewal of the certificate for %s failed %d times, it expires %s", e.Hostname, e.Attempts, e.ExpiresAt.Format(time.RFC3339))
+		if e.Error != "" {
+			msg.Text += ": " + e.Error
+		}
+	case core.HealthChanged:
+		msg.Hostname = e.Hostname
+		if e.Healthy {
+			msg.Event = "health.recovered"
+			msg.Text = fmt.Sprintf("%s is healthy again", e.Hostname)
+		} else {
+			msg.Event = "health.failed"
+			msg.Text = fmt.Sprintf("%s is failing health checks", e.Hostname)
+			if e.Error != "" {
+				msg.Text += ": " + e.Error
+			}
+		}
+	case core.ContainerCrashed:
+		msg.Event, msg.Hostname = "container.crashed", e.Hostname
+		msg.Text = fmt.Sprintf("Container %s exited with code %d, restarting in %s", e.Container, e.ExitCode, e.Backoff)
+	case core.ContainerRestarted:
+		msg.Event, msg.Hostname = "container.restarted", e.Hostname
+		msg.Text = fmt.Sprintf("Container %s restarted after failing its liveness probe: %s", e.Container, e.Error)
+	case core.CrashLoopChanged:
+		msg.Hostname = e.Hostname
+		if e.Looping {
+			msg.Event = "container.crash_loop"
+			msg.Text = fmt.Sprintf("Container %s is crash looping", e.Container)
+			if e.Hostname != "" {
+				msg.Text += fmt.Sprintf(", %s marked unhealthy", e.Hostname)
+			}
+		} else {
+			msg.Event = "container.recovered"
+			msg.Text = fmt.Sprintf("Container %s stopped crash looping", e.Container)
+		}
+	case core.CapacityWarning:
+		msg.Event, msg.Hostname = "capacity."+e.Resource, e.Hostname
+		msg.Text = fmt.Sprintf("Container %s is using %.0f%% of its %s limit", e.Container, e.Percent, e.Resource)
+	case core.Scaled:
+		msg.Event, msg.Hostname = "autoscale.down", e.Hostname
+		if e.To > e.From {
+			msg.Event = "autoscale.up"
+		}
+		msg.Text = fmt.Sprintf("Scaled %s/%s from %d to %d replicas: %s", e.Project, e.App, e.From, e.To, e.Reason)
+	default:
+		return msg, false
+	}
+
+	if len(msg.Metadata) > 0 {
+		msg.Text += fmt.Sprintf(" (%s)", state.FormatMetadata(msg.Metadata))
+	}
+
+	return msg, true
+}
+
+// Matches reports whether an event passes a target's filter. Filters are full
+// event names ("cert.failed") or categories ("cert"); an empty filter matches all.
+func Matches(filter []string, event string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	category := strings.SplitN(event, ".", 2)[0]
+	for _, f := range filter {
+		if f == event || f == category || f == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload renders the request body for a target
+func Payload(target *state.NotificationTarget, msg Message) ([]byte, error) {
+	text := msg.Text
+	if target.Template != "" {
+		tmpl, err := template.New("notification").Parse(target.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, msg); err != nil {
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+		text = buf.String()
+	}
+
+	switch target.Type {
+	case "slack":
+		return json.Marshal(map[string]string{"text": text})
+	case "discord":
+		return json.Marshal(map[string]string{"content": text})
+	case "webhook":
+		if target.Template != "" {
+			return []byte(text), nil
+		}
+		payload := map[string]interface{}{
+			"event":     msg.Event,
+			"hostname":  msg.Hostname,
+			"message":   msg.Text,
+			"timestamp": msg.Timestamp,
+			"data":      msg.Data,
+		}
+		if len(msg.Metadata) > 0 {
+			payload["metadata"] = msg.Metadata
+		}
+		return json.Marshal(payload)
+	default:
+		return nil, fmt.Errorf("unknown notification type %q", target.Type)
+	}
+}
+
+// send posts a message to a single target
+func (n *Notifier) send(target *state.NotificationTarget, msg Message) error {
+	body, err := Payload(target, msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(target.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
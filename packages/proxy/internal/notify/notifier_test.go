@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		filter []string
+		event  string
+		want   bool
+	}{
+		{nil, "cert.failed", true},
+		{[]string{"cert.failed"}, "cert.failed", true},
+		{[]string{"cert"}, "cert.issued", true},
+		{[]string{"*"}, "health.failed", true},
+		{[]string{"deployment.failed"}, "deployment.switched", false},
+		{[]string{"health"}, "cert.failed", false},
+	}
+
+	for _, tt := range tests {
+		if got := Matches(tt.filter, tt.event); got != tt.want {
+			t.Errorf("Matches(%v, %q) = %v, want %v", tt.filter, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	base := core.BaseEvent{Timestamp: time.Now(), Hostname: "app.example.com"}
+
+	msg, ok := NewMessage(core.HealthChanged{BaseEvent: base, Healthy: false, Error: "status 502"})
+	if !ok {
+		t.Fatal("Expected health change to produce a message")
+	}
+	if msg.Event != "health.failed" {
+		t.Errorf("Expected event health.failed, got %s", msg.Event)
+	}
+	if msg.Text != "app.example.com is failing health checks: status 502" {
+		t.Errorf("Unexpected text: %s", msg.Text)
+	}
+
+	msg, ok = NewMessage(core.CertificateFailed{BaseEvent: base, Error: "rate limited", Final: true})
+	if !ok || msg.Event != "cert.failed" {
+		t.Fatalf("Expected cert.failed message, got %+v", msg)
+	}
+
+	msg, ok = NewMessage(core.CertificateExpiring{BaseEvent: base, DaysLeft: 7, ExpiresAt: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)})
+	if !ok || msg.Event != "cert.expiring" {
+		t.Fatalf("Expected cert.expiring message, got %+v", msg)
+	}
+	if msg.Text != "Certificate for app.example.com expires in 7 days (2026-03-01T00:00:00Z)" {
+		t.Errorf("Unexpected text: %s", msg.Text)
+	}
+
+	msg, ok = NewMessage(core.CrashLoopChanged{BaseEvent: base, Container: "blog-web", Looping: true})
+	if !ok || msg.Event != "container.crash_loop" {
+		t.Fatalf("Expected container.crash_loop message, got %+v", msg)
+	}
+	if msg.Text != "Container blog-web is crash looping, app.example.com marked unhealthy" {
+		t.Errorf("Unexpected text: %s", msg.Text)
+	}
+
+	msg, ok = NewMessage(core.Scaled{BaseEvent: base, Project: "blog", App: "web", From: 2, To: 4, Reason: "cpu 92% (target 70%)"})
+	if !ok || msg.Event != "autoscale.up" {
+		t.Fatalf("Expected autoscale.up message, got %+v", msg)
+	}
+	if msg.Text != "Scaled blog/web from 2 to 4 replicas: cpu 92% (target 70%)" {
+		t.Errorf("Unexpected text: %s", msg.Text)
+	}
+
+	msg, ok = NewMessage(core.TrafficSwitched{BaseEvent: base, ToTarget: "blog-web:3000", Metadata: map[string]string{"sha": "4f2a9c1", "actor": "alice"}})
+	if !ok || msg.Event != "deployment.switched" {
+		t.Fatalf("Expected deployment.switched message, got %+v", msg)
+	}
+	if msg.Text != "app.example.com now routes to blog-web:3000 (actor=alice sha=4f2a9c1)" {
+		t.Errorf("Unexpected text: %s", msg.Text)
+	}
+	if msg.Metadata["sha"] != "4f2a9c1" {
+		t.Errorf("Expected the metadata to be passed to templates, got %v", msg.Metadata)
+	}
+
+	msg, ok = NewMessage(core.DeploymentRolledBack{BaseEvent: base, FromColor: core.Green, ToColor: core.Blue, Reason: "error rate 40.0% over 50 requests exceeds 5.0%"})
+	if !ok || msg.Event != "deployment.rolled_back" {
+		t.Fatalf("Expected deployment.rolled_back message, got %+v", msg)
+	}
+	if msg.Text != "Deployment of app.example.com rolled back to blue: error rate 40.0% over 50 requests exceeds 5.0%" {
+		t.Errorf("Unexpected text: %s", msg.Text)
+	}
+
+	if _, ok := NewMessage(core.HealthCheckPassed{BaseEvent: base}); ok {
+		t.Error("Expected individual health check passes to be ignored")
+	}
+}
+
+func TestPayload(t *testing.T) {
+	msg := Message{
+		Event:     "deployment.failed",
+		Hostname:  "app.example.com",
+		Text:      "Deployment of app.example.com failed: boom",
+		Timestamp: time.Now(),
+	}
+
+	tests := []struct {
+		name   string
+		target state.NotificationTarget
+		want   string
+	}{
+		{
+			name:   "slack",
+			target: state.NotificationTarget{Type: "slack"},
+			want:   `{"text":"Deployment of app.example.com failed: boom"}`,
+		},
+		{
+			name:   "discord",
+			target: state.NotificationTarget{Type: "discord"},
+			want:   `{"content":"Deployment of app.example.com failed: boom"}`,
+		},
+		{
+			name:   "slack template",
+			target: state.NotificationTarget{Type: "slack", Template: ":x: {{.Hostname}} ({{.Event}})"},
+			want:   `{"text":":x: app.example.com (deployment.failed)"}`,
+		},
+		{
+			name:   "webhook template",
+			target: state.NotificationTarget{Type: "webhook", Template: `{"host":"{{.Hostname}}"}`},
+			want:   `{"host":"app.example.com"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := Payload(&tt.target, msg)
+			if err != nil {
+				t.Fatalf("Payload failed: %v", err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, body)
+			}
+		})
+	}
+
+	if _, err := Payload(&state.NotificationTarget{Type: "pager"}, msg); err == nil {
+		t.Error("Expected an error for an unknown target type")
+	}
+}
+
+func TestHandleDeliversToMatchingTargets(t *testing.T) {
+	received := make(chan map[string]interface{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetNotifications([]*state.NotificationTarget{
+		{Type: "webhook", URL: server.URL, Events: []string{"deployment"}},
+		{Type: "slack", URL: server.URL, Events: []string{"cert"}},
+	})
+
+	notifier := NewNotifier(st)
+	notifier.Handle(core.TrafficSwitched{
+		BaseEvent:  core.BaseEvent{Timestamp: time.Now(), Hostname: "app.example.com"},
+		FromTarget: "app-blue:3000",
+		ToTarget:   "app-green:3000",
+	})
+
+	select {
+	case payload := <-received:
+		if payload["event"] != "deployment.switched" {
+			t.Errorf("Expected deployment.switched, got %v", payload["event"])
+		}
+	default:
+		t.Fatal("Expected the webhook target to be notified")
+	}
+
+	select {
+	case payload := <-received:
+		t.Errorf("Expected the slack target to be filtered out, got %v", payload)
+	default:
+	}
+}
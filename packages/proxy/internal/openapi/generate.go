@@ -0,0 +1,373 @@
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// initialisms keep their Go casing in generated names
+var initialisms = map[string]string{
+	"acme": "ACME", "alpn": "ALPN", "api": "API", "cname": "CNAME", "cpu": "CPU", "etag": "ETag",
+	"http": "HTTP", "id": "ID", "ip": "IP", "ssl": "SSL", "tls": "TLS", "txt": "TXT", "url": "URL",
+}
+
+// Generate writes the Go client for a specification: a type for every
+// component schema and a method on Client for every operation with a JSON
+// response. The hand-written part of the package provides Client, Response,
+// RequestOption and the do method that sends requests.
+func Generate(spec *Spec, pkg, source string) ([]byte, error) {
+	g := &generator{spec: spec, imports: map[string]bool{"context": true}}
+
+	for _, name := range spec.schemaOrder {
+		if err := g.writeType(name, spec.Components.Schemas[name]); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	for _, op := range spec.Operations() {
+		if err := g.writeOperation(op); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.Method, op.Path, err)
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by client-gen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&out, "package %s\n\nimport (\n", pkg)
+	imports := make([]string, 0, len(g.imports))
+	for name := range g.imports {
+		imports = append(imports, name)
+	}
+	sort.Strings(imports)
+	for _, name := range imports {
+		fmt.Fprintf(&out, "\t%q\n", name)
+	}
+	out.WriteString(")\n")
+	out.Write(g.body.Bytes())
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid Go: %w", err)
+	}
+	return formatted, nil
+}
+
+type generator struct {
+	spec    *Spec
+	imports map[string]bool
+	body    bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.body, format, args...)
+}
+
+// writeType writes the struct of an object schema
+func (g *generator) writeType(name string, schema *Schema) error {
+	if schema.Type != "object" || len(schema.Properties.Names) == 0 {
+		return fmt.Errorf("only objects with properties can be named types")
+	}
+
+	required := make(map[string]bool)
+	for _, field := range schema.Required {
+		required[field] = true
+	}
+
+	g.printf("\n%stype %s struct {\n", comment(name, schema.Description), name)
+	for _, field := range schema.Properties.Names {
+		property := schema.Properties.Schemas[field]
+		goType, err := g.goType(property, true)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field, err)
+		}
+		tag := field
+		if !required[field] {
+			tag += ",omitempty"
+		}
+		g.printf("\t%s %s `json:%q`", fieldName(field, property), goType, tag)
+		if property.Description != "" {
+			g.printf(" // %s", property.Description)
+		}
+		g.printf("\n")
+	}
+	g.printf("}\n")
+	return nil
+}
+
+// goType returns the Go type of a schema. Fields hold named objects by
+// pointer so that unset ones are omitted, slices and maps hold them by value.
+func (g *generator) goType(schema *Schema, field bool) (string, error) {
+	if schema.Ref != "" {
+		if _, err := g.spec.resolve(schema); err != nil {
+			return "", err
+		}
+		if field {
+			return "*" + refName(schema.Ref), nil
+		}
+		return refName(schema.Ref), nil
+	}
+
+	switch schema.Type {
+	case "":
+		g.imports["encoding/json"] = true
+		return "json.RawMessage", nil
+	case "object":
+		if len(schema.Properties.Names) > 0 || schema.AdditionalProperties == nil || schema.AdditionalProperties.Schema == nil {
+			return "", fmt.Errorf("inline objects need a component schema")
+		}
+		values, err := g.goType(schema.AdditionalProperties.Schema, false)
+		return "map[string]" + values, err
+	case "array":
+		if schema.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		items, err := g.goType(schema.Items, false)
+		return "[]" + items, err
+	case "string":
+		switch schema.Format {
+		case "date-time":
+			g.imports["time"] = true
+			return "time.Time", nil
+		case "byte":
+			return "[]byte", nil
+		}
+		return "string", nil
+	case "integer":
+		if schema.Format == "int64" {
+			return "int64", nil
+		}
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	}
+	return "", fmt.Errorf("unsupported type %s", schema.Type)
+}
+
+// writeOperation writes the method calling an operation, and the struct of
+// its query parameters if it has any
+func (g *generator) writeOperation(op *Operation) error {
+	data, ok := successData(op)
+	if !ok {
+		// Not a JSON API response, e.g. /metrics
+		return nil
+	}
+	if op.OperationID == "" {
+		return fmt.Errorf("missing operationId")
+	}
+	name := exported(op.OperationID)
+
+	var pathParams, queryParams []*Parameter
+	for _, param := range op.Params {
+		switch param.In {
+		case "path":
+			pathParams = append(pathParams, param)
+		case "query":
+			queryParams = append(queryParams, param)
+		}
+	}
+
+	args := []string{"ctx context.Context"}
+	for _, param := range pathParams {
+		args = append(args, unexported(param.Name)+" string")
+	}
+	if len(queryParams) > 0 {
+		if err := g.writeParams(name+"Params", op, queryParams); err != nil {
+			return err
+		}
+		args = append(args, fmt.Sprintf("params *%sParams", name))
+	}
+	if body := op.jsonBody(); body != nil {
+		bodyType, err := g.goType(body, true)
+		if err != nil {
+			return fmt.Errorf("request body: %w", err)
+		}
+		args = append(args, "body "+bodyType)
+	}
+	args = append(args, "opts ...RequestOption")
+
+	results := "(*Response, error)"
+	returned := ""
+	if data != nil {
+		// Named objects come back by pointer, nil when the data is null
+		var err error
+		if returned, err = g.goType(data, true); err != nil {
+			return fmt.Errorf("response data: %w", err)
+		}
+		results = fmt.Sprintf("(%s, *Response, error)", returned)
+	}
+
+	g.printf("\n// %s %s\n//\n// %s %s\n", name, thirdPerson(op.Summary), op.Method, op.Path)
+	g.printf("func (c *Client) %s(%s) %s {\n", name, strings.Join(args, ", "), results)
+
+	query := "nil"
+	if len(queryParams) > 0 {
+		query = "query"
+		g.printf("\tquery := url.Values{}\n\tif params != nil {\n")
+		for _, param := range queryParams {
+			g.writeQueryParam(param)
+		}
+		g.printf("\t}\n")
+	}
+
+	body := "nil"
+	if op.jsonBody() != nil {
+		body = "body"
+	}
+	path := g.pathExpression(op.Path)
+
+	if data == nil {
+		g.printf("\treturn c.do(ctx, %q, %s, %s, %s, nil, opts)\n}\n", op.Method, path, query, body)
+		return nil
+	}
+
+	g.printf("\tvar data %s\n", returned)
+	g.printf("\tresp, err := c.do(ctx, %q, %s, %s, %s, &data, opts)\n", op.Method, path, query, body)
+	g.printf("\tif err != nil {\n\t\treturn nil, resp, err\n\t}\n")
+	g.printf("\treturn data, resp, nil\n}\n")
+	return nil
+}
+
+// writeParams writes the struct holding an operation's query parameters
+func (g *generator) writeParams(name string, op *Operation, params []*Parameter) error {
+	g.printf("\n// %s are the query parameters of %s %s\ntype %s struct {\n", name, op.Method, op.Path, name)
+	for _, param := range params {
+		goType, err := g.goType(param.Schema, false)
+		if err != nil {
+			return fmt.Errorf("parameter %s: %w", param.Name, err)
+		}
+		if goType != "string" && goType != "int" {
+			return fmt.Errorf("parameter %s: only string and integer query parameters are supported", param.Name)
+		}
+		g.printf("\t%s %s", fieldName(param.Name, param.Schema), goType)
+		if param.Description != "" {
+			g.printf(" // %s", param.Description)
+		}
+		g.printf("\n")
+	}
+	g.printf("}\n")
+	return nil
+}
+
+// writeQueryParam adds a parameter to the query. Optional ones are left out
+// while they have their zero value.
+func (g *generator) writeQueryParam(param *Parameter) {
+	g.imports["net/url"] = true
+	field := "params." + fieldName(param.Name, param.Schema)
+	value := field
+	zero := `""`
+	if param.Schema.Type == "integer" {
+		g.imports["strconv"] = true
+		value = fmt.Sprintf("strconv.Itoa(%s)", field)
+		zero = "0"
+	}
+	if param.Required {
+		g.printf("\t\tquery.Set(%q, %s)\n", param.Name, value)
+		return
+	}
+	g.printf("\t\tif %s != %s {\n\t\t\tquery.Set(%q, %s)\n\t\t}\n", field, zero, param.Name, value)
+}
+
+// pathExpression turns a path template into a Go expression that escapes
+// its parameters, e.g. "/api/hosts/" + url.PathEscape(host)
+func (g *generator) pathExpression(template string) string {
+	var parts []string
+	literal := ""
+	for _, segment := range strings.Split(strings.TrimPrefix(template, "/"), "/") {
+		literal += "/"
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			g.imports["net/url"] = true
+			parts = append(parts, fmt.Sprintf("%q", literal), fmt.Sprintf("url.PathEscape(%s)", unexported(strings.Trim(segment, "{}"))))
+			literal = ""
+			continue
+		}
+		literal += segment
+	}
+	if literal != "" {
+		parts = append(parts, fmt.Sprintf("%q", literal))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// successData returns the data schema of an operation's successful JSON
+// response, reporting false if it doesn't answer with the JSON envelope
+func successData(op *Operation) (*Schema, bool) {
+	for _, code := range []string{"200", "201"} {
+		response := op.Responses[code]
+		if response == nil {
+			continue
+		}
+		media := response.Content["application/json"]
+		if media == nil || media.Schema == nil {
+			return nil, false
+		}
+		return media.Schema.Properties.Schemas["data"], true
+	}
+	return nil, false
+}
+
+// fieldName returns the Go name of a JSON field such as health_path
+func fieldName(name string, schema *Schema) string {
+	if schema != nil && schema.GoName != "" {
+		return schema.GoName
+	}
+	var out strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' }) {
+		if initialism, ok := initialisms[strings.ToLower(word)]; ok {
+			out.WriteString(initialism)
+		} else {
+			out.WriteString(exported(word))
+		}
+	}
+	return out.String()
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	return string(unicode.ToUpper(rune(name[0]))) + name[1:]
+}
+
+func unexported(name string) string {
+	if name == "" {
+		return name
+	}
+	return string(unicode.ToLower(rune(name[0]))) + name[1:]
+}
+
+// thirdPerson turns a summary such as "Get a host" into "gets a host", and
+// "Add or replace a rule" into "adds or replaces a rule"
+func thirdPerson(summary string) string {
+	verb, rest, _ := strings.Cut(summary, " ")
+	verb = conjugate(strings.ToLower(verb))
+	if alternative, ok := strings.CutPrefix(rest, "or "); ok {
+		second, remainder, _ := strings.Cut(alternative, " ")
+		verb += " or " + conjugate(second)
+		rest = remainder
+	}
+	if rest == "" {
+		return verb
+	}
+	return verb + " " + rest
+}
+
+// conjugate returns the third person singular of a verb
+func conjugate(verb string) string {
+	switch {
+	case strings.HasSuffix(verb, "s"), strings.HasSuffix(verb, "sh"), strings.HasSuffix(verb, "ch"), strings.HasSuffix(verb, "x"):
+		return verb + "es"
+	}
+	return verb + "s"
+}
+
+// comment formats a type's doc comment
+func comment(name, description string) string {
+	if description == "" {
+		return ""
+	}
+	return fmt.Sprintf("// %s: %s\n", name, description)
+}
@@ -0,0 +1,177 @@
+package openapi
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSpec = `{
+  "openapi": "3.0.3",
+  "paths": {
+    "/api/pets": {
+      "get": {
+        "operationId": "listPets",
+        "summary": "List pets",
+        "parameters": [{"$ref": "#/components/parameters/Limit"}],
+        "responses": {"200": {"description": "Pets", "content": {"application/json": {"schema": {
+          "type": "object",
+          "properties": {"data": {"type": "array", "items": {"$ref": "#/components/schemas/Pet"}}}
+        }}}}}
+      },
+      "post": {
+        "operationId": "addPet",
+        "summary": "Add or replace a pet",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}},
+        "responses": {"201": {"description": "Added", "content": {"application/json": {"schema": {
+          "type": "object",
+          "properties": {"data": {"$ref": "#/components/schemas/Pet"}}
+        }}}}}
+      }
+    },
+    "/api/pets/{name}": {
+      "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "delete": {
+        "operationId": "removePet",
+        "summary": "Remove a pet",
+        "responses": {"200": {"description": "Removed", "content": {"application/json": {"schema": {"type": "object"}}}}}
+      }
+    },
+    "/metrics": {
+      "get": {
+        "operationId": "getMetrics",
+        "responses": {"200": {"description": "Metrics", "content": {"text/plain": {"schema": {"type": "string"}}}}}
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "Limit": {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+    },
+    "schemas": {
+      "Pet": {
+        "type": "object",
+        "description": "A pet",
+        "required": ["name"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "kind": {"type": "string", "enum": ["cat", "dog"]},
+          "owner_id": {"type": "integer", "format": "int64", "description": "Who feeds it"},
+          "tags": {"type": "object", "additionalProperties": {"type": "string"}},
+          "adopted_at": {"type": "string", "format": "date-time"},
+          "ip": {"type": "string", "x-go-name": "Address"}
+        }
+      }
+    }
+  }
+}`
+
+func parseTestSpec(t *testing.T) *Spec {
+	spec, err := Parse([]byte(testSpec))
+	require.NoError(t, err)
+	return spec
+}
+
+func TestParse(t *testing.T) {
+	spec := parseTestSpec(t)
+
+	ops := spec.Operations()
+	require.Len(t, ops, 4)
+	assert.Equal(t, "listPets", ops[0].OperationID)
+	assert.Equal(t, "addPet", ops[1].OperationID)
+	assert.Equal(t, "removePet", ops[2].OperationID)
+
+	// Parameter references and path item parameters are resolved
+	assert.Equal(t, "limit", ops[0].Params[0].Name)
+	assert.Equal(t, "name", ops[2].Params[0].Name)
+
+	assert.Equal(t, []string{"name", "kind", "owner_id", "tags", "adopted_at", "ip"}, spec.Components.Schemas["Pet"].Properties.Names)
+
+	_, err := Parse([]byte(`{"openapi": "2.0"}`))
+	assert.Error(t, err)
+	_, err = Parse([]byte(`{"openapi": "3.0.3", "paths": {"/": {"get": {"parameters": [{"$ref": "#/components/parameters/Missing"}]}}}}`))
+	assert.Error(t, err)
+}
+
+func TestFind(t *testing.T) {
+	spec := parseTestSpec(t)
+
+	assert.Equal(t, "listPets", spec.Find("GET", "/api/pets").OperationID)
+	assert.Equal(t, "removePet", spec.Find("DELETE", "/api/pets/rex").OperationID)
+	assert.Nil(t, spec.Find("DELETE", "/api/pets/"))
+	assert.Nil(t, spec.Find("PUT", "/api/pets"))
+	assert.Nil(t, spec.Find("GET", "/api/pets/rex/toys"))
+}
+
+func TestValidateRequest(t *testing.T) {
+	spec := parseTestSpec(t)
+	list := spec.Find("GET", "/api/pets")
+	add := spec.Find("POST", "/api/pets")
+
+	tests := []struct {
+		name  string
+		op    *Operation
+		query string
+		body  string
+		err   string
+	}{
+		{name: "valid", op: add, body: `{"name":"rex","kind":"dog","owner_id":7,"tags":{"color":"brown"},"adopted_at":"2024-01-01T00:00:00Z"}`},
+		{name: "missing body", op: add, err: "missing JSON body"},
+		{name: "invalid JSON", op: add, body: `{`, err: "invalid JSON body"},
+		{name: "not an object", op: add, body: `[]`, err: "body must be an object"},
+		{name: "required", op: add, body: `{"kind":"cat"}`, err: "body.name is required"},
+		{name: "unknown field", op: add, body: `{"name":"rex","age":3}`, err: "body has unknown field age"},
+		{name: "enum", op: add, body: `{"name":"rex","kind":"fish"}`, err: "body.kind must be one of cat, dog"},
+		{name: "min length", op: add, body: `{"name":""}`, err: "body.name must not be shorter than 1 characters"},
+		{name: "integer", op: add, body: `{"name":"rex","owner_id":1.5}`, err: "body.owner_id must be an integer"},
+		{name: "null", op: add, body: `{"name":null}`, err: "body.name must not be null"},
+		{name: "map values", op: add, body: `{"name":"rex","tags":{"color":1}}`, err: "body.tags.color must be a string"},
+		{name: "query", op: list, query: "limit=10"},
+		{name: "query type", op: list, query: "limit=ten", err: "limit must be an integer"},
+		{name: "query minimum", op: list, query: "limit=0", err: "limit must be at least 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			err = spec.ValidateRequest(tt.op, query, []byte(tt.body))
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	code, err := Generate(parseTestSpec(t), "pets", "pets.json")
+	require.NoError(t, err)
+	out := string(code)
+
+	assert.True(t, strings.HasPrefix(out, "// Code generated by client-gen from pets.json. DO NOT EDIT.\n"))
+	for _, want := range []string{
+		"// Pet: A pet\ntype Pet struct {",
+		"Name      string            `json:\"name\"`",
+		"OwnerID   int64             `json:\"owner_id,omitempty\"` // Who feeds it",
+		"Tags      map[string]string `json:\"tags,omitempty\"`",
+		"AdoptedAt time.Time         `json:\"adopted_at,omitempty\"`",
+		"Address   string            `json:\"ip,omitempty\"`",
+		"// ListPetsParams are the query parameters of GET /api/pets",
+		"func (c *Client) ListPets(ctx context.Context, params *ListPetsParams, opts ...RequestOption) ([]Pet, *Response, error) {",
+		"query.Set(\"limit\", strconv.Itoa(params.Limit))",
+		"// AddPet adds or replaces a pet\n//\n// POST /api/pets",
+		"func (c *Client) AddPet(ctx context.Context, body *Pet, opts ...RequestOption) (*Pet, *Response, error) {",
+		"func (c *Client) RemovePet(ctx context.Context, name string, opts ...RequestOption) (*Response, error) {",
+		"c.do(ctx, \"DELETE\", \"/api/pets/\"+url.PathEscape(name), nil, nil, nil, opts)",
+	} {
+		assert.Contains(t, out, want)
+	}
+
+	// Operations that don't answer with JSON get no method
+	assert.NotContains(t, out, "GetMetrics")
+}
@@ -0,0 +1,284 @@
+// Package openapi reads the proxy's OpenAPI specification, validates requests
+// against it and generates the Go client in pkg/client. It supports the
+// subset of OpenAPI 3.0 the specification uses.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Spec is an OpenAPI 3.0 document
+type Spec struct {
+	OpenAPI    string               `json:"openapi"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components struct {
+		Schemas    map[string]*Schema    `json:"schemas"`
+		Parameters map[string]*Parameter `json:"parameters"`
+	} `json:"components"`
+
+	schemaOrder []string // Schema names in document order
+}
+
+// PathItem holds the operations of one path
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters"`
+	Get        *Operation   `json:"get"`
+	Put        *Operation   `json:"put"`
+	Post       *Operation   `json:"post"`
+	Delete     *Operation   `json:"delete"`
+	Patch      *Operation   `json:"patch"`
+}
+
+// operations returns the path's operations by HTTP method
+func (p *PathItem) operations() map[string]*Operation {
+	ops := map[string]*Operation{"GET": p.Get, "PUT": p.Put, "POST": p.Post, "DELETE": p.Delete, "PATCH": p.Patch}
+	for method, op := range ops {
+		if op == nil {
+			delete(ops, method)
+		}
+	}
+	return ops
+}
+
+// Operation is one method on one path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+
+	// Set by Parse
+	Method string       `json:"-"`
+	Path   string       `json:"-"`
+	Params []*Parameter `json:"-"` // Path item and operation parameters, references resolved
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Ref         string  `json:"$ref"`
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is an operation's JSON body
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is one of an operation's responses
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// MediaType holds the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema as used by OpenAPI 3.0
+type Schema struct {
+	Ref                  string      `json:"$ref"`
+	Type                 string      `json:"type"`
+	Format               string      `json:"format"`
+	Description          string      `json:"description"`
+	Nullable             bool        `json:"nullable"`
+	Enum                 []string    `json:"enum"`
+	Required             []string    `json:"required"`
+	Properties           Properties  `json:"properties"`
+	AdditionalProperties *Additional `json:"additionalProperties"`
+	Items                *Schema     `json:"items"`
+	Minimum              *float64    `json:"minimum"`
+	Maximum              *float64    `json:"maximum"`
+	MinLength            *int        `json:"minLength"`
+	MaxLength            *int        `json:"maxLength"`
+	GoName               string      `json:"x-go-name"` // Overrides the generated Go name
+}
+
+// Properties are an object's properties in document order, the generated
+// structs list their fields in the same order
+type Properties struct {
+	Names   []string
+	Schemas map[string]*Schema
+}
+
+// UnmarshalJSON keeps the order of the properties
+func (p *Properties) UnmarshalJSON(data []byte) error {
+	p.Schemas = make(map[string]*Schema)
+	names, err := objectKeys(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &p.Schemas); err != nil {
+		return err
+	}
+	p.Names = names
+	return nil
+}
+
+// Additional is additionalProperties: false, or the schema of a map's values
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalJSON reads a boolean or a schema
+func (a *Additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// objectKeys returns the keys of a JSON object in order
+func objectKeys(data []byte) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, token.(string))
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Parse reads a specification and resolves its parameter references
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI specification: %w", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.0") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", spec.OpenAPI)
+	}
+
+	var raw struct {
+		Components struct {
+			Schemas json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if len(raw.Components.Schemas) > 0 {
+		names, err := objectKeys(raw.Components.Schemas)
+		if err != nil {
+			return nil, err
+		}
+		spec.schemaOrder = names
+	}
+
+	for path, item := range spec.Paths {
+		for method, op := range item.operations() {
+			op.Method, op.Path = method, path
+			for _, param := range append(append([]*Parameter{}, item.Parameters...), op.Parameters...) {
+				resolved, err := spec.parameter(param)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+				op.Params = append(op.Params, resolved)
+			}
+		}
+	}
+	return &spec, nil
+}
+
+// parameter resolves a parameter reference
+func (s *Spec) parameter(param *Parameter) (*Parameter, error) {
+	if param.Ref == "" {
+		return param, nil
+	}
+	resolved := s.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+	if resolved == nil {
+		return nil, fmt.Errorf("unknown parameter %s", param.Ref)
+	}
+	return resolved, nil
+}
+
+// resolve follows a schema reference
+func (s *Spec) resolve(schema *Schema) (*Schema, error) {
+	if schema == nil || schema.Ref == "" {
+		return schema, nil
+	}
+	resolved := s.Components.Schemas[refName(schema.Ref)]
+	if resolved == nil {
+		return nil, fmt.Errorf("unknown schema %s", schema.Ref)
+	}
+	return resolved, nil
+}
+
+// refName is the schema name of a reference such as #/components/schemas/Host
+func refName(ref string) string {
+	return strings.TrimPrefix(ref, "#/components/schemas/")
+}
+
+// Operations returns every operation, sorted by path and method
+func (s *Spec) Operations() []*Operation {
+	var ops []*Operation
+	for _, item := range s.Paths {
+		for _, op := range item.operations() {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Path != ops[j].Path {
+			return ops[i].Path < ops[j].Path
+		}
+		return ops[i].Method < ops[j].Method
+	})
+	return ops
+}
+
+// Find returns the operation serving a request path, nil if there is none
+func (s *Spec) Find(method, path string) *Operation {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for template, item := range s.Paths {
+		op := item.operations()[method]
+		if op == nil {
+			continue
+		}
+		parts := strings.Split(strings.Trim(template, "/"), "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, part := range parts {
+			isParam := strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")
+			if (isParam && segments[i] == "") || (!isParam && part != segments[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return op
+		}
+	}
+	return nil
+}
+
+// jsonBody returns the schema of an operation's JSON request body, nil if it takes none
+func (op *Operation) jsonBody() *Schema {
+	if op.RequestBody == nil || op.RequestBody.Content["application/json"] == nil {
+		return nil
+	}
+	return op.RequestBody.Content["application/json"].Schema
+}
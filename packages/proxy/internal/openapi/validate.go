@@ -0,0 +1,180 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateRequest checks an operation's query parameters and JSON body
+// against the specification. Path and header parameters are left to the
+// handlers.
+func (s *Spec) ValidateRequest(op *Operation, query url.Values, body []byte) error {
+	for _, param := range op.Params {
+		if param.In != "query" {
+			continue
+		}
+		value := query.Get(param.Name)
+		if value == "" {
+			if param.Required {
+				return fmt.Errorf("missing query parameter %s", param.Name)
+			}
+			continue
+		}
+		if err := s.validateParameter(param, value); err != nil {
+			return err
+		}
+	}
+
+	schema := op.jsonBody()
+	if schema == nil {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return fmt.Errorf("missing JSON body")
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return s.Validate(schema, value, "body")
+}
+
+// validateParameter checks a query parameter's value
+func (s *Spec) validateParameter(param *Parameter, value string) error {
+	var typed interface{} = value
+	if param.Schema != nil && (param.Schema.Type == "integer" || param.Schema.Type == "number") {
+		typed = json.Number(value)
+	}
+	return s.Validate(param.Schema, typed, param.Name)
+}
+
+// Validate checks a value decoded with json.Decoder.UseNumber against a
+// schema. The error names the offending field, e.g. body.projects.blog.target.
+func (s *Spec) Validate(schema *Schema, value interface{}, path string) error {
+	schema, err := s.resolve(schema)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s must not be null", path)
+	}
+
+	switch schema.Type {
+	case "":
+		// Any value
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		return s.validateObject(schema, object, path)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		for i, item := range array {
+			if err := s.Validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		if schema.MinLength != nil && len(text) < *schema.MinLength {
+			return fmt.Errorf("%s must not be shorter than %d characters", path, *schema.MinLength)
+		}
+		if schema.MaxLength != nil && len(text) > *schema.MaxLength {
+			return fmt.Errorf("%s must not be longer than %d characters", path, *schema.MaxLength)
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, text) {
+			return fmt.Errorf("%s must be one of %s, got %q", path, strings.Join(schema.Enum, ", "), text)
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s must be a number", path)
+		}
+		if schema.Type == "integer" {
+			if _, err := strconv.ParseInt(string(number), 10, 64); err != nil {
+				return fmt.Errorf("%s must be an integer", path)
+			}
+		}
+		n, err := number.Float64()
+		if err != nil {
+			return fmt.Errorf("%s must be a number", path)
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			return fmt.Errorf("%s must be at least %g", path, *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			return fmt.Errorf("%s must be at most %g", path, *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	default:
+		return fmt.Errorf("%s has unsupported schema type %s", path, schema.Type)
+	}
+	return nil
+}
+
+// validateObject checks an object's required, known and extra properties
+func (s *Spec) validateObject(schema *Schema, object map[string]interface{}, path string) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s.%s is required", path, name)
+		}
+	}
+
+	// Sorted so the same request always reports the same error
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property := schema.Properties.Schemas[name]
+		switch {
+		case property != nil:
+		case schema.AdditionalProperties == nil:
+			continue
+		case !schema.AdditionalProperties.Allowed:
+			return fmt.Errorf("%s has unknown field %s", path, name)
+		default:
+			property = schema.AdditionalProperties.Schema
+		}
+		if err := s.Validate(property, object[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,325 @@
+package ports
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	// udpSessionTimeout closes UDP sessions that have been quiet this long
+	udpSessionTimeout = 2 * time.Minute
+	maxDatagramSize   = 64 * 1024
+)
+
+// ReservedPorts are used by the proxy itself and can't be forwarded
+var ReservedPorts = map[int]bool{80: true, 443: true, 8080: true}
+
+// ValidateRule checks that a forwarding rule can be applied
+func ValidateRule(rule *state.PortForward) error {
+	if rule.Listen < 1 || rule.Listen > 65535 {
+		return fmt.Errorf("invalid port %d", rule.Listen)
+	}
+	if ReservedPorts[rule.Listen] {
+		return fmt.Errorf("port %d is used by the proxy", rule.Listen)
+	}
+	if rule.Protocol != "tcp" && rule.Protocol != "udp" {
+		return fmt.Errorf("unknown protocol %q, expected tcp or udp", rule.Protocol)
+	}
+	if _, _, err := net.SplitHostPort(rule.Target); err != nil {
+		return fmt.Errorf("invalid target %s, expected container:port", rule.Target)
+	}
+	if _, err := ParseAllowList(rule.Allow); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseAllowList converts IPs and CIDRs to networks. Plain IPs match only themselves.
+func ParseAllowList(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allowlist entry %q, expected an IP or CIDR", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q, expected an IP or CIDR", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Manager runs a listener for each forwarding rule in state
+type Manager struct {
+	state *state.State
+	host  string // Interface to listen on, empty for all
+
+	mu         sync.Mutex
+	forwarders map[string]*forwarder
+}
+
+// NewManager creates a port forwarding manager. Call Sync to start listening.
+func NewManager(st *state.State) *Manager {
+	return &Manager{
+		state:      st,
+		forwarders: make(map[string]*forwarder),
+	}
+}
+
+// Sync starts listeners for new rules, restarts changed ones and stops removed
+// ones. Existing connections are kept until they close. Returns the rules that
+// failed to start, for example because the port is in use.
+func (m *Manager) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]state.PortForward)
+	for _, rule := range m.state.GetPortForwards() {
+		wanted[rule.Key()] = rule
+	}
+
+	for key, f := range m.forwarders {
+		if rule, ok := wanted[key]; !ok || !sameRule(rule, f.rule) {
+			log.Printf("[PORTS] Stopping %s -> %s", key, f.rule.Target)
+			f.stop()
+			delete(m.forwarders, key)
+		}
+	}
+
+	var errs []error
+	for key, rule := range wanted {
+		if _, running := m.forwarders[key]; running {
+			continue
+		}
+		f, err := m.start(rule)
+		if err != nil {
+			log.Printf("[PORTS] Failed to forward %s: %v", key, err)
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		log.Printf("[PORTS] Forwarding %s -> %s", key, rule.Target)
+		m.forwarders[key] = f
+	}
+
+	return errors.Join(errs...)
+}
+
+// Stop closes all listeners
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, f := range m.forwarders {
+		f.stop()
+		delete(m.forwarders, key)
+	}
+}
+
+func (m *Manager) start(rule state.PortForward) (*forwarder, error) {
+	allow, err := ParseAllowList(rule.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &forwarder{rule: rule, allow: allow, sessions: make(map[string]*udpSession)}
+	addr := net.JoinHostPort(m.host, fmt.Sprint(rule.Listen))
+
+	if rule.Protocol == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		f.closer = conn
+		go f.serveUDP(conn)
+	} else {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		f.closer = ln
+		go f.serveTCP(ln)
+	}
+
+	return f, nil
+}
+
+func sameRule(a, b state.PortForward) bool {
+	return a.Target == b.Target && strings.Join(a.Allow, ",") == strings.Join(b.Allow, ",")
+}
+
+// forwarder serves a single rule
+type forwarder struct {
+	rule   state.PortForward
+	allow  []*net.IPNet
+	closer io.Closer
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession // UDP sessions by client address
+}
+
+func (f *forwarder) stop() {
+	f.closer.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for addr, session := range f.sessions {
+		session.conn.Close()
+		delete(f.sessions, addr)
+	}
+}
+
+// allowed reports whether a client may use the rule
+func (f *forwarder) allowed(addr net.Addr) bool {
+	if len(f.allow) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range f.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *forwarder) serveTCP(ln net.Listener) {
+	for {
+		client, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[PORTS] %s accept error: %v", f.rule.Key(), err)
+			}
+			return
+		}
+
+		if !f.allowed(client.RemoteAddr()) {
+			log.Printf("[PORTS] %s refused %s (not in allowlist)", f.rule.Key(), client.RemoteAddr())
+			client.Close()
+			continue
+		}
+
+		go f.pipeTCP(client)
+	}
+}
+
+// pipeTCP copies bytes between the client and the target until either side closes
+func (f *forwarder) pipeTCP(client net.Conn) {
+	defer client.Close()
+
+	backend, err := net.DialTimeout("tcp", f.rule.Target, dialTimeout)
+	if err != nil {
+		log.Printf("[PORTS] %s dial %s failed: %v", f.rule.Key(), f.rule.Target, err)
+		return
+	}
+	defer backend.Close()
+
+	errChan := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backend, client)
+		errChan <- err
+	}()
+	go func() {
+		_, err := io.Copy(client, backend)
+		errChan <- err
+	}()
+
+	// Wait for one direction to close
+	<-errChan
+}
+
+// udpSession relays datagrams between one client and the target
+type udpSession struct {
+	conn net.Conn
+}
+
+func (f *forwarder) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[PORTS] %s read error: %v", f.rule.Key(), err)
+			}
+			return
+		}
+
+		if !f.allowed(client) {
+			continue
+		}
+
+		session, err := f.udpSession(conn, client)
+		if err != nil {
+			log.Printf("[PORTS] %s dial %s failed: %v", f.rule.Key(), f.rule.Target, err)
+			continue
+		}
+		session.conn.Write(buf[:n])
+	}
+}
+
+// udpSession returns the session for a client, creating it on its first datagram
+func (f *forwarder) udpSession(listener net.PacketConn, client net.Addr) (*udpSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if session, ok := f.sessions[client.String()]; ok {
+		return session, nil
+	}
+
+	backend, err := net.DialTimeout("udp", f.rule.Target, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &udpSession{conn: backend}
+	f.sessions[client.String()] = session
+	go f.relayReplies(listener, client, session)
+	return session, nil
+}
+
+// relayReplies sends the target's datagrams back to the client until the session goes quiet
+func (f *forwarder) relayReplies(listener net.PacketConn, client net.Addr, session *udpSession) {
+	defer func() {
+		f.mu.Lock()
+		if f.sessions[client.String()] == session {
+			delete(f.sessions, client.String())
+		}
+		f.mu.Unlock()
+		session.conn.Close()
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		session.conn.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+		n, err := session.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := listener.WriteTo(buf[:n], client); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package ports
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// freePort returns a port that is currently free for the protocol
+func freePort(t *testing.T, protocol string) int {
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func newTestManager(t *testing.T) (*Manager, *state.State) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	m := NewManager(st)
+	m.host = "127.0.0.1"
+	t.Cleanup(m.Stop)
+	return m, st
+}
+
+func TestValidateRule(t *testing.T) {
+	valid := &state.PortForward{Listen: 5432, Protocol: "tcp", Target: "postgres:5432", Allow: []string{"10.0.0.0/8", "203.0.113.7"}}
+	assert.NoError(t, ValidateRule(valid))
+
+	for _, rule := range []*state.PortForward{
+		{Listen: 0, Protocol: "tcp", Target: "db:5432"},
+		{Listen: 443, Protocol: "tcp", Target: "db:5432"},
+		{Listen: 5432, Protocol: "sctp", Target: "db:5432"},
+		{Listen: 5432, Protocol: "tcp", Target: "db"},
+		{Listen: 5432, Protocol: "tcp", Target: "db:5432", Allow: []string{"office"}},
+	} {
+		assert.Error(t, ValidateRule(rule), rule.Key())
+	}
+}
+
+func TestTCPForwarding(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	m, st := newTestManager(t)
+	port := freePort(t, "tcp")
+	st.SetPortForward(&state.PortForward{Listen: port, Protocol: "tcp", Target: backend.Addr().String()})
+	require.NoError(t, m.Sync())
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+	conn.Close()
+
+	// Clients outside the allowlist are disconnected
+	st.SetPortForward(&state.PortForward{Listen: port, Protocol: "tcp", Target: backend.Addr().String(), Allow: []string{"10.0.0.0/8"}})
+	require.NoError(t, m.Sync())
+
+	conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(reply)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Removed rules stop listening
+	require.NoError(t, st.RemovePortForward(port, "tcp"))
+	require.NoError(t, m.Sync())
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.Error(t, err)
+}
+
+func TestUDPForwarding(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	m, st := newTestManager(t)
+	port := freePort(t, "udp")
+	st.SetPortForward(&state.PortForward{Listen: port, Protocol: "udp", Target: backend.LocalAddr().String(), Allow: []string{"127.0.0.1"}})
+	require.NoError(t, m.Sync())
+
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, message := range []string{"first", "second"} {
+		_, err = conn.Write([]byte(message))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, message, string(buf[:n]))
+	}
+}
+
+func TestSyncReportsBindFailures(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	m, st := newTestManager(t)
+	st.SetPortForward(&state.PortForward{Listen: taken.Addr().(*net.TCPAddr).Port, Protocol: "tcp", Target: "db:5432"})
+	assert.Error(t, m.Sync())
+}
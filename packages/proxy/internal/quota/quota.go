@@ -0,0 +1,248 @@
+// Package quota measures what projects run on this server and checks deploys
+// and autoscaling against the containers, memory and CPUs their quota allows
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// ErrExceeded is wrapped by the errors of deploys that don't fit a quota
+var ErrExceeded = errors.New("quota exceeded")
+
+// Docker is the part of the Docker Engine API quotas are measured with
+type Docker interface {
+	Containers(ctx context.Context, label string) ([]docker.Container, error)
+	Limits(ctx context.Context, id string) (docker.Limits, error)
+}
+
+// Container is the limits of one container. Zero means unlimited.
+type Container struct {
+	Memory int64   `json:"memory,omitempty"` // Bytes
+	CPUs   float64 `json:"cpus,omitempty"`
+}
+
+// Workload is the containers a deploy runs for one service, including sidecars
+type Workload struct {
+	Service    string      `json:"service"`
+	Containers []Container `json:"containers"`
+}
+
+// Usage is what a project runs on this server: its running containers and
+// the sum of their limits
+type Usage struct {
+	Project    string  `json:"project"`
+	Containers int     `json:"containers"`
+	Memory     int64   `json:"memory"` // Bytes
+	CPUs       float64 `json:"cpus"`
+	// Containers without a memory or CPU limit, which count as using none
+	Unlimited int `json:"unlimited,omitempty"`
+}
+
+func (u *Usage) add(container Container) {
+	u.Containers++
+	u.Memory += container.Memory
+	u.CPUs += container.CPUs
+	if container.Memory == 0 || container.CPUs == 0 {
+		u.Unlimited++
+	}
+}
+
+// Checker checks projects against the quotas in state
+type Checker struct {
+	state  *state.State
+	docker Docker
+}
+
+// New creates a checker for the quotas in state
+func New(st *state.State, docker Docker) *Checker {
+	return &Checker{state: st, docker: docker}
+}
+
+// Validate checks that a quota caps something and nothing below zero
+func Validate(quota *state.Quota) error {
+	if quota.Project == "" {
+		return fmt.Errorf("project is required")
+	}
+	if quota.MaxContainers < 0 || quota.MaxMemory < 0 || quota.MaxCPUs < 0 {
+		return fmt.Errorf("quota limits can't be negative")
+	}
+	if quota.MaxContainers == 0 && quota.MaxMemory == 0 && quota.MaxCPUs == 0 {
+		return fmt.Errorf("at least one of max containers, memory or CPUs is required")
+	}
+	return nil
+}
+
+// Usage measures what a project currently runs
+func (c *Checker) Usage(ctx context.Context, project string) (Usage, error) {
+	return c.measure(ctx, project, nil)
+}
+
+// Check returns the usage a project would have once a deploy replaced the
+// containers of the workloads' services, and an error wrapping ErrExceeded
+// if that is more than its quota allows. Under a memory or CPU quota every
+// new container needs that limit, or the quota couldn't hold.
+func (c *Checker) Check(ctx context.Context, project string, workloads []Workload) (Usage, error) {
+	replaced := make(map[string]bool, len(workloads))
+	for _, workload := range workloads {
+		replaced[workload.Service] = true
+	}
+	usage, err := c.measure(ctx, project, replaced)
+	if err != nil {
+		return Usage{}, err
+	}
+	for _, workload := range workloads {
+		for _, container := range workload.Containers {
+			usage.add(container)
+		}
+	}
+
+	quota := c.state.GetQuota(project)
+	if quota == nil {
+		return usage, nil
+	}
+	for _, workload := range workloads {
+		for _, container := range workload.Containers {
+			if quota.MaxMemory > 0 && container.Memory == 0 {
+				return usage, fmt.Errorf("%w: %s needs a memory limit, project %s has a memory quota", ErrExceeded, workload.Service, project)
+			}
+			if quota.MaxCPUs > 0 && container.CPUs == 0 {
+				return usage, fmt.Errorf("%w: %s needs a CPU limit, project %s has a CPU quota", ErrExceeded, workload.Service, project)
+			}
+		}
+	}
+	return usage, exceeds(quota, usage)
+}
+
+// Room returns how many of count more copies of a container, each with its
+// sidecars, fit in its project's quota
+func (c *Checker) Room(ctx context.Context, project string, container docker.Container, count int) (int, error) {
+	quota := c.state.GetQuota(project)
+	if quota == nil {
+		return count, nil
+	}
+	usage, err := c.measure(ctx, project, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	copies := []docker.Container{container}
+	sidecars, err := c.docker.Containers(ctx, docker.SidecarOfLabel+"="+container.Name)
+	if err != nil {
+		return 0, err
+	}
+	copies = append(copies, sidecars...)
+	var replica []Container
+	for _, original := range copies {
+		limits, err := c.docker.Limits(ctx, original.ID)
+		if err != nil {
+			return 0, err
+		}
+		replica = append(replica, Container{Memory: limits.Memory, CPUs: limits.CPUs})
+	}
+
+	for room := 0; room < count; room++ {
+		for _, limits := range replica {
+			usage.add(limits)
+		}
+		if exceeds(quota, usage) != nil {
+			return room, nil
+		}
+	}
+	return count, nil
+}
+
+// measure sums the limits of a project's running containers, leaving out
+// those of the given services and their sidecars
+func (c *Checker) measure(ctx context.Context, project string, skip map[string]bool) (Usage, error) {
+	containers, err := c.docker.Containers(ctx, "iop.project="+project)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	skipped := make(map[string]bool)
+	for _, container := range containers {
+		if skip[serviceOf(container)] {
+			skipped[container.Name] = true
+		}
+	}
+
+	usage := Usage{Project: project}
+	for _, container := range containers {
+		if skipped[container.Name] || skipped[container.Labels[docker.SidecarOfLabel]] {
+			continue
+		}
+		limits, err := c.docker.Limits(ctx, container.ID)
+		if err != nil {
+			return Usage{}, err
+		}
+		usage.add(Container{Memory: limits.Memory, CPUs: limits.CPUs})
+	}
+	return usage, nil
+}
+
+// serviceOf returns the service a container was deployed for, "" for sidecars
+func serviceOf(container docker.Container) string {
+	if app := container.Labels["iop.app"]; app != "" {
+		return app
+	}
+	return container.Labels["iop.service"]
+}
+
+// exceeds describes each limit of the quota the usage is above
+func exceeds(quota *state.Quota, usage Usage) error {
+	var over []string
+	if quota.MaxContainers > 0 && usage.Containers > quota.MaxContainers {
+		over = append(over, fmt.Sprintf("%d containers (quota %d)", usage.Containers, quota.MaxContainers))
+	}
+	if quota.MaxMemory > 0 && usage.Memory > quota.MaxMemory {
+		over = append(over, fmt.Sprintf("%s memory (quota %s)", FormatBytes(usage.Memory), FormatBytes(quota.MaxMemory)))
+	}
+	// Rounded, so e.g. ten 0.1 CPU containers fit a quota of 1 CPU
+	if quota.MaxCPUs > 0 && math.Round(usage.CPUs*1000) > math.Round(quota.MaxCPUs*1000) {
+		over = append(over, fmt.Sprintf("%g CPUs (quota %g)", math.Round(usage.CPUs*1000)/1000, quota.MaxCPUs))
+	}
+	if len(over) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: project %s would use %s", ErrExceeded, quota.Project, strings.Join(over, ", "))
+}
+
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)([bkmg]?)$`)
+
+// ParseSize parses a size the way docker run --memory does, e.g. "512m" or "4g"
+func ParseSize(size string) (int64, error) {
+	match := sizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(size)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 512m or 4g", size)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", size, err)
+	}
+	multiplier := map[string]float64{"": 1, "b": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30}[match[2]]
+	return int64(value * multiplier), nil
+}
+
+// FormatBytes formats a byte count with binary units, e.g. 512MiB
+func FormatBytes(bytes int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value == math.Trunc(value) {
+		return fmt.Sprintf("%.0f%s", value, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
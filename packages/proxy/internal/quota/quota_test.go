@@ -0,0 +1,126 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDocker struct {
+	containers []docker.Container
+	limits     map[string]docker.Limits
+}
+
+// Containers supports the label=value filters the checker uses
+func (f *fakeDocker) Containers(ctx context.Context, label string) ([]docker.Container, error) {
+	key, value, _ := strings.Cut(label, "=")
+	var matched []docker.Container
+	for _, c := range f.containers {
+		if c.Labels[key] == value {
+			matched = append(matched, c)
+		}
+	}
+	return matched, nil
+}
+
+func (f *fakeDocker) Limits(ctx context.Context, id string) (docker.Limits, error) {
+	return f.limits[id], nil
+}
+
+func (f *fakeDocker) add(name string, labels map[string]string, limits docker.Limits) {
+	labels["iop.project"] = "shop"
+	f.containers = append(f.containers, docker.Container{ID: "id-" + name, Name: name, Labels: labels})
+	f.limits["id-"+name] = limits
+}
+
+// server runs shop's web app with a log shipping sidecar and its postgres
+// service, each with 512MiB and half a CPU
+func server(t *testing.T) (*Checker, *state.State) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	f := &fakeDocker{limits: map[string]docker.Limits{}}
+	half := docker.Limits{Memory: 512 << 20, CPUs: 0.5}
+	f.add("shop-web-blue", map[string]string{"iop.type": "service", "iop.app": "web"}, half)
+	f.add("shop-web-blue-logs", map[string]string{"iop.type": "sidecar", docker.SidecarOfLabel: "shop-web-blue"}, half)
+	f.add("shop-postgres", map[string]string{"iop.service": "postgres"}, half)
+	f.add("blog-web-blue", map[string]string{"iop.app": "web"}, half)
+	f.containers[3].Labels["iop.project"] = "blog"
+	return New(st, f), st
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(&state.Quota{Project: "shop", MaxContainers: 4}))
+	assert.Error(t, Validate(&state.Quota{MaxContainers: 4}))
+	assert.Error(t, Validate(&state.Quota{Project: "shop"}))
+	assert.Error(t, Validate(&state.Quota{Project: "shop", MaxMemory: -1, MaxCPUs: 2}))
+}
+
+func TestUsage(t *testing.T) {
+	c, _ := server(t)
+	usage, err := c.Usage(context.Background(), "shop")
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Project: "shop", Containers: 3, Memory: 1536 << 20, CPUs: 1.5}, usage)
+}
+
+func TestCheckReplacesDeployedServices(t *testing.T) {
+	c, st := server(t)
+	st.SetQuota(&state.Quota{Project: "shop", MaxContainers: 3, MaxMemory: 2 << 30, MaxCPUs: 2})
+	ctx := context.Background()
+
+	// The web app and its sidecar are replaced, not added to
+	web := Workload{Service: "web", Containers: []Container{{Memory: 512 << 20, CPUs: 0.5}, {Memory: 512 << 20, CPUs: 0.5}}}
+	usage, err := c.Check(ctx, "shop", []Workload{web})
+	require.NoError(t, err)
+	assert.Equal(t, 3, usage.Containers)
+	assert.Equal(t, int64(1536<<20), usage.Memory)
+
+	// A second replica doesn't fit
+	web.Containers = append(web.Containers, web.Containers...)
+	_, err = c.Check(ctx, "shop", []Workload{web})
+	require.True(t, errors.Is(err, ErrExceeded))
+	assert.Contains(t, err.Error(), "project shop would use 5 containers (quota 3), 2.5GiB memory (quota 2GiB), 2.5 CPUs (quota 2)")
+
+	// Under a memory quota new containers need a memory limit
+	_, err = c.Check(ctx, "shop", []Workload{{Service: "web", Containers: []Container{{CPUs: 0.5}}}})
+	require.True(t, errors.Is(err, ErrExceeded))
+	assert.Contains(t, err.Error(), "web needs a memory limit")
+
+	// Projects without a quota always fit
+	usage, err = c.Check(ctx, "blog", []Workload{{Service: "web", Containers: []Container{{}, {}}}})
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Project: "blog", Containers: 2, Unlimited: 2}, usage)
+}
+
+func TestRoom(t *testing.T) {
+	c, st := server(t)
+	ctx := context.Background()
+	web := docker.Container{ID: "id-shop-web-blue", Name: "shop-web-blue"}
+
+	room, err := c.Room(ctx, "shop", web, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 5, room)
+
+	// Each replica brings its sidecar, one CPU in all
+	st.SetQuota(&state.Quota{Project: "shop", MaxCPUs: 3.5})
+	room, err = c.Room(ctx, "shop", web, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 2, room)
+}
+
+func TestParseSize(t *testing.T) {
+	for size, bytes := range map[string]int64{"512m": 512 << 20, "4g": 4 << 30, "1.5G": 3 << 29, "100k": 100 << 10, "1024": 1024} {
+		parsed, err := ParseSize(size)
+		require.NoError(t, err, size)
+		assert.Equal(t, bytes, parsed, size)
+	}
+	_, err := ParseSize("4gb")
+	assert.Error(t, err)
+	assert.Equal(t, "512MiB", FormatBytes(512<<20))
+	assert.Equal(t, "1.5GiB", FormatBytes(3<<29))
+}
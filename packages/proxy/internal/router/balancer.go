@@ -0,0 +1,86 @@
+package router
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// balancer dials the address with the fewest open connections among those a
+// target's hostname resolves to. Replicas of an app share its network alias,
+// so new connections go to the least busy replica instead of whichever
+// address the resolver listed first.
+type balancer struct {
+	dialer *net.Dialer
+	lookup func(ctx context.Context, host string) ([]string, error)
+
+	mu   sync.Mutex
+	open map[string]int // Open connections per address
+}
+
+func newBalancer(dialer *net.Dialer) *balancer {
+	return &balancer{
+		dialer: dialer,
+		lookup: net.DefaultResolver.LookupHost,
+		open:   make(map[string]int),
+	}
+}
+
+// DialContext connects to the least busy address of the target
+func (b *balancer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return b.dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := b.lookup(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return b.dialer.DialContext(ctx, network, address)
+	}
+
+	addr := b.pick(addrs)
+	conn, err := b.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+	if err != nil {
+		b.release(addr)
+		return nil, err
+	}
+	return &balancedConn{Conn: conn, release: func() { b.release(addr) }}, nil
+}
+
+// pick returns the address with the fewest open connections and counts the
+// one about to be opened
+func (b *balancer) pick(addrs []string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best := addrs[0]
+	for _, addr := range addrs[1:] {
+		if b.open[addr] < b.open[best] {
+			best = addr
+		}
+	}
+	b.open[best]++
+	return best
+}
+
+// release forgets a closed connection
+func (b *balancer) release(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.open[addr]--
+	if b.open[addr] <= 0 {
+		delete(b.open, addr)
+	}
+}
+
+// balancedConn releases its address once closed
+type balancedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *balancedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,57 @@
+package router
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalancerPrefersLeastConnections(t *testing.T) {
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer first.Close()
+	_, port, _ := net.SplitHostPort(first.Addr().String())
+	second, err := net.Listen("tcp", "127.0.0.2:"+port)
+	if err != nil {
+		t.Skipf("can't listen on 127.0.0.2: %v", err)
+	}
+	defer second.Close()
+
+	b := newBalancer(&net.Dialer{})
+	b.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1", "127.0.0.2"}, nil
+	}
+
+	dial := func() net.Conn {
+		conn, err := b.DialContext(context.Background(), "tcp", net.JoinHostPort("blog-web", port))
+		require.NoError(t, err)
+		return conn
+	}
+	remote := func(conn net.Conn) string {
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		return host
+	}
+
+	conns := []net.Conn{dial(), dial(), dial(), dial()}
+	var hosts []string
+	for _, conn := range conns {
+		hosts = append(hosts, remote(conn))
+	}
+	assert.ElementsMatch(t, []string{"127.0.0.1", "127.0.0.1", "127.0.0.2", "127.0.0.2"}, hosts)
+
+	// A closed connection frees up its address for the next dial
+	closed := remote(conns[0])
+	require.NoError(t, conns[0].Close())
+	conns[0].Close()
+	next := dial()
+	assert.Equal(t, closed, remote(next))
+
+	next.Close()
+	for _, conn := range conns[1:] {
+		conn.Close()
+	}
+	assert.Empty(t, b.open)
+}
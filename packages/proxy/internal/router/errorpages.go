@@ -0,0 +1,149 @@
+package router
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// RequestIDHeader carries the ID shown on error pages, so a visitor's report
+// can be matched to the proxy's log line. A client's own ID is kept.
+const RequestIDHeader = "X-Request-ID"
+
+// maxErrorPageSize bounds an error page template
+const maxErrorPageSize = 64 << 10
+
+// errorPageStatuses are the responses the router produces itself, and so the
+// ones an error page can replace
+var errorPageStatuses = map[string]bool{
+	"404": true, // Unknown host
+	"502": true, // Backend unreachable
+	"503": true, // Unhealthy, starting or at its concurrency limit
+	"504": true, // Backend timed out
+}
+
+// ErrorPageData is what an error page template can show
+type ErrorPageData struct {
+	Status     int    // e.g. 503
+	StatusText string // e.g. "Service Unavailable"
+	Host       string
+	Path       string
+	Message    string // Why the request failed
+	RequestID  string
+	RetryAfter int // Seconds before retrying is worthwhile, 0 if unknown
+}
+
+// ValidateErrorPages checks that error pages are for statuses the router
+// serves and that their templates parse
+func ValidateErrorPages(pages map[string]string) error {
+	for status, page := range pages {
+		if !errorPageStatuses[status] {
+			return fmt.Errorf("no error page for status %s, only 404, 502, 503 and 504 are served by the proxy", status)
+		}
+		if len(page) > maxErrorPageSize {
+			return fmt.Errorf("error page for %s is larger than %d bytes", status, maxErrorPageSize)
+		}
+		if _, err := template.New(status).Parse(page); err != nil {
+			return fmt.Errorf("error page for %s: %w", status, err)
+		}
+	}
+	return nil
+}
+
+// errorPages caches parsed error page templates by their source
+type errorPages struct {
+	mu        sync.Mutex
+	templates map[string]*template.Template
+}
+
+func newErrorPages() *errorPages {
+	return &errorPages{templates: make(map[string]*template.Template)}
+}
+
+// parse returns the template for a page, parsing it on first use
+func (e *errorPages) parse(page string) (*template.Template, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if tmpl, ok := e.templates[page]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := template.New("error").Parse(page)
+	if err != nil {
+		return nil, err
+	}
+	// Replaced pages stay cached, drop them all once there are too many
+	if len(e.templates) >= 100 {
+		e.templates = make(map[string]*template.Template)
+	}
+	e.templates[page] = tmpl
+	return tmpl, nil
+}
+
+// serveError answers a request the router can't route. Browsers get the
+// host's error page for the status, or the global one, and everyone else a
+// plain text body. host is nil for requests that match no host.
+func (r *Router) serveError(w http.ResponseWriter, req *http.Request, host *state.Host, status int, message string, retryAfter int) {
+	requestID := req.Header.Get(RequestIDHeader)
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, requestID)
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+
+	if page := r.errorPageFor(host, status); page != "" && strings.Contains(req.Header.Get("Accept"), "text/html") {
+		data := ErrorPageData{
+			Status:     status,
+			StatusText: http.StatusText(status),
+			Host:       req.Host,
+			Path:       req.URL.Path,
+			Message:    message,
+			RequestID:  requestID,
+			RetryAfter: retryAfter,
+		}
+		var body bytes.Buffer
+		tmpl, err := r.errorPages.parse(page)
+		if err == nil {
+			err = tmpl.Execute(&body, data)
+		}
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(status)
+			w.Write(body.Bytes())
+			return
+		}
+		log.Printf("[PROXY] %s error page for %d failed: %v", req.Host, status, err)
+	}
+
+	http.Error(w, fmt.Sprintf("%s (request ID %s)", message, requestID), status)
+}
+
+// errorPageFor returns the template for a status, the host's own before the global one
+func (r *Router) errorPageFor(host *state.Host, status int) string {
+	key := strconv.Itoa(status)
+	if host != nil {
+		if page, ok := host.ErrorPages[key]; ok {
+			return page
+		}
+	}
+	return r.state.GetErrorPages()[key]
+}
+
+// newRequestID returns a random ID for a request without one
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
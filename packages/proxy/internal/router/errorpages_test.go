@@ -0,0 +1,67 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateErrorPages(t *testing.T) {
+	assert.NoError(t, ValidateErrorPages(nil))
+	assert.NoError(t, ValidateErrorPages(map[string]string{"502": "<h1>{{.StatusText}}</h1>", "404": "gone"}))
+	assert.Error(t, ValidateErrorPages(map[string]string{"500": "oops"}))
+	assert.Error(t, ValidateErrorPages(map[string]string{"503": "{{.Status"}))
+}
+
+func TestErrorPages(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	// Nothing listens on port 1, so the backend is unreachable
+	require.NoError(t, st.DeployHost("shop.example.com", "127.0.0.1:1", "shop", "web", "/up", false))
+	require.NoError(t, st.DeployHost("blog.example.com", "127.0.0.1:1", "blog", "web", "/up", false))
+	st.SetErrorPages(map[string]string{
+		"404": "<p>No site at {{.Host}}</p>",
+		"502": "<p>Global {{.Status}}</p>",
+	})
+	require.NoError(t, st.SetHostErrorPages("shop.example.com", map[string]string{
+		"502": "<p>Shop is down, ID {{.RequestID}}</p>",
+	}))
+	r := NewRouter(st, nil)
+
+	get := func(host, accept, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		req.Header.Set("Accept", accept)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A host's own page replaces the global one, with the client's request ID
+	rec := get("shop.example.com", "text/html", "abc123")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "abc123", rec.Header().Get(RequestIDHeader))
+	assert.Equal(t, "<p>Shop is down, ID abc123</p>", rec.Body.String())
+
+	rec = get("blog.example.com", "text/html", "")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "<p>Global 502</p>", rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get(RequestIDHeader))
+
+	// Values from the request are escaped
+	rec = get("<b>.example.com", "text/html", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "<p>No site at &lt;b&gt;.example.com</p>", rec.Body.String())
+
+	// Clients that don't want HTML get plain text with the request ID
+	rec = get("shop.example.com", "application/json", "abc123")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "Bad Gateway (request ID abc123)\n", rec.Body.String())
+}
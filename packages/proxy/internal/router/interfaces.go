@@ -1,9 +1,21 @@
 package router
 
-import "crypto/tls"
+import (
+	"context"
+	"crypto/tls"
+)
 
 // CertificateProvider is the interface that the router needs from cert management
 type CertificateProvider interface {
 	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
 	ServeHTTPChallenge(token string) (string, bool)
-}
\ No newline at end of file
+}
+
+// Waker starts the stopped containers of hosts that scale to zero
+type Waker interface {
+	// Acquire holds a request until its host's app is running and counts it
+	// as activity until release is called
+	Acquire(ctx context.Context, hostname string) (release func(), err error)
+	// Wake starts the host's app if it is asleep without waiting for it
+	Wake(hostname string)
+}
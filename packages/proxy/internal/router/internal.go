@@ -0,0 +1,87 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// DefaultInternalNetworks are the client networks internal hosts are served
+// to: loopback, the addresses Tailscale hands out and the default subnet of
+// the WireGuard mesh. Docker's bridge ranges are left out, as containers and
+// connections relayed by docker-proxy arrive from them.
+var DefaultInternalNetworks = []string{
+	"127.0.0.0/8",
+	"::1/128",
+	"100.64.0.0/10",
+	"fd7a:115c:a1e0::/48",
+	"10.210.0.0/24",
+}
+
+// InternalNetworks are the client networks allowed to reach internal hosts
+type InternalNetworks []*net.IPNet
+
+// ParseInternalNetworks parses CIDRs such as "10.8.0.0/24". A bare address
+// is a network of its own.
+func ParseInternalNetworks(values []string) (InternalNetworks, error) {
+	var networks InternalNetworks
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid internal network %q, expected a CIDR such as 10.8.0.0/24", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid internal network %q, expected a CIDR such as 10.8.0.0/24", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// InternalNetworksFromEnv returns the networks in IOP_INTERNAL_NETWORKS, a
+// comma-separated list of CIDRs such as a WireGuard subnet, or the defaults
+func InternalNetworksFromEnv() (InternalNetworks, error) {
+	configured := os.Getenv("IOP_INTERNAL_NETWORKS")
+	if configured == "" {
+		return ParseInternalNetworks(DefaultInternalNetworks)
+	}
+	networks, err := ParseInternalNetworks(strings.Split(configured, ","))
+	if err != nil {
+		return nil, fmt.Errorf("IOP_INTERNAL_NETWORKS: %w", err)
+	}
+	return networks, nil
+}
+
+// Allows reports whether a connection from addr, a host:port remote
+// address, may reach internal hosts. Forwarding headers are ignored as
+// clients can set them to anything.
+func (n InternalNetworks) Allows(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalNetworks(t *testing.T) {
+	defaults, err := ParseInternalNetworks(DefaultInternalNetworks)
+	require.NoError(t, err)
+	for addr, allowed := range map[string]bool{
+		"10.210.0.2:51820":          true,
+		"100.101.102.103:80":        true,
+		"[fd7a:115c:a1e0::1]:443":   true,
+		"127.0.0.1:8080":            true,
+		"172.17.0.1:443":            false,
+		"[::ffff:172.20.0.1]:443":   false,
+		"10.8.0.2:51820":            false,
+		"192.168.1.20:443":          false,
+		"[fd00::1]:443":             false,
+		"203.0.113.10:443":          false,
+		"[2001:db8::10]:443":        false,
+		"not-an-address":            false,
+		"[::ffff:198.51.100.7]:443": false,
+	} {
+		assert.Equal(t, allowed, defaults.Allows(addr), addr)
+	}
+
+	wireguard, err := ParseInternalNetworks([]string{"10.8.0.0/24", " 198.51.100.7"})
+	require.NoError(t, err)
+	assert.True(t, wireguard.Allows("10.8.0.2:51820"))
+	assert.True(t, wireguard.Allows("198.51.100.7:443"))
+	assert.False(t, wireguard.Allows("10.9.0.2:51820"))
+
+	_, err = ParseInternalNetworks([]string{"10.8.0.0/33"})
+	assert.ErrorContains(t, err, `invalid internal network "10.8.0.0/33"`)
+
+	t.Setenv("IOP_INTERNAL_NETWORKS", "10.8.0.0/24,vpn")
+	_, err = InternalNetworksFromEnv()
+	assert.ErrorContains(t, err, "IOP_INTERNAL_NETWORKS")
+}
+
+func TestInternalHostsOnlyServePrivateClients(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	}))
+	defer backend.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("admin.example.com", strings.TrimPrefix(backend.URL, "http://"), "ops", "admin", "/up", false))
+	require.NoError(t, st.SetHostInternal("admin.example.com", true))
+	r := NewRouter(st, nil)
+
+	get := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://admin.example.com/", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, get("10.210.0.2:40000", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("203.0.113.10:40000", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("203.0.113.10:40000", "10.210.0.2").Code, "forwarding headers can be forged")
+	assert.Equal(t, http.StatusNotFound, get("172.17.0.1:40000", "").Code, "docker-proxy relays from the bridge gateway")
+
+	// Only the configured VPN subnet
+	networks, err := ParseInternalNetworks([]string{"10.8.0.0/24"})
+	require.NoError(t, err)
+	r.SetInternalNetworks(networks)
+	assert.Equal(t, http.StatusOK, get("10.8.0.2:40000", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("192.168.1.20:40000", "").Code)
+
+	require.NoError(t, st.SetHostInternal("admin.example.com", false))
+	assert.Equal(t, http.StatusOK, get("203.0.113.10:40000", "").Code)
+}
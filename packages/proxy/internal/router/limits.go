@@ -0,0 +1,210 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// maxThrottledWrite bounds how much of a response is written per token bucket
+// wait, so throttled responses stream smoothly instead of in one-second bursts
+const maxThrottledWrite = 32 * 1024
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseBandwidth converts a rate such as "512KB" or "10MB" (per second) to
+// bytes per second. Units are powers of 1024 and a plain number means bytes.
+func ParseBandwidth(input string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(input), "/s"))
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, expected e.g. 512KB or 10MB", input)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// ValidateHostLimits checks that limits can be applied
+func ValidateHostLimits(limits *state.HostLimits) error {
+	if limits == nil {
+		return nil
+	}
+	if limits.MaxConcurrent < 0 {
+		return fmt.Errorf("max concurrent requests can't be negative")
+	}
+	if limits.Bandwidth < 0 {
+		return fmt.Errorf("bandwidth can't be negative")
+	}
+	if limits.Bandwidth > 0 && limits.Bandwidth < 1024 {
+		return fmt.Errorf("bandwidth must be at least 1KB per second")
+	}
+	return nil
+}
+
+// hostLimiter enforces a host's limits across all of its requests
+type hostLimiter struct {
+	limits   state.HostLimits
+	inFlight chan struct{} // Semaphore, nil when concurrency is unlimited
+	bucket   *tokenBucket  // nil when bandwidth is unlimited
+}
+
+func newHostLimiter(limits state.HostLimits) *hostLimiter {
+	l := &hostLimiter{limits: limits}
+	if limits.MaxConcurrent > 0 {
+		l.inFlight = make(chan struct{}, limits.MaxConcurrent)
+	}
+	if limits.Bandwidth > 0 {
+		l.bucket = newTokenBucket(limits.Bandwidth)
+	}
+	return l
+}
+
+// acquire reserves an in-flight slot, returning false if the host is at its cap
+func (l *hostLimiter) acquire() bool {
+	if l.inFlight == nil {
+		return true
+	}
+	select {
+	case l.inFlight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees a slot reserved by acquire
+func (l *hostLimiter) release() {
+	if l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// throttle wraps a response writer so its writes share the host's bandwidth
+func (l *hostLimiter) throttle(ctx context.Context, w http.ResponseWriter) http.ResponseWriter {
+	if l.bucket == nil {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, bucket: l.bucket}
+}
+
+// limiterFor returns the limiter for a host, or nil if it has no limits. The
+// limiter is replaced when the host's limits change.
+func (r *Router) limiterFor(hostname string, limits *state.HostLimits) *hostLimiter {
+	r.limitersMu.Lock()
+	defer r.limitersMu.Unlock()
+
+	if limits == nil || (limits.MaxConcurrent == 0 && limits.Bandwidth == 0) {
+		delete(r.limiters, hostname)
+		return nil
+	}
+
+	if l, exists := r.limiters[hostname]; exists && l.limits == *limits {
+		return l
+	}
+
+	l := newHostLimiter(*limits)
+	r.limiters[hostname] = l
+	return l
+}
+
+// tokenBucket limits throughput to rate bytes per second with a one second burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens, sleeping until the bucket has refilled enough. Tokens
+// may go negative so concurrent writers queue fairly behind each other.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledWriter paces response body writes through a token bucket
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	bucket *tokenBucket
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxThrottledWrite {
+			chunk = chunk[:maxThrottledWrite]
+		}
+		if err := w.bucket.wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
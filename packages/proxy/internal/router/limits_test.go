@@ -0,0 +1,103 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"2048":   2048,
+		"512KB":  512 * 1024,
+		"10MB":   10 << 20,
+		"1.5mb":  3 << 19,
+		"1GB/s":  1 << 30,
+		"100 KB": 100 * 1024,
+	} {
+		rate, err := ParseBandwidth(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, rate, input)
+	}
+
+	_, err := ParseBandwidth("fast")
+	assert.Error(t, err)
+	_, err = ParseBandwidth("-1MB")
+	assert.Error(t, err)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	defer backend.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("noisy.example.com", strings.TrimPrefix(backend.URL, "http://"), "tenants", "web", "/up", false))
+	require.NoError(t, st.SetHostLimits("noisy.example.com", &state.HostLimits{MaxConcurrent: 1}))
+	r := NewRouter(st, nil)
+
+	first := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://noisy.example.com/", nil))
+		first <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://noisy.example.com/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "maximum of 1 concurrent requests")
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-first)
+
+	// The slot is free again once the first request finishes
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://noisy.example.com/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestThrottledWriter(t *testing.T) {
+	bucket := newTokenBucket(64 * 1024)
+	rec := httptest.NewRecorder()
+	w := &throttledWriter{ResponseWriter: rec, ctx: context.Background(), bucket: bucket}
+
+	// The first second's worth is the burst, the rest is paced at the rate
+	start := time.Now()
+	n, err := w.Write(make([]byte, 64*1024+16*1024))
+	require.NoError(t, err)
+	assert.Equal(t, 80*1024, n)
+	assert.Equal(t, 80*1024, rec.Body.Len())
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.ctx = ctx
+	_, err = w.Write(make([]byte, 64*1024))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLimiterForReplacesChangedLimits(t *testing.T) {
+	r := NewRouter(state.NewState(filepath.Join(t.TempDir(), "state.json")), nil)
+
+	assert.Nil(t, r.limiterFor("app.example.com", nil))
+	assert.Nil(t, r.limiterFor("app.example.com", &state.HostLimits{}))
+
+	limiter := r.limiterFor("app.example.com", &state.HostLimits{MaxConcurrent: 5})
+	assert.Same(t, limiter, r.limiterFor("app.example.com", &state.HostLimits{MaxConcurrent: 5}))
+	assert.NotSame(t, limiter, r.limiterFor("app.example.com", &state.HostLimits{MaxConcurrent: 10}))
+}
@@ -0,0 +1,132 @@
+package router
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBounds are the upper bounds of the latency histogram buckets. The
+// last bucket counts the requests slower than all of them.
+var LatencyBounds = [...]time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// RequestStats counts the requests proxied for a host since the proxy
+// started. Rates, average latencies and percentiles come from the difference
+// between two readings.
+type RequestStats struct {
+	Requests       uint64                         `json:"requests"`
+	Errors         uint64                         `json:"errors"`          // Responses with a 5xx status
+	Latency        time.Duration                  `json:"latency"`         // Total time spent serving the requests
+	InFlight       int64                          `json:"in_flight"`       // Requests being proxied right now
+	LatencyBuckets [len(LatencyBounds) + 1]uint64 `json:"latency_buckets"` // Requests per bucket of LatencyBounds
+}
+
+// Add adds the counters of other, e.g. to total the hosts of an app
+func (s *RequestStats) Add(other RequestStats) {
+	s.Requests += other.Requests
+	s.Errors += other.Errors
+	s.Latency += other.Latency
+	s.InFlight += other.InFlight
+	for i, count := range other.LatencyBuckets {
+		s.LatencyBuckets[i] += count
+	}
+}
+
+// Percentile estimates the latency below which a fraction q of the requests
+// were served, as the upper bound of the bucket it falls in. Requests slower
+// than every bound count as the last bound.
+func (s RequestStats) Percentile(q float64) time.Duration {
+	var total uint64
+	for _, count := range s.LatencyBuckets {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var seen uint64
+	for i, count := range s.LatencyBuckets[:len(LatencyBounds)] {
+		seen += count
+		if float64(seen) >= rank {
+			return LatencyBounds[i]
+		}
+	}
+	return LatencyBounds[len(LatencyBounds)-1]
+}
+
+// requestMetrics tracks request counts and latency per host
+type requestMetrics struct {
+	mu    sync.Mutex
+	hosts map[string]*RequestStats
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{hosts: make(map[string]*RequestStats)}
+}
+
+// statsFor returns the counters of a host. Callers hold m.mu.
+func (m *requestMetrics) statsFor(hostname string) *RequestStats {
+	stats, exists := m.hosts[hostname]
+	if !exists {
+		stats = &RequestStats{}
+		m.hosts[hostname] = stats
+	}
+	return stats
+}
+
+// begin counts a request that is being proxied until end is called
+func (m *requestMetrics) begin(hostname string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.statsFor(hostname).InFlight++
+}
+
+// end stops counting a request as in flight
+func (m *requestMetrics) end(hostname string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.statsFor(hostname).InFlight--
+}
+
+// record counts a finished request
+func (m *requestMetrics) record(hostname string, status int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.statsFor(hostname)
+	stats.Requests++
+	if status >= 500 {
+		stats.Errors++
+	}
+	stats.Latency += latency
+	stats.LatencyBuckets[latencyBucket(latency)]++
+}
+
+// latencyBucket returns the index of the histogram bucket a latency falls in
+func latencyBucket(latency time.Duration) int {
+	for i, bound := range LatencyBounds {
+		if latency <= bound {
+			return i
+		}
+	}
+	return len(LatencyBounds)
+}
+
+// RequestStats returns a copy of the request counters of every host that has
+// served a request, keyed by hostname or host pattern
+func (r *Router) RequestStats() map[string]RequestStats {
+	r.metrics.mu.Lock()
+	defer r.metrics.mu.Unlock()
+
+	stats := make(map[string]RequestStats, len(r.metrics.hosts))
+	for hostname, s := range r.metrics.hosts {
+		stats[hostname] = *s
+	}
+	return stats
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// MirrorHeader marks the copies sent to a shadow target
+const MirrorHeader = "X-IOP-Mirror"
+
+const (
+	// maxMirrorBody is the largest request body copied to a shadow target,
+	// requests with bigger bodies aren't mirrored
+	maxMirrorBody = 1 << 20
+
+	// maxMirrorsInFlight bounds the copies waiting on shadow targets, further
+	// copies are dropped so a slow shadow target can't pile up memory
+	maxMirrorsInFlight = 100
+
+	// mirrorTimeout bounds how long a copy may take
+	mirrorTimeout = 30 * time.Second
+)
+
+// ValidateMirrorPolicy checks that a mirror policy can be applied
+func ValidateMirrorPolicy(mirror *state.MirrorPolicy) error {
+	if mirror == nil {
+		return nil
+	}
+	if mirror.Target == "" {
+		return fmt.Errorf("mirror has no target")
+	}
+	if mirror.Percent < 1 || mirror.Percent > 100 {
+		return fmt.Errorf("mirror percent must be between 1 and 100")
+	}
+	return nil
+}
+
+// mirrorer sends copies of requests to shadow targets and discards the answers
+type mirrorer struct {
+	client   *http.Client
+	inFlight chan struct{}
+	sample   func() int // Returns 0-99, picks the requests to copy
+}
+
+func newMirrorer() *mirrorer {
+	return &mirrorer{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   10 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			// The shadow target's redirects aren't followed, its answers don't matter
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Timeout: mirrorTimeout,
+		},
+		inFlight: make(chan struct{}, maxMirrorsInFlight),
+		sample:   func() int { return rand.Intn(100) },
+	}
+}
+
+// mirror copies a share of requests to the policy's target in the background.
+// The original request's body is read into memory when it is copied and
+// replaced, so the real backend still receives all of it.
+func (m *mirrorer) mirror(req *http.Request, policy *state.MirrorPolicy) {
+	if policy == nil || m.sample() >= policy.Percent {
+		return
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.ContentLength > maxMirrorBody {
+			return
+		}
+		buffered, err := io.ReadAll(io.LimitReader(req.Body, maxMirrorBody+1))
+		// Whatever was read goes back in front of the rest of the body
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(buffered), req.Body), req.Body}
+		if err != nil || len(buffered) > maxMirrorBody {
+			return
+		}
+		body = buffered
+	}
+
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		log.Printf("[MIRROR] %s %s %s -> %s dropped (too many copies in flight)", req.Host, req.Method, req.URL.Path, policy.Target)
+		return
+	}
+
+	copied, err := http.NewRequestWithContext(context.Background(), req.Method, "http://"+policy.Target+req.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		<-m.inFlight
+		log.Printf("[MIRROR] %s %s %s -> %s failed: %v", req.Host, req.Method, req.URL.Path, policy.Target, err)
+		return
+	}
+	copied.Header = req.Header.Clone()
+	for name := range copied.Header {
+		if isHopByHop(name) {
+			copied.Header.Del(name)
+		}
+	}
+	// Lets the shadow target skip side effects such as sending emails
+	copied.Header.Set(MirrorHeader, "1")
+	copied.Host = req.Host
+	if body == nil {
+		copied.Body = http.NoBody
+	}
+
+	go func() {
+		defer func() { <-m.inFlight }()
+
+		start := time.Now()
+		resp, err := m.client.Do(copied)
+		if err != nil {
+			log.Printf("[MIRROR] %s %s %s -> %s failed: %v", copied.Host, copied.Method, copied.URL.Path, policy.Target, err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.Printf("[MIRROR] %s %s %s -> %s %d (%dms)",
+			copied.Host, copied.Method, copied.URL.Path, policy.Target, resp.StatusCode, time.Since(start).Milliseconds())
+	}()
+}
+
+// isHopByHop reports whether a header only applies to one connection
+func isHopByHop(name string) bool {
+	switch strings.ToLower(name) {
+	case "connection", "keep-alive", "proxy-connection", "te", "trailer", "transfer-encoding", "upgrade":
+		return true
+	}
+	return false
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMirrorPolicy(t *testing.T) {
+	assert.NoError(t, ValidateMirrorPolicy(nil))
+	assert.NoError(t, ValidateMirrorPolicy(&state.MirrorPolicy{Target: "shadow:80", Percent: 100}))
+	assert.Error(t, ValidateMirrorPolicy(&state.MirrorPolicy{Percent: 10}))
+	assert.Error(t, ValidateMirrorPolicy(&state.MirrorPolicy{Target: "shadow:80"}))
+	assert.Error(t, ValidateMirrorPolicy(&state.MirrorPolicy{Target: "shadow:80", Percent: 101}))
+}
+
+func TestMirrorCopiesRequests(t *testing.T) {
+	type copied struct {
+		method, path, body, mirror, host string
+	}
+	copies := make(chan copied, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		copies <- copied{r.Method, r.URL.RequestURI(), string(body), r.Header.Get(MirrorHeader), r.Host}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("primary " + string(body)))
+	}))
+	defer primary.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", strings.TrimPrefix(primary.URL, "http://"), "shop", "web", "/up", false))
+	require.NoError(t, st.SetHostMirror("shop.example.com", &state.MirrorPolicy{Target: strings.TrimPrefix(shadow.URL, "http://"), Percent: 50}))
+	r := NewRouter(st, nil)
+
+	send := func(sample int) *httptest.ResponseRecorder {
+		r.mirrorer.sample = func() int { return sample }
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://shop.example.com/orders?id=1", strings.NewReader("order")))
+		return rec
+	}
+
+	// The client gets the primary's answer with the whole body passed on
+	rec := send(49)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "primary order", rec.Body.String())
+
+	select {
+	case c := <-copies:
+		assert.Equal(t, copied{http.MethodPost, "/orders?id=1", "order", "1", "shop.example.com"}, c)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	// Requests outside the percentage aren't copied
+	assert.Equal(t, "primary order", send(50).Body.String())
+	select {
+	case c := <-copies:
+		t.Fatalf("unexpected copy %+v", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMirrorSkipsLargeBodies(t *testing.T) {
+	m := newMirrorer()
+	m.sample = func() int { return 0 }
+	m.inFlight = make(chan struct{}) // Any copy would be dropped, none should get that far
+
+	body := strings.Repeat("x", maxMirrorBody+1)
+	req := httptest.NewRequest(http.MethodPost, "http://shop.example.com/upload", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	m.mirror(req, &state.MirrorPolicy{Target: "shadow:80", Percent: 100})
+
+	// The backend still receives the whole body
+	forwarded, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(forwarded))
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// clientHelloTimeout bounds how long a client may take to send its ClientHello
+const clientHelloTimeout = 10 * time.Second
+
+var errClientHelloRead = errors.New("client hello read")
+
+// SNIListener routes TLS connections by SNI name. Connections for passthrough
+// hosts are piped to their backend without terminating TLS, all others are
+// returned from Accept for the HTTPS server to handle.
+type SNIListener struct {
+	net.Listener
+	router *Router
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSNIListener wraps a TCP listener and starts accepting connections from it
+func NewSNIListener(ln net.Listener, r *Router) *SNIListener {
+	l := &SNIListener{
+		Listener: ln,
+		router:   r,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// Accept returns the next connection the proxy should terminate TLS for
+func (l *SNIListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *SNIListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *SNIListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			select {
+			case l.errs <- err:
+			case <-l.done:
+			}
+			return
+		}
+		go l.route(conn)
+	}
+}
+
+// route peeks at the ClientHello and either passes the connection through or
+// hands it to the HTTPS server with the peeked bytes replayed
+func (l *SNIListener) route(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	serverName, peeked, err := peekServerName(conn)
+	conn.SetReadDeadline(time.Time{})
+	replayed := &replayConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(peeked), conn)}
+
+	if err == nil && serverName != "" {
+		if host, _, err := l.router.state.MatchHost(serverName); err == nil && host.Mode == state.HostModePassthrough {
+			if host.Internal && !l.router.internal.Allows(conn.RemoteAddr().String()) {
+				log.Printf("[PROXY] %s passthrough refused (internal host, client %s not on a private network)", serverName, conn.RemoteAddr())
+				conn.Close()
+				return
+			}
+			l.router.passthrough(replayed, serverName, host)
+			return
+		}
+	}
+
+	// Not a passthrough host, or not readable as TLS. The HTTPS server
+	// reports handshake errors itself.
+	select {
+	case l.conns <- replayed:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// passthrough copies bytes between the client and the host's backend until either side closes
+func (r *Router) passthrough(client net.Conn, serverName string, host *state.Host) {
+	defer client.Close()
+
+	if !host.Healthy {
+		log.Printf("[PROXY] %s TLS passthrough -> %s refused (unhealthy)", serverName, host.Target)
+		return
+	}
+
+	start := time.Now()
+	backend, err := net.DialTimeout("tcp", host.Target, 10*time.Second)
+	if err != nil {
+		log.Printf("[PROXY] %s TLS passthrough -> %s dial failed: %v", serverName, host.Target, err)
+		return
+	}
+	defer backend.Close()
+
+	log.Printf("[PROXY] %s TLS passthrough -> %s", serverName, host.Target)
+
+	errChan := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backend, client)
+		errChan <- err
+	}()
+	go func() {
+		_, err := io.Copy(client, backend)
+		errChan <- err
+	}()
+
+	// Wait for one direction to close
+	<-errChan
+	log.Printf("[PROXY] %s TLS passthrough closed (%s)", serverName, time.Since(start).Round(time.Millisecond))
+}
+
+// peekServerName reads the ClientHello from conn and returns its SNI name
+// along with the bytes read, which must be replayed to whoever handles the
+// connection next. crypto/tls parses the hello, the handshake is aborted
+// before anything is written back.
+func peekServerName(conn net.Conn) (string, []byte, error) {
+	var peeked bytes.Buffer
+	var hello *tls.ClientHelloInfo
+
+	err := tls.Server(readOnlyConn{reader: io.TeeReader(conn, &peeked)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+
+	if hello == nil {
+		return "", peeked.Bytes(), err
+	}
+	return hello.ServerName, peeked.Bytes(), nil
+}
+
+// readOnlyConn feeds a TLS handshake from a reader and discards its writes
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// replayConn returns already peeked bytes before reading from the connection
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIListener(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("end-to-end"))
+	}))
+	defer backend.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("secure.example.com", backend.Listener.Addr().String(), "vault", "api", "/up", true))
+	require.NoError(t, st.SetHostMode("secure.example.com", state.HostModePassthrough))
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "web", "app", "/up", false))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	sni := NewSNIListener(ln, NewRouter(st, nil))
+	defer sni.Close()
+
+	// Terminated hosts are handed to the HTTPS server with the ClientHello intact
+	terminated := make(chan string, 1)
+	go func() {
+		conn, err := sni.Accept()
+		if err != nil {
+			return
+		}
+		server := tls.Server(conn, &tls.Config{Certificates: backend.TLS.Certificates})
+		if server.Handshake() == nil {
+			terminated <- server.ConnectionState().ServerName
+		}
+		server.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "app.example.com", InsecureSkipVerify: true})
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "app.example.com", <-terminated)
+
+	// Passthrough hosts reach the backend's own certificate
+	conn, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "secure.example.com", InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, backend.Certificate().Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: secure.example.com\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
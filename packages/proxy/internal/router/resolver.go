@@ -0,0 +1,148 @@
+package router
+
+import (
+	"context"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// resolveTTL is how long the addresses of a backend hostname are trusted
+// before they are looked up again
+const resolveTTL = 5 * time.Second
+
+// resolver caches the addresses backend hostnames resolve to. Docker DNS only
+// answers once per connection, so a blue-green switch moving an app's network
+// alias goes unnoticed by kept-alive connections. Entries older than the TTL
+// are looked up again on their next use, and when the addresses changed
+// onChange is called to drop the connections to the old ones.
+type resolver struct {
+	lookup   func(ctx context.Context, host string) ([]string, error)
+	ttl      time.Duration
+	now      func() time.Time
+	onChange func(host string)
+
+	mu      sync.Mutex
+	entries map[string]*resolved // By hostname
+}
+
+type resolved struct {
+	addrs      []string // Sorted
+	expires    time.Time
+	refreshing bool
+}
+
+func newResolver(onChange func(host string)) *resolver {
+	return &resolver{
+		lookup:   net.DefaultResolver.LookupHost,
+		ttl:      resolveTTL,
+		now:      time.Now,
+		onChange: onChange,
+		entries:  make(map[string]*resolved),
+	}
+}
+
+// LookupHost returns the addresses of a hostname, from the cache while fresh
+func (r *resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	if entry := r.entries[host]; entry != nil && r.now().Before(entry.expires) {
+		addrs := entry.addrs
+		r.mu.Unlock()
+		return addrs, nil
+	}
+	r.mu.Unlock()
+
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.store(host, addrs)
+	return addrs, nil
+}
+
+// check looks up a hostname again in the background once its entry expired.
+// Requests call it, so an idle backend isn't looked up.
+func (r *resolver) check(host string) {
+	if net.ParseIP(host) != nil {
+		return
+	}
+
+	r.mu.Lock()
+	entry := r.entries[host]
+	if entry == nil || entry.refreshing || r.now().Before(entry.expires) {
+		r.mu.Unlock()
+		return
+	}
+	entry.refreshing = true
+	r.mu.Unlock()
+
+	go r.refresh(host)
+}
+
+// refresh looks up a hostname again, keeping its addresses when it fails
+func (r *resolver) refresh(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.ttl)
+	defer cancel()
+
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		log.Printf("[PROXY] Failed to resolve backend %s: %v", host, err)
+		r.mu.Lock()
+		if entry := r.entries[host]; entry != nil {
+			entry.expires = r.now().Add(r.ttl)
+			entry.refreshing = false
+		}
+		r.mu.Unlock()
+		return
+	}
+	r.store(host, addrs)
+}
+
+// store caches the addresses of a hostname and reports a change to them
+func (r *resolver) store(host string, addrs []string) {
+	addrs = slices.Clone(addrs)
+	slices.Sort(addrs)
+
+	r.mu.Lock()
+	previous := r.entries[host]
+	r.entries[host] = &resolved{addrs: addrs, expires: r.now().Add(r.ttl)}
+	r.mu.Unlock()
+
+	if previous != nil && !slices.Equal(previous.addrs, addrs) {
+		log.Printf("[PROXY] Backend %s moved from %v to %v", host, previous.addrs, addrs)
+		if r.onChange != nil {
+			r.onChange(host)
+		}
+	}
+}
+
+// forget drops a hostname's addresses, so the next connection looks it up
+func (r *resolver) forget(host string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.entries, host)
+}
+
+// retain drops the addresses of hostnames no longer used by any target
+func (r *resolver) retain(hosts map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for host := range r.entries {
+		if !hosts[host] {
+			delete(r.entries, host)
+		}
+	}
+}
+
+// targetHost returns the hostname of a host:port target
+func targetHost(target string) string {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return target
+	}
+	return host
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolverRefreshesExpiredAddresses(t *testing.T) {
+	var mu sync.Mutex
+	addrs := []string{"172.18.0.2"}
+	lookups := 0
+	var changed []string
+
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	r := newResolver(func(host string) { changed = append(changed, host) })
+	r.now = func() time.Time { return now }
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups++
+		return addrs, nil
+	}
+
+	// Fresh addresses come from the cache
+	got, err := r.LookupHost(context.Background(), "blog-web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"172.18.0.2"}, got)
+	r.LookupHost(context.Background(), "blog-web")
+	r.check("blog-web")
+	assert.Equal(t, 1, lookups)
+
+	// An alias moved to another container is noticed once the entry expired
+	mu.Lock()
+	addrs = []string{"172.18.0.3"}
+	mu.Unlock()
+	now = now.Add(resolveTTL)
+	r.refresh("blog-web")
+	assert.Equal(t, []string{"blog-web"}, changed)
+	got, _ = r.LookupHost(context.Background(), "blog-web")
+	assert.Equal(t, []string{"172.18.0.3"}, got)
+
+	// Unchanged addresses report nothing
+	now = now.Add(resolveTTL)
+	r.refresh("blog-web")
+	assert.Len(t, changed, 1)
+}
+
+func TestSwitchReplacesBackendProxies(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("a.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.DeployHost("b.example.com", "shop-web:3000", "shop", "web", "/up", false))
+	r := NewRouter(st, nil)
+	r.Rebuild()
+
+	a := r.table.Load().proxies["a.example.com"]
+	b := r.table.Load().proxies["b.example.com"]
+	r.resolver.store("blog-web", []string{"172.18.0.2"})
+
+	// Only the proxies of the switched host's backend are replaced, and its
+	// addresses are looked up again
+	r.switched("a.example.com")
+	assert.NotSame(t, a, r.table.Load().proxies["a.example.com"])
+	assert.Same(t, b, r.table.Load().proxies["b.example.com"])
+	assert.NotContains(t, r.resolver.entries, "blog-web")
+}
@@ -1,7 +1,10 @@
 package router
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -9,33 +12,79 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/tracing"
 )
 
 type Router struct {
 	state       *state.State
 	certManager CertificateProvider
-	proxies     map[string]*routerProxy
-}
-
-type routerProxy struct {
-	target string
-	proxy  *httputil.ReverseProxy
+	table       atomic.Pointer[routingTable] // Read without locking, replaced as a whole
+	tableMu     sync.Mutex                   // Serializes replacing the table
+	limitersMu  sync.Mutex
+	limiters    map[string]*hostLimiter
+	metrics     *requestMetrics
+	mirrorer    *mirrorer
+	errorPages  *errorPages
+	resolver    *resolver
+	waker       Waker
+	internal    InternalNetworks // Clients allowed to reach internal hosts
 }
 
 // NewRouter creates a new router instance
 func NewRouter(st *state.State, cm CertificateProvider) *Router {
-	return &Router{
+	r := &Router{
 		state:       st,
 		certManager: cm,
-		proxies:     make(map[string]*routerProxy),
+		limiters:    make(map[string]*hostLimiter),
+		metrics:     newRequestMetrics(),
+		mirrorer:    newMirrorer(),
+		errorPages:  newErrorPages(),
 	}
+	r.resolver = newResolver(r.invalidate)
+	r.internal, _ = ParseInternalNetworks(DefaultInternalNetworks)
+	r.table.Store(&routingTable{proxies: make(map[string]*routerProxy)})
+	return r
+}
+
+// SetWaker makes the router start sleeping apps of hosts that scale to zero
+func (r *Router) SetWaker(w Waker) {
+	r.waker = w
+}
+
+// SetInternalNetworks sets the client networks internal hosts are served to
+func (r *Router) SetInternalNetworks(networks InternalNetworks) {
+	r.internal = networks
 }
 
-// ServeHTTP handles incoming HTTP requests
+// ServeHTTP handles incoming HTTP requests, recording a server span when tracing is enabled
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx, span := tracing.Start(tracing.Extract(req.Context(), req.Header), "HTTP "+req.Method, tracing.SpanKindServer,
+		tracing.String("http.request.method", req.Method),
+		tracing.String("server.address", req.Host),
+		tracing.String("url.path", req.URL.Path),
+	)
+	if span == nil {
+		r.serveHTTP(w, req)
+		return
+	}
+	defer span.End()
+
+	wrapped := &responseWriter{ResponseWriter: w}
+	r.serveHTTP(wrapped, req.WithContext(ctx))
+
+	span.SetAttributes(tracing.Int("http.response.status_code", wrapped.statusCode))
+	if wrapped.statusCode >= 500 {
+		span.RecordError(fmt.Errorf("HTTP %d", wrapped.statusCode))
+	}
+}
+
+// serveHTTP routes a request to its host's backend
+func (r *Router) serveHTTP(w http.ResponseWriter, req *http.Request) {
 	start := time.Now()
 
 	// Handle ACME challenges
@@ -57,37 +106,118 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Get host configuration
-	host, _, err := r.state.GetHost(req.Host)
+	// Get host configuration, falling back to wildcard patterns and then the default backend
+	host, hostKey, err := r.state.MatchHost(req.Host)
 	if err != nil {
+		if target := r.state.GetDefaultBackend(); target != "" {
+			r.serveDefaultBackend(w, req, target, start)
+			return
+		}
 		log.Printf("[PROXY] %s %s %s -> 404 (host not found)", req.Host, req.Method, req.URL.Path)
-		http.NotFound(w, req)
+		r.serveError(w, req, nil, http.StatusNotFound, "Not Found", 0)
+		return
+	}
+
+	// Internal hosts don't exist for clients outside the private networks
+	if host.Internal && !r.internal.Allows(req.RemoteAddr) {
+		log.Printf("[PROXY] %s %s %s -> 404 (internal host, client %s not on a private network)", req.Host, req.Method, req.URL.Path, req.RemoteAddr)
+		r.serveError(w, req, nil, http.StatusNotFound, "Not Found", 0)
+		return
+	}
+
+	// Passthrough hosts are served by the SNI listener. A terminated request
+	// for one means the client's SNI name didn't match its Host header.
+	passthrough := host.Mode == state.HostModePassthrough
+	if passthrough && req.TLS != nil {
+		log.Printf("[PROXY] %s %s %s -> 421 (passthrough host requested via SNI %q)", req.Host, req.Method, req.URL.Path, req.TLS.ServerName)
+		http.Error(w, "Misdirected Request", http.StatusMisdirectedRequest)
 		return
 	}
 
 	// Check if SSL redirect is enabled and this is HTTP
-	if host.SSLRedirect && req.TLS == nil {
+	if (host.SSLRedirect || passthrough) && req.TLS == nil {
 		httpsURL := "https://" + req.Host + req.URL.RequestURI()
 		http.Redirect(w, req, httpsURL, http.StatusMovedPermanently)
 		log.Printf("[PROXY] %s %s %s -> 301 (HTTPS redirect)", req.Host, req.Method, req.URL.Path)
 		return
 	}
 
+	// A stopped app isn't woken by requests, it waits for iop start
+	if host.Stopped {
+		log.Printf("[PROXY] %s %s %s -> 503 (app stopped)", req.Host, req.Method, req.URL.Path)
+		r.serveError(w, req, host, http.StatusServiceUnavailable, "Service Unavailable", 0)
+		return
+	}
+
+	// Hold requests for a sleeping app until it has started
+	if r.waker != nil && (host.ScaleToZero || host.Sleeping) {
+		if r.wantsStartingPage(req, host) {
+			r.waker.Wake(hostKey)
+			log.Printf("[PROXY] %s %s %s -> 503 (app starting, starting page)", req.Host, req.Method, req.URL.Path)
+			r.serveStartingPage(w, req)
+			return
+		}
+
+		release, err := r.waker.Acquire(req.Context(), hostKey)
+		if err != nil {
+			log.Printf("[PROXY] %s %s %s -> 503 (app not started: %v)", req.Host, req.Method, req.URL.Path, err)
+			r.serveError(w, req, host, http.StatusServiceUnavailable, "Service Unavailable", 5)
+			return
+		}
+		defer release()
+
+		if host.Sleeping {
+			if host, hostKey, err = r.state.MatchHost(req.Host); err != nil {
+				r.serveError(w, req, nil, http.StatusNotFound, "Not Found", 0)
+				return
+			}
+		}
+	}
+
 	// Check health status
 	if !host.Healthy {
 		log.Printf("[PROXY] %s %s %s -> 503 (unhealthy)", req.Host, req.Method, req.URL.Path)
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		r.serveError(w, req, host, http.StatusServiceUnavailable, "Service Unavailable", 0)
 		return
 	}
 
+	// Enforce the host's concurrency limit
+	limiter := r.limiterFor(hostKey, host.Limits)
+	if limiter != nil {
+		if !limiter.acquire() {
+			log.Printf("[PROXY] %s %s %s -> 503 (concurrency limit of %d reached)", req.Host, req.Method, req.URL.Path, limiter.limits.MaxConcurrent)
+			r.serveError(w, req, host, http.StatusServiceUnavailable,
+				fmt.Sprintf("Service Unavailable: %s is handling its maximum of %d concurrent requests, try again shortly", req.Host, limiter.limits.MaxConcurrent), 1)
+			return
+		}
+		defer limiter.release()
+	}
+
+	// Send requests matching one of the host's routing rules to the rule's target
+	target, proxyKey := host.Target, hostKey
+	if rule := matchRule(w, req, host.Rules); rule != nil {
+		target, proxyKey = rule.Target, hostKey+" -> "+rule.Target
+	}
+
 	// Check if this is a WebSocket upgrade request
 	if r.isWebSocketUpgrade(req) {
-		r.handleWebSocketProxy(w, req, host.Target, start)
+		r.handleWebSocketProxy(w, req, target, start)
 		return
 	}
 
-	// Get or create proxy for regular HTTP requests
-	proxy := r.getOrCreateProxy(req.Host, host.Target)
+	// Pace the response body to the host's bandwidth limit
+	if limiter != nil {
+		w = limiter.throttle(req.Context(), w)
+	}
+
+	// Get or create proxy for regular HTTP requests, shared by all hosts matching a pattern
+	proxy := r.getOrCreateProxy(proxyKey, target, hostProxyOptions(host))
+	r.resolver.check(targetHost(target))
+
+	// Streams outlast the server's write timeout
+	if host.Streaming {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
 
 	// Set forwarding headers
 	if host.ForwardHeaders {
@@ -97,58 +227,89 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		req.Header.Set("X-Forwarded-Host", req.Host)
 	}
 
+	// Copy a share of requests to the host's shadow target
+	r.mirrorer.mirror(req, host.Mirror)
+
 	// Create response writer wrapper to capture status code
 	wrapped := &responseWriter{ResponseWriter: w}
 
-	// Proxy the request
+	// Time the upstream exchange and continue the trace in the backend
+	ctx, upstream := tracing.Start(req.Context(), "HTTP "+req.Method, tracing.SpanKindClient,
+		tracing.String("server.address", target),
+	)
+	if upstream != nil {
+		req = req.WithContext(ctx)
+		tracing.Inject(ctx, req.Header)
+	}
+
+	// Bound the whole exchange, body included, by the host's request timeout
+	if timeout := parseTimeout(host.RequestTimeout, 0); timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	// Proxy the request, counting it as in flight even if the client goes away mid-response
+	r.metrics.begin(hostKey)
+	defer r.metrics.end(hostKey)
 	proxy.ServeHTTP(wrapped, req)
 
-	// Log the request
+	upstream.SetAttributes(tracing.Int("http.response.status_code", wrapped.statusCode))
+	if wrapped.statusCode >= 500 {
+		upstream.RecordError(fmt.Errorf("upstream returned %d", wrapped.statusCode))
+	}
+	upstream.End()
+
+	// Log the request and count it towards the host's request rate and latency
 	duration := time.Since(start)
+	r.metrics.record(hostKey, wrapped.statusCode, duration)
 	log.Printf("[PROXY] %s %s %s -> %s %d (%dms)",
-		req.Host, req.Method, req.URL.Path, host.Target, wrapped.statusCode, duration.Milliseconds())
+		req.Host, req.Method, req.URL.Path, target, wrapped.statusCode, duration.Milliseconds())
+}
+
+// serveDefaultBackend proxies a request for an unknown host to the default backend
+func (r *Router) serveDefaultBackend(w http.ResponseWriter, req *http.Request, target string, start time.Time) {
+	if r.isWebSocketUpgrade(req) {
+		r.handleWebSocketProxy(w, req, target, start)
+		return
+	}
+
+	req.Header.Set("X-Forwarded-For", r.getClientIP(req))
+	req.Header.Set("X-Forwarded-Proto", r.getProto(req))
+	req.Header.Set("X-Forwarded-Host", req.Host)
+
+	wrapped := &responseWriter{ResponseWriter: w}
+	r.getOrCreateProxy(defaultBackendKey, target, proxyOptions{}).ServeHTTP(wrapped, req)
+
+	log.Printf("[PROXY] %s %s %s -> %s %d (default backend, %dms)",
+		req.Host, req.Method, req.URL.Path, target, wrapped.statusCode, time.Since(start).Milliseconds())
 }
 
 // GetTLSConfig returns the TLS configuration for HTTPS
 func (r *Router) GetTLSConfig() *tls.Config {
 	config := &tls.Config{
-		MinVersion:     tls.VersionTLS12,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		},
+		MinVersion:               tls.VersionTLS12,
+		CipherSuites:             defaultCipherSuites,
+		NextProtos:               defaultALPN,
 		PreferServerCipherSuites: true,
 	}
-	
+
 	if r.certManager != nil {
 		config.GetCertificate = r.certManager.GetCertificate
 	}
-	
-	return config
-}
 
-// getOrCreateProxy returns a reverse proxy for the given hostname/target combination
-func (r *Router) getOrCreateProxy(hostname, target string) *httputil.ReverseProxy {
-	// Check if we have a proxy for this hostname and if the target matches
-	if hp, exists := r.proxies[hostname]; exists && hp.target == target {
-		return hp.proxy
+	// Apply the global and per-host TLS policies from state on each handshake
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		return r.configForClient(config, hello)
 	}
 
-	// Create new proxy
-	proxy := r.createProxy(target)
-	r.proxies[hostname] = &routerProxy{
-		target: target,
-		proxy:  proxy,
-	}
-	return proxy
+	return config
 }
 
-// createProxy creates a new reverse proxy for the given target
-func (r *Router) createProxy(target string) *httputil.ReverseProxy {
+// createProxy creates a new reverse proxy for the given target, waiting on it
+// no longer than the host's timeouts allow
+func (r *Router) createProxy(target string, options proxyOptions) *httputil.ReverseProxy {
+	timeouts := options.timeouts
 	targetURL, err := url.Parse("http://" + target)
 	if err != nil {
 		log.Printf("[PROXY] Failed to parse target URL %s: %v", target, err)
@@ -163,29 +324,60 @@ func (r *Router) createProxy(target string) *httputil.ReverseProxy {
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
 	// Configure transport
-	proxy.Transport = &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   10 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+	// Replicas share the target's network alias, spread connections across them
+	balancer := newBalancer(&net.Dialer{
+		Timeout:   parseTimeout(timeouts.DialTimeout, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	})
+	balancer.lookup = r.resolver.LookupHost
+	transport := &http.Transport{
+		DialContext:           balancer.DialContext,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		MaxIdleConnsPerHost:   10,
+		ResponseHeaderTimeout: responseHeaderTimeout(timeouts),
+	}
+	proxy.Transport = transport
+
+	// Send every write on to the client right away. Long polls may hold back
+	// their headers for as long as the request timeout allows.
+	if options.streaming {
+		proxy.FlushInterval = -1
+		transport.ResponseHeaderTimeout = 0
 	}
 
 	// Custom error handler
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
 		log.Printf("[PROXY] Error proxying to %s: %v", target, err)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		// Proxies are shared by the hosts of a pattern, find the one this request is for
+		host, _, _ := r.state.MatchHost(req.Host)
+		if isTimeout(err) {
+			r.serveError(w, req, host, http.StatusGatewayTimeout, "Gateway Timeout", 0)
+			return
+		}
+		r.serveError(w, req, host, http.StatusBadGateway, "Bad Gateway", 0)
 	}
 
+	// Cut off responses that stop sending data
+	streamIdle := parseTimeout(timeouts.StreamIdleTimeout, 0)
+
 	// Custom modify response to handle errors
 	proxy.ModifyResponse = func(resp *http.Response) error {
 		if resp.StatusCode >= 500 {
 			log.Printf("[PROXY] Upstream error from %s: %d", target, resp.StatusCode)
 		}
+		if streamIdle > 0 {
+			resp.Body = newIdleTimeoutBody(resp.Body, streamIdle)
+		}
+		if options.streaming {
+			// Keep caches and buffering proxies in front of us from holding the stream back
+			resp.Header.Set("X-Accel-Buffering", "no")
+			if resp.Header.Get("Cache-Control") == "" {
+				resp.Header.Set("Cache-Control", "no-cache")
+			}
+		}
 		return nil
 	}
 
@@ -241,7 +433,8 @@ func (r *Router) handleWebSocketProxy(w http.ResponseWriter, req *http.Request,
 	backendConn, err := net.Dial("tcp", target)
 	if err != nil {
 		log.Printf("[PROXY] WebSocket backend dial failed %s: %v", target, err)
-		http.Error(w, "Backend unavailable", http.StatusBadGateway)
+		host, _, _ := r.state.MatchHost(req.Host)
+		r.serveError(w, req, host, http.StatusBadGateway, "Backend unavailable", 0)
 		return
 	}
 	defer backendConn.Close()
@@ -262,6 +455,7 @@ func (r *Router) handleWebSocketProxy(w http.ResponseWriter, req *http.Request,
 	defer clientConn.Close()
 
 	// Forward the upgrade request to backend
+	tracing.Inject(req.Context(), req.Header)
 	err = req.Write(backendConn)
 	if err != nil {
 		log.Printf("[PROXY] WebSocket request forward failed: %v", err)
@@ -310,3 +504,17 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 	}
 	return w.ResponseWriter.Write(b)
 }
+
+// Hijack lets WebSocket upgrades take over the wrapped connection
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
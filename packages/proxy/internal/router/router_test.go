@@ -0,0 +1,219 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHTTPPropagatesTraceparent(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(tracing.TraceparentHeader)
+	}))
+	defer backend.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("app.example.com", strings.TrimPrefix(backend.URL, "http://"), "web", "app", "/up", false))
+	r := NewRouter(st, nil)
+
+	tracing.SetTracer(tracing.NewTracer(nil))
+	defer tracing.SetTracer(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	sc, err := tracing.ParseTraceparent(traceparent)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String(), "backend should continue the caller's trace")
+	assert.NotEqual(t, "00f067aa0ba902b7", sc.SpanID.String(), "backend should see the proxy's upstream span as parent")
+}
+
+func TestWildcardHostsAndDefaultBackend(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.Host))
+		}))
+	}
+	tenants, fallback := backend("tenants"), backend("fallback")
+	defer tenants.Close()
+	defer fallback.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("*.tenant.example.com", strings.TrimPrefix(tenants.URL, "http://"), "saas", "web", "/up", false))
+	r := NewRouter(st, nil)
+
+	get := func(host string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+		return rec
+	}
+
+	rec := get("acme.tenant.example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "tenants acme.tenant.example.com", rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("unknown.example.com").Code)
+
+	st.SetDefaultBackend(strings.TrimPrefix(fallback.URL, "http://"))
+	rec = get("unknown.example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "fallback unknown.example.com", rec.Body.String())
+}
+
+func TestRequestStats(t *testing.T) {
+	started, finish := make(chan struct{}), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			close(started)
+			<-finish
+		}
+	}))
+	defer backend.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("*.tenant.example.com", strings.TrimPrefix(backend.URL, "http://"), "saas", "web", "/up", false))
+	r := NewRouter(st, nil)
+
+	for _, url := range []string{"http://a.tenant.example.com/", "http://b.tenant.example.com/", "http://a.tenant.example.com/fail"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+
+	stats := r.RequestStats()
+	require.Contains(t, stats, "*.tenant.example.com", "requests count towards the matching host pattern")
+	assert.Equal(t, uint64(3), stats["*.tenant.example.com"].Requests)
+	assert.Equal(t, uint64(1), stats["*.tenant.example.com"].Errors)
+	assert.Positive(t, stats["*.tenant.example.com"].Latency)
+	assert.Zero(t, stats["*.tenant.example.com"].InFlight)
+
+	done := make(chan struct{})
+	go func() {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://a.tenant.example.com/slow", nil))
+		close(done)
+	}()
+	<-started
+	assert.Equal(t, int64(1), r.RequestStats()["*.tenant.example.com"].InFlight)
+	close(finish)
+	<-done
+	assert.Zero(t, r.RequestStats()["*.tenant.example.com"].InFlight)
+}
+
+func TestRequestStatsPercentile(t *testing.T) {
+	m := newRequestMetrics()
+	for i := 0; i < 90; i++ {
+		m.record("example.com", http.StatusOK, 3*time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		m.record("example.com", http.StatusOK, 200*time.Millisecond)
+	}
+	m.record("example.com", http.StatusOK, time.Minute)
+
+	stats := *m.hosts["example.com"]
+	assert.Equal(t, uint64(90), stats.LatencyBuckets[0])
+	assert.Equal(t, uint64(1), stats.LatencyBuckets[len(LatencyBounds)])
+	assert.Equal(t, 5*time.Millisecond, stats.Percentile(0.5))
+	assert.Equal(t, 250*time.Millisecond, stats.Percentile(0.95))
+	assert.Equal(t, 10*time.Second, stats.Percentile(1), "slower requests count as the last bound")
+	assert.Zero(t, RequestStats{}.Percentile(0.95))
+
+	var total RequestStats
+	total.Add(stats)
+	total.Add(stats)
+	assert.Equal(t, uint64(200), total.Requests)
+	assert.Equal(t, uint64(180), total.LatencyBuckets[0])
+	assert.Equal(t, 250*time.Millisecond, total.Percentile(0.95))
+}
+
+type fakeWaker struct {
+	st       *state.State
+	acquired int
+	released int
+	woken    int
+}
+
+func (f *fakeWaker) Wake(hostname string) {
+	f.woken++
+}
+
+func (f *fakeWaker) Acquire(ctx context.Context, hostname string) (func(), error) {
+	f.acquired++
+	f.st.SetSleeping("blog", "web", false)
+	return func() { f.released++ }, nil
+}
+
+func TestScaleToZeroHostsWakeOnRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("awake"))
+	}))
+	defer backend.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", strings.TrimPrefix(backend.URL, "http://"), "blog", "web", "/up", false))
+	require.NoError(t, st.SetScaleToZero("blog.example.com", true, "", nil))
+	st.SetSleeping("blog", "web", true)
+
+	waker := &fakeWaker{st: st}
+	r := NewRouter(st, nil)
+	r.SetWaker(waker)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://blog.example.com/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "awake", rec.Body.String())
+	assert.Equal(t, 1, waker.acquired)
+	assert.Equal(t, 1, waker.released)
+}
+
+func TestStoppedHostsAreNotWoken(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.SetScaleToZero("blog.example.com", true, "", nil))
+	st.SetStopped("blog", "web", true)
+
+	waker := &fakeWaker{st: st}
+	r := NewRouter(st, nil)
+	r.SetWaker(waker)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://blog.example.com/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, 0, waker.acquired)
+	assert.Equal(t, 0, waker.woken)
+}
+
+func TestStartingPageForBrowsers(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.SetScaleToZero("blog.example.com", true, "", &state.ColdStartPolicy{StartingPage: true}))
+	st.SetSleeping("blog", "web", true)
+
+	waker := &fakeWaker{st: st}
+	r := NewRouter(st, nil)
+	r.SetWaker(waker)
+
+	req := httptest.NewRequest(http.MethodGet, "http://blog.example.com/posts", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "blog.example.com is starting")
+	assert.Equal(t, 1, waker.woken)
+	assert.Equal(t, 0, waker.acquired, "browsers don't wait for the start")
+}
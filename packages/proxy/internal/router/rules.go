@@ -0,0 +1,129 @@
+package router
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// BucketCookie keeps a client in the same percentage bucket across requests,
+// so it sees the same side of an experiment
+const BucketCookie = "iop_bucket"
+
+// bucketCookieMaxAge outlasts a typical experiment
+const bucketCookieMaxAge = 90 * 24 * time.Hour
+
+// ValidateRoutingRules checks that rules can be applied
+func ValidateRoutingRules(rules []state.RoutingRule) error {
+	names := make(map[string]bool)
+	percent := 0
+	for i, rule := range rules {
+		label := fmt.Sprintf("rule %d", i+1)
+		if rule.Name != "" {
+			if names[rule.Name] {
+				return fmt.Errorf("rule name %q is used twice", rule.Name)
+			}
+			names[rule.Name] = true
+			label = fmt.Sprintf("rule %q", rule.Name)
+		}
+
+		if rule.Target == "" {
+			return fmt.Errorf("%s has no target", label)
+		}
+		if rule.Header != "" && rule.Cookie != "" {
+			return fmt.Errorf("%s matches a header and a cookie, choose one", label)
+		}
+		if rule.Value != "" && rule.Header == "" && rule.Cookie == "" {
+			return fmt.Errorf("%s has a value but no header or cookie", label)
+		}
+		if rule.Percent < 0 || rule.Percent > 100 {
+			return fmt.Errorf("%s percent must be between 1 and 100", label)
+		}
+		if rule.Header == "" && rule.Cookie == "" && rule.Percent == 0 {
+			return fmt.Errorf("%s matches every request, set a header, cookie or percent", label)
+		}
+		percent += rule.Percent
+	}
+
+	// Each rule gets its own range of buckets, so they can't add up to more than everyone
+	if percent > 100 {
+		return fmt.Errorf("rule percentages add up to %d, at most 100 is possible", percent)
+	}
+	return nil
+}
+
+// matchRule returns the first rule a request matches, or nil. Percentage
+// rules take consecutive ranges of buckets in order: with 10 and 20 percent,
+// buckets 0-9 match the first and 10-29 the second. A client without a
+// bucket is assigned one, which is stored in a cookie on the response.
+func matchRule(w http.ResponseWriter, req *http.Request, rules []state.RoutingRule) *state.RoutingRule {
+	bucket := -1
+	end := 0
+	for i := range rules {
+		rule := &rules[i]
+		start := end
+		end += rule.Percent
+
+		if !matchesValue(req, rule) {
+			continue
+		}
+		if rule.Percent > 0 {
+			if bucket < 0 {
+				bucket = clientBucket(w, req)
+			}
+			if bucket < start || bucket >= end {
+				continue
+			}
+		}
+		return rule
+	}
+	return nil
+}
+
+// matchesValue reports whether a request has the header or cookie of a rule.
+// A rule without either matches every request.
+func matchesValue(req *http.Request, rule *state.RoutingRule) bool {
+	var value string
+	switch {
+	case rule.Header != "":
+		values, ok := req.Header[http.CanonicalHeaderKey(rule.Header)]
+		if !ok {
+			return false
+		}
+		value = values[0]
+	case rule.Cookie != "":
+		cookie, err := req.Cookie(rule.Cookie)
+		if err != nil {
+			return false
+		}
+		value = cookie.Value
+	default:
+		return true
+	}
+	return rule.Value == "" || value == rule.Value
+}
+
+// clientBucket returns the client's bucket from 0 to 99, assigning a random
+// one to new clients
+func clientBucket(w http.ResponseWriter, req *http.Request) int {
+	if cookie, err := req.Cookie(BucketCookie); err == nil {
+		if bucket, err := strconv.Atoi(cookie.Value); err == nil && bucket >= 0 && bucket < 100 {
+			return bucket
+		}
+	}
+
+	bucket := rand.Intn(100)
+	http.SetCookie(w, &http.Cookie{
+		Name:     BucketCookie,
+		Value:    strconv.Itoa(bucket),
+		Path:     "/",
+		MaxAge:   int(bucketCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return bucket
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRoutingRules(t *testing.T) {
+	assert.NoError(t, ValidateRoutingRules(nil))
+	assert.NoError(t, ValidateRoutingRules([]state.RoutingRule{
+		{Name: "beta", Header: "X-Beta", Value: "1", Target: "beta:80"},
+		{Cookie: "plan", Percent: 50, Target: "b:80"},
+		{Percent: 50, Target: "c:80"},
+	}))
+
+	for message, rules := range map[string][]state.RoutingRule{
+		`rule 1 has no target`:                       {{Header: "X-Beta"}},
+		`rule "a" matches a header and a cookie`:     {{Name: "a", Header: "X-Beta", Cookie: "beta", Target: "b:80"}},
+		`rule 1 has a value but no header or cookie`: {{Value: "1", Percent: 10, Target: "b:80"}},
+		`rule 1 percent must be between 1 and 100`:   {{Percent: 101, Target: "b:80"}},
+		`rule 1 matches every request`:               {{Target: "b:80"}},
+		`rule name "a" is used twice`:                {{Name: "a", Percent: 1, Target: "b:80"}, {Name: "a", Percent: 1, Target: "c:80"}},
+		`rule percentages add up to 110`:             {{Percent: 60, Target: "b:80"}, {Percent: 50, Target: "c:80"}},
+	} {
+		err := ValidateRoutingRules(rules)
+		if assert.Error(t, err, message) {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+}
+
+func TestRoutingRules(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	stable, beta, canary := backend("stable"), backend("beta"), backend("canary")
+	defer stable.Close()
+	defer beta.Close()
+	defer canary.Close()
+	target := func(s *httptest.Server) string { return strings.TrimPrefix(s.URL, "http://") }
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", target(stable), "shop", "web", "/up", false))
+	require.NoError(t, st.SetHostRules("shop.example.com", []state.RoutingRule{
+		{Name: "beta", Header: "X-Beta", Value: "1", Target: target(beta)},
+		{Name: "opt-in", Cookie: "beta", Target: target(beta)},
+		{Name: "canary", Percent: 20, Target: target(canary)},
+	}))
+	r := NewRouter(st, nil)
+
+	get := func(modify func(req *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://shop.example.com/", nil)
+		modify(req)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	bucket := func(n string) func(req *http.Request) {
+		return func(req *http.Request) { req.AddCookie(&http.Cookie{Name: BucketCookie, Value: n}) }
+	}
+
+	assert.Equal(t, "beta", get(func(req *http.Request) {
+		req.Header.Set("X-Beta", "1")
+		bucket("50")(req)
+	}).Body.String())
+	assert.Equal(t, "stable", get(func(req *http.Request) {
+		req.Header.Set("X-Beta", "0")
+		bucket("50")(req)
+	}).Body.String())
+	assert.Equal(t, "beta", get(func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: "beta", Value: "yes"})
+	}).Body.String())
+
+	// Buckets 0-19 get the canary
+	assert.Equal(t, "canary", get(bucket("0")).Body.String())
+	assert.Equal(t, "canary", get(bucket("19")).Body.String())
+	assert.Equal(t, "stable", get(bucket("20")).Body.String())
+
+	// New clients are assigned a bucket they keep
+	rec := get(func(req *http.Request) {})
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, BucketCookie, cookies[0].Name)
+	assert.Equal(t, rec.Body.String(), get(bucket(cookies[0].Value)).Body.String())
+	assert.Empty(t, get(bucket("42")).Result().Cookies())
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// startingPageRefresh is how often the starting page reloads, in seconds
+const startingPageRefresh = "2"
+
+var startingPage = template.Must(template.New("starting").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="` + startingPageRefresh + `">
+<title>Starting {{.}}</title>
+<style>body{font-family:system-ui,sans-serif;color:#333;display:flex;align-items:center;justify-content:center;height:90vh}</style>
+</head>
+<body><p>{{.}} is starting, this page reloads when it's ready.</p></body>
+</html>
+`))
+
+// wantsStartingPage reports whether a request for a sleeping host should get
+// the starting page instead of waiting: the host opted in and the request
+// comes from a browser navigating to a page
+func (r *Router) wantsStartingPage(req *http.Request, host *state.Host) bool {
+	if !host.Sleeping || host.ColdStart == nil || !host.ColdStart.StartingPage {
+		return false
+	}
+	if req.Method != http.MethodGet || r.isWebSocketUpgrade(req) {
+		return false
+	}
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// serveStartingPage answers with a page that reloads until the app is up. It
+// is a 503 so that crawlers and caches don't keep it.
+func (r *Router) serveStartingPage(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", startingPageRefresh)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	startingPage.Execute(w, req.Host)
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamingHosts(t *testing.T) {
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for i := 1; i <= 3; i++ {
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+			fmt.Fprintf(w, "event %d\n", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer backend.Close()
+	defer close(next)
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("events.example.com", strings.TrimPrefix(backend.URL, "http://"), "events", "web", "/up", false))
+	require.NoError(t, st.SetHostStreaming("events.example.com", true))
+
+	// The server's write timeout would end the stream before its last event
+	proxy := httptest.NewUnstartedServer(NewRouter(st, nil))
+	proxy.Config.WriteTimeout = 200 * time.Millisecond
+	proxy.Start()
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/events", nil)
+	req.Host = "events.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "no", resp.Header.Get("X-Accel-Buffering"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	// Each event reaches the client before the backend sends the next one
+	lines := bufio.NewReader(resp.Body)
+	for i := 1; i <= 3; i++ {
+		next <- struct{}{}
+		line, err := lines.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("event %d\n", i), line)
+		time.Sleep(100 * time.Millisecond)
+	}
+	rest, _ := io.ReadAll(lines)
+	assert.Empty(t, rest)
+}
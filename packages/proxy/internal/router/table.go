@@ -0,0 +1,175 @@
+package router
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// defaultBackendKey caches the default backend's proxy, it can't clash with a hostname
+const defaultBackendKey = "*"
+
+// routingTable maps hosts to their reverse proxies. A table is never changed
+// once published: updates copy it and swap the copy in, so requests read
+// it without locking and always see a consistent set of routes.
+type routingTable struct {
+	proxies map[string]*routerProxy // By host key, or host key -> rule target
+}
+
+type routerProxy struct {
+	target  string
+	options proxyOptions
+	proxy   *httputil.ReverseProxy
+}
+
+// proxyOptions are the settings of a host that shape its reverse proxy
+type proxyOptions struct {
+	timeouts  state.HostTimeouts
+	streaming bool
+}
+
+// hostProxyOptions returns the proxy settings of a host
+func hostProxyOptions(host *state.Host) proxyOptions {
+	return proxyOptions{timeouts: host.HostTimeouts, streaming: host.Streaming}
+}
+
+// getOrCreateProxy returns a reverse proxy for the given hostname/target
+// combination. Routes missing from the table, e.g. a host deployed since
+// the last rebuild, are added to a copy of it.
+func (r *Router) getOrCreateProxy(hostname, target string, options proxyOptions) *httputil.ReverseProxy {
+	if hp := r.table.Load().proxies[hostname]; hp != nil && hp.target == target && hp.options == options {
+		return hp.proxy
+	}
+
+	r.tableMu.Lock()
+	defer r.tableMu.Unlock()
+
+	// Another request may have added it while we waited
+	current := r.table.Load()
+	if hp := current.proxies[hostname]; hp != nil && hp.target == target && hp.options == options {
+		return hp.proxy
+	}
+
+	proxies := make(map[string]*routerProxy, len(current.proxies)+1)
+	for key, hp := range current.proxies {
+		proxies[key] = hp
+	}
+	hp := &routerProxy{target: target, options: options, proxy: r.createProxy(target, options)}
+	if replaced := proxies[hostname]; replaced != nil {
+		closeIdle(replaced)
+	}
+	proxies[hostname] = hp
+	r.table.Store(&routingTable{proxies: proxies})
+	return hp.proxy
+}
+
+// Watch builds the routing table and rebuilds it whenever a host or the
+// default backend changes, so requests never use a removed host's proxy.
+// A deploy or switch also drops the connections to the host's backend, which
+// may now resolve to other containers.
+func (r *Router) Watch() {
+	r.Rebuild()
+	r.state.OnHostChanged(func(change state.HostChange) {
+		r.Rebuild()
+		if change.Switched {
+			r.switched(change.Hostname)
+		}
+	})
+}
+
+// switched looks up a deployed or switched host's backend again and replaces
+// its proxies, so the switch takes effect with the next request
+func (r *Router) switched(hostname string) {
+	host, _, err := r.state.GetHost(hostname)
+	if err != nil {
+		return
+	}
+	backend := targetHost(host.Target)
+	r.resolver.forget(backend)
+	r.invalidate(backend)
+}
+
+// invalidate replaces the proxies of the targets on a backend hostname and
+// closes the old ones' idle connections. Requests still using them finish
+// normally, new ones connect to the addresses the hostname resolves to now.
+func (r *Router) invalidate(backend string) {
+	r.tableMu.Lock()
+	defer r.tableMu.Unlock()
+
+	current := r.table.Load()
+	proxies := make(map[string]*routerProxy, len(current.proxies))
+	var replaced []*routerProxy
+	for key, hp := range current.proxies {
+		if targetHost(hp.target) == backend {
+			replaced = append(replaced, hp)
+			hp = &routerProxy{target: hp.target, options: hp.options, proxy: r.createProxy(hp.target, hp.options)}
+		}
+		proxies[key] = hp
+	}
+	if len(replaced) == 0 {
+		return
+	}
+	r.table.Store(&routingTable{proxies: proxies})
+	for _, hp := range replaced {
+		closeIdle(hp)
+	}
+	log.Printf("[PROXY] Dropped pooled connections to %s", backend)
+}
+
+// Rebuild replaces the routing table with one built from the state, in one
+// step. Proxies whose target and settings didn't change are kept along with
+// their idle connections, those of removed hosts and targets are closed.
+func (r *Router) Rebuild() {
+	hosts := r.state.GetRoutes()
+	defaultTarget := r.state.GetDefaultBackend()
+
+	r.tableMu.Lock()
+	defer r.tableMu.Unlock()
+
+	current := r.table.Load()
+	proxies := make(map[string]*routerProxy)
+	add := func(key, target string, options proxyOptions) {
+		if hp := current.proxies[key]; hp != nil && hp.target == target && hp.options == options {
+			proxies[key] = hp
+			return
+		}
+		proxies[key] = &routerProxy{target: target, options: options, proxy: r.createProxy(target, options)}
+	}
+
+	for key, host := range hosts {
+		if host.Mode == state.HostModePassthrough || host.OnDemand {
+			continue
+		}
+		options := hostProxyOptions(&host)
+		add(key, host.Target, options)
+		for _, rule := range host.Rules {
+			add(key+" -> "+rule.Target, rule.Target, options)
+		}
+	}
+	if defaultTarget != "" {
+		add(defaultBackendKey, defaultTarget, proxyOptions{})
+	}
+
+	for key, hp := range current.proxies {
+		if proxies[key] != hp {
+			closeIdle(hp)
+		}
+	}
+	backends := make(map[string]bool)
+	for _, hp := range proxies {
+		backends[targetHost(hp.target)] = true
+	}
+	r.resolver.retain(backends)
+	r.table.Store(&routingTable{proxies: proxies})
+	log.Printf("[PROXY] Routing table rebuilt with %d routes", len(proxies))
+}
+
+// closeIdle releases the idle backend connections of a proxy leaving the
+// table. Requests still using it finish normally.
+func closeIdle(hp *routerProxy) {
+	if transport, ok := hp.proxy.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+}
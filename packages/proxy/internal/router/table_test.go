@@ -0,0 +1,102 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildRoutingTable(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	target := strings.TrimPrefix(backend.URL, "http://")
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("a.example.com", target, "a", "web", "/up", false))
+	require.NoError(t, st.DeployHost("b.example.com", target, "b", "web", "/up", false))
+	r := NewRouter(st, nil)
+
+	r.Rebuild()
+	table := r.table.Load()
+	require.Len(t, table.proxies, 2)
+	a := table.proxies["a.example.com"]
+
+	// Unchanged hosts keep their proxy, removed ones leave the table
+	require.NoError(t, st.RemoveHost("b.example.com"))
+	r.Rebuild()
+	table = r.table.Load()
+	assert.Len(t, table.proxies, 1)
+	assert.Same(t, a, table.proxies["a.example.com"])
+
+	// A changed setting replaces the proxy
+	require.NoError(t, st.SetHostStreaming("a.example.com", true))
+	r.Rebuild()
+	assert.NotSame(t, a, r.table.Load().proxies["a.example.com"])
+}
+
+func TestRoutingTableConcurrentUpdates(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	target := strings.TrimPrefix(backend.URL, "http://")
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, st.DeployHost(fmt.Sprintf("app%d.example.com", i), target, "app", fmt.Sprintf("web%d", i), "/up", false))
+	}
+	r := NewRouter(st, nil)
+
+	// Requests race with deploys and rebuilds, run with -race to check them
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		host := fmt.Sprintf("app%d.example.com", i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				st.SetHostStreaming(host, j%2 == 0)
+				r.Rebuild()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, r.table.Load().proxies, 4)
+}
+
+func TestWatchRebuildsOnHostChanges(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("a.example.com", "a:3000", "a", "web", "/up", false))
+	r := NewRouter(st, nil)
+	r.Watch()
+	assert.Contains(t, r.table.Load().proxies, "a.example.com")
+
+	require.NoError(t, st.RemoveHost("a.example.com"))
+	assert.Eventually(t, func() bool {
+		return len(r.table.Load().proxies) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	st.SetDefaultBackend("fallback:3000")
+	assert.Eventually(t, func() bool {
+		return r.table.Load().proxies[defaultBackendKey] != nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// defaultDialTimeout bounds connecting to a backend when a host sets no dial timeout
+const defaultDialTimeout = 10 * time.Second
+
+// parseTimeout returns a timeout from a host's configuration, or def if it
+// is empty or invalid. Values are validated when they are set.
+func parseTimeout(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// responseHeaderTimeout returns how long a host's backend gets to start answering
+func responseHeaderTimeout(timeouts state.HostTimeouts) time.Duration {
+	def, _ := time.ParseDuration(state.DefaultResponseTimeout)
+	return parseTimeout(timeouts.ResponseTimeout, def)
+}
+
+// isTimeout reports whether proxying failed because a timeout ran out
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errStreamIdle) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// errStreamIdle ends a response whose backend stopped sending data
+var errStreamIdle = errors.New("no response data within the stream idle timeout")
+
+// idleTimeoutBody closes a response body when no data arrives for a while,
+// so a stalled stream doesn't hold the client's connection forever
+type idleTimeoutBody struct {
+	body  io.ReadCloser
+	idle  time.Duration
+	timer *time.Timer
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, idle time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, idle: idle}
+	b.timer = time.AfterFunc(idle, func() {
+		b.mu.Lock()
+		b.expired = true
+		b.mu.Unlock()
+		// Unblocks a Read waiting on the backend
+		body.Close()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.idle)
+	}
+
+	b.mu.Lock()
+	expired := b.expired
+	b.mu.Unlock()
+	if err != nil && expired {
+		return n, errStreamIdle
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
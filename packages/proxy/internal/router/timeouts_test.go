@@ -0,0 +1,94 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-headers":
+			select {
+			case <-time.After(time.Second):
+			case <-release:
+			}
+		case "/stream":
+			// Sends a first chunk, then stalls
+			w.Write([]byte("first"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(time.Second):
+			case <-release:
+			}
+		}
+		w.Write([]byte("done"))
+	}))
+	defer backend.Close()
+	defer close(release) // Before closing the backend, which waits for its handlers
+	target := strings.TrimPrefix(backend.URL, "http://")
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("app.example.com", target, "app", "web", "/up", false))
+	r := NewRouter(st, nil)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app.example.com"+path, nil))
+		return rec
+	}
+
+	// The default timeouts leave a prompt backend alone
+	rec := get("/fast")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "done", rec.Body.String())
+
+	// Waiting too long for the response headers is a gateway timeout
+	require.NoError(t, st.SetHostTimeouts("app.example.com", state.HostTimeouts{ResponseTimeout: "50ms"}))
+	start := time.Now()
+	rec = get("/slow-headers")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// So is a request outlasting the request timeout
+	require.NoError(t, st.SetHostTimeouts("app.example.com", state.HostTimeouts{RequestTimeout: "50ms"}))
+	rec = get("/slow-headers")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	// A stalled stream is cut off after what was already sent
+	require.NoError(t, st.SetHostTimeouts("app.example.com", state.HostTimeouts{StreamIdleTimeout: "50ms"}))
+	start = time.Now()
+	rec = get("/stream")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "first", rec.Body.String())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestIdleTimeoutBodyResetsOnData(t *testing.T) {
+	reader, writer := io.Pipe()
+	body := newIdleTimeoutBody(reader, 100*time.Millisecond)
+	defer body.Close()
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(40 * time.Millisecond)
+			writer.Write([]byte("x"))
+		}
+		writer.Close()
+	}()
+
+	// Each chunk arrives within the idle timeout, though all of them take longer
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "xxxx", string(data))
+}
@@ -0,0 +1,148 @@
+package router
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// defaultCipherSuites are used for TLS 1.2 when no policy overrides them.
+// TLS 1.3 suites are not configurable in Go.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+// defaultALPN offers HTTP/2 with a fallback to HTTP/1.1
+var defaultALPN = []string{"h2", "http/1.1"}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converts a version such as "1.2" to its crypto/tls constant
+func ParseTLSVersion(version string) (uint16, error) {
+	if v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", version)
+}
+
+// ParseCipherSuites converts Go cipher suite names such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 to their IDs
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ValidateTLSPolicy checks that a policy can be applied
+func ValidateTLSPolicy(policy *state.TLSPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.MinVersion != "" {
+		if _, err := ParseTLSVersion(policy.MinVersion); err != nil {
+			return err
+		}
+	}
+	if _, err := ParseCipherSuites(policy.CipherSuites); err != nil {
+		return err
+	}
+	for _, proto := range policy.ALPN {
+		if proto != "h2" && proto != "http/1.1" {
+			return fmt.Errorf("unsupported ALPN protocol %q, expected h2 or http/1.1", proto)
+		}
+	}
+	return nil
+}
+
+// applyTLSPolicy overrides the settings a policy sets on a TLS config. Policies
+// are validated when stored, so parse errors are not expected here.
+func applyTLSPolicy(config *tls.Config, policy *state.TLSPolicy) {
+	if policy == nil {
+		return
+	}
+
+	if policy.MinVersion != "" {
+		if v, err := ParseTLSVersion(policy.MinVersion); err == nil {
+			config.MinVersion = v
+			// Legacy versions can't use the AEAD-only default suites, so fall
+			// back to Go's defaults unless suites are set explicitly
+			if v < tls.VersionTLS12 && len(policy.CipherSuites) == 0 {
+				config.CipherSuites = nil
+			}
+		}
+	}
+	if len(policy.CipherSuites) > 0 {
+		if ids, err := ParseCipherSuites(policy.CipherSuites); err == nil {
+			config.CipherSuites = ids
+		}
+	}
+	if len(policy.ALPN) > 0 {
+		config.NextProtos = policy.ALPN
+	}
+}
+
+// mergeTLSPolicy combines the global policy with a host's, the host's fields winning
+func mergeTLSPolicy(global, host *state.TLSPolicy) *state.TLSPolicy {
+	merged := &state.TLSPolicy{}
+	for _, policy := range []*state.TLSPolicy{global, host} {
+		if policy == nil {
+			continue
+		}
+		if policy.MinVersion != "" {
+			merged.MinVersion = policy.MinVersion
+		}
+		if len(policy.CipherSuites) > 0 {
+			merged.CipherSuites = policy.CipherSuites
+		}
+		if len(policy.ALPN) > 0 {
+			merged.ALPN = policy.ALPN
+		}
+	}
+	return merged
+}
+
+// configForClient returns the TLS config for a handshake, applying the global
+// and per-host policies. Returns nil to use the base config when no policy is set.
+func (r *Router) configForClient(base *tls.Config, hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	global := r.state.GetDefaultTLSPolicy()
+
+	var hostPolicy *state.TLSPolicy
+	if host, _, err := r.state.MatchHost(hello.ServerName); err == nil {
+		hostPolicy = host.TLS
+	}
+
+	if global == nil && hostPolicy == nil {
+		return nil, nil
+	}
+
+	config := base.Clone()
+	config.GetConfigForClient = nil
+	applyTLSPolicy(config, mergeTLSPolicy(global, hostPolicy))
+	return config, nil
+}
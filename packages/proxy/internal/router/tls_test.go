@@ -0,0 +1,55 @@
+package router
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTLSPolicy(t *testing.T) {
+	assert.NoError(t, ValidateTLSPolicy(&state.TLSPolicy{
+		MinVersion:   "1.0",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"},
+		ALPN:         []string{"http/1.1"},
+	}))
+
+	assert.Error(t, ValidateTLSPolicy(&state.TLSPolicy{MinVersion: "1.4"}))
+	assert.Error(t, ValidateTLSPolicy(&state.TLSPolicy{CipherSuites: []string{"TLS_MADE_UP"}}))
+	assert.Error(t, ValidateTLSPolicy(&state.TLSPolicy{ALPN: []string{"h3"}}))
+}
+
+func TestConfigForClient(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("legacy.example.com", "legacy:80", "iot", "api", "/up", true))
+	require.NoError(t, st.DeployHost("app.example.com", "app:3000", "web", "app", "/up", true))
+
+	r := NewRouter(st, nil)
+	base := r.GetTLSConfig()
+
+	// Without policies the base config is used as is
+	config, err := base.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "app.example.com"})
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	require.NoError(t, st.SetHostTLSPolicy("legacy.example.com", &state.TLSPolicy{
+		MinVersion: "1.0",
+		ALPN:       []string{"http/1.1"},
+	}))
+	st.SetDefaultTLSPolicy(&state.TLSPolicy{MinVersion: "1.3"})
+
+	config, err = base.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "legacy.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS10), config.MinVersion)
+	assert.Nil(t, config.CipherSuites, "legacy versions should fall back to Go's cipher suites")
+	assert.Equal(t, []string{"http/1.1"}, config.NextProtos)
+
+	config, err = base.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "app.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	assert.Equal(t, defaultCipherSuites, config.CipherSuites)
+	assert.Equal(t, defaultALPN, config.NextProtos)
+}
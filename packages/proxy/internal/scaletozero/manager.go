@@ -0,0 +1,469 @@
+// Package scaletozero stops the containers of idle apps whose hosts scale to
+// zero and starts them again when the next request arrives. Apps with a warm
+// pool keep some containers paused instead, which resume in milliseconds.
+package scaletozero
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+const (
+	// DefaultIdleTimeout applies to hosts without their own idle timeout
+	DefaultIdleTimeout = 15 * time.Minute
+	// DefaultMaxWait is how long a request waits for an app to start
+	DefaultMaxWait = 30 * time.Second
+	// DefaultQueueDepth is how many requests may wait for an app to start
+	DefaultQueueDepth = 100
+	// sweepInterval is how often idle apps are looked for
+	sweepInterval = 30 * time.Second
+	// wakeTimeout bounds how long a sleeping app gets to start, unless a
+	// host's cold start policy lets requests wait longer
+	wakeTimeout = 60 * time.Second
+	// readinessInterval is the wait between health checks while an app starts
+	readinessInterval = 250 * time.Millisecond
+	// stopTimeout is how long a stopped container gets to shut down
+	stopTimeout = 30 * time.Second
+)
+
+// Docker is the part of the Docker Engine API the manager uses
+type Docker interface {
+	AllContainers(ctx context.Context, label string) ([]docker.Container, error)
+	Start(ctx context.Context, id string) error
+	Stop(ctx context.Context, id string, timeout time.Duration) error
+	Pause(ctx context.Context, id string) error
+	Unpause(ctx context.Context, id string) error
+}
+
+// wakeup is a start of a sleeping app that requests wait on
+type wakeup struct {
+	done chan struct{}
+	err  error
+}
+
+// app tracks the activity of one app
+type app struct {
+	inFlight   int
+	waiting    int // Requests held until the app has started
+	lastActive time.Time
+	asleep     bool
+	waking     *wakeup
+	stopping   chan struct{} // Closed once the containers of a sleeping app have stopped
+}
+
+// Manager holds requests for sleeping apps until their containers have
+// started and passed a health check, and stops apps without requests for
+// their idle timeout
+type Manager struct {
+	state  *state.State
+	docker Docker
+	health core.HealthChecker
+
+	// now is replaced in tests
+	now func() time.Time
+
+	mu   sync.Mutex
+	apps map[string]*app // Keyed by project/app
+}
+
+// NewManager creates a scale to zero manager
+func NewManager(st *state.State, d Docker, health core.HealthChecker) *Manager {
+	return &Manager{
+		state:  st,
+		docker: d,
+		health: health,
+		now:    time.Now,
+		apps:   make(map[string]*app),
+	}
+}
+
+// appOf returns the app a host routes to, from its app or its target's
+// project-specific alias ("blog-web:3000")
+func appOf(project string, host *state.Host) string {
+	if host.App != "" {
+		return host.App
+	}
+	targetHost, _, err := net.SplitHostPort(host.Target)
+	if err != nil {
+		targetHost = host.Target
+	}
+	return strings.TrimPrefix(targetHost, project+"-")
+}
+
+// coldStartLimits returns how long requests for a host wait for its app to
+// start and how many may wait at once
+func coldStartLimits(host *state.Host) (time.Duration, int) {
+	maxWait, depth := DefaultMaxWait, DefaultQueueDepth
+	if policy := host.ColdStart; policy != nil {
+		if d, err := time.ParseDuration(policy.MaxWait); err == nil && d > 0 {
+			maxWait = d
+		}
+		if policy.QueueDepth > 0 {
+			depth = policy.QueueDepth
+		}
+	}
+	return maxWait, depth
+}
+
+// appFor returns the tracked app for a key. Callers hold m.mu.
+func (m *Manager) appFor(key string) *app {
+	a, exists := m.apps[key]
+	if !exists {
+		a = &app{lastActive: m.now()}
+		m.apps[key] = a
+	}
+	return a
+}
+
+// Acquire counts a request towards its app's activity, first starting the
+// app if it is asleep. Requests for a starting app wait at most the host's
+// max wait, and fail straight away once its queue is full. The returned
+// release must be called when the request is done.
+func (m *Manager) Acquire(ctx context.Context, hostname string) (func(), error) {
+	host, project, err := m.state.GetHost(hostname)
+	if err != nil {
+		return func() {}, nil
+	}
+	appName := appOf(project, host)
+	key := project + "/" + appName
+
+	m.mu.Lock()
+	a := m.appFor(key)
+	a.inFlight++
+	a.lastActive = m.now()
+	var released sync.Once
+	release := func() {
+		released.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			a.inFlight--
+			a.lastActive = m.now()
+		})
+	}
+
+	if !host.Sleeping {
+		m.mu.Unlock()
+		return release, nil
+	}
+
+	maxWait, depth := coldStartLimits(host)
+	if a.waiting >= depth {
+		m.mu.Unlock()
+		release()
+		return nil, fmt.Errorf("queue for %s is full, %d requests waiting for it to start", key, depth)
+	}
+	a.waiting++
+	w := m.startWake(a, key, project, appName, host)
+	m.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-w.done:
+		err = w.err
+	case <-timer.C:
+		err = fmt.Errorf("%s not started within %s", key, maxWait)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	m.mu.Lock()
+	a.waiting--
+	m.mu.Unlock()
+
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// Wake starts a host's app if it is asleep without waiting for it, for
+// requests answered with a starting page
+func (m *Manager) Wake(hostname string) {
+	host, project, err := m.state.GetHost(hostname)
+	if err != nil || !host.Sleeping {
+		return
+	}
+	appName := appOf(project, host)
+	key := project + "/" + appName
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.appFor(key)
+	a.lastActive = m.now()
+	m.startWake(a, key, project, appName, host)
+}
+
+// startWake returns the app's start in progress, beginning one if there is
+// none so that concurrent requests share it. Callers hold m.mu.
+func (m *Manager) startWake(a *app, key, project, appName string, host *state.Host) *wakeup {
+	if a.waking == nil {
+		a.waking = &wakeup{done: make(chan struct{})}
+		go m.wake(key, project, appName, host, a.waking)
+	}
+	return a.waking
+}
+
+// wake starts a sleeping app's containers and waits until the host answers
+// its health check
+func (m *Manager) wake(key, project, appName string, host *state.Host, w *wakeup) {
+	timeout := wakeTimeout
+	if maxWait, _ := coldStartLimits(host); maxWait > timeout {
+		timeout = maxWait
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Let a sweep finish stopping the app before starting it again
+	m.mu.Lock()
+	stopping := m.appFor(key).stopping
+	m.mu.Unlock()
+	if stopping != nil {
+		<-stopping
+	}
+
+	start := m.now()
+	log.Printf("[SCALE-TO-ZERO] [%s] Request waiting, starting app", key)
+
+	w.err = m.start(ctx, project, appName)
+	if w.err == nil {
+		w.err = m.waitReady(ctx, host)
+	}
+
+	m.mu.Lock()
+	a := m.appFor(key)
+	a.waking = nil
+	if w.err == nil {
+		a.asleep = false
+		a.lastActive = m.now()
+	}
+	m.mu.Unlock()
+
+	if w.err != nil {
+		log.Printf("[SCALE-TO-ZERO] [%s] Failed to start app: %v", key, w.err)
+	} else {
+		m.state.SetSleeping(project, appName, false)
+		log.Printf("[SCALE-TO-ZERO] [%s] App ready after %dms", key, m.now().Sub(start).Milliseconds())
+	}
+	close(w.done)
+}
+
+// start resumes the paused containers of an app and starts the stopped ones
+func (m *Manager) start(ctx context.Context, project, appName string) error {
+	containers, err := m.containers(ctx, project, appName)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found for %s/%s", project, appName)
+	}
+
+	for _, container := range containers {
+		var err error
+		switch container.State {
+		case "running":
+			continue
+		case "paused":
+			err = m.docker.Unpause(ctx, container.ID)
+		default:
+			err = m.docker.Start(ctx, container.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitReady checks the host's health path until it passes
+func (m *Manager) waitReady(ctx context.Context, host *state.Host) error {
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+
+	for {
+		err := m.health.CheckHealth(ctx, host.Target, host.HealthPath)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("app not ready in time: %w", err)
+		}
+	}
+}
+
+// containers lists an app's containers, running or not
+func (m *Manager) containers(ctx context.Context, project, appName string) ([]docker.Container, error) {
+	all, err := m.docker.AllContainers(ctx, "iop.project="+project)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []docker.Container
+	for _, container := range all {
+		app := container.Labels["iop.app"]
+		if app == "" {
+			app = container.Labels["iop.service"]
+		}
+		if container.Labels["iop.type"] == "service" && app == appName {
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+// Run stops idle apps until the context is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	log.Println("[SCALE-TO-ZERO] Starting inactivity monitor")
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		m.Sweep(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("[SCALE-TO-ZERO] Stopping inactivity monitor")
+			return
+		}
+	}
+}
+
+// Sweep stops the containers of apps that had no requests for their idle
+// timeout. Apps found without running containers, e.g. after a proxy
+// restart, are marked asleep so the next request starts them.
+func (m *Manager) Sweep(ctx context.Context) {
+	type idleApp struct {
+		project, app string
+		timeout      time.Duration
+		warmPool     int
+		sleeping     bool
+	}
+
+	// Apps with several hosts wait for the longest idle timeout and keep the
+	// largest warm pool
+	apps := make(map[string]*idleApp)
+	for _, h := range m.state.GetScaleToZeroHosts() {
+		// Stopped apps stay down until started, there is nothing to put to sleep
+		if h.Host.Stopped {
+			continue
+		}
+
+		timeout := DefaultIdleTimeout
+		if d, err := time.ParseDuration(h.Host.IdleTimeout); err == nil && d > 0 {
+			timeout = d
+		}
+
+		warmPool := 0
+		if h.Host.ColdStart != nil {
+			warmPool = h.Host.ColdStart.WarmPool
+		}
+
+		appName := appOf(h.Project, &h.Host)
+		key := h.Project + "/" + appName
+		if existing, ok := apps[key]; ok {
+			if timeout > existing.timeout {
+				existing.timeout = timeout
+			}
+			if warmPool > existing.warmPool {
+				existing.warmPool = warmPool
+			}
+			existing.sleeping = existing.sleeping && h.Host.Sleeping
+			continue
+		}
+		apps[key] = &idleApp{project: h.Project, app: appName, timeout: timeout, warmPool: warmPool, sleeping: h.Host.Sleeping}
+	}
+
+	m.mu.Lock()
+	for key := range m.apps {
+		if _, ok := apps[key]; !ok {
+			delete(m.apps, key)
+		}
+	}
+	m.mu.Unlock()
+
+	for key, idle := range apps {
+		if idle.sleeping {
+			continue
+		}
+
+		m.mu.Lock()
+		a := m.appFor(key)
+		// Woken outside the manager, e.g. by a deploy replacing the hosts
+		if a.asleep {
+			a.asleep = false
+			a.lastActive = m.now()
+		}
+		if a.waking != nil || a.stopping != nil {
+			m.mu.Unlock()
+			continue
+		}
+		busy := a.inFlight > 0 || m.now().Sub(a.lastActive) < idle.timeout
+		m.mu.Unlock()
+
+		containers, err := m.containers(ctx, idle.project, idle.app)
+		if err != nil {
+			log.Printf("[SCALE-TO-ZERO] [%s] Failed to list containers: %v", key, err)
+			continue
+		}
+		var running []docker.Container
+		for _, container := range containers {
+			if container.State == "running" {
+				running = append(running, container)
+			}
+		}
+
+		// Without running containers the app can only be woken
+		if busy && len(running) > 0 {
+			continue
+		}
+
+		// Requests arriving from here on wait for the app to start again
+		m.mu.Lock()
+		if a.inFlight > 0 && len(running) > 0 {
+			m.mu.Unlock()
+			continue
+		}
+		stopping := make(chan struct{})
+		a.asleep, a.stopping = true, stopping
+		m.mu.Unlock()
+		m.state.SetSleeping(idle.project, idle.app, true)
+
+		paused := min(idle.warmPool, len(running))
+		if len(running) > 0 {
+			log.Printf("[SCALE-TO-ZERO] [%s] No requests for %s, pausing %d and stopping %d containers", key, idle.timeout, paused, len(running)-paused)
+		}
+		for i, container := range running {
+			if i < paused {
+				err := m.docker.Pause(ctx, container.ID)
+				if err == nil {
+					continue
+				}
+				log.Printf("[SCALE-TO-ZERO] [%s] Failed to pause %s, stopping it: %v", key, container.Name, err)
+			}
+			if err := m.docker.Stop(ctx, container.ID, stopTimeout); err != nil {
+				log.Printf("[SCALE-TO-ZERO] [%s] Failed to stop %s: %v", key, container.Name, err)
+			}
+		}
+
+		m.mu.Lock()
+		a.stopping = nil
+		m.mu.Unlock()
+		close(stopping)
+	}
+}
@@ -0,0 +1,306 @@
+package scaletozero
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []docker.Container
+	starts     int
+	stops      int
+	unpauses   int
+}
+
+func (f *fakeDocker) AllContainers(ctx context.Context, label string) ([]docker.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]docker.Container(nil), f.containers...), nil
+}
+
+func (f *fakeDocker) setState(id, state string) {
+	for i := range f.containers {
+		if f.containers[i].ID == id {
+			f.containers[i].State = state
+		}
+	}
+}
+
+func (f *fakeDocker) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.starts++
+	f.setState(id, "running")
+	return nil
+}
+
+func (f *fakeDocker) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stops++
+	f.setState(id, "exited")
+	return nil
+}
+
+func (f *fakeDocker) Pause(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setState(id, "paused")
+	return nil
+}
+
+func (f *fakeDocker) Unpause(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unpauses++
+	f.setState(id, "running")
+	return nil
+}
+
+func (f *fakeDocker) count(state string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.containers {
+		if c.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeDocker) running() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.containers {
+		if c.State == "running" {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeHealth fails until the app has been running for a few checks
+type fakeHealth struct {
+	docker   *fakeDocker
+	failures int32
+}
+
+func (f *fakeHealth) CheckHealth(ctx context.Context, target, healthPath string) error {
+	if f.docker.running() == 0 {
+		return errors.New("connection refused")
+	}
+	if atomic.AddInt32(&f.failures, -1) >= 0 {
+		return errors.New("starting")
+	}
+	return nil
+}
+
+func appContainer(id, app string) docker.Container {
+	return docker.Container{
+		ID:     id,
+		Name:   "blog-" + app + "-" + id,
+		State:  "running",
+		Labels: map[string]string{"iop.managed": "true", "iop.type": "service", "iop.project": "blog", "iop.app": app},
+	}
+}
+
+func newTestManager(t *testing.T) (*Manager, *state.State, *fakeDocker, *time.Time) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.DeployHost("api.example.com", "blog-api:3000", "blog", "api", "/up", false))
+	require.NoError(t, st.SetScaleToZero("blog.example.com", true, "10m", nil))
+	require.NoError(t, st.UpdateHealthStatus("blog.example.com", true))
+
+	d := &fakeDocker{containers: []docker.Container{
+		appContainer("1", "web"), appContainer("2", "web"), appContainer("3", "api"),
+	}}
+	m := NewManager(st, d, &fakeHealth{docker: d, failures: 2})
+
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	return m, st, d, &now
+}
+
+func TestSweepStopsIdleApps(t *testing.T) {
+	m, st, d, now := newTestManager(t)
+	m.Sweep(context.Background())
+	assert.Equal(t, 3, d.running(), "idle time starts when the app is first seen")
+
+	*now = now.Add(9 * time.Minute)
+	m.Sweep(context.Background())
+	assert.Equal(t, 3, d.running())
+
+	*now = now.Add(2 * time.Minute)
+	m.Sweep(context.Background())
+	assert.Equal(t, 1, d.running(), "only the scale to zero app stops")
+
+	host, _, err := st.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.True(t, host.Sleeping)
+	assert.False(t, host.Healthy)
+
+	api, _, err := st.GetHost("api.example.com")
+	require.NoError(t, err)
+	assert.False(t, api.Sleeping)
+}
+
+func TestInFlightRequestsKeepAppAwake(t *testing.T) {
+	m, _, d, now := newTestManager(t)
+
+	release, err := m.Acquire(context.Background(), "blog.example.com")
+	require.NoError(t, err)
+
+	*now = now.Add(time.Hour)
+	m.Sweep(context.Background())
+	assert.Equal(t, 3, d.running())
+
+	release()
+	*now = now.Add(11 * time.Minute)
+	m.Sweep(context.Background())
+	assert.Equal(t, 1, d.running())
+}
+
+func TestAcquireWakesSleepingApp(t *testing.T) {
+	m, st, d, now := newTestManager(t)
+	m.Sweep(context.Background())
+	*now = now.Add(time.Hour)
+	m.Sweep(context.Background())
+	require.Equal(t, 1, d.running())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := m.Acquire(context.Background(), "blog.example.com")
+			if err == nil {
+				release()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, d.starts, "waiting requests share one start")
+	host, _, err := st.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.False(t, host.Sleeping)
+	assert.True(t, host.Healthy)
+
+	// A fresh idle period starts after waking
+	m.Sweep(context.Background())
+	assert.Equal(t, 3, d.running())
+}
+
+func TestColdStartQueueLimits(t *testing.T) {
+	m, st, d, now := newTestManager(t)
+	require.NoError(t, st.SetScaleToZero("blog.example.com", true, "10m", &state.ColdStartPolicy{MaxWait: "200ms", QueueDepth: 1}))
+	m.Sweep(context.Background())
+	*now = now.Add(time.Hour)
+	m.Sweep(context.Background())
+
+	// The app never becomes healthy
+	m.health = &fakeHealth{docker: d, failures: 1 << 20}
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := m.Acquire(context.Background(), "blog.example.com")
+		first <- err
+	}()
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.apps["blog/web"].waiting == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := m.Acquire(context.Background(), "blog.example.com")
+	assert.ErrorContains(t, err, "queue for blog/web is full")
+
+	assert.ErrorContains(t, <-first, "blog/web not started within 200ms")
+	m.mu.Lock()
+	assert.Zero(t, m.apps["blog/web"].inFlight)
+	m.mu.Unlock()
+}
+
+func TestWakeDoesNotWait(t *testing.T) {
+	m, st, d, now := newTestManager(t)
+	m.Sweep(context.Background())
+	*now = now.Add(time.Hour)
+	m.Sweep(context.Background())
+
+	m.Wake("blog.example.com")
+	m.Wake("blog.example.com")
+	require.Eventually(t, func() bool {
+		host, _, err := st.GetHost("blog.example.com")
+		return err == nil && !host.Sleeping
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Equal(t, 2, d.starts, "one start for both calls")
+}
+
+func TestWarmPoolPausesContainers(t *testing.T) {
+	m, st, d, now := newTestManager(t)
+	require.NoError(t, st.SetScaleToZero("blog.example.com", true, "10m", &state.ColdStartPolicy{WarmPool: 1}))
+	m.Sweep(context.Background())
+	*now = now.Add(time.Hour)
+	m.Sweep(context.Background())
+
+	assert.Equal(t, 1, d.count("paused"))
+	assert.Equal(t, 1, d.stops)
+	host, _, err := st.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.True(t, host.Sleeping)
+
+	// Paused containers don't count as running, so the app stays asleep
+	m.Sweep(context.Background())
+	assert.Equal(t, 1, d.stops)
+
+	release, err := m.Acquire(context.Background(), "blog.example.com")
+	require.NoError(t, err)
+	release()
+	assert.Equal(t, 1, d.unpauses)
+	assert.Equal(t, 1, d.starts)
+	assert.Equal(t, 3, d.running())
+}
+
+func TestSweepMarksStoppedAppsAsleep(t *testing.T) {
+	m, st, d, _ := newTestManager(t)
+	d.setState("1", "exited")
+	d.setState("2", "exited")
+
+	m.Sweep(context.Background())
+	assert.Equal(t, 0, d.stops)
+
+	host, _, err := st.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.True(t, host.Sleeping)
+}
+
+func TestAcquireFailsWithoutContainers(t *testing.T) {
+	m, st, d, _ := newTestManager(t)
+	d.containers = d.containers[2:]
+	st.SetSleeping("blog", "web", true)
+
+	_, err := m.Acquire(context.Background(), "blog.example.com")
+	assert.ErrorContains(t, err, "no containers found for blog/web")
+}
@@ -0,0 +1,118 @@
+package sentry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// certFailureAttempts is the failed attempt at which a certificate that keeps
+// failing is reported. Single failures, e.g. while DNS propagates, are common.
+const certFailureAttempts = 3
+
+// Reporter sends failures published on the event bus to Sentry, tagged with
+// the host and the project and app it belongs to
+type Reporter struct {
+	state  *state.State
+	client *Client
+}
+
+// NewReporter creates a reporter sending to the given client
+func NewReporter(st *state.State, client *Client) *Reporter {
+	return &Reporter{state: st, client: client}
+}
+
+// Run reports events until the context is cancelled or the channel closes
+func (r *Reporter) Run(ctx context.Context, events <-chan core.Event) {
+	defer Recover()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			r.Handle(event)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Handle reports an event if it is a failure
+func (r *Reporter) Handle(event core.Event) {
+	report, ok := NewEvent(event)
+	if !ok {
+		return
+	}
+
+	if host := report.Tags["host"]; host != "" {
+		if app, ok := r.state.HostApps()[host]; ok {
+			report.Tags["project"] = app.Project
+			report.Tags["app"] = app.App
+		}
+	}
+	log.Printf("[SENTRY] [%s] Reporting %s", report.Tags["host"], report.Logger)
+	r.client.Capture(report)
+}
+
+// NewEvent describes a failure for Sentry. Events that aren't failures, and
+// certificate failures that haven't repeated yet, return false.
+func NewEvent(event core.Event) (Event, bool) {
+	// Deployments publish pointers
+	if e, ok := event.(*core.DeploymentFailed); ok {
+		event = *e
+	}
+
+	var report Event
+	var hostname string
+	switch e := event.(type) {
+	case core.DeploymentFailed:
+		hostname = e.Hostname
+		report = Event{
+			Logger:  "deployment.failed",
+			Message: fmt.Sprintf("Deployment of %s failed: %s", e.Hostname, e.Error),
+			Extra:   map[string]any{"deployment_id": e.DeploymentID, "color": string(e.Color)},
+		}
+		for key, value := range e.Metadata {
+			report.Extra[key] = value
+		}
+	case core.CertificateFailed:
+		if !e.Final && e.Attempts != certFailureAttempts {
+			return Event{}, false
+		}
+		hostname = e.Hostname
+		report = Event{
+			Logger:  "cert.failed",
+			Message: fmt.Sprintf("Certificate acquisition for %s failed %d times: %s", e.Hostname, e.Attempts, e.Error),
+			Extra:   map[string]any{"attempts": e.Attempts, "final": e.Final},
+		}
+		if e.Final {
+			report.Message += " (giving up)"
+		}
+	case core.CertificateRenewalFailing:
+		hostname = e.Hostname
+		report = Event{
+			Logger:  "cert.renewal_failing",
+			Message: fmt.Sprintf("Renewal of the certificate for %s failed %d times: %s", e.Hostname, e.Attempts, e.Error),
+			Extra:   map[string]any{"attempts": e.Attempts, "expires_at": e.ExpiresAt},
+		}
+	default:
+		return Event{}, false
+	}
+
+	report.Timestamp = event.EventTime()
+	report.Level = LevelError
+	report.Exception = []Exception{{Type: report.Logger, Value: report.Message}}
+	report.Tags = map[string]string{"host": hostname}
+	if attempts, ok := report.Extra["attempts"].(int); ok {
+		report.Tags["attempts"] = strconv.Itoa(attempts)
+	}
+	// One issue per kind of failure and host, not per error message
+	report.Fingerprint = []string{report.Logger, hostname}
+	return report, true
+}
@@ -0,0 +1,382 @@
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	clientName   = "iop-proxy/1.0"
+	maxQueueSize = 100
+)
+
+// Level is the severity of an event
+type Level string
+
+const (
+	LevelFatal   Level = "fatal"
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+)
+
+// Config configures error reporting
+type Config struct {
+	DSN         string // Project DSN, e.g. https://<key>@o1.ingest.sentry.io/123
+	Environment string // e.g. production
+	Release     string
+	ServerName  string // Server the proxy runs on, the container's hostname is its ID
+}
+
+// ConfigFromEnv reads the standard Sentry environment variables and
+// SENTRY_SERVER_NAME. Returns nil when no DSN is configured, which disables
+// error reporting.
+func ConfigFromEnv() *Config {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return nil
+	}
+
+	serverName := os.Getenv("SENTRY_SERVER_NAME")
+	if serverName == "" {
+		serverName, _ = os.Hostname()
+	}
+
+	return &Config{
+		DSN:         dsn,
+		Environment: os.Getenv("SENTRY_ENVIRONMENT"),
+		Release:     os.Getenv("SENTRY_RELEASE"),
+		ServerName:  serverName,
+	}
+}
+
+// Event is an error reported to Sentry
+type Event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       Level             `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Exception   []Exception       `json:"exception,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]any    `json:"extra,omitempty"`
+	Fingerprint []string          `json:"fingerprint,omitempty"` // Groups events into one issue, e.g. per host
+}
+
+// Exception describes the error of an event
+type Exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *Stacktrace `json:"stacktrace,omitempty"`
+}
+
+// Stacktrace holds the frames of a panicking goroutine, innermost last
+type Stacktrace struct {
+	Frames []Frame `json:"frames"`
+}
+
+// Frame is a single function call of a stack trace
+type Frame struct {
+	Function string `json:"function"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// Client sends events to a Sentry project in the background
+type Client struct {
+	config   Config
+	endpoint string
+	auth     string
+	client   *http.Client
+	queue    chan *Event
+	pending  sync.WaitGroup
+}
+
+// NewClient creates a client for the DSN's project and starts its sender
+func NewClient(config Config) (*Client, error) {
+	endpoint, key, err := parseDSN(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		config:   config,
+		endpoint: endpoint,
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_key=%s", clientName, key),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *Event, maxQueueSize),
+	}
+	go c.run()
+	return c, nil
+}
+
+// parseDSN returns the envelope endpoint and public key of a DSN, which has
+// the form <scheme>://<key>@<host>[/<path>]/<project>
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: scheme must be http or https")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: missing public key")
+	}
+
+	path := strings.Trim(u.Path, "/")
+	prefix, project := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		prefix, project = path[:i+1], path[i+1:]
+	}
+	if project == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: missing project ID")
+	}
+
+	endpoint := fmt.Sprintf("%s://%s/%sapi/%s/envelope/", u.Scheme, u.Host, prefix, project)
+	return endpoint, u.User.Username(), nil
+}
+
+// Capture queues an event, filling in the fields the client knows. Events
+// are dropped when the queue is full so reporting never blocks the proxy.
+func (c *Client) Capture(event Event) {
+	if c == nil {
+		return
+	}
+
+	if event.EventID == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		event.EventID = hex.EncodeToString(id)
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if event.Level == "" {
+		event.Level = LevelError
+	}
+	event.Platform = "go"
+	event.ServerName = c.config.ServerName
+	event.Environment = c.config.Environment
+	event.Release = c.config.Release
+
+	c.pending.Add(1)
+	select {
+	case c.queue <- &event:
+	default:
+		c.pending.Done()
+	}
+}
+
+// Flush waits until queued events are sent or the context is done
+func (c *Client) Flush(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run sends queued events one at a time
+func (c *Client) run() {
+	for event := range c.queue {
+		if err := c.send(event); err != nil {
+			log.Printf("[SENTRY] Failed to report %q: %v", event.Message, err)
+		}
+		c.pending.Done()
+	}
+}
+
+// send posts an event as an envelope with a single item
+func (c *Client) send(event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	var body bytes.Buffer
+	header, _ := json.Marshal(map[string]string{
+		"event_id": event.EventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+	body.Write(header)
+	body.WriteByte('\n')
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`, len(payload))
+	body.WriteByte('\n')
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// PanicEvent describes a recovered panic with the stack of the goroutine
+// that panicked
+func PanicEvent(value any, stack []byte) Event {
+	message := fmt.Sprint(value)
+	return Event{
+		Level:   LevelFatal,
+		Logger:  "panic",
+		Message: "panic: " + message,
+		Exception: []Exception{{
+			Type:       "panic",
+			Value:      message,
+			Stacktrace: parseStack(stack),
+		}},
+	}
+}
+
+// parseStack converts the output of debug.Stack into frames, leaving out the
+// frames of recovering from the panic
+func parseStack(stack []byte) *Stacktrace {
+	lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+	var frames []Frame
+	// The first line is the goroutine header, then a function line is
+	// followed by a "\tfile:line +0x.." line
+	for i := 1; i+1 < len(lines); i += 2 {
+		function := lines[i]
+		if strings.HasPrefix(function, "created by ") {
+			function = strings.Fields(function)[2]
+		}
+		if paren := strings.LastIndex(function, "("); paren > 0 {
+			function = function[:paren]
+		}
+		location := strings.TrimSpace(lines[i+1])
+		if space := strings.Index(location, " "); space >= 0 {
+			location = location[:space]
+		}
+		file, line := location, 0
+		if colon := strings.LastIndex(location, ":"); colon >= 0 {
+			file = location[:colon]
+			fmt.Sscanf(location[colon+1:], "%d", &line)
+		}
+
+		// Frames up to the call to panic are the deferred function recovering
+		if function == "panic" {
+			frames = frames[:0]
+			continue
+		}
+		frames = append(frames, Frame{
+			Function: function,
+			AbsPath:  file,
+			Lineno:   line,
+			InApp:    strings.HasPrefix(function, "github.com/elitan/iop/"),
+		})
+	}
+
+	// Sentry lists the outermost frame first
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return &Stacktrace{Frames: frames}
+}
+
+var (
+	globalMu     sync.RWMutex
+	globalClient *Client
+)
+
+// SetClient installs the client used by Capture and Recover. A nil client
+// disables error reporting.
+func SetClient(c *Client) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	globalClient = c
+}
+
+func getClient() *Client {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return globalClient
+}
+
+// Capture reports an event with the global client
+func Capture(event Event) {
+	getClient().Capture(event)
+}
+
+// flushTimeout is how long a panicking goroutine waits for its report to be
+// sent before the process exits
+const flushTimeout = 2 * time.Second
+
+// Recover reports a panic of the calling goroutine and panics again, so the
+// proxy still crashes and is restarted. Defer it at the top of goroutines.
+func Recover() {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	if c := getClient(); c != nil {
+		c.Capture(PanicEvent(value, debug.Stack()))
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		c.Flush(ctx)
+		cancel()
+	}
+	panic(value)
+}
+
+// Handler reports panics of HTTP requests with the request's host. The
+// server still recovers them and closes the connection as before.
+// http.ErrAbortHandler, which aborts a response on purpose, isn't reported.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value != http.ErrAbortHandler {
+				event := PanicEvent(value, debug.Stack())
+				event.Level = LevelError
+				event.Tags = map[string]string{"host": r.Host}
+				event.Extra = map[string]any{"method": r.Method, "path": r.URL.Path}
+				Capture(event)
+			}
+			panic(value)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,175 @@
+package sentry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sentryServer records the events posted to it
+type sentryServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	auth   string
+	events []Event
+}
+
+func newSentryServer(t *testing.T) *sentryServer {
+	s := &sentryServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/42/envelope/", r.URL.Path)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		require.Len(t, lines, 3)
+		assert.Contains(t, lines[1], `"type":"event"`)
+
+		var event Event
+		require.NoError(t, json.Unmarshal([]byte(lines[2]), &event))
+
+		s.mu.Lock()
+		s.auth = r.Header.Get("X-Sentry-Auth")
+		s.events = append(s.events, event)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *sentryServer) client(t *testing.T) *Client {
+	dsn := strings.Replace(s.URL, "http://", "http://public@", 1) + "/42"
+	client, err := NewClient(Config{DSN: dsn, Environment: "production", ServerName: "web1.example.com"})
+	require.NoError(t, err)
+	return client
+}
+
+func flush(t *testing.T, client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.Flush(ctx))
+}
+
+func TestParseDSN(t *testing.T) {
+	endpoint, key, err := parseDSN("https://abc123@o1.ingest.sentry.io/4505")
+	require.NoError(t, err)
+	assert.Equal(t, "https://o1.ingest.sentry.io/api/4505/envelope/", endpoint)
+	assert.Equal(t, "abc123", key)
+
+	endpoint, _, err = parseDSN("http://abc123@sentry.internal:9000/sentry/7")
+	require.NoError(t, err)
+	assert.Equal(t, "http://sentry.internal:9000/sentry/api/7/envelope/", endpoint)
+
+	for _, invalid := range []string{
+		"",
+		"https://o1.ingest.sentry.io/4505",
+		"https://abc123@o1.ingest.sentry.io/",
+		"ftp://abc123@o1.ingest.sentry.io/4505",
+	} {
+		_, _, err := parseDSN(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestClientSendsEvents(t *testing.T) {
+	server := newSentryServer(t)
+	client := server.client(t)
+
+	client.Capture(Event{Message: "boom", Tags: map[string]string{"host": "example.com"}})
+	flush(t, client)
+
+	require.Len(t, server.events, 1)
+	event := server.events[0]
+	assert.Len(t, event.EventID, 32)
+	assert.Equal(t, LevelError, event.Level)
+	assert.Equal(t, "go", event.Platform)
+	assert.Equal(t, "web1.example.com", event.ServerName)
+	assert.Equal(t, "production", event.Environment)
+	assert.Equal(t, "example.com", event.Tags["host"])
+	assert.Contains(t, server.auth, "sentry_key=public")
+}
+
+func TestNewEvent(t *testing.T) {
+	base := core.BaseEvent{Timestamp: time.Now(), Hostname: "example.com"}
+
+	// Deployments publish pointers
+	event, ok := NewEvent(&core.DeploymentFailed{BaseEvent: base, Error: "health check failed", Metadata: map[string]string{"sha": "abc123"}})
+	require.True(t, ok)
+	assert.Equal(t, "Deployment of example.com failed: health check failed", event.Message)
+	assert.Equal(t, "abc123", event.Extra["sha"])
+	assert.Equal(t, []string{"deployment.failed", "example.com"}, event.Fingerprint)
+
+	// Only certificate failures that keep happening are reported
+	_, ok = NewEvent(core.CertificateFailed{BaseEvent: base, Error: "dns", Attempts: 1})
+	assert.False(t, ok)
+	event, ok = NewEvent(core.CertificateFailed{BaseEvent: base, Error: "dns", Attempts: certFailureAttempts})
+	require.True(t, ok)
+	assert.Equal(t, "3", event.Tags["attempts"])
+	_, ok = NewEvent(core.CertificateFailed{BaseEvent: base, Error: "dns", Attempts: certFailureAttempts + 1})
+	assert.False(t, ok)
+	event, ok = NewEvent(core.CertificateFailed{BaseEvent: base, Error: "dns", Attempts: 144, Final: true})
+	require.True(t, ok)
+	assert.True(t, strings.HasSuffix(event.Message, "(giving up)"))
+
+	_, ok = NewEvent(core.CertificateIssued{BaseEvent: base})
+	assert.False(t, ok)
+}
+
+func TestReporterTagsProject(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("example.com", "blog-web:3000", "blog", "web", "/up", true))
+
+	server := newSentryServer(t)
+	client := server.client(t)
+	NewReporter(st, client).Handle(core.CertificateRenewalFailing{
+		BaseEvent: core.BaseEvent{Timestamp: time.Now(), Hostname: "example.com"},
+		Attempts:  3,
+		Error:     "rate limited",
+	})
+	flush(t, client)
+
+	require.Len(t, server.events, 1)
+	assert.Equal(t, map[string]string{"host": "example.com", "project": "blog", "app": "web", "attempts": "3"}, server.events[0].Tags)
+}
+
+func TestHandlerReportsPanics(t *testing.T) {
+	server := newSentryServer(t)
+	client := server.client(t)
+	SetClient(client)
+	defer SetClient(nil)
+
+	serve := func(value any) (recovered any) {
+		defer func() { recovered = recover() }()
+		handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(value)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/posts", nil))
+		return nil
+	}
+
+	// The panic still reaches the server, which closes the connection
+	assert.Equal(t, "nil map", serve("nil map"))
+	assert.Equal(t, http.ErrAbortHandler, serve(http.ErrAbortHandler))
+	flush(t, client)
+
+	require.Len(t, server.events, 1, "aborted responses aren't reported")
+	event := server.events[0]
+	assert.Equal(t, "panic: nil map", event.Message)
+	assert.Equal(t, "example.com", event.Tags["host"])
+	frames := event.Exception[0].Stacktrace.Frames
+	require.NotEmpty(t, frames)
+	assert.Contains(t, frames[len(frames)-1].Function, "TestHandlerReportsPanics")
+	assert.True(t, frames[len(frames)-1].InApp)
+}
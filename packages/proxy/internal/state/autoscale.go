@@ -0,0 +1,69 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AutoscalePolicy bounds the number of replicas of an app and sets the load
+// each replica should carry. The autoscaler adds replicas when any target is
+// exceeded and removes them when all are comfortably met. Unset targets are
+// ignored.
+type AutoscalePolicy struct {
+	Project           string  `json:"project"`
+	App               string  `json:"app"`
+	MinReplicas       int     `json:"min_replicas"`
+	MaxReplicas       int     `json:"max_replicas"`
+	TargetCPU         float64 `json:"target_cpu,omitempty"`          // Average CPU percent per replica, 100 is one full core
+	TargetRequestRate float64 `json:"target_request_rate,omitempty"` // Requests per second per replica
+	TargetLatencyMs   int     `json:"target_latency_ms,omitempty"`   // Average response time across the app's hosts
+	TargetConcurrency float64 `json:"target_concurrency,omitempty"`  // Average requests in flight per replica
+	ScaleUpCooldown   string  `json:"scale_up_cooldown,omitempty"`   // Wait after scaling before adding replicas, e.g. "1m"
+	ScaleDownCooldown string  `json:"scale_down_cooldown,omitempty"` // Wait after scaling before removing replicas, e.g. "5m"
+}
+
+// Key identifies a policy by its project and app, e.g. "blog/web"
+func (p *AutoscalePolicy) Key() string {
+	return p.Project + "/" + p.App
+}
+
+// SetAutoscalePolicy adds or replaces the autoscaling policy of an app
+func (s *State) SetAutoscalePolicy(policy *AutoscalePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Autoscale == nil {
+		s.Autoscale = make(map[string]*AutoscalePolicy)
+	}
+	policyCopy := *policy
+	s.Autoscale[policy.Key()] = &policyCopy
+	s.markModified()
+}
+
+// RemoveAutoscalePolicy stops autoscaling an app
+func (s *State) RemoveAutoscalePolicy(project, app string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := project + "/" + app
+	if _, exists := s.Autoscale[key]; !exists {
+		return fmt.Errorf("no autoscaling policy for %s", key)
+	}
+	delete(s.Autoscale, key)
+	s.markModified()
+
+	return nil
+}
+
+// GetAutoscalePolicies returns copies of all autoscaling policies, sorted by project and app
+func (s *State) GetAutoscalePolicies() []AutoscalePolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	policies := make([]AutoscalePolicy, 0, len(s.Autoscale))
+	for _, policy := range s.Autoscale {
+		policies = append(policies, *policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Key() < policies[j].Key() })
+	return policies
+}
@@ -0,0 +1,76 @@
+package state
+
+import "sort"
+
+// CertGroupMaxNames caps the hostnames on one grouped certificate, Let's
+// Encrypt allows 100. Hosts beyond it get their own certificates.
+const CertGroupMaxNames = 100
+
+// SetCertGroups sets the projects whose hosts share one certificate, an empty
+// list gives every host its own again
+func (s *State) SetCertGroups(projects []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(projects) == 0 {
+		s.CertGroups = nil
+	} else {
+		s.CertGroups = append([]string{}, projects...)
+	}
+	s.markModified()
+}
+
+// GetCertGroups returns the projects whose hosts share one certificate
+func (s *State) GetCertGroups() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string{}, s.CertGroups...)
+}
+
+// CertGroup returns the sorted hostnames that share a certificate with
+// hostname, including it, or nil when the host gets its own. Passthrough,
+// on-demand and wildcard hosts and custom domains are never grouped.
+func (s *State) CertGroup(hostname string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	host, projectName := s.findHost(hostname)
+	if host == nil || !groupable(hostname, host) {
+		return nil
+	}
+	grouped := false
+	for _, project := range s.CertGroups {
+		grouped = grouped || project == projectName
+	}
+	if !grouped {
+		return nil
+	}
+
+	var names []string
+	for name, other := range s.Projects[projectName].Hosts {
+		if groupable(name, other) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > CertGroupMaxNames {
+		names = names[:CertGroupMaxNames]
+	}
+
+	// Hosts past the cap, and groups of one, keep their own certificate
+	if i := sort.SearchStrings(names, hostname); len(names) < 2 || i == len(names) || names[i] != hostname {
+		return nil
+	}
+	return names
+}
+
+// groupable reports whether a host may share a certificate with the other
+// hosts of its project
+func groupable(hostname string, host *Host) bool {
+	return host.SSLEnabled &&
+		host.Mode != HostModePassthrough &&
+		!host.OnDemand &&
+		host.AliasOf == "" &&
+		!IsHostPattern(hostname)
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxDeploymentHistory is how many deployments are kept per host
+const MaxDeploymentHistory = 20
+
+// Bounds on the metadata of a deployment, which is stored with every record
+const (
+	maxMetadataKeys   = 20
+	maxMetadataLength = 1024
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// DeploymentRecord is one deploy of a host, with the metadata the client
+// attached to it
+type DeploymentRecord struct {
+	Time     time.Time         `json:"time"`
+	Target   string            `json:"target"`
+	Metadata map[string]string `json:"metadata,omitempty"` // e.g. sha, branch, actor and ci_url
+}
+
+// ValidateMetadata checks the metadata attached to a deployment
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("too much metadata, at most %d keys are allowed", maxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid metadata key %q, use lowercase letters, digits, _, . and -", key)
+		}
+		if len(value) > maxMetadataLength {
+			return fmt.Errorf("metadata %s is longer than %d characters", key, maxMetadataLength)
+		}
+	}
+	return nil
+}
+
+// RecordDeployment appends a deploy to a host's history, dropping the oldest
+// records beyond MaxDeploymentHistory
+func (s *State) RecordDeployment(hostname, target string, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+
+	// Copies handed out by GetHost share the old slice, so build a new one
+	start := 0
+	if len(host.Deployments) >= MaxDeploymentHistory {
+		start = len(host.Deployments) - MaxDeploymentHistory + 1
+	}
+	history := make([]DeploymentRecord, 0, len(host.Deployments)-start+1)
+	history = append(history, host.Deployments[start:]...)
+	host.Deployments = append(history, DeploymentRecord{
+		Time:     time.Now().UTC(),
+		Target:   target,
+		Metadata: metadata,
+	})
+	s.markModified()
+	return nil
+}
+
+// GetDeployments returns a host's recorded deployments, most recent first
+func (s *State) GetDeployments(hostname string) ([]DeploymentRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return nil, fmt.Errorf("host %s not found", hostname)
+	}
+
+	records := make([]DeploymentRecord, 0, len(host.Deployments))
+	for i := len(host.Deployments) - 1; i >= 0; i-- {
+		records = append(records, host.Deployments[i])
+	}
+	return records, nil
+}
+
+// FormatMetadata renders deployment metadata as "key=value" pairs sorted by
+// key, for logs and messages
+func FormatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + metadata[key]
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,57 @@
+package state
+
+import "sort"
+
+// ServiceRecord makes a service resolvable through the proxy's DNS server as
+// <name>.internal and <name>.<project>.internal. Services on this server are
+// resolved to their containers, services on other servers to an address of
+// that server such as its mesh address.
+type ServiceRecord struct {
+	Project   string `json:"project"`
+	Name      string `json:"name"`
+	Address   string `json:"address,omitempty"`   // IPv4 address of another server running the service
+	Container string `json:"container,omitempty"` // Container on this server, resolved on each query
+}
+
+// SetServiceRecords replaces the service records of a project. No records
+// removes the project's records.
+func (s *State) SetServiceRecords(project string, records []ServiceRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(records) == 0 {
+		delete(s.Discovery, project)
+	} else {
+		if s.Discovery == nil {
+			s.Discovery = make(map[string][]ServiceRecord)
+		}
+		stored := make([]ServiceRecord, len(records))
+		for i, record := range records {
+			record.Project = project
+			stored[i] = record
+		}
+		s.Discovery[project] = stored
+	}
+	if len(s.Discovery) == 0 {
+		s.Discovery = nil
+	}
+	s.markModified()
+}
+
+// GetServiceRecords returns copies of all service records, sorted by project and name
+func (s *State) GetServiceRecords() []ServiceRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []ServiceRecord
+	for _, projectRecords := range s.Discovery {
+		records = append(records, projectRecords...)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Project != records[j].Project {
+			return records[i].Project < records[j].Project
+		}
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
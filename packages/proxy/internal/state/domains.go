@@ -0,0 +1,173 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Custom domain verification statuses
+const (
+	DomainPending  = "pending"
+	DomainVerified = "verified"
+)
+
+// CustomDomain is a domain a customer brings to an app. Once the customer
+// proves they own it, it is served by the same backend as Host.
+type CustomDomain struct {
+	Domain     string    `json:"domain"`
+	Host       string    `json:"host"`   // Deployed host whose backend serves the domain
+	Token      string    `json:"token"`  // Expected in the domain's verification TXT record
+	Status     string    `json:"status"` // DomainPending or DomainVerified
+	CreatedAt  time.Time `json:"created_at"`
+	VerifiedAt time.Time `json:"verified_at,omitempty"`
+	LastCheck  time.Time `json:"last_check,omitempty"`
+	LastError  string    `json:"last_error,omitempty"` // Why the last verification failed
+}
+
+// AddCustomDomain registers a domain for verification
+func (s *State) AddCustomDomain(domain *CustomDomain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.Domains[domain.Domain]; exists {
+		return fmt.Errorf("domain %s is already registered", domain.Domain)
+	}
+	if host, _ := s.findHost(domain.Domain); host != nil && !host.OnDemand {
+		return fmt.Errorf("domain %s is already deployed as a host", domain.Domain)
+	}
+	if host, _ := s.findHost(domain.Host); host == nil {
+		return fmt.Errorf("host %s not found", domain.Host)
+	}
+
+	if s.Domains == nil {
+		s.Domains = make(map[string]*CustomDomain)
+	}
+	domainCopy := *domain
+	s.Domains[domain.Domain] = &domainCopy
+	s.markModified()
+
+	return nil
+}
+
+// GetCustomDomain returns a copy of a registered domain
+func (s *State) GetCustomDomain(domain string) (*CustomDomain, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	registered, exists := s.Domains[domain]
+	if !exists {
+		return nil, fmt.Errorf("domain %s not found", domain)
+	}
+	domainCopy := *registered
+	return &domainCopy, nil
+}
+
+// GetCustomDomains returns copies of all registered domains, sorted by name
+func (s *State) GetCustomDomains() []CustomDomain {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domains := make([]CustomDomain, 0, len(s.Domains))
+	for _, domain := range s.Domains {
+		domains = append(domains, *domain)
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Domain < domains[j].Domain })
+	return domains
+}
+
+// RecordDomainCheck stores the outcome of a failed verification attempt
+func (s *State) RecordDomainCheck(domain string, checkErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	registered, exists := s.Domains[domain]
+	if !exists {
+		return fmt.Errorf("domain %s not found", domain)
+	}
+	registered.LastCheck = time.Now()
+	registered.LastError = ""
+	if checkErr != nil {
+		registered.LastError = checkErr.Error()
+	}
+	s.markModified()
+
+	return nil
+}
+
+// VerifyCustomDomain marks a domain verified and deploys it as an alias of
+// its host, with SSL so a certificate is acquired for it
+func (s *State) VerifyCustomDomain(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	registered, exists := s.Domains[domain]
+	if !exists {
+		return fmt.Errorf("domain %s not found", domain)
+	}
+	parent, project := s.findHost(registered.Host)
+	if parent == nil {
+		return fmt.Errorf("host %s not found", registered.Host)
+	}
+
+	host := newHost(parent.Target, parent.App, parent.HealthPath, parent.Mode != HostModePassthrough)
+	host.Mode = parent.Mode
+	host.AliasOf = registered.Host
+
+	// Keep a certificate issued on demand or before a re-verification
+	if existing, existingProject := s.findHost(domain); existing != nil {
+		if existing.Certificate != nil && host.SSLEnabled {
+			host.Certificate = existing.Certificate
+		}
+		delete(s.Projects[existingProject].Hosts, domain)
+		if len(s.Projects[existingProject].Hosts) == 0 && existingProject != project {
+			delete(s.Projects, existingProject)
+		}
+	}
+	s.Projects[project].Hosts[domain] = host
+	s.hostChanged(domain, host)
+
+	now := time.Now()
+	registered.Status = DomainVerified
+	registered.VerifiedAt = now
+	registered.LastCheck = now
+	registered.LastError = ""
+	s.markModified()
+
+	return nil
+}
+
+// RemoveCustomDomain unregisters a domain and stops serving it
+func (s *State) RemoveCustomDomain(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.Domains[domain]; !exists {
+		return fmt.Errorf("domain %s not found", domain)
+	}
+	delete(s.Domains, domain)
+
+	if host, project := s.findHost(domain); host != nil && host.AliasOf != "" {
+		delete(s.Projects[project].Hosts, domain)
+		if len(s.Projects[project].Hosts) == 0 {
+			delete(s.Projects, project)
+		}
+		s.hostRemoved(domain, host)
+	}
+	s.markModified()
+
+	return nil
+}
+
+// syncAliases points the custom domains of a host at its new target. The
+// caller must hold s.mu.
+func (s *State) syncAliases(hostname, target string) {
+	for _, project := range s.Projects {
+		for alias, host := range project.Hosts {
+			if host.AliasOf == hostname && host.Target != target {
+				host.Target = target
+				s.hostChanged(alias, host)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package state
+
+import "sync"
+
+// HostChange tells subscribers that a host was deployed, changed or removed.
+// An empty Hostname means any host may have changed, e.g. after a restore.
+type HostChange struct {
+	Hostname    string
+	Removed     bool
+	Certificate *CertificateStatus // A removed host's certificate, for cleaning up its files
+	Switched    bool               // Deployed or switched, its target may now reach other containers
+}
+
+// CertChange tells subscribers that a host's certificate status changed,
+// including a newly deployed host waiting for its first certificate
+type CertChange struct {
+	Hostname string
+	Status   string // e.g. "pending" or "active"
+}
+
+// hooks delivers change notifications to subscribers. Mutators queue them
+// while holding the state lock and a single goroutine delivers them in order
+// after it is released, so subscribers can read the state and take their time.
+type hooks struct {
+	mu      sync.Mutex
+	onHost  []func(HostChange)
+	onCert  []func(CertChange)
+	pending []func()
+	wake    chan struct{}
+}
+
+// OnHostChanged calls fn after each host deploy, change or removal
+func (s *State) OnHostChanged(fn func(HostChange)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+
+	s.hooks.onHost = append(s.hooks.onHost, fn)
+	s.hooks.start()
+}
+
+// OnCertChanged calls fn after each certificate status change
+func (s *State) OnCertChanged(fn func(CertChange)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+
+	s.hooks.onCert = append(s.hooks.onCert, fn)
+	s.hooks.start()
+}
+
+// hostChanged queues notifications for a host, or for every host when
+// hostname is empty. A host with a pending certificate also notifies
+// certificate subscribers. The caller must hold s.mu.
+func (s *State) hostChanged(hostname string, host *Host) {
+	s.notifyHost(HostChange{Hostname: hostname})
+	if host != nil && host.Certificate != nil && host.Certificate.Status == "pending" {
+		s.certChanged(hostname, host.Certificate.Status)
+	}
+}
+
+// hostSwitched queues notifications for a host that was deployed or switched,
+// whose connections to its old backend are stale. The caller must hold s.mu.
+func (s *State) hostSwitched(hostname string, host *Host) {
+	s.notifyHost(HostChange{Hostname: hostname, Switched: true})
+	if host.Certificate != nil && host.Certificate.Status == "pending" {
+		s.certChanged(hostname, host.Certificate.Status)
+	}
+}
+
+// hostRemoved queues notifications for a host that was just removed. The
+// caller must hold s.mu.
+func (s *State) hostRemoved(hostname string, host *Host) {
+	change := HostChange{Hostname: hostname, Removed: true}
+	if host.Certificate != nil {
+		certificate := *host.Certificate
+		change.Certificate = &certificate
+	}
+	s.notifyHost(change)
+}
+
+// notifyHost queues a host notification. The caller must hold s.mu.
+func (s *State) notifyHost(change HostChange) {
+	s.hooks.queue(func() {
+		onHost, _ := s.hooks.subscribers()
+		for _, fn := range onHost {
+			fn(change)
+		}
+	})
+}
+
+// certChanged queues a certificate notification. The caller must hold s.mu.
+func (s *State) certChanged(hostname, status string) {
+	change := CertChange{Hostname: hostname, Status: status}
+	s.hooks.queue(func() {
+		_, onCert := s.hooks.subscribers()
+		for _, fn := range onCert {
+			fn(change)
+		}
+	})
+}
+
+// start launches the delivery goroutine with the first subscription. The
+// caller must hold h.mu.
+func (h *hooks) start() {
+	if h.wake != nil {
+		return
+	}
+	h.wake = make(chan struct{}, 1)
+	go h.deliver()
+}
+
+// queue adds a notification, dropping it when nobody subscribed
+func (h *hooks) queue(notify func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.wake == nil {
+		return
+	}
+	h.pending = append(h.pending, notify)
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// subscribers returns the current subscriber lists
+func (h *hooks) subscribers() ([]func(HostChange), []func(CertChange)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.onHost, h.onCert
+}
+
+// deliver runs queued notifications in order, for the life of the process
+func (h *hooks) deliver() {
+	for range h.wake {
+		h.mu.Lock()
+		pending := h.pending
+		h.pending = nil
+		h.mu.Unlock()
+
+		for _, notify := range pending {
+			notify()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package state
+
+import "sort"
+
+// Networks are the Docker networks iop attached the proxy container to
+type Networks struct {
+	Managed []string `json:"managed,omitempty"` // Attached for a project, detached once it has no hosts
+	Pinned  []string `json:"pinned,omitempty"`  // Attached by hand, kept until detached by hand
+}
+
+// GetNetworks returns the networks attached to the proxy container
+func (s *State) GetNetworks() Networks {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.Networks == nil {
+		return Networks{}
+	}
+	return Networks{
+		Managed: append([]string{}, s.Networks.Managed...),
+		Pinned:  append([]string{}, s.Networks.Pinned...),
+	}
+}
+
+// SetNetworks records the networks attached to the proxy container
+func (s *State) SetNetworks(networks Networks) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(networks.Managed) == 0 && len(networks.Pinned) == 0 {
+		s.Networks = nil
+	} else {
+		sort.Strings(networks.Managed)
+		sort.Strings(networks.Pinned)
+		s.Networks = &networks
+	}
+	s.markModified()
+}
+
+// RoutedProjects returns the sorted projects the proxy sends traffic to: those
+// with hosts, port forwarding rules or service records of containers it
+// resolves. Hosts created by on-demand TLS belong to no project.
+func (s *State) RoutedProjects() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	routed := make(map[string]bool)
+	for name, project := range s.Projects {
+		if name != OnDemandProject && len(project.Hosts) > 0 {
+			routed[name] = true
+		}
+	}
+	for _, rule := range s.Ports {
+		if rule.Project != "" {
+			routed[rule.Project] = true
+		}
+	}
+	for name, records := range s.Discovery {
+		for _, record := range records {
+			if record.Container != "" {
+				routed[name] = true
+			}
+		}
+	}
+
+	projects := make([]string, 0, len(routed))
+	for name := range routed {
+		projects = append(projects, name)
+	}
+	sort.Strings(projects)
+	return projects
+}
@@ -0,0 +1,33 @@
+package state
+
+// markModified flags the state for saving and tells the persistence worker,
+// which waits for a quiet moment so a burst of changes is written once. The
+// caller must hold s.mu.
+func (s *State) markModified() {
+	s.modified = true
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// markCritical flags a change that must not be lost to a crash, such as a
+// deploy or an issued certificate, for saving right away. The caller must
+// hold s.mu.
+func (s *State) markCritical() {
+	s.markModified()
+	select {
+	case s.urgent <- struct{}{}:
+	default:
+	}
+}
+
+// Changed receives after mutations, for saving the state soon after
+func (s *State) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// Urgent receives after critical mutations, for saving the state immediately
+func (s *State) Urgent() <-chan struct{} {
+	return s.urgent
+}
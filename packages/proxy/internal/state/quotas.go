@@ -0,0 +1,72 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Quota caps what a project may run on this server, so projects sharing it
+// can't exhaust it. Deploys and the autoscaler are checked against it. Zero
+// leaves a resource uncapped.
+type Quota struct {
+	Project       string  `json:"project"`
+	MaxContainers int     `json:"max_containers,omitempty"`
+	MaxMemory     int64   `json:"max_memory,omitempty"` // Bytes, the sum of the containers' memory limits
+	MaxCPUs       float64 `json:"max_cpus,omitempty"`   // Cores, the sum of the containers' CPU limits
+}
+
+// SetQuota adds or replaces the quota of a project
+func (s *State) SetQuota(quota *Quota) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Quotas == nil {
+		s.Quotas = make(map[string]*Quota)
+	}
+	quotaCopy := *quota
+	s.Quotas[quota.Project] = &quotaCopy
+	s.markModified()
+}
+
+// RemoveQuota lifts the quota of a project
+func (s *State) RemoveQuota(project string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.Quotas[project]; !exists {
+		return fmt.Errorf("no quota for project %s", project)
+	}
+	delete(s.Quotas, project)
+	if len(s.Quotas) == 0 {
+		s.Quotas = nil
+	}
+	s.markModified()
+
+	return nil
+}
+
+// GetQuota returns a copy of a project's quota, or nil if it has none
+func (s *State) GetQuota(project string) *Quota {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	quota, exists := s.Quotas[project]
+	if !exists {
+		return nil
+	}
+	quotaCopy := *quota
+	return &quotaCopy
+}
+
+// GetQuotas returns copies of all quotas, sorted by project
+func (s *State) GetQuotas() []Quota {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	quotas := make([]Quota, 0, len(s.Quotas))
+	for _, quota := range s.Quotas {
+		quotas = append(quotas, *quota)
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Project < quotas[j].Project })
+	return quotas
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// SchemaVersion is the layout of the state file this proxy writes. Files
+// without a schema version predate versioning and are schema 1.
+const SchemaVersion = 2
+
+// StateBackups is how many previous versions of the state file are kept as
+// state.json.1 (newest) to state.json.5
+const StateBackups = 5
+
+// StateBackupInterval is how long the newest backup is kept before a save
+// rotates in another one. Saves come seconds apart, so rotating on each of
+// them would push every good copy out before a bad change is noticed.
+const StateBackupInterval = time.Hour
+
+// ErrNewerSchema is returned for state files written by a newer proxy, which
+// this one could only damage
+var ErrNewerSchema = errors.New("state file has a newer schema")
+
+// migrations upgrade a decoded state file one schema at a time, the one at
+// index i from schema i+1 to i+2. They work on the raw JSON so fields can be
+// renamed or reshaped before the file is decoded into a State.
+var migrations = []func(raw map[string]interface{}) error{
+	migrateResponseTimeouts,
+}
+
+// migrateResponseTimeouts gives hosts saved before per-host timeouts existed
+// the default response timeout, so they match a spec using the defaults
+func migrateResponseTimeouts(raw map[string]interface{}) error {
+	projects, _ := raw["projects"].(map[string]interface{})
+	for _, project := range projects {
+		project, _ := project.(map[string]interface{})
+		hosts, _ := project["hosts"].(map[string]interface{})
+		for _, host := range hosts {
+			host, ok := host.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if timeout, _ := host["response_timeout"].(string); timeout == "" {
+				host["response_timeout"] = DefaultResponseTimeout
+			}
+		}
+	}
+	return nil
+}
+
+// decodeState parses a state file, migrating it to the current schema.
+// Reports whether it was migrated, and so needs saving.
+func decodeState(data []byte, filePath string) (*State, bool, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+	if raw == nil {
+		return nil, false, fmt.Errorf("failed to unmarshal state: not a JSON object")
+	}
+
+	schema := 1
+	if metadata, ok := raw["metadata"].(map[string]interface{}); ok {
+		if version, ok := metadata["schema_version"].(float64); ok {
+			schema = int(version)
+		}
+	}
+	if schema > SchemaVersion {
+		return nil, false, fmt.Errorf("%w: version %d, this proxy only knows up to %d, upgrade it", ErrNewerSchema, schema, SchemaVersion)
+	}
+
+	migrated := schema < SchemaVersion
+	for ; schema < SchemaVersion; schema++ {
+		if err := migrations[schema-1](raw); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate state from schema %d: %w", schema, err)
+		}
+	}
+	if migrated {
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, false, fmt.Errorf("failed to marshal migrated state: %w", err)
+		}
+	}
+
+	st := NewState(filePath)
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+	if st.Projects == nil {
+		st.Projects = make(map[string]*Project)
+	}
+	for _, project := range st.Projects {
+		if project.Hosts == nil {
+			project.Hosts = make(map[string]*Host)
+		}
+	}
+	if st.Metadata == nil {
+		st.Metadata = &Metadata{Version: "2.0.0"}
+	}
+	st.Metadata.SchemaVersion = SchemaVersion
+	return st, migrated, nil
+}
+
+// backupFile returns the path of the nth previous state file, 1 being the newest
+func (s *State) backupFile(n int) string {
+	return fmt.Sprintf("%s.%d", s.filePath, n)
+}
+
+// rotateBackups shifts the backups by one and copies the current state file
+// to the newest slot, dropping the oldest. Nothing is rotated while the
+// newest backup is younger than StateBackupInterval. The caller must hold
+// s.mu.
+func (s *State) rotateBackups(now time.Time) error {
+	if newest, err := os.Stat(s.backupFile(1)); err == nil && now.Sub(newest.ModTime()) < StateBackupInterval {
+		return nil
+	}
+
+	current, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for n := StateBackups - 1; n >= 1; n-- {
+		if err := os.Rename(s.backupFile(n), s.backupFile(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.WriteFile(s.backupFile(1), current, 0644)
+}
+
+// recoverFromBackup loads the newest backup that decodes, after the state
+// file failed to. The broken file is moved aside so saving doesn't rotate it
+// into the backups. The caller must hold s.mu.
+func (s *State) recoverFromBackup(loadErr error) error {
+	for n := 1; n <= StateBackups; n++ {
+		data, err := os.ReadFile(s.backupFile(n))
+		if err != nil {
+			continue
+		}
+		restored, _, err := decodeState(data, s.filePath)
+		if err != nil {
+			log.Printf("[STATE] Backup %s is unusable too: %v", s.backupFile(n), err)
+			continue
+		}
+
+		corrupt := fmt.Sprintf("%s.corrupt-%s", s.filePath, time.Now().Format("20060102-150405"))
+		if err := os.Rename(s.filePath, corrupt); err != nil {
+			return fmt.Errorf("%w, and moving it aside failed: %v", loadErr, err)
+		}
+		log.Printf("[STATE] WARNING: %v. Recovered from %s, changes since it was written are lost. The broken file was kept as %s", loadErr, s.backupFile(n), corrupt)
+
+		s.replace(restored)
+		s.modified = true
+		return nil
+	}
+	return loadErr
+}
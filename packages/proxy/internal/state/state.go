@@ -1,10 +1,17 @@
 package state
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,12 +19,88 @@ import (
 type State struct {
 	mu sync.RWMutex
 
-	Projects    map[string]*Project `json:"projects"`
-	LetsEncrypt *LetsEncryptConfig  `json:"lets_encrypt"`
-	Metadata    *Metadata           `json:"metadata"`
+	Projects      map[string]*Project         `json:"projects"`
+	LetsEncrypt   *LetsEncryptConfig          `json:"lets_encrypt"`
+	Notifications []*NotificationTarget       `json:"notifications,omitempty"`
+	TLS           *TLSPolicy                  `json:"tls,omitempty"`             // Default TLS policy for all hosts
+	Default       *DefaultBackend             `json:"default_backend,omitempty"` // Serves requests for unknown hosts
+	OnDemandTLS   *OnDemandTLS                `json:"on_demand_tls,omitempty"`   // Issues certificates for unknown hosts on first handshake
+	CertGroups    []string                    `json:"cert_groups,omitempty"`     // Projects whose hosts share one certificate, see certgroups.go
+	Ports         []*PortForward              `json:"ports,omitempty"`           // Raw TCP/UDP forwarding rules
+	Networks      *Networks                   `json:"networks,omitempty"`        // Docker networks attached to the proxy container, see networks.go
+	Domains       map[string]*CustomDomain    `json:"domains,omitempty"`         // Customer domains by name, see domains.go
+	Autoscale     map[string]*AutoscalePolicy `json:"autoscale,omitempty"`       // Replica bounds and targets by project/app, see autoscale.go
+	Users         map[string]*User            `json:"users,omitempty"`           // API token holders by name, see users.go
+	ErrorPages    map[string]string           `json:"error_pages,omitempty"`     // HTML templates by status code, used by hosts without their own
+	Discovery     map[string][]ServiceRecord  `json:"discovery,omitempty"`       // Service DNS records by project, see discovery.go
+	Quotas        map[string]*Quota           `json:"quotas,omitempty"`          // Resource caps by project, see quotas.go
+	StatusPages   map[string]*StatusPage      `json:"status_pages,omitempty"`    // Public status pages by project, see statuspages.go
+	Metadata      *Metadata                   `json:"metadata"`
 
 	modified bool
+	changed  chan struct{} // Signaled by mutations, see persist.go
+	urgent   chan struct{} // Signaled by mutations that must be saved right away
 	filePath string
+	hooks    hooks // Change subscribers, see hooks.go
+}
+
+// DefaultBackend receives requests that match no host instead of a 404
+type DefaultBackend struct {
+	Target string `json:"target"`
+}
+
+// OnDemandTLS lets unknown hostnames matching Allow get a certificate on
+// their first TLS handshake, e.g. customer domains pointed at a SaaS app
+type OnDemandTLS struct {
+	Allow     []string `json:"allow"`                // Hostnames or *.example.com patterns
+	Ask       string   `json:"ask,omitempty"`        // URL approving each hostname before a certificate is ordered
+	MaxHosts  int      `json:"max_hosts,omitempty"`  // Hosts held at once, DefaultOnDemandMaxHosts if 0
+	RateLimit int      `json:"rate_limit,omitempty"` // Acquisitions started per hour, DefaultOnDemandRateLimit if 0
+}
+
+// Bounds on on-demand TLS, so handshakes with made-up hostnames can neither
+// grow the state nor use up the CA's rate limits
+const (
+	DefaultOnDemandMaxHosts  = 100
+	DefaultOnDemandRateLimit = 10
+)
+
+// HostLimit returns how many hosts on-demand TLS may hold at once
+func (o OnDemandTLS) HostLimit() int {
+	if o.MaxHosts > 0 {
+		return o.MaxHosts
+	}
+	return DefaultOnDemandMaxHosts
+}
+
+// IssueLimit returns how many acquisitions on-demand TLS may start per hour
+func (o OnDemandTLS) IssueLimit() int {
+	if o.RateLimit > 0 {
+		return o.RateLimit
+	}
+	return DefaultOnDemandRateLimit
+}
+
+// ErrOnDemandLimit is returned when on-demand TLS already holds as many hosts
+// as it may
+var ErrOnDemandLimit = errors.New("on-demand TLS host limit reached")
+
+// OnDemandProject holds the hosts created by on-demand TLS
+const OnDemandProject = "on-demand"
+
+// PortForward forwards a port on the proxy host to a container, for services
+// that don't speak HTTP such as databases or game servers
+type PortForward struct {
+	Listen   int      `json:"listen"`
+	Protocol string   `json:"protocol"` // "tcp" or "udp"
+	Target   string   `json:"target"`   // container:port
+	Project  string   `json:"project,omitempty"`
+	Allow    []string `json:"allow,omitempty"` // Client IPs or CIDRs, empty allows everyone
+}
+
+// Key identifies a rule by its listening port and protocol, e.g. "5432/tcp"
+func (p *PortForward) Key() string {
+	return fmt.Sprintf("%d/%s", p.Listen, p.Protocol)
 }
 
 type Project struct {
@@ -25,19 +108,197 @@ type Project struct {
 }
 
 type Host struct {
-	Target          string             `json:"target"`
-	App             string             `json:"app"`
-	HealthPath      string             `json:"health_path"`
-	CreatedAt       time.Time          `json:"created_at"`
-	SSLEnabled      bool               `json:"ssl_enabled"`
-	SSLRedirect     bool               `json:"ssl_redirect"`
-	ForwardHeaders  bool               `json:"forward_headers"`
-	ResponseTimeout string             `json:"response_timeout"`
-	Certificate     *CertificateStatus `json:"certificate,omitempty"`
+	ID             string             `json:"id,omitempty"` // Stays the same across redeploys and project moves
+	Target         string             `json:"target"`
+	App            string             `json:"app"`
+	HealthPath     string             `json:"health_path"`
+	CreatedAt      time.Time          `json:"created_at"`
+	SSLEnabled     bool               `json:"ssl_enabled"`
+	SSLRedirect    bool               `json:"ssl_redirect"`
+	ForwardHeaders bool               `json:"forward_headers"`
+	Certificate    *CertificateStatus `json:"certificate,omitempty"`
+	TLS            *TLSPolicy         `json:"tls,omitempty"`
+	Limits         *HostLimits        `json:"limits,omitempty"`
+	Mode           string             `json:"mode,omitempty"`          // HostModeHTTP (default) or HostModePassthrough
+	OnDemand       bool               `json:"on_demand,omitempty"`     // Only holds an on-demand certificate, requests route like an unknown host
+	AliasOf        string             `json:"alias_of,omitempty"`      // Custom domain serving the same backend as this host
+	ScaleToZero    bool               `json:"scale_to_zero,omitempty"` // Stop the app's containers when idle and start them on the next request
+	IdleTimeout    string             `json:"idle_timeout,omitempty"`  // Inactivity before a scale to zero app is stopped, e.g. "15m"
+	ColdStart      *ColdStartPolicy   `json:"cold_start,omitempty"`    // Bounds the requests waiting for a scaled to zero app to start
+	Rules          []RoutingRule      `json:"rules,omitempty"`         // Send matching requests to alternate targets, e.g. for A/B tests
+	Mirror         *MirrorPolicy      `json:"mirror,omitempty"`        // Copy requests to a shadow target, e.g. to load test a new version
+	Probes         *HostProbes        `json:"probes,omitempty"`        // Readiness and liveness probes replacing the regular health check
+	ErrorPages     map[string]string  `json:"error_pages,omitempty"`   // HTML templates by status code, e.g. "502", replacing the global ones
+	Streaming      bool               `json:"streaming,omitempty"`     // Flush responses as they arrive, for Server-Sent Events and long polling
+	Internal       bool               `json:"internal,omitempty"`      // Only served to clients on private networks, e.g. over a VPN
+	Stopped        bool               `json:"stopped,omitempty"`       // The app was stopped on purpose and stays down until started or deployed
+	Deployments    []DeploymentRecord `json:"deployments,omitempty"`   // Recent deploys, oldest first
+	HostTimeouts                      // How long the proxy waits on the backend
 
 	// Runtime state (not persisted)
 	Healthy         bool      `json:"-"`
 	LastHealthCheck time.Time `json:"-"`
+	CrashLooping    bool      `json:"-"` // The backend container keeps crashing, so the host stays unhealthy
+	Sleeping        bool      `json:"-"` // Scaled to zero, the next request starts the backend
+}
+
+// HostTimeouts bounds how long the proxy waits on a host's backend, as
+// durations such as "30s". Empty fields use the defaults.
+type HostTimeouts struct {
+	DialTimeout       string `json:"dial_timeout,omitempty"`        // Connecting to the backend, 10s by default
+	ResponseTimeout   string `json:"response_timeout"`              // Waiting for the response headers, 30s by default
+	RequestTimeout    string `json:"request_timeout,omitempty"`     // The whole exchange including the body, unlimited by default
+	StreamIdleTimeout string `json:"stream_idle_timeout,omitempty"` // No response data arriving, e.g. a stalled event stream, unlimited by default
+}
+
+// DefaultResponseTimeout is how long backends get to start answering
+const DefaultResponseTimeout = "30s"
+
+// Validate checks that the timeouts are positive durations
+func (t HostTimeouts) Validate() error {
+	for name, value := range map[string]string{
+		"dial_timeout":        t.DialTimeout,
+		"response_timeout":    t.ResponseTimeout,
+		"request_timeout":     t.RequestTimeout,
+		"stream_idle_timeout": t.StreamIdleTimeout,
+	} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q, expected a duration such as 30s", name, value)
+		}
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	return nil
+}
+
+// withDefaults fills in the default response timeout, so a spec without one
+// matches a host deployed with the default
+func (t HostTimeouts) withDefaults() HostTimeouts {
+	if t.ResponseTimeout == "" {
+		t.ResponseTimeout = DefaultResponseTimeout
+	}
+	return t
+}
+
+// TLSPolicy controls the TLS handshake for a host. Unset fields fall back to
+// the global policy and then to the built-in defaults.
+type TLSPolicy struct {
+	MinVersion   string   `json:"min_version,omitempty"`   // "1.0", "1.1", "1.2" or "1.3"
+	CipherSuites []string `json:"cipher_suites,omitempty"` // Go cipher suite names, only used up to TLS 1.2
+	ALPN         []string `json:"alpn,omitempty"`          // Protocols offered, e.g. ["http/1.1"] to disable HTTP/2
+}
+
+// ColdStartPolicy bounds the requests held while a scaled to zero app starts.
+// Requests beyond it get a 503 with a Retry-After header.
+type ColdStartPolicy struct {
+	MaxWait      string `json:"max_wait,omitempty"`      // Longest a request waits for the app, e.g. "30s"
+	QueueDepth   int    `json:"queue_depth,omitempty"`   // Most requests waiting at once
+	StartingPage bool   `json:"starting_page,omitempty"` // Answer browsers with a page that reloads until the app is up
+	WarmPool     int    `json:"warm_pool,omitempty"`     // Containers paused instead of stopped when idle, so they resume without a restart
+}
+
+// Host modes
+const (
+	// HostModeHTTP terminates TLS at the proxy and routes HTTP requests
+	HostModeHTTP = "http"
+	// HostModePassthrough forwards TLS connections for the host's SNI name to
+	// the backend untouched, leaving certificates to the backend
+	HostModePassthrough = "passthrough"
+)
+
+// RoutingRule sends the requests it matches to an alternate target instead
+// of the host's own. A rule matches on a header or cookie, a share of
+// clients, or both. Rules are checked in order and the first match wins.
+type RoutingRule struct {
+	Name    string `json:"name,omitempty"`    // Identifies the rule in logs, e.g. "beta"
+	Header  string `json:"header,omitempty"`  // Request header to match, e.g. "X-Beta"
+	Cookie  string `json:"cookie,omitempty"`  // Cookie to match instead of a header
+	Value   string `json:"value,omitempty"`   // Required value of the header or cookie, empty matches any value
+	Percent int    `json:"percent,omitempty"` // Share of clients, 1-100. Clients keep their bucket across requests.
+	Target  string `json:"target"`            // Backend as container:port
+}
+
+// MirrorPolicy copies a share of a host's requests to a shadow target. The
+// copies are sent in the background and their responses are discarded, so
+// the shadow target can't slow down or change what clients get.
+type MirrorPolicy struct {
+	Target  string `json:"target"`  // Backend as container:port
+	Percent int    `json:"percent"` // Share of requests to copy, 1-100
+}
+
+// ProbePolicy checks a host's app on its own path and schedule, acting only
+// after several consecutive failures
+type ProbePolicy struct {
+	Path             string `json:"path,omitempty"`              // Defaults to the host's health path
+	Interval         string `json:"interval,omitempty"`          // Time between checks, e.g. "10s"
+	FailureThreshold int    `json:"failure_threshold,omitempty"` // Consecutive failures before acting
+}
+
+// Probe defaults for policies without an interval or threshold
+const (
+	DefaultProbeInterval         = 10 * time.Second
+	DefaultProbeFailureThreshold = 3
+)
+
+// Every returns the time between checks
+func (p *ProbePolicy) Every() time.Duration {
+	if d, err := time.ParseDuration(p.Interval); err == nil && d > 0 {
+		return d
+	}
+	return DefaultProbeInterval
+}
+
+// Threshold returns the consecutive failures before the probe acts
+func (p *ProbePolicy) Threshold() int {
+	if p.FailureThreshold > 0 {
+		return p.FailureThreshold
+	}
+	return DefaultProbeFailureThreshold
+}
+
+// HostProbes replaces a host's regular health check. A failing readiness
+// probe takes the host out of rotation until it passes again, a failing
+// liveness probe restarts the app's containers.
+type HostProbes struct {
+	Readiness *ProbePolicy `json:"readiness,omitempty"`
+	Liveness  *ProbePolicy `json:"liveness,omitempty"`
+}
+
+// Validate checks the probes' intervals and thresholds
+func (p *HostProbes) Validate() error {
+	for name, probe := range map[string]*ProbePolicy{"readiness": p.Readiness, "liveness": p.Liveness} {
+		if probe == nil {
+			continue
+		}
+		if probe.Path != "" && !strings.HasPrefix(probe.Path, "/") {
+			return fmt.Errorf("%s path %q must start with /", name, probe.Path)
+		}
+		if probe.Interval != "" {
+			d, err := time.ParseDuration(probe.Interval)
+			if err != nil {
+				return fmt.Errorf("invalid %s interval %q, expected a duration such as 10s", name, probe.Interval)
+			}
+			if d < time.Second {
+				return fmt.Errorf("%s interval must be at least 1s", name)
+			}
+		}
+		if probe.FailureThreshold < 0 {
+			return fmt.Errorf("%s failure threshold can't be negative", name)
+		}
+	}
+	return nil
+}
+
+// HostLimits caps the resources a host can use so one tenant can't starve the
+// others on a shared server. Zero means unlimited.
+type HostLimits struct {
+	MaxConcurrent int   `json:"max_concurrent,omitempty"` // In-flight requests before new ones get a 503
+	Bandwidth     int64 `json:"bandwidth,omitempty"`      // Response bytes per second across all requests
 }
 
 type CertificateStatus struct {
@@ -45,9 +306,12 @@ type CertificateStatus struct {
 	AcquiredAt         time.Time `json:"acquired_at,omitempty"`
 	ExpiresAt          time.Time `json:"expires_at,omitempty"`
 	LastRenewalAttempt time.Time `json:"last_renewal_attempt,omitempty"`
-	RenewalAttempts    int       `json:"renewal_attempts,omitempty"`
+	RenewalAttempts    int       `json:"renewal_attempts,omitempty"` // Renewals that failed in a row
+	RenewalError       string    `json:"renewal_error,omitempty"`    // Why the last renewal failed
+	NextRenewal        time.Time `json:"next_renewal,omitempty"`     // Retry time after a failed renewal
 	CertFile           string    `json:"cert_file,omitempty"`
 	KeyFile            string    `json:"key_file,omitempty"`
+	Names              []string  `json:"names,omitempty"` // Every hostname on the certificate when it is shared by a certificate group
 
 	// For acquiring status
 	FirstAttempt time.Time `json:"first_attempt,omitempty"`
@@ -55,6 +319,8 @@ type CertificateStatus struct {
 	NextAttempt  time.Time `json:"next_attempt,omitempty"`
 	AttemptCount int       `json:"attempt_count,omitempty"`
 	MaxAttempts  int       `json:"max_attempts,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	ErrorType    string    `json:"error_type,omitempty"` // Why the last attempt failed: rate_limited, dns, caa, dnssec, authorization or other
 }
 
 type LetsEncryptConfig struct {
@@ -62,11 +328,49 @@ type LetsEncryptConfig struct {
 	DirectoryURL   string `json:"directory_url"`
 	Email          string `json:"email"`
 	Staging        bool   `json:"staging"`
+	EABKeyID       string `json:"eab_kid,omitempty"`      // External Account Binding key ID, for CAs that require one
+	EABHMACKey     string `json:"eab_hmac_key,omitempty"` // Base64url encoded EAB MAC key
+}
+
+const (
+	letsEncryptDirectoryURL        = "https://acme-v02.api.letsencrypt.org/directory"
+	letsEncryptStagingDirectoryURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// ACMEDirectories maps the CA names accepted in place of a directory URL
+var ACMEDirectories = map[string]string{
+	"letsencrypt":         letsEncryptDirectoryURL,
+	"letsencrypt-staging": letsEncryptStagingDirectoryURL,
+	"zerossl":             "https://acme.zerossl.com/v2/DV90",
+	"buypass":             "https://api.buypass.com/acme/directory",
+	"buypass-staging":     "https://api.test4.buypass.no/acme/directory",
+	"google":              "https://dv.acme-v02.api.pki.goog/directory",
+	"google-staging":      "https://dv.acme-v02.test-api.pki.goog/directory",
+}
+
+// ResolveACMEDirectory turns a CA name from ACMEDirectories or a directory URL into a URL
+func ResolveACMEDirectory(nameOrURL string) (string, error) {
+	if url, ok := ACMEDirectories[strings.ToLower(nameOrURL)]; ok {
+		return url, nil
+	}
+	if strings.HasPrefix(nameOrURL, "https://") {
+		return nameOrURL, nil
+	}
+	return "", fmt.Errorf("unknown ACME directory %q, expected an https:// URL or one of letsencrypt, zerossl, buypass, google", nameOrURL)
+}
+
+// NotificationTarget is a Slack, Discord or generic webhook receiving proxy events
+type NotificationTarget struct {
+	Type     string   `json:"type"` // "slack", "discord" or "webhook"
+	URL      string   `json:"url"`
+	Events   []string `json:"events,omitempty"`   // Event names or categories, empty means all
+	Template string   `json:"template,omitempty"` // Go text/template for the message (webhook: whole body)
 }
 
 type Metadata struct {
-	Version     string    `json:"version"`
-	LastUpdated time.Time `json:"last_updated"`
+	Version       string    `json:"version"`
+	SchemaVersion int       `json:"schema_version,omitempty"` // Layout of the state file, see schema.go
+	LastUpdated   time.Time `json:"last_updated"`
 }
 
 // NewState creates a new state instance
@@ -75,14 +379,17 @@ func NewState(filePath string) *State {
 		Projects: make(map[string]*Project),
 		LetsEncrypt: &LetsEncryptConfig{
 			AccountKeyFile: "/var/lib/iop-proxy/certs/account.key",
-			DirectoryURL:   "https://acme-v02.api.letsencrypt.org/directory",
+			DirectoryURL:   letsEncryptDirectoryURL,
 			Email:          "",
 			Staging:        false,
 		},
 		Metadata: &Metadata{
-			Version:     "2.0.0",
-			LastUpdated: time.Now(),
+			Version:       "2.0.0",
+			SchemaVersion: SchemaVersion,
+			LastUpdated:   time.Now(),
 		},
+		changed:  make(chan struct{}, 1),
+		urgent:   make(chan struct{}, 1),
 		filePath: filePath,
 	}
 }
@@ -101,22 +408,58 @@ func (s *State) Load() error {
 		return fmt.Errorf("failed to read state file: %w", err)
 	}
 
-	if err := json.Unmarshal(data, s); err != nil {
-		return fmt.Errorf("failed to unmarshal state: %w", err)
+	// A file that doesn't decode is replaced by its newest valid backup,
+	// one written by a newer proxy is left alone
+	loaded, migrated, err := decodeState(data, s.filePath)
+	if errors.Is(err, ErrNewerSchema) {
+		return err
 	}
-
-	// Ensure maps are initialized
-	if s.Projects == nil {
-		s.Projects = make(map[string]*Project)
+	if err != nil {
+		return s.recoverFromBackup(err)
+	}
+	s.replace(loaded)
+	if migrated {
+		s.modified = true
 	}
 
+	return nil
+}
+
+// replace takes over everything persisted from another state. Hosts saved
+// before IDs existed get one, persisted by the next save. The caller must
+// hold s.mu.
+func (s *State) replace(other *State) {
+	s.Projects = other.Projects
+	s.LetsEncrypt = other.LetsEncrypt
+	s.Notifications = other.Notifications
+	s.TLS = other.TLS
+	s.Default = other.Default
+	s.OnDemandTLS = other.OnDemandTLS
+	s.CertGroups = other.CertGroups
+	s.Domains = other.Domains
+	s.Ports = other.Ports
+	s.Networks = other.Networks
+	s.Autoscale = other.Autoscale
+	s.Users = other.Users
+	s.ErrorPages = other.ErrorPages
+	s.Discovery = other.Discovery
+	s.Quotas = other.Quotas
+	s.StatusPages = other.StatusPages
+	s.Metadata = other.Metadata
+	s.assignHostIDs()
+}
+
+// assignHostIDs gives every host without an ID a new one. The caller must
+// hold s.mu.
+func (s *State) assignHostIDs() {
 	for _, project := range s.Projects {
-		if project.Hosts == nil {
-			project.Hosts = make(map[string]*Host)
+		for _, host := range project.Hosts {
+			if host.ID == "" {
+				host.ID = newHostID()
+				s.markModified()
+			}
 		}
 	}
-
-	return nil
 }
 
 // Save saves state to the JSON file
@@ -142,6 +485,12 @@ func (s *State) Save() error {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
+	// Keep previous versions, the state is recovered from them if the file
+	// is ever damaged
+	if err := s.rotateBackups(time.Now()); err != nil {
+		return fmt.Errorf("failed to rotate state backups: %w", err)
+	}
+
 	// Write atomically
 	tmpFile := s.filePath + ".tmp"
 	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
@@ -156,6 +505,39 @@ func (s *State) Save() error {
 	return nil
 }
 
+// Snapshot returns the persisted state as JSON
+func (s *State) Snapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return data, nil
+}
+
+// Restore replaces the whole state with a snapshot. The snapshot is parsed
+// before anything changes, so an invalid one leaves the state untouched.
+func (s *State) Restore(data []byte) error {
+	restored, _, err := decodeState(data, s.filePath)
+	if err != nil {
+		return err
+	}
+	if restored.LetsEncrypt == nil {
+		return fmt.Errorf("snapshot has no ACME configuration")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replace(restored)
+	s.markCritical()
+	s.hostChanged("", nil)
+
+	return nil
+}
+
 // DeployHost adds or updates a host configuration
 func (s *State) DeployHost(hostname, target, project, app, healthPath string, sslEnabled bool) error {
 	s.mu.Lock()
@@ -167,16 +549,62 @@ func (s *State) DeployHost(hostname, target, project, app, healthPath string, ss
 		}
 	}
 
+	host := newHost(target, app, healthPath, sslEnabled)
+
+	// Deploying a host that got an on-demand certificate keeps the certificate
+	if onDemand := s.Projects[OnDemandProject]; project != OnDemandProject && onDemand != nil {
+		if existing := onDemand.Hosts[hostname]; existing != nil {
+			host.ID = existing.ID
+			if existing.Certificate != nil && sslEnabled {
+				host.Certificate = existing.Certificate
+			}
+			delete(onDemand.Hosts, hostname)
+			if len(onDemand.Hosts) == 0 {
+				delete(s.Projects, OnDemandProject)
+			}
+		}
+	}
+
+	// Preserve existing ID, certificate, TLS policy, limits, mode, scale to zero, rules, mirror, probes, error pages and deployment history if updating
+	if existing := s.Projects[project].Hosts[hostname]; existing != nil {
+		host.ID = existing.ID
+		if existing.Certificate != nil {
+			host.Certificate = existing.Certificate
+		}
+		host.TLS = existing.TLS
+		host.Limits = existing.Limits
+		host.Mode = existing.Mode
+		host.ScaleToZero = existing.ScaleToZero
+		host.IdleTimeout = existing.IdleTimeout
+		host.ColdStart = existing.ColdStart
+		host.Rules = existing.Rules
+		host.Mirror = existing.Mirror
+		host.Probes = existing.Probes
+		host.ErrorPages = existing.ErrorPages
+		host.Deployments = existing.Deployments
+	}
+
+	s.Projects[project].Hosts[hostname] = host
+	s.syncAliases(hostname, target)
+	s.markCritical()
+	s.hostSwitched(hostname, host)
+
+	return nil
+}
+
+// newHost creates a freshly deployed host
+func newHost(target, app, healthPath string, sslEnabled bool) *Host {
 	host := &Host{
-		Target:          target,
-		App:             app,
-		HealthPath:      healthPath,
-		CreatedAt:       time.Now(),
-		SSLEnabled:      sslEnabled,
-		SSLRedirect:     sslEnabled,
-		ForwardHeaders:  true,
-		ResponseTimeout: "30s",
-		Healthy:         true, // Assume healthy until health check proves otherwise
+		ID:             newHostID(),
+		Target:         target,
+		App:            app,
+		HealthPath:     healthPath,
+		CreatedAt:      time.Now(),
+		SSLEnabled:     sslEnabled,
+		SSLRedirect:    sslEnabled,
+		ForwardHeaders: true,
+		HostTimeouts:   HostTimeouts{ResponseTimeout: DefaultResponseTimeout},
+		Healthy:        true, // Assume healthy until health check proves otherwise
 	}
 
 	// If SSL is enabled, set up certificate status
@@ -187,24 +615,317 @@ func (s *State) DeployHost(hostname, target, project, app, healthPath string, ss
 		}
 	}
 
-	// Preserve existing certificate if updating
-	if existing := s.Projects[project].Hosts[hostname]; existing != nil && existing.Certificate != nil {
-		host.Certificate = existing.Certificate
+	return host
+}
+
+// newHostID returns a random host ID
+func newHostID() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return "host_" + hex.EncodeToString(bytes)
+}
+
+// carryOver keeps what a host spec doesn't describe from the host being
+// replaced: its ID, its certificate while SSL stays on, its deployment
+// history and the settings managed by their own endpoints
+func (h *Host) carryOver(existing *Host) {
+	h.ID = existing.ID
+	if existing.Certificate != nil && h.SSLEnabled {
+		h.Certificate = existing.Certificate
 	}
+	h.TLS = existing.TLS
+	h.Limits = existing.Limits
+	h.ScaleToZero = existing.ScaleToZero
+	h.IdleTimeout = existing.IdleTimeout
+	h.ColdStart = existing.ColdStart
+	h.Rules = existing.Rules
+	h.Mirror = existing.Mirror
+	h.Probes = existing.Probes
+	h.ErrorPages = existing.ErrorPages
+	h.Deployments = existing.Deployments
+}
 
-	s.Projects[project].Hosts[hostname] = host
-	s.modified = true
+// ETag identifies the current configuration of a host in a project, for
+// conditional requests. Certificate renewals don't change it, the caller
+// doesn't manage certificates and shouldn't see them as conflicting writes.
+func (h *Host) ETag(project string) string {
+	host := *h
+	host.Certificate = nil
+	data, _ := json.Marshal(struct {
+		Project string `json:"project"`
+		Host    *Host  `json:"host"`
+	}{project, &host})
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ErrPreconditionFailed is returned by conditional writes when the host
+// isn't in the state the caller expected
+var ErrPreconditionFailed = errors.New("precondition failed")
 
+// Precondition makes a host write conditional, like the HTTP If-Match and
+// If-None-Match headers. Empty fields allow any state.
+type Precondition struct {
+	IfMatch     string // Comma separated ETags one of which the host must have, or "*" for any existing host
+	IfNoneMatch string // "*" requires that the host doesn't exist yet
+}
+
+// check tests the precondition against a host, nil if it doesn't exist
+func (p Precondition) check(host *Host, project string) error {
+	if p.IfMatch != "" {
+		if host == nil {
+			return fmt.Errorf("%w: host does not exist", ErrPreconditionFailed)
+		}
+		if !ETagMatches(p.IfMatch, host.ETag(project)) {
+			return fmt.Errorf("%w: host has changed, it is now %s", ErrPreconditionFailed, host.ETag(project))
+		}
+	}
+	if p.IfNoneMatch == "*" && host != nil {
+		return fmt.Errorf("%w: host already exists", ErrPreconditionFailed)
+	}
 	return nil
 }
 
+// ETagMatches reports whether a comma separated list of ETags, or "*",
+// contains etag
+func ETagMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// HostSpec is the desired routing configuration of a host
+type HostSpec struct {
+	Target     string `json:"target"`
+	App        string `json:"app"`
+	HealthPath string `json:"health_path"`
+	SSL        bool   `json:"ssl"`
+	Mode       string `json:"mode,omitempty"` // HostModeHTTP (default) or HostModePassthrough
+	Streaming  bool   `json:"streaming,omitempty"`
+	Internal   bool   `json:"internal,omitempty"` // Only served to clients on private networks
+	HostTimeouts
+}
+
+// matches reports whether a host is already deployed as described
+func (spec *HostSpec) matches(host *Host) bool {
+	return host.Target == spec.Target &&
+		host.App == spec.App &&
+		host.HealthPath == spec.HealthPath &&
+		host.SSLEnabled == spec.SSL &&
+		host.Mode == spec.Mode &&
+		host.Streaming == spec.Streaming &&
+		host.Internal == spec.Internal &&
+		host.HostTimeouts == spec.HostTimeouts.withDefaults()
+}
+
+// ApplyResult lists the hostnames Apply added, updated, removed and left alone
+type ApplyResult struct {
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+	Unchanged []string `json:"unchanged"`
+}
+
+// Changed reports whether any host was added, updated or removed
+func (r *ApplyResult) Changed() bool {
+	return len(r.Added) > 0 || len(r.Updated) > 0 || len(r.Removed) > 0
+}
+
+// Apply makes the hosts match desired, keyed by project and then hostname, in
+// one step: hosts missing from desired are removed. The document is validated
+// before anything changes, so an invalid one leaves the state untouched.
+// Updated hosts keep their certificate, TLS policy and limits. With dryRun the
+// changes are only reported.
+func (s *State) Apply(desired map[string]map[string]*HostSpec, dryRun bool) (*ApplyResult, error) {
+	owners := make(map[string]string)
+	for project, hosts := range desired {
+		if project == "" {
+			return nil, fmt.Errorf("project name must not be empty")
+		}
+		for hostname, spec := range hosts {
+			if spec == nil || spec.Target == "" {
+				return nil, fmt.Errorf("host %s has no target", hostname)
+			}
+			if spec.Mode != "" && spec.Mode != HostModeHTTP && spec.Mode != HostModePassthrough {
+				return nil, fmt.Errorf("unknown host mode %q for %s, expected %s or %s", spec.Mode, hostname, HostModeHTTP, HostModePassthrough)
+			}
+			if err := spec.HostTimeouts.Validate(); err != nil {
+				return nil, fmt.Errorf("host %s: %w", hostname, err)
+			}
+			if other, exists := owners[hostname]; exists {
+				return nil, fmt.Errorf("host %s is in both project %s and %s", hostname, other, project)
+			}
+			owners[hostname] = project
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &ApplyResult{
+		Added:     []string{},
+		Updated:   []string{},
+		Removed:   []string{},
+		Unchanged: []string{},
+	}
+
+	for projectName, project := range s.Projects {
+		for hostname, host := range project.Hosts {
+			// On-demand hosts, custom domains and status pages aren't deployed, so a document never lists them
+			if (host.OnDemand || host.AliasOf != "" || host.Target == StatusPageTarget) && owners[hostname] == "" {
+				continue
+			}
+			if owners[hostname] == "" {
+				result.Removed = append(result.Removed, hostname)
+				if !dryRun {
+					delete(project.Hosts, hostname)
+					s.hostRemoved(hostname, host)
+				}
+			} else if owners[hostname] != projectName {
+				// Moving to another project, re-added below
+				result.Updated = append(result.Updated, hostname)
+			}
+		}
+	}
+
+	for projectName, hosts := range desired {
+		for hostname, spec := range hosts {
+			existing, existingProject := s.findHost(hostname)
+			switch {
+			case existing == nil:
+				result.Added = append(result.Added, hostname)
+			case existingProject == projectName && spec.matches(existing):
+				result.Unchanged = append(result.Unchanged, hostname)
+				continue
+			case existingProject == projectName:
+				result.Updated = append(result.Updated, hostname)
+			}
+			if dryRun {
+				continue
+			}
+
+			host := newHost(spec.Target, spec.App, spec.HealthPath, spec.SSL && spec.Mode != HostModePassthrough)
+			host.Mode = spec.Mode
+			host.Streaming = spec.Streaming
+			host.Internal = spec.Internal
+			host.HostTimeouts = spec.HostTimeouts.withDefaults()
+			if existing != nil {
+				host.carryOver(existing)
+				delete(s.Projects[existingProject].Hosts, hostname)
+			}
+
+			if s.Projects[projectName] == nil {
+				s.Projects[projectName] = &Project{Hosts: make(map[string]*Host)}
+			}
+			s.Projects[projectName].Hosts[hostname] = host
+			s.syncAliases(hostname, spec.Target)
+			s.hostChanged(hostname, host)
+		}
+	}
+
+	if !dryRun {
+		// Clean up empty projects
+		for projectName, project := range s.Projects {
+			if len(project.Hosts) == 0 {
+				delete(s.Projects, projectName)
+			}
+		}
+		if result.Changed() {
+			s.markCritical()
+		}
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Updated)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Unchanged)
+	return result, nil
+}
+
+// PutHost creates or replaces one host from spec, moving it to project if it
+// is in another one. Like Apply, a host already deployed as described is
+// left alone and a replaced host keeps its ID, certificate, TLS policy,
+// limits and scale to zero settings. The precondition is checked under the
+// same lock as the write, so two writers can't both pass it. Reports whether
+// the host was created and whether anything changed.
+func (s *State) PutHost(hostname, project string, spec *HostSpec, pre Precondition) (created, changed bool, err error) {
+	if project == "" {
+		return false, false, fmt.Errorf("project name must not be empty")
+	}
+	if spec.Target == "" {
+		return false, false, fmt.Errorf("host %s has no target", hostname)
+	}
+	if spec.Mode != "" && spec.Mode != HostModeHTTP && spec.Mode != HostModePassthrough {
+		return false, false, fmt.Errorf("unknown host mode %q for %s, expected %s or %s", spec.Mode, hostname, HostModeHTTP, HostModePassthrough)
+	}
+	if err := spec.HostTimeouts.Validate(); err != nil {
+		return false, false, fmt.Errorf("host %s: %w", hostname, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, existingProject := s.findHost(hostname)
+	if err := pre.check(existing, existingProject); err != nil {
+		return false, false, err
+	}
+	if existing != nil && existingProject == project && spec.matches(existing) {
+		return false, false, nil
+	}
+
+	host := newHost(spec.Target, spec.App, spec.HealthPath, spec.SSL && spec.Mode != HostModePassthrough)
+	host.Mode = spec.Mode
+	host.Streaming = spec.Streaming
+	host.Internal = spec.Internal
+	host.HostTimeouts = spec.HostTimeouts.withDefaults()
+	if existing != nil {
+		host.carryOver(existing)
+		delete(s.Projects[existingProject].Hosts, hostname)
+		if len(s.Projects[existingProject].Hosts) == 0 {
+			delete(s.Projects, existingProject)
+		}
+	}
+
+	if s.Projects[project] == nil {
+		s.Projects[project] = &Project{Hosts: make(map[string]*Host)}
+	}
+	s.Projects[project].Hosts[hostname] = host
+	s.syncAliases(hostname, spec.Target)
+	s.markCritical()
+	s.hostChanged(hostname, host)
+
+	return existing == nil, true, nil
+}
+
+// findHost returns a host and its project. The caller must hold s.mu.
+func (s *State) findHost(hostname string) (*Host, string) {
+	for projectName, project := range s.Projects {
+		if host, exists := project.Hosts[hostname]; exists {
+			return host, projectName
+		}
+	}
+	return nil, ""
+}
+
 // RemoveHost removes a host configuration
 func (s *State) RemoveHost(hostname string) error {
+	return s.RemoveHostIf(hostname, Precondition{})
+}
+
+// RemoveHostIf removes a host configuration if the precondition holds
+func (s *State) RemoveHostIf(hostname string, pre Precondition) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for projectName, project := range s.Projects {
-		if _, exists := project.Hosts[hostname]; exists {
+		if host, exists := project.Hosts[hostname]; exists {
+			if err := pre.check(host, projectName); err != nil {
+				return err
+			}
 			delete(project.Hosts, hostname)
 
 			// Clean up empty projects
@@ -212,7 +933,8 @@ func (s *State) RemoveHost(hostname string) error {
 				delete(s.Projects, projectName)
 			}
 
-			s.modified = true
+			s.markCritical()
+			s.hostRemoved(hostname, host)
 			return nil
 		}
 	}
@@ -228,75 +950,454 @@ func (s *State) GetHost(hostname string) (*Host, string, error) {
 	for projectName, project := range s.Projects {
 		if host, exists := project.Hosts[hostname]; exists {
 			// Return a copy of the host to prevent race conditions
-			hostCopy := *host
-			return &hostCopy, projectName, nil
+			return copyHost(host), projectName, nil
 		}
 	}
 
 	return nil, "", fmt.Errorf("host %s not found", hostname)
 }
 
-// GetAllHosts returns all hosts across all projects
-func (s *State) GetAllHosts() map[string]*Host {
+// IsHostPattern reports whether a hostname is a wildcard pattern such as *.tenant.example.com
+func IsHostPattern(hostname string) bool {
+	return strings.HasPrefix(hostname, "*.")
+}
+
+// MatchHost finds the host serving a request hostname. Exact hosts win, then
+// the wildcard pattern with the longest matching suffix, so
+// *.eu.tenant.example.com beats *.tenant.example.com. Returns the matched key,
+// which is the pattern for wildcard matches.
+func (s *State) MatchHost(hostname string) (*Host, string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	hosts := make(map[string]*Host)
+	hostname = strings.ToLower(hostname)
+
+	var match *Host
+	var matchKey string
 	for _, project := range s.Projects {
-		for hostname, host := range project.Hosts {
-			hosts[hostname] = host
+		for key, host := range project.Hosts {
+			if key == hostname && !host.OnDemand {
+				hostCopy := *host
+				return &hostCopy, key, nil
+			}
+			if !IsHostPattern(key) || len(key) <= len(matchKey) {
+				continue
+			}
+			// The wildcard must cover at least one label
+			if strings.HasSuffix(hostname, key[1:]) && len(hostname) > len(key)-1 {
+				match, matchKey = host, key
+			}
 		}
 	}
 
-	return hosts
+	if match == nil {
+		return nil, "", fmt.Errorf("host %s not found", hostname)
+	}
+	hostCopy := *match
+	return &hostCopy, matchKey, nil
 }
 
-// UpdateCertificateStatus updates the certificate status for a host
-func (s *State) UpdateCertificateStatus(hostname string, status *CertificateStatus) error {
+// SetDefaultBackend sets the target for requests that match no host, an empty
+// target removes it
+func (s *State) SetDefaultBackend(target string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, project := range s.Projects {
-		if host, exists := project.Hosts[hostname]; exists {
-			host.Certificate = status
-			s.modified = true
-			return nil
-		}
+	if target == "" {
+		s.Default = nil
+	} else {
+		s.Default = &DefaultBackend{Target: target}
 	}
+	s.markModified()
+	s.hostChanged("", nil)
+}
 
-	return fmt.Errorf("host %s not found", hostname)
+// GetDefaultBackend returns the default backend target, or "" if none is set
+func (s *State) GetDefaultBackend() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.Default == nil {
+		return ""
+	}
+	return s.Default.Target
 }
 
-// UpdateHealthStatus updates the health status for a host (runtime only)
-func (s *State) UpdateHealthStatus(hostname string, healthy bool) error {
+// SetErrorPages sets the error page templates used by hosts without their
+// own, an empty map restores the built-in plain text responses
+func (s *State) SetErrorPages(pages map[string]string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, project := range s.Projects {
-		if host, exists := project.Hosts[hostname]; exists {
-			host.Healthy = healthy
-			host.LastHealthCheck = time.Now()
-			// Note: We don't set modified=true because health is runtime-only
-			return nil
-		}
+	if len(pages) == 0 {
+		pages = nil
 	}
+	s.ErrorPages = pages
+	s.markModified()
+}
 
-	return fmt.Errorf("host %s not found", hostname)
+// GetErrorPages returns a copy of the global error page templates
+func (s *State) GetErrorPages() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := make(map[string]string, len(s.ErrorPages))
+	for status, page := range s.ErrorPages {
+		pages[status] = page
+	}
+	return pages
 }
 
-// SetLetsEncryptStaging enables or disables Let's Encrypt staging mode
-func (s *State) SetLetsEncryptStaging(enabled bool) {
+// SetOnDemandTLS sets the hostnames allowed to get certificates on their first
+// TLS handshake and the limits on them, an empty allowlist turns on-demand
+// TLS off
+func (s *State) SetOnDemandTLS(onDemand OnDemandTLS) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(onDemand.Allow) == 0 {
+		s.OnDemandTLS = nil
+	} else {
+		onDemand.Allow = append([]string{}, onDemand.Allow...)
+		s.OnDemandTLS = &onDemand
+	}
+	s.markModified()
+}
+
+// GetOnDemandTLS returns a copy of the on-demand TLS settings, with an empty
+// allowlist when it is off
+func (s *State) GetOnDemandTLS() OnDemandTLS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.OnDemandTLS == nil {
+		return OnDemandTLS{Allow: []string{}}
+	}
+	onDemand := *s.OnDemandTLS
+	onDemand.Allow = append([]string{}, s.OnDemandTLS.Allow...)
+	return onDemand
+}
+
+// AllowsOnDemand reports whether hostname may get an on-demand certificate
+func (s *State) AllowsOnDemand(hostname string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.OnDemandTLS == nil || hostname == "" {
+		return false
+	}
+	hostname = strings.ToLower(hostname)
+	for _, pattern := range s.OnDemandTLS.Allow {
+		pattern = strings.ToLower(pattern)
+		if pattern == hostname {
+			return true
+		}
+		// The wildcard must cover at least one label
+		if IsHostPattern(pattern) && strings.HasSuffix(hostname, pattern[1:]) && len(hostname) > len(pattern)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// AddOnDemandHost creates the host that tracks an on-demand certificate. It
+// isn't routed, requests for it are served like any unknown host. Returns
+// ErrOnDemandLimit once on-demand TLS holds as many hosts as it may.
+func (s *State) AddOnDemandHost(hostname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if host, _ := s.findHost(hostname); host != nil {
+		return fmt.Errorf("host %s already exists", hostname)
+	}
+
+	limit := DefaultOnDemandMaxHosts
+	if s.OnDemandTLS != nil {
+		limit = s.OnDemandTLS.HostLimit()
+	}
+	if project := s.Projects[OnDemandProject]; project != nil && len(project.Hosts) >= limit {
+		return fmt.Errorf("%w (%d hosts)", ErrOnDemandLimit, limit)
+	}
+
+	if s.Projects[OnDemandProject] == nil {
+		s.Projects[OnDemandProject] = &Project{Hosts: make(map[string]*Host)}
+	}
+	host := newHost("", "", "", true)
+	host.OnDemand = true
+	s.Projects[OnDemandProject].Hosts[hostname] = host
+	s.markModified()
+	s.hostChanged(hostname, host)
+
+	return nil
+}
+
+// RemoveOnDemandHost removes a host created by on-demand TLS whose
+// certificate was never issued, e.g. because its acquisition failed. Hosts
+// that were deployed meanwhile or hold a certificate are kept.
+func (s *State) RemoveOnDemandHost(hostname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	project := s.Projects[OnDemandProject]
+	if project == nil || project.Hosts[hostname] == nil {
+		return fmt.Errorf("on-demand host %s not found", hostname)
+	}
+	host := project.Hosts[hostname]
+	if host.Certificate != nil && host.Certificate.Status == "active" {
+		return fmt.Errorf("on-demand host %s has a certificate", hostname)
+	}
+
+	delete(project.Hosts, hostname)
+	if len(project.Hosts) == 0 {
+		delete(s.Projects, OnDemandProject)
+	}
+	s.markModified()
+	s.hostRemoved(hostname, host)
+
+	return nil
+}
+
+// GetAllHosts returns copies of all hosts across all projects
+func (s *State) GetAllHosts() map[string]*Host {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hosts := make(map[string]*Host)
+	for _, project := range s.Projects {
+		for hostname, host := range project.Hosts {
+			hosts[hostname] = copyHost(host)
+		}
+	}
+
+	return hosts
+}
+
+// copyHost copies a host and its certificate status, so the copy can be read
+// and changed without holding the lock. Changes are saved through the
+// State's methods, e.g. UpdateCertificateStatus.
+func copyHost(host *Host) *Host {
+	hostCopy := *host
+	if host.Certificate != nil {
+		certificate := *host.Certificate
+		hostCopy.Certificate = &certificate
+	}
+	return &hostCopy
+}
+
+// GetRoutes returns copies of all hosts keyed by hostname or pattern, for
+// building routing tables without holding the state lock
+func (s *State) GetRoutes() map[string]Host {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	routes := make(map[string]Host)
+	for _, project := range s.Projects {
+		for hostname, host := range project.Hosts {
+			routes[hostname] = *host
+		}
+	}
+	return routes
+}
+
+// UpdateCertificateStatus updates the certificate status for a host
+func (s *State) UpdateCertificateStatus(hostname string, status *CertificateStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, project := range s.Projects {
+		if host, exists := project.Hosts[hostname]; exists {
+			host.Certificate = status
+			if status != nil && status.Status == "active" {
+				s.markCritical()
+			} else {
+				s.markModified()
+			}
+			if status != nil {
+				s.certChanged(hostname, status.Status)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host %s not found", hostname)
+}
+
+// SetCrashLooping flags the hosts routed to a project's app while its container
+// crash loops and returns their names. Crash looping hosts are marked unhealthy
+// (runtime only).
+func (s *State) SetCrashLooping(project, app string, looping bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hostnames := s.appHosts(project, app)
+	for _, hostname := range hostnames {
+		host := s.Projects[project].Hosts[hostname]
+		host.CrashLooping = looping
+		if looping {
+			host.Healthy = false
+		}
+	}
+	return hostnames
+}
+
+// AppHosts returns the sorted names of the hosts routed to a project's app
+func (s *State) AppHosts(project, app string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.appHosts(project, app)
+}
+
+// appHosts matches hosts by app or by a target naming the app's
+// project-specific alias ("blog-web:3000"). Callers hold s.mu.
+func (s *State) appHosts(project, app string) []string {
+	p, exists := s.Projects[project]
+	if !exists {
+		return nil
+	}
+
+	alias := project + "-" + app
+	var hostnames []string
+	for hostname, host := range p.Hosts {
+		targetHost, _, err := net.SplitHostPort(host.Target)
+		if err != nil {
+			targetHost = host.Target
+		}
+		if host.App == app || targetHost == alias {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+
+	sort.Strings(hostnames)
+	return hostnames
+}
+
+// AppRef names an app of a project
+type AppRef struct {
+	Project string `json:"project"`
+	App     string `json:"app"`
+}
+
+// HostApps returns the app each host is routed to, keyed by hostname or
+// pattern. Hosts without an app belong to the app their target's alias names,
+// e.g. "blog-web:3000" to web, and hosts routed elsewhere are left out.
+func (s *State) HostApps() map[string]AppRef {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	apps := make(map[string]AppRef)
+	for name, project := range s.Projects {
+		for hostname, host := range project.Hosts {
+			app := host.App
+			if app == "" {
+				targetHost, _, err := net.SplitHostPort(host.Target)
+				if err != nil {
+					targetHost = host.Target
+				}
+				app = strings.TrimPrefix(targetHost, name+"-")
+				if app == targetHost || app == "" {
+					continue
+				}
+			}
+			apps[hostname] = AppRef{Project: name, App: app}
+		}
+	}
+	return apps
+}
+
+// SetSleeping flags the hosts routed to a project's app while it is scaled to
+// zero and returns their names. Sleeping hosts are unhealthy until woken, then
+// healthy again (runtime only).
+func (s *State) SetSleeping(project, app string, sleeping bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hostnames := s.appHosts(project, app)
+	for _, hostname := range hostnames {
+		host := s.Projects[project].Hosts[hostname]
+		host.Sleeping = sleeping
+		host.Healthy = !sleeping
+		host.LastHealthCheck = time.Now()
+	}
+	return hostnames
+}
+
+// SetStopped flags the hosts routed to a project's app while it is stopped on
+// purpose and returns their names. Stopped hosts are unhealthy and skipped by
+// health checks until the app is started again or redeployed.
+func (s *State) SetStopped(project, app string, stopped bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hostnames := s.appHosts(project, app)
+	for _, hostname := range hostnames {
+		host := s.Projects[project].Hosts[hostname]
+		host.Stopped = stopped
+		host.Healthy = false
+		host.LastHealthCheck = time.Now()
+		s.hostChanged(hostname, host)
+	}
+	if len(hostnames) > 0 {
+		s.markModified()
+	}
+	return hostnames
+}
+
+// UpdateHealthStatus updates the health status for a host (runtime only)
+func (s *State) UpdateHealthStatus(hostname string, healthy bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, project := range s.Projects {
+		if host, exists := project.Hosts[hostname]; exists {
+			host.Healthy = healthy
+			host.LastHealthCheck = time.Now()
+			// Note: We don't set modified=true because health is runtime-only
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host %s not found", hostname)
+}
+
+// SetLetsEncryptStaging enables or disables Let's Encrypt staging mode
+func (s *State) SetLetsEncryptStaging(enabled bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.LetsEncrypt.Staging = enabled
 	if enabled {
-		s.LetsEncrypt.DirectoryURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+		s.LetsEncrypt.DirectoryURL = letsEncryptStagingDirectoryURL
 	} else {
-		s.LetsEncrypt.DirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+		s.LetsEncrypt.DirectoryURL = letsEncryptDirectoryURL
 	}
+	s.LetsEncrypt.EABKeyID = ""
+	s.LetsEncrypt.EABHMACKey = ""
+
+	s.markModified()
+}
+
+// SetACMEConfig switches the certificate authority, account email and
+// External Account Binding credentials
+func (s *State) SetACMEConfig(directoryURL, email, eabKeyID, eabHMACKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.LetsEncrypt.DirectoryURL = directoryURL
+	s.LetsEncrypt.Staging = directoryURL == letsEncryptStagingDirectoryURL
+	s.LetsEncrypt.Email = email
+	s.LetsEncrypt.EABKeyID = eabKeyID
+	s.LetsEncrypt.EABHMACKey = eabHMACKey
+
+	s.markModified()
+}
 
-	s.modified = true
+// GetACMEConfig returns a copy of the ACME configuration
+func (s *State) GetACMEConfig() LetsEncryptConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return *s.LetsEncrypt
 }
 
 // SwitchTarget updates the target for a host (for blue-green deployments)
@@ -307,10 +1408,317 @@ func (s *State) SwitchTarget(hostname, newTarget string) error {
 	for _, project := range s.Projects {
 		if host, exists := project.Hosts[hostname]; exists {
 			host.Target = newTarget
-			s.modified = true
+			s.syncAliases(hostname, newTarget)
+			s.markModified()
+			s.hostSwitched(hostname, host)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host %s not found", hostname)
+}
+
+// SetHostTLSPolicy sets the TLS policy for a host, nil restores the default
+func (s *State) SetHostTLSPolicy(hostname string, policy *TLSPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, project := range s.Projects {
+		if host, exists := project.Hosts[hostname]; exists {
+			host.TLS = policy
+			s.markModified()
+			s.hostChanged(hostname, host)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host %s not found", hostname)
+}
+
+// SetHostLimits sets the resource limits for a host, nil removes them
+func (s *State) SetHostLimits(hostname string, limits *HostLimits) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, project := range s.Projects {
+		if host, exists := project.Hosts[hostname]; exists {
+			host.Limits = limits
+			s.markModified()
+			s.hostChanged(hostname, host)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host %s not found", hostname)
+}
+
+// SetHostRules replaces the routing rules of a host, nil removes them
+func (s *State) SetHostRules(hostname string, rules []RoutingRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	host.Rules = rules
+	s.markModified()
+	s.hostChanged(hostname, host)
+	return nil
+}
+
+// SetHostMirror sets the shadow target of a host, nil stops mirroring
+func (s *State) SetHostMirror(hostname string, mirror *MirrorPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	host.Mirror = mirror
+	s.markModified()
+	s.hostChanged(hostname, host)
+	return nil
+}
+
+// SetHostProbes sets the readiness and liveness probes of a host, nil goes
+// back to the regular health check
+func (s *State) SetHostProbes(hostname string, probes *HostProbes) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	host.Probes = probes
+	s.markModified()
+	s.hostChanged(hostname, host)
+	return nil
+}
+
+// SetHostErrorPages sets the error page templates of a host by status code,
+// an empty map falls back to the global ones
+func (s *State) SetHostErrorPages(hostname string, pages map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	if len(pages) == 0 {
+		pages = nil
+	}
+	host.ErrorPages = pages
+	s.markModified()
+	s.hostChanged(hostname, host)
+	return nil
+}
+
+// SetScaleToZero enables or disables scaling a host's app to zero when idle.
+// An empty idle timeout and a nil cold start policy use the defaults.
+func (s *State) SetScaleToZero(hostname string, enabled bool, idleTimeout string, coldStart *ColdStartPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	host.ScaleToZero = enabled
+	host.IdleTimeout = ""
+	host.ColdStart = nil
+	if enabled {
+		host.IdleTimeout = idleTimeout
+		host.ColdStart = coldStart
+	}
+	s.markModified()
+	s.hostChanged(hostname, host)
+
+	return nil
+}
+
+// ScaleToZeroHost is a copy of a host that scales to zero, with its name and project
+type ScaleToZeroHost struct {
+	Hostname string
+	Project  string
+	Host     Host
+}
+
+// GetScaleToZeroHosts returns copies of the hosts that scale to zero, sorted by name
+func (s *State) GetScaleToZeroHosts() []ScaleToZeroHost {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hosts []ScaleToZeroHost
+	for projectName, project := range s.Projects {
+		for hostname, host := range project.Hosts {
+			if host.ScaleToZero {
+				hosts = append(hosts, ScaleToZeroHost{Hostname: hostname, Project: projectName, Host: *host})
+			}
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Hostname < hosts[j].Hostname })
+	return hosts
+}
+
+// SetHostMode sets how the proxy serves a host. Passthrough hosts don't get
+// certificates from the proxy.
+func (s *State) SetHostMode(hostname, mode string) error {
+	if mode != "" && mode != HostModeHTTP && mode != HostModePassthrough {
+		return fmt.Errorf("unknown host mode %q, expected %s or %s", mode, HostModeHTTP, HostModePassthrough)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, project := range s.Projects {
+		if host, exists := project.Hosts[hostname]; exists {
+			host.Mode = mode
+			if mode == HostModePassthrough {
+				host.Certificate = nil
+				host.SSLEnabled = false
+			}
+			s.markModified()
+			s.hostChanged(hostname, host)
 			return nil
 		}
 	}
 
 	return fmt.Errorf("host %s not found", hostname)
 }
+
+// SetHostTimeouts sets how long the proxy waits on a host's backend, empty
+// fields use the defaults
+func (s *State) SetHostTimeouts(hostname string, timeouts HostTimeouts) error {
+	if err := timeouts.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	host.HostTimeouts = timeouts.withDefaults()
+	s.markModified()
+	s.hostChanged(hostname, host)
+	return nil
+}
+
+// SetHostStreaming sets whether a host's responses are flushed to clients as
+// they arrive instead of being buffered
+func (s *State) SetHostStreaming(hostname string, streaming bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	host.Streaming = streaming
+	s.markModified()
+	s.hostChanged(hostname, host)
+	return nil
+}
+
+// SetHostInternal sets whether a host is only served to clients on private
+// networks
+func (s *State) SetHostInternal(hostname string, internal bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, _ := s.findHost(hostname)
+	if host == nil {
+		return fmt.Errorf("host %s not found", hostname)
+	}
+	host.Internal = internal
+	s.markModified()
+	s.hostChanged(hostname, host)
+	return nil
+}
+
+// SetPortForward adds a forwarding rule, replacing any rule for the same port and protocol
+func (s *State) SetPortForward(rule *PortForward) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.Ports {
+		if existing.Key() == rule.Key() {
+			s.Ports[i] = rule
+			s.markModified()
+			return
+		}
+	}
+
+	s.Ports = append(s.Ports, rule)
+	s.markModified()
+}
+
+// RemovePortForward removes the rule for a port and protocol
+func (s *State) RemovePortForward(listen int, protocol string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.Ports {
+		if existing.Listen == listen && existing.Protocol == protocol {
+			s.Ports = append(s.Ports[:i], s.Ports[i+1:]...)
+			s.markModified()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no forwarding rule for %d/%s", listen, protocol)
+}
+
+// GetPortForwards returns a copy of the forwarding rules
+func (s *State) GetPortForwards() []PortForward {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]PortForward, 0, len(s.Ports))
+	for _, rule := range s.Ports {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// SetDefaultTLSPolicy sets the TLS policy used by hosts without their own
+func (s *State) SetDefaultTLSPolicy(policy *TLSPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.TLS = policy
+	s.markModified()
+}
+
+// GetDefaultTLSPolicy returns the global TLS policy, or nil if none is set
+func (s *State) GetDefaultTLSPolicy() *TLSPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.TLS
+}
+
+// SetNotifications replaces the configured notification targets
+func (s *State) SetNotifications(targets []*NotificationTarget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Notifications = targets
+	s.markModified()
+}
+
+// GetNotifications returns a copy of the configured notification targets
+func (s *State) GetNotifications() []*NotificationTarget {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	targets := make([]*NotificationTarget, len(s.Notifications))
+	copy(targets, s.Notifications)
+	return targets
+}
@@ -290,6 +290,497 @@ func TestSetLetsEncryptStaging(t *testing.T) {
 	assert.Equal(t, "https://acme-v02.api.letsencrypt.org/directory", state.LetsEncrypt.DirectoryURL)
 }
 
+func TestSetACMEConfig(t *testing.T) {
+	state := NewState("/tmp/test.json")
+
+	state.SetACMEConfig("https://acme.zerossl.com/v2/DV90", "ops@example.com", "kid-1", "c2VjcmV0")
+	config := state.GetACMEConfig()
+	assert.Equal(t, "https://acme.zerossl.com/v2/DV90", config.DirectoryURL)
+	assert.Equal(t, "ops@example.com", config.Email)
+	assert.Equal(t, "kid-1", config.EABKeyID)
+	assert.False(t, config.Staging)
+
+	// Switching to Let's Encrypt staging drops the EAB credentials
+	state.SetLetsEncryptStaging(true)
+	config = state.GetACMEConfig()
+	assert.True(t, config.Staging)
+	assert.Empty(t, config.EABKeyID)
+	assert.Empty(t, config.EABHMACKey)
+}
+
+func TestResolveACMEDirectory(t *testing.T) {
+	url, err := ResolveACMEDirectory("ZeroSSL")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://acme.zerossl.com/v2/DV90", url)
+
+	url, err = ResolveACMEDirectory("https://ca.internal.example.com/acme/directory")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ca.internal.example.com/acme/directory", url)
+
+	_, err = ResolveACMEDirectory("http://insecure.example.com/directory")
+	assert.Error(t, err)
+	_, err = ResolveACMEDirectory("letsencrpyt")
+	assert.Error(t, err)
+}
+
+func TestDeployHostKeepsTLSPolicy(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("legacy.example.com", "legacy-blue:80", "iot", "api", "/up", true))
+	require.NoError(t, st.SetHostTLSPolicy("legacy.example.com", &TLSPolicy{MinVersion: "1.0"}))
+
+	require.NoError(t, st.DeployHost("legacy.example.com", "legacy-green:80", "iot", "api", "/up", true))
+
+	host, _, err := st.GetHost("legacy.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, host.TLS)
+	assert.Equal(t, "1.0", host.TLS.MinVersion)
+}
+
+func TestSetHostLimits(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("noisy.example.com", "noisy-blue:80", "tenants", "web", "/up", true))
+
+	assert.Error(t, st.SetHostLimits("missing.example.com", &HostLimits{MaxConcurrent: 10}))
+	require.NoError(t, st.SetHostLimits("noisy.example.com", &HostLimits{MaxConcurrent: 10, Bandwidth: 1 << 20}))
+
+	// Limits survive redeploys
+	require.NoError(t, st.DeployHost("noisy.example.com", "noisy-green:80", "tenants", "web", "/up", true))
+	host, _, err := st.GetHost("noisy.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &HostLimits{MaxConcurrent: 10, Bandwidth: 1 << 20}, host.Limits)
+
+	require.NoError(t, st.SetHostLimits("noisy.example.com", nil))
+	host, _, _ = st.GetHost("noisy.example.com")
+	assert.Nil(t, host.Limits)
+}
+
+func TestSetHostRules(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-blue:80", "shop", "web", "/up", true))
+
+	rules := []RoutingRule{{Name: "beta", Header: "X-Beta", Value: "1", Target: "shop-beta:80"}}
+	assert.Error(t, st.SetHostRules("missing.example.com", rules))
+	require.NoError(t, st.SetHostRules("shop.example.com", rules))
+
+	// Rules survive redeploys
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-green:80", "shop", "web", "/up", true))
+	host, _, err := st.GetHost("shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, rules, host.Rules)
+
+	require.NoError(t, st.SetHostRules("shop.example.com", nil))
+	host, _, _ = st.GetHost("shop.example.com")
+	assert.Nil(t, host.Rules)
+}
+
+func TestSetHostMirror(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-blue:80", "shop", "web", "/up", true))
+
+	mirror := &MirrorPolicy{Target: "shop-green:80", Percent: 10}
+	assert.Error(t, st.SetHostMirror("missing.example.com", mirror))
+	require.NoError(t, st.SetHostMirror("shop.example.com", mirror))
+
+	// The mirror survives redeploys
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-green:80", "shop", "web", "/up", true))
+	host, _, err := st.GetHost("shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, mirror, host.Mirror)
+
+	require.NoError(t, st.SetHostMirror("shop.example.com", nil))
+	host, _, _ = st.GetHost("shop.example.com")
+	assert.Nil(t, host.Mirror)
+}
+
+func TestSetHostErrorPages(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-blue:80", "shop", "web", "/up", true))
+
+	pages := map[string]string{"502": "<p>Back soon</p>"}
+	assert.Error(t, st.SetHostErrorPages("missing.example.com", pages))
+	require.NoError(t, st.SetHostErrorPages("shop.example.com", pages))
+
+	// The pages survive redeploys
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-green:80", "shop", "web", "/up", true))
+	host, _, err := st.GetHost("shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, pages, host.ErrorPages)
+
+	require.NoError(t, st.SetHostErrorPages("shop.example.com", map[string]string{}))
+	host, _, _ = st.GetHost("shop.example.com")
+	assert.Nil(t, host.ErrorPages)
+
+	// Global pages are returned as a copy
+	st.SetErrorPages(map[string]string{"404": "<p>Not here</p>"})
+	st.GetErrorPages()["404"] = "changed"
+	assert.Equal(t, map[string]string{"404": "<p>Not here</p>"}, st.GetErrorPages())
+}
+
+func TestSetHostTimeouts(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop:80", "shop", "web", "/up", false))
+
+	host, _, _ := st.GetHost("shop.example.com")
+	assert.Equal(t, HostTimeouts{ResponseTimeout: DefaultResponseTimeout}, host.HostTimeouts)
+
+	assert.Error(t, st.SetHostTimeouts("missing.example.com", HostTimeouts{}))
+	assert.Error(t, st.SetHostTimeouts("shop.example.com", HostTimeouts{DialTimeout: "10"}))
+	assert.Error(t, st.SetHostTimeouts("shop.example.com", HostTimeouts{RequestTimeout: "-1s"}))
+
+	require.NoError(t, st.SetHostTimeouts("shop.example.com", HostTimeouts{RequestTimeout: "2m", StreamIdleTimeout: "15s"}))
+	host, _, _ = st.GetHost("shop.example.com")
+	assert.Equal(t, HostTimeouts{ResponseTimeout: DefaultResponseTimeout, RequestTimeout: "2m", StreamIdleTimeout: "15s"}, host.HostTimeouts)
+
+	// A spec with the same timeouts leaves the host alone, other timeouts update it
+	spec := &HostSpec{Target: "shop:80", App: "web", HealthPath: "/up", HostTimeouts: HostTimeouts{RequestTimeout: "2m", StreamIdleTimeout: "15s"}}
+	_, changed, err := st.PutHost("shop.example.com", "shop", spec, Precondition{})
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	spec.RequestTimeout = "5m"
+	_, changed, err = st.PutHost("shop.example.com", "shop", spec, Precondition{})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	host, _, _ = st.GetHost("shop.example.com")
+	assert.Equal(t, "5m", host.RequestTimeout)
+}
+
+func TestSetHostStreaming(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("events.example.com", "events:80", "events", "web", "/up", false))
+
+	assert.Error(t, st.SetHostStreaming("missing.example.com", true))
+	require.NoError(t, st.SetHostStreaming("events.example.com", true))
+	host, _, _ := st.GetHost("events.example.com")
+	assert.True(t, host.Streaming)
+
+	// Streaming is part of the spec, turning it off updates the host
+	_, changed, err := st.PutHost("events.example.com", "events", &HostSpec{Target: "events:80", App: "web", HealthPath: "/up"}, Precondition{})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	host, _, _ = st.GetHost("events.example.com")
+	assert.False(t, host.Streaming)
+}
+
+func TestSetStopped(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.DeployHost("www.blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.DeployHost("api.blog.example.com", "blog-api:3000", "blog", "api", "/up", false))
+
+	assert.Equal(t, []string{"blog.example.com", "www.blog.example.com"}, st.SetStopped("blog", "web", true))
+	host, _, _ := st.GetHost("blog.example.com")
+	assert.True(t, host.Stopped)
+	assert.False(t, host.Healthy)
+	api, _, _ := st.GetHost("api.blog.example.com")
+	assert.False(t, api.Stopped)
+	assert.Empty(t, st.SetStopped("blog", "missing", true))
+
+	// Stopped apps stay stopped across restarts
+	require.NoError(t, st.Save())
+	loaded := NewState(st.filePath)
+	require.NoError(t, loaded.Load())
+	host, _, _ = loaded.GetHost("www.blog.example.com")
+	assert.True(t, host.Stopped)
+
+	// A deploy brings the app back
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/up", false))
+	host, _, _ = st.GetHost("blog.example.com")
+	assert.False(t, host.Stopped)
+}
+
+func TestRecordDeployment(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-blue:3000", "blog", "web", "/up", false))
+
+	assert.Error(t, st.RecordDeployment("missing.example.com", "x:80", nil))
+	for i := 0; i < MaxDeploymentHistory+5; i++ {
+		require.NoError(t, st.RecordDeployment("blog.example.com", fmt.Sprintf("blog-%d:3000", i), map[string]string{"sha": fmt.Sprint(i)}))
+	}
+
+	// Redeploying keeps the history, only the most recent records are kept
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-green:3000", "blog", "web", "/up", false))
+	deployments, err := st.GetDeployments("blog.example.com")
+	require.NoError(t, err)
+	require.Len(t, deployments, MaxDeploymentHistory)
+	assert.Equal(t, fmt.Sprint(MaxDeploymentHistory+4), deployments[0].Metadata["sha"])
+	assert.Equal(t, "blog-5:3000", deployments[MaxDeploymentHistory-1].Target)
+
+	assert.NoError(t, ValidateMetadata(map[string]string{"ci_url": "https://ci.example.com/runs/1"}))
+	assert.Error(t, ValidateMetadata(map[string]string{"Commit SHA": "4f2a9c1"}))
+	assert.Equal(t, "actor=alice sha=4f2a9c1", FormatMetadata(map[string]string{"sha": "4f2a9c1", "actor": "alice"}))
+}
+
+func TestSetHostProbes(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-blue:80", "shop", "web", "/up", true))
+
+	probes := &HostProbes{
+		Readiness: &ProbePolicy{Path: "/ready", Interval: "5s"},
+		Liveness:  &ProbePolicy{FailureThreshold: 5},
+	}
+	require.NoError(t, probes.Validate())
+	assert.Error(t, st.SetHostProbes("missing.example.com", probes))
+	require.NoError(t, st.SetHostProbes("shop.example.com", probes))
+
+	// The probes survive redeploys
+	require.NoError(t, st.DeployHost("shop.example.com", "shop-green:80", "shop", "web", "/up", true))
+	host, _, err := st.GetHost("shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, probes, host.Probes)
+	assert.Equal(t, 5*time.Second, host.Probes.Readiness.Every())
+	assert.Equal(t, DefaultProbeFailureThreshold, host.Probes.Readiness.Threshold())
+	assert.Equal(t, DefaultProbeInterval, host.Probes.Liveness.Every())
+
+	require.NoError(t, st.SetHostProbes("shop.example.com", nil))
+	host, _, _ = st.GetHost("shop.example.com")
+	assert.Nil(t, host.Probes)
+
+	for _, invalid := range []*HostProbes{
+		{Readiness: &ProbePolicy{Path: "ready"}},
+		{Liveness: &ProbePolicy{Interval: "soon"}},
+		{Liveness: &ProbePolicy{Interval: "100ms"}},
+		{Readiness: &ProbePolicy{FailureThreshold: -1}},
+	} {
+		assert.Error(t, invalid.Validate())
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("*.tenant.example.com", "tenants:3000", "saas", "web", "/up", false))
+	require.NoError(t, st.DeployHost("*.eu.tenant.example.com", "tenants-eu:3000", "saas", "web", "/up", false))
+	require.NoError(t, st.DeployHost("admin.tenant.example.com", "admin:3000", "saas", "admin", "/up", false))
+
+	for hostname, expected := range map[string]string{
+		"acme.tenant.example.com":    "*.tenant.example.com",
+		"ACME.Tenant.Example.com":    "*.tenant.example.com",
+		"a.b.tenant.example.com":     "*.tenant.example.com",
+		"acme.eu.tenant.example.com": "*.eu.tenant.example.com",
+		"admin.tenant.example.com":   "admin.tenant.example.com",
+		"eu.tenant.example.com":      "*.tenant.example.com",
+	} {
+		host, key, err := st.MatchHost(hostname)
+		require.NoError(t, err, hostname)
+		assert.Equal(t, expected, key, hostname)
+		assert.NotEmpty(t, host.Target)
+	}
+
+	for _, hostname := range []string{"tenant.example.com", ".tenant.example.com", "example.com", "acme.tenant.example.org"} {
+		_, _, err := st.MatchHost(hostname)
+		assert.Error(t, err, hostname)
+	}
+}
+
+func TestDefaultBackend(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	assert.Empty(t, st.GetDefaultBackend())
+
+	st.SetDefaultBackend("fallback:8080")
+	assert.Equal(t, "fallback:8080", st.GetDefaultBackend())
+
+	st.SetDefaultBackend("")
+	assert.Empty(t, st.GetDefaultBackend())
+	assert.Nil(t, st.Default)
+}
+
+func TestSetHostMode(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("secure.example.com", "vault:8443", "vault", "api", "/up", true))
+
+	assert.Error(t, st.SetHostMode("secure.example.com", "tcp"))
+	require.NoError(t, st.SetHostMode("secure.example.com", HostModePassthrough))
+
+	// Passthrough hosts keep their mode on redeploy and never get a certificate
+	require.NoError(t, st.DeployHost("secure.example.com", "vault:8443", "vault", "api", "/up", false))
+	host, _, err := st.GetHost("secure.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, HostModePassthrough, host.Mode)
+	assert.Nil(t, host.Certificate)
+	assert.False(t, host.SSLEnabled)
+}
+
+func TestPortForwards(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetPortForward(&PortForward{Listen: 5432, Protocol: "tcp", Target: "postgres:5432"})
+	st.SetPortForward(&PortForward{Listen: 27015, Protocol: "udp", Target: "game:27015"})
+
+	// Same port and protocol replaces the rule
+	st.SetPortForward(&PortForward{Listen: 5432, Protocol: "tcp", Target: "postgres:5432", Allow: []string{"10.0.0.0/8"}})
+
+	rules := st.GetPortForwards()
+	require.Len(t, rules, 2)
+	assert.Equal(t, "5432/tcp", rules[0].Key())
+	assert.Equal(t, []string{"10.0.0.0/8"}, rules[0].Allow)
+
+	require.NoError(t, st.RemovePortForward(27015, "udp"))
+	assert.Error(t, st.RemovePortForward(27015, "udp"))
+	assert.Len(t, st.GetPortForwards(), 1)
+}
+
+func TestApply(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, state.DeployHost("keep.example.com", "keep:3000", "blog", "web", "/up", false))
+	require.NoError(t, state.DeployHost("change.example.com", "old:3000", "blog", "web", "/up", true))
+	require.NoError(t, state.DeployHost("gone.example.com", "gone:3000", "legacy", "web", "/up", false))
+	require.NoError(t, state.SetHostLimits("change.example.com", &HostLimits{MaxConcurrent: 5}))
+	require.NoError(t, state.UpdateCertificateStatus("change.example.com", &CertificateStatus{Status: "active"}))
+	state.modified = false
+
+	desired := map[string]map[string]*HostSpec{
+		"blog": {
+			"keep.example.com":   {Target: "keep:3000", App: "web", HealthPath: "/up"},
+			"change.example.com": {Target: "new:3000", App: "web", HealthPath: "/up", SSL: true},
+		},
+		"shop": {
+			"shop.example.com": {Target: "shop:8080", App: "web", HealthPath: "/health"},
+		},
+	}
+
+	// A dry run reports the changes without making them
+	result, err := state.Apply(desired, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop.example.com"}, result.Added)
+	assert.Equal(t, []string{"change.example.com"}, result.Updated)
+	assert.Equal(t, []string{"gone.example.com"}, result.Removed)
+	assert.Equal(t, []string{"keep.example.com"}, result.Unchanged)
+	assert.False(t, state.modified)
+	_, _, err = state.GetHost("gone.example.com")
+	assert.NoError(t, err)
+
+	result, err = state.Apply(desired, false)
+	require.NoError(t, err)
+	assert.True(t, result.Changed())
+	assert.True(t, state.modified)
+
+	_, _, err = state.GetHost("gone.example.com")
+	assert.Error(t, err)
+	assert.NotContains(t, state.Projects, "legacy")
+
+	changed, project, err := state.GetHost("change.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "blog", project)
+	assert.Equal(t, "new:3000", changed.Target)
+	assert.Equal(t, "active", changed.Certificate.Status)
+	assert.Equal(t, 5, changed.Limits.MaxConcurrent)
+
+	_, project, err = state.GetHost("shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "shop", project)
+
+	// Applying the same document again changes nothing
+	result, err = state.Apply(desired, false)
+	require.NoError(t, err)
+	assert.False(t, result.Changed())
+	assert.Len(t, result.Unchanged, 3)
+}
+
+func TestApplyMovesHostsBetweenProjects(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, state.DeployHost("app.example.com", "app:3000", "old", "web", "/up", false))
+
+	result, err := state.Apply(map[string]map[string]*HostSpec{
+		"new": {"app.example.com": {Target: "app:3000", App: "web", HealthPath: "/up"}},
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app.example.com"}, result.Updated)
+	assert.Empty(t, result.Removed)
+
+	_, project, err := state.GetHost("app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "new", project)
+	assert.NotContains(t, state.Projects, "old")
+}
+
+func TestApplyRejectsInvalidDocuments(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, state.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", false))
+
+	invalid := []map[string]map[string]*HostSpec{
+		{"blog": {"new.example.com": {Target: ""}}},
+		{"blog": {"new.example.com": {Target: "new:3000", Mode: "tcp"}}},
+		{"blog": {"new.example.com": {Target: "new:3000", HostTimeouts: HostTimeouts{ResponseTimeout: "soon"}}}},
+		{
+			"blog": {"dup.example.com": {Target: "a:3000"}},
+			"shop": {"dup.example.com": {Target: "b:3000"}},
+		},
+	}
+	for _, desired := range invalid {
+		_, err := state.Apply(desired, false)
+		assert.Error(t, err)
+	}
+
+	// Nothing was applied
+	assert.Len(t, state.GetAllHosts(), 1)
+}
+
+func TestOnDemandHosts(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	assert.False(t, state.AllowsOnDemand("shop.customer.com"))
+
+	state.SetOnDemandTLS(OnDemandTLS{Allow: []string{"*.customers.example.com", "shop.customer.com"}})
+	assert.True(t, state.AllowsOnDemand("shop.customer.com"))
+	assert.True(t, state.AllowsOnDemand("Acme.Customers.Example.com"))
+	assert.False(t, state.AllowsOnDemand("customers.example.com"))
+	assert.False(t, state.AllowsOnDemand("evil.example.com"))
+
+	require.NoError(t, state.AddOnDemandHost("shop.customer.com"))
+	assert.Error(t, state.AddOnDemandHost("shop.customer.com"))
+
+	host, project, err := state.GetHost("shop.customer.com")
+	require.NoError(t, err)
+	assert.Equal(t, OnDemandProject, project)
+	assert.True(t, host.OnDemand)
+	assert.Equal(t, "pending", host.Certificate.Status)
+
+	// On-demand hosts aren't routed, the request is served like an unknown host
+	_, _, err = state.MatchHost("shop.customer.com")
+	assert.Error(t, err)
+
+	// Apply leaves them alone
+	_, err = state.Apply(map[string]map[string]*HostSpec{}, false)
+	require.NoError(t, err)
+	_, _, err = state.GetHost("shop.customer.com")
+	require.NoError(t, err)
+
+	// Deploying the host takes over its certificate
+	require.NoError(t, state.UpdateCertificateStatus("shop.customer.com", &CertificateStatus{Status: "active"}))
+	require.NoError(t, state.DeployHost("shop.customer.com", "shop:3000", "shop", "web", "/up", true))
+	host, project, err = state.GetHost("shop.customer.com")
+	require.NoError(t, err)
+	assert.Equal(t, "shop", project)
+	assert.False(t, host.OnDemand)
+	assert.Equal(t, "active", host.Certificate.Status)
+	assert.NotContains(t, state.Projects, OnDemandProject)
+
+	state.SetOnDemandTLS(OnDemandTLS{})
+	assert.Empty(t, state.GetOnDemandTLS().Allow)
+}
+
+func TestOnDemandHostLimit(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	state.SetOnDemandTLS(OnDemandTLS{Allow: []string{"*.customers.example.com"}, MaxHosts: 2})
+	assert.Equal(t, 2, state.GetOnDemandTLS().HostLimit())
+	assert.Equal(t, DefaultOnDemandRateLimit, state.GetOnDemandTLS().IssueLimit())
+
+	require.NoError(t, state.AddOnDemandHost("a.customers.example.com"))
+	require.NoError(t, state.AddOnDemandHost("b.customers.example.com"))
+	assert.ErrorIs(t, state.AddOnDemandHost("c.customers.example.com"), ErrOnDemandLimit)
+
+	// Removing a failed host makes room again
+	require.NoError(t, state.RemoveOnDemandHost("a.customers.example.com"))
+	assert.Error(t, state.RemoveOnDemandHost("a.customers.example.com"))
+	require.NoError(t, state.AddOnDemandHost("c.customers.example.com"))
+
+	// Issued certificates and deployed hosts are kept
+	require.NoError(t, state.UpdateCertificateStatus("b.customers.example.com", &CertificateStatus{Status: "active"}))
+	assert.Error(t, state.RemoveOnDemandHost("b.customers.example.com"))
+	require.NoError(t, state.DeployHost("c.customers.example.com", "shop:3000", "shop", "web", "/up", true))
+	assert.Error(t, state.RemoveOnDemandHost("c.customers.example.com"))
+	_, _, err := state.GetHost("c.customers.example.com")
+	require.NoError(t, err)
+}
+
 func TestSwitchTarget(t *testing.T) {
 	state := NewState("/tmp/test.json")
 
@@ -406,3 +897,481 @@ func TestStateJSONSerialization(t *testing.T) {
 	assert.Equal(t, 5, cert.AttemptCount)
 	assert.Equal(t, "/certs/json.example.com/cert.pem", cert.CertFile)
 }
+
+func TestAutoscalePolicies(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/health", false))
+	require.NoError(t, st.DeployHost("www.blog.example.com", "blog-web:3000", "blog", "", "/health", false))
+	require.NoError(t, st.DeployHost("api.blog.example.com", "blog-api:3000", "blog", "api", "/health", false))
+
+	assert.Equal(t, []string{"blog.example.com", "www.blog.example.com"}, st.AppHosts("blog", "web"))
+
+	st.SetAutoscalePolicy(&AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 1, MaxReplicas: 4, TargetCPU: 70})
+	st.SetAutoscalePolicy(&AutoscalePolicy{Project: "blog", App: "api", MinReplicas: 2, MaxReplicas: 3, TargetCPU: 50})
+	st.SetAutoscalePolicy(&AutoscalePolicy{Project: "blog", App: "web", MinReplicas: 2, MaxReplicas: 6, TargetCPU: 70})
+
+	policies := st.GetAutoscalePolicies()
+	require.Len(t, policies, 2)
+	assert.Equal(t, "blog/api", policies[0].Key())
+	assert.Equal(t, 6, policies[1].MaxReplicas)
+
+	require.NoError(t, st.RemoveAutoscalePolicy("blog", "api"))
+	assert.Error(t, st.RemoveAutoscalePolicy("blog", "api"))
+	assert.Len(t, st.GetAutoscalePolicies(), 1)
+
+	snapshot, err := st.Snapshot()
+	require.NoError(t, err)
+	restored := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, st.GetAutoscalePolicies(), restored.GetAutoscalePolicies())
+}
+
+func TestScaleToZero(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/health", false))
+	require.NoError(t, st.SetScaleToZero("blog.example.com", true, "30m", &ColdStartPolicy{MaxWait: "20s", QueueDepth: 10}))
+	assert.Error(t, st.SetScaleToZero("missing.example.com", true, "", nil))
+
+	// Redeploying keeps the setting
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/health", false))
+	hosts := st.GetScaleToZeroHosts()
+	require.Len(t, hosts, 1)
+	assert.Equal(t, "blog", hosts[0].Project)
+	assert.Equal(t, "30m", hosts[0].Host.IdleTimeout)
+	assert.Equal(t, &ColdStartPolicy{MaxWait: "20s", QueueDepth: 10}, hosts[0].Host.ColdStart)
+
+	assert.Equal(t, []string{"blog.example.com"}, st.SetSleeping("blog", "web", true))
+	host, _, err := st.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.True(t, host.Sleeping)
+	assert.False(t, host.Healthy)
+
+	require.NoError(t, st.SetScaleToZero("blog.example.com", false, "30m", nil))
+	assert.Empty(t, st.GetScaleToZeroHosts())
+}
+
+func TestServiceRecords(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetServiceRecords("shop", []ServiceRecord{
+		{Name: "postgres", Address: "10.210.0.2"},
+		{Project: "other", Name: "api", Container: "shop-api"},
+	})
+	st.SetServiceRecords("blog", []ServiceRecord{{Name: "postgres", Address: "10.210.0.3"}})
+
+	records := st.GetServiceRecords()
+	require.Len(t, records, 3)
+	assert.Equal(t, "blog", records[0].Project)
+	assert.Equal(t, ServiceRecord{Project: "shop", Name: "api", Container: "shop-api"}, records[1])
+
+	// Only projects with containers on this server are routed to
+	assert.Equal(t, []string{"shop"}, st.RoutedProjects())
+
+	st.SetServiceRecords("shop", nil)
+	st.SetServiceRecords("blog", nil)
+	assert.Empty(t, st.GetServiceRecords())
+	assert.Nil(t, st.Discovery)
+}
+
+func TestQuotas(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	st.SetQuota(&Quota{Project: "shop", MaxContainers: 10, MaxMemory: 4 << 30})
+	st.SetQuota(&Quota{Project: "blog", MaxCPUs: 2})
+
+	quotas := st.GetQuotas()
+	require.Len(t, quotas, 2)
+	assert.Equal(t, "blog", quotas[0].Project)
+	assert.Equal(t, int64(4<<30), st.GetQuota("shop").MaxMemory)
+	assert.Nil(t, st.GetQuota("wiki"))
+
+	assert.Error(t, st.RemoveQuota("wiki"))
+	require.NoError(t, st.RemoveQuota("shop"))
+	require.NoError(t, st.RemoveQuota("blog"))
+	assert.Empty(t, st.GetQuotas())
+	assert.Nil(t, st.Quotas)
+}
+
+func TestStatusPages(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.SetStatusPage(&StatusPage{Project: "shop", Host: "status.shop.com", SSL: true}))
+	assert.Error(t, st.SetStatusPage(&StatusPage{Project: "blog", Host: "status.shop.com"}), "one page per host")
+	assert.Equal(t, "shop", st.StatusPageForHost("status.shop.com").Project)
+	assert.Nil(t, st.StatusPageForHost("shop.com"))
+
+	// Deploys don't list the page's host, applying leaves it alone
+	_, _, err := st.PutHost("status.shop.com", "shop", &HostSpec{Target: StatusPageTarget, App: StatusPageApp}, Precondition{})
+	require.NoError(t, err)
+	result, err := st.Apply(map[string]map[string]*HostSpec{}, false)
+	require.NoError(t, err)
+	assert.Empty(t, result.Removed)
+
+	incident, err := st.OpenIncident("shop", "Checkout is slow", IncidentInvestigating, "Looking into it", []string{"api"})
+	require.NoError(t, err)
+	assert.True(t, incident.Affects("api"))
+	assert.False(t, incident.Affects("web"))
+	_, err = st.OpenIncident("blog", "Down", IncidentInvestigating, "", nil)
+	assert.Error(t, err, "blog has no status page")
+	_, err = st.OpenIncident("shop", "Down", "panicking", "", nil)
+	assert.Error(t, err)
+
+	// Replacing the page keeps its incidents
+	require.NoError(t, st.SetStatusPage(&StatusPage{Project: "shop", Host: "status.shop.com", Title: "Shop"}))
+	require.Len(t, st.GetStatusPage("shop").Incidents, 1)
+
+	resolved, err := st.UpdateIncident(incident.ID, IncidentResolved, "Fixed")
+	require.NoError(t, err)
+	assert.NotNil(t, resolved.ResolvedAt)
+	assert.Len(t, resolved.Updates, 2)
+	assert.False(t, resolved.Affects("api"))
+	_, project, err := st.GetIncident(incident.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "shop", project)
+
+	// Resolved incidents are dropped after their retention
+	st.StatusPages["shop"].Incidents[0].ResolvedAt = &[]time.Time{time.Now().Add(-incidentRetention)}[0]
+	_, err = st.OpenIncident("shop", "Down again", IncidentIdentified, "", nil)
+	require.NoError(t, err)
+	incidents := st.GetStatusPage("shop").Incidents
+	require.Len(t, incidents, 1)
+	require.NoError(t, st.RemoveIncident(incidents[0].ID))
+	assert.Error(t, st.RemoveIncident(incidents[0].ID))
+
+	page, err := st.RemoveStatusPage("shop")
+	require.NoError(t, err)
+	assert.Equal(t, "status.shop.com", page.Host)
+	assert.Empty(t, st.GetStatusPages())
+	assert.Nil(t, st.StatusPages)
+}
+
+func TestUsers(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	assert.False(t, st.HasUsers())
+
+	require.NoError(t, st.AddUser("ci", RoleDeployer, nil, HashToken("iop_secret")))
+	assert.Error(t, st.AddUser("ci", RoleAdmin, nil, HashToken("iop_other")))
+	assert.Error(t, st.AddUser("root", "superuser", nil, HashToken("iop_other")))
+	assert.Error(t, st.AddUser("shop-ci", RoleDeployer, []string{""}, HashToken("iop_other")))
+	assert.True(t, st.HasUsers())
+
+	user, err := st.Authenticate("iop_secret")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", user.Name)
+	assert.True(t, user.Allows("shop"))
+
+	require.NoError(t, st.AddUser("shop-ci", RoleDeployer, []string{"shop", "shop-staging"}, HashToken("iop_shop")))
+	user, err = st.Authenticate("iop_shop")
+	require.NoError(t, err)
+	assert.True(t, user.Allows("shop-staging"))
+	assert.False(t, user.Allows("blog"))
+	require.NoError(t, st.RemoveUser("shop-ci"))
+	_, err = st.Authenticate("iop_wrong")
+	assert.Error(t, err)
+
+	users := st.GetUsers()
+	require.Len(t, users, 1)
+	assert.Empty(t, users[0].TokenHash, "hashes aren't handed out")
+
+	assert.True(t, RoleAllows(RoleDeployer, RoleReadOnly))
+	assert.False(t, RoleAllows(RoleDeployer, RoleAdmin))
+	assert.False(t, RoleAllows("", RoleReadOnly))
+
+	require.NoError(t, st.RemoveUser("ci"))
+	_, err = st.Authenticate("iop_secret")
+	assert.Error(t, err)
+	assert.Error(t, st.RemoveUser("ci"))
+}
+
+func TestPutHost(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	spec := &HostSpec{Target: "app:3000", App: "web", HealthPath: "/up"}
+
+	created, changed, err := state.PutHost("app.example.com", "blog", spec, Precondition{IfNoneMatch: "*"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.True(t, changed)
+
+	host, project, err := state.GetHost("app.example.com")
+	require.NoError(t, err)
+	id, etag := host.ID, host.ETag(project)
+	assert.NotEmpty(t, id)
+
+	// Repeating the request changes nothing
+	created, changed, err = state.PutHost("app.example.com", "blog", spec, Precondition{IfMatch: etag})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.False(t, changed)
+
+	_, _, err = state.PutHost("app.example.com", "blog", spec, Precondition{IfNoneMatch: "*"})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+	// A new target or project keeps the ID but changes the ETag
+	_, changed, err = state.PutHost("app.example.com", "shop", &HostSpec{Target: "app:4000", App: "web", HealthPath: "/up"}, Precondition{IfMatch: etag})
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	host, project, err = state.GetHost("app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "shop", project)
+	assert.Equal(t, id, host.ID)
+	assert.NotEqual(t, etag, host.ETag(project))
+	assert.NotContains(t, state.Projects, "blog")
+
+	// Writes based on the old ETag are rejected
+	_, _, err = state.PutHost("app.example.com", "shop", spec, Precondition{IfMatch: etag})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.ErrorIs(t, state.RemoveHostIf("app.example.com", Precondition{IfMatch: etag}), ErrPreconditionFailed)
+	assert.NoError(t, state.RemoveHostIf("app.example.com", Precondition{IfMatch: host.ETag(project)}))
+}
+
+func TestHostIDs(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, state.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	host, _, _ := state.GetHost("app.example.com")
+	id := host.ID
+
+	// Redeploys and certificate renewals keep the ID and ETag stable
+	require.NoError(t, state.DeployHost("app.example.com", "app:4000", "blog", "web", "/up", true))
+	host, project, _ := state.GetHost("app.example.com")
+	assert.Equal(t, id, host.ID)
+	etag := host.ETag(project)
+	require.NoError(t, state.UpdateCertificateStatus("app.example.com", &CertificateStatus{Status: "active"}))
+	host, _, _ = state.GetHost("app.example.com")
+	assert.Equal(t, etag, host.ETag(project))
+
+	// Hosts saved before IDs existed get one on load
+	require.NoError(t, os.WriteFile(state.filePath, []byte(`{"projects":{"blog":{"hosts":{"old.example.com":{"target":"old:3000"}}}}}`), 0644))
+	loaded := NewState(state.filePath)
+	require.NoError(t, loaded.Load())
+	host, _, err := loaded.GetHost("old.example.com")
+	require.NoError(t, err)
+	assert.NotEmpty(t, host.ID)
+	assert.True(t, loaded.modified)
+}
+
+func TestChangeHooks(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	hostChanges := make(chan HostChange, 10)
+	certChanges := make(chan CertChange, 10)
+	state.OnHostChanged(func(change HostChange) {
+		// Subscribers may read the state, the lock is released
+		state.GetAllHosts()
+		hostChanges <- change
+	})
+	state.OnCertChanged(func(change CertChange) { certChanges <- change })
+
+	// A deploy with SSL also has a certificate to acquire, and may have moved
+	// the host to other containers
+	require.NoError(t, state.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	assert.Equal(t, HostChange{Hostname: "app.example.com", Switched: true}, receive(t, hostChanges))
+	assert.Equal(t, CertChange{Hostname: "app.example.com", Status: "pending"}, receive(t, certChanges))
+
+	require.NoError(t, state.UpdateCertificateStatus("app.example.com", &CertificateStatus{Status: "active"}))
+	assert.Equal(t, CertChange{Hostname: "app.example.com", Status: "active"}, receive(t, certChanges))
+
+	// Settings changes notify in order
+	require.NoError(t, state.SetHostStreaming("app.example.com", true))
+	require.NoError(t, state.RemoveHost("app.example.com"))
+	assert.Equal(t, HostChange{Hostname: "app.example.com"}, receive(t, hostChanges))
+	// Removals pass on the certificate for cleaning up
+	assert.Equal(t, HostChange{Hostname: "app.example.com", Removed: true, Certificate: &CertificateStatus{Status: "active"}}, receive(t, hostChanges))
+
+	// Changes beyond one host name none
+	state.SetDefaultBackend("fallback:3000")
+	assert.Equal(t, HostChange{}, receive(t, hostChanges))
+
+	// Runtime only changes don't notify
+	require.NoError(t, state.DeployHost("api.example.com", "api:3000", "blog", "api", "/up", false))
+	receive(t, hostChanges)
+	require.NoError(t, state.UpdateHealthStatus("api.example.com", false))
+	select {
+	case change := <-hostChanges:
+		t.Fatalf("unexpected notification %+v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// receive waits for a notification
+func receive[T any](t *testing.T, changes chan T) T {
+	t.Helper()
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+	}
+	var zero T
+	return zero
+}
+
+func TestSaveSignals(t *testing.T) {
+	state := NewState(filepath.Join(t.TempDir(), "state.json"))
+	pending := func(signal <-chan struct{}) bool {
+		select {
+		case <-signal:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Settings changes are saved after a quiet moment
+	require.NoError(t, state.DeployHost("app.example.com", "app:3000", "blog", "web", "/up", true))
+	pending(state.Changed())
+	pending(state.Urgent())
+	require.NoError(t, state.SetHostStreaming("app.example.com", true))
+	assert.True(t, pending(state.Changed()))
+	assert.False(t, pending(state.Urgent()))
+
+	// Deploys, removals and issued certificates are saved right away
+	require.NoError(t, state.DeployHost("api.example.com", "api:3000", "blog", "api", "/up", false))
+	assert.True(t, pending(state.Urgent()))
+	require.NoError(t, state.UpdateCertificateStatus("app.example.com", &CertificateStatus{Status: "acquiring"}))
+	assert.False(t, pending(state.Urgent()))
+	require.NoError(t, state.UpdateCertificateStatus("app.example.com", &CertificateStatus{Status: "active"}))
+	assert.True(t, pending(state.Urgent()))
+	require.NoError(t, state.RemoveHost("api.example.com"))
+	assert.True(t, pending(state.Urgent()))
+
+	// Runtime only changes aren't saved
+	pending(state.Changed())
+	require.NoError(t, state.UpdateHealthStatus("app.example.com", false))
+	assert.False(t, pending(state.Changed()))
+}
+
+func TestSchemaMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	// Written before schema versions and per-host timeouts
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"projects": {"blog": {"hosts": {"app.example.com": {"id": "host_1", "target": "app:3000"}}}},
+		"lets_encrypt": {"email": "ops@example.com"},
+		"metadata": {"version": "2.0.0"}
+	}`), 0644))
+
+	state := NewState(path)
+	require.NoError(t, state.Load())
+	assert.Equal(t, SchemaVersion, state.Metadata.SchemaVersion)
+	assert.True(t, state.modified)
+	host, _, err := state.GetHost("app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultResponseTimeout, host.ResponseTimeout)
+	assert.Equal(t, "ops@example.com", state.GetACMEConfig().Email)
+
+	// A file from a newer proxy is left alone
+	require.NoError(t, os.WriteFile(path, []byte(`{"metadata": {"schema_version": 99}}`), 0644))
+	assert.ErrorIs(t, NewState(path).Load(), ErrNewerSchema)
+}
+
+func TestStateBackupsAndRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state := NewState(path)
+
+	// A save keeps the previous file once the newest backup is an hour old,
+	// up to StateBackups of them
+	for i := 0; i < StateBackups+2; i++ {
+		ageBackup(t, path+".1")
+		require.NoError(t, state.DeployHost(fmt.Sprintf("app%d.example.com", i), "app:3000", "blog", "web", "/up", false))
+		require.NoError(t, state.Save())
+	}
+	for n := 1; n <= StateBackups; n++ {
+		assert.FileExists(t, fmt.Sprintf("%s.%d", path, n))
+	}
+	assert.NoFileExists(t, fmt.Sprintf("%s.%d", path, StateBackups+1))
+
+	// Saves in quick succession keep the backups as they are
+	oldest, err := os.ReadFile(fmt.Sprintf("%s.%d", path, StateBackups))
+	require.NoError(t, err)
+	require.NoError(t, state.DeployHost("app-new.example.com", "app:3000", "blog", "web", "/up", false))
+	require.NoError(t, state.Save())
+	for i := 0; i < 3; i++ {
+		require.NoError(t, state.RemoveHost(fmt.Sprintf("app%d.example.com", i)))
+		require.NoError(t, state.Save())
+	}
+	newest, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.NotContains(t, string(newest), "app-new.example.com")
+	data, err := os.ReadFile(fmt.Sprintf("%s.%d", path, StateBackups))
+	require.NoError(t, err)
+	assert.Equal(t, oldest, data)
+
+	// A damaged file falls back to the newest backup that parses
+	require.NoError(t, os.WriteFile(path, []byte(`{"projects": {`), 0644))
+	require.NoError(t, os.WriteFile(path+".1", []byte(`not json`), 0644))
+	recovered := NewState(path)
+	require.NoError(t, recovered.Load())
+	assert.Len(t, recovered.GetAllHosts(), StateBackups)
+	assert.True(t, recovered.modified)
+	assert.NoFileExists(t, path)
+	corrupt, _ := filepath.Glob(path + ".corrupt-*")
+	assert.Len(t, corrupt, 1)
+
+	// Saving the recovered state doesn't rotate the damaged file into the backups
+	ageBackup(t, path+".1")
+	require.NoError(t, recovered.Save())
+	data, err = os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "not json", string(data))
+
+	// Without a usable backup loading fails
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0644))
+	for n := 1; n <= StateBackups; n++ {
+		os.Remove(fmt.Sprintf("%s.%d", path, n))
+	}
+	assert.Error(t, NewState(path).Load())
+}
+
+// ageBackup makes a backup, if there is one yet, older than
+// StateBackupInterval so the next save rotates
+func ageBackup(t *testing.T, path string) {
+	old := time.Now().Add(-StateBackupInterval)
+	if err := os.Chtimes(path, old, old); !os.IsNotExist(err) {
+		require.NoError(t, err)
+	}
+}
+
+func TestCertGroup(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("www.example.com", "web:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.DeployHost("api.example.com", "api:3000", "blog", "api", "/up", true))
+	require.NoError(t, st.DeployHost("plain.example.com", "web:3000", "blog", "web", "/up", false))
+	require.NoError(t, st.DeployHost("secure.example.com", "tls:443", "blog", "tls", "/up", true))
+	require.NoError(t, st.SetHostMode("secure.example.com", HostModePassthrough))
+	require.NoError(t, st.DeployHost("shop.example.com", "shop:3000", "shop", "web", "/up", true))
+
+	// Hosts get their own certificates until their project is grouped
+	assert.Nil(t, st.CertGroup("www.example.com"))
+
+	st.SetCertGroups([]string{"blog", "shop"})
+	assert.Equal(t, []string{"blog", "shop"}, st.GetCertGroups())
+	assert.Equal(t, []string{"api.example.com", "www.example.com"}, st.CertGroup("www.example.com"))
+	assert.Equal(t, []string{"api.example.com", "www.example.com"}, st.CertGroup("api.example.com"))
+	// Without TLS termination, or alone in the project, a host isn't grouped
+	assert.Nil(t, st.CertGroup("plain.example.com"))
+	assert.Nil(t, st.CertGroup("secure.example.com"))
+	assert.Nil(t, st.CertGroup("shop.example.com"))
+
+	// Removals regroup
+	require.NoError(t, st.RemoveHost("api.example.com"))
+	assert.Nil(t, st.CertGroup("www.example.com"))
+
+	// Groups stop at the CA's limit of names
+	for i := 0; i < CertGroupMaxNames; i++ {
+		require.NoError(t, st.DeployHost(fmt.Sprintf("s%03d.example.com", i), "shop:3000", "shop", "web", "/up", true))
+	}
+	assert.Len(t, st.CertGroup("s000.example.com"), CertGroupMaxNames)
+	assert.Nil(t, st.CertGroup("shop.example.com"))
+
+	st.SetCertGroups(nil)
+	assert.Empty(t, st.GetCertGroups())
+	assert.Nil(t, st.CertGroup("s000.example.com"))
+}
+
+func TestHostApps(t *testing.T) {
+	st := NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "web", "/health", false))
+	require.NoError(t, st.DeployHost("www.blog.example.com", "blog-web:3000", "blog", "", "/health", false))
+	require.NoError(t, st.DeployHost("legacy.blog.example.com", "10.0.0.5:8080", "blog", "", "/health", false))
+
+	assert.Equal(t, map[string]AppRef{
+		"blog.example.com":     {Project: "blog", App: "web"},
+		"www.blog.example.com": {Project: "blog", App: "web"},
+	}, st.HostApps())
+}
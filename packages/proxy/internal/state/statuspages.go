@@ -0,0 +1,306 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// StatusPageTarget is where the proxy serves status pages itself. A status
+// page's host routes there like any other host, so it gets a certificate,
+// health checks and uptime monitoring the same way.
+const StatusPageTarget = "127.0.0.1:8081"
+
+// StatusPageApp is the app name of status page hosts
+const StatusPageApp = "status-page"
+
+// Incident statuses, in the order an incident usually goes through them
+const (
+	IncidentInvestigating = "investigating"
+	IncidentIdentified    = "identified"
+	IncidentMonitoring    = "monitoring"
+	IncidentResolved      = "resolved"
+)
+
+// incidentRetention is how long resolved incidents are kept, as long as the
+// uptime history shown next to them
+const incidentRetention = 90 * 24 * time.Hour
+
+// ValidIncidentStatus reports whether status is one of the incident statuses
+func ValidIncidentStatus(status string) bool {
+	switch status {
+	case IncidentInvestigating, IncidentIdentified, IncidentMonitoring, IncidentResolved:
+		return true
+	}
+	return false
+}
+
+// StatusPage is a public page showing how a project's apps are doing, served
+// by the proxy at Host
+type StatusPage struct {
+	Project   string      `json:"project"`
+	Host      string      `json:"host"`
+	Title     string      `json:"title,omitempty"`     // Defaults to the project name
+	Apps      []string    `json:"apps,omitempty"`      // Apps shown, every app with a host when empty
+	SSL       bool        `json:"ssl"`                 // Serve the page over HTTPS with a certificate for Host
+	Incidents []*Incident `json:"incidents,omitempty"` // Newest first
+}
+
+// Incident is an annotation on a status page telling visitors about an
+// outage and how it is being handled
+type Incident struct {
+	ID         string           `json:"id"`
+	Title      string           `json:"title"`
+	Status     string           `json:"status"`         // Of the latest update
+	Apps       []string         `json:"apps,omitempty"` // Affected apps, shown as degraded until it is resolved
+	Updates    []IncidentUpdate `json:"updates"`        // Oldest first
+	CreatedAt  time.Time        `json:"created_at"`
+	ResolvedAt *time.Time       `json:"resolved_at,omitempty"`
+}
+
+// IncidentUpdate is a message posted on an incident
+type IncidentUpdate struct {
+	Time    time.Time `json:"time"`
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Affects reports whether an open incident affects an app. Incidents that
+// name no apps affect them all.
+func (i *Incident) Affects(app string) bool {
+	if i.Status == IncidentResolved {
+		return false
+	}
+	if len(i.Apps) == 0 {
+		return true
+	}
+	for _, affected := range i.Apps {
+		if affected == app {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Incident) copy() *Incident {
+	incidentCopy := *i
+	incidentCopy.Apps = append([]string(nil), i.Apps...)
+	incidentCopy.Updates = append([]IncidentUpdate(nil), i.Updates...)
+	return &incidentCopy
+}
+
+func (p *StatusPage) copy() *StatusPage {
+	pageCopy := *p
+	pageCopy.Apps = append([]string(nil), p.Apps...)
+	pageCopy.Incidents = make([]*Incident, len(p.Incidents))
+	for i, incident := range p.Incidents {
+		pageCopy.Incidents[i] = incident.copy()
+	}
+	return &pageCopy
+}
+
+// SetStatusPage adds or replaces the status page of a project, keeping the
+// incidents of the page it replaces. The page's host is deployed separately.
+func (s *State) SetStatusPage(page *StatusPage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for project, other := range s.StatusPages {
+		if project != page.Project && other.Host == page.Host {
+			return fmt.Errorf("%s is already the status page of project %s", page.Host, project)
+		}
+	}
+
+	pageCopy := page.copy()
+	pageCopy.Incidents = nil
+	if existing, exists := s.StatusPages[page.Project]; exists {
+		pageCopy.Incidents = existing.Incidents
+	}
+
+	if s.StatusPages == nil {
+		s.StatusPages = make(map[string]*StatusPage)
+	}
+	s.StatusPages[page.Project] = pageCopy
+	s.markModified()
+
+	return nil
+}
+
+// RemoveStatusPage removes the status page of a project with its incidents
+// and returns it, so the caller can remove its host
+func (s *State) RemoveStatusPage(project string) (*StatusPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, exists := s.StatusPages[project]
+	if !exists {
+		return nil, fmt.Errorf("no status page for project %s", project)
+	}
+	delete(s.StatusPages, project)
+	if len(s.StatusPages) == 0 {
+		s.StatusPages = nil
+	}
+	s.markModified()
+
+	return page, nil
+}
+
+// GetStatusPage returns a copy of a project's status page, or nil if it has none
+func (s *State) GetStatusPage(project string) *StatusPage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, exists := s.StatusPages[project]
+	if !exists {
+		return nil
+	}
+	return page.copy()
+}
+
+// GetStatusPages returns copies of all status pages, sorted by project
+func (s *State) GetStatusPages() []StatusPage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := make([]StatusPage, 0, len(s.StatusPages))
+	for _, page := range s.StatusPages {
+		pages = append(pages, *page.copy())
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Project < pages[j].Project })
+	return pages
+}
+
+// StatusPageForHost returns a copy of the status page served at hostname, or
+// nil if there is none
+func (s *State) StatusPageForHost(hostname string) *StatusPage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, page := range s.StatusPages {
+		if page.Host == hostname {
+			return page.copy()
+		}
+	}
+	return nil
+}
+
+// OpenIncident adds an incident to a project's status page. The incident
+// gets an ID and its first update from its status and message. Resolved
+// incidents past their retention are dropped.
+func (s *State) OpenIncident(project, title, status, message string, apps []string) (*Incident, error) {
+	if !ValidIncidentStatus(status) {
+		return nil, fmt.Errorf("unknown incident status %q", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, exists := s.StatusPages[project]
+	if !exists {
+		return nil, fmt.Errorf("no status page for project %s", project)
+	}
+
+	now := time.Now()
+	incident := &Incident{
+		ID:        newIncidentID(),
+		Title:     title,
+		Apps:      append([]string(nil), apps...),
+		CreatedAt: now,
+	}
+	incident.update(status, message, now)
+
+	incidents := []*Incident{incident}
+	for _, existing := range page.Incidents {
+		if existing.ResolvedAt == nil || now.Sub(*existing.ResolvedAt) < incidentRetention {
+			incidents = append(incidents, existing)
+		}
+	}
+	page.Incidents = incidents
+	s.markModified()
+
+	return incident.copy(), nil
+}
+
+// UpdateIncident posts an update on an incident, resolving it when status
+// is IncidentResolved
+func (s *State) UpdateIncident(id, status, message string) (*Incident, error) {
+	if !ValidIncidentStatus(status) {
+		return nil, fmt.Errorf("unknown incident status %q", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incident, _ := s.findIncident(id)
+	if incident == nil {
+		return nil, fmt.Errorf("incident %s not found", id)
+	}
+	incident.update(status, message, time.Now())
+	s.markModified()
+
+	return incident.copy(), nil
+}
+
+// RemoveIncident deletes an incident, e.g. one opened by mistake
+func (s *State) RemoveIncident(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, page := s.findIncident(id)
+	if page == nil {
+		return fmt.Errorf("incident %s not found", id)
+	}
+	for i, incident := range page.Incidents {
+		if incident.ID == id {
+			page.Incidents = append(page.Incidents[:i], page.Incidents[i+1:]...)
+			break
+		}
+	}
+	s.markModified()
+
+	return nil
+}
+
+// GetIncident returns a copy of an incident and the project it belongs to
+func (s *State) GetIncident(id string) (*Incident, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	incident, page := s.findIncident(id)
+	if incident == nil {
+		return nil, "", fmt.Errorf("incident %s not found", id)
+	}
+	return incident.copy(), page.Project, nil
+}
+
+// findIncident returns an incident and the status page it is on. The caller
+// must hold s.mu.
+func (s *State) findIncident(id string) (*Incident, *StatusPage) {
+	for _, page := range s.StatusPages {
+		for _, incident := range page.Incidents {
+			if incident.ID == id {
+				return incident, page
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (i *Incident) update(status, message string, now time.Time) {
+	i.Status = status
+	i.Updates = append(i.Updates, IncidentUpdate{Time: now, Status: status, Message: message})
+	if status == IncidentResolved {
+		i.ResolvedAt = &now
+	} else {
+		i.ResolvedAt = nil
+	}
+}
+
+// newIncidentID returns a random incident ID
+func newIncidentID() string {
+	bytes := make([]byte, 4)
+	rand.Read(bytes)
+	return "inc_" + hex.EncodeToString(bytes)
+}
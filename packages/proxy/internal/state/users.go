@@ -0,0 +1,139 @@
+package state
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// API roles, from least to most privileged
+const (
+	RoleReadOnly = "read-only" // Read state, certificates, stats and the audit log
+	RoleDeployer = "deployer"  // Also deploy, switch and tune hosts
+	RoleAdmin    = "admin"     // Everything, including removing hosts and managing users
+)
+
+var roleRanks = map[string]int{RoleReadOnly: 1, RoleDeployer: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is one of the API roles
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAllows reports whether role grants at least the required role
+func RoleAllows(role, required string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
+
+// User is a holder of an API token. Only the token's SHA-256 is stored.
+type User struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Projects limits the token to these projects' hosts, certificates and
+	// deployments. Empty for every project and the server-wide settings.
+	Projects  []string  `json:"projects,omitempty"`
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Allows reports whether the user may access a project
+func (u *User) Allows(project string) bool {
+	if len(u.Projects) == 0 {
+		return true
+	}
+	for _, allowed := range u.Projects {
+		if allowed == project {
+			return true
+		}
+	}
+	return false
+}
+
+// HashToken returns the stored form of an API token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddUser adds an API user whose token hashes to tokenHash, limited to
+// projects unless that is empty
+func (s *State) AddUser(name, role string, projects []string, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q, expected %s, %s or %s", role, RoleReadOnly, RoleDeployer, RoleAdmin)
+	}
+	for _, project := range projects {
+		if project == "" {
+			return fmt.Errorf("invalid empty project name")
+		}
+	}
+	if _, exists := s.Users[name]; exists {
+		return fmt.Errorf("user %s already exists", name)
+	}
+
+	if s.Users == nil {
+		s.Users = make(map[string]*User)
+	}
+	s.Users[name] = &User{Name: name, Role: role, Projects: append([]string(nil), projects...), TokenHash: tokenHash, CreatedAt: time.Now()}
+	s.markModified()
+
+	return nil
+}
+
+// RemoveUser deletes an API user, revoking their token
+func (s *State) RemoveUser(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.Users[name]; !exists {
+		return fmt.Errorf("user %s not found", name)
+	}
+	delete(s.Users, name)
+	s.markModified()
+
+	return nil
+}
+
+// GetUsers returns copies of all API users without their token hashes, sorted by name
+func (s *State) GetUsers() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.Users))
+	for _, user := range s.Users {
+		userCopy := *user
+		userCopy.TokenHash = ""
+		users = append(users, userCopy)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// HasUsers reports whether any API user exists. Until one does, the API
+// doesn't ask for tokens.
+func (s *State) HasUsers() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.Users) > 0
+}
+
+// Authenticate returns a copy of the user holding token
+func (s *State) Authenticate(token string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash := []byte(HashToken(token))
+	for _, user := range s.Users {
+		if subtle.ConstantTimeCompare(hash, []byte(user.TokenHash)) == 1 {
+			userCopy := *user
+			return &userCopy, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid token")
+}
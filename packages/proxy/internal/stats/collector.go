@@ -0,0 +1,261 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+)
+
+const (
+	// sampleInterval is how often usage is sampled
+	sampleInterval = 15 * time.Second
+	// memoryWarnPercent raises a capacity warning for a container using this
+	// much of its memory limit, memoryClearPercent ends it
+	memoryWarnPercent  = 90
+	memoryClearPercent = 80
+)
+
+// Docker is the part of the Docker Engine API the collector uses
+type Docker interface {
+	Containers(ctx context.Context, label string) ([]docker.Container, error)
+	Stats(ctx context.Context, id string) (*docker.Stats, error)
+}
+
+// Sample is the resource usage of an app container. Network and disk values
+// are totals since the container started.
+type Sample struct {
+	Container        string    `json:"container"`
+	Project          string    `json:"project"`
+	App              string    `json:"app"`
+	Time             time.Time `json:"time"`
+	CPUPercent       float64   `json:"cpu_percent"` // 100 is one full core
+	MemoryBytes      uint64    `json:"memory_bytes"`
+	MemoryLimitBytes uint64    `json:"memory_limit_bytes"`
+	NetworkRxBytes   uint64    `json:"network_rx_bytes"`
+	NetworkTxBytes   uint64    `json:"network_tx_bytes"`
+	DiskReadBytes    uint64    `json:"disk_read_bytes"`
+	DiskWriteBytes   uint64    `json:"disk_write_bytes"`
+	PIDs             uint64    `json:"pids"`
+}
+
+// MemoryPercent is the share of its memory limit the container uses
+func (s Sample) MemoryPercent() float64 {
+	if s.MemoryLimitBytes == 0 {
+		return 0
+	}
+	return float64(s.MemoryBytes) / float64(s.MemoryLimitBytes) * 100
+}
+
+// Collector periodically samples the resource usage of the containers iop manages
+type Collector struct {
+	docker Docker
+	events core.EventBus
+
+	mu      sync.RWMutex
+	samples map[string]Sample // Keyed by container ID
+	warned  map[string]bool   // Containers with an active memory warning
+}
+
+// NewCollector creates a stats collector
+func NewCollector(d Docker) *Collector {
+	return &Collector{
+		docker:  d,
+		samples: make(map[string]Sample),
+		warned:  make(map[string]bool),
+	}
+}
+
+// SetEventBus makes the collector publish capacity warnings
+func (c *Collector) SetEventBus(events core.EventBus) {
+	c.events = events
+}
+
+// Run samples usage until the context is cancelled
+func (c *Collector) Run(ctx context.Context) {
+	log.Println("[STATS] Starting stats collector")
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	lastErr := ""
+	for {
+		// Only log a failure once, e.g. when the Docker socket isn't mounted
+		if err := c.Collect(ctx); err != nil && err.Error() != lastErr && ctx.Err() == nil {
+			log.Printf("[STATS] Failed to collect container stats: %v", err)
+			lastErr = err.Error()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("[STATS] Stopping stats collector")
+			return
+		}
+	}
+}
+
+// Collect samples every running app container once
+func (c *Collector) Collect(ctx context.Context) error {
+	containers, err := c.docker.Containers(ctx, "iop.managed=true")
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	samples := make(map[string]Sample, len(containers))
+
+	var wg sync.WaitGroup
+	for _, container := range containers {
+		if container.Labels["iop.type"] != "service" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(container docker.Container) {
+			defer wg.Done()
+
+			raw, err := c.docker.Stats(ctx, container.ID)
+			if err != nil {
+				log.Printf("[STATS] [%s] Failed to sample: %v", container.Name, err)
+				return
+			}
+
+			sample := newSample(container, raw, time.Now())
+			mu.Lock()
+			samples[container.ID] = sample
+			mu.Unlock()
+		}(container)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	c.samples = samples
+	for id := range c.warned {
+		if _, ok := samples[id]; !ok {
+			delete(c.warned, id)
+		}
+	}
+	warnings := c.checkCapacity(samples)
+	c.mu.Unlock()
+
+	if c.events != nil {
+		for _, warning := range warnings {
+			c.events.Publish(warning)
+		}
+	}
+	return nil
+}
+
+// checkCapacity returns a warning for each container that crossed the memory
+// threshold since the last sample. Callers hold c.mu.
+func (c *Collector) checkCapacity(samples map[string]Sample) []core.CapacityWarning {
+	var warnings []core.CapacityWarning
+	for id, sample := range samples {
+		percent := sample.MemoryPercent()
+		switch {
+		case percent >= memoryWarnPercent && !c.warned[id]:
+			c.warned[id] = true
+			warnings = append(warnings, core.CapacityWarning{
+				BaseEvent: core.BaseEvent{Timestamp: sample.Time},
+				Container: sample.Container,
+				Resource:  "memory",
+				Percent:   percent,
+			})
+		case percent < memoryClearPercent:
+			delete(c.warned, id)
+		}
+	}
+	return warnings
+}
+
+// Samples returns the latest sample of every app container, ordered by project, app and container
+func (c *Collector) Samples() []Sample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	samples := make([]Sample, 0, len(c.samples))
+	for _, sample := range c.samples {
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		if a.App != b.App {
+			return a.App < b.App
+		}
+		return a.Container < b.Container
+	})
+	return samples
+}
+
+// newSample converts Docker's raw stats to a sample
+func newSample(container docker.Container, raw *docker.Stats, now time.Time) Sample {
+	app := container.Labels["iop.app"]
+	if app == "" {
+		app = container.Labels["iop.service"]
+	}
+
+	sample := Sample{
+		Container:        container.Name,
+		Project:          container.Labels["iop.project"],
+		App:              app,
+		Time:             now,
+		CPUPercent:       cpuPercent(raw),
+		MemoryBytes:      memoryUsage(raw),
+		MemoryLimitBytes: raw.MemoryStats.Limit,
+		PIDs:             raw.PidsStats.Current,
+	}
+
+	for _, network := range raw.Networks {
+		sample.NetworkRxBytes += network.RxBytes
+		sample.NetworkTxBytes += network.TxBytes
+	}
+	for _, entry := range raw.BlkioStats.IOServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			sample.DiskReadBytes += entry.Value
+		case "write":
+			sample.DiskWriteBytes += entry.Value
+		}
+	}
+
+	return sample
+}
+
+// cpuPercent computes CPU usage between the sample's two readings the way
+// docker stats does
+func cpuPercent(raw *docker.Stats) float64 {
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	cpus := float64(raw.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = 1
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// memoryUsage excludes the page cache from memory usage like docker stats,
+// using the cgroup v2 or v1 field available
+func memoryUsage(raw *docker.Stats) uint64 {
+	usage := raw.MemoryStats.Usage
+	cache, ok := raw.MemoryStats.Stats["inactive_file"]
+	if !ok {
+		cache = raw.MemoryStats.Stats["total_inactive_file"]
+	}
+	if cache < usage {
+		return usage - cache
+	}
+	return usage
+}
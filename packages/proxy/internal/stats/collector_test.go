@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDocker struct {
+	containers []docker.Container
+	stats      map[string]*docker.Stats
+}
+
+func (f *fakeDocker) Containers(ctx context.Context, label string) ([]docker.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeDocker) Stats(ctx context.Context, id string) (*docker.Stats, error) {
+	return f.stats[id], nil
+}
+
+func rawStats(memory, limit uint64) *docker.Stats {
+	raw := &docker.Stats{}
+	raw.CPUStats.CPUUsage.TotalUsage = 300
+	raw.CPUStats.SystemUsage = 2000
+	raw.CPUStats.OnlineCPUs = 4
+	raw.PreCPUStats.CPUUsage.TotalUsage = 200
+	raw.PreCPUStats.SystemUsage = 1000
+	raw.MemoryStats.Usage = memory + 1024
+	raw.MemoryStats.Limit = limit
+	raw.MemoryStats.Stats = map[string]uint64{"inactive_file": 1024}
+	raw.PidsStats.Current = 12
+	return raw
+}
+
+var testTime = time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+
+func TestNewSample(t *testing.T) {
+	raw := rawStats(100<<20, 512<<20)
+	raw.Networks = map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	}{"eth0": {RxBytes: 100, TxBytes: 50}, "eth1": {RxBytes: 1, TxBytes: 2}}
+	raw.BlkioStats.IOServiceBytesRecursive = []struct {
+		Op    string `json:"op"`
+		Value uint64 `json:"value"`
+	}{{Op: "Read", Value: 10}, {Op: "write", Value: 20}, {Op: "Total", Value: 30}}
+
+	sample := newSample(docker.Container{
+		Name:   "blog-web-blue-1",
+		Labels: map[string]string{"iop.project": "blog", "iop.app": "web"},
+	}, raw, testTime)
+
+	assert.Equal(t, "blog", sample.Project)
+	assert.Equal(t, "web", sample.App)
+	assert.InDelta(t, 40.0, sample.CPUPercent, 0.001) // 10% of all time on 4 cores
+	assert.Equal(t, uint64(100<<20), sample.MemoryBytes)
+	assert.InDelta(t, 19.53, sample.MemoryPercent(), 0.01)
+	assert.Equal(t, uint64(101), sample.NetworkRxBytes)
+	assert.Equal(t, uint64(52), sample.NetworkTxBytes)
+	assert.Equal(t, uint64(10), sample.DiskReadBytes)
+	assert.Equal(t, uint64(20), sample.DiskWriteBytes)
+	assert.Equal(t, uint64(12), sample.PIDs)
+}
+
+func TestCollectWarnsOnMemoryPressure(t *testing.T) {
+	labels := map[string]string{"iop.managed": "true", "iop.type": "service", "iop.project": "blog", "iop.app": "web"}
+	fake := &fakeDocker{
+		containers: []docker.Container{
+			{ID: "c1", Name: "blog-web-blue-1", Labels: labels},
+			{ID: "c2", Name: "iop-proxy", Labels: map[string]string{"iop.managed": "true", "iop.type": "proxy"}},
+		},
+		stats: map[string]*docker.Stats{"c1": rawStats(95, 100)},
+	}
+
+	bus := events.NewSimpleBus()
+	ch := bus.Subscribe()
+	collector := NewCollector(fake)
+	collector.SetEventBus(bus)
+
+	require.NoError(t, collector.Collect(context.Background()))
+	samples := collector.Samples()
+	require.Len(t, samples, 1)
+	assert.Equal(t, "blog-web-blue-1", samples[0].Container)
+
+	warning := (<-ch).(core.CapacityWarning)
+	assert.Equal(t, "memory", warning.Resource)
+	assert.Equal(t, "blog-web-blue-1", warning.Container)
+	assert.InDelta(t, 95.0, warning.Percent, 0.001)
+
+	// Still above the threshold, no repeated warning
+	require.NoError(t, collector.Collect(context.Background()))
+	assert.Len(t, ch, 0)
+
+	// Dropping below the clear threshold re-arms the warning
+	fake.stats["c1"] = rawStats(50, 100)
+	require.NoError(t, collector.Collect(context.Background()))
+	fake.stats["c1"] = rawStats(92, 100)
+	require.NoError(t, collector.Collect(context.Background()))
+	assert.Len(t, ch, 1)
+}
+
+func TestWritePrometheus(t *testing.T) {
+	var out strings.Builder
+	require.NoError(t, WritePrometheus(&out, []Sample{{
+		Container:   `blog-"web"`,
+		Project:     "blog",
+		App:         "web",
+		CPUPercent:  12.5,
+		MemoryBytes: 1024,
+	}}))
+
+	text := out.String()
+	assert.Contains(t, text, "# TYPE iop_container_cpu_percent gauge\n")
+	assert.Contains(t, text, `iop_container_cpu_percent{container="blog-\"web\"",project="blog",app="web"} 12.5`)
+	assert.Contains(t, text, `iop_container_memory_bytes{container="blog-\"web\"",project="blog",app="web"} 1024`)
+	assert.Contains(t, text, "# TYPE iop_container_network_receive_bytes_total counter\n")
+}
@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// metric describes one Prometheus metric derived from a sample
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(Sample) float64
+}
+
+var metrics = []metric{
+	{"iop_container_cpu_percent", "CPU usage of the container, 100 is one full core.", "gauge",
+		func(s Sample) float64 { return s.CPUPercent }},
+	{"iop_container_memory_bytes", "Memory used by the container, excluding page cache.", "gauge",
+		func(s Sample) float64 { return float64(s.MemoryBytes) }},
+	{"iop_container_memory_limit_bytes", "Memory limit of the container.", "gauge",
+		func(s Sample) float64 { return float64(s.MemoryLimitBytes) }},
+	{"iop_container_network_receive_bytes_total", "Bytes received by the container.", "counter",
+		func(s Sample) float64 { return float64(s.NetworkRxBytes) }},
+	{"iop_container_network_transmit_bytes_total", "Bytes sent by the container.", "counter",
+		func(s Sample) float64 { return float64(s.NetworkTxBytes) }},
+	{"iop_container_disk_read_bytes_total", "Bytes read from disk by the container.", "counter",
+		func(s Sample) float64 { return float64(s.DiskReadBytes) }},
+	{"iop_container_disk_write_bytes_total", "Bytes written to disk by the container.", "counter",
+		func(s Sample) float64 { return float64(s.DiskWriteBytes) }},
+	{"iop_container_pids", "Processes and threads running in the container.", "gauge",
+		func(s Sample) float64 { return float64(s.PIDs) }},
+}
+
+// WritePrometheus writes samples in the Prometheus text exposition format
+func WritePrometheus(w io.Writer, samples []Sample) error {
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, s := range samples {
+			if _, err := fmt.Fprintf(w, "%s{container=%s,project=%s,app=%s} %g\n",
+				m.name, quote(s.Container), quote(s.Project), quote(s.App), m.value(s)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// quote escapes a label value
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
@@ -0,0 +1,232 @@
+package statuspage
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/internal/uptime"
+)
+
+// Days of uptime shown per app, as many as the uptime monitor keeps
+const historyDays = 90
+
+// pastIncidentDays is how long resolved incidents stay on the page
+const pastIncidentDays = 14
+
+// App and page statuses, from best to worst
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded" // Some hosts failing, or an open incident
+	StatusOutage      = "outage"   // Every host failing
+	StatusStopped     = "stopped"  // Stopped on purpose
+)
+
+var statusRanks = map[string]int{StatusStopped: 0, StatusOperational: 1, StatusDegraded: 2, StatusOutage: 3}
+
+// Summary is what a status page shows, also served as JSON at /status.json
+type Summary struct {
+	Title     string           `json:"title"`
+	Status    string           `json:"status"` // The worst status of the apps
+	Apps      []AppStatus      `json:"apps"`
+	Incidents []state.Incident `json:"incidents"` // Open ones and those resolved recently, newest first
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// AppStatus is how an app is doing now and its uptime over the last days
+type AppStatus struct {
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	Uptime90d *float64 `json:"uptime_90d"` // Nil until the app's hosts have been checked
+	Days      []Day    `json:"days"`       // Oldest first
+}
+
+// Day is an app's uptime on one day, nil for days without checks
+type Day struct {
+	Date   string   `json:"date"`
+	Uptime *float64 `json:"uptime"`
+}
+
+// Server serves the status pages of all projects, picking the page by the
+// request's host. The router sends status page hosts to it at
+// state.StatusPageTarget.
+type Server struct {
+	state  *state.State
+	uptime *uptime.Monitor // Optional, pages only show health without it
+}
+
+// New creates a status page server
+func New(st *state.State, monitor *uptime.Monitor) *Server {
+	return &Server{state: st, uptime: monitor}
+}
+
+// ServeHTTP serves the page of the request's host at / and /status.json, and
+// answers health checks at /healthz
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		w.Write([]byte("OK"))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hostname := r.Host
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	page := s.state.StatusPageForHost(hostname)
+	if page == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	summary := s.Summary(page, time.Now())
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := pageTemplate.Execute(w, summary); err != nil {
+			log.Printf("[STATUS-PAGE] [%s] Failed to render page: %v", hostname, err)
+		}
+	case "/status.json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Summary describes a status page as of now. An app's status comes from the
+// health checker and the uptime monitor: failing hosts are unhealthy or down
+// when last checked from outside. Open incidents naming an app mark it
+// degraded even while its hosts pass.
+func (s *Server) Summary(page *state.StatusPage, now time.Time) Summary {
+	summary := Summary{
+		Title:     page.Title,
+		Status:    StatusOperational,
+		Apps:      []AppStatus{},
+		Incidents: []state.Incident{},
+		UpdatedAt: now,
+	}
+	if summary.Title == "" {
+		summary.Title = page.Project
+	}
+
+	for _, incident := range page.Incidents {
+		if incident.ResolvedAt == nil || now.Sub(*incident.ResolvedAt) < pastIncidentDays*24*time.Hour {
+			summary.Incidents = append(summary.Incidents, *incident)
+		}
+	}
+
+	hosts := s.state.GetAllHosts()
+	for _, app := range s.apps(page) {
+		status := s.appStatus(page, app, hosts, now)
+		if statusRanks[status.Status] > statusRanks[summary.Status] {
+			summary.Status = status.Status
+		}
+		summary.Apps = append(summary.Apps, status)
+	}
+	return summary
+}
+
+// apps returns the apps a page shows: the ones it names, or every app of
+// the project with a host
+func (s *Server) apps(page *state.StatusPage) []string {
+	if len(page.Apps) > 0 {
+		return page.Apps
+	}
+
+	seen := make(map[string]bool)
+	var apps []string
+	for _, ref := range s.state.HostApps() {
+		if ref.Project == page.Project && ref.App != state.StatusPageApp && !seen[ref.App] {
+			seen[ref.App] = true
+			apps = append(apps, ref.App)
+		}
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+func (s *Server) appStatus(page *state.StatusPage, app string, hosts map[string]*state.Host, now time.Time) AppStatus {
+	status := AppStatus{Name: app, Status: StatusOperational}
+
+	// Totals of the app's hosts by day
+	type total struct{ checks, up int }
+	days := make(map[string]*total)
+
+	var checked, failing, stopped int
+	hostnames := s.state.AppHosts(page.Project, app)
+	for _, hostname := range hostnames {
+		host, ok := hosts[hostname]
+		if !ok {
+			continue
+		}
+		if host.Stopped {
+			stopped++
+			continue
+		}
+
+		var report uptime.Report
+		var reported bool
+		if s.uptime != nil {
+			report, reported = s.uptime.HostReport(hostname)
+		}
+		// Sleeping hosts are unhealthy until a request wakes them
+		if (!host.Healthy && !host.Sleeping) || (reported && report.LastCheck != nil && !report.Up) {
+			failing++
+		}
+		checked++
+
+		for _, bucket := range report.Daily {
+			date := bucket.Start.Format("2006-01-02")
+			if days[date] == nil {
+				days[date] = &total{}
+			}
+			days[date].checks += bucket.Checks
+			days[date].up += bucket.Up
+		}
+	}
+
+	switch {
+	case checked == 0 && stopped > 0:
+		status.Status = StatusStopped
+	case checked > 0 && failing == checked:
+		status.Status = StatusOutage
+	case failing > 0:
+		status.Status = StatusDegraded
+	}
+	if status.Status == StatusOperational {
+		for _, incident := range page.Incidents {
+			if incident.Affects(app) {
+				status.Status = StatusDegraded
+				break
+			}
+		}
+	}
+
+	var checks, up int
+	today := now.UTC().Truncate(24 * time.Hour)
+	for i := historyDays - 1; i >= 0; i-- {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		day := Day{Date: date}
+		if t := days[date]; t != nil && t.checks > 0 {
+			percent := float64(t.up) * 100 / float64(t.checks)
+			day.Uptime = &percent
+			checks += t.checks
+			up += t.up
+		}
+		status.Days = append(status.Days, day)
+	}
+	if checks > 0 {
+		percent := float64(up) * 100 / float64(checks)
+		status.Uptime90d = &percent
+	}
+	return status
+}
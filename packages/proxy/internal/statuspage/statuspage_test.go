@@ -0,0 +1,105 @@
+package statuspage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestState(t *testing.T) *state.State {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("shop.com", "shop-web:3000", "shop", "web", "/up", true))
+	require.NoError(t, st.DeployHost("www.shop.com", "shop-web:3000", "shop", "web", "/up", true))
+	require.NoError(t, st.DeployHost("api.shop.com", "shop-api:3000", "shop", "api", "/up", true))
+	require.NoError(t, st.DeployHost("blog.com", "blog-web:3000", "blog", "web", "/up", true))
+	for _, hostname := range []string{"shop.com", "www.shop.com", "api.shop.com"} {
+		require.NoError(t, st.UpdateHealthStatus(hostname, true))
+	}
+	require.NoError(t, st.SetStatusPage(&state.StatusPage{Project: "shop", Host: "status.shop.com"}))
+	_, _, err := st.PutHost("status.shop.com", "shop", &state.HostSpec{Target: state.StatusPageTarget, App: state.StatusPageApp}, state.Precondition{})
+	require.NoError(t, err)
+	return st
+}
+
+func TestSummary(t *testing.T) {
+	st := newTestState(t)
+	s := New(st, nil)
+	now := time.Now()
+
+	summary := s.Summary(st.GetStatusPage("shop"), now)
+	assert.Equal(t, "shop", summary.Title)
+	assert.Equal(t, StatusOperational, summary.Status)
+	require.Len(t, summary.Apps, 2, "the status page itself isn't listed")
+	assert.Equal(t, "api", summary.Apps[0].Name)
+	assert.Len(t, summary.Apps[0].Days, historyDays)
+	assert.Nil(t, summary.Apps[0].Uptime90d, "no checks yet")
+
+	// One of web's hosts failing degrades it, all of api's is an outage
+	require.NoError(t, st.UpdateHealthStatus("www.shop.com", false))
+	require.NoError(t, st.UpdateHealthStatus("api.shop.com", false))
+	summary = s.Summary(st.GetStatusPage("shop"), now)
+	assert.Equal(t, StatusOutage, summary.Apps[0].Status)
+	assert.Equal(t, StatusDegraded, summary.Apps[1].Status)
+	assert.Equal(t, StatusOutage, summary.Status)
+
+	// Open incidents degrade the apps they name
+	require.NoError(t, st.UpdateHealthStatus("www.shop.com", true))
+	require.NoError(t, st.UpdateHealthStatus("api.shop.com", true))
+	incident, err := st.OpenIncident("shop", "Checkout is slow", state.IncidentIdentified, "", []string{"web"})
+	require.NoError(t, err)
+	summary = s.Summary(st.GetStatusPage("shop"), now)
+	assert.Equal(t, StatusOperational, summary.Apps[0].Status)
+	assert.Equal(t, StatusDegraded, summary.Apps[1].Status)
+	require.Len(t, summary.Incidents, 1)
+
+	// Resolved incidents are shown for a while without degrading anything
+	_, err = st.UpdateIncident(incident.ID, state.IncidentResolved, "Fixed")
+	require.NoError(t, err)
+	summary = s.Summary(st.GetStatusPage("shop"), now)
+	assert.Equal(t, StatusOperational, summary.Status)
+	assert.Len(t, summary.Incidents, 1)
+	summary = s.Summary(st.GetStatusPage("shop"), now.Add(pastIncidentDays*24*time.Hour+time.Minute))
+	assert.Empty(t, summary.Incidents)
+
+	// Stopped apps aren't an outage
+	st.SetStopped("shop", "api", true)
+	summary = s.Summary(st.GetStatusPage("shop"), now)
+	assert.Equal(t, StatusStopped, summary.Apps[0].Status)
+	assert.Equal(t, StatusOperational, summary.Status)
+}
+
+func TestServeHTTP(t *testing.T) {
+	st := newTestState(t)
+	require.NoError(t, st.SetStatusPage(&state.StatusPage{Project: "shop", Host: "status.shop.com", Title: "<Shop>", Apps: []string{"web"}}))
+	s := New(st, nil)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "http://status.shop.com/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "&lt;Shop&gt;", "the title is escaped")
+	assert.Contains(t, rec.Body.String(), "All systems operational")
+
+	rec = serve(http.MethodGet, "http://status.shop.com:443/status.json")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var summary Summary
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &summary))
+	require.Len(t, summary.Apps, 1, "only the apps the page names")
+	assert.Equal(t, "web", summary.Apps[0].Name)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "http://127.0.0.1:8081/healthz").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "http://shop.com/").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "http://status.shop.com/admin").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPost, "http://status.shop.com/").Code)
+}
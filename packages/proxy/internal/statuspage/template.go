@@ -0,0 +1,95 @@
+package statuspage
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+var pageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"headline": headline,
+	"percent": func(p *float64) string {
+		if p == nil {
+			return "No data"
+		}
+		return fmt.Sprintf("%.2f%% uptime", *p)
+	},
+	"dayClass": func(p *float64) string {
+		switch {
+		case p == nil:
+			return "none"
+		case *p >= 99.5:
+			return "operational"
+		case *p >= 95:
+			return "degraded"
+		default:
+			return "outage"
+		}
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format("Jan 2, 15:04 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>{{.Title}} status</title>
+<style>
+body{font-family:system-ui,sans-serif;color:#333;max-width:720px;margin:2rem auto;padding:0 1rem}
+h1{font-size:1.5rem}
+h2{font-size:1.1rem;margin-top:2rem}
+.banner{padding:1rem;border-radius:6px;color:#fff;font-weight:600}
+.operational{background:#2e9d5b}.degraded{background:#e5a000}.outage{background:#d93f3f}.stopped,.none{background:#c8ccd0}
+.app{margin:1.5rem 0}
+.app-head{display:flex;justify-content:space-between}
+.app-status{font-size:.9rem;text-transform:capitalize}
+.days{display:flex;gap:2px;margin:.4rem 0}
+.days span{flex:1;height:28px;border-radius:2px}
+.legend{display:flex;justify-content:space-between;font-size:.8rem;color:#777}
+.incident{border-left:3px solid #c8ccd0;padding-left:1rem;margin:1rem 0}
+.incident.open{border-color:#e5a000}
+.update{font-size:.9rem;margin:.4rem 0}
+.muted{color:#777;font-size:.8rem}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.Status}}">{{headline .Status}}</div>
+{{range .Incidents}}{{if not .ResolvedAt}}
+<div class="incident open">
+<strong>{{.Title}}</strong>
+{{range .Updates}}<div class="update"><strong>{{.Status}}</strong> {{.Message}} <span class="muted">{{time .Time}}</span></div>{{end}}
+</div>
+{{end}}{{end}}
+{{range .Apps}}
+<div class="app">
+<div class="app-head"><strong>{{.Name}}</strong><span class="app-status">{{.Status}}</span></div>
+<div class="days">{{range .Days}}<span class="{{dayClass .Uptime}}" title="{{.Date}}: {{percent .Uptime}}"></span>{{end}}</div>
+<div class="legend"><span>90 days ago</span><span>{{percent .Uptime90d}}</span><span>Today</span></div>
+</div>
+{{end}}
+<h2>Past incidents</h2>
+{{$past := false}}{{range .Incidents}}{{if .ResolvedAt}}{{$past = true}}
+<div class="incident">
+<strong>{{.Title}}</strong>
+{{range .Updates}}<div class="update"><strong>{{.Status}}</strong> {{.Message}} <span class="muted">{{time .Time}}</span></div>{{end}}
+</div>
+{{end}}{{end}}{{if not $past}}<p class="muted">No incidents in the last 14 days.</p>{{end}}
+<p class="muted">Updated {{time .UpdatedAt}}</p>
+</body>
+</html>
+`))
+
+// headline describes a page's status in the banner
+func headline(status string) string {
+	switch status {
+	case StatusOutage:
+		return "Major outage"
+	case StatusDegraded:
+		return "Some systems are degraded"
+	default:
+		return "All systems operational"
+	}
+}
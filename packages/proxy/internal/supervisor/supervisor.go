@@ -0,0 +1,298 @@
+package supervisor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+const (
+	// initialBackoff is the restart delay after a first crash, doubling with
+	// every further crash within the crash window up to maxBackoff
+	initialBackoff = 2 * time.Second
+	maxBackoff     = 5 * time.Minute
+	// crashWindow is how long crashes are remembered
+	crashWindow = 10 * time.Minute
+	// crashLoopThreshold is how many crashes within the window make a crash loop
+	crashLoopThreshold = 5
+	// stopGrace treats a container exiting this soon after being killed as stopped on purpose
+	stopGrace = 30 * time.Second
+	// reconnectDelay is the wait before reconnecting to a broken event stream
+	reconnectDelay = 5 * time.Second
+)
+
+// Docker is the part of the Docker Engine API the supervisor uses
+type Docker interface {
+	Events(ctx context.Context, handle func(docker.Event)) error
+	Running(ctx context.Context, id string) (bool, error)
+	Start(ctx context.Context, id string) error
+	RestartSidecars(ctx context.Context, name string) error
+}
+
+// container tracks the crashes of one app container
+type container struct {
+	id      string
+	name    string
+	project string
+	app     string
+	crashes []time.Time
+	killed  time.Time
+	looping bool
+	// died is set between a crash and the next start
+	died bool
+}
+
+// Supervisor restarts app containers that crash, backing off exponentially,
+// and takes the hosts of crash looping containers out of rotation
+type Supervisor struct {
+	state  *state.State
+	docker Docker
+	events core.EventBus
+
+	// now and schedule are replaced in tests
+	now      func() time.Time
+	schedule func(delay time.Duration, fn func())
+
+	mu         sync.Mutex
+	containers map[string]*container
+}
+
+// New creates a supervisor for the containers iop manages
+func New(st *state.State, docker Docker, events core.EventBus) *Supervisor {
+	return &Supervisor{
+		state:  st,
+		docker: docker,
+		events: events,
+		now:    time.Now,
+		schedule: func(delay time.Duration, fn func()) {
+			time.AfterFunc(delay, fn)
+		},
+		containers: make(map[string]*container),
+	}
+}
+
+// Run watches Docker events until the context is cancelled, reconnecting when
+// the event stream breaks
+func (s *Supervisor) Run(ctx context.Context) {
+	log.Println("[SUPERVISOR] Starting container supervisor")
+
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	lastErr := ""
+	for {
+		err := s.docker.Events(ctx, s.Handle)
+		if ctx.Err() != nil {
+			log.Println("[SUPERVISOR] Stopping container supervisor")
+			return
+		}
+
+		// Only log a failure once, e.g. when the Docker socket isn't mounted
+		if err != nil && err.Error() != lastErr {
+			log.Printf("[SUPERVISOR] Docker event stream failed, retrying: %v", err)
+			lastErr = err.Error()
+		}
+
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			log.Println("[SUPERVISOR] Stopping container supervisor")
+			return
+		}
+	}
+}
+
+// Handle processes a container event. Only app containers deployed by iop are supervised.
+func (s *Supervisor) Handle(event docker.Event) {
+	if event.Labels["iop.managed"] != "true" || event.Labels["iop.type"] != "service" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.containers[event.ID]
+	if !ok {
+		app := event.Labels["iop.app"]
+		if app == "" {
+			app = event.Labels["iop.service"]
+		}
+		c = &container{id: event.ID, name: event.Name, project: event.Labels["iop.project"], app: app}
+		s.containers[event.ID] = c
+	}
+
+	switch event.Action {
+	case "kill":
+		// docker stop and docker kill send a kill before the container dies
+		c.killed = s.now()
+	case "die":
+		if s.now().Sub(c.killed) < stopGrace {
+			return
+		}
+		c.died = true
+		s.crashed(c, event.ExitCode)
+	case "start":
+		// Sidecars share the network namespace of the crashed container,
+		// which was replaced when it started again
+		if c.died {
+			c.died = false
+			name := c.name
+			s.schedule(0, func() { s.restartSidecars(name) })
+		}
+	case "destroy":
+		// A new deploy replaces the container, so its crash loop no longer applies
+		if c.looping {
+			s.state.SetCrashLooping(c.project, c.app, false)
+		}
+		delete(s.containers, event.ID)
+	}
+}
+
+// crashed records a crash, detects crash loops and schedules a restart
+func (s *Supervisor) crashed(c *container, exitCode int) {
+	now := s.now()
+	c.crashes = append(recentCrashes(c.crashes, now), now)
+
+	backoff := initialBackoff
+	for i := 1; i < len(c.crashes) && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	log.Printf("[SUPERVISOR] [%s] Exited with code %d (%d crashes in %s), restarting in %s",
+		c.name, exitCode, len(c.crashes), crashWindow, backoff)
+
+	hostnames := []string{""}
+	if len(c.crashes) >= crashLoopThreshold && !c.looping {
+		c.looping = true
+		if hosts := s.state.SetCrashLooping(c.project, c.app, true); len(hosts) > 0 {
+			hostnames = hosts
+		}
+		log.Printf("[SUPERVISOR] [%s] Crash loop detected, marking %v unhealthy", c.name, hostnames)
+		for _, hostname := range hostnames {
+			s.publish(core.CrashLoopChanged{
+				BaseEvent: core.BaseEvent{Timestamp: now, Hostname: hostname},
+				Container: c.name,
+				Looping:   true,
+			})
+		}
+	}
+
+	s.publish(core.ContainerCrashed{
+		BaseEvent: core.BaseEvent{Timestamp: now, Hostname: hostnames[0]},
+		Container: c.name,
+		ExitCode:  exitCode,
+		Crashes:   len(c.crashes),
+		Backoff:   backoff,
+	})
+
+	id := c.id
+	s.schedule(backoff, func() { s.restart(id) })
+}
+
+// restart starts a crashed container unless Docker's restart policy already did
+func (s *Supervisor) restart(id string) {
+	s.mu.Lock()
+	c, ok := s.containers[id]
+	var name string
+	if ok {
+		name = c.name
+	}
+	s.mu.Unlock()
+
+	// Removed in the meantime, e.g. replaced by a new deploy
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	running, err := s.docker.Running(ctx, id)
+	if err != nil {
+		log.Printf("[SUPERVISOR] [%s] Failed to inspect container: %v", name, err)
+		return
+	}
+	if running {
+		return
+	}
+
+	if err := s.docker.Start(ctx, id); err != nil {
+		log.Printf("[SUPERVISOR] [%s] Failed to restart container: %v", name, err)
+		return
+	}
+	log.Printf("[SUPERVISOR] [%s] Restarted container", name)
+}
+
+// restartSidecars moves the sidecars of a restarted container into its new network namespace
+func (s *Supervisor) restartSidecars(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.docker.RestartSidecars(ctx, name); err != nil {
+		log.Printf("[SUPERVISOR] [%s] Failed to restart sidecars: %v", name, err)
+	}
+}
+
+// sweep forgets old crashes and ends crash loops of containers that stopped crashing
+func (s *Supervisor) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, c := range s.containers {
+		c.crashes = recentCrashes(c.crashes, now)
+		if !c.looping || len(c.crashes) > 0 {
+			continue
+		}
+
+		c.looping = false
+		hostnames := s.state.SetCrashLooping(c.project, c.app, false)
+		log.Printf("[SUPERVISOR] [%s] No crashes for %s, crash loop over", c.name, crashWindow)
+		if len(hostnames) == 0 {
+			hostnames = []string{""}
+		}
+		for _, hostname := range hostnames {
+			s.publish(core.CrashLoopChanged{
+				BaseEvent: core.BaseEvent{Timestamp: now, Hostname: hostname},
+				Container: c.name,
+				Looping:   false,
+			})
+		}
+	}
+}
+
+func (s *Supervisor) publish(event core.Event) {
+	if s.events != nil {
+		s.events.Publish(event)
+	}
+}
+
+// recentCrashes drops crashes older than the crash window
+func recentCrashes(crashes []time.Time, now time.Time) []time.Time {
+	recent := crashes[:0]
+	for _, crash := range crashes {
+		if now.Sub(crash) < crashWindow {
+			recent = append(recent, crash)
+		}
+	}
+	return recent
+}
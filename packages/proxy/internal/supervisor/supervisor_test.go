@@ -0,0 +1,218 @@
+package supervisor
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/core"
+	"github.com/elitan/iop/proxy/internal/docker"
+	"github.com/elitan/iop/proxy/internal/events"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDocker struct {
+	mu                sync.Mutex
+	running           map[string]bool
+	started           []string
+	restartedSidecars []string
+}
+
+func (f *fakeDocker) Events(ctx context.Context, handle func(docker.Event)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeDocker) Running(ctx context.Context, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running[id], nil
+}
+
+func (f *fakeDocker) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, id)
+	f.running[id] = true
+	return nil
+}
+
+func (f *fakeDocker) RestartSidecars(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.restartedSidecars = append(f.restartedSidecars, name)
+	return nil
+}
+
+var webLabels = map[string]string{
+	"iop.managed": "true",
+	"iop.type":    "service",
+	"iop.project": "blog",
+	"iop.app":     "web",
+}
+
+type testSupervisor struct {
+	*Supervisor
+	docker  *fakeDocker
+	state   *state.State
+	events  <-chan core.Event
+	clock   time.Time
+	delays  []time.Duration
+	pending []func()
+}
+
+func newTestSupervisor(t *testing.T) *testSupervisor {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("blog.example.com", "blog-web:3000", "blog", "", "/up", true))
+	require.NoError(t, st.DeployHost("api.example.com", "blog-api:3000", "blog", "", "/up", true))
+	st.UpdateHealthStatus("blog.example.com", true)
+
+	bus := events.NewSimpleBus()
+	docker := &fakeDocker{running: map[string]bool{}}
+	ts := &testSupervisor{docker: docker, state: st, events: bus.Subscribe(), clock: time.Now()}
+	ts.Supervisor = New(st, docker, bus)
+	ts.now = func() time.Time { return ts.clock }
+	ts.schedule = func(delay time.Duration, fn func()) {
+		ts.delays = append(ts.delays, delay)
+		ts.pending = append(ts.pending, fn)
+	}
+	return ts
+}
+
+func (ts *testSupervisor) crash(id string) {
+	ts.docker.running[id] = false
+	ts.Handle(docker.Event{ID: id, Name: "blog-web-1", Action: "die", ExitCode: 1, Labels: webLabels})
+	ts.clock = ts.clock.Add(time.Second)
+}
+
+func (ts *testSupervisor) drainEvents() []core.Event {
+	var out []core.Event
+	for {
+		select {
+		case event := <-ts.events:
+			out = append(out, event)
+		default:
+			return out
+		}
+	}
+}
+
+func TestCrashedContainerIsRestartedWithBackoff(t *testing.T) {
+	ts := newTestSupervisor(t)
+
+	ts.crash("c1")
+	ts.crash("c1")
+	ts.crash("c1")
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}, ts.delays)
+
+	ts.pending[0]()
+	assert.Equal(t, []string{"c1"}, ts.docker.started)
+
+	// Already running again, e.g. through Docker's restart policy
+	ts.pending[1]()
+	assert.Equal(t, []string{"c1"}, ts.docker.started)
+
+	crashes := 0
+	for _, event := range ts.drainEvents() {
+		if crashed, ok := event.(core.ContainerCrashed); ok {
+			crashes++
+			assert.Equal(t, "blog-web-1", crashed.Container)
+			assert.Equal(t, 1, crashed.ExitCode)
+		}
+	}
+	assert.Equal(t, 3, crashes)
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	ts := newTestSupervisor(t)
+	for i := 0; i < 12; i++ {
+		ts.crash("c1")
+	}
+	assert.Equal(t, maxBackoff, ts.delays[len(ts.delays)-1])
+}
+
+func TestStoppedContainerIsNotRestarted(t *testing.T) {
+	ts := newTestSupervisor(t)
+
+	ts.Handle(docker.Event{ID: "c1", Action: "kill", Labels: webLabels})
+	ts.Handle(docker.Event{ID: "c1", Action: "die", ExitCode: 143, Labels: webLabels})
+	assert.Empty(t, ts.delays)
+
+	// Containers iop doesn't manage are left alone
+	ts.Handle(docker.Event{ID: "c2", Action: "die", ExitCode: 1, Labels: map[string]string{"name": "other"}})
+	assert.Empty(t, ts.delays)
+}
+
+func TestCrashLoopMarksHostUnhealthy(t *testing.T) {
+	ts := newTestSupervisor(t)
+
+	for i := 0; i < crashLoopThreshold; i++ {
+		ts.crash("c1")
+	}
+
+	host, _, err := ts.state.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.True(t, host.CrashLooping)
+	assert.False(t, host.Healthy)
+
+	other, _, err := ts.state.GetHost("api.example.com")
+	require.NoError(t, err)
+	assert.False(t, other.CrashLooping)
+
+	var loop *core.CrashLoopChanged
+	for _, event := range ts.drainEvents() {
+		if changed, ok := event.(core.CrashLoopChanged); ok {
+			loop = &changed
+		}
+	}
+	require.NotNil(t, loop)
+	assert.True(t, loop.Looping)
+	assert.Equal(t, "blog.example.com", loop.Hostname)
+
+	// The loop ends once the container stays up for the crash window
+	ts.clock = ts.clock.Add(crashWindow)
+	ts.sweep()
+
+	host, _, err = ts.state.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.False(t, host.CrashLooping)
+
+	events := ts.drainEvents()
+	require.Len(t, events, 1)
+	assert.False(t, events[0].(core.CrashLoopChanged).Looping)
+}
+
+func TestDestroyClearsCrashLoop(t *testing.T) {
+	ts := newTestSupervisor(t)
+
+	for i := 0; i < crashLoopThreshold; i++ {
+		ts.crash("c1")
+	}
+	ts.Handle(docker.Event{ID: "c1", Action: "destroy", Labels: webLabels})
+
+	host, _, err := ts.state.GetHost("blog.example.com")
+	require.NoError(t, err)
+	assert.False(t, host.CrashLooping)
+
+	// The scheduled restart of a removed container does nothing
+	ts.pending[0]()
+	assert.Empty(t, ts.docker.started)
+}
+
+func TestSidecarsAreRestartedWithCrashedContainer(t *testing.T) {
+	ts := newTestSupervisor(t)
+
+	// Starts that don't follow a crash leave the sidecars alone
+	ts.Handle(docker.Event{ID: "c1", Name: "blog-web-1", Action: "start", Labels: webLabels})
+	assert.Empty(t, ts.pending)
+
+	ts.crash("c1")
+	ts.Handle(docker.Event{ID: "c1", Name: "blog-web-1", Action: "start", Labels: webLabels})
+	require.Len(t, ts.pending, 2)
+	ts.pending[1]()
+	assert.Equal(t, []string{"blog-web-1"}, ts.docker.restartedSidecars)
+}
@@ -0,0 +1,241 @@
+package timeline
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxDeployments is how many timelines are kept, older ones are dropped
+const maxDeployments = 100
+
+// Steps of a deployment, in the order they run
+const (
+	StepBuild       = "build"
+	StepPush        = "push"
+	StepScan        = "scan" // Vulnerability scan of the image
+	StepStart       = "start"
+	StepHealthCheck = "health_check"
+	StepSwitch      = "switch"
+	StepCleanup     = "cleanup"
+)
+
+// Steps lists every step a timeline tracks
+var Steps = []string{StepBuild, StepPush, StepScan, StepStart, StepHealthCheck, StepSwitch, StepCleanup}
+
+// Status of a step or of a whole deployment
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusSkipped   Status = "skipped"
+)
+
+// Attempt is one try of a step that retries, e.g. a health check
+type Attempt struct {
+	Time   time.Time `json:"time"`
+	Result string    `json:"result,omitempty"` // e.g. "200" or "503"
+	Error  string    `json:"error,omitempty"`
+}
+
+// Step is the progress of one step of a deployment
+type Step struct {
+	Name       string     `json:"name"`
+	Status     Status     `json:"status"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Attempts   []Attempt  `json:"attempts,omitempty"`
+}
+
+// Deployment is the timeline of one app being deployed
+type Deployment struct {
+	ID         string            `json:"id"`
+	Project    string            `json:"project"`
+	App        string            `json:"app"`
+	Host       string            `json:"host,omitempty"`
+	Status     Status            `json:"status"`
+	FailedStep string            `json:"failed_step,omitempty"` // The step that broke the deployment
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Steps      []Step            `json:"steps"`
+}
+
+// Update reports progress of a step. An empty Status only records the attempt.
+type Update struct {
+	Step    string   `json:"step"`
+	Status  Status   `json:"status,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Attempt *Attempt `json:"attempt,omitempty"`
+}
+
+// ErrNotFound is returned for deployments that are unknown or were dropped
+var ErrNotFound = fmt.Errorf("deployment not found")
+
+// Tracker keeps the timelines of recent deployments in memory
+type Tracker struct {
+	mu          sync.RWMutex
+	deployments map[string]*Deployment
+	order       []string // IDs, oldest first
+}
+
+// NewTracker creates an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{deployments: make(map[string]*Deployment)}
+}
+
+// Start begins the timeline of a deployment with every step pending
+func (t *Tracker) Start(project, app, host string, metadata map[string]string) (Deployment, error) {
+	id, err := newID()
+	if err != nil {
+		return Deployment{}, err
+	}
+
+	now := time.Now().UTC()
+	deployment := &Deployment{
+		ID:        id,
+		Project:   project,
+		App:       app,
+		Host:      host,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Metadata:  metadata,
+		Steps:     make([]Step, len(Steps)),
+	}
+	for i, name := range Steps {
+		deployment.Steps[i] = Step{Name: name, Status: StatusPending}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deployments[id] = deployment
+	t.order = append(t.order, id)
+	if len(t.order) > maxDeployments {
+		delete(t.deployments, t.order[0])
+		t.order = t.order[1:]
+	}
+	return copyDeployment(deployment), nil
+}
+
+// Update applies progress of a step to a deployment's timeline
+func (t *Tracker) Update(id string, update Update) (Deployment, error) {
+	switch update.Status {
+	case "", StatusRunning, StatusSucceeded, StatusFailed, StatusSkipped:
+	default:
+		return Deployment{}, fmt.Errorf("invalid status %s, expected running, succeeded, failed or skipped", update.Status)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	deployment, ok := t.deployments[id]
+	if !ok {
+		return Deployment{}, ErrNotFound
+	}
+
+	var step *Step
+	for i := range deployment.Steps {
+		if deployment.Steps[i].Name == update.Step {
+			step = &deployment.Steps[i]
+		}
+	}
+	if step == nil {
+		return Deployment{}, fmt.Errorf("unknown step %s", update.Step)
+	}
+
+	now := time.Now().UTC()
+	if update.Attempt != nil {
+		attempt := *update.Attempt
+		if attempt.Time.IsZero() {
+			attempt.Time = now
+		}
+		step.Attempts = append(step.Attempts, attempt)
+	}
+
+	switch update.Status {
+	case StatusRunning:
+		step.StartedAt = &now
+		step.FinishedAt = nil
+	case StatusSucceeded, StatusFailed, StatusSkipped:
+		if step.StartedAt == nil && update.Status != StatusSkipped {
+			step.StartedAt = &now
+		}
+		step.FinishedAt = &now
+	}
+	if update.Status != "" {
+		step.Status = update.Status
+		step.Error = update.Error
+	}
+	if update.Message != "" {
+		step.Message = update.Message
+	}
+
+	deployment.UpdatedAt = now
+	deployment.Status, deployment.FailedStep = progress(deployment.Steps)
+	return copyDeployment(deployment), nil
+}
+
+// Get returns a deployment's timeline
+func (t *Tracker) Get(id string) (Deployment, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	deployment, ok := t.deployments[id]
+	if !ok {
+		return Deployment{}, ErrNotFound
+	}
+	return copyDeployment(deployment), nil
+}
+
+// progress derives a deployment's status from its steps: failed once a step
+// failed, succeeded once every step finished and running in between
+func progress(steps []Step) (Status, string) {
+	status := StatusSucceeded
+	started := false
+	for _, step := range steps {
+		switch step.Status {
+		case StatusFailed:
+			return StatusFailed, step.Name
+		case StatusPending, StatusRunning:
+			status = StatusRunning
+		}
+		if step.Status != StatusPending {
+			started = true
+		}
+	}
+	if !started {
+		return StatusPending, ""
+	}
+	return status, ""
+}
+
+// copyDeployment returns a copy callers can't use to change the tracked
+// timeline
+func copyDeployment(deployment *Deployment) Deployment {
+	copied := *deployment
+	copied.Steps = make([]Step, len(deployment.Steps))
+	for i, step := range deployment.Steps {
+		step.Attempts = append([]Attempt(nil), step.Attempts...)
+		copied.Steps[i] = step
+	}
+	return copied
+}
+
+// newID returns a random deployment ID
+func newID() (string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate deployment ID: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
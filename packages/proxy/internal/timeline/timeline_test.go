@@ -0,0 +1,85 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimelineProgress(t *testing.T) {
+	tracker := NewTracker()
+
+	deployment, err := tracker.Start("blog", "web", "blog.example.com", map[string]string{"sha": "4f2a9c1"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, deployment.Status)
+	require.Len(t, deployment.Steps, len(Steps))
+
+	_, err = tracker.Update(deployment.ID, Update{Step: StepBuild, Status: StatusSkipped})
+	require.NoError(t, err)
+	deployment, err = tracker.Update(deployment.ID, Update{Step: StepHealthCheck, Status: StatusRunning})
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, deployment.Status)
+
+	_, err = tracker.Update(deployment.ID, Update{Step: StepHealthCheck, Attempt: &Attempt{Result: "503"}})
+	require.NoError(t, err)
+	deployment, err = tracker.Update(deployment.ID, Update{Step: StepHealthCheck, Status: StatusFailed, Error: "no 200 after 30 attempts", Attempt: &Attempt{Result: "503"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusFailed, deployment.Status)
+	assert.Equal(t, StepHealthCheck, deployment.FailedStep)
+
+	step := deployment.Steps[4]
+	assert.Equal(t, StepHealthCheck, step.Name)
+	assert.Len(t, step.Attempts, 2)
+	assert.Equal(t, "no 200 after 30 attempts", step.Error)
+	require.NotNil(t, step.StartedAt)
+	require.NotNil(t, step.FinishedAt)
+	assert.Nil(t, deployment.Steps[0].StartedAt, "skipped steps never start")
+
+	// The tracked timeline isn't changed through returned copies
+	deployment.Steps[4].Attempts[0].Result = "200"
+	got, err := tracker.Get(deployment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "503", got.Steps[4].Attempts[0].Result)
+}
+
+func TestTimelineSucceedsOnceEveryStepFinished(t *testing.T) {
+	tracker := NewTracker()
+	deployment, err := tracker.Start("blog", "web", "", nil)
+	require.NoError(t, err)
+
+	for _, step := range Steps {
+		deployment, err = tracker.Update(deployment.ID, Update{Step: step, Status: StatusSucceeded})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, StatusSucceeded, deployment.Status)
+	assert.Empty(t, deployment.FailedStep)
+}
+
+func TestTimelineRejectsInvalidUpdates(t *testing.T) {
+	tracker := NewTracker()
+	deployment, err := tracker.Start("blog", "web", "", nil)
+	require.NoError(t, err)
+
+	_, err = tracker.Update(deployment.ID, Update{Step: "deploy", Status: StatusRunning})
+	assert.Error(t, err)
+	_, err = tracker.Update(deployment.ID, Update{Step: StepBuild, Status: "done"})
+	assert.Error(t, err)
+	_, err = tracker.Update("unknown", Update{Step: StepBuild, Status: StatusRunning})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestTrackerDropsOldestTimelines(t *testing.T) {
+	tracker := NewTracker()
+	first, err := tracker.Start("blog", "web", "", nil)
+	require.NoError(t, err)
+	for i := 0; i < maxDeployments; i++ {
+		_, err := tracker.Start("blog", "web", "", nil)
+		require.NoError(t, err)
+	}
+
+	_, err = tracker.Get(first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Len(t, tracker.deployments, maxDeployments)
+}
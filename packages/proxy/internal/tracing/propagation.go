@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// ParseTraceparent parses a W3C traceparent header value such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	// Future versions may append fields, but version 00 has exactly four
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("unsupported traceparent version %q", version)
+	}
+
+	var sc SpanContext
+	if len(traceID) != 32 || strings.ToLower(traceID) != traceID {
+		return SpanContext{}, fmt.Errorf("invalid trace ID %q", traceID)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceID)); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace ID %q", traceID)
+	}
+	if len(spanID) != 16 || strings.ToLower(spanID) != spanID {
+		return SpanContext{}, fmt.Errorf("invalid parent ID %q", spanID)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(spanID)); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid parent ID %q", spanID)
+	}
+
+	flagBytes, err := hex.DecodeString(flags)
+	if err != nil || len(flagBytes) != 1 {
+		return SpanContext{}, fmt.Errorf("invalid trace flags %q", flags)
+	}
+	sc.Sampled = flagBytes[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent has an all-zero ID")
+	}
+	return sc, nil
+}
+
+// FormatTraceparent formats a span context as a version 00 traceparent value
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Extract returns ctx with the remote parent from the request headers, if any.
+// Invalid headers are ignored and a new trace is started instead.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Inject writes the current span context in ctx to the headers so the next
+// service continues the trace. tracestate is passed through untouched.
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, FormatTraceparent(sc))
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", FormatTraceparent(sc))
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := ParseTraceparent(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestStartContinuesRemoteTrace(t *testing.T) {
+	tracer := NewTracer(nil)
+
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, span := tracer.Start(Extract(context.Background(), header), "HTTP GET", SpanKindServer)
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context().TraceID.String())
+	assert.NotEqual(t, "00f067aa0ba902b7", span.Context().SpanID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.parent.String())
+	assert.False(t, span.Context().Sampled, "sampling decision should follow the parent")
+
+	out := http.Header{}
+	Inject(ctx, out)
+	assert.Equal(t, FormatTraceparent(span.Context()), out.Get(TraceparentHeader))
+}
+
+func TestNilSpanIsNoop(t *testing.T) {
+	ctx, span := Start(context.Background(), "disabled", SpanKindInternal)
+	assert.Nil(t, span)
+
+	span.SetAttributes(String("key", "value"))
+	span.RecordError(errors.New("boom"))
+	span.End()
+
+	header := http.Header{}
+	Inject(ctx, header)
+	assert.Empty(t, header.Get(TraceparentHeader))
+}
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+
+		var req otlpRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		received <- req
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL+"/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=secret")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	config := ConfigFromEnv()
+	require.NotNil(t, config)
+	assert.Equal(t, "iop-proxy", config.ServiceName)
+
+	tracer := NewTracer(NewOTLPExporter(*config))
+	ctx, parent := tracer.Start(context.Background(), "HTTP GET", SpanKindServer, String("server.address", "app.example.com"))
+	_, child := tracer.Start(ctx, "HTTP GET", SpanKindClient, Int("http.response.status_code", 502))
+	child.RecordError(errors.New("upstream returned 502"))
+	child.End()
+	parent.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, tracer.Shutdown(shutdownCtx))
+
+	req := <-received
+	require.Len(t, req.ResourceSpans, 1)
+	assert.Equal(t, "iop-proxy", *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, parent.Context().TraceID.String(), spans[0].TraceID)
+	assert.Equal(t, parent.Context().SpanID.String(), spans[0].ParentSpanID)
+	assert.Equal(t, SpanKindClient, spans[0].Kind)
+	assert.Equal(t, "502", *spans[0].Attributes[0].Value.IntValue)
+	assert.Equal(t, 2, spans[0].Status.Code)
+	assert.Empty(t, spans[1].ParentSpanID)
+}
@@ -0,0 +1,402 @@
+package uptime
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+)
+
+// Checks run every checkInterval, at most maxConcurrentChecks at a time, and
+// fail when a host takes longer than checkTimeout to answer
+const (
+	checkInterval       = time.Minute
+	checkTimeout        = 10 * time.Second
+	maxConcurrentChecks = 10
+)
+
+// How much history is kept per host: the checks of the last hour, hourly
+// totals for a week and daily totals for 90 days
+const (
+	recentChecks = 60
+	hourlyLimit  = 7 * 24
+	dailyLimit   = 90
+)
+
+// Check is the result of checking a host from outside
+type Check struct {
+	Time       time.Time `json:"time"`
+	Up         bool      `json:"up"`
+	StatusCode int       `json:"status_code,omitempty"`
+	LatencyMs  int64     `json:"latency_ms"`       // Until the response headers arrived, including DNS, connecting and TLS
+	TLSMs      int64     `json:"tls_ms,omitempty"` // TLS handshake
+	Error      string    `json:"error,omitempty"`
+}
+
+// Bucket totals the checks of an hour or a day
+type Bucket struct {
+	Start     time.Time `json:"start"`
+	Checks    int       `json:"checks"`
+	Up        int       `json:"up"`
+	LatencyMs int64     `json:"latency_ms"` // Summed over the checks that were up
+}
+
+// history is what is kept of a host's checks
+type history struct {
+	Recent    []Check    `json:"recent"` // Oldest first
+	Hourly    []Bucket   `json:"hourly"`
+	Daily     []Bucket   `json:"daily"`
+	DownSince *time.Time `json:"down_since,omitempty"`
+}
+
+// add records a check in the host's history
+func (h *history) add(check Check) {
+	h.Recent = append(h.Recent, check)
+	if len(h.Recent) > recentChecks {
+		h.Recent = h.Recent[len(h.Recent)-recentChecks:]
+	}
+	h.Hourly = addToBucket(h.Hourly, check, check.Time.UTC().Truncate(time.Hour), hourlyLimit)
+	h.Daily = addToBucket(h.Daily, check, check.Time.UTC().Truncate(24*time.Hour), dailyLimit)
+
+	if check.Up {
+		h.DownSince = nil
+	} else if h.DownSince == nil {
+		since := check.Time
+		h.DownSince = &since
+	}
+}
+
+func addToBucket(buckets []Bucket, check Check, start time.Time, limit int) []Bucket {
+	if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(start) {
+		buckets = append(buckets, Bucket{Start: start})
+		if len(buckets) > limit {
+			buckets = buckets[len(buckets)-limit:]
+		}
+	}
+	bucket := &buckets[len(buckets)-1]
+	bucket.Checks++
+	if check.Up {
+		bucket.Up++
+		bucket.LatencyMs += check.LatencyMs
+	}
+	return buckets
+}
+
+// Report is a host's uptime. Percentages are nil for periods without checks.
+type Report struct {
+	Host         string     `json:"host"`
+	Project      string     `json:"project"`
+	App          string     `json:"app,omitempty"`
+	Up           bool       `json:"up"`
+	LastCheck    *Check     `json:"last_check,omitempty"`
+	DownSince    *time.Time `json:"down_since,omitempty"`
+	Uptime24h    *float64   `json:"uptime_24h"`
+	Uptime7d     *float64   `json:"uptime_7d"`
+	Uptime30d    *float64   `json:"uptime_30d"`
+	Uptime90d    *float64   `json:"uptime_90d"`
+	AvgLatencyMs int64      `json:"avg_latency_ms"` // Over the last 24 hours
+	Daily        []Bucket   `json:"daily,omitempty"`
+	Recent       []Check    `json:"recent,omitempty"`
+}
+
+// Monitor checks each deployed host the way a visitor reaches it: resolving
+// its name, connecting to its public address and completing the TLS
+// handshake with certificate verification. Unlike health checks, which call
+// the backend directly, this catches broken DNS, certificates and routing.
+type Monitor struct {
+	state  *state.State
+	path   string
+	client *http.Client
+
+	mu      sync.Mutex
+	history map[string]*history
+}
+
+// New creates a monitor keeping its history in the file at path
+func New(st *state.State, path string) *Monitor {
+	return &Monitor{
+		state: st,
+		path:  path,
+		client: &http.Client{
+			Timeout: checkTimeout,
+			Transport: &http.Transport{
+				// Each check connects and shakes hands anew, as a new visitor would
+				DisableKeepAlives: true,
+			},
+			// A redirect is an answer, e.g. to a login page
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		history: make(map[string]*history),
+	}
+}
+
+// Load reads the history saved by a previous run. A missing file is not an error.
+func (m *Monitor) Load() error {
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read uptime history: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := json.Unmarshal(data, &m.history); err != nil {
+		return fmt.Errorf("failed to parse uptime history: %w", err)
+	}
+	return nil
+}
+
+// save writes the history of hosts that still exist
+func (m *Monitor) save() error {
+	hosts := m.state.GetAllHosts()
+
+	m.mu.Lock()
+	for hostname := range m.history {
+		if _, ok := hosts[hostname]; !ok {
+			delete(m.history, hostname)
+		}
+	}
+	data, err := json.Marshal(m.history)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Run checks every host each interval until the context is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	log.Println("[UPTIME] Starting uptime monitor")
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		m.CheckAll(ctx)
+		if err := m.save(); err != nil {
+			log.Printf("[UPTIME] Failed to save history: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("[UPTIME] Stopping uptime monitor")
+			return
+		}
+	}
+}
+
+// monitored reports whether a host is checked. Patterns have no name to
+// resolve, internal hosts can't be reached from outside, and hosts stopped
+// or scaled to zero on purpose would be reported down or woken up.
+func monitored(hostname string, host *state.Host) bool {
+	return !state.IsHostPattern(hostname) && !host.Internal && !host.OnDemand &&
+		!host.Stopped && !host.Sleeping
+}
+
+// CheckAll checks every monitored host once
+func (m *Monitor) CheckAll(ctx context.Context) {
+	jobs := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < maxConcurrentChecks; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for hostname := range jobs {
+				m.checkHost(ctx, hostname)
+			}
+		}()
+	}
+
+	for hostname, host := range m.state.GetAllHosts() {
+		if !monitored(hostname, host) {
+			continue
+		}
+		select {
+		case jobs <- hostname:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	workers.Wait()
+}
+
+// checkHost requests a host's health path, or / without one, over HTTPS
+// when it has a certificate. Any answer below 500 counts as up, since a
+// 404 or a login redirect still means the app is serving.
+func (m *Monitor) checkHost(ctx context.Context, hostname string) {
+	host, _, err := m.state.GetHost(hostname)
+	if err != nil {
+		return
+	}
+
+	scheme := "http"
+	if host.SSLEnabled {
+		scheme = "https"
+	}
+	path := host.HealthPath
+	if path == "" {
+		path = "/"
+	}
+
+	check := Check{Time: time.Now()}
+	var tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			check.TLSMs = time.Since(tlsStart).Milliseconds()
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, fmt.Sprintf("%s://%s%s", scheme, hostname, path), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", "iop-proxy-uptime/1.0")
+
+	resp, err := m.client.Do(req)
+	check.LatencyMs = time.Since(check.Time).Milliseconds()
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		check.Error = err.Error()
+	} else {
+		resp.Body.Close()
+		check.StatusCode = resp.StatusCode
+		check.Up = resp.StatusCode < 500
+		if !check.Up {
+			check.Error = fmt.Sprintf("status %d", resp.StatusCode)
+		}
+	}
+
+	m.record(hostname, check)
+}
+
+// record adds a check to a host's history and logs when the host goes down
+// or comes back
+func (m *Monitor) record(hostname string, check Check) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.history[hostname]
+	if !ok {
+		h = &history{}
+		m.history[hostname] = h
+	}
+	wasUp, downSince := h.DownSince == nil, h.DownSince
+	h.add(check)
+
+	if wasUp && !check.Up {
+		log.Printf("[UPTIME] [%s] Down: %s", hostname, check.Error)
+	} else if !wasUp && check.Up {
+		log.Printf("[UPTIME] [%s] Up again after %s", hostname, check.Time.Sub(*downSince).Round(time.Second))
+	}
+}
+
+// uptime returns the share of checks that were up in the buckets starting
+// at or after since, as a percentage
+func uptime(buckets []Bucket, since time.Time) (*float64, int64) {
+	checks, up := 0, 0
+	var latency int64
+	for _, bucket := range buckets {
+		if bucket.Start.Before(since) {
+			continue
+		}
+		checks += bucket.Checks
+		up += bucket.Up
+		latency += bucket.LatencyMs
+	}
+	if checks == 0 {
+		return nil, 0
+	}
+	percent := float64(up) * 100 / float64(checks)
+	var avgLatency int64
+	if up > 0 {
+		avgLatency = latency / int64(up)
+	}
+	return &percent, avgLatency
+}
+
+// report describes a host's history as of now
+func (m *Monitor) report(hostname string, h *history, apps map[string]state.AppRef, now time.Time) Report {
+	report := Report{Host: hostname, DownSince: h.DownSince}
+	if len(h.Recent) > 0 {
+		last := h.Recent[len(h.Recent)-1]
+		report.LastCheck = &last
+		report.Up = last.Up
+	}
+
+	hour := now.UTC().Truncate(time.Hour)
+	day := now.UTC().Truncate(24 * time.Hour)
+	report.Uptime24h, report.AvgLatencyMs = uptime(h.Hourly, hour.Add(-23*time.Hour))
+	report.Uptime7d, _ = uptime(h.Hourly, hour.Add(-(hourlyLimit-1)*time.Hour))
+	report.Uptime30d, _ = uptime(h.Daily, day.AddDate(0, 0, -29))
+	report.Uptime90d, _ = uptime(h.Daily, day.AddDate(0, 0, -(dailyLimit-1)))
+
+	if _, project, err := m.state.GetHost(hostname); err == nil {
+		report.Project = project
+	}
+	if app, ok := apps[hostname]; ok {
+		report.App = app.App
+	}
+	return report
+}
+
+// Reports returns the uptime of every checked host, sorted by project and host
+func (m *Monitor) Reports() []Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	apps := m.state.HostApps()
+	reports := make([]Report, 0, len(m.history))
+	for hostname, h := range m.history {
+		report := m.report(hostname, h, apps, now)
+		if report.Project == "" {
+			continue // Removed since its last check
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Project != reports[j].Project {
+			return reports[i].Project < reports[j].Project
+		}
+		return reports[i].Host < reports[j].Host
+	})
+	return reports
+}
+
+// HostReport returns a host's uptime with its daily totals and recent
+// checks. Returns false for hosts that haven't been checked.
+func (m *Monitor) HostReport(hostname string) (Report, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.history[hostname]
+	if !ok {
+		return Report{}, false
+	}
+	report := m.report(hostname, h, m.state.HostApps(), time.Now())
+	report.Daily = append([]Bucket{}, h.Daily...)
+	report.Recent = append([]Check{}, h.Recent...)
+	return report, true
+}
@@ -0,0 +1,132 @@
+package uptime
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMonitor returns a monitor whose checks reach the given servers by
+// hostname instead of resolving it
+func newTestMonitor(t *testing.T, st *state.State, servers map[string]*httptest.Server) *Monitor {
+	m := New(st, filepath.Join(t.TempDir(), "uptime.json"))
+
+	var transport *http.Transport
+	for _, server := range servers {
+		if server.TLS != nil {
+			transport = server.Client().Transport.(*http.Transport).Clone()
+		}
+	}
+	if transport == nil {
+		transport = &http.Transport{}
+	}
+	transport.DisableKeepAlives = true
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		server, ok := servers[host]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	m.client.Transport = transport
+	return m
+}
+
+func TestCheckAll(t *testing.T) {
+	// httptest's certificate is valid for example.com
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/up", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer secure.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("example.com", "blog-web:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.DeployHost("api.test", "shop-api:3000", "shop", "api", "", false))
+	require.NoError(t, st.DeployHost("gone.test", "shop-web:3000", "shop", "web", "/up", false))
+	require.NoError(t, st.DeployHost("*.example.com", "blog-web:3000", "blog", "web", "/up", true))
+	require.NoError(t, st.DeployHost("admin.example.com", "blog-admin:3000", "blog", "admin", "/up", true))
+	require.NoError(t, st.SetHostInternal("admin.example.com", true))
+
+	m := newTestMonitor(t, st, map[string]*httptest.Server{"example.com": secure, "api.test": failing})
+	m.CheckAll(context.Background())
+
+	reports := m.Reports()
+	require.Len(t, reports, 3, "patterns and internal hosts aren't checked")
+
+	assert.Equal(t, "example.com", reports[0].Host)
+	assert.Equal(t, "web", reports[0].App)
+	assert.True(t, reports[0].Up)
+	assert.Equal(t, 200, reports[0].LastCheck.StatusCode)
+	assert.Equal(t, 100.0, *reports[0].Uptime24h)
+
+	assert.Equal(t, "api.test", reports[1].Host)
+	assert.False(t, reports[1].Up)
+	assert.Equal(t, "status 502", reports[1].LastCheck.Error)
+	assert.NotNil(t, reports[1].DownSince)
+	assert.Equal(t, 0.0, *reports[1].Uptime24h)
+
+	assert.Equal(t, "gone.test", reports[2].Host)
+	assert.Contains(t, reports[2].LastCheck.Error, "no such host")
+}
+
+func TestHistory(t *testing.T) {
+	var h history
+	start := time.Date(2024, 5, 1, 22, 0, 0, 0, time.UTC)
+	for i := 0; i < 180; i++ {
+		// Down for the first 10 minutes of each hour
+		h.add(Check{Time: start.Add(time.Duration(i) * time.Minute), Up: i%60 >= 10, LatencyMs: 20})
+	}
+
+	assert.Len(t, h.Recent, recentChecks)
+	require.Len(t, h.Hourly, 3)
+	assert.Equal(t, Bucket{Start: start, Checks: 60, Up: 50, LatencyMs: 1000}, h.Hourly[0])
+	require.Len(t, h.Daily, 2, "the checks span midnight")
+	assert.Equal(t, 120, h.Daily[0].Checks)
+	assert.Equal(t, 60, h.Daily[1].Checks)
+	assert.Nil(t, h.DownSince)
+
+	percent, latency := uptime(h.Hourly, start)
+	assert.InDelta(t, 83.33, *percent, 0.01)
+	assert.Equal(t, int64(20), latency)
+	percent, _ = uptime(h.Hourly, start.Add(24*time.Hour))
+	assert.Nil(t, percent, "no checks in the period")
+
+	down := start.Add(3 * time.Hour)
+	h.add(Check{Time: down})
+	h.add(Check{Time: down.Add(time.Minute)})
+	assert.Equal(t, down, *h.DownSince, "an outage starts at its first failed check")
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, st.DeployHost("example.com", "blog-web:3000", "blog", "web", "/up", true))
+
+	m := New(st, filepath.Join(t.TempDir(), "uptime.json"))
+	m.record("example.com", Check{Time: time.Now(), Up: true})
+	m.record("removed.example.com", Check{Time: time.Now(), Up: true})
+	require.NoError(t, m.save())
+
+	loaded := New(st, m.path)
+	require.NoError(t, loaded.Load())
+	report, ok := loaded.HostReport("example.com")
+	require.True(t, ok)
+	assert.Len(t, report.Recent, 1)
+	_, ok = loaded.HostReport("removed.example.com")
+	assert.False(t, ok, "hosts that were removed are dropped")
+
+	require.NoError(t, New(st, filepath.Join(t.TempDir(), "missing.json")).Load())
+}
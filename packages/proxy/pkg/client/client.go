@@ -0,0 +1,186 @@
+// Package client is a typed Go client for the iop-proxy management API. The
+// types and methods in client_gen.go are generated from the API's OpenAPI
+// specification, this file sends the requests.
+package client
+
+//go:generate go run ../../cmd/client-gen -spec ../../internal/api/openapi.json -out client_gen.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultBaseURL is where the API listens over TCP
+const DefaultBaseURL = "http://localhost:8080"
+
+// ActorHeader carries who is making a request, for the audit log
+const ActorHeader = "X-IOP-Actor"
+
+// ErrNotModified is returned for a 304 answer to IfNoneMatch
+var ErrNotModified = errors.New("not modified")
+
+// Client calls the management API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	actor      string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken authenticates requests with an API token from iop-proxy user add
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithActor names who requests are made on behalf of in the audit log
+func WithActor(actor string) Option {
+	return func(c *Client) { c.actor = actor }
+}
+
+// WithHTTPClient sends requests with hc instead of a default client
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// New creates a client for the API at baseURL, DefaultBaseURL if empty
+func New(baseURL string, opts ...Option) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	c := &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: &http.Client{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewUnix creates a client that connects to the API's unix socket
+func NewUnix(socketPath string, opts ...Option) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return New("http://iop-proxy", append([]Option{WithHTTPClient(&http.Client{Transport: transport})}, opts...)...)
+}
+
+// RequestOption sets headers on one request
+type RequestOption func(*http.Request)
+
+// IfMatch only makes a write if the resource still has the ETag, or exists
+// at all with "*". Otherwise the request fails with a 412 Error.
+func IfMatch(etag string) RequestOption {
+	return func(r *http.Request) { r.Header.Set("If-Match", etag) }
+}
+
+// IfNoneMatch with "*" only creates a resource that doesn't exist yet. On a
+// read, an unchanged resource returns ErrNotModified.
+func IfNoneMatch(etag string) RequestOption {
+	return func(r *http.Request) { r.Header.Set("If-None-Match", etag) }
+}
+
+// IdempotencyKey makes a write safe to retry: repeating the key replays the
+// first response instead of making the change again
+func IdempotencyKey(key string) RequestOption {
+	return func(r *http.Request) { r.Header.Set("Idempotency-Key", key) }
+}
+
+// Response is what the API said besides the data
+type Response struct {
+	StatusCode int
+	Header     http.Header // e.g. ETag and Idempotent-Replayed
+	Message    string      // Human readable outcome, e.g. "Deployed host blog.example.com"
+}
+
+// Error is a request the API rejected
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// do sends a request and decodes the data of the JSON envelope into data,
+// which may be nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, data interface{}, opts []RequestOption) (*Response, error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.actor != "" {
+		req.Header.Set(ActorHeader, c.actor)
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	resp := &Response{StatusCode: httpResp.StatusCode, Header: httpResp.Header}
+	if httpResp.StatusCode == http.StatusNotModified {
+		return resp, ErrNotModified
+	}
+
+	payload, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return resp, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		// Routing errors are plain text
+		if httpResp.StatusCode >= http.StatusBadRequest {
+			return resp, &Error{StatusCode: httpResp.StatusCode, Message: strings.TrimSpace(string(payload))}
+		}
+		return resp, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	resp.Message = envelope.Message
+
+	if !envelope.Success || httpResp.StatusCode >= http.StatusBadRequest {
+		return resp, &Error{StatusCode: httpResp.StatusCode, Message: envelope.Message}
+	}
+	if data != nil && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, data); err != nil {
+			return resp, fmt.Errorf("failed to unmarshal response data: %w", err)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,1684 @@
+// Code generated by client-gen from openapi.json. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Envelope: Envelope of every JSON response
+type Envelope struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"` // Depends on the operation
+}
+
+// HostSpec: Desired routing configuration of a host
+type HostSpec struct {
+	Target            string `json:"target"`                        // Backend as container:port
+	App               string `json:"app,omitempty"`                 // App the host belongs to, used for scaling and metrics
+	HealthPath        string `json:"health_path,omitempty"`         // Path checked for a 2xx, defaults to /up
+	SSL               bool   `json:"ssl,omitempty"`                 // Acquire a certificate and redirect HTTP to HTTPS
+	Mode              string `json:"mode,omitempty"`                // http terminates TLS at the proxy, passthrough forwards it to the backend
+	Streaming         bool   `json:"streaming,omitempty"`           // Flush responses to clients as they arrive, without the write timeout, for Server-Sent Events and long polling
+	Internal          bool   `json:"internal,omitempty"`            // Only serve clients whose address is on a private network, e.g. over a VPN. Others get 404.
+	DialTimeout       string `json:"dial_timeout,omitempty"`        // Time to connect to the backend, e.g. 5s. Defaults to 10s.
+	ResponseTimeout   string `json:"response_timeout,omitempty"`    // Time for the backend to send response headers. Defaults to 30s.
+	RequestTimeout    string `json:"request_timeout,omitempty"`     // Time for the whole request including the response body, unlimited if empty
+	StreamIdleTimeout string `json:"stream_idle_timeout,omitempty"` // Time without response data before the response is cut off, unlimited if empty
+}
+
+// DeployRequest: Deploys a host into a project
+type DeployRequest struct {
+	Host              string            `json:"host"`
+	Target            string            `json:"target"` // Backend as container:port
+	Project           string            `json:"project"`
+	App               string            `json:"app,omitempty"`                 // App the host belongs to, used for scaling and metrics
+	HealthPath        string            `json:"health_path,omitempty"`         // Path checked for a 2xx, defaults to /up
+	SSL               bool              `json:"ssl,omitempty"`                 // Acquire a certificate and redirect HTTP to HTTPS
+	Mode              string            `json:"mode,omitempty"`                // http terminates TLS at the proxy, passthrough forwards it to the backend
+	Streaming         bool              `json:"streaming,omitempty"`           // Flush responses to clients as they arrive, without the write timeout, for Server-Sent Events and long polling
+	Internal          bool              `json:"internal,omitempty"`            // Only serve clients whose address is on a private network, e.g. over a VPN. Others get 404.
+	Metadata          map[string]string `json:"metadata,omitempty"`            // Recorded in the host's deployment history and included in notifications, e.g. sha, branch, actor and ci_url
+	DialTimeout       string            `json:"dial_timeout,omitempty"`        // Time to connect to the backend, e.g. 5s. Defaults to 10s.
+	ResponseTimeout   string            `json:"response_timeout,omitempty"`    // Time for the backend to send response headers. Defaults to 30s.
+	RequestTimeout    string            `json:"request_timeout,omitempty"`     // Time for the whole request including the response body, unlimited if empty
+	StreamIdleTimeout string            `json:"stream_idle_timeout,omitempty"` // Time without response data before the response is cut off, unlimited if empty
+	DeploymentID      string            `json:"deployment_id,omitempty"`       // Timeline whose switch step this deploy is, from POST /api/deployments
+	Bake              *BakeRequest      `json:"bake,omitempty"`
+}
+
+// BakeRequest: Watches a deploy's requests after the traffic switch. The replaced containers keep running outside the app's network aliases and get them back when the error rate or latency exceed the limits, otherwise they are removed once the bake passes. A later deploy of the app ends the bake.
+type BakeRequest struct {
+	Duration      string   `json:"duration"`                 // How long the replaced containers are kept, e.g. 5m
+	Interval      string   `json:"interval,omitempty"`       // Time between checks such as 5s, 10s by default
+	MaxErrorRate  float64  `json:"max_error_rate,omitempty"` // Highest fraction of 5xx responses, e.g. 0.05, no limit if 0
+	MaxLatency    string   `json:"max_latency,omitempty"`    // Highest average response time, e.g. 500ms, no limit if empty
+	MinRequests   int64    `json:"min_requests,omitempty"`   // Requests needed before the limits apply
+	Network       string   `json:"network"`                  // Project network the aliases are on
+	Aliases       []string `json:"aliases"`                  // Network aliases the app is reachable as
+	Color         string   `json:"color,omitempty"`          // Color of the new containers
+	Containers    []string `json:"containers"`               // The new containers, removed on rollback
+	PreviousColor string   `json:"previous_color,omitempty"` // Color of the replaced containers
+	Previous      []string `json:"previous"`                 // The replaced containers, still running outside the aliases
+}
+
+// HostPutRequest: Complete routing configuration of a host
+type HostPutRequest struct {
+	Project           string `json:"project"`
+	Target            string `json:"target"`                        // Backend as container:port
+	App               string `json:"app,omitempty"`                 // App the host belongs to, used for scaling and metrics
+	HealthPath        string `json:"health_path,omitempty"`         // Path checked for a 2xx, defaults to /up
+	SSL               bool   `json:"ssl,omitempty"`                 // Acquire a certificate and redirect HTTP to HTTPS
+	Mode              string `json:"mode,omitempty"`                // http terminates TLS at the proxy, passthrough forwards it to the backend
+	Streaming         bool   `json:"streaming,omitempty"`           // Flush responses to clients as they arrive, without the write timeout, for Server-Sent Events and long polling
+	Internal          bool   `json:"internal,omitempty"`            // Only serve clients whose address is on a private network, e.g. over a VPN. Others get 404.
+	DialTimeout       string `json:"dial_timeout,omitempty"`        // Time to connect to the backend, e.g. 5s. Defaults to 10s.
+	ResponseTimeout   string `json:"response_timeout,omitempty"`    // Time for the backend to send response headers. Defaults to 30s.
+	RequestTimeout    string `json:"request_timeout,omitempty"`     // Time for the whole request including the response body, unlimited if empty
+	StreamIdleTimeout string `json:"stream_idle_timeout,omitempty"` // Time without response data before the response is cut off, unlimited if empty
+}
+
+// ApplyRequest: Complete desired set of hosts, keyed by project and then hostname. Hosts that aren't listed are removed.
+type ApplyRequest struct {
+	Projects map[string]map[string]HostSpec `json:"projects"`
+	DryRun   bool                           `json:"dry_run,omitempty"` // Only report what would change
+}
+
+// ApplyResult: Hostnames apply added, updated, removed and left alone
+type ApplyResult struct {
+	Added     []string `json:"added,omitempty"`
+	Updated   []string `json:"updated,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Unchanged []string `json:"unchanged,omitempty"`
+}
+
+// Host: Routing configuration of a deployed host
+type Host struct {
+	ID                string             `json:"id,omitempty"`     // Stays the same across redeploys and project moves
+	Target            string             `json:"target,omitempty"` // Backend as container:port
+	App               string             `json:"app,omitempty"`
+	HealthPath        string             `json:"health_path,omitempty"`
+	CreatedAt         time.Time          `json:"created_at,omitempty"`
+	SSLEnabled        bool               `json:"ssl_enabled,omitempty"`
+	SSLRedirect       bool               `json:"ssl_redirect,omitempty"`
+	ForwardHeaders    bool               `json:"forward_headers,omitempty"`
+	DialTimeout       string             `json:"dial_timeout,omitempty"`        // Time to connect to the backend, e.g. 5s. Defaults to 10s.
+	ResponseTimeout   string             `json:"response_timeout,omitempty"`    // Time for the backend to send response headers. Defaults to 30s.
+	RequestTimeout    string             `json:"request_timeout,omitempty"`     // Time for the whole request including the response body, unlimited if empty
+	StreamIdleTimeout string             `json:"stream_idle_timeout,omitempty"` // Time without response data before the response is cut off, unlimited if empty
+	Certificate       *CertificateStatus `json:"certificate,omitempty"`
+	TLS               *TLSPolicy         `json:"tls,omitempty"`
+	Limits            *HostLimits        `json:"limits,omitempty"`
+	Mode              string             `json:"mode,omitempty"`      // http (default) or passthrough
+	OnDemand          bool               `json:"on_demand,omitempty"` // Only holds an on-demand certificate
+	AliasOf           string             `json:"alias_of,omitempty"`  // Custom domain serving the same backend as this host
+	ScaleToZero       bool               `json:"scale_to_zero,omitempty"`
+	IdleTimeout       string             `json:"idle_timeout,omitempty"` // Inactivity before a scale to zero app is stopped, e.g. 15m
+	ColdStart         *ColdStartPolicy   `json:"cold_start,omitempty"`
+	Rules             []RoutingRule      `json:"rules,omitempty"` // Send matching requests to alternate targets, first match wins
+	Mirror            *MirrorPolicy      `json:"mirror,omitempty"`
+	Probes            *HostProbes        `json:"probes,omitempty"`
+	ErrorPages        map[string]string  `json:"error_pages,omitempty"` // HTML templates by status code, 404, 502, 503 or 504. Templates see Status, StatusText, Host, Path, Message, RequestID and RetryAfter.
+	Streaming         bool               `json:"streaming,omitempty"`   // Flush responses to clients as they arrive, without the write timeout, for Server-Sent Events and long polling
+	Internal          bool               `json:"internal,omitempty"`    // Only serve clients whose address is on a private network, e.g. over a VPN. Others get 404.
+	Stopped           bool               `json:"stopped,omitempty"`     // The app was stopped on purpose and stays down until started or deployed
+	Deployments       []DeploymentRecord `json:"deployments,omitempty"` // Recent deploys, oldest first
+}
+
+// HostStatus: Host with its project, ETag and runtime health
+type HostStatus struct {
+	ID                string             `json:"id,omitempty"` // Stays the same across redeploys and project moves
+	Project           string             `json:"project,omitempty"`
+	ETag              string             `json:"etag,omitempty"`   // For If-Match on PUT and DELETE /api/hosts/{host}
+	Target            string             `json:"target,omitempty"` // Backend as container:port
+	App               string             `json:"app,omitempty"`
+	HealthPath        string             `json:"health_path,omitempty"`
+	CreatedAt         time.Time          `json:"created_at,omitempty"`
+	SSLEnabled        bool               `json:"ssl_enabled,omitempty"`
+	SSLRedirect       bool               `json:"ssl_redirect,omitempty"`
+	ForwardHeaders    bool               `json:"forward_headers,omitempty"`
+	DialTimeout       string             `json:"dial_timeout,omitempty"`        // Time to connect to the backend, e.g. 5s. Defaults to 10s.
+	ResponseTimeout   string             `json:"response_timeout,omitempty"`    // Time for the backend to send response headers. Defaults to 30s.
+	RequestTimeout    string             `json:"request_timeout,omitempty"`     // Time for the whole request including the response body, unlimited if empty
+	StreamIdleTimeout string             `json:"stream_idle_timeout,omitempty"` // Time without response data before the response is cut off, unlimited if empty
+	Certificate       *CertificateStatus `json:"certificate,omitempty"`
+	TLS               *TLSPolicy         `json:"tls,omitempty"`
+	Limits            *HostLimits        `json:"limits,omitempty"`
+	Mode              string             `json:"mode,omitempty"`      // http (default) or passthrough
+	OnDemand          bool               `json:"on_demand,omitempty"` // Only holds an on-demand certificate
+	AliasOf           string             `json:"alias_of,omitempty"`  // Custom domain serving the same backend as this host
+	ScaleToZero       bool               `json:"scale_to_zero,omitempty"`
+	IdleTimeout       string             `json:"idle_timeout,omitempty"` // Inactivity before a scale to zero app is stopped, e.g. 15m
+	ColdStart         *ColdStartPolicy   `json:"cold_start,omitempty"`
+	Rules             []RoutingRule      `json:"rules,omitempty"` // Send matching requests to alternate targets, first match wins
+	Mirror            *MirrorPolicy      `json:"mirror,omitempty"`
+	Probes            *HostProbes        `json:"probes,omitempty"`
+	ErrorPages        map[string]string  `json:"error_pages,omitempty"` // HTML templates by status code, 404, 502, 503 or 504. Templates see Status, StatusText, Host, Path, Message, RequestID and RetryAfter.
+	Streaming         bool               `json:"streaming,omitempty"`   // Flush responses to clients as they arrive, without the write timeout, for Server-Sent Events and long polling
+	Internal          bool               `json:"internal,omitempty"`    // Only serve clients whose address is on a private network, e.g. over a VPN. Others get 404.
+	Stopped           bool               `json:"stopped,omitempty"`     // The app was stopped on purpose and stays down until started or deployed
+	Healthy           bool               `json:"healthy,omitempty"`
+	LastHealthCheck   time.Time          `json:"last_health_check,omitempty"`
+	CrashLooping      bool               `json:"crash_looping,omitempty"` // The backend container keeps crashing
+	Sleeping          bool               `json:"sleeping,omitempty"`      // Scaled to zero, the next request starts the backend
+}
+
+// SwitchTargetRequest: Points a host at another backend
+type SwitchTargetRequest struct {
+	Target string `json:"target"` // Backend as container:port
+}
+
+// HealthUpdateRequest: Sets a host's health
+type HealthUpdateRequest struct {
+	Healthy bool `json:"healthy"`
+}
+
+// AppStoppedRequest: Stops or starts serving an app
+type AppStoppedRequest struct {
+	Stopped bool `json:"stopped"` // true while the app is stopped, false to route to it again once healthy
+}
+
+// HealthResult: One health check
+type HealthResult struct {
+	Time       time.Time `json:"time,omitempty"`
+	Healthy    bool      `json:"healthy,omitempty"`
+	LatencyMs  int64     `json:"latency_ms,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// HealthHistory: A host's recent health checks, oldest first
+type HealthHistory struct {
+	Results  []HealthResult `json:"results,omitempty"`
+	Flapping bool           `json:"flapping,omitempty"`
+}
+
+// DeploymentRecord: One deploy of a host
+type DeploymentRecord struct {
+	Time     time.Time         `json:"time,omitempty"`
+	Target   string            `json:"target,omitempty"`   // Backend as container:port
+	Metadata map[string]string `json:"metadata,omitempty"` // Attached by the client deploying
+}
+
+// TLSPolicy: TLS handshake settings. Unset fields fall back to the global policy and then to the defaults.
+type TLSPolicy struct {
+	MinVersion   string   `json:"min_version,omitempty"`
+	CipherSuites []string `json:"cipher_suites,omitempty"` // Go cipher suite names, only used up to TLS 1.2
+	ALPN         []string `json:"alpn,omitempty"`          // Protocols offered, e.g. ["http/1.1"] to disable HTTP/2
+}
+
+// HostLimits: Caps a host's resources, zero means unlimited
+type HostLimits struct {
+	MaxConcurrent int   `json:"max_concurrent,omitempty"` // In-flight requests before new ones get a 503
+	Bandwidth     int64 `json:"bandwidth,omitempty"`      // Response bytes per second across all requests
+}
+
+// RoutingRule: Sends the requests it matches to an alternate target, e.g. for an A/B test
+type RoutingRule struct {
+	Name    string `json:"name,omitempty"`    // Identifies the rule in logs
+	Header  string `json:"header,omitempty"`  // Request header to match, e.g. X-Beta
+	Cookie  string `json:"cookie,omitempty"`  // Cookie to match instead of a header
+	Value   string `json:"value,omitempty"`   // Required value of the header or cookie, empty matches any value
+	Percent int    `json:"percent,omitempty"` // Share of clients, who keep their bucket across requests
+	Target  string `json:"target"`            // Backend as container:port
+}
+
+// MirrorPolicy: Copies a share of a host's requests to a shadow target, whose responses are discarded
+type MirrorPolicy struct {
+	Target  string `json:"target,omitempty"`  // Backend as container:port
+	Percent int    `json:"percent,omitempty"` // Share of requests to copy
+}
+
+// ProbePolicy: Checks a host's app on its own path and schedule, acting after several consecutive failures
+type ProbePolicy struct {
+	Path             string `json:"path,omitempty"`              // Path to request, the host's health path by default
+	Interval         string `json:"interval,omitempty"`          // Time between checks such as 5s, 10s by default
+	FailureThreshold int    `json:"failure_threshold,omitempty"` // Consecutive failures before acting, 3 by default
+}
+
+// HostProbes: Replaces a host's regular health check. Readiness failures take the host out of rotation, liveness failures restart its app's containers.
+type HostProbes struct {
+	Readiness *ProbePolicy `json:"readiness,omitempty"`
+	Liveness  *ProbePolicy `json:"liveness,omitempty"`
+}
+
+// ColdStartPolicy: Bounds the requests held while a scaled to zero app starts
+type ColdStartPolicy struct {
+	MaxWait      string `json:"max_wait,omitempty"`      // Longest a request waits for the app, e.g. 30s
+	QueueDepth   int    `json:"queue_depth,omitempty"`   // Most requests waiting at once
+	StartingPage bool   `json:"starting_page,omitempty"` // Answer browsers with a page that reloads until the app is up
+	WarmPool     int    `json:"warm_pool,omitempty"`     // Containers paused instead of stopped when idle
+}
+
+// ScaleToZeroRequest: Enables or disables scaling a host's app to zero
+type ScaleToZeroRequest struct {
+	Enabled     bool             `json:"enabled"`
+	IdleTimeout string           `json:"idle_timeout,omitempty"` // e.g. 15m, empty for the default
+	ColdStart   *ColdStartPolicy `json:"cold_start,omitempty"`
+}
+
+// CertificateStatus: A host's certificate
+type CertificateStatus struct {
+	Status             string    `json:"status,omitempty"`
+	AcquiredAt         time.Time `json:"acquired_at,omitempty"`
+	ExpiresAt          time.Time `json:"expires_at,omitempty"`
+	LastRenewalAttempt time.Time `json:"last_renewal_attempt,omitempty"`
+	RenewalAttempts    int       `json:"renewal_attempts,omitempty"` // Renewals that failed in a row
+	RenewalError       string    `json:"renewal_error,omitempty"`    // Why the last renewal failed
+	NextRenewal        time.Time `json:"next_renewal,omitempty"`     // Retry time after a failed renewal
+	CertFile           string    `json:"cert_file,omitempty"`
+	KeyFile            string    `json:"key_file,omitempty"`
+	Names              []string  `json:"names,omitempty"` // Every hostname on the certificate when a certificate group shares it
+	FirstAttempt       time.Time `json:"first_attempt,omitempty"`
+	LastAttempt        time.Time `json:"last_attempt,omitempty"`
+	NextAttempt        time.Time `json:"next_attempt,omitempty"`
+	AttemptCount       int       `json:"attempt_count,omitempty"`
+	MaxAttempts        int       `json:"max_attempts,omitempty"`
+	LastError          string    `json:"last_error,omitempty"`
+	ErrorType          string    `json:"error_type,omitempty"`  // Why the last attempt failed: rate_limited, dns, caa, dnssec, authorization or other
+	SelfSigned         bool      `json:"self_signed,omitempty"` // Served a temporary self-signed certificate
+	Precheck           []string  `json:"precheck,omitempty"`    // DNS changes needed before the CA issues, reported for a single host without an active certificate
+}
+
+// StagingRequest: Switches between the Let's Encrypt staging and production CAs
+type StagingRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ACMERequest: Selects the certificate authority
+type ACMERequest struct {
+	DirectoryURL string `json:"directory_url,omitempty"` // Directory URL or a CA name such as zerossl
+	Email        string `json:"email,omitempty"`
+	EABKeyID     string `json:"eab_kid,omitempty"`      // External Account Binding key ID
+	EABHMACKey   string `json:"eab_hmac_key,omitempty"` // Base64url encoded EAB MAC key
+}
+
+// ACMEConfig: Certificate authority settings, with the EAB key redacted
+type ACMEConfig struct {
+	AccountKeyFile string `json:"account_key_file,omitempty"`
+	DirectoryURL   string `json:"directory_url,omitempty"`
+	Email          string `json:"email,omitempty"`
+	Staging        bool   `json:"staging,omitempty"`
+	EABKeyID       string `json:"eab_kid,omitempty"`
+	EABHMACKey     string `json:"eab_hmac_key,omitempty"`
+}
+
+// DefaultBackend: Backend for requests that match no host, empty for a 404
+type DefaultBackend struct {
+	Target string `json:"target,omitempty"`
+}
+
+// OnDemandTLS: Hostnames that get certificates on their first TLS handshake, empty turns it off
+type OnDemandTLS struct {
+	Allow     []string `json:"allow,omitempty"`      // Hostnames or *.example.com patterns
+	Ask       string   `json:"ask,omitempty"`        // URL asked to approve each hostname, GET <ask>?domain=<hostname> must answer 2xx
+	MaxHosts  int      `json:"max_hosts,omitempty"`  // Hosts held at once, defaults to 100
+	RateLimit int      `json:"rate_limit,omitempty"` // Acquisitions started per hour, defaults to 10
+}
+
+// DomainRequest: Registers a customer domain for a deployed host
+type DomainRequest struct {
+	Domain string `json:"domain"`
+	Host   string `json:"host"`
+}
+
+// DomainVerification: DNS records that verify a domain, either one is enough
+type DomainVerification struct {
+	TXTName     string `json:"txt_name,omitempty"`
+	TXTValue    string `json:"txt_value,omitempty"`
+	CNAMETarget string `json:"cname_target,omitempty"`
+}
+
+// DomainStatus: Customer domain with the records that verify it
+type DomainStatus struct {
+	Domain       string              `json:"domain,omitempty"`
+	Host         string              `json:"host,omitempty"`  // Deployed host whose backend serves the domain
+	Token        string              `json:"token,omitempty"` // Expected in the domain's verification TXT record
+	Status       string              `json:"status,omitempty"`
+	CreatedAt    time.Time           `json:"created_at,omitempty"`
+	VerifiedAt   time.Time           `json:"verified_at,omitempty"`
+	LastCheck    time.Time           `json:"last_check,omitempty"`
+	LastError    string              `json:"last_error,omitempty"` // Why the last verification failed
+	Verification *DomainVerification `json:"verification,omitempty"`
+}
+
+// PortForward: Forwards a port on the proxy host to a container
+type PortForward struct {
+	Listen   int      `json:"listen"`
+	Protocol string   `json:"protocol,omitempty"`
+	Target   string   `json:"target"` // container:port
+	Project  string   `json:"project,omitempty"`
+	Allow    []string `json:"allow,omitempty"` // Client IPs or CIDRs, empty allows everyone
+}
+
+// NotificationTarget: Slack, Discord or webhook receiving proxy events
+type NotificationTarget struct {
+	Type     string   `json:"type"`
+	URL      string   `json:"url"`
+	Events   []string `json:"events,omitempty"`   // Event names or categories, empty means all
+	Template string   `json:"template,omitempty"` // Go text/template for the message
+}
+
+// AuditEntry: A state-changing request
+type AuditEntry struct {
+	Time     time.Time `json:"time,omitempty"`
+	Action   string    `json:"action,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Details  string    `json:"details,omitempty"`
+	Actor    string    `json:"actor,omitempty"`
+	SourceIP string    `json:"source_ip,omitempty"`
+}
+
+// StatsSample: Resource usage of an app container
+type StatsSample struct {
+	Container        string    `json:"container,omitempty"`
+	Project          string    `json:"project,omitempty"`
+	App              string    `json:"app,omitempty"`
+	Time             time.Time `json:"time,omitempty"`
+	CPUPercent       float64   `json:"cpu_percent,omitempty"` // 100 is one full core
+	MemoryBytes      int64     `json:"memory_bytes,omitempty"`
+	MemoryLimitBytes int64     `json:"memory_limit_bytes,omitempty"`
+	NetworkRxBytes   int64     `json:"network_rx_bytes,omitempty"`
+	NetworkTxBytes   int64     `json:"network_tx_bytes,omitempty"`
+	DiskReadBytes    int64     `json:"disk_read_bytes,omitempty"`
+	DiskWriteBytes   int64     `json:"disk_write_bytes,omitempty"`
+	PIDs             int64     `json:"pids,omitempty"`
+}
+
+// AppStats: Requests an app's hosts served since the proxy started, with the current usage of its containers
+type AppStats struct {
+	Project          string   `json:"project,omitempty"`
+	App              string   `json:"app,omitempty"`
+	Hosts            []string `json:"hosts,omitempty"`
+	Requests         int64    `json:"requests,omitempty"`
+	Errors           int64    `json:"errors,omitempty"`          // Responses with a 5xx status
+	Latency          int64    `json:"latency,omitempty"`         // Total nanoseconds spent serving the requests
+	InFlight         int64    `json:"in_flight,omitempty"`       // Requests being proxied right now
+	LatencyBuckets   []int64  `json:"latency_buckets,omitempty"` // Requests per latency bucket, with upper bounds of 5, 10, 25, 50, 100, 250 and 500 milliseconds, 1, 2.5, 5 and 10 seconds, then the slower ones
+	Containers       int      `json:"containers,omitempty"`
+	CPUPercent       float64  `json:"cpu_percent,omitempty"` // Summed over the containers, 100 is one full core
+	MemoryBytes      int64    `json:"memory_bytes,omitempty"`
+	MemoryLimitBytes int64    `json:"memory_limit_bytes,omitempty"`
+}
+
+// AutoscalePolicy: Replica bounds and load targets of an app
+type AutoscalePolicy struct {
+	Project           string  `json:"project"`
+	App               string  `json:"app"`
+	MinReplicas       int     `json:"min_replicas,omitempty"`
+	MaxReplicas       int     `json:"max_replicas,omitempty"`
+	TargetCPU         float64 `json:"target_cpu,omitempty"`          // Average CPU percent per replica, 100 is one full core
+	TargetRequestRate float64 `json:"target_request_rate,omitempty"` // Requests per second per replica
+	TargetLatencyMs   int     `json:"target_latency_ms,omitempty"`   // Average response time across the app's hosts
+	TargetConcurrency float64 `json:"target_concurrency,omitempty"`  // Average requests in flight per replica
+	ScaleUpCooldown   string  `json:"scale_up_cooldown,omitempty"`   // Wait after scaling before adding replicas, e.g. 1m
+	ScaleDownCooldown string  `json:"scale_down_cooldown,omitempty"` // Wait after scaling before removing replicas, e.g. 5m
+}
+
+// AutoscaleStatus: An app's last measured load
+type AutoscaleStatus struct {
+	Project     string    `json:"project,omitempty"`
+	App         string    `json:"app,omitempty"`
+	Replicas    int       `json:"replicas,omitempty"`
+	CPUPercent  float64   `json:"cpu_percent,omitempty"`  // Average per replica
+	RequestRate float64   `json:"request_rate,omitempty"` // Requests per second per replica
+	LatencyMs   float64   `json:"latency_ms,omitempty"`   // Average response time
+	Concurrency float64   `json:"concurrency,omitempty"`  // Average requests in flight per replica
+	InFlight    int64     `json:"in_flight,omitempty"`    // Requests in flight when measured
+	LastScaled  time.Time `json:"last_scaled,omitempty"`
+	LastReason  string    `json:"last_reason,omitempty"`
+}
+
+// AutoscaleEntry: An autoscaling policy with its app's last measured load
+type AutoscaleEntry struct {
+	Project           string           `json:"project,omitempty"`
+	App               string           `json:"app,omitempty"`
+	MinReplicas       int              `json:"min_replicas,omitempty"`
+	MaxReplicas       int              `json:"max_replicas,omitempty"`
+	TargetCPU         float64          `json:"target_cpu,omitempty"`          // Average CPU percent per replica, 100 is one full core
+	TargetRequestRate float64          `json:"target_request_rate,omitempty"` // Requests per second per replica
+	TargetLatencyMs   int              `json:"target_latency_ms,omitempty"`   // Average response time across the app's hosts
+	TargetConcurrency float64          `json:"target_concurrency,omitempty"`  // Average requests in flight per replica
+	ScaleUpCooldown   string           `json:"scale_up_cooldown,omitempty"`   // Wait after scaling before adding replicas, e.g. 1m
+	ScaleDownCooldown string           `json:"scale_down_cooldown,omitempty"` // Wait after scaling before removing replicas, e.g. 5m
+	Status            *AutoscaleStatus `json:"status,omitempty"`
+}
+
+// Archive: The whole state and the files it references
+type Archive struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at,omitempty"`
+	State     json.RawMessage   `json:"state"`           // The state file
+	Files     map[string][]byte `json:"files,omitempty"` // File contents by absolute path
+}
+
+// UserRequest: Adds an API user
+type UserRequest struct {
+	Name     string   `json:"name"`
+	Role     string   `json:"role"`
+	Projects []string `json:"projects,omitempty"` // Limits the token to these projects' hosts, certificates and deployments. Empty for every project and the server-wide settings.
+}
+
+// User: Holder of an API token
+type User struct {
+	Name      string    `json:"name,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Projects  []string  `json:"projects,omitempty"`   // Limits the token to these projects' hosts, certificates and deployments. Empty for every project and the server-wide settings.
+	TokenHash string    `json:"token_hash,omitempty"` // Always empty in responses
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// UserCreated: A new user with their token, which is only shown once
+type UserCreated struct {
+	Name      string    `json:"name,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Projects  []string  `json:"projects,omitempty"`   // Limits the token to these projects' hosts, certificates and deployments. Empty for every project and the server-wide settings.
+	TokenHash string    `json:"token_hash,omitempty"` // Always empty in responses
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	Token     string    `json:"token,omitempty"`
+}
+
+type CertRevokeRequest struct {
+	Reason string `json:"reason,omitempty"` // Why the certificate is revoked, key_compromise by default
+}
+
+// CertGroups: Projects whose hosts share one certificate, empty gives every host its own
+type CertGroups struct {
+	Projects []string `json:"projects,omitempty"` // Project names
+}
+
+// ExpiringCertificate: An active certificate close to its expiry
+type ExpiringCertificate struct {
+	Hostname        string    `json:"hostname"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	DaysLeft        int       `json:"days_left"`                  // Whole days until expiry, negative once expired
+	RenewalAttempts int       `json:"renewal_attempts,omitempty"` // Renewals that failed in a row
+	RenewalError    string    `json:"renewal_error,omitempty"`    // Why the last renewal failed
+}
+
+// NetworkAttachment: Docker network the proxy container is attached to, or should be
+type NetworkAttachment struct {
+	Network   string   `json:"network"`
+	Projects  []string `json:"projects,omitempty"` // Routed projects using the network
+	Connected bool     `json:"connected"`
+	Managed   bool     `json:"managed"` // Detached once its projects have no hosts
+	Pinned    bool     `json:"pinned"`  // Attached by hand, kept until disconnected
+}
+
+type DeploymentStartRequest struct {
+	Project  string            `json:"project"`
+	App      string            `json:"app"`
+	Host     string            `json:"host,omitempty"`     // Host the app is served as, if any
+	Metadata map[string]string `json:"metadata,omitempty"` // e.g. sha, branch, actor and ci_url
+}
+
+// DeploymentAttempt: One try of a step that retries, e.g. a health check
+type DeploymentAttempt struct {
+	Time   time.Time `json:"time,omitempty"`
+	Result string    `json:"result,omitempty"` // e.g. the status code a health check got
+	Error  string    `json:"error,omitempty"`
+}
+
+type DeploymentStep struct {
+	Name       string              `json:"name"`
+	Status     string              `json:"status"`
+	StartedAt  time.Time           `json:"started_at,omitempty"`
+	FinishedAt time.Time           `json:"finished_at,omitempty"`
+	Message    string              `json:"message,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	Attempts   []DeploymentAttempt `json:"attempts,omitempty"`
+}
+
+// DeploymentTimeline: Step by step progress of a deployment
+type DeploymentTimeline struct {
+	ID         string            `json:"id"`
+	Project    string            `json:"project"`
+	App        string            `json:"app"`
+	Host       string            `json:"host,omitempty"`
+	Status     string            `json:"status"`                // Failed once a step failed, succeeded once every step succeeded or was skipped
+	FailedStep string            `json:"failed_step,omitempty"` // The step that broke the deployment
+	CreatedAt  time.Time         `json:"created_at,omitempty"`
+	UpdatedAt  time.Time         `json:"updated_at,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Steps      []DeploymentStep  `json:"steps"`
+}
+
+type DeploymentStepUpdate struct {
+	Step    string             `json:"step"`
+	Status  string             `json:"status,omitempty"` // Omit to only record an attempt
+	Message string             `json:"message,omitempty"`
+	Error   string             `json:"error,omitempty"`
+	Attempt *DeploymentAttempt `json:"attempt,omitempty"`
+}
+
+// DiagnosticCheck: Result of one diagnostic
+type DiagnosticCheck struct {
+	Name   string `json:"name"` // ports, docker, acme, disk or clock
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// DiagnosticsReport: Result of all diagnostics
+type DiagnosticsReport struct {
+	Status    string            `json:"status"` // Status of the worst check
+	Checks    []DiagnosticCheck `json:"checks"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+type CertPromoteRequest struct {
+	Hosts []string `json:"hosts,omitempty"` // Hosts to promote, every host when empty
+}
+
+// PromotionStep: Outcome of one step of a promotion
+type PromotionStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// Promotion: A host's way from a staging to a production certificate
+type Promotion struct {
+	Hostname string          `json:"hostname"`
+	Promoted bool            `json:"promoted"` // Serves a production certificate now
+	Steps    []PromotionStep `json:"steps"`
+}
+
+// ServiceRecord: A service resolvable as <name>.internal and <name>.<project>.internal, by its container on this server or the address of another server
+type ServiceRecord struct {
+	Project   string `json:"project,omitempty"`
+	Name      string `json:"name"`
+	Address   string `json:"address,omitempty"`   // IPv4 address of another server running the service, e.g. its mesh address
+	Container string `json:"container,omitempty"` // Container on this server, resolved on each query
+}
+
+// DiscoveryRequest: All service records of a project
+type DiscoveryRequest struct {
+	Project string          `json:"project"`
+	Records []ServiceRecord `json:"records"`
+}
+
+// Quota: What a project may run on this server. Zero leaves a resource uncapped.
+type Quota struct {
+	Project       string  `json:"project"`
+	MaxContainers int     `json:"max_containers,omitempty"`
+	MaxMemory     int64   `json:"max_memory,omitempty"` // Bytes, the sum of the containers' memory limits
+	MaxCpus       float64 `json:"max_cpus,omitempty"`   // Cores, the sum of the containers' CPU limits
+}
+
+// QuotaUsage: A project's running containers and the sum of their limits
+type QuotaUsage struct {
+	Project    string  `json:"project"`
+	Containers int     `json:"containers"`
+	Memory     int64   `json:"memory"` // Bytes
+	Cpus       float64 `json:"cpus"`
+	Unlimited  int     `json:"unlimited,omitempty"` // Containers without a memory or CPU limit, which count as using none
+}
+
+// QuotaEntry: A project's quota, if it has one, with what it currently runs
+type QuotaEntry struct {
+	Project string      `json:"project"`
+	Quota   *Quota      `json:"quota,omitempty"`
+	Usage   *QuotaUsage `json:"usage,omitempty"`
+}
+
+// QuotaContainer: Limits of one container of a deploy, zero or unset for none
+type QuotaContainer struct {
+	Memory int64   `json:"memory,omitempty"` // Bytes
+	Cpus   float64 `json:"cpus,omitempty"`
+}
+
+// QuotaWorkload: The containers a deploy runs for one service, including sidecars
+type QuotaWorkload struct {
+	Service    string           `json:"service"`
+	Containers []QuotaContainer `json:"containers"`
+}
+
+// QuotaCheckRequest: The services a deploy replaces and the containers it runs for them
+type QuotaCheckRequest struct {
+	Project   string          `json:"project"`
+	Workloads []QuotaWorkload `json:"workloads"`
+}
+
+// UptimeCheck: One check of a host from outside
+type UptimeCheck struct {
+	Time       time.Time `json:"time,omitempty"`
+	Up         bool      `json:"up,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	LatencyMs  int64     `json:"latency_ms,omitempty"` // Until the response headers arrived, including DNS, connecting and TLS
+	TLSMs      int64     `json:"tls_ms,omitempty"`     // TLS handshake
+	Error      string    `json:"error,omitempty"`
+}
+
+// UptimeBucket: The checks of an hour or a day
+type UptimeBucket struct {
+	Start     time.Time `json:"start,omitempty"`
+	Checks    int       `json:"checks,omitempty"`
+	Up        int       `json:"up,omitempty"`
+	LatencyMs int64     `json:"latency_ms,omitempty"` // Summed over the checks that were up
+}
+
+// UptimeReport: A host's uptime. Percentages are null for periods without checks.
+type UptimeReport struct {
+	Host         string         `json:"host"`
+	Project      string         `json:"project"`
+	App          string         `json:"app,omitempty"`
+	Up           bool           `json:"up,omitempty"`
+	LastCheck    *UptimeCheck   `json:"last_check,omitempty"`
+	DownSince    time.Time      `json:"down_since,omitempty"`
+	Uptime24h    float64        `json:"uptime_24h,omitempty"`     // Percentage of checks that were up in the last 24 hours
+	Uptime7d     float64        `json:"uptime_7d,omitempty"`      // Percentage of checks that were up in the last 7 days
+	Uptime30d    float64        `json:"uptime_30d,omitempty"`     // Percentage of checks that were up in the last 30 days
+	Uptime90d    float64        `json:"uptime_90d,omitempty"`     // Percentage of checks that were up in the last 90 days
+	AvgLatencyMs int64          `json:"avg_latency_ms,omitempty"` // Over the last 24 hours
+	Daily        []UptimeBucket `json:"daily,omitempty"`          // Daily totals, oldest first, only for a single host
+	Recent       []UptimeCheck  `json:"recent,omitempty"`         // Checks of the last hour, oldest first, only for a single host
+}
+
+// IncidentUpdate: A message posted on an incident
+type IncidentUpdate struct {
+	Time    time.Time `json:"time,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Incident: An annotation on a status page about an outage
+type Incident struct {
+	ID         string           `json:"id,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Status     string           `json:"status,omitempty"`  // Of the latest update
+	Apps       []string         `json:"apps,omitempty"`    // Affected apps, shown as degraded until it is resolved. Empty affects every app.
+	Updates    []IncidentUpdate `json:"updates,omitempty"` // Oldest first
+	CreatedAt  time.Time        `json:"created_at,omitempty"`
+	ResolvedAt time.Time        `json:"resolved_at,omitempty"`
+}
+
+// IncidentEntry: An incident with the project whose status page it is on
+type IncidentEntry struct {
+	Project    string           `json:"project,omitempty"`
+	ID         string           `json:"id,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Status     string           `json:"status,omitempty"`  // Of the latest update
+	Apps       []string         `json:"apps,omitempty"`    // Affected apps, shown as degraded until it is resolved. Empty affects every app.
+	Updates    []IncidentUpdate `json:"updates,omitempty"` // Oldest first
+	CreatedAt  time.Time        `json:"created_at,omitempty"`
+	ResolvedAt time.Time        `json:"resolved_at,omitempty"`
+}
+
+// StatusPage: A public page showing how a project's apps are doing, served by the proxy at its host
+type StatusPage struct {
+	Project   string     `json:"project"`
+	Host      string     `json:"host"`                // Hostname the page is served at, deployed as a host of the project
+	Title     string     `json:"title,omitempty"`     // Defaults to the project name
+	Apps      []string   `json:"apps,omitempty"`      // Apps shown, every app with a host when empty
+	SSL       bool       `json:"ssl,omitempty"`       // Serve the page over HTTPS with a certificate for the host
+	Incidents []Incident `json:"incidents,omitempty"` // Newest first, ignored when setting the page
+}
+
+type OpenIncidentRequest struct {
+	Project string   `json:"project"`
+	Title   string   `json:"title"`
+	Status  string   `json:"status,omitempty"` // Defaults to investigating
+	Message string   `json:"message,omitempty"`
+	Apps    []string `json:"apps,omitempty"` // Affected apps, every app when empty
+}
+
+type IncidentUpdateRequest struct {
+	Status  string `json:"status"` // resolved closes the incident
+	Message string `json:"message,omitempty"`
+}
+
+// GetACME gets the certificate authority
+//
+// GET /api/acme
+func (c *Client) GetACME(ctx context.Context, opts ...RequestOption) (*ACMEConfig, *Response, error) {
+	var data *ACMEConfig
+	resp, err := c.do(ctx, "GET", "/api/acme", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetACME switches the certificate authority
+//
+// PUT /api/acme
+func (c *Client) SetACME(ctx context.Context, body *ACMERequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/acme", nil, body, nil, opts)
+}
+
+// ApplyHosts replaces all hosts with a desired set
+//
+// POST /api/apply
+func (c *Client) ApplyHosts(ctx context.Context, body *ApplyRequest, opts ...RequestOption) (*ApplyResult, *Response, error) {
+	var data *ApplyResult
+	resp, err := c.do(ctx, "POST", "/api/apply", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetAppStopped stops or starts serving an app's hosts
+//
+// PUT /api/apps/{project}/{app}/stopped
+func (c *Client) SetAppStopped(ctx context.Context, project string, app string, body *AppStoppedRequest, opts ...RequestOption) ([]string, *Response, error) {
+	var data []string
+	resp, err := c.do(ctx, "PUT", "/api/apps/"+url.PathEscape(project)+"/"+url.PathEscape(app)+"/stopped", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ListAuditEntriesParams are the query parameters of GET /api/audit
+type ListAuditEntriesParams struct {
+	Host   string // Only entries for this host
+	Action string // Only this action, e.g. deploy
+	Since  string // A duration such as 24h or an RFC 3339 time
+	Limit  int    // Most entries returned, defaults to 100
+}
+
+// ListAuditEntries reads the audit log
+//
+// GET /api/audit
+func (c *Client) ListAuditEntries(ctx context.Context, params *ListAuditEntriesParams, opts ...RequestOption) ([]AuditEntry, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Host != "" {
+			query.Set("host", params.Host)
+		}
+		if params.Action != "" {
+			query.Set("action", params.Action)
+		}
+		if params.Since != "" {
+			query.Set("since", params.Since)
+		}
+		if params.Limit != 0 {
+			query.Set("limit", strconv.Itoa(params.Limit))
+		}
+	}
+	var data []AuditEntry
+	resp, err := c.do(ctx, "GET", "/api/audit", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// RemoveAutoscalePolicyParams are the query parameters of DELETE /api/autoscale
+type RemoveAutoscalePolicyParams struct {
+	Project string // Project of the app
+	App     string // App name
+}
+
+// RemoveAutoscalePolicy stops autoscaling an app
+//
+// DELETE /api/autoscale
+func (c *Client) RemoveAutoscalePolicy(ctx context.Context, params *RemoveAutoscalePolicyParams, opts ...RequestOption) (*Response, error) {
+	query := url.Values{}
+	if params != nil {
+		query.Set("project", params.Project)
+		query.Set("app", params.App)
+	}
+	return c.do(ctx, "DELETE", "/api/autoscale", query, nil, nil, opts)
+}
+
+// ListAutoscale lists autoscaling policies with each app's load
+//
+// GET /api/autoscale
+func (c *Client) ListAutoscale(ctx context.Context, opts ...RequestOption) ([]AutoscaleEntry, *Response, error) {
+	var data []AutoscaleEntry
+	resp, err := c.do(ctx, "GET", "/api/autoscale", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetAutoscalePolicy adds or replaces an app's autoscaling policy
+//
+// PUT /api/autoscale
+func (c *Client) SetAutoscalePolicy(ctx context.Context, body *AutoscalePolicy, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/autoscale", nil, body, nil, opts)
+}
+
+// GetCertGroups gets the projects whose hosts share one certificate
+//
+// GET /api/cert/groups
+func (c *Client) GetCertGroups(ctx context.Context, opts ...RequestOption) (*CertGroups, *Response, error) {
+	var data *CertGroups
+	resp, err := c.do(ctx, "GET", "/api/cert/groups", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetCertGroups sets the projects whose hosts share one certificate
+//
+// PUT /api/cert/groups
+func (c *Client) SetCertGroups(ctx context.Context, body *CertGroups, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/cert/groups", nil, body, nil, opts)
+}
+
+// PrecheckCertificateParams are the query parameters of GET /api/cert/precheck
+type PrecheckCertificateParams struct {
+	Host string // Hostname to check
+}
+
+// PrecheckCertificate checks DNS before a certificate is ordered
+//
+// GET /api/cert/precheck
+func (c *Client) PrecheckCertificate(ctx context.Context, params *PrecheckCertificateParams, opts ...RequestOption) ([]string, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		query.Set("host", params.Host)
+	}
+	var data []string
+	resp, err := c.do(ctx, "GET", "/api/cert/precheck", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// PromoteCertificates promotes hosts from staging to production certificates
+//
+// POST /api/cert/promote
+func (c *Client) PromoteCertificates(ctx context.Context, body *CertPromoteRequest, opts ...RequestOption) ([]Promotion, *Response, error) {
+	var data []Promotion
+	resp, err := c.do(ctx, "POST", "/api/cert/promote", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// RenewCertificate renews a host's certificate now
+//
+// POST /api/cert/renew/{host}
+func (c *Client) RenewCertificate(ctx context.Context, host string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "POST", "/api/cert/renew/"+url.PathEscape(host), nil, nil, nil, opts)
+}
+
+// RevokeCertificate revokes a host's certificate with the CA and acquire a new one
+//
+// POST /api/cert/revoke/{host}
+func (c *Client) RevokeCertificate(ctx context.Context, host string, body *CertRevokeRequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "POST", "/api/cert/revoke/"+url.PathEscape(host), nil, body, nil, opts)
+}
+
+// GetExpiringCertificatesParams are the query parameters of GET /api/certs/expiring
+type GetExpiringCertificatesParams struct {
+	Within string // A duration such as 14d or 72h, defaults to 14d
+}
+
+// GetExpiringCertificates lists certificates close to expiry
+//
+// GET /api/certs/expiring
+func (c *Client) GetExpiringCertificates(ctx context.Context, params *GetExpiringCertificatesParams, opts ...RequestOption) ([]ExpiringCertificate, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Within != "" {
+			query.Set("within", params.Within)
+		}
+	}
+	var data []ExpiringCertificate
+	resp, err := c.do(ctx, "GET", "/api/certs/expiring", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// PruneCertificates deletes certificate files no host uses
+//
+// DELETE /api/certs/unreferenced
+func (c *Client) PruneCertificates(ctx context.Context, opts ...RequestOption) ([]string, *Response, error) {
+	var data []string
+	resp, err := c.do(ctx, "DELETE", "/api/certs/unreferenced", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ListUnreferencedCertificates lists certificate files no host uses
+//
+// GET /api/certs/unreferenced
+func (c *Client) ListUnreferencedCertificates(ctx context.Context, opts ...RequestOption) ([]string, *Response, error) {
+	var data []string
+	resp, err := c.do(ctx, "GET", "/api/certs/unreferenced", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// GetDefaultBackend gets the backend for unknown hosts
+//
+// GET /api/default-backend
+func (c *Client) GetDefaultBackend(ctx context.Context, opts ...RequestOption) (*DefaultBackend, *Response, error) {
+	var data *DefaultBackend
+	resp, err := c.do(ctx, "GET", "/api/default-backend", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetDefaultBackend sets the backend for unknown hosts, empty removes it
+//
+// PUT /api/default-backend
+func (c *Client) SetDefaultBackend(ctx context.Context, body *DefaultBackend, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/default-backend", nil, body, nil, opts)
+}
+
+// DeployHost deploys a host
+//
+// POST /api/deploy
+func (c *Client) DeployHost(ctx context.Context, body *DeployRequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "POST", "/api/deploy", nil, body, nil, opts)
+}
+
+// StartDeployment starts the timeline of a deployment
+//
+// POST /api/deployments
+func (c *Client) StartDeployment(ctx context.Context, body *DeploymentStartRequest, opts ...RequestOption) (*DeploymentTimeline, *Response, error) {
+	var data *DeploymentTimeline
+	resp, err := c.do(ctx, "POST", "/api/deployments", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// GetDeployment gets a deployment's step progress
+//
+// GET /api/deployments/{id}
+func (c *Client) GetDeployment(ctx context.Context, id string, opts ...RequestOption) (*DeploymentTimeline, *Response, error) {
+	var data *DeploymentTimeline
+	resp, err := c.do(ctx, "GET", "/api/deployments/"+url.PathEscape(id), nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// UpdateDeploymentStep reports progress of a deployment step
+//
+// POST /api/deployments/{id}/steps
+func (c *Client) UpdateDeploymentStep(ctx context.Context, id string, body *DeploymentStepUpdate, opts ...RequestOption) (*DeploymentTimeline, *Response, error) {
+	var data *DeploymentTimeline
+	resp, err := c.do(ctx, "POST", "/api/deployments/"+url.PathEscape(id)+"/steps", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// GetDiagnostics runs self-diagnostics
+//
+// GET /api/diagnostics
+func (c *Client) GetDiagnostics(ctx context.Context, opts ...RequestOption) (*DiagnosticsReport, *Response, error) {
+	var data *DiagnosticsReport
+	resp, err := c.do(ctx, "GET", "/api/diagnostics", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ListServiceRecords lists the service records the DNS server answers
+//
+// GET /api/discovery
+func (c *Client) ListServiceRecords(ctx context.Context, opts ...RequestOption) ([]ServiceRecord, *Response, error) {
+	var data []ServiceRecord
+	resp, err := c.do(ctx, "GET", "/api/discovery", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetServiceRecords replaces the service records of a project
+//
+// PUT /api/discovery
+func (c *Client) SetServiceRecords(ctx context.Context, body *DiscoveryRequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/discovery", nil, body, nil, opts)
+}
+
+// ListDomains lists customer domains
+//
+// GET /api/domains
+func (c *Client) ListDomains(ctx context.Context, opts ...RequestOption) ([]DomainStatus, *Response, error) {
+	var data []DomainStatus
+	resp, err := c.do(ctx, "GET", "/api/domains", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// AddDomain registers a customer domain
+//
+// POST /api/domains
+func (c *Client) AddDomain(ctx context.Context, body *DomainRequest, opts ...RequestOption) (*DomainStatus, *Response, error) {
+	var data *DomainStatus
+	resp, err := c.do(ctx, "POST", "/api/domains", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// RemoveDomain removes a customer domain
+//
+// DELETE /api/domains/{domain}
+func (c *Client) RemoveDomain(ctx context.Context, domain string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "DELETE", "/api/domains/"+url.PathEscape(domain), nil, nil, nil, opts)
+}
+
+// GetDomain gets a customer domain
+//
+// GET /api/domains/{domain}
+func (c *Client) GetDomain(ctx context.Context, domain string, opts ...RequestOption) (*DomainStatus, *Response, error) {
+	var data *DomainStatus
+	resp, err := c.do(ctx, "GET", "/api/domains/"+url.PathEscape(domain), nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// VerifyDomain checks a customer domain's DNS now
+//
+// POST /api/domains/{domain}/verify
+func (c *Client) VerifyDomain(ctx context.Context, domain string, opts ...RequestOption) (*DomainStatus, *Response, error) {
+	var data *DomainStatus
+	resp, err := c.do(ctx, "POST", "/api/domains/"+url.PathEscape(domain)+"/verify", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// GetErrorPages gets the error page templates of hosts without their own
+//
+// GET /api/error-pages
+func (c *Client) GetErrorPages(ctx context.Context, opts ...RequestOption) (map[string]string, *Response, error) {
+	var data map[string]string
+	resp, err := c.do(ctx, "GET", "/api/error-pages", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetErrorPages sets the error page templates of hosts without their own, empty restores plain text
+//
+// PUT /api/error-pages
+func (c *Client) SetErrorPages(ctx context.Context, body map[string]string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/error-pages", nil, body, nil, opts)
+}
+
+// ExportState exports the state and certificates
+//
+// GET /api/export
+func (c *Client) ExportState(ctx context.Context, opts ...RequestOption) (*Archive, *Response, error) {
+	var data *Archive
+	resp, err := c.do(ctx, "GET", "/api/export", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ListHosts lists hosts
+//
+// GET /api/hosts
+func (c *Client) ListHosts(ctx context.Context, opts ...RequestOption) (map[string]HostStatus, *Response, error) {
+	var data map[string]HostStatus
+	resp, err := c.do(ctx, "GET", "/api/hosts", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// RemoveHost removes a host
+//
+// DELETE /api/hosts/{host}
+func (c *Client) RemoveHost(ctx context.Context, host string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "DELETE", "/api/hosts/"+url.PathEscape(host), nil, nil, nil, opts)
+}
+
+// GetHost gets a host with its ETag
+//
+// GET /api/hosts/{host}
+func (c *Client) GetHost(ctx context.Context, host string, opts ...RequestOption) (*HostStatus, *Response, error) {
+	var data *HostStatus
+	resp, err := c.do(ctx, "GET", "/api/hosts/"+url.PathEscape(host), nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SwitchTarget points a host at another backend
+//
+// PATCH /api/hosts/{host}
+func (c *Client) SwitchTarget(ctx context.Context, host string, body *SwitchTargetRequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PATCH", "/api/hosts/"+url.PathEscape(host), nil, body, nil, opts)
+}
+
+// PutHost creates or replaces a host
+//
+// PUT /api/hosts/{host}
+func (c *Client) PutHost(ctx context.Context, host string, body *HostPutRequest, opts ...RequestOption) (*HostStatus, *Response, error) {
+	var data *HostStatus
+	resp, err := c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host), nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ListDeployments lists a host's recent deployments
+//
+// GET /api/hosts/{host}/deployments
+func (c *Client) ListDeployments(ctx context.Context, host string, opts ...RequestOption) ([]DeploymentRecord, *Response, error) {
+	var data []DeploymentRecord
+	resp, err := c.do(ctx, "GET", "/api/hosts/"+url.PathEscape(host)+"/deployments", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetHostErrorPages sets a host's error page templates by status, an empty object uses the global ones
+//
+// PUT /api/hosts/{host}/error-pages
+func (c *Client) SetHostErrorPages(ctx context.Context, host string, body map[string]string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/error-pages", nil, body, nil, opts)
+}
+
+// UpdateHealth sets a host's health
+//
+// PUT /api/hosts/{host}/health
+func (c *Client) UpdateHealth(ctx context.Context, host string, body *HealthUpdateRequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/health", nil, body, nil, opts)
+}
+
+// GetHealthHistory gets a host's recent health checks
+//
+// GET /api/hosts/{host}/health-history
+func (c *Client) GetHealthHistory(ctx context.Context, host string, opts ...RequestOption) (*HealthHistory, *Response, error) {
+	var data *HealthHistory
+	resp, err := c.do(ctx, "GET", "/api/hosts/"+url.PathEscape(host)+"/health-history", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetHostLimits sets a host's limits, empty removes them
+//
+// PUT /api/hosts/{host}/limits
+func (c *Client) SetHostLimits(ctx context.Context, host string, body *HostLimits, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/limits", nil, body, nil, opts)
+}
+
+// SetHostMirror sets a host's shadow target, an empty target stops mirroring
+//
+// PUT /api/hosts/{host}/mirror
+func (c *Client) SetHostMirror(ctx context.Context, host string, body *MirrorPolicy, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/mirror", nil, body, nil, opts)
+}
+
+// SetHostProbes sets a host's readiness and liveness probes, an empty object goes back to the regular health check
+//
+// PUT /api/hosts/{host}/probes
+func (c *Client) SetHostProbes(ctx context.Context, host string, body *HostProbes, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/probes", nil, body, nil, opts)
+}
+
+// SetHostRules replaces a host's routing rules, empty removes them
+//
+// PUT /api/hosts/{host}/rules
+func (c *Client) SetHostRules(ctx context.Context, host string, body []RoutingRule, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/rules", nil, body, nil, opts)
+}
+
+// SetScaleToZero enables or disables scaling a host's app to zero
+//
+// PUT /api/hosts/{host}/scale-to-zero
+func (c *Client) SetScaleToZero(ctx context.Context, host string, body *ScaleToZeroRequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/scale-to-zero", nil, body, nil, opts)
+}
+
+// SetHostTLSPolicy sets a host's TLS policy, empty restores the defaults
+//
+// PUT /api/hosts/{host}/tls
+func (c *Client) SetHostTLSPolicy(ctx context.Context, host string, body *TLSPolicy, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/hosts/"+url.PathEscape(host)+"/tls", nil, body, nil, opts)
+}
+
+// GetHostUptime gets a host's uptime with its daily totals and recent checks
+//
+// GET /api/hosts/{host}/uptime
+func (c *Client) GetHostUptime(ctx context.Context, host string, opts ...RequestOption) (*UptimeReport, *Response, error) {
+	var data *UptimeReport
+	resp, err := c.do(ctx, "GET", "/api/hosts/"+url.PathEscape(host)+"/uptime", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ImportState replaces the state and certificates with an archive
+//
+// POST /api/import
+func (c *Client) ImportState(ctx context.Context, body *Archive, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "POST", "/api/import", nil, body, nil, opts)
+}
+
+// ListIncidentsParams are the query parameters of GET /api/incidents
+type ListIncidentsParams struct {
+	Project string // Only this project
+}
+
+// ListIncidents lists the incidents of the status pages, newest first
+//
+// GET /api/incidents
+func (c *Client) ListIncidents(ctx context.Context, params *ListIncidentsParams, opts ...RequestOption) ([]IncidentEntry, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Project != "" {
+			query.Set("project", params.Project)
+		}
+	}
+	var data []IncidentEntry
+	resp, err := c.do(ctx, "GET", "/api/incidents", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// OpenIncident opens an incident on a project's status page
+//
+// POST /api/incidents
+func (c *Client) OpenIncident(ctx context.Context, body *OpenIncidentRequest, opts ...RequestOption) (*IncidentEntry, *Response, error) {
+	var data *IncidentEntry
+	resp, err := c.do(ctx, "POST", "/api/incidents", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// RemoveIncident removes an incident, e.g. one opened by mistake
+//
+// DELETE /api/incidents/{id}
+func (c *Client) RemoveIncident(ctx context.Context, id string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "DELETE", "/api/incidents/"+url.PathEscape(id), nil, nil, nil, opts)
+}
+
+// UpdateIncident posts an update on an incident
+//
+// POST /api/incidents/{id}/updates
+func (c *Client) UpdateIncident(ctx context.Context, id string, body *IncidentUpdateRequest, opts ...RequestOption) (*IncidentEntry, *Response, error) {
+	var data *IncidentEntry
+	resp, err := c.do(ctx, "POST", "/api/incidents/"+url.PathEscape(id)+"/updates", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// EncryptKeys encrypts private keys stored before a key passphrase was set
+//
+// POST /api/keys/encrypt
+func (c *Client) EncryptKeys(ctx context.Context, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "POST", "/api/keys/encrypt", nil, nil, nil, opts)
+}
+
+// ListNetworks lists the proxy container's networks
+//
+// GET /api/networks
+func (c *Client) ListNetworks(ctx context.Context, opts ...RequestOption) ([]NetworkAttachment, *Response, error) {
+	var data []NetworkAttachment
+	resp, err := c.do(ctx, "GET", "/api/networks", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ConnectNetwork attaches the proxy container to a network, kept until disconnected
+//
+// POST /api/networks/{network}/connect
+func (c *Client) ConnectNetwork(ctx context.Context, network string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "POST", "/api/networks/"+url.PathEscape(network)+"/connect", nil, nil, nil, opts)
+}
+
+// DisconnectNetwork detaches the proxy container from a network no routed project uses
+//
+// POST /api/networks/{network}/disconnect
+func (c *Client) DisconnectNetwork(ctx context.Context, network string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "POST", "/api/networks/"+url.PathEscape(network)+"/disconnect", nil, nil, nil, opts)
+}
+
+// ListNotifications lists notification targets
+//
+// GET /api/notifications
+func (c *Client) ListNotifications(ctx context.Context, opts ...RequestOption) ([]NotificationTarget, *Response, error) {
+	var data []NotificationTarget
+	resp, err := c.do(ctx, "GET", "/api/notifications", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetNotifications replaces the notification targets
+//
+// PUT /api/notifications
+func (c *Client) SetNotifications(ctx context.Context, body []NotificationTarget, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/notifications", nil, body, nil, opts)
+}
+
+// GetOnDemandTLS gets the on-demand TLS allowlist
+//
+// GET /api/on-demand-tls
+func (c *Client) GetOnDemandTLS(ctx context.Context, opts ...RequestOption) (*OnDemandTLS, *Response, error) {
+	var data *OnDemandTLS
+	resp, err := c.do(ctx, "GET", "/api/on-demand-tls", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetOnDemandTLS sets the on-demand TLS allowlist
+//
+// PUT /api/on-demand-tls
+func (c *Client) SetOnDemandTLS(ctx context.Context, body *OnDemandTLS, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/on-demand-tls", nil, body, nil, opts)
+}
+
+// RemovePortForwardParams are the query parameters of DELETE /api/ports
+type RemovePortForwardParams struct {
+	Listen   int    // Listening port
+	Protocol string // Defaults to tcp
+}
+
+// RemovePortForward removes a port forwarding rule
+//
+// DELETE /api/ports
+func (c *Client) RemovePortForward(ctx context.Context, params *RemovePortForwardParams, opts ...RequestOption) (*Response, error) {
+	query := url.Values{}
+	if params != nil {
+		query.Set("listen", strconv.Itoa(params.Listen))
+		if params.Protocol != "" {
+			query.Set("protocol", params.Protocol)
+		}
+	}
+	return c.do(ctx, "DELETE", "/api/ports", query, nil, nil, opts)
+}
+
+// ListPortForwards lists port forwarding rules
+//
+// GET /api/ports
+func (c *Client) ListPortForwards(ctx context.Context, opts ...RequestOption) ([]PortForward, *Response, error) {
+	var data []PortForward
+	resp, err := c.do(ctx, "GET", "/api/ports", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetPortForward adds or replaces a port forwarding rule
+//
+// PUT /api/ports
+func (c *Client) SetPortForward(ctx context.Context, body *PortForward, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/ports", nil, body, nil, opts)
+}
+
+// RemoveQuotaParams are the query parameters of DELETE /api/quotas
+type RemoveQuotaParams struct {
+	Project string // Project whose quota to remove
+}
+
+// RemoveQuota lifts a project's quota
+//
+// DELETE /api/quotas
+func (c *Client) RemoveQuota(ctx context.Context, params *RemoveQuotaParams, opts ...RequestOption) (*Response, error) {
+	query := url.Values{}
+	if params != nil {
+		query.Set("project", params.Project)
+	}
+	return c.do(ctx, "DELETE", "/api/quotas", query, nil, nil, opts)
+}
+
+// ListQuotasParams are the query parameters of GET /api/quotas
+type ListQuotasParams struct {
+	Project string // Only this project, listed even without a quota
+}
+
+// ListQuotas lists project quotas with each project's usage
+//
+// GET /api/quotas
+func (c *Client) ListQuotas(ctx context.Context, params *ListQuotasParams, opts ...RequestOption) ([]QuotaEntry, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Project != "" {
+			query.Set("project", params.Project)
+		}
+	}
+	var data []QuotaEntry
+	resp, err := c.do(ctx, "GET", "/api/quotas", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetQuota adds or replaces a project's quota
+//
+// PUT /api/quotas
+func (c *Client) SetQuota(ctx context.Context, body *Quota, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/quotas", nil, body, nil, opts)
+}
+
+// CheckQuota checks whether a deploy fits its project's quota
+//
+// POST /api/quotas/check
+func (c *Client) CheckQuota(ctx context.Context, body *QuotaCheckRequest, opts ...RequestOption) (*QuotaUsage, *Response, error) {
+	var data *QuotaUsage
+	resp, err := c.do(ctx, "POST", "/api/quotas/check", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetStaging uses the Let's Encrypt staging CA
+//
+// PUT /api/staging
+func (c *Client) SetStaging(ctx context.Context, body *StagingRequest, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/staging", nil, body, nil, opts)
+}
+
+// ListStats gets container resource usage
+//
+// GET /api/stats
+func (c *Client) ListStats(ctx context.Context, opts ...RequestOption) ([]StatsSample, *Response, error) {
+	var data []StatsSample
+	resp, err := c.do(ctx, "GET", "/api/stats", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ListAppStats gets each app's request counters and resource usage
+//
+// GET /api/stats/apps
+func (c *Client) ListAppStats(ctx context.Context, opts ...RequestOption) ([]AppStats, *Response, error) {
+	var data []AppStats
+	resp, err := c.do(ctx, "GET", "/api/stats/apps", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// GetCertificateStatusParams are the query parameters of GET /api/status
+type GetCertificateStatusParams struct {
+	Host string // Only this host
+}
+
+// GetCertificateStatus gets certificate status
+//
+// GET /api/status
+func (c *Client) GetCertificateStatus(ctx context.Context, params *GetCertificateStatusParams, opts ...RequestOption) (json.RawMessage, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Host != "" {
+			query.Set("host", params.Host)
+		}
+	}
+	var data json.RawMessage
+	resp, err := c.do(ctx, "GET", "/api/status", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// RemoveStatusPageParams are the query parameters of DELETE /api/status-pages
+type RemoveStatusPageParams struct {
+	Project string // Project whose status page to remove
+}
+
+// RemoveStatusPage removes a project's status page, its incidents and its host
+//
+// DELETE /api/status-pages
+func (c *Client) RemoveStatusPage(ctx context.Context, params *RemoveStatusPageParams, opts ...RequestOption) (*Response, error) {
+	query := url.Values{}
+	if params != nil {
+		query.Set("project", params.Project)
+	}
+	return c.do(ctx, "DELETE", "/api/status-pages", query, nil, nil, opts)
+}
+
+// ListStatusPagesParams are the query parameters of GET /api/status-pages
+type ListStatusPagesParams struct {
+	Project string // Only this project
+}
+
+// ListStatusPages lists the status pages
+//
+// GET /api/status-pages
+func (c *Client) ListStatusPages(ctx context.Context, params *ListStatusPagesParams, opts ...RequestOption) ([]StatusPage, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Project != "" {
+			query.Set("project", params.Project)
+		}
+	}
+	var data []StatusPage
+	resp, err := c.do(ctx, "GET", "/api/status-pages", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetStatusPage adds or replaces a project's status page
+//
+// PUT /api/status-pages
+func (c *Client) SetStatusPage(ctx context.Context, body *StatusPage, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/status-pages", nil, body, nil, opts)
+}
+
+// GetTLSPolicy gets the default TLS policy, null for the built-in defaults
+//
+// GET /api/tls
+func (c *Client) GetTLSPolicy(ctx context.Context, opts ...RequestOption) (*TLSPolicy, *Response, error) {
+	var data *TLSPolicy
+	resp, err := c.do(ctx, "GET", "/api/tls", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// SetTLSPolicy sets the default TLS policy, empty restores the defaults
+//
+// PUT /api/tls
+func (c *Client) SetTLSPolicy(ctx context.Context, body *TLSPolicy, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "PUT", "/api/tls", nil, body, nil, opts)
+}
+
+// ListUptimeParams are the query parameters of GET /api/uptime
+type ListUptimeParams struct {
+	Project string // Only this project
+}
+
+// ListUptime gets the uptime of each checked host
+//
+// GET /api/uptime
+func (c *Client) ListUptime(ctx context.Context, params *ListUptimeParams, opts ...RequestOption) ([]UptimeReport, *Response, error) {
+	query := url.Values{}
+	if params != nil {
+		if params.Project != "" {
+			query.Set("project", params.Project)
+		}
+	}
+	var data []UptimeReport
+	resp, err := c.do(ctx, "GET", "/api/uptime", query, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// ListUsers lists API users
+//
+// GET /api/users
+func (c *Client) ListUsers(ctx context.Context, opts ...RequestOption) ([]User, *Response, error) {
+	var data []User
+	resp, err := c.do(ctx, "GET", "/api/users", nil, nil, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// AddUser adds an API user with a new token
+//
+// POST /api/users
+func (c *Client) AddUser(ctx context.Context, body *UserRequest, opts ...RequestOption) (*UserCreated, *Response, error) {
+	var data *UserCreated
+	resp, err := c.do(ctx, "POST", "/api/users", nil, body, &data, opts)
+	if err != nil {
+		return nil, resp, err
+	}
+	return data, resp, nil
+}
+
+// RemoveUser removes an API user, revoking their token
+//
+// DELETE /api/users/{name}
+func (c *Client) RemoveUser(ctx context.Context, name string, opts ...RequestOption) (*Response, error) {
+	return c.do(ctx, "DELETE", "/api/users/"+url.PathEscape(name), nil, nil, nil, opts)
+}
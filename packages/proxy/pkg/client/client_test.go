@@ -0,0 +1,87 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elitan/iop/proxy/internal/api"
+	"github.com/elitan/iop/proxy/internal/health"
+	"github.com/elitan/iop/proxy/internal/openapi"
+	"github.com/elitan/iop/proxy/internal/state"
+	"github.com/elitan/iop/proxy/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGenerated fails when client_gen.go wasn't regenerated after the
+// specification changed. Run go generate ./pkg/client to fix it.
+func TestGenerated(t *testing.T) {
+	data, err := os.ReadFile("../../internal/api/openapi.json")
+	require.NoError(t, err)
+	spec, err := openapi.Parse(data)
+	require.NoError(t, err)
+	want, err := openapi.Generate(spec, "client", "openapi.json")
+	require.NoError(t, err)
+
+	got, err := os.ReadFile("client_gen.go")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got), "client_gen.go is out of date, run go generate ./pkg/client")
+}
+
+func TestClient(t *testing.T) {
+	st := state.NewState(filepath.Join(t.TempDir(), "state.json"))
+	server := httptest.NewServer(api.NewHTTPServer(st, nil, health.NewChecker(st)).Handler())
+	defer server.Close()
+	c := client.New(server.URL, client.WithActor("ci"))
+	ctx := context.Background()
+
+	// Create only if the host doesn't exist yet
+	created, resp, err := c.PutHost(ctx, "blog.example.com", &client.HostPutRequest{Project: "blog", Target: "blog:3000"}, client.IfNoneMatch("*"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "blog", created.Project)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, created.ETag, resp.Header.Get("ETag"))
+
+	_, _, err = c.PutHost(ctx, "blog.example.com", &client.HostPutRequest{Project: "blog", Target: "blog:3000"}, client.IfNoneMatch("*"))
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode)
+
+	// Reads of an unchanged host aren't sent again
+	_, _, err = c.GetHost(ctx, "blog.example.com", client.IfNoneMatch(created.ETag))
+	assert.True(t, errors.Is(err, client.ErrNotModified))
+
+	hosts, _, err := c.ListHosts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "blog:3000", hosts["blog.example.com"].Target)
+
+	// The server rejects requests that don't match the specification
+	_, err = c.SetPortForward(ctx, &client.PortForward{Listen: 0, Target: "db:5432"})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "body.listen must be at least 1")
+
+	// Retries with an idempotency key are replayed
+	resp, err = c.SwitchTarget(ctx, "blog.example.com", &client.SwitchTargetRequest{Target: "blog:4000"}, client.IdempotencyKey("switch-1"))
+	require.NoError(t, err)
+	assert.Empty(t, resp.Header.Get(api.ReplayedHeader))
+	resp, err = c.SwitchTarget(ctx, "blog.example.com", &client.SwitchTargetRequest{Target: "blog:4000"}, client.IdempotencyKey("switch-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "true", resp.Header.Get(api.ReplayedHeader))
+
+	resp, err = c.RemoveHost(ctx, "blog.example.com", client.IfMatch(created.ETag))
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusPreconditionFailed, apiErr.StatusCode, resp.Message)
+
+	_, err = c.RemoveHost(ctx, "blog.example.com")
+	require.NoError(t, err)
+	_, _, err = c.GetHost(ctx, "blog.example.com")
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}